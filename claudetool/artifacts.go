@@ -0,0 +1,204 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// Artifacts is a small per-session key-value store the model can use to stash
+// intermediate results (file inventories, analysis notes, etc.) outside its context window.
+var Artifacts = &llm.Tool{
+	Name:        "artifacts",
+	Description: artifactsDescription,
+	InputSchema: llm.MustSchema(artifactsInputSchema),
+	Run:         artifactsRun,
+}
+
+const (
+	// maxArtifactSize caps a single artifact, and maxArtifactStoreSize the whole store.
+	maxArtifactSize      = 64 * 1024
+	maxArtifactStoreSize = 1024 * 1024
+
+	artifactsDescription = `Stores and retrieves named text artifacts for this session.
+
+Use to stash intermediate results you will need later but don't want to keep in context,
+e.g. a long file inventory, a list of failing tests, or notes from an investigation.
+Artifacts persist for the rest of the session, including across conversation compaction.
+
+Actions:
+- put: store value under key (replaces any existing value)
+- get: retrieve the value stored under key
+- list: show all keys with their sizes and descriptions
+- delete: remove key
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	artifactsInputSchema = `
+{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["put", "get", "list", "delete"]
+    },
+    "key": {
+      "type": "string",
+      "description": "artifact name, e.g. go-file-inventory; required except for list"
+    },
+    "value": {
+      "type": "string",
+      "description": "content to store (put only)"
+    },
+    "description": {
+      "type": "string",
+      "description": "one-line summary of the content, shown by list (put only)"
+    }
+  }
+}
+`
+)
+
+type artifactsInput struct {
+	Action      string `json:"action"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+// Artifact is a single stored value.
+type Artifact struct {
+	Value       string    `json:"value"`
+	Description string    `json:"description,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// artifactsMu serializes reads and writes of artifact files; tools may run concurrently.
+var artifactsMu sync.Mutex
+
+// ArtifactsFilePath returns the path to the artifact store for the given session ID.
+func ArtifactsFilePath(sessionID string) string {
+	if sessionID == "" {
+		return "/tmp/sketch_artifacts.json"
+	}
+	return filepath.Join("/tmp", sessionID, "artifacts.json")
+}
+
+// LoadArtifacts reads the artifact store for the given session.
+// A missing store is not an error; it yields an empty map.
+func LoadArtifacts(sessionID string) (map[string]Artifact, error) {
+	artifactsMu.Lock()
+	defer artifactsMu.Unlock()
+	return loadArtifactsLocked(ArtifactsFilePath(sessionID))
+}
+
+func loadArtifactsLocked(path string) (map[string]Artifact, error) {
+	artifacts := make(map[string]Artifact)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return artifacts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact store: %w", err)
+	}
+	if err := json.Unmarshal(content, &artifacts); err != nil {
+		return nil, fmt.Errorf("failed to parse artifact store: %w", err)
+	}
+	return artifacts, nil
+}
+
+func saveArtifactsLocked(path string, artifacts map[string]Artifact) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	content, err := json.Marshal(artifacts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact store: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return fmt.Errorf("failed to write artifact store: %w", err)
+	}
+	return nil
+}
+
+func artifactsRun(ctx context.Context, m json.RawMessage) llm.ToolOut {
+	var input artifactsInput
+	if err := json.Unmarshal(m, &input); err != nil {
+		return llm.ErrorfToolOut("invalid input: %w", err)
+	}
+	if input.Action != "list" && strings.TrimSpace(input.Key) == "" {
+		return llm.ErrorfToolOut("key is required for %s", input.Action)
+	}
+
+	artifactsMu.Lock()
+	defer artifactsMu.Unlock()
+	path := ArtifactsFilePath(SessionID(ctx))
+	artifacts, err := loadArtifactsLocked(path)
+	if err != nil {
+		return llm.ErrorToolOut(err)
+	}
+
+	switch input.Action {
+	case "put":
+		if len(input.Value) > maxArtifactSize {
+			return llm.ErrorfToolOut("value is %d bytes; artifacts are limited to %d bytes", len(input.Value), maxArtifactSize)
+		}
+		total := len(input.Value)
+		for k, a := range artifacts {
+			if k != input.Key {
+				total += len(a.Value)
+			}
+		}
+		if total > maxArtifactStoreSize {
+			return llm.ErrorfToolOut("artifact store would grow to %d bytes (limit %d); delete artifacts you no longer need", total, maxArtifactStoreSize)
+		}
+		artifacts[input.Key] = Artifact{Value: input.Value, Description: input.Description, UpdatedAt: time.Now()}
+		if err := saveArtifactsLocked(path, artifacts); err != nil {
+			return llm.ErrorToolOut(err)
+		}
+		return llm.ToolOut{LLMContent: llm.TextContent(fmt.Sprintf("Stored artifact %q (%d bytes).", input.Key, len(input.Value)))}
+	case "get":
+		a, ok := artifacts[input.Key]
+		if !ok {
+			return llm.ErrorfToolOut("no artifact named %q; use list to see available artifacts", input.Key)
+		}
+		return llm.ToolOut{LLMContent: llm.TextContent(a.Value)}
+	case "delete":
+		if _, ok := artifacts[input.Key]; !ok {
+			return llm.ErrorfToolOut("no artifact named %q", input.Key)
+		}
+		delete(artifacts, input.Key)
+		if err := saveArtifactsLocked(path, artifacts); err != nil {
+			return llm.ErrorToolOut(err)
+		}
+		return llm.ToolOut{LLMContent: llm.TextContent(fmt.Sprintf("Deleted artifact %q.", input.Key))}
+	case "list":
+		if len(artifacts) == 0 {
+			return llm.ToolOut{LLMContent: llm.TextContent("No artifacts stored.")}
+		}
+		keys := make([]string, 0, len(artifacts))
+		for k := range artifacts {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "<artifacts count=\"%d\">\n", len(keys))
+		for _, k := range keys {
+			a := artifacts[k]
+			fmt.Fprintf(&sb, "  <artifact key=%q bytes=\"%d\">%s</artifact>\n", k, len(a.Value), a.Description)
+		}
+		sb.WriteString("</artifacts>")
+		return llm.ToolOut{LLMContent: llm.TextContent(sb.String())}
+	default:
+		return llm.ErrorfToolOut("unknown action %q", input.Action)
+	}
+}
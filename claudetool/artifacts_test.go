@@ -0,0 +1,72 @@
+package claudetool
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestArtifactsPutGetListDelete(t *testing.T) {
+	sessionID := "test-artifacts-" + filepath.Base(t.TempDir())
+	ctx := WithSessionID(context.Background(), sessionID)
+	defer os.RemoveAll(filepath.Dir(ArtifactsFilePath(sessionID)))
+
+	run := func(input string) string {
+		t.Helper()
+		out := artifactsRun(ctx, []byte(input))
+		if out.Error != nil {
+			t.Fatalf("artifacts %s: unexpected error: %v", input, out.Error)
+		}
+		return out.LLMContent[0].Text
+	}
+
+	if got := run(`{"action": "list"}`); got != "No artifacts stored." {
+		t.Errorf("list on empty store = %q", got)
+	}
+	run(`{"action": "put", "key": "inventory", "value": "a.go\nb.go", "description": "Go files"}`)
+	if got := run(`{"action": "get", "key": "inventory"}`); got != "a.go\nb.go" {
+		t.Errorf("get = %q", got)
+	}
+	if got := run(`{"action": "list"}`); !strings.Contains(got, `key="inventory" bytes="9">Go files`) {
+		t.Errorf("list = %q", got)
+	}
+
+	stored, err := LoadArtifacts(sessionID)
+	if err != nil || stored["inventory"].Value != "a.go\nb.go" {
+		t.Errorf("LoadArtifacts = %v, %v", stored, err)
+	}
+
+	run(`{"action": "delete", "key": "inventory"}`)
+	if out := artifactsRun(ctx, []byte(`{"action": "get", "key": "inventory"}`)); out.Error == nil {
+		t.Error("expected error getting deleted artifact")
+	}
+}
+
+func TestArtifactsLimits(t *testing.T) {
+	sessionID := "test-artifacts-" + filepath.Base(t.TempDir())
+	ctx := WithSessionID(context.Background(), sessionID)
+	defer os.RemoveAll(filepath.Dir(ArtifactsFilePath(sessionID)))
+
+	big := strings.Repeat("x", maxArtifactSize+1)
+	if out := artifactsRun(ctx, []byte(`{"action": "put", "key": "big", "value": "`+big+`"}`)); out.Error == nil {
+		t.Error("expected error for oversized artifact")
+	}
+	if out := artifactsRun(ctx, []byte(`{"action": "get"}`)); out.Error == nil {
+		t.Error("expected error for missing key")
+	}
+
+	chunk := strings.Repeat("y", maxArtifactSize)
+	var err error
+	for i := 0; i <= maxArtifactStoreSize/maxArtifactSize; i++ {
+		out := artifactsRun(ctx, []byte(`{"action": "put", "key": "k`+strings.Repeat("0", i)+`", "value": "`+chunk+`"}`))
+		err = out.Error
+		if err != nil {
+			break
+		}
+	}
+	if err == nil || !strings.Contains(err.Error(), "artifact store would grow") {
+		t.Errorf("expected store size limit error, got %v", err)
+	}
+}
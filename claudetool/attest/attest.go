@@ -0,0 +1,181 @@
+// Package attest describes build outputs for supply-chain checks: an SBOM of
+// the dependencies that went into them, in SPDX, and a SLSA provenance
+// statement saying what built them, from which commit, in which image.
+package attest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Types of the statement and its predicate.
+const (
+	StatementType  = "https://in-toto.io/Statement/v1"
+	ProvenanceType = "https://slsa.dev/provenance/v1"
+	buildType      = "https://sketch.dev/attest/session/v1"
+)
+
+// A Builder is what a provenance statement says built its subjects.
+type Builder struct {
+	SessionID  string // the sketch session
+	Origin     string // the URL of the repository's origin, if it has one
+	BaseImage  string // the base image of the container the build ran in, if any
+	BaseDigest string // the registry digest of BaseImage, such as sha256:...
+}
+
+// A Subject is a build output, named by its path relative to the repository root.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// A Statement is an in-toto statement with a SLSA provenance predicate.
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Provenance is a SLSA v1 provenance predicate.
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]any       `json:"externalParameters"`
+	InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type ResourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type RunDetails struct {
+	Builder  BuilderID     `json:"builder"`
+	Metadata BuildMetadata `json:"metadata"`
+}
+
+type BuilderID struct {
+	ID string `json:"id"`
+}
+
+type BuildMetadata struct {
+	InvocationID string    `json:"invocationID"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+// Subjects digests the files at paths. Those inside root are named relative to it.
+func Subjects(root string, paths []string) ([]Subject, error) {
+	var subjects []Subject
+	for _, path := range paths {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return nil, err
+		}
+		name := path
+		if rel, err := filepath.Rel(root, path); err == nil && filepath.IsLocal(rel) {
+			name = filepath.ToSlash(rel)
+		}
+		subjects = append(subjects, Subject{Name: name, Digest: map[string]string{"sha256": sum}})
+	}
+	return subjects, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return "", err
+	} else if fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory; attest the files in it", path)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewStatement says that b built subjects from HEAD of the repository at root
+// by running command. It reports whether tracked files had changes HEAD doesn't
+// have, in which case the commit alone doesn't reproduce the build; untracked
+// files don't count, since the build's outputs are usually among them.
+func NewStatement(ctx context.Context, root string, b Builder, subjects []Subject, command string) (Statement, bool, error) {
+	commit, err := git(ctx, root, "rev-parse", "HEAD")
+	if err != nil {
+		return Statement{}, false, err
+	}
+	status, err := git(ctx, root, "status", "--porcelain", "--untracked-files=no")
+	if err != nil {
+		return Statement{}, false, err
+	}
+	dirty := status != ""
+
+	source := "git+file://" + root
+	if b.Origin != "" {
+		source = "git+" + b.Origin
+	}
+	deps := []ResourceDescriptor{{Name: "source", URI: source, Digest: map[string]string{"gitCommit": commit}}}
+	if b.BaseImage != "" && b.BaseDigest != "" {
+		alg, sum, _ := strings.Cut(b.BaseDigest, ":")
+		deps = append(deps, ResourceDescriptor{Name: "base image", URI: "oci://" + b.BaseImage, Digest: map[string]string{alg: sum}})
+	}
+	params := map[string]any{"source": source}
+	if command != "" {
+		params["command"] = command
+	}
+	return Statement{
+		Type:          StatementType,
+		Subject:       subjects,
+		PredicateType: ProvenanceType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType:            buildType,
+				ExternalParameters:   params,
+				InternalParameters:   map[string]any{"dirty": dirty},
+				ResolvedDependencies: deps,
+			},
+			RunDetails: RunDetails{
+				Builder:  BuilderID{ID: "urn:sketch:session:" + b.SessionID},
+				Metadata: BuildMetadata{InvocationID: b.SessionID, FinishedOn: time.Now().UTC().Truncate(time.Second)},
+			},
+		},
+	}, dirty, nil
+}
+
+// Commit returns the commit the subjects were built from.
+func (s Statement) Commit() string {
+	for _, d := range s.Predicate.BuildDefinition.ResolvedDependencies {
+		if c := d.Digest["gitCommit"]; c != "" {
+			return c
+		}
+	}
+	return ""
+}
+
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
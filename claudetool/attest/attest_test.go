@@ -0,0 +1,147 @@
+package attest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// testRepo makes a repository with a commit holding files.
+func testRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+	return root
+}
+
+func TestNewStatement(t *testing.T) {
+	ctx := context.Background()
+	root := testRepo(t, map[string]string{"main.go": "package main\n"})
+	os.MkdirAll(filepath.Join(root, "dist"), 0o755)
+	os.WriteFile(filepath.Join(root, "dist", "app"), []byte("binary"), 0o755)
+	outside := filepath.Join(t.TempDir(), "app.tar.gz")
+	os.WriteFile(outside, []byte("tarball"), 0o644)
+
+	subjects, err := Subjects(root, []string{filepath.Join(root, "dist", "app"), outside})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subjects[0].Name != "dist/app" || subjects[1].Name != outside {
+		t.Errorf("subjects named %q and %q", subjects[0].Name, subjects[1].Name)
+	}
+	// sha256("binary")
+	if got := subjects[0].Digest["sha256"]; got != "9a3a45d01531a20e89ac6ae10b0b0beb0492acd7216a368aa062d1a5fecaf9cd" {
+		t.Errorf("dist/app digest %s", got)
+	}
+	if _, err := Subjects(root, []string{filepath.Join(root, "dist")}); err == nil {
+		t.Error("attested a directory")
+	}
+
+	b := Builder{SessionID: "abcd-efgh", Origin: "https://github.com/example/app", BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:beef"}
+	stmt, dirty, err := NewStatement(ctx, root, b, subjects, "make release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirty {
+		t.Error("untracked build outputs made the tree dirty")
+	}
+	head, _ := git(ctx, root, "rev-parse", "HEAD")
+	if stmt.Commit() != head || stmt.Type != StatementType || stmt.PredicateType != ProvenanceType {
+		t.Errorf("statement: %+v", stmt)
+	}
+	deps := stmt.Predicate.BuildDefinition.ResolvedDependencies
+	if len(deps) != 2 || deps[0].URI != "git+https://github.com/example/app" || deps[1].Digest["sha256"] != "beef" {
+		t.Errorf("resolved dependencies: %+v", deps)
+	}
+	if id := stmt.Predicate.RunDetails.Builder.ID; id != "urn:sketch:session:abcd-efgh" {
+		t.Errorf("builder %s", id)
+	}
+
+	os.WriteFile(filepath.Join(root, "main.go"), []byte("package main // changed\n"), 0o644)
+	if _, dirty, err := NewStatement(ctx, root, Builder{}, subjects, ""); err != nil || !dirty {
+		t.Errorf("after changing main.go: dirty %v, %v", dirty, err)
+	}
+}
+
+func TestManifestSBOM(t *testing.T) {
+	root := testRepo(t, map[string]string{
+		"go.mod":       "module example.com/app\n\ngo 1.24\n\nrequire golang.org/x/mod v0.24.0\n",
+		"tools/go.mod": "module example.com/app/tools\n\nrequire (\n\tgolang.org/x/mod v0.24.0\n\tgolang.org/x/tools v0.32.0 // indirect\n)\n",
+		"web/package-lock.json": `{"packages": {
+			"": {"name": "web"},
+			"node_modules/lit": {"version": "3.2.1"},
+			"node_modules/lit/node_modules/@lit/reactive-element": {"version": "2.0.4"},
+			"node_modules/shared": {"link": true}
+		}}`,
+	})
+	// A PATH with git but not syft.
+	bin := t.TempDir()
+	if err := os.Symlink(mustLookPath(t, "git"), filepath.Join(bin, "git")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	sbom, err := NewSBOM(context.Background(), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			Name         string `json:"name"`
+			ExternalRefs []struct {
+				Locator string `json:"referenceLocator"`
+			} `json:"externalRefs"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(sbom.Document, &doc); err != nil {
+		t.Fatal(err)
+	}
+	var purls []string
+	for _, p := range doc.Packages {
+		purls = append(purls, p.ExternalRefs[0].Locator)
+	}
+	want := []string{
+		"pkg:golang/golang.org/x/mod@v0.24.0",
+		"pkg:golang/golang.org/x/tools@v0.32.0",
+		"pkg:npm/%40lit/reactive-element@2.0.4",
+		"pkg:npm/lit@3.2.1",
+	}
+	if sbom.Tool != "sketch" || sbom.Packages != 4 || doc.SPDXVersion != "SPDX-2.3" || len(purls) != 4 {
+		t.Fatalf("SBOM by %s of %d packages: %v", sbom.Tool, sbom.Packages, purls)
+	}
+	for i := range want {
+		if purls[i] != want[i] {
+			t.Errorf("package %d = %s, want %s", i, purls[i], want[i])
+		}
+	}
+}
+
+func mustLookPath(t *testing.T, name string) string {
+	t.Helper()
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s not installed", name)
+	}
+	return path
+}
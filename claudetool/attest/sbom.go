@@ -0,0 +1,178 @@
+package attest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/mod/modfile"
+)
+
+// An SBOM is an SPDX document listing the packages a tree depends on.
+type SBOM struct {
+	Tool     string // what made it: syft, or sketch from the Go and npm manifests
+	Packages int
+	Document []byte // SPDX 2.3 JSON
+}
+
+// NewSBOM lists the dependencies of the tree at dir. It asks syft, which
+// knows most ecosystems, if it is installed; otherwise it reads the go.mod and
+// package-lock.json files git tracks under dir.
+func NewSBOM(ctx context.Context, dir string) (SBOM, error) {
+	if _, err := exec.LookPath("syft"); err == nil {
+		return syftSBOM(ctx, dir)
+	}
+	pkgs, err := manifestPackages(ctx, dir)
+	if err != nil {
+		return SBOM{}, err
+	}
+	doc, err := spdxDocument(filepath.Base(dir), pkgs)
+	if err != nil {
+		return SBOM{}, err
+	}
+	return SBOM{Tool: "sketch", Packages: len(pkgs), Document: doc}, nil
+}
+
+func syftSBOM(ctx context.Context, dir string) (SBOM, error) {
+	cmd := exec.CommandContext(ctx, "syft", "scan", "dir:"+dir, "-o", "spdx-json", "-q")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return SBOM{}, fmt.Errorf("syft: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	var doc struct {
+		Packages []json.RawMessage `json:"packages"`
+	}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		return SBOM{}, fmt.Errorf("syft wrote something other than SPDX JSON: %w", err)
+	}
+	return SBOM{Tool: "syft", Packages: len(doc.Packages), Document: out}, nil
+}
+
+// A pkg is a dependency found in a manifest.
+type pkg struct {
+	name, version string
+	purl          string
+}
+
+// manifestPackages reads the dependencies from the Go and npm manifests under dir.
+func manifestPackages(ctx context.Context, dir string) ([]pkg, error) {
+	out, err := git(ctx, dir, "ls-files", "--", ":(glob)**/go.mod", ":(glob)**/package-lock.json")
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []pkg
+	for _, f := range strings.Fields(out) {
+		data, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			return nil, err
+		}
+		var found []pkg
+		if path.Base(f) == "go.mod" {
+			found, err = goModPackages(f, data)
+		} else {
+			found, err = npmLockPackages(f, data)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, found...)
+	}
+	slices.SortFunc(pkgs, func(a, b pkg) int { return strings.Compare(a.purl, b.purl) })
+	return slices.CompactFunc(pkgs, func(a, b pkg) bool { return a.purl == b.purl }), nil
+}
+
+func goModPackages(name string, data []byte) ([]pkg, error) {
+	mf, err := modfile.ParseLax(name, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []pkg
+	for _, r := range mf.Require {
+		pkgs = append(pkgs, pkg{r.Mod.Path, r.Mod.Version, "pkg:golang/" + r.Mod.Path + "@" + r.Mod.Version})
+	}
+	return pkgs, nil
+}
+
+func npmLockPackages(name string, data []byte) ([]pkg, error) {
+	var lock struct {
+		Packages map[string]struct {
+			Version string `json:"version"`
+			Link    bool   `json:"link"`
+		} `json:"packages"`
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	var pkgs []pkg
+	for p, info := range lock.Packages {
+		// Keys are install paths such as node_modules/a/node_modules/@b/c;
+		// the project and its workspaces have keys without node_modules.
+		i := strings.LastIndex(p, "node_modules/")
+		if i < 0 || info.Link || info.Version == "" {
+			continue
+		}
+		pkgName := p[i+len("node_modules/"):]
+		// A scope's @ is written %40 in a purl.
+		pkgs = append(pkgs, pkg{pkgName, info.Version, "pkg:npm/" + strings.Replace(pkgName, "@", "%40", 1) + "@" + info.Version})
+	}
+	return pkgs, nil
+}
+
+// spdxDocument writes pkgs as an SPDX 2.3 JSON document.
+func spdxDocument(name string, pkgs []pkg) ([]byte, error) {
+	type externalRef struct {
+		Category string `json:"referenceCategory"`
+		Type     string `json:"referenceType"`
+		Locator  string `json:"referenceLocator"`
+	}
+	type spdxPackage struct {
+		Name             string        `json:"name"`
+		SPDXID           string        `json:"SPDXID"`
+		VersionInfo      string        `json:"versionInfo"`
+		DownloadLocation string        `json:"downloadLocation"`
+		FilesAnalyzed    bool          `json:"filesAnalyzed"`
+		ExternalRefs     []externalRef `json:"externalRefs"`
+	}
+	doc := struct {
+		SPDXVersion       string `json:"spdxVersion"`
+		DataLicense       string `json:"dataLicense"`
+		SPDXID            string `json:"SPDXID"`
+		Name              string `json:"name"`
+		DocumentNamespace string `json:"documentNamespace"`
+		CreationInfo      struct {
+			Created  string   `json:"created"`
+			Creators []string `json:"creators"`
+		} `json:"creationInfo"`
+		Packages []spdxPackage `json:"packages"`
+	}{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: "https://sketch.dev/spdx/" + name + "-" + uuid.NewString(),
+		Packages:          []spdxPackage{},
+	}
+	doc.CreationInfo.Created = time.Now().UTC().Format(time.RFC3339)
+	doc.CreationInfo.Creators = []string{"Tool: sketch"}
+	for i, p := range pkgs {
+		doc.Packages = append(doc.Packages, spdxPackage{
+			Name:             p.name,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i+1),
+			VersionInfo:      p.version,
+			DownloadLocation: "NOASSERTION",
+			ExternalRefs:     []externalRef{{"PACKAGE-MANAGER", "purl", p.purl}},
+		})
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
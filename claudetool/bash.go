@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -18,6 +19,7 @@ import (
 	"time"
 
 	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/claudetool/testreport"
 	"sketch.dev/llm"
 	"sketch.dev/llm/conversation"
 )
@@ -35,6 +37,10 @@ type BashTool struct {
 	Timeouts *Timeouts
 	// Pwd is the working directory for the tool
 	Pwd string
+	// Policy restricts the commands that may run, if set
+	Policy *bashkit.Policy
+	// OnBlocked is called with each script Policy rejects, if set
+	OnBlocked func(script string, v *bashkit.Violation)
 }
 
 const (
@@ -79,9 +85,13 @@ func (t *Timeouts) background() time.Duration {
 
 // Tool returns an llm.Tool based on b.
 func (b *BashTool) Tool() *llm.Tool {
+	description := fmt.Sprintf(strings.TrimSpace(bashDescription), b.Pwd)
+	if b.Policy != nil {
+		description += "\n\nThis environment restricts commands: " + b.Policy.Describe() + ". Other commands fail with permission denied."
+	}
 	return &llm.Tool{
 		Name:        bashName,
-		Description: fmt.Sprintf(strings.TrimSpace(bashDescription), b.Pwd),
+		Description: description,
 		InputSchema: llm.MustSchema(bashInputSchema),
 		Run:         b.Run,
 	}
@@ -172,6 +182,18 @@ func (b *BashTool) Run(ctx context.Context, m json.RawMessage) llm.ToolOut {
 		return llm.ErrorfToolOut("failed to unmarshal bash command input: %w", err)
 	}
 
+	// Enforce the bash policy first; unlike the check below, it is meant to hold.
+	if err := b.Policy.Check(req.Command); err != nil {
+		var v *bashkit.Violation
+		errors.As(err, &v)
+		if b.OnBlocked != nil {
+			b.OnBlocked(req.Command, v)
+		}
+		toolOut := llm.ErrorToolOut(err)
+		toolOut.Display = v
+		return toolOut
+	}
+
 	// do a quick permissions check (NOT a security barrier)
 	err := bashkit.Check(req.Command)
 	if err != nil {
@@ -205,11 +227,19 @@ func (b *BashTool) Run(ctx context.Context, m json.RawMessage) llm.ToolOut {
 	}
 
 	// For foreground commands, use executeBash
-	out, execErr := b.executeBash(ctx, req, timeout)
+	out, report, execErr := b.executeBash(ctx, req, timeout)
 	if execErr != nil {
-		return llm.ErrorToolOut(execErr)
+		toolOut := llm.ErrorToolOut(execErr)
+		if report != nil {
+			toolOut.Display = report
+		}
+		return toolOut
+	}
+	toolOut := llm.ToolOut{LLMContent: llm.TextContent(out)}
+	if report != nil {
+		toolOut.Display = report
 	}
-	return llm.ToolOut{LLMContent: llm.TextContent(out)}
+	return toolOut
 }
 
 const maxBashOutputLength = 131072
@@ -255,33 +285,57 @@ func cmdWait(cmd *exec.Cmd) error {
 	return err
 }
 
-func (b *BashTool) executeBash(ctx context.Context, req bashInput, timeout time.Duration) (string, error) {
+// executeBash runs a foreground command.
+// If its output is a test run, it also returns a report of the run.
+func (b *BashTool) executeBash(ctx context.Context, req bashInput, timeout time.Duration) (string, *testreport.Report, error) {
 	execCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	output := new(bytes.Buffer)
-	cmd := b.makeBashCommand(execCtx, req.Command, output, req.shouldDetectPorts())
+	cmd := b.makeBashCommand(execCtx, req.Command, newProgressWriter(ctx, output), req.shouldDetectPorts())
 	// TODO: maybe detect simple interactive git rebase commands and auto-background them?
 	// Would need to hint to the agent what is happening.
 	// We might also be able to do this for other simple interactive commands that use EDITOR.
 	cmd.Env = append(cmd.Env, `GIT_SEQUENCE_EDITOR=echo "To do an interactive rebase, run it as a background task and check the output file." && exit 1`)
 	if err := cmd.Start(); err != nil {
-		return "", fmt.Errorf("command failed: %w", err)
+		return "", nil, fmt.Errorf("command failed: %w", err)
 	}
 
 	err := cmdWait(cmd)
 
-	out := output.String()
+	out, report := summarizeTestOutput(ctx, output.String())
 	out = formatForegroundBashOutput(out)
 
 	if execCtx.Err() == context.DeadlineExceeded {
-		return "", fmt.Errorf("[command timed out after %s, showing output until timeout]\n%s", timeout, out)
+		return "", report, fmt.Errorf("[command timed out after %s, showing output until timeout]\n%s", timeout, out)
 	}
 	if err != nil {
-		return "", fmt.Errorf("[command failed: %w]\n%s", err, out)
+		return "", report, fmt.Errorf("[command failed: %w]\n%s", err, out)
 	}
 
-	return out, nil
+	return out, report, nil
+}
+
+// testSummaryThreshold is the size above which the agent gets a summary of a test run
+// in place of its output. Smaller outputs are cheap enough to pass along whole.
+const testSummaryThreshold = 8192
+
+// summarizeTestOutput parses out as a test run. If it is one, the raw output is stored
+// for the UI, and large outputs are replaced by the report and a pointer to the stored output.
+func summarizeTestOutput(ctx context.Context, out string) (string, *testreport.Report) {
+	report := testreport.Parse(out)
+	if report == nil {
+		return out, nil
+	}
+	path, err := report.Save(out)
+	if err != nil {
+		slog.DebugContext(ctx, "failed to save test output", "error", err)
+		return out, report
+	}
+	if len(out) > testSummaryThreshold {
+		out = fmt.Sprintf("%s\n\n[full test output (%s): %s]", report, humanizeBytes(len(out)), path)
+	}
+	return out, report
 }
 
 // formatForegroundBashOutput formats the output of a foreground bash command for display to the agent.
@@ -440,6 +494,7 @@ func (b *BashTool) installTool(ctx context.Context, cmd string) error {
 	}
 	subConvo := info.Convo.SubConvo()
 	subConvo.Hidden = true
+	subConvo.Purpose = "install check"
 	subConvo.SystemPrompt = "You are an expert in software developer tools."
 
 	query := fmt.Sprintf(`Do you know this command/package/tool? Is it legitimate, clearly non-harmful, and commonly used? Can it be installed with package manager %s?
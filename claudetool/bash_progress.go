@@ -0,0 +1,84 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// progressMarker starts lines of command output that report progress,
+// e.g. "##sketch-progress running tests 34/120".
+const progressMarker = "##sketch-progress "
+
+var progressCount = regexp.MustCompile(`^(.*?)\s+(\d+)/(\d+)$`)
+
+// Reports are throttled: every one of them refreshes the state of every
+// client watching the session.
+const (
+	markerReportInterval = 200 * time.Millisecond
+	outputReportInterval = time.Second
+)
+
+// progressWriter passes command output through to w and reports the command's
+// progress from it: marker lines as they come, and, for commands that don't
+// print markers, the latest line of output.
+type progressWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	now     func() time.Time
+	partial []byte // the unfinished last line
+	marked  bool   // seen a marker; plain output no longer counts as progress
+	last    time.Time
+}
+
+func newProgressWriter(ctx context.Context, w io.Writer) *progressWriter {
+	return &progressWriter{ctx: ctx, w: w, now: time.Now}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.partial = append(p.partial, b[:n]...)
+	var latest string
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+		if label, ok := strings.CutPrefix(line, progressMarker); ok {
+			p.marked = true
+			p.reportMarker(label)
+		} else if line != "" {
+			latest = line
+		}
+	}
+	if len(p.partial) > 4096 {
+		p.partial = p.partial[len(p.partial)-4096:] // a very long line, e.g. a progress bar redrawn with \r
+	}
+	if latest != "" && !p.marked && p.now().Sub(p.last) >= outputReportInterval {
+		p.last = p.now()
+		ReportProgress(p.ctx, ToolProgress{Label: latest})
+	}
+	return n, err
+}
+
+// reportMarker reports the progress in a marker line, minus the marker.
+func (p *progressWriter) reportMarker(label string) {
+	tp := ToolProgress{Label: strings.TrimSpace(label)}
+	if m := progressCount.FindStringSubmatch(tp.Label); m != nil {
+		tp.Label = m[1]
+		tp.Done, _ = strconv.Atoi(m[2])
+		tp.Total, _ = strconv.Atoi(m[3])
+	}
+	final := tp.Total > 0 && tp.Done >= tp.Total
+	if !final && p.now().Sub(p.last) < markerReportInterval {
+		return
+	}
+	p.last = p.now()
+	ReportProgress(p.ctx, tp)
+}
@@ -0,0 +1,47 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var got []ToolProgress
+	ctx := WithProgressReporter(context.Background(), func(_ context.Context, p ToolProgress) {
+		got = append(got, p)
+	})
+	var out bytes.Buffer
+	clock := time.Unix(0, 0)
+	pw := newProgressWriter(ctx, &out)
+	pw.now = func() time.Time { return clock }
+
+	write := func(s string, advance time.Duration) {
+		clock = clock.Add(advance)
+		io.WriteString(pw, s)
+	}
+	write("building\n", time.Second)
+	write("still building\n", 100*time.Millisecond) // too soon for plain output
+	write("##sketch-progress running tests 1/3\n", time.Second)
+	write("##sketch-progress running tests 2/3\n", 50*time.Millisecond)
+	write("ok pkg/a\n", 0) // plain output is ignored once there are markers
+	write("##sketch-progress running ", 0)
+	write("tests 3/3\n", 0) // the final report is never throttled
+	write("##sketch-progress done\n", time.Second)
+
+	want := []ToolProgress{
+		{Label: "building"},
+		{Label: "running tests", Done: 1, Total: 3},
+		{Label: "running tests", Done: 3, Total: 3},
+		{Label: "done"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("reports = %+v, want %+v", got, want)
+	}
+	if !bytes.Contains(out.Bytes(), []byte("##sketch-progress running tests 2/3\n")) {
+		t.Errorf("output not passed through: %q", out.String())
+	}
+}
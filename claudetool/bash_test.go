@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"testing"
 	"time"
+
+	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/claudetool/testreport"
 )
 
 func TestBashSlowOk(t *testing.T) {
@@ -175,6 +179,36 @@ func TestBashTool(t *testing.T) {
 	})
 }
 
+func TestBashPolicy(t *testing.T) {
+	policy, err := bashkit.ParsePolicy("echo,ls", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocked []string
+	bash := &BashTool{
+		Policy:    policy,
+		OnBlocked: func(script string, v *bashkit.Violation) { blocked = append(blocked, script+": "+v.Command) },
+	}
+	tool := bash.Tool()
+	if !strings.Contains(tool.Description, "echo, ls") {
+		t.Errorf("description doesn't mention the policy:\n%s", tool.Description)
+	}
+
+	if out := tool.Run(context.Background(), json.RawMessage(`{"command":"echo ok"}`)); out.Error != nil {
+		t.Errorf("allowed command failed: %v", out.Error)
+	}
+	out := tool.Run(context.Background(), json.RawMessage(`{"command":"echo hi && touch pwned"}`))
+	if out.Error == nil || !strings.Contains(out.Error.Error(), "permission denied") {
+		t.Fatalf("blocked command = %v", out.Error)
+	}
+	if v, ok := out.Display.(*bashkit.Violation); !ok || v.Command != "touch pwned" {
+		t.Errorf("Display = %#v, want the violation", out.Display)
+	}
+	if want := []string{"echo hi && touch pwned: touch pwned"}; !slices.Equal(blocked, want) {
+		t.Errorf("blocked = %q, want %q", blocked, want)
+	}
+}
+
 func TestExecuteBash(t *testing.T) {
 	ctx := context.Background()
 	bashTool := &BashTool{}
@@ -185,7 +219,7 @@ func TestExecuteBash(t *testing.T) {
 			Command: "echo 'Success'",
 		}
 
-		output, err := bashTool.executeBash(ctx, req, 5*time.Second)
+		output, _, err := bashTool.executeBash(ctx, req, 5*time.Second)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -202,7 +236,7 @@ func TestExecuteBash(t *testing.T) {
 			Command: "echo $SKETCH",
 		}
 
-		output, err := bashTool.executeBash(ctx, req, 5*time.Second)
+		output, _, err := bashTool.executeBash(ctx, req, 5*time.Second)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -219,7 +253,7 @@ func TestExecuteBash(t *testing.T) {
 			Command: "echo 'Error message' >&2 && echo 'Success'",
 		}
 
-		output, err := bashTool.executeBash(ctx, req, 5*time.Second)
+		output, _, err := bashTool.executeBash(ctx, req, 5*time.Second)
 		if err != nil {
 			t.Fatalf("Unexpected error: %v", err)
 		}
@@ -236,7 +270,7 @@ func TestExecuteBash(t *testing.T) {
 			Command: "echo 'Error message' >&2 && exit 1",
 		}
 
-		_, err := bashTool.executeBash(ctx, req, 5*time.Second)
+		_, _, err := bashTool.executeBash(ctx, req, 5*time.Second)
 		if err == nil {
 			t.Errorf("Expected error for failed command, got none")
 		} else if !strings.Contains(err.Error(), "Error message") {
@@ -251,7 +285,7 @@ func TestExecuteBash(t *testing.T) {
 		}
 
 		start := time.Now()
-		_, err := bashTool.executeBash(ctx, req, 100*time.Millisecond)
+		_, _, err := bashTool.executeBash(ctx, req, 100*time.Millisecond)
 		elapsed := time.Since(start)
 
 		// Command should time out after ~100ms, not wait for full 1 second
@@ -267,6 +301,38 @@ func TestExecuteBash(t *testing.T) {
 	})
 }
 
+func TestSummarizeTestOutput(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	ctx := context.Background()
+
+	out, report := summarizeTestOutput(ctx, "just some output\n")
+	if report != nil || out != "just some output\n" {
+		t.Errorf("non-test output: got %q, %+v", out, report)
+	}
+
+	small := "--- FAIL: TestX (0.00s)\n    x_test.go:3: bad\nFAIL\tex.com/x\t0.01s\n"
+	out, report = summarizeTestOutput(ctx, small)
+	if report == nil || report.Failed != 1 || report.RawID == "" {
+		t.Fatalf("report = %+v", report)
+	}
+	if out != small {
+		t.Errorf("small output was summarized: %q", out)
+	}
+
+	large := strings.Repeat("=== RUN   TestOK\n--- PASS: TestOK (0.00s)\n", 500) + small
+	out, report = summarizeTestOutput(ctx, large)
+	if report == nil || report.Failed != 1 || report.Passed != 500 {
+		t.Fatalf("report = %+v", report)
+	}
+	if !strings.HasPrefix(out, "go tests: 1 failed, 500 passed") || !strings.Contains(out, "x_test.go:3") {
+		t.Errorf("summary = %q", out)
+	}
+	raw, err := os.ReadFile(testreport.RawPath(report.RawID))
+	if err != nil || string(raw) != large {
+		t.Errorf("stored raw output: %v", err)
+	}
+}
+
 func TestBackgroundBash(t *testing.T) {
 	var bashTool BashTool
 	tool := bashTool.Tool()
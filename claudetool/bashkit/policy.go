@@ -0,0 +1,317 @@
+package bashkit
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// A Policy restricts the commands the bash tool may run, for demo environments
+// and models that shouldn't be trusted with a whole shell.
+//
+// Unlike Check, a Policy fails closed: a script that doesn't parse, a command
+// whose name isn't a literal word, or, with an allowlist, a change to PATH is
+// rejected. It checks every simple command in the script, including those in
+// pipelines, substitutions and function bodies. Deny rules match commands run
+// by path too (rm denies /bin/rm); allow rules match only the name as written.
+// Only ReadOnly looks at redirections, refusing those that write to files, and
+// allowing a command that runs others (env, xargs, find, sh) allows whatever
+// that command runs.
+type Policy struct {
+	allow    []Rule // if any, every command must match one
+	deny     []Rule // no command may match any
+	noWrites bool   // no redirection may write to a file other than /dev/null
+}
+
+// A Rule matches commands by executable name and, optionally, their arguments.
+type Rule struct {
+	Command string // the executable, exactly as written in the script
+	Args    string // a pattern for the space-separated arguments, in which * matches anything; empty matches any
+	args    *regexp.Regexp
+}
+
+// builtins are the shell builtins allowed without being listed, since they
+// can't run anything else. eval, exec, source, command and the like can, so
+// they must be allowed explicitly.
+var builtins = []string{
+	":", "[", "break", "cd", "continue", "echo", "exit", "export", "false", "local",
+	"printf", "pwd", "read", "return", "set", "shift", "test", "true", "unset", "wait",
+}
+
+// ReadOnly is the policy of plan mode: commands that look at the workspace and
+// its history without changing them, and no redirections into files. It keeps
+// a well-meaning agent from editing the workspace, which is all plan mode asks.
+var ReadOnly = &Policy{
+	allow: mustParseRules("ls,cat,head,tail,grep,egrep,fgrep,rg,find,tree,wc,sort,uniq,cut,tr,diff,cmp,file,stat,du,df," +
+		"which,basename,dirname,realpath,readlink,date,uname,whoami,id,printenv,ps,jq," +
+		"git status*,git log*,git diff*,git show*,git grep*,git blame*,git ls-files*,git ls-tree*,git rev-parse*," +
+		"git rev-list*,git cat-file*,git describe*,git shortlog*,git branch,git branch --list*,git branch -a*,git branch -r*,git branch -v*," +
+		"git tag,git tag --list*,git tag -l*,git remote,git remote -v,go doc*,go list*,go version"),
+	// git accepts --op for --open-files-in-pager, which runs the pager named with it, as -O does.
+	deny: mustParseRules("find *-delete*,find *-exec*,find *-ok*,find *-fprint*,find *-fls*,sort *-o*,tree *-o*," +
+		"git *--output*,git grep *-O*,git grep *--op*"),
+	noWrites: true,
+}
+
+func mustParseRules(s string) []Rule {
+	rules, err := parseRules(s)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// ParsePolicy parses the -bash-allow and -bash-deny flags: comma-separated rules,
+// each an executable optionally followed by an argument pattern, such as
+// "ls,git status*,go test *". It returns nil if neither restricts anything.
+func ParsePolicy(allow, deny string) (*Policy, error) {
+	p := &Policy{}
+	var err error
+	if p.allow, err = parseRules(allow); err != nil {
+		return nil, err
+	}
+	if p.deny, err = parseRules(deny); err != nil {
+		return nil, err
+	}
+	if p.allow == nil && p.deny == nil {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func parseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for spec := range strings.SplitSeq(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		r, err := ParseRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// ParseRule parses a rule written as an executable optionally followed by an argument pattern.
+func ParseRule(spec string) (Rule, error) {
+	command, args, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	if command == "" || strings.ContainsAny(command, "*?$`'\"") {
+		return Rule{}, fmt.Errorf("bash rule %q: want a literal command name, optionally followed by an argument pattern", spec)
+	}
+	r := Rule{Command: command, Args: strings.TrimSpace(args)}
+	if r.Args != "" {
+		parts := strings.Split(r.Args, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		r.args = regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	if r.Args == "" {
+		return r.Command
+	}
+	return r.Command + " " + r.Args
+}
+
+func (r Rule) matches(name string, args []string, literal bool) bool {
+	if r.Command != name {
+		return false
+	}
+	if r.args == nil {
+		return true
+	}
+	// A pattern can't vouch for arguments that are only known at run time.
+	return literal && r.args.MatchString(strings.Join(args, " "))
+}
+
+// A Violation is a command a Policy rejects.
+type Violation struct {
+	Command string `json:"command"`        // the offending command, as written
+	Rule    string `json:"rule,omitempty"` // the deny rule it matched, if any
+	Reason  string `json:"reason"`
+}
+
+func (v *Violation) Error() string {
+	return fmt.Sprintf("permission denied: %s: %s", v.Command, v.Reason)
+}
+
+// Check returns a *Violation for the first command in bashScript that p rejects,
+// or nil if p allows them all. A nil Policy allows everything.
+func (p *Policy) Check(bashScript string) error {
+	if p == nil {
+		return nil
+	}
+	file, err := syntax.NewParser().Parse(strings.NewReader(bashScript), "")
+	if err != nil {
+		return &Violation{Command: bashScript, Reason: fmt.Sprintf("can't be checked against the bash policy: %v", err)}
+	}
+	var v *Violation
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if v != nil {
+			return false
+		}
+		switch node := node.(type) {
+		case *syntax.CallExpr:
+			if len(node.Args) > 0 {
+				v = p.checkCall(node)
+			}
+		case *syntax.Redirect:
+			if p.noWrites && writesFile(node) {
+				v = &Violation{Command: printRedirect(node), Reason: "writing to files is not allowed by the bash policy"}
+			}
+		case *syntax.Assign:
+			if node.Name != nil && node.Name.Value == "PATH" && len(p.allow) > 0 {
+				v = &Violation{Command: "PATH=" + printWords(node.Value), Reason: "changing PATH is not allowed by the bash policy, since it changes what allowed commands run"}
+			}
+		}
+		return v == nil
+	})
+	if v != nil {
+		return v
+	}
+	return nil
+}
+
+func (p *Policy) checkCall(call *syntax.CallExpr) *Violation {
+	command := printWords(call.Args...)
+	name, ok := literalWord(call.Args[0])
+	if !ok {
+		return &Violation{Command: command, Reason: "the command name must be written literally, not computed, so the bash policy can check it"}
+	}
+	args := make([]string, 0, len(call.Args)-1)
+	literal := true
+	for _, w := range call.Args[1:] {
+		arg, ok := literalWord(w)
+		literal = literal && ok
+		args = append(args, arg)
+	}
+	for _, r := range p.deny {
+		base := path.Base(name)
+		if r.matches(base, args, true) || (!literal && r.Command == base) {
+			return &Violation{Command: command, Rule: r.String(), Reason: fmt.Sprintf("denied by the bash policy rule %q", r.String())}
+		}
+	}
+	if len(p.allow) == 0 || slices.Contains(builtins, name) {
+		return nil
+	}
+	for _, r := range p.allow {
+		if r.matches(name, args, literal) {
+			return nil
+		}
+	}
+	return &Violation{Command: command, Reason: fmt.Sprintf("not allowed by the bash policy; allowed commands are %s", p.allowed())}
+}
+
+// writesFile reports whether r may write to a file other than /dev/null.
+func writesFile(r *syntax.Redirect) bool {
+	switch r.Op {
+	case syntax.RdrOut, syntax.AppOut, syntax.RdrInOut, syntax.ClbOut, syntax.RdrAll, syntax.AppAll:
+	case syntax.DplOut:
+		// >&2 duplicates a file descriptor; >&file writes to file.
+		if target, ok := literalWord(r.Word); ok {
+			if _, err := strconv.Atoi(strings.TrimSuffix(target, "-")); err == nil || target == "-" {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	target, ok := literalWord(r.Word)
+	return !ok || target != "/dev/null"
+}
+
+func printRedirect(r *syntax.Redirect) string {
+	var b strings.Builder
+	if r.N != nil {
+		b.WriteString(r.N.Value)
+	}
+	b.WriteString(r.Op.String())
+	b.WriteString(printWords(r.Word))
+	return b.String()
+}
+
+// Describe summarizes p for the model.
+func (p *Policy) Describe() string {
+	var parts []string
+	if len(p.allow) > 0 {
+		parts = append(parts, "only these commands may be run (besides simple shell builtins): "+p.allowed())
+	}
+	if len(p.deny) > 0 {
+		rules := make([]string, len(p.deny))
+		for i, r := range p.deny {
+			rules[i] = r.String()
+		}
+		parts = append(parts, "these commands may never be run: "+strings.Join(rules, ", "))
+	}
+	if p.noWrites {
+		parts = append(parts, "output may only be redirected to /dev/null, not to files")
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (p *Policy) allowed() string {
+	rules := make([]string, len(p.allow))
+	for i, r := range p.allow {
+		rules[i] = r.String()
+	}
+	return strings.Join(rules, ", ")
+}
+
+// literalWord returns w's value if it has no expansions, unquoting as needed.
+func literalWord(w *syntax.Word) (string, bool) {
+	var b strings.Builder
+	for i, part := range w.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			// Escapes, globs, brace expansions and leading tildes change the word when it runs.
+			v := part.Value
+			if strings.ContainsAny(v, `\*?[`) || (i == 0 && strings.HasPrefix(v, "~")) ||
+				(strings.Contains(v, "{") && (strings.Contains(v, ",") || strings.Contains(v, ".."))) {
+				return "", false
+			}
+			b.WriteString(part.Value)
+		case *syntax.SglQuoted:
+			if part.Dollar {
+				return "", false
+			}
+			b.WriteString(part.Value)
+		case *syntax.DblQuoted:
+			for _, inner := range part.Parts {
+				lit, ok := inner.(*syntax.Lit)
+				if !ok || strings.Contains(lit.Value, `\`) {
+					return "", false
+				}
+				b.WriteString(lit.Value)
+			}
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+func printWords(words ...*syntax.Word) string {
+	var b strings.Builder
+	printer := syntax.NewPrinter()
+	for i, w := range words {
+		if w == nil {
+			continue
+		}
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		printer.Print(&b, w)
+	}
+	return b.String()
+}
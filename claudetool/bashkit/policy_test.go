@@ -0,0 +1,123 @@
+package bashkit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	p, err := ParsePolicy("ls, git status*, git diff, go test *, cat", "rm, git push --force*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		script string
+		ok     bool
+	}{
+		{"ls -la", true},
+		{"git status", true},
+		{"git status --short", true},
+		{"git diff", true},
+		{"git diff HEAD~1", false},
+		{"cat a b", true}, // no pattern: any arguments
+		{"cat ~/.ssh/id_rsa", true},
+		{"git status {a,b}", false},
+		{"go test ./...", true},
+		{"cd sub && ls | cat", true},
+		{`echo "$(ls)"`, true},
+		{"'ls' \"-la\"", true},
+		{"git commit -m x", false},
+		{"go build ./...", false},
+		{"go test $PKGS", false}, // arguments a pattern can't check
+		{"curl example.com", false},
+		{"ls; curl example.com", false},
+		{"echo $(curl example.com)", false},
+		{"f() { curl example.com; }; f", false},
+		{"$CMD", false},
+		{"l\\s", false},
+		{"eval ls", false},
+		{"PATH=/tmp ls", false},
+		{"export PATH=/tmp; ls", false},
+		{"rm -rf /", false},
+		{"ls (", false}, // doesn't parse
+	}
+	for _, tc := range tests {
+		err := p.Check(tc.script)
+		if (err == nil) != tc.ok {
+			t.Errorf("Check(%q) = %v, want ok=%v", tc.script, err, tc.ok)
+		}
+		var v *Violation
+		if err != nil && !errors.As(err, &v) {
+			t.Errorf("Check(%q) = %T, want *Violation", tc.script, err)
+		}
+	}
+}
+
+func TestPolicyDenyOnly(t *testing.T) {
+	p, err := ParsePolicy("", "rm, git push --force*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, script := range []string{"ls", "git push origin main", "FOO=1 ls"} {
+		if err := p.Check(script); err != nil {
+			t.Errorf("Check(%q) = %v", script, err)
+		}
+	}
+	for _, script := range []string{"rm x", "/bin/rm x", "git push --force origin", "git push --force $REMOTE", "git $(echo push) --force"} {
+		err := p.Check(script)
+		var v *Violation
+		if !errors.As(err, &v) || v.Rule == "" {
+			t.Errorf("Check(%q) = %v, want a violation of a deny rule", script, err)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy(" , ", "")
+	if p != nil || err != nil {
+		t.Errorf("ParsePolicy of nothing = %v, %v; want nil, nil", p, err)
+	}
+	if err := p.Check("anything goes"); err != nil {
+		t.Errorf("nil Policy rejected a command: %v", err)
+	}
+	for _, spec := range []string{"g*t", "$X status", "'ls'"} {
+		if _, err := ParsePolicy(spec, ""); err == nil {
+			t.Errorf("ParsePolicy(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestReadOnlyPolicy(t *testing.T) {
+	tests := []struct {
+		script string
+		ok     bool
+	}{
+		{"ls -la && cat go.mod", true},
+		{"grep -rn TODO . | head -20", true},
+		{"git log --oneline -5", true},
+		{"git branch", true},
+		{"git diff HEAD~1 2>&1", true},
+		{"find . -name x.go 2>/dev/null", true},
+		{"cat a > b", false},
+		{"echo x >> notes.md", false},
+		{"ls &> out.txt", false},
+		{"ls >&out.txt", false},
+		{"git commit -am x", false},
+		{"git branch -D main", false},
+		{"git diff --output=p.diff", false},
+		{"find . -name '*.tmp' -delete", false},
+		{"tree -L 2", true},
+		{"tree -o tree.txt", false},
+		{"git grep -n TODO", true},
+		{"git grep -Ovim TODO", false},
+		{"git grep --open-files-in-pager=vim TODO", false},
+		{"git grep --open TODO", false},
+		{"rm -rf build", false},
+		{"go test ./...", false},
+	}
+	for _, tc := range tests {
+		if err := ReadOnly.Check(tc.script); (err == nil) != tc.ok {
+			t.Errorf("Check(%q) = %v, want ok=%v", tc.script, err, tc.ok)
+		}
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 	"github.com/google/uuid"
@@ -41,6 +42,15 @@ type BrowseTools struct {
 	consoleLogs      []*runtime.EventConsoleAPICalled
 	consoleLogsMutex sync.Mutex
 	maxConsoleLogs   int
+
+	// ProfileDir is where browser profiles are read from and saved to;
+	// see Profile. Empty disables profiles.
+	ProfileDir string
+	// Profile names the profile the browser starts from, if any.
+	// Set ProfileDir and Profile before the browser is first used.
+	Profile string
+	// stateScript restores the local storage of the profile last applied.
+	stateScript page.ScriptIdentifier
 }
 
 // NewBrowseTools creates a new set of browser automation tools
@@ -106,11 +116,18 @@ func (b *BrowseTools) Initialize() error {
 		}
 
 		// Set default viewport size to 1280x720 (16:9 widescreen)
-		if err := chromedp.Run(browserCtx, chromedp.EmulateViewport(1280, 720)); err != nil {
+		if err := chromedp.Run(browserCtx, chromedp.EmulateViewport(defaultWidth, defaultHeight)); err != nil {
 			b.initErr = fmt.Errorf("failed to set default viewport: %w", err)
 			return
 		}
 
+		if b.Profile != "" {
+			if _, err := b.applyProfile(browserCtx, b.Profile); err != nil {
+				b.initErr = err
+				return
+			}
+		}
+
 		b.initialized = true
 	})
 
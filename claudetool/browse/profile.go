@@ -0,0 +1,372 @@
+package browse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/storage"
+	"github.com/chromedp/chromedp"
+)
+
+// A Profile is a named browser setup a session can start from: the viewport,
+// the user agent, and the cookies and local storage of earlier sessions, so that
+// recurring tasks against the same web app don't have to log in every time.
+//
+// A profile is a directory in the profile directory holding profile.json and,
+// once state has been saved to it, storage-state.json. The browser only reads a
+// profile when it applies it; a session's browsing changes the profile only
+// when the session saves its state back.
+type Profile struct {
+	Name      string `json:"name"`
+	Width     int    `json:"width,omitempty"`      // viewport width; 1280 if unset
+	Height    int    `json:"height,omitempty"`     // viewport height; 720 if unset
+	UserAgent string `json:"user_agent,omitempty"` // the browser's own if unset
+	HasState  bool   `json:"has_state"`            // whether storage-state.json exists
+}
+
+// Default viewport, 16:9 widescreen.
+const (
+	defaultWidth  = 1280
+	defaultHeight = 720
+)
+
+var profileNameRE = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// DefaultProfileDir is where profiles live unless configured otherwise.
+func DefaultProfileDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "browser-profiles"), nil
+}
+
+// ValidateProfileName reports whether name can name a profile.
+func ValidateProfileName(name string) error {
+	if !profileNameRE.MatchString(name) {
+		return fmt.Errorf("invalid browser profile name %q: use letters, digits, '.', '_', and '-'", name)
+	}
+	return nil
+}
+
+// LoadProfile reads the profile called name from dir. A profile whose
+// directory has no profile.json, such as one made by saving state, has the defaults.
+func LoadProfile(dir, name string) (*Profile, error) {
+	if err := ValidateProfileName(name); err != nil {
+		return nil, err
+	}
+	pdir := filepath.Join(dir, name)
+	if _, err := os.Stat(pdir); err != nil {
+		return nil, fmt.Errorf("browser profile %q: %w", name, err)
+	}
+	p := &Profile{}
+	data, err := os.ReadFile(filepath.Join(pdir, "profile.json"))
+	if err == nil {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("browser profile %q: %w", name, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	p.Name = name
+	_, err = os.Stat(filepath.Join(pdir, "storage-state.json"))
+	p.HasState = err == nil
+	return p, nil
+}
+
+// ListProfiles returns the profiles in dir, sorted by name.
+func ListProfiles(dir string) ([]Profile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Profile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	profiles := []Profile{}
+	for _, e := range entries {
+		if !e.IsDir() || ValidateProfileName(e.Name()) != nil {
+			continue
+		}
+		p, err := LoadProfile(dir, e.Name())
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, *p)
+	}
+	slices.SortFunc(profiles, func(a, b Profile) int { return strings.Compare(a.Name, b.Name) })
+	return profiles, nil
+}
+
+func (p *Profile) viewport() (int64, int64) {
+	if p == nil || p.Width <= 0 || p.Height <= 0 {
+		return defaultWidth, defaultHeight
+	}
+	return int64(p.Width), int64(p.Height)
+}
+
+// A StorageState is a browser's cookies and local storage, in the storage
+// state format Playwright uses, so that state files can be shared with it.
+type StorageState struct {
+	Cookies []StateCookie `json:"cookies"`
+	Origins []StateOrigin `json:"origins"`
+}
+
+type StateCookie struct {
+	Name     string  `json:"name"`
+	Value    string  `json:"value"`
+	Domain   string  `json:"domain"`
+	Path     string  `json:"path"`
+	Expires  float64 `json:"expires"` // seconds since the epoch; -1 for session cookies
+	HTTPOnly bool    `json:"httpOnly"`
+	Secure   bool    `json:"secure"`
+	SameSite string  `json:"sameSite,omitempty"` // Strict, Lax, or None
+}
+
+type StateOrigin struct {
+	Origin       string      `json:"origin"`
+	LocalStorage []NameValue `json:"localStorage"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func readStorageState(path string) (*StorageState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st StorageState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// writeStorageState writes st to path readable only by the user:
+// it holds whatever logged the browser in.
+func writeStorageState(path string, st *StorageState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func cookiesFromBrowser(cookies []*network.Cookie) []StateCookie {
+	out := []StateCookie{}
+	for _, c := range cookies {
+		sc := StateCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Expires:  c.Expires,
+			HTTPOnly: c.HTTPOnly,
+			Secure:   c.Secure,
+			SameSite: string(c.SameSite),
+		}
+		if c.Session {
+			sc.Expires = -1
+		}
+		out = append(out, sc)
+	}
+	return out
+}
+
+func cookiesToBrowser(cookies []StateCookie) []*network.CookieParam {
+	var out []*network.CookieParam
+	for _, c := range cookies {
+		cp := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			HTTPOnly: c.HTTPOnly,
+			Secure:   c.Secure,
+			SameSite: network.CookieSameSite(c.SameSite),
+		}
+		if c.Expires > 0 {
+			sec, frac := math.Modf(c.Expires)
+			t := cdp.TimeSinceEpoch(time.Unix(int64(sec), int64(frac*1e9)))
+			cp.Expires = &t
+		}
+		out = append(out, cp)
+	}
+	return out
+}
+
+// mergeOrigins replaces the local storage of the origins in update and keeps the rest.
+func mergeOrigins(old, update []StateOrigin) []StateOrigin {
+	out := slices.Clone(update)
+	for _, o := range old {
+		if !slices.ContainsFunc(update, func(u StateOrigin) bool { return u.Origin == o.Origin }) {
+			out = append(out, o)
+		}
+	}
+	slices.SortFunc(out, func(a, b StateOrigin) int { return strings.Compare(a.Origin, b.Origin) })
+	return out
+}
+
+// localStorageScript returns a script that, run in each new document, fills in
+// the local storage of origins, leaving alone keys the page has already set.
+// Local storage can only be written from a page of its origin, hence the script.
+func localStorageScript(origins []StateOrigin) (string, error) {
+	byOrigin := make(map[string]map[string]string)
+	for _, o := range origins {
+		items := make(map[string]string)
+		for _, kv := range o.LocalStorage {
+			items[kv.Name] = kv.Value
+		}
+		byOrigin[o.Origin] = items
+	}
+	data, err := json.Marshal(byOrigin)
+	if err != nil {
+		return "", err
+	}
+	return `(() => {
+  const items = (` + string(data) + `)[location.origin];
+  if (!items) return;
+  for (const [k, v] of Object.entries(items)) {
+    if (localStorage.getItem(k) === null) localStorage.setItem(k, v);
+  }
+})();`, nil
+}
+
+// ApplyProfile switches the browser to the profile called name: its viewport
+// and user agent, and its saved cookies and local storage, added to what the
+// browser already has. It starts the browser if need be.
+func (b *BrowseTools) ApplyProfile(name string) (*Profile, error) {
+	browserCtx, err := b.GetBrowserContext()
+	if err != nil {
+		return nil, err
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.applyProfile(browserCtx, name)
+}
+
+// applyProfile does the work of ApplyProfile; b.mux must be held.
+func (b *BrowseTools) applyProfile(browserCtx context.Context, name string) (*Profile, error) {
+	if b.ProfileDir == "" {
+		return nil, errors.New("browser profiles are not configured")
+	}
+	p, err := LoadProfile(b.ProfileDir, name)
+	if err != nil {
+		return nil, err
+	}
+	w, h := p.viewport()
+	actions := []chromedp.Action{chromedp.EmulateViewport(w, h)}
+	if p.UserAgent != "" {
+		actions = append(actions, emulation.SetUserAgentOverride(p.UserAgent))
+	}
+	if p.HasState {
+		st, err := readStorageState(filepath.Join(b.ProfileDir, name, "storage-state.json"))
+		if err != nil {
+			return nil, fmt.Errorf("browser profile %q: %w", name, err)
+		}
+		if len(st.Cookies) > 0 {
+			actions = append(actions, network.SetCookies(cookiesToBrowser(st.Cookies)))
+		}
+		script, err := localStorageScript(st.Origins)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error {
+			if b.stateScript != "" {
+				if err := page.RemoveScriptToEvaluateOnNewDocument(b.stateScript).Do(ctx); err != nil {
+					return err
+				}
+				b.stateScript = ""
+			}
+			if len(st.Origins) == 0 {
+				return nil
+			}
+			id, err := page.AddScriptToEvaluateOnNewDocument(script).Do(ctx)
+			b.stateScript = id
+			return err
+		}))
+	}
+	ctx, cancel := context.WithTimeout(browserCtx, 15*time.Second)
+	defer cancel()
+	if err := chromedp.Run(ctx, actions...); err != nil {
+		return nil, fmt.Errorf("applying browser profile %q: %w", name, err)
+	}
+	return p, nil
+}
+
+// SaveState saves the browser's cookies, and the local storage of the page it
+// has open, to the profile called name, creating the profile if need be.
+// Local storage saved earlier for other origins is kept.
+func (b *BrowseTools) SaveState(name string) (*Profile, error) {
+	if b.ProfileDir == "" {
+		return nil, errors.New("browser profiles are not configured")
+	}
+	if err := ValidateProfileName(name); err != nil {
+		return nil, err
+	}
+	b.mux.Lock()
+	browserCtx, initialized := b.browserCtx, b.initialized
+	b.mux.Unlock()
+	if !initialized {
+		return nil, errors.New("the browser is not running; there is no state to save")
+	}
+
+	ctx, cancel := context.WithTimeout(browserCtx, 15*time.Second)
+	defer cancel()
+	var cookies []*network.Cookie
+	var current struct {
+		Origin string      `json:"origin"`
+		Items  [][2]string `json:"items"`
+	}
+	err := chromedp.Run(ctx,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			cookies, err = storage.GetCookies().Do(ctx)
+			return err
+		}),
+		chromedp.Evaluate(`({origin: location.origin, items: Object.entries(localStorage)})`, &current),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("reading browser state: %w", err)
+	}
+
+	path := filepath.Join(b.ProfileDir, name, "storage-state.json")
+	st := &StorageState{Origins: []StateOrigin{}}
+	if old, err := readStorageState(path); err == nil {
+		st.Origins = old.Origins
+	}
+	st.Cookies = cookiesFromBrowser(cookies)
+	if strings.HasPrefix(current.Origin, "http") {
+		o := StateOrigin{Origin: current.Origin, LocalStorage: []NameValue{}}
+		for _, kv := range current.Items {
+			o.LocalStorage = append(o.LocalStorage, NameValue{Name: kv[0], Value: kv[1]})
+		}
+		st.Origins = mergeOrigins(st.Origins, []StateOrigin{o})
+	}
+	if err := writeStorageState(path, st); err != nil {
+		return nil, err
+	}
+	return LoadProfile(b.ProfileDir, name)
+}
@@ -0,0 +1,93 @@
+package browse
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+)
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "mobile"), 0o700)
+	os.WriteFile(filepath.Join(dir, "mobile", "profile.json"), []byte(`{"width": 390, "height": 844, "user_agent": "phone"}`), 0o600)
+	st := &StorageState{Cookies: []StateCookie{{Name: "sid", Value: "x", Domain: "example.com", Path: "/", Expires: -1}}}
+	if err := writeStorageState(filepath.Join(dir, "staging", "storage-state.json"), st); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, ".hidden"), 0o700)
+
+	profiles, err := ListProfiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("ListProfiles = %+v, want mobile and staging", profiles)
+	}
+	if p := profiles[0]; p.Name != "mobile" || p.Width != 390 || p.UserAgent != "phone" || p.HasState {
+		t.Errorf("mobile = %+v", p)
+	}
+	if p := profiles[1]; p.Name != "staging" || !p.HasState {
+		t.Errorf("staging = %+v", p)
+	}
+	if w, h := profiles[1].viewport(); w != defaultWidth || h != defaultHeight {
+		t.Errorf("staging viewport = %dx%d, want the default", w, h)
+	}
+
+	if _, err := LoadProfile(dir, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadProfile(missing) = %v, want a not-exist error", err)
+	}
+	if _, err := LoadProfile(dir, "../staging"); err == nil {
+		t.Error("LoadProfile accepted a path as a name")
+	}
+	if got, err := ListProfiles(filepath.Join(dir, "nope")); err != nil || len(got) != 0 {
+		t.Errorf("ListProfiles of a missing dir = %v, %v", got, err)
+	}
+}
+
+func TestCookieRoundTrip(t *testing.T) {
+	expires := float64(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC).Unix())
+	saved := cookiesFromBrowser([]*network.Cookie{
+		{Name: "sid", Value: "abc", Domain: ".example.com", Path: "/", Expires: expires, HTTPOnly: true, Secure: true, SameSite: network.CookieSameSiteLax},
+		{Name: "tmp", Value: "1", Domain: "example.com", Path: "/", Session: true},
+	})
+	if saved[1].Expires != -1 {
+		t.Errorf("session cookie expires = %v, want -1", saved[1].Expires)
+	}
+	params := cookiesToBrowser(saved)
+	if len(params) != 2 {
+		t.Fatalf("got %d cookies, want 2", len(params))
+	}
+	if p := params[0]; p.Expires == nil || !time.Time(*p.Expires).Equal(time.Unix(int64(expires), 0)) || p.SameSite != network.CookieSameSiteLax || !p.HTTPOnly {
+		t.Errorf("sid = %+v", p)
+	}
+	if params[1].Expires != nil {
+		t.Errorf("session cookie got an expiry: %v", params[1].Expires)
+	}
+}
+
+func TestMergeOrigins(t *testing.T) {
+	old := []StateOrigin{
+		{Origin: "https://a.example", LocalStorage: []NameValue{{"k", "old"}}},
+		{Origin: "https://b.example", LocalStorage: []NameValue{{"k", "b"}}},
+	}
+	got := mergeOrigins(old, []StateOrigin{{Origin: "https://a.example", LocalStorage: []NameValue{{"k", "new"}}}})
+	if len(got) != 2 || got[0].LocalStorage[0].Value != "new" || got[1].Origin != "https://b.example" {
+		t.Errorf("mergeOrigins = %+v", got)
+	}
+}
+
+func TestLocalStorageScript(t *testing.T) {
+	script, err := localStorageScript([]StateOrigin{{Origin: "https://a.example", LocalStorage: []NameValue{{"token", `"quoted"`}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(script, `{"https://a.example":{"token":"\"quoted\""}}`) {
+		t.Errorf("script doesn't carry the storage:\n%s", script)
+	}
+}
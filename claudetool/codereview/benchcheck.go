@@ -0,0 +1,267 @@
+package codereview
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchCheck is the optional benchmark comparison of the code review: it runs
+// the benchmarks of the changed packages at the base commit and at HEAD, and
+// reports those that got slower, or allocate more, by more than a threshold.
+type BenchCheck struct {
+	Time      float64 // the time/op ratio, HEAD over base, that counts as a regression
+	Allocs    float64 // the same for allocs/op; 0 doesn't compare allocations
+	Count     int     // runs of each benchmark; more runs mean less noise
+	BenchTime string  // go test -benchtime for each run
+	Bench     string  // go test -bench: which benchmarks to run
+}
+
+// A BenchRegression is a benchmark that got worse than a BenchCheck allows.
+type BenchRegression struct {
+	Package string
+	Name    string
+	Metric  string  // "ns/op" or "allocs/op"
+	Before  float64 // median at the base commit
+	After   float64 // median at HEAD
+}
+
+func (b BenchRegression) String() string {
+	return fmt.Sprintf("%s %s: %s → %s %s (%.1fx)", b.Package, b.Name, formatBenchValue(b.Before), formatBenchValue(b.After), b.Metric, b.After/b.Before)
+}
+
+func formatBenchValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+// ParseBenchCheck parses a -bench-check spec: "on" or space-separated settings from
+//
+//	time=R        report benchmarks whose time/op grew by a factor of R or more (default 1.5)
+//	allocs=R      the same for allocs/op (default: allocations aren't compared)
+//	count=N       run each benchmark N times (default 5)
+//	benchtime=D   go test -benchtime for each run (default 100ms)
+//	bench=REGEXP  go test -bench: the benchmarks to run (default all)
+//
+// An empty spec or "off" returns nil, which disables the check.
+func ParseBenchCheck(spec string) (*BenchCheck, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+	c := &BenchCheck{Time: 1.5, Count: 5, BenchTime: "100ms", Bench: "."}
+	ratio := func(key, val string) (float64, error) {
+		r, err := strconv.ParseFloat(strings.TrimSuffix(val, "x"), 64)
+		if err != nil || r <= 1 {
+			return 0, fmt.Errorf("bench check: %s wants a ratio above 1, such as 1.5, got %q", key, val)
+		}
+		return r, nil
+	}
+	for _, setting := range strings.Fields(spec) {
+		key, val, _ := strings.Cut(setting, "=")
+		var err error
+		switch key {
+		case "on":
+		case "time":
+			c.Time, err = ratio(key, val)
+		case "allocs":
+			c.Allocs, err = ratio(key, val)
+		case "count":
+			c.Count, err = strconv.Atoi(val)
+			if err != nil || c.Count < 1 {
+				err = fmt.Errorf("bench check: count wants a positive number, got %q", val)
+			}
+		case "benchtime":
+			c.BenchTime = val
+			if _, derr := time.ParseDuration(val); derr != nil && !strings.HasSuffix(val, "x") {
+				err = fmt.Errorf("bench check: benchtime wants a duration or a count such as 100x, got %q", val)
+			}
+		case "bench":
+			if val == "" {
+				err = fmt.Errorf("bench check: bench wants a regexp")
+			}
+			c.Bench = val
+		default:
+			err = fmt.Errorf("bench check: unknown setting %q (want on, time, allocs, count, benchtime or bench)", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// LoadBenchCheck parses spec, falling back to the sketch.benchCheck
+// git config setting of the repository at repoRoot when spec is empty.
+func LoadBenchCheck(ctx context.Context, repoRoot, spec string) (*BenchCheck, error) {
+	return ParseBenchCheck(configSpec(ctx, repoRoot, "benchCheck", spec))
+}
+
+// SetBenchCheck sets the benchmark comparison Run makes; nil disables it.
+func (r *CodeReviewer) SetBenchCheck(c *BenchCheck) {
+	r.benchCheck = c
+}
+
+// checkBenchmarks runs the benchmarks in pkgList before and after, and
+// describes the regressions, if any. New and removed benchmarks aren't compared.
+func (r *CodeReviewer) checkBenchmarks(ctx context.Context, pkgList []string) (string, error) {
+	if r.benchCheck == nil || len(pkgList) == 0 {
+		return "", nil
+	}
+	if err := r.initializeInitialCommitWorktree(ctx); err != nil {
+		return "", err
+	}
+	args := []string{"test", "-run=^$", "-bench=" + r.benchCheck.Bench, "-benchmem", "-vet=off",
+		"-count=" + strconv.Itoa(r.benchCheck.Count), "-benchtime=" + r.benchCheck.BenchTime}
+	args = append(args, pkgList...)
+	// Benchmarks are timed, so the two runs mustn't overlap.
+	run := func(dir string) []byte {
+		cmd := exec.CommandContext(ctx, "go", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "SKETCH_IGNORE_PORTS=1")
+		out, _ := cmd.Output() // packages that fail to build just have no results
+		return out
+	}
+	after := run(r.repoRoot)
+	before := run(r.initialWorktree)
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("benchmarks didn't finish before the code review timed out; pass a longer timeout or narrow the bench check")
+	}
+	regressions := r.benchCheck.compare(parseBenchOutput(before), parseBenchOutput(after))
+	if len(regressions) == 0 {
+		return "", nil
+	}
+	return r.benchCheck.format(regressions, benchstat(ctx, before, after)), nil
+}
+
+// benchSamples are a benchmark's results over its runs, by metric.
+type benchSamples map[string][]float64
+
+// parseBenchOutput collects the results in go test -bench output,
+// keyed by package and benchmark name.
+func parseBenchOutput(out []byte) map[string]benchSamples {
+	results := make(map[string]benchSamples)
+	pkg := ""
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if p, ok := strings.CutPrefix(line, "pkg: "); ok {
+			pkg = strings.TrimSpace(p)
+			continue
+		}
+		fields := strings.Fields(line)
+		// BenchmarkName-8  1000  1234 ns/op  56 B/op  2 allocs/op
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") || len(fields)%2 != 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		key := pkg + " " + fields[0]
+		if results[key] == nil {
+			results[key] = make(benchSamples)
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			results[key][fields[i+1]] = append(results[key][fields[i+1]], v)
+		}
+	}
+	return results
+}
+
+// compare returns the benchmarks whose median grew by c's threshold for a
+// metric. With several runs, every run after must also be worse than every run
+// before, so that a noisy benchmark doesn't count.
+func (c *BenchCheck) compare(before, after map[string]benchSamples) []BenchRegression {
+	var regressions []BenchRegression
+	for _, key := range slices.Sorted(maps.Keys(after)) {
+		b, ok := before[key]
+		if !ok {
+			continue
+		}
+		pkg, name, _ := strings.Cut(key, " ")
+		for _, m := range []struct {
+			metric    string
+			threshold float64
+		}{{"ns/op", c.Time}, {"allocs/op", c.Allocs}} {
+			if m.threshold == 0 || len(b[m.metric]) == 0 || len(after[key][m.metric]) == 0 {
+				continue
+			}
+			bm, am := median(b[m.metric]), median(after[key][m.metric])
+			if bm == 0 {
+				continue // from no allocations to some has no ratio
+			}
+			if am < bm*m.threshold || slices.Min(after[key][m.metric]) <= slices.Max(b[m.metric]) {
+				continue
+			}
+			regressions = append(regressions, BenchRegression{Package: pkg, Name: name, Metric: m.metric, Before: bm, After: am})
+		}
+	}
+	return regressions
+}
+
+func median(vs []float64) float64 {
+	vs = slices.Sorted(slices.Values(vs))
+	n := len(vs)
+	if n%2 == 1 {
+		return vs[n/2]
+	}
+	return (vs[n/2-1] + vs[n/2]) / 2
+}
+
+// benchstat returns benchstat's comparison of the two runs, if it is installed.
+func benchstat(ctx context.Context, before, after []byte) string {
+	path, err := exec.LookPath("benchstat")
+	if err != nil {
+		return ""
+	}
+	dir, err := os.MkdirTemp("", "sketch-bench-")
+	if err != nil {
+		return ""
+	}
+	defer os.RemoveAll(dir)
+	// benchstat names the columns after the files.
+	if os.WriteFile(filepath.Join(dir, "before"), before, 0o644) != nil || os.WriteFile(filepath.Join(dir, "after"), after, 0o644) != nil {
+		return ""
+	}
+	cmd := exec.CommandContext(ctx, path, "before", "after")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// format describes regressions for the agent, with benchstat's table if there is one.
+func (c *BenchCheck) format(regressions []BenchRegression, table string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Benchmarks got slower than the bench check allows (time/op %gx", c.Time)
+	if c.Allocs > 0 {
+		fmt.Fprintf(&b, ", allocs/op %gx", c.Allocs)
+	}
+	b.WriteString(", medians over the commit's base and HEAD):\n\n")
+	for _, r := range regressions {
+		b.WriteString("- ")
+		b.WriteString(r.String())
+		b.WriteString("\n")
+	}
+	if table != "" {
+		b.WriteString("\nbenchstat:\n\n")
+		b.WriteString(table)
+		b.WriteString("\n")
+	}
+	b.WriteString("\nFix the slowdown, or, if it is the price of the change, say so when presenting your work.")
+	return b.String()
+}
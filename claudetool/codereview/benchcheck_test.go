@@ -0,0 +1,66 @@
+package codereview
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBenchCheck(t *testing.T) {
+	c, err := ParseBenchCheck("on")
+	if err != nil || *c != (BenchCheck{Time: 1.5, Count: 5, BenchTime: "100ms", Bench: "."}) {
+		t.Errorf("ParseBenchCheck(on) = %+v, %v", c, err)
+	}
+	c, err = ParseBenchCheck("time=3x allocs=1.2 count=10 benchtime=1000x bench=Parse")
+	if err != nil || *c != (BenchCheck{Time: 3, Allocs: 1.2, Count: 10, BenchTime: "1000x", Bench: "Parse"}) {
+		t.Errorf("ParseBenchCheck = %+v, %v", c, err)
+	}
+	if c, err := ParseBenchCheck("off"); c != nil || err != nil {
+		t.Errorf("ParseBenchCheck(off) = %v, %v; want nil, nil", c, err)
+	}
+	for _, spec := range []string{"time=0.5", "time=fast", "count=0", "benchtime=soon", "bench=", "cpu=4"} {
+		if _, err := ParseBenchCheck(spec); err == nil {
+			t.Errorf("ParseBenchCheck(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestBenchCheckCompare(t *testing.T) {
+	before := parseBenchOutput([]byte(`goos: linux
+pkg: example.com/parse
+BenchmarkParse-8   	  10000	     1000 ns/op	     64 B/op	       2 allocs/op
+BenchmarkParse-8   	  10000	     1100 ns/op	     64 B/op	       2 allocs/op
+BenchmarkParse-8   	  10000	     1050 ns/op	     64 B/op	       2 allocs/op
+BenchmarkNoisy-8   	  10000	      100 ns/op
+BenchmarkNoisy-8   	  10000	      900 ns/op
+BenchmarkNoisy-8   	  10000	      110 ns/op
+BenchmarkGone-8    	  10000	      100 ns/op
+PASS
+ok  	example.com/parse	1.2s
+`))
+	after := parseBenchOutput([]byte(`pkg: example.com/parse
+BenchmarkParse-8   	   3000	     3200 ns/op	    640 B/op	      20 allocs/op
+BenchmarkParse-8   	   3000	     3000 ns/op	    640 B/op	      20 allocs/op
+BenchmarkParse-8   	   3000	     3100 ns/op	    640 B/op	      20 allocs/op
+BenchmarkNoisy-8   	  10000	      300 ns/op
+BenchmarkNoisy-8   	  10000	      320 ns/op
+BenchmarkNoisy-8   	  10000	      310 ns/op
+BenchmarkNew-8     	  10000	     9999 ns/op
+--- FAIL: BenchmarkBroken-8
+`))
+
+	got := (&BenchCheck{Time: 1.5}).compare(before, after)
+	if len(got) != 1 || got[0] != (BenchRegression{Package: "example.com/parse", Name: "BenchmarkParse-8", Metric: "ns/op", Before: 1050, After: 3100}) {
+		t.Fatalf("compare = %v", got)
+	}
+	if s := got[0].String(); s != "example.com/parse BenchmarkParse-8: 1050 → 3100 ns/op (3.0x)" {
+		t.Errorf("String() = %q", s)
+	}
+
+	got = (&BenchCheck{Time: 5, Allocs: 2}).compare(before, after)
+	if len(got) != 1 || got[0].Metric != "allocs/op" {
+		t.Errorf("compare with only allocs over the threshold = %v", got)
+	}
+	if msg := (&BenchCheck{Time: 5, Allocs: 2}).format(got, ""); !strings.Contains(msg, "allocs/op 2x") || !strings.Contains(msg, "2 → 20 allocs/op") {
+		t.Errorf("format = %q", msg)
+	}
+}
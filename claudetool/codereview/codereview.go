@@ -13,6 +13,7 @@ import (
 	"sync"
 
 	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/taskrunner"
 )
 
 // A CodeReviewer manages quality checks.
@@ -21,13 +22,19 @@ type CodeReviewer struct {
 	sketchBaseRef   string
 	initialStatus   []fileStatus // git status of files at initial commit, absolute paths
 	reviewed        []string     // history of all commits which have been reviewed
+	unclean         []string     // reviewed commits for which the review reported errors
 	initialWorktree string       // git worktree at initial commit, absolute path
 	// "Related files" caching
 	processedChangedFileSets map[string]bool // hash of sorted changedFiles -> processed
 	reportedRelatedFiles     map[string]bool // file path -> reported
 	// Pre-warming of Go build/test cache
-	warmMutex      sync.Mutex      // protects warmedPackages map
-	warmedPackages map[string]bool // packages that have been cache warmed
+	warmMutex      sync.Mutex         // protects warmedPackages map
+	warmedPackages map[string]bool    // packages that have been cache warmed
+	commitLint     *CommitLint        // commit message policy; nil if none
+	docsCheck      *DocsCheck         // docs-quality check of added prose; nil if off
+	benchCheck     *BenchCheck        // benchmark comparison; nil if off
+	taskRunner     *taskrunner.Runner // selects and runs the affected tests; nil uses go test
+	tests          testCache          // go test results of earlier reviews
 }
 
 func NewCodeReviewer(ctx context.Context, repoRoot, sketchBaseRef string) (*CodeReviewer, error) {
@@ -254,6 +261,30 @@ func (r *CodeReviewer) ResolveCommit(ctx context.Context, ref string) (string, e
 	return strings.TrimSpace(string(out)), nil
 }
 
+// BaseMoved discards what the reviewer derived from the sketch base commit,
+// for use after the sketch base ref has been moved, e.g. by a rebase.
+func (r *CodeReviewer) BaseMoved(ctx context.Context) {
+	r.Close(ctx)
+	r.warmMutex.Lock()
+	r.warmedPackages = make(map[string]bool)
+	r.warmMutex.Unlock()
+	r.tests.resetBefore()
+}
+
+// Close removes the worktree the reviewer checked the sketch base out in,
+// so that it doesn't outlive the session.
+func (r *CodeReviewer) Close(ctx context.Context) {
+	if r.initialWorktree == "" {
+		return
+	}
+	cmd := exec.CommandContext(ctx, "git", "worktree", "remove", "--force", r.initialWorktree)
+	cmd.Dir = r.repoRoot
+	if out, err := cmd.CombinedOutput(); err != nil {
+		slog.WarnContext(ctx, "codereview: failed to remove initial commit worktree", "err", err, "out", string(out))
+	}
+	r.initialWorktree = ""
+}
+
 func (r *CodeReviewer) absPath(relPath string) string {
 	if filepath.IsAbs(relPath) {
 		return relPath
@@ -487,7 +518,8 @@ func (r *CodeReviewer) ModTidy(ctx context.Context) ([]string, error) {
 	return changedByTidy, nil
 }
 
-// RunMechanicalChecks runs all mechanical checks and returns a message describing any changes made.
+// RunMechanicalChecks runs all mechanical checks and returns a message describing any changes made
+// and any ways the latest commit message breaks the commit message policy.
 func (r *CodeReviewer) RunMechanicalChecks(ctx context.Context) string {
 	var actions []string
 
@@ -506,9 +538,26 @@ func (r *CodeReviewer) RunMechanicalChecks(ctx context.Context) string {
 		actions = append(actions, "`go mod tidy`")
 	}
 
-	if len(changed) == 0 {
+	lint := r.lintHeadMessage(ctx)
+	msg := mechanicalChangesMessage(changed, actions, lint)
+	if issues := r.checkHeadDocs(ctx); len(issues) > 0 {
+		if msg != "" {
+			msg += "\n\n"
+		}
+		msg += formatDocsIssues(issues)
+	}
+	return msg
+}
+
+// mechanicalChangesMessage asks the agent to amend its latest commit with the
+// files the mechanical checks changed and a message that passes lint, if need be.
+func mechanicalChangesMessage(changed, actions []string, lint string) string {
+	if len(changed) == 0 && lint == "" {
 		return ""
 	}
+	if len(changed) == 0 {
+		return lint + "\n\nPlease amend your latest git commit with a message that follows the policy and then continue with what you were doing."
+	}
 
 	slices.Sort(changed)
 
@@ -516,10 +565,15 @@ func (r *CodeReviewer) RunMechanicalChecks(ctx context.Context) string {
 
 %s
 
-Please amend your latest git commit with these changes and then continue with what you were doing.`,
+`,
 		strings.Join(actions, " and "),
 		strings.Join(changed, "\n"),
 	)
+	if lint != "" {
+		msg += lint + "\n\nPlease amend your latest git commit with these changes and a message that follows the policy, and then continue with what you were doing."
+	} else {
+		msg += "Please amend your latest git commit with these changes and then continue with what you were doing."
+	}
 
 	return msg
 }
@@ -529,3 +583,15 @@ func isGoModFile(path string) bool {
 	basename := filepath.Base(path)
 	return strings.HasPrefix(basename, "go.")
 }
+
+// configSpec returns spec, or when it is empty, the sketch.<key> git config
+// setting of the repository at repoRoot, which the Load functions parse.
+func configSpec(ctx context.Context, repoRoot, key, spec string) string {
+	if spec != "" {
+		return spec
+	}
+	cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch."+key)
+	cmd.Dir = repoRoot
+	out, _ := cmd.Output()
+	return string(out)
+}
@@ -0,0 +1,213 @@
+package codereview
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A CommitLint is a repository's commit message policy.
+// The zero value accepts every message.
+type CommitLint struct {
+	// Conventional requires Conventional Commits subjects ("type(scope): subject")
+	// using one of ConventionalTypes.
+	Conventional      bool
+	ConventionalTypes []string
+	// MaxSubject, if positive, caps the length of the subject line in characters.
+	MaxSubject int
+	// Ticket, if set, must match somewhere in the message.
+	Ticket *regexp.Regexp
+}
+
+// defaultConventionalTypes are the types from the Conventional Commits
+// specification and its widely used Angular convention.
+var defaultConventionalTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "build", "ci", "chore", "revert"}
+
+var (
+	conventionalSubject = regexp.MustCompile(`^([a-z]+)(\([^()]+\))?(!)?: (.*)$`)
+	conventionalType    = regexp.MustCompile(`^[a-z]+$`)
+)
+
+// ParseCommitLint parses a -commit-lint spec: space-separated rules from
+//
+//	conventional            Conventional Commits subjects, with the standard types
+//	conventional=feat,fix   Conventional Commits subjects, with only these types
+//	max-subject=N           subject lines of at most N characters
+//	ticket=REGEXP           a ticket ID matching REGEXP somewhere in the message
+//
+// An empty spec or "off" returns nil, which disables the lint.
+func ParseCommitLint(spec string) (*CommitLint, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+	l := &CommitLint{}
+	for _, rule := range strings.Fields(spec) {
+		key, val, hasVal := strings.Cut(rule, "=")
+		switch key {
+		case "conventional":
+			l.Conventional = true
+			l.ConventionalTypes = defaultConventionalTypes
+			if hasVal {
+				l.ConventionalTypes = strings.Split(val, ",")
+				for _, typ := range l.ConventionalTypes {
+					if !conventionalType.MatchString(typ) {
+						return nil, fmt.Errorf("commit lint: conventional commit type %q must be lowercase letters", typ)
+					}
+				}
+			}
+		case "max-subject":
+			n, err := strconv.Atoi(val)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("commit lint: max-subject wants a positive number, got %q", val)
+			}
+			l.MaxSubject = n
+		case "ticket":
+			if val == "" {
+				return nil, fmt.Errorf("commit lint: ticket wants a regular expression")
+			}
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return nil, fmt.Errorf("commit lint: ticket: %w", err)
+			}
+			l.Ticket = re
+		default:
+			return nil, fmt.Errorf("commit lint: unknown rule %q (want conventional, max-subject or ticket)", key)
+		}
+	}
+	return l, nil
+}
+
+// LoadCommitLint parses spec, falling back to the sketch.commitLint
+// git config setting of the repository at repoRoot when spec is empty.
+func LoadCommitLint(ctx context.Context, repoRoot, spec string) (*CommitLint, error) {
+	return ParseCommitLint(configSpec(ctx, repoRoot, "commitLint", spec))
+}
+
+// Describe summarizes the policy for the agent's instructions.
+func (l *CommitLint) Describe() string {
+	var rules []string
+	if l.Conventional {
+		rules = append(rules, fmt.Sprintf("use Conventional Commits subjects, \"type(scope): subject\", with type one of %s", strings.Join(l.ConventionalTypes, ", ")))
+	}
+	if l.MaxSubject > 0 {
+		rules = append(rules, fmt.Sprintf("keep the subject line to %d characters or fewer", l.MaxSubject))
+	}
+	if l.Ticket != nil {
+		rules = append(rules, fmt.Sprintf("mention a ticket ID matching the regular expression `%s`", l.Ticket))
+	}
+	return strings.Join(rules, "; ")
+}
+
+// Lint checks msg against the policy. It returns the rules msg breaks
+// and, if there are any, a reworded message that fixes what can be fixed mechanically.
+func (l *CommitLint) Lint(msg string) (problems []string, fixed string) {
+	msg = strings.TrimSpace(msg)
+	subject, body, _ := strings.Cut(msg, "\n")
+	subject = strings.TrimSpace(subject)
+	fixedSubject := subject
+
+	if l.Conventional {
+		m := conventionalSubject.FindStringSubmatch(subject)
+		switch {
+		case m == nil:
+			problems = append(problems, fmt.Sprintf("the subject %q is not in Conventional Commits form, \"type(scope): subject\"", subject))
+			typ, rest := guessConventionalType(subject, l.ConventionalTypes)
+			fixedSubject = typ + ": " + rest
+		case !slices.Contains(l.ConventionalTypes, m[1]):
+			problems = append(problems, fmt.Sprintf("%q is not an allowed commit type (want one of %s)", m[1], strings.Join(l.ConventionalTypes, ", ")))
+			typ, _ := guessConventionalType(m[4], l.ConventionalTypes)
+			fixedSubject = typ + m[2] + m[3] + ": " + m[4]
+		}
+	}
+	if l.MaxSubject > 0 && len([]rune(subject)) > l.MaxSubject {
+		problems = append(problems, fmt.Sprintf("the subject line is %d characters long; the limit is %d", len([]rune(subject)), l.MaxSubject))
+	}
+	if l.MaxSubject > 0 {
+		fixedSubject = truncateWords(fixedSubject, l.MaxSubject)
+	}
+	if l.Ticket != nil && !l.Ticket.MatchString(msg) {
+		problems = append(problems, fmt.Sprintf("the message doesn't mention a ticket ID matching `%s`; add one, for example as a \"Refs:\" trailer", l.Ticket))
+	}
+
+	if len(problems) == 0 {
+		return nil, ""
+	}
+	fixed = fixedSubject
+	if body = strings.TrimSpace(body); body != "" {
+		fixed += "\n\n" + body
+	}
+	return problems, fixed
+}
+
+// verbTypes maps the leading verbs of non-conventional subjects to the commit type they suggest.
+var verbTypes = map[string]string{
+	"fix": "fix", "correct": "fix", "repair": "fix", "resolve": "fix", "handle": "fix",
+	"add": "feat", "implement": "feat", "introduce": "feat", "support": "feat", "allow": "feat", "enable": "feat",
+	"doc": "docs", "document": "docs", "test": "test",
+	"refactor": "refactor", "simplify": "refactor", "rename": "refactor", "move": "refactor", "extract": "refactor", "clean": "refactor",
+	"revert": "revert",
+}
+
+// guessConventionalType picks a commit type for a subject from its leading verb,
+// and returns the subject lowercased to follow the colon.
+func guessConventionalType(subject string, types []string) (typ, rest string) {
+	verb, _, _ := strings.Cut(strings.ToLower(subject), " ")
+	typ = "chore"
+	for _, stem := range []string{verb, strings.TrimSuffix(verb, "s"), strings.TrimSuffix(verb, "es"), strings.TrimSuffix(verb, "ed"), strings.TrimSuffix(verb, "d")} {
+		if t, ok := verbTypes[stem]; ok {
+			typ = t
+			break
+		}
+	}
+	if !slices.Contains(types, typ) {
+		typ = types[0]
+	}
+	rest = subject
+	if rest != "" {
+		rest = strings.ToLower(rest[:1]) + rest[1:]
+	}
+	return typ, rest
+}
+
+// truncateWords shortens s to at most n characters, cutting at a word boundary when it can.
+func truncateWords(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	cut := string(r[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 && r[n] != ' ' {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,;:.-")
+}
+
+// lintHeadMessage lints the message of the HEAD commit.
+// It returns "" if there is no policy or the message follows it.
+func (r *CodeReviewer) lintHeadMessage(ctx context.Context) string {
+	if r.commitLint == nil {
+		return ""
+	}
+	cmd := exec.CommandContext(ctx, "git", "log", "-1", "--format=%B", "HEAD")
+	cmd.Dir = r.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	problems, fixed := r.commitLint.Lint(string(out))
+	if len(problems) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Your latest commit message doesn't follow this repository's commit message policy:\n\n- %s\n\nHere is a proposed rewording that fixes what can be fixed mechanically:\n\n```\n%s\n```",
+		strings.Join(problems, "\n- "), fixed)
+}
+
+// SetCommitLint sets the commit message policy RunMechanicalChecks enforces; nil disables it.
+func (r *CodeReviewer) SetCommitLint(l *CommitLint) {
+	r.commitLint = l
+}
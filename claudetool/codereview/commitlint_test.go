@@ -0,0 +1,50 @@
+package codereview
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommitLint(t *testing.T) {
+	l, err := ParseCommitLint(`conventional max-subject=30 ticket=[A-Z]+-[0-9]+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		msg      string
+		problems int
+		fixed    string
+	}{
+		{"feat(ui): add dark mode\n\nRefs: UI-12", 0, ""},
+		{"Fixed the crash on empty input again\n\nRefs: CORE-7", 2, "fix: fixed the crash on empty\n\nRefs: CORE-7"},
+		{"feature: add dark mode", 2, "feat: add dark mode"},
+		{"docs!: rewrite README UI-3", 0, ""},
+	}
+	for _, tt := range tests {
+		problems, fixed := l.Lint(tt.msg)
+		if len(problems) != tt.problems || fixed != tt.fixed {
+			t.Errorf("Lint(%q) = %q, %q; want %d problems, %q", tt.msg, problems, fixed, tt.problems, tt.fixed)
+		}
+	}
+}
+
+func TestParseCommitLint(t *testing.T) {
+	if l, err := ParseCommitLint("off"); l != nil || err != nil {
+		t.Errorf(`ParseCommitLint("off") = %v, %v`, l, err)
+	}
+	l, err := ParseCommitLint("conventional=fix,feat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems, fixed := l.Lint("Tidy up logging"); len(problems) != 1 || fixed != "fix: tidy up logging" {
+		t.Errorf("restricted types: %q, %q", problems, fixed)
+	}
+	if !strings.Contains(l.Describe(), "fix, feat") {
+		t.Errorf("Describe() = %q", l.Describe())
+	}
+	for _, spec := range []string{"max-subject=0", "ticket=(", "conventional=Feat", "signoff"} {
+		if _, err := ParseCommitLint(spec); err == nil {
+			t.Errorf("ParseCommitLint(%q) succeeded", spec)
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/mod/modfile"
 	"golang.org/x/tools/go/packages"
 	"sketch.dev/llm"
 )
@@ -149,15 +150,33 @@ func (r *CodeReviewer) Run(ctx context.Context, m json.RawMessage) llm.ToolOut {
 		}
 	}
 
-	testMsg, err := r.checkTests(timeoutCtx, allPkgList)
-	if err != nil {
-		slog.DebugContext(ctx, "CodeReviewer.Run: failed to check tests", "err", err)
-		return llm.ErrorToolOut(err)
+	var testMsg string
+	if r.taskRunner != nil {
+		testMsg, err = r.checkRunnerTests(timeoutCtx, changedFiles)
+		if err != nil {
+			slog.DebugContext(ctx, "CodeReviewer.Run: failed to check tests with the task runner", "err", err)
+			infoMessages = append(infoMessages, fmt.Sprintf("The %s test check was skipped: %v", r.taskRunner.Kind, err))
+		}
+	} else {
+		testMsg, err = r.checkTests(timeoutCtx, allPkgs)
+		if err != nil {
+			slog.DebugContext(ctx, "CodeReviewer.Run: failed to check tests", "err", err)
+			return llm.ErrorToolOut(err)
+		}
 	}
 	if testMsg != "" {
 		errorMessages = append(errorMessages, testMsg)
 	}
 
+	benchMsg, err := r.checkBenchmarks(timeoutCtx, allPkgList)
+	if err != nil {
+		slog.DebugContext(ctx, "CodeReviewer.Run: failed to check benchmarks", "err", err)
+		infoMessages = append(infoMessages, "The bench check was skipped: "+err.Error())
+	}
+	if benchMsg != "" {
+		errorMessages = append(errorMessages, benchMsg)
+	}
+
 	goplsMsg, err := r.checkGopls(timeoutCtx, changedFiles) // includes vet checks
 	if err != nil {
 		slog.DebugContext(ctx, "CodeReviewer.Run: failed to check gopls", "err", err)
@@ -176,6 +195,7 @@ func (r *CodeReviewer) Run(ctx context.Context, m json.RawMessage) llm.ToolOut {
 		buf.WriteString("\n\n")
 	}
 	if len(errorMessages) > 0 {
+		r.unclean = append(r.unclean, currentCommit)
 		buf.WriteString("# Errors\n\n")
 		buf.WriteString(strings.Join(errorMessages, "\n\n"))
 		buf.WriteString("\n\nPlease fix before proceeding.\n")
@@ -204,38 +224,38 @@ func (r *CodeReviewer) initializeInitialCommitWorktree(ctx context.Context) erro
 	return nil
 }
 
-func (r *CodeReviewer) checkTests(ctx context.Context, pkgList []string) (string, error) {
-	// 'gopls check' covers everything that 'go vet' covers.
-	// Disabling vet here speeds things up, and allows more precise filtering and reporting.
-	goTestArgs := []string{"test", "-json", "-v", "-vet=off"}
-	goTestArgs = append(goTestArgs, pkgList...)
-
-	afterTestCmd := exec.CommandContext(ctx, "go", goTestArgs...)
-	afterTestCmd.Dir = r.repoRoot
-	afterTestCmd.Env = append(os.Environ(), "SKETCH_IGNORE_PORTS=1")
-	afterTestOut, _ := afterTestCmd.Output()
-	// unfortunately, we can't short-circuit here even if all tests pass,
+func (r *CodeReviewer) checkTests(ctx context.Context, pkgs map[string]*packages.Package) (string, error) {
+	// Only re-run the packages that changed since the last review.
+	// Unfortunately, we can't skip the rest even if all tests pass,
 	// because we need to check for skipped tests.
-
-	err := r.initializeInitialCommitWorktree(ctx)
-	if err != nil {
-		return "", err
+	keys := r.packageKeys(ctx, pkgs)
+	afterResults, stale := r.tests.lookupAfter(keys)
+	if len(stale) > 0 {
+		afterTestOut := r.goTest(ctx, r.repoRoot, stale)
+		results, err := parseTestResults(afterTestOut)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse test results for current commit: %w\n%s", err, afterTestOut)
+		}
+		r.tests.storeAfter(keys, stale, results)
+		afterResults = append(afterResults, results...)
 	}
 
-	beforeTestCmd := exec.CommandContext(ctx, "go", goTestArgs...)
-	beforeTestCmd.Dir = r.initialWorktree
-	beforeTestCmd.Env = append(os.Environ(), "SKETCH_IGNORE_PORTS=1")
-	beforeTestOut, _ := beforeTestCmd.Output() // ignore error, interesting info is in the output
-
-	// Parse the jsonl test results
-	beforeResults, beforeParseErr := parseTestResults(beforeTestOut)
-	if beforeParseErr != nil {
-		return "", fmt.Errorf("unable to parse test results for initial commit: %w\n%s", beforeParseErr, beforeTestOut)
-	}
-	afterResults, afterParseErr := parseTestResults(afterTestOut)
-	if afterParseErr != nil {
-		return "", fmt.Errorf("unable to parse test results for current commit: %w\n%s", afterParseErr, afterTestOut)
+	beforeResults, missing := r.tests.lookupBefore(slices.Sorted(maps.Keys(pkgs)))
+	if len(missing) > 0 {
+		err := r.initializeInitialCommitWorktree(ctx)
+		if err != nil {
+			return "", err
+		}
+		beforeTestOut := r.goTest(ctx, r.initialWorktree, missing)
+		results, err := parseTestResults(beforeTestOut)
+		if err != nil {
+			return "", fmt.Errorf("unable to parse test results for initial commit: %w\n%s", err, beforeTestOut)
+		}
+		r.tests.storeBefore(missing, results)
+		beforeResults = append(beforeResults, results...)
 	}
+	slog.DebugContext(ctx, "codereview: ran tests", "packages", len(pkgs), "current", len(stale), "initial", len(missing))
+
 	testRegressions, err := r.compareTestResults(beforeResults, afterResults)
 	if err != nil {
 		return "", fmt.Errorf("failed to compare test results: %w", err)
@@ -245,6 +265,19 @@ func (r *CodeReviewer) checkTests(ctx context.Context, pkgList []string) (string
 	return res, nil
 }
 
+// goTest runs the tests of pkgs in dir, returning the JSON output.
+func (r *CodeReviewer) goTest(ctx context.Context, dir string, pkgs []string) []byte {
+	// 'gopls check' covers everything that 'go vet' covers.
+	// Disabling vet here speeds things up, and allows more precise filtering and reporting.
+	goTestArgs := []string{"test", "-json", "-v", "-vet=off"}
+	goTestArgs = append(goTestArgs, pkgs...)
+	cmd := exec.CommandContext(ctx, "go", goTestArgs...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SKETCH_IGNORE_PORTS=1")
+	out, _ := cmd.Output() // ignore error, interesting info is in the output
+	return out
+}
+
 // GoplsIssue represents a single issue reported by gopls check
 type GoplsIssue struct {
 	Position string // File position in format "file:line:col-range"
@@ -593,12 +626,23 @@ func (r *CodeReviewer) packagesForFiles(ctx context.Context, files []string) (al
 		// Logf: func(msg string, args ...any) {
 		// 	slog.DebugContext(ctx, "loading go packages", "msg", fmt.Sprintf(msg, args...))
 		// },
-		// TODO: in theory, go.mod might not be in the repo root, and there might be multiple go.mod files.
-		// We can cross that bridge when we get there.
 		Dir:   r.repoRoot,
 		Tests: true,
 	}
-	universe, err := packages.Load(cfg, "./...")
+	// In a Go workspace, "./..." only covers the modules rooted under repoRoot
+	// and misses their dependents elsewhere in the workspace, so ask for every used module.
+	patterns := []string{"./..."}
+	moduleDirs, err := goWorkspaceModules(ctx, r.repoRoot)
+	if err != nil {
+		slog.DebugContext(ctx, "ignoring unreadable go.work", "err", err)
+	}
+	if len(moduleDirs) > 0 {
+		patterns = patterns[:0]
+		for _, dir := range moduleDirs {
+			patterns = append(patterns, dir+"/...")
+		}
+	}
+	universe, err := packages.Load(cfg, patterns...)
 	if err != nil {
 		return nil, err
 	}
@@ -659,8 +703,11 @@ func addDependentPackages(universe []*packages.Package, pkgs map[string]*package
 				// skip test packages
 				continue
 			}
-			if _, ok := pkgs[p.PkgPath]; ok {
-				// already in pkgs
+			if prev, ok := pkgs[p.PkgPath]; ok {
+				// already in pkgs; prefer test packages, whose imports include the tests'
+				if prev.ForTest == "" && p.ForTest != "" {
+					pkgs[p.PkgPath] = p
+				}
 				continue
 			}
 			for importPath := range p.Imports {
@@ -1266,3 +1313,35 @@ func (r *CodeReviewer) warmTestCache(ctx context.Context, modifiedFile string) e
 	slog.DebugContext(ctx, "cache warming complete", "duration", time.Since(start), "error", err)
 	return nil
 }
+
+// goWorkspaceModules returns the absolute directories of the modules used by
+// the Go workspace in effect for dir. It returns nil if there is no workspace.
+func goWorkspaceModules(ctx context.Context, dir string) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "go", "env", "GOWORK")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env GOWORK: %w", err)
+	}
+	gowork := strings.TrimSpace(string(out))
+	if gowork == "" || gowork == "off" {
+		return nil, nil
+	}
+	content, err := os.ReadFile(gowork)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := modfile.ParseWork(gowork, content, nil)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, use := range wf.Use {
+		p := use.Path
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(gowork), p)
+		}
+		dirs = append(dirs, filepath.Clean(p))
+	}
+	return dirs, nil
+}
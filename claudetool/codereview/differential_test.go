@@ -1,6 +1,11 @@
 package codereview
 
 import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 )
@@ -214,3 +219,46 @@ func TestHashChangedFiles(t *testing.T) {
 		t.Errorf("Expected different hash for different number of files, got same hash %q", hash1)
 	}
 }
+
+func TestPackagesForFilesWorkspace(t *testing.T) {
+	t.Setenv("GOFLAGS", "") // -mod=mod is rejected in workspace mode
+	// The repo (lib) is one module of a workspace whose go.work lives above it.
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.work":     "go 1.24\n\nuse (\n\t./app\n\t./lib\n)\n",
+		"app/go.mod":  "module example.com/app\n\ngo 1.24\n",
+		"app/main.go": "package main\n\nimport \"example.com/lib\"\n\nfunc main() { lib.Hello() }\n",
+		"lib/go.mod":  "module example.com/lib\n\ngo 1.24\n",
+		"lib/lib.go":  "package lib\n\nfunc Hello() {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repoRoot := filepath.Join(dir, "lib")
+
+	mods, err := goWorkspaceModules(context.Background(), repoRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(dir, "app"), repoRoot}; !slices.Equal(mods, want) {
+		t.Errorf("goWorkspaceModules = %v, want %v", mods, want)
+	}
+
+	r := &CodeReviewer{repoRoot: repoRoot}
+	pkgs, err := r.packagesForFiles(context.Background(), []string{filepath.Join(repoRoot, "lib.go")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The change to lib affects app, which lives in another workspace module.
+	for _, want := range []string{"example.com/lib", "example.com/app"} {
+		if pkgs[want] == nil {
+			t.Errorf("packagesForFiles missing %s; got %v", want, slices.Collect(maps.Keys(pkgs)))
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package codereview
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DocsCheck is the docs-quality pass of the mechanical checks: a codespell- and
+// vale-like review of the markdown and code comments the latest commit adds.
+type DocsCheck struct {
+	Spelling bool // common misspellings and repeated words
+	Style    bool // informal or unprofessional wording
+}
+
+// A DocsIssue is a possible problem in prose the agent wrote.
+type DocsIssue struct {
+	File       string
+	Line       int
+	Kind       string // "spelling", "repeated word" or "style"
+	Text       string // the offending word or phrase
+	Suggestion string // what to write instead; empty to just drop it
+}
+
+func (i DocsIssue) String() string {
+	s := fmt.Sprintf("%s:%d: %s: %q", i.File, i.Line, i.Kind, i.Text)
+	if i.Suggestion != "" {
+		s += fmt.Sprintf(" (did you mean %q?)", i.Suggestion)
+	}
+	return s
+}
+
+// ParseDocsCheck parses a -docs-check spec: space-separated checks from
+//
+//	spelling   common misspellings and repeated words
+//	style      informal wording, such as "gonna" or "stuff"
+//	on         both
+//
+// An empty spec or "off" returns nil, which disables the check.
+func ParseDocsCheck(spec string) (*DocsCheck, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+	c := &DocsCheck{}
+	for _, check := range strings.Fields(spec) {
+		switch check {
+		case "spelling":
+			c.Spelling = true
+		case "style":
+			c.Style = true
+		case "on":
+			c.Spelling, c.Style = true, true
+		default:
+			return nil, fmt.Errorf("docs check: unknown check %q (want spelling, style or on)", check)
+		}
+	}
+	return c, nil
+}
+
+// LoadDocsCheck parses spec, falling back to the sketch.docsCheck
+// git config setting of the repository at repoRoot when spec is empty.
+func LoadDocsCheck(ctx context.Context, repoRoot, spec string) (*DocsCheck, error) {
+	return ParseDocsCheck(configSpec(ctx, repoRoot, "docsCheck", spec))
+}
+
+// SetDocsCheck sets the docs check RunMechanicalChecks runs; nil disables it.
+func (r *CodeReviewer) SetDocsCheck(c *DocsCheck) {
+	r.docsCheck = c
+}
+
+// misspellings maps common misspellings, mostly from codespell's dictionary, to their fixes.
+var misspellings = map[string]string{
+	"accesible": "accessible", "accomodate": "accommodate", "accross": "across", "acheive": "achieve",
+	"adress": "address", "alot": "a lot", "aquire": "acquire", "arguement": "argument",
+	"asynchronus": "asynchronous", "becuase": "because", "begining": "beginning", "beleive": "believe",
+	"calender": "calendar", "commited": "committed", "compatability": "compatibility", "concious": "conscious",
+	"defualt": "default", "definately": "definitely", "dissapear": "disappear", "embarass": "embarrass",
+	"enviroment": "environment", "existance": "existence", "explicitely": "explicitly", "familar": "familiar",
+	"finaly": "finally", "foward": "forward", "functionallity": "functionality", "gaurantee": "guarantee",
+	"goverment": "government", "happend": "happened", "immediatly": "immediately", "implmentation": "implementation",
+	"independant": "independent", "initalize": "initialize", "interupt": "interrupt", "lenght": "length",
+	"neccessary": "necessary", "noticable": "noticeable", "occassion": "occasion", "occured": "occurred",
+	"occurence": "occurrence", "overriden": "overridden", "paramter": "parameter", "parrallel": "parallel",
+	"persistant": "persistent", "posible": "possible", "preceed": "precede", "prefered": "preferred",
+	"propogate": "propagate", "publically": "publicly", "recieve": "receive", "recomend": "recommend",
+	"refered": "referred", "relevent": "relevant", "reponse": "response", "responsability": "responsibility",
+	"retreive": "retrieve", "retrun": "return", "seperate": "separate", "similiar": "similar",
+	"succesfully": "successfully", "sucess": "success", "sucessful": "successful", "supress": "suppress",
+	"suprise": "surprise", "teh": "the", "thier": "their", "tommorow": "tomorrow",
+	"transfered": "transferred", "truely": "truly", "unecessary": "unnecessary", "untill": "until",
+	"usefull": "useful", "wich": "which", "wierd": "weird", "writting": "writing",
+}
+
+// informal maps wording that reads as unprofessional in docs and comments to
+// a replacement, or to "" if it is better left out.
+var informal = map[string]string{
+	"gonna": "going to", "wanna": "want to", "gotta": "have to", "kinda": "somewhat", "sorta": "somewhat",
+	"dunno": "don't know", "btw": "", "lol": "", "fyi": "", "stuff": "", "awesome": "", "hacky": "",
+	"obviously": "", "simply": "", "basically": "",
+}
+
+var (
+	// proseCommentRe finds a comment in a line of code. The comment token must
+	// start the line or follow a space, and be followed by one, which keeps out
+	// URLs, shebangs and most operators.
+	proseCommentRe = regexp.MustCompile(`(?:^|\s)(?://+|#+|/\*+|\*+|--|<!--)\s+(.*)`)
+	inlineCodeRe   = regexp.MustCompile("`[^`]*`")
+	urlRe          = regexp.MustCompile(`\w+://\S+`)
+	wordRe         = regexp.MustCompile(`[A-Za-z]+(?:'[A-Za-z]+)?`)
+)
+
+// docsCheckExts are the code files whose comments the docs check reads.
+var docsCheckExts = map[string]bool{
+	".go": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".py": true, ".rb": true,
+	".rs": true, ".java": true, ".kt": true, ".swift": true, ".c": true, ".h": true, ".cc": true,
+	".cpp": true, ".sh": true, ".sql": true, ".yaml": true, ".yml": true,
+}
+
+func isMarkdown(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".md", ".markdown", ".mdx":
+		return true
+	}
+	return false
+}
+
+// checkHeadDocs returns the docs issues in the lines the HEAD commit adds,
+// leaving out any already present in the lines it removes.
+func (r *CodeReviewer) checkHeadDocs(ctx context.Context) []DocsIssue {
+	if r.docsCheck == nil {
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "diff", "-U0", "--no-color", "--no-ext-diff", "HEAD^1", "HEAD")
+	cmd.Dir = r.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	return r.docsCheck.checkDiff(out)
+}
+
+// checkDiff reports the issues on the added lines of a -U0 diff. Issues on
+// removed lines of the same file were there before, so as many matching
+// added issues are not reported.
+func (c *DocsCheck) checkDiff(diff []byte) []DocsIssue {
+	var issues []DocsIssue
+	existing := make(map[string]int) // file, kind and text -> count on removed lines
+	key := func(i DocsIssue) string { return i.File + "\x00" + i.Kind + "\x00" + strings.ToLower(i.Text) }
+
+	var file string
+	var line int
+	var inFence bool // inside a markdown code block
+	sc := bufio.NewScanner(bytes.NewReader(diff))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		l := sc.Text()
+		switch {
+		case strings.HasPrefix(l, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(l, "+++ "), "b/")
+			if file == "/dev/null" || !isMarkdown(file) && !docsCheckExts[strings.ToLower(filepath.Ext(file))] {
+				file = ""
+			}
+		case strings.HasPrefix(l, "--- "):
+		case strings.HasPrefix(l, "@@ "):
+			// @@ -a,b +c,d @@
+			fields := strings.Fields(l)
+			if len(fields) >= 3 {
+				start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+				line, _ = strconv.Atoi(start)
+			}
+			inFence = false
+		case file == "":
+		case strings.HasPrefix(l, "-"):
+			for _, i := range c.checkLine(file, 0, l[1:], nil) {
+				existing[key(i)]++
+			}
+		case strings.HasPrefix(l, "+"):
+			for _, i := range c.checkLine(file, line, l[1:], &inFence) {
+				if existing[key(i)] > 0 {
+					existing[key(i)]--
+					continue
+				}
+				issues = append(issues, i)
+			}
+			line++
+		}
+	}
+	return issues
+}
+
+// checkLine reports the issues in the prose of one line of file:
+// all of it for markdown, outside code, and the comment for code.
+// inFence tracks markdown code blocks across lines, if non-nil.
+func (c *DocsCheck) checkLine(file string, line int, text string, inFence *bool) []DocsIssue {
+	if isMarkdown(file) {
+		if strings.HasPrefix(strings.TrimSpace(text), "```") {
+			if inFence != nil {
+				*inFence = !*inFence
+			}
+			return nil
+		}
+		if inFence != nil && *inFence {
+			return nil
+		}
+	} else {
+		m := proseCommentRe.FindStringSubmatch(text)
+		if m == nil {
+			return nil
+		}
+		text = m[1]
+	}
+	text = urlRe.ReplaceAllString(inlineCodeRe.ReplaceAllString(text, " "), " ")
+
+	var issues []DocsIssue
+	prev := ""
+	for _, loc := range wordRe.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		lower := strings.ToLower(word)
+		if c.Spelling {
+			if fix, ok := misspellings[lower]; ok {
+				issues = append(issues, DocsIssue{File: file, Line: line, Kind: "spelling", Text: word, Suggestion: matchCase(fix, word)})
+			}
+			// Only count words separated by spaces alone, so "that. That" and "x, x" pass.
+			if lower == prev && len(lower) > 1 && strings.TrimSpace(text[loc[0]-1:loc[0]]) == "" {
+				issues = append(issues, DocsIssue{File: file, Line: line, Kind: "repeated word", Text: word + " " + word, Suggestion: word})
+			}
+		}
+		if c.Style {
+			if fix, ok := informal[lower]; ok {
+				issues = append(issues, DocsIssue{File: file, Line: line, Kind: "style", Text: word, Suggestion: matchCase(fix, word)})
+			}
+		}
+		prev = lower
+		if end := loc[1]; end < len(text) && text[end] != ' ' {
+			prev = ""
+		}
+	}
+	return issues
+}
+
+// matchCase capitalizes fix if word is capitalized.
+func matchCase(fix, word string) string {
+	if fix == "" || !unicode.IsUpper(rune(word[0])) {
+		return fix
+	}
+	return strings.ToUpper(fix[:1]) + fix[1:]
+}
+
+// formatDocsIssues describes docs issues for the agent.
+func formatDocsIssues(issues []DocsIssue) string {
+	var b strings.Builder
+	b.WriteString("The docs check found possible problems in the prose your latest commit adds:\n\n")
+	for _, i := range issues {
+		b.WriteString("- ")
+		b.WriteString(i.String())
+		b.WriteString("\n")
+	}
+	b.WriteString("\nThey don't block anything, but please fix the ones that are real mistakes.")
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package codereview
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDocsCheck(t *testing.T) {
+	c, err := ParseDocsCheck("spelling")
+	if err != nil || *c != (DocsCheck{Spelling: true}) {
+		t.Errorf("ParseDocsCheck(spelling) = %+v, %v", c, err)
+	}
+	if c, err := ParseDocsCheck("on"); err != nil || *c != (DocsCheck{Spelling: true, Style: true}) {
+		t.Errorf("ParseDocsCheck(on) = %+v, %v", c, err)
+	}
+	if c, err := ParseDocsCheck("off"); c != nil || err != nil {
+		t.Errorf("ParseDocsCheck(off) = %v, %v; want nil, nil", c, err)
+	}
+	if _, err := ParseDocsCheck("grammar"); err == nil {
+		t.Error("ParseDocsCheck(grammar) succeeded")
+	}
+}
+
+func TestDocsCheckDiff(t *testing.T) {
+	diff := "diff --git a/README.md b/README.md\n" +
+		"--- a/README.md\n" +
+		"+++ b/README.md\n" +
+		"@@ -3 +3,6 @@\n" +
+		"-We recieve requests.\n" +
+		"+We recieve requests and seperate them.\n" +
+		"+Run `teh` against the the https://example.com/alot page.\n" +
+		"+```\n" +
+		"+wich is code\n" +
+		"+```\n" +
+		"+This is gonna work. Work it.\n" +
+		"diff --git a/main.go b/main.go\n" +
+		"--- a/main.go\n" +
+		"+++ b/main.go\n" +
+		"@@ -0,0 +1,2 @@\n" +
+		"+x := \"untill\" // Occured once; stuff\n" +
+		"+url := \"http://teh.example\"\n" +
+		"diff --git a/data.csv b/data.csv\n" +
+		"--- a/data.csv\n" +
+		"+++ b/data.csv\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+recieve\n"
+
+	got := (&DocsCheck{Spelling: true, Style: true}).checkDiff([]byte(diff))
+	want := []DocsIssue{
+		{File: "README.md", Line: 3, Kind: "spelling", Text: "seperate", Suggestion: "separate"},
+		{File: "README.md", Line: 4, Kind: "repeated word", Text: "the the", Suggestion: "the"},
+		{File: "README.md", Line: 8, Kind: "style", Text: "gonna", Suggestion: "going to"},
+		{File: "main.go", Line: 1, Kind: "spelling", Text: "Occured", Suggestion: "Occurred"},
+		{File: "main.go", Line: 1, Kind: "style", Text: "stuff"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("checkDiff =\n%v\nwant\n%v", got, want)
+	}
+
+	got = (&DocsCheck{Style: true}).checkDiff([]byte(diff))
+	if len(got) != 2 || got[0].Kind != "style" || got[1].Kind != "style" {
+		t.Errorf("style-only checkDiff = %v", got)
+	}
+}
@@ -0,0 +1,242 @@
+package codereview
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QualityGates are the criteria the done tool checks before the agent may finish.
+// The zero value checks nothing.
+type QualityGates struct {
+	Tests      bool    // the repository's tests pass
+	CodeReview bool    // the codereview tool reported no errors for HEAD
+	Coverage   float64 // if positive, the minimum total statement coverage, in percent
+	Lint       bool    // the repository's linter reports nothing
+}
+
+// A Requirement is a quality gate that isn't met, phrased as what the agent must do about it.
+type Requirement struct {
+	Gate        string `json:"gate"`
+	Requirement string `json:"requirement"`
+	Details     string `json:"details,omitempty"`
+}
+
+// gateTimeout bounds each command a quality gate runs.
+const gateTimeout = 10 * time.Minute
+
+// maxGateDetails caps the command output carried in a Requirement; the end is kept.
+const maxGateDetails = 4096
+
+// ParseQualityGates parses a -quality-gates spec: space-separated gates from
+//
+//	tests        the tests pass (go test ./..., npm test, or make test)
+//	codereview   the codereview tool reported no errors for the final commit
+//	coverage=N   total Go statement coverage is at least N percent
+//	lint         the linter is clean (go vet ./..., npm run lint, or make lint)
+//
+// An empty spec or "off" returns nil, which disables the gates.
+func ParseQualityGates(spec string) (*QualityGates, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+	g := &QualityGates{}
+	for _, gate := range strings.Fields(spec) {
+		key, val, _ := strings.Cut(gate, "=")
+		switch key {
+		case "tests":
+			g.Tests = true
+		case "codereview":
+			g.CodeReview = true
+		case "coverage":
+			n, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+			if err != nil || n <= 0 || n > 100 {
+				return nil, fmt.Errorf("quality gates: coverage wants a percentage in (0, 100], got %q", val)
+			}
+			g.Coverage = n
+		case "lint":
+			g.Lint = true
+		default:
+			return nil, fmt.Errorf("quality gates: unknown gate %q (want tests, codereview, coverage or lint)", key)
+		}
+	}
+	return g, nil
+}
+
+// LoadQualityGates parses spec, falling back to the sketch.qualityGates
+// git config setting of the repository at repoRoot when spec is empty.
+func LoadQualityGates(ctx context.Context, repoRoot, spec string) (*QualityGates, error) {
+	return ParseQualityGates(configSpec(ctx, repoRoot, "qualityGates", spec))
+}
+
+// CheckQualityGates evaluates g against HEAD and returns the gates that aren't met.
+// Gates that don't apply to the repository, such as coverage outside Go, are skipped.
+func (r *CodeReviewer) CheckQualityGates(ctx context.Context, g *QualityGates) []Requirement {
+	if g == nil {
+		return nil
+	}
+	var reqs []Requirement
+	if g.CodeReview {
+		head, err := r.CurrentCommit(ctx)
+		if err == nil && slices.Contains(r.unclean, head) {
+			reqs = append(reqs, Requirement{
+				Gate:        "codereview",
+				Requirement: "Fix the errors the codereview tool reported, commit, and run codereview again until it reports no errors.",
+			})
+		}
+	}
+	if g.Lint {
+		if cmd := r.lintCommand(); cmd != nil {
+			if out, err := r.runGate(ctx, cmd); err != nil {
+				reqs = append(reqs, Requirement{
+					Gate:        "lint",
+					Requirement: fmt.Sprintf("Make `%s` report no problems.", strings.Join(cmd, " ")),
+					Details:     out,
+				})
+			}
+		} else {
+			slog.DebugContext(ctx, "quality gates: no linter found, skipping lint gate")
+		}
+	}
+	coverage := g.Coverage > 0 && r.isGoRepository()
+	if g.Tests || coverage {
+		reqs = append(reqs, r.checkTestGates(ctx, g.Coverage, coverage)...)
+	}
+	return reqs
+}
+
+// checkTestGates runs the tests once for the tests and coverage gates.
+// Coverage is measured only when coverage is set, which needs a Go repository.
+func (r *CodeReviewer) checkTestGates(ctx context.Context, minCoverage float64, coverage bool) []Requirement {
+	cmd := r.testCommand()
+	if cmd == nil {
+		slog.DebugContext(ctx, "quality gates: no test command found, skipping tests gate")
+		return nil
+	}
+	var profile string
+	if coverage {
+		f, err := os.CreateTemp("", "sketch-coverage-*.out")
+		if err != nil {
+			slog.WarnContext(ctx, "quality gates: skipping coverage gate", "err", err)
+			coverage = false
+		} else {
+			f.Close()
+			profile = f.Name()
+			defer os.Remove(profile)
+			cmd = []string{"go", "test", "-coverprofile=" + profile, "./..."}
+		}
+	}
+	out, err := r.runGate(ctx, cmd)
+	if err != nil {
+		// Coverage of a failing run means little; ask for passing tests first.
+		return []Requirement{{
+			Gate:        "tests",
+			Requirement: fmt.Sprintf("Make `%s` pass.", strings.Join(cmd, " ")),
+			Details:     out,
+		}}
+	}
+	if !coverage {
+		return nil
+	}
+	pct, err := r.totalCoverage(ctx, profile)
+	if err != nil {
+		slog.WarnContext(ctx, "quality gates: skipping coverage gate", "err", err)
+		return nil
+	}
+	if pct < minCoverage {
+		return []Requirement{{
+			Gate:        "coverage",
+			Requirement: fmt.Sprintf("Raise total statement coverage from %.1f%% to at least %g%% by adding tests.", pct, minCoverage),
+		}}
+	}
+	return nil
+}
+
+var coverageTotal = regexp.MustCompile(`(?m)^total:\s+\(statements\)\s+([0-9.]+)%`)
+
+// totalCoverage reads the total statement coverage from a Go coverage profile.
+func (r *CodeReviewer) totalCoverage(ctx context.Context, profile string) (float64, error) {
+	cmd := exec.CommandContext(ctx, "go", "tool", "cover", "-func="+profile)
+	cmd.Dir = r.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("go tool cover: %w", err)
+	}
+	m := coverageTotal.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("go tool cover: no total in output")
+	}
+	return strconv.ParseFloat(string(m[1]), 64)
+}
+
+// testCommand picks the command that runs the repository's tests, or nil if there's none.
+func (r *CodeReviewer) testCommand() []string {
+	switch {
+	case r.isGoRepository():
+		return []string{"go", "test", "./..."}
+	case r.hasNPMScript("test"):
+		return []string{"npm", "test"}
+	case r.hasMakeTarget("test"):
+		return []string{"make", "test"}
+	}
+	return nil
+}
+
+// lintCommand picks the command that lints the repository, or nil if there's none.
+func (r *CodeReviewer) lintCommand() []string {
+	switch {
+	case r.isGoRepository():
+		return []string{"go", "vet", "./..."}
+	case r.hasNPMScript("lint"):
+		return []string{"npm", "run", "lint"}
+	case r.hasMakeTarget("lint"):
+		return []string{"make", "lint"}
+	}
+	return nil
+}
+
+func (r *CodeReviewer) hasNPMScript(name string) bool {
+	buf, err := os.ReadFile(filepath.Join(r.repoRoot, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+	if json.Unmarshal(buf, &pkg) != nil {
+		return false
+	}
+	_, ok := pkg.Scripts[name]
+	return ok
+}
+
+func (r *CodeReviewer) hasMakeTarget(name string) bool {
+	buf, err := os.ReadFile(filepath.Join(r.repoRoot, "Makefile"))
+	if err != nil {
+		return false
+	}
+	return regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(name) + `\s*:`).Match(buf)
+}
+
+// runGate runs cmd in the repository and returns the tail of its combined output.
+func (r *CodeReviewer) runGate(ctx context.Context, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gateTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = r.repoRoot
+	out, err := cmd.CombinedOutput()
+	if len(out) > maxGateDetails {
+		out = append([]byte("...\n"), out[len(out)-maxGateDetails:]...)
+	}
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,55 @@
+package codereview
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseQualityGates(t *testing.T) {
+	g, err := ParseQualityGates("tests codereview coverage=80% lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (QualityGates{Tests: true, CodeReview: true, Coverage: 80, Lint: true}); *g != want {
+		t.Errorf("ParseQualityGates = %+v, want %+v", *g, want)
+	}
+	if g, err := ParseQualityGates(" off "); g != nil || err != nil {
+		t.Errorf("ParseQualityGates(off) = %v, %v; want nil, nil", g, err)
+	}
+	for _, bad := range []string{"coverage=0", "coverage=101", "coverage", "typecheck"} {
+		if _, err := ParseQualityGates(bad); err == nil {
+			t.Errorf("ParseQualityGates(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestCheckQualityGates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/gates\n\ngo 1.21\n")
+	write("half.go", "package gates\n\nfunc Covered() int { return 1 }\n\nfunc Uncovered() int { return 2 }\n")
+	write("half_test.go", "package gates\n\nimport \"testing\"\n\nfunc TestCovered(t *testing.T) { Covered() }\n")
+	r := &CodeReviewer{repoRoot: dir}
+	ctx := context.Background()
+
+	reqs := r.CheckQualityGates(ctx, &QualityGates{Tests: true, Lint: true, Coverage: 90})
+	if len(reqs) != 1 || reqs[0].Gate != "coverage" {
+		t.Fatalf("CheckQualityGates = %+v, want only a coverage requirement", reqs)
+	}
+
+	write("half_test.go", "package gates\n\nimport \"testing\"\n\nfunc TestCovered(t *testing.T) { t.Fatal(\"boom\") }\n")
+	reqs = r.CheckQualityGates(ctx, &QualityGates{Tests: true, Coverage: 10})
+	if len(reqs) != 1 || reqs[0].Gate != "tests" || reqs[0].Details == "" {
+		t.Fatalf("CheckQualityGates = %+v, want a tests requirement with the output", reqs)
+	}
+
+	if reqs := r.CheckQualityGates(ctx, nil); reqs != nil {
+		t.Errorf("CheckQualityGates(nil) = %+v", reqs)
+	}
+}
@@ -0,0 +1,46 @@
+package codereview
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"sketch.dev/claudetool/taskrunner"
+)
+
+// SetTaskRunner makes Run select and run the tests the changes affect with
+// the repository's monorepo task runner, instead of go test; nil goes back to go test.
+func (r *CodeReviewer) SetTaskRunner(tr *taskrunner.Runner) {
+	r.taskRunner = tr
+}
+
+// checkRunnerTests runs the tests the task runner finds the changed files affect
+// and, if they fail, runs them again at the base commit: only tests that passed
+// there are reported. Tests that are new in the changes fail at the base commit
+// too, for want of a target, so they aren't reported either.
+func (r *CodeReviewer) checkRunnerTests(ctx context.Context, changedFiles []string) (string, error) {
+	var rel []string
+	for _, f := range changedFiles {
+		if p, err := filepath.Rel(r.repoRoot, f); err == nil {
+			rel = append(rel, filepath.ToSlash(p))
+		}
+	}
+	targets, err := r.taskRunner.AffectedTests(ctx, r.repoRoot, r.sketchBaseRef, rel)
+	if err != nil || len(targets) == 0 {
+		return "", err
+	}
+	afterOut, afterErr := r.taskRunner.Test(ctx, r.repoRoot, targets)
+	if afterErr == nil {
+		return "", nil
+	}
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if err := r.initializeInitialCommitWorktree(ctx); err != nil {
+		return "", err
+	}
+	if _, beforeErr := r.taskRunner.Test(ctx, r.initialWorktree, targets); beforeErr != nil {
+		return "", nil
+	}
+	return fmt.Sprintf("Tests that %s selected as affected by your changes fail, but passed at the base commit:\n\n%s", r.taskRunner.Kind, afterOut), nil
+}
@@ -0,0 +1,62 @@
+package codereview
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/claudetool/taskrunner"
+)
+
+func TestCheckRunnerTests(t *testing.T) {
+	// A fake bazel whose tests fail where the file "broken" exists.
+	bin := t.TempDir()
+	os.WriteFile(filepath.Join(bin, "bazel"), []byte(`#!/bin/sh
+case "$1" in
+query) echo //server:api_test ;;
+test) if [ -e broken ]; then echo "FAILED: //server:api_test"; exit 3; fi; echo "PASSED: //server:api_test" ;;
+esac
+`), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	dir, _ = filepath.EvalSymlinks(dir)
+	os.WriteFile(filepath.Join(dir, "MODULE.bazel"), nil, 0o644)
+	if err := initGitRepo(dir); err != nil {
+		t.Fatal(err)
+	}
+	base, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := func(file string) {
+		os.WriteFile(filepath.Join(dir, file), nil, 0o644)
+		for _, args := range [][]string{{"add", "."}, {"commit", "-m", "add " + file}} {
+			cmd := exec.Command("git", args...)
+			cmd.Dir = dir
+			cmd.Env = gitCommitEnv()
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("git %v: %v: %s", args, err, out)
+			}
+		}
+	}
+	ctx := context.Background()
+	r, err := NewCodeReviewer(ctx, dir, strings.TrimSpace(string(base)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetTaskRunner(taskrunner.Detect(dir))
+
+	commit("api.go")
+	if msg, err := r.checkRunnerTests(ctx, []string{filepath.Join(dir, "api.go")}); msg != "" || err != nil {
+		t.Errorf("passing tests: %q, %v", msg, err)
+	}
+	commit("broken")
+	msg, err := r.checkRunnerTests(ctx, []string{filepath.Join(dir, "broken")})
+	if err != nil || !strings.Contains(msg, "passed at the base commit") || !strings.Contains(msg, "$ bazel test //server:api_test\nFAILED") {
+		t.Errorf("regression: %q, %v", msg, err)
+	}
+}
@@ -0,0 +1,226 @@
+package codereview
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// A testCache holds the go test results of earlier reviews by package, so
+// that a review after a small fix re-runs only the packages the fix affects.
+//
+// Results at the sketch base don't change until the base moves. Results now
+// are keyed by a hash of what a package's tests build from and usually read:
+// the files in its directory and testdata, its embedded files, and the same
+// for every package outside GOROOT and the module cache that it imports,
+// transitively, along with their go.mod and go.sum files. Like go test's own
+// cache, it doesn't keep failed packages, which are re-run every time.
+type testCache struct {
+	mu     sync.Mutex
+	before map[string][]testJSON  // by package
+	after  map[string]cachedTests // by package
+	fixed  []string               // GOROOT and GOMODCACHE, once known
+}
+
+type cachedTests struct {
+	key    string
+	events []testJSON
+}
+
+// resetBefore forgets the results at the sketch base, after it moved.
+func (c *testCache) resetBefore() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.before = nil
+}
+
+// lookupBefore returns the cached results at the sketch base of pkgs, and
+// the packages that have none.
+func (c *testCache) lookupBefore(pkgs []string) (cached []testJSON, missing []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pkg := range pkgs {
+		events, ok := c.before[pkg]
+		if !ok {
+			missing = append(missing, pkg)
+			continue
+		}
+		cached = append(cached, events...)
+	}
+	return cached, missing
+}
+
+// lookupAfter returns the cached results of the packages whose key is
+// unchanged, and the packages to re-run.
+func (c *testCache) lookupAfter(keys map[string]string) (cached []testJSON, stale []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pkg := range slices.Sorted(maps.Keys(keys)) {
+		ct, ok := c.after[pkg]
+		if !ok || ct.key != keys[pkg] {
+			stale = append(stale, pkg)
+			continue
+		}
+		cached = append(cached, ct.events...)
+	}
+	return cached, stale
+}
+
+// storeBefore caches the results at the sketch base of the pkgs that ran.
+func (c *testCache) storeBefore(pkgs []string, events []testJSON) {
+	byPkg := eventsByPackage(events)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.before == nil {
+		c.before = make(map[string][]testJSON)
+	}
+	for _, pkg := range pkgs {
+		c.before[pkg] = byPkg[pkg]
+	}
+}
+
+// storeAfter caches the results of the pkgs that ran, unless they failed.
+func (c *testCache) storeAfter(keys map[string]string, pkgs []string, events []testJSON) {
+	byPkg := eventsByPackage(events)
+	statuses := collectTestStatuses(events)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.after == nil {
+		c.after = make(map[string]cachedTests)
+	}
+	for _, pkg := range pkgs {
+		switch res := statuses[pkg]; {
+		case res == nil, res.Status == testStatusFail, res.Status == testStatusBuildFail, res.Status == testStatusUnknown:
+			delete(c.after, pkg)
+		default:
+			c.after[pkg] = cachedTests{key: keys[pkg], events: byPkg[pkg]}
+		}
+	}
+}
+
+// eventsByPackage splits test events by the package tested. Build events,
+// which only carry output, are left out.
+func eventsByPackage(events []testJSON) map[string][]testJSON {
+	m := make(map[string][]testJSON)
+	for _, e := range events {
+		if e.Package != "" {
+			m[e.Package] = append(m[e.Package], e)
+		}
+	}
+	return m
+}
+
+// packageKeys hashes, for each of pkgs, what its tests depend on; see testCache.
+func (r *CodeReviewer) packageKeys(ctx context.Context, pkgs map[string]*packages.Package) map[string]string {
+	fixed := r.tests.fixedDirs(ctx, r.repoRoot)
+	dirs := make(map[string][]byte) // of each directory hashed
+	var visit func(h hash.Hash, p *packages.Package, seen map[string]bool)
+	visit = func(h hash.Hash, p *packages.Package, seen map[string]bool) {
+		if seen[p.ID] || p.Dir == "" || slices.ContainsFunc(fixed, func(dir string) bool { return within(p.Dir, dir) }) {
+			return
+		}
+		seen[p.ID] = true
+		sum, ok := dirs[p.Dir]
+		if !ok {
+			sum = r.hashDir(p.Dir)
+			dirs[p.Dir] = sum
+		}
+		fmt.Fprintf(h, "%s %x\n", p.ID, sum)
+		for _, f := range p.EmbedFiles {
+			hashFile(h, f)
+		}
+		for _, path := range slices.Sorted(maps.Keys(p.Imports)) {
+			visit(h, p.Imports[path], seen)
+		}
+	}
+	keys := make(map[string]string, len(pkgs))
+	for path, p := range pkgs {
+		h := sha256.New()
+		seen := make(map[string]bool)
+		visit(h, p, seen)
+		// External tests import packages of their own.
+		if xtest := pkgs[path+"_test"]; xtest != nil {
+			visit(h, xtest, seen)
+		}
+		keys[path] = hex.EncodeToString(h.Sum(nil))
+	}
+	return keys
+}
+
+// hashDir hashes the files directly in dir, its testdata, and the go.mod and
+// go.sum files of dir and its parents up to the repository root.
+func (r *CodeReviewer) hashDir(dir string) []byte {
+	h := sha256.New()
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			hashFile(h, filepath.Join(dir, e.Name()))
+		}
+	}
+	filepath.WalkDir(filepath.Join(dir, "testdata"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			hashFile(h, path)
+		}
+		return nil
+	})
+	for d := filepath.Dir(dir); within(d, r.repoRoot); d = filepath.Dir(d) {
+		hashFile(h, filepath.Join(d, "go.mod"))
+		hashFile(h, filepath.Join(d, "go.sum"))
+		if d == r.repoRoot || d == filepath.Dir(d) {
+			break
+		}
+	}
+	return h.Sum(nil)
+}
+
+// hashFile writes path and a hash of its contents to h; a missing file hashes as such.
+func hashFile(h hash.Hash, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(h, "%s: %v\n", path, err)
+		return
+	}
+	defer f.Close()
+	fh := sha256.New()
+	io.Copy(fh, f)
+	fmt.Fprintf(h, "%s %x\n", path, fh.Sum(nil))
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// fixedDirs returns GOROOT and GOMODCACHE, whose packages only change along
+// with go.mod and go.sum.
+func (c *testCache) fixedDirs(ctx context.Context, dir string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fixed == nil {
+		cmd := exec.CommandContext(ctx, "go", "env", "GOROOT", "GOMODCACHE")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			slog.DebugContext(ctx, "codereview: go env failed; standard library and module cache files will be hashed too", "err", err)
+		}
+		c.fixed = []string{}
+		for _, line := range strings.Fields(string(out)) {
+			c.fixed = append(c.fixed, filepath.Clean(line))
+		}
+	}
+	return c.fixed
+}
@@ -0,0 +1,89 @@
+package codereview
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageKeys(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/m\n\ngo 1.24\n")
+	write("a/a.go", "package a\n\nfunc A() {}\n")
+	write("a/testdata/in.txt", "one\n")
+	write("b/b.go", "package b\n\nimport \"example.com/m/a\"\n\nfunc B() { a.A() }\n")
+	write("c/c.go", "package c\n")
+
+	ctx := context.Background()
+	r := &CodeReviewer{repoRoot: dir}
+	keys := func() map[string]string {
+		pkgs, err := r.packagesForFiles(ctx, []string{filepath.Join(dir, "a/a.go")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.packageKeys(ctx, pkgs)
+	}
+	changed := func(before, after map[string]string) map[string]bool {
+		m := make(map[string]bool)
+		for pkg, key := range after {
+			if before[pkg] != key {
+				m[pkg] = true
+			}
+		}
+		return m
+	}
+	const a, b = "example.com/m/a", "example.com/m/b"
+
+	k0 := keys()
+	if len(k0) != 2 || k0[a] == "" || k0[b] == "" {
+		t.Fatalf("keys = %v, want a and b", k0)
+	}
+	write("c/c.go", "package c\n\nfunc C() {}\n")
+	if got := changed(k0, keys()); len(got) != 0 {
+		t.Errorf("changing an unrelated package changed the keys of %v", got)
+	}
+	write("b/b.go", "package b\n\nimport \"example.com/m/a\"\n\nfunc B() { a.A(); a.A() }\n")
+	k1 := keys()
+	if got := changed(k0, k1); len(got) != 1 || !got[b] {
+		t.Errorf("changing b changed the keys of %v", got)
+	}
+	write("a/testdata/in.txt", "two\n")
+	if got := changed(k1, keys()); len(got) != 2 {
+		t.Errorf("changing a's testdata changed the keys of %v, want a and b", got)
+	}
+}
+
+func TestTestCache(t *testing.T) {
+	var c testCache
+	keys := map[string]string{"p": "1", "q": "1"}
+	c.storeAfter(keys, []string{"p", "q"}, []testJSON{
+		{Package: "p", Action: "pass"},
+		{Package: "q", Action: "fail"},
+	})
+	cached, stale := c.lookupAfter(keys)
+	if len(cached) != 1 || len(stale) != 1 || stale[0] != "q" {
+		t.Errorf("after a pass and a failure, cached %v and stale %v", cached, stale)
+	}
+	if _, stale := c.lookupAfter(map[string]string{"p": "2"}); len(stale) != 1 {
+		t.Error("a changed key still hit the cache")
+	}
+
+	c.storeBefore([]string{"p", "r"}, []testJSON{{Package: "p", Action: "pass"}})
+	if _, missing := c.lookupBefore([]string{"p", "r"}); len(missing) != 0 {
+		t.Errorf("packages without results at the base were run again: %v", missing)
+	}
+	c.resetBefore()
+	if _, missing := c.lookupBefore([]string{"p"}); len(missing) != 1 {
+		t.Error("results at the base outlived a base move")
+	}
+}
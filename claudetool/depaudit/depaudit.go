@@ -0,0 +1,448 @@
+// Package depaudit runs dependency vulnerability audits (govulncheck, npm audit, pip-audit)
+// and reports what changed relative to the sketch base commit.
+package depaudit
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// Vulnerability is a single known vulnerability affecting a dependency, normalized across ecosystems.
+type Vulnerability struct {
+	Ecosystem    string `json:"ecosystem"` // "go", "npm", or "pip"
+	ID           string `json:"id"`        // e.g. GO-2024-1234, GHSA-xxxx-xxxx-xxxx, PYSEC-2023-1
+	Package      string `json:"package"`
+	Version      string `json:"version,omitempty"`
+	FixedVersion string `json:"fixed_version,omitempty"`
+	Severity     string `json:"severity,omitempty"`
+	Summary      string `json:"summary,omitempty"`
+}
+
+func (v Vulnerability) key() string {
+	return v.Ecosystem + "\x00" + v.ID + "\x00" + v.Package
+}
+
+// EcosystemResult records whether an ecosystem's audit ran.
+type EcosystemResult struct {
+	Ecosystem string `json:"ecosystem"`
+	Tool      string `json:"tool"`
+	Skipped   string `json:"skipped,omitempty"` // reason the audit did not run, e.g. tool not installed
+	Error     string `json:"error,omitempty"`
+	BaseError string `json:"base_error,omitempty"` // why the audit at the sketch base did not run or failed
+}
+
+// Report is the result of comparing audits at HEAD and at the sketch base.
+type Report struct {
+	RanAt      time.Time         `json:"ran_at"`
+	Commit     string            `json:"commit"`
+	Ecosystems []EcosystemResult `json:"ecosystems"`
+	Introduced []Vulnerability   `json:"introduced"` // present at HEAD but not at the base
+	Fixed      []Vulnerability   `json:"fixed"`      // present at the base but not at HEAD
+	Unchanged  int               `json:"unchanged"`  // present in both
+	// UnknownBaseline are present at HEAD in ecosystems that could not be
+	// audited at both ends, so whether they are new is unknown.
+	UnknownBaseline []Vulnerability `json:"unknown_baseline,omitempty"`
+}
+
+// An Auditor runs dependency audits for a repository and remembers the latest report.
+type Auditor struct {
+	repoRoot      string
+	sketchBaseRef string
+
+	mu   sync.Mutex // serializes audits and protects last
+	last *Report
+}
+
+// NewAuditor creates an Auditor for the repository at repoRoot.
+func NewAuditor(repoRoot, sketchBaseRef string) *Auditor {
+	return &Auditor{repoRoot: repoRoot, sketchBaseRef: sketchBaseRef}
+}
+
+// LastReport returns the most recent audit report, or nil if no audit has run.
+func (a *Auditor) LastReport() *Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+// Audit audits dependencies at HEAD and at the sketch base, and reports the difference.
+func (a *Auditor) Audit(ctx context.Context) (*Report, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	head, err := gitOutput(ctx, a.repoRoot, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	after, results := auditDir(ctx, a.repoRoot)
+
+	worktree, err := os.MkdirTemp("", "sketch-depaudit-worktree")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		cmd := exec.Command("git", "worktree", "remove", "--force", worktree)
+		cmd.Dir = a.repoRoot
+		if out, err := cmd.CombinedOutput(); err != nil {
+			slog.WarnContext(ctx, "depaudit: failed to remove worktree", "err", err, "out", string(out))
+		}
+		os.RemoveAll(worktree)
+	}()
+	if _, err := gitOutput(ctx, a.repoRoot, "worktree", "add", "--detach", worktree, a.sketchBaseRef); err != nil {
+		return nil, err
+	}
+	before, baseResults := auditDir(ctx, worktree)
+
+	// An ecosystem audited at only one end can't be diffed: what the other end
+	// failed to find would pass for introduced or fixed.
+	unaudited := make(map[string]bool)
+	for i, r := range results {
+		if r.Skipped != "" || r.Error != "" {
+			unaudited[r.Ecosystem] = true
+		}
+		for _, b := range baseResults {
+			if b.Ecosystem == r.Ecosystem && (b.Skipped != "" || b.Error != "") {
+				results[i].BaseError = cmp.Or(b.Error, b.Skipped)
+				unaudited[r.Ecosystem] = true
+			}
+		}
+	}
+	var unknown []Vulnerability
+	before = slices.DeleteFunc(before, func(v Vulnerability) bool { return unaudited[v.Ecosystem] })
+	after = slices.DeleteFunc(after, func(v Vulnerability) bool {
+		if unaudited[v.Ecosystem] {
+			unknown = append(unknown, v)
+			return true
+		}
+		return false
+	})
+
+	report := compare(before, after)
+	report.UnknownBaseline = unknown
+	report.RanAt = time.Now()
+	report.Commit = head
+	report.Ecosystems = results
+	a.last = report
+	return report, nil
+}
+
+// compare computes which vulnerabilities were introduced or fixed going from before to after.
+func compare(before, after []Vulnerability) *Report {
+	report := &Report{Introduced: []Vulnerability{}, Fixed: []Vulnerability{}}
+	beforeKeys := make(map[string]bool)
+	for _, v := range before {
+		beforeKeys[v.key()] = true
+	}
+	afterKeys := make(map[string]bool)
+	for _, v := range after {
+		afterKeys[v.key()] = true
+		if beforeKeys[v.key()] {
+			report.Unchanged++
+		} else {
+			report.Introduced = append(report.Introduced, v)
+		}
+	}
+	for _, v := range before {
+		if !afterKeys[v.key()] {
+			report.Fixed = append(report.Fixed, v)
+		}
+	}
+	return report
+}
+
+// An auditor knows how to detect and audit one ecosystem.
+type auditor struct {
+	ecosystem string
+	tool      string
+	marker    string // file (relative to the repo root) whose presence indicates the ecosystem
+	args      []string
+	parse     func([]byte) ([]Vulnerability, error)
+}
+
+var auditors = []auditor{
+	{ecosystem: "go", tool: "govulncheck", marker: "go.mod", args: []string{"-format", "json", "./..."}, parse: parseGovulncheck},
+	{ecosystem: "npm", tool: "npm", marker: "package-lock.json", args: []string{"audit", "--json"}, parse: parseNPMAudit},
+	{ecosystem: "pip", tool: "pip-audit", marker: "requirements.txt", args: []string{"-f", "json", "-r", "requirements.txt"}, parse: parsePipAudit},
+}
+
+// auditDir runs every applicable audit in dir.
+func auditDir(ctx context.Context, dir string) ([]Vulnerability, []EcosystemResult) {
+	var vulns []Vulnerability
+	var results []EcosystemResult
+	for _, au := range auditors {
+		if _, err := os.Stat(filepath.Join(dir, au.marker)); err != nil {
+			continue
+		}
+		result := EcosystemResult{Ecosystem: au.ecosystem, Tool: au.tool}
+		if _, err := exec.LookPath(au.tool); err != nil {
+			result.Skipped = au.tool + " is not installed"
+			results = append(results, result)
+			continue
+		}
+		cmd := exec.CommandContext(ctx, au.tool, au.args...)
+		cmd.Dir = dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		// All three tools exit non-zero when they find vulnerabilities, so judge success by the output.
+		out, _ := cmd.Output()
+		found, err := au.parse(out)
+		if err != nil {
+			result.Error = fmt.Sprintf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		vulns = append(vulns, found...)
+		results = append(results, result)
+	}
+	return vulns, results
+}
+
+// parseGovulncheck parses the JSON message stream from govulncheck -format json.
+func parseGovulncheck(out []byte) ([]Vulnerability, error) {
+	type osvEntry struct {
+		ID      string `json:"id"`
+		Summary string `json:"summary"`
+		Details string `json:"details"`
+	}
+	type message struct {
+		OSV     *osvEntry `json:"osv"`
+		Finding *struct {
+			OSV          string `json:"osv"`
+			FixedVersion string `json:"fixed_version"`
+			Trace        []struct {
+				Module  string `json:"module"`
+				Version string `json:"version"`
+			} `json:"trace"`
+		} `json:"finding"`
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, fmt.Errorf("govulncheck produced no output")
+	}
+	osvs := make(map[string]*osvEntry)
+	seen := make(map[string]bool)
+	var vulns []Vulnerability
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return vulns, fmt.Errorf("parsing govulncheck output: %w", err)
+		}
+		if m.OSV != nil {
+			osvs[m.OSV.ID] = m.OSV
+		}
+		if m.Finding == nil || len(m.Finding.Trace) == 0 {
+			continue
+		}
+		// govulncheck reports findings at module, package and symbol level; keep one per module.
+		v := Vulnerability{
+			Ecosystem:    "go",
+			ID:           m.Finding.OSV,
+			Package:      m.Finding.Trace[0].Module,
+			Version:      m.Finding.Trace[0].Version,
+			FixedVersion: m.Finding.FixedVersion,
+		}
+		if seen[v.key()] {
+			continue
+		}
+		seen[v.key()] = true
+		vulns = append(vulns, v)
+	}
+	for i := range vulns {
+		if e := osvs[vulns[i].ID]; e != nil {
+			vulns[i].Summary = cmp.Or(e.Summary, firstLine(e.Details))
+		}
+	}
+	return vulns, nil
+}
+
+// parseNPMAudit parses npm audit --json output (npm 7 and later).
+func parseNPMAudit(out []byte) ([]Vulnerability, error) {
+	var report struct {
+		Vulnerabilities map[string]struct {
+			Name     string            `json:"name"`
+			Severity string            `json:"severity"`
+			Range    string            `json:"range"`
+			Via      []json.RawMessage `json:"via"`
+		} `json:"vulnerabilities"`
+		Error *struct {
+			Summary string `json:"summary"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("parsing npm audit output: %w", err)
+	}
+	if report.Error != nil {
+		return nil, fmt.Errorf("npm audit: %s", report.Error.Summary)
+	}
+	var vulns []Vulnerability
+	for name, entry := range report.Vulnerabilities {
+		for _, raw := range entry.Via {
+			// "via" mixes advisory objects with names of other vulnerable packages
+			// (transitive paths); only the advisories are interesting here.
+			var adv struct {
+				Title    string `json:"title"`
+				URL      string `json:"url"`
+				Severity string `json:"severity"`
+				Range    string `json:"range"`
+			}
+			if json.Unmarshal(raw, &adv) != nil || adv.URL == "" {
+				continue
+			}
+			vulns = append(vulns, Vulnerability{
+				Ecosystem: "npm",
+				ID:        path.Base(adv.URL),
+				Package:   cmp.Or(entry.Name, name),
+				Version:   cmp.Or(adv.Range, entry.Range),
+				Severity:  cmp.Or(adv.Severity, entry.Severity),
+				Summary:   adv.Title,
+			})
+		}
+	}
+	sortVulns(vulns)
+	return vulns, nil
+}
+
+// parsePipAudit parses pip-audit -f json output, in both its old (list) and new (object) forms.
+func parsePipAudit(out []byte) ([]Vulnerability, error) {
+	type dependency struct {
+		Name  string `json:"name"`
+		Ver   string `json:"version"`
+		Vulns []struct {
+			ID          string   `json:"id"`
+			FixVersions []string `json:"fix_versions"`
+			Description string   `json:"description"`
+		} `json:"vulns"`
+	}
+	var deps []dependency
+	trimmed := bytes.TrimSpace(out)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &deps); err != nil {
+			return nil, fmt.Errorf("parsing pip-audit output: %w", err)
+		}
+	} else {
+		var report struct {
+			Dependencies []dependency `json:"dependencies"`
+		}
+		if err := json.Unmarshal(trimmed, &report); err != nil {
+			return nil, fmt.Errorf("parsing pip-audit output: %w", err)
+		}
+		deps = report.Dependencies
+	}
+	var vulns []Vulnerability
+	for _, dep := range deps {
+		for _, pv := range dep.Vulns {
+			v := Vulnerability{
+				Ecosystem: "pip",
+				ID:        pv.ID,
+				Package:   dep.Name,
+				Version:   dep.Ver,
+				Summary:   firstLine(pv.Description),
+			}
+			if len(pv.FixVersions) > 0 {
+				v.FixedVersion = pv.FixVersions[0]
+			}
+			vulns = append(vulns, v)
+		}
+	}
+	return vulns, nil
+}
+
+// Tool returns an llm.Tool that runs the audit and reports changes to the model.
+func (a *Auditor) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name: "dependency_audit",
+		Description: `Audit third-party dependencies for known vulnerabilities (govulncheck, npm audit, pip-audit).
+Reports only vulnerabilities introduced or fixed relative to the starting commit. Use after adding or upgrading dependencies.`,
+		InputSchema: llm.EmptySchema(),
+		Run: func(ctx context.Context, m json.RawMessage) llm.ToolOut {
+			report, err := a.Audit(ctx)
+			if err != nil {
+				return llm.ErrorfToolOut("dependency audit failed: %w", err)
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent(report.String())}
+		},
+	}
+}
+
+// String renders the report for the model.
+func (r *Report) String() string {
+	var sb strings.Builder
+	for _, e := range r.Ecosystems {
+		switch {
+		case e.Skipped != "":
+			fmt.Fprintf(&sb, "%s: skipped (%s)\n", e.Ecosystem, e.Skipped)
+		case e.Error != "":
+			fmt.Fprintf(&sb, "%s: error running %s: %s\n", e.Ecosystem, e.Tool, e.Error)
+		case e.BaseError != "":
+			fmt.Fprintf(&sb, "%s: audited with %s, but not at the starting commit: %s\n", e.Ecosystem, e.Tool, e.BaseError)
+		default:
+			fmt.Fprintf(&sb, "%s: audited with %s\n", e.Ecosystem, e.Tool)
+		}
+	}
+	if len(r.Ecosystems) == 0 {
+		sb.WriteString("No supported dependency manifests found (go.mod, package-lock.json, requirements.txt).\n")
+		return sb.String()
+	}
+	writeList := func(title string, vulns []Vulnerability) {
+		if len(vulns) == 0 {
+			return
+		}
+		fmt.Fprintf(&sb, "\n%s:\n", title)
+		for _, v := range vulns {
+			fmt.Fprintf(&sb, "- %s %s@%s: %s", v.ID, v.Package, v.Version, v.Summary)
+			if v.FixedVersion != "" {
+				fmt.Fprintf(&sb, " (fixed in %s)", v.FixedVersion)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	writeList("Introduced vulnerabilities", r.Introduced)
+	writeList("Fixed vulnerabilities", r.Fixed)
+	writeList("Vulnerabilities that may or may not be new (no audit of the starting commit to compare with)", r.UnknownBaseline)
+	if len(r.Introduced) == 0 && len(r.Fixed) == 0 && len(r.UnknownBaseline) == 0 {
+		sb.WriteString("\nNo vulnerabilities introduced or fixed relative to the starting commit.\n")
+	}
+	if r.Unchanged > 0 {
+		fmt.Fprintf(&sb, "(%d pre-existing vulnerabilities unchanged)\n", r.Unchanged)
+	}
+	return sb.String()
+}
+
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func sortVulns(vulns []Vulnerability) {
+	slices.SortFunc(vulns, func(a, b Vulnerability) int { return strings.Compare(a.key(), b.key()) })
+}
+
+func firstLine(s string) string {
+	sc := bufio.NewScanner(strings.NewReader(strings.TrimSpace(s)))
+	if sc.Scan() {
+		return sc.Text()
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package depaudit
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseGovulncheck(t *testing.T) {
+	out := `{"config":{"protocol_version":"v1.0.0"}}
+{"osv":{"id":"GO-2024-0001","summary":"Bad thing in example.com/mod","details":"Long details"}}
+{"finding":{"osv":"GO-2024-0001","fixed_version":"v1.2.4","trace":[{"module":"example.com/mod","version":"v1.2.3"}]}}
+{"finding":{"osv":"GO-2024-0001","fixed_version":"v1.2.4","trace":[{"module":"example.com/mod","version":"v1.2.3","package":"example.com/mod/pkg"}]}}
+`
+	vulns, err := parseGovulncheck([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vulns) != 1 {
+		t.Fatalf("got %d vulnerabilities, want 1: %+v", len(vulns), vulns)
+	}
+	want := Vulnerability{Ecosystem: "go", ID: "GO-2024-0001", Package: "example.com/mod", Version: "v1.2.3", FixedVersion: "v1.2.4", Summary: "Bad thing in example.com/mod"}
+	if vulns[0] != want {
+		t.Errorf("got %+v, want %+v", vulns[0], want)
+	}
+
+	if _, err := parseGovulncheck(nil); err == nil {
+		t.Error("expected error for empty output")
+	}
+}
+
+func TestParseNPMAudit(t *testing.T) {
+	out := `{
+  "auditReportVersion": 2,
+  "vulnerabilities": {
+    "lodash": {
+      "name": "lodash", "severity": "high", "range": "<4.17.21",
+      "via": [{"source": 1, "title": "Prototype Pollution", "url": "https://github.com/advisories/GHSA-aaaa-bbbb-cccc", "severity": "high", "range": "<4.17.21"}]
+    },
+    "uses-lodash": {
+      "name": "uses-lodash", "severity": "high", "range": "*",
+      "via": ["lodash"]
+    }
+  }
+}`
+	vulns, err := parseNPMAudit([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vulns) != 1 {
+		t.Fatalf("got %d vulnerabilities, want 1: %+v", len(vulns), vulns)
+	}
+	if vulns[0].ID != "GHSA-aaaa-bbbb-cccc" || vulns[0].Package != "lodash" || vulns[0].Severity != "high" {
+		t.Errorf("unexpected vulnerability: %+v", vulns[0])
+	}
+
+	if _, err := parseNPMAudit([]byte(`{"error": {"summary": "no lockfile"}}`)); err == nil || !strings.Contains(err.Error(), "no lockfile") {
+		t.Errorf("expected npm error to be surfaced, got %v", err)
+	}
+}
+
+func TestParsePipAudit(t *testing.T) {
+	dep := `{"name": "requests", "version": "2.0.0", "vulns": [{"id": "PYSEC-2023-74", "fix_versions": ["2.31.0"], "description": "Leaks headers.\nMore."}]}`
+	for _, out := range []string{`{"dependencies": [` + dep + `], "fixes": []}`, `[` + dep + `]`} {
+		vulns, err := parsePipAudit([]byte(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Vulnerability{Ecosystem: "pip", ID: "PYSEC-2023-74", Package: "requests", Version: "2.0.0", FixedVersion: "2.31.0", Summary: "Leaks headers."}
+		if len(vulns) != 1 || vulns[0] != want {
+			t.Errorf("got %+v, want [%+v]", vulns, want)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	old := Vulnerability{Ecosystem: "go", ID: "GO-1", Package: "a"}
+	kept := Vulnerability{Ecosystem: "npm", ID: "GHSA-1", Package: "b"}
+	added := Vulnerability{Ecosystem: "pip", ID: "PYSEC-1", Package: "c"}
+	r := compare([]Vulnerability{old, kept}, []Vulnerability{kept, added})
+	if len(r.Introduced) != 1 || r.Introduced[0] != added {
+		t.Errorf("Introduced = %+v, want [%+v]", r.Introduced, added)
+	}
+	if len(r.Fixed) != 1 || r.Fixed[0] != old {
+		t.Errorf("Fixed = %+v, want [%+v]", r.Fixed, old)
+	}
+	if r.Unchanged != 1 {
+		t.Errorf("Unchanged = %d, want 1", r.Unchanged)
+	}
+	s := r.String()
+	if !strings.Contains(s, "No supported dependency manifests") {
+		t.Errorf("expected no-manifest message without ecosystems, got: %s", s)
+	}
+	r.Ecosystems = []EcosystemResult{{Ecosystem: "go", Tool: "govulncheck"}}
+	s = r.String()
+	if !strings.Contains(s, "Introduced vulnerabilities:\n- PYSEC-1 c@") || !strings.Contains(s, "Fixed vulnerabilities:\n- GO-1 a@") {
+		t.Errorf("unexpected report text: %s", s)
+	}
+}
+
+func TestAuditUnknownBaseline(t *testing.T) {
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	// A govulncheck that fails where the base commit has broken.txt and finds one vulnerability elsewhere.
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ -e broken.txt ] && exit 1\n" +
+		`echo '{"finding":{"osv":"GO-2024-0001","trace":[{"module":"example.com/mod","version":"v1.0.0"}]}}'` + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "govulncheck"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	git("init", "-q")
+	os.WriteFile(filepath.Join(repo, "go.mod"), []byte("module example.com/m\n"), 0o644)
+	os.WriteFile(filepath.Join(repo, "broken.txt"), nil, 0o644)
+	git("add", ".")
+	git("commit", "-q", "-m", "base")
+	base := git("rev-parse", "HEAD")
+	git("rm", "-q", "broken.txt")
+	git("commit", "-q", "-m", "head")
+
+	r, err := NewAuditor(repo, base).Audit(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Introduced) != 0 || len(r.UnknownBaseline) != 1 || r.UnknownBaseline[0].ID != "GO-2024-0001" {
+		t.Errorf("introduced %+v, unknown baseline %+v", r.Introduced, r.UnknownBaseline)
+	}
+	if len(r.Ecosystems) != 1 || r.Ecosystems[0].BaseError == "" || r.Ecosystems[0].Error != "" {
+		t.Errorf("ecosystems = %+v", r.Ecosystems)
+	}
+	if s := r.String(); !strings.Contains(s, "but not at the starting commit") || strings.Contains(s, "Introduced") {
+		t.Errorf("report text: %s", s)
+	}
+}
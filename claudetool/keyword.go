@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"sketch.dev/llm"
 	"sketch.dev/llm/conversation"
+	"sketch.dev/sketchignore"
 )
 
 // The Keyword tool provides keyword search.
@@ -129,6 +132,7 @@ func keywordRun(ctx context.Context, m json.RawMessage) llm.ToolOut {
 
 	info := conversation.ToolCallInfoFromContext(ctx)
 	convo := info.Convo.SubConvo()
+	convo.Purpose = "keyword search"
 	convo.SystemPrompt = strings.TrimSpace(keywordSystemPrompt)
 	convo.PromptCaching = false
 
@@ -164,6 +168,9 @@ func keywordRun(ctx context.Context, m json.RawMessage) llm.ToolOut {
 
 func ripgrep(ctx context.Context, wd string, terms []string) (string, error) {
 	args := []string{"-C", "10", "-i", "--line-number", "--with-filename"}
+	if _, err := os.Stat(filepath.Join(wd, sketchignore.FileName)); err == nil {
+		args = append(args, "--ignore-file", sketchignore.FileName)
+	}
 	for _, term := range terms {
 		args = append(args, "-e", term)
 	}
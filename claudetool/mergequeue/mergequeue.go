@@ -0,0 +1,365 @@
+// Package mergequeue lets the agent land a pushed branch through a merge queue
+// (GitHub's merge queue or a custom HTTP service) and follow it until it merges or is rejected.
+package mergequeue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// State is the position of an entry in a merge queue.
+type State string
+
+const (
+	StateQueued  State = "queued"  // waiting in the queue or being tested
+	StateMerged  State = "merged"  // landed on the target branch
+	StateFailed  State = "failed"  // rejected by the queue, e.g. because checks failed
+	StateRemoved State = "removed" // taken out of the queue without merging, e.g. closed by a human
+)
+
+// Done reports whether the state is terminal.
+func (s State) Done() bool {
+	return s == StateMerged || s == StateFailed || s == StateRemoved
+}
+
+// Entry is a branch submitted to a merge queue.
+type Entry struct {
+	ID        string    `json:"id"` // queue-specific identifier, e.g. a pull request number
+	Branch    string    `json:"branch"`
+	Commit    string    `json:"commit,omitempty"`
+	State     State     `json:"state"`
+	URL       string    `json:"url,omitempty"`
+	Detail    string    `json:"detail,omitempty"` // human-readable reason for the state, if any
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// String renders the entry for the model.
+func (e Entry) String() string {
+	s := fmt.Sprintf("%s (%s): %s", e.Branch, e.ID, e.State)
+	if e.Detail != "" {
+		s += " - " + e.Detail
+	}
+	if e.URL != "" {
+		s += " " + e.URL
+	}
+	return s
+}
+
+// A Queue is a merge queue backend.
+type Queue interface {
+	// Enqueue submits branch, expected to be at commit if commit is non-empty.
+	Enqueue(ctx context.Context, branch, commit string) (Entry, error)
+	// Status returns the current state of a previously enqueued entry.
+	Status(ctx context.Context, e Entry) (Entry, error)
+}
+
+// Parse returns the queue described by spec: "github" uses the gh CLI against
+// the repository in repoDir; an http(s) URL uses a custom queue service (see HTTPQueue).
+// An empty spec returns a nil Queue, meaning merge queue integration is disabled.
+func Parse(spec, repoDir string) (Queue, error) {
+	switch {
+	case spec == "":
+		return nil, nil
+	case spec == "github":
+		return &GitHubQueue{Dir: repoDir}, nil
+	case strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://"):
+		u, err := url.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid merge queue URL %q: %w", spec, err)
+		}
+		return &HTTPQueue{BaseURL: strings.TrimSuffix(u.String(), "/")}, nil
+	default:
+		return nil, fmt.Errorf("unknown merge queue %q: want \"github\" or an http(s) URL", spec)
+	}
+}
+
+// GitHubQueue enqueues pull requests with "gh pr merge --auto", which adds
+// them to the repository's merge queue when one is configured, or merges
+// them once required checks pass otherwise.
+type GitHubQueue struct {
+	Dir string
+}
+
+type ghPullRequest struct {
+	Number           int    `json:"number"`
+	URL              string `json:"url"`
+	State            string `json:"state"` // OPEN, CLOSED, MERGED
+	HeadRefOid       string `json:"headRefOid"`
+	MergeStateStatus string `json:"mergeStateStatus"`
+	AutoMergeRequest *struct {
+		MergeMethod string `json:"mergeMethod"`
+	} `json:"autoMergeRequest"`
+}
+
+func (q *GitHubQueue) Enqueue(ctx context.Context, branch, commit string) (Entry, error) {
+	args := []string{"pr", "merge", branch, "--auto"}
+	if commit != "" {
+		args = append(args, "--match-head-commit", commit)
+	}
+	if _, err := q.gh(ctx, args...); err != nil {
+		return Entry{}, err
+	}
+	return q.Status(ctx, Entry{ID: branch, Branch: branch, Commit: commit})
+}
+
+func (q *GitHubQueue) Status(ctx context.Context, e Entry) (Entry, error) {
+	out, err := q.gh(ctx, "pr", "view", e.ID, "--json", "number,url,state,headRefOid,mergeStateStatus,autoMergeRequest")
+	if err != nil {
+		return e, err
+	}
+	var pr ghPullRequest
+	if err := json.Unmarshal(out, &pr); err != nil {
+		return e, fmt.Errorf("parsing gh pr view output: %w", err)
+	}
+	e.ID = fmt.Sprint(pr.Number)
+	e.URL = pr.URL
+	e.Commit = pr.HeadRefOid
+	e.Detail = ""
+	switch {
+	case pr.State == "MERGED":
+		e.State = StateMerged
+	case pr.State == "CLOSED":
+		e.State = StateRemoved
+		e.Detail = "pull request was closed"
+	case pr.AutoMergeRequest == nil:
+		// GitHub drops the auto-merge request when the queue rejects the pull request.
+		e.State = StateFailed
+		e.Detail = "removed from the merge queue (merge state " + strings.ToLower(pr.MergeStateStatus) + ")"
+	default:
+		e.State = StateQueued
+		e.Detail = "merge state " + strings.ToLower(pr.MergeStateStatus)
+	}
+	e.UpdatedAt = time.Now()
+	return e, nil
+}
+
+func (q *GitHubQueue) gh(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "gh", args...)
+	cmd.Dir = q.Dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gh %s: %w: %s", strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// HTTPQueue talks to a custom merge queue service. Enqueue POSTs
+// {"branch": ..., "commit": ...} to BaseURL/enqueue, and Status GETs
+// BaseURL/entries/{id}; both respond with an Entry as JSON.
+type HTTPQueue struct {
+	BaseURL string
+	Client  *http.Client // defaults to http.DefaultClient
+}
+
+func (q *HTTPQueue) Enqueue(ctx context.Context, branch, commit string) (Entry, error) {
+	body, err := json.Marshal(map[string]string{"branch": branch, "commit": commit})
+	if err != nil {
+		return Entry{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.BaseURL+"/enqueue", bytes.NewReader(body))
+	if err != nil {
+		return Entry{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return q.do(req)
+}
+
+func (q *HTTPQueue) Status(ctx context.Context, e Entry) (Entry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, q.BaseURL+"/entries/"+url.PathEscape(e.ID), nil)
+	if err != nil {
+		return e, err
+	}
+	return q.do(req)
+}
+
+func (q *HTTPQueue) do(req *http.Request) (Entry, error) {
+	client := q.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return Entry{}, fmt.Errorf("merge queue %s %s: %s: %s", req.Method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+	}
+	var e Entry
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return Entry{}, fmt.Errorf("decoding merge queue response: %w", err)
+	}
+	if e.UpdatedAt.IsZero() {
+		e.UpdatedAt = time.Now()
+	}
+	return e, nil
+}
+
+// A Tracker submits branches to a Queue and polls them until they reach a terminal state.
+type Tracker struct {
+	queue    Queue
+	branch   func() string              // the agent's branch, used when none is given
+	commit   func(branch string) string // resolves the commit expected at the head of a branch
+	onChange func(Entry)
+
+	// PollInterval is how often Run checks queued entries.
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// NewTracker returns a Tracker for q. branch returns the branch to enqueue
+// by default, and commit resolves a branch to its expected head commit (or "").
+// onChange is called whenever an entry's state changes after it was enqueued.
+func NewTracker(q Queue, branch func() string, commit func(branch string) string, onChange func(Entry)) *Tracker {
+	return &Tracker{
+		queue:        q,
+		branch:       branch,
+		commit:       commit,
+		onChange:     onChange,
+		PollInterval: 30 * time.Second,
+	}
+}
+
+// Enqueue submits branch, or the default branch if branch is empty.
+func (t *Tracker) Enqueue(ctx context.Context, branch string) (Entry, error) {
+	if branch == "" {
+		branch = t.branch()
+	}
+	if branch == "" {
+		return Entry{}, fmt.Errorf("no branch to enqueue; push a branch first")
+	}
+	e, err := t.queue.Enqueue(ctx, branch, t.commit(branch))
+	if err != nil {
+		return Entry{}, err
+	}
+	if e.Branch == "" {
+		e.Branch = branch
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.entries {
+		if t.entries[i].ID == e.ID {
+			t.entries[i] = e
+			return e, nil
+		}
+	}
+	t.entries = append(t.entries, e)
+	return e, nil
+}
+
+// Entries returns the entries enqueued so far, oldest first.
+func (t *Tracker) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Entry(nil), t.entries...)
+}
+
+// Refresh polls the queue for every entry that isn't done yet and reports state changes.
+func (t *Tracker) Refresh(ctx context.Context) {
+	for _, e := range t.Entries() {
+		if e.State.Done() {
+			continue
+		}
+		updated, err := t.queue.Status(ctx, e)
+		if err != nil {
+			slog.WarnContext(ctx, "merge queue status check failed", "id", e.ID, "error", err)
+			continue
+		}
+		t.mu.Lock()
+		for i := range t.entries {
+			if t.entries[i].ID == e.ID {
+				t.entries[i] = updated
+			}
+		}
+		t.mu.Unlock()
+		if updated.State != e.State && t.onChange != nil {
+			t.onChange(updated)
+		}
+	}
+}
+
+// Run polls queued entries every PollInterval until ctx is done.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Refresh(ctx)
+		}
+	}
+}
+
+// Tool returns the merge_queue tool.
+func (t *Tracker) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name: "merge_queue",
+		Description: `Land a pushed branch through the repository's merge queue, or check on branches already enqueued.
+Use "enqueue" only when the user has asked you to land or merge the change and the branch has been pushed (with a pull request, for GitHub).
+You will be notified in the conversation when an enqueued branch merges or is rejected; no need to poll.`,
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "required": ["action"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["enqueue", "status"],
+      "description": "enqueue submits a branch; status refreshes and lists enqueued branches"
+    },
+    "branch": {
+      "type": "string",
+      "description": "Branch to enqueue; defaults to the branch sketch pushes your commits to"
+    }
+  }
+}`),
+		Run: func(ctx context.Context, m json.RawMessage) llm.ToolOut {
+			var input struct {
+				Action string `json:"action"`
+				Branch string `json:"branch"`
+			}
+			if err := json.Unmarshal(m, &input); err != nil {
+				return llm.ErrorfToolOut("invalid input: %w", err)
+			}
+			switch input.Action {
+			case "enqueue":
+				e, err := t.Enqueue(ctx, input.Branch)
+				if err != nil {
+					return llm.ErrorfToolOut("enqueue failed: %w", err)
+				}
+				return llm.ToolOut{LLMContent: llm.TextContent("Enqueued " + e.String())}
+			case "status":
+				t.Refresh(ctx)
+				entries := t.Entries()
+				if len(entries) == 0 {
+					return llm.ToolOut{LLMContent: llm.TextContent("No branches have been enqueued in this session.")}
+				}
+				var sb strings.Builder
+				for _, e := range entries {
+					sb.WriteString(e.String() + "\n")
+				}
+				return llm.ToolOut{LLMContent: llm.TextContent(sb.String())}
+			default:
+				return llm.ErrorfToolOut("unknown action %q: want enqueue or status", input.Action)
+			}
+		},
+	}
+}
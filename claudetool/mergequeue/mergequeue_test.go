@@ -0,0 +1,117 @@
+package mergequeue
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Queue
+		wantErr bool
+	}{
+		{spec: ""},
+		{spec: "github", want: &GitHubQueue{Dir: "/repo"}},
+		{spec: "https://mq.example.com/api/", want: &HTTPQueue{BaseURL: "https://mq.example.com/api"}},
+		{spec: "gitlab", wantErr: true},
+	}
+	for _, tt := range tests {
+		q, err := Parse(tt.spec, "/repo")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		got, _ := json.Marshal(q)
+		want, _ := json.Marshal(tt.want)
+		if string(got) != string(want) {
+			t.Errorf("Parse(%q) = %s, want %s", tt.spec, got, want)
+		}
+	}
+}
+
+// fakeService is a minimal custom merge queue: entries merge after a configurable number of status checks.
+type fakeService struct {
+	mu       sync.Mutex
+	checks   int
+	enqueued map[string]string // branch -> commit
+}
+
+func (f *fakeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := Entry{ID: "42", Branch: "sketch/feature", State: StateQueued}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/enqueue":
+		var req struct{ Branch, Commit string }
+		json.NewDecoder(r.Body).Decode(&req)
+		f.enqueued[req.Branch] = req.Commit
+		e.Commit = req.Commit
+	case r.Method == http.MethodGet && r.URL.Path == "/entries/42":
+		f.checks++
+		if f.checks >= 2 {
+			e.State = StateMerged
+		}
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(e)
+}
+
+func TestTrackerHTTPQueue(t *testing.T) {
+	svc := &fakeService{enqueued: map[string]string{}}
+	ts := httptest.NewServer(svc)
+	defer ts.Close()
+
+	q, err := Parse(ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []Entry
+	tr := NewTracker(q,
+		func() string { return "sketch/feature" },
+		func(branch string) string { return "abc123" },
+		func(e Entry) { changes = append(changes, e) },
+	)
+	ctx := context.Background()
+
+	e, err := tr.Enqueue(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.State != StateQueued || svc.enqueued["sketch/feature"] != "abc123" {
+		t.Fatalf("enqueue: got %+v, service saw %v", e, svc.enqueued)
+	}
+
+	tr.Refresh(ctx) // still queued
+	if len(changes) != 0 {
+		t.Fatalf("unexpected change reported: %+v", changes)
+	}
+	tr.Refresh(ctx) // merged
+	tr.Refresh(ctx) // done entries aren't polled again
+	if len(changes) != 1 || changes[0].State != StateMerged {
+		t.Fatalf("changes = %+v, want a single merged entry", changes)
+	}
+	if svc.checks != 2 {
+		t.Errorf("status checked %d times, want 2", svc.checks)
+	}
+	if entries := tr.Entries(); len(entries) != 1 || entries[0].State != StateMerged {
+		t.Errorf("Entries() = %+v", entries)
+	}
+}
+
+func TestHTTPQueueError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "branch is not pushed", http.StatusConflict)
+	}))
+	defer ts.Close()
+	q := &HTTPQueue{BaseURL: ts.URL}
+	if _, err := q.Enqueue(context.Background(), "b", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -6,15 +6,23 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/sync/errgroup"
+	"sketch.dev/sketchignore"
 )
 
 // Codebase contains metadata about the codebase.
@@ -35,101 +43,374 @@ type Codebase struct {
 	// InjectFileContents maps paths to file contents for critical inject files
 	// to avoid requiring an extra file read during template rendering
 	InjectFileContents map[string]string
+	// Directories summarizes each top-level directory, or each scope entry
+	Directories []DirectorySummary
+	// OmittedFiles counts build, documentation, and guidance files left out of those lists
+	OmittedFiles int
+	// Partial is set when some files were not examined, due to sampling or the time budget
+	Partial bool
+	// SketchIgnore is set when the repository's .sketchignore file left paths out of the analysis
+	SketchIgnore bool
 }
 
-// AnalyzeCodebase walks the codebase and analyzes the paths it finds.
-func AnalyzeCodebase(ctx context.Context, repoPath string) (*Codebase, error) {
-	// TODO: do a filesystem walk instead?
-	// There's a balance: git ls-files skips node_modules etc,
-	// but some guidance files might be locally .gitignored.
-	cmd := exec.Command("git", "ls-files", "-z")
-	cmd.Dir = repoPath
-
-	r, w := io.Pipe() // stream and scan rather than buffer
-	cmd.Stdout = w
+// Large reports whether the codebase is big enough that its per-directory
+// summary is worth showing.
+func (c *Codebase) Large() bool {
+	return c.Partial || c.TotalFiles > largeRepoFiles
+}
 
-	err := cmd.Start()
-	if err != nil {
-		return nil, err
-	}
+// Defaults for Options fields left zero.
+const (
+	DefaultTimeBudget     = 15 * time.Second
+	DefaultMaxFilesPerDir = 50000
+	DefaultMaxListedFiles = 50
+)
 
-	extCounts := make(map[string]int)
-	var buildFiles []string
-	var documentationFiles []string
-	var guidanceFiles []string
-	var injectFiles []string
-	injectFileContents := make(map[string]string)
-	var totalFiles int
+// largeRepoFiles is the file count above which a codebase is considered large
+// enough for per-directory summaries to be worth including in the prompt.
+const largeRepoFiles = 10000
 
-	eg, _ := errgroup.WithContext(ctx)
+// Options controls how much work AnalyzeCodebase does. The zero value analyzes
+// the whole repository with the default limits and no caching.
+type Options struct {
+	// Scope restricts the analysis to these repo-relative directories.
+	// Root-level files are always analyzed, so root guidance files are still found.
+	Scope []string
+	// TimeBudget bounds the analysis. Directories not reached in time are
+	// reported as skipped rather than failing the analysis.
+	TimeBudget time.Duration
+	// MaxFilesPerDir is the number of files examined in each top-level
+	// directory; larger directories are sampled.
+	MaxFilesPerDir int
+	// MaxListedFiles caps each of the build, documentation, and guidance file
+	// lists, keeping the shallowest files, so the prompt stays a manageable size.
+	MaxListedFiles int
+	// CacheDir, if set, holds analysis results keyed by the HEAD commit.
+	CacheDir string
+}
 
-	eg.Go(func() error {
-		defer r.Close()
+func (o Options) withDefaults() Options {
+	o.TimeBudget = cmp.Or(o.TimeBudget, DefaultTimeBudget)
+	o.MaxFilesPerDir = cmp.Or(o.MaxFilesPerDir, DefaultMaxFilesPerDir)
+	o.MaxListedFiles = cmp.Or(o.MaxListedFiles, DefaultMaxListedFiles)
+	return o
+}
 
-		scanner := bufio.NewScanner(r)
-		scanner.Split(scanZero)
-		for scanner.Scan() {
-			file := scanner.Text()
-			file = strings.TrimSpace(file)
-			if file == "" {
-				continue
-			}
-			totalFiles++
-			ext := strings.ToLower(filepath.Ext(file))
-			ext = cmp.Or(ext, "<no-extension>")
-			extCounts[ext]++
-
-			fileCategory := categorizeFile(file)
-			// fmt.Println(file, "->", fileCategory)
-			switch fileCategory {
-			case "build":
-				buildFiles = append(buildFiles, file)
-			case "documentation":
-				documentationFiles = append(documentationFiles, file)
-			case "guidance":
-				guidanceFiles = append(guidanceFiles, file)
-			case "inject":
-				injectFiles = append(injectFiles, file)
-			}
+// ParseScope parses the -codebase-analysis flag: "" or "full" analyzes the whole
+// repository, "off" skips analysis, and anything else is a comma-separated list
+// of directories to restrict the analysis to.
+func ParseScope(spec string) (scope []string, skip bool, err error) {
+	switch strings.TrimSpace(spec) {
+	case "", "full":
+		return nil, false, nil
+	case "off":
+		return nil, true, nil
+	}
+	for dir := range strings.SplitSeq(spec, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
 		}
-		return scanner.Err()
-	})
+		dir = path.Clean(filepath.ToSlash(dir))
+		if path.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			return nil, false, fmt.Errorf("codebase analysis scope %q must be a directory inside the repository", dir)
+		}
+		scope = append(scope, dir)
+	}
+	return scope, false, nil
+}
 
-	// Wait for the command to complete
-	eg.Go(func() error {
-		err := cmd.Wait()
-		if err != nil {
-			w.CloseWithError(err)
-		} else {
-			w.Close()
+// DirectorySummary describes one top-level directory (or scope entry) of a codebase.
+type DirectorySummary struct {
+	Path            string
+	Files           int
+	ExtensionCounts map[string]int
+	// Sampled is set when only the first Files files in the directory were examined,
+	// because it is larger than Options.MaxFilesPerDir or the time budget ran out.
+	Sampled bool
+	// Skipped is set when the time budget ran out before the directory was examined.
+	Skipped bool
+}
+
+func (d DirectorySummary) String() string {
+	switch {
+	case d.Skipped:
+		return d.Path + "/: not analyzed (time budget exceeded)"
+	case d.Sampled:
+		return fmt.Sprintf("%s/: %d+ files (sampled); %s", d.Path, d.Files, strings.Join(topExtensions(d.ExtensionCounts, d.Files, 3), ", "))
+	}
+	return fmt.Sprintf("%s/: %d files; %s", d.Path, d.Files, strings.Join(topExtensions(d.ExtensionCounts, d.Files, 3), ", "))
+}
+
+// AnalyzeCodebase analyzes the paths git tracks in the repository at repoPath.
+// Each top-level directory is scanned concurrently, within opts.TimeBudget.
+func AnalyzeCodebase(ctx context.Context, repoPath string, opts Options) (*Codebase, error) {
+	opts = opts.withDefaults()
+
+	head := gitOutput(ctx, repoPath, "rev-parse", "--verify", "-q", "HEAD")
+	ignore, err := sketchignore.Load(repoPath)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read .sketchignore", "error", err)
+	}
+	cachePath := opts.cachePath(head, ignore)
+	if cachePath != "" {
+		if codebase, err := loadCachedCodebase(cachePath); err == nil {
+			return codebase, nil
 		}
-		return err
-	})
+	}
 
+	units := analysisUnits(ctx, repoPath, head, opts.Scope, ignore)
+	budgetCtx, cancel := context.WithTimeout(ctx, opts.TimeBudget)
+	defer cancel()
+	results := make([]unitResult, len(units))
+	eg, egCtx := errgroup.WithContext(budgetCtx)
+	eg.SetLimit(runtime.GOMAXPROCS(0))
+	for i, u := range units {
+		eg.Go(func() error {
+			res, err := scanUnit(egCtx, repoPath, u, opts.MaxFilesPerDir, ignore)
+			if err != nil && budgetCtx.Err() != nil && ctx.Err() == nil {
+				// Out of time: report whatever we have, rather than failing.
+				err = nil
+			}
+			results[i] = res
+			return err
+		})
+	}
 	if err := eg.Wait(); err != nil {
 		return nil, err
 	}
 
+	codebase := &Codebase{
+		ExtensionCounts:    make(map[string]int),
+		InjectFileContents: make(map[string]string),
+		SketchIgnore:       ignore != nil,
+	}
+	for i, res := range results {
+		codebase.TotalFiles += res.files
+		for ext, n := range res.extCounts {
+			codebase.ExtensionCounts[ext] += n
+		}
+		codebase.BuildFiles = append(codebase.BuildFiles, res.buildFiles...)
+		codebase.DocumentationFiles = append(codebase.DocumentationFiles, res.documentationFiles...)
+		codebase.GuidanceFiles = append(codebase.GuidanceFiles, res.guidanceFiles...)
+		codebase.InjectFiles = append(codebase.InjectFiles, res.injectFiles...)
+		codebase.Partial = codebase.Partial || res.sampled || res.skipped
+		if units[i].dir != "" {
+			codebase.Directories = append(codebase.Directories, DirectorySummary{
+				Path:            units[i].dir,
+				Files:           res.files,
+				ExtensionCounts: res.extCounts,
+				Sampled:         res.sampled,
+				Skipped:         res.skipped,
+			})
+		}
+	}
+	// Units run in parallel; restore git's path order.
+	slices.Sort(codebase.InjectFiles)
+	var omitted int
+	codebase.BuildFiles, omitted = shallowest(codebase.BuildFiles, opts.MaxListedFiles)
+	codebase.OmittedFiles += omitted
+	codebase.DocumentationFiles, omitted = shallowest(codebase.DocumentationFiles, opts.MaxListedFiles)
+	codebase.OmittedFiles += omitted
+	codebase.GuidanceFiles, omitted = shallowest(codebase.GuidanceFiles, opts.MaxListedFiles)
+	codebase.OmittedFiles += omitted
+
 	// Read content of inject files
-	for _, filePath := range injectFiles {
+	for _, filePath := range codebase.InjectFiles {
 		absPath := filepath.Join(repoPath, filePath)
 		content, err := os.ReadFile(absPath)
 		if err != nil {
 			fmt.Printf("Warning: Failed to read inject file %s: %v\n", filePath, err)
 			continue
 		}
-		injectFileContents[filePath] = string(content)
+		codebase.InjectFileContents[filePath] = string(content)
+	}
+
+	// Results cut short by the time budget depend on machine load, so don't cache them.
+	skipped := slices.ContainsFunc(codebase.Directories, func(d DirectorySummary) bool { return d.Skipped })
+	if cachePath != "" && !skipped {
+		if err := saveCachedCodebase(cachePath, codebase); err != nil {
+			slog.WarnContext(ctx, "failed to cache codebase analysis", "error", err)
+		}
 	}
+	return codebase, nil
+}
 
-	return &Codebase{
-		ExtensionCounts:    extCounts,
-		TotalFiles:         totalFiles,
-		BuildFiles:         buildFiles,
-		DocumentationFiles: documentationFiles,
-		GuidanceFiles:      guidanceFiles,
-		InjectFiles:        injectFiles,
-		InjectFileContents: injectFileContents,
-	}, nil
+// analysisUnit is a set of paths scanned by a single git ls-files invocation.
+type analysisUnit struct {
+	dir       string // reported directory; empty for root-level files
+	pathspecs []string
+}
+
+// analysisUnits splits the repository into a unit per top-level directory
+// (or scope entry) plus one for everything else, including root-level files.
+// Directories ignore ignores get no unit.
+func analysisUnits(ctx context.Context, repoPath, head string, scope []string, ignore *sketchignore.Matcher) []analysisUnit {
+	if len(scope) > 0 {
+		units := []analysisUnit{{pathspecs: []string{":(glob)*"}}}
+		for _, dir := range scope {
+			if !ignore.Ignored(dir + "/") {
+				units = append(units, analysisUnit{dir: dir, pathspecs: []string{dir}})
+			}
+		}
+		return units
+	}
+	var dirs []string
+	if head != "" {
+		// Directories only in the index are picked up by the root unit below.
+		out := gitOutput(ctx, repoPath, "ls-tree", "-d", "-z", "--name-only", "HEAD")
+		dirs = strings.FieldsFunc(out, func(r rune) bool { return r == 0 })
+	}
+	root := analysisUnit{pathspecs: []string{"."}}
+	units := []analysisUnit{}
+	for _, dir := range dirs {
+		if !ignore.Ignored(dir + "/") {
+			units = append(units, analysisUnit{dir: dir, pathspecs: []string{":(literal)" + dir}})
+		}
+		root.pathspecs = append(root.pathspecs, ":(exclude,literal)"+dir)
+	}
+	return append(units, root)
+}
+
+// unitResult is what scanUnit found in one analysisUnit.
+type unitResult struct {
+	files              int
+	extCounts          map[string]int
+	buildFiles         []string
+	documentationFiles []string
+	guidanceFiles      []string
+	injectFiles        []string
+	sampled            bool // stopped early
+	skipped            bool // never started
+}
+
+// scanUnit lists and categorizes the files in u that ignore doesn't ignore,
+// stopping after maxFiles files.
+//
+// TODO: do a filesystem walk instead?
+// There's a balance: git ls-files skips node_modules etc,
+// but some guidance files might be locally .gitignored.
+func scanUnit(ctx context.Context, repoPath string, u analysisUnit, maxFiles int, ignore *sketchignore.Matcher) (unitResult, error) {
+	res := unitResult{extCounts: make(map[string]int)}
+	if ctx.Err() != nil {
+		res.skipped = true
+		return res, ctx.Err()
+	}
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(scanCtx, "git", append([]string{"ls-files", "-z", "--"}, u.pathspecs...)...)
+	cmd.Dir = repoPath
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return res, err
+	}
+	if err := cmd.Start(); err != nil {
+		return res, err
+	}
+
+	scanner := bufio.NewScanner(stdout) // stream and scan rather than buffer
+	scanner.Split(scanZero)
+	for scanner.Scan() {
+		file := strings.TrimSpace(scanner.Text())
+		if file == "" || ignore.Ignored(file) {
+			continue
+		}
+		if res.files == maxFiles {
+			res.sampled = true
+			cancel()
+			break
+		}
+		res.files++
+		ext := strings.ToLower(filepath.Ext(file))
+		ext = cmp.Or(ext, "<no-extension>")
+		res.extCounts[ext]++
+
+		switch categorizeFile(file) {
+		case "build":
+			res.buildFiles = append(res.buildFiles, file)
+		case "documentation":
+			res.documentationFiles = append(res.documentationFiles, file)
+		case "guidance":
+			res.guidanceFiles = append(res.guidanceFiles, file)
+		case "inject":
+			res.injectFiles = append(res.injectFiles, file)
+		}
+	}
+	scanErr := scanner.Err()
+	// Drain so that git isn't blocked writing when we stopped early.
+	io.Copy(io.Discard, stdout)
+	err = cmd.Wait()
+	if res.sampled {
+		return res, nil
+	}
+	if ctx.Err() != nil {
+		res.sampled = true
+		return res, ctx.Err()
+	}
+	return res, cmp.Or(scanErr, err)
+}
+
+// shallowest returns at most n of files, preferring those nearest the
+// repository root, in path order, along with how many were dropped.
+func shallowest(files []string, n int) ([]string, int) {
+	omitted := max(0, len(files)-n)
+	if omitted > 0 {
+		slices.SortStableFunc(files, func(a, b string) int {
+			return cmp.Compare(strings.Count(a, "/"), strings.Count(b, "/"))
+		})
+		files = files[:n]
+	}
+	slices.Sort(files)
+	return files, omitted
+}
+
+func gitOutput(ctx context.Context, dir string, args ...string) string {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// cachePath returns where results for this analysis of commit head are cached,
+// or "" if caching is disabled or there is no commit to key on.
+// The .sketchignore file is part of the key, since it needn't be committed.
+func (o Options) cachePath(head string, ignore *sketchignore.Matcher) string {
+	if o.CacheDir == "" || head == "" {
+		return ""
+	}
+	key := fmt.Sprintf("v2\x00%s\x00%s\x00%d\x00%d\x00%s", head, strings.Join(o.Scope, ","), o.MaxFilesPerDir, o.MaxListedFiles, ignore.String())
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(o.CacheDir, hex.EncodeToString(sum[:16])+".json")
+}
+
+func loadCachedCodebase(path string) (*Codebase, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	codebase := new(Codebase)
+	if err := json.Unmarshal(data, codebase); err != nil {
+		return nil, err
+	}
+	return codebase, nil
+}
+
+func saveCachedCodebase(path string, codebase *Codebase) error {
+	data, err := json.Marshal(codebase)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
 
 // categorizeFile categorizes a file into one of four categories: build, documentation, guidance, or inject.
@@ -184,12 +465,17 @@ func categorizeFile(path string) string {
 
 // TopExtensions returns the top 5 most common file extensions in the codebase
 func (c *Codebase) TopExtensions() []string {
+	return topExtensions(c.ExtensionCounts, c.TotalFiles, 5)
+}
+
+// topExtensions returns the n most common extensions in counts, with their share of total.
+func topExtensions(counts map[string]int, total, n int) []string {
 	type extCount struct {
 		ext   string
 		count int
 	}
-	pairs := make([]extCount, 0, len(c.ExtensionCounts))
-	for ext, count := range c.ExtensionCounts {
+	pairs := make([]extCount, 0, len(counts))
+	for ext, count := range counts {
 		pairs = append(pairs, extCount{ext, count})
 	}
 
@@ -201,11 +487,10 @@ func (c *Codebase) TopExtensions() []string {
 		)
 	})
 
-	const nTop = 5
-	count := min(nTop, len(pairs))
+	count := min(n, len(pairs))
 	result := make([]string, count)
 	for i := range count {
-		result[i] = fmt.Sprintf("%v: %v (%0.0f%%)", pairs[i].ext, pairs[i].count, 100*float64(pairs[i].count)/float64(c.TotalFiles))
+		result[i] = fmt.Sprintf("%v: %v (%0.0f%%)", pairs[i].ext, pairs[i].count, 100*float64(pairs[i].count)/float64(total))
 	}
 
 	return result
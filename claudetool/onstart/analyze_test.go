@@ -7,12 +7,13 @@ import (
 	"path/filepath"
 	"slices"
 	"testing"
+	"time"
 )
 
 func TestAnalyzeCodebase(t *testing.T) {
 	t.Run("Basic Analysis", func(t *testing.T) {
 		// Test basic functionality with regular ASCII filenames
-		codebase, err := AnalyzeCodebase(context.Background(), ".")
+		codebase, err := AnalyzeCodebase(context.Background(), ".", Options{})
 		if err != nil {
 			t.Fatalf("AnalyzeCodebase failed: %v", err)
 		}
@@ -111,7 +112,7 @@ func TestAnalyzeCodebase(t *testing.T) {
 		}
 
 		// Test with non-ASCII characters in filenames
-		codebase, err := AnalyzeCodebase(context.Background(), tempDir)
+		codebase, err := AnalyzeCodebase(context.Background(), tempDir, Options{})
 		if err != nil {
 			t.Fatalf("AnalyzeCodebase failed with non-ASCII filenames: %v", err)
 		}
@@ -236,3 +237,134 @@ func TestTopExtensions(t *testing.T) {
 		}
 	})
 }
+
+// newCommittedRepo creates a git repository with files committed at the given paths.
+func newCommittedRepo(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestAnalyzeCodebaseLimits(t *testing.T) {
+	repo := newCommittedRepo(t,
+		"README.md", "dear_llm.md",
+		"a/README.md", "a/x.go", "a/y.go",
+		"b/README.md", "b/deep/README.md", "b/1.ts", "b/2.ts", "b/3.ts",
+	)
+	ctx := context.Background()
+
+	t.Run("sampling", func(t *testing.T) {
+		codebase, err := AnalyzeCodebase(ctx, repo, Options{MaxFilesPerDir: 3, MaxListedFiles: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(codebase.Directories) != 2 || codebase.Directories[0].Path != "a" || codebase.Directories[1].Path != "b" {
+			t.Fatalf("directories = %+v", codebase.Directories)
+		}
+		if a, b := codebase.Directories[0], codebase.Directories[1]; a.Sampled || a.Files != 3 || !b.Sampled || b.Files != 3 {
+			t.Errorf("a = %+v, b = %+v", a, b)
+		}
+		if !codebase.Partial || !codebase.Large() || codebase.TotalFiles != 8 {
+			t.Errorf("partial = %v, total = %d", codebase.Partial, codebase.TotalFiles)
+		}
+		// b is sampled before its READMEs; of the rest, the shallowest is kept.
+		if want := []string{"README.md"}; !slices.Equal(codebase.DocumentationFiles, want) {
+			t.Errorf("documentation files = %q, want %q", codebase.DocumentationFiles, want)
+		}
+		if codebase.OmittedFiles != 1 {
+			t.Errorf("omitted = %d, want 1", codebase.OmittedFiles)
+		}
+		if codebase.InjectFileContents["dear_llm.md"] != "dear_llm.md" {
+			t.Errorf("inject files = %q", codebase.InjectFiles)
+		}
+	})
+
+	t.Run("scope", func(t *testing.T) {
+		codebase, err := AnalyzeCodebase(ctx, repo, Options{Scope: []string{"a"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if codebase.TotalFiles != 5 || len(codebase.Directories) != 1 || !slices.Equal(codebase.InjectFiles, []string{"dear_llm.md"}) {
+			t.Errorf("total = %d, directories = %+v, inject = %q", codebase.TotalFiles, codebase.Directories, codebase.InjectFiles)
+		}
+	})
+
+	t.Run("time budget", func(t *testing.T) {
+		codebase, err := AnalyzeCodebase(ctx, repo, Options{TimeBudget: time.Nanosecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !codebase.Partial || codebase.TotalFiles != 0 || !codebase.Directories[0].Skipped {
+			t.Errorf("codebase = %+v", codebase)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		opts := Options{CacheDir: t.TempDir()}
+		first, err := AnalyzeCodebase(ctx, repo, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Changes to the worktree aren't seen until HEAD moves.
+		if err := os.WriteFile(filepath.Join(repo, "dear_llm.md"), []byte("changed"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		second, err := AnalyzeCodebase(ctx, repo, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first.InjectFileContents["dear_llm.md"] != second.InjectFileContents["dear_llm.md"] {
+			t.Errorf("second analysis was not served from the cache")
+		}
+	})
+
+	t.Run("sketchignore", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(repo, ".sketchignore"), []byte("b/\n*.go\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		codebase, err := AnalyzeCodebase(ctx, repo, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !codebase.SketchIgnore || codebase.TotalFiles != 3 || len(codebase.Directories) != 1 || codebase.Directories[0].Path != "a" {
+			t.Errorf("ignore = %v, total = %d, directories = %+v", codebase.SketchIgnore, codebase.TotalFiles, codebase.Directories)
+		}
+	})
+}
+
+func TestParseScope(t *testing.T) {
+	for _, spec := range []string{"", "full"} {
+		if scope, skip, err := ParseScope(spec); scope != nil || skip || err != nil {
+			t.Errorf("ParseScope(%q) = %q, %v, %v", spec, scope, skip, err)
+		}
+	}
+	if _, skip, _ := ParseScope("off"); !skip {
+		t.Errorf("ParseScope(off) should skip")
+	}
+	if scope, _, err := ParseScope("services/api/, ./web"); err != nil || !slices.Equal(scope, []string{"services/api", "web"}) {
+		t.Errorf("ParseScope = %q, %v", scope, err)
+	}
+	if _, _, err := ParseScope("../elsewhere"); err == nil {
+		t.Errorf("expected error for a scope outside the repository")
+	}
+}
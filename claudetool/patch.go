@@ -529,6 +529,16 @@ func (p *PatchTool) patchRun(ctx context.Context, input *PatchInput) llm.ToolOut
 		fmt.Fprintf(response, "<warning>%q appears to be autogenerated. Patches were applied anyway.</warning>\n", input.Path)
 	}
 
+	// A quick parse catches broken syntax now rather than at the next build or test run.
+	if syntaxErrs := CheckSyntax(ctx, input.Path, patched); len(syntaxErrs) > 0 {
+		fmt.Fprintf(response, "<syntax_errors>\n%s\n</syntax_errors>\n", strings.Join(syntaxErrs, "\n"))
+		if len(orig) > 0 && len(CheckSyntax(ctx, input.Path, orig)) > 0 {
+			fmt.Fprintf(response, "The file had syntax errors before this patch too.\n")
+		} else {
+			fmt.Fprintf(response, "The patched file no longer parses. Fix it before moving on.\n")
+		}
+	}
+
 	diff := generateUnifiedDiff(input.Path, string(orig), string(patched))
 
 	// TODO: maybe report the patch result to the model, i.e. some/all of the new code after the patches and formatting.
@@ -396,6 +396,39 @@ func TestPatchTool_AutogeneratedDetection(t *testing.T) {
 	}
 }
 
+func TestPatchTool_SyntaxErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	patch := &PatchTool{Pwd: tempDir}
+	ctx := context.Background()
+
+	input := PatchInput{
+		Path: filepath.Join(tempDir, "main.go"),
+		Patches: []PatchRequest{{
+			Operation: "overwrite",
+			NewText:   "package main\n\nfunc main() {}\n",
+		}},
+	}
+	msg, _ := json.Marshal(input)
+	if result := patch.Run(ctx, msg); result.Error != nil || strings.Contains(result.LLMContent[0].Text, "syntax_errors") {
+		t.Fatalf("valid file: %v %v", result.Error, result.LLMContent)
+	}
+
+	// Breaking the syntax still applies the patch, but says so.
+	input.Patches = []PatchRequest{{
+		Operation: "replace",
+		OldText:   "func main() {}",
+		NewText:   "func main() {",
+	}}
+	msg, _ = json.Marshal(input)
+	result := patch.Run(ctx, msg)
+	if result.Error != nil {
+		t.Fatalf("patch failed: %v", result.Error)
+	}
+	if text := result.LLMContent[0].Text; !strings.Contains(text, "<syntax_errors>") || !strings.Contains(text, "no longer parses") {
+		t.Errorf("expected a syntax error report, got:\n%s", text)
+	}
+}
+
 func TestPatchTool_MultiplePatches(t *testing.T) {
 	tempDir := t.TempDir()
 	patch := &PatchTool{Pwd: tempDir}
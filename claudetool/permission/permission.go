@@ -0,0 +1,240 @@
+// Package permission decides, tool by tool, whether the agent may run a tool
+// call: always, never, or once the user approves that call.
+//
+// Calls that need approval wait in a Gate's queue while the user is asked, so
+// the agent's turn pauses on them and resumes with the user's decision.
+package permission
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// A Mode says what happens to a call of a tool.
+type Mode string
+
+const (
+	Allow Mode = "allow" // the call runs
+	Deny  Mode = "deny"  // the call fails
+	Ask   Mode = "ask"   // the call waits for the user to approve or deny it
+)
+
+// Default is the Policy key that sets the mode of the tools it doesn't name.
+const Default = "*"
+
+// A Policy maps tool names, or Default, to modes. Tools it doesn't cover are allowed.
+type Policy map[string]Mode
+
+// ParsePolicy parses the -tool-permissions flag: comma-separated tool=mode
+// pairs, such as "bash=ask,patch=ask" or "*=ask,think=allow".
+func ParsePolicy(s string) (Policy, error) {
+	p := make(Policy)
+	for pair := range strings.SplitSeq(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tool, mode, ok := strings.Cut(pair, "=")
+		tool, mode = strings.TrimSpace(tool), strings.TrimSpace(mode)
+		if !ok || tool == "" {
+			return nil, fmt.Errorf("tool permission %q: want tool=mode", pair)
+		}
+		switch m := Mode(mode); m {
+		case Allow, Deny, Ask:
+			p[tool] = m
+		default:
+			return nil, fmt.Errorf("tool permission %q: unknown mode %q, want allow, deny or ask", pair, mode)
+		}
+	}
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// Mode returns the mode of calls of tool.
+func (p Policy) Mode(tool string) Mode {
+	return cmp.Or(p[tool], p[Default], Allow)
+}
+
+// String formats p as ParsePolicy reads it.
+func (p Policy) String() string {
+	var pairs []string
+	for _, tool := range slices.Sorted(maps.Keys(p)) {
+		pairs = append(pairs, tool+"="+string(p[tool]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// An ApprovalRequest is a tool call waiting for the user's approval.
+type ApprovalRequest struct {
+	ID         string    `json:"id"`
+	Tool       string    `json:"tool"`
+	Input      string    `json:"input"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	Requested  time.Time `json:"requested"`
+}
+
+// A Decision is the user's answer to an ApprovalRequest.
+type Decision struct {
+	Approve bool
+	Comment string // passed to the model along with a denial
+	// Always applies the decision to every later call of the tool, in this session.
+	Always bool
+}
+
+// ErrUnknownRequest is the error of deciding on a request that isn't waiting,
+// because it was never made, was already decided, or its turn was cancelled.
+var ErrUnknownRequest = errors.New("no tool call is waiting for that approval")
+
+// A Gate applies a Policy to tool calls.
+type Gate struct {
+	// OnRequest, if set, is called as a call starts waiting for approval,
+	// to ask the user; OnDecided when it stops waiting.
+	OnRequest func(ctx context.Context, req ApprovalRequest)
+	OnDecided func(ctx context.Context, req ApprovalRequest, d Decision)
+
+	mu      sync.Mutex
+	policy  Policy
+	pending map[string]*waiting
+	seq     int
+}
+
+type waiting struct {
+	req     ApprovalRequest
+	decided chan Decision
+}
+
+// NewGate returns a Gate applying policy, which it doesn't modify.
+func NewGate(policy Policy) *Gate {
+	return &Gate{policy: maps.Clone(policy)}
+}
+
+// Policy returns the policy in effect, including the decisions made Always.
+func (g *Gate) Policy() Policy {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return maps.Clone(g.policy)
+}
+
+// Mode returns the mode of calls of tool.
+func (g *Gate) Mode(tool string) Mode {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.policy.Mode(tool)
+}
+
+// Pending returns the calls waiting for approval, oldest first.
+func (g *Gate) Pending() []ApprovalRequest {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var reqs []ApprovalRequest
+	for _, w := range g.pending {
+		reqs = append(reqs, w.req)
+	}
+	slices.SortFunc(reqs, func(a, b ApprovalRequest) int { return a.Requested.Compare(b.Requested) })
+	return reqs
+}
+
+// Decide answers the request with the given id, letting its call run or fail.
+func (g *Gate) Decide(id string, d Decision) error {
+	g.mu.Lock()
+	w, ok := g.pending[id]
+	if ok {
+		delete(g.pending, id)
+		if d.Always {
+			if g.policy == nil {
+				g.policy = make(Policy)
+			}
+			g.policy[w.req.Tool] = Deny
+			if d.Approve {
+				g.policy[w.req.Tool] = Allow
+			}
+		}
+	}
+	g.mu.Unlock()
+	if !ok {
+		return ErrUnknownRequest
+	}
+	w.decided <- d
+	return nil
+}
+
+// Wrap returns a copy of t whose calls are subject to the gate's policy, as
+// of each call: a denied call fails, and a call in Ask mode waits for Decide.
+func (g *Gate) Wrap(t *llm.Tool) *llm.Tool {
+	gated := *t
+	run := t.Run
+	gated.Run = func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+		switch g.Mode(t.Name) {
+		case Deny:
+			return llm.ErrorfToolOut("the user doesn't allow the %s tool in this session", t.Name)
+		case Ask:
+			d, err := g.wait(ctx, t.Name, string(input))
+			if err != nil {
+				return llm.ErrorToolOut(err)
+			}
+			if !d.Approve {
+				if d.Comment != "" {
+					return llm.ErrorfToolOut("the user denied this %s call: %s", t.Name, d.Comment)
+				}
+				return llm.ErrorfToolOut("the user denied this %s call", t.Name)
+			}
+		}
+		return run(ctx, input)
+	}
+	return &gated
+}
+
+// wait queues a call of tool for approval and returns the user's decision.
+func (g *Gate) wait(ctx context.Context, tool, input string) (Decision, error) {
+	g.mu.Lock()
+	g.seq++
+	w := &waiting{
+		req: ApprovalRequest{
+			ID:         fmt.Sprintf("%d", g.seq),
+			Tool:       tool,
+			Input:      input,
+			ToolCallID: conversation.ToolCallInfoFromContext(ctx).ToolUseID,
+			Requested:  time.Now(),
+		},
+		decided: make(chan Decision, 1),
+	}
+	if g.pending == nil {
+		g.pending = make(map[string]*waiting)
+	}
+	g.pending[w.req.ID] = w
+	g.mu.Unlock()
+
+	if g.OnRequest != nil {
+		g.OnRequest(ctx, w.req)
+	}
+	var d Decision
+	select {
+	case d = <-w.decided:
+	case <-ctx.Done():
+		g.mu.Lock()
+		_, ok := g.pending[w.req.ID]
+		delete(g.pending, w.req.ID)
+		g.mu.Unlock()
+		if ok {
+			return Decision{}, fmt.Errorf("the %s call was cancelled while waiting for the user's approval: %w", tool, ctx.Err())
+		}
+		d = <-w.decided // Decide won the race
+	}
+	if g.OnDecided != nil {
+		g.OnDecided(ctx, w.req, d)
+	}
+	return d, nil
+}
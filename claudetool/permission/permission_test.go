@@ -0,0 +1,99 @@
+package permission
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy(" bash=ask, *=deny ,think=allow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tool, want := range map[string]Mode{"bash": Ask, "think": Allow, "patch": Deny} {
+		if got := p.Mode(tool); got != want {
+			t.Errorf("Mode(%q) = %q, want %q", tool, got, want)
+		}
+	}
+	if got := p.String(); got != "*=deny,bash=ask,think=allow" {
+		t.Errorf("String() = %q", got)
+	}
+	if p, err := ParsePolicy(""); p != nil || err != nil {
+		t.Errorf(`ParsePolicy("") = %v, %v`, p, err)
+	}
+	for _, bad := range []string{"bash", "bash=maybe", "=ask"} {
+		if _, err := ParsePolicy(bad); err == nil {
+			t.Errorf("ParsePolicy(%q) succeeded", bad)
+		}
+	}
+	if got := Policy(nil).Mode("bash"); got != Allow {
+		t.Errorf("a nil policy gives bash %q", got)
+	}
+}
+
+func TestGate(t *testing.T) {
+	ctx := context.Background()
+	requests := make(chan ApprovalRequest, 1)
+	g := NewGate(Policy{"bash": Ask, "patch": Deny})
+	g.OnRequest = func(ctx context.Context, req ApprovalRequest) { requests <- req }
+	ran := 0
+	tool := func(name string) *llm.Tool {
+		return g.Wrap(&llm.Tool{Name: name, Run: func(context.Context, json.RawMessage) llm.ToolOut {
+			ran++
+			return llm.ToolOut{}
+		}})
+	}
+	bash, patch, think := tool("bash"), tool("patch"), tool("think")
+
+	if out := patch.Run(ctx, nil); out.Error == nil {
+		t.Error("a denied tool ran")
+	}
+	if out := think.Run(ctx, nil); out.Error != nil || ran != 1 {
+		t.Errorf("an allowed tool didn't run: %v", out.Error)
+	}
+
+	call := func(approve, always bool) llm.ToolOut {
+		done := make(chan llm.ToolOut)
+		go func() { done <- bash.Run(ctx, json.RawMessage(`{"command":"ls"}`)) }()
+		req := <-requests
+		if pending := g.Pending(); len(pending) != 1 || pending[0].Input != `{"command":"ls"}` {
+			t.Errorf("pending = %+v", pending)
+		}
+		if err := g.Decide(req.ID, Decision{Approve: approve, Comment: "no", Always: always}); err != nil {
+			t.Fatal(err)
+		}
+		return <-done
+	}
+	if out := call(false, false); out.Error == nil || ran != 1 {
+		t.Error("a denied call ran")
+	}
+	if out := call(true, true); out.Error != nil || ran != 2 {
+		t.Errorf("an approved call didn't run: %v", out.Error)
+	}
+	if out := bash.Run(ctx, nil); out.Error != nil || ran != 3 {
+		t.Errorf("always allowed, bash still asked or failed: %v", out.Error)
+	}
+	if err := g.Decide("1", Decision{Approve: true}); !errors.Is(err, ErrUnknownRequest) {
+		t.Errorf("deciding twice: %v", err)
+	}
+}
+
+func TestGateCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := NewGate(Policy{Default: Ask})
+	g.OnRequest = func(context.Context, ApprovalRequest) { cancel() }
+	tool := g.Wrap(&llm.Tool{Name: "bash", Run: func(context.Context, json.RawMessage) llm.ToolOut {
+		t.Error("a cancelled call ran")
+		return llm.ToolOut{}
+	}})
+	if out := tool.Run(ctx, nil); out.Error == nil {
+		t.Error("a cancelled call succeeded")
+	}
+	if pending := g.Pending(); len(pending) != 0 {
+		t.Errorf("a cancelled call is still pending: %+v", pending)
+	}
+}
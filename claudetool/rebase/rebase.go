@@ -0,0 +1,427 @@
+// Package rebase keeps the agent's branch current with a moving upstream: it
+// replays the session's commits onto the latest upstream, stops at each
+// conflict with a structured description of it, and runs the tests at the end.
+package rebase
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sketch.dev/claudetool/codereview"
+	"sketch.dev/llm"
+)
+
+// maxHunkSide caps each side of a conflict hunk in a Status; the start is kept.
+const maxHunkSide = 4096
+
+// A Hunk is one conflicted region of a file, as delimited by diff3 conflict markers.
+type Hunk struct {
+	Line     int    `json:"line"`     // of the <<<<<<< marker, from 1
+	Upstream string `json:"upstream"` // the upstream version
+	Base     string `json:"base"`     // the version both sides started from
+	Yours    string `json:"yours"`    // the version of the commit being replayed
+}
+
+// A Conflict is a file the rebase couldn't merge.
+type Conflict struct {
+	File   string `json:"file"`
+	Status string `json:"status"`          // git's two-letter unmerged status, e.g. UU or DU
+	Hunks  []Hunk `json:"hunks,omitempty"` // empty when there are no markers, e.g. for a deleted file
+}
+
+// Status describes where a rebase stands.
+type Status struct {
+	State      string                   `json:"state"` // "idle", "up_to_date", "conflict", "rebased" or "aborted"
+	Onto       string                   `json:"onto,omitempty"`
+	OntoCommit string                   `json:"onto_commit,omitempty"`
+	Step       int                      `json:"step,omitempty"`  // the commit being replayed, from 1
+	Steps      int                      `json:"steps,omitempty"` // the commits to replay
+	Commit     string                   `json:"commit,omitempty"`
+	Subject    string                   `json:"subject,omitempty"`
+	Conflicts  []Conflict               `json:"conflicts,omitempty"`
+	Unmet      []codereview.Requirement `json:"unmet,omitempty"` // failing tests after a rebase
+}
+
+// An Assistant rebases the commits since the sketch base onto an upstream branch.
+type Assistant struct {
+	repoRoot       string
+	sketchBaseRef  string
+	upstreamBranch string
+	reviewer       *codereview.CodeReviewer // runs the tests after a rebase; may be nil
+
+	mu         sync.Mutex
+	onto       string // the ref of the rebase under way
+	ontoCommit string
+}
+
+// NewAssistant creates an Assistant for the repository at repoRoot. By default
+// it rebases onto upstreamBranch of the "upstream" remote, or of "origin" if
+// there is none, and moves sketchBaseRef there once done.
+func NewAssistant(repoRoot, sketchBaseRef, upstreamBranch string, reviewer *codereview.CodeReviewer) *Assistant {
+	return &Assistant{repoRoot: repoRoot, sketchBaseRef: sketchBaseRef, upstreamBranch: upstreamBranch, reviewer: reviewer}
+}
+
+func (a *Assistant) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = a.repoRoot
+	cmd.Env = append(os.Environ(), "GIT_EDITOR=true")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("git %s: %w\n%s", args[0], err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// rebaseDir returns the directory of the rebase under way, or "" if there is none.
+func (a *Assistant) rebaseDir(ctx context.Context) string {
+	for _, name := range []string{"rebase-merge", "rebase-apply"} {
+		dir, err := a.git(ctx, "rev-parse", "--path-format=absolute", "--git-path", name)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// defaultOnto is the upstream branch on the upstream remote, or origin.
+func (a *Assistant) defaultOnto(ctx context.Context) (string, error) {
+	if a.upstreamBranch == "" {
+		return "", errors.New("no upstream branch is configured; pass onto")
+	}
+	remotes, err := a.git(ctx, "remote")
+	if err != nil {
+		return "", err
+	}
+	remote := "origin"
+	if slices.Contains(strings.Fields(remotes), "upstream") {
+		remote = "upstream"
+	}
+	return remote + "/" + a.upstreamBranch, nil
+}
+
+// Start fetches onto's remote and rebases the current branch onto it.
+// An empty onto rebases onto the upstream branch.
+func (a *Assistant) Start(ctx context.Context, onto string) (Status, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rebaseDir(ctx) != "" {
+		return Status{}, errors.New("a rebase is already under way; continue or abort it first")
+	}
+	if out, err := a.git(ctx, "status", "--porcelain", "--untracked-files=no"); err != nil {
+		return Status{}, err
+	} else if out != "" {
+		return Status{}, fmt.Errorf("commit or discard your changes before rebasing:\n%s", out)
+	}
+	if onto == "" {
+		var err error
+		if onto, err = a.defaultOnto(ctx); err != nil {
+			return Status{}, err
+		}
+	}
+	if remote, _, ok := strings.Cut(onto, "/"); ok {
+		remotes, err := a.git(ctx, "remote")
+		if err != nil {
+			return Status{}, err
+		}
+		if slices.Contains(strings.Fields(remotes), remote) {
+			if _, err := a.git(ctx, "fetch", remote); err != nil {
+				return Status{}, err
+			}
+		}
+	}
+	ontoCommit, err := a.git(ctx, "rev-parse", "--verify", "--quiet", onto+"^{commit}")
+	if err != nil {
+		return Status{}, fmt.Errorf("unknown ref %q", onto)
+	}
+	base, err := a.git(ctx, "rev-parse", "--verify", a.sketchBaseRef+"^{commit}")
+	if err != nil {
+		return Status{}, err
+	}
+	if _, err := a.git(ctx, "merge-base", "--is-ancestor", ontoCommit, base); err == nil {
+		return Status{State: "up_to_date", Onto: onto, OntoCommit: ontoCommit}, nil
+	}
+
+	a.onto, a.ontoCommit = onto, ontoCommit
+	_, err = a.git(ctx, "-c", "merge.conflictStyle=diff3", "rebase", "--onto", ontoCommit, base)
+	return a.after(ctx, err)
+}
+
+// Continue resumes a rebase stopped at a conflict once the conflicted files are fixed.
+// With skip, it drops the commit being replayed instead.
+func (a *Assistant) Continue(ctx context.Context, skip bool) (Status, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	dir := a.rebaseDir(ctx)
+	if dir == "" {
+		return Status{}, errors.New("no rebase is under way")
+	}
+	if a.ontoCommit == "" {
+		// A rebase started before a restart; git knows where it's going.
+		b, _ := os.ReadFile(filepath.Join(dir, "onto"))
+		a.ontoCommit = strings.TrimSpace(string(b))
+		a.onto = a.ontoCommit
+	}
+	if skip {
+		_, err := a.git(ctx, "-c", "merge.conflictStyle=diff3", "rebase", "--skip")
+		return a.after(ctx, err)
+	}
+	conflicts, err := a.conflicts(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	var unresolved, files []string
+	for _, c := range conflicts {
+		if len(c.Hunks) > 0 {
+			unresolved = append(unresolved, c.File)
+		}
+		files = append(files, c.File)
+	}
+	if len(unresolved) > 0 {
+		return Status{}, fmt.Errorf("conflict markers remain in %s", strings.Join(unresolved, ", "))
+	}
+	if len(files) > 0 {
+		// add -A also stages the deletion of a file that was removed to resolve it.
+		if _, err := a.git(ctx, append([]string{"add", "-A", "--"}, files...)...); err != nil {
+			return Status{}, err
+		}
+	}
+	out, err := a.git(ctx, "-c", "merge.conflictStyle=diff3", "rebase", "--continue")
+	if err != nil && strings.Contains(out, "nothing to commit") {
+		return Status{}, errors.New("the resolution leaves this commit empty; skip it to drop it")
+	}
+	return a.after(ctx, err)
+}
+
+// Abort abandons the rebase under way, restoring the branch.
+func (a *Assistant) Abort(ctx context.Context) (Status, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rebaseDir(ctx) == "" {
+		return Status{}, errors.New("no rebase is under way")
+	}
+	if _, err := a.git(ctx, "rebase", "--abort"); err != nil {
+		return Status{}, err
+	}
+	onto := a.onto
+	a.onto, a.ontoCommit = "", ""
+	return Status{State: "aborted", Onto: onto}, nil
+}
+
+// Status reports the rebase under way, if any.
+func (a *Assistant) Status(ctx context.Context) (Status, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if dir := a.rebaseDir(ctx); dir != "" {
+		return a.stopped(ctx, dir)
+	}
+	return Status{State: "idle"}, nil
+}
+
+// after reports the outcome of a rebase command that returned runErr:
+// stopped at a conflict, failed, or done, in which case the base moves and the tests run.
+func (a *Assistant) after(ctx context.Context, runErr error) (Status, error) {
+	if dir := a.rebaseDir(ctx); dir != "" {
+		st, err := a.stopped(ctx, dir)
+		if err == nil && len(st.Conflicts) == 0 && runErr != nil {
+			// Stopped for some other reason, such as a failing commit hook.
+			return st, runErr
+		}
+		return st, err
+	}
+	onto, ontoCommit := a.onto, a.ontoCommit
+	a.onto, a.ontoCommit = "", ""
+	if runErr != nil {
+		return Status{}, runErr
+	}
+	if _, err := a.git(ctx, "tag", "-f", a.sketchBaseRef, ontoCommit); err != nil {
+		return Status{}, err
+	}
+	st := Status{State: "rebased", Onto: onto, OntoCommit: ontoCommit}
+	if a.reviewer != nil {
+		a.reviewer.BaseMoved(ctx)
+		st.Unmet = a.reviewer.CheckQualityGates(ctx, &codereview.QualityGates{Tests: true})
+	}
+	return st, nil
+}
+
+// stopped describes a rebase stopped at a conflict; dir is its state directory.
+func (a *Assistant) stopped(ctx context.Context, dir string) (Status, error) {
+	st := Status{State: "conflict", Onto: a.onto, OntoCommit: a.ontoCommit}
+	readInt := func(name string) int {
+		b, _ := os.ReadFile(filepath.Join(dir, name))
+		n, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+		return n
+	}
+	st.Step, st.Steps = readInt("msgnum"), readInt("end")
+	if out, err := a.git(ctx, "log", "-1", "--format=%H%x00%s", "REBASE_HEAD"); err == nil {
+		st.Commit, st.Subject, _ = strings.Cut(out, "\x00")
+	}
+	var err error
+	st.Conflicts, err = a.conflicts(ctx)
+	return st, err
+}
+
+// conflicts lists the unmerged files and the conflict hunks left in them.
+func (a *Assistant) conflicts(ctx context.Context) ([]Conflict, error) {
+	out, err := a.git(ctx, "status", "--porcelain=v1", "-z")
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []Conflict
+	for entry := range strings.SplitSeq(out, "\x00") {
+		if len(entry) < 4 {
+			continue
+		}
+		code, file := entry[:2], entry[3:]
+		switch code {
+		case "DD", "AU", "UD", "UA", "DU", "AA", "UU":
+		default:
+			continue
+		}
+		c := Conflict{File: file, Status: code}
+		if b, err := os.ReadFile(filepath.Join(a.repoRoot, file)); err == nil {
+			c.Hunks = parseHunks(b)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, nil
+}
+
+// parseHunks finds the diff3-style conflict hunks in content.
+func parseHunks(content []byte) []Hunk {
+	const (
+		outside = iota
+		upstream
+		base
+		yours
+	)
+	var hunks []Hunk
+	var h Hunk
+	var side *string
+	state := outside
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		l := sc.Text()
+		switch {
+		case strings.HasPrefix(l, "<<<<<<<") && state == outside:
+			h, state, side = Hunk{Line: line}, upstream, &h.Upstream
+		case strings.HasPrefix(l, "|||||||") && state == upstream:
+			state, side = base, &h.Base
+		case l == "=======" && (state == upstream || state == base):
+			state, side = yours, &h.Yours
+		case strings.HasPrefix(l, ">>>>>>>") && state == yours:
+			hunks = append(hunks, h)
+			state, side = outside, nil
+		case state != outside:
+			if len(*side) < maxHunkSide {
+				*side += l + "\n"
+				if len(*side) >= maxHunkSide {
+					*side = (*side)[:maxHunkSide] + "\n[truncated]\n"
+				}
+			}
+		}
+	}
+	return hunks
+}
+
+// Tool returns the rebase_upstream tool.
+func (a *Assistant) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name: "rebase_upstream",
+		Description: `Rebase your commits since sketch-base onto the latest upstream branch, fetching it first.
+Action "start" (the default) begins the rebase and stops at the first conflicting commit, listing each conflicted hunk as upstream, base and yours.
+Resolve the hunks by editing the files and removing every conflict marker, then use "continue"; "skip" drops the commit being replayed, and "abort" restores your branch.
+When the rebase completes, sketch-base moves to the upstream commit and the tests run. Use "status" to see a rebase under way.`,
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "properties": {
+    "action": {"type": "string", "enum": ["start", "continue", "skip", "abort", "status"]},
+    "onto": {"type": "string", "description": "ref to rebase onto instead of the upstream branch, for start"}
+  }
+}`),
+		Run: a.run,
+	}
+}
+
+func (a *Assistant) run(ctx context.Context, m json.RawMessage) llm.ToolOut {
+	var input struct {
+		Action string `json:"action"`
+		Onto   string `json:"onto"`
+	}
+	if len(m) > 0 {
+		if err := json.Unmarshal(m, &input); err != nil {
+			return llm.ErrorfToolOut("invalid input: %w", err)
+		}
+	}
+	var st Status
+	var err error
+	switch input.Action {
+	case "", "start":
+		st, err = a.Start(ctx, input.Onto)
+	case "continue":
+		st, err = a.Continue(ctx, false)
+	case "skip":
+		st, err = a.Continue(ctx, true)
+	case "abort":
+		st, err = a.Abort(ctx)
+	case "status":
+		st, err = a.Status(ctx)
+	default:
+		return llm.ErrorfToolOut("unknown action %q: want start, continue, skip, abort or status", input.Action)
+	}
+	if err != nil {
+		return llm.ErrorToolOut(err)
+	}
+	return llm.ToolOut{LLMContent: llm.TextContent(st.String()), Display: st}
+}
+
+// String renders the status for the model; conflicts are included as JSON.
+func (st Status) String() string {
+	switch st.State {
+	case "idle":
+		return "No rebase is under way."
+	case "up_to_date":
+		return fmt.Sprintf("Already up to date: %s (%s) is contained in sketch-base.", st.Onto, short(st.OntoCommit))
+	case "aborted":
+		return "Rebase aborted; your branch is as it was."
+	case "rebased":
+		s := fmt.Sprintf("Rebased onto %s (%s); sketch-base now points there.", st.Onto, short(st.OntoCommit))
+		if len(st.Unmet) == 0 {
+			return s + " The tests pass (or there are none to run)."
+		}
+		s += " The tests fail after the rebase; fix them and commit:"
+		for _, r := range st.Unmet {
+			s += "\n\n" + r.Requirement
+			if r.Details != "" {
+				s += "\n" + r.Details
+			}
+		}
+		return s
+	}
+	conflicts, _ := json.MarshalIndent(st.Conflicts, "", "  ")
+	return fmt.Sprintf("Conflict replaying commit %d of %d onto %s: %s %q.\n"+
+		"Resolve these conflicts, then continue (or skip the commit, or abort):\n%s",
+		st.Step, st.Steps, st.Onto, short(st.Commit), st.Subject, conflicts)
+}
+
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
@@ -0,0 +1,110 @@
+package rebase
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseHunks(t *testing.T) {
+	content := "a\n<<<<<<< HEAD\nup\n||||||| parent of 1234 (change)\nbase\n=======\nmine\nmine2\n>>>>>>> 1234 (change)\nb\n"
+	hunks := parseHunks([]byte(content))
+	if len(hunks) != 1 {
+		t.Fatalf("parseHunks found %d hunks, want 1", len(hunks))
+	}
+	if h := hunks[0]; h != (Hunk{Line: 2, Upstream: "up\n", Base: "base\n", Yours: "mine\nmine2\n"}) {
+		t.Errorf("hunk = %+v", h)
+	}
+	if hunks := parseHunks([]byte("no\n=======\nmarkers\n")); len(hunks) != 0 {
+		t.Errorf("parseHunks without markers = %+v", hunks)
+	}
+}
+
+func TestRebase(t *testing.T) {
+	ctx := context.Background()
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "t")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "t@example.com")
+	}
+	upstream, work := t.TempDir(), t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(dir, file, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git(upstream, "init", "-q", "-b", "main")
+	write(upstream, "a.txt", "one\n")
+	git(upstream, "add", "a.txt")
+	git(upstream, "commit", "-q", "-m", "base")
+	git(work, "clone", "-q", upstream, ".")
+	git(work, "tag", "sketch-base")
+	write(work, "a.txt", "mine\n")
+	git(work, "commit", "-q", "-am", "change a")
+	write(work, "b.txt", "b\n")
+	git(work, "add", "b.txt")
+	git(work, "commit", "-q", "-m", "add b")
+
+	a := NewAssistant(work, "sketch-base", "main", nil)
+	if st, err := a.Start(ctx, ""); err != nil || st.State != "up_to_date" {
+		t.Fatalf("Start with nothing upstream = %+v, %v", st, err)
+	}
+
+	write(upstream, "a.txt", "theirs\n")
+	git(upstream, "commit", "-q", "-am", "upstream change")
+	upstreamHead := git(upstream, "rev-parse", "HEAD")
+
+	st, err := a.Start(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != "conflict" || st.Onto != "origin/main" || st.Step != 1 || st.Steps != 2 || st.Subject != "change a" {
+		t.Fatalf("Start = %+v", st)
+	}
+	if len(st.Conflicts) != 1 || st.Conflicts[0].File != "a.txt" || st.Conflicts[0].Status != "UU" {
+		t.Fatalf("conflicts = %+v", st.Conflicts)
+	}
+	if h := st.Conflicts[0].Hunks; len(h) != 1 || h[0].Upstream != "theirs\n" || h[0].Base != "one\n" || h[0].Yours != "mine\n" {
+		t.Errorf("hunks = %+v", h)
+	}
+	if _, err := a.Start(ctx, ""); err == nil {
+		t.Error("started a second rebase")
+	}
+	if _, err := a.Continue(ctx, false); err == nil {
+		t.Error("continued with conflict markers left")
+	}
+
+	write(work, "a.txt", "theirs and mine\n")
+	st, err = a.Continue(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != "rebased" || st.OntoCommit != upstreamHead {
+		t.Fatalf("Continue = %+v", st)
+	}
+	if base := git(work, "rev-parse", "sketch-base^{commit}"); base != upstreamHead {
+		t.Errorf("sketch-base = %s, want %s", base, upstreamHead)
+	}
+	if log := git(work, "log", "--format=%s", "sketch-base..HEAD"); log != "add b\nchange a" {
+		t.Errorf("commits since sketch-base = %q", log)
+	}
+	if st, err := a.Status(ctx); err != nil || st.State != "idle" {
+		t.Errorf("Status = %+v, %v", st, err)
+	}
+}
@@ -0,0 +1,158 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// Scratchpad runs short Python or Node snippets in a throwaway directory, with
+// limits on time, memory and output, for calculations and data munging that
+// shouldn't leave files in the repository.
+var Scratchpad = &llm.Tool{
+	Name:        scratchpadName,
+	Description: scratchpadDescription,
+	InputSchema: llm.MustSchema(scratchpadInputSchema),
+	Run:         scratchpadRun,
+}
+
+const (
+	scratchpadName        = "scratchpad"
+	scratchpadDescription = `Runs a short Python or Node.js snippet and returns what it prints.
+
+Use for quick calculations, parsing or transforming data, and checking how a library function behaves,
+instead of writing a script into the repository. Each run starts in a fresh, empty directory that is
+deleted afterwards; nothing carries over between runs. The repository is not the working directory,
+but may be read by absolute path.
+
+Runs are limited to 30 seconds of CPU and wall-clock time, 1 GiB of memory, 50 MiB of written files
+and 16 KiB of output. For anything longer-lived, or that should change the repository, use bash.
+`
+
+	// If you modify this, update the termui template for prettier rendering.
+	scratchpadInputSchema = `
+{
+  "type": "object",
+  "required": ["language", "code"],
+  "properties": {
+    "language": {
+      "type": "string",
+      "enum": ["python", "node"]
+    },
+    "code": {
+      "type": "string",
+      "description": "the program to run; print the results you want to see"
+    }
+  }
+}
+`
+)
+
+type scratchpadInput struct {
+	Language string `json:"language"`
+	Code     string `json:"code"`
+}
+
+const (
+	scratchpadTimeout   = 30 * time.Second
+	scratchpadMemoryKiB = 1 << 20  // address space, for Python; Node gets a heap limit instead
+	scratchpadFileKiB   = 50 << 10 // largest file a snippet may write
+	scratchpadMaxOutput = 16 << 10
+)
+
+// scratchpadLanguages are the interpreters, with the file the snippet goes in.
+var scratchpadLanguages = map[string]struct {
+	file string
+	cmd  []string
+}{
+	"python": {"snippet.py", []string{"python3", "-I"}}, // -I: ignore PYTHON* variables and user site-packages
+	"node":   {"snippet.cjs", []string{"node", "--max-old-space-size=" + strconv.Itoa(scratchpadMemoryKiB>>10)}},
+}
+
+func scratchpadRun(ctx context.Context, m json.RawMessage) llm.ToolOut {
+	var req scratchpadInput
+	if err := json.Unmarshal(m, &req); err != nil {
+		return llm.ErrorfToolOut("failed to unmarshal scratchpad input: %w", err)
+	}
+	lang, ok := scratchpadLanguages[req.Language]
+	if !ok {
+		return llm.ErrorfToolOut("unknown language %q: want python or node", req.Language)
+	}
+	if _, err := exec.LookPath(lang.cmd[0]); err != nil {
+		return llm.ErrorfToolOut("%s isn't installed here; install it with bash, or use the other language", lang.cmd[0])
+	}
+	dir, err := os.MkdirTemp("", "sketch-scratchpad-")
+	if err != nil {
+		return llm.ErrorToolOut(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, lang.file), []byte(req.Code), 0o600); err != nil {
+		return llm.ErrorToolOut(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scratchpadTimeout)
+	defer cancel()
+	// bash sets the limits, then becomes the interpreter.
+	limits := fmt.Sprintf("ulimit -t %d -f %d", int(scratchpadTimeout.Seconds()), scratchpadFileKiB)
+	if req.Language == "python" {
+		limits += fmt.Sprintf(" -v %d", scratchpadMemoryKiB)
+	}
+	args := append([]string{"-c", limits + ` && exec "$@"`, "scratchpad"}, lang.cmd...)
+	cmd := exec.CommandContext(ctx, "bash", append(args, lang.file)...)
+	cmd.Dir = dir
+	out := &limitedBuffer{max: scratchpadMaxOutput}
+	cmd.Stdout, cmd.Stderr = out, out
+	// A clean environment: no SKETCH_ credentials, and caches and temporary files land in dir.
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir, "LANG=C.UTF-8", "SKETCH=1", "SKETCH_IGNORE_PORTS=1"}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	result := out.String()
+	if out.truncated {
+		result += fmt.Sprintf("\n[output truncated to %d bytes]", scratchpadMaxOutput)
+	}
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return llm.ErrorfToolOut("%s\n[stopped after %s; the scratchpad is for short runs]", result, scratchpadTimeout)
+	case err != nil:
+		return llm.ErrorfToolOut("%s\n[%v]", result, err)
+	case result == "":
+		result = "[no output]"
+	}
+	return llm.ToolOut{LLMContent: llm.TextContent(result)}
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest.
+// It doesn't embed its buffer: io.Copy would use the buffer's ReadFrom and skip the limit.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
@@ -0,0 +1,42 @@
+package claudetool
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func runScratchpad(t *testing.T, language, code string) (string, bool) {
+	t.Helper()
+	if lang, ok := scratchpadLanguages[language]; ok {
+		if _, err := exec.LookPath(lang.cmd[0]); err != nil {
+			t.Skipf("%s not installed", lang.cmd[0])
+		}
+	}
+	in, _ := json.Marshal(scratchpadInput{Language: language, Code: code})
+	out := scratchpadRun(context.Background(), in)
+	if out.Error != nil {
+		return out.Error.Error(), false
+	}
+	return out.LLMContent[0].Text, true
+}
+
+func TestScratchpad(t *testing.T) {
+	if out, ok := runScratchpad(t, "python", "import os\nprint(sum(range(10)), os.listdir('.'))"); !ok || out != "45 ['snippet.py']\n" {
+		t.Errorf("python = %q, %v", out, ok)
+	}
+	if out, ok := runScratchpad(t, "python", "import sys\nprint('before')\nsys.exit(3)"); ok || !strings.Contains(out, "before") || !strings.Contains(out, "exit status 3") {
+		t.Errorf("failing python = %q, %v", out, ok)
+	}
+	if out, ok := runScratchpad(t, "python", "print('x' * 100000)"); !ok || !strings.Contains(out, "[output truncated") || len(out) > scratchpadMaxOutput+100 {
+		t.Errorf("long python output: %d bytes, %v", len(out), ok)
+	}
+	if out, ok := runScratchpad(t, "node", "console.log(JSON.stringify({a: [1, 2].map(x => x * 2)}))"); !ok || out != "{\"a\":[2,4]}\n" {
+		t.Errorf("node = %q, %v", out, ok)
+	}
+	if out, ok := runScratchpad(t, "ruby", "puts 1"); ok || !strings.Contains(out, "unknown language") {
+		t.Errorf("ruby = %q, %v", out, ok)
+	}
+}
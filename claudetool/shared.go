@@ -36,3 +36,28 @@ func SessionID(ctx context.Context) string {
 	sessionID, _ := ctx.Value(sessionIDCtxKey).(string)
 	return sessionID
 }
+
+// ToolProgress is a long-running tool's report of what it is doing, such as
+// Label "running tests" with Done 34 of Total 120. Total is 0 when unknown.
+type ToolProgress struct {
+	Label string `json:"label"`
+	Done  int    `json:"done,omitempty"`
+	Total int    `json:"total,omitempty"`
+}
+
+type progressReporterCtxKeyType string
+
+const progressReporterCtxKey progressReporterCtxKeyType = "progressReporter"
+
+// WithProgressReporter returns a context whose tools send their progress to report.
+func WithProgressReporter(ctx context.Context, report func(ctx context.Context, p ToolProgress)) context.Context {
+	return context.WithValue(ctx, progressReporterCtxKey, report)
+}
+
+// ReportProgress tells the user what the tool running with ctx is doing.
+// It does nothing if no one is listening.
+func ReportProgress(ctx context.Context, p ToolProgress) {
+	if report, ok := ctx.Value(progressReporterCtxKey).(func(context.Context, ToolProgress)); ok {
+		report(ctx, p)
+	}
+}
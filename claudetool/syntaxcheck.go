@@ -0,0 +1,126 @@
+package claudetool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// maxSyntaxErrors caps how many syntax errors are reported for one file;
+// past the first few, parsers mostly report fallout from the first.
+const maxSyntaxErrors = 5
+
+// esbuildLoaders maps the extensions esbuild can parse to its loader for them.
+var esbuildLoaders = map[string]api.Loader{
+	".js":  api.LoaderJS,
+	".mjs": api.LoaderJS,
+	".cjs": api.LoaderJS,
+	".jsx": api.LoaderJSX,
+	".ts":  api.LoaderTS,
+	".mts": api.LoaderTS,
+	".cts": api.LoaderTS,
+	".tsx": api.LoaderTSX,
+	".css": api.LoaderCSS,
+}
+
+// CheckSyntax parses content as the language path's extension indicates and
+// returns its syntax errors, formatted as "path:line:col: message".
+// It is meant to be fast enough to run after every edit, so it only parses:
+// it does not type check, resolve imports, or run linters.
+// Files in languages it doesn't know have no errors.
+func CheckSyntax(ctx context.Context, path string, content []byte) []string {
+	var errs []string
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case ext == ".go":
+		_, err := parser.ParseFile(token.NewFileSet(), path, content, parser.AllErrors|parser.SkipObjectResolution)
+		var list scanner.ErrorList
+		if errors.As(err, &list) {
+			for _, e := range list {
+				errs = append(errs, e.Error())
+			}
+		}
+	case ext == ".json":
+		errs = checkJSON(path, content)
+	case ext == ".sh" || ext == ".bash":
+		if _, err := syntax.NewParser().Parse(bytes.NewReader(content), path); err != nil {
+			errs = append(errs, err.Error())
+		}
+	case ext == ".py":
+		errs = checkPython(ctx, path, content)
+	case esbuildLoaders[ext] != api.LoaderNone:
+		res := api.Transform(string(content), api.TransformOptions{
+			Loader:     esbuildLoaders[ext],
+			Sourcefile: path,
+			LogLevel:   api.LogLevelSilent,
+		})
+		for _, m := range res.Errors {
+			if m.Location == nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", path, m.Text))
+				continue
+			}
+			errs = append(errs, fmt.Sprintf("%s:%d:%d: %s", path, m.Location.Line, m.Location.Column+1, m.Text))
+		}
+	}
+	if len(errs) > maxSyntaxErrors {
+		errs = append(errs[:maxSyntaxErrors], fmt.Sprintf("(and %d more)", len(errs)-maxSyntaxErrors))
+	}
+	return errs
+}
+
+// checkJSON reports where content stops being valid JSON.
+func checkJSON(path string, content []byte) []string {
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil
+	}
+	var v any
+	err := json.Unmarshal(content, &v)
+	if err == nil {
+		return nil
+	}
+	offset := int64(len(content))
+	var syn *json.SyntaxError
+	if errors.As(err, &syn) {
+		offset = syn.Offset
+	}
+	before := content[:min(offset, int64(len(content)))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return []string{fmt.Sprintf("%s:%d:%d: %v", path, line, col, err)}
+}
+
+// checkPython compiles content with python3, if it is installed.
+func checkPython(ctx context.Context, path string, content []byte) []string {
+	if _, err := exec.LookPath("python3"); err != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	const script = `import ast, sys
+try:
+    ast.parse(sys.stdin.read(), sys.argv[1])
+except SyntaxError as e:
+    print(f"{e.filename}:{e.lineno}:{e.offset or 0}: {e.msg}")`
+	cmd := exec.CommandContext(ctx, "python3", "-c", script, path)
+	cmd.Stdin = bytes.NewReader(content)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil // not a verdict on the file
+	}
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return []string{msg}
+	}
+	return nil
+}
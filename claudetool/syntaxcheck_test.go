@@ -0,0 +1,49 @@
+package claudetool
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCheckSyntax(t *testing.T) {
+	tests := []struct {
+		path    string
+		content string
+		want    string // substring of the first error; empty for none
+	}{
+		{"main.go", "package main\n\nfunc main() {}\n", ""},
+		{"main.go", "package main\n\nfunc main() {\n", "main.go:3:15: expected ';'"},
+		{"x.json", `{"a": [1, 2]}`, ""},
+		{"x.json", "{\n  \"a\": [1, 2,]\n}", "x.json:2:15"},
+		{"run.sh", "for f in *; do echo $f; done\n", ""},
+		{"run.sh", "if true; then echo hi\n", "run.sh:1:1"},
+		{"app.ts", "const x: number = 1;\n", ""},
+		{"app.ts", "function f( {\n", "app.ts:2:1"},
+		{"style.css", "a { color: red; }\n", ""},
+		{"notes.txt", "func (", ""},
+	}
+	for _, tt := range tests {
+		errs := CheckSyntax(context.Background(), tt.path, []byte(tt.content))
+		switch {
+		case tt.want == "" && len(errs) > 0:
+			t.Errorf("CheckSyntax(%s, %q) = %q, want no errors", tt.path, tt.content, errs)
+		case tt.want != "" && (len(errs) == 0 || !strings.Contains(errs[0], tt.want)):
+			t.Errorf("CheckSyntax(%s, %q) = %q, want an error containing %q", tt.path, tt.content, errs, tt.want)
+		}
+	}
+}
+
+func TestCheckSyntaxPython(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+	if errs := CheckSyntax(context.Background(), "ok.py", []byte("def f():\n    return 1\n")); len(errs) > 0 {
+		t.Errorf("valid python: %q", errs)
+	}
+	errs := CheckSyntax(context.Background(), "bad.py", []byte("def f(:\n    return 1\n"))
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "bad.py:1:") {
+		t.Errorf("invalid python: %q", errs)
+	}
+}
@@ -0,0 +1,253 @@
+// Package taskrunner drives the task runners of monorepos (Bazel, Pants, Nx
+// and Turborepo): it builds and tests their targets, queries their
+// dependencies, and selects the tests a change affects.
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// A Kind is a task runner.
+type Kind string
+
+const (
+	Bazel     Kind = "bazel"
+	Pants     Kind = "pants"
+	Nx        Kind = "nx"
+	Turborepo Kind = "turborepo"
+)
+
+// markers are the files at the root of a repository that mark its task runner,
+// in the order they are looked for: a JavaScript runner may sit in a Bazel or
+// Pants repository, to manage its frontend, but not the other way around.
+var markers = []struct {
+	kind  Kind
+	files []string
+}{
+	{Bazel, []string{"MODULE.bazel", "WORKSPACE.bazel", "WORKSPACE"}},
+	{Pants, []string{"pants.toml"}},
+	{Nx, []string{"nx.json"}},
+	{Turborepo, []string{"turbo.json"}},
+}
+
+// A Runner is the task runner of a repository.
+type Runner struct {
+	Kind Kind
+	root string
+}
+
+// Detect returns the task runner of the repository at root, or nil if it has none.
+func Detect(root string) *Runner {
+	for _, m := range markers {
+		for _, f := range m.files {
+			if _, err := os.Stat(filepath.Join(root, f)); err == nil {
+				return &Runner{Kind: m.kind, root: root}
+			}
+		}
+	}
+	return nil
+}
+
+// command returns the command line that performs action ("build", "test" or "deps") on targets.
+func (r *Runner) command(action string, targets ...string) ([]string, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no target to %s", action)
+	}
+	if action == "deps" && len(targets) > 1 {
+		return nil, fmt.Errorf("deps takes one target")
+	}
+	switch r.Kind {
+	case Bazel:
+		switch action {
+		case "build", "test":
+			return append([]string{"bazel", action}, targets...), nil
+		case "deps":
+			return []string{"bazel", "query", "deps(" + targets[0] + ")", "--output=label"}, nil
+		}
+	case Pants:
+		switch action {
+		case "build":
+			return append([]string{"pants", "package"}, targets...), nil
+		case "test":
+			return append([]string{"pants", "test"}, targets...), nil
+		case "deps":
+			return []string{"pants", "dependencies", "--transitive", targets[0]}, nil
+		}
+	case Nx:
+		switch action {
+		case "build", "test":
+			if len(targets) == 1 && strings.Contains(targets[0], ":") {
+				return []string{"npx", "nx", "run", targets[0]}, nil
+			}
+			return []string{"npx", "nx", "run-many", "--target=" + action, "--projects=" + strings.Join(targets, ",")}, nil
+		case "deps":
+			project, _, _ := strings.Cut(targets[0], ":")
+			return []string{"npx", "nx", "graph", "--focus=" + project, "--file=stdout"}, nil
+		}
+	case Turborepo:
+		var filters []string
+		for _, t := range targets {
+			filters = append(filters, "--filter="+t)
+		}
+		switch action {
+		case "build", "test":
+			return append([]string{"npx", "turbo", "run", action}, filters...), nil
+		case "deps":
+			// Selecting a package with its dependencies lists them, without running anything.
+			return []string{"npx", "turbo", "run", "build", "--filter=" + targets[0] + "...", "--dry-run"}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown action %q: want build, test or deps", action)
+}
+
+// targetHelp describes the targets of each runner to the model.
+var targetHelp = map[Kind]string{
+	Bazel:     "a Bazel label or pattern, such as //server:api_test or //server/...",
+	Pants:     "a Pants address or spec, such as src/python/app:tests or src/python/app::",
+	Nx:        "an Nx project, such as api, or a project:target such as api:lint for other targets",
+	Turborepo: "a Turborepo package filter: a package name such as web, or a directory such as ./apps/web",
+}
+
+// Tool returns the task_runner tool, which runs r's tasks at the root of the repository.
+func (r *Runner) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name: "task_runner",
+		Description: fmt.Sprintf(`Build or test targets of this %s monorepo, or list a target's transitive dependencies, with the right %s command line.
+Prefer it to running %s through bash. Targets are %s.`, r.Kind, r.Kind, r.Kind, targetHelp[r.Kind]),
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "required": ["action", "target"],
+  "properties": {
+    "action": {
+      "type": "string",
+      "enum": ["build", "test", "deps"],
+      "description": "build or test the target, or list its transitive dependencies"
+    },
+    "target": {
+      "type": "string",
+      "description": "The target to act on"
+    },
+    "timeout": {
+      "type": "string",
+      "description": "Timeout as a Go duration string (default: 15m)"
+    }
+  }
+}`),
+		Run: func(ctx context.Context, m json.RawMessage) llm.ToolOut {
+			var input struct {
+				Action  string `json:"action"`
+				Target  string `json:"target"`
+				Timeout string `json:"timeout"`
+			}
+			if err := json.Unmarshal(m, &input); err != nil {
+				return llm.ErrorfToolOut("invalid input: %w", err)
+			}
+			timeout := 15 * time.Minute
+			if input.Timeout != "" {
+				d, err := time.ParseDuration(input.Timeout)
+				if err != nil {
+					return llm.ErrorfToolOut("invalid timeout %q: %w", input.Timeout, err)
+				}
+				timeout = d
+			}
+			args, err := r.command(input.Action, input.Target)
+			if err != nil {
+				return llm.ErrorToolOut(err)
+			}
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			out, err := run(ctx, r.root, args)
+			text := "$ " + strings.Join(args, " ") + "\n" + truncate(out)
+			if ctx.Err() != nil {
+				return llm.ErrorfToolOut("%s\ntimed out after %s", text, timeout)
+			}
+			if err != nil {
+				return llm.ErrorfToolOut("%s\n%w", text, err)
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent(text)}
+		},
+	}
+}
+
+// AffectedTests returns the test targets of the repository, checked out at dir,
+// that the changes since base, to the files changedFiles (relative to dir), affect.
+func (r *Runner) AffectedTests(ctx context.Context, dir, base string, changedFiles []string) ([]string, error) {
+	if len(changedFiles) == 0 {
+		return nil, nil
+	}
+	var args []string
+	switch r.Kind {
+	case Bazel:
+		args = []string{"bazel", "query", "--keep_going", "--output=label",
+			fmt.Sprintf("kind('.*_test rule', rdeps(//..., set(%s)))", strings.Join(changedFiles, " "))}
+	case Pants:
+		// pants test skips the targets it lists that aren't tests.
+		args = []string{"pants", "--changed-since=" + base, "--changed-dependents=transitive", "list"}
+	case Nx:
+		args = []string{"npx", "nx", "show", "projects", "--affected", "--base=" + base, "--head=HEAD", "--withTarget=test"}
+	case Turborepo:
+		args = []string{"npx", "turbo", "ls", "--filter=...[" + base + "]", "--output=json"}
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	// A Bazel query that can't place some of the files in a package still lists the rest.
+	if err != nil && !(r.Kind == Bazel && len(out) > 0) {
+		return nil, fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+	}
+	if r.Kind == Turborepo {
+		var ls struct {
+			Packages struct {
+				Items []struct {
+					Name string `json:"name"`
+				} `json:"items"`
+			} `json:"packages"`
+		}
+		if err := json.Unmarshal(out, &ls); err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+		}
+		var targets []string
+		for _, p := range ls.Packages.Items {
+			targets = append(targets, p.Name)
+		}
+		return targets, nil
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// Test runs the tests of targets in dir, and returns their output.
+func (r *Runner) Test(ctx context.Context, dir string, targets []string) (string, error) {
+	args, err := r.command("test", targets...)
+	if err != nil {
+		return "", err
+	}
+	out, err := run(ctx, dir, args)
+	return "$ " + strings.Join(args, " ") + "\n" + truncate(out), err
+}
+
+func run(ctx context.Context, dir string, args []string) (string, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "SKETCH_IGNORE_PORTS=1")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// maxOutput is how much of a command's output is kept; the end, where the failures are summarized.
+const maxOutput = 32 << 10
+
+func truncate(out string) string {
+	if len(out) <= maxOutput {
+		return out
+	}
+	return fmt.Sprintf("[%d bytes of output omitted]\n%s", len(out)-maxOutput, out[len(out)-maxOutput:])
+}
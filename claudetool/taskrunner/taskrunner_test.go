@@ -0,0 +1,112 @@
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	root := t.TempDir()
+	if r := Detect(root); r != nil {
+		t.Errorf("Detect in an empty repo = %v", r.Kind)
+	}
+	os.WriteFile(filepath.Join(root, "turbo.json"), []byte("{}"), 0o644)
+	if r := Detect(root); r == nil || r.Kind != Turborepo {
+		t.Errorf("Detect with turbo.json = %v", r)
+	}
+	// The frontend's runner in a Bazel repo isn't the repo's.
+	os.WriteFile(filepath.Join(root, "MODULE.bazel"), nil, 0o644)
+	if r := Detect(root); r == nil || r.Kind != Bazel {
+		t.Errorf("Detect with MODULE.bazel and turbo.json = %v", r)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	for _, tt := range []struct {
+		kind    Kind
+		action  string
+		targets []string
+		want    string
+	}{
+		{Bazel, "test", []string{"//a:t", "//b:t"}, "bazel test //a:t //b:t"},
+		{Bazel, "deps", []string{"//a"}, "bazel query deps(//a) --output=label"},
+		{Pants, "build", []string{"src/app:bin"}, "pants package src/app:bin"},
+		{Pants, "deps", []string{"src/app"}, "pants dependencies --transitive src/app"},
+		{Nx, "build", []string{"api"}, "npx nx run-many --target=build --projects=api"},
+		{Nx, "test", []string{"api", "web"}, "npx nx run-many --target=test --projects=api,web"},
+		{Nx, "build", []string{"api:lint"}, "npx nx run api:lint"},
+		{Nx, "deps", []string{"api:build"}, "npx nx graph --focus=api --file=stdout"},
+		{Turborepo, "test", []string{"web", "./packages/ui"}, "npx turbo run test --filter=web --filter=./packages/ui"},
+		{Turborepo, "deps", []string{"web"}, "npx turbo run build --filter=web... --dry-run"},
+	} {
+		r := &Runner{Kind: tt.kind}
+		args, err := r.command(tt.action, tt.targets...)
+		if err != nil || strings.Join(args, " ") != tt.want {
+			t.Errorf("%s %s %v = %q, %v; want %q", tt.kind, tt.action, tt.targets, args, err, tt.want)
+		}
+	}
+	r := &Runner{Kind: Bazel}
+	if _, err := r.command("deploy", "//a"); err == nil {
+		t.Error("command accepted deploy")
+	}
+	if _, err := r.command("test"); err == nil {
+		t.Error("command accepted no target")
+	}
+}
+
+// fakeRunner puts a program named name on PATH that prints output, after
+// recording its arguments in the returned file.
+func fakeRunner(t *testing.T, name, output string) string {
+	t.Helper()
+	bin := t.TempDir()
+	argsFile := filepath.Join(bin, "args")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsFile + "\ncat <<'EOF'\n" + output + "\nEOF\n"
+	if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestAffectedTests(t *testing.T) {
+	ctx := context.Background()
+	fakeRunner(t, "bazel", "//server:api_test\n//server:db_test")
+	r := &Runner{Kind: Bazel, root: t.TempDir()}
+	got, err := r.AffectedTests(ctx, r.root, "sketch-base", []string{"server/api.go"})
+	if err != nil || !slices.Equal(got, []string{"//server:api_test", "//server:db_test"}) {
+		t.Errorf("bazel AffectedTests = %q, %v", got, err)
+	}
+
+	argsFile := fakeRunner(t, "npx", `{"packageManager": "npm", "packages": {"count": 2, "items": [{"name": "web", "path": "apps/web"}, {"name": "ui", "path": "packages/ui"}]}}`)
+	r = &Runner{Kind: Turborepo, root: t.TempDir()}
+	got, err = r.AffectedTests(ctx, r.root, "sketch-base", []string{"packages/ui/button.tsx"})
+	if err != nil || !slices.Equal(got, []string{"web", "ui"}) {
+		t.Errorf("turborepo AffectedTests = %q, %v", got, err)
+	}
+	if args, _ := os.ReadFile(argsFile); !strings.Contains(string(args), "--filter=...[sketch-base]") {
+		t.Errorf("turbo ran with %q", args)
+	}
+	if got, err := r.AffectedTests(ctx, r.root, "sketch-base", nil); got != nil || err != nil {
+		t.Errorf("AffectedTests of no changes = %q, %v", got, err)
+	}
+}
+
+func TestTool(t *testing.T) {
+	argsFile := fakeRunner(t, "bazel", "INFO: Build completed successfully")
+	r := &Runner{Kind: Bazel, root: t.TempDir()}
+	out := r.Tool().Run(context.Background(), json.RawMessage(`{"action": "build", "target": "//server/..."}`))
+	if out.Error != nil || !strings.Contains(out.LLMContent[0].Text, "$ bazel build //server/...\nINFO: Build completed successfully") {
+		t.Errorf("tool output = %+v", out)
+	}
+	if args, _ := os.ReadFile(argsFile); string(args) != "build //server/...\n" {
+		t.Errorf("bazel ran with %q", args)
+	}
+	if out := r.Tool().Run(context.Background(), json.RawMessage(`{"action": "build", "target": "//x", "timeout": "soon"}`)); out.Error == nil {
+		t.Error("tool accepted a bad timeout")
+	}
+}
@@ -0,0 +1,137 @@
+package testreport
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	goResultRe  = regexp.MustCompile(`^(\s*)--- (FAIL|PASS|SKIP): (\S+) \(`)
+	goRunRe     = regexp.MustCompile(`^=== (?:RUN|CONT|NAME)\s+(\S+)`)
+	goPkgRe     = regexp.MustCompile(`^(ok|FAIL)\s+(\S+)\s+(\[build failed\]|\[setup failed\]|\(cached\)|[\d.]+s|\[no test files\])`)
+	goLogRe     = regexp.MustCompile(`^\s+([\w./-]+\.go:\d+): (.*)$`)
+	goCompileRe = regexp.MustCompile(`^([\w./-]+\.go:\d+)(?::\d+)?: (.*)$`)
+	goFrameRe   = regexp.MustCompile(`^\s+(\S+_test\.go:\d+)`)
+)
+
+// goLog is what a go test logged when it failed.
+type goLog struct {
+	location string
+	lines    []string
+}
+
+// parseGo parses the output of go test, with or without -v.
+func parseGo(output string) *Report {
+	r := &Report{Framework: "go"}
+	seen := false
+	logs := make(map[string]*goLog) // by test
+	running := ""                   // with -v, the test whose output follows
+	failed := -1                    // the failure whose output follows, without -v
+	failedIndent := 0
+	var pending []int // failures not yet assigned a package
+	var compile goLog // the first compile error since the last package line
+
+	addLog := func(name, line string, indent int) {
+		l := logs[name]
+		if l == nil {
+			m := goLogRe.FindStringSubmatch(line)
+			if m == nil {
+				return
+			}
+			l = &goLog{location: m[1]}
+			logs[name] = l
+			l.lines = append(l.lines, m[2])
+			return
+		}
+		l.lines = append(l.lines, strings.TrimPrefix(line, strings.Repeat(" ", indent)))
+	}
+
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		if m := goRunRe.FindStringSubmatch(line); m != nil {
+			running, failed = m[1], -1
+			continue
+		}
+		if m := goResultRe.FindStringSubmatch(line); m != nil {
+			seen = true
+			running, failed = "", -1
+			switch m[2] {
+			case "PASS":
+				r.Passed++
+				r.PassedNames = append(r.PassedNames, m[3])
+			case "SKIP":
+				r.Skipped++
+			case "FAIL":
+				r.Failures = append(r.Failures, Failure{Name: m[3]})
+				failed, failedIndent = len(r.Failures)-1, len(m[1])+4
+				pending = append(pending, failed)
+			}
+			continue
+		}
+		if m := goPkgRe.FindStringSubmatch(line); m != nil {
+			seen = true
+			running, failed = "", -1
+			pkg := m[2]
+			switch {
+			case m[1] == "ok":
+				r.PassedNames = append(r.PassedNames, pkg)
+			case len(pending) == 0:
+				// The package failed without a failing test: it didn't build, or it panicked outside tests.
+				r.Failures = append(r.Failures, Failure{Name: pkg, Package: pkg, Location: compile.location, Message: firstLines(compile.lines)})
+			}
+			for _, j := range pending {
+				r.Failures[j].Package = pkg
+			}
+			pending, compile = nil, goLog{}
+			continue
+		}
+		if strings.HasPrefix(line, "panic: ") {
+			name := running
+			if failed >= 0 {
+				name = r.Failures[failed].Name
+			}
+			if name != "" && logs[name] == nil {
+				l := &goLog{lines: []string{line}}
+				for _, next := range lines[i+1:] {
+					if m := goFrameRe.FindStringSubmatch(next); m != nil {
+						l.location = m[1]
+						break
+					}
+				}
+				logs[name] = l
+			}
+			continue
+		}
+		if line != "" && line[0] != ' ' && line[0] != '\t' && compile.location == "" {
+			if m := goCompileRe.FindStringSubmatch(line); m != nil {
+				compile = goLog{location: m[1], lines: []string{m[2]}}
+				continue
+			}
+		}
+		switch {
+		case failed >= 0 && strings.HasPrefix(line, strings.Repeat(" ", failedIndent)):
+			addLog(r.Failures[failed].Name, line, failedIndent)
+		case running != "" && strings.HasPrefix(line, "    "):
+			addLog(running, line, strings.Count(running, "/")*4+4)
+		}
+	}
+	if !seen {
+		return nil
+	}
+
+	for j := range r.Failures {
+		f := &r.Failures[j]
+		if l := logs[f.Name]; l != nil {
+			f.Location, f.Message = l.location, firstLines(l.lines)
+		}
+	}
+	// A test fails when its subtests do; the subtests say why.
+	r.Failures = slices.DeleteFunc(r.Failures, func(f Failure) bool {
+		return f.Message == "" && slices.ContainsFunc(r.Failures, func(sub Failure) bool {
+			return strings.HasPrefix(sub.Name, f.Name+"/")
+		})
+	})
+	r.Failed = len(r.Failures)
+	return r
+}
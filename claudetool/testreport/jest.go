@@ -0,0 +1,94 @@
+package testreport
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	jestTestsRe  = regexp.MustCompile(`^Tests:\s+(.*\d+ total)`)
+	jestCountRe  = regexp.MustCompile(`(\d+) (failed|passed|skipped)`)
+	jestSuiteRe  = regexp.MustCompile(`^(?:FAIL|PASS) (\S+)`)
+	jestHeaderRe = regexp.MustCompile(`^\s*● (.+)$`)
+	jestAtRe     = regexp.MustCompile(`^\s*at .*?\(?([^()\s]+\.[cm]?[jt]sx?:\d+):\d+\)?$`)
+	jestFrameRe  = regexp.MustCompile(`^\s*>?\s*\d+ \|`)
+	jestPassRe   = regexp.MustCompile(`^\s+[✓√] (.+?)(?: \(\d+ ms\))?$`)
+)
+
+// parseJest parses the output of jest, and of vitest where it matches.
+func parseJest(output string) *Report {
+	r := &Report{Framework: "jest"}
+	seen := false
+	suite := ""
+	seenNames := make(map[string]bool)
+	var cur *Failure
+	var msg []string
+	done := false // cur's message is complete
+	flush := func() {
+		if cur != nil {
+			cur.Message = firstLines(msg)
+			r.Failures = append(r.Failures, *cur)
+		}
+		cur, msg, done = nil, nil, false
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := jestTestsRe.FindStringSubmatch(line); m != nil {
+			flush()
+			seen = true
+			for _, c := range jestCountRe.FindAllStringSubmatch(m[1], -1) {
+				n, _ := strconv.Atoi(c[1])
+				switch c[2] {
+				case "failed":
+					r.Failed = n
+				case "passed":
+					r.Passed = n
+				case "skipped":
+					r.Skipped = n
+				}
+			}
+			continue
+		}
+		if m := jestSuiteRe.FindStringSubmatch(line); m != nil {
+			flush()
+			suite = m[1]
+			continue
+		}
+		if m := jestHeaderRe.FindStringSubmatch(line); m != nil {
+			flush()
+			// Jest repeats the failures in its summary.
+			if !seenNames[m[1]] {
+				seenNames[m[1]] = true
+				cur = &Failure{Name: m[1], Package: suite}
+			}
+			continue
+		}
+		if m := jestPassRe.FindStringSubmatch(line); m != nil && cur == nil {
+			r.PassedNames = append(r.PassedNames, m[1])
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		if m := jestAtRe.FindStringSubmatch(line); m != nil {
+			if cur.Location == "" && !strings.Contains(m[1], "node_modules") {
+				cur.Location = m[1]
+			}
+			done = true
+			continue
+		}
+		if done || jestFrameRe.MatchString(line) {
+			done = true
+			continue
+		}
+		if strings.TrimSpace(line) != "" || len(msg) > 0 {
+			msg = append(msg, strings.TrimPrefix(line, "    "))
+		}
+	}
+	flush()
+	if !seen {
+		return nil
+	}
+	return r
+}
@@ -0,0 +1,112 @@
+package testreport
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	pytestSummaryRe = regexp.MustCompile(`^=+ (.*\b(?:passed|failed|errors?|skipped)\b.*) in [\d.]+s.* =+$`)
+	pytestCountRe   = regexp.MustCompile(`(\d+) (passed|failed|skipped|errors?)`)
+	pytestHeaderRe  = regexp.MustCompile(`^=+ (.+?) =+$`)
+	pytestSectionRe = regexp.MustCompile(`^_{3,} (.+?) _{3,}$`)
+	pytestShortRe   = regexp.MustCompile(`^(?:FAILED|ERROR) (\S+)(?: - (.*))?$`)
+	pytestLocRe     = regexp.MustCompile(`^(\S+\.py:\d+): `)
+	pytestPassRe    = regexp.MustCompile(`^(\S+::\S+) PASSED`)
+)
+
+// parsePytest parses the output of pytest.
+func parsePytest(output string) *Report {
+	r := &Report{Framework: "pytest"}
+	seen := false
+	sections := make(map[string]*Failure) // by section title, e.g. "TestThing.test_x"
+	var order []string
+	var cur *Failure
+	var msg []string
+	inFailures := false
+	flush := func() {
+		if cur != nil {
+			cur.Message = firstLines(msg)
+		}
+		cur, msg = nil, nil
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if m := pytestSummaryRe.FindStringSubmatch(line); m != nil {
+			flush()
+			seen = true
+			for _, c := range pytestCountRe.FindAllStringSubmatch(m[1], -1) {
+				n, _ := strconv.Atoi(c[1])
+				switch c[2] {
+				case "passed":
+					r.Passed = n
+				case "skipped":
+					r.Skipped = n
+				default:
+					r.Failed += n
+				}
+			}
+			continue
+		}
+		if m := pytestHeaderRe.FindStringSubmatch(line); m != nil {
+			flush()
+			inFailures = m[1] == "FAILURES" || m[1] == "ERRORS"
+			continue
+		}
+		if m := pytestShortRe.FindStringSubmatch(line); m != nil {
+			f := Failure{Name: m[1], Message: m[2]}
+			f.Package, _, _ = strings.Cut(m[1], "::")
+			if s := sections[pytestTitle(m[1])]; s != nil {
+				f.Location = s.Location
+				if s.Message != "" {
+					f.Message = s.Message
+				}
+				delete(sections, pytestTitle(m[1]))
+			}
+			r.Failures = append(r.Failures, f)
+			continue
+		}
+		if m := pytestPassRe.FindStringSubmatch(line); m != nil {
+			r.PassedNames = append(r.PassedNames, m[1])
+			continue
+		}
+		if !inFailures {
+			continue
+		}
+		if m := pytestSectionRe.FindStringSubmatch(line); m != nil {
+			flush()
+			cur = &Failure{Name: m[1]}
+			sections[m[1]] = cur
+			order = append(order, m[1])
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		if strings.HasPrefix(line, "E ") {
+			// pytest indents the messages it explains to column 8.
+			msg = append(msg, strings.TrimPrefix(line[1:], "       "))
+		} else if m := pytestLocRe.FindStringSubmatch(line); m != nil && cur.Location == "" {
+			cur.Location = m[1]
+		}
+	}
+	flush()
+	if !seen {
+		return nil
+	}
+	// Without the short summary, the failure sections are all there is.
+	for _, title := range order {
+		if s := sections[title]; s != nil {
+			r.Failures = append(r.Failures, *s)
+		}
+	}
+	return r
+}
+
+// pytestTitle returns the title pytest gives the failure section of the test with nodeid,
+// e.g. "TestThing.test_x" for "tests/test_thing.py::TestThing::test_x".
+func pytestTitle(nodeid string) string {
+	_, rest, _ := strings.Cut(nodeid, "::")
+	return strings.ReplaceAll(rest, "::", ".")
+}
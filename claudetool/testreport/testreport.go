@@ -0,0 +1,113 @@
+// Package testreport turns the output of test runs into structured summaries
+// of what failed: the failing tests, where, and the first assertion message of each.
+// It understands go test, jest, and pytest output.
+package testreport
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A Report summarizes one test run.
+type Report struct {
+	Framework string    `json:"framework"` // "go", "jest" or "pytest"
+	Passed    int       `json:"passed"`
+	Failed    int       `json:"failed"`
+	Skipped   int       `json:"skipped,omitempty"`
+	Failures  []Failure `json:"failures,omitempty"`
+	// PassedNames are the tests, or for go the packages, the output reports passing.
+	PassedNames []string `json:"passed_names,omitempty"`
+	// RawID names the stored raw output; see Save.
+	RawID    string `json:"raw_id,omitempty"`
+	RawBytes int    `json:"raw_bytes,omitempty"`
+}
+
+// A Failure is a failed test, or a package that failed to build.
+type Failure struct {
+	Name     string `json:"name"`
+	Package  string `json:"package,omitempty"`  // go package, or the test file
+	Location string `json:"location,omitempty"` // file:line of the first failure message
+	Message  string `json:"message,omitempty"`  // the first failure message, a few lines at most
+}
+
+// maxMessageLines caps Failure.Message.
+const maxMessageLines = 12
+
+// Parse returns a report of the test run in output, or nil if output isn't from a test run it knows.
+func Parse(output string) *Report {
+	for _, parse := range []func(string) *Report{parseGo, parsePytest, parseJest} {
+		if r := parse(output); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// String renders r compactly, for the model.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s tests: %d failed, %d passed", r.Framework, r.Failed, r.Passed)
+	if r.Skipped > 0 {
+		fmt.Fprintf(&b, ", %d skipped", r.Skipped)
+	}
+	for _, f := range r.Failures {
+		b.WriteString("\n\nFAIL " + f.Name)
+		if f.Package != "" && f.Package != f.Name {
+			b.WriteString(" (" + f.Package + ")")
+		}
+		if f.Location != "" {
+			b.WriteString(" at " + f.Location)
+		}
+		if f.Message != "" {
+			b.WriteString("\n" + indent(f.Message))
+		}
+	}
+	return b.String()
+}
+
+func indent(s string) string {
+	return "    " + strings.ReplaceAll(s, "\n", "\n    ")
+}
+
+// firstLines returns the first lines of s, at most maxMessageLines of them.
+func firstLines(lines []string) string {
+	if len(lines) > maxMessageLines {
+		lines = append(lines[:maxMessageLines:maxMessageLines], "…")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Dir is where Save stores raw test output.
+func Dir() string {
+	return filepath.Join(os.TempDir(), "sketch-test-output")
+}
+
+var rawIDRe = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Save stores the raw output of r's run, so that a UI can show it on demand,
+// and sets r.RawID. It returns the path of the stored output.
+func (r *Report) Save(output string) (string, error) {
+	var id [8]byte
+	rand.Read(id[:])
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return "", err
+	}
+	r.RawID = hex.EncodeToString(id[:])
+	r.RawBytes = len(output)
+	path := RawPath(r.RawID)
+	return path, os.WriteFile(path, []byte(output), 0o600)
+}
+
+// RawPath returns the path of the raw output Save stored with id,
+// or "" if id can't be one.
+func RawPath(id string) string {
+	if !rawIDRe.MatchString(id) {
+		return ""
+	}
+	return filepath.Join(Dir(), id+".txt")
+}
@@ -0,0 +1,193 @@
+package testreport
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseGo(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		want   []Failure
+		passed []string
+	}{
+		{
+			name: "plain",
+			output: `--- FAIL: TestAdd (0.00s)
+    add_test.go:12: Add(1, 2) = 4, want 3
+        extra detail
+--- FAIL: TestTable (0.00s)
+    --- FAIL: TestTable/negative (0.00s)
+        table_test.go:30: got -1
+FAIL
+FAIL	example.com/calc	0.004s
+ok  	example.com/util	(cached)
+FAIL
+`,
+			want: []Failure{
+				{Name: "TestAdd", Package: "example.com/calc", Location: "add_test.go:12", Message: "Add(1, 2) = 4, want 3\n    extra detail"},
+				{Name: "TestTable/negative", Package: "example.com/calc", Location: "table_test.go:30", Message: "got -1"},
+			},
+			passed: []string{"example.com/util"},
+		},
+		{
+			name: "verbose",
+			output: `=== RUN   TestAdd
+    add_test.go:12: Add(1, 2) = 4, want 3
+--- FAIL: TestAdd (0.00s)
+=== RUN   TestSub
+--- PASS: TestSub (0.00s)
+FAIL
+FAIL	example.com/calc	0.004s
+`,
+			want:   []Failure{{Name: "TestAdd", Package: "example.com/calc", Location: "add_test.go:12", Message: "Add(1, 2) = 4, want 3"}},
+			passed: []string{"TestSub"},
+		},
+		{
+			name: "panic",
+			output: `--- FAIL: TestBoom (0.00s)
+panic: boom [recovered]
+	panic: boom
+
+goroutine 7 [running]:
+testing.tRunner.func1.2({0x1, 0x2})
+	/usr/local/go/src/testing/testing.go:1734 +0x21c
+example.com/calc.TestBoom(0x0?)
+	/src/calc/boom_test.go:8 +0x25
+FAIL	example.com/calc	0.005s
+`,
+			want: []Failure{{Name: "TestBoom", Package: "example.com/calc", Location: "/src/calc/boom_test.go:8", Message: "panic: boom [recovered]"}},
+		},
+		{
+			name: "build failure",
+			output: `# example.com/calc
+./calc.go:3:9: undefined: y
+FAIL	example.com/calc [build failed]
+`,
+			want: []Failure{{Name: "example.com/calc", Package: "example.com/calc", Location: "./calc.go:3", Message: "undefined: y"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Parse(tc.output)
+			if r == nil || r.Framework != "go" {
+				t.Fatalf("Parse = %+v", r)
+			}
+			if len(r.Failures) != len(tc.want) || r.Failed != len(tc.want) {
+				t.Fatalf("failures = %+v", r.Failures)
+			}
+			for i, f := range r.Failures {
+				if f != tc.want[i] {
+					t.Errorf("failure %d = %+v\nwant %+v", i, f, tc.want[i])
+				}
+			}
+			if strings.Join(r.PassedNames, ",") != strings.Join(tc.passed, ",") {
+				t.Errorf("passed = %q, want %q", r.PassedNames, tc.passed)
+			}
+		})
+	}
+}
+
+func TestParsePytest(t *testing.T) {
+	output := `============================= test session starts ==============================
+collected 3 items
+
+tests/test_calc.py .F.                                                   [100%]
+
+=================================== FAILURES ===================================
+____________________________ TestCalc.test_divide _____________________________
+
+self = <tests.test_calc.TestCalc object at 0x7f>
+
+    def test_divide(self):
+>       assert divide(4, 2) == 3
+E       assert 2.0 == 3
+E        +  where 2.0 = divide(4, 2)
+
+tests/test_calc.py:9: AssertionError
+=========================== short test summary info ============================
+FAILED tests/test_calc.py::TestCalc::test_divide - assert 2.0 == 3
+========================= 1 failed, 2 passed in 0.03s ==========================
+`
+	r := Parse(output)
+	if r == nil || r.Framework != "pytest" || r.Failed != 1 || r.Passed != 2 {
+		t.Fatalf("Parse = %+v", r)
+	}
+	want := Failure{
+		Name:     "tests/test_calc.py::TestCalc::test_divide",
+		Package:  "tests/test_calc.py",
+		Location: "tests/test_calc.py:9",
+		Message:  "assert 2.0 == 3\n +  where 2.0 = divide(4, 2)",
+	}
+	if len(r.Failures) != 1 || r.Failures[0] != want {
+		t.Errorf("failures = %+v\nwant %+v", r.Failures, want)
+	}
+}
+
+func TestParseJest(t *testing.T) {
+	output := `FAIL src/sum.test.js
+  sum
+    ✓ adds positives (2 ms)
+    ✕ adds negatives (3 ms)
+
+  ● sum › adds negatives
+
+    expect(received).toBe(expected) // Object.is equality
+
+    Expected: -3
+    Received: 3
+
+      4 |
+      5 | test('adds negatives', () => {
+    > 6 |   expect(sum(-1, -2)).toBe(-3);
+        |                       ^
+
+      at Object.<anonymous> (src/sum.test.js:6:23)
+
+Test Suites: 1 failed, 1 total
+Tests:       1 failed, 1 passed, 2 total
+`
+	r := Parse(output)
+	if r == nil || r.Framework != "jest" || r.Failed != 1 || r.Passed != 1 {
+		t.Fatalf("Parse = %+v", r)
+	}
+	want := Failure{
+		Name:     "sum › adds negatives",
+		Package:  "src/sum.test.js",
+		Location: "src/sum.test.js:6",
+		Message:  "expect(received).toBe(expected) // Object.is equality\n\nExpected: -3\nReceived: 3",
+	}
+	if len(r.Failures) != 1 || r.Failures[0] != want {
+		t.Errorf("failures = %+v\nwant %+v", r.Failures, want)
+	}
+	if len(r.PassedNames) != 1 || r.PassedNames[0] != "adds positives" {
+		t.Errorf("passed = %q", r.PassedNames)
+	}
+}
+
+func TestParseOther(t *testing.T) {
+	for _, output := range []string{"", "hello\nworld\n", "FAIL: this is not a test run"} {
+		if r := Parse(output); r != nil {
+			t.Errorf("Parse(%q) = %+v", output, r)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	r := &Report{Framework: "go"}
+	path, err := r.Save("raw output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if RawPath(r.RawID) != path || r.RawBytes != len("raw output") {
+		t.Errorf("RawPath(%q) = %q, Save stored %q", r.RawID, RawPath(r.RawID), path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "raw output" {
+		t.Errorf("stored %q, %v", data, err)
+	}
+	if RawPath("../etc/passwd") != "" {
+		t.Error("RawPath accepted a path")
+	}
+}
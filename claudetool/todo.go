@@ -138,39 +138,74 @@ func todoWriteRun(ctx context.Context, m json.RawMessage) llm.ToolOut {
 	if err := json.Unmarshal(m, &input); err != nil {
 		return llm.ErrorfToolOut("invalid input: %w", err)
 	}
+	if err := WriteTodoList(SessionID(ctx), TodoList{Items: input.Tasks}); err != nil {
+		return llm.ErrorToolOut(err)
+	}
+
+	result := fmt.Sprintf("Updated todo list with %d items.", len(input.Tasks))
+
+	return llm.ToolOut{LLMContent: llm.TextContent(result)}
+}
 
-	// Validate that only one task is in-progress
+// Validate checks that l could have been written by todo_write.
+func (l TodoList) Validate() error {
 	inProgressCount := 0
-	for _, task := range input.Tasks {
-		if task.Status == "in-progress" {
+	ids := make(map[string]bool)
+	for _, item := range l.Items {
+		switch {
+		case item.ID == "":
+			return fmt.Errorf("task %q has no id", item.Task)
+		case ids[item.ID]:
+			return fmt.Errorf("duplicate task id %q", item.ID)
+		case item.Status != "queued" && item.Status != "in-progress" && item.Status != "completed":
+			return fmt.Errorf("task %q has status %q; want queued, in-progress, or completed", item.ID, item.Status)
+		}
+		ids[item.ID] = true
+		if item.Status == "in-progress" {
 			inProgressCount++
 		}
 	}
-	switch {
-	case inProgressCount > 1:
-		return llm.ErrorfToolOut("only one task can be 'in-progress' at a time, found %d", inProgressCount)
+	if inProgressCount > 1 {
+		return fmt.Errorf("only one task can be 'in-progress' at a time, found %d", inProgressCount)
 	}
+	return nil
+}
 
-	todoList := TodoList{
-		Items: input.Tasks,
+// ReadTodoList returns the todo list of the given session, which is empty
+// if the session has none yet.
+func ReadTodoList(sessionID string) (TodoList, error) {
+	var todoList TodoList
+	content, err := os.ReadFile(TodoFilePath(sessionID))
+	if os.IsNotExist(err) {
+		return todoList, nil
 	}
+	if err != nil {
+		return todoList, fmt.Errorf("failed to read todo file: %w", err)
+	}
+	if err := json.Unmarshal(content, &todoList); err != nil {
+		return todoList, fmt.Errorf("failed to parse todo file: %w", err)
+	}
+	return todoList, nil
+}
 
-	todoPath := todoFilePathForContext(ctx)
+// WriteTodoList validates todoList and makes it the given session's todo list.
+func WriteTodoList(sessionID string, todoList TodoList) error {
+	if err := todoList.Validate(); err != nil {
+		return err
+	}
+	todoPath := TodoFilePath(sessionID)
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(todoPath), 0o700); err != nil {
-		return llm.ErrorfToolOut("failed to create todo directory: %w", err)
+		return fmt.Errorf("failed to create todo directory: %w", err)
 	}
 
 	content, err := json.Marshal(todoList)
 	if err != nil {
-		return llm.ErrorfToolOut("failed to marshal todo list: %w", err)
+		return fmt.Errorf("failed to marshal todo list: %w", err)
 	}
 
 	if err := os.WriteFile(todoPath, content, 0o600); err != nil {
-		return llm.ErrorfToolOut("failed to write todo file: %w", err)
+		return fmt.Errorf("failed to write todo file: %w", err)
 	}
-
-	result := fmt.Sprintf("Updated todo list with %d items.", len(input.Tasks))
-
-	return llm.ToolOut{LLMContent: llm.TextContent(result)}
+	return nil
 }
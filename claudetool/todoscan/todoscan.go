@@ -0,0 +1,298 @@
+// Package todoscan finds TODO and FIXME comments the agent adds, tracks them
+// on the session todo list, and reports the ones the user was never told about.
+package todoscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm"
+)
+
+// A Marker is a TODO-style comment.
+type Marker struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Kind string `json:"kind"` // TODO, FIXME, XXX or HACK
+	Text string `json:"text"`
+}
+
+// markerRe matches a marker at the start of a comment, in any of the common comment syntaxes.
+var markerRe = regexp.MustCompile(`(?://|#|/\*|<!--|--|;|\*)\s*(TODO|FIXME|XXX|HACK)\b(?:\([^)]*\))?:?\s*(.*)`)
+
+// todoIDPrefix marks the todo list items that track markers.
+const todoIDPrefix = "marker-"
+
+// maxRepoMarkers caps the markers a repo scan reports.
+const maxRepoMarkers = 200
+
+func parseMarker(file string, line int, s string) (Marker, bool) {
+	m := markerRe.FindStringSubmatch(s)
+	if m == nil {
+		return Marker{}, false
+	}
+	text := strings.TrimSpace(m[2])
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(text, "*/"), "-->"))
+	return Marker{File: file, Line: line, Kind: m[1], Text: text}, true
+}
+
+// todoID is the id of the todo list item tracking m. It leaves out the line
+// number, which shifts as the file is edited.
+func (m Marker) todoID() string {
+	h := sha256.Sum256([]byte(m.File + "\x00" + m.Kind + "\x00" + m.Text))
+	return todoIDPrefix + hex.EncodeToString(h[:])[:10]
+}
+
+func (m Marker) String() string {
+	return fmt.Sprintf("%s:%d: %s %s", m.File, m.Line, m.Kind, m.Text)
+}
+
+// A Scanner finds markers in a repository.
+type Scanner struct {
+	repoRoot      string
+	sketchBaseRef string
+}
+
+// NewScanner creates a Scanner for the repository at repoRoot.
+// Markers are introduced if they are new since sketchBaseRef.
+func NewScanner(repoRoot, sketchBaseRef string) *Scanner {
+	return &Scanner{repoRoot: repoRoot, sketchBaseRef: sketchBaseRef}
+}
+
+// Introduced returns the markers added since the sketch base, including uncommitted ones.
+func (s *Scanner) Introduced(ctx context.Context) ([]Marker, error) {
+	cmd := exec.CommandContext(ctx, "git", "diff", "-U0", "--no-color", "--no-ext-diff", s.sketchBaseRef)
+	cmd.Dir = s.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	return parseDiff(out), nil
+}
+
+// parseDiff returns the markers on the added lines of a -U0 diff.
+func parseDiff(diff []byte) []Marker {
+	var markers []Marker
+	var file string
+	var line int
+	sc := bufio.NewScanner(bytes.NewReader(diff))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		l := sc.Text()
+		switch {
+		case strings.HasPrefix(l, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(l, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = ""
+			}
+		case strings.HasPrefix(l, "@@ "):
+			// @@ -a,b +c,d @@
+			fields := strings.Fields(l)
+			if len(fields) >= 3 {
+				start, _, _ := strings.Cut(strings.TrimPrefix(fields[2], "+"), ",")
+				line, _ = strconv.Atoi(start)
+			}
+		case strings.HasPrefix(l, "+") && file != "":
+			if m, ok := parseMarker(file, line, l[1:]); ok {
+				markers = append(markers, m)
+			}
+			line++
+		}
+	}
+	return markers
+}
+
+// InRepo returns the markers under path, relative to the repository root,
+// whoever added them.
+func (s *Scanner) InRepo(ctx context.Context, path string) ([]Marker, error) {
+	args := []string{"grep", "-n", "-I", "--no-color", "-e", "TODO", "-e", "FIXME", "-e", "XXX", "-e", "HACK"}
+	if path != "" {
+		args = append(args, "--", path)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = s.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return nil, nil // no matches
+		}
+		return nil, fmt.Errorf("git grep: %w", err)
+	}
+	var markers []Marker
+	for l := range strings.SplitSeq(strings.TrimSpace(string(out)), "\n") {
+		file, rest, _ := strings.Cut(l, ":")
+		num, text, _ := strings.Cut(rest, ":")
+		n, _ := strconv.Atoi(num)
+		if m, ok := parseMarker(file, n, text); ok {
+			markers = append(markers, m)
+		}
+		if len(markers) == maxRepoMarkers {
+			break
+		}
+	}
+	return markers, nil
+}
+
+// Track adds markers to the session todo list as queued tasks, and completes
+// the tasks of earlier markers that are gone. It returns how many tasks it added.
+func Track(sessionID string, markers []Marker) (int, error) {
+	list, err := claudetool.ReadTodoList(sessionID)
+	if err != nil {
+		return 0, err
+	}
+	current := make(map[string]bool)
+	for _, m := range markers {
+		current[m.todoID()] = true
+	}
+	existing := make(map[string]bool)
+	for i, item := range list.Items {
+		existing[item.ID] = true
+		if strings.HasPrefix(item.ID, todoIDPrefix) && !current[item.ID] {
+			list.Items[i].Status = "completed"
+		}
+	}
+	added := 0
+	for _, m := range markers {
+		if existing[m.todoID()] {
+			continue
+		}
+		existing[m.todoID()] = true
+		list.Items = append(list.Items, claudetool.TodoItem{
+			ID:     m.todoID(),
+			Task:   fmt.Sprintf("Resolve %s in %s: %s", m.Kind, m.File, m.Text),
+			Status: "queued",
+		})
+		added++
+	}
+	return added, claudetool.WriteTodoList(sessionID, list)
+}
+
+// Unmentioned returns the introduced markers that neither the todo list nor the
+// texts (typically the agent's messages to the user) mention.
+func (s *Scanner) Unmentioned(ctx context.Context, sessionID string, texts []string) ([]Marker, error) {
+	markers, err := s.Introduced(ctx)
+	if err != nil || len(markers) == 0 {
+		return nil, err
+	}
+	list, err := claudetool.ReadTodoList(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range list.Items {
+		texts = append(texts, item.Task)
+	}
+	tracked := make(map[string]bool)
+	for _, item := range list.Items {
+		tracked[item.ID] = true
+	}
+	var unmentioned []Marker
+	for _, m := range markers {
+		if tracked[m.todoID()] || mentioned(m, texts) {
+			continue
+		}
+		unmentioned = append(unmentioned, m)
+	}
+	return unmentioned, nil
+}
+
+func mentioned(m Marker, texts []string) bool {
+	if m.Text == "" {
+		return false
+	}
+	for _, t := range texts {
+		if strings.Contains(t, m.Text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tool returns the todo_scan tool.
+func (s *Scanner) Tool() *llm.Tool {
+	return &llm.Tool{
+		Name: "todo_scan",
+		Description: `Find TODO, FIXME, XXX and HACK comments. With scope "diff" (the default), lists the ones your changes add and tracks them on the todo list until they're gone.
+With scope "repo", lists the existing ones under path, e.g. to check for related work.`,
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "properties": {
+    "scope": {"type": "string", "enum": ["diff", "repo"]},
+    "path": {"type": "string", "description": "directory or file to limit a repo scan to, relative to the repository root"}
+  }
+}`),
+		Run: s.run,
+	}
+}
+
+func (s *Scanner) run(ctx context.Context, m json.RawMessage) llm.ToolOut {
+	var input struct {
+		Scope string `json:"scope"`
+		Path  string `json:"path"`
+	}
+	if len(m) > 0 {
+		if err := json.Unmarshal(m, &input); err != nil {
+			return llm.ErrorfToolOut("invalid input: %w", err)
+		}
+	}
+	switch input.Scope {
+	case "", "diff":
+		markers, err := s.Introduced(ctx)
+		if err != nil {
+			return llm.ErrorToolOut(err)
+		}
+		added, err := Track(claudetool.SessionID(ctx), markers)
+		if err != nil {
+			return llm.ErrorToolOut(err)
+		}
+		if len(markers) == 0 {
+			return llm.ToolOut{LLMContent: llm.TextContent("Your changes add no TODO-style comments."), Display: markers}
+		}
+		text := fmt.Sprintf("Your changes add %d TODO-style comments (%d newly added to the todo list):\n%s", len(markers), added, formatMarkers(markers))
+		return llm.ToolOut{LLMContent: llm.TextContent(text), Display: markers}
+	case "repo":
+		markers, err := s.InRepo(ctx, input.Path)
+		if err != nil {
+			return llm.ErrorToolOut(err)
+		}
+		if len(markers) == 0 {
+			return llm.ToolOut{LLMContent: llm.TextContent("No TODO-style comments found."), Display: markers}
+		}
+		text := formatMarkers(markers)
+		if len(markers) == maxRepoMarkers {
+			text += fmt.Sprintf("\n(stopped after %d; narrow the path to see more)", maxRepoMarkers)
+		}
+		return llm.ToolOut{LLMContent: llm.TextContent(text), Display: markers}
+	default:
+		return llm.ErrorfToolOut("unknown scope %q: want diff or repo", input.Scope)
+	}
+}
+
+// formatMarkers lists markers one per line.
+func formatMarkers(markers []Marker) string {
+	var b strings.Builder
+	for _, m := range markers {
+		b.WriteString("- ")
+		b.WriteString(m.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Warning describes unmentioned markers for the done tool, or returns "" if there are none.
+func Warning(markers []Marker) string {
+	if len(markers) == 0 {
+		return ""
+	}
+	return "Warning: your changes leave TODO-style comments you haven't mentioned to the user:\n" + formatMarkers(markers) +
+		"Finish them, or tell the user about them in your summary."
+}
@@ -0,0 +1,113 @@
+package todoscan
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sketch.dev/claudetool"
+)
+
+func TestParseDiff(t *testing.T) {
+	diff := `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -10,0 +11,3 @@ func main() {
++	// TODO: handle the error
++	x := 1 // not a todo: TODOS is a word here
++	/* FIXME(josh): leaks */
+@@ -40 +43 @@
+-	// TODO: old
++	// XXX stays hacky
+diff --git a/gone.py b/gone.py
+--- a/gone.py
++++ /dev/null
+@@ -1 +0,0 @@
+-# TODO: removed
+`
+	got := parseDiff([]byte(diff))
+	want := []Marker{
+		{File: "main.go", Line: 11, Kind: "TODO", Text: "handle the error"},
+		{File: "main.go", Line: 13, Kind: "FIXME", Text: "leaks"},
+		{File: "main.go", Line: 43, Kind: "XXX", Text: "stays hacky"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseDiff =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestTrackAndUnmentioned(t *testing.T) {
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "--allow-empty", "-m", "base")
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n\n// TODO: validate input\n// FIXME: racy\n"), 0o644)
+	git("add", "a.go")
+
+	sessionID := "todoscan-test-" + filepath.Base(dir)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(claudetool.TodoFilePath(sessionID))) })
+	s := NewScanner(dir, "HEAD")
+	ctx := context.Background()
+
+	unmentioned, err := s.Unmentioned(ctx, sessionID, []string{"I left a note: racy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unmentioned) != 1 || unmentioned[0].Text != "validate input" {
+		t.Fatalf("Unmentioned = %+v, want only the TODO", unmentioned)
+	}
+
+	markers, err := s.Introduced(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added, err := Track(sessionID, markers); err != nil || added != 2 {
+		t.Fatalf("Track = %d, %v; want 2 added", added, err)
+	}
+	if added, err := Track(sessionID, markers); err != nil || added != 0 {
+		t.Fatalf("Track again = %d, %v; want none added", added, err)
+	}
+	if unmentioned, err := s.Unmentioned(ctx, sessionID, nil); err != nil || len(unmentioned) != 0 {
+		t.Errorf("Unmentioned after tracking = %+v, %v", unmentioned, err)
+	}
+
+	// Resolving a marker completes its task.
+	if _, err := Track(sessionID, markers[1:]); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := claudetool.ReadTodoList(sessionID)
+	if len(list.Items) != 2 || list.Items[0].Status != "completed" || list.Items[1].Status != "queued" {
+		t.Errorf("todo list = %+v", list.Items)
+	}
+}
+
+func TestInRepo(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command("git", "init", "-q")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "sub", "x.sh"), []byte("echo hi\n# HACK: works around a bug\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "y.txt"), []byte("TODO without a comment\n"), 0o644)
+	exec.Command("git", "-C", dir, "add", ".").Run()
+
+	got, err := NewScanner(dir, "HEAD").InRepo(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Marker{{File: "sub/x.sh", Line: 2, Kind: "HACK", Text: "works around a bug"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("InRepo = %+v, want %+v", got, want)
+	}
+}
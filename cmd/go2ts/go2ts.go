@@ -12,10 +12,20 @@ import (
 	"os"
 
 	"go.skia.org/infra/go/go2ts"
+	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/claudetool/browse"
+	"sketch.dev/claudetool/depaudit"
+	"sketch.dev/claudetool/mergequeue"
+	"sketch.dev/claudetool/testreport"
+	"sketch.dev/devcontainer"
 	"sketch.dev/git_tools"
 	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
 	"sketch.dev/loop/server"
+	"sketch.dev/netpolicy"
+	"sketch.dev/onboarding"
+	"sketch.dev/skabandclient"
 )
 
 func main() {
@@ -51,26 +61,99 @@ func TS() *go2ts.Go2TS {
 			loop.CompactMessageType,
 			loop.SlugMessageType,
 			loop.ExternalMessageType,
+			loop.MilestoneMessageType,
+			loop.ApprovalMessageType,
 		},
 	)
 
+	// Named before the types that contain them, which would otherwise name them Status and Violation.
+	generator.AddWithName(skabandclient.Status{}, "SkabandStatus")
+	generator.AddWithName(bashkit.Violation{}, "BashViolation")
+
 	// Struct types
 	generator.AddMultiple(
 		loop.AgentMessage{},
 		loop.GitCommit{},
 		loop.ToolCall{},
+		loop.Milestone{},
+		loop.StateVisit{},
+		loop.Artifact{},
+		loop.Attestation{},
+		loop.MatrixRun{},
+		loop.BlockedCommand{},
 		llm.Usage{},
 		server.State{},
 		server.TodoItem{},
 		server.TodoList{},
+		server.TodoUpdateRequest{},
+		server.TodoReorderRequest{},
 		server.Remote{},
 		server.GitPushInfoResponse{},
 		server.GitPushRequest{},
 		server.GitPushResponse{},
+		server.TerminalInfo{},
+		server.TerminalControl{},
+		server.TerminalCreateRequest{},
+		server.TerminalResponse{},
+		server.TurnTimeoutRequest{},
+		server.CostEstimateRequest{},
+		server.FileActivityResponse{},
+		depaudit.Report{},
+		mergequeue.Entry{},
+		server.MergeQueueEnqueueRequest{},
+		server.ApplyPatchRequest{},
+		server.UploadCreateRequest{},
+		server.UploadStatus{},
+		server.ContainerFile{},
+		server.ContainerFileList{},
+		server.AuthUser{},
+		server.AuditEntry{},
+		loop.PatchResult{},
+		server.FeedbackRequest{},
+		server.CompactRequest{},
+		server.PinRequest{},
+		server.ResumedRequest{},
+		loop.Feedback{},
+		loop.HistoryMatch{},
+		loop.ToolCallProgress{},
+		loop.GitStats{},
+		loop.ImageProvenance{},
+		loop.ImageStatus{},
+		loop.ConversationNode{},
+		loop.ContextBundle{},
+		loop.GitIdentity{},
+		server.GitIdentityRequest{},
+		server.PlanModeRequest{},
+		server.PlanReviewRequest{},
+		server.ApproveRequest{},
+		server.AttachContextRequest{},
+		llm.Sampling{},
+		browse.Profile{},
+		netpolicy.Violation{},
 		git_tools.DiffFile{},
 		git_tools.GitLogEntry{},
+		git_tools.FileStat{},
+		git_tools.FileDiff{},
 	)
 
+	generator.AddWithName(conversation.Estimate{}, "CostEstimate")
+	generator.AddWithName(testreport.Failure{}, "TestFailure")
+	generator.AddWithName(testreport.Report{}, "TestReport")
+
+	// The devcontainer package's type names are too generic for the global namespace.
+	generator.AddWithName(devcontainer.VSCode{}, "DevcontainerVSCode")
+	generator.AddWithName(devcontainer.JetBrains{}, "DevcontainerJetBrains")
+	generator.AddWithName(devcontainer.Customizations{}, "DevcontainerCustomizations")
+	generator.AddWithName(devcontainer.Config{}, "DevcontainerConfig")
+	generator.AddWithName(devcontainer.Info{}, "DevcontainerInfo")
+
+	// Nor are the onboarding package's.
+	generator.AddWithName(onboarding.Capability{}, "OnboardingCapability")
+	generator.AddWithName(onboarding.Step{}, "OnboardingStep")
+	generator.AddWithName(onboarding.StepState{}, "OnboardingStepState")
+	generator.AddWithName(onboarding.State{}, "OnboardingState")
+	generator.AddWithName(server.OnboardingStepRequest{}, "OnboardingStepRequest")
+
 	generator.GenerateNominalTypes = true
 
 	return generator
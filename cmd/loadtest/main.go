@@ -0,0 +1,72 @@
+// Loadtest runs loop/server against many simulated SSE clients and a high
+// message rate, and reports delivery latency and goroutine counts. CPU,
+// mutex, block, and goroutine profiles of the run are always collected.
+//
+// Example:
+//
+//	go run ./cmd/loadtest -clients 500 -messages 1000 -max-p99 250ms
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+
+	"sketch.dev/loop/server/loadtest"
+)
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var cfg loadtest.Config
+	flag.IntVar(&cfg.Clients, "clients", 200, "concurrent SSE clients")
+	flag.IntVar(&cfg.Messages, "messages", 500, "messages to publish")
+	flag.Float64Var(&cfg.Rate, "rate", 0, "messages per second (0 means as fast as possible)")
+	flag.IntVar(&cfg.Size, "size", 1024, "bytes of content per message")
+	flag.DurationVar(&cfg.Timeout, "timeout", 2*time.Minute, "limit for the whole run")
+	flag.StringVar(&cfg.ProfileDir, "profiles", "", "directory for profiles (default: a new temporary directory)")
+	maxP99 := flag.Duration("max-p99", 0, "fail if p99 delivery latency exceeds this")
+	maxPerClient := flag.Float64("max-goroutines-per-client", 0, "fail if each client costs more goroutines than this")
+	flag.Parse()
+
+	// The SSE handler logs every connection and disconnection at info level.
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	if cfg.ProfileDir == "" {
+		dir, err := os.MkdirTemp("", "sketch-loadtest-")
+		if err != nil {
+			return err
+		}
+		cfg.ProfileDir = dir
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := loadtest.Run(ctx, cfg)
+	if res != nil {
+		fmt.Println(res)
+	}
+	fmt.Printf("profiles: %s (go tool pprof %s/mutex.pprof)\n", cfg.ProfileDir, cfg.ProfileDir)
+	if err != nil {
+		return err
+	}
+	if *maxP99 > 0 && res.P99 > *maxP99 {
+		return fmt.Errorf("p99 latency %v exceeds %v", res.P99, *maxP99)
+	}
+	if *maxPerClient > 0 && res.PerClient() > *maxPerClient {
+		return fmt.Errorf("%.1f goroutines per client exceeds %.1f", res.PerClient(), *maxPerClient)
+	}
+	if leaked := res.GoroutinesAfter - res.GoroutinesBefore; leaked > 0 {
+		fmt.Printf("warning: %d goroutines still running after the run\n", leaked)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"sketch.dev/loop/server"
+	"sketch.dev/termui"
+)
+
+// runAttach implements "sketch attach", which runs the terminal UI here
+// against a session running elsewhere, such as on a headless server.
+func runAttach(args []string) error {
+	fs := flag.NewFlagSet("attach", flag.ExitOnError)
+	remote := fs.String("remote", "", "URL of the session's web UI, e.g. http://buildbox:8000")
+	token := fs.String("token", "", "the session's -attach-token (default $SKETCH_ATTACH_TOKEN)")
+	plain := fs.Bool("plain", termui.PlainTerminal(), "line-oriented output, for terminals without cursor addressing")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch attach -remote URL [-token TOKEN]\n\nAttaches the terminal UI to a session started elsewhere with -attach-token.\nExiting detaches; the session carries on.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *remote == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("attach needs -remote and no other arguments")
+	}
+	if *token == "" {
+		*token = os.Getenv("SKETCH_ATTACH_TOKEN")
+	}
+	if *token == "" {
+		return fmt.Errorf("attach needs the session's token: pass -token or set SKETCH_ATTACH_TOKEN")
+	}
+
+	ctx := context.Background()
+	agent, err := server.DialAttach(ctx, *remote, *token)
+	if err != nil {
+		return err
+	}
+
+	s := termui.New(agent, strings.TrimSuffix(*remote, "/"), *plain)
+	go func() {
+		// The terminal UI would only notice on its next command, so don't wait for it.
+		<-agent.Done()
+		s.RestoreOldState()
+		fmt.Fprintf(os.Stderr, "\n%v\n", agent.Err())
+		os.Exit(1)
+	}()
+	defer func() {
+		r := recover()
+		if err := s.RestoreOldState(); err != nil {
+			fmt.Fprintf(os.Stderr, "couldn't restore old terminal state: %s\n", err)
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+	return s.Run(ctx)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"sketch.dev/crashreport"
+	"sketch.dev/skabandclient"
+)
+
+// crashRecorder captures fatal crashes of this process; main discards its
+// pending files when run returns without crashing.
+var crashRecorder *crashreport.Recorder
+
+func installCrashRecorder(flags CLIFlags, logFile *os.File, inContainer bool) {
+	dir, err := crashreport.Dir()
+	if err != nil {
+		slog.Debug("crash reports disabled", "error", err)
+		return
+	}
+	meta := crashreport.Metadata{
+		SessionID:   flags.sessionID,
+		Version:     release,
+		Model:       flags.modelName,
+		InContainer: inContainer,
+	}
+	if logFile != nil {
+		meta.LogPath = logFile.Name()
+	}
+	crashRecorder, err = crashreport.Install(dir, meta)
+	if err != nil {
+		slog.Debug("crash reports disabled", "error", err)
+	}
+}
+
+func closeCrashRecorder() {
+	if crashRecorder != nil {
+		crashRecorder.Close()
+	}
+}
+
+// reportCrashes collects crashes of earlier sessions, tells the user about them,
+// and uploads them to skaband if the user opted in.
+func reportCrashes(ctx context.Context, flags CLIFlags) {
+	dir, err := crashreport.Dir()
+	if err != nil {
+		return
+	}
+	reports, err := crashreport.Collect(dir)
+	if err != nil {
+		slog.WarnContext(ctx, "collecting crash reports", "error", err)
+	}
+	for _, r := range reports {
+		fmt.Fprintf(os.Stderr, "💥 a previous sketch session crashed (%s); details: sketch crash-reports %s\n", r.Panic, r.ID)
+		if !flags.uploadCrashReports || flags.skabandAddr == "" {
+			continue
+		}
+		pubKey, err := skabandPublicKey(flags.skabandAddr)
+		if err == nil {
+			err = r.Upload(ctx, flags.skabandAddr, pubKey)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "uploading crash report", "id", r.ID, "error", err)
+		}
+	}
+}
+
+func skabandPublicKey(skabandAddr string) (string, error) {
+	privKey, err := skabandclient.LoadOrCreatePrivateKey(skabandclient.DefaultKeyPath(skabandAddr))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(privKey.Public().(ed25519.PublicKey)), nil
+}
+
+// runCrashReports implements "sketch crash-reports [-upload] [id]", which lists
+// local crash reports, or shows (and optionally uploads) a single one.
+func runCrashReports(args []string) error {
+	fs := flag.NewFlagSet("crash-reports", flag.ExitOnError)
+	upload := fs.Bool("upload", false, "upload the report to skaband")
+	skabandAddr := fs.String("skaband-addr", "https://sketch.dev", "URL of the skaband server to upload to")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch crash-reports [-upload] [id]\n\nLists crash reports, or shows the report with the given id.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	dir, err := crashreport.Dir()
+	if err != nil {
+		return err
+	}
+	// Pick up crashes that no later session has collected yet.
+	if _, err := crashreport.Collect(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	reports, err := crashreport.List(dir)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		if *upload {
+			return fmt.Errorf("-upload requires a report id")
+		}
+		if len(reports) == 0 {
+			fmt.Println("No crash reports.")
+			return nil
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tCOLLECTED\tVERSION\tUPLOADED\tPANIC")
+		for _, r := range reports {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\n", r.ID, r.Time.Format("2006-01-02 15:04"), r.Metadata.Version, r.Uploaded, r.Panic)
+		}
+		return tw.Flush()
+	}
+
+	id := fs.Arg(0)
+	for _, r := range reports {
+		if r.ID != id {
+			continue
+		}
+		if *upload {
+			pubKey, err := skabandPublicKey(*skabandAddr)
+			if err != nil {
+				return err
+			}
+			if err := r.Upload(context.Background(), strings.TrimSuffix(*skabandAddr, "/"), pubKey); err != nil {
+				return err
+			}
+			fmt.Printf("Uploaded crash report %s.\n", r.ID)
+			return nil
+		}
+		fmt.Printf("Crash report %s (%s)\n", r.ID, r.Path)
+		fmt.Printf("Session:   %s\n", r.Metadata.SessionID)
+		fmt.Printf("Version:   %s (%s/%s)\n", r.Metadata.Version, r.Metadata.GOOS, r.Metadata.GOARCH)
+		fmt.Printf("Model:     %s\n", r.Metadata.Model)
+		fmt.Printf("Container: %t\n", r.Metadata.InContainer)
+		fmt.Printf("Started:   %s\n", r.Metadata.StartedAt.Format("2006-01-02 15:04:05"))
+		fmt.Printf("Uploaded:  %t\n\n", r.Uploaded)
+		fmt.Println(r.Stack)
+		if len(r.LogTail) > 0 {
+			fmt.Println("Recent log:")
+			for _, line := range r.LogTail {
+				fmt.Println(line)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no crash report %q; run sketch crash-reports to list them", id)
+}
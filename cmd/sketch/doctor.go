@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"sketch.dev/llm/ant"
+	"sketch.dev/llm/oai"
+	"sketch.dev/llm/ollama"
+)
+
+type doctorStatus int
+
+const (
+	doctorPass doctorStatus = iota
+	doctorWarn
+	doctorFail
+)
+
+func (s doctorStatus) String() string {
+	switch s {
+	case doctorPass:
+		return "ok"
+	case doctorWarn:
+		return "warning"
+	default:
+		return "FAIL"
+	}
+}
+
+func (s doctorStatus) icon() string {
+	switch s {
+	case doctorPass:
+		return "✅"
+	case doctorWarn:
+		return "⚠️ "
+	default:
+		return "❌"
+	}
+}
+
+// A doctorResult is the outcome of one sketch doctor check.
+type doctorResult struct {
+	Name   string
+	Status doctorStatus
+	Detail string // what was found
+	Fix    string // how to fix it, for warnings and failures
+}
+
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) doctorResult
+}
+
+// Free space below which the disk check warns and fails. Images, the
+// container's copy of the repo, and build caches all land on this disk.
+const (
+	doctorDiskWarn = 10 << 30
+	doctorDiskFail = 2 << 30
+)
+
+// runDoctor implements "sketch doctor", which checks that this machine
+// can run sketch and says how to fix what's missing.
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	skabandAddr := fs.String("skaband-addr", "https://sketch.dev", "URL of the skaband server; set to empty if you don't use it")
+	modelName := fs.String("model", "claude", "model whose provider to check reachability of")
+	llmURL := fs.String("llm-url", "", "LLM provider URL to check, overriding the model's default")
+	report := fs.String("report", "", "also write a shareable Markdown report to this file")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch doctor [-model name] [-report file]\n\nChecks that this machine is set up to run sketch.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	checks := doctorChecks(*skabandAddr, *modelName, *llmURL)
+	results := runDoctorChecks(ctx, checks)
+	printDoctorResults(os.Stdout, results)
+
+	if *report != "" {
+		f, err := os.Create(*report)
+		if err != nil {
+			return err
+		}
+		writeDoctorReport(f, results)
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("\nReport written to %s; attach it when asking for help.\n", *report)
+	}
+	if n := countDoctorStatus(results, doctorFail); n > 0 {
+		return fmt.Errorf("%d check(s) failed", n)
+	}
+	return nil
+}
+
+func doctorChecks(skabandAddr, modelName, llmURL string) []doctorCheck {
+	checks := []doctorCheck{
+		{"docker", checkDockerDaemon},
+		{"seccomp", checkSeccomp},
+		{"disk space", checkDiskSpace},
+		{"git identity", checkGitIdentity},
+		{"git receivepack", checkReceivePack},
+		{"ssh", checkSSH},
+	}
+	if llmURL == "" {
+		switch {
+		case ant.IsClaudeModel(modelName):
+			llmURL = ant.DefaultURL
+		case modelName == "gemini":
+			llmURL = "https://generativelanguage.googleapis.com"
+		case strings.HasPrefix(modelName, ollama.Prefix):
+			llmURL = ollama.URLFromEnv()
+		case strings.HasPrefix(modelName, oai.AzurePrefix):
+			llmURL = os.Getenv(oai.AzureEndpointEnv)
+		default:
+			llmURL = oai.ModelByUserName(modelName).URL
+		}
+	}
+	provider := "LLM provider (" + modelName + ")"
+	if llmURL != "" {
+		checks = append(checks, doctorCheck{provider, reachabilityCheck(llmURL)})
+	} else {
+		checks = append(checks, doctorCheck{provider, func(context.Context) doctorResult {
+			return doctorResult{Status: doctorFail, Detail: "unknown model " + modelName, Fix: "run sketch -list-models, or pass -llm-url"}
+		}})
+	}
+	if skabandAddr != "" {
+		checks = append(checks, doctorCheck{"skaband", reachabilityCheck(skabandAddr)})
+	}
+	return checks
+}
+
+// runDoctorChecks runs checks concurrently, returning their results in order.
+func runDoctorChecks(ctx context.Context, checks []doctorCheck) []doctorResult {
+	results := make([]doctorResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+			results[i].Name = c.name
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func printDoctorResults(w io.Writer, results []doctorResult) {
+	for _, r := range results {
+		fmt.Fprintf(w, "%s %s: %s\n", r.Status.icon(), r.Name, r.Detail)
+		if r.Status != doctorPass && r.Fix != "" {
+			fmt.Fprintf(w, "   ↳ %s\n", r.Fix)
+		}
+	}
+	fmt.Fprintf(w, "\n%d ok, %d warnings, %d failed\n",
+		countDoctorStatus(results, doctorPass), countDoctorStatus(results, doctorWarn), countDoctorStatus(results, doctorFail))
+}
+
+// writeDoctorReport writes results as Markdown, along with the system
+// details that matter when someone else is diagnosing the problem.
+func writeDoctorReport(w io.Writer, results []doctorResult) {
+	fmt.Fprintf(w, "# sketch doctor report\n\n")
+	fmt.Fprintf(w, "- sketch: %s\n", release)
+	fmt.Fprintf(w, "- os: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "- time: %s\n\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "| Check | Status | Detail | Fix |\n|---|---|---|---|\n")
+	for _, r := range results {
+		fix := ""
+		if r.Status != doctorPass {
+			fix = r.Fix
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s |\n", r.Name, r.Status, markdownCell(r.Detail), markdownCell(fix))
+	}
+}
+
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+func countDoctorStatus(results []doctorResult, s doctorStatus) int {
+	n := 0
+	for _, r := range results {
+		if r.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+func doctorOutput(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func checkDockerDaemon(ctx context.Context) doctorResult {
+	if _, err := checkDocker(); err != nil {
+		return doctorResult{Status: doctorFail, Detail: "docker is not installed", Fix: err.Error()}
+	}
+	out, err := doctorOutput(ctx, "docker", "info", "--format", "{{.ServerVersion}}")
+	if err != nil {
+		fix := "start the docker daemon (e.g. sudo systemctl start docker), and make sure your user may use it"
+		if runtime.GOOS == "darwin" {
+			fix = "start the docker VM: colima start"
+		}
+		return doctorResult{Status: doctorFail, Detail: "cannot reach the docker daemon: " + lastLine(out), Fix: fix}
+	}
+	return doctorResult{Status: doctorPass, Detail: "docker daemon " + out}
+}
+
+func checkSeccomp(ctx context.Context) doctorResult {
+	out, err := doctorOutput(ctx, "docker", "info", "--format", "{{json .SecurityOptions}}")
+	if err != nil {
+		return doctorResult{Status: doctorWarn, Detail: "skipped: docker daemon unavailable", Fix: "fix the docker check first"}
+	}
+	if !strings.Contains(out, "name=seccomp") {
+		return doctorResult{
+			Status: doctorFail,
+			Detail: "the docker daemon doesn't support seccomp profiles",
+			Fix:    "sketch applies a seccomp profile that keeps the agent from killing itself; use a docker daemon built with seccomp support",
+		}
+	}
+	return doctorResult{Status: doctorPass, Detail: "seccomp supported"}
+}
+
+func checkDiskSpace(ctx context.Context) doctorResult {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return doctorResult{Status: doctorWarn, Detail: fmt.Sprintf("cannot check free space in %s: %v", dir, err)}
+	}
+	free := uint64(st.Bavail) * uint64(st.Bsize)
+	res := doctorResult{Status: doctorPass, Detail: fmt.Sprintf("%.1f GiB free in %s", float64(free)/(1<<30), dir)}
+	switch {
+	case free < doctorDiskFail:
+		res.Status = doctorFail
+	case free < doctorDiskWarn:
+		res.Status = doctorWarn
+	}
+	res.Fix = "free up disk space; docker system prune removes unused images and build cache"
+	return res
+}
+
+func checkGitIdentity(ctx context.Context) doctorResult {
+	name, _ := doctorOutput(ctx, "git", "config", "--get", "user.name")
+	email, _ := doctorOutput(ctx, "git", "config", "--get", "user.email")
+	var missing []string
+	var fixes []string
+	if name == "" {
+		missing = append(missing, "user.name")
+		fixes = append(fixes, `git config --global user.name "Your Name"`)
+	}
+	if email == "" {
+		missing = append(missing, "user.email")
+		fixes = append(fixes, `git config --global user.email "you@example.com"`)
+	}
+	if len(missing) > 0 {
+		return doctorResult{Status: doctorFail, Detail: strings.Join(missing, " and ") + " not set", Fix: "run " + strings.Join(fixes, " && ")}
+	}
+	return doctorResult{Status: doctorPass, Detail: fmt.Sprintf("%s <%s>", name, email)}
+}
+
+// checkReceivePack checks that sketch can enable pushes from the container,
+// which it does by setting http.receivepack in the current repo.
+func checkReceivePack(ctx context.Context) doctorResult {
+	if _, err := doctorOutput(ctx, "git", "rev-parse", "--git-dir"); err != nil {
+		return doctorResult{Status: doctorWarn, Detail: "not in a git repository", Fix: "run sketch doctor from the repo you want to use sketch in"}
+	}
+	out, err := doctorOutput(ctx, "git", "config", "--get", "http.receivepack")
+	switch {
+	case out == "false":
+		return doctorResult{
+			Status: doctorWarn,
+			Detail: "http.receivepack is false; sketch will set it to true for this repo",
+			Fix:    "nothing to do, unless a config you don't control sets it back; check: git config --show-origin http.receivepack",
+		}
+	case err == nil:
+		return doctorResult{Status: doctorPass, Detail: "http.receivepack is " + out}
+	}
+	// Unset; make sure sketch will be able to set it.
+	if _, err := doctorOutput(ctx, "git", "config", "--local", "--list"); err != nil {
+		return doctorResult{Status: doctorFail, Detail: "cannot read the repo's git config", Fix: "check the permissions of .git/config"}
+	}
+	return doctorResult{Status: doctorPass, Detail: "http.receivepack unset; sketch sets it on launch"}
+}
+
+func checkSSH(ctx context.Context) doctorResult {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		return doctorResult{Status: doctorWarn, Detail: "ssh is not installed", Fix: "install OpenSSH to ssh into containers and attach IDEs to them"}
+	}
+	include := "Include " + filepath.Join(os.Getenv("HOME"), ".config", "sketch", "ssh_config")
+	config, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".ssh", "config"))
+	if err != nil || !strings.Contains(string(config), include) {
+		return doctorResult{
+			Status: doctorWarn,
+			Detail: "~/.ssh/config doesn't include sketch's ssh config",
+			Fix:    "sketch offers to add it on launch, or add this as the first line of ~/.ssh/config: " + include,
+		}
+	}
+	return doctorResult{Status: doctorPass, Detail: "ssh installed and configured"}
+}
+
+// reachabilityCheck checks that url answers HTTP requests at all;
+// any response, even an error status, means the network path works.
+func reachabilityCheck(url string) func(context.Context) doctorResult {
+	return func(ctx context.Context) doctorResult {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+		if err != nil {
+			return doctorResult{Status: doctorFail, Detail: fmt.Sprintf("bad URL %q: %v", url, err)}
+		}
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return doctorResult{
+				Status: doctorFail,
+				Detail: fmt.Sprintf("cannot reach %s: %v", url, err),
+				Fix:    "check your network connection, proxy settings (HTTPS_PROXY), and firewall",
+			}
+		}
+		resp.Body.Close()
+		return doctorResult{Status: doctorPass, Detail: fmt.Sprintf("%s reachable (%v)", url, time.Since(start).Round(time.Millisecond))}
+	}
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoctorReport(t *testing.T) {
+	results := []doctorResult{
+		{Name: "docker", Status: doctorPass, Detail: "docker daemon 27.0", Fix: "unused"},
+		{Name: "git identity", Status: doctorFail, Detail: "user.email not set", Fix: "run git config | tee"},
+		{Name: "ssh", Status: doctorWarn, Detail: "two\nlines"},
+	}
+
+	var out strings.Builder
+	printDoctorResults(&out, results)
+	if got := out.String(); !strings.Contains(got, "1 ok, 1 warnings, 1 failed") || strings.Contains(got, "unused") {
+		t.Errorf("printDoctorResults:\n%s", got)
+	}
+
+	var report strings.Builder
+	writeDoctorReport(&report, results)
+	for _, want := range []string{
+		"| docker | ok | docker daemon 27.0 |  |",
+		`| git identity | FAIL | user.email not set | run git config \| tee |`,
+		"| ssh | warning | two<br>lines |  |",
+	} {
+		if !strings.Contains(report.String(), want) {
+			t.Errorf("report missing %q:\n%s", want, report.String())
+		}
+	}
+}
+
+func TestReachabilityCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusUnauthorized) // any answer means the host is reachable
+	}))
+	defer srv.Close()
+	if r := reachabilityCheck(srv.URL)(context.Background()); r.Status != doctorPass {
+		t.Errorf("reachable server: %+v", r)
+	}
+	srv.Close()
+	if r := reachabilityCheck(srv.URL)(context.Background()); r.Status != doctorFail || r.Fix == "" {
+		t.Errorf("closed server: %+v", r)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"sketch.dev/loop"
+	"sketch.dev/output"
+)
+
+// runExport implements "sketch export", which packs a session recorded on
+// this machine into an archive that "sketch import" recreates it from on another.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("o", "", "the archive to write; defaults to SESSION-ID.sketch.tgz, and - is stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch export [-o file] SESSION-ID\n\nWrites the session's messages, record, artifacts, and a git bundle of its\nbranch and commit from the repository sketch runs in to an archive that\nsketch import recreates the session from.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	id := fs.Arg(0)
+	if *out == "" {
+		*out = id + ".sketch.tgz"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		return err
+	}
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	a, err := loop.ExportSession(ctx, w, dir, gitToplevel(ctx), id)
+	if err != nil {
+		if *out != "-" {
+			os.Remove(*out)
+		}
+		return err
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	if len(a.BundleRefs) == 0 {
+		output.Warnf("the session's commits aren't in this repository, so the archive has no code")
+	}
+	if *out != "-" {
+		output.Printf("📦", "exported session %s to %s", a.SessionID, *out)
+	}
+	return nil
+}
+
+// runImport implements "sketch import".
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	replace := fs.Bool("force", false, "replace the session if it is already on this machine")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch import [-force] FILE\n\nRecreates the session sketch export wrote to FILE, - for stdin: its record\nand messages, its artifacts, and its branch and commit in the repository\nsketch runs in, for sketch -resume to continue it.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		return err
+	}
+	r := io.Reader(os.Stdin)
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	a, err := loop.ImportSession(ctx, r, dir, gitToplevel(ctx), *replace)
+	if err != nil {
+		return err
+	}
+	output.Printf("📦", "imported session %s, exported %s, with %d artifact(s)", a.SessionID, a.ExportedAt.Local().Format(time.DateTime), len(a.Artifacts))
+	if ref, onBranch := a.WorkRef(); onBranch {
+		output.Printf("🌿", "its work on branch %s is at %s", a.Branch, ref)
+	} else if ref != "" {
+		output.Printf("🌿", "its work is at %s", ref)
+	}
+	resume := "sketch -resume " + a.SessionID
+	if img := a.BaseImageRef(); img != "" {
+		// Rebuild the container from what the session's was built on.
+		resume += " -base-image " + img
+	}
+	fmt.Printf("To continue the session: %s\n", resume)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"sketch.dev/dockerimg"
+	"sketch.dev/output"
+)
+
+// leakCheckInterval is how often a running session looks for what crashed sessions left behind.
+const leakCheckInterval = 6 * time.Hour
+
+// runGC implements "sketch gc", which finds what sessions that didn't exit
+// cleanly left behind and, with -sessions, removes it.
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	sessions := fs.Bool("sessions", false, "remove what past sessions left behind, rather than only list it")
+	minAge := fs.Duration("min-age", time.Hour, "leave worktrees and cache files younger than this alone")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch gc [-sessions] [-min-age duration]\n\nLists the containers, code review worktrees and cache files that sessions\nwhich didn't exit cleanly left behind, and sketch images over 30 days old\nthat no container uses; -sessions removes them.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	leaks, err := dockerimg.FindLeaks(ctx, dockerimg.LeakOptions{MinAge: *minAge, Repo: gitToplevel(ctx), Images: true})
+	if err != nil {
+		output.Warnf("not everything could be checked: %v", err)
+	}
+	if !*sessions {
+		printLeaks(os.Stdout, leaks)
+		if len(leaks) > 0 {
+			fmt.Println("\nTo remove them, run: sketch gc --sessions")
+		}
+		return nil
+	}
+	failed := 0
+	for _, l := range leaks {
+		if err := l.Clean(ctx); err != nil {
+			output.Warnf("%v", err)
+			failed++
+			continue
+		}
+		fmt.Printf("removed %s\n", l)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d could not be removed", failed, len(leaks))
+	}
+	if len(leaks) == 0 {
+		fmt.Println("Nothing left behind by past sessions.")
+	}
+	return nil
+}
+
+func printLeaks(w io.Writer, leaks []dockerimg.Leak) {
+	if len(leaks) == 0 {
+		fmt.Fprintln(w, "Nothing left behind by past sessions.")
+		return
+	}
+	for _, l := range leaks {
+		fmt.Fprintf(w, "%-9s %s\n          %s\n", l.Kind, l.Name, l.Detail)
+	}
+}
+
+// gitToplevel returns the root of the git repository sketch runs in, or "" outside of one.
+func gitToplevel(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// reportLeaks points at sketch gc when sessions have left things behind.
+func reportLeaks(leaks []dockerimg.Leak) {
+	kinds := map[string]int{}
+	for _, l := range leaks {
+		kinds[l.Kind]++
+	}
+	var parts []string
+	for _, k := range []string{"container", "image", "worktree", "cache"} {
+		if n := kinds[k]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, k))
+		}
+	}
+	output.Warnf("past sessions left behind %s; sketch gc lists them, sketch gc --sessions removes them", strings.Join(parts, ", "))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"sketch.dev/gittrace"
+)
+
+// runGitReplay implements "sketch git-replay", which replays a -git-trace
+// against scratch repositories and reports where it comes out differently.
+func runGitReplay(args []string) error {
+	fs := flag.NewFlagSet("git-replay", flag.ExitOnError)
+	keep := fs.Bool("keep", false, "keep the scratch repositories, to poke at")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch git-replay [-keep] TRACE\n\nReplays the git commands recorded with -git-trace in scratch repositories,\nand reports those whose exit status or resulting refs differ from the trace.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ops, err := gittrace.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "sketch-git-replay-")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Printf("scratch repositories in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	divs, err := gittrace.Replay(context.Background(), ops, dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range divs {
+		fmt.Println(d)
+	}
+	if len(divs) > 0 {
+		return fmt.Errorf("%d divergences replaying %d git commands", len(divs), len(ops))
+	}
+	fmt.Printf("replayed %d git commands as traced\n", len(ops))
+	return nil
+}
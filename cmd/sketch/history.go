@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"sketch.dev/loop"
+)
+
+// runHistory implements "sketch history", which finds past sessions
+// recorded on this machine without asking skaband.
+func runHistory(args []string) error {
+	if len(args) == 0 || args[0] != "search" {
+		fmt.Fprintf(os.Stderr, "Usage: sketch history search [-json] [query]\n")
+		return fmt.Errorf("unknown history command")
+	}
+	fs := flag.NewFlagSet("history search", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print matches as JSON")
+	limit := fs.Int("n", 20, "show at most this many matches; 0 means all")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch history search [-json] [query]\n\nFinds past sessions whose prompt, messages, touched files, branch, or repository\ncontain every word of query. Without a query, lists recent sessions.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		return err
+	}
+	matches, err := loop.SearchHistory(dir, strings.Join(fs.Args(), " "))
+	if err != nil {
+		return err
+	}
+	if *limit > 0 && len(matches) > *limit {
+		matches = matches[:*limit]
+	}
+	if *asJSON {
+		if matches == nil {
+			matches = []loop.HistoryMatch{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(matches)
+	}
+	printHistoryMatches(os.Stdout, matches)
+	return nil
+}
+
+func printHistoryMatches(w io.Writer, matches []loop.HistoryMatch) {
+	if len(matches) == 0 {
+		fmt.Fprintln(w, "No matching sessions.")
+		return
+	}
+	for _, m := range matches {
+		fmt.Fprintf(w, "%s  %s", m.SavedAt.Local().Format(time.DateTime), m.SessionID)
+		if m.Branch != "" {
+			fmt.Fprintf(w, "  %s", m.Branch)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "    %s\n", firstLine(m.Prompt, 100))
+		if len(m.Files) > 0 {
+			fmt.Fprintf(w, "    files: %s\n", firstLine(strings.Join(m.Files, ", "), 100))
+		}
+		fmt.Fprintf(w, "    resume: sketch -resume %s\n", m.SessionID)
+	}
+}
+
+// firstLine returns the first line of s, cut to at most n runes.
+func firstLine(s string, n int) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
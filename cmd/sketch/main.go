@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,33 +12,51 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"golang.org/x/term"
 	"sketch.dev/browser"
 	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/claudetool/browse"
+	"sketch.dev/claudetool/codereview"
+	"sketch.dev/claudetool/mergequeue"
+	"sketch.dev/claudetool/onstart"
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/control"
 	"sketch.dev/dockerimg"
 	"sketch.dev/experiment"
+	"sketch.dev/gittrace"
+	"sketch.dev/hooks"
 	"sketch.dev/llm"
 	"sketch.dev/llm/ant"
 	"sketch.dev/llm/conversation"
 	"sketch.dev/llm/gem"
 	"sketch.dev/llm/oai"
+	"sketch.dev/llm/ollama"
 	"sketch.dev/loop"
 	"sketch.dev/loop/server"
 	"sketch.dev/mcp"
+	"sketch.dev/netpolicy"
+	"sketch.dev/oidc"
+	"sketch.dev/onboarding"
+	"sketch.dev/output"
 	"sketch.dev/skabandclient"
 	"sketch.dev/skribe"
 	"sketch.dev/termui"
-	"sketch.dev/update"
+	"sketch.dev/untrusted"
+	"sketch.dev/webhook"
 	"sketch.dev/webui"
 )
 
@@ -78,7 +97,94 @@ func doVersionCheck(ch chan *VersionResponse, pubKey string) {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "crash-reports" {
+		if err := runCrashReports(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		if err := runDoctor(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		if err := runGC(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "attach" {
+		if err := runAttach(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := runRestore(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		if err := runHistory(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "update" {
+		if err := runUpdate(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "git-replay" {
+		if err := runGitReplay(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "new" {
+		err := runNew(os.Args[2:])
+		closeCrashRecorder()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "supervisor" {
+		if err := runSupervisor(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
+			os.Exit(1)
+		}
+		return
+	}
 	err := run()
+	closeCrashRecorder()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v: %v\n", os.Args[0], err)
 		os.Exit(1)
@@ -90,12 +196,19 @@ func main() {
 func run() error {
 	flagArgs := parseCLIFlags()
 
+	// Resolved here so that the container, whose stdout isn't a terminal, follows the outer mode.
+	outputMode, err := output.ParseMode(flagArgs.outputMode)
+	if err != nil {
+		return err
+	}
+	output.SetMode(outputMode)
+
 	// If not built with make, embedded assets will be missing.
 	if builtBy == "" {
 		// If your sketch binary isn't working and you are seeing this warning,
 		// it's probably because your build method has stale or missing embedded assets.
 		// See the makefile/GoReleaser configs for how to build.
-		fmt.Fprintln(os.Stderr, "⚠️  not using a recommended build method")
+		output.Warnf("not using a recommended build method")
 	}
 
 	// Set up signal handling if -ignoresig flag is set
@@ -118,7 +231,7 @@ func run() error {
 	}
 
 	if flagArgs.doUpdate {
-		return doSelfUpdate()
+		return runUpdate(nil)
 	}
 
 	if flagArgs.listModels {
@@ -136,6 +249,15 @@ func run() error {
 			}
 			fmt.Printf("- %s%s\n", name, note)
 		}
+		fmt.Printf("- %s<name> (a model served by a local Ollama)\n", ollama.Prefix)
+		fmt.Printf("- %s<deployment> (an Azure OpenAI deployment, at -llm-api-base or $%s)\n", oai.AzurePrefix, oai.AzureEndpointEnv)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if names, err := (&ollama.Service{URL: ollama.URLFromEnv()}).Models(ctx); err == nil {
+			for _, name := range names {
+				fmt.Printf("  - %s%s\n", ollama.Prefix, name)
+			}
+		}
 		return nil
 	}
 
@@ -143,6 +265,193 @@ func run() error {
 		return dumpDistFilesystem(flagArgs.dumpDist)
 	}
 
+	if flagArgs.anthropicLogin {
+		return anthropicLogin(context.Background())
+	}
+
+	if _, _, err := onstart.ParseScope(flagArgs.codebaseScope); err != nil {
+		return fmt.Errorf("invalid -codebase-analysis: %w", err)
+	}
+	if _, err := mergequeue.Parse(flagArgs.mergeQueue, ""); err != nil {
+		return fmt.Errorf("invalid -merge-queue: %w", err)
+	}
+	if _, err := codereview.ParseCommitLint(flagArgs.commitLint); err != nil {
+		return fmt.Errorf("invalid -commit-lint: %w", err)
+	}
+	if _, err := codereview.ParseQualityGates(flagArgs.qualityGates); err != nil {
+		return fmt.Errorf("invalid -quality-gates: %w", err)
+	}
+	if _, err := codereview.ParseDocsCheck(flagArgs.docsCheck); err != nil {
+		return fmt.Errorf("invalid -docs-check: %w", err)
+	}
+	if _, err := codereview.ParseBenchCheck(flagArgs.benchCheck); err != nil {
+		return fmt.Errorf("invalid -bench-check: %w", err)
+	}
+	if _, err := loop.ParseTrailerPolicy(flagArgs.gitTrailers); err != nil {
+		return fmt.Errorf("invalid -git-trailers: %w", err)
+	}
+	if _, err := loop.ParseCompactionStrategy(flagArgs.compaction); err != nil {
+		return fmt.Errorf("invalid -compaction: %w", err)
+	}
+	if _, err := loop.ParseCloneStrategy(flagArgs.cloneStrategy); err != nil {
+		return fmt.Errorf("invalid -clone-strategy: %w", err)
+	}
+	if d := flagArgs.idleSuspend; d != 0 && d < time.Minute {
+		return fmt.Errorf("invalid -idle-suspend %s: must be 0 or at least 1m", d)
+	}
+	if flagArgs.snapshotTo != "" {
+		if err := dockerimg.ValidateSnapshotURL(flagArgs.snapshotTo); err != nil {
+			return fmt.Errorf("invalid -snapshot-to: %w", err)
+		}
+		if flagArgs.unsafe {
+			return fmt.Errorf("-snapshot-to needs a container: it can't be used with -unsafe")
+		}
+	}
+	if d := flagArgs.snapshotEvery; d < time.Minute {
+		return fmt.Errorf("invalid -snapshot-every %s: must be at least 1m", d)
+	}
+	if c, err := oidc.ParseConfig(flagArgs.oidc); err != nil {
+		return fmt.Errorf("invalid -oidc: %w", err)
+	} else if c != nil && flagArgs.skabandAddr != "" {
+		return fmt.Errorf("-oidc needs -skaband-addr=\"\": sign-ins can't go through sketch.dev")
+	}
+	if strings.HasPrefix(flagArgs.addr, "unix:") && !flagArgs.unsafe {
+		return fmt.Errorf("-addr=unix:PATH needs -unsafe: a container's web server is published on a port")
+	}
+	if len(flagArgs.attach) > 0 && flagArgs.prompt == "" {
+		return fmt.Errorf("-attach needs -prompt: the files go with the first message")
+	}
+	if _, err := webhook.ParseEvents(flagArgs.webhookEvents); err != nil {
+		return fmt.Errorf("invalid -webhook-events: %w", err)
+	}
+	if flagArgs.webhook != "" {
+		if err := webhook.ValidateURL(flagArgs.webhook); err != nil {
+			return fmt.Errorf("invalid -webhook: %w", err)
+		}
+	}
+	for _, spec := range flagArgs.hooks {
+		if _, err := hooks.Parse(spec); err != nil {
+			return fmt.Errorf("invalid -hook: %w", err)
+		}
+	}
+	if _, err := dockerimg.ParseSSHRoute(flagArgs.sshJump, flagArgs.sshJumpKey, flagArgs.sshHostName); err != nil {
+		return fmt.Errorf("invalid ssh route: %w", err)
+	}
+	if flagArgs.attachToken == "" {
+		// Also how the container receives the token, keeping it out of its command line.
+		flagArgs.attachToken = os.Getenv("SKETCH_ATTACH_TOKEN")
+	}
+	if n := len(flagArgs.attachToken); n > 0 && n < 16 {
+		return fmt.Errorf("invalid -attach-token: must be at least 16 characters")
+	}
+	if _, err := llm.ParseSampling(flagArgs.sampling); err != nil {
+		return fmt.Errorf("invalid -sampling: %w", err)
+	}
+	if _, err := llm.ParseShaping(flagArgs.reqShaping); err != nil {
+		return fmt.Errorf("invalid -request-shaping: %w", err)
+	}
+	if flagArgs.webProfile != "" {
+		dir, err := browse.DefaultProfileDir()
+		if err == nil {
+			_, err = browse.LoadProfile(dir, flagArgs.webProfile)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid -browser-profile: %w", err)
+		}
+	}
+	if _, err := untrusted.ParsePolicy(flagArgs.untrustedMode); err != nil {
+		return fmt.Errorf("invalid -untrusted-content: %w", err)
+	}
+	if flagArgs.resume != "" {
+		if flagArgs.resumeFrom != "" {
+			return fmt.Errorf("-resume and -resume-from can't be used together")
+		}
+		dir, err := loop.SessionRecordDir()
+		if err != nil {
+			return fmt.Errorf("invalid -resume: %w", err)
+		}
+		// The session carries on under its own id, adding to its record.
+		flagArgs.resumeFrom = filepath.Join(dir, filepath.Base(flagArgs.resume)+".json")
+		flagArgs.sessionID = filepath.Base(flagArgs.resume)
+	}
+	if flagArgs.resumeFrom != "" {
+		if _, err := loop.LoadSessionRecord(flagArgs.resumeFrom); err != nil {
+			return fmt.Errorf("invalid -resume-from: %w", err)
+		}
+		// Before -C changes the working directory.
+		if abs, err := filepath.Abs(flagArgs.resumeFrom); err == nil {
+			flagArgs.resumeFrom = abs
+		}
+	}
+	if err := dockerimg.ValidateImageRegistry(flagArgs.imageRegistry); err != nil {
+		return fmt.Errorf("invalid -image-registry: %w", err)
+	}
+	if err := dockerimg.ValidateImagePlatforms(flagArgs.platforms); err != nil {
+		return fmt.Errorf("invalid -image-platforms: %w", err)
+	}
+	if _, err := dockerimg.ParseBuildSecrets(flagArgs.buildSecrets); err != nil {
+		return fmt.Errorf("invalid -build-secret: %w", err)
+	}
+	if _, err := loop.ParseToolFilter(flagArgs.enableTools, flagArgs.disableTools); err != nil {
+		return fmt.Errorf("invalid -disable-tools: %w", err)
+	}
+	if _, err := bashkit.ParsePolicy(flagArgs.bashAllow, flagArgs.bashDeny); err != nil {
+		return fmt.Errorf("invalid -bash-allow or -bash-deny: %w", err)
+	}
+	if _, err := permission.ParsePolicy(flagArgs.toolPerms); err != nil {
+		return fmt.Errorf("invalid -tool-permissions: %w", err)
+	}
+	if envs, err := dockerimg.ParseMatrix(flagArgs.matrix); err != nil {
+		return fmt.Errorf("invalid -matrix: %w", err)
+	} else if len(envs) > 0 {
+		if flagArgs.unsafe {
+			return fmt.Errorf("-matrix runs its environments in containers; it can't be used with -unsafe")
+		}
+		if flagArgs.matrixVerify == "" {
+			return fmt.Errorf("-matrix needs -matrix-verify, the command to run in each environment")
+		}
+	}
+	if _, err := parseTmuxWindows(flagArgs.tmuxWindows); err != nil {
+		return fmt.Errorf("invalid -tmux-windows: %w", err)
+	}
+	if flagArgs.tmux && flagArgs.outsideHostname == "" {
+		if flagArgs.unsafe || !flagArgs.termUI || flagArgs.oneShot {
+			return fmt.Errorf("-tmux needs the terminal UI and a container; it can't be used with -unsafe, -termui=false or -one-shot")
+		}
+		if _, err := exec.LookPath("tmux"); err != nil {
+			return fmt.Errorf("-tmux: %w", err)
+		}
+		if os.Getenv("TMUX") == "" {
+			// This sketch only starts the tmux session the session runs in.
+			return runInTmux(flagArgs)
+		}
+	}
+	switch flagArgs.termUIMode {
+	case "auto":
+		// Resolve here so that the container, whose TERM docker sets, gets the outer terminal's mode.
+		flagArgs.termUIMode = "full"
+		if termui.PlainTerminal() {
+			flagArgs.termUIMode = "plain"
+		}
+	case "full", "plain":
+	default:
+		return fmt.Errorf("invalid -termui-mode %q: want auto, full, or plain", flagArgs.termUIMode)
+	}
+	if flagArgs.maxUploadMB <= 0 {
+		return fmt.Errorf("invalid -max-upload-mb %d: must be positive", flagArgs.maxUploadMB)
+	}
+
+	// Local models need no sketch.dev, and a run with one may well be offline.
+	if _, ok := ollama.ModelName(flagArgs.modelName); ok {
+		flagArgs.skabandAddr = ""
+	}
+	// Nor do Azure deployments, which are the user's own.
+	if strings.HasPrefix(flagArgs.modelName, oai.AzurePrefix) {
+		flagArgs.skabandAddr = ""
+	}
+	if flagArgs.llmAPIBase != "" && flagArgs.skabandAddr != "" {
+		return fmt.Errorf("-llm-api-base needs -skaband-addr='', since sketch.dev reaches the model itself")
+	}
 	// Not all models have skaband support.
 	hasSkabandSupport := ant.IsClaudeModel(flagArgs.modelName)
 	switch flagArgs.modelName {
@@ -182,6 +491,11 @@ func run() error {
 	// Detect whether we're inside the sketch container
 	inInsideSketch := flagArgs.outsideHostname != ""
 
+	installCrashRecorder(flagArgs, logFile, inInsideSketch)
+	if !inInsideSketch {
+		reportCrashes(ctx, flagArgs)
+	}
+
 	// Change to working directory if specified
 	// Delay chdir when running in container mode, so that container setup can happen first,
 	// which might be necessary for the requested working dir to exist.
@@ -279,16 +593,69 @@ type CLIFlags struct {
 	openBrowser   bool
 	httprrFile    string
 	maxDollars    float64
+	turnTimeout   time.Duration
+	idleSuspend   time.Duration
+	snapshotTo    string
+	snapshotEvery time.Duration
+	hooks         StringSliceFlag
+	webhook       string
+	webhookEvents string
+	confirmCost   float64
+	autoConfirm   bool
+	maxUploadMB   int
+	netAllowlist  string
+	bashAllow     string
+	bashDeny      string
+	language      string
+	ackReply      string
+	codebaseScope string
+	mergeQueue    string
+	commitLint    string
+	qualityGates  string
+	docsCheck     string
+	benchCheck    string
+	gitTrailers   string
+	forwardPorts  bool
+	compaction    string
+	cloneStrategy string
+	attachToken   string
+	oidc          string
+	sampling      string
+	reqShaping    string
+	webProfile    string
+	imageRegistry string
+	turnSummaries bool
+	resultRefs    bool
+	slimTools     bool
+	watch         bool
+	planMode      bool
+	toolPerms     string
+	tmux          bool
+	tmuxWindows   string
+	matrix        StringSliceFlag
+	matrixVerify  string
+	feedbackSync  bool
+	untrustedMode string
+	resumeFrom    string
+	resume        string
+	uncommitted   bool
+	registryPush  bool
+	platforms     string
+	buildSecrets  StringSliceFlag
 	oneShot       bool
 	prompt        string
 	modelName     string
 	llmAPIKey     string
+	llmAPIBase    string
 	listModels    bool
 	verbose       bool
 	version       bool
 	workingDir    string
 	dumpDist      string
 	sshPort       int
+	sshJump       string
+	sshJumpKey    string
+	sshHostName   string
 	forceRebuild  bool
 	baseImage     string
 	linkToGitHub  bool
@@ -311,15 +678,24 @@ type CLIFlags struct {
 	dockerArgs          string
 	mounts              StringSliceFlag
 	termUI              bool
+	termUIMode          string
+	outputMode          string
+	uploadCrashReports  bool
+	enableTools         string
+	disableTools        string
 	gitRemoteURL        string
 	originalGitOrigin   string
 	upstream            string
 	commit              string
+	uncommittedBase     string
 	outsideHTTP         string
+	controlAddr         string
+	image               loop.ImageProvenance
 	branchPrefix        string
 	sshConnectionString string
 	subtraceToken       string
 	mcpServers          StringSliceFlag
+	attach              StringSliceFlag
 	// Timeout configuration for bash tool
 	bashFastTimeout       string
 	bashSlowTimeout       string
@@ -327,6 +703,12 @@ type CLIFlags struct {
 	passthroughUpstream   bool
 	// LLM debugging
 	dumpLLM bool
+	// Git sync debugging
+	gitTrace        string
+	gitTraceOutside bool
+	// Anthropic OAuth
+	anthropicLogin bool
+	anthropicOAuth bool
 }
 
 // parseCLIFlags parses all command-line flags and returns a CLIFlags struct
@@ -338,24 +720,77 @@ func parseCLIFlags() CLIFlags {
 	internalFlags := flag.NewFlagSet("sketch-internal", flag.ContinueOnError)
 
 	// User-visible flags
-	userFlags.StringVar(&flags.addr, "addr", "localhost:0", "local HTTP server")
+	userFlags.StringVar(&flags.addr, "addr", "localhost:0", "local HTTP server, or unix:PATH to serve it on a unix socket (with -unsafe)")
 	userFlags.StringVar(&flags.skabandAddr, "skaband-addr", "https://sketch.dev", "URL of the skaband server; set to empty to disable sketch.dev integration")
 	userFlags.StringVar(&flags.skabandAddr, "ska-band-addr", "https://sketch.dev", "URL of the skaband server; set to empty to disable sketch.dev integration (alias for -skaband-addr)")
 	userFlags.BoolVar(&flags.unsafe, "unsafe", false, "run without a docker container")
 	userFlags.BoolVar(&flags.openBrowser, "open", true, "open sketch URL in system browser; on by default except if -one-shot is used or a ssh connection is detected")
 	userFlags.Float64Var(&flags.maxDollars, "max-dollars", 10.0, "maximum dollars the agent should spend per turn, 0 to disable limit")
+	userFlags.DurationVar(&flags.turnTimeout, "turn-timeout", 0, "maximum wall-clock time for a single agent turn (e.g. 30m), 0 to disable limit")
+	userFlags.Var(&flags.hooks, "hook", "host command to run on a session event, as EVENT=COMMAND, with EVENT one of session_start, branch_pushed, budget_exceeded, session_end; it gets the event as JSON on stdin and in SKETCH_HOOK_* variables; executables in ~/.config/sketch/plugins get every event (can be repeated)")
+	userFlags.StringVar(&flags.webhook, "webhook", "", "URL to POST the session's events to, as JSON: commits, end of turn, errors and budget stops; with $SKETCH_WEBHOOK_SECRET set, X-Sketch-Signature-256 carries the body's HMAC-SHA256; failed deliveries are retried, and all are logged in ~/.cache/sketch/webhooks")
+	userFlags.StringVar(&flags.webhookEvents, "webhook-events", "", "comma-separated events -webhook gets, of commit, end_of_turn, error, budget, approval; empty sends all")
+	userFlags.DurationVar(&flags.idleSuspend, "idle-suspend", 0, "pause the container (docker pause) once the agent has had no messages or tool activity for this long (e.g. 2h); the web UI or terminal resumes it when next used; 0 never suspends")
+	userFlags.StringVar(&flags.snapshotTo, "snapshot-to", "", "snapshot the container's workspace (a git bundle of its history and uncommitted work, and a manifest of untracked files) to this s3:// or gs:// URL, using the host's aws or gcloud CLI and credentials, so that sketch restore can recreate it on another machine")
+	userFlags.DurationVar(&flags.snapshotEvery, "snapshot-every", 30*time.Minute, "how often -snapshot-to snapshots the workspace, when it has changed; a last snapshot is taken at exit")
+	userFlags.Float64Var(&flags.confirmCost, "confirm-cost", 1.0, "ask before sending a request to the LLM estimated to cost more than this many dollars, 0 to never ask")
+	userFlags.BoolVar(&flags.autoConfirm, "auto-confirm-cost", false, "send requests above -confirm-cost without asking, for -one-shot runs")
+	userFlags.IntVar(&flags.maxUploadMB, "max-upload-mb", 512, "largest file, in megabytes, that can be uploaded to the session or transferred to and from the container from the web UI")
+	userFlags.StringVar(&flags.language, "language", "", "language for the agent's replies and sketch's notices (e.g. Japanese, de); defaults to English")
+	userFlags.StringVar(&flags.ackReply, "ack-reply", "", "reply to messages that only acknowledge, such as \"ok\" or \"thanks\", with this text, such as 👍, instead of asking the model; empty, the default, sends them to the model")
+	userFlags.StringVar(&flags.codebaseScope, "codebase-analysis", "full", "analyze the codebase at startup to inform the agent: \"full\", \"off\", or comma-separated directories to limit the analysis to")
+	userFlags.StringVar(&flags.enableTools, "enable-tools", "", "comma-separated tools or tool groups (browser, mcp) the agent may use; empty allows all tools not disabled")
+	userFlags.StringVar(&flags.disableTools, "disable-tools", "", "comma-separated tools or tool groups (browser, mcp) to withhold from the agent, e.g. browser,mcp")
+	userFlags.StringVar(&flags.mergeQueue, "merge-queue", "", "let the agent land pushed branches through a merge queue: \"github\" (uses gh and its credentials) or the URL of a custom queue service; empty disables")
+	userFlags.StringVar(&flags.sampling, "sampling", "", "sampling parameters sent with each request to the model, as comma-separated name=value pairs: temperature, top_p, seed (e.g. \"temperature=0,seed=42\"); unset parameters use the model's defaults, and a -resume-from run keeps the recorded ones")
+	userFlags.StringVar(&flags.reqShaping, "request-shaping", "", "limits of each request to the model, as comma-separated name=value pairs: max_tokens, stop (once per stop sequence), thinking_budget (Claude, Gemini 2.5), reasoning_effort (low, medium, or high; OpenAI reasoning models) (e.g. \"max_tokens=32000,thinking_budget=8000\"); models ignore what they don't support, Claude takes no -sampling while thinking, and /debug shows the values in use")
+	userFlags.StringVar(&flags.webProfile, "browser-profile", "", "browser profile the browser tools start from: a directory in ~/.config/sketch/browser-profiles with an optional profile.json (width, height, user_agent) and the storage-state.json a session saved to it, so the agent doesn't have to log in again")
+	userFlags.StringVar(&flags.commitLint, "commit-lint", "", "commit message policy the agent's commits are checked against, as space-separated rules: conventional[=type,...], max-subject=N, ticket=REGEXP (e.g. \"conventional max-subject=72\"); defaults to the sketch.commitLint git config setting, \"off\" disables")
+	userFlags.StringVar(&flags.qualityGates, "quality-gates", "", "criteria the done tool checks before the agent may finish, as space-separated gates: tests, codereview, coverage=N, lint (e.g. \"tests lint coverage=80\"); defaults to the sketch.qualityGates git config setting, \"off\" disables")
+	userFlags.StringVar(&flags.docsCheck, "docs-check", "", "spelling and style checks run on the markdown and code comments each commit adds, reporting only new issues: spelling, style, or on for both; defaults to the sketch.docsCheck git config setting, \"off\" disables")
+	userFlags.StringVar(&flags.benchCheck, "bench-check", "", "compare the benchmarks of changed Go packages before and after in code review, reporting slowdowns: on, or settings such as \"time=1.5 allocs=2 count=5 benchtime=100ms bench=REGEXP\"; defaults to the sketch.benchCheck git config setting, \"off\" disables")
+	userFlags.BoolVar(&flags.forwardPorts, "forward-ports", false, "forward the ports that servers open in the container to the same ports on the host's loopback interface, or others where those are taken")
+	userFlags.StringVar(&flags.gitTrailers, "git-trailers", "", "trailers added to the agent's commits: \"off\" for none, or comma-separated changes to the default Co-Authored-By sketch and Change-ID trailers, such as \"co-author=NAME <EMAIL>,change-id=off,signoff=on\" (signoff adds the DCO's Signed-off-by for the git user); defaults to the sketch.gitTrailers git config setting")
+	userFlags.StringVar(&flags.cloneStrategy, "clone-strategy", "auto", "how the container gets the repo's git history: \"full\" copies all git objects into the image; \"partial\" clones without file contents, fetched from the host as needed; \"shallow\" or \"shallow:YYYY-MM-DD\" clones the history since a year ago or the date; \"auto\" picks by repo size (shown with -verbose)")
+	userFlags.StringVar(&flags.compaction, "compaction", "summary", "how the conversation is compacted as it nears the context window: \"summary\" restarts it from a summary; \"drop-tool-results\" replaces the output of older tool calls, summarizing once none is left; \"keep-pinned\" restarts it from the first message, pinned messages and a recap of the session")
+	userFlags.StringVar(&flags.attachToken, "attach-token", "", "enable \"sketch attach -remote URL\" from other machines for clients presenting this secret, at least 16 characters; combine with -addr to listen beyond localhost (default $SKETCH_ATTACH_TOKEN)")
+	userFlags.StringVar(&flags.oidc, "oidc", "", "require signing in to the web UI with an OpenID Connect provider, for sketch shared on a host: space-separated issuer=URL client=ID redirect=URL owners=LIST spectators=LIST, a LIST being comma-separated emails, @domains, group:NAME, sub:ID or *; owners drive the session, spectators only watch it; the client secret comes from $SKETCH_OIDC_CLIENT_SECRET; needs -skaband-addr=\"\"")
+	userFlags.StringVar(&flags.untrustedMode, "untrusted-content", "strip", "how to handle prompt injection attempts in web pages and MCP tool output: \"strip\" removes them, \"block\" withholds the whole output from the agent")
+	userFlags.BoolVar(&flags.resultRefs, "tool-result-refs", true, "give large tool results a handle the model can pass to later tool calls instead of copying the output")
+	userFlags.BoolVar(&flags.slimTools, "slim-tools", true, "send the model abridged tool descriptions, saving tokens on every request, along with a tool_help tool that returns the full description of a tool")
+	userFlags.StringVar(&flags.toolPerms, "tool-permissions", "", "per-tool permissions, as comma-separated tool=mode pairs, * standing for the tools not named: allow runs the tool, deny refuses it, ask holds each call until you approve or deny it (e.g. \"bash=ask,patch=ask\" or \"*=ask,think=allow\")")
+	userFlags.BoolVar(&flags.watch, "watch", false, "tell the agent, with your next message, which files changed between its turns, such as your edits over SSH or in an IDE; changes made while it works count as its own")
+	userFlags.BoolVar(&flags.planMode, "plan", false, "start in plan mode: the agent reads and explores but doesn't change files or commit until you approve its plan")
+	userFlags.BoolVar(&flags.turnSummaries, "turn-summaries", false, "after each turn, have the model write a one-line summary, shown as a milestone for skimming long sessions (costs an extra, mostly cached, model call per turn)")
+	userFlags.BoolVar(&flags.feedbackSync, "share-feedback", false, "send your 👍/👎 ratings of agent messages, and their comments, to skaband so they can be aggregated across sessions; ratings are always stored with the session")
+	userFlags.StringVar(&flags.imageRegistry, "image-registry", "", "share layered images with teammates through this image repository (e.g. registry.example.com/team/sketch) using your docker login credentials; images include the repo's git objects; defaults to the sketch.imageRegistry git config setting, \"off\" disables")
+	userFlags.BoolVar(&flags.registryPush, "image-registry-push", true, "push layered images built locally to -image-registry; when false, only pull")
+	userFlags.StringVar(&flags.platforms, "image-platforms", "", "comma-separated platforms to build layered images for when pushing to -image-registry (e.g. \"linux/amd64,linux/arm64\"), so teammates on other architectures can pull them; needs docker buildx with a multi-platform builder; defaults to the sketch.imagePlatforms git config setting")
+	userFlags.Var(&flags.buildSecrets, "build-secret", "file to mount while the layered image fetches dependencies, without storing it in the image, as ID=PATH (e.g. netrc=~/.netrc for private Go modules; netrc, gitconfig, git-credentials and npmrc are mounted where their tools look, others under /run/secrets) (can be repeated)")
+	userFlags.StringVar(&flags.netAllowlist, "net-allowlist", "", "restrict container network access to these comma-separated domains and their subdomains; \"default\" adds common package registries (e.g. default,example.com). Advisory: it is kept by a proxy and DNS, which the agent, as root in the container, can get around")
+	userFlags.StringVar(&flags.bashAllow, "bash-allow", "", "restrict the bash tool to these comma-separated commands, each optionally followed by an argument pattern in which * matches anything (e.g. \"ls,git status*,go test *\"); blocked attempts are logged to an audit trail")
+	userFlags.StringVar(&flags.bashDeny, "bash-deny", "", "never let the bash tool run these comma-separated commands, in the form of -bash-allow (e.g. \"rm,git push --force*\")")
 	userFlags.BoolVar(&flags.oneShot, "one-shot", false, "exit after the first turn without termui")
 	userFlags.StringVar(&flags.prompt, "prompt", "", "prompt to send to sketch")
 	userFlags.StringVar(&flags.prompt, "p", "", "prompt to send to sketch (alias for -prompt)")
-	userFlags.StringVar(&flags.modelName, "model", "claude", "model to use (e.g. claude, opus, gemini, gpt4.1)")
+	userFlags.Var(&flags.attach, "attach", "file or directory, relative to the repository root, whose contents to send with -prompt; directories stand for the files in them git doesn't ignore (can be repeated)")
+	userFlags.BoolVar(&flags.uncommitted, "include-uncommitted", true, "bring uncommitted changes to tracked files into the container, as a commit atop HEAD; when false, the container starts from HEAD")
+	userFlags.StringVar(&flags.resume, "resume", "", "continue the session with this id where it left off, with its conversation, usage and code; sketch history search lists past sessions")
+	userFlags.StringVar(&flags.resumeFrom, "resume-from", "", "continue the conversation recorded in this session file, saved when a -one-shot run ends; -prompt, if set, replaces the default request to carry on")
+	userFlags.StringVar(&flags.modelName, "model", "claude", "model to use (e.g. claude, opus, gemini, gpt4.1, ollama:qwen3-coder for a model served by a local Ollama, found through $OLLAMA_HOST, or azure:gpt-4.1 for an Azure OpenAI deployment)")
 	userFlags.StringVar(&flags.llmAPIKey, "llm-api-key", "", "API key for the LLM provider; if not set, will be read from an env var")
+	userFlags.StringVar(&flags.llmAPIBase, "llm-api-base", "", "base URL of the LLM provider's API, overriding the model's; for an azure: model, the endpoint of the Azure OpenAI resource, e.g. https://NAME.openai.azure.com, optionally with ?api-version=VERSION (default $AZURE_OPENAI_ENDPOINT); needs -skaband-addr=\"\"")
+	userFlags.BoolVar(&flags.anthropicLogin, "anthropic-login", false, "experimental: sign in to Anthropic in a browser and save credentials for use without an API key or sketch.dev, then exit; needs the ID of an OAuth client registered with Anthropic in $"+ant.OAuthClientIDEnv)
 	userFlags.BoolVar(&flags.listModels, "list-models", false, "list all available models and exit")
 	userFlags.BoolVar(&flags.verbose, "verbose", false, "enable verbose output")
 	userFlags.BoolVar(&flags.version, "version", false, "print the version and exit")
-	userFlags.BoolVar(&flags.doUpdate, "update", false, "update to the latest version of sketch")
+	userFlags.BoolVar(&flags.doUpdate, "update", false, "update to the latest version of sketch on its release channel; see sketch update -help")
 	userFlags.BoolVar(&flags.checkVersion, "version-check", true, "do version upgrade check (please leave this on)")
 	userFlags.BoolVar(&flags.fetchOnLaunch, "fetch-on-launch", true, "do a git fetch when sketch starts")
 	userFlags.IntVar(&flags.sshPort, "ssh-port", 0, "the host port number that the container's ssh server will listen on, or a randomly chosen port if this value is 0")
+	userFlags.StringVar(&flags.sshJump, "ssh-proxy-jump", "", "jump hosts (bastions) to reach the docker host through with ssh, as for ssh -J: [user@]host[:port][,...]; defaults to the sketch.sshProxyJump git config setting")
+	userFlags.StringVar(&flags.sshJumpKey, "ssh-jump-identity", "", "ssh identity file for the last jump host, if your ssh config doesn't provide one; defaults to the sketch.sshJumpIdentity git config setting")
+	userFlags.StringVar(&flags.sshHostName, "ssh-hostname", "", "the docker host's address as the last jump host sees it, if not the published address; defaults to the sketch.sshHostName git config setting")
 	userFlags.BoolVar(&flags.forceRebuild, "force-rebuild-container", false, "rebuild Docker container")
 	userFlags.BoolVar(&flags.forceRebuild, "rebuild", false, "rebuild Docker container (alias for -force-rebuild-container)")
 	// Get the default image info for help text
@@ -366,6 +801,13 @@ func parseCLIFlags() CLIFlags {
 	userFlags.StringVar(&flags.dockerArgs, "docker-args", "", "additional arguments to pass to the docker create command (e.g., --memory=2g --cpus=2)")
 	userFlags.Var(&flags.mounts, "mount", "volume to mount in the container in format /path/on/host:/path/in/container (can be repeated)")
 	userFlags.BoolVar(&flags.termUI, "termui", true, "enable terminal UI")
+	userFlags.BoolVar(&flags.tmux, "tmux", false, "run the terminal UI in a tmux session, or in the current one when already in tmux, with windows next to it as -tmux-windows says; they close when sketch exits")
+	userFlags.Var(&flags.matrix, "matrix", "environment to verify changes in, each in a container of its own, as NAME=IMAGE[,KEY=VALUE...] (e.g. go1.22=golang:1.22, or race=,GOFLAGS=-race for the session's image); the agent runs -matrix-verify across them on its working tree, and a -one-shot run reports each result and fails unless all pass (can be repeated)")
+	userFlags.StringVar(&flags.matrixVerify, "matrix-verify", "", "with -matrix, the shell command that verifies the changes in each environment, e.g. \"go build ./... && go test ./...\"")
+	userFlags.StringVar(&flags.tmuxWindows, "tmux-windows", "shell,diff", "with -tmux, the comma-separated windows to open once the container is up: shell, a shell in the container; diff, a live summary of the session's changes that pages the whole diff on a key press")
+	userFlags.BoolVar(&flags.uploadCrashReports, "upload-crash-reports", false, "upload reports of sketch crashes (redacted stack trace, session metadata, recent logs) to skaband; see 'sketch crash-reports'")
+	userFlags.StringVar(&flags.outputMode, "output", "auto", "how to print status outside the terminal UI: \"fancy\", \"plain\", \"ci\", \"json\" lines, or \"auto\" to detect from NO_COLOR, CI, and the terminal")
+	userFlags.StringVar(&flags.termUIMode, "termui-mode", "auto", "terminal UI rendering: \"full\", \"plain\" for dumb terminals and legacy consoles (no cursor addressing), or \"auto\" to detect from TERM")
 	userFlags.StringVar(&flags.branchPrefix, "branch-prefix", "sketch/", "prefix for git branches created by sketch")
 	userFlags.BoolVar(&flags.ignoreSig, "ignoresig", false, "ignore typical termination signals (SIGINT, SIGTERM)")
 	userFlags.Var(&flags.mcpServers, "mcp", "MCP server configuration as JSON (can be repeated). Schema: {\"name\": \"server-name\", \"type\": \"stdio|http|sse\", \"url\": \"...\", \"command\": \"...\", \"args\": [...], \"env\": {...}, \"headers\": {...}}")
@@ -389,10 +831,16 @@ func parseCLIFlags() CLIFlags {
 	internalFlags.StringVar(&flags.originalGitOrigin, "original-git-origin", "", "(internal) original git origin URL from host repository")
 	internalFlags.StringVar(&flags.upstream, "upstream", "", "(internal) upstream branch for git work")
 	internalFlags.StringVar(&flags.commit, "commit", "", "(internal) the git commit reference to check out from git remote url")
+	internalFlags.StringVar(&flags.uncommittedBase, "uncommitted-base", "", "(internal) the host's HEAD, when -commit carries the host's uncommitted changes atop it")
 	internalFlags.StringVar(&flags.outsideHTTP, "outside-http", "", "(internal) host for outside sketch")
+	internalFlags.StringVar(&flags.controlAddr, "control-addr", "", "(internal) address of outside sketch's control plane")
+	internalFlags.StringVar(&flags.image.Image, "image", "", "(internal) the image outside sketch started the container from")
+	internalFlags.StringVar(&flags.image.BaseImage, "image-base", "", "(internal) the base image of -image")
+	internalFlags.StringVar(&flags.image.BaseDigest, "image-digest", "", "(internal) the registry digest -image-base was pulled at")
 	internalFlags.BoolVar(&flags.linkToGitHub, "link-to-github", false, "(internal) enable GitHub branch linking in UI")
 	internalFlags.StringVar(&flags.sshConnectionString, "ssh-connection-string", "", "(internal) SSH connection string for connecting to the container")
 	internalFlags.BoolVar(&flags.passthroughUpstream, "passthrough-upstream", false, "(internal) configure upstream remote for passthrough to innie")
+	internalFlags.BoolVar(&flags.anthropicOAuth, "anthropic-oauth", false, "(internal) get Anthropic OAuth tokens from outside sketch")
 
 	// Developer flags
 	internalFlags.StringVar(&flags.httprrFile, "httprr", "", "if set, record HTTP interactions to file")
@@ -404,12 +852,18 @@ func parseCLIFlags() CLIFlags {
 	internalFlags.StringVar(&flags.dumpDist, "dump-dist", "", "(internal) dump embedded /dist/ filesystem to specified directory and exit")
 	internalFlags.StringVar(&flags.subtraceToken, "subtrace-token", "", "(development) run sketch under subtrace.dev with the provided token")
 	internalFlags.BoolVar(&flags.dumpLLM, "dump-llm", false, "(debugging) dump raw communications with LLM services to files in ~/.cache/sketch/")
+	internalFlags.StringVar(&flags.gitTrace, "git-trace", "", "(debugging) record the agent's and git server's git commands to this file, for sketch git-replay")
+	internalFlags.BoolVar(&flags.gitTraceOutside, "git-trace-outside", false, "(internal) send the agent's git commands to outside sketch's git trace")
 
 	// Custom usage function that shows only user-visible flags by default
 	userFlags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		userFlags.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nFor additional internal/debugging flags, use -help-internal\n")
+		fmt.Fprintf(os.Stderr, "To list or inspect crash reports, use: %s crash-reports [id]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "To diagnose problems with your setup, use: %s doctor\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "To find and remove what crashed sessions left behind, use: %s gc --sessions\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "To drive a session on another machine from this terminal, use: %s attach -remote URL\n", os.Args[0])
 	}
 
 	// Check if user requested internal help
@@ -499,16 +953,38 @@ func skabandMcpConfiguration(flags CLIFlags) string {
 	return string(out)
 }
 
+// dialForwardedPort connects a stream outtie opened for a forwarded port to
+// the port, in the container.
+func dialForwardedPort(ctx context.Context, body []byte) (io.ReadWriteCloser, error) {
+	var fwd control.PortForward
+	if err := json.Unmarshal(body, &fwd); err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(fwd.Port)))
+}
+
+// onboardingDiscovery finds what the web UI's onboarding flow starts from,
+// or returns nil for one-shot sessions, which have no user to guide.
+func onboardingDiscovery(ctx context.Context, flags CLIFlags) *onboarding.Discovery {
+	if flags.oneShot {
+		return nil
+	}
+	d := onboarding.Discover(ctx, onboarding.Options{
+		Path:        onboarding.DefaultPath(),
+		SkabandAddr: flags.skabandAddr,
+		SkabandKey:  skabandclient.DefaultKeyPath(flags.skabandAddr),
+	})
+	return &d
+}
+
 // runAsOuttie handles execution on the host machine, which typically involves
 // checking host requirements and launching a Docker container.
 func runAsOuttie(ctx context.Context, flags CLIFlags) error {
 	// Check host requirements
 	msgs, err := hostReqsCheck(flags.unsafe)
 	if flags.verbose {
-		fmt.Println("Host requirement checks:")
-		for _, m := range msgs {
-			fmt.Println(m)
-		}
+		output.Banner("Host requirement checks", msgs...)
 	}
 	if err != nil {
 		return err
@@ -531,6 +1007,16 @@ func runAsOuttie(ctx context.Context, flags CLIFlags) error {
 		return fmt.Errorf("sketch: cannot resolve working directory symlinks: %v", err)
 	}
 
+	// Resume from where the recorded run left the code, if it still exists here.
+	var resumeCommit string
+	if flags.resumeFrom != "" {
+		rec, err := loop.LoadSessionRecord(flags.resumeFrom)
+		if err != nil {
+			return err
+		}
+		resumeCommit = rec.Commit
+	}
+
 	// Configure and launch the container
 	config := dockerimg.ContainerConfig{
 		SessionID:         flags.sessionID,
@@ -540,6 +1026,7 @@ func runAsOuttie(ctx context.Context, flags CLIFlags) error {
 		ModelURL:          spec.modelURL,
 		OAIModelName:      spec.oaiModelName,
 		ModelAPIKey:       spec.apiKey,
+		AnthropicTokens:   spec.tokens,
 		Path:              cwd,
 		GitUsername:       flags.gitUsername,
 		GitEmail:          flags.gitEmail,
@@ -549,6 +1036,9 @@ func runAsOuttie(ctx context.Context, flags CLIFlags) error {
 		SketchBinaryLinux: flags.sketchBinaryLinux,
 		SketchPubKey:      pubKey,
 		SSHPort:           flags.sshPort,
+		SSHProxyJump:      flags.sshJump,
+		SSHJumpIdentity:   flags.sshJumpKey,
+		SSHHostName:       flags.sshHostName,
 		ForceRebuild:      flags.forceRebuild,
 		BaseImage:         flags.baseImage,
 		OutsideHostname:   getHostname(),
@@ -562,24 +1052,119 @@ func runAsOuttie(ctx context.Context, flags CLIFlags) error {
 		Mounts:              flags.mounts,
 		ExperimentFlag:      flags.experimentFlag.String(),
 		TermUI:              flags.termUI,
+		TermUIMode:          flags.termUIMode,
+		OutputMode:          output.Default().Mode(),
 		MaxDollars:          flags.maxDollars,
+		TurnTimeout:         flags.turnTimeout,
+		IdleSuspend:         flags.idleSuspend,
+		SnapshotTo:          flags.snapshotTo,
+		SnapshotEvery:       flags.snapshotEvery,
+		Hooks:               flags.hooks,
+		Webhook:             webhookConfig(flags),
+		GitTrace:            flags.gitTrace,
+		ConfirmCost:         flags.confirmCost,
+		AutoConfirmCost:     flags.autoConfirm,
+		MaxUploadMB:         flags.maxUploadMB,
+		NetAllowlist:        flags.netAllowlist,
+		BashAllow:           flags.bashAllow,
+		BashDeny:            flags.bashDeny,
+		Language:            flags.language,
+		AckReply:            flags.ackReply,
+		CodebaseAnalysis:    flags.codebaseScope,
+		MergeQueue:          flags.mergeQueue,
+		CommitLint:          flags.commitLint,
+		QualityGates:        flags.qualityGates,
+		DocsCheck:           flags.docsCheck,
+		BenchCheck:          flags.benchCheck,
+		GitTrailers:         flags.gitTrailers,
+		ForwardPorts:        flags.forwardPorts,
+		Compaction:          flags.compaction,
+		CloneStrategy:       flags.cloneStrategy,
+		AttachToken:         flags.attachToken,
+		OIDC:                flags.oidc,
+		OIDCClientSecret:    os.Getenv("SKETCH_OIDC_CLIENT_SECRET"),
+		Sampling:            flags.sampling,
+		RequestShaping:      flags.reqShaping,
+		BrowserProfileDir:   browserProfileDir(),
+		BrowserProfile:      flags.webProfile,
+		TurnSummaries:       flags.turnSummaries,
+		ToolResultRefs:      flags.resultRefs,
+		SlimTools:           flags.slimTools,
+		Watch:               flags.watch,
+		PlanMode:            flags.planMode,
+		ToolPermissions:     flags.toolPerms,
+		Matrix:              flags.matrix,
+		MatrixVerify:        flags.matrixVerify,
+		ShareFeedback:       flags.feedbackSync,
+		ResumeFrom:          flags.resumeFrom,
+		ResumeCommit:        resumeCommit,
+		IncludeUncommitted:  flags.uncommitted,
+		UntrustedContent:    flags.untrustedMode,
+		ImageRegistry:       flags.imageRegistry,
+		ImageRegistryPush:   flags.registryPush,
+		ImagePlatforms:      flags.platforms,
+		BuildSecrets:        flags.buildSecrets,
+		EnableTools:         flags.enableTools,
+		DisableTools:        flags.disableTools,
 		BranchPrefix:        flags.branchPrefix,
 		LinkToGitHub:        flags.linkToGitHub,
 		SubtraceToken:       flags.subtraceToken,
 		MCPServers:          flags.mcpServers,
+		Attach:              flags.attach,
 		PassthroughUpstream: flags.passthroughUpstream,
 		DumpLLM:             flags.dumpLLM,
 		FetchOnLaunch:       flags.fetchOnLaunch,
+		Onboarding:          onboardingDiscovery(ctx, flags),
+	}
+
+	// Open the browser on a splash page right away; it redirects to the
+	// session once the container is up.
+	var splash *splashPage
+	if config.OpenBrowser {
+		if splash, err = startSplashPage(); err == nil {
+			browser.Open(splash.URL())
+			config.OpenBrowser = false
+		} else {
+			slog.DebugContext(ctx, "no splash page", "error", err)
+		}
+	}
+	progress := make(chan dockerimg.ProgressEvent)
+	config.Progress = progress
+	if cockpit := newTmuxCockpit(ctx, flags, cwd); cockpit != nil {
+		go showLaunchProgress(cockpit.relay(ctx, progress), splash)
+		defer cockpit.close()
+	} else {
+		go showLaunchProgress(progress, splash)
 	}
 
-	if err := dockerimg.LaunchContainer(ctx, config); err != nil {
-		if flags.verbose {
+	// The container's crashes are copied out when it stops.
+	defer reportCrashes(ctx, flags)
+	// Point out what crashed sessions left behind, while this one runs and as it exits.
+	leakOpts := dockerimg.LeakOptions{MinAge: time.Hour, Repo: config.Path}
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	go dockerimg.WatchLeaks(watchCtx, leakOpts, leakCheckInterval, reportLeaks)
+	defer func() {
+		stopWatching()
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		if leaks, _ := dockerimg.FindLeaks(ctx, leakOpts); len(leaks) > 0 {
+			reportLeaks(leaks)
+		}
+	}()
+	for {
+		err := dockerimg.LaunchContainer(ctx, config)
+		var rebuild *dockerimg.RebuildError
+		if errors.As(err, &rebuild) {
+			if config, err = relaunchConfig(config, rebuild.Addr); err == nil {
+				output.Printf("🔁", "moving the session to a container built from the latest base image")
+				continue
+			}
+		}
+		if err != nil && flags.verbose {
 			fmt.Fprintf(os.Stderr, "dockerimg launch container failed: %v\n", err)
 		}
 		return err
 	}
-
-	return nil
 }
 
 // runInInnieMode handles execution inside the Docker container.
@@ -598,9 +1183,35 @@ func runInInnieMode(ctx context.Context, flags CLIFlags, logFile *os.File) error
 		oaiModelName: os.Getenv("SKETCH_OAI_MODEL_NAME"),
 		apiKey:       apiKey,
 	}
+	if flags.anthropicOAuth {
+		spec.tokens = &ant.HTTPTokenSource{URL: flags.outsideHTTP + "/anthropic-token"}
+	}
 	return setupAndRunAgent(ctx, flags, spec, pubKey, true, logFile)
 }
 
+// sketchServiceHosts returns the hosts sketch itself must reach from inside
+// the container, which are always allowed regardless of the network allowlist.
+func sketchServiceHosts(flags CLIFlags, spec modelSpec) []string {
+	urls := []string{flags.skabandAddr, spec.modelURL}
+	if spec.modelURL == "" {
+		switch {
+		case ant.IsClaudeModel(flags.modelName):
+			urls = append(urls, ant.DefaultURL)
+		case flags.modelName == "gemini":
+			urls = append(urls, "https://generativelanguage.googleapis.com")
+		default:
+			urls = append(urls, oai.ModelByUserName(flags.modelName).URL)
+		}
+	}
+	var hosts []string
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err == nil && parsed.Hostname() != "" {
+			hosts = append(hosts, parsed.Hostname())
+		}
+	}
+	return hosts
+}
+
 // runInUnsafeMode handles execution on the host machine without Docker.
 // This mode is used when the -unsafe flag is provided.
 func runInUnsafeMode(ctx context.Context, flags CLIFlags, logFile *os.File) error {
@@ -615,6 +1226,7 @@ type modelSpec struct {
 	modelURL     string
 	oaiModelName string // the OpenAI model name, if applicable; this varies even for the same model by provider
 	apiKey       string
+	tokens       ant.TokenSource // Anthropic OAuth tokens, used in place of apiKey
 }
 
 // resolveModel logs in to skaband (as appropriate) and resolves the flags to a model URL and API key.
@@ -628,6 +1240,16 @@ func resolveModel(flags CLIFlags) (spec modelSpec, pubKey string, err error) {
 		return modelSpec{}, "", err
 	}
 
+	if _, ok := ollama.ModelName(flags.modelName); ok {
+		modelURL = ollama.URLFromEnv()
+	}
+	if strings.HasPrefix(flags.modelName, oai.AzurePrefix) && flags.llmAPIBase == "" {
+		modelURL = os.Getenv(oai.AzureEndpointEnv)
+		if modelURL == "" {
+			return modelSpec{}, "", fmt.Errorf("%s needs the endpoint of its Azure OpenAI resource: pass -llm-api-base https://NAME.openai.azure.com or set %s", flags.modelName, oai.AzureEndpointEnv)
+		}
+	}
+	modelURL = cmp.Or(flags.llmAPIBase, modelURL)
 	if flags.skabandAddr == "" {
 		// When not using skaband, get API key from environment or flag
 		envName := envNameForModel(flags.modelName)
@@ -635,6 +1257,12 @@ func resolveModel(flags CLIFlags) (spec modelSpec, pubKey string, err error) {
 			return modelSpec{}, "", fmt.Errorf("unknown model '%s', use -list-models to see available models", flags.modelName)
 		}
 		apiKey = cmp.Or(os.Getenv(envName), flags.llmAPIKey)
+		if apiKey == "" && ant.IsClaudeModel(flags.modelName) {
+			if tokenPath, err := ant.DefaultOAuthTokenPath(); err == nil && fileExists(tokenPath) {
+				tokens := &ant.FileTokenSource{Path: tokenPath, Config: ant.DefaultOAuthConfig}
+				return modelSpec{modelURL: modelURL, tokens: tokens}, pubKey, nil
+			}
+		}
 		if apiKey == "" && envName != "NONE" {
 			return modelSpec{}, "", fmt.Errorf("%s environment variable is not set, -llm-api-key flag not provided", envName)
 		}
@@ -643,6 +1271,31 @@ func resolveModel(flags CLIFlags) (spec modelSpec, pubKey string, err error) {
 	return modelSpec{modelURL: modelURL, oaiModelName: oaiModelName, apiKey: apiKey}, pubKey, nil
 }
 
+// anthropicLogin signs in to Anthropic with the OAuth device flow and saves the resulting tokens.
+func anthropicLogin(ctx context.Context) error {
+	if ant.DefaultOAuthConfig.ClientID == "" {
+		return fmt.Errorf("-anthropic-login is experimental and needs an OAuth client registered with Anthropic; set %s to its client ID", ant.OAuthClientIDEnv)
+	}
+	path, err := ant.DefaultOAuthTokenPath()
+	if err != nil {
+		return err
+	}
+	tok, err := ant.DeviceLogin(ctx, nil, ant.DefaultOAuthConfig, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if err := ant.SaveOAuthToken(path, tok); err != nil {
+		return fmt.Errorf("failed to save credentials: %w", err)
+	}
+	fmt.Printf("Signed in. Credentials saved to %s.\nRun sketch with -skaband-addr='' to use them.\n", path)
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // setupAndRunAgent handles the common logic for setting up and running the agent
 // in both container and unsafe modes.
 func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKey string, inInsideSketch bool, logFile *os.File) error {
@@ -655,6 +1308,35 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 		go doVersionCheck(versionC, pubKey)
 	}
 
+	// Lock down the container's network before anything makes outbound requests.
+	var netPolicy *netpolicy.Policy
+	if flags.netAllowlist != "" && inInsideSketch {
+		netPolicy = netpolicy.New(append(netpolicy.ParseAllowlist(flags.netAllowlist), sketchServiceHosts(flags, spec)...))
+		if err := netpolicy.Enforce(ctx, netPolicy); err != nil {
+			return fmt.Errorf("failed to enforce network allowlist: %w", err)
+		}
+	}
+
+	// Validated in run.
+	toolFilter, _ := loop.ParseToolFilter(flags.enableTools, flags.disableTools)
+	bashPolicy, _ := bashkit.ParsePolicy(flags.bashAllow, flags.bashDeny)
+	toolPerms, _ := permission.ParsePolicy(flags.toolPerms)
+	matrixEnvs, _ := dockerimg.ParseMatrix(flags.matrix)
+	var matrix []string
+	for _, env := range matrixEnvs {
+		matrix = append(matrix, env.Name)
+	}
+	untrustedPolicy, _ := untrusted.ParsePolicy(flags.untrustedMode)
+	sampling, _ := llm.ParseSampling(flags.sampling)
+	profileDir, _ := browse.DefaultProfileDir()
+	var resume *loop.SessionRecord
+	if flags.resumeFrom != "" {
+		var err error
+		if resume, err = loop.LoadSessionRecord(flags.resumeFrom); err != nil {
+			return err
+		}
+	}
+
 	// Set the public key environment variable if provided
 	// This is needed for MCP server authentication placeholder replacement
 	if pubKey != "" {
@@ -714,13 +1396,48 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 		Upstream:            flags.upstream,
 		OutsideHTTP:         flags.outsideHTTP,
 		Commit:              flags.commit,
+		UncommittedBase:     flags.uncommittedBase,
 		BranchPrefix:        flags.branchPrefix,
 		LinkToGitHub:        flags.linkToGitHub,
 		SSHConnectionString: flags.sshConnectionString,
 		MCPServers:          flags.mcpServers,
+		TurnTimeout:         flags.turnTimeout,
+		ConfirmCost:         flags.confirmCost,
+		AutoConfirmCost:     flags.autoConfirm,
+		NetPolicy:           netPolicy,
+		Tools:               toolFilter,
+		BashPolicy:          bashPolicy,
+		Language:            flags.language,
+		AckReply:            flags.ackReply,
+		CodebaseAnalysis:    flags.codebaseScope,
+		MergeQueue:          flags.mergeQueue,
+		CommitLint:          flags.commitLint,
+		QualityGates:        flags.qualityGates,
+		DocsCheck:           flags.docsCheck,
+		BenchCheck:          flags.benchCheck,
+		GitTrailers:         flags.gitTrailers,
+		Compaction:          flags.compaction,
+		CloneStrategy:       flags.cloneStrategy,
+		TurnSummaries:       flags.turnSummaries,
+		ToolResultRefs:      flags.resultRefs,
+		SlimTools:           flags.slimTools,
+		Watch:               flags.watch,
+		PlanMode:            flags.planMode,
+		ToolPermissions:     toolPerms,
+		Matrix:              matrix,
+		MatrixVerify:        flags.matrixVerify,
+		ShareFeedback:       flags.feedbackSync,
+		UntrustedPolicy:     untrustedPolicy,
+		Resume:              resume,
+		Sampling:            sampling,
+		BrowserProfileDir:   profileDir,
+		BrowserProfile:      flags.webProfile,
 		PassthroughUpstream: flags.passthroughUpstream,
 		FetchOnLaunch:       flags.fetchOnLaunch,
 	}
+	if flags.image.Image != "" {
+		agentConfig.Image = &flags.image
+	}
 
 	// Parse timeout configuration
 	var bashTimeouts claudetool.Timeouts
@@ -745,6 +1462,45 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 	if flags.skabandAddr != "" && pubKey != "" {
 		agentConfig.SkabandClient = skabandclient.NewSkabandClient(flags.skabandAddr, pubKey)
 	}
+	var localHook *localWebhook
+	switch {
+	case inInsideSketch && flags.webhookEvents != "":
+		events, _ := webhook.ParseEvents(flags.webhookEvents)
+		agentConfig.Webhooks = &webhook.Forwarder{URL: flags.outsideHTTP + "/webhooks", Events: events}
+	case !inInsideSketch && flags.webhook != "":
+		d, err := webhook.New(webhookConfig(flags))
+		if err != nil {
+			return err
+		}
+		localHook = &localWebhook{Dispatcher: d, sessionID: flags.sessionID}
+		agentConfig.Webhooks = localHook
+	}
+	switch {
+	case inInsideSketch && flags.gitTraceOutside:
+		agentConfig.GitTrace = gittrace.NewRecorder(gittrace.Agent, "/app", gittrace.Post(flags.outsideHTTP+"/git-trace"))
+	case !inInsideSketch && flags.gitTrace != "":
+		l, err := gittrace.Create(flags.gitTrace)
+		if err != nil {
+			return fmt.Errorf("-git-trace: %w", err)
+		}
+		defer l.Close()
+		agentConfig.GitTrace = gittrace.NewRecorder(gittrace.Agent, wd, l.Write)
+	}
+	var ctl *control.Peer
+	if inInsideSketch && flags.controlAddr != "" {
+		ctl = control.NewPeer()
+		ctl.HandleStream(control.StreamPort, dialForwardedPort)
+		agentConfig.Control = ctl
+		agentConfig.ForwardPorts = flags.forwardPorts
+	}
+	if dir, err := loop.SessionRecordDir(); err != nil {
+		slog.WarnContext(ctx, "not storing the session", "error", err)
+	} else if store, history, err := loop.OpenFileStore(dir, flags.sessionID); err != nil {
+		slog.WarnContext(ctx, "not storing the session", "error", err)
+	} else {
+		defer store.Close()
+		agentConfig.Store, agentConfig.History = store, history
+	}
 	agent := loop.NewAgent(agentConfig)
 
 	// Create the server
@@ -752,29 +1508,67 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 	if err != nil {
 		return err
 	}
+	srv.SetMaxUpload(int64(flags.maxUploadMB) << 20)
+	srv.SetAttachToken(flags.attachToken)
+	if c, _ := oidc.ParseConfig(flags.oidc); c != nil {
+		c.ClientSecret = os.Getenv("SKETCH_OIDC_CLIENT_SECRET")
+		// In a container, the host sketch calls in with this token.
+		srv.SetOIDC(c, os.Getenv("SKETCH_HOST_TOKEN"))
+	}
+
+	if ctl != nil {
+		srv.HandleControl(ctl)
+	}
 
 	// Initialize the agent (only needed when not inside sketch with outside hostname)
-	// In the innie case, outtie sends a POST /init
+	// In the innie case, outtie sends init over the control plane
 	if !inInsideSketch {
 		if err = agent.Init(loop.AgentInit{}); err != nil {
 			return fmt.Errorf("failed to initialize agent: %v", err)
 		}
+		if d := onboardingDiscovery(ctx, flags); d != nil {
+			g := onboarding.NewGuide(*d)
+			srv.SetOnboarding(g)
+			defer func() {
+				if err := g.Progress().Save(onboarding.DefaultPath()); err != nil {
+					slog.WarnContext(ctx, "saving onboarding progress", "error", err)
+				}
+			}()
+		}
 	}
 
-	// Start the agent
-	go agent.Loop(ctx)
+	// Start the agent; cancelling ctx, as a shutdown does, stops it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		agent.Loop(ctx)
+	}()
 
-	// Start the local HTTP server
-	ln, err := net.Listen("tcp", flags.addr)
+	// Start the local HTTP server. Its requests end with ctx too, so that
+	// open streams don't hold up its shutdown.
+	network, address := "tcp", flags.addr
+	if path, ok := strings.CutPrefix(flags.addr, "unix:"); ok {
+		network, address = "unix", path
+	}
+	ln, err := net.Listen(network, address)
 	if err != nil {
 		return fmt.Errorf("cannot create debug server listener: %v", err)
 	}
-	go (&http.Server{Handler: srv}).Serve(ln)
+	httpServer := &http.Server{Handler: srv, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go httpServer.Serve(ln)
+	if ctl != nil {
+		// Outtie sends init once connected, so connect once the web server is serving.
+		go ctl.DialAndServe(ctx, flags.controlAddr, os.Getenv("SKETCH_CONTROL_SECRET"))
+	}
 
 	// Determine the URL to display
 	var ps1URL string
 	if flags.skabandAddr != "" {
 		ps1URL = fmt.Sprintf("%s/s/%s", flags.skabandAddr, flags.sessionID)
+	} else if network == "unix" {
+		ps1URL = flags.addr
 	} else if !agentConfig.InDocker {
 		// Do not tell users about the port inside the container, let the
 		// process running on the host report this.
@@ -787,10 +1581,28 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 			ps1URL = agent.URL()
 		}
 	}
+	if localHook != nil {
+		localHook.url.Store(&ps1URL)
+	}
 
 	// Use prompt if provided
 	if flags.prompt != "" {
+		if len(flags.attach) > 0 {
+			// Relative paths on the command line are relative to where sketch was started.
+			paths := make([]string, len(flags.attach))
+			for i, p := range flags.attach {
+				paths[i] = p
+				if !filepath.IsAbs(p) {
+					paths[i] = filepath.Join(agent.WorkingDir(), p)
+				}
+			}
+			if _, err := agent.AttachContext(ctx, paths); err != nil {
+				return fmt.Errorf("-attach: %w", err)
+			}
+		}
 		agent.UserMessage(ctx, flags.prompt)
+	} else if agentConfig.Resume != nil && !agentConfig.Resume.Rebuild {
+		agent.UserMessage(ctx, agentConfig.Resume.ContinuePrompt())
 	}
 
 	// Open the web UI URL in the system browser if requested
@@ -811,7 +1623,7 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 		if resp != nil && resp.Stdout != "" {
 			// Mild server paranoia: Limit message to 120 characters.
 			message := resp.Stdout[:min(len(resp.Stdout), 120)]
-			fmt.Printf("🦋 %s\n", message)
+			output.Printf("🦋", "%s", message)
 		}
 	default:
 		// Version check hasn't responded yet, or never ran, or hit an error. Continue without it.
@@ -819,22 +1631,26 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 
 	var s *termui.TermUI
 	if flags.termUI {
-		s = termui.New(agent, ps1URL)
+		s = termui.New(agent, ps1URL, flags.termUIMode == "plain")
 	}
 
 	// Start skaband connection loop if needed
 	if flags.skabandAddr != "" {
+		// Losing the connection is always reported, since it takes features away;
+		// connecting is only news after a disconnect, or when verbose.
+		var disconnected atomic.Bool
 		connectFn := func(connected bool) {
-			if flags.verbose {
-				if connected {
-					if s != nil {
-						s.AppendSystemMessage("skaband connected")
-					}
-				} else {
-					if s != nil {
-						s.AppendSystemMessage("skaband disconnected")
-					}
-				}
+			if s == nil {
+				return
+			}
+			switch {
+			case !connected:
+				disconnected.Store(true)
+				s.AppendSystemMessage("skaband disconnected, reconnecting; until then, unavailable: %s", strings.Join(agentConfig.SkabandClient.Status().Unavailable, ", "))
+			case disconnected.Swap(false):
+				s.AppendSystemMessage("skaband reconnected")
+			case flags.verbose:
+				s.AppendSystemMessage("skaband connected")
 			}
 		}
 		if agentConfig.SkabandClient != nil {
@@ -843,6 +1659,31 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 		}
 	}
 
+	sd := &shutdown{
+		cancel:     cancel,
+		loopDone:   loopDone,
+		srv:        srv,
+		httpServer: httpServer,
+		skaband:    agentConfig.SkabandClient,
+		logFile:    logFile,
+		saveRecord: func(ctx context.Context) { saveSessionRecord(ctx, agent, flags, inInsideSketch) },
+		begun:      make(chan struct{}),
+	}
+	defer sd.wait()
+	if localHook != nil {
+		sd.webhooks = localHook.Dispatcher
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+			defer cancel()
+			localHook.Close(ctx)
+		}()
+	}
+	go sd.watch(ctx, flags.ignoreSig, func() {
+		if s != nil {
+			s.RestoreOldState()
+		}
+	})
+
 	// Handle one-shot mode or mode without terminal UI
 	if flags.oneShot || s == nil {
 		it := agent.NewIterator(ctx, 0)
@@ -852,11 +1693,14 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 				return nil
 			}
 			if m.Content != "" {
-				fmt.Printf("[%d] 💬 %s %s: %s\n", m.Idx, m.Timestamp.Format("15:04:05"), m.Type, m.Content)
+				output.Default().Emit(output.Info, "💬", fmt.Sprintf("[%d] %s %s: %s", m.Idx, m.Timestamp.Format("15:04:05"), m.Type, m.Content),
+					map[string]any{"idx": m.Idx, "type": m.Type, "content": m.Content, "end_of_turn": m.EndOfTurn})
 			}
 			if m.EndOfTurn && m.ParentConversationID == nil {
-				fmt.Printf("Total cost: $%0.2f\n", agent.TotalUsage().TotalCostUSD)
+				cost := agent.TotalUsage().TotalCostUSD
+				output.Default().Emit(output.Info, "", fmt.Sprintf("Total cost: $%0.2f", cost), map[string]any{"total_cost_usd": cost})
 				if flags.oneShot {
+					saveSessionRecord(ctx, agent, flags, inInsideSketch)
 					return nil
 				}
 			}
@@ -881,11 +1725,10 @@ func setupAndRunAgent(ctx context.Context, flags CLIFlags, spec modelSpec, pubKe
 			panic(r)
 		}
 	}()
-	if err := s.Run(ctx); err != nil {
-		return err
-	}
-
-	return nil
+	err = s.Run(ctx)
+	sd.wait() // which saves the session record itself
+	saveSessionRecord(context.WithoutCancel(ctx), agent, flags, inInsideSketch)
+	return err
 }
 
 // setupLogging configures the logging system based on command-line flags.
@@ -907,7 +1750,7 @@ func setupLogging(termui, verbose, unsafe bool) (slog.Handler, *os.File, error)
 		return nil, nil, fmt.Errorf("cannot create log file: %v", err)
 	}
 	if unsafe {
-		fmt.Printf("structured logs: %v\n", logFile.Name())
+		output.Printf("", "structured logs: %v", logFile.Name())
 	}
 
 	slogHandler = slog.NewJSONHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug})
@@ -916,6 +1759,20 @@ func setupLogging(termui, verbose, unsafe bool) (slog.Handler, *os.File, error)
 	return slogHandler, logFile, nil
 }
 
+// browserProfileDir returns the host's browser profile directory, creating it
+// so that it can be shared with the container; "" if it cannot be.
+func browserProfileDir() string {
+	dir, err := browse.DefaultProfileDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0o700)
+	}
+	if err != nil {
+		slog.Warn("browser profiles unavailable", "error", err)
+		return ""
+	}
+	return dir
+}
+
 func getHostname() string {
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -924,7 +1781,12 @@ func getHostname() string {
 	return hostname
 }
 
+// defaultGitUsername is who the agent commits as: the repository's
+// sketch.gitUsername git config setting, or else the user.
 func defaultGitUsername() string {
+	if out, err := exec.Command("git", "config", "--get", "sketch.gitUsername").Output(); err == nil && strings.TrimSpace(string(out)) != "" {
+		return strings.TrimSpace(string(out))
+	}
 	out, err := exec.Command("git", "config", "user.name").CombinedOutput()
 	if err != nil {
 		return "Sketch🕴️" // TODO: what should this be?
@@ -932,7 +1794,11 @@ func defaultGitUsername() string {
 	return strings.TrimSpace(string(out))
 }
 
+// defaultGitEmail is the sketch.gitEmail git config setting, or else the user's email.
 func defaultGitEmail() string {
+	if out, err := exec.Command("git", "config", "--get", "sketch.gitEmail").Output(); err == nil && strings.TrimSpace(string(out)) != "" {
+		return strings.TrimSpace(string(out))
+	}
 	out, err := exec.Command("git", "config", "user.email").CombinedOutput()
 	if err != nil {
 		return "skallywag@sketch.dev" // TODO: what should this be?
@@ -946,16 +1812,20 @@ func defaultGitEmail() string {
 // Otherwise, it tries to use the OpenAI service with the specified model.
 // Returns an error if the model name is not recognized or if required configuration is missing.
 func selectLLMService(client *http.Client, flags CLIFlags, spec modelSpec) (llm.Service, error) {
+	// Validated in run.
+	shaping, _ := llm.ParseShaping(flags.reqShaping)
 	if ant.IsClaudeModel(flags.modelName) {
-		if spec.apiKey == "" {
-			return nil, fmt.Errorf("no anthropic api key provided, set %s", ant.APIKeyEnv)
+		if spec.apiKey == "" && spec.tokens == nil {
+			return nil, fmt.Errorf("no anthropic api key provided, set %s or run sketch -anthropic-login", ant.APIKeyEnv)
 		}
 		return &ant.Service{
-			HTTPC:   client,
-			URL:     spec.modelURL,
-			APIKey:  spec.apiKey,
-			DumpLLM: flags.dumpLLM,
-			Model:   ant.ClaudeModelName(flags.modelName),
+			HTTPC:       client,
+			URL:         spec.modelURL,
+			APIKey:      spec.apiKey,
+			TokenSource: spec.tokens,
+			DumpLLM:     flags.dumpLLM,
+			Model:       ant.ClaudeModelName(flags.modelName),
+			Shaping:     shaping,
 		}, nil
 	}
 
@@ -968,6 +1838,17 @@ func selectLLMService(client *http.Client, flags CLIFlags, spec modelSpec) (llm.
 			URL:     spec.modelURL,
 			Model:   gem.DefaultModel,
 			APIKey:  spec.apiKey,
+			Shaping: shaping,
+			DumpLLM: flags.dumpLLM,
+		}, nil
+	}
+
+	if name, ok := ollama.ModelName(flags.modelName); ok {
+		return &ollama.Service{
+			HTTPC:   client,
+			URL:     cmp.Or(spec.modelURL, ollama.URLFromEnv()),
+			Model:   name,
+			Shaping: shaping,
 			DumpLLM: flags.dumpLLM,
 		}, nil
 	}
@@ -993,6 +1874,7 @@ func selectLLMService(client *http.Client, flags CLIFlags, spec modelSpec) (llm.
 		Model:    model,
 		ModelURL: spec.modelURL,
 		APIKey:   apiKey,
+		Shaping:  shaping,
 		DumpLLM:  flags.dumpLLM,
 	}, nil
 }
@@ -1003,6 +1885,8 @@ func envNameForModel(modelName string) string {
 		return ant.APIKeyEnv
 	case modelName == "gemini":
 		return gem.GeminiAPIKeyEnv
+	case strings.HasPrefix(modelName, ollama.Prefix):
+		return "NONE"
 	default:
 		model := oai.ModelByUserName(modelName)
 		if model.IsZero() {
@@ -1101,14 +1985,6 @@ func getGitOrigin(ctx context.Context, dir string) string {
 	return strings.TrimSpace(string(out))
 }
 
-func doSelfUpdate() error {
-	executable, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("failed to get executable path: %w", err)
-	}
-	return update.Do(context.Background(), release, executable)
-}
-
 // zombieReaper monitors /proc for zombie processes and reaps them after 5 minutes.
 // This goroutine should only run when we are PID 1 (init process).
 func zombieReaper(ctx context.Context) {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sketch.dev/scaffold"
+)
+
+// runNew implements "sketch new", which creates a repository from a project
+// template and starts a session in it, for work that doesn't have a repository yet.
+func runNew(args []string) error {
+	var sessionArgs []string
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, sessionArgs = args[:i], args[i+1:]
+	}
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	module := fs.String("module", "", "Go module path, for the Go templates; defaults to the directory's name")
+	noStart := fs.Bool("no-start", false, "create the repository without starting a session in it")
+	list := fs.Bool("list", false, "list the built-in templates")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch new [flags] TEMPLATE DIR [WHAT TO BUILD...] [-- SKETCH FLAGS...]\n\nCreates DIR from TEMPLATE, a built-in template or a git URL to copy one from,\nmakes it a git repository, and starts a session there about building it.\n\nBuilt-in templates:\n")
+		for _, t := range scaffold.Templates {
+			fmt.Fprintf(os.Stderr, "  %-12s %s\n", t.Name, t.Description)
+		}
+		fmt.Fprintf(os.Stderr, "\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *list {
+		for _, t := range scaffold.Templates {
+			fmt.Printf("%-12s %s\n", t.Name, t.Description)
+		}
+		return nil
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source, dir := fs.Arg(0), fs.Arg(1)
+	what := strings.Join(fs.Args()[2:], " ")
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	p := scaffold.Params{Name: filepath.Base(abs), Module: *module}
+	if p.Module == "" {
+		p.Module = p.Name
+	}
+
+	ctx := context.Background()
+	var prompt string
+	if scaffold.IsRemote(source) {
+		p.Source = source
+		if err := scaffold.Fetch(ctx, source, abs); err != nil {
+			return err
+		}
+		prompt, err = scaffold.FetchedPrompt(p)
+	} else {
+		t, ok := scaffold.Lookup(source)
+		if !ok {
+			return fmt.Errorf("no template %q; see sketch new -list", source)
+		}
+		if err := t.Write(abs, p); err != nil {
+			return err
+		}
+		prompt, err = t.Prompt(p)
+	}
+	if err != nil {
+		return err
+	}
+	if err := scaffold.InitRepo(ctx, abs, fmt.Sprintf("Create %s from the %s template", p.Name, source)); err != nil {
+		return err
+	}
+	fmt.Printf("created %s from %s\n", dir, source)
+	if *noStart {
+		return nil
+	}
+
+	if what != "" {
+		prompt += "\n\nWhat I want it to do: " + what
+	} else {
+		prompt += "\n\nBefore writing any code, ask me what it should do."
+	}
+	if err := os.Chdir(abs); err != nil {
+		return err
+	}
+	os.Args = append([]string{os.Args[0], "-prompt", prompt}, sessionArgs...)
+	return run()
+}
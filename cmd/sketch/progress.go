@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	"sketch.dev/dockerimg"
+	"sketch.dev/output"
+)
+
+// showLaunchProgress reports container launch progress until events is closed.
+// Most of the launch speaks for itself on the terminal; the base image pull is
+// silent, so it gets a spinner. In JSON output mode, every event gets a line.
+// The splash page, if any, shows every event.
+func showLaunchProgress(events <-chan dockerimg.ProgressEvent, splash *splashPage) {
+	out := output.Default()
+	spin := out.Mode() == output.Fancy && term.IsTerminal(int(os.Stdout.Fd()))
+	var pulling *dockerimg.ProgressEvent
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	frame := 0
+	for {
+		select {
+		case ev, ok := <-events:
+			if splash != nil {
+				splash.update(ev, !ok)
+			}
+			if ok && out.Mode() == output.JSON {
+				out.Emit(output.Progress, "", ev.Message, map[string]any{
+					"stage":   ev.Stage,
+					"percent": ev.Percent,
+					"step":    ev.Step,
+					"steps":   ev.Steps,
+					"url":     ev.URL,
+				})
+				continue
+			}
+			if pulling != nil && (!ok || ev.Stage != dockerimg.StagePullBase) {
+				if spin {
+					fmt.Print("\r\033[K")
+				}
+				if ok {
+					output.Successf("successfully pulled base image")
+				}
+				pulling = nil
+			}
+			if !ok {
+				return
+			}
+			if ev.Stage == dockerimg.StagePullBase {
+				if pulling == nil && !spin {
+					output.Printf("🐋", "%s...", ev.Message)
+				}
+				pulling = &ev
+			}
+		case <-tick.C:
+			if pulling == nil || !spin {
+				continue
+			}
+			frame++
+			fmt.Printf("\r%c %s... %d%%\033[K", spinnerFrames[frame%len(spinnerFrames)], pulling.Message, pulling.Percent)
+		}
+	}
+}
+
+var spinnerFrames = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// A splashPage is a page served from the host while the container launches,
+// so that a browser tab can be opened right away instead of once sketch is up.
+// It shows launch progress and then redirects to the session's web UI.
+type splashPage struct {
+	ln  net.Listener
+	srv *http.Server
+
+	mu     sync.Mutex
+	events []dockerimg.ProgressEvent
+	done   bool
+}
+
+func startSplashPage() (*splashPage, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	sp := &splashPage{ln: ln, events: []dockerimg.ProgressEvent{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, splashHTML)
+	})
+	mux.HandleFunc("/progress", sp.serveProgress)
+	sp.srv = &http.Server{Handler: mux}
+	go sp.srv.Serve(ln)
+	return sp, nil
+}
+
+func (sp *splashPage) URL() string {
+	return "http://" + sp.ln.Addr().String() + "/"
+}
+
+func (sp *splashPage) update(ev dockerimg.ProgressEvent, done bool) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if done {
+		sp.done = true
+		// Leave the page up long enough for it to fetch the last event.
+		time.AfterFunc(time.Minute, func() { sp.srv.Close() })
+		return
+	}
+	sp.events = append(sp.events, ev)
+}
+
+func (sp *splashPage) serveProgress(w http.ResponseWriter, r *http.Request) {
+	sp.mu.Lock()
+	resp := struct {
+		Events []dockerimg.ProgressEvent `json:"events"`
+		Done   bool                      `json:"done"`
+	}{sp.events, sp.done}
+	data, err := json.Marshal(resp)
+	sp.mu.Unlock()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "splash progress", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+const splashHTML = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Starting sketch…</title>
+<style>
+body { font-family: system-ui, sans-serif; display: flex; justify-content: center; margin-top: 20vh; color: #333; }
+main { width: 28rem; }
+progress { width: 100%; }
+#log { color: #888; font-size: 0.9em; list-style: none; padding: 0; }
+.failed { color: #c00; }
+</style>
+</head>
+<body>
+<main>
+<h2>Starting sketch…</h2>
+<p id="status">Launching the container</p>
+<progress id="bar"></progress>
+<ul id="log"></ul>
+</main>
+<script>
+const status = document.getElementById("status");
+const bar = document.getElementById("bar");
+const log = document.getElementById("log");
+let shown = 0;
+
+async function poll() {
+  let p;
+  try {
+    p = await (await fetch("progress")).json();
+  } catch (e) {
+    status.textContent = "Lost contact with sketch; check your terminal.";
+    status.className = "failed";
+    return;
+  }
+  for (; shown < p.events.length; shown++) {
+    const ev = p.events[shown];
+    if (ev.stage === "ready") {
+      location.replace(ev.url);
+      return;
+    }
+    const li = document.createElement("li");
+    li.textContent = ev.message;
+    if (!log.lastChild || log.lastChild.textContent !== li.textContent) log.append(li);
+  }
+  const last = p.events[p.events.length - 1];
+  if (last) {
+    status.textContent = last.message;
+    if (last.percent) { bar.max = 100; bar.value = last.percent; status.textContent += " (" + last.percent + "%)"; }
+    else if (last.steps) { bar.max = last.steps; bar.value = last.step; status.textContent += " (step " + last.step + " of " + last.steps + ")"; }
+    else bar.removeAttribute("value");
+  }
+  if (p.done) {
+    status.textContent = "sketch failed to start; see your terminal for details.";
+    status.className = "failed";
+    return;
+  }
+  setTimeout(poll, 500);
+}
+poll();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"sketch.dev/dockerimg"
+	"sketch.dev/output"
+)
+
+// runRestore implements "sketch restore", which recreates the workspace of a
+// session from the snapshots -snapshot-to took of it.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	at := fs.String("at", "", "the snapshot to restore, by the time it was taken as in its folder's name (e.g. 20250601T120000Z); defaults to the latest")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch restore [-at time] URL [DIR]\n\nRecreates in DIR, by default named after the session, the git workspace of\nthe session snapshotted to URL, such as s3://bucket/prefix/SESSION-ID as sketch\nprinted at the start of the session: its branches, HEAD, uncommitted changes\nand untracked files. Files git ignored weren't snapshotted.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	url := fs.Arg(0)
+	dir := fs.Arg(1)
+	if dir == "" {
+		dir = path.Base(url)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	m, err := dockerimg.RestoreSnapshot(ctx, url, *at, dir)
+	if err != nil {
+		return err
+	}
+	where := m.Head
+	if m.Branch != "" {
+		where = m.Branch + " at " + m.Head
+	}
+	output.Printf("🛟", "restored session %s from its snapshot of %s to %s: %s, with %d untracked file(s)", m.SessionID, m.Taken.Local().Format(time.DateTime), dir, where, len(m.Untracked))
+	if len(m.Ignored) > 0 {
+		output.Printf("📦", "ignored paths weren't snapshotted, so rebuild or reinstall them: %v", m.Ignored)
+	}
+	fmt.Printf("To continue the work: cd %s && sketch\n", dir)
+	return nil
+}
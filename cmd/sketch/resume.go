@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"sketch.dev/dockerimg"
+	"sketch.dev/loop"
+	"sketch.dev/output"
+)
+
+// saveSessionRecord records a finished session so that it can be found with
+// sketch history and, for one-shot runs, retried with -resume-from. Inside a
+// container, the outtie copies the record out and tells the user about it.
+func saveSessionRecord(ctx context.Context, agent *loop.Agent, flags CLIFlags, inInsideSketch bool) {
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		slog.WarnContext(ctx, "not saving session record", "error", err)
+		return
+	}
+	rec, err := agent.SessionRecord(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "not saving session record", "error", err)
+		return
+	}
+	path := filepath.Join(dir, flags.sessionID+".json")
+	if err := rec.Save(path); err != nil {
+		slog.WarnContext(ctx, "saving session record", "error", err)
+		return
+	}
+	if flags.oneShot && !inInsideSketch {
+		output.Printf("🔁", "to retry from here: sketch -one-shot -resume-from %s [-prompt ...]", path)
+	}
+}
+
+// relaunchConfig is the config that continues a session in a container built
+// from the latest base image, from the session record the old container left
+// behind, with the web UI where it was.
+func relaunchConfig(config dockerimg.ContainerConfig, addr string) (dockerimg.ContainerConfig, error) {
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		return config, err
+	}
+	path := filepath.Join(dir, config.SessionID+".json")
+	rec, err := loop.LoadSessionRecord(path)
+	if err != nil {
+		return config, fmt.Errorf("continuing in a rebuilt container: %w", err)
+	}
+	config.ResumeFrom, config.ResumeCommit = path, rec.Commit
+	config.LocalAddr = addr
+	// The first launch sent the prompt, opened the browser and closed the progress channel.
+	config.Prompt = ""
+	config.Attach = nil
+	config.OpenBrowser = false
+	config.Progress = nil
+	config.ForceRebuild = false
+	return config, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"sketch.dev/update"
+)
+
+// runUpdate implements "sketch update", which updates sketch from its release
+// channel, after switching channels if asked to.
+func runUpdate(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	check := fs.Bool("check", false, "show the newer release and what changed since this version, without installing it")
+	channel := fs.String("channel", "", "switch to this release channel, stable, beta or nightly, for this and later updates")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch update [-check] [-channel stable|beta|nightly]\n\nUpdates sketch to the newest signed release on its channel, stable unless set.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	c, err := update.LoadChannel()
+	if err != nil {
+		return err
+	}
+	if *channel != "" {
+		if c, err = update.ParseChannel(*channel); err != nil {
+			return err
+		}
+		path, err := update.SaveChannel(c)
+		if err != nil {
+			return fmt.Errorf("saving the update channel: %w", err)
+		}
+		fmt.Printf("Update channel set to %s, in %s.\n", c, path)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return update.Do(context.Background(), release, executable, update.Options{Channel: c, Check: *check})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"sketch.dev/dockerimg"
+	"sketch.dev/loop/server"
+	"sketch.dev/skabandclient"
+	"sketch.dev/webhook"
+)
+
+// shutdownTimeout bounds a graceful shutdown; sketch exits when it runs out.
+const shutdownTimeout = 10 * time.Second
+
+// A shutdown stops a running session on SIGTERM, SIGINT or POST /end, so that
+// a supervisor can stop sketch without losing its state. It exits 0 once
+// everything has stopped, or dockerimg.ExitShutdownIncomplete if that took
+// longer than shutdownTimeout or a second signal cut it short.
+type shutdown struct {
+	cancel     context.CancelFunc // stops the agent loop, the skaband connection, and requests in flight
+	loopDone   <-chan struct{}    // closed once the agent loop returns
+	srv        *server.Server
+	httpServer *http.Server
+	skaband    *skabandclient.SkabandClient // nil without skaband
+	logFile    *os.File                     // nil when logging to stderr
+	webhooks   *webhook.Dispatcher          // nil unless -unsafe with a -webhook
+	saveRecord func(context.Context)
+	begun      chan struct{} // closed once shutting down
+}
+
+// watch waits for a reason to shut down, shuts down, and exits.
+// restoreTerminal runs just before exiting.
+func (sd *shutdown) watch(ctx context.Context, ignoreSignals bool, restoreTerminal func()) {
+	sigs := make(chan os.Signal, 2)
+	if !ignoreSignals {
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	}
+	var reason string
+	select {
+	case sig := <-sigs:
+		reason = "received " + sig.String()
+	case reason = <-sd.srv.Ended():
+	}
+	close(sd.begun)
+
+	done := make(chan int, 1)
+	go func() { done <- sd.run(ctx, reason) }()
+	var code int
+	select {
+	case code = <-done:
+	case sig := <-sigs:
+		slog.WarnContext(ctx, "exiting without finishing shutdown", "signal", sig.String())
+		code = dockerimg.ExitShutdownIncomplete
+	}
+	restoreTerminal()
+	closeCrashRecorder()
+	os.Exit(code)
+}
+
+// wait blocks, if a shutdown has begun, until it exits, so that the session
+// stopping under it doesn't return from main first.
+func (sd *shutdown) wait() {
+	select {
+	case <-sd.begun:
+		select {}
+	default:
+	}
+}
+
+// run shuts the session down and returns the exit code. The agent loop stops
+// first, so that the session record saved next is final; queued webhook
+// deliveries and skaband updates go out while the servers are still up, and the log file is synced
+// last, to keep whatever the steps before logged.
+func (sd *shutdown) run(ctx context.Context, reason string) int {
+	slog.InfoContext(ctx, "shutting down", "reason", reason)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+	code := 0
+
+	sd.cancel()
+	select {
+	case <-sd.loopDone:
+	case <-ctx.Done():
+		slog.WarnContext(ctx, "agent loop didn't stop before the shutdown timeout")
+		code = dockerimg.ExitShutdownIncomplete
+	}
+	sd.saveRecord(ctx)
+	sd.webhooks.Close(ctx)
+	if sd.skaband != nil {
+		if n := sd.skaband.Flush(ctx); n > 0 {
+			slog.WarnContext(ctx, "skaband updates not delivered before shutdown", "queued", n)
+		}
+	}
+	sd.srv.Shutdown()
+	if err := sd.httpServer.Shutdown(ctx); err != nil {
+		slog.WarnContext(ctx, "stopping HTTP server", "error", err)
+		code = dockerimg.ExitShutdownIncomplete
+	}
+	slog.InfoContext(ctx, "shut down", "exit_code", code)
+	if sd.logFile != nil {
+		sd.logFile.Sync()
+	}
+	return code
+}
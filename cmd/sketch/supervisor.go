@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"sketch.dev/supervisor"
+)
+
+// runSupervisor implements "sketch supervisor", which serves many sessions
+// from one container, each in a workspace cloned for it.
+func runSupervisor(args []string) error {
+	fs := flag.NewFlagSet("supervisor", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "address to serve the supervisor API and session UIs on; the API has no authentication, so anyone who can reach it controls every session")
+	dir := fs.String("dir", filepath.Join(os.TempDir(), "sketch-supervisor"), "directory to keep session workspaces in")
+	users := fs.Bool("session-users", false, "run each session as a unix user of its own (requires root)")
+	maxSessions := fs.Int("max-sessions", 0, "maximum number of sessions running at once; 0 means no limit")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: sketch supervisor [flags] [-- session flags]\n\nRuns sketch sessions side by side in this container, managed over HTTP.\nFlags after -- are passed to every session, e.g. -- -model=claude.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	sketchBin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	sup, err := supervisor.New(supervisor.Config{
+		Dir:         *dir,
+		Sketch:      sketchBin,
+		Args:        fs.Args(),
+		Users:       *users,
+		MaxSessions: *maxSessions,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: *addr, Handler: sup.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.InfoContext(ctx, "supervisor listening", "addr", *addr, "dir", *dir)
+	err = srv.ListenAndServe()
+	// Sessions don't outlive their supervisor; nothing would manage them.
+	sup.Close(context.Background())
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"sketch.dev/dockerimg"
+	"sketch.dev/output"
+)
+
+// tmuxWindowNames are the windows -tmux-windows may open next to the one
+// sketch's terminal UI runs in.
+var tmuxWindowNames = []string{"shell", "diff"}
+
+// parseTmuxWindows parses the -tmux-windows flag: comma-separated window names.
+func parseTmuxWindows(s string) ([]string, error) {
+	var windows []string
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(tmuxWindowNames, name) {
+			return nil, fmt.Errorf("unknown window %q, want %s", name, strings.Join(tmuxWindowNames, " or "))
+		}
+		windows = append(windows, name)
+	}
+	return windows, nil
+}
+
+// runInTmux runs sketch again, with the same arguments and session id, in a
+// new tmux session named after the session, and returns once that ends.
+// Running inside tmux, that sketch opens the other windows itself.
+func runInTmux(flags CLIFlags) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	name := "sketch-" + flags.sessionID
+	args := append([]string{"new-session", "-s", name, "-n", "sketch", "--", exe, "-session-id=" + flags.sessionID}, os.Args[1:]...)
+	cmd := exec.Command("tmux", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	// The windows outlive a sketch that crashed.
+	exec.Command("tmux", "kill-session", "-t", "="+name).Run()
+	return err
+}
+
+// A tmuxCockpit opens windows next to sketch's own in the tmux session it
+// runs in, once the container is up, and closes them when sketch exits.
+type tmuxCockpit struct {
+	cntr    string   // the container, also its ssh host
+	dir     string   // the working directory in the container
+	windows []string // to open, of tmuxWindowNames
+
+	mu     sync.Mutex
+	opened []string // tmux window ids, once open
+}
+
+// newTmuxCockpit returns the cockpit of the session, or nil if sketch doesn't
+// run in tmux or no windows are asked for.
+func newTmuxCockpit(ctx context.Context, flags CLIFlags, cwd string) *tmuxCockpit {
+	windows, _ := parseTmuxWindows(flags.tmuxWindows) // validated in run
+	if !flags.tmux || os.Getenv("TMUX") == "" || len(windows) == 0 {
+		return nil
+	}
+	dir := "/app"
+	if top := gitToplevel(ctx); top != "" {
+		if rel, err := filepath.Rel(top, cwd); err == nil && rel != "." {
+			dir = path.Join(dir, filepath.ToSlash(rel))
+		}
+	}
+	return &tmuxCockpit{cntr: "sketch-" + flags.sessionID, dir: dir, windows: windows}
+}
+
+// relay passes launch progress events on, opening the windows once sketch is ready.
+func (c *tmuxCockpit) relay(ctx context.Context, events <-chan dockerimg.ProgressEvent) <-chan dockerimg.ProgressEvent {
+	out := make(chan dockerimg.ProgressEvent)
+	go func() {
+		defer close(out)
+		for ev := range events {
+			if ev.Stage == dockerimg.StageReady {
+				c.open(ctx)
+			}
+			out <- ev
+		}
+	}()
+	return out
+}
+
+// open opens the windows, behind sketch's own, unless they are open.
+func (c *tmuxCockpit) open(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.opened != nil {
+		return
+	}
+	c.opened = []string{}
+	// ssh is how you'd get in yourself, but it may not be set up.
+	viaSSH := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", "-o", "ConnectTimeout=5", c.cntr, "true").Run() == nil
+	for _, name := range c.windows {
+		args := append([]string{"new-window", "-d", "-P", "-F", "#{window_id}", "-n", name, "--"}, c.command(name, viaSSH)...)
+		out, err := exec.CommandContext(ctx, "tmux", args...).Output()
+		if err != nil {
+			output.Warnf("could not open the tmux %s window: %v", name, err)
+			continue
+		}
+		c.opened = append(c.opened, strings.TrimSpace(string(out)))
+	}
+}
+
+// command returns the command the named window runs, in the container
+// through ssh or docker exec.
+func (c *tmuxCockpit) command(name string, viaSSH bool) []string {
+	script := "cd " + shellQuote(c.dir) + " && exec bash -l"
+	if name == "diff" {
+		// The summary stays current; the whole diff is a key press away.
+		script = "cd " + shellQuote(c.dir) + " || exit\n" +
+			"while :; do\n" +
+			"  clear\n" +
+			"  git -c color.ui=always --no-pager diff --stat sketch-base\n" +
+			"  printf '\\nPress a key to page through the whole diff.'\n" +
+			"  read -rsn1 -t 2 && git -c color.ui=always diff sketch-base | less -R\n" +
+			"done"
+	}
+	if !viaSSH {
+		return []string{"docker", "exec", "-it", c.cntr, "bash", "-c", script}
+	}
+	return []string{"ssh", "-t", c.cntr, "bash -c " + shellQuote(script)}
+}
+
+// close closes the windows open opened.
+func (c *tmuxCockpit) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range c.opened {
+		exec.Command("tmux", "kill-window", "-t", id).Run()
+	}
+}
+
+// shellQuote quotes s as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTmuxWindows(t *testing.T) {
+	if got, err := parseTmuxWindows(" diff,shell "); err != nil || !slices.Equal(got, []string{"diff", "shell"}) {
+		t.Errorf("parseTmuxWindows = %v, %v", got, err)
+	}
+	if got, err := parseTmuxWindows(""); err != nil || got != nil {
+		t.Errorf(`parseTmuxWindows("") = %v, %v`, got, err)
+	}
+	if _, err := parseTmuxWindows("shell,logs"); err == nil {
+		t.Error("parseTmuxWindows accepted an unknown window")
+	}
+}
+
+func TestTmuxCockpitCommand(t *testing.T) {
+	c := &tmuxCockpit{cntr: "sketch-abc", dir: "/app/it's here"}
+	ssh := c.command("shell", true)
+	if len(ssh) != 4 || ssh[0] != "ssh" || ssh[2] != "sketch-abc" {
+		t.Fatalf("shell over ssh = %q", ssh)
+	}
+	// ssh hands its command to the container's shell, which must see one bash -c argument.
+	if want := `bash -c 'cd '\''/app/it'\''\'\'''\''s here'\'' && exec bash -l'`; ssh[3] != want {
+		t.Errorf("remote command = %s, want %s", ssh[3], want)
+	}
+	exec := c.command("diff", false)
+	if exec[0] != "docker" || !strings.Contains(exec[len(exec)-1], "diff --stat sketch-base") {
+		t.Errorf("diff over docker exec = %q", exec)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"sync/atomic"
+
+	"sketch.dev/webhook"
+)
+
+// webhookConfig is the -webhook configuration. The secret comes from the
+// environment, so that it doesn't show up in process listings.
+func webhookConfig(flags CLIFlags) webhook.Config {
+	if flags.webhook == "" {
+		return webhook.Config{}
+	}
+	events, _ := webhook.ParseEvents(flags.webhookEvents) // validated by run
+	cfg := webhook.Config{URL: flags.webhook, Secret: os.Getenv("SKETCH_WEBHOOK_SECRET"), Events: events}
+	if p, err := webhook.DefaultLogPath(flags.sessionID); err == nil {
+		cfg.LogPath = p
+	}
+	return cfg
+}
+
+// A localWebhook delivers the events of an -unsafe session itself. In a
+// container, the agent forwards them to the outer sketch, which does this.
+type localWebhook struct {
+	*webhook.Dispatcher
+	sessionID string
+	url       atomic.Pointer[string]
+}
+
+func (w *localWebhook) Send(e webhook.Event) {
+	e.SessionID = w.sessionID
+	if u := w.url.Load(); u != nil {
+		e.URL = *u
+	}
+	w.Dispatcher.Send(e)
+}
@@ -0,0 +1,781 @@
+// Package control is the control plane between the sketch on the host and the
+// sketch in its container: a single connection, dialed by the container and
+// authenticated with a secret the host gave it, that carries requests in both
+// directions (init, health, shutdown, opening the browser, forwarding a port)
+// and the byte streams of forwarded connections.
+//
+// Heartbeats notice a connection that went quiet, and the container redials
+// one that was lost, so that a network hiccup costs the requests in flight
+// rather than the session. Requests don't survive a reconnect: Call fails
+// with ErrDisconnected, and the caller decides whether to try again.
+//
+// Each frame is a 9-byte header, a type, a uint32 id, and a uint32 payload
+// length, all big-endian, followed by the payload. Requests and streams are
+// numbered odd by the container and even by the host, so that the two sides'
+// ids never collide.
+package control
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame types.
+const (
+	frameChallenge byte = iota + 1 // host: a nonce for the container to sign
+	frameHello                     // container: the host's nonce signed, and a nonce for the host to sign
+	frameWelcome                   // host: the container's nonce signed
+	framePing
+	framePong
+	frameRequest  // method and body
+	frameResponse // status and body, or the error
+	frameOpen     // a stream: its kind and body
+	frameData     // stream bytes
+	frameWindow   // bytes of the stream the receiver consumed, which the sender may send again
+	frameClose    // of a stream; the error, if any
+)
+
+const (
+	headerSize = 9
+	// maxPayload bounds frames, so that a bad one can't exhaust memory.
+	maxPayload = 1 << 20
+	nonceSize  = 32
+	// streamWindow is how many bytes of a stream may be in flight, unread.
+	streamWindow = 256 << 10
+	// maxChunk bounds data frames, so that a busy stream doesn't hold up the others or the heartbeats.
+	maxChunk = 32 << 10
+	// handshakeTimeout bounds authenticating a connection.
+	handshakeTimeout = 10 * time.Second
+)
+
+// HeartbeatInterval is how often each side pings the other. A connection
+// that has been quiet for three intervals is taken to be lost.
+const HeartbeatInterval = 5 * time.Second
+
+// ErrDisconnected is the error of calls and streams whose connection was lost.
+var ErrDisconnected = errors.New("control connection lost")
+
+// A RemoteError is an error the other side's handler returned.
+type RemoteError struct {
+	Method string
+	Msg    string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Method, e.Msg)
+}
+
+// A Handler answers requests for a method.
+type Handler func(ctx context.Context, body []byte) ([]byte, error)
+
+// A StreamHandler accepts streams of a kind, returning what to connect them to.
+type StreamHandler func(ctx context.Context, body []byte) (io.ReadWriteCloser, error)
+
+// A Peer is one side of the control plane. It outlives its connections: calls
+// wait for one while there is none. Register the handlers before serving.
+type Peer struct {
+	// OnState, if set, is called when the peer connects and disconnects.
+	OnState func(connected bool)
+
+	heartbeat time.Duration
+
+	mu       sync.Mutex
+	handlers map[string]Handler
+	streams  map[string]StreamHandler
+	conn     *conn
+	up       chan struct{} // closed while connected
+}
+
+// NewPeer returns a Peer with no handlers and no connection.
+func NewPeer() *Peer {
+	return &Peer{
+		handlers:  make(map[string]Handler),
+		streams:   make(map[string]StreamHandler),
+		up:        make(chan struct{}),
+		heartbeat: HeartbeatInterval,
+	}
+}
+
+// Handle has h answer requests for method.
+func (p *Peer) Handle(method string, h Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[method] = h
+}
+
+// HandleStream has h accept the streams of kind.
+func (p *Peer) HandleStream(kind string, h StreamHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams[kind] = h
+}
+
+// Connected reports whether the peer has a connection.
+func (p *Peer) Connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn != nil
+}
+
+// current returns the connection, waiting for one until ctx is done.
+func (p *Peer) current(ctx context.Context) (*conn, error) {
+	for {
+		p.mu.Lock()
+		c, up := p.conn, p.up
+		p.mu.Unlock()
+		if c != nil {
+			return c, nil
+		}
+		select {
+		case <-up:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("control: not connected: %w", context.Cause(ctx))
+		}
+	}
+}
+
+// Call asks the other side to run method, and returns its answer.
+func (p *Peer) Call(ctx context.Context, method string, body []byte) ([]byte, error) {
+	c, err := p.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(ctx, method, body)
+}
+
+// CallJSON is Call with in and out encoded as JSON. A nil out ignores the answer.
+func (p *Peer) CallJSON(ctx context.Context, method string, in, out any) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	resp, err := p.Call(ctx, method, body)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(resp, out)
+}
+
+// Open opens a stream of kind to the other side, whose StreamHandler connects it.
+func (p *Peer) Open(ctx context.Context, kind string, body []byte) (*Stream, error) {
+	c, err := p.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(kind, body)
+}
+
+func (p *Peer) attach(c *conn) {
+	p.mu.Lock()
+	old := p.conn
+	p.conn = c
+	if old == nil {
+		close(p.up)
+	}
+	p.mu.Unlock()
+	if old != nil {
+		// The other side reconnected before this side noticed it was gone.
+		old.close(ErrDisconnected)
+	} else if p.OnState != nil {
+		p.OnState(true)
+	}
+}
+
+func (p *Peer) detach(c *conn) {
+	p.mu.Lock()
+	if p.conn != c {
+		p.mu.Unlock()
+		return
+	}
+	p.conn = nil
+	p.up = make(chan struct{})
+	p.mu.Unlock()
+	if p.OnState != nil {
+		p.OnState(false)
+	}
+}
+
+func (p *Peer) handler(method string) Handler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.handlers[method]
+}
+
+func (p *Peer) streamHandler(kind string) StreamHandler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.streams[kind]
+}
+
+// Serve accepts the other side's connections on ln until ctx is done. A new
+// connection replaces the one before it.
+func (p *Peer) Serve(ctx context.Context, ln net.Listener, secret string) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		go func() {
+			if err := acceptHandshake(nc, secret); err != nil {
+				slog.WarnContext(ctx, "control connection refused", "remote_addr", nc.RemoteAddr().String(), "error", err)
+				nc.Close()
+				return
+			}
+			p.run(ctx, newConn(p, nc, false))
+		}()
+	}
+}
+
+// DialAndServe connects to the other side at addr, and reconnects whenever
+// the connection is lost, until ctx is done.
+func (p *Peer) DialAndServe(ctx context.Context, addr, secret string) error {
+	const maxBackoff = 30 * time.Second
+	backoff := 100 * time.Millisecond
+	for {
+		nc, err := (&net.Dialer{Timeout: handshakeTimeout}).DialContext(ctx, "tcp", addr)
+		if err == nil {
+			if err = dialHandshake(nc, secret); err != nil {
+				nc.Close()
+			}
+		}
+		if err == nil {
+			backoff = 100 * time.Millisecond
+			p.run(ctx, newConn(p, nc, true))
+		} else {
+			slog.DebugContext(ctx, "control connection failed", "addr", addr, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (p *Peer) run(ctx context.Context, c *conn) {
+	p.attach(c)
+	err := c.serve(ctx)
+	p.detach(c)
+	if ctx.Err() == nil {
+		slog.InfoContext(ctx, "control connection lost", "error", err)
+	}
+}
+
+// sign signs nonce with secret.
+func sign(secret string, nonce []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte("sketch control v1\x00"))
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+// acceptHandshake authenticates the container, and itself to it: each signs
+// the other's nonce, so that neither learns the secret of the other's passing.
+func acceptHandshake(nc net.Conn, secret string) error {
+	nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer nc.SetDeadline(time.Time{})
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	if err := writeFrame(nc, frameChallenge, 0, nonce); err != nil {
+		return err
+	}
+	typ, _, payload, err := readFrame(nc)
+	if err != nil {
+		return err
+	}
+	if typ != frameHello || len(payload) != sha256.Size+nonceSize {
+		return errors.New("bad hello")
+	}
+	if !hmac.Equal(payload[:sha256.Size], sign(secret, nonce)) {
+		return errors.New("wrong secret")
+	}
+	return writeFrame(nc, frameWelcome, 0, sign(secret, payload[sha256.Size:]))
+}
+
+func dialHandshake(nc net.Conn, secret string) error {
+	nc.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer nc.SetDeadline(time.Time{})
+	typ, _, challenge, err := readFrame(nc)
+	if err != nil {
+		return err
+	}
+	if typ != frameChallenge || len(challenge) != nonceSize {
+		return errors.New("bad challenge")
+	}
+	nonce := make([]byte, nonceSize)
+	rand.Read(nonce)
+	if err := writeFrame(nc, frameHello, 0, sign(secret, challenge), nonce); err != nil {
+		return err
+	}
+	typ, _, payload, err := readFrame(nc)
+	if err != nil {
+		// The host hangs up on a wrong secret.
+		return fmt.Errorf("not welcomed: %w", err)
+	}
+	if typ != frameWelcome || !hmac.Equal(payload, sign(secret, nonce)) {
+		return errors.New("the host didn't prove it knows the secret")
+	}
+	return nil
+}
+
+func writeFrame(w io.Writer, typ byte, id uint32, parts ...[]byte) error {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	if n > maxPayload {
+		return fmt.Errorf("control frame of %d bytes exceeds %d", n, maxPayload)
+	}
+	buf := make([]byte, headerSize, headerSize+n)
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:5], id)
+	binary.BigEndian.PutUint32(buf[5:9], uint32(n))
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader) (typ byte, id uint32, payload []byte, err error) {
+	var h [headerSize]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(h[5:9])
+	if n > maxPayload {
+		return 0, 0, nil, fmt.Errorf("control frame of %d bytes exceeds %d", n, maxPayload)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return h[0], binary.BigEndian.Uint32(h[1:5]), payload, nil
+}
+
+// named encodes a method or stream kind, and its body.
+func named(name string, body []byte) [][]byte {
+	return [][]byte{{byte(len(name))}, []byte(name), body}
+}
+
+func parseNamed(payload []byte) (string, []byte, error) {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return "", nil, errors.New("bad name")
+	}
+	n := 1 + int(payload[0])
+	return string(payload[1:n]), payload[n:], nil
+}
+
+type result struct {
+	body []byte
+	err  error
+}
+
+// A conn is one connection between the peers.
+type conn struct {
+	p      *Peer
+	nc     net.Conn
+	wmu    sync.Mutex // serializes frames
+	nextID atomic.Uint32
+
+	mu      sync.Mutex
+	calls   map[uint32]chan result
+	methods map[uint32]string // of the calls, for their errors
+	streams map[uint32]*Stream
+	err     error // set once closed
+	done    chan struct{}
+}
+
+func newConn(p *Peer, nc net.Conn, dialer bool) *conn {
+	c := &conn{
+		p:       p,
+		nc:      nc,
+		calls:   make(map[uint32]chan result),
+		methods: make(map[uint32]string),
+		streams: make(map[uint32]*Stream),
+		done:    make(chan struct{}),
+	}
+	if dialer {
+		c.nextID.Store(math.MaxUint32) // the first id is 1
+	}
+	return c
+}
+
+func (c *conn) id() uint32 {
+	return c.nextID.Add(2)
+}
+
+func (c *conn) send(typ byte, id uint32, parts ...[]byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	// A write the other side doesn't take is as lost as a quiet connection.
+	c.nc.SetWriteDeadline(time.Now().Add(3 * c.p.heartbeat))
+	if err := writeFrame(c.nc, typ, id, parts...); err != nil {
+		c.close(err)
+		return ErrDisconnected
+	}
+	return nil
+}
+
+// close ends the connection: its calls and streams fail with ErrDisconnected.
+func (c *conn) close(err error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.err = err
+	close(c.done)
+	calls, streams := c.calls, c.streams
+	c.calls, c.streams = nil, nil
+	c.mu.Unlock()
+	c.nc.Close()
+	for _, ch := range calls {
+		ch <- result{err: ErrDisconnected}
+	}
+	for _, s := range streams {
+		s.peerClosed(ErrDisconnected)
+	}
+}
+
+// serve reads frames until the connection is lost.
+func (c *conn) serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		t := time.NewTicker(c.p.heartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.send(framePing, 0)
+			case <-c.done:
+				return
+			case <-ctx.Done():
+				c.close(ctx.Err())
+				return
+			}
+		}
+	}()
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(3 * c.p.heartbeat))
+		typ, id, payload, err := readFrame(c.nc)
+		if err != nil {
+			c.close(err)
+			return err
+		}
+		if err := c.dispatch(ctx, typ, id, payload); err != nil {
+			c.close(err)
+			return err
+		}
+	}
+}
+
+func (c *conn) dispatch(ctx context.Context, typ byte, id uint32, payload []byte) error {
+	switch typ {
+	case framePing:
+		go c.send(framePong, id)
+	case framePong:
+	case frameRequest:
+		method, body, err := parseNamed(payload)
+		if err != nil {
+			return err
+		}
+		go c.answer(ctx, id, method, body)
+	case frameResponse:
+		if len(payload) < 1 {
+			return errors.New("bad response")
+		}
+		c.mu.Lock()
+		ch, method := c.calls[id], c.methods[id]
+		delete(c.calls, id)
+		delete(c.methods, id)
+		c.mu.Unlock()
+		if ch == nil {
+			return nil // the call gave up waiting
+		}
+		if payload[0] != 0 {
+			ch <- result{err: &RemoteError{Method: method, Msg: string(payload[1:])}}
+		} else {
+			ch <- result{body: payload[1:]}
+		}
+	case frameOpen:
+		kind, body, err := parseNamed(payload)
+		if err != nil {
+			return err
+		}
+		s := c.newStream(id)
+		if s == nil {
+			return nil
+		}
+		go c.accept(ctx, s, kind, body)
+	case frameData:
+		if s := c.stream(id); s != nil {
+			return s.deliver(payload)
+		}
+	case frameWindow:
+		if len(payload) != 4 {
+			return errors.New("bad window")
+		}
+		if s := c.stream(id); s != nil {
+			s.credit(int(binary.BigEndian.Uint32(payload)))
+		}
+	case frameClose:
+		if s := c.stream(id); s != nil {
+			c.removeStream(id)
+			var err error = io.EOF
+			if len(payload) > 0 {
+				err = errors.New(string(payload))
+			}
+			s.peerClosed(err)
+		}
+	default:
+		return fmt.Errorf("unknown control frame type %d", typ)
+	}
+	return nil
+}
+
+func (c *conn) answer(ctx context.Context, id uint32, method string, body []byte) {
+	h := c.p.handler(method)
+	if h == nil {
+		c.send(frameResponse, id, []byte{1}, []byte("no such method"))
+		return
+	}
+	resp, err := h(ctx, body)
+	if err != nil {
+		c.send(frameResponse, id, []byte{1}, []byte(err.Error()))
+		return
+	}
+	c.send(frameResponse, id, []byte{0}, resp)
+}
+
+func (c *conn) call(ctx context.Context, method string, body []byte) ([]byte, error) {
+	id := c.id()
+	ch := make(chan result, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, ErrDisconnected
+	}
+	c.calls[id] = ch
+	c.methods[id] = method
+	c.mu.Unlock()
+	if err := c.send(frameRequest, id, named(method, body)...); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		return r.body, r.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		if c.calls != nil {
+			delete(c.calls, id)
+			delete(c.methods, id)
+		}
+		c.mu.Unlock()
+		return nil, context.Cause(ctx)
+	}
+}
+
+func (c *conn) open(kind string, body []byte) (*Stream, error) {
+	id := c.id()
+	s := c.newStream(id)
+	if s == nil {
+		return nil, ErrDisconnected
+	}
+	if err := c.send(frameOpen, id, named(kind, body)...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *conn) accept(ctx context.Context, s *Stream, kind string, body []byte) {
+	h := c.p.streamHandler(kind)
+	if h == nil {
+		s.closeWith("no such stream kind")
+		return
+	}
+	rwc, err := h(ctx, body)
+	if err != nil {
+		s.closeWith(err.Error())
+		return
+	}
+	Join(s, rwc)
+}
+
+func (c *conn) newStream(id uint32) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil
+	}
+	s := &Stream{c: c, id: id, window: streamWindow}
+	s.cond = sync.NewCond(&s.mu)
+	c.streams[id] = s
+	return s
+}
+
+func (c *conn) stream(id uint32) *Stream {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[id]
+}
+
+func (c *conn) removeStream(id uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.streams, id)
+}
+
+// A Stream is a byte stream to the other side, such as a forwarded
+// connection. Each direction may have streamWindow bytes in flight: writes
+// wait for the reader to catch up.
+type Stream struct {
+	c  *conn
+	id uint32
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte // received, unread
+	rerr   error  // once the other side closed the stream
+	window int    // bytes that may be sent before the reader makes room
+	closed bool
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	for len(s.buf) == 0 && s.rerr == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return 0, net.ErrClosed
+	}
+	if len(s.buf) == 0 {
+		err := s.rerr
+		s.mu.Unlock()
+		return 0, err
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	s.mu.Unlock()
+	var credit [4]byte
+	binary.BigEndian.PutUint32(credit[:], uint32(n))
+	s.c.send(frameWindow, s.id, credit[:])
+	return n, nil
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		s.mu.Lock()
+		for s.window == 0 && s.rerr == nil && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return written, net.ErrClosed
+		}
+		if s.rerr != nil {
+			s.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		n := min(len(b), s.window, maxChunk)
+		s.window -= n
+		s.mu.Unlock()
+		if err := s.c.send(frameData, s.id, b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Close closes the stream in both directions.
+func (s *Stream) Close() error {
+	return s.closeWith("")
+}
+
+func (s *Stream) closeWith(msg string) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	peerGone := s.rerr != nil
+	s.mu.Unlock()
+	s.c.removeStream(s.id)
+	if !peerGone {
+		s.c.send(frameClose, s.id, []byte(msg))
+	}
+	return nil
+}
+
+func (s *Stream) deliver(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf)+len(p) > streamWindow {
+		return errors.New("control stream overran its window")
+	}
+	s.buf = append(s.buf, p...)
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *Stream) credit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window += n
+	s.cond.Broadcast()
+}
+
+func (s *Stream) peerClosed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rerr == nil {
+		s.rerr = err
+	}
+	s.cond.Broadcast()
+}
+
+// Join copies between a and b until either ends, then closes both.
+func Join(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	cp := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
@@ -0,0 +1,270 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pair serves a host peer, whose secret is "secret", and connects a container
+// peer to it with secret, once setup has registered their handlers.
+func pair(t *testing.T, secret string, setup func(host, container *Peer)) (host, container *Peer) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	host, container = NewPeer(), NewPeer()
+	if setup != nil {
+		setup(host, container)
+	}
+	go host.Serve(ctx, ln, "secret")
+	go container.DialAndServe(ctx, ln.Addr().String(), secret)
+	return host, container
+}
+
+func timeout(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCall(t *testing.T) {
+	host, container := pair(t, "secret", func(host, container *Peer) {
+		host.Handle("browser-open", func(ctx context.Context, body []byte) ([]byte, error) {
+			return append([]byte("opened "), body...), nil
+		})
+		container.Handle("health", func(ctx context.Context, body []byte) ([]byte, error) {
+			return []byte(`{"ready":true}`), nil
+		})
+		container.Handle("fail", func(ctx context.Context, body []byte) ([]byte, error) {
+			return nil, errors.New("it broke")
+		})
+	})
+	ctx := timeout(t)
+
+	// The container calls the host...
+	got, err := container.Call(ctx, "browser-open", []byte("http://localhost"))
+	if err != nil || string(got) != "opened http://localhost" {
+		t.Errorf("browser-open = %q, %v", got, err)
+	}
+	// ...and the host the container.
+	var health struct{ Ready bool }
+	if err := host.CallJSON(ctx, "health", nil, &health); err != nil || !health.Ready {
+		t.Errorf("health = %+v, %v", health, err)
+	}
+	var re *RemoteError
+	if _, err := host.Call(ctx, "fail", nil); !errors.As(err, &re) || re.Msg != "it broke" || re.Method != "fail" {
+		t.Errorf("fail = %v", err)
+	}
+	if _, err := host.Call(ctx, "nope", nil); !errors.As(err, &re) {
+		t.Errorf("an unknown method = %v", err)
+	}
+	if !host.Connected() || !container.Connected() {
+		t.Errorf("Connected() = %v, %v", host.Connected(), container.Connected())
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	host, container := pair(t, "guess", func(host, container *Peer) {
+		container.Handle("health", func(ctx context.Context, body []byte) ([]byte, error) {
+			return nil, nil
+		})
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := host.Call(ctx, "health", nil); err == nil {
+		t.Error("a container with the wrong secret answered a call")
+	}
+	if container.Connected() {
+		t.Error("a container with the wrong secret connected")
+	}
+}
+
+func TestStream(t *testing.T) {
+	host, _ := pair(t, "secret", func(host, container *Peer) {
+		container.HandleStream("echo", func(ctx context.Context, body []byte) (io.ReadWriteCloser, error) {
+			a, b := net.Pipe()
+			go func() {
+				io.Copy(b, b)
+				b.Close()
+			}()
+			return a, nil
+		})
+		container.HandleStream("refuse", func(ctx context.Context, body []byte) (io.ReadWriteCloser, error) {
+			return nil, errors.New("nothing listens on port " + string(body))
+		})
+	})
+	ctx := timeout(t)
+
+	s, err := host.Open(ctx, "echo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Several windows' worth, so that the writer waits for the reader.
+	want := bytes.Repeat([]byte("0123456789abcdef"), 4*streamWindow/16)
+	go func() {
+		s.Write(want)
+	}()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(s, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("the stream's echo differs from what was written")
+	}
+	s.Close()
+
+	s, err = host.Open(ctx, "refuse", []byte("8080"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Read(make([]byte, 1)); err == nil || !strings.Contains(err.Error(), "port 8080") {
+		t.Errorf("reading a refused stream = %v", err)
+	}
+}
+
+// A proxy forwards connections, and can stop forwarding them without closing
+// them, as a network that stopped delivering packets would.
+type proxy struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	frozen chan struct{}
+}
+
+func newProxy(t *testing.T, target string) *proxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	p := &proxy{ln: ln}
+	go func() {
+		for {
+			in, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			out, err := net.Dial("tcp", target)
+			if err != nil {
+				in.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.frozen = make(chan struct{})
+			frozen := p.frozen
+			p.mu.Unlock()
+			cp := func(dst, src net.Conn) {
+				buf := make([]byte, 4096)
+				for {
+					n, err := src.Read(buf)
+					select {
+					case <-frozen:
+						return
+					default:
+					}
+					if err != nil {
+						dst.Close()
+						return
+					}
+					dst.Write(buf[:n])
+				}
+			}
+			go cp(in, out)
+			go cp(out, in)
+		}
+	}()
+	return p
+}
+
+// freeze stops delivering the current connection's bytes.
+func (p *proxy) freeze() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	close(p.frozen)
+}
+
+func TestReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := timeout(t)
+	host, container := NewPeer(), NewPeer()
+	host.heartbeat, container.heartbeat = 20*time.Millisecond, 20*time.Millisecond
+	var mu sync.Mutex
+	var states []bool
+	container.OnState = func(connected bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, connected)
+	}
+	block := make(chan struct{})
+	container.Handle("health", func(ctx context.Context, body []byte) ([]byte, error) {
+		if string(body) == "block" {
+			<-block
+		}
+		return []byte("ok"), nil
+	})
+	defer close(block)
+	p := newProxy(t, ln.Addr().String())
+	go host.Serve(ctx, ln, "secret")
+	go container.DialAndServe(ctx, p.ln.Addr().String(), "secret")
+
+	if _, err := host.Call(ctx, "health", nil); err != nil {
+		t.Fatal(err)
+	}
+	// A call in flight when the network goes quiet fails once the heartbeats
+	// stop, and the container reconnects.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := host.Call(ctx, "health", []byte("block"))
+		errc <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	p.freeze()
+	if err := <-errc; !errors.Is(err, ErrDisconnected) {
+		t.Errorf("a call across a lost connection = %v", err)
+	}
+	if got, err := host.Call(ctx, "health", nil); err != nil || string(got) != "ok" {
+		t.Errorf("health after reconnecting = %q, %v", got, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) < 3 || !states[0] || states[1] || !states[2] {
+		t.Errorf("container states = %v, want connected, disconnected, connected", states)
+	}
+}
+
+func TestFrames(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, frameRequest, 7, named("init", []byte("{}"))...); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != headerSize+1+len("init")+2 {
+		t.Errorf("frame of %d bytes", buf.Len())
+	}
+	typ, id, payload, err := readFrame(&buf)
+	if err != nil || typ != frameRequest || id != 7 {
+		t.Fatalf("readFrame = %d, %d, %v", typ, id, err)
+	}
+	if method, body, err := parseNamed(payload); err != nil || method != "init" || string(body) != "{}" {
+		t.Errorf("parseNamed = %q, %q, %v", method, body, err)
+	}
+	if err := writeFrame(&buf, frameData, 1, make([]byte, maxPayload+1)); err == nil {
+		t.Error("wrote an oversized frame")
+	}
+	// A header announcing an oversized payload is refused before reading it.
+	hdr := []byte{frameData, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff}
+	if _, _, _, err := readFrame(bytes.NewReader(hdr)); err == nil {
+		t.Error("read an oversized frame")
+	}
+}
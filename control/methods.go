@@ -0,0 +1,44 @@
+package control
+
+// The methods the host calls in the container.
+const (
+	// MethodInit hands the container its address and SSH keys, as a
+	// server.InitRequest. It initializes an agent that an earlier host
+	// process already initialized again, with the new settings.
+	MethodInit = "init"
+	// MethodHealth answers a Health.
+	MethodHealth = "health"
+	// MethodShutdown asks the container to end the session and exit.
+	MethodShutdown = "shutdown"
+)
+
+// The methods the container calls in the host.
+const (
+	// MethodBrowserOpen opens the session's web UI on the host. It takes no
+	// URL: the container is untrusted.
+	MethodBrowserOpen = "browser-open"
+	// MethodPortForward forwards a port on the host's loopback interface to
+	// a PortForward's port in the container, answering a PortForwarded.
+	MethodPortForward = "port-forward"
+)
+
+// StreamPort is the kind of the streams the host opens for each connection
+// to a forwarded port; their body is a PortForward.
+const StreamPort = "port"
+
+// Health is how the container is doing.
+type Health struct {
+	Ready        bool   `json:"ready"` // whether the agent is initialized
+	State        string `json:"state"` // the agent's state, e.g. "WaitingForUserInput"
+	MessageCount int    `json:"message_count"`
+}
+
+// A PortForward names a port in the container.
+type PortForward struct {
+	Port int `json:"port"`
+}
+
+// PortForwarded is where the host forwards a port from.
+type PortForwarded struct {
+	Addr string `json:"addr"` // e.g. "127.0.0.1:8080"
+}
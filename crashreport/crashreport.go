@@ -0,0 +1,333 @@
+// Package crashreport records sketch crashes as local report files, with secrets
+// and home directories redacted, and optionally uploads them.
+//
+// Install arranges for the Go runtime to copy fatal panic output to a pending
+// file next to a metadata file describing the session. The process can't write
+// a report as it dies, so Collect, run by the next sketch invocation, turns
+// pending files with crash output into reports.
+package crashreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// logTailLines is how many lines of the session log a report keeps.
+const logTailLines = 200
+
+// staleAfter is how long pending files without crash output are kept;
+// they belong to sessions that are still running or were killed.
+const staleAfter = 7 * 24 * time.Hour
+
+// Metadata describes the session a crash happened in.
+type Metadata struct {
+	SessionID   string    `json:"session_id"`
+	Version     string    `json:"version"`
+	Model       string    `json:"model,omitempty"`
+	InContainer bool      `json:"in_container"`
+	GOOS        string    `json:"goos"`
+	GOARCH      string    `json:"goarch"`
+	StartedAt   time.Time `json:"started_at"`
+	LogPath     string    `json:"log_path,omitempty"` // not included in reports
+}
+
+// Report is a collected crash.
+type Report struct {
+	ID       string    `json:"id"`
+	Metadata Metadata  `json:"metadata"`
+	Time     time.Time `json:"time"`     // when the crash was collected; the crash itself happened earlier
+	Panic    string    `json:"panic"`    // first line of the crash output, e.g. "panic: runtime error: ..."
+	Stack    string    `json:"stack"`    // redacted crash output
+	LogTail  []string  `json:"log_tail"` // redacted last lines of the session log
+	Uploaded bool      `json:"uploaded"` // whether the report was sent to skaband
+	Path     string    `json:"-"`        // where the report is stored
+}
+
+// Dir returns the directory crash reports are stored in.
+func Dir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "sketch", "crashes"), nil
+}
+
+// A Recorder captures crash output for the running process.
+type Recorder struct {
+	crash *os.File
+	meta  string
+}
+
+// Install starts recording fatal crashes of this process into dir.
+// Call Close on a clean exit to discard the pending files.
+func Install(dir string, meta Metadata) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if meta.GOOS == "" {
+		meta.GOOS, meta.GOARCH = runtime.GOOS, runtime.GOARCH
+	}
+	if meta.StartedAt.IsZero() {
+		meta.StartedAt = time.Now()
+	}
+	id := fmt.Sprintf("%s-%d", meta.StartedAt.UTC().Format("20060102T150405"), os.Getpid())
+	b, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{meta: filepath.Join(dir, id+".meta")}
+	if err := os.WriteFile(r.meta, b, 0o600); err != nil {
+		return nil, err
+	}
+	r.crash, err = os.OpenFile(filepath.Join(dir, id+".crash"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		os.Remove(r.meta)
+		return nil, err
+	}
+	if err := debug.SetCrashOutput(r.crash, debug.CrashOptions{}); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close stops recording and removes the pending files.
+func (r *Recorder) Close() error {
+	debug.SetCrashOutput(nil, debug.CrashOptions{})
+	r.crash.Close()
+	return errors.Join(os.Remove(r.crash.Name()), os.Remove(r.meta))
+}
+
+// Collect turns pending crash output in dir into reports, and returns the new reports.
+func Collect(dir string) ([]*Report, error) {
+	metas, err := filepath.Glob(filepath.Join(dir, "*.meta"))
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	var errs []error
+	for _, metaPath := range metas {
+		id := strings.TrimSuffix(filepath.Base(metaPath), ".meta")
+		crashPath := filepath.Join(dir, id+".crash")
+		output, err := os.ReadFile(crashPath)
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			continue
+		}
+		if len(bytes.TrimSpace(output)) == 0 {
+			if fi, err := os.Stat(metaPath); err == nil && time.Since(fi.ModTime()) > staleAfter {
+				os.Remove(metaPath)
+				os.Remove(crashPath)
+			}
+			continue
+		}
+		r, err := newReport(id, metaPath, output)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.Path = filepath.Join(dir, id+".json")
+		if err := r.Save(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		os.Remove(metaPath)
+		os.Remove(crashPath)
+		os.Remove(filepath.Join(dir, id+".log")) // copied in by Import, if any
+		reports = append(reports, r)
+	}
+	return reports, errors.Join(errs...)
+}
+
+// Import copies pending crash output from src, such as a directory copied out of a
+// stopped container, into dir for Collect. copyLog copies a session's log file,
+// named by its path where the session ran, to dst.
+func Import(src, dir string, copyLog func(logPath, dst string) error) error {
+	metas, err := filepath.Glob(filepath.Join(src, "*.meta"))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, metaPath := range metas {
+		id := strings.TrimSuffix(filepath.Base(metaPath), ".meta")
+		output, err := os.ReadFile(filepath.Join(src, id+".crash"))
+		if err != nil || len(bytes.TrimSpace(output)) == 0 {
+			continue
+		}
+		var meta Metadata
+		b, err := os.ReadFile(metaPath)
+		if err == nil {
+			err = json.Unmarshal(b, &meta)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("crash metadata %s: %w", metaPath, err))
+			continue
+		}
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if meta.LogPath != "" {
+			dst := filepath.Join(dir, id+".log")
+			if err := copyLog(meta.LogPath, dst); err == nil {
+				meta.LogPath = dst
+			} else {
+				meta.LogPath = ""
+			}
+		}
+		b, _ = json.MarshalIndent(meta, "", "  ")
+		if err := os.WriteFile(filepath.Join(dir, id+".crash"), output, 0o600); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, id+".meta"), b, 0o600); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newReport(id, metaPath string, output []byte) (*Report, error) {
+	r := &Report{ID: id, Time: time.Now()}
+	b, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &r.Metadata); err != nil {
+		return nil, fmt.Errorf("crash metadata %s: %w", metaPath, err)
+	}
+	r.Stack = Redact(string(output))
+	r.Panic, _, _ = strings.Cut(strings.TrimSpace(r.Stack), "\n")
+	if r.Metadata.LogPath != "" {
+		if tail, err := logTail(r.Metadata.LogPath, logTailLines); err == nil {
+			for _, line := range tail {
+				r.LogTail = append(r.LogTail, Redact(line))
+			}
+		}
+		r.Metadata.LogPath = ""
+	}
+	return r, nil
+}
+
+// Save writes the report to its Path.
+func (r *Report) Save() error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.Path, b, 0o600)
+}
+
+// List returns the reports in dir, newest first.
+func List(dir string) ([]*Report, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var reports []*Report
+	for _, path := range paths {
+		r, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	slices.SortFunc(reports, func(a, b *Report) int { return b.Time.Compare(a.Time) })
+	return reports, nil
+}
+
+// Load reads the report at path.
+func Load(path string) (*Report, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Path: path}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("crash report %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Upload sends the report to skaband and marks it uploaded.
+func (r *Report) Upload(ctx context.Context, skabandAddr, publicKey string) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, skabandAddr+"/crash-report", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Public-Key", publicKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading crash report: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("uploading crash report: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	r.Uploaded = true
+	return r.Save()
+}
+
+// logTail returns the last n lines of the file at path.
+func logTail(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// Logs can be large; only the end matters.
+	const maxTail = 1 << 20
+	if fi, err := f.Stat(); err == nil && fi.Size() > maxTail {
+		f.Seek(-maxTail, io.SeekEnd)
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`sk-ant-[A-Za-z0-9_-]+`), "[REDACTED]"},
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`), "[REDACTED]"},
+	{regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{20,}`), "[REDACTED]"},
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`), "$1 [REDACTED]"},
+	{regexp.MustCompile(`(?i)((?:api[_-]?key|token|secret|password|passwd)["']?\s*[:=]\s*["']?)[^\s"',}]+`), "${1}[REDACTED]"},
+	{regexp.MustCompile(`(https?://)[^/\s:@]+:[^/\s@]+@`), "${1}[REDACTED]@"},
+}
+
+// Redact removes credentials and the user's home directory from s.
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	if home, err := os.UserHomeDir(); err == nil && len(home) > 1 {
+		s = strings.ReplaceAll(s, home, "~")
+	}
+	return s
+}
@@ -0,0 +1,152 @@
+package crashreport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	tests := []struct{ in, want string }{
+		{"key sk-ant-api03-abcDEF_123", "key [REDACTED]"},
+		{"Authorization: Bearer abc.def", "Authorization: Bearer [REDACTED]"},
+		{`{"api_key": "hunter2", "x": 1}`, `{"api_key": "[REDACTED]", "x": 1}`},
+		{"SKETCH_MODEL_API_KEY=abc123 next", "SKETCH_MODEL_API_KEY=[REDACTED] next"},
+		{"push https://user:pw@github.com/o/r.git", "push https://[REDACTED]@github.com/o/r.git"},
+		{filepath.Join(home, "src/main.go:12"), "~/src/main.go:12"},
+		{"main.main()\n\tmain.go:5 +0x1d", "main.main()\n\tmain.go:5 +0x1d"},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// TestCrash runs a subprocess that panics with a Recorder installed, then collects its report.
+func TestCrash(t *testing.T) {
+	if dir := os.Getenv("CRASHREPORT_TEST_DIR"); dir != "" {
+		logPath := filepath.Join(dir, "session.log")
+		os.WriteFile(logPath, []byte("starting\nusing token=abc123\n"), 0o600)
+		if _, err := Install(dir, Metadata{SessionID: "s1", Version: "test", LogPath: logPath}); err != nil {
+			panic(err)
+		}
+		go panic("boom")
+		select {}
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCrash$")
+	cmd.Env = append(os.Environ(), "CRASHREPORT_TEST_DIR="+dir)
+	if out, err := cmd.CombinedOutput(); err == nil {
+		t.Fatalf("subprocess should have crashed:\n%s", out)
+	}
+
+	reports, err := Collect(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reports))
+	}
+	r := reports[0]
+	if r.Panic != "panic: boom" {
+		t.Errorf("Panic = %q", r.Panic)
+	}
+	if !strings.Contains(r.Stack, "goroutine") {
+		t.Errorf("Stack has no goroutine trace:\n%s", r.Stack)
+	}
+	if r.Metadata.SessionID != "s1" || r.Metadata.LogPath != "" {
+		t.Errorf("Metadata = %+v", r.Metadata)
+	}
+	if got := strings.Join(r.LogTail, "\n"); got != "starting\nusing token=[REDACTED]" {
+		t.Errorf("LogTail = %q", got)
+	}
+
+	// Collecting again finds nothing new, and List finds the saved report.
+	if again, err := Collect(dir); err != nil || len(again) != 0 {
+		t.Errorf("second Collect = %d reports, %v", len(again), err)
+	}
+	listed, err := List(dir)
+	if err != nil || len(listed) != 1 || listed[0].ID != r.ID {
+		t.Fatalf("List = %v, %v", listed, err)
+	}
+}
+
+func TestCleanExit(t *testing.T) {
+	dir := t.TempDir()
+	rec, err := Install(dir, Metadata{SessionID: "s1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("pending files left behind: %v", entries)
+	}
+}
+
+func TestImport(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	meta, _ := json.Marshal(Metadata{SessionID: "s2", InContainer: true, LogPath: "/tmp/sketch-cli-log-1"})
+	os.WriteFile(filepath.Join(src, "a.meta"), meta, 0o600)
+	os.WriteFile(filepath.Join(src, "a.crash"), []byte("panic: container\n"), 0o600)
+	os.WriteFile(filepath.Join(src, "b.meta"), meta, 0o600) // still running: no crash output
+	os.WriteFile(filepath.Join(src, "b.crash"), nil, 0o600)
+
+	var copied []string
+	err := Import(src, dst, func(logPath, to string) error {
+		copied = append(copied, logPath)
+		return os.WriteFile(to, []byte("container log\n"), 0o600)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copied) != 1 || copied[0] != "/tmp/sketch-cli-log-1" {
+		t.Errorf("copied logs %v", copied)
+	}
+	reports, err := Collect(dst)
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("Collect = %v, %v", reports, err)
+	}
+	if r := reports[0]; r.Panic != "panic: container" || len(r.LogTail) != 1 || !r.Metadata.InContainer {
+		t.Errorf("report = %+v", r)
+	}
+	if entries, _ := os.ReadDir(dst); len(entries) != 1 {
+		t.Errorf("want only the report left in %s, got %v", dst, entries)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var got Report
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/crash-report" || r.Header.Get("Public-Key") != "pub" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	r := &Report{ID: "c1", Panic: "panic: x", Path: filepath.Join(t.TempDir(), "c1.json")}
+	if err := r.Upload(t.Context(), ts.URL, "pub"); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != "c1" {
+		t.Errorf("server got %+v", got)
+	}
+	saved, err := Load(r.Path)
+	if err != nil || !saved.Uploaded {
+		t.Errorf("saved report = %+v, %v", saved, err)
+	}
+}
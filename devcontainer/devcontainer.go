@@ -0,0 +1,167 @@
+// Package devcontainer describes a running sketch container to IDEs, so that
+// attaching VS Code or a JetBrains IDE to it takes one click.
+//
+// The description is a devcontainer.json in the attached-container form (see
+// https://containers.dev/implementors/json_reference/), which both VS Code's
+// Dev Containers extension and JetBrains Gateway understand, plus links that
+// open each IDE on the container directly.
+package devcontainer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// Config is the subset of devcontainer.json that matters for attaching to a
+// container sketch already runs.
+type Config struct {
+	Name            string         `json:"name"`
+	WorkspaceFolder string         `json:"workspaceFolder"`
+	RemoteUser      string         `json:"remoteUser"`
+	Customizations  Customizations `json:"customizations"`
+}
+
+// Customizations holds the settings specific to each IDE.
+type Customizations struct {
+	VSCode    VSCode    `json:"vscode"`
+	JetBrains JetBrains `json:"jetbrains"`
+}
+
+// VSCode lists the extensions to install in the container.
+type VSCode struct {
+	Extensions []string `json:"extensions"`
+}
+
+// JetBrains names the IDE backend Gateway should run in the container.
+type JetBrains struct {
+	Backend string `json:"backend,omitempty"` // e.g. "GoLand"; empty lets the user pick
+}
+
+// Info is served at /devcontainer.
+type Info struct {
+	Config     Config `json:"config"`
+	VSCodeURI  string `json:"vscode_uri"`  // opens the workspace in VS Code, attached to the container
+	GatewayURL string `json:"gateway_url"` // opens the workspace in JetBrains Gateway over ssh
+}
+
+// A Target identifies the container to attach to.
+type Target struct {
+	Container       string // docker container name
+	SSHHost         string // ssh host the user's ssh config knows the container by
+	WorkspaceFolder string // directory to open, inside the container
+	RepoDir         string // a checkout of the repo, to pick extensions by what's in it
+}
+
+// ecosystems maps a file at the repo root to the IDE support its language needs.
+var ecosystems = []struct {
+	file       string
+	extensions []string
+	backend    string // JetBrains IDE
+	product    string // its Gateway product code
+}{
+	{"go.mod", []string{"golang.go"}, "GoLand", "GO"},
+	{"Cargo.toml", []string{"rust-lang.rust-analyzer"}, "RustRover", "RR"},
+	{"pyproject.toml", []string{"ms-python.python"}, "PyCharm", "PY"},
+	{"requirements.txt", []string{"ms-python.python"}, "PyCharm", "PY"},
+	{"package.json", []string{"dbaeumer.vscode-eslint", "esbenp.prettier-vscode"}, "WebStorm", "WS"},
+}
+
+// New describes t.
+func New(t Target) *Info {
+	cfg := Config{
+		Name:            t.Container,
+		WorkspaceFolder: t.WorkspaceFolder,
+		RemoteUser:      "root",
+	}
+	exts := recommendedExtensions(t.RepoDir)
+	product := ""
+	for _, e := range ecosystems {
+		if _, err := os.Stat(filepath.Join(t.RepoDir, e.file)); err != nil {
+			continue
+		}
+		exts = append(exts, e.extensions...)
+		if cfg.Customizations.JetBrains.Backend == "" {
+			cfg.Customizations.JetBrains.Backend = e.backend
+			product = e.product
+		}
+	}
+	cfg.Customizations.VSCode.Extensions = dedup(exts)
+
+	return &Info{
+		Config:     cfg,
+		VSCodeURI:  VSCodeURI(t.Container, t.WorkspaceFolder),
+		GatewayURL: GatewayURL(t.SSHHost, t.WorkspaceFolder, product),
+	}
+}
+
+// recommendedExtensions returns the extensions the repo itself recommends
+// in .vscode/extensions.json. Files with comments are skipped.
+func recommendedExtensions(repoDir string) []string {
+	data, err := os.ReadFile(filepath.Join(repoDir, ".vscode", "extensions.json"))
+	if err != nil {
+		return nil
+	}
+	var rec struct {
+		Recommendations []string `json:"recommendations"`
+	}
+	if json.Unmarshal(data, &rec) != nil {
+		return nil
+	}
+	return rec.Recommendations
+}
+
+func dedup(ss []string) []string {
+	out := []string{}
+	seen := make(map[string]bool)
+	for _, s := range ss {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// VSCodeURI returns a link that opens folder in a VS Code window attached to container
+// through the Dev Containers extension.
+func VSCodeURI(container, folder string) string {
+	target, _ := json.Marshal(map[string]string{"containerName": "/" + container})
+	return "vscode://vscode-remote/attached-container+" + hex.EncodeToString(target) + folder
+}
+
+// GatewayURL returns a JetBrains Gateway link that opens folder on host over ssh.
+// product is a Gateway product code such as "GO"; empty lets the user pick the IDE.
+func GatewayURL(host, folder, product string) string {
+	q := url.Values{}
+	q.Set("type", "ssh")
+	q.Set("deploy", "true")
+	q.Set("host", host)
+	q.Set("port", "22")
+	q.Set("user", "root")
+	q.Set("projectPath", folder)
+	if product != "" {
+		q.Set("productCode", product)
+	}
+	return "jetbrains-gateway://connect#" + q.Encode()
+}
+
+// Write writes info's devcontainer.json under dir, where IDEs look for it,
+// and returns its path.
+func Write(dir string, info *Info) (string, error) {
+	data, err := json.MarshalIndent(info.Config, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, ".devcontainer", "devcontainer.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("writing devcontainer.json: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,56 @@
+package devcontainer
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	repo := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod":                  "module example.com/m\n",
+		"package.json":            "{}",
+		".vscode/extensions.json": `{"recommendations": ["golang.go", "eamodio.gitlens"]}`,
+	} {
+		path := filepath.Join(repo, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := New(Target{Container: "sketch-abc", SSHHost: "sketch-abc", WorkspaceFolder: "/app/web", RepoDir: repo})
+	cfg := info.Config
+	if cfg.WorkspaceFolder != "/app/web" || cfg.RemoteUser != "root" {
+		t.Errorf("config = %+v", cfg)
+	}
+	wantExts := []string{"golang.go", "eamodio.gitlens", "dbaeumer.vscode-eslint", "esbenp.prettier-vscode"}
+	if !slices.Equal(cfg.Customizations.VSCode.Extensions, wantExts) {
+		t.Errorf("extensions = %v, want %v", cfg.Customizations.VSCode.Extensions, wantExts)
+	}
+	if cfg.Customizations.JetBrains.Backend != "GoLand" {
+		t.Errorf("backend = %q, want GoLand", cfg.Customizations.JetBrains.Backend)
+	}
+
+	target := hex.EncodeToString([]byte(`{"containerName":"/sketch-abc"}`))
+	if want := "vscode://vscode-remote/attached-container+" + target + "/app/web"; info.VSCodeURI != want {
+		t.Errorf("VSCodeURI = %q, want %q", info.VSCodeURI, want)
+	}
+	for _, part := range []string{"host=sketch-abc", "projectPath=%2Fapp%2Fweb", "productCode=GO", "type=ssh"} {
+		if !strings.Contains(info.GatewayURL, part) {
+			t.Errorf("GatewayURL %q lacks %q", info.GatewayURL, part)
+		}
+	}
+
+	path, err := Write(t.TempDir(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(path, filepath.Join(".devcontainer", "devcontainer.json")) {
+		t.Errorf("wrote %s", path)
+	}
+}
@@ -0,0 +1,177 @@
+package dockerimg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// buildOptions are the BuildKit features a layered image build uses.
+// The zero value is a plain single-platform build.
+type buildOptions struct {
+	platforms []string      // target platforms, e.g. linux/amd64; empty builds for the host only
+	secrets   []buildSecret // files mounted into the go mod download steps
+	cacheFrom string        // image to reuse layers from, if any
+	push      string        // if set, build for all platforms and push to this ref instead of loading
+	// noGitObjects leaves the repository's git objects out of the image, for
+	// clone strategies that get them from the git server instead.
+	noGitObjects bool
+}
+
+// keyBase returns the part of the image cache key that stands for the base
+// image, which is baseKey unless the image differs in more than its base.
+func (o buildOptions) keyBase(baseKey string) string {
+	if o.noGitObjects {
+		return baseKey + " without git objects"
+	}
+	return baseKey
+}
+
+// A buildSecret is a host file made available to build steps without being stored in the image.
+type buildSecret struct {
+	id  string
+	src string
+}
+
+// secretTargets are where well-known secrets are mounted, so that the tools
+// fetching private modules find them without further configuration.
+var secretTargets = map[string]string{
+	"netrc":           "/root/.netrc",
+	"gitconfig":       "/root/.gitconfig",
+	"git-credentials": "/root/.git-credentials",
+	"npmrc":           "/root/.npmrc",
+}
+
+var secretIDRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ParseBuildSecrets parses -build-secret values of the form ID=PATH, e.g. netrc=~/.netrc.
+// The files must exist.
+func ParseBuildSecrets(specs []string) ([]buildSecret, error) {
+	var secrets []buildSecret
+	for _, spec := range specs {
+		id, src, ok := strings.Cut(spec, "=")
+		if !ok || !secretIDRe.MatchString(id) || src == "" {
+			return nil, fmt.Errorf("build secret %q: want ID=PATH, e.g. netrc=~/.netrc", spec)
+		}
+		if rest, ok := strings.CutPrefix(src, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("build secret %s: %w", id, err)
+			}
+			src = filepath.Join(home, rest)
+		}
+		if _, err := os.Stat(src); err != nil {
+			return nil, fmt.Errorf("build secret %s: %w", id, err)
+		}
+		secrets = append(secrets, buildSecret{id: id, src: src})
+	}
+	return secrets, nil
+}
+
+// target is where the secret is mounted in build steps.
+func (s buildSecret) target() string {
+	if t, ok := secretTargets[s.id]; ok {
+		return t
+	}
+	return path.Join("/run/secrets", s.id)
+}
+
+// secretMounts returns the RUN flags that mount the secrets, with a trailing space, or "".
+func (o buildOptions) secretMounts() string {
+	var b strings.Builder
+	for _, s := range o.secrets {
+		fmt.Fprintf(&b, "--mount=type=secret,id=%s,target=%s ", s.id, s.target())
+	}
+	return b.String()
+}
+
+// ValidateImagePlatforms checks a comma-separated list of platforms such as "linux/amd64,linux/arm64".
+func ValidateImagePlatforms(spec string) error {
+	_, err := parsePlatforms(spec)
+	return err
+}
+
+var platformRe = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
+
+func parsePlatforms(spec string) ([]string, error) {
+	var platforms []string
+	for _, p := range strings.Split(spec, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !platformRe.MatchString(p) {
+			return nil, fmt.Errorf("image platform %q: want os/arch, such as linux/arm64", p)
+		}
+		if !slices.Contains(platforms, p) {
+			platforms = append(platforms, p)
+		}
+	}
+	return platforms, nil
+}
+
+// resolveImagePlatforms returns the platforms to build shared images for.
+// An empty spec falls back to the sketch.imagePlatforms git config setting,
+// so that a whole team builds the same set.
+func resolveImagePlatforms(ctx context.Context, gitRoot, spec string) ([]string, error) {
+	if spec == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.imagePlatforms")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		spec = strings.TrimSpace(string(out))
+	}
+	return parsePlatforms(spec)
+}
+
+// buildArgs returns the docker arguments for building dockerfilePath as imgName.
+// Multi-platform builds can't be loaded into the local image store,
+// so they go through buildx and straight to the registry.
+func (o buildOptions) buildArgs(imgName, dockerfilePath string, buildArgs ...string) []string {
+	args := []string{"build"}
+	if o.push != "" {
+		args = []string{"buildx", "build", "--platform", strings.Join(o.platforms, ","), "--push", "-t", o.push}
+	} else {
+		args = append(args, "-t", imgName)
+	}
+	args = append(args, "-f", dockerfilePath)
+	for _, a := range buildArgs {
+		args = append(args, "--build-arg", a)
+	}
+	if o.cacheFrom != "" {
+		// Inline cache metadata lets the next build, on any machine, reuse the pushed layers.
+		args = append(args, "--cache-from", o.cacheFrom, "--build-arg", "BUILDKIT_INLINE_CACHE=1")
+	}
+	for _, s := range o.secrets {
+		args = append(args, "--secret", "id="+s.id+",src="+s.src)
+	}
+	return append(args, ".")
+}
+
+// baseImageDigest returns the registry digest of baseImage, which unlike
+// its image ID is the same on every architecture. It falls back to the
+// image ID for images that were never pulled from a registry.
+func baseImageDigest(ctx context.Context, baseImage, baseImageID string) string {
+	out, err := combinedOutput(ctx, "docker", "inspect", "--format", `{{join .RepoDigests "\n"}}`, baseImage)
+	if err != nil {
+		return baseImageID
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if _, digest, ok := strings.Cut(first, "@"); ok {
+		return digest
+	}
+	return baseImageID
+}
+
+// requireBuildx returns an error if docker buildx isn't installed.
+func requireBuildx(ctx context.Context) error {
+	if out, err := combinedOutput(ctx, "docker", "buildx", "version"); err != nil {
+		return fmt.Errorf("multi-platform image builds need docker buildx: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
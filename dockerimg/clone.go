@@ -0,0 +1,116 @@
+package dockerimg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sketch.dev/loop"
+)
+
+// Automatic clone strategies go by the size of the repository's git objects:
+// copying them into the image is simplest up to fullCloneMax; beyond that a
+// partial clone keeps the history without the old file contents, until the
+// history itself is too large and only its recent part is worth having.
+const (
+	fullCloneMax    = 1 << 30 // 1 GiB
+	partialCloneMax = 8 << 30
+	// shallowHistory is how far back a shallow clone goes, unless told a date.
+	shallowHistory = 365 * 24 * time.Hour
+)
+
+// chooseCloneStrategy resolves the -clone-strategy setting spec for the
+// repository at gitRoot. It also returns what the choice costs, for verbose output.
+func chooseCloneStrategy(ctx context.Context, gitRoot, spec string) (loop.CloneStrategy, string, error) {
+	clone, err := loop.ParseCloneStrategy(spec)
+	if err != nil {
+		return clone, "", err
+	}
+	size, err := gitObjectsSize(ctx, gitRoot)
+	if err != nil {
+		return clone, "", err
+	}
+	if clone.Kind == loop.CloneAuto {
+		switch {
+		case size <= fullCloneMax:
+			clone.Kind = loop.CloneFull
+		case size <= partialCloneMax:
+			clone.Kind = loop.ClonePartial
+		default:
+			clone.Kind = loop.CloneShallow
+		}
+	}
+	if clone.Kind == loop.CloneShallow && clone.Since.IsZero() {
+		clone.Since = time.Now().Add(-shallowHistory).UTC().Truncate(24 * time.Hour)
+	}
+
+	var tradeoffs string
+	switch clone.Kind {
+	case loop.CloneFull:
+		tradeoffs = fmt.Sprintf("copying all %s of git objects into the image: the clone is fast and complete, but the image is as large as the history", humanBytes(size))
+	case loop.ClonePartial:
+		tradeoffs = fmt.Sprintf("partial clone of %s of git objects from the git server: all commits, but old file contents are fetched as needed, so log -p and blame are slower", humanBytes(size))
+	case loop.CloneShallow:
+		tradeoffs = fmt.Sprintf("shallow clone of the history since %s from the git server: a fraction of the %s of git objects, but older commits are missing from log, blame and merge bases", clone.Since.Format(time.DateOnly), humanBytes(size))
+	}
+	return clone, tradeoffs, nil
+}
+
+// gitObjectsSize returns the size of the git objects of the repository at gitRoot, in bytes.
+func gitObjectsSize(ctx context.Context, gitRoot string) (int64, error) {
+	cmd := exec.CommandContext(ctx, "git", "count-objects", "-v")
+	cmd.Dir = gitRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("git count-objects: %w", err)
+	}
+	var kib int64
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		key, val, _ := strings.Cut(sc.Text(), ": ")
+		if key == "size" || key == "size-pack" {
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("git count-objects: %q: %w", sc.Text(), err)
+			}
+			kib += n
+		}
+	}
+	return kib << 10, nil
+}
+
+// writeBlobs writes the git blobs with the given hashes into dir, each in a file named by its hash.
+func writeBlobs(ctx context.Context, gitRoot, dir string, shas []string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, sha := range shas {
+		cmd := exec.CommandContext(ctx, "git", "cat-file", "blob", sha)
+		cmd.Dir = gitRoot
+		out, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("git cat-file blob %s: %w", sha, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, sha), out, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func humanBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	}
+	return fmt.Sprintf("%d KiB", n>>10)
+}
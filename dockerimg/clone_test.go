@@ -0,0 +1,86 @@
+package dockerimg
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/loop"
+)
+
+// cloneTestRepo makes a repository with a file committed in 2020 and changed in 2024.
+func cloneTestRepo(t *testing.T) string {
+	t.Helper()
+	repo := t.TempDir()
+	git := func(date string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("", "init", "-q", "-b", "main")
+	os.WriteFile(filepath.Join(repo, "notes.txt"), []byte("old\n"), 0o644)
+	git("2020-01-01T00:00:00Z", "add", ".")
+	git("2020-01-01T00:00:00Z", "commit", "-q", "-m", "old")
+	os.WriteFile(filepath.Join(repo, "notes.txt"), []byte("new\n"), 0o644)
+	git("2024-01-01T00:00:00Z", "commit", "-q", "-am", "new")
+	return repo
+}
+
+func TestChooseCloneStrategy(t *testing.T) {
+	ctx := context.Background()
+	repo := cloneTestRepo(t)
+	for spec, want := range map[string]loop.CloneKind{"": loop.CloneFull, "auto": loop.CloneFull, "partial": loop.ClonePartial} {
+		clone, tradeoffs, err := chooseCloneStrategy(ctx, repo, spec)
+		if err != nil || clone.Kind != want || tradeoffs == "" {
+			t.Errorf("chooseCloneStrategy(%q) = %v, %q, %v; want %s", spec, clone, tradeoffs, err, want)
+		}
+	}
+	clone, tradeoffs, err := chooseCloneStrategy(ctx, repo, "shallow")
+	if err != nil || clone.Since.Before(time.Now().Add(-shallowHistory-48*time.Hour)) || !strings.Contains(tradeoffs, clone.Since.Format(time.DateOnly)) {
+		t.Errorf("shallow = %v, %q, %v", clone, tradeoffs, err)
+	}
+	if _, _, err := chooseCloneStrategy(ctx, repo, "deep"); err == nil {
+		t.Error("chooseCloneStrategy accepted deep")
+	}
+}
+
+// TestCloneStrategiesOverGitHTTP checks that the git server supports the clones the strategies make.
+func TestCloneStrategiesOverGitHTTP(t *testing.T) {
+	repo := cloneTestRepo(t)
+	srv := httptest.NewServer(&gitHTTP{gitRepoRoot: repo, pass: []byte("test-pass")})
+	defer srv.Close()
+	remote := strings.Replace(srv.URL, "http://", "http://sketch:test-pass@", 1) + "/.git"
+
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	partial := filepath.Join(t.TempDir(), "app")
+	git(".", "clone", "-q", "--filter=blob:none", remote, partial)
+	if missing := git(partial, "rev-list", "--objects", "--missing=print", "HEAD~1"); !strings.Contains(missing, "?") {
+		t.Errorf("partial clone has the old blob:\n%s", missing)
+	}
+	if got := git(partial, "show", "HEAD~1:notes.txt"); got != "old" {
+		t.Errorf("partial clone: old notes.txt = %q", got)
+	}
+
+	shallow := filepath.Join(t.TempDir(), "app")
+	git(".", "clone", "-q", "--shallow-since=2022-01-01", remote, shallow)
+	if n := git(shallow, "rev-list", "--count", "HEAD"); n != "1" {
+		t.Errorf("shallow clone has %s commits, want 1", n)
+	}
+}
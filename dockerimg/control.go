@@ -0,0 +1,186 @@
+package dockerimg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sketch.dev/browser"
+	"sketch.dev/control"
+	"sketch.dev/loop/server"
+)
+
+// containerShutdownTimeout bounds waiting for the container to stop once
+// asked to: a little longer than sketch in it gives its own shutdown.
+const containerShutdownTimeout = 15 * time.Second
+
+// controlInitTimeout bounds waiting for sketch in the container to connect
+// and initialize.
+const controlInitTimeout = time.Minute
+
+// hostControl is the host's end of the control plane to the container. It
+// opens the browser and forwards ports for the container, and sends it init
+// and shutdown.
+type hostControl struct {
+	ctx      context.Context
+	peer     *control.Peer
+	ln       net.Listener
+	port     string
+	secret   string
+	ps1URL   *atomic.Pointer[string] // the web UI, once sketch is ready
+	browserC chan struct{}           // browser launch requests
+
+	mu       sync.Mutex
+	forwards map[int]net.Listener // by container port
+}
+
+func newHostControl(ctx context.Context, ps1URL *atomic.Pointer[string]) (*hostControl, error) {
+	ln, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("control listen: %w", err)
+	}
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("control port: %w", err)
+	}
+	hc := &hostControl{
+		ctx:      ctx,
+		peer:     control.NewPeer(),
+		ln:       ln,
+		port:     port,
+		secret:   rand.Text(),
+		ps1URL:   ps1URL,
+		browserC: make(chan struct{}, 1),
+		forwards: make(map[int]net.Listener),
+	}
+	hc.peer.Handle(control.MethodBrowserOpen, hc.openBrowser)
+	hc.peer.Handle(control.MethodPortForward, hc.forwardPort)
+	hc.peer.OnState = func(connected bool) {
+		slog.InfoContext(ctx, "container control connection", "connected", connected)
+	}
+	return hc, nil
+}
+
+// serve accepts the container's control connections until hc.ctx is done.
+func (hc *hostControl) serve() error {
+	go func() {
+		for {
+			select {
+			case <-hc.browserC:
+				browser.Open(*hc.ps1URL.Load())
+			case <-hc.ctx.Done():
+				return
+			}
+		}
+	}()
+	err := hc.peer.Serve(hc.ctx, hc.ln, hc.secret)
+	hc.mu.Lock()
+	for _, ln := range hc.forwards {
+		ln.Close()
+	}
+	hc.mu.Unlock()
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// init hands sketch in the container its address and SSH keys, once it connects.
+func (hc *hostControl) init(ctx context.Context, req server.InitRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, controlInitTimeout)
+	defer cancel()
+	if err := hc.peer.CallJSON(ctx, control.MethodInit, req, nil); err != nil {
+		return fmt.Errorf("failed to initialize sketch in container: %w", err)
+	}
+	var h control.Health
+	if err := hc.peer.CallJSON(ctx, control.MethodHealth, nil, &h); err != nil {
+		return fmt.Errorf("sketch in container: %w", err)
+	}
+	if !h.Ready {
+		return fmt.Errorf("sketch in container isn't ready after init (%s)", h.State)
+	}
+	return nil
+}
+
+// shutdown asks sketch in the container to end the session, and waits for
+// the container to stop, so that the session record is complete by the time
+// the cleanup copies it out.
+func (hc *hostControl) shutdown(ctx context.Context, cntrName string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerShutdownTimeout)
+	defer cancel()
+	if _, err := hc.peer.Call(ctx, control.MethodShutdown, nil); err != nil {
+		slog.WarnContext(ctx, "asking the container to shut down", "error", err)
+		return
+	}
+	if out, err := combinedOutput(ctx, "docker", "wait", cntrName); err != nil {
+		slog.WarnContext(ctx, "waiting for the container to stop", "out", string(out), "error", err)
+	}
+}
+
+func (hc *hostControl) openBrowser(ctx context.Context, body []byte) ([]byte, error) {
+	if hc.ps1URL.Load() == nil {
+		return nil, errors.New("sketch isn't ready")
+	}
+	select {
+	case hc.browserC <- struct{}{}:
+		slog.InfoContext(ctx, "open browser requested")
+		return nil, nil
+	default:
+		return nil, errors.New("too many browser launch requests")
+	}
+}
+
+// forwardPort listens on the host's loopback interface for the container's
+// port: on the same port if it is free, and on any other if not. Forwarding
+// a port twice answers the same address.
+func (hc *hostControl) forwardPort(ctx context.Context, body []byte) ([]byte, error) {
+	var req control.PortForward
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if req.Port < 1 || req.Port > 65535 {
+		return nil, fmt.Errorf("bad port %d", req.Port)
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	ln := hc.forwards[req.Port]
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp4", fmt.Sprintf("127.0.0.1:%d", req.Port)); err != nil {
+			if ln, err = net.Listen("tcp4", "127.0.0.1:0"); err != nil {
+				return nil, err
+			}
+		}
+		hc.forwards[req.Port] = ln
+		go hc.serveForward(ln, body)
+		slog.InfoContext(ctx, "forwarding port", "container_port", req.Port, "host_addr", ln.Addr().String())
+	}
+	return json.Marshal(control.PortForwarded{Addr: ln.Addr().String()})
+}
+
+// serveForward connects each connection to ln to the container's port, as a
+// stream over the control plane.
+func (hc *hostControl) serveForward(ln net.Listener, fwd []byte) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			s, err := hc.peer.Open(hc.ctx, control.StreamPort, fwd)
+			if err != nil {
+				c.Close()
+				return
+			}
+			control.Join(c, s)
+		}()
+	}
+}
@@ -16,17 +16,29 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/mod/modfile"
 	"sketch.dev/browser"
+	"sketch.dev/crashreport"
+	"sketch.dev/devcontainer"
 	"sketch.dev/embedded"
+	"sketch.dev/gittrace"
+	"sketch.dev/hooks"
+	"sketch.dev/llm/ant"
+	"sketch.dev/loop"
 	"sketch.dev/loop/server"
+	"sketch.dev/onboarding"
+	"sketch.dev/output"
 	"sketch.dev/skribe"
+	"sketch.dev/webhook"
 )
 
 // ContainerConfig holds all configuration for launching a container
@@ -85,6 +97,12 @@ type ContainerConfig struct {
 	// Host port for the container's ssh server
 	SSHPort int
 
+	// How ssh reaches a docker host behind a bastion; see SSHRoute. Empty values use the
+	// sketch.sshProxyJump, sketch.sshJumpIdentity and sketch.sshHostName git config settings.
+	SSHProxyJump    string
+	SSHJumpIdentity string
+	SSHHostName     string
+
 	// Outside information to pass to the container
 	OutsideHostname   string
 	OutsideOS         string
@@ -96,6 +114,9 @@ type ContainerConfig struct {
 	// Initial prompt
 	Prompt string
 
+	// Attach lists the files and directories whose contents go with Prompt
+	Attach []string
+
 	// Verbose enables verbose output
 	Verbose bool
 
@@ -111,9 +132,172 @@ type ContainerConfig struct {
 	// TermUI enables terminal UI
 	TermUI bool
 
+	// TermUIMode is the resolved -termui-mode: "full" or "plain"
+	TermUIMode string
+
+	// OutputMode is the resolved -output mode, which the container's prints follow too
+	OutputMode output.Mode
+
 	// Budget configuration
 	MaxDollars float64
 
+	// TurnTimeout is the wall-clock limit for a single agent turn; zero means no limit
+	TurnTimeout time.Duration
+
+	// ConfirmCost is the -confirm-cost threshold in dollars; AutoConfirmCost skips asking
+	ConfirmCost     float64
+	AutoConfirmCost bool
+
+	// MaxUploadMB limits the size of files uploaded through the web UI
+	MaxUploadMB int
+
+	// AnthropicTokens, if set, supplies OAuth access tokens to the container in place of ModelAPIKey
+	AnthropicTokens ant.TokenSource
+
+	// NetAllowlist, if non-empty, restricts the container's egress to these
+	// comma-separated domains (see the netpolicy package)
+	NetAllowlist string
+
+	// BashAllow and BashDeny restrict the commands the agent's bash tool may run
+	// (see bashkit.ParsePolicy)
+	BashAllow string
+	BashDeny  string
+
+	// Language is the language the agent converses in; empty means English
+	Language string
+
+	// AckReply answers acknowledgment-only messages without the model; empty disables it
+	AckReply string
+
+	// CodebaseAnalysis is the -codebase-analysis setting: "full", "off", or directories to analyze
+	CodebaseAnalysis string
+
+	// EnableTools and DisableTools are the -enable-tools and -disable-tools settings
+	EnableTools  string
+	DisableTools string
+
+	// MergeQueue is the -merge-queue setting: "github", a queue service URL, or empty
+	MergeQueue string
+
+	// CommitLint is the -commit-lint setting; empty uses the sketch.commitLint git config setting
+	CommitLint string
+
+	// QualityGates is the -quality-gates setting; empty uses the sketch.qualityGates git config setting
+	QualityGates string
+
+	// DocsCheck is the -docs-check setting; empty uses the sketch.docsCheck git config setting
+	DocsCheck string
+
+	// BenchCheck is the -bench-check setting; empty uses the sketch.benchCheck git config setting
+	BenchCheck string
+
+	// GitTrailers is the -git-trailers setting; empty uses the sketch.gitTrailers git config setting
+	GitTrailers string
+
+	// Compaction is the -compaction setting
+	Compaction string
+
+	// IdleSuspend, if positive, pauses the container once its agent has been idle
+	// this long, until the user next interacts with it
+	IdleSuspend time.Duration
+
+	// SnapshotTo, if set, is the s3:// or gs:// URL the workspace is
+	// snapshotted to every SnapshotEvery, for sketch restore to recreate
+	SnapshotTo    string
+	SnapshotEvery time.Duration
+
+	// Onboarding, if set, is what the web UI guides the user through setting up,
+	// whose progress is saved to onboarding.DefaultPath when the session ends
+	Onboarding *onboarding.Discovery
+
+	// Hooks are the -hook settings, EVENT=COMMAND host commands run on lifecycle events
+	Hooks []string
+
+	// Webhook, if its URL is set, receives the agent's events, sent from the host with its secret
+	Webhook webhook.Config
+
+	// GitTrace, if set, is the file that the agent's and the git server's git commands are recorded to
+	GitTrace string
+
+	// AttachToken, if set, lets "sketch attach -remote" clients presenting it drive the session
+	AttachToken string
+
+	// OIDC is the -oidc setting, which requires signing in to the web UI, and
+	// OIDCClientSecret the provider's client secret
+	OIDC             string
+	OIDCClientSecret string
+
+	// hostToken, with OIDC, is how this process reaches the container's server without signing in
+	hostToken string
+
+	// controlAddr is where the container reaches this process's control plane, and controlSecret how it authenticates
+	controlAddr   string
+	controlSecret string
+
+	// ForwardPorts forwards the ports that open in the container to the host's loopback interface
+	ForwardPorts bool
+
+	// Sampling is the -sampling setting, e.g. "temperature=0,seed=42"
+	Sampling string
+
+	// RequestShaping is the -request-shaping setting, e.g. "max_tokens=32000"
+	RequestShaping string
+
+	// BrowserProfileDir is the host's browser profile directory, shared with
+	// the container so that sessions can save state for later ones
+	BrowserProfileDir string
+	// BrowserProfile is the -browser-profile setting
+	BrowserProfile string
+
+	// TurnSummaries is the -turn-summaries setting
+	TurnSummaries bool
+
+	// ToolResultRefs is the -tool-result-refs setting
+	ToolResultRefs bool
+
+	// SlimTools is the -slim-tools setting
+	SlimTools bool
+
+	// Watch is the -watch setting
+	Watch bool
+
+	// ShareFeedback is the -share-feedback setting
+	ShareFeedback bool
+
+	// PlanMode is the -plan setting
+	PlanMode bool
+
+	// ToolPermissions is the -tool-permissions setting
+	ToolPermissions string
+
+	// Matrix are the -matrix settings, NAME=IMAGE[,KEY=VALUE...] environments to
+	// run MatrixVerify in, the -matrix-verify setting; see ParseMatrix
+	Matrix       []string
+	MatrixVerify string
+
+	// UntrustedContent is the -untrusted-content setting: "strip" or "block"
+	UntrustedContent string
+
+	// ResumeFrom is the host path of a session record to continue (-resume-from)
+	ResumeFrom string
+	// ResumeCommit is the commit the recorded run ended at; the container starts
+	// from it instead of HEAD if the host repo has it
+	ResumeCommit string
+
+	// IncludeUncommitted is the -include-uncommitted setting
+	IncludeUncommitted bool
+
+	// ImageRegistry is the -image-registry setting: a repository to share layered images
+	// through, "off", or empty to use the sketch.imageRegistry git config setting
+	ImageRegistry string
+	// ImageRegistryPush is whether to push layered images built locally to ImageRegistry
+	ImageRegistryPush bool
+	// ImagePlatforms is the -image-platforms setting: the comma-separated platforms to build
+	// shared images for, or empty to use the sketch.imagePlatforms git config setting
+	ImagePlatforms string
+	// BuildSecrets are the -build-secret settings, ID=PATH files mounted while fetching dependencies
+	BuildSecrets []string
+
 	GitRemoteUrl string
 
 	// Original git origin URL from the host repository
@@ -125,9 +309,15 @@ type ContainerConfig struct {
 	// Commit hash to checkout from GetRemoteUrl
 	Commit string
 
+	// UncommittedBase is the host's HEAD when Commit carries uncommitted changes atop it
+	UncommittedBase string
+
 	// Outtie's HTTP server
 	OutsideHTTP string
 
+	// Image is the image the container runs, which LaunchContainer finds or builds
+	Image loop.ImageProvenance
+
 	// Prefix for git branches created by sketch
 	BranchPrefix string
 
@@ -148,12 +338,25 @@ type ContainerConfig struct {
 
 	// FetchOnLaunch enables git fetch during initialization
 	FetchOnLaunch bool
+
+	// CloneStrategy is the -clone-strategy setting; see loop.ParseCloneStrategy.
+	// LaunchContainer resolves it to the strategy the container uses.
+	CloneStrategy string
+
+	// Progress, if set, receives events as the container is built and launched.
+	// LaunchContainer closes it once the container is ready or launching fails.
+	Progress chan<- ProgressEvent
 }
 
 // LaunchContainer creates a docker container for a project, installs sketch and opens a connection to it.
 // It writes status to stdout.
 func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	slog.Debug("Container Config", slog.String("config", fmt.Sprintf("%+v", config)))
+	progress := newProgressReporter(ctx, config.Progress)
+	defer progress.close()
+	if config.OIDC != "" {
+		config.hostToken = rand.Text()
+	}
 	if _, err := exec.LookPath("docker"); err != nil {
 		if runtime.GOOS == "darwin" {
 			return fmt.Errorf("cannot find `docker` binary; run: brew install docker colima && colima start")
@@ -173,6 +376,16 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	if err != nil {
 		return err
 	}
+	// To suspend the container when idle, sketch's web server is reached through
+	// a proxy that resumes it, listening where the web server would have.
+	var proxyLn net.Listener
+	if config.IdleSuspend > 0 {
+		if proxyLn, err = net.Listen("tcp", config.LocalAddr); err != nil {
+			return err
+		}
+		defer proxyLn.Close()
+		hostPort = "0"
+	}
 	// Bail early if sketch was started from a path that isn't in a git repo.
 	err = requireGitRepo(ctx, config.Path)
 	if err != nil {
@@ -193,16 +406,102 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		config.PassthroughUpstream = true
 	}
 
-	imgName, err := findOrBuildDockerImage(ctx, gitRoot, config.BaseImage, config.ForceRebuild, config.Verbose)
+	// The container's clone doesn't carry the repo's git config, so resolve the policy here.
+	if config.CommitLint == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.commitLint")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		config.CommitLint = strings.TrimSpace(string(out))
+	}
+	if config.QualityGates == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.qualityGates")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		config.QualityGates = strings.TrimSpace(string(out))
+	}
+	if config.DocsCheck == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.docsCheck")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		config.DocsCheck = strings.TrimSpace(string(out))
+	}
+	if config.BenchCheck == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.benchCheck")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		config.BenchCheck = strings.TrimSpace(string(out))
+	}
+	if config.GitTrailers == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.gitTrailers")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		config.GitTrailers = strings.TrimSpace(string(out))
+	}
+	// The ssh route only matters on this side; resolving it now fails a bad one before the container starts.
+	for _, s := range []struct {
+		val *string
+		key string
+	}{{&config.SSHProxyJump, "sketch.sshProxyJump"}, {&config.SSHJumpIdentity, "sketch.sshJumpIdentity"}, {&config.SSHHostName, "sketch.sshHostName"}} {
+		if *s.val == "" {
+			cmd := exec.CommandContext(ctx, "git", "config", "--get", s.key)
+			cmd.Dir = gitRoot
+			out, _ := cmd.Output()
+			*s.val = strings.TrimSpace(string(out))
+		}
+	}
+	sshRoute, err := ParseSSHRoute(config.SSHProxyJump, config.SSHJumpIdentity, config.SSHHostName)
+	if err != nil {
+		return err
+	}
+
+	registry, err := resolveImageRegistry(ctx, gitRoot, config.ImageRegistry, config.ImageRegistryPush)
+	if err != nil {
+		return err
+	}
+	platforms, err := resolveImagePlatforms(ctx, gitRoot, config.ImagePlatforms)
+	if err != nil {
+		return err
+	}
+	secrets, err := ParseBuildSecrets(config.BuildSecrets)
+	if err != nil {
+		return err
+	}
+	matrixEnvs, err := ParseMatrix(config.Matrix)
+	if err != nil {
+		return err
+	}
+	clone, tradeoffs, err := chooseCloneStrategy(ctx, gitRoot, config.CloneStrategy)
+	if err != nil {
+		return err
+	}
+	config.CloneStrategy = clone.String()
+	if config.Verbose {
+		output.Printf("", "clone strategy %s: %s", clone, tradeoffs)
+	}
+	build := buildOptions{platforms: platforms, secrets: secrets, noGitObjects: clone.Kind != loop.CloneFull}
+	imgName, err := findOrBuildDockerImage(ctx, gitRoot, config.BaseImage, registry, build, progress, config.ForceRebuild, config.Verbose)
 	if err != nil {
 		return err
 	}
+	config.Image = imageProvenance(ctx, config.BaseImage, imgName)
+	noRebuild := ""
+	if config.NoCleanup {
+		noRebuild = "a -nocleanup container stays behind, so it can't be replaced"
+	}
+	image := newImageWatcher(config.Image, noRebuild)
 
 	cntrName := "sketch-" + config.SessionID
+	var matrix *matrixRunner
+	if rel, err := filepath.Rel(gitRoot, config.Path); err == nil {
+		matrix = newMatrixRunner(cntrName, imgName, filepath.ToSlash(rel), matrixEnvs, config.MatrixVerify)
+	}
 	defer func() {
 		if config.NoCleanup {
 			return
 		}
+		// ctx may be canceled by now; the container must go regardless.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+		defer cancel()
 		if out, err := combinedOutput(ctx, "docker", "kill", cntrName); err != nil {
 			// TODO: print in verbose mode? fmt.Fprintf(os.Stderr, "docker kill: %s: %v\n", out, err)
 			_ = out
@@ -211,6 +510,7 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 			// TODO: print in verbose mode? fmt.Fprintf(os.Stderr, "docker kill: %s: %v\n", out, err)
 			_ = out
 		}
+		verifyContainerRemoved(ctx, cntrName)
 	}()
 
 	// errCh receives errors from operations that this function calls in separate goroutines.
@@ -223,8 +523,36 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		upstream = strings.TrimSpace(string(out))
 	}
 
+	pluginDir, err := hooks.DefaultPluginDir()
+	if err != nil {
+		slog.DebugContext(ctx, "no plugin directory", "error", err)
+	}
+	hookRunner, err := hooks.NewRunner(config.Hooks, pluginDir)
+	if err != nil {
+		return err
+	}
+	session := hooks.Payload{SessionID: config.SessionID, RepoRoot: gitRoot, Container: cntrName}
+	webhooks, err := webhook.New(config.Webhook)
+	if err != nil {
+		return err
+	}
+	// Runs last, after the container reported its last events: queued deliveries get a little longer.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		webhooks.Close(ctx)
+	}()
+
+	var gitTrace *gittrace.Log
+	if config.GitTrace != "" {
+		if gitTrace, err = gittrace.Create(config.GitTrace); err != nil {
+			return fmt.Errorf("-git-trace: %w", err)
+		}
+		defer gitTrace.Close()
+	}
+
 	// Start the git server
-	gitSrv, err := newGitServer(gitRoot, config.PassthroughUpstream, upstream)
+	gitSrv, err := newGitServer(gitRoot, config.PassthroughUpstream, upstream, config.AnthropicTokens, hookRunner, webhooks, gitTrace, session, image, matrix)
 	if err != nil {
 		return fmt.Errorf("failed to start git server: %w", err)
 	}
@@ -234,6 +562,19 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		errCh <- gitSrv.serve(ctx)
 	}()
 
+	// The control plane outlives ctx, to stop the container gracefully once ctx is canceled.
+	ctlCtx, stopControl := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopControl()
+	ctl, err := newHostControl(ctlCtx, &gitSrv.ps1URL)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := ctl.serve(); err != nil {
+			errCh <- fmt.Errorf("control server: %w", err)
+		}
+	}()
+
 	// Check if we have any commits, and if not, create an empty initial commit
 	cmd := exec.CommandContext(ctx, "git", "rev-list", "--all", "--count")
 	countOut, err := cmd.CombinedOutput()
@@ -256,6 +597,22 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	} else {
 		commit = strings.TrimSpace(string(out))
 	}
+	if config.ResumeCommit != "" {
+		if _, err := combinedOutput(ctx, "git", "cat-file", "-e", config.ResumeCommit+"^{commit}"); err == nil {
+			commit = config.ResumeCommit
+		} else {
+			output.Warnf("resuming from HEAD: commit %.12s of the recorded run isn't in this repo", config.ResumeCommit)
+		}
+	} else if config.IncludeUncommitted {
+		wip, files, err := uncommittedCommit(ctx, commit)
+		if err != nil {
+			output.Warnf("starting from HEAD without your uncommitted changes: %v", err)
+		} else if wip != "" {
+			output.Printf("📎", "bringing uncommitted changes to %d file(s) into the container as commit %.12s (use -include-uncommitted=false to start from HEAD)", len(files), wip)
+			config.UncommittedBase = commit
+			commit = wip
+		}
+	}
 
 	if out, err := combinedOutput(ctx, "git", "config", "http.receivepack", "true"); err != nil {
 		return fmt.Errorf("git config http.receivepack true: %s: %w", out, err)
@@ -270,29 +627,50 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	config.GitRemoteUrl = fmt.Sprintf("http://sketch:%s@host.docker.internal:%s/.git", gitSrv.pass, gitSrv.gitPort)
 	config.Upstream = upstream
 	config.Commit = commit
+	config.controlAddr = "host.docker.internal:" + ctl.port
+	config.controlSecret = ctl.secret
 
 	// Create the sketch container, copy over linux sketch
+	progress.send(ProgressEvent{Stage: StageCreate, Message: "creating container " + cntrName})
 	if err := createDockerContainer(ctx, cntrName, hostPort, relPath, imgName, config); err != nil {
 		return fmt.Errorf("failed to create docker container: %w", err)
 	}
 	if err := copyEmbeddedLinuxBinaryToContainer(ctx, cntrName); err != nil {
 		return fmt.Errorf("failed to copy linux binary to container: %w", err)
 	}
+	if config.ResumeFrom != "" {
+		if out, err := combinedOutput(ctx, "docker", "cp", config.ResumeFrom, cntrName+":"+containerResumePath); err != nil {
+			return fmt.Errorf("failed to copy session record to container: %s: %w", out, err)
+		}
+		// Continuing the same session, as -resume does, the web UI shows its messages so far.
+		msgs := loop.MessagesPath(filepath.Join(filepath.Dir(config.ResumeFrom), config.SessionID+".json"))
+		if _, err := os.Stat(msgs); err == nil {
+			if out, err := combinedOutput(ctx, "docker", "cp", msgs, cntrName+":"+containerSessionRecords+"/"); err != nil {
+				return fmt.Errorf("failed to copy session messages to container: %s: %w", out, err)
+			}
+		}
+	}
 
-	fmt.Printf("📦 running in container %s\n", cntrName)
+	output.Printf("📦", "running in container %s", cntrName)
 
 	// Setup subtrace if token is provided (development only) - after container creation, before start
 	if config.SubtraceToken != "" {
-		fmt.Println("🔍 Setting up subtrace (development only)")
+		output.Printf("🔍", "Setting up subtrace (development only)")
 		if err := setupSubtraceBeforeStart(ctx, cntrName, config.SubtraceToken); err != nil {
 			return fmt.Errorf("failed to setup subtrace: %w", err)
 		}
 	}
 
 	// Start the sketch container
+	progress.send(ProgressEvent{Stage: StageStart, Message: "starting container " + cntrName})
 	if out, err := combinedOutput(ctx, "docker", "start", cntrName); err != nil {
 		return fmt.Errorf("docker start: %s, %w", out, err)
 	}
+	// Report the end of the session before the container is removed, and let the hooks finish.
+	defer func() {
+		gitSrv.fire(ctx, hooks.Payload{Event: hooks.SessionEnd})
+		hookRunner.Wait()
+	}()
 
 	// Copies structured logs from the container to the host.
 	copyLogs := func() {
@@ -347,6 +725,72 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		return appendInternalErr(err)
 	}
 
+	var suspender *idleSuspender
+	if proxyLn != nil {
+		suspender = newIdleSuspender(cntrName, localAddr, config.IdleSuspend)
+		suspender.hostToken = config.hostToken
+		sctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := suspender.serveProxy(sctx, proxyLn); err != nil {
+				slog.ErrorContext(ctx, "web UI proxy", "error", err)
+			}
+		}()
+		go suspender.watch(sctx)
+		// Leave the container running for the cleanup below, which runs docker cp.
+		defer suspender.interact(context.WithoutCancel(ctx))
+		localAddr = strings.Replace(proxyLn.Addr().String(), "[::]", "127.0.0.1", 1)
+	}
+
+	defer copyLogs()
+	defer copyCrashReports(context.WithoutCancel(ctx), cntrName)
+	defer copySessionRecord(context.WithoutCancel(ctx), cntrName, config.SessionID, config.OneShot)
+	// Runs after the last snapshot and saving the onboarding progress below,
+	// which need sketch in the container, and before the copies above.
+	defer func() {
+		if suspender != nil {
+			suspender.interact(context.WithoutCancel(ctx))
+		}
+		if ctx.Err() != nil {
+			// sketch in the container is still running: stop it gracefully, so
+			// that it records the session and delivers its last events.
+			ctl.shutdown(ctx, cntrName)
+		}
+	}()
+
+	if config.SnapshotTo != "" {
+		snap, err := newSnapshotter(cntrName, config.SessionID, config.OriginalGitOrigin, config.SnapshotTo, config.SnapshotEvery)
+		if err != nil {
+			return appendInternalErr(err)
+		}
+		sctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			snap.watch(sctx)
+			close(done)
+		}()
+		// Take the last snapshot before the cleanup below removes the container.
+		defer func() {
+			if suspender != nil {
+				suspender.interact(context.WithoutCancel(ctx))
+			}
+			cancel()
+			<-done
+		}()
+		output.Printf("🛟", "snapshotting the workspace every %s; to recreate it elsewhere: sketch restore %s", config.SnapshotEvery, snap.store.url)
+	}
+
+	if config.Onboarding != nil {
+		defer func() {
+			if suspender != nil {
+				suspender.interact(context.WithoutCancel(ctx))
+			}
+			if err := saveOnboardingProgress(context.WithoutCancel(ctx), localAddr, config.hostToken); err != nil {
+				slog.WarnContext(ctx, "saving onboarding progress", "error", err)
+			}
+		}()
+	}
+
 	if config.Verbose {
 		fmt.Fprintf(os.Stderr, "Host web server: http://%s/\n", localAddr)
 	}
@@ -362,7 +806,7 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 
 	var sshServerIdentity, sshUserIdentity, containerCAPublicKey, hostCertificate []byte
 
-	cst, err := NewLocalSSHimmer(cntrName, sshHost, sshPort)
+	cst, err := NewLocalSSHimmer(cntrName, sshHost, sshPort, sshRoute)
 	if err != nil {
 		return appendInternalErr(fmt.Errorf("NewContainerSSHTheather: %w", err))
 	}
@@ -371,18 +815,25 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	sshAvailable := false
 	sshErrMsg := ""
 	if sshErr != nil {
-		fmt.Println(sshErr.Error())
+		output.Warnf("%s", strings.TrimLeft(sshErr.Error(), "⚠️ "))
 		sshErrMsg = sshErr.Error()
 		// continue - ssh config is not required for the rest of sketch to function locally.
 	} else {
 		sshAvailable = true
 		// Note: The vscode: link uses an undocumented request parameter that I really had to dig to find:
 		// https://github.com/microsoft/vscode/blob/2b9486161abaca59b5132ce3c59544f3cc7000f6/src/vs/code/electron-main/app.ts#L878
-		fmt.Printf(`Connect to this container via any of these methods:
-🖥️  ssh %s
-🖥️  code --remote ssh-remote+root@%s /app -n
-🔗 vscode://vscode-remote/ssh-remote+root@%s/app?windowId=_blank
-`, cntrName, cntrName, cntrName)
+		output.Printf("", "Connect to this container via any of these methods:")
+		output.Printf("🖥️ ", "ssh %s", cntrName)
+		output.Printf("🖥️ ", "code --remote ssh-remote+root@%s /app -n", cntrName)
+		output.Printf("🔗", "vscode://vscode-remote/ssh-remote+root@%s/app?windowId=_blank", cntrName)
+		if dc, err := writeDevcontainer(cntrName, path.Join("/app", filepath.ToSlash(relPath)), gitRoot); err != nil {
+			slog.DebugContext(ctx, "not writing devcontainer.json", "error", err)
+		} else {
+			defer os.RemoveAll(dc.dir)
+			output.Printf("🧩", "%s", dc.info.VSCodeURI)
+			output.Printf("🧩", "%s", dc.info.GatewayURL)
+			output.Printf("  ", "(devcontainer.json: %s)", dc.path)
+		}
 		sshUserIdentity = cst.userIdentity
 		sshServerIdentity = cst.serverIdentity
 
@@ -409,15 +860,18 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 	// get the port Docker chose until after the process starts. The SSH config is
 	// mostly available ahead of time, but whether it works ("sshAvailable"/"sshErrMsg")
 	// may also empirically need to be done after the SSH server is up and running.
+	progress.send(ProgressEvent{Stage: StageInit, Message: "waiting for sketch to initialize"})
 	go func() {
 		// TODO: Why is this called in a goroutine? I have found that when I pull this out
 		// of the goroutine and call it inline, then the terminal UI clears itself and all
 		// the scrollback (which is not good, but also not fatal).  I can't see why it does this
-		// though, since none of the calls in postContainerInitConfig obviously write to stdout
+		// though, since none of the calls in initContainer obviously write to stdout
 		// or stderr.
-		if err := postContainerInitConfig(ctx, localAddr, sshAvailable, sshErrMsg, sshServerIdentity, sshUserIdentity, containerCAPublicKey, hostCertificate); err != nil {
-			slog.ErrorContext(ctx, "LaunchContainer.postContainerInitConfig", slog.String("err", err.Error()))
+		if err := initContainer(ctx, ctl, localAddr, config.IdleSuspend, config.Onboarding, sshAvailable, sshErrMsg, sshServerIdentity, sshUserIdentity, containerCAPublicKey, hostCertificate); err != nil {
+			slog.ErrorContext(ctx, "LaunchContainer.initContainer", slog.String("err", err.Error()))
 			errCh <- appendInternalErr(err)
+			progress.close()
+			return
 		}
 
 		// We open the browser after the init config because the above waits for the web server to be serving.
@@ -429,6 +883,9 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 			browser.Open(ps1URL)
 		}
 		gitSrv.ps1URL.Store(&ps1URL)
+		gitSrv.fire(ctx, hooks.Payload{Event: hooks.SessionStart})
+		progress.send(ProgressEvent{Stage: StageReady, Message: "sketch is ready", URL: ps1URL})
+		progress.close()
 	}()
 
 	go func() {
@@ -436,11 +893,23 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-		errCh <- run(ctx, "docker attach", cmd)
+		restore := func() {}
+		if suspender != nil {
+			var err error
+			if restore, err = suspender.attachStdin(ctx, cmd); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		err := run(ctx, "docker attach", cmd)
+		restore()
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == ExitShutdownIncomplete {
+			output.Warnf("sketch in the container was stopped before it finished shutting down; the session record may be missing")
+			err = nil
+		}
+		errCh <- err
 	}()
 
-	defer copyLogs()
-
 	for {
 		select {
 		case <-ctx.Done():
@@ -449,11 +918,116 @@ func LaunchContainer(ctx context.Context, config ContainerConfig) error {
 			if err != nil {
 				return appendInternalErr(fmt.Errorf("container process: %w", err))
 			}
+			if image.rebuildRequested() {
+				return &RebuildError{Addr: localAddr}
+			}
+			if config.OneShot && matrix != nil {
+				// The container has stopped, but its working tree is still there to copy.
+				return matrix.report(ctx)
+			}
 			return nil
 		}
 	}
 }
 
+// ExitShutdownIncomplete is the exit code of a sketch that was stopped, by a
+// signal or POST /end, but didn't finish shutting down in time. A clean
+// shutdown exits 0 and a failure to start exits 1.
+const ExitShutdownIncomplete = 3
+
+// containerCrashDir is crashreport.Dir() inside the container, where sketch runs as root.
+const containerCrashDir = "/root/.cache/sketch/crashes"
+
+// copyCrashReports copies crash output left by the sketch process in the container
+// to the host, where sketch collects it into crash reports.
+func copyCrashReports(ctx context.Context, cntrName string) {
+	dir, err := crashreport.Dir()
+	if err != nil {
+		return
+	}
+	tmp, err := os.MkdirTemp("", "sketch-crashes-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmp)
+	if _, err := combinedOutput(ctx, "docker", "cp", cntrName+":"+containerCrashDir+"/.", tmp); err != nil {
+		return // nothing recorded
+	}
+	err = crashreport.Import(tmp, dir, func(logPath, dst string) error {
+		_, err := combinedOutput(ctx, "docker", "cp", cntrName+":"+logPath, dst)
+		return err
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "copying container crash reports", "error", err)
+	}
+}
+
+// Paths of session records inside the container: the one a resumed run
+// starts from, and the directory a session saves its own record in.
+const (
+	containerResumePath     = "/tmp/sketch-resume.json"
+	containerSessionRecords = "/root/.cache/sketch/sessions"
+)
+
+// containerBrowserProfiles is browse.DefaultProfileDir inside the container.
+const containerBrowserProfiles = "/root/.config/sketch/browser-profiles"
+
+// uncommittedCommit records the uncommitted changes to tracked files in the
+// current repo as a commit atop head, without touching the working tree,
+// index, or any ref, and returns it and the files it changes. It returns ""
+// if there are no such changes. Untracked files stay behind, as they would
+// with git stash.
+//
+// The commit is on no branch; the container fetches it by hash.
+func uncommittedCommit(ctx context.Context, head string) (string, []string, error) {
+	out, err := combinedOutput(ctx, "git", "stash", "create")
+	if err != nil {
+		return "", nil, fmt.Errorf("git stash create: %s: %w", out, err)
+	}
+	stash := strings.TrimSpace(string(out))
+	if stash == "" {
+		return "", nil, nil
+	}
+	// A stash is a merge of HEAD and the index; the container only needs the
+	// working tree, as a plain commit the agent's history can build on.
+	out, err = combinedOutput(ctx, "git", "commit-tree", stash+"^{tree}", "-p", head, "-m", "sketch: uncommitted changes from the host")
+	if err != nil {
+		return "", nil, fmt.Errorf("git commit-tree: %s: %w", out, err)
+	}
+	wip := strings.TrimSpace(string(out))
+	out, err = combinedOutput(ctx, "git", "diff", "--name-only", head, wip)
+	if err != nil {
+		return "", nil, fmt.Errorf("git diff: %s: %w", out, err)
+	}
+	return wip, strings.Fields(string(out)), nil
+}
+
+// copySessionRecord copies the record and messages a session saved in the
+// container to the host, where sketch history and -resume can find them. For one-shot runs, it tells the user
+// how to resume from it.
+func copySessionRecord(ctx context.Context, cntrName, sessionID string, oneShot bool) {
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return
+	}
+	name := sessionID + ".json"
+	dst := filepath.Join(dir, name)
+	if _, err := combinedOutput(ctx, "docker", "cp", cntrName+":"+containerSessionRecords+"/"+name, dst); err != nil {
+		return // the run didn't get far enough to record anything
+	}
+	combinedOutput(ctx, "docker", "cp", cntrName+":"+loop.MessagesPath(containerSessionRecords+"/"+name), loop.MessagesPath(dst))
+	// Artifacts too, for sketch export to carry; the container's /tmp goes with it.
+	if artifacts := loop.ArtifactsDir(sessionID); os.MkdirAll(artifacts, 0o700) == nil {
+		combinedOutput(ctx, "docker", "cp", cntrName+":"+artifacts+"/.", artifacts)
+	}
+	if oneShot {
+		output.Printf("🔁", "to retry from here: sketch -one-shot -resume-from %s [-prompt ...]", dst)
+	}
+}
+
 func combinedOutput(ctx context.Context, cmdName string, args ...string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, cmdName, args...)
 	start := time.Now()
@@ -479,11 +1053,35 @@ func run(ctx context.Context, cmdName string, cmd *exec.Cmd) error {
 }
 
 type gitServer struct {
-	gitLn   net.Listener
-	gitPort string
-	srv     *http.Server
-	pass    string
-	ps1URL  atomic.Pointer[string]
+	gitLn    net.Listener
+	gitPort  string
+	srv      *http.Server
+	pass     string
+	ps1URL   atomic.Pointer[string]
+	hooks    *hooks.Runner       // nil if the user has no lifecycle hooks
+	webhooks *webhook.Dispatcher // nil without a webhook
+	session  hooks.Payload       // what describes the session in every event
+}
+
+// fire runs the user's lifecycle hooks for p.Event.
+func (gs *gitServer) fire(ctx context.Context, p hooks.Payload) {
+	if gs.hooks == nil {
+		return
+	}
+	p.SessionID, p.RepoRoot, p.Container = gs.session.SessionID, gs.session.RepoRoot, gs.session.Container
+	if u := gs.ps1URL.Load(); u != nil {
+		p.URL = *u
+	}
+	gs.hooks.Fire(ctx, p)
+}
+
+// sendWebhook queues e, from the container, for the user's webhook.
+func (gs *gitServer) sendWebhook(e webhook.Event) {
+	e.SessionID, e.URL = gs.session.SessionID, ""
+	if u := gs.ps1URL.Load(); u != nil {
+		e.URL = *u
+	}
+	gs.webhooks.Send(e)
 }
 
 func (gs *gitServer) shutdown(ctx context.Context) {
@@ -497,9 +1095,12 @@ func (gs *gitServer) serve(ctx context.Context) error {
 	return gs.srv.Serve(gs.gitLn)
 }
 
-func newGitServer(gitRoot string, configureUpstreamPassthrough bool, upstream string) (*gitServer, error) {
+func newGitServer(gitRoot string, configureUpstreamPassthrough bool, upstream string, tokens ant.TokenSource, hookRunner *hooks.Runner, webhooks *webhook.Dispatcher, trace *gittrace.Log, session hooks.Payload, image *imageWatcher, matrix *matrixRunner) (*gitServer, error) {
 	ret := &gitServer{
-		pass: rand.Text(),
+		pass:     rand.Text(),
+		hooks:    hookRunner,
+		webhooks: webhooks,
+		session:  session,
 	}
 
 	gitLn, err := net.Listen("tcp4", ":0")
@@ -508,14 +1109,6 @@ func newGitServer(gitRoot string, configureUpstreamPassthrough bool, upstream st
 	}
 	ret.gitLn = gitLn
 
-	browserC := make(chan bool, 1) // channel of browser open requests
-
-	go func() {
-		for range browserC {
-			browser.Open(*ret.ps1URL.Load())
-		}
-	}()
-
 	var hooksDir string
 	if configureUpstreamPassthrough {
 		hooksDir, err = setupHooksDir(upstream)
@@ -524,7 +1117,12 @@ func newGitServer(gitRoot string, configureUpstreamPassthrough bool, upstream st
 		}
 	}
 
-	srv := http.Server{Handler: &gitHTTP{gitRepoRoot: gitRoot, hooksDir: hooksDir, pass: []byte(ret.pass), browserC: browserC}}
+	handler := &gitHTTP{gitRepoRoot: gitRoot, hooksDir: hooksDir, pass: []byte(ret.pass), tokens: tokens, fire: ret.fire, webhook: ret.sendWebhook, image: image, matrix: matrix}
+	if trace != nil {
+		handler.trace = gittrace.NewRecorder(gittrace.Host, gitRoot, trace.Write)
+		handler.traceLog = trace.Write
+	}
+	srv := http.Server{Handler: handler}
 	ret.srv = &srv
 
 	_, gitPort, err := net.SplitHostPort(gitLn.Addr().String())
@@ -546,6 +1144,7 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	if !(config.OneShot || !config.TermUI) {
 		cmdArgs = append(cmdArgs, "-t")
 	}
+	cmdArgs = append(cmdArgs, containerLabels(config.NoCleanup)...)
 
 	for _, envVar := range getEnvForwardingFromGitConfig(ctx) {
 		cmdArgs = append(cmdArgs, "-e", envVar)
@@ -559,6 +1158,15 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	if config.SketchPubKey != "" {
 		cmdArgs = append(cmdArgs, "-e", "SKETCH_PUB_KEY="+config.SketchPubKey)
 	}
+	if config.AttachToken != "" {
+		cmdArgs = append(cmdArgs, "-e", "SKETCH_ATTACH_TOKEN="+config.AttachToken)
+	}
+	if config.OIDC != "" {
+		cmdArgs = append(cmdArgs, "-e", "SKETCH_OIDC_CLIENT_SECRET="+config.OIDCClientSecret, "-e", "SKETCH_HOST_TOKEN="+config.hostToken)
+	}
+	if config.controlSecret != "" {
+		cmdArgs = append(cmdArgs, "-e", "SKETCH_CONTROL_SECRET="+config.controlSecret)
+	}
 	if config.SSHPort > 0 {
 		cmdArgs = append(cmdArgs, "-p", fmt.Sprintf("%d:22", config.SSHPort)) // forward container ssh port to host ssh port
 	} else {
@@ -581,6 +1189,10 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 		cmdArgs = append(cmdArgs, "-e", "SUBTRACE_HTTP2=1")
 	}
 
+	if config.BrowserProfileDir != "" {
+		cmdArgs = append(cmdArgs, "-v", config.BrowserProfileDir+":"+containerBrowserProfiles)
+	}
+
 	// Add volume mounts if specified
 	for _, mount := range config.Mounts {
 		if mount != "" {
@@ -607,12 +1219,18 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 		"-outside-os="+config.OutsideOS,
 		"-outside-working-dir="+config.OutsideWorkingDir,
 		fmt.Sprintf("-max-dollars=%f", config.MaxDollars),
+		"-turn-timeout="+config.TurnTimeout.String(),
+		"-output="+string(config.OutputMode),
+		fmt.Sprintf("-confirm-cost=%f", config.ConfirmCost),
+		fmt.Sprintf("-auto-confirm-cost=%t", config.AutoConfirmCost),
+		fmt.Sprintf("-max-upload-mb=%d", config.MaxUploadMB),
 		"-open=false",
 		"-termui="+fmt.Sprintf("%t", config.TermUI),
 		"-verbose="+fmt.Sprintf("%t", config.Verbose),
 		"-x="+config.ExperimentFlag,
 		"-branch-prefix="+config.BranchPrefix,
 		"-link-to-github="+fmt.Sprintf("%t", config.LinkToGitHub),
+		"-ack-reply="+config.AckReply,
 	)
 	// Set SSH connection string based on session ID for SSH Theater
 	cmdArgs = append(cmdArgs, "-ssh-connection-string=sketch-"+config.SessionID)
@@ -628,6 +1246,9 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 			panic("Commit should have been set when GitRemoteUrl was set")
 		}
 		cmdArgs = append(cmdArgs, "-commit="+config.Commit)
+		if config.UncommittedBase != "" {
+			cmdArgs = append(cmdArgs, "-uncommitted-base="+config.UncommittedBase)
+		}
 		cmdArgs = append(cmdArgs, "-upstream="+config.Upstream)
 	}
 	if config.OriginalGitOrigin != "" {
@@ -636,10 +1257,127 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	if config.OutsideHTTP != "" {
 		cmdArgs = append(cmdArgs, "-outside-http="+config.OutsideHTTP)
 	}
+	if config.controlAddr != "" {
+		cmdArgs = append(cmdArgs, "-control-addr="+config.controlAddr)
+	}
+	if config.ForwardPorts {
+		cmdArgs = append(cmdArgs, "-forward-ports")
+	}
+	if config.NetAllowlist != "" {
+		cmdArgs = append(cmdArgs, "-net-allowlist="+config.NetAllowlist)
+	}
+	if config.BashAllow != "" {
+		cmdArgs = append(cmdArgs, "-bash-allow="+config.BashAllow)
+	}
+	if config.BashDeny != "" {
+		cmdArgs = append(cmdArgs, "-bash-deny="+config.BashDeny)
+	}
+	if config.Language != "" {
+		cmdArgs = append(cmdArgs, "-language="+config.Language)
+	}
+	if config.CodebaseAnalysis != "" {
+		cmdArgs = append(cmdArgs, "-codebase-analysis="+config.CodebaseAnalysis)
+	}
+	if config.TermUIMode != "" {
+		cmdArgs = append(cmdArgs, "-termui-mode="+config.TermUIMode)
+	}
+	if config.EnableTools != "" {
+		cmdArgs = append(cmdArgs, "-enable-tools="+config.EnableTools)
+	}
+	if config.DisableTools != "" {
+		cmdArgs = append(cmdArgs, "-disable-tools="+config.DisableTools)
+	}
+	if config.MergeQueue != "" {
+		cmdArgs = append(cmdArgs, "-merge-queue="+config.MergeQueue)
+	}
+	if config.CommitLint != "" {
+		cmdArgs = append(cmdArgs, "-commit-lint="+config.CommitLint)
+	}
+	if config.QualityGates != "" {
+		cmdArgs = append(cmdArgs, "-quality-gates="+config.QualityGates)
+	}
+	if config.DocsCheck != "" {
+		cmdArgs = append(cmdArgs, "-docs-check="+config.DocsCheck)
+	}
+	if config.BenchCheck != "" {
+		cmdArgs = append(cmdArgs, "-bench-check="+config.BenchCheck)
+	}
+	if config.GitTrailers != "" {
+		cmdArgs = append(cmdArgs, "-git-trailers="+config.GitTrailers)
+	}
+	if config.OIDC != "" {
+		cmdArgs = append(cmdArgs, "-oidc="+config.OIDC)
+	}
+	if config.Webhook.URL != "" {
+		// The agent forwards these events to us; the URL and secret stay out here.
+		events := config.Webhook.Events
+		if events == nil {
+			events = webhook.Events
+		}
+		names := make([]string, len(events))
+		for i, e := range events {
+			names[i] = string(e)
+		}
+		cmdArgs = append(cmdArgs, "-webhook-events="+strings.Join(names, ","))
+	}
+	if config.GitTrace != "" {
+		cmdArgs = append(cmdArgs, "-git-trace-outside")
+	}
+	if config.Compaction != "" {
+		cmdArgs = append(cmdArgs, "-compaction="+config.Compaction)
+	}
+	if config.Sampling != "" {
+		cmdArgs = append(cmdArgs, "-sampling="+config.Sampling)
+	}
+	if config.RequestShaping != "" {
+		cmdArgs = append(cmdArgs, "-request-shaping="+config.RequestShaping)
+	}
+	if config.BrowserProfile != "" {
+		cmdArgs = append(cmdArgs, "-browser-profile="+config.BrowserProfile)
+	}
+	if config.TurnSummaries {
+		cmdArgs = append(cmdArgs, "-turn-summaries")
+	}
+	if !config.ToolResultRefs {
+		cmdArgs = append(cmdArgs, "-tool-result-refs=false")
+	}
+	if !config.SlimTools {
+		cmdArgs = append(cmdArgs, "-slim-tools=false")
+	}
+	if config.Watch {
+		cmdArgs = append(cmdArgs, "-watch")
+	}
+	if config.ShareFeedback {
+		cmdArgs = append(cmdArgs, "-share-feedback")
+	}
+	if config.PlanMode {
+		cmdArgs = append(cmdArgs, "-plan")
+	}
+	if config.ToolPermissions != "" {
+		cmdArgs = append(cmdArgs, "-tool-permissions="+config.ToolPermissions)
+	}
+	for _, env := range config.Matrix {
+		cmdArgs = append(cmdArgs, "-matrix="+env)
+	}
+	if len(config.Matrix) > 0 {
+		cmdArgs = append(cmdArgs, "-matrix-verify="+config.MatrixVerify)
+	}
+	if config.ResumeFrom != "" {
+		cmdArgs = append(cmdArgs, "-resume-from="+containerResumePath)
+	}
+	if config.UntrustedContent != "" {
+		cmdArgs = append(cmdArgs, "-untrusted-content="+config.UntrustedContent)
+	}
+	if config.AnthropicTokens != nil {
+		cmdArgs = append(cmdArgs, "-anthropic-oauth")
+	}
 	cmdArgs = append(cmdArgs, "-skaband-addr="+config.SkabandAddr)
 	if config.Prompt != "" {
 		cmdArgs = append(cmdArgs, "-prompt", config.Prompt)
 	}
+	for _, path := range config.Attach {
+		cmdArgs = append(cmdArgs, "-attach", path)
+	}
 	if config.OneShot {
 		cmdArgs = append(cmdArgs, "-one-shot")
 	}
@@ -657,6 +1395,12 @@ func createDockerContainer(ctx context.Context, cntrName, hostPort, relPath, img
 	if !config.FetchOnLaunch {
 		cmdArgs = append(cmdArgs, "-fetch-on-launch=false")
 	}
+	if config.CloneStrategy != "" && config.CloneStrategy != string(loop.CloneFull) {
+		cmdArgs = append(cmdArgs, "-clone-strategy="+config.CloneStrategy)
+	}
+	if config.Image.Image != "" {
+		cmdArgs = append(cmdArgs, "-image="+config.Image.Image, "-image-base="+config.Image.BaseImage, "-image-digest="+config.Image.BaseDigest)
+	}
 
 	// Add additional docker arguments if provided
 	if config.DockerArgs != "" {
@@ -691,64 +1435,56 @@ func getContainerPort(ctx context.Context, cntrName, cntrPort string) (string, e
 	return localAddr, nil
 }
 
-// Contact the container and configure it.
-func postContainerInitConfig(ctx context.Context, localAddr string, sshAvailable bool, sshError string, sshServerIdentity, sshAuthorizedKeys, sshContainerCAKey, sshHostCertificate []byte) error {
-	localURL := "http://" + localAddr
-
-	initMsg, err := json.Marshal(
-		server.InitRequest{
-			HostAddr:           localAddr,
-			SSHAuthorizedKeys:  sshAuthorizedKeys,
-			SSHServerIdentity:  sshServerIdentity,
-			SSHContainerCAKey:  sshContainerCAKey,
-			SSHHostCertificate: sshHostCertificate,
-			SSHAvailable:       sshAvailable,
-			SSHError:           sshError,
-		})
-	if err != nil {
-		return fmt.Errorf("init msg: %w", err)
-	}
+// initContainer hands sketch in the container its address and SSH keys, over the control plane.
+func initContainer(ctx context.Context, ctl *hostControl, localAddr string, idleSuspend time.Duration, onboard *onboarding.Discovery, sshAvailable bool, sshError string, sshServerIdentity, sshAuthorizedKeys, sshContainerCAKey, sshHostCertificate []byte) error {
+	// Note: this init is handled in loop/server/control.go.
+	return ctl.init(ctx, server.InitRequest{
+		HostAddr:           localAddr,
+		SSHAuthorizedKeys:  sshAuthorizedKeys,
+		SSHServerIdentity:  sshServerIdentity,
+		SSHContainerCAKey:  sshContainerCAKey,
+		SSHHostCertificate: sshHostCertificate,
+		SSHAvailable:       sshAvailable,
+		SSHError:           sshError,
+		IdleSuspend:        formatIdleSuspend(idleSuspend),
+		Onboarding:         onboard,
+	})
+}
 
-	// Note: this /init POST is handled in loop/server/loophttp.go:
-	initMsgByteReader := bytes.NewReader(initMsg)
-	req, err := http.NewRequest("POST", localURL+"/init", initMsgByteReader)
+// saveOnboardingProgress keeps what the user did of the container's onboarding
+// flow for the next session.
+func saveOnboardingProgress(ctx context.Context, localAddr, hostToken string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+localAddr+"/onboarding/progress", nil)
 	if err != nil {
 		return err
 	}
-
-	var res *http.Response
-	for i := 0; ; i++ {
-		time.Sleep(100 * time.Millisecond)
-		// If you DON'T reset this byteReader, then subsequent retries may end up sending 0 bytes.
-		initMsgByteReader.Reset(initMsg)
-		res, err = http.DefaultClient.Do(req)
-		if err != nil {
-			if i < 100 {
-				if i%10 == 0 {
-					slog.DebugContext(ctx, "postContainerInitConfig retrying", slog.Int("retry", i), slog.String("err", err.Error()))
-				}
-				continue
-			}
-			return fmt.Errorf("failed to %s/init sketch in container, NOT retrying: err: %v", localURL, err)
-		}
-		break
+	if hostToken != "" {
+		req.Header.Set(server.HostTokenHeader, hostToken)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
-	resBytes, _ := io.ReadAll(res.Body)
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to initialize sketch in container, response status code %d: %s", res.StatusCode, resBytes)
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("GET /onboarding/progress: %s: %s", res.Status, body)
 	}
-	return nil
+	var p onboarding.Progress
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		return err
+	}
+	return p.Save(onboarding.DefaultPath())
 }
 
-func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, forceRebuild, verbose bool) (imgName string, err error) {
+func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, registry *imageRegistry, build buildOptions, progress *progressReporter, forceRebuild, verbose bool) (imgName string, err error) {
 	// Default to the published sketch image if no base image is specified
-	if baseImage == "" {
-		imageTag := dockerfileBaseHash()
-		baseImage = fmt.Sprintf("%s:%s", dockerImgName, imageTag)
-	}
+	baseImage = defaultBaseImage(baseImage)
 
 	// Ensure the base image exists locally, pull if necessary
-	if err := ensureBaseImageExists(ctx, baseImage); err != nil {
+	if err := ensureBaseImageExists(ctx, baseImage, progress); err != nil {
 		return "", fmt.Errorf("failed to ensure base image %s exists: %w", baseImage, err)
 	}
 
@@ -762,7 +1498,7 @@ func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, forc
 	// Docker naming conventions restrict you to 20 characters per path component
 	// and only allow lowercase letters, digits, underscores, and dashes, so encoding
 	// the hash and the repo directory is sadly a bit of a non-starter.
-	cacheKey := createCacheKey(baseImageID, gitRoot)
+	cacheKey := createCacheKey(build.keyBase(baseImageID), gitRoot)
 	imgName = "sketch-" + cacheKey
 
 	// Check if the cached image exists and is up to date
@@ -771,44 +1507,82 @@ func findOrBuildDockerImage(ctx context.Context, gitRoot, baseImage string, forc
 			return "", fmt.Errorf("failed to check if image exists: %w", err)
 		} else if exists {
 			if verbose {
-				fmt.Printf("using cached image %s\n", imgName)
+				output.Printf("", "using cached image %s", imgName)
 			}
 			return imgName, nil
 		}
 	}
 
+	// A teammate may already have built and shared this image.
+	var sharedKey string
+	if registry != nil {
+		baseKey := baseImageID
+		if len(build.platforms) > 0 {
+			// The base image ID differs between architectures; its digest doesn't.
+			baseKey = baseImageDigest(ctx, baseImage, baseImageID)
+		}
+		sharedKey = sharedCacheKey(ctx, build.keyBase(baseKey), gitRoot)
+		if !forceRebuild && registry.pull(ctx, sharedKey, imgName, verbose) {
+			return imgName, nil
+		}
+		build.cacheFrom = registry.ref(sharedKey)
+		if len(build.platforms) > 0 && registry.push {
+			if err := requireBuildx(ctx); err != nil {
+				return "", err
+			}
+			build.push = registry.ref(sharedKey)
+		}
+	}
+	if len(build.platforms) > 0 && build.push == "" {
+		output.Warnf("building for this machine only: multi-platform images are built only when pushing to an image registry")
+	}
+
 	// Explain a bit what's happening, to help orient and de-FUD new users.
-	fmt.Println()
-	fmt.Println("┌──────────────────────────────────────────────────┐")
-	fmt.Println("│ Building Docker image (one-time)                 │")
-	fmt.Println("│                                                  │")
-	fmt.Println("│ • Built and run locally                          │")
-	fmt.Println("│ • Packages your git repo into isolated container │")
-	fmt.Println("│ • Custom images: https://sketch.dev/docs/docker  │")
-	fmt.Println("│ • Rebuild: sketch -rebuild                       │")
-	fmt.Println("└──────────────────────────────────────────────────┘")
-	fmt.Println()
-
-	if err := buildLayeredImage(ctx, imgName, baseImage, gitRoot, verbose); err != nil {
+	output.Banner("Building Docker image (one-time)",
+		"Built and run locally",
+		"Packages your git repo into isolated container",
+		"Custom images: https://sketch.dev/docs/docker",
+		"Rebuild: sketch -rebuild",
+	)
+
+	if err := buildLayeredImage(ctx, imgName, baseImage, gitRoot, build, progress, verbose); err != nil {
 		return "", fmt.Errorf("failed to build layered image: %w", err)
 	}
+	if build.push != "" {
+		// The multi-platform image went straight to the registry; pull this machine's variant.
+		if !registry.pull(ctx, sharedKey, imgName, verbose) {
+			return "", fmt.Errorf("failed to pull the image just pushed to %s", build.push)
+		}
+	} else if registry != nil {
+		registry.publish(ctx, sharedKey, imgName)
+	}
 
 	return imgName, nil
 }
 
 // ensureBaseImageExists checks if the base image exists locally and pulls it if not
-func ensureBaseImageExists(ctx context.Context, imageName string) error {
+func ensureBaseImageExists(ctx context.Context, imageName string, progress *progressReporter) error {
 	exists, err := dockerImageExists(ctx, imageName)
 	if err != nil {
 		return fmt.Errorf("failed to check if image exists: %w", err)
 	}
 
 	if !exists {
-		fmt.Printf("🐋 pulling base image %s...\n", imageName)
-		if out, err := combinedOutput(ctx, "docker", "pull", imageName); err != nil {
+		if progress == nil {
+			output.Printf("🐋", "pulling base image %s...", imageName)
+		}
+		progress.send(ProgressEvent{Stage: StagePullBase, Message: "pulling base image " + imageName})
+		pp := &pullProgress{p: progress, image: imageName, layers: make(map[string]bool)}
+		out := new(bytes.Buffer)
+		cmd := exec.CommandContext(ctx, "docker", "pull", imageName)
+		cmd.Stdout = io.MultiWriter(out, &lineWriter{fn: pp.line})
+		cmd.Stderr = out
+		if err := run(ctx, "docker pull", cmd); err != nil {
 			return fmt.Errorf("docker pull %s failed: %s: %w", imageName, out, err)
 		}
-		fmt.Printf("✅ successfully pulled %s\n", imageName)
+		if progress == nil {
+			output.Successf("successfully pulled %s", imageName)
+		}
 	}
 
 	return nil
@@ -875,30 +1649,81 @@ func dockerImageExists(ctx context.Context, imageName string) (bool, error) {
 // (This wouldn't happen here, but at agent/container initialization time.)
 //
 // repoPath is the current working directory where sketch is being run from.
-func buildLayeredImage(ctx context.Context, imgName, baseImage, gitRoot string, verbose bool) error {
+func buildLayeredImage(ctx context.Context, imgName, baseImage, gitRoot string, build buildOptions, progress *progressReporter, verbose bool) error {
 	goModules, err := collectGoModules(ctx, gitRoot)
 	if err != nil {
 		return fmt.Errorf("failed to collect go modules: %w", err)
 	}
+	goWorkspaces, err := collectGoWorkspaces(ctx, gitRoot, goModules)
+	if err != nil {
+		return fmt.Errorf("failed to collect go workspaces: %w", err)
+	}
 
 	buf := new(strings.Builder)
 	line := func(msg string, args ...any) {
 		fmt.Fprintf(buf, msg+"\n", args...)
 	}
 
+	// Without the git objects, the build context is a directory of just the
+	// blobs the go mod download steps need, named by hash.
+	var blobs []string
+	catBlob := func(workTree, sha string) string {
+		if build.noGitObjects {
+			blobs = append(blobs, sha)
+			return "cat /git-blobs/" + sha
+		}
+		return fmt.Sprintf("git --git-dir=/git-ref --work-tree=%s cat-file blob %s", workTree, sha)
+	}
+
 	line("FROM %s", baseImage)
-	line("COPY . /git-ref")
+	if build.noGitObjects {
+		line("COPY . /git-blobs")
+	} else {
+		line("COPY . /git-ref")
+	}
+
+	// Download workspace dependencies in one go, using the workspace's combined build list
+	// and go.work.sum; the individual modules' go.sum files may be incomplete on their own.
+	inWorkspace := make(map[string]bool)
+	for _, ws := range goWorkspaces {
+		for _, module := range ws.modules {
+			inWorkspace[module.modPath] = true
+			// Repo paths can hold anything a file name can; the RUN lines are sh -c.
+			modDir := shellQuote(path.Join("/go-work", path.Dir(module.modPath)))
+			line("RUN mkdir -p %s", modDir)
+			line("RUN %s > %s/go.mod", catBlob("/go-work", module.modSHA), modDir)
+			if module.sumSHA != "" {
+				line("RUN %s > %s/go.sum", catBlob("/go-work", module.sumSHA), modDir)
+			}
+			line("RUN cd %s && go mod edit -json | jq -r '.Replace? // [] | .[] | .Old.Path' | xargs -r -I{} go mod edit -dropreplace={} -droprequire={}", modDir)
+		}
+		workDir := shellQuote(path.Join("/go-work", path.Dir(ws.workPath)))
+		line("RUN mkdir -p %s", workDir)
+		line("RUN %s > %s/go.work", catBlob("/go-work", ws.workSHA), workDir)
+		if ws.sumSHA != "" {
+			line("RUN %s > %s/go.work.sum", catBlob("/go-work", ws.sumSHA), workDir)
+		}
+		for _, use := range ws.dropUses {
+			line("RUN cd %s && go work edit -dropuse=%s", workDir, shellQuote(use))
+		}
+		line("RUN cd %s && go work edit -json | jq -r '.Replace? // [] | .[] | .Old.Path' | xargs -r -I{} go work edit -dropreplace={}", workDir)
+		line("RUN %scd %s && go mod download || true", build.secretMounts(), workDir)
+		line("RUN rm -rf /go-work")
+	}
 
 	for _, module := range goModules {
+		if inWorkspace[module.modPath] {
+			continue
+		}
 		line("RUN mkdir -p /go-module")
-		line("RUN git --git-dir=/git-ref --work-tree=/go-module cat-file blob %s > /go-module/go.mod", module.modSHA)
+		line("RUN %s > /go-module/go.mod", catBlob("/go-module", module.modSHA))
 		if module.sumSHA != "" {
-			line("RUN git --git-dir=/git-ref --work-tree=/go-module cat-file blob %s > /go-module/go.sum", module.sumSHA)
+			line("RUN %s > /go-module/go.sum", catBlob("/go-module", module.sumSHA))
 		}
 		// drop any replaced modules
 		line("RUN cd /go-module && go mod edit -json | jq -r '.Replace? // [] | .[] | .Old.Path' | xargs -r -I{} go mod edit -dropreplace={} -droprequire={}")
 		// grab what’s left, best effort only to avoid breaking on (say) private modules
-		line("RUN cd /go-module && go mod download || true")
+		line("RUN %scd /go-module && go mod download || true", build.secretMounts())
 		line("RUN rm -rf /go-module")
 	}
 
@@ -932,34 +1757,58 @@ func buildLayeredImage(ctx context.Context, imgName, baseImage, gitRoot string,
 	}
 
 	start := time.Now()
-	cmdArgs := []string{
-		"build",
-		"-t", imgName,
-		"-f", dockerfilePath,
-		"--build-arg", "GIT_USER_EMAIL=" + gitUserEmail,
-		"--build-arg", "GIT_USER_NAME=" + gitUserName,
-		".",
-	}
+	cmdArgs := build.buildArgs(imgName, dockerfilePath,
+		"GIT_USER_EMAIL="+gitUserEmail,
+		"GIT_USER_NAME="+gitUserName,
+	)
 
-	commonDir, err := gitCommonDir(ctx, gitRoot)
+	contextDir, err := gitCommonDir(ctx, gitRoot)
 	if err != nil {
 		return fmt.Errorf("failed to get git common dir: %w", err)
 	}
+	if build.noGitObjects {
+		contextDir = filepath.Join(tmpDir, "git-blobs")
+		if err := writeBlobs(ctx, gitRoot, contextDir, blobs); err != nil {
+			return err
+		}
+	}
+
+	buildOut := io.Writer(os.Stdout)
+	switch output.Default().Mode() {
+	case output.JSON:
+		buildOut = os.Stderr // keep stdout to JSON lines
+		fallthrough
+	case output.Plain, output.CI:
+		cmdArgs = slices.Insert(cmdArgs, slices.Index(cmdArgs, "build")+1, "--progress=plain")
+	}
 
 	cmd := exec.CommandContext(ctx, "docker", cmdArgs...)
-	cmd.Dir = commonDir
+	cmd.Dir = contextDir
+	// Secret mounts and inline caches need BuildKit, which older docker versions don't default to.
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
 	// We print the docker build output whether or not the user
 	// has selected --verbose. Building an image takes a while
 	// and this gives good context.
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = buildOut
 	cmd.Stderr = os.Stderr
-	fmt.Printf("🏗️  building docker image %s from base %s...\n", imgName, baseImage)
+	if progress != nil {
+		// Teeing the output means docker doesn't see a terminal,
+		// so BuildKit prints the plain progress that buildProgress parses.
+		bp := &buildProgress{p: progress}
+		cmd.Stdout = io.MultiWriter(buildOut, &lineWriter{fn: bp.line})
+		cmd.Stderr = io.MultiWriter(os.Stderr, &lineWriter{fn: bp.line})
+		progress.send(ProgressEvent{Stage: StageBuild, Message: "building docker image"})
+	}
+	output.Printf("🏗️ ", "building docker image %s from base %s...", imgName, baseImage)
 
 	err = run(ctx, "docker build", cmd)
+	if err != nil && build.push != "" {
+		return fmt.Errorf("docker buildx build failed (multi-platform builds need a builder that supports them, e.g. `docker buildx create --use`): %v", err)
+	}
 	if err != nil {
 		return fmt.Errorf("docker build failed: %v", err)
 	}
-	fmt.Printf("built docker image %s in %s\n", imgName, time.Since(start).Round(time.Millisecond))
+	output.Successf("built docker image %s in %s", imgName, time.Since(start).Round(time.Millisecond))
 	return nil
 }
 
@@ -1059,6 +1908,73 @@ func collectGoModules(ctx context.Context, gitRoot string) ([]goModuleInfo, erro
 	return modules, nil
 }
 
+// goWorkspaceInfo represents a go.work file and the modules it uses.
+type goWorkspaceInfo struct {
+	// workPath is the path to the go.work file, relative to the git root
+	workPath string
+	// workSHA is the git blob SHA of the go.work file
+	workSHA string
+	// sumSHA is the git blob SHA of the go.work.sum file, empty if none exists
+	sumSHA string
+	// modules are the used modules that are committed to the repository
+	modules []goModuleInfo
+	// dropUses are use directives, as written in go.work, that can't be
+	// satisfied from the repository (outside it, or not committed)
+	dropUses []string
+}
+
+// collectGoWorkspaces returns all go.work files in the git repository,
+// matched up with the modules from collectGoModules that they use.
+func collectGoWorkspaces(ctx context.Context, gitRoot string, modules []goModuleInfo) ([]goWorkspaceInfo, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "*.work")
+	cmd.Dir = gitRoot
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files -z *.work: %s: %w", out, err)
+	}
+
+	byDir := make(map[string]goModuleInfo)
+	for _, m := range modules {
+		byDir[path.Dir(m.modPath)] = m
+	}
+
+	var workspaces []goWorkspaceInfo
+	for _, file := range strings.Split(string(out), "\x00") {
+		if path.Base(file) != "go.work" {
+			continue
+		}
+		workSHA, err := getGitBlobSHA(ctx, gitRoot, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blob SHA for %s: %w", file, err)
+		}
+		cmd := exec.CommandContext(ctx, "git", "cat-file", "blob", workSHA)
+		cmd.Dir = gitRoot
+		content, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("git cat-file blob %s: %w", workSHA, err)
+		}
+		wf, err := modfile.ParseWork(file, content, nil)
+		if err != nil {
+			// A broken go.work breaks the build anyway; don't let it break the image too.
+			slog.WarnContext(ctx, "ignoring unparseable go.work", "path", file, "err", err)
+			continue
+		}
+		sumSHA, _ := getGitBlobSHA(ctx, gitRoot, path.Join(path.Dir(file), "go.work.sum")) // best effort
+
+		ws := goWorkspaceInfo{workPath: file, workSHA: workSHA, sumSHA: sumSHA}
+		for _, use := range wf.Use {
+			m, ok := byDir[path.Join(path.Dir(file), use.Path)]
+			if ok && !path.IsAbs(use.Path) {
+				ws.modules = append(ws.modules, m)
+			} else {
+				ws.dropUses = append(ws.dropUses, use.Path)
+			}
+		}
+		workspaces = append(workspaces, ws)
+	}
+	return workspaces, nil
+}
+
 // getGitBlobSHA returns the git blob SHA for a file at HEAD
 func getGitBlobSHA(ctx context.Context, gitRoot, filePath string) (string, error) {
 	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD:"+filePath)
@@ -1243,6 +2159,9 @@ const seccompProfile = `{
   ]
 }`
 
+// seccompProfileName is the name seccompProfile is written under; change it when the profile changes.
+const seccompProfileName = "seccomp-no-kill-1.json"
+
 // ensureSeccompProfile creates the seccomp profile file in the sketch cache directory if it doesn't exist.
 func ensureSeccompProfile(ctx context.Context) (seccompPath string, err error) {
 	homeDir, err := os.UserHomeDir()
@@ -1253,7 +2172,7 @@ func ensureSeccompProfile(ctx context.Context) (seccompPath string, err error) {
 	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
 		return "", fmt.Errorf("failed to create cache directory: %w", err)
 	}
-	seccompPath = filepath.Join(cacheDir, "seccomp-no-kill-1.json")
+	seccompPath = filepath.Join(cacheDir, seccompProfileName)
 
 	curBytes, err := os.ReadFile(seccompPath)
 	if err != nil && !os.IsNotExist(err) {
@@ -1269,3 +2188,29 @@ func ensureSeccompProfile(ctx context.Context) (seccompPath string, err error) {
 	slog.DebugContext(ctx, "created seccomp profile", "path", seccompPath)
 	return seccompPath, nil
 }
+
+type writtenDevcontainer struct {
+	info      *devcontainer.Info
+	dir, path string
+}
+
+// writeDevcontainer writes a devcontainer.json for attaching IDEs to the
+// container to a temporary directory, which the caller removes.
+func writeDevcontainer(cntrName, workspaceFolder, gitRoot string) (*writtenDevcontainer, error) {
+	info := devcontainer.New(devcontainer.Target{
+		Container:       cntrName,
+		SSHHost:         cntrName,
+		WorkspaceFolder: workspaceFolder,
+		RepoDir:         gitRoot,
+	})
+	dir, err := os.MkdirTemp("", cntrName+"-devcontainer-")
+	if err != nil {
+		return nil, err
+	}
+	p, err := devcontainer.Write(dir, info)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &writtenDevcontainer{info: info, dir: dir, path: p}, nil
+}
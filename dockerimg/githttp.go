@@ -5,7 +5,9 @@ import (
 	"context"
 	"crypto/subtle"
 	_ "embed"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/cgi"
@@ -13,9 +15,16 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"text/template"
 	"time"
+
+	"sketch.dev/gittrace"
+	"sketch.dev/hooks"
+	"sketch.dev/llm/ant"
+	"sketch.dev/loop"
+	"sketch.dev/webhook"
 )
 
 //go:embed pre-receive.sh
@@ -28,7 +37,13 @@ type gitHTTP struct {
 	gitRepoRoot string
 	hooksDir    string
 	pass        []byte
-	browserC    chan bool // browser launch requests
+	tokens      ant.TokenSource                      // lends Anthropic OAuth access tokens to the container, if set
+	fire        func(context.Context, hooks.Payload) // runs the user's lifecycle hooks, if set
+	webhook     func(webhook.Event)                  // queues an event for the user's webhook
+	trace       *gittrace.Recorder                   // records our git commands, if set
+	traceLog    func(gittrace.Op)                    // appends the container's git commands to the trace, if set
+	image       *imageWatcher                        // checks for a newer base image, if set
+	matrix      *matrixRunner                        // runs the verification across the -matrix, if set
 }
 
 // setupHooksDir creates a temporary directory with git hooks for this session.
@@ -106,20 +121,83 @@ func (g *gitHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// TODO: real mux?
-	if strings.HasPrefix(r.URL.Path, "/browser") {
+	if strings.HasPrefix(r.URL.Path, "/anthropic-token") {
+		g.serveAnthropicToken(w, r)
+		return
+	}
+
+	// The container reports the events only it sees. It doesn't get to describe
+	// them, because we are running the user's commands and it is untrusted.
+	if event, ok := strings.CutPrefix(r.URL.Path, "/hooks/"); ok {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-		defer r.Body.Close()
+		if hooks.Event(event) != hooks.BudgetExceeded {
+			http.Error(w, "unknown event", http.StatusNotFound)
+			return
+		}
+		if g.fire != nil {
+			g.fire(r.Context(), hooks.Payload{Event: hooks.BudgetExceeded})
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-		select {
-		case g.browserC <- true:
-			slog.InfoContext(r.Context(), "open browser requested")
-			w.WriteHeader(http.StatusOK)
-		default:
-			http.Error(w, "Too many browser launch requests", http.StatusTooManyRequests)
+	// Webhook events are the agent's to describe: they go to the user's
+	// endpoint, not to a command. Which session they come from is ours to say.
+	if r.URL.Path == "/webhooks" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var e webhook.Event
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&e); err != nil {
+			http.Error(w, "bad event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(webhook.Events, e.Type) {
+			http.Error(w, "unknown event", http.StatusNotFound)
+			return
+		}
+		if g.webhook != nil {
+			g.webhook(e)
 		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The container asks whether its base image is stale, and to be rebuilt on the
+	// latest one. Which image that is, is ours to say.
+	if r.URL.Path == "/image" || r.URL.Path == "/image/rebuild" {
+		g.serveImage(w, r)
+		return
+	}
+
+	// The container asks for its working tree to be verified in each environment
+	// of the matrix. What runs where is ours to say; it only picks environments.
+	if r.URL.Path == "/matrix" {
+		g.serveMatrix(w, r)
+		return
+	}
+
+	// The container's own git commands, for the trace. It may only speak for its side.
+	if r.URL.Path == "/git-trace" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if g.traceLog == nil {
+			http.Error(w, "not tracing", http.StatusNotFound)
+			return
+		}
+		var op gittrace.Op
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&op); err != nil || op.Side != gittrace.Agent {
+			http.Error(w, "bad op", http.StatusBadRequest)
+			return
+		}
+		g.traceLog(op)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
@@ -152,7 +230,7 @@ func (g *gitHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		cmd.Dir = g.gitRepoRoot
 
 		// Execute the command
-		output, err := cmd.CombinedOutput()
+		output, err := g.trace.CombinedOutput(cmd)
 		if err != nil {
 			slog.WarnContext(r.Context(), "git fetch failed",
 				"error", err,
@@ -178,7 +256,16 @@ func (g *gitHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"-c", "receive.denyCurrentBranch=refuse",
 		)
 	}
-	args = append(args, "http-backend")
+	// The container fetches commits on no branch, such as the one carrying
+	// the host's uncommitted changes, by hash; partial clones fetch blobs the same way.
+	args = append(args, "-c", "uploadpack.allowAnySHA1InWant=true", "-c", "uploadpack.allowFilter=true", "http-backend")
+
+	// Pushes are how the agent's branches reach the host repo.
+	pushing := g.fire != nil && r.Method == http.MethodPost && strings.HasSuffix(path, "/git-receive-pack")
+	var before map[string]string
+	if pushing {
+		before = g.branches(r.Context())
+	}
 
 	h := &cgi.Handler{
 		Path: gitBin,
@@ -198,5 +285,132 @@ func (g *gitHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"SSH_AUTH_SOCK=" + os.Getenv("SSH_AUTH_SOCK"),
 		},
 	}
-	h.ServeHTTP(w, r)
+	if g.trace == nil {
+		h.ServeHTTP(w, r)
+	} else {
+		// The request goes along, for replays to know which push the refs come from.
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		g.trace.Record(g.gitRepoRoot, append(args, r.Method+" "+path), func() error {
+			h.ServeHTTP(sw, r)
+			if sw.status >= 400 {
+				return fmt.Errorf("http-backend: %d", sw.status)
+			}
+			return nil
+		})
+	}
+
+	if pushing {
+		for branch, commit := range g.branches(r.Context()) {
+			if before[branch] != commit {
+				g.fire(r.Context(), hooks.Payload{Event: hooks.BranchPushed, Branch: branch, Commit: commit, OldCommit: before[branch]})
+			}
+		}
+	}
+}
+
+// statusWriter notes the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// branches returns the commits of the repo's branches, by name.
+func (g *gitHTTP) branches(ctx context.Context) map[string]string {
+	cmd := exec.CommandContext(ctx, "git", "-C", g.gitRepoRoot, "for-each-ref", "--format=%(refname:short)%00%(objectname)", "refs/heads/")
+	out, err := cmd.Output()
+	if err != nil {
+		slog.DebugContext(ctx, "githttp: listing branches failed", "error", err)
+		return nil
+	}
+	branches := make(map[string]string)
+	for line := range strings.Lines(string(out)) {
+		if name, commit, ok := strings.Cut(strings.TrimSpace(line), "\x00"); ok {
+			branches[name] = commit
+		}
+	}
+	return branches
+}
+
+// serveAnthropicToken hands the container a current OAuth access token.
+// The refresh token never leaves the host.
+func (g *gitHTTP) serveAnthropicToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if g.tokens == nil {
+		http.Error(w, "Anthropic OAuth is not configured", http.StatusNotFound)
+		return
+	}
+	tok, err := g.tokens.Token(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "githttp: anthropic token", "err", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ant.OAuthToken{AccessToken: tok.AccessToken, ExpiresAt: tok.ExpiresAt})
+}
+
+// serveImage serves GET /image, where the container checks for a newer base
+// image, and POST /image/rebuild, where it asks for a container built on it.
+func (g *gitHTTP) serveImage(w http.ResponseWriter, r *http.Request) {
+	if g.image == nil {
+		http.Error(w, "no image to check", http.StatusNotFound)
+		return
+	}
+	if r.URL.Path == "/image" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g.image.check(r.Context(), false))
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := g.image.prepareRebuild(r.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveMatrix serves POST /matrix, which runs the verification in the
+// requested environments of the matrix and answers with a loop.MatrixRun.
+func (g *gitHTTP) serveMatrix(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if g.matrix == nil {
+		http.Error(w, "no -matrix", http.StatusNotFound)
+		return
+	}
+	var req loop.MatrixRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	run, err := g.matrix.run(r.Context(), req.Envs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
 }
@@ -1,10 +1,20 @@
 package dockerimg
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+
+	"sketch.dev/gittrace"
+	"sketch.dev/hooks"
+	"sketch.dev/webhook"
 )
 
 func TestSetupHooksDir(t *testing.T) {
@@ -164,7 +174,6 @@ func TestGitHTTPHooksConfiguration(t *testing.T) {
 		gitRepoRoot: tmpDir,
 		hooksDir:    hooksDir,
 		pass:        []byte("test-pass"),
-		browserC:    make(chan bool, 1),
 	}
 
 	// Test that the gitHTTP struct has the hooks directory set
@@ -228,3 +237,136 @@ func TestPreReceiveHookExecution(t *testing.T) {
 		t.Errorf("pre-receive hook is not executable: mode = %v", mode)
 	}
 }
+
+func TestGitHTTPLifecycleHooks(t *testing.T) {
+	repo := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-b", "main"},
+		{"config", "http.receivepack", "true"},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "--allow-empty", "-m", "initial"},
+	} {
+		if err := runGitCommand(repo, args...); err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+	}
+
+	var mu sync.Mutex
+	var fired []hooks.Payload
+	srv := httptest.NewServer(&gitHTTP{
+		gitRepoRoot: repo,
+		pass:        []byte("test-pass"),
+		fire: func(ctx context.Context, p hooks.Payload) {
+			mu.Lock()
+			defer mu.Unlock()
+			fired = append(fired, p)
+		},
+	})
+	defer srv.Close()
+	remote := strings.Replace(srv.URL, "http://", "http://sketch:test-pass@", 1) + "/.git"
+
+	clone := t.TempDir()
+	if out, err := exec.Command("git", "clone", remote, clone).CombinedOutput(); err != nil {
+		t.Fatalf("git clone: %v: %s", err, out)
+	}
+	for _, args := range [][]string{
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "--allow-empty", "-m", "work"},
+		{"push", "origin", "HEAD:refs/heads/sketch/work"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", clone}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	head, err := exec.Command("git", "-C", clone, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(path string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+path, nil)
+		req.SetBasicAuth("sketch", "test-pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post("/hooks/budget_exceeded"); code != http.StatusOK {
+		t.Errorf("budget_exceeded: status %d", code)
+	}
+	// The host sees the end of the session itself; the container can't claim it.
+	if code := post("/hooks/session_end"); code != http.StatusNotFound {
+		t.Errorf("session_end: status %d", code)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []hooks.Payload{
+		{Event: hooks.BranchPushed, Branch: "sketch/work", Commit: strings.TrimSpace(string(head))},
+		{Event: hooks.BudgetExceeded},
+	}
+	if !slices.Equal(fired, want) {
+		t.Errorf("fired %+v\nwant %+v", fired, want)
+	}
+}
+
+func TestGitHTTPWebhooks(t *testing.T) {
+	var got []webhook.Event
+	srv := httptest.NewServer(&gitHTTP{
+		pass:    []byte("test-pass"),
+		webhook: func(e webhook.Event) { got = append(got, e) },
+	})
+	defer srv.Close()
+
+	post := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/webhooks", strings.NewReader(body))
+		req.SetBasicAuth("sketch", "test-pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(`{"type":"commit","commits":[{"hash":"abc","subject":"Fix"}]}`); code != http.StatusOK {
+		t.Errorf("commit: status %d", code)
+	}
+	if code := post(`{"type":"session_end"}`); code != http.StatusNotFound {
+		t.Errorf("unknown event: status %d", code)
+	}
+	if code := post(`{`); code != http.StatusBadRequest {
+		t.Errorf("bad JSON: status %d", code)
+	}
+	if len(got) != 1 || got[0].Type != webhook.CommitEvent || len(got[0].Commits) != 1 {
+		t.Errorf("sent %+v", got)
+	}
+}
+
+func TestGitHTTPGitTrace(t *testing.T) {
+	var got []gittrace.Op
+	srv := httptest.NewServer(&gitHTTP{
+		pass:     []byte("test-pass"),
+		traceLog: func(op gittrace.Op) { got = append(got, op) },
+	})
+	defer srv.Close()
+
+	post := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/git-trace", strings.NewReader(body))
+		req.SetBasicAuth("sketch", "test-pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(`{"side":"agent","args":["push","origin"],"exit":1}`); code != http.StatusOK {
+		t.Errorf("agent op: status %d", code)
+	}
+	if code := post(`{"side":"host","args":["http-backend"]}`); code != http.StatusBadRequest {
+		t.Errorf("host op from the container: status %d", code)
+	}
+	if len(got) != 1 || got[0].Exit != 1 || got[0].Args[0] != "push" {
+		t.Errorf("traced %+v", got)
+	}
+}
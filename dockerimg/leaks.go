@@ -0,0 +1,329 @@
+package dockerimg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"sketch.dev/output"
+)
+
+// Labels createDockerContainer puts on session containers, so that sketch gc
+// can tell a container whose sketch crashed from one still in use.
+const (
+	labelOutsidePID  = "dev.sketch.outside-pid"
+	labelOutsideHost = "dev.sketch.outside-host"
+	labelNoCleanup   = "dev.sketch.nocleanup"
+)
+
+// containerLabels returns the docker create flags that label a session container.
+func containerLabels(noCleanup bool) []string {
+	host, _ := os.Hostname()
+	labels := []string{"--label", labelOutsidePID + "=" + strconv.Itoa(os.Getpid()), "--label", labelOutsideHost + "=" + host}
+	if noCleanup {
+		labels = append(labels, "--label", labelNoCleanup+"=true")
+	}
+	return labels
+}
+
+// codereviewWorktreePrefix starts the name of the temporary worktrees the code
+// review checks the sketch base out in.
+const codereviewWorktreePrefix = "sketch-codereview-worktree"
+
+// A Leak is something sketch sessions leave behind when they don't exit
+// cleanly: a container, an image no container uses, a worktree, or a cache file.
+type Leak struct {
+	Kind   string // "container", "image", "worktree" or "cache"
+	Name   string // the container or image name, or the path
+	Detail string // why it counts as left behind
+	clean  func(ctx context.Context) error
+}
+
+func (l Leak) String() string { return fmt.Sprintf("%s %s (%s)", l.Kind, l.Name, l.Detail) }
+
+// Clean removes what l found.
+func (l Leak) Clean(ctx context.Context) error {
+	if err := l.clean(ctx); err != nil {
+		return fmt.Errorf("removing %s %s: %w", l.Kind, l.Name, err)
+	}
+	return nil
+}
+
+// LeakOptions says where to look, and what is old enough, to count as left behind.
+type LeakOptions struct {
+	// MinAge is how long a worktree or a stale cache file must have been
+	// around, so that one a session is using right now isn't taken.
+	MinAge time.Duration
+	// Repo is the git repository whose code review worktrees to check; empty skips them.
+	Repo string
+	// Images has FindLeaks count the sketch images no container uses that
+	// were built over staleImageAge ago. They may still be a repo's current
+	// image; removing one only means building it again.
+	Images bool
+}
+
+// staleImageAge is how old an unused sketch image must be to count as left behind.
+const staleImageAge = 30 * 24 * time.Hour
+
+// FindLeaks returns what past sessions left behind. It looks at everything it
+// can, and reports what it couldn't look at in the error.
+func FindLeaks(ctx context.Context, opts LeakOptions) ([]Leak, error) {
+	var leaks []Leak
+	var errs []error
+	l, err := dockerLeaks(ctx, opts.Images, time.Now())
+	leaks = append(leaks, l...)
+	errs = append(errs, err)
+	if opts.Repo != "" {
+		l, err := worktreeLeaks(ctx, opts.Repo, opts.MinAge)
+		leaks = append(leaks, l...)
+		errs = append(errs, err)
+	}
+	leaks = append(leaks, orphanedWorktreeDirs(os.TempDir(), opts.MinAge)...)
+	if home, err := os.UserHomeDir(); err == nil {
+		leaks = append(leaks, cacheLeaks(filepath.Join(home, ".cache", "sketch"), opts.MinAge)...)
+	}
+	return leaks, errors.Join(errs...)
+}
+
+// dockerLeaks finds session containers whose sketch is gone and sketch images no container uses.
+func dockerLeaks(ctx context.Context, images bool, now time.Time) ([]Leak, error) {
+	ps, err := combinedOutput(ctx, "docker", "ps", "-a", "--no-trunc", "--format",
+		`{{.Names}}\t{{.State}}\t{{.Image}}\t{{.Label "`+labelOutsidePID+`"}}\t{{.Label "`+labelOutsideHost+`"}}\t{{.Label "`+labelNoCleanup+`"}}`)
+	if err != nil {
+		return nil, fmt.Errorf("docker ps: %s", strings.TrimSpace(string(ps)))
+	}
+	host, _ := os.Hostname()
+	leaks, used := containerLeaks(string(ps), host, processAlive)
+
+	if !images {
+		return leaks, nil
+	}
+	out, err := combinedOutput(ctx, "docker", "images", "--format", `{{.Repository}}\t{{.Tag}}\t{{.CreatedAt}}`)
+	if err != nil {
+		return leaks, fmt.Errorf("docker images: %s", strings.TrimSpace(string(out)))
+	}
+	return append(leaks, imageLeaks(string(out), used, now)...), nil
+}
+
+// containerLeaks picks, out of docker ps output, the session containers left
+// behind: stopped ones, and running ones whose sketch on this host has died.
+// A container made with -nocleanup stays on purpose. It also returns the
+// images any container uses.
+func containerLeaks(ps, host string, alive func(pid int) bool) ([]Leak, map[string]bool) {
+	var leaks []Leak
+	used := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimRight(ps, "\n"), "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) < 6 {
+			continue
+		}
+		name, state, image, pidLabel, hostLabel, noCleanup := f[0], f[1], f[2], f[3], f[4], f[5]
+		used[image] = true
+		if !strings.HasPrefix(name, "sketch-") || noCleanup == "true" {
+			continue
+		}
+		pid, _ := strconv.Atoi(pidLabel)
+		owned := pid > 0 && hostLabel == host
+		var detail string
+		switch {
+		case owned && alive(pid):
+			continue // its sketch is still using it
+		case state == "running" || state == "paused":
+			if !owned {
+				continue // started on another host, or by a sketch that didn't label it
+			}
+			detail = fmt.Sprintf("%s, but sketch (pid %d) is gone", state, pid)
+		default:
+			detail = state
+		}
+		leaks = append(leaks, Leak{Kind: "container", Name: name, Detail: detail, clean: func(ctx context.Context) error {
+			if out, err := combinedOutput(ctx, "docker", "rm", "-f", name); err != nil {
+				return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+			}
+			return nil
+		}})
+	}
+	return leaks, used
+}
+
+// imageLeaks picks, out of docker images output, the sketch images built for
+// a repo that no container uses and that were built over staleImageAge ago.
+func imageLeaks(images string, used map[string]bool, now time.Time) []Leak {
+	var leaks []Leak
+	for _, line := range strings.Split(strings.TrimSpace(images), "\n") {
+		f := strings.Split(line, "\t")
+		if len(f) < 3 || !strings.HasPrefix(f[0], "sketch-") {
+			continue
+		}
+		ref := f[0] + ":" + f[1]
+		if used[ref] || (f[1] == "latest" && used[f[0]]) {
+			continue
+		}
+		created, err := time.Parse("2006-01-02 15:04:05 -0700 MST", f[2])
+		if err != nil || now.Sub(created) < staleImageAge {
+			continue
+		}
+		leaks = append(leaks, Leak{Kind: "image", Name: ref, Detail: "built " + created.Format(time.DateOnly) + ", no container uses it", clean: func(ctx context.Context) error {
+			if out, err := combinedOutput(ctx, "docker", "rmi", ref); err != nil {
+				return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+			}
+			return nil
+		}})
+	}
+	return leaks
+}
+
+// processAlive reports whether the process pid exists on this machine.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// worktreeLeaks finds the code review worktrees registered in repo that are
+// older than minAge, or whose directory is already gone.
+func worktreeLeaks(ctx context.Context, repo string, minAge time.Duration) ([]Leak, error) {
+	cmd := exec.CommandContext(ctx, "git", "worktree", "list", "--porcelain")
+	cmd.Dir = repo
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git worktree list: %w", err)
+	}
+	var leaks []Leak
+	sc := bufio.NewScanner(strings.NewReader(string(out)))
+	for sc.Scan() {
+		path, ok := strings.CutPrefix(sc.Text(), "worktree ")
+		if !ok || !strings.HasPrefix(filepath.Base(path), codereviewWorktreePrefix) {
+			continue
+		}
+		detail := "the directory is gone"
+		if fi, err := os.Stat(path); err == nil {
+			if time.Since(fi.ModTime()) < minAge {
+				continue
+			}
+			detail = "a code review's checkout of the sketch base"
+		}
+		leaks = append(leaks, Leak{Kind: "worktree", Name: path, Detail: detail, clean: func(ctx context.Context) error {
+			cmd := exec.CommandContext(ctx, "git", "worktree", "remove", "--force", path)
+			cmd.Dir = repo
+			if _, err := os.Stat(path); err != nil {
+				cmd = exec.CommandContext(ctx, "git", "worktree", "prune")
+				cmd.Dir = repo
+			}
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("%s", strings.TrimSpace(string(out)))
+			}
+			return nil
+		}})
+	}
+	return leaks, nil
+}
+
+// orphanedWorktreeDirs finds code review worktrees in tmp whose repository
+// no longer has them, so that git won't ever remove them.
+func orphanedWorktreeDirs(tmp string, minAge time.Duration) []Leak {
+	dirs, _ := filepath.Glob(filepath.Join(tmp, codereviewWorktreePrefix+"*"))
+	var leaks []Leak
+	for _, dir := range dirs {
+		fi, err := os.Stat(dir)
+		if err != nil || !fi.IsDir() || time.Since(fi.ModTime()) < minAge {
+			continue
+		}
+		dotGit, err := os.ReadFile(filepath.Join(dir, ".git"))
+		gitdir, ok := strings.CutPrefix(strings.TrimSpace(string(dotGit)), "gitdir: ")
+		if err == nil && ok {
+			if _, err := os.Stat(gitdir); err == nil {
+				continue // still registered; worktreeLeaks covers it from its repo
+			}
+		}
+		leaks = append(leaks, Leak{Kind: "worktree", Name: dir, Detail: "no repository has it any more", clean: func(context.Context) error {
+			return os.RemoveAll(dir)
+		}})
+	}
+	return leaks
+}
+
+// cacheLeaks finds files in sketch's cache directory that this version of
+// sketch no longer uses: seccomp profiles under an older name, and web UI
+// bundles other than the latest.
+func cacheLeaks(cacheDir string, minAge time.Duration) []Leak {
+	var leaks []Leak
+	stale := func(path, detail string) {
+		fi, err := os.Stat(path)
+		if err != nil || time.Since(fi.ModTime()) < minAge {
+			return
+		}
+		leaks = append(leaks, Leak{Kind: "cache", Name: path, Detail: detail, clean: func(context.Context) error {
+			return os.Remove(path)
+		}})
+	}
+	profiles, _ := filepath.Glob(filepath.Join(cacheDir, "seccomp-*.json"))
+	for _, p := range profiles {
+		if filepath.Base(p) != seccompProfileName {
+			stale(p, "an older seccomp profile")
+		}
+	}
+
+	bundles, _ := filepath.Glob(filepath.Join(cacheDir, "webui", "skui-*.zip"))
+	modTime := func(path string) time.Time {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	slices.SortFunc(bundles, func(a, b string) int { return modTime(b).Compare(modTime(a)) })
+	for i, b := range bundles {
+		if i > 0 {
+			stale(b, "an older web UI bundle")
+		}
+	}
+	return leaks
+}
+
+// verifyContainerRemoved checks, once a session is over, that its container
+// is gone, and says how to remove it if not.
+func verifyContainerRemoved(ctx context.Context, cntrName string) {
+	out, err := combinedOutput(ctx, "docker", "ps", "-a", "--filter", "name=^"+cntrName+"$", "--format", "{{.State}}")
+	if state := strings.TrimSpace(string(out)); err == nil && state != "" {
+		output.Warnf("container %s (%s) was left behind; sketch gc --sessions removes it", cntrName, state)
+	}
+}
+
+// leakCheckDelay is how long WatchLeaks waits before its first check, to stay
+// out of the way of a session starting up.
+const leakCheckDelay = time.Minute
+
+// WatchLeaks checks for what past sessions left behind every interval, the
+// first time shortly after it starts, and calls report when it finds more than
+// it last reported. It returns when ctx is done.
+func WatchLeaks(ctx context.Context, opts LeakOptions, interval time.Duration, report func([]Leak)) {
+	reported := 0
+	t := time.NewTimer(leakCheckDelay)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		leaks, err := FindLeaks(ctx, opts)
+		if err != nil {
+			slog.DebugContext(ctx, "checking for leftovers of past sessions", "error", err)
+		}
+		if len(leaks) > reported {
+			report(leaks)
+		}
+		reported = len(leaks)
+		t.Reset(interval)
+	}
+}
@@ -0,0 +1,129 @@
+package dockerimg
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func leakNames(leaks []Leak) []string {
+	var names []string
+	for _, l := range leaks {
+		names = append(names, l.Kind+" "+l.Name)
+	}
+	return names
+}
+
+func TestContainerLeaks(t *testing.T) {
+	ps := "sketch-live\trunning\tsketch-aaa\t100\thost\t\n" +
+		"sketch-crashed\trunning\tsketch-aaa\t200\thost\t\n" +
+		"sketch-exited\texited\tsketch-bbb:v1\t\t\t\n" +
+		"sketch-kept\texited\tsketch-ccc\t200\thost\ttrue\n" +
+		"sketch-elsewhere\trunning\tsketch-ddd\t200\tother\t\n" +
+		"postgres\texited\tpostgres:16\t\t\t\n"
+	alive := func(pid int) bool { return pid == 100 }
+	leaks, used := containerLeaks(ps, "host", alive)
+	if got, want := leakNames(leaks), []string{"container sketch-crashed", "container sketch-exited"}; !slices.Equal(got, want) {
+		t.Errorf("leaks = %q, want %q", got, want)
+	}
+	if !used["sketch-aaa"] || !used["sketch-bbb:v1"] || !used["postgres:16"] {
+		t.Errorf("used = %v", used)
+	}
+
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	images := "sketch-aaa\tlatest\t2025-01-01 10:00:00 +0000 UTC\n" +
+		"sketch-old\tlatest\t2025-01-01 10:00:00 +0000 UTC\n" +
+		"sketch-new\tlatest\t2025-05-30 10:00:00 +0000 UTC\n" +
+		"ghcr.io/boldsoftware/sketch\tabc\t2025-01-01 10:00:00 +0000 UTC\n"
+	if got, want := leakNames(imageLeaks(images, used, now)), []string{"image sketch-old:latest"}; !slices.Equal(got, want) {
+		t.Errorf("image leaks = %q, want %q", got, want)
+	}
+}
+
+func TestCacheLeaks(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	write := func(name string, mtime time.Time) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	write(seccompProfileName, old)
+	write("seccomp-no-kill-0.json", old)
+	write("webui/skui-new.zip", time.Now().Add(-90*time.Minute))
+	write("webui/skui-old.zip", old)
+	write("webui/skui-older.zip", old.Add(-time.Hour))
+
+	leaks := cacheLeaks(dir, time.Hour)
+	want := []string{
+		"cache " + filepath.Join(dir, "seccomp-no-kill-0.json"),
+		"cache " + filepath.Join(dir, "webui/skui-old.zip"),
+		"cache " + filepath.Join(dir, "webui/skui-older.zip"),
+	}
+	if got := leakNames(leaks); !slices.Equal(got, want) {
+		t.Fatalf("leaks = %q, want %q", got, want)
+	}
+	for _, l := range leaks {
+		if err := l.Clean(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "webui/skui-new.zip")); err != nil {
+		t.Errorf("the latest bundle is gone: %v", err)
+	}
+	if leaks := cacheLeaks(dir, time.Hour); len(leaks) != 0 {
+		t.Errorf("after cleaning: %q", leakNames(leaks))
+	}
+}
+
+func TestWorktreeLeaks(t *testing.T) {
+	ctx := context.Background()
+	repo, tmp := t.TempDir(), t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s", args, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "initial")
+	stale := filepath.Join(tmp, codereviewWorktreePrefix+"1")
+	fresh := filepath.Join(tmp, codereviewWorktreePrefix+"2")
+	git("worktree", "add", "-q", "--detach", stale)
+	git("worktree", "add", "-q", "--detach", fresh)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(stale, old, old)
+
+	leaks, err := worktreeLeaks(ctx, repo, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := leakNames(leaks); len(got) != 1 || filepath.Base(leaks[0].Name) != filepath.Base(stale) {
+		t.Fatalf("leaks = %q", got)
+	}
+	if err := leaks[0].Clean(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if leaks, _ := worktreeLeaks(ctx, repo, time.Hour); len(leaks) != 0 {
+		t.Errorf("after cleaning: %q", leakNames(leaks))
+	}
+
+	// A worktree whose repository is gone is only a directory.
+	orphan := filepath.Join(tmp, codereviewWorktreePrefix+"3")
+	os.Mkdir(orphan, 0o755)
+	os.WriteFile(filepath.Join(orphan, ".git"), []byte("gitdir: /nonexistent/.git/worktrees/x\n"), 0o644)
+	os.Chtimes(orphan, old, old)
+	leaks = orphanedWorktreeDirs(tmp, time.Hour)
+	if got, want := leakNames(leaks), []string{"worktree " + orphan}; !slices.Equal(got, want) {
+		t.Errorf("orphaned = %q, want %q", got, want)
+	}
+}
@@ -11,12 +11,14 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/kevinburke/ssh_config"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
+	"sketch.dev/output"
 )
 
 // Ed25519 has a fixed key size, no bit size constant needed
@@ -39,6 +41,7 @@ type LocalSSHimmer struct {
 	cntrName string
 	sshHost  string
 	sshPort  string
+	route    SSHRoute
 
 	knownHostsPath     string
 	userIdentityPath   string
@@ -70,12 +73,14 @@ type LocalSSHimmer struct {
 // If this doesn't return an error, you should be able to run "ssh <cntrName>"
 // in a terminal on your host machine to open a shell into the container without having
 // to manually accept changes to your known_hosts file etc.
-func NewLocalSSHimmer(cntrName, sshHost, sshPort string) (*LocalSSHimmer, error) {
-	return newLocalSSHimmerWithDeps(cntrName, sshHost, sshPort, &RealFileSystem{}, &RealKeyGenerator{})
+//
+// If the docker host is only reachable through a bastion, route says how to get there.
+func NewLocalSSHimmer(cntrName, sshHost, sshPort string, route SSHRoute) (*LocalSSHimmer, error) {
+	return newLocalSSHimmerWithDeps(cntrName, sshHost, sshPort, route, &RealFileSystem{}, &RealKeyGenerator{})
 }
 
 // newLocalSSHimmerWithDeps creates a new LocalSSHimmer with the specified dependencies
-func newLocalSSHimmerWithDeps(cntrName, sshHost, sshPort string, fs FileSystem, kg KeyGenerator) (*LocalSSHimmer, error) {
+func newLocalSSHimmerWithDeps(cntrName, sshHost, sshPort string, route SSHRoute, fs FileSystem, kg KeyGenerator) (*LocalSSHimmer, error) {
 	base := filepath.Join(os.Getenv("HOME"), ".config", "sketch")
 	if _, err := fs.Stat(base); err != nil {
 		if err := fs.MkdirAll(base, 0o777); err != nil {
@@ -83,10 +88,14 @@ func newLocalSSHimmerWithDeps(cntrName, sshHost, sshPort string, fs FileSystem,
 		}
 	}
 
+	if route.HostName != "" {
+		sshHost = route.HostName
+	}
 	cst := &LocalSSHimmer{
 		cntrName:           cntrName,
 		sshHost:            sshHost,
 		sshPort:            sshPort,
+		route:              route,
 		knownHostsPath:     filepath.Join(base, "known_hosts"),
 		userIdentityPath:   filepath.Join(base, "container_user_identity"),
 		serverIdentityPath: filepath.Join(base, "container_server_identity"),
@@ -154,6 +163,73 @@ func newLocalSSHimmerWithDeps(cntrName, sshHost, sshPort string, fs FileSystem,
 	return cst, nil
 }
 
+// SSHRoute says how ssh reaches the container when the docker host is only
+// reachable through one or more jump hosts (bastions).
+// The zero value connects directly.
+type SSHRoute struct {
+	ProxyJump    string // jump hosts as for ssh -J: [user@]host[:port], comma-separated
+	IdentityFile string // identity for the last jump host; empty leaves it to your ssh config
+	HostName     string // the docker host as the last jump host sees it; default: the published address
+}
+
+// sshHopRe matches a jump host, [user@]host[:port]. It keeps out
+// anything the shell or ssh's %-expansion would interpret in a ProxyCommand.
+var sshHopRe = regexp.MustCompile(`^[A-Za-z0-9_.\[\]][A-Za-z0-9_.@:\[\]-]*$`)
+
+// ParseSSHRoute checks the ssh route settings; tilde-prefixed identity files are expanded.
+func ParseSSHRoute(proxyJump, identityFile, hostName string) (SSHRoute, error) {
+	r := SSHRoute{ProxyJump: strings.TrimSpace(proxyJump), IdentityFile: strings.TrimSpace(identityFile), HostName: strings.TrimSpace(hostName)}
+	if r.ProxyJump != "" {
+		for hop := range strings.SplitSeq(r.ProxyJump, ",") {
+			if !sshHopRe.MatchString(hop) {
+				return SSHRoute{}, fmt.Errorf("invalid jump host %q in %q", hop, r.ProxyJump)
+			}
+		}
+	}
+	if r.IdentityFile != "" {
+		if r.ProxyJump == "" {
+			return SSHRoute{}, fmt.Errorf("an ssh jump identity needs jump hosts")
+		}
+		if rest, ok := strings.CutPrefix(r.IdentityFile, "~/"); ok {
+			r.IdentityFile = filepath.Join(os.Getenv("HOME"), rest)
+		}
+		if _, err := os.Stat(r.IdentityFile); err != nil {
+			return SSHRoute{}, fmt.Errorf("ssh jump identity: %w", err)
+		}
+	}
+	if r.HostName != "" && !sshHopRe.MatchString(r.HostName) || strings.Contains(r.HostName, "@") {
+		return SSHRoute{}, fmt.Errorf("invalid ssh host name %q", r.HostName)
+	}
+	return r, nil
+}
+
+// proxyCommand is the ProxyCommand reaching the container through the jump
+// hosts with the route's identity, or "" if ProxyJump does the job.
+// ProxyJump can't set the identity for a jump host, which would otherwise
+// need a Host block of its own in the user's ssh config.
+func (r SSHRoute) proxyCommand() string {
+	if r.ProxyJump == "" || r.IdentityFile == "" {
+		return ""
+	}
+	hops := strings.Split(r.ProxyJump, ",")
+	last := hops[len(hops)-1]
+	cmd := "ssh -i " + shellQuote(strings.ReplaceAll(r.IdentityFile, "%", "%%")) + " -W [%h]:%p"
+	if len(hops) > 1 {
+		cmd += " -J " + strings.Join(hops[:len(hops)-1], ",")
+	}
+	// The URI form is the one ssh destination syntax that takes a port.
+	return cmd + " ssh://" + last
+}
+
+// shellQuote quotes s for a POSIX shell, such as the one ssh runs a
+// ProxyCommand with, if needed.
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\$`;&|<>()*?[]{}~#!") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func checkSSHResolve(hostname string) error {
 	cmd := exec.Command("ssh", "-T", hostname)
 	out, err := cmd.CombinedOutput()
@@ -226,7 +302,7 @@ func CheckForIncludeWithFS(fs FileSystem, stdinReader bufio.Reader) error {
 	}
 
 	if firstNonIncludePos != nil && firstNonIncludePos.Line < sketchInludePos.Line {
-		fmt.Printf("⚠️  SSH confg warning: the location of the Include statement for sketch's ssh config on line %d of %s may prevent ssh from working with sketch containers. try moving it to the top of the file (before any 'Host' lines) if ssh isn't working for you.\n", sketchInludePos.Line, defaultSSHPath)
+		output.Warnf("SSH confg warning: the location of the Include statement for sketch's ssh config on line %d of %s may prevent ssh from working with sketch containers. try moving it to the top of the file (before any 'Host' lines) if ssh isn't working for you.", sketchInludePos.Line, defaultSSHPath)
 	}
 	return nil
 }
@@ -363,6 +439,11 @@ func (c *LocalSSHimmer) addContainerToSSHConfig() error {
 	hostCfg.Nodes = append(hostCfg.Nodes, &ssh_config.KV{Key: "IdentityFile", Value: c.userIdentityPath})
 	hostCfg.Nodes = append(hostCfg.Nodes, &ssh_config.KV{Key: "CertificateFile", Value: c.hostCertPath})
 	hostCfg.Nodes = append(hostCfg.Nodes, &ssh_config.KV{Key: "UserKnownHostsFile", Value: c.knownHostsPath})
+	if proxy := c.route.proxyCommand(); proxy != "" {
+		hostCfg.Nodes = append(hostCfg.Nodes, &ssh_config.KV{Key: "ProxyCommand", Value: proxy})
+	} else if c.route.ProxyJump != "" {
+		hostCfg.Nodes = append(hostCfg.Nodes, &ssh_config.KV{Key: "ProxyJump", Value: c.route.ProxyJump})
+	}
 
 	hostCfg.Nodes = append(hostCfg.Nodes, &ssh_config.Empty{})
 	cfg.Hosts = append(cfg.Hosts, hostCfg)
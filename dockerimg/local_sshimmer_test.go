@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -280,7 +281,7 @@ func setupTestLocalSSHimmer(t *testing.T) (*LocalSSHimmer, *MockFileSystem, *Moc
 	t.Cleanup(func() { os.Setenv("HOME", oldHome) })
 
 	// Create LocalSSHimmer with mocks
-	ssh, err := newLocalSSHimmerWithDeps("test-container", "localhost", "2222", mockFS, mockKG)
+	ssh, err := newLocalSSHimmerWithDeps("test-container", "localhost", "2222", SSHRoute{}, mockFS, mockKG)
 	if err != nil {
 		t.Fatalf("Failed to create LocalSSHimmer: %v", err)
 	}
@@ -304,7 +305,7 @@ func TestNewLocalSSHimmerCreatesRequiredDirectories(t *testing.T) {
 	mockFS.Files[knownHostsPath] = []byte("")
 
 	// Create sshimmer
-	_, err := newLocalSSHimmerWithDeps("test-container", "localhost", "2222", mockFS, mockKG)
+	_, err := newLocalSSHimmerWithDeps("test-container", "localhost", "2222", SSHRoute{}, mockFS, mockKG)
 	if err != nil {
 		t.Fatalf("Failed to create LocalSSHimmer: %v", err)
 	}
@@ -413,6 +414,82 @@ Host existing-host
 	}
 }
 
+func TestAddContainerToSSHConfigWithRoute(t *testing.T) {
+	tempDir := t.TempDir()
+	identity := filepath.Join(tempDir, "bastion key")
+	if err := os.WriteFile(identity, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name                     string
+		jump, identity, hostName string
+		want                     []string
+	}{
+		{
+			name: "proxy jump",
+			jump: "me@bastion:2200",
+			want: []string{"ProxyJump me@bastion:2200", "HostName 127.0.0.1"},
+		},
+		{
+			name:     "identity",
+			jump:     "outer,me@bastion:2200",
+			identity: identity,
+			hostName: "10.0.0.5",
+			want: []string{
+				"ProxyCommand ssh -i '" + identity + "' -W [%h]:%p -J outer ssh://me@bastion:2200",
+				"HostName 10.0.0.5",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			route, err := ParseSSHRoute(tc.jump, tc.identity, tc.hostName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configPath := filepath.Join(tempDir, tc.name+".ssh_config")
+			sshimmer := &LocalSSHimmer{
+				cntrName:         "test-container",
+				sshHost:          "127.0.0.1",
+				sshPort:          "2222",
+				route:            route,
+				sshConfigPath:    configPath,
+				userIdentityPath: filepath.Join(tempDir, "user_identity"),
+				fs:               &RealFileSystem{},
+				kg:               &RealKeyGenerator{},
+			}
+			if route.HostName != "" {
+				sshimmer.sshHost = route.HostName
+			}
+			if err := sshimmer.addContainerToSSHConfig(); err != nil {
+				t.Fatal(err)
+			}
+			configData, err := os.ReadFile(configPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(string(configData), want) {
+					t.Errorf("ssh config lacks %q:\n%s", want, configData)
+				}
+			}
+		})
+	}
+
+	for _, bad := range [][3]string{
+		{"bastion;rm -rf ~", "", ""},
+		{"-oProxyCommand=x", "", ""},
+		{"bastion,,other", "", ""},
+		{"", identity, ""},
+		{"bastion", filepath.Join(tempDir, "missing"), ""},
+		{"bastion", "", "host name"},
+	} {
+		if _, err := ParseSSHRoute(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("ParseSSHRoute(%q, %q, %q) succeeded", bad[0], bad[1], bad[2])
+		}
+	}
+}
+
 func TestAddContainerToKnownHosts(t *testing.T) {
 	// Skip this test as it requires more complex setup
 	// The TestLocalSSHimmerCleanup test covers the addContainerToKnownHosts
@@ -703,7 +780,7 @@ func TestLocalSSHimmerWithErrors(t *testing.T) {
 	defer func() { os.Setenv("HOME", oldHome) }()
 
 	// Try to create sshimmer with failing FS
-	_, err := newLocalSSHimmerWithDeps("test-container", "localhost", "2222", mockFS, mockKG)
+	_, err := newLocalSSHimmerWithDeps("test-container", "localhost", "2222", SSHRoute{}, mockFS, mockKG)
 	if err == nil || !strings.Contains(err.Error(), "mock mkdir error") {
 		t.Errorf("Should have failed with mkdir error, got: %v", err)
 	}
@@ -713,7 +790,7 @@ func TestLocalSSHimmerWithErrors(t *testing.T) {
 	mockKG = NewMockKeyGenerator(nil, nil, nil, nil)
 	mockKG.FailOn["GenerateKeyPair"] = fmt.Errorf("mock key generation error")
 
-	_, err = newLocalSSHimmerWithDeps("test-container", "localhost", "2222", mockFS, mockKG)
+	_, err = newLocalSSHimmerWithDeps("test-container", "localhost", "2222", SSHRoute{}, mockFS, mockKG)
 	if err == nil || !strings.Contains(err.Error(), "key generation error") {
 		t.Errorf("Should have failed with key generation error, got: %v", err)
 	}
@@ -733,3 +810,15 @@ func (m *MockKeyGenerator) GetCASigner() ssh.Signer {
 func (m *MockKeyGenerator) IsMock() bool {
 	return true
 }
+
+func TestShellQuote(t *testing.T) {
+	for _, s := range []string{"../outside", "../my lib", "it's", "$(touch pwned)", "a;b", ""} {
+		out, err := exec.Command("sh", "-c", "printf %s "+shellQuote(s)).Output()
+		if err != nil || string(out) != s {
+			t.Errorf("shellQuote(%q) = %s, which sh reads as %q (%v)", s, shellQuote(s), out, err)
+		}
+	}
+	if got := shellQuote("../outside"); got != "../outside" {
+		t.Errorf("shellQuote quoted a plain path: %s", got)
+	}
+}
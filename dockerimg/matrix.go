@@ -0,0 +1,219 @@
+package dockerimg
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/loop"
+	"sketch.dev/output"
+)
+
+// A MatrixEnv is an environment of the -matrix: the image and environment
+// variables the -matrix-verify command runs with, in a container of its own.
+type MatrixEnv struct {
+	Name  string
+	Image string   // empty for the session's own image
+	Env   []string // KEY=VALUE
+}
+
+var matrixNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ParseMatrix parses -matrix values of the form NAME=IMAGE[,KEY=VALUE...],
+// e.g. go1.22=golang:1.22, or race=,GOFLAGS=-race for the session's image.
+func ParseMatrix(specs []string) ([]MatrixEnv, error) {
+	var envs []MatrixEnv
+	for _, spec := range specs {
+		name, rest, ok := strings.Cut(spec, "=")
+		if !ok || !matrixNameRe.MatchString(name) {
+			return nil, fmt.Errorf("matrix environment %q: want NAME=IMAGE[,KEY=VALUE...], e.g. go1.22=golang:1.22", spec)
+		}
+		if slices.ContainsFunc(envs, func(e MatrixEnv) bool { return e.Name == name }) {
+			return nil, fmt.Errorf("matrix environment %s: defined twice", name)
+		}
+		fields := strings.Split(rest, ",")
+		env := MatrixEnv{Name: name, Image: strings.TrimSpace(fields[0])}
+		for _, kv := range fields[1:] {
+			if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+				return nil, fmt.Errorf("matrix environment %s: variable %q: want KEY=VALUE", name, kv)
+			}
+			env.Env = append(env.Env, kv)
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+// matrixOutputTail is how much of the end of its output a result keeps.
+const matrixOutputTail = 16 << 10
+
+// A matrixRunner runs the verification across the matrix, for the container's
+// POST /matrix and once a one-shot run ends.
+type matrixRunner struct {
+	cntr   string // the session's container, whose /app the environments copy
+	image  string // the session's image, for environments without one of their own
+	dir    string // the working directory under /app
+	envs   []MatrixEnv
+	verify string
+	// runs the verification in one environment, replaced in tests
+	runEnv func(ctx context.Context, env MatrixEnv) loop.MatrixResult
+
+	mu sync.Mutex // one run at a time, since they share container names
+}
+
+// newMatrixRunner returns the runner of the session, or nil without a matrix.
+func newMatrixRunner(cntr, image, relPath string, envs []MatrixEnv, verify string) *matrixRunner {
+	if len(envs) == 0 {
+		return nil
+	}
+	m := &matrixRunner{cntr: cntr, image: image, dir: path.Join("/app", relPath), envs: envs, verify: verify}
+	m.runEnv = m.dockerRun
+	return m
+}
+
+// run runs the verification in the named environments, or all of them, all at once.
+func (m *matrixRunner) run(ctx context.Context, names []string) (loop.MatrixRun, error) {
+	run := loop.MatrixRun{Command: m.verify}
+	var envs []MatrixEnv
+	for _, name := range names {
+		if !slices.ContainsFunc(m.envs, func(e MatrixEnv) bool { return e.Name == name }) {
+			return run, fmt.Errorf("unknown matrix environment %q", name)
+		}
+	}
+	for _, e := range m.envs {
+		if len(names) == 0 || slices.Contains(names, e.Name) {
+			envs = append(envs, e)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run.Results = make([]loop.MatrixResult, len(envs))
+	var wg sync.WaitGroup
+	for i, env := range envs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run.Results[i] = m.runEnv(ctx, env)
+		}()
+	}
+	wg.Wait()
+	return run, nil
+}
+
+// dockerRun runs the verification in a new container of env's image, on a
+// copy of the session container's /app, which need not be running.
+func (m *matrixRunner) dockerRun(ctx context.Context, env MatrixEnv) (res loop.MatrixResult) {
+	res = loop.MatrixResult{Env: env.Name, Image: cmp.Or(env.Image, m.image)}
+	start := time.Now()
+	defer func() { res.Duration = time.Since(start) }()
+
+	// The copy lands in /tmp/app: an image may have an /app of its own, but
+	// every image with a shell has a /tmp.
+	name := m.cntr + "-matrix-" + env.Name
+	args := []string{"create", "--name", name, "-w", path.Join("/tmp", m.dir), "--entrypoint", "sh"}
+	for _, kv := range env.Env {
+		args = append(args, "-e", kv)
+	}
+	args = append(args, res.Image, "-c", m.verify)
+	combinedOutput(ctx, "docker", "rm", "-f", name) // left behind by an interrupted run
+	if out, err := combinedOutput(ctx, "docker", args...); err != nil {
+		res.Error = fmt.Sprintf("docker create: %s", bytes.TrimSpace(out))
+		return res
+	}
+	defer combinedOutput(context.WithoutCancel(ctx), "docker", "rm", "-f", name)
+	if err := copyWorkingTree(ctx, m.cntr, name); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "docker", "start", "-a", name)
+	cmd.Stdout, cmd.Stderr = &out, &out
+	err := run(ctx, "docker start", cmd)
+	res.Output = tail(out.String(), matrixOutputTail)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		res.Passed = true
+	case errors.As(err, &exitErr):
+		res.ExitCode = exitErr.ExitCode()
+	default:
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// copyWorkingTree copies /app from the session's container to /tmp/app in
+// another, as the tar stream docker cp reads and writes.
+func copyWorkingTree(ctx context.Context, from, to string) error {
+	var srcErr, dstErr bytes.Buffer
+	src := exec.CommandContext(ctx, "docker", "cp", from+":/app", "-")
+	src.Stderr = &srcErr
+	dst := exec.CommandContext(ctx, "docker", "cp", "-", to+":/tmp")
+	dst.Stdout, dst.Stderr = &dstErr, &dstErr
+	pipe, err := src.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	dst.Stdin = pipe
+	if err := src.Start(); err != nil {
+		return fmt.Errorf("docker cp: %w", err)
+	}
+	if err := dst.Run(); err != nil {
+		src.Process.Kill()
+		src.Wait()
+		return fmt.Errorf("docker cp to %s: %s: %w", to, bytes.TrimSpace(dstErr.Bytes()), err)
+	}
+	if err := src.Wait(); err != nil {
+		return fmt.Errorf("docker cp from %s: %s: %w", from, bytes.TrimSpace(srcErr.Bytes()), err)
+	}
+	return nil
+}
+
+// tail returns the last n bytes of s, from the start of a line.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[len(s)-n:]
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return "…\n" + s
+}
+
+// report runs the verification in every environment once a one-shot run
+// ended, and reports each result. It fails unless they all passed.
+func (m *matrixRunner) report(ctx context.Context) error {
+	output.Printf("🧪", "verifying across the matrix: %s", m.verify)
+	run, err := m.run(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, res := range run.Results {
+		fields := map[string]any{"matrix_env": res.Env, "image": res.Image, "passed": res.Passed, "exit_code": res.ExitCode, "duration_seconds": res.Duration.Seconds()}
+		d := res.Duration.Round(time.Second)
+		switch {
+		case res.Passed:
+			output.Default().Emit(output.Success, "✅", fmt.Sprintf("%s (%s): passed in %s", res.Env, res.Image, d), fields)
+		case res.Error != "":
+			output.Default().Emit(output.Warning, "❌", fmt.Sprintf("%s (%s): could not run: %s", res.Env, res.Image, res.Error), fields)
+		default:
+			output.Default().Emit(output.Warning, "❌", fmt.Sprintf("%s (%s): failed with exit code %d after %s\n%s", res.Env, res.Image, res.ExitCode, d, strings.TrimRight(res.Output, "\n")), fields)
+		}
+	}
+	if failed := run.Failed(); len(failed) > 0 {
+		return fmt.Errorf("-matrix-verify failed in %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
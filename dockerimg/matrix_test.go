@@ -0,0 +1,96 @@
+package dockerimg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"sketch.dev/loop"
+)
+
+func TestParseMatrix(t *testing.T) {
+	envs, err := ParseMatrix([]string{"go1.22=golang:1.22", "race=,GOFLAGS=-race,CGO_ENABLED=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MatrixEnv{
+		{Name: "go1.22", Image: "golang:1.22"},
+		{Name: "race", Env: []string{"GOFLAGS=-race", "CGO_ENABLED=1"}},
+	}
+	if len(envs) != len(want) {
+		t.Fatalf("got %+v", envs)
+	}
+	for i := range want {
+		if envs[i].Name != want[i].Name || envs[i].Image != want[i].Image || !slices.Equal(envs[i].Env, want[i].Env) {
+			t.Errorf("env %d = %+v, want %+v", i, envs[i], want[i])
+		}
+	}
+	for _, bad := range [][]string{
+		{"golang:1.22"},
+		{"-x=golang:1.22"},
+		{"a b=golang:1.22"},
+		{"go=golang:1.22,GOFLAGS"},
+		{"go=golang:1.22", "go=golang:1.23"},
+	} {
+		if _, err := ParseMatrix(bad); err == nil {
+			t.Errorf("ParseMatrix(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestMatrixRunner(t *testing.T) {
+	envs, _ := ParseMatrix([]string{"old=golang:1.22", "new=golang:1.23", "race=,GOFLAGS=-race"})
+	m := newMatrixRunner("sketch-abc", "sketch-img", "cmd", envs, "go test ./...")
+	if m.dir != "/app/cmd" {
+		t.Errorf("dir = %q", m.dir)
+	}
+	m.runEnv = func(ctx context.Context, env MatrixEnv) loop.MatrixResult {
+		return loop.MatrixResult{Env: env.Name, Image: env.Image, Passed: env.Name != "old", ExitCode: 1, Output: "FAIL"}
+	}
+
+	srv := httptest.NewServer(&gitHTTP{pass: []byte("test-pass"), matrix: m})
+	defer srv.Close()
+	post := func(body string) (int, loop.MatrixRun) {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/matrix", strings.NewReader(body))
+		req.SetBasicAuth("sketch", "test-pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var run loop.MatrixRun
+		json.NewDecoder(resp.Body).Decode(&run)
+		return resp.StatusCode, run
+	}
+
+	code, run := post(`{}`)
+	if code != http.StatusOK || run.Command != "go test ./..." || len(run.Results) != 3 {
+		t.Fatalf("all environments: status %d, %+v", code, run)
+	}
+	if got := run.Failed(); !slices.Equal(got, []string{"old"}) {
+		t.Errorf("failed in %v, want old", got)
+	}
+	if code, run := post(`{"envs":["race"]}`); code != http.StatusOK || len(run.Results) != 1 || run.Results[0].Env != "race" {
+		t.Errorf("one environment: status %d, %+v", code, run)
+	}
+	if code, _ := post(`{"envs":["nope"]}`); code != http.StatusBadRequest {
+		t.Errorf("unknown environment: status %d", code)
+	}
+
+	if newMatrixRunner("sketch-abc", "sketch-img", ".", nil, "") != nil {
+		t.Error("a runner without a matrix")
+	}
+}
+
+func TestTail(t *testing.T) {
+	if got := tail("short", 10); got != "short" {
+		t.Errorf("tail of a short output = %q", got)
+	}
+	if got := tail("first line\nsecond\nthird\n", 10); got != "…\nthird\n" {
+		t.Errorf("tail = %q, want from the start of a line", got)
+	}
+}
@@ -0,0 +1,170 @@
+package dockerimg
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Stage is one step of launching a sketch container.
+type Stage string
+
+const (
+	StagePullBase Stage = "pull_base" // pulling the base image
+	StageBuild    Stage = "build"     // building the image with the repo layered in
+	StageCreate   Stage = "create"    // creating the container
+	StageStart    Stage = "start"     // starting the container
+	StageInit     Stage = "init"      // waiting for sketch in the container to initialize
+	StageReady    Stage = "ready"     // the session's web UI is up
+)
+
+// A ProgressEvent reports how far LaunchContainer has come.
+// Events are JSON-encoded as is for the web UI's splash screen.
+type ProgressEvent struct {
+	Stage   Stage     `json:"stage"`
+	Message string    `json:"message"`
+	Percent int       `json:"percent,omitempty"` // StagePullBase: share of the image's layers pulled
+	Step    int       `json:"step,omitempty"`    // StageBuild: the Dockerfile step being built...
+	Steps   int       `json:"steps,omitempty"`   // ...out of this many
+	URL     string    `json:"url,omitempty"`     // StageReady: where the web UI is served
+	Time    time.Time `json:"time"`
+}
+
+// progressReporter sends events on a ContainerConfig's Progress channel.
+// A nil *progressReporter discards them, as does one that has been closed:
+// the container's init runs in its own goroutine and can outlive LaunchContainer.
+type progressReporter struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	ch     chan<- ProgressEvent
+	closed bool
+}
+
+func newProgressReporter(ctx context.Context, ch chan<- ProgressEvent) *progressReporter {
+	if ch == nil {
+		return nil
+	}
+	return &progressReporter{ctx: ctx, ch: ch}
+}
+
+func (p *progressReporter) send(ev ProgressEvent) {
+	if p == nil {
+		return
+	}
+	ev.Time = time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.ch <- ev:
+	case <-p.ctx.Done():
+	}
+}
+
+// close closes the channel. It is safe to call more than once.
+func (p *progressReporter) close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.ch)
+	}
+}
+
+// pullProgress turns `docker pull` output into StagePullBase events.
+// Percent is the share of layers that are pulled or already present.
+type pullProgress struct {
+	p       *progressReporter
+	image   string
+	layers  map[string]bool // layer ID -> done
+	fetched bool            // whether any layer needs pulling at all
+	percent int
+}
+
+func (pp *pullProgress) line(s string) {
+	id, status, ok := strings.Cut(s, ": ")
+	if !ok || strings.ContainsAny(id, " /:") {
+		return // not a per-layer status, e.g. "latest: Pulling from library/alpine"
+	}
+	switch status = strings.TrimSpace(status); {
+	case status == "Pulling fs layer" || status == "Waiting":
+		if _, seen := pp.layers[id]; !seen {
+			pp.layers[id] = false
+		}
+		pp.fetched = true
+	case status == "Pull complete" || status == "Already exists":
+		pp.layers[id] = true
+	default:
+		return
+	}
+	if !pp.fetched {
+		return // layers already present would otherwise count as 100%
+	}
+	done := 0
+	for _, d := range pp.layers {
+		if d {
+			done++
+		}
+	}
+	if percent := 100 * done / len(pp.layers); percent != pp.percent {
+		pp.percent = percent
+		pp.p.send(ProgressEvent{Stage: StagePullBase, Message: "pulling base image " + pp.image, Percent: percent})
+	}
+}
+
+// buildStepRe matches the step counters of BuildKit ("#5 [2/7] RUN ...")
+// and the legacy builder ("Step 2/7 : RUN ...").
+var buildStepRe = regexp.MustCompile(`^(?:#\d+ \[\s*(\d+)/(\d+)\]|Step (\d+)/(\d+) :)`)
+
+// buildProgress turns `docker build` output into StageBuild events.
+// It reads both stdout and stderr, so line may be called concurrently.
+type buildProgress struct {
+	p    *progressReporter
+	mu   sync.Mutex
+	step int
+}
+
+func (bp *buildProgress) line(s string) {
+	m := buildStepRe.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	step, _ := strconv.Atoi(m[1] + m[3])
+	steps, _ := strconv.Atoi(m[2] + m[4])
+	if step <= bp.step {
+		return // BuildKit repeats a step's header when it finishes
+	}
+	bp.step = step
+	bp.p.send(ProgressEvent{Stage: StageBuild, Message: "building docker image", Step: step, Steps: steps})
+}
+
+// lineWriter is an io.Writer that calls fn for each line written to it.
+type lineWriter struct {
+	fn  func(string)
+	buf []byte
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexAny(w.buf, "\r\n")
+		if i < 0 {
+			return len(b), nil
+		}
+		if i > 0 {
+			w.fn(string(w.buf[:i]))
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
@@ -0,0 +1,93 @@
+package dockerimg
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// collect runs feed with a progressReporter and returns the events it sent.
+func collect(t *testing.T, feed func(p *progressReporter)) []ProgressEvent {
+	t.Helper()
+	ch := make(chan ProgressEvent)
+	p := newProgressReporter(context.Background(), ch)
+	go func() {
+		feed(p)
+		p.close()
+	}()
+	var events []ProgressEvent
+	for ev := range ch {
+		events = append(events, ev)
+	}
+	return events
+}
+
+func TestPullProgress(t *testing.T) {
+	out := `latest: Pulling from sketch/base
+a1: Already exists
+b2: Pulling fs layer
+c3: Pulling fs layer
+b2: Downloading  12.3MB/40MB
+b2: Verifying Checksum
+b2: Download complete
+b2: Pull complete
+c3: Pull complete
+Digest: sha256:0123
+Status: Downloaded newer image for sketch/base:latest
+`
+	events := collect(t, func(p *progressReporter) {
+		w := &lineWriter{fn: (&pullProgress{p: p, image: "sketch/base", layers: make(map[string]bool)}).line}
+		// Split writes mid-line, as a pipe might.
+		for chunk := range slices.Chunk([]byte(out), 7) {
+			w.Write(chunk)
+		}
+	})
+	var percents []int
+	for _, ev := range events {
+		percents = append(percents, ev.Percent)
+	}
+	if want := []int{50, 33, 66, 100}; !slices.Equal(percents, want) {
+		t.Errorf("percents = %v, want %v", percents, want)
+	}
+}
+
+func TestBuildProgress(t *testing.T) {
+	lines := []string{
+		"#1 [internal] load build definition from Dockerfile",
+		"#5 [1/4] FROM docker.io/sketch/base",
+		"#6 [2/4] COPY . /git-ref",
+		"#6 DONE 0.4s",
+		"#6 [2/4] COPY . /git-ref",
+		"#7 [ 3/4] RUN mkdir -p /go-module",
+		"Step 4/4 : CMD [\"/bin/sketch\"]",
+	}
+	events := collect(t, func(p *progressReporter) {
+		bp := &buildProgress{p: p}
+		for _, l := range lines {
+			bp.line(l)
+		}
+	})
+	var steps []string
+	for _, ev := range events {
+		steps = append(steps, fmt.Sprintf("%d/%d", ev.Step, ev.Steps))
+	}
+	if want := []string{"1/4", "2/4", "3/4", "4/4"}; !slices.Equal(steps, want) {
+		t.Errorf("steps = %v, want %v", steps, want)
+	}
+}
+
+func TestProgressReporterClosed(t *testing.T) {
+	ch := make(chan ProgressEvent, 1)
+	p := newProgressReporter(context.Background(), ch)
+	p.close()
+	p.close()
+	p.send(ProgressEvent{Stage: StageReady}) // must not panic
+	if _, ok := <-ch; ok {
+		t.Error("event sent after close")
+	}
+
+	var nilp *progressReporter
+	nilp.send(ProgressEvent{Stage: StageInit})
+	nilp.close()
+}
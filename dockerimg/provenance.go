@@ -0,0 +1,159 @@
+package dockerimg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/loop"
+)
+
+// baseCheckInterval is how long a check of the base image's registry stands
+// before the next GET /image asks the registry again.
+const baseCheckInterval = 15 * time.Minute
+
+// A RebuildError is what LaunchContainer returns when the session in the
+// container asked to move to a container built from the latest base image.
+// By then the session record has been copied to the host, with the working
+// tree's snapshot as its commit; launching again from it completes the move.
+type RebuildError struct {
+	Addr string // where the old container's web UI was, for the new one to take its place
+}
+
+func (e *RebuildError) Error() string { return "session ended to move to a rebuilt container" }
+
+// imageProvenance describes the image LaunchContainer runs the session in.
+// For a base pulled from a registry, the digest is the one it was pulled at.
+func imageProvenance(ctx context.Context, baseImage, imgName string) loop.ImageProvenance {
+	p := loop.ImageProvenance{BaseImage: defaultBaseImage(baseImage), Image: imgName}
+	if id, err := getDockerImageID(ctx, p.BaseImage); err == nil {
+		if digest := baseImageDigest(ctx, p.BaseImage, id); digest != id {
+			p.BaseDigest = digest
+		}
+	}
+	return p
+}
+
+// defaultBaseImage is the base image findOrBuildDockerImage builds on.
+func defaultBaseImage(baseImage string) string {
+	if baseImage != "" {
+		return baseImage
+	}
+	return fmt.Sprintf("%s:%s", dockerImgName, dockerfileBaseHash())
+}
+
+// An imageWatcher answers the container's GET /image and POST /image/rebuild:
+// whether the registry has a newer base than the one the container was built
+// from, and pulling it for the relaunch that follows the session's end.
+type imageWatcher struct {
+	prov loop.ImageProvenance
+	// why the container can't be rebuilt in place, if it can't
+	noRebuild string
+	// the docker commands, replaced in tests
+	latestDigest func(ctx context.Context, ref string) (string, error)
+	pull         func(ctx context.Context, ref string) error
+
+	mu       sync.Mutex
+	status   loop.ImageStatus // the last check, if CheckedAt is set
+	rebuild  bool             // set once the new base is pulled
+	building bool             // set while it is being pulled
+}
+
+func newImageWatcher(prov loop.ImageProvenance, noRebuild string) *imageWatcher {
+	return &imageWatcher{
+		prov:         prov,
+		noRebuild:    noRebuild,
+		latestDigest: registryDigest,
+		pull: func(ctx context.Context, ref string) error {
+			if out, err := combinedOutput(ctx, "docker", "pull", ref); err != nil {
+				return fmt.Errorf("docker pull %s: %s: %w", ref, strings.TrimSpace(string(out)), err)
+			}
+			return nil
+		},
+	}
+}
+
+// check reports whether the base has a newer digest in its registry,
+// asking the registry at most every baseCheckInterval unless fresh is set.
+func (iw *imageWatcher) check(ctx context.Context, fresh bool) loop.ImageStatus {
+	iw.mu.Lock()
+	st := iw.status
+	iw.mu.Unlock()
+	if !fresh && time.Since(st.CheckedAt) < baseCheckInterval {
+		return st
+	}
+
+	st = loop.ImageStatus{BaseImage: iw.prov.BaseImage, BaseDigest: iw.prov.BaseDigest, CheckedAt: time.Now()}
+	if iw.prov.BaseDigest == "" {
+		st.Error = "the base image wasn't pulled from a registry"
+	} else if latest, err := iw.latestDigest(ctx, iw.prov.BaseImage); err != nil {
+		st.Error = err.Error()
+	} else {
+		st.LatestDigest = latest
+		st.UpdateAvailable = latest != iw.prov.BaseDigest
+	}
+	iw.mu.Lock()
+	iw.status = st
+	iw.mu.Unlock()
+	return st
+}
+
+// prepareRebuild pulls the latest base, so that launching again builds on it.
+func (iw *imageWatcher) prepareRebuild(ctx context.Context) error {
+	if iw.noRebuild != "" {
+		return errors.New(iw.noRebuild)
+	}
+	iw.mu.Lock()
+	if iw.building || iw.rebuild {
+		iw.mu.Unlock()
+		return errors.New("the container is already being rebuilt")
+	}
+	iw.building = true
+	iw.mu.Unlock()
+	defer func() {
+		iw.mu.Lock()
+		iw.building = false
+		iw.mu.Unlock()
+	}()
+
+	st := iw.check(ctx, true)
+	if st.Error != "" {
+		return fmt.Errorf("can't check for a newer base image: %s", st.Error)
+	}
+	if !st.UpdateAvailable {
+		return errors.New("the container already runs on the latest base image")
+	}
+	if err := iw.pull(ctx, iw.prov.BaseImage); err != nil {
+		return err
+	}
+	iw.mu.Lock()
+	iw.rebuild = true
+	iw.mu.Unlock()
+	return nil
+}
+
+// rebuildRequested reports whether the session is to continue in a rebuilt container.
+func (iw *imageWatcher) rebuildRequested() bool {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	return iw.rebuild
+}
+
+// registryDigest returns the digest ref has in its registry right now, without pulling it.
+func registryDigest(ctx context.Context, ref string) (string, error) {
+	out, err := combinedOutput(ctx, "docker", "buildx", "imagetools", "inspect", "--format", "{{json .Manifest}}", ref)
+	if err != nil {
+		return "", fmt.Errorf("docker buildx imagetools inspect %s: %s", ref, strings.TrimSpace(string(out)))
+	}
+	var manifest struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal(out, &manifest); err != nil || manifest.Digest == "" {
+		return "", fmt.Errorf("docker buildx imagetools inspect %s: no digest in %q", ref, strings.TrimSpace(string(out)))
+	}
+	return manifest.Digest, nil
+}
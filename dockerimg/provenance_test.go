@@ -0,0 +1,89 @@
+package dockerimg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sketch.dev/loop"
+)
+
+func TestImageWatcher(t *testing.T) {
+	ctx := context.Background()
+	latest, checks, pulls := "sha256:old", 0, 0
+	iw := newImageWatcher(loop.ImageProvenance{BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:old", Image: "sketch-abc"}, "")
+	iw.latestDigest = func(ctx context.Context, ref string) (string, error) {
+		checks++
+		return latest, nil
+	}
+	iw.pull = func(ctx context.Context, ref string) error {
+		pulls++
+		return nil
+	}
+
+	if st := iw.check(ctx, false); st.UpdateAvailable || st.LatestDigest != "sha256:old" {
+		t.Errorf("unchanged base: %+v", st)
+	}
+	if err := iw.prepareRebuild(ctx); err == nil || pulls != 0 {
+		t.Errorf("rebuilt on the latest base: %v, %d pulls", err, pulls)
+	}
+
+	latest = "sha256:new"
+	if st := iw.check(ctx, false); st.UpdateAvailable || checks != 2 {
+		t.Errorf("the check wasn't cached: %+v, %d checks", st, checks)
+	}
+	if err := iw.prepareRebuild(ctx); err != nil || pulls != 1 || !iw.rebuildRequested() {
+		t.Fatalf("prepareRebuild: %v, %d pulls", err, pulls)
+	}
+	if st := iw.check(ctx, false); !st.UpdateAvailable || st.LatestDigest != "sha256:new" {
+		t.Errorf("newer base: %+v", st)
+	}
+	if err := iw.prepareRebuild(ctx); err == nil {
+		t.Error("rebuilt twice")
+	}
+
+	local := newImageWatcher(loop.ImageProvenance{BaseImage: "my-base", Image: "sketch-def"}, "")
+	local.latestDigest = func(ctx context.Context, ref string) (string, error) { return "", errors.New("not in a registry") }
+	if st := local.check(ctx, false); st.Error == "" || st.UpdateAvailable {
+		t.Errorf("locally built base: %+v", st)
+	}
+	if err := newImageWatcher(iw.prov, "can't").prepareRebuild(ctx); err == nil || err.Error() != "can't" {
+		t.Errorf("prepareRebuild with noRebuild: %v", err)
+	}
+}
+
+func TestGitHTTPImage(t *testing.T) {
+	iw := newImageWatcher(loop.ImageProvenance{BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:old", Image: "sketch-abc"}, "")
+	iw.latestDigest = func(ctx context.Context, ref string) (string, error) { return "sha256:new", nil }
+	iw.pull = func(ctx context.Context, ref string) error { return nil }
+	srv := httptest.NewServer(&gitHTTP{pass: []byte("test-pass"), image: iw})
+	defer srv.Close()
+
+	do := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		req.SetBasicAuth("sketch", "test-pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	resp := do(http.MethodGet, "/image")
+	var st loop.ImageStatus
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil || !st.UpdateAvailable || st.BaseImage != "ghcr.io/example/base:1" {
+		t.Errorf("GET /image: %v %+v", err, st)
+	}
+	if resp := do(http.MethodGet, "/image/rebuild"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /image/rebuild: status %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/image/rebuild"); resp.StatusCode != http.StatusOK || !iw.rebuildRequested() {
+		t.Errorf("POST /image/rebuild: status %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/image/rebuild"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("second POST /image/rebuild: status %d", resp.StatusCode)
+	}
+}
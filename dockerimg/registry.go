@@ -0,0 +1,131 @@
+package dockerimg
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"sketch.dev/output"
+)
+
+// imageRegistry shares layered images through a registry, so that an image
+// built by one teammate can be pulled by the others instead of rebuilt.
+// Registry credentials come from the user's docker configuration
+// (docker login, credential helpers), as for any other docker pull or push.
+type imageRegistry struct {
+	repo string // repository to tag shared images in, e.g. registry.example.com/team/sketch
+	push bool   // whether to push images built locally
+}
+
+// resolveImageRegistry returns the registry to share layered images through.
+// An empty spec falls back to the sketch.imageRegistry git config setting;
+// "off" disables sharing. It returns nil when sharing is disabled.
+func resolveImageRegistry(ctx context.Context, gitRoot, spec string, push bool) (*imageRegistry, error) {
+	if spec == "" {
+		cmd := exec.CommandContext(ctx, "git", "config", "--get", "sketch.imageRegistry")
+		cmd.Dir = gitRoot
+		out, _ := cmd.Output()
+		spec = strings.TrimSpace(string(out))
+	}
+	if spec == "" || spec == "off" {
+		return nil, nil
+	}
+	if err := ValidateImageRegistry(spec); err != nil {
+		return nil, err
+	}
+	return &imageRegistry{repo: spec, push: push}, nil
+}
+
+// ValidateImageRegistry checks that repo names an image repository without a tag or digest,
+// such as "registry.example.com:5000/team/sketch". "off" is also valid.
+func ValidateImageRegistry(repo string) error {
+	if repo == "" || repo == "off" {
+		return nil
+	}
+	if strings.Contains(repo, "://") {
+		return fmt.Errorf("image registry %q: use a repository name such as registry.example.com/team/sketch, not a URL", repo)
+	}
+	last := repo[strings.LastIndex(repo, "/")+1:]
+	if !strings.Contains(repo, "/") || strings.ContainsAny(last, ":@") {
+		return fmt.Errorf("image registry %q: want a repository such as registry.example.com/team/sketch, without a tag", repo)
+	}
+	if repo != strings.ToLower(repo) {
+		return fmt.Errorf("image registry %q: repository names must be lowercase", repo)
+	}
+	return nil
+}
+
+// ref returns the shared tag for the layered image with the given key.
+func (r *imageRegistry) ref(key string) string {
+	return r.repo + ":" + key
+}
+
+// sharedCacheKey is the cache key for a layered image in a shared registry.
+// The local cache key includes the repo's path, which differs between machines,
+// so the shared key identifies the repo by its origin instead.
+func sharedCacheKey(ctx context.Context, baseImageID, gitRoot string) string {
+	repoID := normalizeRepoURL(getOriginalGitOrigin(ctx, gitRoot))
+	if repoID == "" {
+		repoID = gitRoot
+	}
+	return createCacheKey(baseImageID, repoID)
+}
+
+// normalizeRepoURL reduces the forms of a git remote URL (https, ssh, scp-like,
+// with or without credentials or a .git suffix) to host/path.
+func normalizeRepoURL(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	s := origin
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = u.Hostname() + "/" + strings.TrimPrefix(u.Path, "/")
+	} else if i := strings.Index(s, ":"); i > 0 && !strings.Contains(s[:i], "/") {
+		// scp-like: git@github.com:owner/repo.git
+		host := s[:i]
+		host = host[strings.LastIndex(host, "@")+1:]
+		s = host + "/" + strings.TrimPrefix(s[i+1:], "/")
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/"), ".git")
+	return strings.ToLower(s)
+}
+
+// pull fetches the shared image for key and tags it as imgName.
+// It reports whether the image is now available locally.
+func (r *imageRegistry) pull(ctx context.Context, key, imgName string, verbose bool) bool {
+	ref := r.ref(key)
+	output.Printf("🐋", "looking for a shared image at %s...", ref)
+	if out, err := combinedOutput(ctx, "docker", "pull", ref); err != nil {
+		if verbose {
+			output.Printf("", "no shared image: %s", strings.TrimSpace(string(out)))
+		}
+		return false
+	}
+	if out, err := combinedOutput(ctx, "docker", "tag", ref, imgName); err != nil {
+		output.Warnf("docker tag %s %s failed: %s", ref, imgName, out)
+		return false
+	}
+	output.Successf("using shared image %s", ref)
+	return true
+}
+
+// publish pushes a locally built imgName to the registry under key.
+// Failing to push only costs teammates a build, so errors are reported but not returned.
+func (r *imageRegistry) publish(ctx context.Context, key, imgName string) {
+	if !r.push {
+		return
+	}
+	ref := r.ref(key)
+	if out, err := combinedOutput(ctx, "docker", "tag", imgName, ref); err != nil {
+		output.Warnf("docker tag %s %s failed: %s", imgName, ref, out)
+		return
+	}
+	output.Printf("🐋", "sharing image as %s...", ref)
+	if out, err := combinedOutput(ctx, "docker", "push", ref); err != nil {
+		output.Warnf("docker push %s failed (use -image-registry-push=false to stop sharing): %s", ref, strings.TrimSpace(string(out)))
+		return
+	}
+	output.Successf("shared %s", ref)
+}
@@ -0,0 +1,332 @@
+package dockerimg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files of a workspace snapshot, under <url>/<session>/<taken>/. The session's
+// latest object names the most recent complete one.
+const (
+	snapshotBundle   = "workspace.bundle"
+	snapshotManifest = "manifest.json"
+	snapshotLatest   = "latest"
+	snapshotRef      = "refs/sketch/snapshot"
+	snapshotStamp    = "20060102T150405Z"
+)
+
+// A SnapshotManifest describes a workspace snapshot. The bundle has every ref
+// of the container's repository, and the Worktree commit, whose parent is Head
+// and whose tree is the working tree with changes and untracked files that git
+// doesn't ignore.
+type SnapshotManifest struct {
+	SessionID string    `json:"session_id"`
+	Taken     time.Time `json:"taken"`
+	Origin    string    `json:"origin,omitempty"` // the URL of the repository's origin, if it has one
+	Branch    string    `json:"branch,omitempty"` // empty if HEAD was detached
+	Head      string    `json:"head"`
+	Worktree  string    `json:"worktree"`
+	Tree      string    `json:"tree"`              // of Worktree
+	Untracked []string  `json:"untracked"`         // files Worktree has that git doesn't track
+	Ignored   []string  `json:"ignored,omitempty"` // ignored paths, such as node_modules/, left out
+}
+
+// ValidateSnapshotURL reports whether url names a place to keep snapshots:
+// s3://bucket[/prefix] or gs://bucket[/prefix].
+func ValidateSnapshotURL(url string) error {
+	_, err := newObjectStore(url)
+	return err
+}
+
+// An objectStore copies files to and from a bucket with the provider's CLI,
+// which brings its own credentials: aws for s3:// URLs, gcloud for gs://.
+type objectStore struct {
+	url string // without a trailing slash
+	cli []string
+	run func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+func newObjectStore(url string) (*objectStore, error) {
+	scheme, rest, ok := strings.Cut(url, "://")
+	bucket, _, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return nil, fmt.Errorf("%q: want s3://bucket/prefix or gs://bucket/prefix", url)
+	}
+	o := &objectStore{url: strings.TrimRight(url, "/"), run: combinedOutput}
+	switch scheme {
+	case "s3":
+		o.cli = []string{"aws", "s3", "cp", "--only-show-errors"}
+	case "gs":
+		o.cli = []string{"gcloud", "storage", "cp"}
+	default:
+		return nil, fmt.Errorf("%q: only s3:// and gs:// buckets are supported", url)
+	}
+	return o, nil
+}
+
+func (o *objectStore) cp(ctx context.Context, src, dst string) error {
+	args := append(append([]string{}, o.cli[1:]...), src, dst)
+	if out, err := o.run(ctx, o.cli[0], args...); err != nil {
+		return fmt.Errorf("%s %s: %s: %w", o.cli[0], o.cli[1], bytes.TrimSpace(out), err)
+	}
+	return nil
+}
+
+func (o *objectStore) put(ctx context.Context, local, key string) error {
+	return o.cp(ctx, local, o.url+"/"+key)
+}
+
+func (o *objectStore) get(ctx context.Context, key, local string) error {
+	return o.cp(ctx, o.url+"/"+key, local)
+}
+
+// A snapshotter periodically copies the container's workspace to an object
+// store, so that a session outlives the machine it runs on.
+type snapshotter struct {
+	cntrName  string
+	sessionID string
+	origin    string
+	store     *objectStore
+	every     time.Duration
+	workDir   string // the repository in the container
+	tmpDir    string // for the bundle, in the container
+	docker    func(ctx context.Context, args ...string) ([]byte, error)
+	now       func() time.Time
+
+	last string // Head and Tree of the last snapshot taken
+}
+
+func newSnapshotter(cntrName, sessionID, origin, url string, every time.Duration) (*snapshotter, error) {
+	store, err := newObjectStore(url)
+	if err != nil {
+		return nil, err
+	}
+	// Each session's snapshots live in a folder of their own.
+	store.url += "/" + sessionID
+	return &snapshotter{
+		cntrName:  cntrName,
+		sessionID: sessionID,
+		origin:    origin,
+		store:     store,
+		every:     every,
+		workDir:   "/app",
+		tmpDir:    "/tmp",
+		docker: func(ctx context.Context, args ...string) ([]byte, error) {
+			return combinedOutput(ctx, "docker", args...)
+		},
+		now: time.Now,
+	}, nil
+}
+
+// watch takes a snapshot every s.every until ctx is done, and a last one then.
+func (s *snapshotter) watch(ctx context.Context) {
+	tick := time.NewTicker(s.every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// The container outlives ctx until it is cleaned up.
+			fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Minute)
+			if _, err := s.take(fctx); err != nil {
+				slog.WarnContext(ctx, "final workspace snapshot", "error", err)
+			}
+			cancel()
+			return
+		case <-tick.C:
+			if _, err := s.take(ctx); err != nil {
+				// A paused container can't be snapshotted, and has nothing new anyway.
+				slog.WarnContext(ctx, "workspace snapshot", "error", err)
+			}
+		}
+	}
+}
+
+// git runs git in the container's repository.
+func (s *snapshotter) git(ctx context.Context, env []string, args ...string) (string, error) {
+	cmd := []string{"exec"}
+	for _, e := range env {
+		cmd = append(cmd, "-e", e)
+	}
+	cmd = append(cmd, s.cntrName, "git", "-C", s.workDir)
+	out, err := s.docker(ctx, append(cmd, args...)...)
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %w", args[0], bytes.TrimSpace(out), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// take uploads a snapshot of the workspace, unless it hasn't changed since the
+// last one. It returns the snapshot's manifest, or nil if it took none.
+func (s *snapshotter) take(ctx context.Context) (*SnapshotManifest, error) {
+	head, err := s.git(ctx, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	// A scratch index, starting from HEAD, picks up the working tree without
+	// disturbing what the agent has staged.
+	index := []string{"GIT_INDEX_FILE=" + path.Join(s.tmpDir, "sketch-snapshot.index")}
+	defer s.docker(context.WithoutCancel(ctx), "exec", s.cntrName, "rm", "-f", path.Join(s.tmpDir, "sketch-snapshot.index"), path.Join(s.tmpDir, snapshotBundle))
+	if _, err := s.git(ctx, index, "read-tree", head); err != nil {
+		return nil, err
+	}
+	if _, err := s.git(ctx, index, "add", "--all"); err != nil {
+		return nil, err
+	}
+	tree, err := s.git(ctx, index, "write-tree")
+	if err != nil {
+		return nil, err
+	}
+	if head+" "+tree == s.last {
+		return nil, nil
+	}
+
+	m := &SnapshotManifest{SessionID: s.sessionID, Taken: s.now().UTC().Truncate(time.Second), Origin: s.origin, Head: head, Tree: tree}
+	m.Branch, _ = s.git(ctx, nil, "symbolic-ref", "--quiet", "--short", "HEAD")
+	if m.Worktree, err = s.git(ctx, nil, "-c", "user.name=sketch", "-c", "user.email=snapshot@sketch.dev", "commit-tree", tree, "-p", head, "-m", "sketch workspace snapshot"); err != nil {
+		return nil, err
+	}
+	if _, err := s.git(ctx, nil, "update-ref", snapshotRef, m.Worktree); err != nil {
+		return nil, err
+	}
+	untracked, err := s.git(ctx, nil, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	m.Untracked = splitNUL(untracked)
+	ignored, err := s.git(ctx, nil, "ls-files", "-z", "--others", "--ignored", "--exclude-standard", "--directory")
+	if err != nil {
+		return nil, err
+	}
+	m.Ignored = splitNUL(ignored)
+	if _, err := s.git(ctx, nil, "bundle", "create", "-q", path.Join(s.tmpDir, snapshotBundle), "--all"); err != nil {
+		return nil, err
+	}
+
+	local, err := os.MkdirTemp("", "sketch-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(local)
+	if out, err := s.docker(ctx, "cp", s.cntrName+":"+path.Join(s.tmpDir, snapshotBundle), local); err != nil {
+		return nil, fmt.Errorf("docker cp: %s: %w", bytes.TrimSpace(out), err)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(local, snapshotManifest), data, 0o644); err != nil {
+		return nil, err
+	}
+	stamp := m.Taken.Format(snapshotStamp)
+	if err := os.WriteFile(filepath.Join(local, snapshotLatest), []byte(stamp+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	// The manifest goes after the bundle, and latest last, so that neither
+	// names an incomplete snapshot.
+	for _, name := range []string{snapshotBundle, snapshotManifest} {
+		if err := s.store.put(ctx, filepath.Join(local, name), stamp+"/"+name); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.store.put(ctx, filepath.Join(local, snapshotLatest), snapshotLatest); err != nil {
+		return nil, err
+	}
+	s.last = head + " " + tree
+	slog.InfoContext(ctx, "took workspace snapshot", "url", s.store.url+"/"+stamp, "head", head, "untracked", len(m.Untracked))
+	return m, nil
+}
+
+func splitNUL(s string) []string {
+	var out []string
+	for f := range strings.SplitSeq(s, "\x00") {
+		if f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// RestoreSnapshot recreates in dir, which mustn't exist or must be empty, the
+// workspace of the session snapshotted to url (such as s3://bucket/prefix/SESSION):
+// its refs, HEAD, and working tree, with changes and untracked files uncommitted;
+// what was staged comes back unstaged.
+// It restores the snapshot taken at at, in the form of the folders under url,
+// or the latest one if at is empty.
+func RestoreSnapshot(ctx context.Context, url, at, dir string) (SnapshotManifest, error) {
+	store, err := newObjectStore(url)
+	if err != nil {
+		return SnapshotManifest{}, err
+	}
+	return restoreSnapshot(ctx, store, at, dir)
+}
+
+func restoreSnapshot(ctx context.Context, store *objectStore, at, dir string) (SnapshotManifest, error) {
+	var m SnapshotManifest
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return m, fmt.Errorf("%s isn't empty", dir)
+	}
+	local, err := os.MkdirTemp("", "sketch-restore-")
+	if err != nil {
+		return m, err
+	}
+	defer os.RemoveAll(local)
+	if at == "" {
+		if err := store.get(ctx, snapshotLatest, filepath.Join(local, snapshotLatest)); err != nil {
+			return m, fmt.Errorf("finding the latest snapshot: %w", err)
+		}
+		data, err := os.ReadFile(filepath.Join(local, snapshotLatest))
+		if err != nil {
+			return m, err
+		}
+		at = strings.TrimSpace(string(data))
+	}
+	for _, name := range []string{snapshotManifest, snapshotBundle} {
+		if err := store.get(ctx, at+"/"+name, filepath.Join(local, name)); err != nil {
+			return m, err
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(local, snapshotManifest))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("reading the snapshot's manifest: %w", err)
+	}
+
+	bundle := filepath.Join(local, snapshotBundle)
+	steps := [][]string{
+		{"init", "-q"},
+		{"fetch", "-q", "--update-head-ok", bundle, "+refs/*:refs/*"},
+	}
+	if m.Branch != "" {
+		steps = append(steps, []string{"symbolic-ref", "HEAD", "refs/heads/" + m.Branch})
+	} else {
+		steps = append(steps, []string{"update-ref", "--no-deref", "HEAD", m.Head})
+	}
+	// Check out the working tree, then put the index back at HEAD, leaving
+	// what wasn't committed uncommitted.
+	steps = append(steps, []string{"checkout", "-q", m.Worktree, "--", "."}, []string{"reset", "-q"})
+	if m.Origin != "" {
+		steps = append(steps, []string{"remote", "add", "origin", m.Origin})
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return m, err
+	}
+	for _, args := range steps {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return m, fmt.Errorf("git %s: %s: %w", args[0], bytes.TrimSpace(out), err)
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,162 @@
+package dockerimg
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateSnapshotURL(t *testing.T) {
+	for url, ok := range map[string]bool{
+		"s3://bucket":             true,
+		"gs://bucket/some/prefix": true,
+		"s3://":                   false,
+		"bucket/prefix":           false,
+		"https://example.com/x":   false,
+	} {
+		if err := ValidateSnapshotURL(url); (err == nil) != ok {
+			t.Errorf("ValidateSnapshotURL(%q) = %v, want ok %v", url, err, ok)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo := t.TempDir()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s: %v", args[0], out, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(dir, name, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git(repo, "init", "-q", "-b", "work")
+	write(repo, ".gitignore", "node_modules/\n")
+	write(repo, "main.go", "package main\n")
+	write(repo, "gone.txt", "deleted later\n")
+	git(repo, "add", ".")
+	git(repo, "commit", "-q", "-m", "initial")
+	write(repo, "main.go", "package main\n\nfunc main() {}\n")
+	write(repo, "staged.txt", "staged\n")
+	git(repo, "add", "staged.txt")
+	os.Remove(filepath.Join(repo, "gone.txt"))
+	write(repo, "notes/new.txt", "untracked\n")
+	write(repo, "node_modules/dep/index.js", "ignored\n")
+
+	// The container is the local repository, and the bucket a local directory.
+	bucket := t.TempDir()
+	box := t.TempDir()
+	s, err := newSnapshotter("sketch-test", "s1", "https://example.com/repo.git", "s3://bucket/snaps", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.workDir, s.tmpDir = repo, box
+	s.now = func() time.Time { return time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC) }
+	s.docker = func(ctx context.Context, args ...string) ([]byte, error) {
+		switch args[0] {
+		case "exec":
+			var env []string
+			args = args[1:]
+			for args[0] == "-e" {
+				env, args = append(env, args[1]), args[2:]
+			}
+			cmd := exec.Command(args[1], args[2:]...)
+			cmd.Env = append(os.Environ(), env...)
+			return cmd.CombinedOutput()
+		case "cp":
+			return exec.Command("cp", strings.TrimPrefix(args[1], "sketch-test:"), args[2]).CombinedOutput()
+		}
+		t.Fatalf("unexpected docker %q", args)
+		return nil, nil
+	}
+	s.store.run = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if name != "aws" {
+			t.Fatalf("ran %s, want aws", name)
+		}
+		src, dst := args[len(args)-2], args[len(args)-1]
+		local := func(p string) string {
+			if rest, ok := strings.CutPrefix(p, "s3://bucket/"); ok {
+				return filepath.Join(bucket, rest)
+			}
+			return p
+		}
+		os.MkdirAll(filepath.Dir(local(dst)), 0o755)
+		return exec.Command("cp", local(src), local(dst)).CombinedOutput()
+	}
+
+	m, err := s.take(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil {
+		t.Fatal("took no snapshot")
+	}
+	if m.Branch != "work" || m.Head != git(repo, "rev-parse", "HEAD") {
+		t.Errorf("snapshot of %s at %s, want work at HEAD", m.Branch, m.Head)
+	}
+	if !slices.Equal(m.Untracked, []string{"notes/new.txt"}) || !slices.Equal(m.Ignored, []string{"node_modules/"}) {
+		t.Errorf("untracked %q, ignored %q", m.Untracked, m.Ignored)
+	}
+	if status := git(repo, "status", "--porcelain"); !strings.Contains(status, "A  staged.txt") {
+		t.Errorf("the snapshot disturbed the index:\n%s", status)
+	}
+	if m, err := s.take(ctx); err != nil || m != nil {
+		t.Errorf("second snapshot of an unchanged workspace = %v, %v; want none", m, err)
+	}
+	if entries, _ := os.ReadDir(box); len(entries) > 0 {
+		t.Errorf("left %d file(s) in the container's temporary directory", len(entries))
+	}
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	store, _ := newObjectStore("s3://bucket/snaps/s1")
+	store.run = s.store.run
+	restored, err := restoreSnapshot(ctx, store, "", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Worktree != m.Worktree || restored.Head != git(repo, "rev-parse", "HEAD") {
+		t.Errorf("restored %+v", restored)
+	}
+	if got := git(dir, "symbolic-ref", "--short", "HEAD"); got != "work" {
+		t.Errorf("restored HEAD is %s, want work", got)
+	}
+	if got := git(dir, "remote", "get-url", "origin"); got != "https://example.com/repo.git" {
+		t.Errorf("restored origin is %s", got)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "main.go"))
+	if !strings.Contains(string(data), "func main") {
+		t.Errorf("restored main.go without its change: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "node_modules")); err == nil {
+		t.Errorf("restored ignored files")
+	}
+	// What was staged but not committed comes back untracked.
+	want := []string{"D gone.txt", "M main.go", "?? notes/", "?? staged.txt"}
+	var got []string
+	for line := range strings.Lines(git(dir, "status", "--porcelain")) {
+		got = append(got, strings.Join(strings.Fields(line), " "))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("restored status = %q, want %q", got, want)
+	}
+
+	if _, err := restoreSnapshot(ctx, store, "", dir); err == nil {
+		t.Errorf("restored into a directory that isn't empty")
+	}
+}
@@ -0,0 +1,213 @@
+package dockerimg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	"sketch.dev/loop/server"
+)
+
+// An idleSuspender pauses the container once its agent has been idle for
+// limit, and unpauses it when the user next interacts with it: through the
+// web UI, which it proxies, or the terminal, whose input it watches.
+// SSH connections go straight to the container and don't resume it.
+type idleSuspender struct {
+	cntrName  string
+	innieURL  string // the container's sketch server, reached directly so that checking on it isn't interacting
+	hostToken string // for the container's server, if it requires signing in
+	limit     time.Duration
+	docker    func(ctx context.Context, args ...string) error
+	now       func() time.Time
+
+	mu           sync.Mutex
+	pausedAt     time.Time // zero while the container runs
+	lastInteract time.Time
+}
+
+func newIdleSuspender(cntrName, innieAddr string, limit time.Duration) *idleSuspender {
+	return &idleSuspender{
+		cntrName: cntrName,
+		innieURL: "http://" + innieAddr,
+		limit:    limit,
+		docker: func(ctx context.Context, args ...string) error {
+			if out, err := combinedOutput(ctx, "docker", args...); err != nil {
+				return fmt.Errorf("docker %s: %s: %w", args[0], bytes.TrimSpace(out), err)
+			}
+			return nil
+		},
+		now:          time.Now,
+		lastInteract: time.Now(),
+	}
+}
+
+// watch checks whether the agent is idle until ctx is done.
+func (s *idleSuspender) watch(ctx context.Context) {
+	tick := time.NewTicker(min(s.limit/4, time.Minute))
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			if err := s.check(ctx); err != nil {
+				slog.DebugContext(ctx, "idle check", "error", err)
+			}
+		}
+	}
+}
+
+// check pauses the container if neither the agent nor the user has done anything for s.limit.
+func (s *idleSuspender) check(ctx context.Context) error {
+	s.mu.Lock()
+	paused := !s.pausedAt.IsZero()
+	s.mu.Unlock()
+	if paused {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.innieURL+"/state", nil)
+	if err != nil {
+		return err
+	}
+	if s.hostToken != "" {
+		req.Header.Set(server.HostTokenHeader, s.hostToken)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	var st server.State
+	if err := json.NewDecoder(res.Body).Decode(&st); err != nil {
+		return fmt.Errorf("decoding state: %w", err)
+	}
+	now := s.now()
+	if st.OutstandingLLMCalls > 0 || len(st.OutstandingToolCalls) > 0 || st.LastActivity == nil || now.Sub(*st.LastActivity) < s.limit {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.pausedAt.IsZero() || now.Sub(s.lastInteract) < s.limit {
+		return nil
+	}
+	if err := s.docker(ctx, "pause", s.cntrName); err != nil {
+		return err
+	}
+	s.pausedAt = now
+	slog.InfoContext(ctx, "suspended idle container", "container", s.cntrName, "idle_since", *st.LastActivity)
+	return nil
+}
+
+// interact records that the user did something, resuming the container if it is suspended.
+func (s *idleSuspender) interact(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastInteract = s.now()
+	if s.pausedAt.IsZero() {
+		return
+	}
+	if err := s.docker(ctx, "unpause", s.cntrName); err != nil {
+		slog.WarnContext(ctx, "resuming suspended container", "error", err)
+		return
+	}
+	body, _ := json.Marshal(server.ResumedRequest{SuspendedAt: s.pausedAt})
+	s.pausedAt = time.Time{}
+	go func() {
+		req, err := http.NewRequest("POST", s.innieURL+"/resumed", bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.hostToken != "" {
+			req.Header.Set(server.HostTokenHeader, s.hostToken)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			slog.DebugContext(ctx, "reporting resume", "error", err)
+			return
+		}
+		res.Body.Close()
+	}()
+}
+
+// serveProxy serves a proxy to the container's web server on ln. Every request
+// but the web UI's event stream, which reconnects on its own, counts as interacting.
+func (s *idleSuspender) serveProxy(ctx context.Context, ln net.Listener) error {
+	target, err := url.Parse(s.innieURL)
+	if err != nil {
+		return err
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = -1 // for the event stream
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/stream" {
+			s.interact(r.Context())
+		}
+		proxy.ServeHTTP(w, r)
+	})}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// attachStdin feeds the terminal's input to cmd, a docker attach, so that typing
+// counts as interacting. Docker only puts a terminal into raw mode when it has it
+// as stdin, so attachStdin does that itself; restore undoes it.
+func (s *idleSuspender) attachStdin(ctx context.Context, cmd *exec.Cmd) (restore func(), err error) {
+	cmd.Stdin = nil
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	restore = func() {}
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		st, err := term.MakeRaw(fd)
+		if err != nil {
+			return nil, fmt.Errorf("terminal raw mode: %w", err)
+		}
+		restore = func() { term.Restore(fd, st) }
+	}
+	// Not cmd.Stdin: Wait would wait for the copy, which only ends with the next keystroke.
+	go io.Copy(w, interactReader{r: os.Stdin, interact: func() { s.interact(ctx) }})
+	return restore, nil
+}
+
+type interactReader struct {
+	r        io.Reader
+	interact func()
+}
+
+func (r interactReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.interact()
+	}
+	return n, err
+}
+
+// formatIdleSuspend renders the idle limit for InitRequest, omitting it when disabled.
+func formatIdleSuspend(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,109 @@
+package dockerimg
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sketch.dev/loop/server"
+)
+
+func TestIdleSuspender(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	var mu sync.Mutex
+	state := server.State{LastActivity: &start}
+	resumed := make(chan server.ResumedRequest, 1)
+	innie := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/state":
+			json.NewEncoder(w).Encode(state)
+		case "/resumed":
+			var req server.ResumedRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			resumed <- req
+		default:
+			w.Write([]byte("hello from " + r.URL.Path))
+		}
+	}))
+	defer innie.Close()
+
+	s := newIdleSuspender("sketch-test", strings.TrimPrefix(innie.URL, "http://"), 30*time.Minute)
+	var docker []string
+	s.docker = func(ctx context.Context, args ...string) error {
+		docker = append(docker, strings.Join(args, " "))
+		return nil
+	}
+	s.now = func() time.Time { return now }
+	s.lastInteract = start
+
+	check := func(want ...string) {
+		t.Helper()
+		docker = nil
+		if err := s.check(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(docker, "; ") != strings.Join(want, "; ") {
+			t.Errorf("docker calls = %q, want %q", docker, want)
+		}
+	}
+
+	now = start.Add(10 * time.Minute)
+	check()
+	now = start.Add(time.Hour)
+	mu.Lock()
+	state.OutstandingToolCalls = []string{"bash"}
+	mu.Unlock()
+	check()
+	mu.Lock()
+	state.OutstandingToolCalls = nil
+	mu.Unlock()
+	check("pause sketch-test")
+	check()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.serveProxy(pctx, ln)
+	docker = nil
+	res, err := http.Get("http://" + ln.Addr().String() + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if len(docker) != 0 {
+		t.Errorf("the event stream resumed the container: %q", docker)
+	}
+	res, err = http.Get("http://" + ln.Addr().String() + "/git/log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if strings.Join(docker, "; ") != "unpause sketch-test" {
+		t.Errorf("docker calls after a request = %q", docker)
+	}
+	select {
+	case req := <-resumed:
+		if !req.SuspendedAt.Equal(start.Add(time.Hour)) {
+			t.Errorf("reported suspension at %v", req.SuspendedAt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("resume not reported to the container")
+	}
+
+	// The request counts as interacting, even with the agent still idle.
+	now = now.Add(10 * time.Minute)
+	check()
+}
@@ -0,0 +1,206 @@
+package git_tools
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileDiff is one file's changes in a diff, down to hunk boundaries
+type FileDiff struct {
+	Path       string `json:"path"`
+	OldPath    string `json:"old_path,omitempty"`   // Original path for renames and copies
+	Status     string `json:"status"`               // A=added, M=modified, D=deleted, R=renamed, C=copied
+	Similarity int    `json:"similarity,omitempty"` // Percent similarity for renames and copies
+	Binary     bool   `json:"binary,omitempty"`     // Binary files have no hunks or line counts
+	Additions  int    `json:"additions"`
+	Deletions  int    `json:"deletions"`
+	Hunks      []Hunk `json:"hunks,omitempty"`
+}
+
+// Hunk is the position of one hunk of a file's diff, in the old and new versions of the file
+type Hunk struct {
+	OldStart int    `json:"old_start"`
+	OldLines int    `json:"old_lines"`
+	NewStart int    `json:"new_start"`
+	NewLines int    `json:"new_lines"`
+	Header   string `json:"header,omitempty"` // The enclosing function or section, as git guesses it
+}
+
+// GitDiffStructured returns the diff between two commits or references, file by file, with renames detected.
+// If 'to' is empty, it diffs against the working directory.
+func GitDiffStructured(ctx context.Context, repoDir, from, to string) ([]FileDiff, error) {
+	if strings.HasPrefix(from, "-") || strings.HasPrefix(to, "-") {
+		return nil, fmt.Errorf("invalid revision: %q", from+" "+to)
+	}
+	args := []string{"-C", repoDir, "-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff", "--no-textconv", "-M", "--end-of-options", from}
+	if to != "" {
+		args = append(args, to)
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error executing git diff: %w", err)
+	}
+	return parseUnifiedDiff(string(out)), nil
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// parseUnifiedDiff parses git diff output into files and hunks
+func parseUnifiedDiff(diff string) []FileDiff {
+	files := []FileDiff{}
+	var cur *FileDiff
+	oldLeft, newLeft := 0, 0 // lines left in the current hunk
+
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				cur.Additions++
+				newLeft--
+			case strings.HasPrefix(line, "-"):
+				cur.Deletions++
+				oldLeft--
+			case strings.HasPrefix(line, `\`): // \ No newline at end of file
+			default:
+				oldLeft--
+				newLeft--
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "diff --git "); ok {
+			files = append(files, FileDiff{Path: diffGitPath(rest), Status: "M"})
+			cur = &files[len(files)-1]
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		if m := hunkHeaderRe.FindStringSubmatch(line); m != nil {
+			h := Hunk{OldLines: 1, NewLines: 1, Header: m[5]}
+			h.OldStart, _ = strconv.Atoi(m[1])
+			h.NewStart, _ = strconv.Atoi(m[3])
+			if m[2] != "" {
+				h.OldLines, _ = strconv.Atoi(m[2])
+			}
+			if m[4] != "" {
+				h.NewLines, _ = strconv.Atoi(m[4])
+			}
+			cur.Hunks = append(cur.Hunks, h)
+			oldLeft, newLeft = h.OldLines, h.NewLines
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "new file mode "):
+			cur.Status = "A"
+		case strings.HasPrefix(line, "deleted file mode "):
+			cur.Status = "D"
+		case strings.HasPrefix(line, "rename from "):
+			cur.Status, cur.OldPath = "R", strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			cur.Path = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "copy from "):
+			cur.Status, cur.OldPath = "C", strings.TrimPrefix(line, "copy from ")
+		case strings.HasPrefix(line, "copy to "):
+			cur.Path = strings.TrimPrefix(line, "copy to ")
+		case strings.HasPrefix(line, "similarity index "):
+			cur.Similarity, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "similarity index "), "%"))
+		case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ"):
+			cur.Binary = true
+		case strings.HasPrefix(line, "+++ b/"):
+			cur.Path = strings.TrimPrefix(line, "+++ b/")
+		}
+	}
+	return files
+}
+
+// diffGitPath returns the path in a "diff --git a/path b/path" header.
+// The header is ambiguous when paths contain " b/", but both paths are the same
+// unless the file was renamed or copied, and then later lines name both.
+func diffGitPath(header string) string {
+	if n := (len(header) - 5) / 2; n > 0 && strings.HasPrefix(header, "a/") && header[2+n:2+n+3] == " b/" {
+		return header[2 : 2+n]
+	}
+	_, path, _ := strings.Cut(header, " b/")
+	return path
+}
+
+// maxCachedDiffs bounds the diffs a DiffCache keeps
+const maxCachedDiffs = 64
+
+// DiffCache caches structured diffs between commits, which never change.
+// It is safe for concurrent use.
+type DiffCache struct {
+	repoDir string
+
+	mu    sync.Mutex
+	diffs map[[2]string][]FileDiff // by base and head commit hash
+	order [][2]string              // keys, oldest first
+}
+
+// NewDiffCache returns an empty cache of structured diffs in repoDir
+func NewDiffCache(repoDir string) *DiffCache {
+	return &DiffCache{repoDir: repoDir, diffs: make(map[[2]string][]FileDiff)}
+}
+
+// Diff returns the structured diff between two commits or references, like GitDiffStructured.
+// Diffs against the working directory (an empty 'to') aren't cached.
+// The result is shared between callers, who must not modify it.
+func (c *DiffCache) Diff(ctx context.Context, from, to string) ([]FileDiff, error) {
+	if to == "" {
+		return GitDiffStructured(ctx, c.repoDir, from, "")
+	}
+	base, err := resolveCommit(ctx, c.repoDir, from)
+	if err != nil {
+		return nil, err
+	}
+	head, err := resolveCommit(ctx, c.repoDir, to)
+	if err != nil {
+		return nil, err
+	}
+	key := [2]string{base, head}
+
+	c.mu.Lock()
+	diff, ok := c.diffs[key]
+	c.mu.Unlock()
+	if ok {
+		return diff, nil
+	}
+
+	diff, err = GitDiffStructured(ctx, c.repoDir, base, head)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.diffs[key]; ok {
+		return cached, nil // computed concurrently
+	}
+	c.diffs[key] = diff
+	c.order = append(c.order, key)
+	if len(c.order) > maxCachedDiffs {
+		delete(c.diffs, c.order[0])
+		c.order = c.order[1:]
+	}
+	return diff, nil
+}
+
+// resolveCommit returns the hash of the commit ref names
+func resolveCommit(ctx context.Context, repoDir, ref string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "-C", repoDir, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unknown revision %q", ref)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
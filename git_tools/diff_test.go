@@ -0,0 +1,137 @@
+package git_tools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGitDiffStructured(t *testing.T) {
+	repoDir := setupTestRepo(t)
+	defer os.RemoveAll(repoDir)
+
+	long := strings.Repeat("line\n", 20)
+	createAndCommitFile(t, repoDir, "old.txt", long, true)
+	base := createAndCommitFile(t, repoDir, "edit.go", "package p\n\nfunc f() {\n\treturn\n}\n", true)
+
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repoDir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v - %s", args, err, out)
+		}
+	}
+	run("mv", "old.txt", "new.txt")
+	if err := os.WriteFile(filepath.Join(repoDir, "edit.go"), []byte("package p\n\nfunc f() {\n\tprintln()\n\treturn\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "blob.bin"), []byte{0, 1, 2, 0, 3}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run("add", "-A")
+	run("commit", "-m", "changes")
+
+	ctx := context.Background()
+	files, err := GitDiffStructured(ctx, repoDir, base, "HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := make(map[string]FileDiff)
+	for _, f := range files {
+		byPath[f.Path] = f
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files: %+v", len(files), files)
+	}
+	if f := byPath["new.txt"]; f.Status != "R" || f.OldPath != "old.txt" || f.Similarity != 100 {
+		t.Errorf("rename = %+v", f)
+	}
+	if f := byPath["blob.bin"]; f.Status != "A" || !f.Binary || len(f.Hunks) != 0 {
+		t.Errorf("binary = %+v", f)
+	}
+	f := byPath["edit.go"]
+	if f.Status != "M" || f.Additions != 1 || f.Deletions != 0 || len(f.Hunks) != 1 {
+		t.Fatalf("edit = %+v", f)
+	}
+	if h := f.Hunks[0]; h.OldStart != 1 || h.OldLines != 5 || h.NewStart != 1 || h.NewLines != 6 {
+		t.Errorf("hunk = %+v", h)
+	}
+
+	if _, err := GitDiffStructured(ctx, repoDir, "--output=/tmp/x", "HEAD"); err == nil {
+		t.Error("accepted an option as a revision")
+	}
+}
+
+func TestParseUnifiedDiff(t *testing.T) {
+	diff := `diff --git a/a b/c.txt b/a b/c.txt
+index 1111111..2222222 100644
+--- a/a b/c.txt
++++ b/a b/c.txt
+@@ -1,3 +1,2 @@ func main() {
+ keep
+--- removed line that looks like a header
++++ added line that looks like a header
+-gone
+diff --git a/gone.go b/gone.go
+deleted file mode 100644
+index 3333333..0000000
+--- a/gone.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package gone
+`
+	files := parseUnifiedDiff(diff)
+	if len(files) != 2 {
+		t.Fatalf("got %+v", files)
+	}
+	if f := files[0]; f.Path != "a b/c.txt" || f.Additions != 1 || f.Deletions != 2 || len(f.Hunks) != 1 || f.Hunks[0].Header != "func main() {" {
+		t.Errorf("file 0 = %+v", f)
+	}
+	if f := files[1]; f.Path != "gone.go" || f.Status != "D" || f.Deletions != 1 || f.Hunks[0] != (Hunk{OldStart: 1, OldLines: 1, NewStart: 0, NewLines: 0}) {
+		t.Errorf("file 1 = %+v", f)
+	}
+}
+
+func TestDiffCache(t *testing.T) {
+	repoDir := setupTestRepo(t)
+	defer os.RemoveAll(repoDir)
+	base := createAndCommitFile(t, repoDir, "a.txt", "one\n", true)
+	createAndCommitFile(t, repoDir, "a.txt", "two\n", true)
+
+	c := NewDiffCache(repoDir)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Diff(ctx, base, "HEAD"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(c.diffs) != 1 {
+		t.Errorf("cached %d diffs, want 1", len(c.diffs))
+	}
+
+	// The working directory isn't cached.
+	if err := os.WriteFile(filepath.Join(repoDir, "a.txt"), []byte("three\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := c.Diff(ctx, "HEAD", "")
+	if err != nil || len(files) != 1 || files[0].Path != "a.txt" {
+		t.Errorf("working directory diff = %+v, %v", files, err)
+	}
+	if len(c.diffs) != 1 {
+		t.Errorf("cached %d diffs, want 1", len(c.diffs))
+	}
+
+	if _, err := c.Diff(ctx, "nonexistent", "HEAD"); err == nil {
+		t.Error("diffed an unknown revision")
+	}
+}
@@ -57,6 +57,59 @@ func GitRawDiff(repoDir, from, to string) ([]DiffFile, error) {
 	return parseRawDiffWithNumstat(string(rawOut), string(numstatOut))
 }
 
+// FileStat is the number of lines changed in one file
+type FileStat struct {
+	Path      string `json:"path"`
+	OldPath   string `json:"old_path,omitempty"` // Original path for renames
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"` // Binary files have no line counts
+}
+
+// GitDiffStat returns the lines changed per file between two commits or references,
+// following renames
+func GitDiffStat(repoDir, from, to string) ([]FileStat, error) {
+	cmd := exec.Command("git", "-C", repoDir, "diff", "--numstat", "-z", "-M", from, to)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error executing git diff --numstat: %w", err)
+	}
+	return parseNumstatZ(out), nil
+}
+
+// GitCommitStat returns the lines changed per file by a single commit, following renames.
+// A root commit is compared against the empty tree.
+func GitCommitStat(repoDir, hash string) ([]FileStat, error) {
+	cmd := exec.Command("git", "-C", repoDir, "diff-tree", "--no-commit-id", "--root", "-r", "--numstat", "-z", "-M", hash)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error executing git diff-tree --numstat: %w", err)
+	}
+	return parseNumstatZ(out), nil
+}
+
+// parseNumstatZ parses git --numstat -z output. Each entry is "added\tdeleted\tpath\0",
+// or for renames "added\tdeleted\t\0oldpath\0newpath\0". Binary files have "-" for both counts.
+func parseNumstatZ(out []byte) []FileStat {
+	var files []FileStat
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i < len(fields); i++ {
+		parts := strings.SplitN(strings.TrimLeft(fields[i], "\n"), "\t", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		f := FileStat{Path: parts[2], Binary: parts[0] == "-"}
+		fmt.Sscanf(parts[0], "%d", &f.Additions)
+		fmt.Sscanf(parts[1], "%d", &f.Deletions)
+		if f.Path == "" && i+2 < len(fields) {
+			f.OldPath, f.Path = fields[i+1], fields[i+2]
+			i += 2
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
 // GitShow returns the result of git show for a specific commit hash
 func GitShow(repoDir, hash string) (string, error) {
 	cmd := exec.Command("git", "-C", repoDir, "show", hash)
@@ -470,3 +523,67 @@ func GitGetUntrackedFiles(repoDir string) ([]string, error) {
 	}
 	return result, nil
 }
+
+// ApplyPatch commits patch, a unified diff such as git diff or git format-patch
+// produces, on top of HEAD with the given commit message. Nothing is changed if
+// tracked files have uncommitted changes or the patch doesn't apply cleanly.
+// It returns the new commit's hash and the files the patch touched.
+func ApplyPatch(ctx context.Context, repoDir, patch, message string) (string, []string, error) {
+	if strings.TrimSpace(patch) == "" {
+		return "", nil, fmt.Errorf("patch is empty")
+	}
+	cmd := exec.CommandContext(ctx, "git", "diff", "--quiet", "HEAD")
+	cmd.Dir = repoDir
+	if err := cmd.Run(); err != nil {
+		return "", nil, fmt.Errorf("the working tree has uncommitted changes; commit or discard them before applying a patch")
+	}
+
+	apply := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"apply", "--index"}, args...)...)
+		cmd.Dir = repoDir
+		cmd.Stdin = strings.NewReader(patch)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("patch does not apply cleanly: %s", strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	if err := apply("--check"); err != nil {
+		return "", nil, err
+	}
+	if err := apply(); err != nil {
+		return "", nil, err
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "diff", "--cached", "--name-only", "-z")
+	cmd.Dir = repoDir
+	output, err := cmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("error listing patched files: %w", err)
+	}
+	var files []string
+	for path := range bytes.SplitSeq(output, []byte{0}) {
+		if len(path) > 0 {
+			files = append(files, string(path))
+		}
+	}
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("patch makes no changes")
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "commit", "-m", message)
+	cmd.Dir = repoDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		// Leave the tree as it was rather than half-applied.
+		reset := exec.CommandContext(ctx, "git", "reset", "--hard", "HEAD")
+		reset.Dir = repoDir
+		reset.Run()
+		return "", nil, fmt.Errorf("error committing patch: %w - git output: %s", err, string(output))
+	}
+	cmd = exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = repoDir
+	output, err = cmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("error resolving patch commit: %w", err)
+	}
+	return strings.TrimSpace(string(output)), files, nil
+}
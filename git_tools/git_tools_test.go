@@ -1,10 +1,12 @@
 package git_tools
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -566,3 +568,101 @@ func TestGitSaveFile(t *testing.T) {
 		t.Error("GitSaveFile should have rejected an untracked file")
 	}
 }
+
+func TestApplyPatch(t *testing.T) {
+	repoDir := setupTestRepo(t)
+	defer os.RemoveAll(repoDir)
+	base := createAndCommitFile(t, repoDir, "greeting.txt", "hello\nworld\n", true)
+
+	patch := `diff --git a/greeting.txt b/greeting.txt
+--- a/greeting.txt
++++ b/greeting.txt
+@@ -1,2 +1,2 @@
+ hello
+-world
++there
+diff --git a/new.txt b/new.txt
+new file mode 100644
+--- /dev/null
++++ b/new.txt
+@@ -0,0 +1 @@
++new
+`
+	ctx := context.Background()
+	commit, files, err := ApplyPatch(ctx, repoDir, patch, "Apply a patch")
+	if err != nil {
+		t.Fatalf("ApplyPatch: %v", err)
+	}
+	if commit == "" || commit == base {
+		t.Errorf("commit = %q, want a new commit on top of %s", commit, base)
+	}
+	if want := []string{"greeting.txt", "new.txt"}; strings.Join(files, ",") != strings.Join(want, ",") {
+		t.Errorf("files = %v, want %v", files, want)
+	}
+	content, err := os.ReadFile(filepath.Join(repoDir, "greeting.txt"))
+	if err != nil || string(content) != "hello\nthere\n" {
+		t.Errorf("greeting.txt = %q, %v", content, err)
+	}
+
+	// Applying it again no longer applies cleanly, and leaves HEAD alone.
+	if _, _, err := ApplyPatch(ctx, repoDir, patch, "Again"); err == nil || !strings.Contains(err.Error(), "does not apply cleanly") {
+		t.Errorf("reapplying: err = %v, want a clean-apply failure", err)
+	}
+	out, err := exec.Command("git", "-C", repoDir, "rev-parse", "HEAD").Output()
+	if err != nil || strings.TrimSpace(string(out)) != commit {
+		t.Errorf("HEAD moved to %s after a failed apply", out)
+	}
+
+	// Uncommitted changes block the patch.
+	if err := os.WriteFile(filepath.Join(repoDir, "new.txt"), []byte("edited\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ApplyPatch(ctx, repoDir, patch, "Dirty"); err == nil || !strings.Contains(err.Error(), "uncommitted changes") {
+		t.Errorf("dirty tree: err = %v, want an uncommitted changes error", err)
+	}
+}
+
+func TestGitDiffStatWithRename(t *testing.T) {
+	repoDir := setupTestRepo(t)
+	defer os.RemoveAll(repoDir)
+
+	base := createAndCommitFile(t, repoDir, "original.txt", "one\ntwo\nthree\nfour\nfive\n", true)
+	cmd := exec.Command("git", "-C", repoDir, "mv", "original.txt", "renamed.txt")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Failed to rename file: %v - %s", err, out)
+	}
+	head := createAndCommitFile(t, repoDir, "renamed.txt", "one\ntwo\nthree\nfour\nfive\nsix\n", true)
+
+	want := []FileStat{{Path: "renamed.txt", OldPath: "original.txt", Additions: 1}}
+	stats, err := GitDiffStat(repoDir, base, head)
+	if err != nil {
+		t.Fatalf("GitDiffStat failed: %v", err)
+	}
+	if !slices.Equal(stats, want) {
+		t.Errorf("GitDiffStat = %+v, want %+v", stats, want)
+	}
+	stats, err = GitCommitStat(repoDir, head)
+	if err != nil {
+		t.Fatalf("GitCommitStat failed: %v", err)
+	}
+	if !slices.Equal(stats, want) {
+		t.Errorf("GitCommitStat = %+v, want %+v", stats, want)
+	}
+
+	// The root commit is compared against the empty tree.
+	stats, err = GitCommitStat(repoDir, base)
+	if err != nil {
+		t.Fatalf("GitCommitStat failed: %v", err)
+	}
+	if want := []FileStat{{Path: "original.txt", Additions: 5}}; !slices.Equal(stats, want) {
+		t.Errorf("GitCommitStat(root) = %+v, want %+v", stats, want)
+	}
+}
+
+func TestParseNumstatZBinary(t *testing.T) {
+	got := parseNumstatZ([]byte("-\t-\timage.png\x003\t1\tmain.go\x00"))
+	want := []FileStat{{Path: "image.png", Binary: true}, {Path: "main.go", Additions: 3, Deletions: 1}}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseNumstatZ = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+// Package gittrace records the git commands sketch runs, with the refs of
+// the repository they concern before and after each, so that the git sync
+// between the container's agent and the host can be replayed against
+// scratch repositories (see Replay) to reproduce its bugs.
+//
+// A trace is a file of Ops in JSON, one per line. Both sides write to the
+// same file: the agent in the container posts its ops to the host sketch,
+// which appends them alongside its git server's.
+package gittrace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The sides of a trace.
+const (
+	Agent = "agent" // the agent's clone, or with -unsafe the user's repository
+	Host  = "host"  // the user's repository, as the git server serves it to the container
+)
+
+// An Op is one git command.
+type Op struct {
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration"`
+	Side     string        `json:"side"`
+	Repo     string        `json:"repo"` // whose refs Before and After are
+	Dir      string        `json:"dir"`
+	Args     []string      `json:"args"` // without "git"; passwords in URLs are redacted
+	Exit     int           `json:"exit"` // -1 if git didn't run, or http-backend failed
+	// Before and After map ref names to object names, plus HEAD, which is
+	// "ref: NAME" unless detached. They are nil while there is no repository.
+	Before map[string]string `json:"before"`
+	After  map[string]string `json:"after"`
+}
+
+// A Recorder records the git commands of one side. Its methods run commands
+// whether or not the Recorder is nil, and only a nil one doesn't record them.
+type Recorder struct {
+	side, repo string
+	write      func(Op)
+}
+
+// NewRecorder returns a Recorder of side's commands concerning the repository
+// at repo, which passes each Op to write.
+func NewRecorder(side, repo string, write func(Op)) *Recorder {
+	return &Recorder{side: side, repo: repo, write: write}
+}
+
+// Run runs cmd, a git command, like cmd.Run.
+func (r *Recorder) Run(cmd *exec.Cmd) error {
+	return r.Record(cmd.Dir, cmd.Args[1:], cmd.Run)
+}
+
+// Output runs cmd, a git command, like cmd.Output.
+func (r *Recorder) Output(cmd *exec.Cmd) ([]byte, error) {
+	var out []byte
+	err := r.Record(cmd.Dir, cmd.Args[1:], func() (err error) {
+		out, err = cmd.Output()
+		return err
+	})
+	return out, err
+}
+
+// CombinedOutput runs cmd, a git command, like cmd.CombinedOutput.
+func (r *Recorder) CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	var out []byte
+	err := r.Record(cmd.Dir, cmd.Args[1:], func() (err error) {
+		out, err = cmd.CombinedOutput()
+		return err
+	})
+	return out, err
+}
+
+// Record records run, which runs git with args in dir, and returns its error.
+func (r *Recorder) Record(dir string, args []string, run func() error) error {
+	if r == nil {
+		return run()
+	}
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	op := Op{Side: r.side, Repo: r.repo, Dir: dir, Args: redact(args), Before: Refs(r.repo)}
+	op.Time = time.Now().UTC()
+	err := run()
+	op.Duration = time.Since(op.Time)
+	op.Exit = exitCode(err)
+	op.After = Refs(r.repo)
+	r.write(op)
+	return err
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		return exitErr.ExitCode()
+	default:
+		return -1
+	}
+}
+
+var urlPassword = regexp.MustCompile(`(://[^/@:\s]*):[^/@\s]*@`)
+
+func redact(args []string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		out[i] = urlPassword.ReplaceAllString(a, "$1:REDACTED@")
+	}
+	return out
+}
+
+// Refs returns the refs of the repository at dir, and its HEAD.
+// It returns nil if there is no repository there.
+func Refs(dir string) map[string]string {
+	out, err := exec.Command("git", "-C", dir, "for-each-ref", "--format=%(refname)%00%(objectname)").Output()
+	if err != nil {
+		return nil
+	}
+	refs := make(map[string]string)
+	for line := range strings.Lines(string(out)) {
+		if name, obj, ok := strings.Cut(strings.TrimSpace(line), "\x00"); ok {
+			refs[name] = obj
+		}
+	}
+	if out, err := exec.Command("git", "-C", dir, "symbolic-ref", "-q", "HEAD").Output(); err == nil {
+		refs["HEAD"] = "ref: " + strings.TrimSpace(string(out))
+	} else if out, err := exec.Command("git", "-C", dir, "rev-parse", "-q", "--verify", "HEAD").Output(); err == nil {
+		refs["HEAD"] = strings.TrimSpace(string(out))
+	}
+	return refs
+}
+
+// A Log is a trace file. Its Write is safe for concurrent use.
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Create opens the trace file at path for appending, creating it if need be.
+func Create(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{f: f}, nil
+}
+
+// Write appends op to the trace.
+func (l *Log) Write(op Op) {
+	b, err := json.Marshal(op)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		slog.Warn("writing git trace", "error", err)
+	}
+}
+
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// Post returns a write func for NewRecorder that sends each Op to url,
+// as the container's agent does to the host sketch.
+func Post(url string) func(Op) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func(op Op) {
+		body, err := json.Marshal(op)
+		if err != nil {
+			return
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Debug("posting git trace", "error", err)
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// Read reads a trace.
+func Read(r io.Reader) ([]Op, error) {
+	var ops []Op
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+	for n := 1; sc.Scan(); n++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var op Op
+		if err := json.Unmarshal(sc.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, sc.Err()
+}
+
+// ReadFile reads the trace file at path.
+func ReadFile(path string) ([]Op, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ops, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ops, nil
+}
@@ -0,0 +1,387 @@
+package gittrace
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A Divergence is where a replay came out other than its trace.
+type Divergence struct {
+	Index   int // of the op, in the trace ordered by Time
+	Op      Op
+	Problem string
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("#%d %s: git %s: %s", d.Index, d.Op.Side, strings.Join(d.Op.Args, " "), d.Problem)
+}
+
+// Replay runs ops again, in order of their Time, in scratch repositories under
+// dir, one for each side, and reports where exit codes or refs afterwards
+// differ from the trace. It doesn't touch the repositories the trace was
+// recorded in, nor the user's git configuration.
+//
+// A trace knows commits only by name, so a replay is about refs. Before each
+// op, Replay sets the side's refs to what the trace saw, standing an empty
+// commit in, atop the ref's current one, for each commit it hasn't met yet:
+// commits made between traced commands, like the agent's own, come out as
+// straight lines of stand-ins. Replayed commits get names other than the
+// recorded ones, which Replay pairs up as the trace reveals them.
+//
+// The agent's remote, whatever its URL, is the host's scratch repository.
+// The git server's http-backend doesn't run again; rather, the host's refs
+// are checked against it after the agent's command it served. What the host
+// fetches from or pushes to its own remotes is taken as the trace has it.
+func Replay(ctx context.Context, ops []Op, dir string) ([]Divergence, error) {
+	ops = slices.Clone(ops)
+	slices.SortStableFunc(ops, func(a, b Op) int { return a.Time.Compare(b.Time) })
+	rp := &replayer{
+		ctx:      ctx,
+		dir:      dir,
+		sides:    map[string]string{Agent: filepath.Join(dir, "agent"), Host: filepath.Join(dir, "host")},
+		repos:    make(map[string]string),
+		objects:  make(map[string]string),
+		recorded: make(map[string]string),
+	}
+	for _, op := range ops {
+		side, ok := rp.sides[op.Side]
+		if !ok {
+			return nil, fmt.Errorf("unknown side %q in trace", op.Side)
+		}
+		if op.Repo != "" {
+			rp.repos[op.Repo] = side
+		}
+	}
+	if err := rp.setup(); err != nil {
+		return nil, fmt.Errorf("setting up scratch repositories: %w", err)
+	}
+
+	served := servedRequests(ops)
+	for i, op := range ops {
+		rp.now = op.Time
+		switch sub := subcommand(op.Args); {
+		case sub == "http-backend":
+			continue // checked with the command it served, below
+		case op.Side == Host && slices.Contains([]string{"fetch", "pull", "push", "ls-remote"}, sub):
+			if err := rp.settle(op.Side, op.After); err != nil {
+				rp.diverge(i, op, "adopting the traced refs: %v", err)
+			}
+			continue
+		}
+		if err := rp.settle(op.Side, op.Before); err != nil {
+			rp.diverge(i, op, "setting up the traced refs: %v", err)
+			continue
+		}
+		out, err := rp.run(op)
+		if code := exitCode(err); code != op.Exit {
+			rp.diverge(i, op, "exit status %d, want %d: %s", code, op.Exit, lastLine(out))
+		}
+		rp.compare(i, op, op.Side, op.After)
+		if j, ok := served[i]; ok {
+			rp.compare(j, ops[j], ops[j].Side, ops[j].After)
+		}
+	}
+	return rp.divs, nil
+}
+
+type replayer struct {
+	ctx      context.Context
+	dir      string
+	env      []string
+	now      time.Time         // of the op being replayed, for deterministic commits
+	sides    map[string]string // scratch repository, by side
+	repos    map[string]string // scratch repository, by recorded one
+	objects  map[string]string // replayed object name, by recorded one
+	recorded map[string]string // and the other way round
+	divs     []Divergence
+}
+
+func (rp *replayer) setup() error {
+	home := filepath.Join(rp.dir, "home")
+	if err := os.MkdirAll(home, 0o700); err != nil {
+		return err
+	}
+	config := filepath.Join(home, ".gitconfig")
+	// Stand-ins are fetched between the sides by name.
+	gitconfig := "[user]\n\tname = sketch replay\n\temail = replay@sketch.dev\n[init]\n\tdefaultBranch = main\n[uploadpack]\n\tallowAnySHA1InWant = true\n[advice]\n\tdetachedHead = false\n"
+	if err := os.WriteFile(config, []byte(gitconfig), 0o600); err != nil {
+		return err
+	}
+	rp.env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + home, "GIT_CONFIG_GLOBAL=" + config, "GIT_CONFIG_NOSYSTEM=1", "GIT_TERMINAL_PROMPT=0", "LANG=C"}
+
+	// The host's origin is empty: what comes from it is adopted, not fetched.
+	upstream := filepath.Join(rp.dir, "upstream.git")
+	for _, args := range [][]string{
+		{"init", "-q", "--bare", upstream},
+		{"init", "-q", rp.sides[Host]},
+		{"-C", rp.sides[Host], "remote", "add", "origin", upstream},
+	} {
+		if out, err := rp.git(rp.dir, args...); err != nil {
+			return fmt.Errorf("git %s: %s: %w", strings.Join(args, " "), out, err)
+		}
+	}
+	return nil
+}
+
+func (rp *replayer) git(dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(rp.ctx, "git", args...)
+	cmd.Dir = dir
+	date := fmt.Sprintf("@%d +0000", rp.now.Unix())
+	cmd.Env = append(rp.env, "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	out, err := cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (rp *replayer) diverge(i int, op Op, format string, args ...any) {
+	rp.divs = append(rp.divs, Divergence{Index: i, Op: op, Problem: fmt.Sprintf(format, args...)})
+}
+
+// settle makes the refs of side's repository want, as the trace recorded them.
+func (rp *replayer) settle(side string, want map[string]string) error {
+	repo := rp.sides[side]
+	if want == nil {
+		return nil // no repository yet, as before a clone
+	}
+	cur := Refs(repo)
+	if cur == nil {
+		if out, err := rp.git(rp.dir, "init", "-q", repo); err != nil {
+			return fmt.Errorf("git init: %s: %w", out, err)
+		}
+		if side == Agent {
+			rp.git(repo, "remote", "add", "origin", rp.sides[Host])
+		}
+		cur = Refs(repo)
+	}
+	oldHead, _ := rp.git(repo, "rev-parse", "-q", "--verify", "HEAD")
+
+	for _, name := range slices.Sorted(maps.Keys(want)) {
+		if name == "HEAD" {
+			continue
+		}
+		obj, err := rp.object(repo, want[name], cur[name])
+		if err != nil {
+			return err
+		}
+		if cur[name] != obj {
+			if out, err := rp.git(repo, "update-ref", name, obj); err != nil {
+				return fmt.Errorf("git update-ref %s: %s: %w", name, out, err)
+			}
+		}
+	}
+	for name := range cur {
+		if _, ok := want[name]; !ok && name != "HEAD" {
+			if out, err := rp.git(repo, "update-ref", "-d", name); err != nil {
+				return fmt.Errorf("git update-ref -d %s: %s: %w", name, out, err)
+			}
+		}
+	}
+	if target, ok := strings.CutPrefix(want["HEAD"], "ref: "); ok {
+		if cur["HEAD"] != want["HEAD"] {
+			if out, err := rp.git(repo, "symbolic-ref", "HEAD", target); err != nil {
+				return fmt.Errorf("git symbolic-ref HEAD: %s: %w", out, err)
+			}
+		}
+	} else if want["HEAD"] != "" {
+		obj, err := rp.object(repo, want["HEAD"], "")
+		if err != nil {
+			return err
+		}
+		if out, err := rp.git(repo, "update-ref", "--no-deref", "HEAD", obj); err != nil {
+			return fmt.Errorf("git update-ref HEAD: %s: %w", out, err)
+		}
+	}
+
+	// Commands that commit go by the index, so it follows HEAD.
+	if head, _ := rp.git(repo, "rev-parse", "-q", "--verify", "HEAD"); head != "" && head != oldHead {
+		if out, err := rp.git(repo, "reset", "-q", "--hard"); err != nil {
+			return fmt.Errorf("git reset --hard: %s: %w", out, err)
+		}
+	}
+	return nil
+}
+
+// object returns the replayed object for recorded, making sure repo has it.
+// For one it hasn't met, it commits a stand-in, with parent if that's a commit.
+func (rp *replayer) object(repo, recorded, parent string) (string, error) {
+	if obj, ok := rp.objects[recorded]; ok {
+		if _, err := rp.git(repo, "cat-file", "-e", obj); err == nil {
+			return obj, nil
+		}
+		for _, other := range rp.sides {
+			if other == repo {
+				continue
+			}
+			if _, err := rp.git(repo, "fetch", "-q", other, obj); err == nil {
+				return obj, nil
+			}
+		}
+		return "", fmt.Errorf("no scratch repository has %s, which stands in for %s", short(obj), short(recorded))
+	}
+	tree, err := rp.git(repo, "mktree")
+	if err != nil {
+		return "", fmt.Errorf("git mktree: %s: %w", tree, err)
+	}
+	args := []string{"commit-tree", tree, "-m", "stand-in for " + recorded}
+	if typ, _ := rp.git(repo, "cat-file", "-t", parent); parent != "" && typ == "commit" {
+		args = append(args, "-p", parent)
+	}
+	obj, err := rp.git(repo, args...)
+	if err != nil {
+		return "", fmt.Errorf("git commit-tree: %s: %w", obj, err)
+	}
+	rp.objects[recorded], rp.recorded[obj] = obj, recorded
+	return obj, nil
+}
+
+func (rp *replayer) run(op Op) (string, error) {
+	dir, ok := rp.path(op.Dir)
+	if _, err := os.Stat(dir); !ok || err != nil {
+		dir = rp.dir // e.g. the directory of a clone, still to be made
+	}
+	var args []string
+	for i := 0; i < len(op.Args); i++ {
+		a := op.Args[i]
+		switch {
+		case a == "--reference" || a == "--reference-if-able":
+			i++ // alternates only lend objects
+			continue
+		case strings.HasPrefix(a, "--reference=") || strings.HasPrefix(a, "--reference-if-able="):
+			continue
+		case strings.Contains(a, "://"):
+			a = rp.sides[Host] // the git server
+		default:
+			if p, ok := rp.path(a); ok {
+				a = p
+			}
+			a = objectName.ReplaceAllStringFunc(a, func(s string) string {
+				if obj, ok := rp.objects[s]; ok {
+					return obj
+				}
+				return s
+			})
+		}
+		args = append(args, a)
+	}
+	return rp.git(dir, args...)
+}
+
+var objectName = regexp.MustCompile(`\b[0-9a-f]{40}(?:[0-9a-f]{24})?\b`)
+
+// path maps p, if it is in a recorded repository, to the scratch one.
+func (rp *replayer) path(p string) (string, bool) {
+	for repo, scratch := range rp.repos {
+		if p == repo {
+			return scratch, true
+		}
+		if rest, ok := strings.CutPrefix(p, repo+"/"); ok {
+			return filepath.Join(scratch, rest), true
+		}
+	}
+	return p, false
+}
+
+// compare checks that the refs of side's repository are those of want.
+func (rp *replayer) compare(i int, op Op, side string, want map[string]string) {
+	got := Refs(rp.sides[side])
+	if want == nil {
+		if got != nil {
+			rp.diverge(i, op, "there is a %s repository, the trace has none", side)
+		}
+		return
+	}
+	if got == nil {
+		rp.diverge(i, op, "there is no %s repository", side)
+		return
+	}
+	names := slices.Sorted(maps.Keys(want))
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		w, g := want[name], got[name]
+		switch {
+		case strings.HasPrefix(w, "ref: ") || strings.HasPrefix(g, "ref: "):
+			if w != g {
+				rp.diverge(i, op, "%s is %q, want %q", name, g, w)
+			}
+		case w == "":
+			rp.diverge(i, op, "%s is at %s, the trace has no such ref", name, rp.describe(g))
+		case g == "":
+			rp.diverge(i, op, "%s is missing, want %s", name, short(w))
+		case rp.objects[w] != "":
+			if rp.objects[w] != g {
+				rp.diverge(i, op, "%s is at %s, want %s", name, rp.describe(g), short(w))
+			}
+		case rp.recorded[g] != "":
+			rp.diverge(i, op, "%s is at %s, want %s", name, rp.describe(g), short(w))
+		default:
+			rp.objects[w], rp.recorded[g] = g, w
+		}
+	}
+}
+
+// describe names a replayed object by the recorded one it is, if known.
+func (rp *replayer) describe(obj string) string {
+	if r, ok := rp.recorded[obj]; ok {
+		return short(r)
+	}
+	return "unrecorded " + short(obj)
+}
+
+// servedRequests maps the index of each op to that of the last http-backend
+// request the other side made and finished during it.
+func servedRequests(ops []Op) map[int]int {
+	served := make(map[int]int)
+	end := func(op Op) time.Time { return op.Time.Add(op.Duration) }
+	for j, req := range ops {
+		if subcommand(req.Args) != "http-backend" {
+			continue
+		}
+		for i := j - 1; i >= 0; i-- {
+			op := ops[i]
+			if op.Side == req.Side || subcommand(op.Args) == "http-backend" || end(op).Before(end(req)) {
+				continue
+			}
+			if k, ok := served[i]; !ok || !end(ops[k]).After(end(req)) {
+				served[i] = j
+			}
+			break
+		}
+	}
+	return served
+}
+
+// subcommand returns the git command of args, skipping git's own options.
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "-c" || a == "-C":
+			i++
+		case !strings.HasPrefix(a, "-"):
+			return a
+		}
+	}
+	return ""
+}
+
+func short(obj string) string {
+	return obj[:min(len(obj), 12)]
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
@@ -0,0 +1,191 @@
+// Package hooks runs the user's host-side commands on session lifecycle events,
+// for notifications, time tracking, or workflow integration.
+//
+// A hook is a shell command registered for one event with -hook EVENT=COMMAND.
+// A plugin is an executable in the plugin directory, run on every event with the
+// event name as its only argument. Both get the event as JSON on stdin and as
+// SKETCH_HOOK_* environment variables, and neither can affect the session:
+// failures are logged, and a hook that runs too long is killed.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An Event is a point in a session's life that hooks can run on.
+type Event string
+
+const (
+	SessionStart   Event = "session_start"   // the container is up and sketch is ready
+	BranchPushed   Event = "branch_pushed"   // the agent pushed a branch to the host repo
+	SessionEnd     Event = "session_end"     // the container is about to be removed
+	BudgetExceeded Event = "budget_exceeded" // the agent stopped for exceeding its budget
+)
+
+// Events are all the events, in the order a session goes through them.
+var Events = []Event{SessionStart, BranchPushed, BudgetExceeded, SessionEnd}
+
+// timeout bounds how long a hook or plugin may run.
+const timeout = 30 * time.Second
+
+// A Hook is a shell command to run on an event.
+type Hook struct {
+	Event   Event
+	Command string
+}
+
+// Parse parses a hook given as EVENT=COMMAND.
+func Parse(spec string) (Hook, error) {
+	event, command, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(command) == "" {
+		return Hook{}, fmt.Errorf("%q: want EVENT=COMMAND", spec)
+	}
+	if !slices.Contains(Events, Event(event)) {
+		return Hook{}, fmt.Errorf("%q: unknown event %q; events are %s", spec, event, eventList())
+	}
+	return Hook{Event: Event(event), Command: command}, nil
+}
+
+func eventList() string {
+	names := make([]string, len(Events))
+	for i, e := range Events {
+		names[i] = string(e)
+	}
+	return strings.Join(names, ", ")
+}
+
+// A Payload describes an event. Fields that don't apply to the event are empty.
+type Payload struct {
+	Event     Event     `json:"event"`
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id"`
+	RepoRoot  string    `json:"repo_root,omitempty"` // the host repository
+	Container string    `json:"container,omitempty"`
+	URL       string    `json:"url,omitempty"`        // of the session's web UI
+	Branch    string    `json:"branch,omitempty"`     // for branch_pushed
+	Commit    string    `json:"commit,omitempty"`     // the pushed commit
+	OldCommit string    `json:"old_commit,omitempty"` // what the branch pointed to before, if it existed
+}
+
+func (p Payload) env() []string {
+	return []string{
+		"SKETCH_HOOK_EVENT=" + string(p.Event),
+		"SKETCH_HOOK_SESSION_ID=" + p.SessionID,
+		"SKETCH_HOOK_REPO_ROOT=" + p.RepoRoot,
+		"SKETCH_HOOK_CONTAINER=" + p.Container,
+		"SKETCH_HOOK_URL=" + p.URL,
+		"SKETCH_HOOK_BRANCH=" + p.Branch,
+		"SKETCH_HOOK_COMMIT=" + p.Commit,
+		"SKETCH_HOOK_OLD_COMMIT=" + p.OldCommit,
+	}
+}
+
+// DefaultPluginDir is where plugins live.
+func DefaultPluginDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "plugins"), nil
+}
+
+// A Runner runs hooks and plugins. A nil Runner runs nothing.
+type Runner struct {
+	hooks   []Hook
+	plugins []string // paths of the plugin executables
+	wg      sync.WaitGroup
+}
+
+// NewRunner returns a runner for the hooks given as EVENT=COMMAND and the
+// executables in pluginDir, which need not exist. It returns nil if there
+// is nothing to run.
+func NewRunner(specs []string, pluginDir string) (*Runner, error) {
+	r := &Runner{}
+	for _, spec := range specs {
+		h, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.hooks = append(r.hooks, h)
+	}
+	if pluginDir != "" {
+		entries, err := os.ReadDir(pluginDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("plugins: %w", err)
+		}
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+				continue
+			}
+			r.plugins = append(r.plugins, filepath.Join(pluginDir, e.Name()))
+		}
+	}
+	if len(r.hooks) == 0 && len(r.plugins) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// Fire starts the hooks and plugins for p.Event in the background.
+// They outlive ctx's cancellation, so that a session ending by interrupt is still reported.
+func (r *Runner) Fire(ctx context.Context, p Payload) {
+	if r == nil {
+		return
+	}
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, h := range r.hooks {
+		if h.Event == p.Event {
+			r.start(ctx, p, "sh", "-c", h.Command)
+		}
+	}
+	for _, plugin := range r.plugins {
+		r.start(ctx, p, plugin, string(p.Event))
+	}
+}
+
+// Wait waits for the hooks and plugins started so far to finish.
+func (r *Runner) Wait() {
+	if r == nil {
+		return
+	}
+	r.wg.Wait()
+}
+
+func (r *Runner) start(ctx context.Context, p Payload, name string, args ...string) {
+	input, err := json.Marshal(p)
+	if err != nil {
+		slog.ErrorContext(ctx, "hooks: marshal payload", "error", err)
+		return
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Env = append(os.Environ(), p.env()...)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.WaitDelay = time.Second
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			slog.WarnContext(ctx, "hook failed", "event", p.Event, "command", cmd.String(), "error", err, "output", string(out))
+			return
+		}
+		slog.DebugContext(ctx, "hook ran", "event", p.Event, "command", cmd.String(), "output", string(out))
+	}()
+}
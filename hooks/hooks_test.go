@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	h, err := Parse("branch_pushed=notify-send pushed $SKETCH_HOOK_BRANCH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Event != BranchPushed || h.Command != "notify-send pushed $SKETCH_HOOK_BRANCH" {
+		t.Errorf("Parse = %+v", h)
+	}
+	for _, spec := range []string{"", "session_start", "session_start=", "lunch=eat"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRunner(t *testing.T) {
+	dir := t.TempDir()
+	pluginDir := filepath.Join(dir, "plugins")
+	if err := os.Mkdir(pluginDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	plugin := "#!/bin/sh\necho \"$1 $SKETCH_HOOK_SESSION_ID\" >> " + filepath.Join(dir, "plugin.log") + "\n"
+	if err := os.WriteFile(filepath.Join(pluginDir, "log"), []byte(plugin), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(pluginDir, "README"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRunner([]string{"branch_pushed=cat > " + filepath.Join(dir, "pushed.json")}, pluginDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	r.Fire(ctx, Payload{Event: SessionStart, SessionID: "s1"})
+	r.Wait()
+	r.Fire(ctx, Payload{Event: BranchPushed, SessionID: "s1", Branch: "sketch/x", Commit: "abc"})
+	r.Wait()
+
+	data, err := os.ReadFile(filepath.Join(dir, "pushed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p Payload
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != BranchPushed || p.Branch != "sketch/x" || p.Commit != "abc" || p.Time.IsZero() {
+		t.Errorf("hook got %+v", p)
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "plugin.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(log)); got != "session_start s1\nbranch_pushed s1" {
+		t.Errorf("plugin log = %q", got)
+	}
+}
+
+func TestNewRunnerEmpty(t *testing.T) {
+	r, err := NewRunner(nil, filepath.Join(t.TempDir(), "missing"))
+	if err != nil || r != nil {
+		t.Fatalf("NewRunner = %v, %v; want nil, nil", r, err)
+	}
+	// A nil runner runs nothing.
+	r.Fire(context.Background(), Payload{Event: SessionEnd})
+	r.Wait()
+}
@@ -0,0 +1,161 @@
+// Package i18n localizes the user-facing strings sketch generates itself,
+// such as automated notices and budget warnings. Model output is
+// localized separately, via the system prompt.
+package i18n
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Key identifies a localizable message. Messages are fmt format strings.
+type Key string
+
+const (
+	BudgetWarning  Key = "budget_warning"  // args: budget error
+	BudgetReset    Key = "budget_reset"    // no args
+	UserCancelled  Key = "user_cancelled"  // no args
+	TurnStopped    Key = "turn_stopped"    // args: reason
+	BranchRenamed  Key = "branch_renamed"  // args: old branch, new branch
+	NetworkBlocked Key = "network_blocked" // args: host, how it was reached
+	SessionResumed Key = "session_resumed" // args: earlier session id, message count
+	CostConfirm    Key = "cost_confirm"    // args: estimated dollars, threshold dollars
+
+	UncommittedCarried Key = "uncommitted_carried" // args: file count, commit, file list
+	TodosEdited        Key = "todos_edited"        // args: item count
+	SamplingChanged    Key = "sampling_changed"    // args: parameters, e.g. "temperature=0,seed=42"
+	SamplingReset      Key = "sampling_reset"      // no args
+	ModelChanged       Key = "model_changed"       // args: model name
+	ContainerRebuilt   Key = "container_rebuilt"   // args: base image
+	ContextAttached    Key = "context_attached"    // args: file count, KB included
+	ContextTrimmed     Key = "context_trimmed"     // args: budget in KB, what was cut or left out
+	PlanModeOn         Key = "plan_mode_on"        // no args
+	PlanModeOff        Key = "plan_mode_off"       // no args
+	PlanApproved       Key = "plan_approved"       // no args
+	PlanRejected       Key = "plan_rejected"       // no args
+	ApprovalNeeded     Key = "approval_needed"     // args: tool name
+	ApprovalGranted    Key = "approval_granted"    // args: tool name
+	ApprovalDenied     Key = "approval_denied"     // args: tool name
+	FilesWatched       Key = "files_watched"       // args: file count, file list
+)
+
+// catalogs maps language codes to their translations. English is complete;
+// other languages fall back to English for missing keys.
+var catalogs = map[string]map[Key]string{
+	"en": {
+		BudgetWarning:  "warning: %v (ask to keep trying, if you'd like)",
+		BudgetReset:    "Budget reset.",
+		UserCancelled:  "user requested agent to stop handling responses",
+		TurnStopped:    "Turn stopped: %v",
+		BranchRenamed:  "Branch renamed from %s to %s because the original branch is currently checked out on the remote.",
+		NetworkBlocked: "Network policy blocked access to %s (%s). Add it to -net-allowlist to allow it.",
+		SessionResumed: "Resumed session %s with %d messages of earlier conversation.",
+		CostConfirm:    "The next request to the model will cost about $%.2f, more than the $%.2f confirmation threshold. Send a message to go ahead; it will be sent along with the request.",
+
+		UncommittedCarried: "Brought along uncommitted changes to %d file(s) from the host as commit %.12s: %s. Run sketch with -include-uncommitted=false to start from HEAD instead.",
+		TodosEdited:        "Todo list updated from outside the conversation; it now has %d item(s).",
+		SamplingChanged:    "Sampling parameters changed to %s for the following requests.",
+		SamplingReset:      "Sampling parameters reset to the model's defaults for the following requests.",
+		ModelChanged:       "Switched to the %s model for the following requests.",
+		ContainerRebuilt:   "Moved the session to a new container built from the latest %s, with the working tree as it was.",
+		ContextAttached:    "📎 Attached %d file(s), %d KB, to the message.",
+		ContextTrimmed:     "To stay within %d KB: %s",
+		PlanModeOn:         "Plan mode is on: sketch reads and explores, but won't change files or commit until you approve its plan.",
+		PlanModeOff:        "Plan mode is off.",
+		PlanApproved:       "Plan approved; sketch may now change files and commit.",
+		PlanRejected:       "Plan not approved; sketch stays in plan mode to revise it.",
+		ApprovalNeeded:     "sketch wants to run %s and is waiting for your approval.",
+		ApprovalGranted:    "Allowed the %s call.",
+		ApprovalDenied:     "Denied the %s call.",
+		FilesWatched:       "👀 Noticed changes to %d file(s): %s. sketch will hear of them with your next message.",
+	},
+	"de": {
+		BudgetWarning:  "Warnung: %v (sag Bescheid, falls es weitergehen soll)",
+		BudgetReset:    "Budget zurückgesetzt.",
+		UserCancelled:  "Der Agent wurde auf Wunsch des Benutzers angehalten.",
+		TurnStopped:    "Turn abgebrochen: %v",
+		BranchRenamed:  "Branch von %s in %s umbenannt, weil der ursprüngliche Branch auf dem Remote gerade ausgecheckt ist.",
+		NetworkBlocked: "Die Netzwerkrichtlinie hat den Zugriff auf %s (%s) blockiert. Füge den Host zu -net-allowlist hinzu, um ihn zu erlauben.",
+		SessionResumed: "Sitzung %s mit %d Nachrichten des bisherigen Gesprächs fortgesetzt.",
+		CostConfirm:    "Die nächste Anfrage an das Modell kostet etwa $%.2f und damit mehr als die Bestätigungsschwelle von $%.2f. Schick eine Nachricht, um fortzufahren; sie wird zusammen mit der Anfrage gesendet.",
+
+		TodosEdited:        "Die Todo-Liste wurde außerhalb des Gesprächs geändert und hat jetzt %d Einträge.",
+		UncommittedCarried: "Nicht committete Änderungen an %d Datei(en) wurden vom Host als Commit %.12s übernommen: %s. Starte sketch mit -include-uncommitted=false, um stattdessen bei HEAD zu beginnen.",
+		SamplingChanged:    "Sampling-Parameter für die folgenden Anfragen geändert auf %s.",
+		SamplingReset:      "Sampling-Parameter für die folgenden Anfragen auf die Standardwerte des Modells zurückgesetzt.",
+		ModelChanged:       "Für die folgenden Anfragen auf das Modell %s gewechselt.",
+		ContainerRebuilt:   "Die Sitzung läuft jetzt in einem neuen Container auf Basis des neuesten %s, mit dem Arbeitsverzeichnis wie zuvor.",
+		ContextAttached:    "📎 %d Datei(en), %d KB, an die Nachricht angehängt.",
+		ContextTrimmed:     "Um innerhalb von %d KB zu bleiben: %s",
+		PlanModeOn:         "Planungsmodus an: sketch liest und erkundet, ändert aber keine Dateien und committet nicht, bis du seinen Plan freigibst.",
+		PlanModeOff:        "Planungsmodus aus.",
+		PlanApproved:       "Plan freigegeben; sketch darf jetzt Dateien ändern und committen.",
+		PlanRejected:       "Plan nicht freigegeben; sketch bleibt im Planungsmodus und überarbeitet ihn.",
+		ApprovalNeeded:     "sketch möchte %s ausführen und wartet auf deine Zustimmung.",
+		ApprovalGranted:    "Aufruf von %s erlaubt.",
+		ApprovalDenied:     "Aufruf von %s abgelehnt.",
+		FilesWatched:       "👀 Änderungen an %d Datei(en) bemerkt: %s. sketch erfährt davon mit deiner nächsten Nachricht.",
+	},
+	"ja": {
+		BudgetWarning:  "警告: %v（続行する場合はお知らせください）",
+		BudgetReset:    "予算をリセットしました。",
+		UserCancelled:  "ユーザーの要求によりエージェントを停止しました。",
+		TurnStopped:    "ターンを停止しました: %v",
+		BranchRenamed:  "元のブランチがリモートでチェックアウトされているため、ブランチ名を %s から %s に変更しました。",
+		NetworkBlocked: "ネットワークポリシーにより %s へのアクセスがブロックされました (%s)。許可するには -net-allowlist に追加してください。",
+		SessionResumed: "セッション %s を再開しました（これまでの会話 %d 件）。",
+		CostConfirm:    "次のモデルへのリクエストは約 $%.2f かかり、確認のしきい値 $%.2f を超えています。続行するにはメッセージを送信してください。そのメッセージはリクエストと一緒に送信されます。",
+
+		TodosEdited:        "会話の外部から ToDo リストが更新されました（現在 %d 件）。",
+		UncommittedCarried: "ホストの未コミットの変更（%d ファイル）をコミット %.12s として取り込みました: %s。HEAD から始めるには -include-uncommitted=false を付けて sketch を実行してください。",
+		SamplingChanged:    "以降のリクエストのサンプリングパラメータを %s に変更しました。",
+		SamplingReset:      "以降のリクエストのサンプリングパラメータをモデルのデフォルトに戻しました。",
+		ModelChanged:       "以降のリクエストのモデルを %s に切り替えました。",
+		ContainerRebuilt:   "最新の %s から作り直したコンテナにセッションを移しました。作業ツリーはそのままです。",
+		ContextAttached:    "📎 %d 個のファイル（%d KB）をメッセージに添付しました。",
+		ContextTrimmed:     "%d KB に収めるため: %s",
+		PlanModeOn:         "計画モードがオンです。sketch はコードを読んで調べますが、計画が承認されるまでファイルの変更やコミットは行いません。",
+		PlanModeOff:        "計画モードがオフになりました。",
+		PlanApproved:       "計画が承認されました。sketch はファイルの変更とコミットができるようになりました。",
+		PlanRejected:       "計画は承認されませんでした。sketch は計画モードのまま計画を見直します。",
+		ApprovalNeeded:     "sketch が %s の実行を求めており、あなたの承認を待っています。",
+		ApprovalGranted:    "%s の呼び出しを許可しました。",
+		ApprovalDenied:     "%s の呼び出しを拒否しました。",
+		FilesWatched:       "👀 %d 個のファイルの変更を検出しました: %s。次のメッセージと一緒に sketch に伝えます。",
+	},
+}
+
+// languageNames maps common spellings of language names to codes.
+var languageNames = map[string]string{
+	"english":  "en",
+	"german":   "de",
+	"deutsch":  "de",
+	"japanese": "ja",
+	"日本語":      "ja",
+}
+
+// Code returns the language code for lang, which may be a code such as
+// "ja" or "de-CH" or a name such as "Japanese". Unrecognized values are
+// returned lower-cased, without any region.
+func Code(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if code, ok := languageNames[lang]; ok {
+		return code
+	}
+	base, _, _ := strings.Cut(strings.ReplaceAll(lang, "_", "-"), "-")
+	return base
+}
+
+// Sprintf formats the message for key in lang, falling back to English
+// if lang has no translation for it.
+func Sprintf(lang string, key Key, args ...any) string {
+	format, ok := catalogs[Code(lang)][key]
+	if !ok {
+		format, ok = catalogs["en"][key]
+	}
+	if !ok {
+		// A missing English message is a programming error; make it visible but harmless.
+		return string(key) + fmt.Sprint(args...)
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,67 @@
+package i18n
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCode(t *testing.T) {
+	tests := map[string]string{
+		"":         "",
+		"ja":       "ja",
+		"ja-JP":    "ja",
+		"de_CH":    "de",
+		"Japanese": "ja",
+		" German ": "de",
+		"日本語":      "ja",
+		"fr":       "fr",
+	}
+	for lang, want := range tests {
+		if got := Code(lang); got != want {
+			t.Errorf("Code(%q) = %q, want %q", lang, got, want)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	if got, want := Sprintf("", TurnStopped, errors.New("too slow")), "Turn stopped: too slow"; got != want {
+		t.Errorf("default language: got %q, want %q", got, want)
+	}
+	if got, want := Sprintf("fr", BudgetReset), "Budget reset."; got != want {
+		t.Errorf("untranslated language: got %q, want %q", got, want)
+	}
+	if got, want := Sprintf("Japanese", BranchRenamed, "a", "b"), "元のブランチがリモートでチェックアウトされているため、ブランチ名を a から b に変更しました。"; got != want {
+		t.Errorf("japanese: got %q, want %q", got, want)
+	}
+}
+
+// TestCatalogsComplete checks that translations don't introduce keys
+// English lacks or change the number of format arguments.
+func TestCatalogsComplete(t *testing.T) {
+	en := catalogs["en"]
+	for lang, catalog := range catalogs {
+		for key, format := range catalog {
+			enFormat, ok := en[key]
+			if !ok {
+				t.Errorf("%s: key %q has no English message", lang, key)
+				continue
+			}
+			if got, want := countVerbs(format), countVerbs(enFormat); got != want {
+				t.Errorf("%s: %q has %d format verbs, English has %d", lang, key, got, want)
+			}
+		}
+	}
+}
+
+func countVerbs(format string) int {
+	n := 0
+	for i := 0; i < len(format)-1; i++ {
+		if format[i] == '%' {
+			if format[i+1] != '%' {
+				n++
+			}
+			i++
+		}
+	}
+	return n
+}
@@ -23,8 +23,10 @@ const (
 	// See https://docs.anthropic.com/en/docs/about-claude/models/all-models for
 	// current maximums. There's currently a flag to enable 128k output (output-128k-2025-02-19)
 	DefaultMaxTokens = 8192
-	APIKeyEnv        = "ANTHROPIC_API_KEY"
-	DefaultURL       = "https://api.anthropic.com/v1/messages"
+	// MinThinkingBudget is the fewest tokens Claude may be given to think.
+	MinThinkingBudget = 1024
+	APIKeyEnv         = "ANTHROPIC_API_KEY"
+	DefaultURL        = "https://api.anthropic.com/v1/messages"
 )
 
 const (
@@ -75,15 +77,30 @@ func (s *Service) TokenContextWindow() int {
 	}
 }
 
+// Pricing returns the list prices of the service's model.
+func (s *Service) Pricing() (llm.Pricing, bool) {
+	switch cmp.Or(s.Model, DefaultModel) {
+	case Claude35Sonnet, Claude37Sonnet, Claude4Sonnet, Claude45Sonnet:
+		return llm.Pricing{Input: 3, Output: 15, CacheRead: 0.30, CacheWrite: 3.75}, true
+	case Claude35Haiku:
+		return llm.Pricing{Input: 0.80, Output: 4, CacheRead: 0.08, CacheWrite: 1}, true
+	case Claude4Opus:
+		return llm.Pricing{Input: 15, Output: 75, CacheRead: 1.50, CacheWrite: 18.75}, true
+	default:
+		return llm.Pricing{}, false
+	}
+}
+
 // Service provides Claude completions.
 // Fields should not be altered concurrently with calling any method on Service.
 type Service struct {
-	HTTPC     *http.Client // defaults to http.DefaultClient if nil
-	URL       string       // defaults to DefaultURL if empty
-	APIKey    string       // must be non-empty
-	Model     string       // defaults to DefaultModel if empty
-	MaxTokens int          // defaults to DefaultMaxTokens if zero
-	DumpLLM   bool         // whether to dump request/response text to files for debugging; defaults to false
+	HTTPC       *http.Client // defaults to http.DefaultClient if nil
+	URL         string       // defaults to DefaultURL if empty
+	APIKey      string       // must be non-empty unless TokenSource is set
+	TokenSource TokenSource  // if set, authenticates with OAuth access tokens instead of APIKey
+	Model       string       // defaults to DefaultModel if empty
+	Shaping     llm.Shaping  // request settings; see RequestShaping for the defaults
+	DumpLLM     bool         // whether to dump request/response text to files for debugging; defaults to false
 }
 
 var _ llm.Service = (*Service)(nil)
@@ -221,14 +238,20 @@ type request struct {
 	Tools         []*tool         `json:"tools,omitempty"`
 	Stream        bool            `json:"stream,omitempty"`
 	System        []systemContent `json:"system,omitempty"`
-	Temperature   float64         `json:"temperature,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
 	TopK          int             `json:"top_k,omitempty"`
-	TopP          float64         `json:"top_p,omitempty"`
+	TopP          *float64        `json:"top_p,omitempty"`
 	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Thinking      *thinking       `json:"thinking,omitempty"`
 
 	TokenEfficientToolUse bool `json:"-"` // DO NOT USE, broken on Anthropic's side as of 2025-02-28
 }
 
+type thinking struct {
+	Type         string `json:"type"` // "enabled"
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
 func mapped[Slice ~[]E, E, T any](s Slice, f func(E) T) []T {
 	out := make([]T, len(s))
 	for i, v := range s {
@@ -373,15 +396,43 @@ func fromLLMSystem(s llm.SystemContent) systemContent {
 	}
 }
 
+// RequestShaping reports the settings of the service's requests. Claude has no
+// reasoning effort; it thinks for at least MinThinkingBudget tokens when given
+// a thinking budget, and may by default reply with DefaultMaxTokens tokens
+// after thinking.
+func (s *Service) RequestShaping() llm.Shaping {
+	sh := s.Shaping
+	sh.ReasoningEffort = ""
+	if sh.ThinkingBudget > 0 {
+		sh.ThinkingBudget = max(sh.ThinkingBudget, MinThinkingBudget)
+	}
+	if sh.MaxTokens == 0 {
+		sh.MaxTokens = DefaultMaxTokens + sh.ThinkingBudget
+	}
+	return sh
+}
+
 func (s *Service) fromLLMRequest(r *llm.Request) *request {
-	return &request{
-		Model:      cmp.Or(s.Model, DefaultModel),
-		Messages:   mapped(r.Messages, fromLLMMessage),
-		MaxTokens:  cmp.Or(s.MaxTokens, DefaultMaxTokens),
-		ToolChoice: fromLLMToolChoice(r.ToolChoice),
-		Tools:      mapped(r.Tools, fromLLMTool),
-		System:     mapped(r.System, fromLLMSystem),
+	sh := s.RequestShaping()
+	req := &request{
+		Model:         cmp.Or(s.Model, DefaultModel),
+		Messages:      mapped(r.Messages, fromLLMMessage),
+		MaxTokens:     sh.MaxTokens,
+		ToolChoice:    fromLLMToolChoice(r.ToolChoice),
+		Tools:         mapped(r.Tools, fromLLMTool),
+		System:        mapped(r.System, fromLLMSystem),
+		StopSequences: sh.Stop,
+	}
+	// Claude can't think when made to use a tool, and takes no sampling
+	// parameters while thinking.
+	forced := r.ToolChoice != nil && r.ToolChoice.Type != llm.ToolChoiceTypeAuto && r.ToolChoice.Type != llm.ToolChoiceTypeNone
+	if sh.ThinkingBudget > 0 && !forced {
+		req.Thinking = &thinking{Type: "enabled", BudgetTokens: sh.ThinkingBudget}
+	} else if r.Sampling != nil {
+		req.Temperature = r.Sampling.Temperature
+		req.TopP = r.Sampling.TopP
 	}
+	return req
 }
 
 func toLLMUsage(u usage) llm.Usage {
@@ -485,10 +536,19 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		}
 
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("X-API-Key", s.APIKey)
 		req.Header.Set("Anthropic-Version", "2023-06-01")
 
 		var features []string
+		if s.TokenSource != nil {
+			tok, err := s.TokenSource.Token(ctx)
+			if err != nil {
+				return nil, errors.Join(errs, err)
+			}
+			req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+			features = append(features, OAuthBeta)
+		} else {
+			req.Header.Set("X-API-Key", s.APIKey)
+		}
 		if request.TokenEfficientToolUse {
 			features = append(features, "token-efficient-tool-use-2025-02-19")
 		}
@@ -528,7 +588,8 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 			if err != nil {
 				return nil, errors.Join(errs, err)
 			}
-			if response.StopReason == "max_tokens" && !largerMaxTokens {
+			// A max_tokens the user set is a limit, not a guess.
+			if response.StopReason == "max_tokens" && !largerMaxTokens && s.Shaping.MaxTokens == 0 {
 				slog.InfoContext(ctx, "anthropic_retrying_with_larger_tokens", "message", "Retrying Anthropic API call with larger max tokens size")
 				// Retry with more output tokens.
 				largerMaxTokens = true
@@ -0,0 +1,53 @@
+package ant
+
+import (
+	"slices"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestRequestShaping(t *testing.T) {
+	sampling := &llm.Sampling{Temperature: new(float64)}
+	tests := []struct {
+		name         string
+		shaping      llm.Shaping
+		toolChoice   *llm.ToolChoice
+		wantMax      int
+		wantThinking int // budget_tokens; 0 for no thinking
+	}{
+		{name: "defaults", wantMax: DefaultMaxTokens},
+		{name: "max tokens", shaping: llm.Shaping{MaxTokens: 20000}, wantMax: 20000},
+		{name: "thinking", shaping: llm.Shaping{ThinkingBudget: 4000}, wantMax: DefaultMaxTokens + 4000, wantThinking: 4000},
+		{name: "small budget", shaping: llm.Shaping{ThinkingBudget: 100, MaxTokens: 2000}, wantMax: 2000, wantThinking: MinThinkingBudget},
+		{name: "forced tool", shaping: llm.Shaping{ThinkingBudget: 4000}, toolChoice: &llm.ToolChoice{Type: llm.ToolChoiceTypeAny}, wantMax: DefaultMaxTokens + 4000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.shaping.Stop = []string{"END"}
+			tt.shaping.ReasoningEffort = "high"
+			s := &Service{Shaping: tt.shaping}
+			if got := s.RequestShaping().ReasoningEffort; got != "" {
+				t.Errorf("RequestShaping kept reasoning effort %q", got)
+			}
+			req := s.fromLLMRequest(&llm.Request{ToolChoice: tt.toolChoice, Sampling: sampling})
+			if req.MaxTokens != tt.wantMax {
+				t.Errorf("max_tokens = %d, want %d", req.MaxTokens, tt.wantMax)
+			}
+			if !slices.Equal(req.StopSequences, []string{"END"}) {
+				t.Errorf("stop_sequences = %q, want [END]", req.StopSequences)
+			}
+			budget := 0
+			if req.Thinking != nil {
+				budget = req.Thinking.BudgetTokens
+			}
+			if budget != tt.wantThinking {
+				t.Errorf("thinking budget = %d, want %d", budget, tt.wantThinking)
+			}
+			// Claude rejects sampling parameters while thinking.
+			if sent := req.Temperature != nil; sent == (budget > 0) {
+				t.Errorf("temperature sent = %v with thinking budget %d", sent, budget)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package ant
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OAuthBeta is the anthropic-beta feature required when authenticating with an OAuth access token.
+const OAuthBeta = "oauth-2025-04-20"
+
+// OAuthConfig describes the OAuth 2.0 device authorization (RFC 8628) endpoints.
+type OAuthConfig struct {
+	ClientID      string
+	DeviceAuthURL string
+	TokenURL      string
+	Scope         string
+}
+
+// OAuthClientIDEnv names the environment variable holding the OAuth client ID
+// registered with Anthropic for this sketch installation.
+const OAuthClientIDEnv = "SKETCH_ANTHROPIC_CLIENT_ID"
+
+// DefaultOAuthConfig is Anthropic's console login. Its device flow endpoints
+// are not documented, and sketch has no client registered with them: the
+// client ID is OAuthClientIDEnv's, and without one there is no login.
+var DefaultOAuthConfig = OAuthConfig{
+	ClientID:      os.Getenv(OAuthClientIDEnv),
+	DeviceAuthURL: "https://console.anthropic.com/v1/oauth/device/code",
+	TokenURL:      "https://console.anthropic.com/v1/oauth/token",
+	Scope:         "user:inference",
+}
+
+// OAuthToken is an OAuth access token and, when held by its owner, the refresh token that renews it.
+type OAuthToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// expiryMargin is how long before expiry a token is considered stale,
+// so that it doesn't expire mid-request.
+const expiryMargin = 5 * time.Minute
+
+// Valid reports whether t can be used for a request right now.
+func (t *OAuthToken) Valid() bool {
+	return t != nil && t.AccessToken != "" && time.Until(t.ExpiresAt) > expiryMargin
+}
+
+// A TokenSource supplies OAuth access tokens to Service, refreshing them as needed.
+type TokenSource interface {
+	Token(ctx context.Context) (*OAuthToken, error)
+}
+
+// DefaultOAuthTokenPath is where sketch stores Anthropic OAuth tokens.
+func DefaultOAuthTokenPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("no place for Anthropic credentials: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "sketch", "anthropic-oauth.json"), nil
+}
+
+// tokenResponse is the token endpoint's response, including RFC 8628 polling errors.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	ErrorDesc    string `json:"error_description"`
+}
+
+func postForm(ctx context.Context, httpc *http.Client, endpoint string, form url.Values, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return resp.StatusCode, fmt.Errorf("status %s: %s", resp.Status, body)
+	}
+	return resp.StatusCode, nil
+}
+
+func (tr *tokenResponse) token(now time.Time, previousRefresh string) *OAuthToken {
+	return &OAuthToken{
+		AccessToken: tr.AccessToken,
+		// Servers may or may not rotate refresh tokens.
+		RefreshToken: cmp.Or(tr.RefreshToken, previousRefresh),
+		ExpiresAt:    now.Add(time.Duration(tr.ExpiresIn) * time.Second),
+	}
+}
+
+// DeviceLogin runs the OAuth device authorization flow: it asks the user,
+// via w, to approve access in a browser, and polls until they do.
+func DeviceLogin(ctx context.Context, httpc *http.Client, cfg OAuthConfig, w io.Writer) (*OAuthToken, error) {
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("no OAuth client ID configured, set %s", OAuthClientIDEnv)
+	}
+	httpc = cmp.Or(httpc, http.DefaultClient)
+	var dev struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int    `json:"expires_in"`
+		Interval                int    `json:"interval"`
+	}
+	code, err := postForm(ctx, httpc, cfg.DeviceAuthURL, url.Values{"client_id": {cfg.ClientID}, "scope": {cfg.Scope}}, &dev)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+	if code != http.StatusOK || dev.DeviceCode == "" {
+		return nil, fmt.Errorf("device authorization request failed with status %d", code)
+	}
+
+	fmt.Fprintf(w, "To sign in to Anthropic, visit:\n\n  %s\n\nand enter the code: %s\n\n", cmp.Or(dev.VerificationURIComplete, dev.VerificationURI), dev.UserCode)
+
+	interval := time.Duration(cmp.Or(dev.Interval, 5)) * time.Second
+	deadline := time.Now().Add(time.Duration(cmp.Or(dev.ExpiresIn, 900)) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		var tr tokenResponse
+		_, err := postForm(ctx, httpc, cfg.TokenURL, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {dev.DeviceCode},
+			"client_id":   {cfg.ClientID},
+		}, &tr)
+		if err != nil {
+			return nil, fmt.Errorf("token request failed: %w", err)
+		}
+		switch tr.Error {
+		case "":
+			if tr.AccessToken == "" {
+				return nil, errors.New("token response had no access token")
+			}
+			return tr.token(time.Now(), ""), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("login failed: %s", cmp.Or(tr.ErrorDesc, tr.Error))
+		}
+	}
+	return nil, errors.New("login timed out waiting for approval")
+}
+
+// RefreshOAuthToken exchanges a refresh token for a new access token.
+func RefreshOAuthToken(ctx context.Context, httpc *http.Client, cfg OAuthConfig, refreshToken string) (*OAuthToken, error) {
+	var tr tokenResponse
+	code, err := postForm(ctx, cmp.Or(httpc, http.DefaultClient), cfg.TokenURL, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {cfg.ClientID},
+	}, &tr)
+	if err != nil {
+		return nil, fmt.Errorf("token refresh failed: %w", err)
+	}
+	if code != http.StatusOK || tr.AccessToken == "" {
+		return nil, fmt.Errorf("token refresh failed with status %d: %s", code, cmp.Or(tr.ErrorDesc, tr.Error))
+	}
+	return tr.token(time.Now(), refreshToken), nil
+}
+
+// FileTokenSource is a TokenSource backed by a token file written by SaveOAuthToken.
+// It refreshes the access token when it nears expiry and writes the result back.
+type FileTokenSource struct {
+	Path   string
+	Config OAuthConfig
+	HTTPC  *http.Client // defaults to http.DefaultClient if nil
+
+	mu  sync.Mutex
+	tok *OAuthToken
+}
+
+// Token implements TokenSource.
+func (s *FileTokenSource) Token(ctx context.Context) (*OAuthToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok == nil {
+		tok, err := LoadOAuthToken(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.tok = tok
+	}
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+	if s.tok.RefreshToken == "" {
+		return nil, errors.New("anthropic login expired; run sketch -anthropic-login")
+	}
+	tok, err := RefreshOAuthToken(ctx, s.HTTPC, s.Config, s.tok.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveOAuthToken(s.Path, tok); err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// LoadOAuthToken reads a token saved by SaveOAuthToken.
+func LoadOAuthToken(path string) (*OAuthToken, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tok OAuthToken
+	if err := json.Unmarshal(content, &tok); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %w", path, err)
+	}
+	return &tok, nil
+}
+
+// SaveOAuthToken writes tok to path, readable only by the current user.
+func SaveOAuthToken(path string, tok *OAuthToken) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	content, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and rename, so a crash never leaves a truncated token file.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// HTTPTokenSource fetches access tokens from a URL that serves an OAuthToken as JSON.
+// Sketch containers use it to borrow tokens from the host, which alone holds the refresh token.
+type HTTPTokenSource struct {
+	URL   string
+	HTTPC *http.Client // defaults to http.DefaultClient if nil
+
+	mu  sync.Mutex
+	tok *OAuthToken
+}
+
+// Token implements TokenSource.
+func (s *HTTPTokenSource) Token(ctx context.Context) (*OAuthToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.Valid() {
+		return s.tok, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cmp.Or(s.HTTPC, http.DefaultClient).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching anthropic token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("fetching anthropic token: status %s: %s", resp.Status, body)
+	}
+	var tok OAuthToken
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("fetching anthropic token: %w", err)
+	}
+	s.tok = &tok
+	return s.tok, nil
+}
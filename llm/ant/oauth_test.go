@@ -0,0 +1,136 @@
+package ant
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestDeviceLogin(t *testing.T) {
+	polls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.URL.Path {
+		case "/device":
+			if r.Form.Get("client_id") != "test-client" {
+				t.Errorf("client_id = %q", r.Form.Get("client_id"))
+			}
+			io.WriteString(w, `{"device_code":"dev123","user_code":"ABCD-EFGH","verification_uri":"https://example.com/activate","interval":1}`)
+		case "/token":
+			if r.Form.Get("device_code") != "dev123" {
+				t.Errorf("device_code = %q", r.Form.Get("device_code"))
+			}
+			polls++
+			io.WriteString(w, `{"access_token":"at","refresh_token":"rt","expires_in":3600}`)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := OAuthConfig{ClientID: "test-client", DeviceAuthURL: srv.URL + "/device", TokenURL: srv.URL + "/token"}
+	var out strings.Builder
+	tok, err := DeviceLogin(context.Background(), srv.Client(), cfg, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "at" || tok.RefreshToken != "rt" || !tok.Valid() {
+		t.Errorf("token = %+v", tok)
+	}
+	if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), "https://example.com/activate") {
+		t.Errorf("login instructions = %q", out.String())
+	}
+	if polls != 1 {
+		t.Errorf("polled %d times, want 1", polls)
+	}
+
+	if _, err := DeviceLogin(context.Background(), srv.Client(), OAuthConfig{}, io.Discard); err == nil {
+		t.Error("expected error without a client ID")
+	}
+}
+
+func TestFileTokenSourceRefresh(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "old-rt" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		// No refresh_token in the response: the old one stays valid.
+		io.WriteString(w, `{"access_token":"new-at","expires_in":3600}`)
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "sketch", "oauth.json")
+	if err := SaveOAuthToken(path, &OAuthToken{AccessToken: "old-at", RefreshToken: "old-rt", ExpiresAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	src := &FileTokenSource{Path: path, Config: OAuthConfig{TokenURL: srv.URL}, HTTPC: srv.Client()}
+	tok, err := src.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "new-at" {
+		t.Errorf("access token = %q, want new-at", tok.AccessToken)
+	}
+
+	saved, err := LoadOAuthToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "new-at" || saved.RefreshToken != "old-rt" {
+		t.Errorf("saved token = %+v", saved)
+	}
+}
+
+type staticTokenSource string
+
+func (s staticTokenSource) Token(context.Context) (*OAuthToken, error) {
+	return &OAuthToken{AccessToken: string(s), ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func TestServiceOAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer at" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if got := r.Header.Get("X-API-Key"); got != "" {
+			t.Errorf("X-API-Key = %q, want none", got)
+		}
+		if got := r.Header.Get("anthropic-beta"); !strings.Contains(got, OAuthBeta) {
+			t.Errorf("anthropic-beta = %q", got)
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":          "msg_1",
+			"type":        "message",
+			"role":        "assistant",
+			"stop_reason": "end_turn",
+			"content":     []map[string]any{{"type": "text", "text": "hi"}},
+		})
+	}))
+	defer srv.Close()
+
+	svc := &Service{HTTPC: srv.Client(), URL: srv.URL, TokenSource: staticTokenSource("at")}
+	resp, err := svc.Do(context.Background(), &llm.Request{
+		Messages: []llm.Message{{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("hello")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "hi" {
+		t.Errorf("response content = %+v", resp.Content)
+	}
+}
+
+func TestDefaultOAuthTokenPathNoHome(t *testing.T) {
+	t.Setenv("HOME", "")
+	if path, err := DefaultOAuthTokenPath(); err == nil {
+		t.Errorf("without a home directory, the token path is %q", path)
+	}
+}
@@ -56,6 +56,7 @@ type Convo struct {
 	// Ctx is the context for the entire conversation.
 	Ctx context.Context
 	// Service is the LLM service to use.
+	// Once the conversation is under way, change it with SetService.
 	Service llm.Service
 	// Tools are the tools available during the conversation.
 	Tools []*llm.Tool
@@ -82,8 +83,19 @@ type Convo struct {
 	// Hidden indicates that the output of this conversation should be hidden in the UI.
 	// This is useful for subconversations that can generate noisy, uninteresting output.
 	Hidden bool
+	// Purpose is a short label for what the conversation is for, such as "slug",
+	// shown where the UI lays out the session's conversations. It is not inherited.
+	Purpose string
 	// ExtraData is extra data to make available to all tool calls.
 	ExtraData map[string]any
+	// ToolResultRefs gives large tool results a handle, such as {{tool_result:K3B-7QX:3}},
+	// which later tool inputs can use in place of the result itself,
+	// saving the model from copying it. Handles belong to the conversation,
+	// and only the latest few megabytes of results keep theirs.
+	ToolResultRefs bool
+	// SlimTools sends each tool with only the first sentence of its
+	// descriptions, along with tool_help, which returns the full ones.
+	SlimTools bool
 
 	// messages tracks the messages so far in the conversation.
 	messages []llm.Message
@@ -100,6 +112,10 @@ type Convo struct {
 	usage *CumulativeUsage
 	// lastUsage tracks the usage from the most recent API call
 	lastUsage llm.Usage
+	// sampling is sent with every request; nil leaves it to the provider. Protected by mu.
+	sampling *llm.Sampling
+	// toolResults holds the results with a handle; see ToolResultRefs.
+	toolResults *toolResults
 }
 
 // newConvoID generates a new 8-byte random id.
@@ -131,6 +147,7 @@ func New(ctx context.Context, srv llm.Service, usage *CumulativeUsage) *Convo {
 		ID:            id,
 		toolUseCancel: map[string]context.CancelCauseFunc{},
 		mu:            &sync.Mutex{},
+		toolResults:   newToolResults(id),
 	}
 }
 
@@ -142,7 +159,7 @@ func (c *Convo) SubConvo() *Convo {
 	id := newConvoID()
 	return &Convo{
 		Ctx:           skribe.ContextWithAttr(c.Ctx, slog.String("convo_id", id), slog.String("parent_convo_id", c.ID)),
-		Service:       c.Service,
+		Service:       c.service(),
 		PromptCaching: c.PromptCaching,
 		Parent:        c,
 		// For convenience, sub-convo usage shares tool uses map with parent,
@@ -152,6 +169,8 @@ func (c *Convo) SubConvo() *Convo {
 		Listener:      c.Listener,
 		ID:            id,
 		toolUseCancel: map[string]context.CancelCauseFunc{},
+		sampling:      c.Sampling(),
+		toolResults:   newToolResults(id),
 		// Do not copy Budget. Each budget is independent,
 		// and OverBudget checks whether any ancestor is over budget.
 	}
@@ -161,21 +180,86 @@ func (c *Convo) SubConvoWithHistory() *Convo {
 	id := newConvoID()
 	return &Convo{
 		Ctx:           skribe.ContextWithAttr(c.Ctx, slog.String("convo_id", id), slog.String("parent_convo_id", c.ID)),
-		Service:       c.Service,
+		Service:       c.service(),
 		PromptCaching: c.PromptCaching,
 		Parent:        c,
 		// For convenience, sub-convo usage shares tool uses map with parent,
 		// all other fields separate, propagated in AddResponse
-		usage:    newUsageWithSharedToolUses(c.usage),
-		mu:       c.mu,
-		Listener: c.Listener,
-		ID:       id,
+		usage:         newUsageWithSharedToolUses(c.usage),
+		mu:            c.mu,
+		Listener:      c.Listener,
+		ID:            id,
+		toolUseCancel: map[string]context.CancelCauseFunc{},
+		sampling:      c.Sampling(),
 		// Do not copy Budget. Each budget is independent,
 		// and OverBudget checks whether any ancestor is over budget.
 		messages: slices.Clone(c.messages),
+		// The history may refer to the parent's tool result handles.
+		ToolResultRefs: c.ToolResultRefs,
+		toolResults:    c.toolResults,
 	}
 }
 
+// SetSampling sets the sampling parameters of the conversation's requests from
+// the next one on; nil goes back to the provider's defaults.
+// Sub-conversations started afterwards inherit them.
+func (c *Convo) SetSampling(s *llm.Sampling) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sampling = s
+}
+
+// SetService switches the conversation to srv from the next request on,
+// such as to change models mid-conversation.
+// Sub-conversations started afterwards use it too.
+func (c *Convo) SetService(srv llm.Service) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Service = srv
+}
+
+func (c *Convo) service() llm.Service {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Service
+}
+
+// Sampling returns the conversation's sampling parameters, nil if unset.
+func (c *Convo) Sampling() *llm.Sampling {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sampling
+}
+
+// SetMessages replaces the conversation's history, such as to resume a recorded session.
+func (c *Convo) SetMessages(msgs []llm.Message) {
+	c.messages = slices.Clone(msgs)
+}
+
+// DropToolResults replaces the content of the tool results in all but the last
+// keepLast messages with placeholder, to shrink the context while keeping every
+// tool use paired with its result. It returns how many results were dropped.
+func (c *Convo) DropToolResults(keepLast int, placeholder string) int {
+	dropped := 0
+	for i := range max(len(c.messages)-keepLast, 0) {
+		m := &c.messages[i]
+		cloned := false
+		for j, content := range m.Content {
+			if content.Type != llm.ContentTypeToolResult || len(content.ToolResult) == 1 && content.ToolResult[0].Text == placeholder {
+				continue
+			}
+			if !cloned {
+				// The content may be shared with subconversations, which keep theirs.
+				m.Content = slices.Clone(m.Content)
+				cloned = true
+			}
+			m.Content[j].ToolResult = llm.TextContent(placeholder)
+			dropped++
+		}
+	}
+	return dropped
+}
+
 // Depth reports how many "sub-conversations" deep this conversation is.
 // That it, it walks up parents until it finds a root.
 func (c *Convo) Depth() int {
@@ -228,7 +312,8 @@ func (c *Convo) messageRequest(msg llm.Message) *llm.Request {
 	mr := &llm.Request{
 		Messages: append(nonEmptyMessages, msg), // not yet committed to keeping msg
 		System:   system,
-		Tools:    c.Tools,
+		Tools:    c.requestTools(),
+		Sampling: c.Sampling(),
 	}
 	if c.ToolUseOnly {
 		mr.ToolChoice = &llm.ToolChoice{Type: llm.ToolChoiceTypeAny}
@@ -242,6 +327,9 @@ func (c *Convo) findTool(name string) (*llm.Tool, error) {
 			return tool, nil
 		}
 	}
+	if c.SlimTools && name == ToolHelpName {
+		return c.toolHelp(), nil
+	}
 	return nil, fmt.Errorf("tool %q not found", name)
 }
 
@@ -296,8 +384,22 @@ func (c *Convo) insertMissingToolResults(mr *llm.Request, msg *llm.Message) {
 // SendMessage sends a message to Claude.
 // The conversation records (internally) all messages succesfully sent and received.
 func (c *Convo) SendMessage(msg llm.Message) (*llm.Response, error) {
+	return c.sendMessage(msg, nil)
+}
+
+// SendMessageWithoutTools sends msg as SendMessage does, but with tool_choice
+// none, so that the reply is text only. The tools are still sent, for the
+// tool uses earlier in the conversation.
+func (c *Convo) SendMessageWithoutTools(msg llm.Message) (*llm.Response, error) {
+	return c.sendMessage(msg, &llm.ToolChoice{Type: llm.ToolChoiceTypeNone})
+}
+
+func (c *Convo) sendMessage(msg llm.Message, toolChoice *llm.ToolChoice) (*llm.Response, error) {
 	id := ulid.Make().String()
 	mr := c.messageRequest(msg)
+	if toolChoice != nil {
+		mr.ToolChoice = toolChoice
+	}
 	var lastMessage *llm.Message
 	if c.PromptCaching {
 		lastMessage = &mr.Messages[len(mr.Messages)-1]
@@ -317,7 +419,7 @@ func (c *Convo) SendMessage(msg llm.Message) (*llm.Response, error) {
 	c.Listener.OnRequest(c.Ctx, c, id, &msg)
 
 	startTime := time.Now()
-	resp, err := c.Service.Do(c.Ctx, mr)
+	resp, err := c.service().Do(c.Ctx, mr)
 	if resp != nil {
 		resp.StartTime = &startTime
 		endTime := time.Now()
@@ -464,6 +566,9 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 				endTime := time.Now()
 				content.ToolUseEndTime = &endTime
 
+				if c.ToolResultRefs {
+					toolOut.LLMContent = c.toolResults.record(toolOut.LLMContent)
+				}
 				content.ToolResult = toolOut.LLMContent
 				content.Display = toolOut.Display
 				var firstText string
@@ -485,7 +590,14 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 			defer cancel()
 			// TODO: move this into newToolUseContext?
 			toolUseCtx = context.WithValue(toolUseCtx, toolCallInfoKey, ToolCallInfo{ToolUseID: part.ID, Convo: c})
-			toolOut := tool.Run(toolUseCtx, part.ToolInput)
+			input := part.ToolInput
+			if c.ToolResultRefs {
+				if input, err = c.toolResults.resolve(input); err != nil {
+					sendErr(err)
+					return
+				}
+			}
+			toolOut := tool.Run(toolUseCtx, input)
 			if errors.Is(toolOut.Error, ErrDoNotRespond) {
 				return
 			}
@@ -495,6 +607,7 @@ func (c *Convo) ToolResultContents(ctx context.Context, resp *llm.Response) ([]l
 			}
 
 			if toolOut.Error != nil {
+				content.Display = toolOut.Display
 				sendErr(toolOut.Error)
 				return
 			}
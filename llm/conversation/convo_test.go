@@ -3,6 +3,7 @@ package conversation
 import (
 	"cmp"
 	"context"
+	"math"
 	"net/http"
 	"os"
 	"slices"
@@ -296,3 +297,61 @@ func TestInsertMissingToolResults(t *testing.T) {
 		})
 	}
 }
+
+type unpricedService struct{ llm.Service }
+
+func TestEstimate(t *testing.T) {
+	ctx := context.Background()
+	pending := []llm.Content{llm.StringContent(strings.Repeat("x", 4000))}
+
+	convo := New(ctx, &ant.Service{Model: ant.Claude4Sonnet}, nil)
+	convo.lastUsage = llm.Usage{CacheReadInputTokens: 99_000, OutputTokens: 1000}
+	est := convo.Estimate(pending)
+	if est.ContextTokens != 100_000 || est.NewTokens != 1000 || est.OutputTokens != 1024 || !est.Priced {
+		t.Fatalf("Estimate = %+v", est)
+	}
+	// 100k cached tokens at $0.30/M, 1k written to the cache at $3.75/M, 1024 out at $15/M.
+	if want := 0.03 + 0.00375 + 0.01536; math.Abs(est.CostUSD-want) > 1e-9 {
+		t.Errorf("CostUSD = %v, want %v", est.CostUSD, want)
+	}
+
+	convo = New(ctx, unpricedService{}, nil)
+	if est := convo.Estimate(pending); est.Priced || est.CostUSD != 0 {
+		t.Errorf("unpriced service, nothing spent: %+v", est)
+	}
+	convo.usage.Add(llm.Usage{InputTokens: 9000, OutputTokens: 1000, CostUSD: 0.10})
+	convo.lastUsage = llm.Usage{InputTokens: 9000, OutputTokens: 1000}
+	est = convo.Estimate(pending)
+	// 10k context + 1k new + 1k out, at the $0.10 per 10k tokens spent so far.
+	if want := 0.12; !est.Priced || math.Abs(est.CostUSD-want) > 1e-9 {
+		t.Errorf("unpriced service, observed rate: %+v, want $%v", est, want)
+	}
+}
+
+func TestDropToolResults(t *testing.T) {
+	result := func(id, text string) llm.Message {
+		return llm.Message{Role: llm.MessageRoleUser, Content: []llm.Content{
+			{Type: llm.ContentTypeToolResult, ToolUseID: id, ToolResult: llm.TextContent(text)},
+			{Type: llm.ContentTypeText, Text: "note"},
+		}}
+	}
+	c := New(context.Background(), nil, nil)
+	c.SetMessages([]llm.Message{result("a", "old output"), result("b", "older output"), result("c", "recent output")})
+	sub := c.SubConvoWithHistory()
+
+	if n := c.DropToolResults(1, "[dropped]"); n != 2 {
+		t.Errorf("DropToolResults dropped %d results, want 2", n)
+	}
+	if n := c.DropToolResults(1, "[dropped]"); n != 0 {
+		t.Errorf("dropping again dropped %d results, want 0", n)
+	}
+	for i, want := range []string{"[dropped]", "[dropped]", "recent output"} {
+		m := c.messages[i]
+		if got := m.Content[0].ToolResult[0].Text; got != want || m.Content[0].ToolUseID == "" || m.Content[1].Text != "note" {
+			t.Errorf("message %d = %+v, want tool result %q", i, m.Content, want)
+		}
+	}
+	if got := sub.messages[0].Content[0].ToolResult[0].Text; got != "old output" {
+		t.Errorf("subconversation's tool result changed to %q", got)
+	}
+}
@@ -0,0 +1,77 @@
+package conversation
+
+import (
+	"sketch.dev/llm"
+)
+
+// Estimate is a rough forecast of what the next request in a conversation will cost.
+type Estimate struct {
+	ContextTokens uint64  `json:"context_tokens"` // tokens already in the conversation
+	NewTokens     uint64  `json:"new_tokens"`     // tokens the request adds
+	OutputTokens  uint64  `json:"output_tokens"`  // tokens the reply is expected to have
+	CostUSD       float64 `json:"cost_usd"`
+	// Priced reports whether CostUSD is known. It is false when the model's
+	// prices are unknown and nothing has been spent yet to learn them from.
+	Priced bool `json:"priced"`
+}
+
+const (
+	bytesPerToken       = 4    // a common rule of thumb for English text and code
+	imageTokens         = 1600 // about what a full-size image costs on Claude
+	defaultOutputTokens = 1024 // expected reply length before the conversation shows otherwise
+)
+
+// EstimateTokens approximates the number of tokens contents take up.
+func EstimateTokens(contents []llm.Content) uint64 {
+	var n uint64
+	for _, c := range contents {
+		if c.MediaType != "" {
+			n += imageTokens
+			continue
+		}
+		n += uint64(len(c.Text)+len(c.Thinking)+len(c.ToolName)+len(c.ToolInput)) / bytesPerToken
+		n += EstimateTokens(c.ToolResult)
+	}
+	return n
+}
+
+// Estimate forecasts the cost of sending pending as the conversation's next message.
+// The conversation's context is taken from the most recent response's usage,
+// and prices come from the service or, failing that, from what has been spent so far.
+func (c *Convo) Estimate(pending []llm.Content) Estimate {
+	last := c.LastUsage()
+	usage := c.CumulativeUsage()
+
+	var est Estimate
+	if !last.IsZero() {
+		// The prior request's input, plus the reply to it.
+		est.ContextTokens = last.InputTokens + last.CacheReadInputTokens + last.CacheCreationInputTokens + last.OutputTokens
+	} else {
+		est.ContextTokens = uint64(len(c.SystemPrompt)) / bytesPerToken
+		for _, t := range c.requestTools() {
+			est.ContextTokens += toolTokens(t)
+		}
+	}
+	est.NewTokens = EstimateTokens(pending)
+	est.OutputTokens = defaultOutputTokens
+	if usage.Responses > 0 {
+		est.OutputTokens = usage.OutputTokens / usage.Responses
+	}
+
+	if p, ok := llm.PricingOf(c.service()); ok {
+		contextPrice, newPrice := p.Input, p.Input
+		if c.PromptCaching {
+			contextPrice, newPrice = p.CacheRead, p.CacheWrite
+		}
+		est.CostUSD = (float64(est.ContextTokens)*contextPrice + float64(est.NewTokens)*newPrice + float64(est.OutputTokens)*p.Output) / 1e6
+		est.Priced = true
+		return est
+	}
+	if spent := usage.TotalInputTokens() + usage.OutputTokens; usage.TotalCostUSD > 0 && spent > 0 {
+		// Assume the next tokens cost what past ones did on average.
+		perToken := usage.TotalCostUSD / float64(spent)
+		est.CostUSD = float64(est.ContextTokens+est.NewTokens+est.OutputTokens) * perToken
+		est.Priced = true
+	}
+	return est
+}
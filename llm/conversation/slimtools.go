@@ -0,0 +1,202 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// ToolHelpName is the name of the tool that returns the full description of
+// a tool whose description was slimmed; see Convo.SlimTools.
+const ToolHelpName = "tool_help"
+
+// maxSlimDescription is the longest a slimmed description gets, in bytes.
+const maxSlimDescription = 240
+
+// slimDescription returns the first sentence of the first paragraph of desc,
+// cut at a word to at most maxSlimDescription bytes. A sentence ends at a
+// period before a line break or a capital, so "e.g. go.mod" doesn't end one.
+func slimDescription(desc string) string {
+	desc = strings.TrimSpace(desc)
+	if i := strings.Index(desc, "\n\n"); i >= 0 {
+		desc = desc[:i]
+	}
+	for i := 0; i < len(desc)-1; i++ {
+		if desc[i] != '.' {
+			continue
+		}
+		if next := desc[i+1:]; next[0] == '\n' || len(next) > 1 && next[0] == ' ' && 'A' <= next[1] && next[1] <= 'Z' {
+			desc = desc[:i+1]
+			break
+		}
+	}
+	desc = strings.Join(strings.Fields(desc), " ")
+	if len(desc) <= maxSlimDescription {
+		return desc
+	}
+	cut := strings.LastIndexByte(desc[:maxSlimDescription], ' ')
+	if cut <= 0 {
+		cut = maxSlimDescription
+	}
+	return desc[:cut] + "…"
+}
+
+// slimSchema returns schema with the descriptions of its properties, at any
+// depth, slimmed, and whether any was.
+func slimSchema(schema json.RawMessage) (json.RawMessage, bool) {
+	var v map[string]any
+	if len(schema) == 0 || json.Unmarshal(schema, &v) != nil || !slimProperties(v) {
+		return schema, false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return schema, false
+	}
+	return b, true
+}
+
+func slimProperties(schema map[string]any) bool {
+	changed := false
+	if props, ok := schema["properties"].(map[string]any); ok {
+		for _, p := range props {
+			p, ok := p.(map[string]any)
+			if !ok {
+				continue
+			}
+			if d, ok := p["description"].(string); ok {
+				if s := slimDescription(d); len(s) < len(d) {
+					p["description"] = s
+					changed = true
+				}
+			}
+			changed = slimProperties(p) || changed
+		}
+	}
+	if items, ok := schema["items"].(map[string]any); ok {
+		changed = slimProperties(items) || changed
+	}
+	return changed
+}
+
+// slimTool returns t with its descriptions slimmed, or t itself when they are
+// already short. Tools with a Type are defined by the provider and kept as is.
+func slimTool(t *llm.Tool) *llm.Tool {
+	if t.Type != "" || t.Name == ToolHelpName {
+		return t
+	}
+	desc := slimDescription(t.Description)
+	schema, slimmed := slimSchema(t.InputSchema)
+	if len(desc) >= len(t.Description) {
+		if !slimmed {
+			return t
+		}
+		desc = t.Description
+	}
+	slim := *t
+	slim.Description, slim.InputSchema = desc, schema
+	return &slim
+}
+
+// requestTools returns the tools as the requests send them: slimmed, along
+// with tool_help, when SlimTools is set and any tool is slimmed.
+func (c *Convo) requestTools() []*llm.Tool {
+	if !c.SlimTools || len(c.Tools) == 0 {
+		return c.Tools
+	}
+	tools := make([]*llm.Tool, 0, len(c.Tools)+1)
+	slimmed := false
+	for _, t := range c.Tools {
+		s := slimTool(t)
+		slimmed = slimmed || s != t
+		tools = append(tools, s)
+	}
+	if !slimmed {
+		return c.Tools
+	}
+	return append(tools, c.toolHelp())
+}
+
+// toolHelp returns the tool_help tool, for the full descriptions of the conversation's tools.
+func (c *Convo) toolHelp() *llm.Tool {
+	return &llm.Tool{
+		Name: ToolHelpName,
+		Description: `Returns the full description and input schema of a tool. The descriptions of the other tools are abridged; ` +
+			`call this before using a tool for the first time or in a way its abridged description does not cover.`,
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "required": ["name"],
+  "properties": {
+    "name": {"type": "string", "description": "The name of the tool"}
+  }
+}`),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			var in struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(input, &in); err != nil {
+				return llm.ErrorfToolOut("invalid input: %w", err)
+			}
+			for _, t := range c.Tools {
+				if t.Name != in.Name {
+					continue
+				}
+				var b strings.Builder
+				fmt.Fprintf(&b, "# %s\n\n%s\n", t.Name, strings.TrimSpace(t.Description))
+				if len(t.InputSchema) > 0 {
+					fmt.Fprintf(&b, "\nInput schema:\n%s\n", t.InputSchema)
+				}
+				return llm.ToolOut{LLMContent: llm.TextContent(b.String())}
+			}
+			names := make([]string, len(c.Tools))
+			for i, t := range c.Tools {
+				names[i] = t.Name
+			}
+			return llm.ErrorfToolOut("no tool named %q; the tools are %s", in.Name, strings.Join(names, ", "))
+		},
+	}
+}
+
+// ToolSize is how many tokens a tool's definition takes up in each request.
+type ToolSize struct {
+	Name       string `json:"name"`
+	FullTokens uint64 `json:"full_tokens"` // with its full descriptions
+	SentTokens uint64 `json:"sent_tokens"` // as the requests send it
+}
+
+// ContextSize is a breakdown of the fixed part of each request, and the
+// messages, in estimated tokens.
+type ContextSize struct {
+	SystemTokens   uint64     `json:"system_tokens"`
+	Tools          []ToolSize `json:"tools"`
+	ToolTokens     uint64     `json:"tool_tokens"`      // as sent, tool_help included
+	FullToolTokens uint64     `json:"full_tool_tokens"` // without slimming
+	MessageTokens  uint64     `json:"message_tokens"`
+	SlimTools      bool       `json:"slim_tools"`
+}
+
+func toolTokens(t *llm.Tool) uint64 {
+	return uint64(len(t.Name)+len(t.Description)+len(t.InputSchema)) / bytesPerToken
+}
+
+// ContextSize estimates the tokens each request of the conversation spends
+// on its system prompt, on each tool, and on the messages so far.
+func (c *Convo) ContextSize() ContextSize {
+	cs := ContextSize{SystemTokens: uint64(len(c.SystemPrompt)) / bytesPerToken, SlimTools: c.SlimTools}
+	sent := c.requestTools()
+	for i, t := range sent {
+		size := ToolSize{Name: t.Name, SentTokens: toolTokens(t)}
+		if i < len(c.Tools) { // past them is tool_help
+			size.FullTokens = toolTokens(c.Tools[i])
+		}
+		cs.Tools = append(cs.Tools, size)
+		cs.ToolTokens += size.SentTokens
+		cs.FullToolTokens += size.FullTokens
+	}
+	for _, m := range c.messages {
+		cs.MessageTokens += EstimateTokens(m.Content)
+	}
+	return cs
+}
@@ -0,0 +1,85 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/ant"
+)
+
+func TestSlimDescription(t *testing.T) {
+	for desc, want := range map[string]string{
+		"Short.": "Short.",
+		"Runs a command. Use it for builds.\n\nMore": "Runs a command.",
+		"Edits files, e.g. go.mod.\nThen more":       "Edits files, e.g. go.mod.",
+		"No period\nacross lines\n\nsecond":          "No period across lines",
+	} {
+		if got := slimDescription(desc); got != want {
+			t.Errorf("slimDescription(%q) = %q, want %q", desc, got, want)
+		}
+	}
+	if got := slimDescription(strings.Repeat("word ", 100)); len(got) > maxSlimDescription+len("…") || !strings.HasSuffix(got, "word…") {
+		t.Errorf("a long sentence is cut to %q", got)
+	}
+}
+
+func TestSlimTools(t *testing.T) {
+	long := "Runs a bash command. " + strings.Repeat("It has many caveats. ", 50)
+	bash := &llm.Tool{
+		Name:        "bash",
+		Description: long,
+		InputSchema: llm.MustSchema(`{"type":"object","properties":{"command":{"type":"string","description":"The command. Quote carefully."}}}`),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			return llm.ToolOut{LLMContent: llm.TextContent("ok")}
+		},
+	}
+	think := &llm.Tool{Name: "think", Description: "Think out loud.", InputSchema: llm.EmptySchema()}
+	editor := &llm.Tool{Name: "str_replace_based_edit_tool", Type: "text_editor_20250728"}
+
+	convo := New(context.Background(), &ant.Service{}, nil)
+	convo.Tools = []*llm.Tool{bash, think, editor}
+	if tools := convo.messageRequest(llm.UserStringMessage("hi")).Tools; len(tools) != 3 || tools[0] != bash {
+		t.Fatalf("without SlimTools, the tools are sent as they are: %+v", tools)
+	}
+
+	convo.SlimTools = true
+	tools := convo.messageRequest(llm.UserStringMessage("hi")).Tools
+	if len(tools) != 4 || tools[3].Name != ToolHelpName {
+		t.Fatalf("tools = %+v, want tool_help last", tools)
+	}
+	if tools[0].Description != "Runs a bash command." || !strings.Contains(string(tools[0].InputSchema), `"description":"The command."`) {
+		t.Errorf("bash = %q %s", tools[0].Description, tools[0].InputSchema)
+	}
+	if tools[1] != think || tools[2] != editor {
+		t.Error("short and provider-defined tools were changed")
+	}
+	if bash.Description != long {
+		t.Error("slimming changed the tool itself")
+	}
+
+	resp := &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+		{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: ToolHelpName, ToolInput: json.RawMessage(`{"name":"bash"}`)},
+		{Type: llm.ContentTypeToolUse, ID: "t2", ToolName: ToolHelpName, ToolInput: json.RawMessage(`{"name":"nope"}`)},
+	}}
+	results, _, err := convo.ToolResultContents(context.Background(), resp)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("%v, %v", results, err)
+	}
+	if results[0].ToolUseID != "t1" {
+		results[0], results[1] = results[1], results[0]
+	}
+	if help := results[0].ToolResult[0].Text; !strings.Contains(help, "It has many caveats.") || !strings.Contains(help, "Quote carefully.") {
+		t.Errorf("tool_help bash = %q, want the full description and schema", help)
+	}
+	if !results[1].ToolError || !strings.Contains(results[1].ToolResult[0].Text, "the tools are bash, think") {
+		t.Errorf("tool_help nope = %+v", results[1])
+	}
+
+	cs := convo.ContextSize()
+	if len(cs.Tools) != 4 || cs.Tools[0].SentTokens >= cs.Tools[0].FullTokens || cs.ToolTokens >= cs.FullToolTokens {
+		t.Errorf("context size = %+v", cs)
+	}
+}
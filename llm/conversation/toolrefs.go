@@ -0,0 +1,128 @@
+package conversation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sketch.dev/llm"
+)
+
+// minRefSize is the size from which a tool result gets a handle:
+// smaller ones cost little for the model to repeat.
+const minRefSize = 1024
+
+// maxRefBytes bounds the text kept for handles; the oldest results go first.
+const maxRefBytes = 4 << 20
+
+// toolResultRef matches a tool result handle, as in {{tool_result:K3B-7QX:3}}.
+// The number alone, as in {{tool_result:3}}, matches too, so that it fails as
+// unknown instead of reaching the tool unreplaced.
+var toolResultRef = regexp.MustCompile(`\{\{tool_result:(?:([\w-]+):)?(\d+)\}\}`)
+
+// toolResults holds the text of the tool results that got a handle,
+// so that later tool inputs can refer to them instead of repeating them.
+type toolResults struct {
+	// id is in every handle, so a handle from another conversation, such as the
+	// one before a resume or a compaction, is unknown rather than another result.
+	id    string
+	mu    sync.Mutex
+	texts []string // handle first+i is texts[i]
+	first int
+	size  int // total length of texts
+}
+
+func newToolResults(id string) *toolResults {
+	return &toolResults{id: id, first: 1}
+}
+
+// record stores the text of a large result and returns its contents
+// followed by a note telling the model its handle.
+func (r *toolResults) record(contents []llm.Content) []llm.Content {
+	var text strings.Builder
+	for _, c := range contents {
+		if c.Type == llm.ContentTypeText && c.MediaType == "" {
+			text.WriteString(c.Text)
+		}
+	}
+	if text.Len() < minRefSize {
+		return contents
+	}
+	r.mu.Lock()
+	r.texts = append(r.texts, text.String())
+	r.size += text.Len()
+	for r.size > maxRefBytes && len(r.texts) > 1 {
+		r.size -= len(r.texts[0])
+		r.texts[0] = ""
+		r.texts = r.texts[1:]
+		r.first++
+	}
+	n := r.first + len(r.texts) - 1
+	r.mu.Unlock()
+	note := fmt.Sprintf("(This output is %d bytes. To pass it to another tool, write {{tool_result:%s:%d}} in that tool's input instead of copying it; it is replaced by the output verbatim.)", text.Len(), r.id, n)
+	return append(contents, llm.Content{Type: llm.ContentTypeText, Text: note})
+}
+
+// resolve replaces the handles in the strings of a tool's input with the results they refer to.
+func (r *toolResults) resolve(input json.RawMessage) (json.RawMessage, error) {
+	if !toolResultRef.Match(input) {
+		return input, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("invalid tool input: %w", err)
+	}
+	r.mu.Lock()
+	v, err := r.replace(v)
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (r *toolResults) replace(v any) (any, error) {
+	switch v := v.(type) {
+	case string:
+		var err error
+		s := toolResultRef.ReplaceAllStringFunc(v, func(ref string) string {
+			m := toolResultRef.FindStringSubmatch(ref)
+			n, _ := strconv.Atoi(m[2])
+			if m[1] != r.id || n < r.first || n >= r.first+len(r.texts) {
+				err = fmt.Errorf("unknown tool result handle %s", ref)
+				return ref
+			}
+			return r.texts[n-r.first]
+		})
+		return s, err
+	case []any:
+		for i, e := range v {
+			e, err := r.replace(e)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+	case map[string]any:
+		for k, e := range v {
+			e, err := r.replace(e)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,94 @@
+package conversation
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/ant"
+)
+
+func TestToolResultRefs(t *testing.T) {
+	diff := strings.Repeat("+ added <line>\n", 100)
+	var selected string
+	convo := New(context.Background(), &ant.Service{}, nil)
+	convo.ToolResultRefs = true
+	convo.Tools = []*llm.Tool{
+		{Name: "diff", Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			return llm.ToolOut{LLMContent: llm.TextContent(diff)}
+		}},
+		{Name: "select_tests", Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			var in struct {
+				Diffs []string `json:"diffs"`
+				Limit int      `json:"limit"`
+			}
+			if err := json.Unmarshal(input, &in); err != nil {
+				return llm.ErrorToolOut(err)
+			}
+			selected = strings.Join(in.Diffs, "|")
+			return llm.ToolOut{LLMContent: llm.TextContent("ok")}
+		}},
+	}
+	run := func(name, input string) llm.Content {
+		t.Helper()
+		resp := &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+			{Type: llm.ContentTypeToolUse, ID: "t-" + name, ToolName: name, ToolInput: json.RawMessage(input)},
+		}}
+		results, _, err := convo.ToolResultContents(context.Background(), resp)
+		if err != nil || len(results) != 1 {
+			t.Fatalf("%s: %v, %v", name, results, err)
+		}
+		return results[0]
+	}
+
+	handle := "{{tool_result:" + convo.ID + ":1}}"
+	res := run("diff", `{}`)
+	if len(res.ToolResult) != 2 || !strings.Contains(res.ToolResult[1].Text, handle) {
+		t.Fatalf("large result = %+v, want a handle", res.ToolResult)
+	}
+	if res := run("select_tests", `{}`); len(res.ToolResult) != 1 {
+		t.Errorf("small result = %+v, want no handle", res.ToolResult)
+	}
+
+	run("select_tests", `{"diffs": ["`+handle+`", "before `+handle+`"], "limit": 3}`)
+	if selected != diff+"|before "+diff {
+		t.Errorf("select_tests got %q", selected)
+	}
+	for _, unknown := range []string{
+		"{{tool_result:" + convo.ID + ":9}}",
+		"{{tool_result:1}}",
+		"{{tool_result:ABC-DEF:1}}", // another conversation's, as before a resume
+	} {
+		if res := run("select_tests", `{"diffs": ["`+unknown+`"]}`); !res.ToolError || !strings.Contains(res.ToolResult[0].Text, "unknown tool result handle") {
+			t.Errorf("unknown handle %s = %+v", unknown, res)
+		}
+	}
+
+	// Sub-conversations continuing the history can use its handles.
+	sub := convo.SubConvoWithHistory()
+	sub.Tools = convo.Tools
+	convo = sub
+	run("select_tests", `{"diffs": ["`+handle+`"]}`)
+	if selected != diff {
+		t.Errorf("sub-conversation: select_tests got %q", selected)
+	}
+}
+
+func TestToolResultsDropOldest(t *testing.T) {
+	r := newToolResults("ABC-DEF")
+	big := strings.Repeat("x", maxRefBytes/3+1)
+	for range 3 {
+		r.record(llm.TextContent(big))
+	}
+	if _, err := r.resolve(json.RawMessage(`"{{tool_result:ABC-DEF:1}}"`)); err == nil {
+		t.Error("the oldest result outlived the limit")
+	}
+	if out, err := r.resolve(json.RawMessage(`"{{tool_result:ABC-DEF:3}}"`)); err != nil || len(out) != len(big)+2 {
+		t.Errorf("latest result: %d bytes, %v", len(out), err)
+	}
+	if r.size > maxRefBytes {
+		t.Errorf("kept %d bytes", r.size)
+	}
+}
@@ -27,6 +27,7 @@ type Service struct {
 	URL     string       // Gemini API URL, uses the gemini package default if empty
 	APIKey  string       // must be non-empty
 	Model   string       // defaults to DefaultModel if empty
+	Shaping llm.Shaping  // request settings; see RequestShaping for the defaults
 	DumpLLM bool         // whether to dump request/response text to files for debugging; defaults to false
 }
 
@@ -295,6 +296,20 @@ func (s *Service) buildGeminiRequest(req *llm.Request) (*gemini.Request, error)
 		}
 	}
 
+	sh := s.RequestShaping()
+	gemReq.GenerationConfig = &gemini.GenerationConfig{
+		MaxOutputTokens: sh.MaxTokens,
+		StopSequences:   sh.Stop,
+	}
+	if sh.ThinkingBudget > 0 {
+		gemReq.GenerationConfig.ThinkingConfig = &gemini.ThinkingConfig{ThinkingBudget: sh.ThinkingBudget}
+	}
+	if sm := req.Sampling; sm != nil {
+		gemReq.GenerationConfig.Temperature = sm.Temperature
+		gemReq.GenerationConfig.TopP = sm.TopP
+		gemReq.GenerationConfig.Seed = sm.Seed
+	}
+
 	return gemReq, nil
 }
 
@@ -461,6 +476,26 @@ func (s *Service) TokenContextWindow() int {
 	}
 }
 
+// RequestShaping reports the settings of the service's requests. Gemini has no
+// reasoning effort, and only 2.5 models think; left without a thinking budget,
+// they decide how long to think themselves.
+func (s *Service) RequestShaping() llm.Shaping {
+	thinks := strings.HasPrefix(cmp.Or(s.Model, DefaultModel), "gemini-2.5-")
+	sh := s.Shaping
+	sh.ReasoningEffort = ""
+	switch {
+	case sh.MaxTokens != 0:
+	case thinks:
+		sh.MaxTokens = 65536
+	default:
+		sh.MaxTokens = 8192
+	}
+	if !thinks {
+		sh.ThinkingBudget = 0
+	}
+	return sh
+}
+
 // Do sends a request to Gemini.
 func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
 	// Log the incoming request for debugging
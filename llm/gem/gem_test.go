@@ -364,3 +364,33 @@ func TestHeaderCostIntegration(t *testing.T) {
 		t.Fatalf("Expected output tokens to be estimated, got 0")
 	}
 }
+
+func TestBuildGeminiRequestShaping(t *testing.T) {
+	tests := []struct {
+		model        string
+		wantMax      int
+		wantThinking bool
+	}{
+		{model: DefaultModel, wantMax: 65536, wantThinking: true},
+		{model: "gemini-1.5-pro", wantMax: 8192},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			s := &Service{Model: tt.model, Shaping: llm.Shaping{Stop: []string{"END"}, ThinkingBudget: 2048}}
+			gemReq, err := s.buildGeminiRequest(&llm.Request{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gc := gemReq.GenerationConfig
+			if gc.MaxOutputTokens != tt.wantMax {
+				t.Errorf("maxOutputTokens = %d, want %d", gc.MaxOutputTokens, tt.wantMax)
+			}
+			if len(gc.StopSequences) != 1 || gc.StopSequences[0] != "END" {
+				t.Errorf("stopSequences = %q, want [END]", gc.StopSequences)
+			}
+			if thinking := gc.ThinkingConfig != nil; thinking != tt.wantThinking {
+				t.Errorf("thinkingConfig sent = %v, want %v", thinking, tt.wantThinking)
+			}
+		})
+	}
+}
@@ -33,6 +33,27 @@ func UseSimplifiedPatch(svc Service) bool {
 	return false
 }
 
+// Pricing is what a model charges, in dollars per million tokens.
+type Pricing struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+type Pricer interface {
+	// Pricing reports the prices of the service's model, if known.
+	Pricing() (Pricing, bool)
+}
+
+// PricingOf returns the prices of svc's model, if svc knows them.
+func PricingOf(svc Service) (Pricing, bool) {
+	if p, ok := svc.(Pricer); ok {
+		return p.Pricing()
+	}
+	return Pricing{}, false
+}
+
 // MustSchema validates that schema is a valid JSON schema and returns it as a json.RawMessage.
 // It panics if the schema is invalid.
 // The schema must have at least type="object" and a properties key.
@@ -61,6 +82,7 @@ type Request struct {
 	ToolChoice *ToolChoice
 	Tools      []*Tool
 	System     []SystemContent
+	Sampling   *Sampling // nil leaves sampling to the provider's defaults
 }
 
 // Message represents a message in the conversation.
@@ -0,0 +1,73 @@
+package oai
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// AzurePrefix starts the user name of a model served by an Azure OpenAI
+	// deployment, as in azure:gpt-4.1 for the deployment named gpt-4.1.
+	AzurePrefix = "azure:"
+	// DefaultAzureAPIVersion is the Azure OpenAI API version requests use
+	// unless the endpoint's URL names one, as in ?api-version=2024-10-21.
+	// It takes the reasoning models' parameters, such as reasoning_effort.
+	DefaultAzureAPIVersion = "2025-04-01-preview"
+
+	AzureAPIKeyEnv   = "AZURE_OPENAI_API_KEY"
+	AzureEndpointEnv = "AZURE_OPENAI_ENDPOINT" // e.g. https://NAME.openai.azure.com
+)
+
+// modelDate matches the date at the end of a dated model name, e.g. gpt-4.1-2025-04-14.
+var modelDate = regexp.MustCompile(`-\d{4}-\d{2}-\d{2}$`)
+
+// AzureModel returns the model served by the Azure OpenAI deployment named
+// deployment. A deployment named after an OpenAI model, with or without its
+// date, as it usually is, gets that model's settings; any other is taken
+// for a chat model with the defaults.
+func AzureModel(deployment string) Model {
+	m := Model{ModelName: deployment}
+	for _, known := range ModelsRegistry {
+		if known.URL != OpenAIURL {
+			continue
+		}
+		if known.ModelName == deployment || known.UserName == deployment || modelDate.ReplaceAllString(known.ModelName, "") == deployment {
+			m = known
+			break
+		}
+	}
+	m.UserName = AzurePrefix + deployment
+	m.URL = "" // the endpoint of the user's resource
+	m.APIKeyEnv = AzureAPIKeyEnv
+	m.Deployment = deployment
+	return m
+}
+
+// azureConfig returns the client configuration for the deployment at endpoint,
+// the URL of the Azure OpenAI resource, by default with DefaultAzureAPIVersion.
+func azureConfig(apiKey, endpoint, deployment string) (openai.ClientConfig, error) {
+	if endpoint == "" {
+		return openai.ClientConfig{}, fmt.Errorf("no endpoint for Azure OpenAI deployment %s; set %s or -llm-api-base", deployment, AzureEndpointEnv)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return openai.ClientConfig{}, fmt.Errorf("azure openai endpoint: %w", err)
+	}
+	version := u.Query().Get("api-version")
+	if version == "" {
+		version = DefaultAzureAPIVersion
+	}
+	u.RawQuery = ""
+	// The client adds /openai/deployments/NAME itself.
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/openai")
+
+	config := openai.DefaultAzureConfig(apiKey, u.String())
+	config.APIVersion = version
+	// The default mapper drops the dots of model names, as in gpt-4.1; deployment names keep theirs.
+	config.AzureModelMapperFunc = func(string) string { return deployment }
+	return config, nil
+}
@@ -0,0 +1,77 @@
+package oai
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestAzureModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		modelName  string
+		reasoning  bool
+		contextWin int
+	}{
+		{"azure:gpt-4.1", "gpt-4.1-2025-04-14", false, 200000},
+		{"azure:o4-mini", "o4-mini-2025-04-16", true, 128000},
+		{"azure:gpt5", "gpt-5", false, 256000},
+		{"azure:prod-chat", "prod-chat", false, 128000},
+	}
+	for _, tt := range tests {
+		m := ModelByUserName(tt.name)
+		if m.UserName != tt.name || m.ModelName != tt.modelName || m.IsReasoningModel != tt.reasoning || m.APIKeyEnv != AzureAPIKeyEnv || m.URL != "" {
+			t.Errorf("%s: got %+v", tt.name, m)
+		}
+		if got := (&Service{Model: m}).TokenContextWindow(); got != tt.contextWin {
+			t.Errorf("%s: context window %d, want %d", tt.name, got, tt.contextWin)
+		}
+	}
+	if !ModelByUserName("azure:").IsZero() {
+		t.Error("azure: names a model")
+	}
+}
+
+func TestAzureDo(t *testing.T) {
+	var gotPath, gotVersion, gotKey, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotVersion = r.URL.Path, r.URL.Query().Get("api-version")
+		gotKey, gotAuth = r.Header.Get("api-key"), r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(map[string]any{
+			"id":      "chatcmpl-1",
+			"model":   "gpt-4.1",
+			"choices": []map[string]any{{"index": 0, "finish_reason": "stop", "message": map[string]any{"role": "assistant", "content": "hi"}}},
+			"usage":   map[string]any{"prompt_tokens": 5, "completion_tokens": 1},
+		})
+	}))
+	defer srv.Close()
+
+	for endpoint, version := range map[string]string{
+		srv.URL: DefaultAzureAPIVersion,
+		srv.URL + "/openai/?api-version=2024-10-21": "2024-10-21",
+	} {
+		s := &Service{Model: ModelByUserName("azure:gpt-4.1"), ModelURL: endpoint, APIKey: "azure-key"}
+		resp, err := s.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}})
+		if err != nil {
+			t.Fatalf("%s: %v", endpoint, err)
+		}
+		if len(resp.Content) != 1 || resp.Content[0].Text != "hi" {
+			t.Errorf("%s: response %+v", endpoint, resp)
+		}
+		if gotPath != "/openai/deployments/gpt-4.1/chat/completions" || gotVersion != version {
+			t.Errorf("%s: requested %s?api-version=%s", endpoint, gotPath, gotVersion)
+		}
+		if gotKey != "azure-key" || gotAuth != "" {
+			t.Errorf("%s: api-key %q, Authorization %q", endpoint, gotKey, gotAuth)
+		}
+	}
+
+	s := &Service{Model: ModelByUserName("azure:gpt-4.1"), APIKey: "azure-key"}
+	if _, err := s.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hello")}}); err == nil {
+		t.Error("a deployment without an endpoint was reached")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"math/rand/v2"
 	"net/http"
 	"strings"
@@ -18,6 +19,8 @@ import (
 
 const (
 	DefaultMaxTokens = 8192
+	// DefaultReasoningEffort is how hard reasoning models think unless told otherwise.
+	DefaultReasoningEffort = "medium"
 
 	OpenAIURL    = "https://api.openai.com/v1"
 	FireworksURL = "https://api.fireworks.ai/inference/v1"
@@ -45,6 +48,7 @@ type Model struct {
 	APIKeyEnv          string // environment variable name for the API key
 	IsReasoningModel   bool   // whether this model is a reasoning model (e.g. O3, O4-mini)
 	UseSimplifiedPatch bool   // whether to use the simplified patch input schema; defaults to false
+	Deployment         string // the Azure OpenAI deployment serving the model, if any; see AzureModel
 }
 
 var (
@@ -287,13 +291,13 @@ var (
 // Service provides chat completions.
 // Fields should not be altered concurrently with calling any method on Service.
 type Service struct {
-	HTTPC     *http.Client // defaults to http.DefaultClient if nil
-	APIKey    string       // optional, if not set will try to load from env var
-	Model     Model        // defaults to DefaultModel if zero value
-	ModelURL  string       // optional, overrides Model.URL
-	MaxTokens int          // defaults to DefaultMaxTokens if zero
-	Org       string       // optional - organization ID
-	DumpLLM   bool         // whether to dump request/response text to files for debugging; defaults to false
+	HTTPC    *http.Client // defaults to http.DefaultClient if nil
+	APIKey   string       // optional, if not set will try to load from env var
+	Model    Model        // defaults to DefaultModel if zero value
+	ModelURL string       // optional, overrides Model.URL
+	Shaping  llm.Shaping  // request settings; see RequestShaping for the defaults
+	Org      string       // optional - organization ID
+	DumpLLM  bool         // whether to dump request/response text to files for debugging; defaults to false
 }
 
 var _ llm.Service = (*Service)(nil)
@@ -346,9 +350,13 @@ func ListModels() []string {
 	return names
 }
 
-// ModelByUserName returns a model by its user-friendly name.
-// Returns nil if no model with the given name is found.
+// ModelByUserName returns a model by its user-friendly name, including
+// azure:DEPLOYMENT for an Azure OpenAI deployment.
+// Returns the zero Model if no model with the given name is found.
 func ModelByUserName(name string) Model {
+	if deployment, ok := strings.CutPrefix(name, AzurePrefix); ok && deployment != "" {
+		return AzureModel(deployment)
+	}
 	for _, model := range ModelsRegistry {
 		if model.UserName == name {
 			return model
@@ -743,6 +751,22 @@ func (s *Service) TokenContextWindow() int {
 	}
 }
 
+// RequestShaping reports the settings of the service's requests. The models
+// that take max_completion_tokens are the reasoning ones, which take a
+// reasoning effort instead of stop sequences; none takes a thinking budget.
+func (s *Service) RequestShaping() llm.Shaping {
+	sh := s.Shaping
+	sh.ThinkingBudget = 0
+	sh.MaxTokens = cmp.Or(sh.MaxTokens, DefaultMaxTokens)
+	if cmp.Or(s.Model, DefaultModel).requiresMaxCompletionTokens() {
+		sh.Stop = nil
+		sh.ReasoningEffort = cmp.Or(sh.ReasoningEffort, DefaultReasoningEffort)
+	} else {
+		sh.ReasoningEffort = ""
+	}
+	return sh
+}
+
 // Do sends a request to OpenAI using the go-openai package.
 func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
 	// Configure the OpenAI client
@@ -751,7 +775,12 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 
 	// TODO: do this one during Service setup? maybe with a constructor instead?
 	config := openai.DefaultConfig(s.APIKey)
-	if modelURLOverride := cmp.Or(s.ModelURL, model.URL); modelURLOverride != "" {
+	if model.Deployment != "" {
+		var err error
+		if config, err = azureConfig(s.APIKey, cmp.Or(s.ModelURL, model.URL), model.Deployment); err != nil {
+			return nil, err
+		}
+	} else if modelURLOverride := cmp.Or(s.ModelURL, model.URL); modelURLOverride != "" {
 		config.BaseURL = modelURLOverride
 	}
 	if s.Org != "" {
@@ -787,10 +816,26 @@ func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error
 		Tools:      tools,
 		ToolChoice: fromLLMToolChoice(ir.ToolChoice), // TODO: make fromLLMToolChoice return an error when a perfect translation is not possible
 	}
+	if sm := ir.Sampling; sm != nil {
+		if sm.Temperature != nil {
+			// go-openai omits a zero temperature, which leaves the server default.
+			req.Temperature = max(float32(*sm.Temperature), math.SmallestNonzeroFloat32)
+		}
+		if sm.TopP != nil {
+			req.TopP = float32(*sm.TopP)
+		}
+		if sm.Seed != nil {
+			seed := int(*sm.Seed)
+			req.Seed = &seed
+		}
+	}
+	sh := s.RequestShaping()
 	if model.requiresMaxCompletionTokens() {
-		req.MaxCompletionTokens = cmp.Or(s.MaxTokens, DefaultMaxTokens)
+		req.MaxCompletionTokens = sh.MaxTokens
+		req.ReasoningEffort = sh.ReasoningEffort
 	} else {
-		req.MaxTokens = cmp.Or(s.MaxTokens, DefaultMaxTokens)
+		req.MaxTokens = sh.MaxTokens
+		req.Stop = sh.Stop
 	}
 	// Dump request if enabled
 	if s.DumpLLM {
@@ -1,6 +1,10 @@
 package oai
 
-import "testing"
+import (
+	"testing"
+
+	"sketch.dev/llm"
+)
 
 func TestRequiresMaxCompletionTokens(t *testing.T) {
 	tests := []struct {
@@ -101,3 +105,36 @@ func TestRequestParameterGeneration(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestShaping(t *testing.T) {
+	shaping := llm.Shaping{Stop: []string{"END"}, ThinkingBudget: 4000}
+	tests := []struct {
+		model      Model
+		effort     string
+		wantStop   bool
+		wantEffort string
+	}{
+		{model: GPT41, effort: "high", wantStop: true},
+		{model: O3, wantEffort: DefaultReasoningEffort},
+		{model: GPT5, effort: "low", wantEffort: "low"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model.UserName, func(t *testing.T) {
+			sh := shaping
+			sh.ReasoningEffort = tt.effort
+			got := (&Service{Model: tt.model, Shaping: sh}).RequestShaping()
+			if got.MaxTokens != DefaultMaxTokens {
+				t.Errorf("MaxTokens = %d, want %d", got.MaxTokens, DefaultMaxTokens)
+			}
+			if got.ThinkingBudget != 0 {
+				t.Errorf("ThinkingBudget = %d, want none", got.ThinkingBudget)
+			}
+			if (len(got.Stop) > 0) != tt.wantStop {
+				t.Errorf("Stop = %q, want stop sequences %v", got.Stop, tt.wantStop)
+			}
+			if got.ReasoningEffort != tt.wantEffort {
+				t.Errorf("ReasoningEffort = %q, want %q", got.ReasoningEffort, tt.wantEffort)
+			}
+		})
+	}
+}
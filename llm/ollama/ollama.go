@@ -0,0 +1,628 @@
+// Package ollama provides llm.Service for models served by a local Ollama,
+// through its native chat API, so that sketch can run without a network.
+//
+// Replies are streamed and assembled as they come, so that a long reply from
+// a slow model isn't cut short and a stalled one is noticed. Models that
+// support tools get them natively; for the others, the tools are described in
+// the system prompt and the model's calls are parsed out of its reply.
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+const (
+	// Prefix starts the -model values that name Ollama models, as in ollama:qwen3-coder.
+	Prefix = "ollama:"
+	// DefaultURL is where the ollama CLI looks for its server, unless HostEnv says otherwise.
+	DefaultURL = "http://localhost:11434"
+	HostEnv    = "OLLAMA_HOST"
+	// DefaultNumCtx caps the context window models run with. Ollama's own
+	// default is too small for the system prompt and tools, and a model's
+	// full window may not fit in memory.
+	DefaultNumCtx = 32768
+	// DefaultStallTimeout is how long a reply may go without a chunk,
+	// including while Ollama loads the model.
+	DefaultStallTimeout = 5 * time.Minute
+)
+
+// ModelName returns the Ollama model that a -model value names, if it names one.
+func ModelName(userName string) (string, bool) {
+	name, ok := strings.CutPrefix(userName, Prefix)
+	return name, ok && name != ""
+}
+
+// URLFromEnv returns the URL of the Ollama server, read from HostEnv the way
+// the ollama CLI reads it: a bare host or host:port means http, on port 11434
+// unless given.
+func URLFromEnv() string {
+	host := strings.TrimSpace(os.Getenv(HostEnv))
+	if host == "" {
+		return DefaultURL
+	}
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil || u.Hostname() == "" {
+		return DefaultURL
+	}
+	hostname, port := u.Hostname(), u.Port()
+	if hostname == "0.0.0.0" {
+		// A server listening on every interface is reached on this one.
+		hostname = "localhost"
+	}
+	if port == "" && u.Scheme == "http" {
+		port = "11434"
+	}
+	u.Host = hostname
+	if port != "" {
+		u.Host = net.JoinHostPort(hostname, port)
+	}
+	return strings.TrimRight(u.String(), "/")
+}
+
+// Service provides completions from a model served by Ollama.
+// Fields should not be altered concurrently with calling any method on Service.
+type Service struct {
+	HTTPC        *http.Client  // defaults to http.DefaultClient if nil
+	URL          string        // the Ollama server; defaults to DefaultURL
+	Model        string        // must be non-empty, e.g. qwen3-coder
+	NumCtx       int           // the context window; defaults to the model's, up to DefaultNumCtx
+	StallTimeout time.Duration // defaults to DefaultStallTimeout
+	Shaping      llm.Shaping   // request settings; see RequestShaping for the defaults
+	DumpLLM      bool          // whether to dump request/response text to files for debugging; defaults to false
+
+	mu   sync.Mutex
+	info *modelInfo // once shown
+}
+
+var _ llm.Service = (*Service)(nil)
+
+// modelInfo is what Ollama's /api/show says about a model, as far as sketch cares.
+type modelInfo struct {
+	tools, thinking bool
+	contextLength   int
+}
+
+// Wire types of Ollama's /api/chat and /api/show.
+type (
+	chatRequest struct {
+		Model    string    `json:"model"`
+		Messages []message `json:"messages"`
+		Tools    []tool    `json:"tools,omitempty"`
+		Stream   bool      `json:"stream"`
+		Think    bool      `json:"think,omitempty"`
+		Options  options   `json:"options"`
+	}
+	options struct {
+		NumCtx      int      `json:"num_ctx,omitempty"`
+		NumPredict  int      `json:"num_predict,omitempty"`
+		Stop        []string `json:"stop,omitempty"`
+		Temperature *float64 `json:"temperature,omitempty"`
+		TopP        *float64 `json:"top_p,omitempty"`
+		Seed        *int64   `json:"seed,omitempty"`
+	}
+	message struct {
+		Role      string     `json:"role"`
+		Content   string     `json:"content"`
+		Thinking  string     `json:"thinking,omitempty"`
+		Images    []string   `json:"images,omitempty"` // base64
+		ToolCalls []toolCall `json:"tool_calls,omitempty"`
+		ToolName  string     `json:"tool_name,omitempty"` // of a tool result
+	}
+	toolCall struct {
+		Function struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		} `json:"function"`
+	}
+	tool struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Parameters  json.RawMessage `json:"parameters"`
+		} `json:"function"`
+	}
+	chatChunk struct {
+		Model           string  `json:"model"`
+		Message         message `json:"message"`
+		Done            bool    `json:"done"`
+		DoneReason      string  `json:"done_reason"`
+		PromptEvalCount uint64  `json:"prompt_eval_count"`
+		EvalCount       uint64  `json:"eval_count"`
+		Error           string  `json:"error"`
+	}
+	showResponse struct {
+		Capabilities []string       `json:"capabilities"`
+		ModelInfo    map[string]any `json:"model_info"`
+	}
+)
+
+func (s *Service) url() string { return strings.TrimRight(cmp.Or(s.URL, DefaultURL), "/") }
+
+func (s *Service) httpc() *http.Client { return cmp.Or(s.HTTPC, http.DefaultClient) }
+
+// show returns what Ollama says about the model, asking it once.
+func (s *Service) show(ctx context.Context) (modelInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.info != nil {
+		return *s.info, nil
+	}
+	body, _ := json.Marshal(map[string]string{"model": s.Model})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url()+"/api/show", bytes.NewReader(body))
+	if err != nil {
+		return modelInfo{}, err
+	}
+	resp, err := s.httpc().Do(req)
+	if err != nil {
+		return modelInfo{}, s.unreachable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return modelInfo{}, s.apiError(resp)
+	}
+	var sr showResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return modelInfo{}, fmt.Errorf("ollama: reading /api/show: %w", err)
+	}
+	// Servers too old to list capabilities are taken at their word when they refuse tools.
+	info := modelInfo{
+		tools:    sr.Capabilities == nil || slices.Contains(sr.Capabilities, "tools"),
+		thinking: slices.Contains(sr.Capabilities, "thinking"),
+	}
+	for k, v := range sr.ModelInfo {
+		if n, ok := v.(float64); ok && strings.HasSuffix(k, ".context_length") {
+			info.contextLength = int(n)
+		}
+	}
+	s.info = &info
+	return info, nil
+}
+
+// numCtx is the context window the model runs with.
+func (s *Service) numCtx(info modelInfo) int {
+	if s.NumCtx > 0 {
+		return s.NumCtx
+	}
+	if info.contextLength > 0 {
+		return min(info.contextLength, DefaultNumCtx)
+	}
+	return DefaultNumCtx
+}
+
+// TokenContextWindow returns the context window the model runs with.
+func (s *Service) TokenContextWindow() int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	info, _ := s.show(ctx)
+	return s.numCtx(info)
+}
+
+// RequestShaping reports the settings of the service's requests. Ollama takes
+// a reply's length and stop sequences; models think or not, on their own terms.
+func (s *Service) RequestShaping() llm.Shaping {
+	sh := s.Shaping
+	sh.ThinkingBudget = 0
+	sh.ReasoningEffort = ""
+	return sh
+}
+
+// Pricing reports that local models cost nothing per token.
+func (s *Service) Pricing() (llm.Pricing, bool) {
+	return llm.Pricing{}, true
+}
+
+// UseSimplifiedPatch reports true: the models that fit on a laptop do better
+// with the simpler patch schema.
+func (s *Service) UseSimplifiedPatch() bool {
+	return true
+}
+
+// Do sends a request to Ollama.
+func (s *Service) Do(ctx context.Context, ir *llm.Request) (*llm.Response, error) {
+	if s.Model == "" {
+		return nil, errors.New("ollama: no model")
+	}
+	info, err := s.show(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, emulated := s.buildRequest(ir, info)
+	start := time.Now()
+	chunk, err := s.chat(ctx, req)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.msg, "does not support tools") && info.tools {
+		// Its capabilities said otherwise; describe the tools instead.
+		slog.WarnContext(ctx, "ollama model does not support tools; emulating them", "model", s.Model)
+		s.mu.Lock()
+		s.info.tools = false
+		info = *s.info
+		s.mu.Unlock()
+		req, emulated = s.buildRequest(ir, info)
+		chunk, err = s.chat(ctx, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	end := time.Now()
+
+	var content []llm.Content
+	if chunk.Message.Thinking != "" {
+		content = append(content, llm.Content{Type: llm.ContentTypeThinking, Thinking: chunk.Message.Thinking})
+	}
+	if emulated {
+		content = append(content, parseEmulatedCalls(chunk.Message.Content)...)
+	} else if chunk.Message.Content != "" {
+		content = append(content, llm.StringContent(chunk.Message.Content))
+	}
+	for _, tc := range chunk.Message.ToolCalls {
+		content = append(content, toolUse(tc.Function.Name, tc.Function.Arguments))
+	}
+	if len(content) == 0 {
+		content = append(content, llm.StringContent(""))
+	}
+
+	stop := llm.StopReasonEndTurn
+	if chunk.DoneReason == "length" {
+		stop = llm.StopReasonMaxTokens
+	}
+	if slices.ContainsFunc(content, func(c llm.Content) bool { return c.Type == llm.ContentTypeToolUse }) {
+		stop = llm.StopReasonToolUse
+	}
+	return &llm.Response{
+		Role:       llm.MessageRoleAssistant,
+		Model:      cmp.Or(chunk.Model, s.Model),
+		Content:    content,
+		StopReason: stop,
+		Usage:      llm.Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount},
+		StartTime:  &start,
+		EndTime:    &end,
+	}, nil
+}
+
+// chat sends req and assembles the streamed reply into one chunk: the last,
+// which carries the usage and why the reply ended, with the message whole.
+func (s *Service) chat(ctx context.Context, req chatRequest) (chatChunk, error) {
+	var last chatChunk
+	body, err := json.Marshal(req)
+	if err != nil {
+		return last, err
+	}
+	if s.DumpLLM {
+		if err := llm.DumpToFile("request", s.url()+"/api/chat", body); err != nil {
+			slog.WarnContext(ctx, "failed to dump ollama request to file", "error", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stall := cmp.Or(s.StallTimeout, DefaultStallTimeout)
+	timer := time.AfterFunc(stall, func() {
+		cancel(fmt.Errorf("ollama: no reply from %s in %s", s.Model, stall))
+	})
+	defer timer.Stop()
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url()+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return last, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := s.httpc().Do(hreq)
+	if err != nil {
+		if cause := context.Cause(ctx); cause != nil && ctx.Err() != nil {
+			return last, cause
+		}
+		return last, s.unreachable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return last, s.apiError(resp)
+	}
+
+	var text, thinking strings.Builder
+	var calls []toolCall
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+	for sc.Scan() {
+		timer.Reset(stall)
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var c chatChunk
+		if err := json.Unmarshal(line, &c); err != nil {
+			return last, fmt.Errorf("ollama: bad chunk %q: %w", line, err)
+		}
+		if c.Error != "" {
+			return last, &apiError{status: resp.StatusCode, msg: c.Error}
+		}
+		text.WriteString(c.Message.Content)
+		thinking.WriteString(c.Message.Thinking)
+		calls = append(calls, c.Message.ToolCalls...)
+		last = c
+		if c.Done {
+			break
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if cause := context.Cause(ctx); cause != nil && ctx.Err() != nil {
+			return last, cause
+		}
+		return last, fmt.Errorf("ollama: reading the reply: %w", err)
+	}
+	if !last.Done {
+		return last, errors.New("ollama: the reply ended early")
+	}
+	last.Message.Content, last.Message.Thinking, last.Message.ToolCalls = text.String(), thinking.String(), calls
+
+	if s.DumpLLM {
+		if out, err := json.MarshalIndent(last, "", "  "); err == nil {
+			if err := llm.DumpToFile("response", "", out); err != nil {
+				slog.WarnContext(ctx, "failed to dump ollama response to file", "error", err)
+			}
+		}
+	}
+	slog.DebugContext(ctx, "ollama_response", "model", last.Model, "done_reason", last.DoneReason, "tool_calls", len(calls), "prompt_tokens", last.PromptEvalCount, "output_tokens", last.EvalCount)
+	return last, nil
+}
+
+// buildRequest converts ir to Ollama's format, reporting whether it emulates
+// the tools. Without native tool support, the tools are described in the
+// system prompt, and earlier calls and results written out as the model was
+// told to write them.
+func (s *Service) buildRequest(ir *llm.Request, info modelInfo) (req chatRequest, emulate bool) {
+	sh := s.RequestShaping()
+	req = chatRequest{
+		Model:  s.Model,
+		Stream: true,
+		Think:  info.thinking,
+		Options: options{
+			NumCtx:     s.numCtx(info),
+			NumPredict: sh.MaxTokens,
+			Stop:       sh.Stop,
+		},
+	}
+	if sm := ir.Sampling; sm != nil {
+		req.Options.Temperature, req.Options.TopP, req.Options.Seed = sm.Temperature, sm.TopP, sm.Seed
+	}
+
+	tools := ir.Tools
+	var system []string
+	for _, sys := range ir.System {
+		if sys.Text != "" {
+			system = append(system, sys.Text)
+		}
+	}
+	if tc := ir.ToolChoice; tc != nil {
+		switch tc.Type {
+		case llm.ToolChoiceTypeNone:
+			tools = nil
+		case llm.ToolChoiceTypeAny:
+			system = append(system, "Respond by calling one of your tools.")
+		case llm.ToolChoiceTypeTool:
+			tools = slices.DeleteFunc(slices.Clone(tools), func(t *llm.Tool) bool { return t.Name != tc.Name })
+			system = append(system, fmt.Sprintf("Respond by calling the %s tool.", tc.Name))
+		}
+	}
+	// Earlier calls are written out even when no tool may be called now.
+	emulate = len(ir.Tools) > 0 && !info.tools
+	if emulate && len(tools) > 0 {
+		system = append(system, emulatedToolsPrompt(tools))
+	} else if !emulate {
+		for _, t := range tools {
+			var wt tool
+			wt.Type = "function"
+			wt.Function.Name, wt.Function.Description, wt.Function.Parameters = t.Name, t.Description, t.InputSchema
+			req.Tools = append(req.Tools, wt)
+		}
+	}
+	if len(system) > 0 {
+		req.Messages = append(req.Messages, message{Role: "system", Content: strings.Join(system, "\n\n")})
+	}
+
+	toolNames := make(map[string]string) // by tool use ID
+	for _, msg := range ir.Messages {
+		role := "user"
+		if msg.Role == llm.MessageRoleAssistant {
+			role = "assistant"
+		}
+		m := message{Role: role}
+		var text []string
+		for _, c := range msg.Content {
+			switch c.Type {
+			case llm.ContentTypeText:
+				if c.MediaType != "" && c.Data != "" {
+					m.Images = append(m.Images, c.Data)
+				} else if c.Text != "" {
+					text = append(text, c.Text)
+				}
+			case llm.ContentTypeThinking:
+				m.Thinking += c.Thinking
+			case llm.ContentTypeToolUse:
+				toolNames[c.ID] = c.ToolName
+				if emulate {
+					text = append(text, emulatedCall(c.ToolName, c.ToolInput))
+					continue
+				}
+				var tc toolCall
+				tc.Function.Name, tc.Function.Arguments = c.ToolName, orEmpty(c.ToolInput)
+				m.ToolCalls = append(m.ToolCalls, tc)
+			case llm.ContentTypeToolResult:
+				result, images := toolResultText(c)
+				if emulate {
+					text = append(text, fmt.Sprintf("<tool_result name=%q>\n%s\n</tool_result>", toolNames[c.ToolUseID], result))
+					m.Images = append(m.Images, images...)
+					continue
+				}
+				// Each result is a message of its own, ahead of what the user said with it.
+				req.Messages = append(req.Messages, message{Role: "tool", Content: result, ToolName: toolNames[c.ToolUseID], Images: images})
+			}
+		}
+		m.Content = strings.Join(text, "\n")
+		if m.Content != "" || m.Thinking != "" || len(m.Images) > 0 || len(m.ToolCalls) > 0 {
+			req.Messages = append(req.Messages, m)
+		}
+	}
+	return req, emulate && len(tools) > 0
+}
+
+// toolResultText returns a tool result's text, marked if the tool failed, and its images.
+func toolResultText(c llm.Content) (string, []string) {
+	var texts, images []string
+	for _, r := range c.ToolResult {
+		switch {
+		case r.MediaType != "" && r.Data != "":
+			images = append(images, r.Data)
+		case strings.TrimSpace(r.Text) != "":
+			texts = append(texts, r.Text)
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if c.ToolError {
+		text = "error: " + cmp.Or(text, "tool execution failed")
+	}
+	return text, images
+}
+
+// emulatedToolsPrompt tells a model without tool support how to call tools.
+func emulatedToolsPrompt(tools []*llm.Tool) string {
+	var b strings.Builder
+	b.WriteString("You have tools. To call one, write a tool_call block, with a JSON object giving the tool's name and its arguments, which follow the tool's JSON schema:\n\n")
+	b.WriteString(`<tool_call>{"name": "TOOL", "arguments": {...}}</tool_call>` + "\n\n")
+	b.WriteString("You may call several tools in one reply. Then stop: the results come back in tool_result blocks. These are the tools:\n")
+	for _, t := range tools {
+		fmt.Fprintf(&b, "\n## %s\n%s\nSchema: %s\n", t.Name, t.Description, bytes.TrimSpace(t.InputSchema))
+	}
+	return b.String()
+}
+
+// emulatedCall writes a tool call as emulatedToolsPrompt says to.
+func emulatedCall(name string, input json.RawMessage) string {
+	call, _ := json.Marshal(struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}{name, orEmpty(input)})
+	return "<tool_call>" + string(call) + "</tool_call>"
+}
+
+var toolCallRe = regexp.MustCompile(`(?s)<tool_call>\s*(.*?)\s*</tool_call>`)
+
+// parseEmulatedCalls splits a reply into its text and the tool calls written
+// in it. A block that isn't a call stays in the text, for the model to see.
+func parseEmulatedCalls(reply string) []llm.Content {
+	var content []llm.Content
+	var text strings.Builder
+	rest := 0
+	for _, m := range toolCallRe.FindAllStringSubmatchIndex(reply, -1) {
+		var call struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal([]byte(reply[m[2]:m[3]]), &call); err != nil || call.Name == "" {
+			continue
+		}
+		text.WriteString(reply[rest:m[0]])
+		rest = m[1]
+		content = append(content, toolUse(call.Name, call.Arguments))
+	}
+	text.WriteString(reply[rest:])
+	if t := strings.TrimSpace(text.String()); t != "" {
+		content = append([]llm.Content{llm.StringContent(t)}, content...)
+	}
+	return content
+}
+
+// toolUse returns a call of the named tool. Ollama doesn't identify calls, so
+// it gets an ID here, for its result to refer to.
+func toolUse(name string, args json.RawMessage) llm.Content {
+	return llm.Content{ID: "ollama_" + rand.Text(), Type: llm.ContentTypeToolUse, ToolName: name, ToolInput: orEmpty(args)}
+}
+
+// orEmpty returns args, or an empty object for no arguments.
+func orEmpty(args json.RawMessage) json.RawMessage {
+	if a := bytes.TrimSpace(args); len(a) == 0 || string(a) == "null" {
+		return json.RawMessage("{}")
+	}
+	return args
+}
+
+// An apiError is an error Ollama replied with.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string { return fmt.Sprintf("ollama: status %d: %s", e.status, e.msg) }
+
+// apiError reads the error of a failed request, with a hint for a missing model.
+func (s *Service) apiError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var e struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		msg = e.Error
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		msg += "; pull it with: ollama pull " + s.Model
+	}
+	return &apiError{status: resp.StatusCode, msg: msg}
+}
+
+// unreachable explains a failure to reach the Ollama server.
+func (s *Service) unreachable(err error) error {
+	return fmt.Errorf("ollama: %w; is ollama serve running at %s? A container reaches it through host.docker.internal, which on Linux needs it to listen beyond loopback (OLLAMA_HOST=0.0.0.0 ollama serve)", err, s.url())
+}
+
+// Models lists the models the server has pulled.
+func (s *Service) Models(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url()+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpc().Do(req)
+	if err != nil {
+		return nil, s.unreachable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.apiError(resp)
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("ollama: reading /api/tags: %w", err)
+	}
+	var names []string
+	for _, m := range tags.Models {
+		names = append(names, m.Name)
+	}
+	return names, nil
+}
@@ -0,0 +1,179 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+// fakeOllama serves /api/show with capabilities and /api/chat by streaming
+// the chunks reply returns, recording the requests.
+type fakeOllama struct {
+	capabilities []string
+	reply        func(req chatRequest) []chatChunk
+	requests     []chatRequest
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/show":
+		json.NewEncoder(w).Encode(map[string]any{
+			"capabilities": f.capabilities,
+			"model_info":   map[string]any{"general.architecture": "qwen3", "qwen3.context_length": 262144},
+		})
+	case "/api/chat":
+		var req chatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.requests = append(f.requests, req)
+		if len(req.Tools) > 0 && !strings.Contains(strings.Join(f.capabilities, ","), "tools") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"error":"registry.ollama.ai/library/%s does not support tools"}`, req.Model)
+			return
+		}
+		for _, c := range f.reply(req) {
+			json.NewEncoder(w).Encode(c)
+			w.(http.Flusher).Flush()
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func streamed(text string, calls ...toolCall) []chatChunk {
+	var chunks []chatChunk
+	for _, word := range strings.SplitAfter(text, " ") {
+		chunks = append(chunks, chatChunk{Message: message{Role: "assistant", Content: word}})
+	}
+	last := chatChunk{Done: true, DoneReason: "stop", PromptEvalCount: 100, EvalCount: 20}
+	last.Message.ToolCalls = calls
+	return append(chunks, last)
+}
+
+var bashTool = &llm.Tool{Name: "bash", Description: "Run a command", InputSchema: llm.MustSchema(`{"type":"object","properties":{"command":{"type":"string"}}}`)}
+
+func TestNativeTools(t *testing.T) {
+	f := &fakeOllama{capabilities: []string{"completion", "tools"}}
+	f.reply = func(req chatRequest) []chatChunk {
+		var call toolCall
+		call.Function.Name, call.Function.Arguments = "bash", json.RawMessage(`{"command":"ls"}`)
+		return streamed("Let me look.", call)
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	s := &Service{URL: srv.URL, Model: "qwen3-coder"}
+
+	resp, err := s.Do(context.Background(), &llm.Request{
+		System:   []llm.SystemContent{{Text: "You are sketch."}},
+		Tools:    []*llm.Tool{bashTool},
+		Messages: []llm.Message{llm.UserStringMessage("What's here?")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StopReason != llm.StopReasonToolUse || len(resp.Content) != 2 {
+		t.Fatalf("response: %+v", resp)
+	}
+	if resp.Content[0].Text != "Let me look." || resp.Content[1].ToolName != "bash" || string(resp.Content[1].ToolInput) != `{"command":"ls"}` {
+		t.Errorf("content: %+v", resp.Content)
+	}
+	if resp.Usage.InputTokens != 100 || resp.Usage.OutputTokens != 20 {
+		t.Errorf("usage: %+v", resp.Usage)
+	}
+	req := f.requests[0]
+	if !req.Stream || len(req.Tools) != 1 || req.Options.NumCtx != DefaultNumCtx || req.Messages[0].Role != "system" {
+		t.Errorf("request: %+v", req)
+	}
+
+	// The result goes back as a tool message naming the tool.
+	f.reply = func(chatRequest) []chatChunk { return streamed("Just go.mod.") }
+	_, err = s.Do(context.Background(), &llm.Request{
+		Tools: []*llm.Tool{bashTool},
+		Messages: []llm.Message{
+			llm.UserStringMessage("What's here?"),
+			resp.ToMessage(),
+			{Role: llm.MessageRoleUser, Content: []llm.Content{{Type: llm.ContentTypeToolResult, ToolUseID: resp.Content[1].ID, ToolResult: llm.TextContent("go.mod")}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := f.requests[1].Messages
+	if len(msgs) != 3 || len(msgs[1].ToolCalls) != 1 || msgs[2].Role != "tool" || msgs[2].ToolName != "bash" || msgs[2].Content != "go.mod" {
+		t.Errorf("messages: %+v", msgs)
+	}
+}
+
+func TestEmulatedTools(t *testing.T) {
+	// An old server lists no capabilities, and refuses the tools.
+	f := &fakeOllama{}
+	f.reply = func(req chatRequest) []chatChunk {
+		return streamed(`I'll list the files. <tool_call>{"name": "bash", "arguments": {"command": "ls"}}</tool_call>`)
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+	s := &Service{URL: srv.URL, Model: "gemma3"}
+
+	resp, err := s.Do(context.Background(), &llm.Request{
+		Tools:    []*llm.Tool{bashTool},
+		Messages: []llm.Message{llm.UserStringMessage("What's here?")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.requests) != 2 || len(f.requests[1].Tools) != 0 || !strings.Contains(f.requests[1].Messages[0].Content, "## bash") {
+		t.Fatalf("the retry didn't describe the tools: %+v", f.requests)
+	}
+	if resp.StopReason != llm.StopReasonToolUse || len(resp.Content) != 2 ||
+		resp.Content[0].Text != "I'll list the files." || resp.Content[1].ToolName != "bash" || string(resp.Content[1].ToolInput) != `{"command": "ls"}` {
+		t.Errorf("response: %+v", resp.Content)
+	}
+}
+
+func TestParseEmulatedCalls(t *testing.T) {
+	got := parseEmulatedCalls("a <tool_call>not json</tool_call> b <tool_call>{\"name\":\"think\"}</tool_call>")
+	if len(got) != 2 || got[0].Text != "a <tool_call>not json</tool_call> b" || got[1].ToolName != "think" || string(got[1].ToolInput) != "{}" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestModelNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":"model 'nope' not found"}`)
+			return
+		}
+	}))
+	defer srv.Close()
+	s := &Service{URL: srv.URL, Model: "nope"}
+	_, err := s.Do(context.Background(), &llm.Request{Messages: []llm.Message{llm.UserStringMessage("hi")}})
+	if err == nil || !strings.Contains(err.Error(), "ollama pull nope") {
+		t.Errorf("err = %v, want a hint to pull the model", err)
+	}
+}
+
+func TestURLFromEnv(t *testing.T) {
+	for env, want := range map[string]string{
+		"":                       DefaultURL,
+		"0.0.0.0":                "http://localhost:11434",
+		"gpu-box:8080":           "http://gpu-box:8080",
+		"https://ollama.example": "https://ollama.example",
+	} {
+		t.Setenv(HostEnv, env)
+		if got := URLFromEnv(); got != want {
+			t.Errorf("%s=%q: got %q, want %q", HostEnv, env, got, want)
+		}
+	}
+	if name, ok := ModelName("ollama:qwen3-coder:30b"); !ok || name != "qwen3-coder:30b" {
+		t.Errorf("ModelName = %q, %v", name, ok)
+	}
+	if _, ok := ModelName("ollama:"); ok {
+		t.Error("ollama: names a model")
+	}
+}
@@ -0,0 +1,88 @@
+package llm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Sampling holds the sampling parameters to send with each request.
+// Unset fields are left to the provider's defaults.
+// Providers ignore parameters they don't support; Anthropic has no seed.
+type Sampling struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+}
+
+// ParseSampling parses a comma-separated list of sampling parameters,
+// e.g. "temperature=0,top_p=0.9,seed=42". An empty string sets none.
+func ParseSampling(s string) (Sampling, error) {
+	var sm Sampling
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return Sampling{}, fmt.Errorf("sampling parameter %q: want name=value", kv)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "temperature", "top_p":
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return Sampling{}, fmt.Errorf("sampling parameter %s: %w", k, err)
+			}
+			if k == "temperature" {
+				sm.Temperature = &f
+			} else {
+				sm.TopP = &f
+			}
+		case "seed":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return Sampling{}, fmt.Errorf("sampling parameter seed: %w", err)
+			}
+			sm.Seed = &n
+		default:
+			return Sampling{}, fmt.Errorf("unknown sampling parameter %q (want temperature, top_p, or seed)", k)
+		}
+	}
+	if err := sm.Validate(); err != nil {
+		return Sampling{}, err
+	}
+	return sm, nil
+}
+
+// Validate reports whether s is in the range every provider accepts.
+func (s Sampling) Validate() error {
+	if t := s.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return fmt.Errorf("temperature %v is outside [0, 2]", *t)
+	}
+	if p := s.TopP; p != nil && (*p <= 0 || *p > 1) {
+		return fmt.Errorf("top_p %v is outside (0, 1]", *p)
+	}
+	return nil
+}
+
+// IsZero reports whether s sets no parameters.
+func (s Sampling) IsZero() bool {
+	return s.Temperature == nil && s.TopP == nil && s.Seed == nil
+}
+
+// String formats s the way ParseSampling reads it.
+func (s Sampling) String() string {
+	var parts []string
+	if s.Temperature != nil {
+		parts = append(parts, "temperature="+strconv.FormatFloat(*s.Temperature, 'g', -1, 64))
+	}
+	if s.TopP != nil {
+		parts = append(parts, "top_p="+strconv.FormatFloat(*s.TopP, 'g', -1, 64))
+	}
+	if s.Seed != nil {
+		parts = append(parts, "seed="+strconv.FormatInt(*s.Seed, 10))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,30 @@
+package llm
+
+import "testing"
+
+func TestParseSampling(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // String() of the result; empty for none
+		wantErr bool
+	}{
+		{"", "", false},
+		{"temperature=0", "temperature=0", false},
+		{" seed = 42 , top_p=0.9, temperature=0.7", "temperature=0.7,top_p=0.9,seed=42", false},
+		{"temperature=2.5", "", true},
+		{"top_p=0", "", true},
+		{"seed=x", "", true},
+		{"top_k=5", "", true},
+		{"temperature", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSampling(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSampling(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseSampling(%q) = %q, want %q", tt.in, got.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package llm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shaping holds the limits of each request: how long the reply may be, where
+// it stops, and how much the model may reason before replying. Zero fields use
+// the model's defaults. Each provider sends only what its model supports:
+// ThinkingBudget is for Claude and Gemini, ReasoningEffort for OpenAI's
+// reasoning models, which take no stop sequences.
+type Shaping struct {
+	MaxTokens       int      `json:"max_tokens,omitempty"`
+	Stop            []string `json:"stop,omitempty"`
+	ThinkingBudget  int      `json:"thinking_budget,omitempty"`  // tokens
+	ReasoningEffort string   `json:"reasoning_effort,omitempty"` // low, medium, or high
+}
+
+// ParseShaping parses a comma-separated list of request settings, e.g.
+// "max_tokens=16000,thinking_budget=4000,stop=END,stop=DONE". Each stop adds a
+// stop sequence, which therefore can't contain a comma. An empty string sets none.
+func ParseShaping(s string) (Shaping, error) {
+	var sh Shaping
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return Shaping{}, fmt.Errorf("request setting %q: want name=value", kv)
+		}
+		k = strings.TrimSpace(k)
+		switch k {
+		case "max_tokens", "thinking_budget":
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return Shaping{}, fmt.Errorf("request setting %s: %w", k, err)
+			}
+			if k == "max_tokens" {
+				sh.MaxTokens = n
+			} else {
+				sh.ThinkingBudget = n
+			}
+		case "stop":
+			// Whitespace may be what stops the reply, so keep it.
+			sh.Stop = append(sh.Stop, v)
+		case "reasoning_effort":
+			sh.ReasoningEffort = strings.TrimSpace(v)
+		default:
+			return Shaping{}, fmt.Errorf("unknown request setting %q (want max_tokens, stop, thinking_budget, or reasoning_effort)", k)
+		}
+	}
+	if err := sh.Validate(); err != nil {
+		return Shaping{}, err
+	}
+	return sh, nil
+}
+
+// Validate reports whether s makes sense for any provider.
+func (s Shaping) Validate() error {
+	if s.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens %d is negative", s.MaxTokens)
+	}
+	if s.ThinkingBudget < 0 {
+		return fmt.Errorf("thinking_budget %d is negative", s.ThinkingBudget)
+	}
+	// Thinking counts toward the reply's tokens.
+	if s.MaxTokens > 0 && s.ThinkingBudget >= s.MaxTokens {
+		return fmt.Errorf("thinking_budget %d leaves no room to reply within max_tokens %d", s.ThinkingBudget, s.MaxTokens)
+	}
+	for _, stop := range s.Stop {
+		if strings.TrimSpace(stop) == "" {
+			return fmt.Errorf("stop sequences can't be blank")
+		}
+	}
+	switch s.ReasoningEffort {
+	case "", "low", "medium", "high":
+	default:
+		return fmt.Errorf("reasoning_effort %q: want low, medium, or high", s.ReasoningEffort)
+	}
+	return nil
+}
+
+// IsZero reports whether s sets nothing.
+func (s Shaping) IsZero() bool {
+	return s.MaxTokens == 0 && len(s.Stop) == 0 && s.ThinkingBudget == 0 && s.ReasoningEffort == ""
+}
+
+// String formats s the way ParseShaping reads it.
+func (s Shaping) String() string {
+	var parts []string
+	if s.MaxTokens != 0 {
+		parts = append(parts, "max_tokens="+strconv.Itoa(s.MaxTokens))
+	}
+	for _, stop := range s.Stop {
+		parts = append(parts, "stop="+stop)
+	}
+	if s.ThinkingBudget != 0 {
+		parts = append(parts, "thinking_budget="+strconv.Itoa(s.ThinkingBudget))
+	}
+	if s.ReasoningEffort != "" {
+		parts = append(parts, "reasoning_effort="+s.ReasoningEffort)
+	}
+	return strings.Join(parts, ",")
+}
+
+type Shaper interface {
+	// RequestShaping reports the settings the service's requests are sent
+	// with: its own, less what its model doesn't support, with the model's
+	// defaults filled in.
+	RequestShaping() Shaping
+}
+
+// ShapingOf returns the settings svc's requests are sent with, if svc reports them.
+func ShapingOf(svc Service) (Shaping, bool) {
+	if sh, ok := svc.(Shaper); ok {
+		return sh.RequestShaping(), true
+	}
+	return Shaping{}, false
+}
@@ -0,0 +1,32 @@
+package llm
+
+import "testing"
+
+func TestParseShaping(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // String() of the result; empty for none
+		wantErr bool
+	}{
+		{"", "", false},
+		{"max_tokens=16000", "max_tokens=16000", false},
+		{" reasoning_effort = high , stop=END,thinking_budget=4000, stop=\n\nHuman:", "stop=END,stop=\n\nHuman:,thinking_budget=4000,reasoning_effort=high", false},
+		{"max_tokens=-1", "", true},
+		{"max_tokens=4000,thinking_budget=4000", "", true},
+		{"stop= ", "", true},
+		{"reasoning_effort=max", "", true},
+		{"thinking_budget=x", "", true},
+		{"top_k=5", "", true},
+		{"stop", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseShaping(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseShaping(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("ParseShaping(%q) = %q, want %q", tt.in, got.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"sketch.dev/llm"
+)
+
+// errAcknowledged ends a turn that AckReply answered without the model.
+var errAcknowledged = errors.New("acknowledgment answered without the model")
+
+// duplicateWindow is how soon after a user message the same message again is
+// taken for the UI submitting it twice, rather than the user repeating it.
+const duplicateWindow = 2 * time.Second
+
+// ackWords are the words a message of acknowledgments only is made of.
+var ackWords = map[string]bool{
+	"ok": true, "okay": true, "k": true, "kk": true,
+	"thanks": true, "thank": true, "you": true, "thx": true, "ty": true, "cheers": true,
+	"great": true, "cool": true, "nice": true, "awesome": true, "perfect": true,
+	"got": true, "it": true, "sounds": true, "good": true,
+}
+
+// isAcknowledgment reports whether msg only acknowledges what came before,
+// such as "ok", "Thanks!" or "👍", leaving nothing for the model to do.
+func isAcknowledgment(msg string) bool {
+	if len(msg) > 40 {
+		return false
+	}
+	words := strings.FieldsFunc(strings.ToLower(msg), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+	if len(words) == 0 {
+		return false
+	}
+	for _, w := range words {
+		if !ackWords[w] && !isAckEmoji(w) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAckEmoji(w string) bool {
+	for _, r := range w {
+		switch r {
+		case '👍', '🙏', '👌', '🙌', '🎉', '❤',
+			'\uFE0F', '\U0001F3FB', '\U0001F3FC', '\U0001F3FD', '\U0001F3FE', '\U0001F3FF': // presentation and skin tone modifiers
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// acknowledged answers msgs with AckReply, ending the turn without the model,
+// if they are acknowledgments only. It leaves them to the model when the
+// agent's last reply asked a question, which "ok" answers, when a request
+// awaits cost confirmation, which "ok" gives, and when a note for the model
+// goes along with them, such as the approval of a plan.
+func (a *Agent) acknowledged(ctx context.Context, msgs []llm.Content) bool {
+	if a.config.AckReply == "" {
+		return false
+	}
+	for _, m := range msgs {
+		if m.Type != llm.ContentTypeText || !isAcknowledgment(m.Text) {
+			return false
+		}
+	}
+	a.mu.Lock()
+	held := a.unconfirmed != nil || a.planNote != "" || a.todosEdited.Load()
+	asked := false
+	for i := len(a.history) - 1; i >= 0; i-- {
+		if m := a.history[i]; m.Type == AgentMessageType && m.ParentConversationID == nil {
+			asked = strings.HasSuffix(strings.TrimSpace(m.Content), "?")
+			break
+		}
+	}
+	a.mu.Unlock()
+	if held || asked || (a.watcher != nil && a.watcher.pending(ctx)) {
+		return false
+	}
+	a.stateMachine.Transition(ctx, StateEndOfTurn, "Acknowledgment answered without the model")
+	a.pushToOutbox(ctx, AgentMessage{Type: AgentMessageType, Content: a.config.AckReply, EndOfTurn: true})
+	return true
+}
+
+// duplicateUserMessage reports whether msg repeats the last user message,
+// sent within duplicateWindow.
+func (a *Agent) duplicateUserMessage(msg string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.history) - 1; i >= 0; i-- {
+		if m := a.history[i]; m.Type == UserMessageType {
+			return m.Content == msg && time.Since(m.Timestamp) < duplicateWindow
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package loop
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestIsAcknowledgment(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"ok":                    true,
+		"Thanks!":               true,
+		"ok, thank you.":        true,
+		"👍":                     true,
+		"👍🏽 thx":                true,
+		"Got it, sounds good":   true,
+		"":                      false,
+		"ok, now fix the tests": false,
+		"thanks, but revert it": false,
+		"good":                  true,
+		"no":                    false,
+		"thanks thanks thanks thanks thanks thanks": false, // too long to be sure
+	} {
+		if got := isAcknowledgment(msg); got != want {
+			t.Errorf("isAcknowledgment(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestAcknowledged(t *testing.T) {
+	ctx := context.Background()
+	a := &Agent{stateMachine: NewStateMachine(), config: AgentConfig{AckReply: "👍"}}
+	a.stateMachine.Transition(ctx, StateWaitingForUserInput, "test")
+	ack := []llm.Content{llm.StringContent("thanks!")}
+
+	a.history = []AgentMessage{{Type: AgentMessageType, Content: "Should I also update the docs?"}}
+	if a.acknowledged(ctx, ack) {
+		t.Error("answered an ok to a question")
+	}
+	if a.acknowledged(ctx, []llm.Content{llm.StringContent("ok"), llm.StringContent("also run the tests")}) {
+		t.Error("answered a request")
+	}
+
+	a.history = []AgentMessage{{Type: AgentMessageType, Content: "Done: the tests pass."}}
+	a.unconfirmed = []llm.Content{llm.StringContent("costly")}
+	if a.acknowledged(ctx, ack) {
+		t.Error("answered a cost confirmation")
+	}
+	a.unconfirmed = nil
+
+	if !a.acknowledged(ctx, ack) {
+		t.Fatal("sent an acknowledgment to the model")
+	}
+	if last := a.history[len(a.history)-1]; last.Content != "👍" || !last.EndOfTurn {
+		t.Errorf("reply: %+v", last)
+	}
+	if a.stateMachine.CurrentState() != StateEndOfTurn {
+		t.Errorf("state %v", a.stateMachine.CurrentState())
+	}
+
+	a.config.AckReply = ""
+	if a.acknowledged(ctx, ack) {
+		t.Error("answered with AckReply unset")
+	}
+}
+
+func TestDuplicateUserMessage(t *testing.T) {
+	ctx := context.Background()
+	a := &Agent{inbox: make(chan string, 10)}
+	a.UserMessage(ctx, "fix the build")
+	a.UserMessage(ctx, "fix the build")
+	a.UserMessage(ctx, "and the tests")
+	if len(a.inbox) != 2 || len(a.history) != 2 {
+		t.Errorf("%d messages in the inbox, %d in history; want 2", len(a.inbox), len(a.history))
+	}
+
+	// Sending it again later is deliberate.
+	a.history[1].Timestamp = time.Now().Add(-duplicateWindow)
+	a.UserMessage(ctx, "and the tests")
+	if len(a.inbox) != 3 {
+		t.Errorf("dropped a message repeated after %v", duplicateWindow)
+	}
+}
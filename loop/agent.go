@@ -4,6 +4,7 @@ import (
 	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -16,27 +17,40 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	"sketch.dev/browser"
 	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/bashkit"
 	"sketch.dev/claudetool/browse"
 	"sketch.dev/claudetool/codereview"
+	"sketch.dev/claudetool/depaudit"
+	"sketch.dev/claudetool/mergequeue"
 	"sketch.dev/claudetool/onstart"
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/claudetool/rebase"
+	"sketch.dev/claudetool/taskrunner"
+	"sketch.dev/claudetool/todoscan"
+	"sketch.dev/control"
 	"sketch.dev/experiment"
+	"sketch.dev/git_tools"
+	"sketch.dev/gittrace"
+	"sketch.dev/i18n"
 	"sketch.dev/llm"
 	"sketch.dev/llm/ant"
 	"sketch.dev/llm/conversation"
 	"sketch.dev/mcp"
+	"sketch.dev/netpolicy"
+	"sketch.dev/resultfilter"
 	"sketch.dev/skabandclient"
+	"sketch.dev/untrusted"
+	"sketch.dev/vcs"
+	"sketch.dev/webhook"
 	"tailscale.com/portlist"
 )
 
-const (
-	userCancelMessage = "user requested agent to stop handling responses"
-)
-
 type MessageIterator interface {
 	// Next blocks until the next message is available. It may
 	// return nil if the underlying iterator context is done.
@@ -47,6 +61,9 @@ type MessageIterator interface {
 type CodingAgent interface {
 	// Init initializes an agent inside a docker container.
 	Init(AgentInit) error
+	// Reinit updates the host address of an initialized agent, for when the
+	// outside process restarts. Init itself may be retried with the same settings.
+	Reinit(AgentInit) error
 
 	// Ready returns a channel closed after Init successfully called.
 	Ready() <-chan struct{}
@@ -64,6 +81,14 @@ type CodingAgent interface {
 	// Returns an iterator that notifies of state transitions until the context is done.
 	NewStateTransitionIterator(ctx context.Context) StateTransitionIterator
 
+	// StateHistory returns the states the agent went through, with their durations.
+	StateHistory() []StateVisit
+
+	// Artifacts returns the images and diagrams attached to messages, oldest first.
+	Artifacts() []Artifact
+	// ArtifactFile returns the artifact with the given ID and the path of its content.
+	ArtifactFile(id string) (Artifact, string, bool)
+
 	// Loop begins the agent loop returns only when ctx is cancelled.
 	Loop(ctx context.Context)
 
@@ -85,6 +110,8 @@ type CodingAgent interface {
 
 	TotalUsage() conversation.CumulativeUsage
 	OriginalBudget() conversation.Budget
+	// EstimateCost estimates what sending msg to the model would cost.
+	EstimateCost(msg string) conversation.Estimate
 
 	WorkingDir() string
 	RepoRoot() string
@@ -93,6 +120,10 @@ type CodingAgent interface {
 	// If commit is non-nil, it shows the diff for just that specific commit.
 	Diff(commit *string) (string, error)
 
+	// DiffStructured returns the changes between two revisions file by file.
+	// An empty from is the sketch base; an empty to is the working directory.
+	DiffStructured(from, to string) ([]git_tools.FileDiff, error)
+
 	// SketchGitBase returns the commit that's the "base" for Sketch's work. It
 	// starts out as the commit where sketch started, but a user can move it if need
 	// be, for example in the case of a rebase. It is stored as a git tag.
@@ -128,6 +159,8 @@ type CodingAgent interface {
 
 	// OutstandingToolCalls returns the names of outstanding tool calls.
 	OutstandingToolCalls() []string
+	// ToolProgress returns the progress reported by the tool calls still running.
+	ToolProgress() []ToolCallProgress
 	OutsideOS() string
 	OutsideHostname() string
 	OutsideWorkingDir() string
@@ -141,6 +174,8 @@ type CodingAgent interface {
 
 	// DiffStats returns the number of lines added and removed from sketch-base to HEAD
 	DiffStats() (int, int)
+	// GitStats breaks the changes from sketch-base to HEAD down by file and by commit
+	GitStats() GitStats
 	// OpenBrowser is a best-effort attempt to open a browser at url in outside sketch.
 	OpenBrowser(url string)
 
@@ -152,13 +187,24 @@ type CodingAgent interface {
 	CurrentStateName() string
 	// CurrentTodoContent returns the current todo list data as JSON, or empty string if no todos exist
 	CurrentTodoContent() string
+	// Todos returns the session's todo list.
+	Todos() (claudetool.TodoList, error)
+	// EditTodos applies edit to the session's todo list, saves it, and announces the change.
+	EditTodos(ctx context.Context, edit func(*claudetool.TodoList) error) (claudetool.TodoList, error)
 
 	// CompactConversation compacts the current conversation by generating a summary
 	// and restarting the conversation with that summary as the initial context
 	CompactConversation(ctx context.Context) error
+	// Compact compacts the conversation now, between turns, with strategy or,
+	// if it is empty, the session's compaction strategy; see ParseCompactionStrategy.
+	Compact(ctx context.Context, strategy string) error
+	// PinMessage pins or unpins the message at idx, so that compaction keeps it verbatim.
+	PinMessage(idx int, pinned bool) error
 
 	// SkabandAddr returns the skaband address if configured
 	SkabandAddr() string
+	// SkabandStatus returns the state of the connection to skaband, or nil if it isn't used.
+	SkabandStatus() *skabandclient.Status
 
 	// GetPorts returns the cached list of open TCP ports
 	GetPorts() []portlist.Port
@@ -168,25 +214,91 @@ type CodingAgent interface {
 
 	// ModelName returns the name of the model the agent is using.
 	ModelName() string
+	// SetModel switches the agent to the named model from the next request on.
+	SetModel(ctx context.Context, name string) error
 
 	// ExternalMessage enqueues an external message to the agent and returns immediately.
 	ExternalMessage(ctx context.Context, msg ExternalMessage) error
+
+	// TurnTimeout returns the wall-clock limit for a single turn; zero means no limit.
+	TurnTimeout() time.Duration
+	// SetTurnTimeout changes the wall-clock limit for turns, including the current one.
+	SetTurnTimeout(d time.Duration)
+
+	// Sampling returns the sampling parameters sent with each request to the LLM.
+	Sampling() llm.Sampling
+	// SetSampling changes the sampling parameters from the next request on.
+	SetSampling(ctx context.Context, s llm.Sampling) error
+
+	// BrowserProfiles lists the browser profiles the browser can be switched to.
+	BrowserProfiles() ([]browse.Profile, error)
+	// ApplyBrowserProfile switches the browser to the named profile.
+	ApplyBrowserProfile(name string) (*browse.Profile, error)
+	// SaveBrowserState saves the browser's cookies and local storage to the named profile.
+	SaveBrowserState(name string) (*browse.Profile, error)
+
+	// LastDependencyAudit returns the most recent dependency audit report, or nil if none has run.
+	LastDependencyAudit() *depaudit.Report
+	// RunDependencyAudit audits dependencies for vulnerabilities relative to sketch-base.
+	RunDependencyAudit(ctx context.Context) (*depaudit.Report, error)
+
+	// NetworkViolations returns network accesses blocked by the container's allowlist.
+	NetworkViolations() []netpolicy.Violation
+
+	// MergeQueueEntries returns the branches enqueued in the merge queue this session.
+	MergeQueueEntries() []mergequeue.Entry
+	// EnqueueMerge submits branch (or the agent's branch, if empty) to the merge queue.
+	EnqueueMerge(ctx context.Context, branch string) (mergequeue.Entry, error)
+
+	// ApplyPatch commits a unified diff from the user on the agent's branch
+	// and tells the agent to build on it.
+	ApplyPatch(ctx context.Context, patch, note string) (PatchResult, error)
+
+	// RecordFeedback stores the user's "up" or "down" rating of the message at idx.
+	RecordFeedback(ctx context.Context, idx int, rating, comment string) (Feedback, error)
+	// Feedback returns the user's ratings of messages this session.
+	Feedback() []Feedback
+
+	// BlockedCommands returns the bash commands the bash policy rejected this session.
+	BlockedCommands() []BlockedCommand
+
+	// ImageProvenance returns the image the session's container runs in, or nil outside a container.
+	ImageProvenance() *ImageProvenance
+	// ImageStatus asks the host whether there is a newer base image than the container's.
+	ImageStatus(ctx context.Context) (ImageStatus, error)
+	// PrepareRebuild saves the working tree and has the host ready a container
+	// built from the latest base image, for the session to continue in once it ends.
+	PrepareRebuild(ctx context.Context) error
+
+	// AttachContext attaches the files at paths, relative to the repository root,
+	// to the next user message; directories stand for the files in them.
+	AttachContext(ctx context.Context, paths []string) (ContextBundle, error)
+	// AttachedContext returns the files attached to the next user message.
+	AttachedContext() ContextBundle
+	// ClearContext detaches all files from the next user message.
+	ClearContext()
+
+	// Conversations returns the session's main conversations and subconversations,
+	// parents first, with what each was for and what it used.
+	Conversations() []ConversationNode
 }
 
 type CodingAgentMessageType string
 
 const (
-	UserMessageType     CodingAgentMessageType = "user"
-	AgentMessageType    CodingAgentMessageType = "agent"
-	ErrorMessageType    CodingAgentMessageType = "error"
-	BudgetMessageType   CodingAgentMessageType = "budget" // dedicated for "out of budget" errors
-	ToolUseMessageType  CodingAgentMessageType = "tool"
-	CommitMessageType   CodingAgentMessageType = "commit"   // for displaying git commits
-	AutoMessageType     CodingAgentMessageType = "auto"     // for automated notifications like autoformatting
-	CompactMessageType  CodingAgentMessageType = "compact"  // for conversation compaction notifications
-	PortMessageType     CodingAgentMessageType = "port"     // for port monitoring events
-	SlugMessageType     CodingAgentMessageType = "slug"     // for slug updates
-	ExternalMessageType CodingAgentMessageType = "external" // for external notifications
+	UserMessageType      CodingAgentMessageType = "user"
+	AgentMessageType     CodingAgentMessageType = "agent"
+	ErrorMessageType     CodingAgentMessageType = "error"
+	BudgetMessageType    CodingAgentMessageType = "budget" // dedicated for "out of budget" errors
+	ToolUseMessageType   CodingAgentMessageType = "tool"
+	CommitMessageType    CodingAgentMessageType = "commit"    // for displaying git commits
+	AutoMessageType      CodingAgentMessageType = "auto"      // for automated notifications like autoformatting
+	CompactMessageType   CodingAgentMessageType = "compact"   // for conversation compaction notifications
+	PortMessageType      CodingAgentMessageType = "port"      // for port monitoring events
+	SlugMessageType      CodingAgentMessageType = "slug"      // for slug updates
+	ExternalMessageType  CodingAgentMessageType = "external"  // for external notifications
+	MilestoneMessageType CodingAgentMessageType = "milestone" // for turn summaries
+	ApprovalMessageType  CodingAgentMessageType = "approval"  // for tool calls awaiting the user's approval
 
 	cancelToolUseMessage = "Stop responding to my previous message. Wait for me to ask you something else before attempting to use any more tools."
 )
@@ -204,6 +316,10 @@ type AgentMessage struct {
 	ToolError       bool             `json:"tool_error,omitempty"`
 	ToolCallId      string           `json:"tool_call_id,omitempty"`
 
+	// ToolResultOmitted is how many bytes of ToolResult were left out of this copy
+	// of the message, when a client asked GET /messages to omit or truncate tool results.
+	ToolResultOmitted int `json:"tool_result_omitted,omitempty"`
+
 	// ToolCalls is a list of all tool calls requested in this message (name and input pairs)
 	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 
@@ -225,6 +341,8 @@ type AgentMessage struct {
 
 	// Turn duration - the time taken for a complete agent turn
 	TurnDuration *time.Duration `json:"turnDuration,omitempty"`
+	// StateDurations adds up the time the turn spent in each state, for end-of-turn messages
+	StateDurations map[string]time.Duration `json:"stateDurations,omitempty"`
 
 	// HideOutput indicates that this message should not be rendered in the UI.
 	// This is useful for subconversations that generate output that shouldn't be shown to the user.
@@ -236,6 +354,18 @@ type AgentMessage struct {
 	// Display contains content to be displayed to the user, set by tools
 	Display any `json:"display,omitempty"`
 
+	// Milestone summarizes a completed turn, for milestone messages
+	Milestone *Milestone `json:"milestone,omitempty"`
+
+	// Approval is the tool call awaiting the user's decision, for approval messages
+	Approval *permission.ApprovalRequest `json:"approval,omitempty"`
+
+	// Artifacts are the images and diagrams in this message, served at /artifacts/{id}
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// Pinned marks a user or agent message that compaction keeps verbatim
+	Pinned bool `json:"pinned,omitempty"`
+
 	Idx int `json:"idx"`
 }
 
@@ -344,13 +474,17 @@ type ConvoInterface interface {
 	ResetBudget(conversation.Budget)
 	OverBudget() error
 	SendMessage(message llm.Message) (*llm.Response, error)
+	SendMessageWithoutTools(message llm.Message) (*llm.Response, error)
 	SendUserTextMessage(s string, otherContents ...llm.Content) (*llm.Response, error)
 	GetID() string
 	ToolResultContents(ctx context.Context, resp *llm.Response) ([]llm.Content, bool, error)
 	ToolResultCancelContents(resp *llm.Response) ([]llm.Content, error)
 	CancelToolUse(toolUseID string, cause error) error
 	SubConvoWithHistory() *conversation.Convo
+	SetSampling(*llm.Sampling)
+	SetService(llm.Service)
 	DebugJSON() ([]byte, error)
+	Estimate(pending []llm.Content) conversation.Estimate
 }
 
 // AgentGitState holds the state necessary for pushing to a remote git repo
@@ -366,6 +500,10 @@ type AgentGitState struct {
 	retryNumber   int             // Number to append when branch conflicts occur
 	linesAdded    int             // Lines added from sketch-base to HEAD
 	linesRemoved  int             // Lines removed from sketch-base to HEAD
+	stats         gitStatsCache   // Per-file and per-commit stats from sketch-base to HEAD
+	language      string          // Language for user-facing notices; immutable
+
+	trace *gittrace.Recorder // records the git commands that sync with the host, if set; immutable
 }
 
 func (ags *AgentGitState) SetSlug(slug string) {
@@ -435,7 +573,9 @@ type Agent struct {
 	config            AgentConfig // config for this agent
 	gitState          AgentGitState
 	workingDir        string
-	repoRoot          string // workingDir may be a subdir of repoRoot
+	repoRoot          string   // workingDir may be a subdir of repoRoot
+	vcs               vcs.Repo // set for hg and jj repos, which get read-only support; nil for git
+	vcsBase           string   // with vcs, the commit the session started from
 	url               string
 	firstMessageIndex int           // index of the first message in the current conversation
 	outsideHTTP       string        // base address of the outside webserver (only when under docker)
@@ -444,16 +584,32 @@ type Agent struct {
 	startedAt         time.Time
 	originalBudget    conversation.Budget
 	codereview        *codereview.CodeReviewer
+	commitLint        *codereview.CommitLint   // nil unless a commit message policy is configured
+	qualityGates      *codereview.QualityGates // nil unless quality gates are configured
+	uncommittedFiles  []string                 // files with the host's uncommitted changes, carried in as a commit
+	depAuditor        *depaudit.Auditor
+	todoScanner       *todoscan.Scanner
+	rebaser           *rebase.Assistant
+	diffs             *git_tools.DiffCache
+	mergeQueue        *mergequeue.Tracker // nil unless a merge queue is configured
+	taskRunner        *taskrunner.Runner  // nil unless the repo uses a monorepo task runner
 	// State machine to track agent state
 	stateMachine *StateMachine
+	// Images and diagrams attached to messages
+	artifacts artifactStore
 	// Outside information
 	outsideHostname   string
 	outsideOS         string
 	outsideWorkingDir string
 	// MCP manager for handling MCP server connections
 	mcpManager *mcp.MCPManager
+	// The browser the browser tools drive, kept across compactions; see browseTools
+	browserOnce sync.Once
+	browser     *browse.BrowseTools
 	// Port monitor for tracking TCP ports
 	portMonitor *PortMonitor
+	// watcher notices the user's changes to the working tree between turns, with -watch
+	watcher *fileWatcher
 
 	// Time when the current turn started (reset at the beginning of InnerLoop)
 	startOfTurn time.Time
@@ -464,18 +620,47 @@ type Agent struct {
 	// . e.g. when user types into the chat textarea
 	// read from by GatherMessages
 	inbox chan string
+	// compactRequests carries Compact calls to the loop, which only receives them between turns
+	compactRequests chan compactRequest
+	// compacted is what a conversation restarted by Compact begins from;
+	// it goes out with the next user message
+	compacted string
+
+	// serializes Init and Reinit
+	initMu      sync.Mutex
+	initialized *AgentInit // what Init succeeded with; nil until then
 
 	// protects cancelTurn
 	cancelTurnMu sync.Mutex
 	// cancels potentially long-running tool_use calls or chains of them
 	cancelTurn context.CancelCauseFunc
 
+	// protects the turn timeout state below
+	turnTimerMu    sync.Mutex
+	turnTimeout    time.Duration
+	turnTimer      *time.Timer // non-nil while a turn with a timeout is running
+	turnTimerStart time.Time
+
+	// protects sampling
+	samplingMu sync.Mutex
+	sampling   llm.Sampling
+
+	// protects config.Service and config.Model, which SetModel changes
+	modelMu sync.Mutex
+
+	// protects gitIdentity, which SetGitIdentity changes
+	identityMu  sync.Mutex
+	gitIdentity GitIdentity
+
 	// protects following
 	mu sync.Mutex
 
 	// Stores all messages for this agent
 	history []AgentMessage
 
+	// Content held back until the user confirms its cost; see confirmCost.
+	unconfirmed []llm.Content
+
 	// Iterators add themselves here when they're ready to be notified of new messages.
 	subscribers []chan *AgentMessage
 
@@ -484,6 +669,44 @@ type Agent struct {
 
 	// Track outstanding tool calls by ID with their names
 	outstandingToolCalls map[string]string
+
+	// The latest progress reported by running tool calls, by ID
+	toolProgress map[string]ToolCallProgress
+
+	// Hosts for which a network policy violation has already been reported
+	reportedNetViolations map[string]bool
+
+	// Tools the session's tool filter removed from the conversation
+	disabledTools []string
+
+	// The user's ratings of messages, by message index
+	feedback map[int]Feedback
+
+	// Bash commands the bash policy rejected, oldest first
+	blockedCommands []BlockedCommand
+
+	// The snapshot of the working tree the next container starts from; see PrepareRebuild
+	rebuildCommit string
+
+	// Files attached to the next message, relative to the repository root; see AttachContext
+	attachedPaths []string
+
+	// The session's conversations, by ID and in the order they started; see Conversations
+	convoNodes map[string]*ConversationNode
+	convoOrder []string
+
+	// Serializes EditTodos
+	todoMu sync.Mutex
+	// Set by EditTodos until the model next hears from the user
+	todosEdited atomic.Bool
+
+	// Whether mutating tools are held back until the user approves a plan,
+	// and what to tell the model about it with the next user message
+	planMode bool
+	planNote string
+
+	// Holds the tool calls that need the user's approval; see ToolPermissions
+	permissions *permission.Gate
 }
 
 // ExternalMessage implements CodingAgent.
@@ -500,11 +723,13 @@ func (a *Agent) ExternalMessage(ctx context.Context, msg ExternalMessage) error
 
 // TokenContextWindow implements CodingAgent.
 func (a *Agent) TokenContextWindow() int {
-	return a.config.Service.TokenContextWindow()
+	return a.service().TokenContextWindow()
 }
 
 // ModelName returns the name of the model the agent is using.
 func (a *Agent) ModelName() string {
+	a.modelMu.Lock()
+	defer a.modelMu.Unlock()
 	return a.config.Model
 }
 
@@ -598,6 +823,38 @@ func (a *Agent) CurrentStateName() string {
 	return a.stateMachine.CurrentState().String()
 }
 
+// LastDependencyAudit returns the most recent dependency audit report, or nil if none has run.
+func (a *Agent) LastDependencyAudit() *depaudit.Report {
+	if a.depAuditor == nil {
+		return nil
+	}
+	return a.depAuditor.LastReport()
+}
+
+// RunDependencyAudit audits dependencies for vulnerabilities relative to sketch-base.
+func (a *Agent) RunDependencyAudit(ctx context.Context) (*depaudit.Report, error) {
+	if a.depAuditor == nil {
+		return nil, fmt.Errorf("dependency audit requires a git repository")
+	}
+	return a.depAuditor.Audit(ctx)
+}
+
+// MergeQueueEntries returns the branches enqueued in the merge queue this session.
+func (a *Agent) MergeQueueEntries() []mergequeue.Entry {
+	if a.mergeQueue == nil {
+		return nil
+	}
+	return a.mergeQueue.Entries()
+}
+
+// EnqueueMerge submits branch (or the agent's branch, if empty) to the merge queue.
+func (a *Agent) EnqueueMerge(ctx context.Context, branch string) (mergequeue.Entry, error) {
+	if a.mergeQueue == nil {
+		return mergequeue.Entry{}, fmt.Errorf("no merge queue is configured; start sketch with -merge-queue")
+	}
+	return a.mergeQueue.Enqueue(ctx, branch)
+}
+
 // CurrentTodoContent returns the current todo list data as JSON.
 // It returns an empty string if no todos exist.
 func (a *Agent) CurrentTodoContent() string {
@@ -639,6 +896,7 @@ Reply with ONLY the summary content - no meta-commentary about creating the summ
 	// to capture a summary, but we may need to modify the history (e.g., remove
 	// TODO data) to save on some tokens.
 	convo := a.convo.SubConvoWithHistory()
+	convo.Purpose = "compaction summary"
 
 	// Modify the system prompt to provide context about the original task
 	originalSystemPrompt := convo.SystemPrompt
@@ -683,66 +941,12 @@ func (a *Agent) dumpMessageHistoryToTmp(ctx context.Context) (string, error) {
 	return filename, nil
 }
 
-// CompactConversation compacts the current conversation by generating a summary
-// and restarting the conversation with that summary as the initial context
-func (a *Agent) CompactConversation(ctx context.Context) error {
-	// Dump the entire message history to /tmp as JSON before compacting
-	dumpFile, err := a.dumpMessageHistoryToTmp(ctx)
-	if err != nil {
-		slog.WarnContext(ctx, "Failed to dump message history to /tmp", "error", err)
-		// Continue with compaction even if dump fails
-	}
-
-	summary, err := a.generateConversationSummary(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to generate conversation summary: %w", err)
-	}
-
+func (a *Agent) URL() string {
 	a.mu.Lock()
-
-	// Get usage information before resetting conversation
-	lastUsage := a.convo.LastUsage()
-	contextWindow := a.config.Service.TokenContextWindow()
-	currentContextSize := lastUsage.InputTokens + lastUsage.CacheReadInputTokens + lastUsage.CacheCreationInputTokens
-
-	// Preserve cumulative usage across compaction
-	cumulativeUsage := a.convo.CumulativeUsage()
-
-	// Reset conversation state but keep all other state (git, working dir, etc.)
-	a.firstMessageIndex = len(a.history)
-	a.convo = a.initConvoWithUsage(&cumulativeUsage)
-
-	a.mu.Unlock()
-
-	// Create informative compaction message with token details
-	compactionMsg := fmt.Sprintf("📜 Conversation compacted to manage token limits. Previous context preserved in summary below.\n\n"+
-		"**Token Usage:** %d / %d tokens (%.1f%% of context window)",
-		currentContextSize, contextWindow, float64(currentContextSize)/float64(contextWindow)*100)
-
-	a.pushToOutbox(ctx, AgentMessage{
-		Type:    CompactMessageType,
-		Content: compactionMsg,
-	})
-
-	// Create the message content with dump file information if available
-	var messageContent string
-	if dumpFile != "" {
-		messageContent = fmt.Sprintf("Here's a summary of our previous work:\n\n%s\n\nThe complete message history has been dumped to %s for your reference if needed.\n\nPlease continue with the work based on this summary.", summary, dumpFile)
-	} else {
-		messageContent = fmt.Sprintf("Here's a summary of our previous work:\n\n%s\n\nPlease continue with the work based on this summary.", summary)
-	}
-
-	a.pushToOutbox(ctx, AgentMessage{
-		Type:    UserMessageType,
-		Content: messageContent,
-	})
-	a.inbox <- messageContent
-
-	return nil
+	defer a.mu.Unlock()
+	return a.url
 }
 
-func (a *Agent) URL() string { return a.url }
-
 // GetPorts returns the cached list of open TCP ports.
 func (a *Agent) GetPorts() []portlist.Port {
 	if a.portMonitor == nil {
@@ -824,9 +1028,9 @@ func (a *Agent) PassthroughUpstream() bool {
 	return a.config.PassthroughUpstream
 }
 
-// GitUsername returns the git user name from the agent config.
+// GitUsername returns the name the agent commits as.
 func (a *Agent) GitUsername() string {
-	return a.config.GitUsername
+	return a.GitIdentity().Name
 }
 
 // DiffStats returns the number of lines added and removed from sketch-base to HEAD
@@ -834,18 +1038,36 @@ func (a *Agent) DiffStats() (int, int) {
 	return a.gitState.DiffStats()
 }
 
+// GitStats implements CodingAgent.
+func (a *Agent) GitStats() GitStats {
+	return a.gitState.GitStats()
+}
+
 func (a *Agent) OpenBrowser(url string) {
 	if !a.IsInContainer() {
 		browser.Open(url)
 		return
 	}
-	// We're in Docker, need to send a request to the Git server
-	// to signal that the outer process should open the browser.
+	// We're in Docker, need to ask the outer process to open the browser.
 	// We don't get to specify a URL, because we are untrusted.
+	if a.config.Control == nil {
+		slog.Debug("no control plane to open the browser with")
+		return
+	}
+	ctx, cancel := context.WithTimeout(a.config.Context, 5*time.Second)
+	defer cancel()
+	if _, err := a.config.Control.Call(ctx, control.MethodBrowserOpen, nil); err != nil {
+		slog.Debug("opening the browser failed", "err", err)
+	}
+}
+
+// postOutside asks the outer sketch process, through its git server, to act on something
+// only the agent sees, such as an exceeded budget.
+func (a *Agent) postOutside(path string) {
 	httpc := &http.Client{Timeout: 5 * time.Second}
-	resp, err := httpc.Post(a.outsideHTTP+"/browser", "text/plain", nil)
+	resp, err := httpc.Post(a.outsideHTTP+path, "text/plain", nil)
 	if err != nil {
-		slog.Debug("browser launch request connection failed", "err", err)
+		slog.Debug("outside request connection failed", "path", path, "err", err)
 		return
 	}
 	defer resp.Body.Close()
@@ -853,7 +1075,7 @@ func (a *Agent) OpenBrowser(url string) {
 		return
 	}
 	body, _ := io.ReadAll(resp.Body)
-	slog.Debug("browser launch request execution failed", "status", resp.Status, "body", string(body))
+	slog.Debug("outside request execution failed", "path", path, "status", resp.Status, "body", string(body))
 }
 
 // CurrentState returns the current state of the agent's state machine.
@@ -861,6 +1083,11 @@ func (a *Agent) CurrentState() State {
 	return a.stateMachine.CurrentState()
 }
 
+// StateHistory returns the states the agent's state machine went through.
+func (a *Agent) StateHistory() []StateVisit {
+	return a.stateMachine.StateHistory()
+}
+
 func (a *Agent) IsInContainer() bool {
 	return a.config.InDocker
 }
@@ -923,6 +1150,10 @@ func (a *Agent) OnToolResult(ctx context.Context, convo *conversation.Convo, too
 	// Remove the tool call from outstanding calls
 	a.mu.Lock()
 	delete(a.outstandingToolCalls, toolID)
+	delete(a.toolProgress, toolID)
+	if convo != nil {
+		a.convoNode(convo).ToolCalls++
+	}
 	a.mu.Unlock()
 
 	m := AgentMessage{
@@ -936,6 +1167,10 @@ func (a *Agent) OnToolResult(ctx context.Context, convo *conversation.Convo, too
 		StartTime:  content.ToolUseStartTime,
 		EndTime:    content.ToolUseEndTime,
 		Display:    content.Display,
+		Artifacts:  a.imageArtifacts(ctx, content.ToolResult),
+	}
+	if at, ok := content.Display.(Attestation); ok {
+		m.Artifacts = append(m.Artifacts, at.Artifacts...)
 	}
 
 	// Calculate the elapsed time if both start and end times are set
@@ -944,6 +1179,12 @@ func (a *Agent) OnToolResult(ctx context.Context, convo *conversation.Convo, too
 		m.Elapsed = &elapsed
 	}
 
+	// Keep clients that follow the todo list through messages current.
+	if toolName == claudetool.TodoWrite.Name && !content.ToolError {
+		todos := a.CurrentTodoContent()
+		m.TodoContent = &todos
+	}
+
 	m.SetConvo(convo)
 	a.pushToOutbox(ctx, m)
 }
@@ -953,6 +1194,9 @@ func (a *Agent) OnRequest(ctx context.Context, convo *conversation.Convo, id str
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	a.outstandingLLMCalls[id] = struct{}{}
+	if convo != nil {
+		a.convoNode(convo)
+	}
 	// We already get tool results from the above. We send user messages to the outbox in the agent loop.
 }
 
@@ -965,6 +1209,9 @@ func (a *Agent) OnResponse(ctx context.Context, convo *conversation.Convo, id st
 	a.mu.Lock()
 	delete(a.outstandingLLMCalls, id)
 	a.mu.Unlock()
+	if convo != nil {
+		a.recordConvoResponse(convo, resp)
+	}
 
 	if resp == nil {
 		// LLM API call failed
@@ -1057,6 +1304,19 @@ func (a *Agent) Messages(start int, end int) []AgentMessage {
 	return slices.Clone(a.history[start:end])
 }
 
+// agentSaid returns the text of the agent's messages to the user.
+func (a *Agent) agentSaid() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var texts []string
+	for _, m := range a.history {
+		if m.Type == AgentMessageType && m.Content != "" {
+			texts = append(texts, m.Content)
+		}
+	}
+	return texts
+}
+
 // ShouldCompact checks if the conversation should be compacted based on token usage
 func (a *Agent) ShouldCompact() bool {
 	// Get the threshold from environment variable, default to 0.94 (94%)
@@ -1085,7 +1345,7 @@ func (a *Agent) ShouldCompact() bool {
 	currentContextSize := lastUsage.InputTokens + lastUsage.CacheReadInputTokens + lastUsage.CacheCreationInputTokens
 
 	// Get the service's token context window
-	service := a.config.Service
+	service := a.service()
 	contextWindow := service.TokenContextWindow()
 
 	// Calculate threshold
@@ -1124,16 +1384,27 @@ type AgentConfig struct {
 	OutsideOS         string
 	OutsideWorkingDir string
 
-	// Outtie's HTTP to, e.g., open a browser
+	// Outtie's HTTP to, e.g., report an exceeded budget
 	OutsideHTTP string
+	// Control is the control plane to outtie, which opens the browser and forwards ports
+	Control *control.Peer
+	// ForwardPorts has outtie forward the ports that open in the container to the host
+	ForwardPorts bool
+	// Webhooks, if set, get the session's commit, end-of-turn, error and budget events
+	Webhooks webhook.Sender
 	// Outtie's Git server
 	GitRemoteAddr string
+	// GitTrace, if set, records the agent's git commands, for replaying sync bugs
+	GitTrace *gittrace.Recorder
 	// Original git origin URL from host repository, if any
 	OriginalGitOrigin string
 	// Upstream branch for git work
 	Upstream string
 	// Commit to checkout from Outtie
 	Commit string
+	// UncommittedBase is set when Commit carries the host's uncommitted changes
+	// atop UncommittedBase, the host's HEAD
+	UncommittedBase string
 	// Prefix for git branches created by sketch
 	BranchPrefix string
 	// LinkToGitHub enables GitHub branch linking in UI
@@ -1150,6 +1421,84 @@ type AgentConfig struct {
 	PassthroughUpstream bool
 	// FetchOnLaunch enables git fetch during initialization
 	FetchOnLaunch bool
+	// CloneStrategy is how the repository is cloned into the container; see ParseCloneStrategy
+	CloneStrategy string
+	// TurnTimeout is the wall-clock limit for a single turn; zero means no limit
+	TurnTimeout time.Duration
+	// NetPolicy is the container's network allowlist, if one is enforced
+	NetPolicy *netpolicy.Policy
+	// Language is the language the agent converses in, such as "Japanese"
+	// or "de"; empty means English
+	Language string
+	// AckReply, if set, answers a turn of acknowledgments only, such as "ok" or
+	// "thanks", without a round trip to the model
+	AckReply string
+	// CodebaseAnalysis limits the startup codebase analysis; see onstart.ParseScope
+	CodebaseAnalysis string
+	// Tools restricts which tools the agent may use; nil allows all of them
+	Tools *ToolFilter
+	// MergeQueue selects the merge queue the agent may land branches through:
+	// "github" or the URL of a custom queue service; empty disables it
+	MergeQueue string
+	// CommitLint is the commit message policy the mechanical checks enforce;
+	// see codereview.ParseCommitLint. Empty falls back to the sketch.commitLint git config setting
+	CommitLint string
+	// QualityGates are the criteria the done tool checks before the agent may finish;
+	// see codereview.ParseQualityGates. Empty falls back to the sketch.qualityGates git config setting
+	QualityGates string
+	// DocsCheck selects the spelling and style checks the mechanical checks run on added
+	// markdown and comments; see codereview.ParseDocsCheck. Empty falls back to the sketch.docsCheck git config setting
+	DocsCheck string
+	// BenchCheck configures the benchmark comparison of the code review; see
+	// codereview.ParseBenchCheck. Empty falls back to the sketch.benchCheck git config setting
+	BenchCheck string
+	// GitTrailers is the trailer policy of the agent's commits; see ParseTrailerPolicy
+	GitTrailers string
+	// Compaction is the compaction strategy; see ParseCompactionStrategy
+	Compaction string
+	// TurnSummaries records a one-line summary of each completed turn as a milestone
+	TurnSummaries bool
+	// ToolResultRefs lets tool inputs refer to large earlier tool results by handle
+	ToolResultRefs bool
+	// SlimTools sends abridged tool descriptions, with tool_help for the full ones
+	SlimTools bool
+	// Watch tells the model of the changes made to the working tree between its turns
+	Watch bool
+	// Resume, if set, continues the conversation of an earlier run
+	Resume *SessionRecord
+	// Store, if set, keeps the session as it goes, for -resume
+	Store SessionStore
+	// History is the session's messages so far, from an earlier run of it
+	History []AgentMessage
+	// Image is the image the session's container was started from; nil outside a container
+	Image *ImageProvenance
+	// BrowserProfileDir is where browser profiles are kept; empty disables them
+	BrowserProfileDir string
+	// BrowserProfile names the browser profile the browser starts from, if any
+	BrowserProfile string
+	// Sampling overrides the provider's sampling parameters; when unset,
+	// a resumed run keeps the ones Resume recorded
+	Sampling llm.Sampling
+	// UntrustedPolicy decides whether sanitized web and MCP content may reach the
+	// model; nil lets it through with injection attempts stripped
+	UntrustedPolicy untrusted.Policy
+	// ConfirmCost, if positive, holds back requests to the LLM that are
+	// estimated to cost more dollars than this until the user confirms them
+	ConfirmCost float64
+	// AutoConfirmCost sends such requests without waiting, for runs with no one to ask
+	AutoConfirmCost bool
+	// ShareFeedback sends the user's ratings of messages to skaband, besides storing them
+	ShareFeedback bool
+	// BashPolicy restricts the commands the bash tool may run, if set
+	BashPolicy *bashkit.Policy
+	// PlanMode starts the session in plan mode; see SetPlanMode
+	PlanMode bool
+	// ToolPermissions allows, denies or asks the user about calls of each tool, if set
+	ToolPermissions permission.Policy
+	// Matrix names the environments the host runs MatrixVerify in, each in a
+	// container of its own, for the verify_matrix tool; see MatrixRun
+	Matrix       []string
+	MatrixVerify string
 }
 
 // NewAgent creates a new Agent.
@@ -1161,16 +1510,21 @@ func NewAgent(config AgentConfig) *Agent {
 	}
 
 	agent := &Agent{
-		config:         config,
-		ready:          make(chan struct{}),
-		inbox:          make(chan string, 100),
-		subscribers:    make([]chan *AgentMessage, 0),
-		startedAt:      time.Now(),
-		originalBudget: config.Budget,
+		config:          config,
+		gitIdentity:     GitIdentity{Name: config.GitUsername, Email: config.GitEmail, Trailers: DefaultTrailerPolicy()},
+		ready:           make(chan struct{}),
+		inbox:           make(chan string, 100),
+		compactRequests: make(chan compactRequest),
+		subscribers:     make([]chan *AgentMessage, 0),
+		history:         config.History,
+		startedAt:       time.Now(),
+		originalBudget:  config.Budget,
 		gitState: AgentGitState{
 			seenCommits:   make(map[string]bool),
 			gitRemoteAddr: config.GitRemoteAddr,
 			upstream:      config.Upstream,
+			language:      config.Language,
+			trace:         config.GitTrace,
 		},
 		outsideHostname:      config.OutsideHostname,
 		outsideOS:            config.OutsideOS,
@@ -1180,13 +1534,28 @@ func NewAgent(config AgentConfig) *Agent {
 		stateMachine:         NewStateMachine(),
 		workingDir:           config.WorkingDir,
 		outsideHTTP:          config.OutsideHTTP,
+		turnTimeout:          config.TurnTimeout,
+		sampling:             config.Sampling,
 
 		mcpManager: mcp.NewMCPManager(),
 	}
+	if r := config.Resume; r != nil && r.Sampling != nil && config.Sampling.IsZero() {
+		agent.sampling = *r.Sampling
+	}
+	if config.PlanMode {
+		agent.planMode, agent.planNote = true, planModeNote
+	}
+	agent.permissions = permission.NewGate(config.ToolPermissions)
+	agent.permissions.OnRequest = agent.askApproval
+	agent.permissions.OnDecided = agent.approvalDecided
 
 	// Initialize port monitor with 5-second interval
 	agent.portMonitor = NewPortMonitor(agent, 5*time.Second)
 
+	if config.NetPolicy != nil {
+		config.NetPolicy.SetViolationHandler(agent.reportNetworkViolation)
+	}
+
 	return agent
 }
 
@@ -1197,20 +1566,69 @@ type AgentInit struct {
 	HostAddr string
 }
 
+// ErrInitConflict is returned by Init when the agent has already been
+// initialized with different settings.
+var ErrInitConflict = errors.New("agent already initialized with different settings")
+
+// Init prepares the agent to run. It is idempotent: calling it again with the
+// same settings, as a retried /init does, succeeds without doing anything.
+// Calling it with different settings fails with ErrInitConflict; use Reinit.
 func (a *Agent) Init(ini AgentInit) error {
+	a.initMu.Lock()
+	defer a.initMu.Unlock()
+	if a.initialized != nil {
+		if *a.initialized == ini {
+			return nil
+		}
+		return fmt.Errorf("Agent.Init: %w", ErrInitConflict)
+	}
+	if err := a.init(ini); err != nil {
+		return err
+	}
+	a.initialized = &ini
+	return nil
+}
+
+// Reinit updates the settings of an initialized agent after the outside
+// process restarts and reconnects, possibly at a new address.
+func (a *Agent) Reinit(ini AgentInit) error {
+	a.initMu.Lock()
+	defer a.initMu.Unlock()
+	if a.initialized == nil {
+		return fmt.Errorf("Agent.Reinit: not initialized")
+	}
+	if ini.InDocker != a.initialized.InDocker || ini.NoGit != a.initialized.NoGit {
+		return fmt.Errorf("Agent.Reinit: only the host address can change after Init")
+	}
+	if ini.HostAddr != "" {
+		a.mu.Lock()
+		a.url = "http://" + ini.HostAddr
+		a.mu.Unlock()
+	}
+	a.initialized = &ini
+	slog.InfoContext(a.config.Context, "agent reinitialized", "host_addr", ini.HostAddr)
+	return nil
+}
+
+func (a *Agent) init(ini AgentInit) error {
 	if a.convo != nil {
 		return fmt.Errorf("Agent.Init: already initialized")
 	}
 	ctx := a.config.Context
+	trace := a.gitState.trace
 	slog.InfoContext(ctx, "agent initializing")
 
 	// If a remote + commit was specified, clone it.
 	if a.config.Commit != "" && a.gitState.gitRemoteAddr != "" {
 		if _, err := os.Stat("/app/.git"); err != nil {
-			slog.InfoContext(ctx, "cloning git repo", "commit", a.config.Commit)
-			// TODO: --reference-if-able instead?
-			cmd := exec.CommandContext(ctx, "git", "clone", "--reference", "/git-ref", a.gitState.gitRemoteAddr, "/app")
-			if out, err := cmd.CombinedOutput(); err != nil {
+			clone, err := ParseCloneStrategy(a.config.CloneStrategy)
+			if err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "cloning git repo", "commit", a.config.Commit, "strategy", clone.String())
+			args := append(append([]string{"clone"}, clone.cloneArgs()...), a.gitState.gitRemoteAddr, "/app")
+			cmd := exec.CommandContext(ctx, "git", args...)
+			if out, err := trace.CombinedOutput(cmd); err != nil {
 				return fmt.Errorf("failed to clone repository from %s: %s: %w", a.gitState.gitRemoteAddr, out, err)
 			}
 		}
@@ -1225,30 +1643,28 @@ func (a *Agent) Init(ini AgentInit) error {
 
 	if !ini.NoGit {
 		if a.gitState.gitRemoteAddr != "" {
-			if err := upsertRemoteOrigin(ctx, "/app", a.gitState.gitRemoteAddr); err != nil {
+			if err := upsertRemoteOrigin(ctx, trace, "/app", a.gitState.gitRemoteAddr); err != nil {
 				return err
 			}
 		}
 
-		// Configure git user settings
-		if a.config.GitEmail != "" {
-			cmd := exec.CommandContext(ctx, "git", "config", "--global", "user.email", a.config.GitEmail)
-			cmd.Dir = a.workingDir
-			if out, err := cmd.CombinedOutput(); err != nil {
-				return fmt.Errorf("git config --global user.email: %s: %v", out, err)
-			}
+		// Configure git user settings, and in a container, where the
+		// prepare-commit-msg hook reads it, the trailer policy
+		trailers, err := ParseTrailerPolicy(a.config.GitTrailers)
+		if err != nil {
+			return err
 		}
-		if a.config.GitUsername != "" {
-			cmd := exec.CommandContext(ctx, "git", "config", "--global", "user.name", a.config.GitUsername)
-			cmd.Dir = a.workingDir
-			if out, err := cmd.CombinedOutput(); err != nil {
-				return fmt.Errorf("git config --global user.name: %s: %v", out, err)
-			}
+		a.identityMu.Lock()
+		a.gitIdentity.Trailers = trailers
+		id := a.gitIdentity
+		a.identityMu.Unlock()
+		if err := writeGitIdentity(ctx, trace, a.workingDir, id, a.IsInContainer()); err != nil {
+			return err
 		}
 		// Configure git http.postBuffer
 		cmd := exec.CommandContext(ctx, "git", "config", "--global", "http.postBuffer", "524288000")
 		cmd.Dir = a.workingDir
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := trace.CombinedOutput(cmd); err != nil {
 			return fmt.Errorf("git config --global http.postBuffer: %s: %v", out, err)
 		}
 
@@ -1266,14 +1682,23 @@ func (a *Agent) Init(ini AgentInit) error {
 			slog.InfoContext(ctx, "updating git repo", "commit", a.config.Commit)
 			cmd := exec.CommandContext(ctx, "git", "fetch", "--prune", "origin")
 			cmd.Dir = a.workingDir
-			if out, err := cmd.CombinedOutput(); err != nil {
+			if out, err := trace.CombinedOutput(cmd); err != nil {
 				return fmt.Errorf("git fetch: %s: %w", out, err)
 			}
 		}
+		// A commit on no branch, such as the one carrying the user's uncommitted
+		// changes, doesn't come along with the clone or fetch.
+		if err := trace.Run(exec.CommandContext(ctx, "git", "-C", a.workingDir, "cat-file", "-e", a.config.Commit+"^{commit}")); err != nil {
+			cmd := exec.CommandContext(ctx, "git", "fetch", "origin", a.config.Commit)
+			cmd.Dir = a.workingDir
+			if out, err := trace.CombinedOutput(cmd); err != nil {
+				return fmt.Errorf("git fetch origin %s: %s: %w", a.config.Commit, out, err)
+			}
+		}
 		// The -B resets the branch if it already exists (or creates it if it doesn't)
 		cmd := exec.CommandContext(ctx, "git", "checkout", "-f", "-B", "sketch-wip", a.config.Commit)
 		cmd.Dir = a.workingDir
-		if checkoutOut, err := cmd.CombinedOutput(); err != nil {
+		if checkoutOut, err := trace.CombinedOutput(cmd); err != nil {
 			// Remove git hooks if they exist and retry
 			// Only try removing hooks if we haven't already removed them during fetch
 			hookPath := filepath.Join(a.workingDir, ".git", "hooks")
@@ -1288,7 +1713,7 @@ func (a *Agent) Init(ini AgentInit) error {
 				// Retry the checkout operation
 				cmd = exec.CommandContext(ctx, "git", "checkout", "-f", "-B", "sketch-wip", a.config.Commit)
 				cmd.Dir = a.workingDir
-				if retryOut, retryErr := cmd.CombinedOutput(); retryErr != nil {
+				if retryOut, retryErr := trace.CombinedOutput(cmd); retryErr != nil {
 					return fmt.Errorf("git checkout %s failed even after removing hooks: %s: %w", a.config.Commit, retryOut, retryErr)
 				}
 			} else {
@@ -1300,7 +1725,7 @@ func (a *Agent) Init(ini AgentInit) error {
 		slog.InfoContext(ctx, "checking out branch", slog.String("commit", a.config.Commit))
 		cmd := exec.CommandContext(ctx, "git", "checkout", "-f", "-B", "sketch-wip")
 		cmd.Dir = a.workingDir
-		if checkoutOut, err := cmd.CombinedOutput(); err != nil {
+		if checkoutOut, err := trace.CombinedOutput(cmd); err != nil {
 			return fmt.Errorf("git checkout -f -B sketch-wip: %s: %w", checkoutOut, err)
 		}
 	} else {
@@ -1312,6 +1737,15 @@ func (a *Agent) Init(ini AgentInit) error {
 	}
 
 	if !ini.NoGit {
+		if repo, err := vcs.Detect(a.workingDir); err == nil && repo.Kind() != vcs.Git {
+			a.vcs = repo
+		}
+	}
+	if a.vcs != nil {
+		if err := a.initNonGitRepo(ctx); err != nil {
+			return fmt.Errorf("Agent.Init: %w", err)
+		}
+	} else if !ini.NoGit {
 		repoRoot, err := repoRoot(ctx, a.workingDir)
 		if err != nil {
 			return fmt.Errorf("repoRoot: %w", err)
@@ -1324,10 +1758,20 @@ func (a *Agent) Init(ini AgentInit) error {
 			}
 		}
 
+		if base := a.config.UncommittedBase; base != "" {
+			cmd := exec.CommandContext(ctx, "git", "diff", "--name-only", base, "HEAD")
+			cmd.Dir = repoRoot
+			if out, err := trace.Output(cmd); err != nil {
+				slog.WarnContext(ctx, "listing the host's uncommitted changes", "err", err)
+			} else {
+				a.uncommittedFiles = strings.Fields(string(out))
+			}
+		}
+
 		// Check if we have any commits, and if not, create an empty initial commit
 		cmd := exec.CommandContext(ctx, "git", "rev-list", "--all", "--count")
 		cmd.Dir = repoRoot
-		countOut, err := cmd.CombinedOutput()
+		countOut, err := trace.CombinedOutput(cmd)
 		if err != nil {
 			return fmt.Errorf("git rev-list --all --count: %s: %w", countOut, err)
 		}
@@ -1336,33 +1780,103 @@ func (a *Agent) Init(ini AgentInit) error {
 			slog.Info("No commits found, creating empty initial commit")
 			cmd = exec.CommandContext(ctx, "git", "commit", "--allow-empty", "-m", "Initial empty commit")
 			cmd.Dir = repoRoot
-			if commitOut, err := cmd.CombinedOutput(); err != nil {
+			if commitOut, err := trace.CombinedOutput(cmd); err != nil {
 				return fmt.Errorf("git commit --allow-empty: %s: %w", commitOut, err)
 			}
 		}
 
 		cmd = exec.CommandContext(ctx, "git", "tag", "-f", a.SketchGitBaseRef(), "HEAD")
 		cmd.Dir = repoRoot
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := trace.CombinedOutput(cmd); err != nil {
 			return fmt.Errorf("git tag -f %s %s: %s: %w", a.SketchGitBaseRef(), "HEAD", out, err)
 		}
 
-		slog.Info("running codebase analysis")
-		codebase, err := onstart.AnalyzeCodebase(ctx, a.repoRoot)
-		if err != nil {
-			slog.Warn("failed to analyze codebase", "error", err)
+		if scope, skip, err := onstart.ParseScope(a.config.CodebaseAnalysis); err != nil {
+			slog.Warn("invalid codebase analysis scope", "error", err)
+		} else if skip {
+			slog.Info("skipping codebase analysis")
+		} else {
+			slog.Info("running codebase analysis", "scope", scope)
+			opts := onstart.Options{Scope: scope}
+			if cacheDir, err := os.UserCacheDir(); err == nil {
+				opts.CacheDir = filepath.Join(cacheDir, "sketch", "onstart")
+			}
+			codebase, err := onstart.AnalyzeCodebase(ctx, a.repoRoot, opts)
+			if err != nil {
+				slog.Warn("failed to analyze codebase", "error", err)
+			}
+			a.codebase = codebase
 		}
-		a.codebase = codebase
 
+		commitLint, err := codereview.LoadCommitLint(ctx, a.repoRoot, a.config.CommitLint)
+		if err != nil {
+			return fmt.Errorf("Agent.Init: %w", err)
+		}
+		qualityGates, err := codereview.LoadQualityGates(ctx, a.repoRoot, a.config.QualityGates)
+		if err != nil {
+			return fmt.Errorf("Agent.Init: %w", err)
+		}
+		docsCheck, err := codereview.LoadDocsCheck(ctx, a.repoRoot, a.config.DocsCheck)
+		if err != nil {
+			return fmt.Errorf("Agent.Init: %w", err)
+		}
+		benchCheck, err := codereview.LoadBenchCheck(ctx, a.repoRoot, a.config.BenchCheck)
+		if err != nil {
+			return fmt.Errorf("Agent.Init: %w", err)
+		}
 		codereview, err := codereview.NewCodeReviewer(ctx, a.repoRoot, a.SketchGitBaseRef())
 		if err != nil {
 			return fmt.Errorf("Agent.Init: codereview.NewCodeReviewer: %w", err)
 		}
+		codereview.SetCommitLint(commitLint)
+		codereview.SetDocsCheck(docsCheck)
+		codereview.SetBenchCheck(benchCheck)
+		a.taskRunner = taskrunner.Detect(a.repoRoot)
+		codereview.SetTaskRunner(a.taskRunner)
 		a.codereview = codereview
+		a.commitLint = commitLint
+		a.qualityGates = qualityGates
+		a.depAuditor = depaudit.NewAuditor(a.repoRoot, a.SketchGitBaseRef())
+		a.todoScanner = todoscan.NewScanner(a.repoRoot, a.SketchGitBaseRef())
+		a.rebaser = rebase.NewAssistant(a.repoRoot, a.SketchGitBaseRef(), a.Upstream(), codereview)
+		a.diffs = git_tools.NewDiffCache(a.repoRoot)
+
+		queue, err := mergequeue.Parse(a.config.MergeQueue, a.repoRoot)
+		if err != nil {
+			return fmt.Errorf("Agent.Init: %w", err)
+		}
+		if queue != nil {
+			a.mergeQueue = mergequeue.NewTracker(queue, a.BranchName, a.mergeQueueCommit, a.reportMergeQueueChange)
+			go a.mergeQueue.Run(a.config.Context)
+		}
 
 	}
 	a.gitState.lastSketch = a.SketchGitBase()
-	a.convo = a.initConvo()
+	var usage *conversation.CumulativeUsage
+	if r := a.config.Resume; r != nil && r.SessionID == a.config.SessionID && r.Usage != nil {
+		// The same session, continued: its spending so far counts.
+		usage = r.Usage
+		if usage.ToolUses == nil {
+			usage.ToolUses = make(map[string]int)
+		}
+	}
+	convo := a.initConvoWithUsage(usage)
+	if r := a.config.Resume; r != nil {
+		convo.SetMessages(r.Messages)
+		if r.Rebuild && a.config.Image != nil {
+			a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.ContainerRebuilt, a.config.Image.BaseImage)})
+		} else {
+			a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.SessionResumed, r.SessionID, len(r.Messages))})
+		}
+	}
+	if n := len(a.uncommittedFiles); n > 0 {
+		files := strings.Join(a.uncommittedFiles[:min(n, 10)], ", ")
+		if n > 10 {
+			files += ", …"
+		}
+		a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.UncommittedCarried, n, a.SketchGitBase(), files)})
+	}
+	a.convo = convo
 	close(a.ready)
 	return nil
 }
@@ -1377,24 +1891,34 @@ func (a *Agent) initConvo() *conversation.Convo {
 	return a.initConvoWithUsage(nil)
 }
 
+// untrustedBrowserTools are the browser tools whose output is written by the page.
+var untrustedBrowserTools = []string{"browser_eval", "browser_recent_console_logs"}
+
 // initConvoWithUsage initializes the conversation with optional preserved usage.
 func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *conversation.Convo {
 	ctx := a.config.Context
-	convo := conversation.New(ctx, a.config.Service, usage)
+	convo := conversation.New(ctx, a.service(), usage)
 	convo.PromptCaching = true
 	convo.Budget = a.config.Budget
 	convo.SystemPrompt = a.renderSystemPrompt()
 	convo.ExtraData = map[string]any{"session_id": a.config.SessionID}
+	convo.Purpose = "main"
+	convo.ToolResultRefs = a.config.ToolResultRefs
+	convo.SlimTools = a.config.SlimTools
+	convo.SetSampling(samplingOrNil(a.Sampling()))
 
 	bashTool := &claudetool.BashTool{
 		EnableJITInstall: claudetool.EnableBashToolJITInstall,
 		Timeouts:         a.config.BashTimeouts,
 		Pwd:              a.workingDir,
+		Policy:           a.config.BashPolicy,
+		OnBlocked:        a.recordBlockedCommand,
+		CheckPermission:  a.checkPlanModeBash,
 	}
 	patchTool := &claudetool.PatchTool{
 		Callback:         a.patchCallback,
 		Pwd:              a.workingDir,
-		Simplified:       llm.UseSimplifiedPatch(a.config.Service),
+		Simplified:       llm.UseSimplifiedPatch(a.service()),
 		ClipboardEnabled: experiment.Enabled("clipboard"),
 	}
 
@@ -1402,18 +1926,8 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 	// When adding, removing, or modifying tools here, double-check that the termui tool display
 	// template in termui/termui.go has pretty-printing support for all tools.
 
-	var browserTools []*llm.Tool
-	_, supportsScreenshots := a.config.Service.(*ant.Service)
-	var bTools []*llm.Tool
-	var browserCleanup func()
-
-	bTools, browserCleanup = browse.RegisterBrowserTools(a.config.Context, supportsScreenshots)
-	// Add cleanup function to context cancel
-	go func() {
-		<-a.config.Context.Done()
-		browserCleanup()
-	}()
-	browserTools = bTools
+	_, supportsScreenshots := a.service().(*ant.Service)
+	browserTools := a.browseTools().GetTools(supportsScreenshots)
 
 	convo.Tools = []*llm.Tool{
 		bashTool.Tool(),
@@ -1422,15 +1936,68 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 		claudetool.Think,
 		claudetool.TodoRead,
 		claudetool.TodoWrite,
-		makeDoneTool(a.codereview),
-		a.codereview.Tool(),
-		claudetool.AboutSketch,
+		claudetool.Artifacts,
+		makeDoneTool(a.codereview, a.qualityGates, a.todoScanner, a.agentSaid),
+	}
+	if a.vcs == nil {
+		convo.Tools = append(convo.Tools, a.codereview.Tool())
+	}
+	convo.Tools = append(convo.Tools, claudetool.AboutSketch)
+	if a.depAuditor != nil {
+		convo.Tools = append(convo.Tools, a.depAuditor.Tool())
+	}
+	if a.mergeQueue != nil {
+		convo.Tools = append(convo.Tools, a.mergeQueue.Tool())
+	}
+	if a.taskRunner != nil {
+		convo.Tools = append(convo.Tools, a.taskRunner.Tool())
+	}
+	if a.todoScanner != nil {
+		convo.Tools = append(convo.Tools, a.todoScanner.Tool())
+	}
+	if a.rebaser != nil {
+		convo.Tools = append(convo.Tools, a.rebaser.Tool())
+	}
+	if a.diffs != nil {
+		convo.Tools = append(convo.Tools, a.diffTool())
+	}
+	if a.firstMessageIndex > 0 {
+		convo.Tools = append(convo.Tools, a.recapTool())
+	}
+	if a.IsInContainer() {
+		// Outside a container, snippets would run with the user's own access.
+		convo.Tools = append(convo.Tools, claudetool.Scratchpad)
+		// Provenance names the session's container as the builder.
+		convo.Tools = append(convo.Tools, a.attestTool())
+		if len(a.config.Matrix) > 0 && a.outsideHTTP != "" {
+			convo.Tools = append(convo.Tools, a.matrixTool())
+		}
+	}
+	// Web pages and MCP servers are outside the user's control; see the untrusted package.
+	sanitizer := &untrusted.Sanitizer{Policy: a.config.UntrustedPolicy}
+	for i, t := range browserTools {
+		if slices.Contains(untrustedBrowserTools, t.Name) {
+			browserTools[i] = sanitizer.Wrap(t, "the web page open in the browser")
+		}
 	}
+
+	for i, t := range convo.Tools {
+		if slices.Contains(planModeTools, t.Name) {
+			convo.Tools[i] = a.planGate(t)
+		}
+	}
+
+	var disabledTools, removed []string
+	convo.Tools, disabledTools = a.config.Tools.filter("", convo.Tools)
+	browserTools, removed = a.config.Tools.filter(ToolGroupBrowser, browserTools)
+	disabledTools = append(disabledTools, removed...)
 	convo.Tools = append(convo.Tools, browserTools...)
 
 	// Add MCP tools if configured
-	if len(a.config.MCPServers) > 0 {
-
+	if len(a.config.MCPServers) > 0 && !a.config.Tools.AllowsGroup(ToolGroupMCP) {
+		slog.InfoContext(ctx, "MCP tools disabled; not connecting to MCP servers", "servers", len(a.config.MCPServers))
+		disabledTools = append(disabledTools, ToolGroupMCP)
+	} else if len(a.config.MCPServers) > 0 {
 		slog.InfoContext(ctx, "Initializing MCP connections", "servers", len(a.config.MCPServers))
 		serverConfigs, parseErrors := mcp.ParseServerConfigs(ctx, a.config.MCPServers)
 
@@ -1462,8 +2029,14 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 			// Add tools from all successful connections
 			totalTools := 0
 			for _, connection := range mcpConnections {
-				convo.Tools = append(convo.Tools, connection.Tools...)
-				totalTools += len(connection.Tools)
+				tools, removed := a.config.Tools.filter(ToolGroupMCP, connection.Tools)
+				disabledTools = append(disabledTools, removed...)
+				for i, t := range tools {
+					// MCP tools may change anything, so plan mode holds them all back.
+					tools[i] = a.planGate(sanitizer.Wrap(t, fmt.Sprintf("MCP server %q", connection.ServerName)))
+				}
+				convo.Tools = append(convo.Tools, tools...)
+				totalTools += len(tools)
 				// Log tools per server using structured data
 				slog.InfoContext(ctx, "Added MCP tools from server", "server", connection.ServerName, "count", len(connection.Tools), "tools", connection.ToolNames)
 			}
@@ -1473,10 +2046,42 @@ func (a *Agent) initConvoWithUsage(usage *conversation.CumulativeUsage) *convers
 		}
 	}
 
+	if a.config.ToolPermissions != nil {
+		for i, t := range convo.Tools {
+			if !slices.Contains(requiredTools, t.Name) {
+				convo.Tools[i] = a.permissions.Wrap(t)
+			}
+		}
+	}
+
+	// The repository's filters trim the noise from what tools tell the model.
+	if a.repoRoot != "" {
+		filters, err := resultfilter.Load(a.repoRoot)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to read tool result filters", "error", err)
+			a.pushToOutbox(ctx, AgentMessage{Type: ErrorMessageType, Content: fmt.Sprintf("Tool result filters not applied: %v", err)})
+		}
+		for i, t := range convo.Tools {
+			convo.Tools[i] = filters.Wrap(t)
+		}
+	}
+
+	a.mu.Lock()
+	a.disabledTools = disabledTools
+	a.mu.Unlock()
+
 	convo.Listener = a
 	return convo
 }
 
+// DisabledTools returns the names of the tools (or, for MCP when it's disabled
+// entirely, the group) that the session's tool filter kept out of the conversation.
+func (a *Agent) DisabledTools() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.disabledTools)
+}
+
 // branchExists reports whether branchName exists, either locally or in well-known remotes.
 func branchExists(dir, branchName string) bool {
 	refs := []string{
@@ -1531,6 +2136,7 @@ func (a *Agent) autoGenerateSlug(ctx context.Context, userContents []llm.Content
 		}
 		subConvo := convo.SubConvo()
 		subConvo.Hidden = true
+		subConvo.Purpose = "slug"
 
 		// Prompt for slug generation
 		prompt := `You are a slug generator for Sketch, an agentic coding environment.
@@ -1616,6 +2222,10 @@ func (a *Agent) LinkToGitHub() bool {
 }
 
 func (a *Agent) UserMessage(ctx context.Context, msg string) {
+	if a.duplicateUserMessage(msg) {
+		slog.InfoContext(ctx, "dropping a user message sent twice", "message", msg)
+		return
+	}
 	a.pushToOutbox(ctx, AgentMessage{Type: UserMessageType, Content: msg})
 	a.inbox <- msg
 }
@@ -1645,6 +2255,8 @@ func (a *Agent) Loop(ctxOuter context.Context) {
 		}
 	}
 
+	a.startWatch(ctxOuter)
+
 	// Set up cleanup when context is done
 	defer func() {
 		if a.mcpManager != nil {
@@ -1653,6 +2265,9 @@ func (a *Agent) Loop(ctxOuter context.Context) {
 		if a.portMonitor != nil && a.IsInContainer() {
 			a.portMonitor.Stop()
 		}
+		if a.codereview != nil {
+			a.codereview.Close(context.WithoutCancel(ctxOuter))
+		}
 	}()
 
 	for {
@@ -1669,8 +2284,14 @@ func (a *Agent) Loop(ctxOuter context.Context) {
 			a.cancelTurn = cancel
 			a.cancelTurnMu.Unlock()
 			err := a.processTurn(ctxInner) // Renamed from InnerLoop to better reflect its purpose
+			a.stopTurnTimer()
+			if a.watcher != nil {
+				a.watcher.resume(ctxOuter)
+			}
 			if err != nil {
 				slog.ErrorContext(ctxOuter, "Error in processing turn", "error", err)
+			} else if a.config.TurnSummaries {
+				a.summarizeTurn(ctxOuter)
 			}
 			cancel(nil)
 		}
@@ -1681,24 +2302,50 @@ func (a *Agent) pushToOutbox(ctx context.Context, m AgentMessage) {
 	if m.Timestamp.IsZero() {
 		m.Timestamp = time.Now()
 	}
+	// Times go out in UTC, whatever the container's zone; clients display them in theirs.
+	m.Timestamp = m.Timestamp.UTC()
+	if m.StartTime != nil {
+		t := m.StartTime.UTC()
+		m.StartTime = &t
+	}
+	if m.EndTime != nil {
+		t := m.EndTime.UTC()
+		m.EndTime = &t
+	}
 
 	// If this is a ToolUseMessage and ToolResult is set but Content is not, copy the ToolResult to Content
 	if m.Type == ToolUseMessageType && m.ToolResult != "" && m.Content == "" {
 		m.Content = m.ToolResult
 	}
 
+	// Keep the diagrams the model drew, so that they can be linked to
+	if m.Type == AgentMessageType && m.Artifacts == nil {
+		m.Artifacts = a.diagramArtifacts(ctx, m.Content)
+	}
+
 	// If this is an end-of-turn message, calculate the turn duration and add it to the message
 	if m.EndOfTurn && m.Type == AgentMessageType {
 		turnDuration := time.Since(a.startOfTurn)
 		m.TurnDuration = &turnDuration
-		slog.InfoContext(ctx, "Turn completed", "turnDuration", turnDuration)
+		m.StateDurations = StateDurations(a.stateMachine.StateHistory(), a.stateMachine.Turn())
+		slog.InfoContext(ctx, "Turn completed", "turnDuration", turnDuration, "stateDurations", m.StateDurations)
 	}
 
+	a.sendWebhook(m)
+	if a.config.Store != nil && m.EndOfTurn && m.ParentConversationID == nil {
+		// After the message is in the history, and a.mu unlocked.
+		defer a.saveSessionRecord(ctx)
+	}
 	a.mu.Lock()
 	defer a.mu.Unlock()
 	m.Idx = len(a.history)
 	slog.InfoContext(ctx, "agent message", m.Attr())
 	a.history = append(a.history, m)
+	if a.config.Store != nil {
+		if err := a.config.Store.AppendMessage(m); err != nil {
+			slog.WarnContext(ctx, "storing message", "idx", m.Idx, "error", err)
+		}
+	}
 
 	// Notify all subscribers
 	for _, ch := range a.subscribers {
@@ -1709,18 +2356,31 @@ func (a *Agent) pushToOutbox(ctx context.Context, m AgentMessage) {
 func (a *Agent) GatherMessages(ctx context.Context, block bool) ([]llm.Content, error) {
 	var m []llm.Content
 	if block {
-		select {
-		case <-ctx.Done():
-			return m, ctx.Err()
-		case msg := <-a.inbox:
-			m = append(m, llm.StringContent(msg))
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return m, ctx.Err()
+			case msg := <-a.inbox:
+				m = append(m, llm.StringContent(msg))
+				break wait
+			case req := <-a.compactRequests:
+				req.done <- a.compactNow(ctx, req.strategy)
+			}
 		}
 	}
+	if a.compacted != "" && len(m) > 0 {
+		m = append([]llm.Content{llm.StringContent(a.compacted)}, m...)
+		a.compacted = ""
+	}
 	for {
 		select {
 		case msg := <-a.inbox:
 			m = append(m, llm.StringContent(msg))
 		default:
+			if len(m) > 0 {
+				m = append(m, a.takeAttachedContext(ctx)...)
+			}
 			return m, nil
 		}
 	}
@@ -1736,6 +2396,9 @@ func (a *Agent) processTurn(ctx context.Context) error {
 
 	// Process initial user message
 	initialResp, err := a.processUserMessage(ctx)
+	if errors.Is(err, errCostUnconfirmed) || errors.Is(err, errAcknowledged) {
+		return nil
+	}
 	if err != nil {
 		a.stateMachine.Transition(ctx, StateError, "Error processing user message: "+err.Error())
 		return err
@@ -1770,13 +2433,17 @@ func (a *Agent) processTurn(ctx context.Context) error {
 		// Check if we should compact the conversation
 		if a.ShouldCompact() {
 			a.stateMachine.Transition(ctx, StateCompacting, "Token usage threshold reached, compacting conversation")
-			if err := a.CompactConversation(ctx); err != nil {
+			restarted, err := a.compact(ctx, a.compactionStrategy(), true)
+			if err != nil {
 				a.stateMachine.Transition(ctx, StateError, "Error during compaction: "+err.Error())
 				return err
 			}
-			// After compaction, end this turn and start fresh
-			a.stateMachine.Transition(ctx, StateEndOfTurn, "Compaction completed, ending turn")
-			return nil
+			if restarted {
+				// After compaction, end this turn and start fresh
+				a.stateMachine.Transition(ctx, StateEndOfTurn, "Compaction completed, ending turn")
+				return nil
+			}
+			a.stateMachine.Transition(ctx, StateProcessingLLMResponse, "Compaction completed, continuing turn")
 		}
 
 		// If the model is not requesting to use a tool, we're done
@@ -1813,6 +2480,10 @@ func (a *Agent) processUserMessage(ctx context.Context) (*llm.Response, error) {
 		a.stateMachine.Transition(ctx, StateError, "Error gathering messages: "+err.Error())
 		return nil, err
 	}
+	if a.acknowledged(ctx, msgs) {
+		return nil, errAcknowledged
+	}
+	a.startTurnTimer()
 
 	// Auto-generate slug if this is the first user input and no slug is set
 	if a.Slug() == "" {
@@ -1831,6 +2502,21 @@ func (a *Agent) processUserMessage(ctx context.Context) (*llm.Response, error) {
 		})
 	}
 
+	if held := a.takeUnconfirmed(); held != nil {
+		msgs = append(held, msgs...)
+	} else if !a.confirmCost(ctx, msgs) {
+		return nil, errCostUnconfirmed
+	}
+	if a.todosEdited.Swap(false) {
+		msgs = append(msgs, llm.StringContent(todosEditedNote))
+	}
+	if note := a.takePlanNote(); note != "" {
+		msgs = append(msgs, llm.StringContent(note))
+	}
+	if note := a.takeWatchNote(ctx); note != "" {
+		msgs = append(msgs, llm.StringContent(note))
+	}
+
 	userMessage := llm.Message{
 		Role:    llm.MessageRoleUser,
 		Content: msgs,
@@ -1882,6 +2568,7 @@ func (a *Agent) handleToolExecution(ctx context.Context, resp *llm.Response) (bo
 		// Add working directory and session ID to context for tool execution
 		ctx = claudetool.WithWorkingDir(ctx, a.workingDir)
 		ctx = claudetool.WithSessionID(ctx, a.config.SessionID)
+		ctx = claudetool.WithProgressReporter(ctx, a.reportToolProgress)
 
 		// Execute the tools
 		var err error
@@ -1934,7 +2621,7 @@ func (a *Agent) processGitChanges(ctx context.Context) []string {
 	}
 
 	// Run mechanical checks if there was exactly one new commit.
-	if len(newCommits) != 1 {
+	if len(newCommits) != 1 || a.codereview == nil {
 		return nil
 	}
 	var autoqualityMessages []string
@@ -1968,24 +2655,42 @@ func (a *Agent) continueTurnWithToolResults(ctx context.Context, results []llm.C
 	}
 
 	// Handle cancellation by appending a message about it
-	if cancelled {
+	tte, timedOut := turnTimedOut(ctx)
+	timedOut = timedOut && cancelled
+	if timedOut {
+		// Ask the model to wrap up rather than silently stopping, so the user
+		// learns what was tried. A deadline that passes during an LLM call
+		// doesn't cut it short; if the reply asks for tools, handleToolExecution
+		// cancels them and ends up here.
+		msgs = append(msgs, llm.StringContent(fmt.Sprintf(turnTimeoutPrompt, tte.timeout)))
+		a.pushToOutbox(ctx, AgentMessage{Type: ErrorMessageType, Content: a.localize(i18n.TurnStopped, tte), EndOfTurn: false})
+	} else if cancelled {
 		msgs = append(msgs, llm.StringContent(cancelToolUseMessage))
 		// EndOfTurn is false here so that the client of this agent keeps processing
 		// further messages; the conversation is not over.
-		a.pushToOutbox(ctx, AgentMessage{Type: ErrorMessageType, Content: userCancelMessage, EndOfTurn: false})
+		a.pushToOutbox(ctx, AgentMessage{Type: ErrorMessageType, Content: a.localize(i18n.UserCancelled), EndOfTurn: false})
 	} else if err := a.convo.OverBudget(); err != nil {
 		// Handle budget issues by appending a message about it
 		budgetMsg := "We've exceeded our budget. Please ask the user to confirm before continuing by ending the turn."
 		msgs = append(msgs, llm.StringContent(budgetMsg))
-		a.pushToOutbox(ctx, budgetMessage(fmt.Errorf("warning: %w (ask to keep trying, if you'd like)", err)))
+		a.pushToOutbox(ctx, budgetMessage(errors.New(a.localize(i18n.BudgetWarning, err))))
 	}
 
 	// Combine tool results with user messages
 	results = append(results, msgs...)
+	if !a.confirmCost(ctx, results) {
+		return false, nil
+	}
 
 	// Send the combined message to continue the conversation
 	a.stateMachine.Transition(ctx, StateSendingToolResults, "Sending tool results back to LLM")
-	resp, err := a.convo.SendMessage(llm.Message{
+	send := a.convo.SendMessage
+	if timedOut {
+		// The wrap-up can't ask for tools: nothing would run them. Being text,
+		// it reaches the user through OnResponse, as the end of the turn.
+		send = a.convo.SendMessageWithoutTools
+	}
+	resp, err := send(llm.Message{
 		Role:    llm.MessageRoleUser,
 		Content: results,
 	})
@@ -2008,8 +2713,12 @@ func (a *Agent) continueTurnWithToolResults(ctx context.Context, results []llm.C
 func (a *Agent) overBudget(ctx context.Context) error {
 	if err := a.convo.OverBudget(); err != nil {
 		a.stateMachine.Transition(ctx, StateBudgetExceeded, "Budget exceeded: "+err.Error())
+		if a.IsInContainer() {
+			// For the user's budget_exceeded hooks, which run outside.
+			go a.postOutside("/hooks/budget_exceeded")
+		}
 		m := budgetMessage(err)
-		m.Content = m.Content + "\n\nBudget reset."
+		m.Content = m.Content + "\n\n" + a.localize(i18n.BudgetReset)
 		a.pushToOutbox(ctx, m)
 		a.convo.ResetBudget(a.originalBudget)
 		return err
@@ -2046,6 +2755,16 @@ func (a *Agent) Diff(commit *string) (string, error) {
 	// Find the repository root
 	ctx := context.Background()
 
+	if a.vcs != nil {
+		if commit != nil && *commit != "" {
+			if !isValidGitSHA(*commit) {
+				return "", fmt.Errorf("invalid commit ID format: %s", *commit)
+			}
+			return a.vcs.Show(ctx, *commit, 10)
+		}
+		return a.vcs.Diff(ctx, a.vcsBase, "", 10)
+	}
+
 	// If a specific commit hash is provided, show just that commit's changes
 	if commit != nil && *commit != "" {
 		// Validate that the commit looks like a valid git SHA
@@ -2085,7 +2804,11 @@ func (a *Agent) SketchGitBaseRef() string {
 }
 
 // SketchGitBase returns the Git commit hash that was saved when the agent was instantiated.
+// In hg and jj repos, it's the commit the session started from.
 func (a *Agent) SketchGitBase() string {
+	if a.vcs != nil {
+		return a.vcsBase
+	}
 	cmd := exec.CommandContext(context.Background(), "git", "rev-parse", a.SketchGitBaseRef())
 	cmd.Dir = a.repoRoot
 	output, err := cmd.CombinedOutput()
@@ -2122,6 +2845,13 @@ func removeGitHooks(_ context.Context, repoPath string) error {
 }
 
 func (a *Agent) handleGitCommits(ctx context.Context) ([]*GitCommit, error) {
+	if a.vcs != nil {
+		msgs, commits, err := a.gitState.handleVCSCommits(ctx, a.vcs, a.vcsBase)
+		for _, msg := range msgs {
+			a.pushToOutbox(ctx, msg)
+		}
+		return commits, err
+	}
 	msgs, commits, error := a.gitState.handleGitCommits(ctx, a.repoRoot, a.SketchGitBaseRef(), a.config.BranchPrefix)
 	for _, msg := range msgs {
 		a.pushToOutbox(ctx, msg)
@@ -2140,25 +2870,22 @@ func (ags *AgentGitState) handleGitCommits(ctx context.Context, repoRoot string,
 		return msgs, nil, nil
 	}
 
-	sketch, err := resolveRef(ctx, repoRoot, "sketch-wip")
+	sketch, err := resolveRef(ctx, ags.trace, repoRoot, "sketch-wip")
 	if err != nil {
 		return msgs, nil, err
 	}
 	if sketch == ags.lastSketch {
 		return msgs, nil, nil // nothing to do
 	}
+	previousSketch := ags.lastSketch
 	defer func() {
 		ags.lastSketch = sketch
 	}()
 
 	// Compute diff stats from baseRef to HEAD when HEAD changes
-	if added, removed, err := computeDiffStats(ctx, repoRoot, baseRef); err != nil {
+	if err := ags.updateStats(ctx, repoRoot, baseRef); err != nil {
 		// Log error but don't fail the entire operation
 		slog.WarnContext(ctx, "Failed to compute diff stats", "error", err)
-	} else {
-		// Set diff stats directly since we already hold the mutex
-		ags.linesAdded = added
-		ags.linesRemoved = removed
 	}
 
 	// Get new commits. Because it's possible that the agent does rebases, fixups, and
@@ -2172,7 +2899,7 @@ func (ags *AgentGitState) handleGitCommits(ctx context.Context, repoRoot string,
 	// Limit to 100 commits to avoid overwhelming the user
 	cmd := exec.CommandContext(ctx, "git", "log", "-n", "100", "--pretty=format:%H%x00%s%x00%b%x00", "^"+baseRef, sketch)
 	cmd.Dir = repoRoot
-	output, err := cmd.Output()
+	output, err := ags.trace.Output(cmd)
 	if err != nil {
 		return msgs, nil, fmt.Errorf("failed to get git log: %w", err)
 	}
@@ -2226,7 +2953,7 @@ func (ags *AgentGitState) handleGitCommits(ctx context.Context, repoRoot string,
 			branch := ags.branchNameLocked(branchPrefix)
 			cmd = exec.Command("git", "push", "--force", ags.gitRemoteAddr, "sketch-wip:refs/heads/"+branch)
 			cmd.Dir = repoRoot
-			out, err = cmd.CombinedOutput()
+			out, err = ags.trace.CombinedOutput(cmd)
 
 			if err == nil {
 				// Success! Break out of the retry loop
@@ -2250,7 +2977,7 @@ func (ags *AgentGitState) handleGitCommits(ctx context.Context, repoRoot string,
 				msgs = append(msgs, AgentMessage{
 					Type:      AutoMessageType,
 					Timestamp: time.Now(),
-					Content:   fmt.Sprintf("Branch renamed from %s to %s because the original branch is currently checked out on the remote.", originalBranchName, finalBranch),
+					Content:   i18n.Sprintf(ags.language, i18n.BranchRenamed, originalBranchName, finalBranch),
 				})
 			}
 		}
@@ -2265,6 +2992,20 @@ func (ags *AgentGitState) handleGitCommits(ctx context.Context, repoRoot string,
 		}
 		msgs = append(msgs, msg)
 	}
+
+	// If the agent amended or rebased, the commits above look like duplicates of
+	// ones we showed before. Explain what actually changed between the two series.
+	if previousSketch != "" {
+		if summary, err := rangeDiffSummary(ctx, repoRoot, baseRef, previousSketch, sketch); err != nil {
+			slog.WarnContext(ctx, "Failed to compute range-diff", "error", err)
+		} else if summary != "" {
+			msgs = append(msgs, AgentMessage{
+				Type:      AutoMessageType,
+				Timestamp: time.Now(),
+				Content:   summary,
+			})
+		}
+	}
 	return msgs, commits, nil
 }
 
@@ -2361,29 +3102,29 @@ func repoRoot(ctx context.Context, dir string) (string, error) {
 // If the origin remote exists, it updates the URL. If it doesn't exist, it adds it.
 //
 // NOTE: Maybe we should use an "insteadOf" setting instead of changing the URL.
-func upsertRemoteOrigin(ctx context.Context, repoDir, remoteURL string) error {
+func upsertRemoteOrigin(ctx context.Context, trace *gittrace.Recorder, repoDir, remoteURL string) error {
 	// Try to set the URL for existing origin remote
 	cmd := exec.CommandContext(ctx, "git", "remote", "set-url", "origin", remoteURL)
 	cmd.Dir = repoDir
-	if _, err := cmd.CombinedOutput(); err == nil {
+	if _, err := trace.CombinedOutput(cmd); err == nil {
 		// Success.
 		return nil
 	}
 	// Origin doesn't exist; add it.
 	cmd = exec.CommandContext(ctx, "git", "remote", "add", "origin", remoteURL)
 	cmd.Dir = repoDir
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := trace.CombinedOutput(cmd); err != nil {
 		return fmt.Errorf("failed to add git remote origin: %s: %w", out, err)
 	}
 	return nil
 }
 
-func resolveRef(ctx context.Context, dir, refName string) (string, error) {
+func resolveRef(ctx context.Context, trace *gittrace.Recorder, dir, refName string) (string, error) {
 	cmd := exec.CommandContext(ctx, "git", "rev-parse", refName)
 	stderr := new(strings.Builder)
 	cmd.Stderr = stderr
 	cmd.Dir = dir
-	out, err := cmd.Output()
+	out, err := trace.Output(cmd)
 	if err != nil {
 		return "", fmt.Errorf("git rev-parse failed: %w\n%s", err, stderr)
 	}
@@ -2409,35 +3150,70 @@ func isValidGitSHA(sha string) bool {
 	return true
 }
 
-// computeDiffStats computes the number of lines added and removed from baseRef to HEAD
-func computeDiffStats(ctx context.Context, repoRoot, baseRef string) (int, int, error) {
-	cmd := exec.CommandContext(ctx, "git", "diff", "--numstat", baseRef, "HEAD")
+// maxRangeDiffLen caps how much range-diff output we show the user.
+const maxRangeDiffLen = 8 * 1024
+
+// rangeDiffSummary describes how the commit series baseRef..newTip differs from
+// baseRef..oldTip, using git range-diff. It returns "" if newTip is simply
+// oldTip plus more commits, i.e. history was not rewritten.
+func rangeDiffSummary(ctx context.Context, repoRoot, baseRef, oldTip, newTip string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "merge-base", "--is-ancestor", oldTip, newTip)
+	cmd.Dir = repoRoot
+	if err := cmd.Run(); err == nil {
+		return "", nil // fast-forward
+	} else if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		return "", fmt.Errorf("git merge-base --is-ancestor failed: %w", err)
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "range-diff", "--no-color", baseRef+".."+oldTip, baseRef+".."+newTip)
 	cmd.Dir = repoRoot
 	out, err := cmd.Output()
 	if err != nil {
-		return 0, 0, fmt.Errorf("git diff --numstat failed: %w", err)
+		return "", fmt.Errorf("git range-diff failed: %w", err)
 	}
+	rangeDiff := strings.TrimRight(string(out), "\n")
 
-	var totalAdded, totalRemoved int
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
+	var unchanged, modified, added, removed int
+	for _, line := range strings.Split(rangeDiff, "\n") {
+		// Header lines look like "1:  abc1234 ! 1:  def5678 subject"; the rest is the diff of diffs.
+		fields := strings.Fields(line)
+		if len(fields) < 4 || !strings.HasSuffix(fields[0], ":") {
 			continue
 		}
-		// Format: <added>\t<removed>\t<filename>
-		if added, err := strconv.Atoi(parts[0]); err == nil {
-			totalAdded += added
-		}
-		if removed, err := strconv.Atoi(parts[1]); err == nil {
-			totalRemoved += removed
+		switch fields[2] {
+		case "=":
+			unchanged++
+		case "!":
+			modified++
+		case ">":
+			added++
+		case "<":
+			removed++
 		}
 	}
 
-	return totalAdded, totalRemoved, nil
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "History rewritten (%s → %s): ", oldTip[:min(len(oldTip), 8)], newTip[:min(len(newTip), 8)])
+	var parts []string
+	for _, p := range []struct {
+		n    int
+		what string
+	}{{modified, "modified"}, {unchanged, "unchanged"}, {added, "added"}, {removed, "dropped"}} {
+		if p.n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", p.n, p.what))
+		}
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "no commits")
+	}
+	sb.WriteString(strings.Join(parts, ", "))
+	if len(rangeDiff) > maxRangeDiffLen {
+		rangeDiff = rangeDiff[:maxRangeDiffLen] + "\n... (truncated)"
+	}
+	sb.WriteString("\n\n```\n")
+	sb.WriteString(rangeDiff)
+	sb.WriteString("\n```")
+	return sb.String(), nil
 }
 
 // systemPromptData contains the data used to render the system prompt template
@@ -2452,7 +3228,16 @@ type systemPromptData struct {
 	InstallationNudge  bool
 	Branch             string
 	SpecialInstruction string
+	Language           string
 	Now                string
+	VCS                string   // "hg" or "jj"; empty for git
+	CommitLint         string   // the commit message policy, described; empty if none
+	UncommittedFiles   []string // files the user had uncommitted changes to, now part of HEAD
+}
+
+// localize formats a user-facing notice in the session's language.
+func (a *Agent) localize(key i18n.Key, args ...any) string {
+	return i18n.Sprintf(a.config.Language, key, args...)
 }
 
 // renderSystemPrompt renders the system prompt template.
@@ -2468,11 +3253,17 @@ func (a *Agent) renderSystemPrompt() string {
 		WorkingDir:        a.workingDir,
 		RepoRoot:          a.repoRoot,
 		InitialCommit:     a.SketchGitBase(),
+		UncommittedFiles:  a.uncommittedFiles,
 		Codebase:          a.codebase,
+		VCS:               a.vcsKind(),
 		UseSketchWIP:      a.config.InDocker,
 		InstallationNudge: a.config.InDocker,
+		Language:          a.config.Language,
 		Now:               now.Format(time.DateOnly),
 	}
+	if a.commitLint != nil {
+		data.CommitLint = a.commitLint.Describe()
+	}
 	if now.Month() == time.September && now.Day() == 19 {
 		data.SpecialInstruction = "Today is international talk like a pirate day. Occasionally drop a 🏴‍☠️ into the conversation (not code!), but subtly."
 	}
@@ -2572,10 +3363,10 @@ echo "</last_commit_message>"
 echo "</post_commit_hook>"
 `
 
-	// Define the prepare-commit-msg hook content
+	// Define the prepare-commit-msg hook content; see TrailerPolicy
 	prepareCommitMsgHook := `#!/bin/bash
 # Add Co-Authored-By and Change-ID trailers to commit messages
-# Check if these trailers already exist before adding them
+# as the sketch.trailers.* git config settings say, unless already there
 
 commit_file="$1"
 COMMIT_SOURCE="$2"
@@ -2586,52 +3377,24 @@ if [ "$COMMIT_SOURCE" = "template" ] || [ "$COMMIT_SOURCE" = "merge" ] || \
   exit 0
 fi
 
-commit_msg=$(cat "$commit_file")
-
-needs_co_author=true
-needs_change_id=true
+trailers=()
+while IFS= read -r co_author; do
+  if [ -n "$co_author" ]; then
+    trailers+=(--trailer "Co-Authored-By: $co_author")
+  fi
+done < <(git config --get-all sketch.trailers.coauthor)
 
-# Check if commit message already has Co-Authored-By trailer
-if grep -q "Co-Authored-By: sketch <hello@sketch.dev>" "$commit_file"; then
-  needs_co_author=false
+if [ "$(git config --type=bool --default=false sketch.trailers.changeid)" = true ] && \
+   ! grep -q "^Change-ID: s[a-f0-9]\+k" "$commit_file"; then
+  trailers+=(--trailer "Change-ID: s$(openssl rand -hex 8)k")
 fi
 
-# Check if commit message already has Change-ID trailer
-if grep -q "Change-ID: s[a-f0-9]\+k" "$commit_file"; then
-  needs_change_id=false
+if [ "$(git config --type=bool --default=false sketch.trailers.signoff)" = true ]; then
+  trailers+=(--trailer "Signed-off-by: $(git config user.name) <$(git config user.email)>")
 fi
 
-# Only modify if at least one trailer needs to be added
-if [ "$needs_co_author" = true ] || [ "$needs_change_id" = true ]; then
-  # Ensure there's a proper blank line before trailers
-  if [ -s "$commit_file" ]; then
-    # Check if file ends with newline by reading last character
-    last_char=$(tail -c 1 "$commit_file")
-
-    if [ "$last_char" != "" ]; then
-      # File doesn't end with newline - add two newlines (complete line + blank line)
-      echo "" >> "$commit_file"
-      echo "" >> "$commit_file"
-    else
-      # File ends with newline - check if we already have a blank line
-      last_line=$(tail -1 "$commit_file")
-      if [ -n "$last_line" ]; then
-        # Last line has content - add one newline for blank line
-        echo "" >> "$commit_file"
-      fi
-      # If last line is empty, we already have a blank line - don't add anything
-    fi
-  fi
-
-  # Add trailers if needed
-  if [ "$needs_co_author" = true ]; then
-    echo "Co-Authored-By: sketch <hello@sketch.dev>" >> "$commit_file"
-  fi
-
-  if [ "$needs_change_id" = true ]; then
-    change_id=$(openssl rand -hex 8)
-    echo "Change-ID: s${change_id}k" >> "$commit_file"
-  fi
+if [ ${#trailers[@]} -gt 0 ]; then
+  git -c trailer.ifexists=addIfDifferent interpret-trailers --in-place "${trailers[@]}" "$commit_file"
 fi
 `
 
@@ -2707,10 +3470,11 @@ func updateOrCreateHook(hookPath, content, distinctiveLine string) error {
 //	github     -   laptop   -  sketch container
 //	"upstream"    "origin"
 func (a *Agent) configurePassthroughUpstream(ctx context.Context) error {
+	trace := a.gitState.trace
 	// Get the origin remote URL
 	cmd := exec.CommandContext(ctx, "git", "remote", "get-url", "origin")
 	cmd.Dir = a.workingDir
-	originURLBytes, err := cmd.CombinedOutput()
+	originURLBytes, err := trace.CombinedOutput(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to get origin URL: %s: %w", originURLBytes, err)
 	}
@@ -2719,11 +3483,11 @@ func (a *Agent) configurePassthroughUpstream(ctx context.Context) error {
 	// Check if upstream remote already exists
 	cmd = exec.CommandContext(ctx, "git", "remote", "get-url", "upstream")
 	cmd.Dir = a.workingDir
-	if _, err := cmd.CombinedOutput(); err != nil {
+	if _, err := trace.CombinedOutput(cmd); err != nil {
 		// upstream remote doesn't exist, create it
 		cmd = exec.CommandContext(ctx, "git", "remote", "add", "upstream", originURL)
 		cmd.Dir = a.workingDir
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := trace.CombinedOutput(cmd); err != nil {
 			return fmt.Errorf("failed to add upstream remote: %s: %w", out, err)
 		}
 		slog.InfoContext(ctx, "added upstream remote", "url", originURL)
@@ -2731,7 +3495,7 @@ func (a *Agent) configurePassthroughUpstream(ctx context.Context) error {
 		// upstream remote exists, update its URL
 		cmd = exec.CommandContext(ctx, "git", "remote", "set-url", "upstream", originURL)
 		cmd.Dir = a.workingDir
-		if out, err := cmd.CombinedOutput(); err != nil {
+		if out, err := trace.CombinedOutput(cmd); err != nil {
 			return fmt.Errorf("failed to set upstream remote URL: %s: %w", out, err)
 		}
 		slog.InfoContext(ctx, "updated upstream remote URL", "url", originURL)
@@ -2740,14 +3504,14 @@ func (a *Agent) configurePassthroughUpstream(ctx context.Context) error {
 	// Add the upstream refspec to the upstream remote
 	cmd = exec.CommandContext(ctx, "git", "config", "remote.upstream.fetch", "+refs/remotes/origin/*:refs/remotes/upstream/*")
 	cmd.Dir = a.workingDir
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := trace.CombinedOutput(cmd); err != nil {
 		return fmt.Errorf("failed to set upstream fetch refspec: %s: %w", out, err)
 	}
 
 	// Add the same refspec to the origin remote
 	cmd = exec.CommandContext(ctx, "git", "config", "--add", "remote.origin.fetch", "+refs/remotes/origin/*:refs/remotes/upstream/*")
 	cmd.Dir = a.workingDir
-	if out, err := cmd.CombinedOutput(); err != nil {
+	if out, err := trace.CombinedOutput(cmd); err != nil {
 		return fmt.Errorf("failed to add upstream refspec to origin: %s: %w", out, err)
 	}
 
@@ -2763,6 +3527,15 @@ func (a *Agent) SkabandAddr() string {
 	return ""
 }
 
+// SkabandStatus returns the state of the connection to skaband, or nil if it isn't used.
+func (a *Agent) SkabandStatus() *skabandclient.Status {
+	if a.config.SkabandClient == nil {
+		return nil
+	}
+	st := a.config.SkabandClient.Status()
+	return &st
+}
+
 // ExternalMsg represents a message from a source external to the agent/user conversation,
 // such as the outcome of a github workflow run.
 type ExternalMessage struct {
@@ -108,6 +108,12 @@ func TestGitCommitTracking(t *testing.T) {
 		t.Fatalf("handleGitCommits failed: %v", gitErr)
 	}
 
+	stats := agent.GitStats()
+	if stats.FilesChanged != 1 || len(stats.Commits) != 1 || stats.Commits[0].Subject != "Second commit" ||
+		stats.Commits[0].Additions != 1 || stats.Commits[0].Deletions != 1 {
+		t.Errorf("GitStats = %+v, want one commit changing one line of test.txt", stats)
+	}
+
 	// Check if we received a commit message
 	agent.mu.Lock()
 	if len(agent.history) == 0 {
@@ -375,3 +381,63 @@ func TestSketchBranchWorkflow(t *testing.T) {
 		t.Errorf("Expected commit 'Update on sketch-wip branch' in log, got: %s", logOutput)
 	}
 }
+
+// TestRangeDiffSummary tests that rewritten history is detected and summarized.
+func TestRangeDiffSummary(t *testing.T) {
+	tempDir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tempDir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	commitFile := func(name, content, msg string, extra ...string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", name)
+		git(append([]string{"commit", "-m", msg}, extra...)...)
+	}
+
+	git("init")
+	commitFile("a.txt", "base\n", "Base")
+	git("tag", "base")
+	commitFile("a.txt", "one\n", "First change")
+	lines := strings.Repeat("unchanged line\n", 20)
+	commitFile("b.txt", lines+"two\n", "Second change")
+	oldTip := git("rev-parse", "HEAD")
+
+	ctx := context.Background()
+	commitFile("c.txt", "three\n", "Third change")
+	summary, err := rangeDiffSummary(ctx, tempDir, "base", oldTip, git("rev-parse", "HEAD"))
+	if err != nil {
+		t.Fatalf("rangeDiffSummary failed: %v", err)
+	}
+	if summary != "" {
+		t.Errorf("Expected no summary for a fast-forward, got: %s", summary)
+	}
+
+	// Rewrite history: drop the third commit and amend the second.
+	git("reset", "--hard", oldTip)
+	commitFile("b.txt", lines+"two, amended\n", "Second change", "--amend")
+	summary, err = rangeDiffSummary(ctx, tempDir, "base", oldTip, git("rev-parse", "HEAD"))
+	if err != nil {
+		t.Fatalf("rangeDiffSummary failed: %v", err)
+	}
+	if !strings.HasPrefix(summary, "History rewritten") {
+		t.Errorf("Expected history rewrite summary, got: %s", summary)
+	}
+	if !strings.Contains(summary, "1 modified, 1 unchanged") {
+		t.Errorf("Expected 1 modified and 1 unchanged commit, got: %s", summary)
+	}
+	if !strings.Contains(summary, "two, amended") {
+		t.Errorf("Expected range-diff body in summary, got: %s", summary)
+	}
+}
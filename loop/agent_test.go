@@ -3,6 +3,7 @@ package loop
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"sketch.dev/claudetool/onstart"
 	"sketch.dev/httprr"
 	"sketch.dev/llm"
 	"sketch.dev/llm/ant"
@@ -279,6 +281,7 @@ type MockConvoInterface struct {
 	getIDFunc                    func() string
 	subConvoWithHistoryFunc      func() *conversation.Convo
 	debugJSONFunc                func() ([]byte, error)
+	estimateFunc                 func(pending []llm.Content) conversation.Estimate
 }
 
 func (m *MockConvoInterface) SendMessage(message llm.Message) (*llm.Response, error) {
@@ -288,6 +291,10 @@ func (m *MockConvoInterface) SendMessage(message llm.Message) (*llm.Response, er
 	return nil, nil
 }
 
+func (m *MockConvoInterface) SendMessageWithoutTools(message llm.Message) (*llm.Response, error) {
+	return m.SendMessage(message)
+}
+
 func (m *MockConvoInterface) SendUserTextMessage(s string, otherContents ...llm.Content) (*llm.Response, error) {
 	if m.sendUserTextMessageFunc != nil {
 		return m.sendUserTextMessageFunc(s, otherContents...)
@@ -357,6 +364,16 @@ func (m *MockConvoInterface) SubConvoWithHistory() *conversation.Convo {
 	return nil
 }
 
+func (m *MockConvoInterface) SetSampling(*llm.Sampling) {}
+func (m *MockConvoInterface) SetService(llm.Service)    {}
+
+func (m *MockConvoInterface) Estimate(pending []llm.Content) conversation.Estimate {
+	if m.estimateFunc != nil {
+		return m.estimateFunc(pending)
+	}
+	return conversation.Estimate{}
+}
+
 func (m *MockConvoInterface) DebugJSON() ([]byte, error) {
 	if m.debugJSONFunc != nil {
 		return m.debugJSONFunc()
@@ -501,8 +518,9 @@ func TestAgentStateMachine(t *testing.T) {
 
 // mockConvoInterface is a mock implementation of ConvoInterface for testing
 type mockConvoInterface struct {
-	SendMessageFunc        func(message llm.Message) (*llm.Response, error)
-	ToolResultContentsFunc func(ctx context.Context, resp *llm.Response) ([]llm.Content, bool, error)
+	SendMessageFunc             func(message llm.Message) (*llm.Response, error)
+	SendMessageWithoutToolsFunc func(message llm.Message) (*llm.Response, error)
+	ToolResultContentsFunc      func(ctx context.Context, resp *llm.Response) ([]llm.Content, bool, error)
 }
 
 func (c *mockConvoInterface) GetID() string {
@@ -523,6 +541,9 @@ func (m *mockConvoInterface) LastUsage() llm.Usage {
 
 func (m *mockConvoInterface) ResetBudget(conversation.Budget) {}
 
+func (m *mockConvoInterface) SetSampling(*llm.Sampling) {}
+func (m *mockConvoInterface) SetService(llm.Service)    {}
+
 func (m *mockConvoInterface) OverBudget() error {
 	return nil
 }
@@ -534,6 +555,13 @@ func (m *mockConvoInterface) SendMessage(message llm.Message) (*llm.Response, er
 	return &llm.Response{StopReason: llm.StopReasonEndTurn}, nil
 }
 
+func (m *mockConvoInterface) SendMessageWithoutTools(message llm.Message) (*llm.Response, error) {
+	if m.SendMessageWithoutToolsFunc != nil {
+		return m.SendMessageWithoutToolsFunc(message)
+	}
+	return m.SendMessage(message)
+}
+
 func (m *mockConvoInterface) SendUserTextMessage(s string, otherContents ...llm.Content) (*llm.Response, error) {
 	return m.SendMessage(llm.UserStringMessage(s))
 }
@@ -553,6 +581,10 @@ func (m *mockConvoInterface) CancelToolUse(toolUseID string, cause error) error
 	return nil
 }
 
+func (m *mockConvoInterface) Estimate(pending []llm.Content) conversation.Estimate {
+	return conversation.Estimate{}
+}
+
 func (m *mockConvoInterface) DebugJSON() ([]byte, error) {
 	return []byte(`[{"role": "user", "content": [{"type": "text", "text": "mock conversation"}]}]`), nil
 }
@@ -953,3 +985,75 @@ func TestSystemPromptIncludesDateTime(t *testing.T) {
 		}
 	}
 }
+
+func TestSystemPromptLanguage(t *testing.T) {
+	render := func(lang string) string {
+		agent := NewAgent(AgentConfig{Context: context.Background(), Language: lang})
+		agent.now = func() time.Time { return time.Date(2025, 7, 25, 0, 0, 0, 0, time.UTC) }
+		return agent.renderSystemPrompt()
+	}
+	if prompt := render(""); strings.Contains(prompt, "Always write to the user in") {
+		t.Error("System prompt should not mention a language by default")
+	}
+	if prompt := render("Japanese"); !strings.Contains(prompt, "Always write to the user in Japanese") {
+		t.Error("System prompt should ask for replies in the configured language")
+	}
+}
+
+func TestSystemPromptLargeCodebase(t *testing.T) {
+	agent := NewAgent(AgentConfig{Context: context.Background()})
+	agent.now = func() time.Time { return time.Date(2025, 7, 25, 0, 0, 0, 0, time.UTC) }
+	agent.codebase = &onstart.Codebase{
+		TotalFiles:      3,
+		ExtensionCounts: map[string]int{".go": 3},
+		Directories: []onstart.DirectorySummary{
+			{Path: "api", Files: 3, ExtensionCounts: map[string]int{".go": 3}, Sampled: true},
+			{Path: "web", Skipped: true},
+		},
+		OmittedFiles: 7,
+		Partial:      true,
+	}
+	prompt := agent.renderSystemPrompt()
+	for _, want := range []string{"api/: 3+ files (sampled); .go: 3 (100%)", "web/: not analyzed", "<omitted_files>7 more"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("System prompt should contain %q", want)
+		}
+	}
+}
+
+func TestInitIdempotent(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir) // Init changes directory; this restores it afterwards
+	agent := NewAgent(AgentConfig{
+		Context:    context.Background(),
+		WorkingDir: dir,
+		Service:    &ant.Service{},
+		SessionID:  "init-session",
+	})
+
+	ini := AgentInit{NoGit: true, HostAddr: "127.0.0.1:1234"}
+	if err := agent.Init(ini); err != nil {
+		t.Fatal(err)
+	}
+	// A retried /init with the same settings succeeds.
+	if err := agent.Init(ini); err != nil {
+		t.Errorf("repeating Init: %v", err)
+	}
+	moved := AgentInit{NoGit: true, HostAddr: "127.0.0.1:5678"}
+	if err := agent.Init(moved); !errors.Is(err, ErrInitConflict) {
+		t.Errorf("Init with a new address: err = %v, want ErrInitConflict", err)
+	}
+
+	if err := agent.Reinit(moved); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := agent.URL(), "http://127.0.0.1:5678"; got != want {
+		t.Errorf("URL() after Reinit = %q, want %q", got, want)
+	}
+	if err := agent.Init(moved); err != nil {
+		t.Errorf("Init with the reinitialized settings: %v", err)
+	}
+	if err := agent.Reinit(AgentInit{HostAddr: "127.0.0.1:5678"}); err == nil {
+		t.Error("Reinit accepted a change to more than the address")
+	}
+}
@@ -0,0 +1,157 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sketch.dev/claudetool/attest"
+	"sketch.dev/llm"
+)
+
+// An Attestation is what the build_attestation tool made for a set of build
+// outputs: an SBOM and a provenance statement, kept as the message's artifacts.
+type Attestation struct {
+	Subjects  []attest.Subject `json:"subjects"`
+	Commit    string           `json:"commit"`
+	Dirty     bool             `json:"dirty,omitempty"` // tracked files had uncommitted changes
+	SBOMTool  string           `json:"sbom_tool"`
+	Packages  int              `json:"packages"`
+	Written   []string         `json:"written,omitempty"` // copies written to the tool's out_dir
+	Artifacts []Artifact       `json:"artifacts"`
+}
+
+func (a *Agent) attestTool() *llm.Tool {
+	return &llm.Tool{
+		Name: "build_attestation",
+		Description: `Describe release artifacts you built for teams with supply-chain requirements: an SBOM (SPDX JSON, from syft if installed) of the dependencies of the tree they were built from, and a SLSA provenance statement (in-toto JSON) naming this session as the builder, with the commit and the container's base image digest.
+Use it after building release artifacts, when asked for an SBOM or provenance. Commit first: the provenance records HEAD and notes uncommitted changes to tracked files. The documents are attached to the result for the user to download.`,
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "required": ["subjects"],
+  "properties": {
+    "subjects": {"type": "array", "items": {"type": "string"}, "description": "Paths of the built files to attest"},
+    "command": {"type": "string", "description": "The command that built them, recorded in the provenance"},
+    "sbom_path": {"type": "string", "description": "Directory whose dependencies the SBOM lists; defaults to the repository root"},
+    "out_dir": {"type": "string", "description": "Also write the documents here, as sbom.spdx.json and provenance.intoto.json"}
+  }
+}`),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			var req struct {
+				Subjects []string `json:"subjects"`
+				Command  string   `json:"command"`
+				SBOMPath string   `json:"sbom_path"`
+				OutDir   string   `json:"out_dir"`
+			}
+			if err := json.Unmarshal(input, &req); err != nil {
+				return llm.ErrorfToolOut("failed to parse build_attestation input: %w", err)
+			}
+			if len(req.Subjects) == 0 {
+				return llm.ErrorfToolOut("subjects is required")
+			}
+			at, err := a.attest(ctx, req.Subjects, req.Command, req.SBOMPath, req.OutDir)
+			if err != nil {
+				return llm.ErrorToolOut(err)
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent(at.String()), Display: at}
+		},
+	}
+}
+
+// attest makes the SBOM and provenance statement for the build outputs at subjects.
+func (a *Agent) attest(ctx context.Context, subjects []string, command, sbomPath, outDir string) (Attestation, error) {
+	var at Attestation
+	var paths []string
+	for _, s := range subjects {
+		paths = append(paths, a.workingPath(s))
+	}
+	subs, err := attest.Subjects(a.repoRoot, paths)
+	if err != nil {
+		return at, err
+	}
+	b := attest.Builder{SessionID: a.config.SessionID, Origin: a.config.OriginalGitOrigin}
+	if img := a.config.Image; img != nil {
+		b.BaseImage, b.BaseDigest = img.BaseImage, img.BaseDigest
+	}
+	stmt, dirty, err := attest.NewStatement(ctx, a.repoRoot, b, subs, command)
+	if err != nil {
+		return at, err
+	}
+	provenance, err := json.MarshalIndent(stmt, "", "  ")
+	if err != nil {
+		return at, err
+	}
+	sbomDir := a.repoRoot
+	if sbomPath != "" {
+		sbomDir = a.workingPath(sbomPath)
+	}
+	sbom, err := attest.NewSBOM(ctx, sbomDir)
+	if err != nil {
+		return at, fmt.Errorf("making the SBOM: %w", err)
+	}
+
+	at = Attestation{
+		Subjects: subs,
+		Commit:   stmt.Commit(),
+		Dirty:    dirty,
+		SBOMTool: sbom.Tool,
+		Packages: sbom.Packages,
+	}
+	if outDir != "" {
+		dir := a.workingPath(outDir)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return at, err
+		}
+		for name, data := range map[string][]byte{"sbom.spdx.json": sbom.Document, "provenance.intoto.json": provenance} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return at, err
+			}
+			at.Written = append(at.Written, path)
+		}
+		slices.Sort(at.Written)
+	}
+	if art, ok := a.saveArtifact(ctx, ArtifactSBOM, "application/spdx+json", sbom.Document); ok {
+		at.Artifacts = append(at.Artifacts, art)
+	}
+	if art, ok := a.saveArtifact(ctx, ArtifactProvenance, "application/vnd.in-toto+json", provenance); ok {
+		at.Artifacts = append(at.Artifacts, art)
+	}
+	return at, nil
+}
+
+// workingPath resolves a path the model gave relative to the working directory.
+func (a *Agent) workingPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(a.workingDir, path)
+}
+
+// String summarizes the attestation for the model.
+func (at Attestation) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Attested %d file(s) built from %s", len(at.Subjects), at.Commit)
+	if at.Dirty {
+		sb.WriteString(", with uncommitted changes to tracked files that the commit doesn't have")
+	}
+	sb.WriteString(":\n")
+	for _, s := range at.Subjects {
+		fmt.Fprintf(&sb, "- %s sha256:%s\n", s.Name, s.Digest["sha256"])
+	}
+	fmt.Fprintf(&sb, "The SBOM, by %s, lists %d package(s).\n", at.SBOMTool, at.Packages)
+	if at.SBOMTool != "syft" {
+		sb.WriteString("syft isn't installed, so it covers only the Go modules and npm packages in go.mod and package-lock.json files; install syft for other ecosystems.\n")
+	}
+	for _, art := range at.Artifacts {
+		fmt.Fprintf(&sb, "The %s is attached for the user as artifact %s.\n", art.Kind, art.ID)
+	}
+	for _, w := range at.Written {
+		fmt.Fprintf(&sb, "Wrote %s.\n", w)
+	}
+	return sb.String()
+}
@@ -0,0 +1,66 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestAttestTool(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n\nrequire golang.org/x/mod v0.24.0\n"), 0o644)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "app"), []byte("binary"), 0o755)
+
+	sessionID := "attest-" + filepath.Base(root)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(ArtifactsDir(sessionID))) })
+	a := &Agent{repoRoot: root, workingDir: root, config: AgentConfig{
+		SessionID: sessionID,
+		Image:     &ImageProvenance{BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:beef"},
+	}}
+	out := a.attestTool().Run(ctx, json.RawMessage(`{"subjects": ["app"], "command": "go build -o app", "out_dir": "dist"}`))
+	if out.Error != nil {
+		t.Fatal(out.Error)
+	}
+	at := out.Display.(Attestation)
+	if len(at.Artifacts) != 2 || at.Artifacts[0].Kind != ArtifactSBOM || at.Artifacts[1].Kind != ArtifactProvenance {
+		t.Fatalf("artifacts: %+v", at.Artifacts)
+	}
+	if len(at.Written) != 2 || !strings.HasSuffix(at.Written[0], "dist/provenance.intoto.json") {
+		t.Errorf("wrote %v", at.Written)
+	}
+
+	_, path, ok := a.ArtifactFile(at.Artifacts[1].ID)
+	if !ok {
+		t.Fatal("provenance artifact not stored")
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{`"name": "app"`, `"id": "urn:sketch:session:` + sessionID, `"sha256": "beef"`, `"command": "go build -o app"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("provenance lacks %s:\n%s", want, data)
+		}
+	}
+
+	// The tool's message carries the documents.
+	a.OnToolResult(ctx, nil, "t1", "build_attestation", nil, llm.Content{Display: out.Display}, nil, nil)
+	if len(a.history) != 1 || len(a.history[0].Artifacts) != 2 {
+		t.Errorf("tool message artifacts: %+v", a.history)
+	}
+}
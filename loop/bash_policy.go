@@ -0,0 +1,51 @@
+package loop
+
+import (
+	"cmp"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"sketch.dev/claudetool/bashkit"
+)
+
+// A BlockedCommand is an entry in the audit trail of bash commands the session's
+// bash policy rejected.
+type BlockedCommand struct {
+	Time      time.Time         `json:"time"`
+	SessionID string            `json:"session_id"`
+	Script    string            `json:"script"` // the whole bash tool input
+	Violation bashkit.Violation `json:"violation"`
+}
+
+// blockedCommandsPath is where a session's audit trail of blocked commands is kept.
+func blockedCommandsPath(sessionID string) string {
+	dir, err := SessionRecordDir()
+	if err != nil {
+		dir = filepath.Join(os.TempDir(), "sketch-sessions")
+	}
+	return filepath.Join(dir, cmp.Or(sessionID, "default"), "bash-blocked.jsonl")
+}
+
+// recordBlockedCommand adds a rejected bash script to the audit trail.
+func (a *Agent) recordBlockedCommand(script string, v *bashkit.Violation) {
+	bc := BlockedCommand{Time: time.Now(), SessionID: a.config.SessionID, Script: script, Violation: *v}
+	a.mu.Lock()
+	a.blockedCommands = append(a.blockedCommands, bc)
+	a.mu.Unlock()
+
+	ctx := a.config.Context
+	slog.WarnContext(ctx, "bash policy blocked a command", "command", v.Command, "rule", v.Rule, "reason", v.Reason)
+	if err := appendJSONLine(blockedCommandsPath(a.config.SessionID), bc); err != nil {
+		slog.WarnContext(ctx, "recording blocked command", "error", err)
+	}
+}
+
+// BlockedCommands returns the bash commands the bash policy rejected this session, oldest first.
+func (a *Agent) BlockedCommands() []BlockedCommand {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.blockedCommands)
+}
@@ -0,0 +1,32 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"sketch.dev/claudetool/bashkit"
+)
+
+func TestRecordBlockedCommand(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	a := &Agent{config: AgentConfig{SessionID: "bash-session", Context: context.Background()}}
+	a.recordBlockedCommand("ls && curl x", &bashkit.Violation{Command: "curl x", Reason: "not allowed"})
+
+	blocked := a.BlockedCommands()
+	if len(blocked) != 1 || blocked[0].Script != "ls && curl x" || blocked[0].Violation.Command != "curl x" {
+		t.Fatalf("BlockedCommands = %+v", blocked)
+	}
+	data, err := os.ReadFile(blockedCommandsPath("bash-session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged BlockedCommand
+	if err := json.Unmarshal(data, &logged); err != nil {
+		t.Fatal(err)
+	}
+	if logged.SessionID != "bash-session" || logged.Violation.Reason != "not allowed" {
+		t.Errorf("audit trail has %+v", logged)
+	}
+}
@@ -0,0 +1,41 @@
+package loop
+
+import (
+	"errors"
+
+	"sketch.dev/claudetool/browse"
+)
+
+// browseTools returns the browser the browser tools drive, starting from the
+// configured profile. The browser itself is only launched when first used.
+func (a *Agent) browseTools() *browse.BrowseTools {
+	a.browserOnce.Do(func() {
+		a.browser = browse.NewBrowseTools(a.config.Context)
+		a.browser.ProfileDir = a.config.BrowserProfileDir
+		a.browser.Profile = a.config.BrowserProfile
+		go func() {
+			<-a.config.Context.Done()
+			a.browser.Close()
+		}()
+	})
+	return a.browser
+}
+
+// BrowserProfiles lists the browser profiles in the profile directory.
+func (a *Agent) BrowserProfiles() ([]browse.Profile, error) {
+	if a.config.BrowserProfileDir == "" {
+		return nil, errors.New("browser profiles are not configured")
+	}
+	return browse.ListProfiles(a.config.BrowserProfileDir)
+}
+
+// ApplyBrowserProfile switches the browser to the named profile.
+func (a *Agent) ApplyBrowserProfile(name string) (*browse.Profile, error) {
+	return a.browseTools().ApplyProfile(name)
+}
+
+// SaveBrowserState saves the browser's cookies and the local storage of the
+// page it has open to the named profile, for later sessions to start from.
+func (a *Agent) SaveBrowserState(name string) (*browse.Profile, error) {
+	return a.browseTools().SaveState(name)
+}
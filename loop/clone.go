@@ -0,0 +1,75 @@
+package loop
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A CloneKind is a way for the container to get the repository's git history.
+type CloneKind string
+
+const (
+	// CloneAuto picks one of the others by the repository's size. It is resolved
+	// before the container starts, so the agent never sees it.
+	CloneAuto CloneKind = "auto"
+	// CloneFull copies all the git objects into the image, and clones from there.
+	CloneFull CloneKind = "full"
+	// ClonePartial clones commits and trees from the host's git server, which
+	// serves file contents as git needs them.
+	ClonePartial CloneKind = "partial"
+	// CloneShallow clones the history since a date from the host's git server.
+	CloneShallow CloneKind = "shallow"
+)
+
+// A CloneStrategy is how the container gets the repository's git history.
+type CloneStrategy struct {
+	Kind CloneKind
+	// Since is the date of the oldest commits a CloneShallow keeps;
+	// zero keeps only the commit being checked out.
+	Since time.Time
+}
+
+const cloneSinceLayout = "2006-01-02"
+
+// ParseCloneStrategy parses the -clone-strategy flag: auto, full, partial,
+// shallow, or shallow:YYYY-MM-DD. Empty means CloneAuto.
+func ParseCloneStrategy(s string) (CloneStrategy, error) {
+	kind, since, dated := strings.Cut(s, ":")
+	switch k := CloneKind(kind); {
+	case k == "" && !dated:
+		return CloneStrategy{Kind: CloneAuto}, nil
+	case (k == CloneAuto || k == CloneFull || k == ClonePartial || k == CloneShallow) && !dated:
+		return CloneStrategy{Kind: k}, nil
+	case k == CloneShallow:
+		t, err := time.Parse(cloneSinceLayout, since)
+		if err != nil {
+			return CloneStrategy{}, fmt.Errorf("clone strategy %q: want a date such as shallow:2025-01-31", s)
+		}
+		return CloneStrategy{Kind: k, Since: t}, nil
+	}
+	return CloneStrategy{}, fmt.Errorf("unknown clone strategy %q: want %q, %q, %q, or %q", s, CloneAuto, CloneFull, ClonePartial, CloneShallow)
+}
+
+// String returns s in the form ParseCloneStrategy takes.
+func (s CloneStrategy) String() string {
+	if s.Kind == CloneShallow && !s.Since.IsZero() {
+		return string(s.Kind) + ":" + s.Since.Format(cloneSinceLayout)
+	}
+	return string(s.Kind)
+}
+
+// cloneArgs returns the git clone arguments that get the history as s says.
+func (s CloneStrategy) cloneArgs() []string {
+	switch s.Kind {
+	case ClonePartial:
+		return []string{"--filter=blob:none"}
+	case CloneShallow:
+		if s.Since.IsZero() {
+			return []string{"--depth=1"}
+		}
+		return []string{"--shallow-since=" + s.Since.Format(cloneSinceLayout)}
+	}
+	// TODO: --reference-if-able instead?
+	return []string{"--reference", "/git-ref"}
+}
@@ -0,0 +1,34 @@
+package loop
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseCloneStrategy(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want string
+		args []string
+	}{
+		{"", "auto", []string{"--reference", "/git-ref"}},
+		{"full", "full", []string{"--reference", "/git-ref"}},
+		{"partial", "partial", []string{"--filter=blob:none"}},
+		{"shallow", "shallow", []string{"--depth=1"}},
+		{"shallow:2025-01-31", "shallow:2025-01-31", []string{"--shallow-since=2025-01-31"}},
+	} {
+		s, err := ParseCloneStrategy(tt.spec)
+		if err != nil {
+			t.Errorf("ParseCloneStrategy(%q): %v", tt.spec, err)
+			continue
+		}
+		if s.String() != tt.want || !slices.Equal(s.cloneArgs(), tt.args) {
+			t.Errorf("ParseCloneStrategy(%q) = %s with clone args %q, want %s with %q", tt.spec, s, s.cloneArgs(), tt.want, tt.args)
+		}
+	}
+	for _, bad := range []string{"deep", "partial:2025-01-31", "shallow:last-year", ":2025-01-31"} {
+		if _, err := ParseCloneStrategy(bad); err == nil {
+			t.Errorf("ParseCloneStrategy(%q) succeeded", bad)
+		}
+	}
+}
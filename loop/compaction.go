@@ -0,0 +1,267 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm/conversation"
+)
+
+// A CompactionStrategy is how the agent frees up context once the conversation
+// nears the model's context window, or when the user asks it to.
+type CompactionStrategy string
+
+const (
+	// CompactSummary restarts the conversation from a summary of it that the model writes.
+	CompactSummary CompactionStrategy = "summary"
+	// CompactDropToolResults keeps the conversation but replaces the output of all
+	// but the latest tool calls with a placeholder. Once there is nothing left
+	// to drop, it falls back to CompactSummary.
+	CompactDropToolResults CompactionStrategy = "drop-tool-results"
+	// CompactKeepPinned restarts the conversation from the first user message and the
+	// pinned messages, verbatim, plus a recap of the session built from its history.
+	// It makes no request to the LLM.
+	CompactKeepPinned CompactionStrategy = "keep-pinned"
+)
+
+// ParseCompactionStrategy parses the -compaction flag. Empty means CompactSummary.
+func ParseCompactionStrategy(s string) (CompactionStrategy, error) {
+	switch st := CompactionStrategy(s); st {
+	case "":
+		return CompactSummary, nil
+	case CompactSummary, CompactDropToolResults, CompactKeepPinned:
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown compaction strategy %q: want %q, %q, or %q", s, CompactSummary, CompactDropToolResults, CompactKeepPinned)
+}
+
+// keepToolResults is how many of the latest conversation messages CompactDropToolResults leaves alone.
+const keepToolResults = 8
+
+const droppedToolResult = "[output dropped to free up context; run the tool again if you need it]"
+
+// ErrCompactBusy is returned by Compact when the agent is in the middle of a turn.
+var ErrCompactBusy = errors.New("the agent is busy; compact between turns, or cancel the turn first")
+
+// A compactRequest asks the agent loop, idle between turns, to compact the conversation.
+type compactRequest struct {
+	strategy CompactionStrategy
+	done     chan error
+}
+
+// compactionStrategy returns the session's compaction strategy.
+func (a *Agent) compactionStrategy() CompactionStrategy {
+	st, err := ParseCompactionStrategy(a.config.Compaction)
+	if err != nil {
+		return CompactSummary
+	}
+	return st
+}
+
+// CompactConversation compacts the current conversation with the session's
+// compaction strategy and carries on with the work.
+func (a *Agent) CompactConversation(ctx context.Context) error {
+	_, err := a.compact(ctx, a.compactionStrategy(), true)
+	return err
+}
+
+// Compact compacts the conversation now, with strategy or, if it is empty,
+// the session's compaction strategy. It only works between turns.
+// The context a restarted conversation begins from goes out with the next
+// user message rather than starting a turn of its own.
+func (a *Agent) Compact(ctx context.Context, strategy string) error {
+	st := a.compactionStrategy()
+	if strategy != "" {
+		var err error
+		if st, err = ParseCompactionStrategy(strategy); err != nil {
+			return err
+		}
+	}
+	req := compactRequest{strategy: st, done: make(chan error, 1)}
+	select {
+	case a.compactRequests <- req:
+	default:
+		return ErrCompactBusy
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// compactNow compacts the conversation for Compact while the loop waits for user input.
+func (a *Agent) compactNow(ctx context.Context, strategy CompactionStrategy) error {
+	a.stateMachine.Transition(ctx, StateCompacting, "Compacting conversation on request")
+	_, err := a.compact(ctx, strategy, false)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to compact conversation", "error", err)
+	}
+	a.stateMachine.Transition(ctx, StateWaitingForUserInput, "Compaction completed")
+	return err
+}
+
+// compact compacts the conversation with strategy and reports whether it
+// restarted the conversation. If resume is set, the restarted conversation
+// continues the work straight away; otherwise it waits for the next user message.
+// It must run on the agent loop's goroutine.
+func (a *Agent) compact(ctx context.Context, strategy CompactionStrategy, resume bool) (restarted bool, err error) {
+	if strategy == CompactDropToolResults {
+		if n := a.dropToolResults(); n > 0 {
+			a.pushToOutbox(ctx, AgentMessage{
+				Type:    CompactMessageType,
+				Content: fmt.Sprintf("📜 Dropped the output of %d earlier tool calls to manage token limits.", n),
+			})
+			return false, nil
+		}
+		strategy = CompactSummary
+	}
+
+	// Dump the entire message history to /tmp as JSON before compacting
+	dumpFile, err := a.dumpMessageHistoryToTmp(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to dump message history to /tmp", "error", err)
+		// Continue with compaction even if dump fails
+	}
+
+	a.mu.Lock()
+	history := slices.Clone(a.history)
+	a.mu.Unlock()
+
+	var restart, preserved string
+	if strategy == CompactKeepPinned {
+		restart = keepPinnedContext(history)
+		preserved = "Your first message and pinned messages are preserved below."
+	} else {
+		summary, err := a.generateConversationSummary(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to generate conversation summary: %w", err)
+		}
+		restart = "Here's a summary of our previous work:\n\n" + summary
+		if pinned := pinnedMessages(history); pinned != "" {
+			restart += "\n\n" + pinned
+		}
+		preserved = "Previous context preserved in summary below."
+	}
+
+	a.mu.Lock()
+
+	// Get usage information before resetting conversation
+	lastUsage := a.convo.LastUsage()
+	contextWindow := a.service().TokenContextWindow()
+	currentContextSize := lastUsage.InputTokens + lastUsage.CacheReadInputTokens + lastUsage.CacheCreationInputTokens
+
+	// Preserve cumulative usage across compaction
+	cumulativeUsage := a.convo.CumulativeUsage()
+
+	// Reset conversation state but keep all other state (git, working dir, etc.)
+	a.firstMessageIndex = len(a.history)
+	a.convo = a.initConvoWithUsage(&cumulativeUsage)
+	a.unconfirmed = nil // answers tool uses the new conversation doesn't have
+
+	a.mu.Unlock()
+
+	// Create informative compaction message with token details
+	compactionMsg := fmt.Sprintf("📜 Conversation compacted to manage token limits. %s\n\n"+
+		"**Token Usage:** %d / %d tokens (%.1f%% of context window)",
+		preserved, currentContextSize, contextWindow, float64(currentContextSize)/float64(contextWindow)*100)
+
+	a.pushToOutbox(ctx, AgentMessage{
+		Type:    CompactMessageType,
+		Content: compactionMsg,
+	})
+
+	// Create the message content with dump file information if available
+	messageContent := restart
+	if dumpFile != "" {
+		messageContent += fmt.Sprintf("\n\nThe complete message history has been dumped to %s for your reference if needed.", dumpFile)
+	}
+	if resume {
+		messageContent += "\n\nPlease continue with the work based on this summary. The session_recap tool lists the commands, edits, and test failures so far."
+	} else {
+		messageContent += "\n\nThe session_recap tool lists the commands, edits, and test failures so far. My next message says how to go on."
+	}
+
+	a.pushToOutbox(ctx, AgentMessage{
+		Type:    UserMessageType,
+		Content: messageContent,
+	})
+	if resume {
+		a.inbox <- messageContent
+	} else {
+		a.compacted = messageContent
+	}
+
+	return true, nil
+}
+
+// dropToolResults replaces the output of tool calls before the latest
+// keepToolResults messages of the conversation and returns how many it replaced.
+func (a *Agent) dropToolResults() int {
+	convo, ok := a.convo.(*conversation.Convo)
+	if !ok {
+		return 0
+	}
+	return convo.DropToolResults(keepToolResults, droppedToolResult)
+}
+
+// PinMessage pins or unpins the user or agent message at idx.
+// Compaction keeps pinned messages verbatim.
+func (a *Agent) PinMessage(idx int, pinned bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if idx < 0 || idx >= len(a.history) {
+		return fmt.Errorf("no message %d", idx)
+	}
+	m := &a.history[idx]
+	if (m.Type != UserMessageType && m.Type != AgentMessageType) || m.ParentConversationID != nil {
+		return fmt.Errorf("message %d is a %s message; only user and agent messages can be pinned", idx, m.Type)
+	}
+	m.Pinned = pinned
+	return nil
+}
+
+// pinnedMessages renders the pinned messages of history for a restarted conversation,
+// or returns "" if there are none.
+func pinnedMessages(history []AgentMessage) string {
+	var b strings.Builder
+	for _, m := range history {
+		if !m.Pinned {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString("Messages I pinned to keep, verbatim:")
+		}
+		who := "Me"
+		if m.Type == AgentMessageType {
+			who = "You"
+		}
+		fmt.Fprintf(&b, "\n\n%s (message %d):\n%s", who, m.Idx, m.Content)
+	}
+	return b.String()
+}
+
+// keepPinnedContext is what CompactKeepPinned restarts the conversation from:
+// the first user message, the pinned messages, and a recap of the session.
+func keepPinnedContext(history []AgentMessage) string {
+	var b strings.Builder
+	b.WriteString("The conversation was restarted to free up context.")
+	for _, m := range history {
+		if m.Type == UserMessageType && m.ParentConversationID == nil {
+			if !m.Pinned {
+				fmt.Fprintf(&b, "\n\nWhat I first asked for:\n%s", m.Content)
+			}
+			break
+		}
+	}
+	if pinned := pinnedMessages(history); pinned != "" {
+		b.WriteString("\n\n" + pinned)
+	}
+	b.WriteString("\n\nWhat you have tried so far:\n" + buildRecap(history).String())
+	return b.String()
+}
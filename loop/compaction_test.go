@@ -0,0 +1,57 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPinnedCompactionContext(t *testing.T) {
+	a := &Agent{history: []AgentMessage{
+		{Idx: 0, Type: UserMessageType, Content: "make the build faster"},
+		{Idx: 1, Type: ToolUseMessageType, ToolName: "bash", ToolInput: `{"command":"make"}`, ToolResult: "ok\n"},
+		{Idx: 2, Type: AgentMessageType, Content: "Caching the module downloads is the biggest win."},
+		{Idx: 3, Type: UserMessageType, Content: "don't touch the Dockerfile"},
+	}}
+	if err := a.PinMessage(1, true); err == nil {
+		t.Error("pinned a tool message")
+	}
+	if err := a.PinMessage(4, true); err == nil {
+		t.Error("pinned a message that doesn't exist")
+	}
+	for _, idx := range []int{2, 3} {
+		if err := a.PinMessage(idx, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := keepPinnedContext(a.history)
+	for _, want := range []string{
+		"What I first asked for:\nmake the build faster",
+		"You (message 2):\nCaching the module downloads",
+		"Me (message 3):\ndon't touch the Dockerfile",
+		"[exit 0] make",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("context lacks %q:\n%s", want, got)
+		}
+	}
+
+	if err := a.PinMessage(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := pinnedMessages(a.history); strings.Contains(got, "message 2") || !strings.Contains(got, "message 3") {
+		t.Errorf("pinned messages after unpinning 2:\n%s", got)
+	}
+}
+
+func TestCompactBusy(t *testing.T) {
+	a := &Agent{compactRequests: make(chan compactRequest)}
+	if err := a.Compact(context.Background(), "keep-pinned"); !errors.Is(err, ErrCompactBusy) {
+		t.Errorf("Compact with no loop waiting = %v, want ErrCompactBusy", err)
+	}
+	if err := a.Compact(context.Background(), "forget"); err == nil {
+		t.Error("Compact accepted an unknown strategy")
+	}
+}
@@ -0,0 +1,211 @@
+package loop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sketch.dev/i18n"
+	"sketch.dev/llm"
+)
+
+// Limits on what a context bundle carries into a message.
+const (
+	contextBudget   = 256 << 10 // bytes of file content, all files together
+	contextMaxFiles = 200
+)
+
+// A ContextBundle is the files the user attached to their next message. Their
+// contents go to the model with the message, each in a block naming its path.
+type ContextBundle struct {
+	Files    []BundledFile `json:"files"`
+	Bytes    int           `json:"bytes"`  // of content included, all files together
+	Budget   int           `json:"budget"` // the most Bytes may be
+	Warnings []string      `json:"warnings,omitempty"`
+}
+
+// A BundledFile is one file of a ContextBundle.
+type BundledFile struct {
+	Path      string `json:"path"` // relative to the repository root
+	Size      int64  `json:"size"`
+	Included  int    `json:"included"` // bytes of it included
+	Truncated bool   `json:"truncated,omitempty"`
+	Skipped   string `json:"skipped,omitempty"` // why none of it is included
+}
+
+// AttachContext adds the files at paths, relative to the repository root, to
+// those attached to the next message; a directory stands for the files in it
+// git doesn't ignore. It returns everything attached so far.
+func (a *Agent) AttachContext(ctx context.Context, paths []string) (ContextBundle, error) {
+	var rels []string
+	for _, p := range paths {
+		files, err := a.contextFiles(ctx, p)
+		if err != nil {
+			return ContextBundle{}, err
+		}
+		rels = append(rels, files...)
+	}
+	a.mu.Lock()
+	for _, rel := range rels {
+		if !slices.Contains(a.attachedPaths, rel) {
+			a.attachedPaths = append(a.attachedPaths, rel)
+		}
+	}
+	a.mu.Unlock()
+	return a.AttachedContext(), nil
+}
+
+// AttachedContext returns the files attached to the next message.
+func (a *Agent) AttachedContext() ContextBundle {
+	a.mu.Lock()
+	rels := slices.Clone(a.attachedPaths)
+	a.mu.Unlock()
+	b, _ := bundleFiles(a.repoRoot, rels)
+	return b
+}
+
+// ClearContext detaches all files from the next message.
+func (a *Agent) ClearContext() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attachedPaths = nil
+}
+
+// contextFiles resolves a path the user attached to the files it stands for,
+// relative to the repository root.
+func (a *Agent) contextFiles(ctx context.Context, path string) ([]string, error) {
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(a.repoRoot, path)
+	}
+	rel, err := filepath.Rel(a.repoRoot, abs)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("%s is outside the repository", path)
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{filepath.ToSlash(rel)}, nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard", "--", rel)
+	cmd.Dir = a.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing %s: %w", path, err)
+	}
+	var files []string
+	for _, f := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s has no files git doesn't ignore", path)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// bundleFiles reads the files at rels, relative to root, within the budget.
+// Files past the budget are cut short, then left out; so are files past
+// contextMaxFiles, binary files, and files that went away.
+func bundleFiles(root string, rels []string) (ContextBundle, []llm.Content) {
+	b := ContextBundle{Files: []BundledFile{}, Budget: contextBudget}
+	var contents []llm.Content
+	for i, rel := range rels {
+		f := BundledFile{Path: rel}
+		data, size, err := readHead(filepath.Join(root, rel), contextBudget-b.Bytes)
+		f.Size = size
+		switch {
+		case i >= contextMaxFiles:
+			f.Skipped = fmt.Sprintf("over the %d-file limit", contextMaxFiles)
+		case err != nil:
+			f.Skipped = err.Error()
+		case bytes.IndexByte(data, 0) >= 0:
+			f.Skipped = "binary"
+		case len(data) == 0 && size > 0:
+			f.Skipped = "over the size budget"
+		default:
+			f.Included = len(data)
+			f.Truncated = int64(len(data)) < size
+			b.Bytes += len(data)
+			contents = append(contents, attachedFileContent(f, data))
+		}
+		switch {
+		case f.Skipped != "":
+			b.Warnings = append(b.Warnings, fmt.Sprintf("%s left out: %s", rel, f.Skipped))
+		case f.Truncated:
+			b.Warnings = append(b.Warnings, fmt.Sprintf("%s cut to its first %d of %d bytes", rel, f.Included, f.Size))
+		}
+		b.Files = append(b.Files, f)
+	}
+	return b, contents
+}
+
+// readHead reads at most n bytes of the file at path, and returns them with the file's size.
+func readHead(path string, n int) ([]byte, int64, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, errors.New("no longer exists")
+		}
+		return nil, 0, err
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if fi.IsDir() {
+		return nil, 0, errors.New("is a directory")
+	}
+	data, err := io.ReadAll(io.LimitReader(fh, int64(max(n, 0))))
+	return data, fi.Size(), err
+}
+
+// attachedFileContent is the block that carries one attached file to the model.
+func attachedFileContent(f BundledFile, data []byte) llm.Content {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<attached_file path=%q", f.Path)
+	if f.Truncated {
+		fmt.Fprintf(&sb, " truncated=\"first %d of %d bytes\"", f.Included, f.Size)
+	}
+	sb.WriteString(">\n")
+	sb.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("</attached_file>")
+	return llm.StringContent(sb.String())
+}
+
+// takeAttachedContext detaches the files attached to the next message and
+// returns their blocks, telling the user what was cut short or left out.
+func (a *Agent) takeAttachedContext(ctx context.Context) []llm.Content {
+	a.mu.Lock()
+	rels := a.attachedPaths
+	a.attachedPaths = nil
+	a.mu.Unlock()
+	if len(rels) == 0 {
+		return nil
+	}
+	b, contents := bundleFiles(a.repoRoot, rels)
+	msg := a.localize(i18n.ContextAttached, len(contents), b.Bytes>>10)
+	if len(b.Warnings) > 0 {
+		msg += "\n" + a.localize(i18n.ContextTrimmed, b.Budget>>10, strings.Join(b.Warnings, "; "))
+	}
+	a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: msg})
+	if len(contents) == 0 {
+		return nil
+	}
+	return append([]llm.Content{llm.StringContent("The user attached these files to their message.")}, contents...)
+}
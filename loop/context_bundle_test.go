@@ -0,0 +1,87 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachContext(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.log\n")
+	write("main.go", "package main\n")
+	write("docs/a.md", "# A\n")
+	write("docs/b.md", "# B")
+	write("docs/debug.log", "noise\n")
+	write("logo.png", "\x89PNG\x00\x00")
+	write("big.txt", strings.Repeat("x", contextBudget+10))
+	if out, err := exec.Command("git", "-C", root, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %s: %v", out, err)
+	}
+
+	a := &Agent{repoRoot: root}
+	if _, err := a.AttachContext(ctx, []string{"../elsewhere"}); err == nil {
+		t.Error("attached a path outside the repository")
+	}
+	if _, err := a.AttachContext(ctx, []string{"missing.go"}); err == nil {
+		t.Error("attached a missing file")
+	}
+
+	b, err := a.AttachContext(ctx, []string{"docs", filepath.Join(root, "main.go"), "docs/a.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range b.Files {
+		paths = append(paths, f.Path)
+	}
+	if got := strings.Join(paths, " "); got != "docs/a.md docs/b.md main.go" {
+		t.Errorf("attached %s", got)
+	}
+	if b.Bytes != len("# A\n# Bpackage main\n") || len(b.Warnings) != 0 {
+		t.Errorf("bundle: %+v", b)
+	}
+
+	if _, err := a.AttachContext(ctx, []string{"logo.png", "big.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	b = a.AttachedContext()
+	png, big := b.Files[3], b.Files[4]
+	if !big.Truncated || big.Included != contextBudget-len("# A\n# Bpackage main\n") || b.Bytes != contextBudget {
+		t.Errorf("big.txt: %+v, bundle of %d bytes", big, b.Bytes)
+	}
+	if png.Skipped != "binary" || len(b.Warnings) != 2 {
+		t.Errorf("logo.png: %+v, warnings %q", png, b.Warnings)
+	}
+
+	contents := a.takeAttachedContext(ctx)
+	if len(contents) != 5 {
+		t.Fatalf("got %d content blocks, want an intro and 4 files", len(contents))
+	}
+	if got := contents[2].Text; got != "<attached_file path=\"docs/b.md\">\n# B\n</attached_file>" {
+		t.Errorf("docs/b.md block: %q", got)
+	}
+	if got := contents[4].Text; !strings.HasPrefix(got, "<attached_file path=\"big.txt\" truncated=\"first ") {
+		t.Errorf("big.txt block starts %q", got[:50])
+	}
+	if len(a.history) != 1 || !strings.Contains(a.history[0].Content, "logo.png left out: binary") {
+		t.Errorf("notice: %+v", a.history)
+	}
+	if b := a.AttachedContext(); len(b.Files) != 0 || a.takeAttachedContext(ctx) != nil {
+		t.Errorf("files still attached after the message: %+v", b)
+	}
+}
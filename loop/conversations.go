@@ -0,0 +1,78 @@
+package loop
+
+import (
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// A ConversationNode is one of the session's conversations: the main one,
+// or a subconversation the agent or a tool started for work done out of the
+// user's sight, such as naming the branch or summarizing a turn.
+// Parents come before their children in the list Conversations returns.
+type ConversationNode struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parent_id,omitempty"`
+	Purpose  string `json:"purpose,omitempty"`
+	Hidden   bool   `json:"hidden"` // its messages aren't shown in the timeline
+	Depth    int    `json:"depth"`  // 0 for a main conversation
+
+	StartTime    time.Time `json:"start_time"`
+	LastActivity time.Time `json:"last_activity"`
+	Responses    int       `json:"responses"`
+	ToolCalls    int       `json:"tool_calls"`
+	// The usage of this conversation's own requests, leaving out its children's.
+	InputTokens  uint64  `json:"input_tokens"`
+	OutputTokens uint64  `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// Conversations returns the session's conversations that have made requests,
+// with their ancestors, in the order they started.
+// A compaction starts a new main conversation, so there can be several roots.
+func (a *Agent) Conversations() []ConversationNode {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	nodes := make([]ConversationNode, 0, len(a.convoOrder))
+	for _, id := range a.convoOrder {
+		nodes = append(nodes, *a.convoNodes[id])
+	}
+	return nodes
+}
+
+// convoNode returns convo's node, adding it and its untracked ancestors.
+// a.mu must be held.
+func (a *Agent) convoNode(convo *conversation.Convo) *ConversationNode {
+	if n, ok := a.convoNodes[convo.ID]; ok {
+		return n
+	}
+	n := &ConversationNode{ID: convo.ID, Purpose: convo.Purpose, Hidden: convo.Hidden, StartTime: time.Now()}
+	if convo.Parent != nil {
+		parent := a.convoNode(convo.Parent)
+		n.ParentID = parent.ID
+		n.Depth = parent.Depth + 1
+	}
+	if a.convoNodes == nil {
+		a.convoNodes = map[string]*ConversationNode{}
+	}
+	a.convoNodes[n.ID] = n
+	a.convoOrder = append(a.convoOrder, n.ID)
+	n.LastActivity = n.StartTime
+	return n
+}
+
+// recordConvoResponse adds a response convo got to its node; resp is nil for a failed request.
+func (a *Agent) recordConvoResponse(convo *conversation.Convo, resp *llm.Response) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	n := a.convoNode(convo)
+	n.LastActivity = time.Now()
+	if resp == nil {
+		return
+	}
+	n.Responses++
+	n.InputTokens += resp.Usage.InputTokens + resp.Usage.CacheReadInputTokens + resp.Usage.CacheCreationInputTokens
+	n.OutputTokens += resp.Usage.OutputTokens
+	n.CostUSD += resp.Usage.CostUSD
+}
@@ -0,0 +1,44 @@
+package loop
+
+import (
+	"context"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+func TestConversations(t *testing.T) {
+	a := &Agent{}
+	main := conversation.New(context.Background(), nil, nil)
+	main.Purpose = "main"
+	sub := main.SubConvo()
+	sub.Purpose = "keyword search"
+	sub.Hidden = true
+	subsub := sub.SubConvo()
+
+	// A subconversation's first request adds its ancestors too.
+	a.recordConvoResponse(subsub, nil)
+	a.recordConvoResponse(sub, &llm.Response{Usage: llm.Usage{InputTokens: 10, CacheReadInputTokens: 5, OutputTokens: 3, CostUSD: 0.5}})
+	a.recordConvoResponse(sub, &llm.Response{Usage: llm.Usage{InputTokens: 1, OutputTokens: 1}})
+
+	got := a.Conversations()
+	if len(got) != 3 {
+		t.Fatalf("got %d conversations, want 3: %+v", len(got), got)
+	}
+	if got[0].ID != main.ID || got[0].ParentID != "" || got[0].Depth != 0 || got[0].Purpose != "main" {
+		t.Errorf("main: %+v", got[0])
+	}
+	if got[1].ID != sub.ID || got[1].ParentID != main.ID || got[1].Depth != 1 || !got[1].Hidden {
+		t.Errorf("sub: %+v", got[1])
+	}
+	if got[1].Responses != 2 || got[1].InputTokens != 16 || got[1].OutputTokens != 4 || got[1].CostUSD != 0.5 {
+		t.Errorf("sub usage: %+v", got[1])
+	}
+	if got[2].ParentID != sub.ID || got[2].Depth != 2 || got[2].Responses != 0 {
+		t.Errorf("subsub: %+v", got[2])
+	}
+	if got[0].Responses != 0 {
+		t.Errorf("main counts its children's responses: %+v", got[0])
+	}
+}
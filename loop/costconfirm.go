@@ -0,0 +1,62 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"sketch.dev/i18n"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// errCostUnconfirmed ends a turn whose first request awaits cost confirmation.
+var errCostUnconfirmed = errors.New("request held for cost confirmation")
+
+// EstimateCost estimates what sending msg to the model would cost now,
+// together with anything held back for confirmation, which goes out with it.
+func (a *Agent) EstimateCost(msg string) conversation.Estimate {
+	a.mu.Lock()
+	convo := a.convo
+	pending := slices.Clone(a.unconfirmed)
+	a.mu.Unlock()
+	if msg != "" {
+		pending = append(pending, llm.StringContent(msg))
+	}
+	return convo.Estimate(pending)
+}
+
+// confirmCost reports whether content may be sent to the model. If sending it
+// would cost more than the confirmation threshold, it asks the user to confirm
+// and holds content until their next message, which is sent along with it.
+func (a *Agent) confirmCost(ctx context.Context, content []llm.Content) bool {
+	threshold := a.config.ConfirmCost
+	if threshold <= 0 {
+		return true
+	}
+	est := a.convo.Estimate(content)
+	if !est.Priced || est.CostUSD <= threshold {
+		return true
+	}
+	if a.config.AutoConfirmCost {
+		slog.InfoContext(ctx, "auto-confirming costly request", "estimate_usd", est.CostUSD, "threshold_usd", threshold)
+		return true
+	}
+	a.mu.Lock()
+	a.unconfirmed = content
+	a.mu.Unlock()
+	a.stateMachine.Transition(ctx, StateAwaitingCostConfirmation, "Request held for cost confirmation")
+	a.pushToOutbox(ctx, budgetMessage(errors.New(a.localize(i18n.CostConfirm, est.CostUSD, threshold))))
+	return false
+}
+
+// takeUnconfirmed returns the content confirmCost held back, if any,
+// now that the user has replied to go ahead.
+func (a *Agent) takeUnconfirmed() []llm.Content {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	held := a.unconfirmed
+	a.unconfirmed = nil
+	return held
+}
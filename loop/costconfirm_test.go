@@ -0,0 +1,78 @@
+package loop
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+func TestConfirmCost(t *testing.T) {
+	var sent []llm.Message
+	mockConvo := &MockConvoInterface{
+		sendMessageFunc: func(message llm.Message) (*llm.Response, error) {
+			sent = append(sent, message)
+			return &llm.Response{
+				Role:       llm.MessageRoleAssistant,
+				Content:    []llm.Content{llm.StringContent("done")},
+				StopReason: llm.StopReasonEndTurn,
+			}, nil
+		},
+		estimateFunc: func(pending []llm.Content) conversation.Estimate {
+			return conversation.Estimate{NewTokens: conversation.EstimateTokens(pending), CostUSD: 2.5, Priced: true}
+		},
+	}
+	agent := &Agent{
+		config:               AgentConfig{ConfirmCost: 1},
+		convo:                mockConvo,
+		inbox:                make(chan string, 10),
+		subscribers:          []chan *AgentMessage{},
+		outstandingLLMCalls:  make(map[string]struct{}),
+		outstandingToolCalls: make(map[string]string),
+		stateMachine:         NewStateMachine(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	agent.inbox <- "summarize every file"
+	if err := agent.processTurn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Fatalf("sent %d messages before confirmation", len(sent))
+	}
+	if got := agent.CurrentStateName(); got != StateAwaitingCostConfirmation.String() {
+		t.Errorf("state = %s, want %s", got, StateAwaitingCostConfirmation)
+	}
+	agent.mu.Lock()
+	last := agent.history[len(agent.history)-1]
+	agent.mu.Unlock()
+	if last.Type != BudgetMessageType || !last.EndOfTurn || !strings.Contains(last.Content, "$2.50") {
+		t.Errorf("confirmation message = %+v", last)
+	}
+	if est := agent.EstimateCost("yes"); est.NewTokens == 0 {
+		t.Errorf("EstimateCost leaves out the held message: %+v", est)
+	}
+
+	// Replying goes ahead, sending the held message with the reply.
+	agent.inbox <- "yes, go ahead"
+	if err := agent.processTurn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || len(sent[0].Content) != 2 || sent[0].Content[0].Text != "summarize every file" || sent[0].Content[1].Text != "yes, go ahead" {
+		t.Fatalf("sent %+v, want the held message followed by the reply", sent)
+	}
+
+	// Auto-confirmation never holds anything back.
+	agent.config.AutoConfirmCost = true
+	agent.inbox <- "again"
+	if err := agent.processTurn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 {
+		t.Errorf("auto-confirmed message not sent")
+	}
+}
@@ -0,0 +1,100 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sketch.dev/git_tools"
+	"sketch.dev/llm"
+)
+
+// DiffStructured returns the changes between two revisions file by file, with
+// renames, binary files, and hunk boundaries. An empty from is sketch-base; an empty
+// to is the working directory. Diffs between commits are cached.
+func (a *Agent) DiffStructured(from, to string) ([]git_tools.FileDiff, error) {
+	if a.diffs == nil {
+		return nil, fmt.Errorf("structured diffs are only available in git repositories")
+	}
+	if from == "" {
+		from = a.SketchGitBaseRef()
+	}
+	return a.diffs.Diff(context.Background(), from, to)
+}
+
+// diffTool returns the git_diff tool, which summarizes diffs without their content.
+func (a *Agent) diffTool() *llm.Tool {
+	return &llm.Tool{
+		Name: "git_diff",
+		Description: `List the files changed between two revisions, with their status (added, modified, deleted, renamed, copied), line counts, whether they are binary, and the line ranges of each hunk.
+Use it to get an overview of a change before reading the parts that matter; it does not show the changed lines.`,
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "properties": {
+    "from": {"type": "string", "description": "Revision to diff from; defaults to sketch-base, where this session started"},
+    "to": {"type": "string", "description": "Revision to diff to; defaults to the working directory"},
+    "path": {"type": "string", "description": "Only list files under this path"}
+  }
+}`),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			var req struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+				Path string `json:"path"`
+			}
+			if err := json.Unmarshal(input, &req); err != nil {
+				return llm.ErrorfToolOut("failed to parse git_diff input: %w", err)
+			}
+			files, err := a.DiffStructured(req.From, req.To)
+			if err != nil {
+				return llm.ErrorToolOut(err)
+			}
+			if req.Path != "" {
+				var under []git_tools.FileDiff
+				for _, f := range files {
+					if underPath(f.Path, req.Path) || underPath(f.OldPath, req.Path) {
+						under = append(under, f)
+					}
+				}
+				files = under
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent(formatFileDiffs(files)), Display: files}
+		},
+	}
+}
+
+// underPath reports whether file is dir or inside it.
+func underPath(file, dir string) bool {
+	dir = strings.TrimSuffix(dir, "/")
+	return file != "" && (file == dir || strings.HasPrefix(file, dir+"/"))
+}
+
+// formatFileDiffs renders files for the model, a line per file and per hunk.
+func formatFileDiffs(files []git_tools.FileDiff) string {
+	if len(files) == 0 {
+		return "No changes."
+	}
+	var b strings.Builder
+	for _, f := range files {
+		switch {
+		case f.OldPath != "":
+			fmt.Fprintf(&b, "%s %s -> %s (%d%% similar)", f.Status, f.OldPath, f.Path, f.Similarity)
+		default:
+			fmt.Fprintf(&b, "%s %s", f.Status, f.Path)
+		}
+		if f.Binary {
+			b.WriteString(" binary\n")
+			continue
+		}
+		fmt.Fprintf(&b, " +%d -%d\n", f.Additions, f.Deletions)
+		for _, h := range f.Hunks {
+			fmt.Fprintf(&b, "  @@ -%d,%d +%d,%d @@", h.OldStart, h.OldLines, h.NewStart, h.NewLines)
+			if h.Header != "" {
+				b.WriteString(" " + h.Header)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,42 @@
+package loop
+
+import (
+	"testing"
+
+	"sketch.dev/git_tools"
+)
+
+func TestFormatFileDiffs(t *testing.T) {
+	files := []git_tools.FileDiff{
+		{Path: "main.go", Status: "M", Additions: 2, Deletions: 1, Hunks: []git_tools.Hunk{{OldStart: 3, OldLines: 4, NewStart: 3, NewLines: 5, Header: "func main() {"}}},
+		{Path: "cmd/hello.go", OldPath: "hello.go", Status: "R", Similarity: 100},
+		{Path: "logo.png", Status: "A", Binary: true},
+	}
+	want := `M main.go +2 -1
+  @@ -3,4 +3,5 @@ func main() {
+R hello.go -> cmd/hello.go (100% similar) +0 -0
+A logo.png binary`
+	if got := formatFileDiffs(files); got != want {
+		t.Errorf("formatFileDiffs =\n%s\nwant\n%s", got, want)
+	}
+	if got := formatFileDiffs(nil); got != "No changes." {
+		t.Errorf("formatFileDiffs(nil) = %q", got)
+	}
+}
+
+func TestUnderPath(t *testing.T) {
+	for _, tc := range []struct {
+		file, dir string
+		want      bool
+	}{
+		{"cmd/hello.go", "cmd", true},
+		{"cmd/hello.go", "cmd/", true},
+		{"cmd/hello.go", "cmd/hello.go", true},
+		{"cmdline/x.go", "cmd", false},
+		{"", "cmd", false},
+	} {
+		if got := underPath(tc.file, tc.dir); got != tc.want {
+			t.Errorf("underPath(%q, %q) = %v", tc.file, tc.dir, got)
+		}
+	}
+}
@@ -3,8 +3,14 @@ package loop
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
 
+	"sketch.dev/claudetool"
 	"sketch.dev/claudetool/codereview"
+	"sketch.dev/claudetool/todoscan"
 	"sketch.dev/llm"
 )
 
@@ -13,32 +19,65 @@ import (
 // not as reliable as it could be. Historically, we've found that Claude ignores
 // the tool results here, so we don't tell the tool to say "hey, really check this"
 // at the moment, though we've tried.
-func makeDoneTool(codereview *codereview.CodeReviewer) *llm.Tool {
+//
+// If gates is non-nil, the tool also refuses to finish until every quality gate passes,
+// listing the unmet ones as requirements. If todos is non-nil, the tool warns about
+// TODO-style comments the agent added but neither tracked nor mentioned in what it said.
+func makeDoneTool(codereview *codereview.CodeReviewer, gates *codereview.QualityGates, todos *todoscan.Scanner, said func() []string) *llm.Tool {
 	return &llm.Tool{
 		Name:        "done",
 		Description: doneDescription,
 		InputSchema: json.RawMessage(doneChecklistJSONSchema),
 		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
-			// Cannot be done with a messy git.
-			if err := codereview.RequireNormalGitState(ctx); err != nil {
-				return llm.ErrorToolOut(err)
-			}
-			if err := codereview.RequireNoUncommittedChanges(ctx); err != nil {
-				return llm.ErrorToolOut(err)
+			// hg and jj repos have no code reviewer, nor git state to check.
+			if codereview != nil {
+				// Cannot be done with a messy git.
+				if err := codereview.RequireNormalGitState(ctx); err != nil {
+					return llm.ErrorToolOut(err)
+				}
+				if err := codereview.RequireNoUncommittedChanges(ctx); err != nil {
+					return llm.ErrorToolOut(err)
+				}
+				// Ensure that the current commit has been reviewed.
+				head, err := codereview.CurrentCommit(ctx)
+				if err == nil {
+					needsReview := !codereview.IsInitialCommit(head) && !codereview.HasReviewed(head)
+					if needsReview {
+						return llm.ErrorfToolOut("codereview tool has not been run for commit %v", head)
+					}
+				}
+				if reqs := codereview.CheckQualityGates(ctx, gates); len(reqs) > 0 {
+					return llm.ToolOut{Error: errors.New(formatRequirements(reqs)), Display: reqs}
+				}
 			}
-			// Ensure that the current commit has been reviewed.
-			head, err := codereview.CurrentCommit(ctx)
-			if err == nil {
-				needsReview := !codereview.IsInitialCommit(head) && !codereview.HasReviewed(head)
-				if needsReview {
-					return llm.ErrorfToolOut("codereview tool has not been run for commit %v", head)
+			text := "Please ask the user to review your work. Be concise - users are more likely to read shorter comments."
+			if todos != nil {
+				markers, err := todos.Unmentioned(ctx, claudetool.SessionID(ctx), said())
+				if err != nil {
+					slog.DebugContext(ctx, "done: failed to check for unmentioned TODOs", "err", err)
+				}
+				if w := todoscan.Warning(markers); w != "" {
+					text += "\n\n" + w
 				}
 			}
-			return llm.ToolOut{LLMContent: llm.TextContent("Please ask the user to review your work. Be concise - users are more likely to read shorter comments.")}
+			return llm.ToolOut{LLMContent: llm.TextContent(text)}
 		},
 	}
 }
 
+// formatRequirements renders unmet quality gates for the model.
+func formatRequirements(reqs []codereview.Requirement) string {
+	buf := new(strings.Builder)
+	buf.WriteString("This repository's quality gates are not met, so you are not done yet. Satisfy each requirement, commit, then call done again:\n")
+	for i, req := range reqs {
+		fmt.Fprintf(buf, "\n%d. [%s] %s\n", i+1, req.Gate, req.Requirement)
+		if req.Details != "" {
+			fmt.Fprintf(buf, "\n```\n%s\n```\n", req.Details)
+		}
+	}
+	return buf.String()
+}
+
 // TODO: this is ugly, maybe JSON-encode a deeply nested map[string]any instead? also ugly.
 const (
 	doneDescription         = `Use this tool when you have achieved the user's goal. The parameters form a checklist which you should evaluate.`
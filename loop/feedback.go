@@ -0,0 +1,134 @@
+package loop
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch.dev/skabandclient"
+)
+
+// Feedback is a user's rating of one agent message.
+// It carries enough about the message to aggregate ratings by the kind of action rated.
+type Feedback struct {
+	SessionID   string                 `json:"session_id"`
+	MessageIdx  int                    `json:"message_idx"`
+	Rating      string                 `json:"rating"` // "up" or "down"
+	Comment     string                 `json:"comment,omitempty"`
+	MessageType CodingAgentMessageType `json:"message_type"`
+	Tools       []string               `json:"tools,omitempty"` // tools the message called, or the tool it is the result of
+	Model       string                 `json:"model,omitempty"`
+	Time        time.Time              `json:"time"`
+}
+
+// maxFeedbackComment caps free-text feedback, which is stored and possibly synced verbatim.
+const maxFeedbackComment = 4000
+
+// feedbackPath is where a session's feedback is stored, one JSON object per line,
+// next to its uploads and session record. The log keeps every rating; a later
+// line for the same message supersedes the earlier ones.
+func feedbackPath(sessionID string) string {
+	dir, err := SessionRecordDir()
+	if err != nil {
+		dir = filepath.Join(os.TempDir(), "sketch-sessions")
+	}
+	return filepath.Join(dir, cmp.Or(sessionID, "default"), "feedback.jsonl")
+}
+
+// RecordFeedback rates the message at idx "up" or "down", with an optional comment,
+// replacing any earlier rating of it. It is stored with the session and,
+// if the session shares feedback, sent to skaband.
+func (a *Agent) RecordFeedback(ctx context.Context, idx int, rating, comment string) (Feedback, error) {
+	if rating != "up" && rating != "down" {
+		return Feedback{}, fmt.Errorf("rating must be \"up\" or \"down\", not %q", rating)
+	}
+	comment = strings.TrimSpace(comment)
+	if len(comment) > maxFeedbackComment {
+		return Feedback{}, fmt.Errorf("comment is too long: %d bytes, the limit is %d", len(comment), maxFeedbackComment)
+	}
+
+	a.mu.Lock()
+	if idx < 0 || idx >= len(a.history) {
+		a.mu.Unlock()
+		return Feedback{}, fmt.Errorf("no message %d", idx)
+	}
+	msg := a.history[idx]
+	fb := Feedback{
+		SessionID:   a.config.SessionID,
+		MessageIdx:  idx,
+		Rating:      rating,
+		Comment:     comment,
+		MessageType: msg.Type,
+		Model:       a.ModelName(),
+		Time:        time.Now().UTC(),
+	}
+	for _, tc := range msg.ToolCalls {
+		fb.Tools = append(fb.Tools, tc.Name)
+	}
+	if msg.ToolName != "" {
+		fb.Tools = append(fb.Tools, msg.ToolName)
+	}
+	if a.feedback == nil {
+		a.feedback = make(map[int]Feedback)
+	}
+	a.feedback[idx] = fb
+	a.mu.Unlock()
+
+	if err := appendJSONLine(feedbackPath(a.config.SessionID), fb); err != nil {
+		return Feedback{}, fmt.Errorf("storing feedback: %w", err)
+	}
+	if a.config.ShareFeedback && a.config.SkabandClient != nil {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			err := a.config.SkabandClient.SendFeedback(ctx, a.config.SessionID, fb)
+			switch {
+			case errors.Is(err, skabandclient.ErrQueued):
+				slog.InfoContext(ctx, "feedback queued until skaband is reachable", "error", err)
+			case err != nil:
+				slog.WarnContext(ctx, "sending feedback to skaband", "error", err)
+			}
+		}()
+	}
+	return fb, nil
+}
+
+// Feedback returns the current rating of each rated message, in message order.
+func (a *Agent) Feedback() []Feedback {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []Feedback
+	for _, idx := range slices.Sorted(maps.Keys(a.feedback)) {
+		out = append(out, a.feedback[idx])
+	}
+	return out
+}
+
+// appendJSONLine adds v to the JSON lines log at path, creating it as needed.
+func appendJSONLine(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
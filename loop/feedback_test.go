@@ -0,0 +1,49 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordFeedback(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	a := &Agent{
+		config: AgentConfig{SessionID: "fb-session", Model: "claude"},
+		history: []AgentMessage{
+			{Type: UserMessageType, Content: "make it faster"},
+			{Type: AgentMessageType, Content: "Rewriting it.", ToolCalls: []ToolCall{{Name: "bash"}, {Name: "patch"}}},
+		},
+	}
+	ctx := context.Background()
+
+	if _, err := a.RecordFeedback(ctx, 1, "meh", ""); err == nil {
+		t.Error("accepted a rating other than up or down")
+	}
+	if _, err := a.RecordFeedback(ctx, 5, "up", ""); err == nil {
+		t.Error("accepted a rating of a message that doesn't exist")
+	}
+	if _, err := a.RecordFeedback(ctx, 1, "up", ""); err != nil {
+		t.Fatal(err)
+	}
+	fb, err := a.RecordFeedback(ctx, 1, "down", "  broke the build ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb.Comment != "broke the build" || fb.Model != "claude" || strings.Join(fb.Tools, ",") != "bash,patch" {
+		t.Errorf("feedback = %+v", fb)
+	}
+
+	// The latest rating of a message wins, but the log keeps them all.
+	if got := a.Feedback(); len(got) != 1 || got[0].Rating != "down" {
+		t.Errorf("Feedback() = %+v", got)
+	}
+	data, err := os.ReadFile(feedbackPath("fb-session"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("feedback log has %d lines, want 2:\n%s", n, data)
+	}
+}
@@ -0,0 +1,226 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"sketch.dev/gittrace"
+)
+
+// DefaultCoAuthor is the co-author the agent's commits credit unless the
+// trailer policy says otherwise.
+const DefaultCoAuthor = "sketch <hello@sketch.dev>"
+
+// A TrailerPolicy says which trailers the prepare-commit-msg hook adds to the
+// agent's commits. The hook reads it from the sketch.trailers.* git config
+// settings, so a change applies from the next commit on.
+type TrailerPolicy struct {
+	CoAuthors []string `json:"co_authors"` // each "Name <email>", one Co-Authored-By trailer each
+	ChangeID  bool     `json:"change_id"`  // a Change-ID trailer, for tools that track commits across rebases
+	SignOff   bool     `json:"sign_off"`   // a Signed-off-by trailer for the git user, per the DCO
+}
+
+// DefaultTrailerPolicy credits sketch and adds a Change-ID.
+func DefaultTrailerPolicy() TrailerPolicy {
+	return TrailerPolicy{CoAuthors: []string{DefaultCoAuthor}, ChangeID: true}
+}
+
+// ParseTrailerPolicy parses the -git-trailers flag: "off", for no trailers,
+// or comma-separated settings changing the default policy, such as
+// "co-author=Jane Doe <jane@example.com>,change-id=off,signoff=on". The first
+// co-author replaces sketch; more add to it; "co-author=off" credits no one.
+// An empty spec is the default policy.
+func ParseTrailerPolicy(spec string) (TrailerPolicy, error) {
+	p := DefaultTrailerPolicy()
+	if strings.TrimSpace(spec) == "off" {
+		return TrailerPolicy{}, nil
+	}
+	coAuthorsSet := false
+	for kv := range strings.SplitSeq(spec, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return TrailerPolicy{}, fmt.Errorf("git trailer setting %q: want name=value", kv)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		switch k {
+		case "co-author":
+			if !coAuthorsSet {
+				p.CoAuthors, coAuthorsSet = nil, true
+			}
+			if v == "off" {
+				p.CoAuthors = nil
+				continue
+			}
+			p.CoAuthors = append(p.CoAuthors, v)
+		case "change-id", "signoff":
+			on, err := parseOnOff(v)
+			if err != nil {
+				return TrailerPolicy{}, fmt.Errorf("git trailer setting %s: %w", k, err)
+			}
+			if k == "change-id" {
+				p.ChangeID = on
+			} else {
+				p.SignOff = on
+			}
+		default:
+			return TrailerPolicy{}, fmt.Errorf("unknown git trailer setting %q (want co-author, change-id, or signoff)", k)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return TrailerPolicy{}, err
+	}
+	return p, nil
+}
+
+func parseOnOff(v string) (bool, error) {
+	switch v {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q: want on or off", v)
+}
+
+// Validate reports whether each co-author is a name and an address.
+func (p TrailerPolicy) Validate() error {
+	for _, c := range p.CoAuthors {
+		if _, err := parseNameAddr(c); err != nil {
+			return fmt.Errorf("co-author %q: %w", c, err)
+		}
+	}
+	return nil
+}
+
+func parseNameAddr(s string) (*mail.Address, error) {
+	a, err := mail.ParseAddress(s)
+	if err != nil {
+		return nil, errors.New(`want "Name <email>"`)
+	}
+	if a.Name == "" {
+		return nil, errors.New("no name")
+	}
+	return a, nil
+}
+
+// String formats p the way ParseTrailerPolicy reads it.
+func (p TrailerPolicy) String() string {
+	if len(p.CoAuthors) == 0 && !p.ChangeID && !p.SignOff {
+		return "off"
+	}
+	var parts []string
+	if len(p.CoAuthors) == 0 {
+		parts = append(parts, "co-author=off")
+	} else if !slices.Equal(p.CoAuthors, []string{DefaultCoAuthor}) {
+		for _, c := range p.CoAuthors {
+			parts = append(parts, "co-author="+c)
+		}
+	}
+	if !p.ChangeID {
+		parts = append(parts, "change-id=off")
+	}
+	if p.SignOff {
+		parts = append(parts, "signoff=on")
+	}
+	return strings.Join(parts, ",")
+}
+
+// GitIdentity is who the agent commits as, and the trailers it adds.
+type GitIdentity struct {
+	Name     string        `json:"name"`
+	Email    string        `json:"email"`
+	Trailers TrailerPolicy `json:"trailers"`
+}
+
+// GitIdentity returns who the agent commits as.
+func (a *Agent) GitIdentity() GitIdentity {
+	a.identityMu.Lock()
+	defer a.identityMu.Unlock()
+	id := a.gitIdentity
+	id.Trailers.CoAuthors = slices.Clone(id.Trailers.CoAuthors)
+	return id
+}
+
+// SetGitIdentity changes who the agent commits as from the next commit on.
+// Empty Name or Email keep the current ones.
+func (a *Agent) SetGitIdentity(ctx context.Context, id GitIdentity) error {
+	if !a.IsInContainer() {
+		// Outside a container, the git config is the user's own.
+		return errors.New("the git identity can only be changed in a container")
+	}
+	if err := id.Trailers.Validate(); err != nil {
+		return err
+	}
+	if strings.ContainsAny(id.Name, "<>\n") {
+		return fmt.Errorf("git user name %q can't contain <, >, or a newline", id.Name)
+	}
+	if id.Email != "" {
+		if _, err := mail.ParseAddress(id.Email); err != nil {
+			return fmt.Errorf("git user email %q: %w", id.Email, err)
+		}
+	}
+	a.identityMu.Lock()
+	defer a.identityMu.Unlock()
+	if id.Name == "" {
+		id.Name = a.gitIdentity.Name
+	}
+	if id.Email == "" {
+		id.Email = a.gitIdentity.Email
+	}
+	if err := writeGitIdentity(ctx, a.gitState.trace, a.workingDir, id, true); err != nil {
+		return err
+	}
+	a.gitIdentity = id
+	return nil
+}
+
+// writeGitIdentity records id in the global git config: the user, and, if
+// trailers is set, the policy the prepare-commit-msg hook reads.
+func writeGitIdentity(ctx context.Context, trace *gittrace.Recorder, dir string, id GitIdentity, trailers bool) error {
+	config := func(args ...string) error {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"config", "--global"}, args...)...)
+		cmd.Dir = dir
+		if out, err := trace.CombinedOutput(cmd); err != nil {
+			// --unset-all of a setting that isn't there exits 5.
+			if args[0] == "--unset-all" && cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == 5 {
+				return nil
+			}
+			return fmt.Errorf("git config --global %s: %s: %v", args[0], out, err)
+		}
+		return nil
+	}
+	if id.Email != "" {
+		if err := config("user.email", id.Email); err != nil {
+			return err
+		}
+	}
+	if id.Name != "" {
+		if err := config("user.name", id.Name); err != nil {
+			return err
+		}
+	}
+	if !trailers {
+		return nil
+	}
+	if err := config("--unset-all", "sketch.trailers.coauthor"); err != nil {
+		return err
+	}
+	for _, c := range id.Trailers.CoAuthors {
+		if err := config("--add", "sketch.trailers.coauthor", c); err != nil {
+			return err
+		}
+	}
+	if err := config("--bool", "sketch.trailers.changeid", fmt.Sprint(id.Trailers.ChangeID)); err != nil {
+		return err
+	}
+	return config("--bool", "sketch.trailers.signoff", fmt.Sprint(id.Trailers.SignOff))
+}
@@ -0,0 +1,103 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTrailerPolicy(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    TrailerPolicy
+		wantErr bool
+	}{
+		{spec: "", want: DefaultTrailerPolicy()},
+		{spec: "off", want: TrailerPolicy{}},
+		{spec: "change-id=off,signoff=on", want: TrailerPolicy{CoAuthors: []string{DefaultCoAuthor}, SignOff: true}},
+		{spec: "co-author=off", want: TrailerPolicy{ChangeID: true}},
+		{
+			spec: "co-author=Jane Doe <jane@example.com>, co-author=sketch <hello@sketch.dev>",
+			want: TrailerPolicy{CoAuthors: []string{"Jane Doe <jane@example.com>", DefaultCoAuthor}, ChangeID: true},
+		},
+		{spec: "co-author=jane@example.com", wantErr: true},
+		{spec: "signoff=yes", wantErr: true},
+		{spec: "gpg=on", wantErr: true},
+		{spec: "signoff", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTrailerPolicy(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTrailerPolicy(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if !slices.Equal(got.CoAuthors, tt.want.CoAuthors) || got.ChangeID != tt.want.ChangeID || got.SignOff != tt.want.SignOff {
+			t.Errorf("ParseTrailerPolicy(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+		if again, err := ParseTrailerPolicy(got.String()); err != nil || !slices.Equal(again.CoAuthors, got.CoAuthors) || again.ChangeID != got.ChangeID || again.SignOff != got.SignOff {
+			t.Errorf("ParseTrailerPolicy(%q) = %+v, %v; want %+v", got.String(), again, err, got)
+		}
+	}
+}
+
+func TestCommitTrailers(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not installed")
+	}
+	// The hook reads the policy from the global git config.
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "gitconfig"))
+	repo := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %s: %v", args[0], out, err)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+	if err := setupGitHooks(repo); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	commit := func(id GitIdentity, msg string) string {
+		t.Helper()
+		if err := writeGitIdentity(ctx, nil, repo, id, true); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(repo, "f.txt"), []byte(msg), 0o644)
+		git("add", ".")
+		git("commit", "-q", "-m", msg)
+		return strings.TrimSpace(git("log", "-1", "--format=%B"))
+	}
+
+	msg := commit(GitIdentity{Name: "Ada", Email: "ada@example.com", Trailers: DefaultTrailerPolicy()}, "first")
+	if !regexp.MustCompile(`^first\n\nCo-Authored-By: sketch <hello@sketch.dev>\nChange-ID: s[0-9a-f]{16}k$`).MatchString(msg) {
+		t.Errorf("default trailers:\n%s", msg)
+	}
+
+	msg = commit(GitIdentity{Name: "Ada", Email: "ada@example.com", Trailers: TrailerPolicy{
+		CoAuthors: []string{"Jane Doe <jane@example.com>"},
+		SignOff:   true,
+	}}, "second\n\nCo-Authored-By: Jane Doe <jane@example.com>")
+	if want := "second\n\nCo-Authored-By: Jane Doe <jane@example.com>\nSigned-off-by: Ada <ada@example.com>"; msg != want {
+		t.Errorf("custom trailers:\n%s\nwant:\n%s", msg, want)
+	}
+	if got := strings.TrimSpace(git("log", "-1", "--format=%an <%ae>")); got != "Ada <ada@example.com>" {
+		t.Errorf("author = %s", got)
+	}
+
+	if msg = commit(GitIdentity{Trailers: TrailerPolicy{}}, "third"); msg != "third" {
+		t.Errorf("no trailers:\n%s", msg)
+	}
+}
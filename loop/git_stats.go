@@ -0,0 +1,121 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"sketch.dev/git_tools"
+	"sketch.dev/sketchignore"
+)
+
+// maxStatCommits caps the commits GitStats breaks down, like the commit list.
+const maxStatCommits = 100
+
+// GitStats breaks down the changes from sketch-base to HEAD by file and by commit.
+type GitStats struct {
+	Additions    int                  `json:"additions"`
+	Deletions    int                  `json:"deletions"`
+	FilesChanged int                  `json:"files_changed"`
+	Files        []git_tools.FileStat `json:"files"`
+	Commits      []CommitStats        `json:"commits"` // Newest first
+}
+
+// CommitStats are the changes made by one commit.
+type CommitStats struct {
+	Hash      string               `json:"hash"`
+	Subject   string               `json:"subject"`
+	Additions int                  `json:"additions"`
+	Deletions int                  `json:"deletions"`
+	Files     []git_tools.FileStat `json:"files"`
+}
+
+// gitStatsCache holds the latest GitStats and the stats of every commit seen.
+type gitStatsCache struct {
+	last    GitStats
+	commits map[string]CommitStats // by hash
+	ignore  string                 // the .sketchignore the commit stats were filtered by
+}
+
+// GitStats returns the latest breakdown of the changes from sketch-base to HEAD.
+// It is empty until the first commit, and for non-git repos lists no files or commits.
+func (ags *AgentGitState) GitStats() GitStats {
+	ags.mu.Lock()
+	defer ags.mu.Unlock()
+	stats := ags.stats.last
+	stats.Additions, stats.Deletions = ags.linesAdded, ags.linesRemoved
+	return stats
+}
+
+// updateStats recomputes the stats from baseRef to HEAD, leaving out the files
+// the repository's .sketchignore ignores.
+// Commits don't change, so their stats are computed once. Callers hold ags.mu.
+func (ags *AgentGitState) updateStats(ctx context.Context, repoRoot, baseRef string) error {
+	ignore, err := sketchignore.Load(repoRoot)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to read .sketchignore", "error", err)
+	}
+	if ignore.String() != ags.stats.ignore {
+		// The cached commit stats were filtered differently.
+		ags.stats.commits = nil
+		ags.stats.ignore = ignore.String()
+	}
+	files, err := git_tools.GitDiffStat(repoRoot, baseRef, "HEAD")
+	if err != nil {
+		return err
+	}
+	files = withoutIgnored(files, ignore)
+	cmd := exec.CommandContext(ctx, "git", "log", "--format=%H%x00%s", "-n", fmt.Sprint(maxStatCommits), baseRef+"..HEAD")
+	cmd.Dir = repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git log: %w", err)
+	}
+	if ags.stats.commits == nil {
+		ags.stats.commits = make(map[string]CommitStats)
+	}
+	var commits []CommitStats
+	for line := range strings.SplitSeq(strings.TrimSpace(string(out)), "\n") {
+		hash, subject, ok := strings.Cut(line, "\x00")
+		if !ok {
+			continue
+		}
+		cs, ok := ags.stats.commits[hash]
+		if !ok {
+			files, err := git_tools.GitCommitStat(repoRoot, hash)
+			if err != nil {
+				return err
+			}
+			files = withoutIgnored(files, ignore)
+			cs = CommitStats{Hash: hash, Subject: subject, Files: files}
+			cs.Additions, cs.Deletions = sumStats(files)
+			ags.stats.commits[hash] = cs
+		}
+		commits = append(commits, cs)
+	}
+
+	ags.linesAdded, ags.linesRemoved = sumStats(files)
+	ags.stats.last = GitStats{FilesChanged: len(files), Files: files, Commits: commits}
+	return nil
+}
+
+// withoutIgnored drops the files ignore ignores; a rename counts if either side is kept.
+func withoutIgnored(files []git_tools.FileStat, ignore *sketchignore.Matcher) []git_tools.FileStat {
+	if ignore == nil {
+		return files
+	}
+	return slices.DeleteFunc(files, func(f git_tools.FileStat) bool {
+		return ignore.Ignored(f.Path) && (f.OldPath == "" || ignore.Ignored(f.OldPath))
+	})
+}
+
+func sumStats(files []git_tools.FileStat) (additions, deletions int) {
+	for _, f := range files {
+		additions += f.Additions
+		deletions += f.Deletions
+	}
+	return additions, deletions
+}
@@ -0,0 +1,24 @@
+package loop
+
+import (
+	"testing"
+
+	"sketch.dev/git_tools"
+	"sketch.dev/sketchignore"
+)
+
+func TestWithoutIgnored(t *testing.T) {
+	files := []git_tools.FileStat{
+		{Path: "main.go", Additions: 1},
+		{Path: "vendor/x/x.go", Additions: 1000},
+		{Path: "api/api.pb.go", Additions: 500},
+		{Path: "lib/y.go", OldPath: "vendor/y/y.go"}, // moved out of vendor: kept
+	}
+	got := withoutIgnored(files, sketchignore.Parse("vendor/\n*.pb.go\n"))
+	if len(got) != 2 || got[0].Path != "main.go" || got[1].Path != "lib/y.go" {
+		t.Errorf("withoutIgnored = %+v", got)
+	}
+	if got := withoutIgnored([]git_tools.FileStat{{Path: "a"}}, nil); len(got) != 1 {
+		t.Errorf("withoutIgnored with no .sketchignore dropped files: %+v", got)
+	}
+}
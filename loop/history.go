@@ -0,0 +1,200 @@
+package loop
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// A HistoryEntry is what the session history index keeps of one session record.
+type HistoryEntry struct {
+	SessionID string    `json:"session_id"`
+	Path      string    `json:"path"` // the session record, for -resume-from
+	Prompt    string    `json:"prompt"`
+	Outcome   string    `json:"outcome,omitempty"`
+	Branch    string    `json:"branch,omitempty"`
+	Repo      string    `json:"repo,omitempty"`
+	Commit    string    `json:"commit,omitempty"`
+	Files     []string  `json:"files,omitempty"` // paths the agent's tools were pointed at
+	SavedAt   time.Time `json:"saved_at"`
+
+	text string // the user's messages, lowercased, for search
+}
+
+// A HistoryMatch is a session found by SearchHistory.
+type HistoryMatch struct {
+	HistoryEntry
+	Matched []string `json:"matched"` // which of prompt, messages, files, branch, and repo matched
+}
+
+// historyIndexName is the index of the session records in SessionRecordDir.
+// It only caches what reading the records would give, so it can be deleted at any time.
+const historyIndexName = ".history-index.json"
+
+// historyIndexVersion is bumped whenever what gets indexed changes, to reindex everything.
+const historyIndexVersion = 1
+
+type historyIndex struct {
+	Version int                          `json:"version"`
+	Records map[string]historyIndexEntry `json:"records"` // by record file name
+}
+
+type historyIndexEntry struct {
+	ModTime time.Time    `json:"mod_time"`
+	Entry   HistoryEntry `json:"entry"`
+	Text    string       `json:"text"`
+}
+
+// SearchHistory finds the session records in dir whose prompt, user messages,
+// touched files, branch, or repository contain every word of query, ignoring case.
+// An empty query matches every session. Matches are newest first.
+//
+// Reading every record on every search would get slow as they pile up, so
+// SearchHistory keeps an index next to them and only rereads records that changed.
+func SearchHistory(dir, query string) ([]HistoryMatch, error) {
+	idx, err := updateHistoryIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	terms := strings.Fields(strings.ToLower(query))
+	var matches []HistoryMatch
+	for _, rec := range idx.Records {
+		e := rec.Entry
+		e.text = rec.Text
+		if m, ok := matchHistory(e, terms); ok {
+			matches = append(matches, m)
+		}
+	}
+	slices.SortFunc(matches, func(a, b HistoryMatch) int { return b.SavedAt.Compare(a.SavedAt) })
+	return matches, nil
+}
+
+// matchHistory reports whether every term appears somewhere in e, and where.
+func matchHistory(e HistoryEntry, terms []string) (HistoryMatch, bool) {
+	fields := []struct {
+		name string
+		text string
+	}{
+		{"prompt", strings.ToLower(e.Prompt)},
+		{"messages", e.text},
+		{"files", strings.ToLower(strings.Join(e.Files, "\n"))},
+		{"branch", strings.ToLower(e.Branch)},
+		{"repo", strings.ToLower(e.Repo)},
+	}
+	m := HistoryMatch{HistoryEntry: e, Matched: []string{}}
+	for _, term := range terms {
+		found := false
+		for _, f := range fields {
+			if strings.Contains(f.text, term) {
+				found = true
+				if !slices.Contains(m.Matched, f.name) {
+					m.Matched = append(m.Matched, f.name)
+				}
+			}
+		}
+		if !found {
+			return HistoryMatch{}, false
+		}
+	}
+	return m, true
+}
+
+// updateHistoryIndex brings the index in dir up to date with the records there
+// and returns it. Failing to save the index only costs time on the next search.
+func updateHistoryIndex(dir string) (*historyIndex, error) {
+	indexPath := filepath.Join(dir, historyIndexName)
+	var idx historyIndex
+	if data, err := os.ReadFile(indexPath); err == nil {
+		json.Unmarshal(data, &idx)
+	}
+	if idx.Version != historyIndexVersion || idx.Records == nil {
+		idx = historyIndex{Version: historyIndexVersion, Records: make(map[string]historyIndexEntry)}
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return &idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+	changed := false
+	seen := make(map[string]bool)
+	for _, de := range dirEntries {
+		name := de.Name()
+		if de.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		if old, ok := idx.Records[name]; ok && old.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+		rec, text, err := readHistoryEntry(filepath.Join(dir, name))
+		if err != nil {
+			continue // not a session record
+		}
+		idx.Records[name] = historyIndexEntry{ModTime: info.ModTime(), Entry: rec, Text: text}
+		changed = true
+	}
+	for name := range idx.Records {
+		if !seen[name] {
+			delete(idx.Records, name)
+			changed = true
+		}
+	}
+	if changed {
+		if data, err := json.Marshal(idx); err == nil {
+			os.WriteFile(indexPath, data, 0o600)
+		}
+	}
+	return &idx, nil
+}
+
+// readHistoryEntry reads what the index keeps of the session record at path.
+func readHistoryEntry(path string) (HistoryEntry, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return HistoryEntry{}, "", err
+	}
+	var r SessionRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return HistoryEntry{}, "", err
+	}
+	e := HistoryEntry{
+		SessionID: r.SessionID,
+		Path:      path,
+		Prompt:    r.Prompt,
+		Outcome:   r.Outcome,
+		Branch:    r.Branch,
+		Repo:      r.Repo,
+		Commit:    r.Commit,
+		SavedAt:   r.SavedAt,
+	}
+	var text strings.Builder
+	for _, m := range r.Messages {
+		for _, c := range m.Content {
+			switch {
+			case m.Role == llm.MessageRoleUser && c.Type == llm.ContentTypeText:
+				text.WriteString(strings.ToLower(c.Text))
+				text.WriteByte('\n')
+			case c.Type == llm.ContentTypeToolUse:
+				var input struct {
+					Path string `json:"path"`
+				}
+				if json.Unmarshal(c.ToolInput, &input) == nil && input.Path != "" && !slices.Contains(e.Files, input.Path) {
+					e.Files = append(e.Files, input.Path)
+				}
+			}
+		}
+	}
+	slices.Sort(e.Files)
+	return e, text.String(), nil
+}
@@ -0,0 +1,82 @@
+package loop
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestSearchHistory(t *testing.T) {
+	dir := t.TempDir()
+	save := func(rec *SessionRecord) {
+		t.Helper()
+		if err := rec.Save(filepath.Join(dir, rec.SessionID+".json")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(&SessionRecord{
+		SessionID: "old",
+		Prompt:    "Fix the flaky login test",
+		Branch:    "sketch/fix-login",
+		SavedAt:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Messages: []llm.Message{
+			{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("Fix the flaky login test")}},
+			{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+				{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "patch", ToolInput: json.RawMessage(`{"path":"/app/auth/login_test.go"}`)},
+			}},
+		},
+	})
+	save(&SessionRecord{
+		SessionID: "new",
+		Prompt:    "Speed up the build",
+		SavedAt:   time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC),
+		Messages: []llm.Message{
+			{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("Speed up the build")}},
+			{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("also cache the LOGIN fixtures")}},
+		},
+	})
+	os.WriteFile(filepath.Join(dir, "junk.json"), []byte("not json"), 0o600)
+
+	search := func(q string) []string {
+		t.Helper()
+		matches, err := SearchHistory(dir, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, m := range matches {
+			ids = append(ids, m.SessionID)
+		}
+		return ids
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"new", "old"}},
+		{"login", []string{"new", "old"}},
+		{"login_test.go", []string{"old"}},
+		{"fix-login", []string{"old"}},
+		{"login build", []string{"new"}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		if got := search(tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("SearchHistory(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	// The index notices records that change or go away.
+	if _, err := os.Stat(filepath.Join(dir, historyIndexName)); err != nil {
+		t.Errorf("no index written: %v", err)
+	}
+	os.Remove(filepath.Join(dir, "old.json"))
+	if got := search("login"); !slices.Equal(got, []string{"new"}) {
+		t.Errorf("after removing a record, SearchHistory(login) = %q", got)
+	}
+}
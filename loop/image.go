@@ -0,0 +1,141 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ImageProvenance describes the image a container session runs in.
+type ImageProvenance struct {
+	BaseImage  string `json:"base_image"`            // the base image, such as ghcr.io/boldsoftware/sketch:<hash>
+	BaseDigest string `json:"base_digest,omitempty"` // the registry digest the base was pulled at; empty for a base built locally
+	Image      string `json:"image"`                 // the image layered on the base for this repo
+}
+
+// ImageStatus is what the host found when it last checked the base image's registry.
+type ImageStatus struct {
+	BaseImage       string    `json:"base_image"`
+	BaseDigest      string    `json:"base_digest,omitempty"`
+	LatestDigest    string    `json:"latest_digest,omitempty"`
+	UpdateAvailable bool      `json:"update_available"`
+	CheckedAt       time.Time `json:"checked_at,omitempty"`
+	Error           string    `json:"error,omitempty"` // why the registry couldn't be checked
+}
+
+// rebuildRef is where the working tree's snapshot waits on the host for the new container.
+func rebuildRef(sessionID string) string { return "refs/sketch/rebuild/" + sessionID }
+
+// ImageProvenance returns the image the session's container runs in, or nil outside a container.
+func (a *Agent) ImageProvenance() *ImageProvenance {
+	return a.config.Image
+}
+
+// ImageStatus asks the host whether the base image has a newer digest in its registry.
+func (a *Agent) ImageStatus(ctx context.Context) (ImageStatus, error) {
+	var st ImageStatus
+	if a.config.Image == nil || a.outsideHTTP == "" {
+		return st, errors.New("the session isn't running in a container")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.outsideHTTP+"/image", nil)
+	if err != nil {
+		return st, err
+	}
+	resp, err := (&http.Client{Timeout: time.Minute}).Do(req)
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return st, fmt.Errorf("checking the base image: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	return st, err
+}
+
+// PrepareRebuild readies the session to move to a container built from the
+// latest base image. It snapshots the working tree as a commit and pushes it
+// to the host, which pulls the new base; once the session ends, the host
+// starts the new container from the session record at the snapshot.
+func (a *Agent) PrepareRebuild(ctx context.Context) error {
+	if a.config.Image == nil || a.outsideHTTP == "" || a.gitState.gitRemoteAddr == "" {
+		return errors.New("the session isn't running in a container")
+	}
+	commit, err := a.snapshotWorkingTree(ctx)
+	if err != nil {
+		return fmt.Errorf("saving the working tree: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "push", "--force", a.gitState.gitRemoteAddr, commit+":"+rebuildRef(a.config.SessionID))
+	cmd.Dir = a.repoRoot
+	if out, err := a.gitState.trace.CombinedOutput(cmd); err != nil {
+		return fmt.Errorf("git push: %s: %w", out, err)
+	}
+
+	// Pulling the base can take a while; the container keeps running meanwhile.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.outsideHTTP+"/image/rebuild", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := (&http.Client{Timeout: 15 * time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+	a.mu.Lock()
+	a.rebuildCommit = commit
+	a.mu.Unlock()
+	return nil
+}
+
+// snapshotWorkingTree commits the working tree, untracked files and all, atop
+// HEAD, leaving HEAD, the index and the branches alone. With no changes, it
+// returns HEAD.
+func (a *Agent) snapshotWorkingTree(ctx context.Context) (string, error) {
+	dir, err := os.MkdirTemp("", "sketch-snapshot-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	env := append(os.Environ(), "GIT_INDEX_FILE="+filepath.Join(dir, "index"))
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = a.repoRoot
+		cmd.Env = env
+		out, err := a.gitState.trace.CombinedOutput(cmd)
+		if err != nil {
+			return "", fmt.Errorf("git %s: %s: %w", args[0], out, err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	head, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if _, err := git("read-tree", "HEAD"); err != nil {
+		return "", err
+	}
+	if _, err := git("add", "-A"); err != nil {
+		return "", err
+	}
+	tree, err := git("write-tree")
+	if err != nil {
+		return "", err
+	}
+	if headTree, err := git("rev-parse", "HEAD^{tree}"); err != nil || headTree == tree {
+		return head, err
+	}
+	return git("commit-tree", tree, "-p", head, "-m", "sketch: working tree before moving to a rebuilt container")
+}
@@ -0,0 +1,64 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotWorkingTree(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("main.go", "package main\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	head := git("rev-parse", "HEAD")
+	a := &Agent{repoRoot: dir}
+	for _, v := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(v, "Test")
+	}
+	for _, v := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(v, "test@example.com")
+	}
+
+	if got, err := a.snapshotWorkingTree(ctx); err != nil || got != head {
+		t.Errorf("clean tree: snapshot %s, %v; want HEAD %s", got, err, head)
+	}
+
+	write("main.go", "package main\n\nfunc main() {}\n")
+	write("new.go", "package main\n")
+	git("add", "main.go")
+	snap, err := a.snapshotWorkingTree(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap == head || git("rev-parse", snap+"^") != head {
+		t.Errorf("snapshot %s isn't a child of HEAD %s", snap, head)
+	}
+	if files := git("ls-tree", "--name-only", snap); files != "main.go\nnew.go" {
+		t.Errorf("snapshot has %q", files)
+	}
+	if git("rev-parse", "HEAD") != head || git("status", "--porcelain") != "M  main.go\n?? new.go" {
+		t.Errorf("snapshot changed the repo: %q", git("status", "--porcelain"))
+	}
+}
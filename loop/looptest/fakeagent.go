@@ -0,0 +1,830 @@
+// Package looptest provides an in-memory loop.CodingAgent for testing
+// code that consumes agents, such as the loop/server HTTP handlers.
+package looptest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/browse"
+	"sketch.dev/claudetool/depaudit"
+	"sketch.dev/claudetool/mergequeue"
+	"sketch.dev/git_tools"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+	"sketch.dev/loop"
+	"sketch.dev/netpolicy"
+	"sketch.dev/skabandclient"
+	"tailscale.com/portlist"
+)
+
+// Config is the initial, scripted state of a FakeAgent.
+// Zero values are reported as-is, except where noted.
+type Config struct {
+	SessionID     string
+	Slug          string
+	BranchName    string
+	BranchPrefix  string
+	WorkingDir    string // also reported as RepoRoot
+	GitUsername   string
+	GitOrigin     string
+	SketchGitBase string
+	GitBaseRef    string // defaults to "sketch-base"
+	Model         string
+	SkabandAddr   string
+	Skaband       *skabandclient.Status // SkabandStatus
+	State         string                // CurrentStateName
+	TodoContent   string
+	InContainer   bool
+	TurnTimeout   time.Duration
+	Sampling      llm.Sampling
+
+	// BrowserProfiles are the browser profiles there are; saving state adds to them.
+	BrowserProfiles []browse.Profile
+
+	// Messages are the conversation history; their Idx fields are assigned in order.
+	Messages []loop.AgentMessage
+
+	// Diffs maps commit hashes to the diff Diff reports for them.
+	// The "" key holds the diff for the whole session.
+	Diffs   map[string]string
+	DiffErr error
+	// FileDiffs is what DiffStructured reports, whatever the revisions.
+	FileDiffs []git_tools.FileDiff
+
+	DiffLinesAdded   int
+	DiffLinesRemoved int
+	GitStats         loop.GitStats
+
+	Usage           conversation.CumulativeUsage
+	Budget          conversation.Budget
+	Estimate        conversation.Estimate // returned by EstimateCost, whatever the message
+	Ports           []portlist.Port
+	ToolProgress    []loop.ToolCallProgress
+	NetViolations   []netpolicy.Violation
+	BlockedCommands []loop.BlockedCommand
+	Audit           *depaudit.Report
+	// MergeQueue holds the merge queue entries; EnqueueMerge appends to it.
+	MergeQueue []mergequeue.Entry
+	// Artifacts are the message artifacts; ArtifactFile finds their content in ArtifactDir.
+	Artifacts   []loop.Artifact
+	ArtifactDir string
+	// Image is the container's image provenance; ImageStatus reports ImageStatus for it,
+	// and without it ImageStatus and PrepareRebuild fail.
+	Image         *loop.ImageProvenance
+	ImageStatus   loop.ImageStatus
+	Conversations []loop.ConversationNode
+}
+
+// FakeAgent is a loop.CodingAgent backed entirely by memory. It never calls
+// an LLM; tests drive it with AddMessage and Transition, and inspect what the
+// code under test did through UserMessages, CancelCauses, and friends.
+type FakeAgent struct {
+	mu          sync.Mutex
+	cfg         Config
+	messages    []loop.AgentMessage
+	transitions []loop.StateTransition
+	changed     chan struct{} // closed and replaced whenever messages or transitions grow
+
+	userMessages  []string
+	external      []loop.ExternalMessage
+	patches       []string
+	feedback      []loop.Feedback
+	cancelCauses  []error
+	cancelledUses []string
+	compactions   int
+	rebuilds      int
+	attached      []string
+	retryNumber   int
+	ready         chan struct{}
+}
+
+var _ loop.CodingAgent = (*FakeAgent)(nil)
+
+// NewFakeAgent returns a FakeAgent that starts out in the state described by cfg.
+func NewFakeAgent(cfg Config) *FakeAgent {
+	if cfg.GitBaseRef == "" {
+		cfg.GitBaseRef = "sketch-base"
+	}
+	a := &FakeAgent{
+		cfg:     cfg,
+		changed: make(chan struct{}),
+		ready:   make(chan struct{}),
+	}
+	close(a.ready)
+	for _, m := range cfg.Messages {
+		a.appendLocked(m)
+	}
+	return a
+}
+
+func (a *FakeAgent) appendLocked(m loop.AgentMessage) loop.AgentMessage {
+	m.Idx = len(a.messages)
+	a.messages = append(a.messages, m)
+	return m
+}
+
+func (a *FakeAgent) notifyLocked() {
+	close(a.changed)
+	a.changed = make(chan struct{})
+}
+
+// AddMessage appends m to the conversation, assigning its Idx, and wakes any iterators.
+func (a *FakeAgent) AddMessage(m loop.AgentMessage) loop.AgentMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m = a.appendLocked(m)
+	a.notifyLocked()
+	return m
+}
+
+// Transition moves the agent to state to and notifies state transition iterators.
+func (a *FakeAgent) Transition(from, to loop.State, event loop.TransitionEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cfg.State = to.String()
+	a.transitions = append(a.transitions, loop.StateTransition{From: from, To: to, Event: event})
+	a.notifyLocked()
+}
+
+// Update changes the agent's configuration under its lock.
+func (a *FakeAgent) Update(f func(*Config)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f(&a.cfg)
+}
+
+// UserMessages returns the messages passed to UserMessage, in order.
+func (a *FakeAgent) UserMessages() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.userMessages)
+}
+
+// ExternalMessages returns the messages passed to ExternalMessage, in order.
+func (a *FakeAgent) ExternalMessages() []loop.ExternalMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.external)
+}
+
+// Patches returns the patches passed to ApplyPatch, in order.
+func (a *FakeAgent) Patches() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.patches)
+}
+
+// CancelCauses returns the causes passed to CancelTurn, in order.
+func (a *FakeAgent) CancelCauses() []error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cancelCauses)
+}
+
+// CancelledToolUses returns the tool use IDs passed to CancelToolUse, in order.
+func (a *FakeAgent) CancelledToolUses() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cancelledUses)
+}
+
+// Compactions reports how many times CompactConversation was called.
+func (a *FakeAgent) Compactions() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.compactions
+}
+
+// Rebuilds reports how many times PrepareRebuild succeeded.
+func (a *FakeAgent) Rebuilds() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rebuilds
+}
+
+// RetryNumber reports how many times IncrementRetryNumber was called.
+func (a *FakeAgent) RetryNumber() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.retryNumber
+}
+
+func (a *FakeAgent) Init(loop.AgentInit) error { return nil }
+func (a *FakeAgent) Ready() <-chan struct{}    { return a.ready }
+func (a *FakeAgent) URL() string               { return "http://localhost:8080" }
+func (a *FakeAgent) Loop(ctx context.Context)  { <-ctx.Done() }
+
+func (a *FakeAgent) Reinit(loop.AgentInit) error { return nil }
+
+func (a *FakeAgent) UserMessage(ctx context.Context, msg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.userMessages = append(a.userMessages, msg)
+}
+
+func (a *FakeAgent) ExternalMessage(ctx context.Context, msg loop.ExternalMessage) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.external = append(a.external, msg)
+	return nil
+}
+
+func (a *FakeAgent) CancelTurn(cause error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cancelCauses = append(a.cancelCauses, cause)
+}
+
+func (a *FakeAgent) CancelToolUse(toolUseID string, cause error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cancelledUses = append(a.cancelledUses, toolUseID)
+	return nil
+}
+
+func (a *FakeAgent) CompactConversation(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.compactions++
+	return nil
+}
+
+// Compact counts as a compaction, like CompactConversation, after checking strategy.
+func (a *FakeAgent) Compact(ctx context.Context, strategy string) error {
+	if _, err := loop.ParseCompactionStrategy(strategy); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.compactions++
+	return nil
+}
+
+// PinMessage sets Pinned on the message at idx.
+func (a *FakeAgent) PinMessage(idx int, pinned bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if idx < 0 || idx >= len(a.messages) {
+		return fmt.Errorf("no message %d", idx)
+	}
+	a.messages[idx].Pinned = pinned
+	return nil
+}
+
+func (a *FakeAgent) IncrementRetryNumber() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.retryNumber++
+}
+
+func (a *FakeAgent) Messages(start int, end int) []loop.AgentMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if start < 0 || end < start || end > len(a.messages) {
+		return []loop.AgentMessage{}
+	}
+	return slices.Clone(a.messages[start:end])
+}
+
+func (a *FakeAgent) MessageCount() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.messages)
+}
+
+// NewIterator returns every message from nextMessageIdx on, including ones added later.
+func (a *FakeAgent) NewIterator(ctx context.Context, nextMessageIdx int) loop.MessageIterator {
+	return &messageIterator{agent: a, ctx: ctx, next: nextMessageIdx}
+}
+
+type messageIterator struct {
+	agent *FakeAgent
+	ctx   context.Context
+	next  int
+}
+
+func (it *messageIterator) Next() *loop.AgentMessage {
+	for {
+		it.agent.mu.Lock()
+		if it.next < len(it.agent.messages) {
+			m := it.agent.messages[it.next]
+			it.next++
+			it.agent.mu.Unlock()
+			return &m
+		}
+		changed := it.agent.changed
+		it.agent.mu.Unlock()
+		select {
+		case <-it.ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (it *messageIterator) Close() {}
+
+// NewStateTransitionIterator returns the transitions made after it is called.
+func (a *FakeAgent) NewStateTransitionIterator(ctx context.Context) loop.StateTransitionIterator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return &transitionIterator{agent: a, ctx: ctx, next: len(a.transitions)}
+}
+
+// StateHistory returns a visit, without a duration, to each state entered with Transition.
+func (a *FakeAgent) StateHistory() []loop.StateVisit {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	visits := make([]loop.StateVisit, 0, len(a.transitions))
+	for _, t := range a.transitions {
+		visits = append(visits, loop.StateVisit{State: t.To.String(), Reason: t.Event.Description, Entered: t.Event.Timestamp})
+	}
+	return visits
+}
+
+// Artifacts returns the configured artifacts.
+func (a *FakeAgent) Artifacts() []loop.Artifact {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.Artifacts)
+}
+
+// ArtifactFile returns a configured artifact, whose content is the file named by its ID in ArtifactDir.
+func (a *FakeAgent) ArtifactFile(id string) (loop.Artifact, string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, art := range a.cfg.Artifacts {
+		if art.ID == id {
+			return art, filepath.Join(a.cfg.ArtifactDir, id), true
+		}
+	}
+	return loop.Artifact{}, "", false
+}
+
+type transitionIterator struct {
+	agent *FakeAgent
+	ctx   context.Context
+	next  int
+}
+
+func (it *transitionIterator) Next() *loop.StateTransition {
+	for {
+		it.agent.mu.Lock()
+		if it.next < len(it.agent.transitions) {
+			t := it.agent.transitions[it.next]
+			it.next++
+			it.agent.mu.Unlock()
+			return &t
+		}
+		changed := it.agent.changed
+		it.agent.mu.Unlock()
+		select {
+		case <-it.ctx.Done():
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (it *transitionIterator) Close() {}
+
+func (a *FakeAgent) DiffStructured(from, to string) ([]git_tools.FileDiff, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.DiffErr != nil {
+		return nil, a.cfg.DiffErr
+	}
+	return slices.Clone(a.cfg.FileDiffs), nil
+}
+
+func (a *FakeAgent) Diff(commit *string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.DiffErr != nil {
+		return "", a.cfg.DiffErr
+	}
+	key := ""
+	if commit != nil {
+		key = *commit
+	}
+	diff, ok := a.cfg.Diffs[key]
+	if !ok && key != "" {
+		return "", fmt.Errorf("unknown commit %s", key)
+	}
+	return diff, nil
+}
+
+func (a *FakeAgent) SessionID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.SessionID
+}
+
+func (a *FakeAgent) Slug() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Slug
+}
+
+func (a *FakeAgent) BranchName() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.BranchName
+}
+
+func (a *FakeAgent) BranchPrefix() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.BranchPrefix
+}
+
+func (a *FakeAgent) WorkingDir() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.WorkingDir
+}
+
+func (a *FakeAgent) RepoRoot() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.WorkingDir
+}
+
+func (a *FakeAgent) GitUsername() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.GitUsername
+}
+
+func (a *FakeAgent) GitOrigin() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.GitOrigin
+}
+
+func (a *FakeAgent) SketchGitBase() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.SketchGitBase
+}
+
+func (a *FakeAgent) SketchGitBaseRef() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.GitBaseRef
+}
+
+func (a *FakeAgent) ModelName() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Model
+}
+
+func (a *FakeAgent) SetModel(ctx context.Context, name string) error {
+	a.Update(func(c *Config) { c.Model = name })
+	return nil
+}
+
+func (a *FakeAgent) SkabandAddr() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.SkabandAddr
+}
+
+func (a *FakeAgent) SkabandStatus() *skabandclient.Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Skaband
+}
+
+func (a *FakeAgent) CurrentStateName() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.State
+}
+
+func (a *FakeAgent) CurrentTodoContent() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.TodoContent
+}
+
+func (a *FakeAgent) IsInContainer() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.InContainer
+}
+
+func (a *FakeAgent) TurnTimeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.TurnTimeout
+}
+
+func (a *FakeAgent) SetTurnTimeout(d time.Duration) { a.Update(func(c *Config) { c.TurnTimeout = d }) }
+
+func (a *FakeAgent) Sampling() llm.Sampling {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Sampling
+}
+
+func (a *FakeAgent) SetSampling(ctx context.Context, s llm.Sampling) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	a.Update(func(c *Config) { c.Sampling = s })
+	return nil
+}
+
+func (a *FakeAgent) BrowserProfiles() ([]browse.Profile, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]browse.Profile{}, a.cfg.BrowserProfiles...), nil
+}
+
+func (a *FakeAgent) ApplyBrowserProfile(name string) (*browse.Profile, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, p := range a.cfg.BrowserProfiles {
+		if p.Name == name {
+			return &p, nil
+		}
+	}
+	return nil, fmt.Errorf("browser profile %q: %w", name, os.ErrNotExist)
+}
+
+func (a *FakeAgent) SaveBrowserState(name string) (*browse.Profile, error) {
+	if err := browse.ValidateProfileName(name); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := slices.IndexFunc(a.cfg.BrowserProfiles, func(p browse.Profile) bool { return p.Name == name })
+	if i < 0 {
+		a.cfg.BrowserProfiles = append(a.cfg.BrowserProfiles, browse.Profile{Name: name})
+		i = len(a.cfg.BrowserProfiles) - 1
+	}
+	a.cfg.BrowserProfiles[i].HasState = true
+	p := a.cfg.BrowserProfiles[i]
+	return &p, nil
+}
+
+func (a *FakeAgent) DiffStats() (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.DiffLinesAdded, a.cfg.DiffLinesRemoved
+}
+
+func (a *FakeAgent) GitStats() loop.GitStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.GitStats
+}
+
+func (a *FakeAgent) TotalUsage() conversation.CumulativeUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Usage
+}
+func (a *FakeAgent) OriginalBudget() conversation.Budget {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Budget
+}
+func (a *FakeAgent) EstimateCost(msg string) conversation.Estimate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Estimate
+}
+func (a *FakeAgent) GetPorts() []portlist.Port {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.Ports)
+}
+func (a *FakeAgent) NetworkViolations() []netpolicy.Violation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.NetViolations)
+}
+
+func (a *FakeAgent) BlockedCommands() []loop.BlockedCommand {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.BlockedCommands)
+}
+func (a *FakeAgent) LastDependencyAudit() *depaudit.Report {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Audit
+}
+
+// RunDependencyAudit reports Config.Audit, or an empty report if it is nil.
+func (a *FakeAgent) RunDependencyAudit(ctx context.Context) (*depaudit.Report, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.Audit == nil {
+		a.cfg.Audit = &depaudit.Report{Introduced: []depaudit.Vulnerability{}, Fixed: []depaudit.Vulnerability{}}
+	}
+	return a.cfg.Audit, nil
+}
+
+func (a *FakeAgent) MergeQueueEntries() []mergequeue.Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.MergeQueue)
+}
+
+// EnqueueMerge records a queued entry for branch, defaulting to the agent's branch.
+func (a *FakeAgent) EnqueueMerge(ctx context.Context, branch string) (mergequeue.Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if branch == "" {
+		branch = a.cfg.BranchName
+	}
+	e := mergequeue.Entry{
+		ID:     fmt.Sprint(len(a.cfg.MergeQueue) + 1),
+		Branch: branch,
+		State:  mergequeue.StateQueued,
+	}
+	a.cfg.MergeQueue = append(a.cfg.MergeQueue, e)
+	return e, nil
+}
+
+// ApplyPatch records patch and reports the files its +++ lines name as changed.
+func (a *FakeAgent) ApplyPatch(ctx context.Context, patch, note string) (loop.PatchResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if strings.TrimSpace(patch) == "" {
+		return loop.PatchResult{}, fmt.Errorf("patch is empty")
+	}
+	a.patches = append(a.patches, patch)
+	res := loop.PatchResult{Commit: fmt.Sprintf("%040d", len(a.patches)), Files: []string{}}
+	for line := range strings.Lines(patch) {
+		if f, ok := strings.CutPrefix(strings.TrimSpace(line), "+++ b/"); ok {
+			res.Files = append(res.Files, f)
+		}
+	}
+	return res, nil
+}
+
+// ToolProgress returns Config.ToolProgress.
+func (a *FakeAgent) ToolProgress() []loop.ToolCallProgress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.ToolProgress)
+}
+
+// Todos parses Config.TodoContent.
+func (a *FakeAgent) Todos() (claudetool.TodoList, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.todosLocked()
+}
+
+func (a *FakeAgent) todosLocked() (claudetool.TodoList, error) {
+	var list claudetool.TodoList
+	if a.cfg.TodoContent == "" {
+		return list, nil
+	}
+	err := json.Unmarshal([]byte(a.cfg.TodoContent), &list)
+	return list, err
+}
+
+// EditTodos applies edit to the todo list and stores the result as CurrentTodoContent.
+func (a *FakeAgent) EditTodos(ctx context.Context, edit func(*claudetool.TodoList) error) (claudetool.TodoList, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list, err := a.todosLocked()
+	if err != nil {
+		return list, err
+	}
+	if err := edit(&list); err != nil {
+		return list, err
+	}
+	if err := list.Validate(); err != nil {
+		return list, err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return list, err
+	}
+	a.cfg.TodoContent = string(data)
+	return list, nil
+}
+
+// RecordFeedback records a rating of the message at idx.
+func (a *FakeAgent) RecordFeedback(ctx context.Context, idx int, rating, comment string) (loop.Feedback, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if idx < 0 || idx >= len(a.messages) {
+		return loop.Feedback{}, fmt.Errorf("no message %d", idx)
+	}
+	if rating != "up" && rating != "down" {
+		return loop.Feedback{}, fmt.Errorf("rating must be \"up\" or \"down\", not %q", rating)
+	}
+	fb := loop.Feedback{
+		SessionID:   a.cfg.SessionID,
+		MessageIdx:  idx,
+		Rating:      rating,
+		Comment:     comment,
+		MessageType: a.messages[idx].Type,
+		Time:        a.messages[idx].Timestamp,
+	}
+	a.feedback = slices.DeleteFunc(a.feedback, func(f loop.Feedback) bool { return f.MessageIdx == idx })
+	a.feedback = append(a.feedback, fb)
+	slices.SortFunc(a.feedback, func(x, y loop.Feedback) int { return x.MessageIdx - y.MessageIdx })
+	return fb, nil
+}
+
+func (a *FakeAgent) ImageProvenance() *loop.ImageProvenance {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.cfg.Image
+}
+
+func (a *FakeAgent) ImageStatus(ctx context.Context) (loop.ImageStatus, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.Image == nil {
+		return loop.ImageStatus{}, fmt.Errorf("not in a container")
+	}
+	return a.cfg.ImageStatus, nil
+}
+
+// PrepareRebuild counts a rebuild if the container has an image.
+func (a *FakeAgent) PrepareRebuild(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cfg.Image == nil {
+		return fmt.Errorf("not in a container")
+	}
+	a.rebuilds++
+	return nil
+}
+
+// AttachContext attaches paths as they are, without reading any files;
+// a path starting with "missing" fails.
+func (a *FakeAgent) AttachContext(ctx context.Context, paths []string) (loop.ContextBundle, error) {
+	for _, p := range paths {
+		if strings.HasPrefix(p, "missing") {
+			return loop.ContextBundle{}, fmt.Errorf("%s: no such file", p)
+		}
+	}
+	a.mu.Lock()
+	a.attached = append(a.attached, paths...)
+	a.mu.Unlock()
+	return a.AttachedContext(), nil
+}
+
+func (a *FakeAgent) AttachedContext() loop.ContextBundle {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	b := loop.ContextBundle{Files: []loop.BundledFile{}}
+	for _, p := range a.attached {
+		b.Files = append(b.Files, loop.BundledFile{Path: p})
+	}
+	return b
+}
+
+func (a *FakeAgent) ClearContext() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attached = nil
+}
+
+func (a *FakeAgent) Conversations() []loop.ConversationNode {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.cfg.Conversations)
+}
+
+// Feedback returns the ratings passed to RecordFeedback, in message order.
+func (a *FakeAgent) Feedback() []loop.Feedback {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.feedback)
+}
+
+func (a *FakeAgent) SSHConnectionString() string                { return "sketch-" + a.SessionID() }
+func (a *FakeAgent) TokenContextWindow() int                    { return 200000 }
+func (a *FakeAgent) OS() string                                 { return "linux" }
+func (a *FakeAgent) OutsideOS() string                          { return "linux" }
+func (a *FakeAgent) OutsideHostname() string                    { return "test-host" }
+func (a *FakeAgent) OutsideWorkingDir() string                  { return "/app" }
+func (a *FakeAgent) PassthroughUpstream() bool                  { return false }
+func (a *FakeAgent) LinkToGitHub() bool                         { return false }
+func (a *FakeAgent) OpenBrowser(url string)                     {}
+func (a *FakeAgent) FirstMessageIndex() int                     { return 0 }
+func (a *FakeAgent) OutstandingLLMCallCount() int               { return 0 }
+func (a *FakeAgent) OutstandingToolCalls() []string             { return nil }
+func (a *FakeAgent) DetectGitChanges(ctx context.Context) error { return nil }
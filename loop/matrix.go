@@ -0,0 +1,124 @@
+package loop
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// A MatrixRun is what the -matrix-verify command did in the environments of the -matrix.
+type MatrixRun struct {
+	Command string         `json:"command"`
+	Results []MatrixResult `json:"results"`
+}
+
+// A MatrixResult is how the verification fared in one environment, which
+// ran it in a container of its own on a copy of the session's working tree.
+type MatrixResult struct {
+	Env      string        `json:"env"`
+	Image    string        `json:"image"`
+	Passed   bool          `json:"passed"`
+	ExitCode int           `json:"exit_code"`
+	Output   string        `json:"output,omitempty"` // the end of it
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"` // why the verification couldn't run
+}
+
+// MatrixRequest is the body of the container's POST /matrix to the host.
+type MatrixRequest struct {
+	Envs []string `json:"envs,omitempty"` // the environments to verify in; all of them if empty
+}
+
+// Failed returns the environments the verification failed in.
+func (r MatrixRun) Failed() []string {
+	var failed []string
+	for _, res := range r.Results {
+		if !res.Passed {
+			failed = append(failed, res.Env)
+		}
+	}
+	return failed
+}
+
+func (r MatrixRun) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ran %q in %d environment(s):\n", r.Command, len(r.Results))
+	for _, res := range r.Results {
+		switch {
+		case res.Error != "":
+			fmt.Fprintf(&b, "\n%s (%s): could not run: %s\n", res.Env, res.Image, res.Error)
+		case res.Passed:
+			fmt.Fprintf(&b, "\n%s (%s): passed in %s\n", res.Env, res.Image, res.Duration.Round(time.Second))
+		default:
+			fmt.Fprintf(&b, "\n%s (%s): failed with exit code %d after %s\n", res.Env, res.Image, res.ExitCode, res.Duration.Round(time.Second))
+		}
+		if !res.Passed && res.Output != "" {
+			fmt.Fprintf(&b, "%s\n", strings.TrimRight(res.Output, "\n"))
+		}
+	}
+	return b.String()
+}
+
+func (a *Agent) matrixTool() *llm.Tool {
+	return &llm.Tool{
+		Name: "verify_matrix",
+		Description: fmt.Sprintf(`Run the user's verification command, %q, in each environment of the test matrix, each in a container of its own built from a different image or environment variables: %s.
+Every environment runs it on a copy of your working tree, uncommitted changes and all, so keep making your changes here, on your branch, and use this tool to see which environments they fix or break. Compatibility fixes belong in the code, not in per-environment branches. Before you finish, it must pass in every environment; the user's run checks it again once you're done.`, a.config.MatrixVerify, strings.Join(a.config.Matrix, ", ")),
+		InputSchema: llm.MustSchema(`{
+  "type": "object",
+  "properties": {
+    "envs": {"type": "array", "items": {"type": "string"}, "description": "The environments to verify in; all of them if omitted"}
+  }
+}`),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			var req MatrixRequest
+			if err := json.Unmarshal(input, &req); err != nil {
+				return llm.ErrorfToolOut("failed to parse verify_matrix input: %w", err)
+			}
+			for _, env := range req.Envs {
+				if !slices.Contains(a.config.Matrix, env) {
+					return llm.ErrorfToolOut("unknown environment %q, want one of %s", env, strings.Join(a.config.Matrix, ", "))
+				}
+			}
+			run, err := a.verifyMatrix(ctx, req)
+			if err != nil {
+				return llm.ErrorToolOut(err)
+			}
+			return llm.ToolOut{LLMContent: llm.TextContent(run.String()), Display: run}
+		},
+	}
+}
+
+// verifyMatrix asks the host to run the verification in the environments of req.
+func (a *Agent) verifyMatrix(ctx context.Context, req MatrixRequest) (MatrixRun, error) {
+	var run MatrixRun
+	body, err := json.Marshal(req)
+	if err != nil {
+		return run, err
+	}
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.outsideHTTP+"/matrix", bytes.NewReader(body))
+	if err != nil {
+		return run, err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	// Test suites in fresh containers, with their dependencies to fetch, take a while.
+	resp, err := (&http.Client{Timeout: time.Hour}).Do(hreq)
+	if err != nil {
+		return run, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return run, fmt.Errorf("verifying the matrix: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&run)
+	return run, err
+}
@@ -0,0 +1,58 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatrixTool(t *testing.T) {
+	var asked []MatrixRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/matrix" {
+			http.NotFound(w, r)
+			return
+		}
+		var req MatrixRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		asked = append(asked, req)
+		json.NewEncoder(w).Encode(MatrixRun{Command: "go test ./...", Results: []MatrixResult{
+			{Env: "go1.22", Image: "golang:1.22", ExitCode: 1, Output: "undefined: slices.Collect", Duration: 3 * time.Second},
+			{Env: "go1.23", Image: "golang:1.23", Passed: true, Output: "ok", Duration: 2 * time.Second},
+		}})
+	}))
+	defer srv.Close()
+
+	a := &Agent{outsideHTTP: srv.URL, config: AgentConfig{Matrix: []string{"go1.22", "go1.23"}, MatrixVerify: "go test ./..."}}
+	tool := a.matrixTool()
+	if !strings.Contains(tool.Description, "go1.22, go1.23") {
+		t.Errorf("the description doesn't name the environments: %s", tool.Description)
+	}
+	out := tool.Run(context.Background(), json.RawMessage(`{"envs": ["go1.22", "go1.23"]}`))
+	if out.Error != nil {
+		t.Fatal(out.Error)
+	}
+	run := out.Display.(MatrixRun)
+	if got := run.Failed(); !slices.Equal(got, []string{"go1.22"}) {
+		t.Errorf("failed in %v", got)
+	}
+	text := run.String()
+	if !strings.Contains(text, "go1.22 (golang:1.22): failed with exit code 1 after 3s\nundefined: slices.Collect") {
+		t.Errorf("the result lacks the failure and its output:\n%s", text)
+	}
+	if strings.Contains(text, "\nok\n") {
+		t.Errorf("the result has the output of a passing environment:\n%s", text)
+	}
+	if len(asked) != 1 || len(asked[0].Envs) != 2 {
+		t.Errorf("the host was asked %+v", asked)
+	}
+
+	if out := tool.Run(context.Background(), json.RawMessage(`{"envs": ["go1.21"]}`)); out.Error == nil {
+		t.Error("an unknown environment was verified")
+	}
+}
@@ -0,0 +1,52 @@
+package loop
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"sketch.dev/claudetool/mergequeue"
+)
+
+// mergeQueueCommit returns the commit the merge queue should expect at the head of branch.
+// The agent's own branch is whatever HEAD is; other branches are resolved locally
+// if possible and otherwise left to the queue.
+func (a *Agent) mergeQueueCommit(branch string) string {
+	rev := branch
+	if branch == a.BranchName() {
+		rev = "HEAD"
+	}
+	cmd := exec.CommandContext(a.config.Context, "git", "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	cmd.Dir = a.repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// reportMergeQueueChange tells the agent (and the user) that an enqueued branch merged or was rejected,
+// so that landing a change can be finished without anyone watching the queue.
+func (a *Agent) reportMergeQueueChange(e mergequeue.Entry) {
+	var text string
+	switch e.State {
+	case mergequeue.StateMerged:
+		text = fmt.Sprintf("The merge queue merged %s.", e.Branch)
+	case mergequeue.StateQueued:
+		text = fmt.Sprintf("%s is back in the merge queue.", e.Branch)
+	default:
+		reason := string(e.State)
+		if e.Detail != "" {
+			reason = e.Detail
+		}
+		text = fmt.Sprintf("The merge queue did not merge %s: %s.", e.Branch, reason)
+	}
+	if e.URL != "" {
+		text += " " + e.URL
+	}
+	a.ExternalMessage(a.config.Context, ExternalMessage{
+		MessageType: "merge_queue",
+		Body:        e,
+		TextContent: text,
+	})
+}
@@ -0,0 +1,158 @@
+package loop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+// Kinds of Artifact.
+const (
+	ArtifactImage      = "image"      // an image a tool returned, such as a browser screenshot
+	ArtifactMermaid    = "mermaid"    // a mermaid diagram the model wrote
+	ArtifactSVG        = "svg"        // an SVG image the model wrote
+	ArtifactSBOM       = "sbom"       // an SPDX SBOM from the build_attestation tool
+	ArtifactProvenance = "provenance" // an in-toto provenance statement from the build_attestation tool
+)
+
+// An Artifact is an image, diagram or attestation from the conversation, stored apart from
+// the message it is attached to so that the web UI and exports can refer to it
+// by ID. It is unrelated to the model's artifacts tool, a text store.
+type Artifact struct {
+	// ID is derived from the content, so the same diagram is stored once.
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	MediaType string    `json:"media_type"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArtifactsDir returns the directory holding the artifacts of the given session.
+func ArtifactsDir(sessionID string) string {
+	if sessionID == "" {
+		return "/tmp/sketch-message-artifacts"
+	}
+	return filepath.Join("/tmp", sessionID, "message-artifacts")
+}
+
+// artifactStore keeps a session's artifacts as files named by ID. The zero value is ready to use.
+type artifactStore struct {
+	mu        sync.Mutex
+	artifacts []Artifact // oldest first
+	byID      map[string]int
+}
+
+func (s *artifactStore) save(dir, kind, mediaType string, data []byte) (Artifact, error) {
+	sum := sha256.Sum256(data)
+	id := hex.EncodeToString(sum[:8])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.byID[id]; ok {
+		return s.artifacts[i], nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Artifact{}, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, id), data, 0o600); err != nil {
+		return Artifact{}, err
+	}
+	a := Artifact{ID: id, Kind: kind, MediaType: mediaType, Size: len(data), CreatedAt: time.Now().UTC()}
+	if s.byID == nil {
+		s.byID = make(map[string]int)
+	}
+	s.byID[id] = len(s.artifacts)
+	s.artifacts = append(s.artifacts, a)
+	return a, nil
+}
+
+func (s *artifactStore) list() []Artifact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.artifacts)
+}
+
+func (s *artifactStore) get(id string) (Artifact, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.byID[id]
+	if !ok {
+		return Artifact{}, false
+	}
+	return s.artifacts[i], true
+}
+
+// Artifacts returns the session's artifacts, oldest first.
+func (a *Agent) Artifacts() []Artifact {
+	return a.artifacts.list()
+}
+
+// ArtifactFile returns the artifact with the given ID and the path of its content.
+func (a *Agent) ArtifactFile(id string) (Artifact, string, bool) {
+	art, ok := a.artifacts.get(id)
+	if !ok {
+		return Artifact{}, "", false
+	}
+	return art, filepath.Join(ArtifactsDir(a.config.SessionID), id), true
+}
+
+func (a *Agent) saveArtifact(ctx context.Context, kind, mediaType string, data []byte) (Artifact, bool) {
+	art, err := a.artifacts.save(ArtifactsDir(a.config.SessionID), kind, mediaType, data)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to store artifact", "kind", kind, "error", err)
+		return Artifact{}, false
+	}
+	return art, true
+}
+
+// diagramBlock matches a fenced mermaid or svg code block in Markdown.
+var diagramBlock = regexp.MustCompile("(?ms)^```(mermaid|svg)[ \t]*\n(.*?)\n```")
+
+// diagramArtifacts stores the mermaid and SVG diagrams the model wrote in text.
+func (a *Agent) diagramArtifacts(ctx context.Context, text string) []Artifact {
+	var arts []Artifact
+	for _, match := range diagramBlock.FindAllStringSubmatch(text, -1) {
+		kind, mediaType := ArtifactMermaid, "text/vnd.mermaid"
+		if match[1] == "svg" {
+			if !strings.HasPrefix(strings.TrimSpace(match[2]), "<") {
+				continue
+			}
+			kind, mediaType = ArtifactSVG, "image/svg+xml"
+		}
+		if art, ok := a.saveArtifact(ctx, kind, mediaType, []byte(match[2])); ok {
+			arts = append(arts, art)
+		}
+	}
+	return arts
+}
+
+// imageArtifacts stores the images in a tool's result.
+func (a *Agent) imageArtifacts(ctx context.Context, contents []llm.Content) []Artifact {
+	var arts []Artifact
+	for _, c := range contents {
+		arts = append(arts, a.imageArtifacts(ctx, c.ToolResult)...)
+		if !strings.HasPrefix(c.MediaType, "image/") || c.Data == "" {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(c.Data)
+		if err != nil {
+			slog.WarnContext(ctx, "tool returned an image that isn't base64", "media_type", c.MediaType, "error", err)
+			continue
+		}
+		if art, ok := a.saveArtifact(ctx, ArtifactImage, c.MediaType, data); ok {
+			arts = append(arts, art)
+		}
+	}
+	return arts
+}
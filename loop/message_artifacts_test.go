@@ -0,0 +1,47 @@
+package loop
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestMessageArtifacts(t *testing.T) {
+	a := &Agent{config: AgentConfig{SessionID: "artifacts-test-" + filepath.Base(t.TempDir())}}
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(ArtifactsDir(a.config.SessionID))) })
+	ctx := context.Background()
+
+	text := "The flow:\n```mermaid\ngraph TD\n  A --> B\n```\nand the logo:\n```svg\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>\n```\n" +
+		"and not a diagram:\n```svg\nmake me one\n```\nand again:\n```mermaid\ngraph TD\n  A --> B\n```\n"
+	arts := a.diagramArtifacts(ctx, text)
+	if len(arts) != 3 || arts[0].Kind != ArtifactMermaid || arts[1].Kind != ArtifactSVG || arts[1].MediaType != "image/svg+xml" || arts[2].ID != arts[0].ID {
+		t.Fatalf("diagram artifacts = %+v", arts)
+	}
+
+	png := []byte("\x89PNG not really")
+	images := a.imageArtifacts(ctx, []llm.Content{
+		{Type: llm.ContentTypeText, Text: "Screenshot taken"},
+		{Type: llm.ContentTypeText, MediaType: "image/png", Data: base64.StdEncoding.EncodeToString(png)},
+	})
+	if len(images) != 1 || images[0].Kind != ArtifactImage || images[0].Size != len(png) {
+		t.Fatalf("image artifacts = %+v", images)
+	}
+
+	if got := a.Artifacts(); len(got) != 3 {
+		t.Errorf("Artifacts = %+v, want the 3 distinct ones", got)
+	}
+	art, path, ok := a.ArtifactFile(images[0].ID)
+	if !ok || art != images[0] {
+		t.Fatalf("ArtifactFile = %+v, %v", art, ok)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != string(png) {
+		t.Errorf("artifact content = %q, %v", data, err)
+	}
+	if _, _, ok := a.ArtifactFile("nope"); ok {
+		t.Error("ArtifactFile found an unknown ID")
+	}
+}
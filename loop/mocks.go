@@ -133,6 +133,22 @@ func (m *MockConvo) SendMessage(message llm.Message) (*llm.Response, error) {
 	return exp.result[0].(*llm.Response), retErr
 }
 
+func (m *MockConvo) SendMessageWithoutTools(message llm.Message) (*llm.Response, error) {
+	m.recordCall("SendMessageWithoutTools", message)
+	exp, ok := m.findMatchingExpectation("SendMessageWithoutTools", message)
+	if !ok {
+		m.t.Errorf("unexpected call to SendMessageWithoutTools: %+v", message)
+		m.t.FailNow()
+	}
+	var retErr error
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err, ok := exp.result[1].(error); ok {
+		retErr = err
+	}
+	return exp.result[0].(*llm.Response), retErr
+}
+
 func (m *MockConvo) SendUserTextMessage(message string, otherContents ...llm.Content) (*llm.Response, error) {
 	m.recordCall("SendUserTextMessage", message, otherContents)
 	exp, ok := m.findMatchingExpectation("SendUserTextMessage", message, otherContents)
@@ -212,6 +228,19 @@ func (m *MockConvo) ResetBudget(_ conversation.Budget) {
 	m.recordCall("ResetBudget")
 }
 
+func (m *MockConvo) SetSampling(s *llm.Sampling) {
+	m.recordCall("SetSampling", s)
+}
+
+func (m *MockConvo) SetService(srv llm.Service) {
+	m.recordCall("SetService", srv)
+}
+
+func (m *MockConvo) Estimate(pending []llm.Content) conversation.Estimate {
+	m.recordCall("Estimate", pending)
+	return conversation.Estimate{}
+}
+
 // AssertExpectations checks that all expectations were met
 func (m *MockConvo) AssertExpectations(t *testing.T) {
 	m.mu.Lock()
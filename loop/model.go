@@ -0,0 +1,58 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+
+	"sketch.dev/i18n"
+	"sketch.dev/llm"
+	"sketch.dev/llm/ant"
+)
+
+func (a *Agent) service() llm.Service {
+	a.modelMu.Lock()
+	defer a.modelMu.Unlock()
+	return a.config.Service
+}
+
+// SetModel switches the agent to the model name, such as "opus", from the
+// next request on. Only Claude sessions can switch, and only between Claude
+// models: other providers are configured per model, at launch.
+// The switch is noted in the conversation, like a change of sampling.
+func (a *Agent) SetModel(ctx context.Context, name string) error {
+	model := ant.ClaudeModelName(name)
+	if model == "" {
+		return fmt.Errorf("unknown model %q: want claude, sonnet or opus", name)
+	}
+	a.modelMu.Lock()
+	cur, ok := a.config.Service.(*ant.Service)
+	if !ok {
+		a.modelMu.Unlock()
+		return fmt.Errorf("this session's model, %s, can't be switched", a.config.Model)
+	}
+	if cur.Model == model {
+		a.modelMu.Unlock()
+		return nil
+	}
+	// ant.Service mustn't change while in use, so switch to a copy.
+	srv := *cur
+	srv.Model = model
+	a.config.Service = &srv
+	a.config.Model = name
+	a.modelMu.Unlock()
+
+	a.mu.Lock()
+	convo := a.convo
+	a.mu.Unlock()
+	if convo != nil {
+		convo.SetService(&srv)
+	}
+	a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.ModelChanged, name)})
+	return nil
+}
+
+// RequestShaping reports the limits, such as max_tokens, that the agent's
+// requests are sent with, if its model's service reports them.
+func (a *Agent) RequestShaping() (llm.Shaping, bool) {
+	return llm.ShapingOf(a.service())
+}
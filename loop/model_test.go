@@ -0,0 +1,43 @@
+package loop
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm/ant"
+	"sketch.dev/llm/conversation"
+	"sketch.dev/llm/gem"
+)
+
+func TestSetModel(t *testing.T) {
+	ctx := context.Background()
+	orig := &ant.Service{APIKey: "key", Model: ant.Claude45Sonnet}
+	convo := conversation.New(ctx, orig, nil)
+	a := &Agent{convo: convo, config: AgentConfig{Service: orig, Model: "claude"}}
+
+	if err := a.SetModel(ctx, "opus"); err != nil {
+		t.Fatal(err)
+	}
+	srv, ok := convo.Service.(*ant.Service)
+	if !ok || srv.Model != ant.Claude4Opus || srv.APIKey != "key" {
+		t.Errorf("conversation service = %+v", convo.Service)
+	}
+	if orig.Model != ant.Claude45Sonnet {
+		t.Errorf("the original service changed to %s", orig.Model)
+	}
+	if a.ModelName() != "opus" {
+		t.Errorf("ModelName = %q", a.ModelName())
+	}
+	if len(a.history) != 1 || a.history[0].Type != AutoMessageType || !strings.Contains(a.history[0].Content, "opus") {
+		t.Errorf("switch not noted: %+v", a.history)
+	}
+
+	if err := a.SetModel(ctx, "gpt4.1"); err == nil {
+		t.Error("switched to gpt4.1")
+	}
+	a.config.Service = &gem.Service{}
+	if err := a.SetModel(ctx, "sonnet"); err == nil {
+		t.Error("switched a gemini session")
+	}
+}
@@ -0,0 +1,36 @@
+package loop
+
+import (
+	"time"
+
+	"sketch.dev/i18n"
+	"sketch.dev/netpolicy"
+)
+
+// reportNetworkViolation surfaces a blocked network access to the user.
+// Only the first violation for each host is reported, so a retry loop doesn't flood the conversation.
+func (a *Agent) reportNetworkViolation(v netpolicy.Violation) {
+	a.mu.Lock()
+	if a.reportedNetViolations == nil {
+		a.reportedNetViolations = make(map[string]bool)
+	}
+	seen := a.reportedNetViolations[v.Host]
+	a.reportedNetViolations[v.Host] = true
+	a.mu.Unlock()
+	if seen {
+		return
+	}
+	a.pushToOutbox(a.config.Context, AgentMessage{
+		Type:      AutoMessageType,
+		Content:   a.localize(i18n.NetworkBlocked, v.Host, v.Via),
+		Timestamp: time.Now(),
+	})
+}
+
+// NetworkViolations returns the blocked network accesses recorded by the container's network policy, if any.
+func (a *Agent) NetworkViolations() []netpolicy.Violation {
+	if a.config.NetPolicy == nil {
+		return nil
+	}
+	return a.config.NetPolicy.Violations()
+}
@@ -0,0 +1,59 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"sketch.dev/git_tools"
+)
+
+// PatchResult describes a user-provided patch that ApplyPatch committed.
+type PatchResult struct {
+	Commit string   `json:"commit"`
+	Files  []string `json:"files"`
+	// Checks is what the mechanical checks (autoformatters, go mod tidy) changed
+	// on top of the patch, if anything. Those changes are left for the agent to amend in.
+	Checks string `json:"checks,omitempty"`
+}
+
+// ApplyPatch commits patch, a unified diff the user brought from elsewhere,
+// on the agent's branch and tells the agent to build on it. note, if not empty,
+// is the user's description of the patch.
+func (a *Agent) ApplyPatch(ctx context.Context, patch, note string) (PatchResult, error) {
+	if a.vcs != nil {
+		return PatchResult{}, fmt.Errorf("patches can only be applied in git repositories, not %s", a.vcs.Kind())
+	}
+	message := "Apply user-provided patch"
+	if note = strings.TrimSpace(note); note != "" {
+		message += "\n\n" + note
+	}
+	commit, files, err := git_tools.ApplyPatch(ctx, a.repoRoot, patch, message)
+	if err != nil {
+		return PatchResult{}, err
+	}
+	res := PatchResult{Commit: commit, Files: files}
+	if a.codereview != nil {
+		res.Checks = a.codereview.RunMechanicalChecks(ctx)
+	}
+	if err := a.DetectGitChanges(ctx); err != nil {
+		slog.WarnContext(ctx, "pushing patch commit", "error", err)
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "I applied a patch of my own as commit %s, which changes:\n\n%s\n\n", commit[:min(len(commit), 8)], strings.Join(files, "\n"))
+	if note != "" {
+		fmt.Fprintf(&text, "About the patch: %s\n\n", note)
+	}
+	text.WriteString("Treat it as part of the work so far: build on it rather than redoing or reverting it.")
+	if res.Checks != "" {
+		text.WriteString("\n\n" + res.Checks)
+	}
+	a.ExternalMessage(ctx, ExternalMessage{
+		MessageType: "user_patch",
+		Body:        res,
+		TextContent: text.String(),
+	})
+	return res, nil
+}
@@ -0,0 +1,131 @@
+package loop
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/bashkit"
+	"sketch.dev/i18n"
+	"sketch.dev/llm"
+)
+
+// planModeTools are the tools that change the workspace or run arbitrary
+// code, which plan mode holds back. Bash is limited to bashkit.ReadOnly
+// instead, and MCP tools are all held back.
+var planModeTools = []string{
+	claudetool.PatchName,
+	"codereview", // runs the tests, and formats files in place
+	"done",       // runs the quality gates; a plan ends the turn instead
+	"scratchpad",
+	"build_attestation",
+	"merge_queue",
+	"rebase_upstream",
+	"task_runner",
+	"verify_matrix",
+}
+
+// The notes that go along with the next user message when plan mode changes.
+const (
+	planModeNote = "You are in plan mode. Explore the code and propose a plan, but don't change files or commit: " +
+		"the patch tool and other tools that change the workspace fail, and bash only runs commands that read. " +
+		"End your turn with the plan, for the user to approve."
+	planModeOffNote  = "Plan mode is over: you may change files and commit again."
+	planApprovedNote = "The user approved your plan, and plan mode is over. Carry it out."
+	planRejectedNote = "The user didn't approve your plan. Revise it, still in plan mode, and end your turn with the new plan."
+)
+
+// ErrNotPlanning is the error of reviewing a plan outside plan mode.
+var ErrNotPlanning = errors.New("sketch is not in plan mode")
+
+// PlanMode reports whether the agent is in plan mode, in which it reads and
+// explores but can't change files or commit.
+func (a *Agent) PlanMode() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.planMode
+}
+
+// SetPlanMode enters or leaves plan mode. The model hears of it along with
+// the next user message; tools already running finish.
+func (a *Agent) SetPlanMode(ctx context.Context, on bool) {
+	note, key := planModeOffNote, i18n.PlanModeOff
+	if on {
+		note, key = planModeNote, i18n.PlanModeOn
+	}
+	if a.setPlanMode(on, note) {
+		a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(key)})
+	}
+}
+
+// ReviewPlan answers the plan the agent proposed in plan mode. Approving it
+// leaves plan mode; rejecting it keeps the agent planning. Either way comment,
+// which a rejection must have, is sent to the agent as the user's message.
+func (a *Agent) ReviewPlan(ctx context.Context, approve bool, comment string) error {
+	if !a.PlanMode() {
+		return ErrNotPlanning
+	}
+	if !approve && comment == "" {
+		return errors.New("say what to change in the plan")
+	}
+	if approve {
+		a.setPlanMode(false, planApprovedNote)
+		a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.PlanApproved)})
+	} else {
+		a.mu.Lock()
+		a.planNote = planRejectedNote
+		a.mu.Unlock()
+		a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.PlanRejected)})
+	}
+	a.UserMessage(ctx, cmp.Or(comment, "Approved."))
+	return nil
+}
+
+// setPlanMode switches plan mode, queueing note for the model, and reports
+// whether it changed.
+func (a *Agent) setPlanMode(on bool, note string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.planMode == on {
+		return false
+	}
+	a.planMode, a.planNote = on, note
+	return true
+}
+
+// takePlanNote returns what the model hasn't yet been told about plan mode.
+func (a *Agent) takePlanNote() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	note := a.planNote
+	a.planNote = ""
+	return note
+}
+
+// planGate makes t fail while the agent is in plan mode.
+func (a *Agent) planGate(t *llm.Tool) *llm.Tool {
+	gated := *t
+	run := t.Run
+	gated.Run = func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+		if a.PlanMode() {
+			return llm.ErrorfToolOut("%s is not available in plan mode, until the user approves a plan; end your turn with the plan", t.Name)
+		}
+		return run(ctx, input)
+	}
+	return &gated
+}
+
+// checkPlanModeBash is the bash tool's permission check: in plan mode, only
+// commands that read may run.
+func (a *Agent) checkPlanModeBash(command string) error {
+	if !a.PlanMode() {
+		return nil
+	}
+	if err := bashkit.ReadOnly.Check(command); err != nil {
+		return fmt.Errorf("plan mode: %w; until the user approves a plan, only commands that read may run", err)
+	}
+	return nil
+}
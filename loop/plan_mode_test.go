@@ -0,0 +1,93 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestPlanMode(t *testing.T) {
+	ctx := context.Background()
+	a := NewAgent(AgentConfig{PlanMode: true})
+	ran := false
+	patch := a.planGate(&llm.Tool{Name: "patch", Run: func(context.Context, json.RawMessage) llm.ToolOut {
+		ran = true
+		return llm.ToolOut{}
+	}})
+
+	if note := a.takePlanNote(); note != planModeNote {
+		t.Errorf("starting in plan mode, the model is told %q", note)
+	}
+	if out := patch.Run(ctx, nil); out.Error == nil || ran {
+		t.Error("patch ran in plan mode")
+	}
+	if err := a.checkPlanModeBash("grep -rn TODO ."); err != nil {
+		t.Errorf("plan mode blocked a command that reads: %v", err)
+	}
+	if err := a.checkPlanModeBash("git commit -am wip"); err == nil {
+		t.Error("plan mode let bash commit")
+	}
+	if err := a.ReviewPlan(ctx, false, ""); err == nil {
+		t.Error("rejected a plan without saying what to change")
+	}
+
+	if err := a.ReviewPlan(ctx, true, "Go, but keep the old flag."); err != nil {
+		t.Fatal(err)
+	}
+	if a.PlanMode() {
+		t.Error("still in plan mode after approval")
+	}
+	if note := a.takePlanNote(); note != planApprovedNote {
+		t.Errorf("after approval, the model is told %q", note)
+	}
+	if msg := <-a.inbox; msg != "Go, but keep the old flag." {
+		t.Errorf("the agent got %q", msg)
+	}
+	if patch.Run(ctx, nil); !ran {
+		t.Error("patch didn't run after the plan was approved")
+	}
+	if err := a.checkPlanModeBash("git commit -am wip"); err != nil {
+		t.Errorf("bash still limited after approval: %v", err)
+	}
+	if err := a.ReviewPlan(ctx, true, ""); !errors.Is(err, ErrNotPlanning) {
+		t.Errorf("approving outside plan mode: %v", err)
+	}
+
+	a.SetPlanMode(ctx, true)
+	if !a.PlanMode() || a.takePlanNote() != planModeNote {
+		t.Error("SetPlanMode(true) didn't enter plan mode")
+	}
+}
+
+func TestPlanApprovedWithAnOK(t *testing.T) {
+	ctx := context.Background()
+	a := NewAgent(AgentConfig{PlanMode: true, AckReply: "👍"})
+	a.takePlanNote()
+	a.history = []AgentMessage{{Type: AgentMessageType, Content: "The plan: rename the flag, then update the docs."}}
+	if err := a.ReviewPlan(ctx, true, "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if a.acknowledged(ctx, []llm.Content{llm.StringContent(<-a.inbox)}) {
+		t.Error("an ok approving the plan was answered without the model")
+	}
+	if note := a.takePlanNote(); note != planApprovedNote {
+		t.Errorf("the model is told %q", note)
+	}
+}
+
+func TestPlanModeHoldsBackDone(t *testing.T) {
+	a := NewAgent(AgentConfig{Context: context.Background(), PlanMode: true})
+	for _, tool := range a.initConvo().Tools {
+		if tool.Name != "done" {
+			continue
+		}
+		if out := tool.Run(context.Background(), json.RawMessage(`{}`)); out.Error == nil {
+			t.Error("done ran its checks in plan mode")
+		}
+		return
+	}
+	t.Fatal("no done tool")
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"sketch.dev/control"
 	"tailscale.com/portlist"
 )
 
@@ -197,6 +198,9 @@ func (pm *PortMonitor) sendBatchPortNotification(addedPorts, removedPorts []port
 			if port.Pid != 0 {
 				portDesc += fmt.Sprintf(" [pid:%d]", port.Pid)
 			}
+			if addr := pm.forwardPort(port); addr != "" {
+				portDesc += " forwarded to the host's " + addr
+			}
 			openedPorts = append(openedPorts, portDesc)
 		}
 		if len(openedPorts) == 1 {
@@ -237,6 +241,23 @@ func (pm *PortMonitor) sendBatchPortNotification(addedPorts, removedPorts []port
 	pm.agent.pushToOutbox(pm.ctx, msg)
 }
 
+// forwardPort asks outtie, with -forward-ports, to forward a TCP port to the
+// host, and returns the host address it listens on.
+func (pm *PortMonitor) forwardPort(port portlist.Port) string {
+	cfg := pm.agent.config
+	if !cfg.ForwardPorts || cfg.Control == nil || port.Proto != "tcp" {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(pm.ctx, 5*time.Second)
+	defer cancel()
+	var fwd control.PortForwarded
+	if err := cfg.Control.CallJSON(ctx, control.MethodPortForward, control.PortForward{Port: int(port.Port)}, &fwd); err != nil {
+		slog.WarnContext(ctx, "forwarding port to the host", "port", port.Port, "error", err)
+		return ""
+	}
+	return fwd.Addr
+}
+
 // filterPorts filters out ports that should be ignored.
 func (pm *PortMonitor) filterPorts(ports []portlist.Port) []portlist.Port {
 	var filtered []portlist.Port
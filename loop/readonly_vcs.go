@@ -0,0 +1,72 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sketch.dev/vcs"
+)
+
+// initNonGitRepo sets the agent up in a Mercurial or Jujutsu repo. Such repos
+// get diffs, diff stats, and commit notifications; nothing is tagged or pushed,
+// and the git-based code review and dependency audit tools are left out.
+func (a *Agent) initNonGitRepo(ctx context.Context) error {
+	a.repoRoot = a.vcs.Root()
+	base, err := a.vcs.Head(ctx)
+	if err != nil {
+		return fmt.Errorf("finding the %s base commit: %w", a.vcs.Kind(), err)
+	}
+	a.vcsBase = base
+	slog.InfoContext(ctx, "using read-only version control support", "vcs", a.vcs.Kind(), "root", a.repoRoot, "base", base)
+	return nil
+}
+
+// vcsKind returns "hg" or "jj" for repos with read-only support, and "" for git.
+func (a *Agent) vcsKind() string {
+	if a.vcs == nil {
+		return ""
+	}
+	return string(a.vcs.Kind())
+}
+
+// handleVCSCommits is handleGitCommits for hg and jj repos: it reports
+// commits made since base and keeps the diff stats current.
+func (ags *AgentGitState) handleVCSCommits(ctx context.Context, repo vcs.Repo, base string) ([]AgentMessage, []*GitCommit, error) {
+	ags.mu.Lock()
+	defer ags.mu.Unlock()
+
+	head, err := repo.Head(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if head == ags.lastSketch {
+		return nil, nil, nil // nothing to do
+	}
+	ags.lastSketch = head
+
+	if diff, err := repo.Diff(ctx, base, head, 0); err != nil {
+		slog.WarnContext(ctx, "Failed to compute diff stats", "error", err)
+	} else {
+		ags.linesAdded, ags.linesRemoved = vcs.DiffStat(diff)
+	}
+
+	log, err := repo.Log(ctx, base, head, 100)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get %s log: %w", repo.Kind(), err)
+	}
+	var commits []*GitCommit
+	for _, c := range log {
+		if ags.seenCommits[c.ID] {
+			continue
+		}
+		ags.seenCommits[c.ID] = true
+		commits = append(commits, &GitCommit{Hash: c.ID, Subject: c.Subject, Body: c.Body})
+	}
+	if len(commits) == 0 {
+		return nil, nil, nil
+	}
+	msgs := []AgentMessage{{Type: CommitMessageType, Timestamp: time.Now(), Commits: commits}}
+	return msgs, commits, nil
+}
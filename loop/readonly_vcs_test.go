@@ -0,0 +1,55 @@
+package loop
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"sketch.dev/vcs"
+)
+
+func TestHandleVCSCommits(t *testing.T) {
+	// Any vcs.Repo will do; git is the one sure to be installed.
+	dir := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+	git("init")
+	git("config", "user.name", "Test")
+	git("config", "user.email", "test@example.com")
+	git("commit", "--allow-empty", "-m", "base")
+	repo, err := vcs.Detect(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := repo.Head(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ags := &AgentGitState{seenCommits: make(map[string]bool), lastSketch: base}
+	if msgs, commits, err := ags.handleVCSCommits(t.Context(), repo, base); err != nil || len(msgs) != 0 || len(commits) != 0 {
+		t.Fatalf("no new commits: got %v, %v, %v", msgs, commits, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\nb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git("add", "a.txt")
+	git("commit", "-m", "add a")
+	msgs, commits, err := ags.handleVCSCommits(t.Context(), repo, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 1 || commits[0].Subject != "add a" || len(msgs) != 1 || msgs[0].Type != CommitMessageType {
+		t.Errorf("got msgs %+v, commits %+v", msgs, commits)
+	}
+	if added, removed := ags.DiffStats(); added != 2 || removed != 0 {
+		t.Errorf("DiffStats = +%d -%d, want +2 -0", added, removed)
+	}
+}
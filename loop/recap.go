@@ -0,0 +1,261 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/claudetool/testreport"
+	"sketch.dev/llm"
+)
+
+// A Recap is what the agent has tried so far in the session, built from the
+// message history rather than from the model's recollection of it, which
+// compaction reduces to a summary.
+type Recap struct {
+	Commands        []RecapCommand `json:"commands,omitempty"`
+	OmittedCommands int            `json:"omitted_commands,omitempty"` // older distinct commands left out
+	Files           []RecapFile    `json:"files,omitempty"`
+	Tests           []RecapTest    `json:"tests,omitempty"`
+}
+
+// A RecapCommand is a distinct bash command and how its last run went.
+type RecapCommand struct {
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exit_code"` // of the last run; -1 if it failed without one, e.g. on a timeout
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	Background bool   `json:"background,omitempty"`
+	Runs       int    `json:"runs"`
+	LastIdx    int    `json:"last_idx"` // Idx of the message with the last run
+}
+
+// A RecapFile is a file the agent edited.
+type RecapFile struct {
+	Path        string `json:"path"`
+	Edits       int    `json:"edits"`
+	FailedEdits int    `json:"failed_edits,omitempty"`
+}
+
+// A RecapTest is a test, or a package of tests, seen failing in command output.
+type RecapTest struct {
+	Name    string `json:"name"`
+	Fixed   bool   `json:"fixed"`    // seen passing after it last failed
+	LastIdx int    `json:"last_idx"` // Idx of the message it last failed in
+}
+
+// maxRecapCommands caps the commands in a recap; the most recent are kept.
+const maxRecapCommands = 40
+
+var (
+	exitStatusRe = regexp.MustCompile(`^\[command failed: exit status (\d+)\]`)
+	// Go test and pytest results; the first group of each is the test or package.
+	testFailRe = []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\s*--- FAIL: (\S+)`),
+		regexp.MustCompile(`(?m)^FAIL[ \t]+(\S+)\s`),
+		regexp.MustCompile(`(?m)^FAILED (\S+)`),
+	}
+	testPassRe = []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^\s*--- PASS: (\S+)`),
+		regexp.MustCompile(`(?m)^ok[ \t]+(\S+)\s`),
+		regexp.MustCompile(`(?m)^(\S+::\S+) PASSED`),
+	}
+)
+
+// buildRecap recaps the tool calls of the main conversation in history.
+func buildRecap(history []AgentMessage) Recap {
+	var r Recap
+	commands := make(map[string]*RecapCommand)
+	files := make(map[string]*RecapFile)
+	tests := make(map[string]*RecapTest)
+	var order []string // commands, least recently run first
+
+	for _, m := range history {
+		if m.Type != ToolUseMessageType || m.ParentConversationID != nil {
+			continue
+		}
+		switch m.ToolName {
+		case "bash":
+			var input struct {
+				Command    string `json:"command"`
+				Background bool   `json:"background"`
+			}
+			if json.Unmarshal([]byte(m.ToolInput), &input) != nil || input.Command == "" {
+				continue
+			}
+			c := commands[input.Command]
+			if c == nil {
+				c = &RecapCommand{Command: input.Command}
+				commands[input.Command] = c
+			}
+			order = slices.DeleteFunc(order, func(s string) bool { return s == input.Command })
+			order = append(order, input.Command)
+			c.Runs++
+			c.LastIdx = m.Idx
+			c.Background = input.Background
+			c.ExitCode, c.TimedOut = 0, false
+			if m.ToolError {
+				c.ExitCode = -1
+				if sm := exitStatusRe.FindStringSubmatch(m.ToolResult); sm != nil {
+					c.ExitCode, _ = strconv.Atoi(sm[1])
+				}
+				c.TimedOut = strings.HasPrefix(m.ToolResult, "[command timed out")
+			}
+			recapTests(tests, m)
+		case claudetool.PatchName:
+			var input struct {
+				Path string `json:"path"`
+			}
+			if json.Unmarshal([]byte(m.ToolInput), &input) != nil || input.Path == "" {
+				continue
+			}
+			f := files[input.Path]
+			if f == nil {
+				f = &RecapFile{Path: input.Path}
+				files[input.Path] = f
+			}
+			f.Edits++
+			if m.ToolError {
+				f.FailedEdits++
+			}
+		}
+	}
+
+	if len(order) > maxRecapCommands {
+		r.OmittedCommands = len(order) - maxRecapCommands
+		order = order[r.OmittedCommands:]
+	}
+	for _, cmd := range order {
+		r.Commands = append(r.Commands, *commands[cmd])
+	}
+	for _, f := range files {
+		r.Files = append(r.Files, *f)
+	}
+	slices.SortFunc(r.Files, func(a, b RecapFile) int { return strings.Compare(a.Path, b.Path) })
+	for _, t := range tests {
+		r.Tests = append(r.Tests, *t)
+	}
+	slices.SortFunc(r.Tests, func(a, b RecapTest) int { return a.LastIdx - b.LastIdx })
+	return r
+}
+
+// recapTests records the tests that failed and passed in the output of m.
+// Tests only enter the recap by failing.
+func recapTests(tests map[string]*RecapTest, m AgentMessage) {
+	failed, passed := testResults(m)
+	for _, name := range failed {
+		t := tests[name]
+		if t == nil {
+			t = &RecapTest{Name: name}
+			tests[name] = t
+		}
+		t.Fixed, t.LastIdx = false, m.Idx
+	}
+	for _, name := range passed {
+		if t := tests[name]; t != nil && t.LastIdx != m.Idx {
+			t.Fixed = true
+		}
+	}
+}
+
+// testResults returns the tests and packages that failed and passed in the output of m.
+// The bash tool's report is preferred: the output the model saw may only summarize the run.
+func testResults(m AgentMessage) (failed, passed []string) {
+	if report, ok := m.Display.(*testreport.Report); ok {
+		for _, f := range report.Failures {
+			failed = append(failed, f.Name)
+			if f.Package != "" && f.Package != f.Name && report.Framework == "go" {
+				failed = append(failed, f.Package)
+			}
+		}
+		return failed, report.PassedNames
+	}
+	for _, re := range testFailRe {
+		for _, sm := range re.FindAllStringSubmatch(m.ToolResult, -1) {
+			failed = append(failed, sm[1])
+		}
+	}
+	for _, re := range testPassRe {
+		for _, sm := range re.FindAllStringSubmatch(m.ToolResult, -1) {
+			passed = append(passed, sm[1])
+		}
+	}
+	return failed, passed
+}
+
+// String renders the recap for the model, one line per entry.
+func (r Recap) String() string {
+	if len(r.Commands) == 0 && len(r.Files) == 0 {
+		return "Nothing tried yet: no commands run and no files edited."
+	}
+	var b strings.Builder
+	if len(r.Commands) > 0 {
+		b.WriteString("Commands (last run first, exit code of the last run):\n")
+		if r.OmittedCommands > 0 {
+			fmt.Fprintf(&b, "  (%d older commands omitted)\n", r.OmittedCommands)
+		}
+		for _, c := range slices.Backward(r.Commands) {
+			status := fmt.Sprintf("exit %d", c.ExitCode)
+			switch {
+			case c.TimedOut:
+				status = "timed out"
+			case c.Background:
+				status = "background"
+			case c.ExitCode < 0:
+				status = "failed"
+			}
+			cmd, _, multiline := strings.Cut(c.Command, "\n")
+			if multiline {
+				cmd += " …"
+			}
+			fmt.Fprintf(&b, "  [%s] %s", status, cmd)
+			if c.Runs > 1 {
+				fmt.Fprintf(&b, " (run %d times)", c.Runs)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(r.Files) > 0 {
+		b.WriteString("Files edited:\n")
+		for _, f := range r.Files {
+			fmt.Fprintf(&b, "  %s: %d edits", f.Path, f.Edits)
+			if f.FailedEdits > 0 {
+				fmt.Fprintf(&b, ", %d failed", f.FailedEdits)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(r.Tests) > 0 {
+		b.WriteString("Failing tests seen:\n")
+		for _, t := range r.Tests {
+			state := "still failing"
+			if t.Fixed {
+				state = "passed since"
+			}
+			fmt.Fprintf(&b, "  %s: %s (last failed in message %d)\n", t.Name, state, t.LastIdx)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// recapTool returns the session_recap tool. It is only offered once the
+// conversation has been compacted; until then, the model has the history itself.
+func (a *Agent) recapTool() *llm.Tool {
+	return &llm.Tool{
+		Name: "session_recap",
+		Description: `Recap what you have tried so far in this session, built from the recorded history rather than your memory: the commands you ran with their exit codes, the files you edited, and the tests seen failing and whether they passed since.
+Use it before retrying an approach, to avoid repeating one that already failed.`,
+		InputSchema: llm.MustSchema(`{"type": "object", "properties": {}}`),
+		Run: func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+			a.mu.Lock()
+			history := slices.Clone(a.history)
+			a.mu.Unlock()
+			recap := buildRecap(history)
+			return llm.ToolOut{LLMContent: llm.TextContent(recap.String()), Display: recap}
+		},
+	}
+}
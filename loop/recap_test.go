@@ -0,0 +1,60 @@
+package loop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildRecap(t *testing.T) {
+	sub := "sub"
+	bash := func(idx int, input, result string, failed bool) AgentMessage {
+		return AgentMessage{Idx: idx, Type: ToolUseMessageType, ToolName: "bash", ToolInput: input, ToolResult: result, ToolError: failed}
+	}
+	history := []AgentMessage{
+		{Idx: 0, Type: UserMessageType, Content: "fix the tests"},
+		bash(1, `{"command":"go test ./..."}`, "[command failed: exit status 1]\n--- FAIL: TestA (0.00s)\n--- FAIL: TestB (0.00s)\nFAIL\nFAIL\texample.com/p\t0.01s\n", true),
+		{Idx: 2, Type: ToolUseMessageType, ToolName: "patch", ToolInput: `{"path":"p/a.go"}`},
+		{Idx: 3, Type: ToolUseMessageType, ToolName: "patch", ToolInput: `{"path":"p/a.go"}`, ToolError: true},
+		bash(4, `{"command":"sleep 999"}`, "[command timed out after 1m0s, showing output until timeout]\n", true),
+		bash(5, `{"command":"go test ./..."}`, "[command failed: exit status 1]\n--- PASS: TestA (0.00s)\n--- FAIL: TestB (0.00s)\nFAIL\texample.com/p\t0.01s\n", true),
+		bash(6, `{"command":"ls"}`, "a.go\n", false),
+		{Idx: 7, Type: ToolUseMessageType, ToolName: "bash", ToolInput: `{"command":"rm -rf /"}`, ParentConversationID: &sub},
+	}
+
+	r := buildRecap(history)
+	if len(r.Commands) != 3 {
+		t.Fatalf("commands = %+v", r.Commands)
+	}
+	if c := r.Commands[1]; c.Command != "go test ./..." || c.Runs != 2 || c.ExitCode != 1 || c.LastIdx != 5 {
+		t.Errorf("go test = %+v", c)
+	}
+	if c := r.Commands[0]; !c.TimedOut || c.ExitCode != -1 {
+		t.Errorf("sleep = %+v", c)
+	}
+	if len(r.Files) != 1 || r.Files[0] != (RecapFile{Path: "p/a.go", Edits: 2, FailedEdits: 1}) {
+		t.Errorf("files = %+v", r.Files)
+	}
+	want := map[string]RecapTest{
+		"TestA":         {Name: "TestA", Fixed: true, LastIdx: 1},
+		"TestB":         {Name: "TestB", LastIdx: 5},
+		"example.com/p": {Name: "example.com/p", LastIdx: 5},
+	}
+	if len(r.Tests) != len(want) {
+		t.Errorf("tests = %+v", r.Tests)
+	}
+	for _, got := range r.Tests {
+		if got != want[got.Name] {
+			t.Errorf("test %s = %+v, want %+v", got.Name, got, want[got.Name])
+		}
+	}
+
+	s := r.String()
+	for _, line := range []string{"[exit 0] ls", "[exit 1] go test ./... (run 2 times)", "[timed out] sleep 999", "p/a.go: 2 edits, 1 failed", "TestA: passed since", "TestB: still failing"} {
+		if !strings.Contains(s, line) {
+			t.Errorf("recap lacks %q:\n%s", line, s)
+		}
+	}
+	if strings.Index(s, "ls") > strings.Index(s, "sleep") {
+		t.Errorf("recap doesn't list the last run command first:\n%s", s)
+	}
+}
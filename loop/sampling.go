@@ -0,0 +1,54 @@
+package loop
+
+import (
+	"context"
+
+	"sketch.dev/i18n"
+	"sketch.dev/llm"
+)
+
+// Sampling returns the sampling parameters the agent's requests are sent with.
+func (a *Agent) Sampling() llm.Sampling {
+	a.samplingMu.Lock()
+	defer a.samplingMu.Unlock()
+	return a.sampling
+}
+
+// SetSampling changes the sampling parameters from the next request on.
+// The change is noted in the conversation, so that whoever reads it back
+// can tell which responses were sampled how.
+func (a *Agent) SetSampling(ctx context.Context, s llm.Sampling) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	a.samplingMu.Lock()
+	old := a.sampling
+	a.sampling = s
+	a.samplingMu.Unlock()
+
+	// Set after a.sampling, so that a conversation started by a concurrent
+	// compaction picks up s either way.
+	a.mu.Lock()
+	convo := a.convo
+	a.mu.Unlock()
+	if convo != nil {
+		convo.SetSampling(samplingOrNil(s))
+	}
+
+	if old.String() == s.String() {
+		return nil
+	}
+	content := a.localize(i18n.SamplingReset)
+	if !s.IsZero() {
+		content = a.localize(i18n.SamplingChanged, s.String())
+	}
+	a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: content})
+	return nil
+}
+
+func samplingOrNil(s llm.Sampling) *llm.Sampling {
+	if s.IsZero() {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,50 @@
+package loop
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+func TestSetSampling(t *testing.T) {
+	convo := conversation.New(context.Background(), nil, nil)
+	a := &Agent{convo: convo}
+	ctx := context.Background()
+
+	sm, err := llm.ParseSampling("temperature=0,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetSampling(ctx, sm); err != nil {
+		t.Fatal(err)
+	}
+	if got := convo.Sampling(); got == nil || got.String() != "temperature=0,seed=7" {
+		t.Errorf("conversation sampling = %v", got)
+	}
+	if len(a.history) != 1 || a.history[0].Type != AutoMessageType || !strings.Contains(a.history[0].Content, "temperature=0,seed=7") {
+		t.Errorf("change not noted: %+v", a.history)
+	}
+
+	// Setting the same parameters again isn't worth a note.
+	if err := a.SetSampling(ctx, sm); err != nil || len(a.history) != 1 {
+		t.Errorf("repeated SetSampling: err %v, %d messages", err, len(a.history))
+	}
+
+	if err := a.SetSampling(ctx, llm.Sampling{}); err != nil {
+		t.Fatal(err)
+	}
+	if convo.Sampling() != nil {
+		t.Errorf("conversation sampling = %v after reset, want nil", convo.Sampling())
+	}
+	if len(a.history) != 2 {
+		t.Errorf("reset not noted: %+v", a.history)
+	}
+
+	topP := 2.0
+	if err := a.SetSampling(ctx, llm.Sampling{TopP: &topP}); err == nil {
+		t.Error("accepted top_p=2")
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"sketch.dev/claudetool/permission"
+)
+
+// ApproveRequest is the body of POST /approve: the user's decision on a tool
+// call that waits for their approval under the session's tool permissions.
+type ApproveRequest struct {
+	ID      string `json:"id"`
+	Approve bool   `json:"approve"`
+	Comment string `json:"comment,omitempty"` // told to the agent along with a denial
+	Always  bool   `json:"always,omitempty"`  // decide every later call of the tool the same way
+}
+
+// approvalAgent is implemented by agents that hold tool calls for approval.
+type approvalAgent interface {
+	PendingApprovals() []permission.ApprovalRequest
+	DecideApproval(ctx context.Context, id string, d permission.Decision) error
+}
+
+// pendingApprovals returns the tool calls waiting for approval, for State.
+func (s *Server) pendingApprovals() []permission.ApprovalRequest {
+	if a, ok := s.agent.(approvalAgent); ok {
+		return a.PendingApprovals()
+	}
+	return nil
+}
+
+// handlePendingApprovals serves GET /approve, the tool calls waiting for approval.
+func (s *Server) handlePendingApprovals(w http.ResponseWriter, r *http.Request) {
+	pending := s.pendingApprovals()
+	if pending == nil {
+		pending = []permission.ApprovalRequest{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
+
+// handleApprove serves POST /approve, which lets a waiting tool call run or
+// makes it fail, and the agent's turn go on.
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.agent.(approvalAgent)
+	if !ok {
+		httpError(w, r, "this agent doesn't hold tool calls for approval", http.StatusNotImplemented)
+		return
+	}
+	var req ApproveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	d := permission.Decision{Approve: req.Approve, Comment: req.Comment, Always: req.Always}
+	if err := a.DecideApproval(r.Context(), req.ID, d); errors.Is(err, permission.ErrUnknownRequest) {
+		httpError(w, r, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Approve {
+		s.recordAudit(r, "tool call", "approved "+req.ID)
+	} else {
+		s.recordAudit(r, "tool call", "denied "+req.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.pendingApprovals())
+}
@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+// approvingAgent is a FakeAgent with a tool call waiting for approval.
+type approvingAgent struct {
+	*looptest.FakeAgent
+	pending   []permission.ApprovalRequest
+	decisions []permission.Decision
+}
+
+func (a *approvingAgent) PendingApprovals() []permission.ApprovalRequest { return a.pending }
+
+func (a *approvingAgent) DecideApproval(ctx context.Context, id string, d permission.Decision) error {
+	for i, req := range a.pending {
+		if req.ID == id {
+			a.pending = append(a.pending[:i], a.pending[i+1:]...)
+			a.decisions = append(a.decisions, d)
+			return nil
+		}
+	}
+	return permission.ErrUnknownRequest
+}
+
+func TestApprove(t *testing.T) {
+	agent := &approvingAgent{
+		FakeAgent: looptest.NewFakeAgent(looptest.Config{}),
+		pending:   []permission.ApprovalRequest{{ID: "1", Tool: "bash", Input: `{"command":"rm -rf build"}`}},
+	}
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/state", nil))
+	var state server.State
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if len(state.PendingApprovals) != 1 || state.PendingApprovals[0].Tool != "bash" {
+		t.Errorf("state shows approvals %+v", state.PendingApprovals)
+	}
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", "/approve", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := post(`{"id":"1","approve":false,"comment":"not the build dir"}`); code != http.StatusOK {
+		t.Fatalf("POST /approve = %d", code)
+	}
+	if len(agent.decisions) != 1 || agent.decisions[0].Approve || agent.decisions[0].Comment != "not the build dir" {
+		t.Errorf("the agent got %+v", agent.decisions)
+	}
+	if code := post(`{"id":"1","approve":true}`); code != http.StatusNotFound {
+		t.Errorf("deciding a call no longer waiting = %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/approve", nil))
+	if got := strings.TrimSpace(w.Body.String()); got != "[]" {
+		t.Errorf("GET /approve = %s", got)
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"sketch.dev/loop"
+)
+
+// handleArtifacts serves GET /artifacts: the images and diagrams attached to
+// messages, as JSON, oldest first.
+func (s *Server) handleArtifacts(w http.ResponseWriter, r *http.Request) {
+	artifacts := s.agent.Artifacts()
+	if artifacts == nil {
+		artifacts = []loop.Artifact{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(artifacts)
+}
+
+// handleArtifact serves GET /artifacts/{id}: the content of one artifact.
+func (s *Server) handleArtifact(w http.ResponseWriter, r *http.Request) {
+	art, path, ok := s.agent.ArtifactFile(r.PathValue("id"))
+	if !ok {
+		httpError(w, r, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		httpError(w, r, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", art.MediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// The model wrote the SVGs; opened on their own, they must not run scripts.
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("Cache-Control", "max-age=3600") // the ID is a hash of the content
+	http.ServeContent(w, r, "", art.CreatedAt, f)
+}
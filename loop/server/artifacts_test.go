@@ -0,0 +1,67 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+func TestArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`
+	if err := os.WriteFile(filepath.Join(dir, "abc123"), []byte(svg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	agent := looptest.NewFakeAgent(looptest.Config{
+		SessionID:   "test-session",
+		Artifacts:   []loop.Artifact{{ID: "abc123", Kind: loop.ArtifactSVG, MediaType: "image/svg+xml", Size: len(svg)}},
+		ArtifactDir: dir,
+	})
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []loop.Artifact
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil || len(list) != 1 || list[0].ID != "abc123" {
+		t.Fatalf("artifacts = %+v, %v", list, err)
+	}
+
+	resp, err = http.Get(ts.URL + "/artifacts/abc123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != svg || resp.Header.Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("artifact = %s %q", resp.Header.Get("Content-Type"), body)
+	}
+	if resp.Header.Get("Content-Security-Policy") == "" {
+		t.Error("SVG served without a content security policy")
+	}
+
+	resp, err = http.Get(ts.URL + "/artifacts/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("missing artifact = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+	"sketch.dev/loop"
+)
+
+// An attachFrame is one websocket message of the /attach protocol, which
+// carries the streams the terminal UI follows, and its commands, between
+// a session and a "sketch attach --remote" client on another machine.
+//
+// The server opens with a "hello" frame, then sends a "message" frame per
+// AgentMessage and a "state" frame whenever the state may have changed.
+// The client sends commands ("user_message", "cancel", "set_turn_timeout",
+// "set_sampling", "apply_patch", "compact"), each answered by a "result" frame with the
+// same ID and the state after the command.
+type attachFrame struct {
+	Type string `json:"type"`
+	ID   int    `json:"id,omitempty"`
+
+	Message *loop.AgentMessage   `json:"message,omitempty"`
+	State   *State               `json:"state,omitempty"`
+	Budget  *conversation.Budget `json:"budget,omitempty"` // hello only
+
+	Text     string             `json:"text,omitempty"`    // user_message, or the reason to cancel
+	Timeout  string             `json:"timeout,omitempty"` // set_turn_timeout
+	Sampling *llm.Sampling      `json:"sampling,omitempty"`
+	Patch    *ApplyPatchRequest `json:"patch,omitempty"`
+	Strategy string             `json:"strategy,omitempty"` // compact
+	Model    string             `json:"model,omitempty"`    // set_model
+
+	Error       string            `json:"error,omitempty"`
+	PatchResult *loop.PatchResult `json:"patch_result,omitempty"`
+	GitStats    *loop.GitStats    `json:"git_stats,omitempty"`
+}
+
+// SetAttachToken enables the /attach endpoint for clients presenting token
+// as a bearer token. With no token, remote attach is disabled.
+func (s *Server) SetAttachToken(token string) {
+	s.attachToken = token
+}
+
+func (s *Server) handleAttach(w http.ResponseWriter, r *http.Request) {
+	if s.attachToken == "" {
+		httpError(w, r, "remote attach is disabled; start sketch with -attach-token to enable it", http.StatusNotFound)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.attachToken)) != 1 {
+		httpError(w, r, "invalid attach token", http.StatusUnauthorized)
+		return
+	}
+	from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+	// The token, not the Origin header, is what keeps other sites out:
+	// browsers can't set it on websocket requests.
+	websocket.Server{Handler: func(ws *websocket.Conn) { s.serveAttach(ws, from) }}.ServeHTTP(w, r)
+}
+
+func (s *Server) serveAttach(ws *websocket.Conn, from int) {
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+	go func() {
+		// Also unblocks the Receive below when a stream ends first.
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	var sendMu sync.Mutex
+	send := func(f attachFrame) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		ws.SetWriteDeadline(time.Now().Add(30 * time.Second))
+		return websocket.JSON.Send(ws, f)
+	}
+	sendState := func() error {
+		state := s.getState()
+		return send(attachFrame{Type: "state", State: &state})
+	}
+
+	state := s.getState()
+	budget := s.agent.OriginalBudget()
+	if err := send(attachFrame{Type: "hello", State: &state, Budget: &budget}); err != nil {
+		return
+	}
+
+	go func() {
+		defer cancel()
+		it := s.agent.NewIterator(ctx, max(from, 0))
+		defer it.Close()
+		for {
+			m := it.Next()
+			if m == nil {
+				return
+			}
+			// Usage and git stats move with messages, so follow each with the state.
+			if send(attachFrame{Type: "message", Message: m}) != nil || sendState() != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer cancel()
+		it := s.agent.NewStateTransitionIterator(ctx)
+		defer it.Close()
+		for it.Next() != nil {
+			if sendState() != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		var f attachFrame
+		if err := websocket.JSON.Receive(ws, &f); err != nil {
+			slog.DebugContext(ctx, "attach client went away", "err", err)
+			return
+		}
+		if err := send(s.attachCommand(f)); err != nil {
+			return
+		}
+	}
+}
+
+// attachCommand runs a command from an attached client and returns its result.
+func (s *Server) attachCommand(f attachFrame) attachFrame {
+	// The commands outlive the connection, like requests from the web UI.
+	ctx := context.Background()
+	res := attachFrame{Type: "result", ID: f.ID}
+	var err error
+	switch f.Type {
+	case "user_message":
+		if f.Text == "" {
+			err = errors.New("message cannot be empty")
+			break
+		}
+		s.agent.UserMessage(ctx, f.Text)
+	case "cancel":
+		reason := f.Text
+		if reason == "" {
+			reason = "user requested cancellation"
+		}
+		s.agent.CancelTurn(errors.New(reason))
+	case "set_turn_timeout":
+		d, perr := time.ParseDuration(f.Timeout)
+		if perr != nil || d < 0 {
+			err = errors.New("invalid timeout: must be a non-negative duration such as 30m")
+			break
+		}
+		s.agent.SetTurnTimeout(d)
+	case "set_sampling":
+		if f.Sampling == nil {
+			err = errors.New("missing sampling parameters")
+			break
+		}
+		err = s.agent.SetSampling(ctx, *f.Sampling)
+	case "apply_patch":
+		if f.Patch == nil {
+			err = errors.New("missing patch")
+			break
+		}
+		var pr loop.PatchResult
+		if pr, err = s.agent.ApplyPatch(ctx, f.Patch.Patch, f.Patch.Note); err == nil {
+			res.PatchResult = &pr
+		}
+	case "compact":
+		err = s.agent.Compact(ctx, f.Strategy)
+	case "set_model":
+		err = s.agent.SetModel(ctx, f.Model)
+	case "push":
+		err = s.agent.DetectGitChanges(ctx)
+	case "git_stats":
+		gs := s.agent.GitStats()
+		res.GitStats = &gs
+	default:
+		err = errors.New("unknown command " + strconv.Quote(f.Type))
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	// So that the client sees what a command changed as soon as it returns.
+	state := s.getState()
+	res.State = &state
+	return res
+}
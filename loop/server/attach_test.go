@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+func TestAttach(t *testing.T) {
+	agent := looptest.NewFakeAgent(looptest.Config{
+		Slug:          "remote-slug",
+		BranchPrefix:  "sketch/",
+		SketchGitBase: "abcd1234",
+		GitStats:      loop.GitStats{Additions: 3, Deletions: 1, FilesChanged: 1},
+		Messages:      []loop.AgentMessage{{Type: loop.UserMessageType, Content: "hello"}},
+	})
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := server.DialAttach(ctx, ts.URL, "0123456789abcdef"); err == nil {
+		t.Fatal("attached with remote attach disabled")
+	}
+	srv.SetAttachToken("0123456789abcdef")
+	if _, err := server.DialAttach(ctx, ts.URL, "wrong-token-wrong-token"); err == nil {
+		t.Fatal("attached with the wrong token")
+	}
+
+	remote, err := server.DialAttach(ctx, ts.URL+"/", "0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Slug() != "remote-slug" || remote.BranchPrefix() != "sketch/" || remote.SketchGitBase() != "abcd1234" {
+		t.Errorf("remote state: slug %q, branch prefix %q, base %q", remote.Slug(), remote.BranchPrefix(), remote.SketchGitBase())
+	}
+
+	it := remote.NewIterator(ctx, 0)
+	if m := it.Next(); m == nil || m.Content != "hello" {
+		t.Fatalf("first message = %+v", m)
+	}
+	agent.AddMessage(loop.AgentMessage{Type: loop.AgentMessageType, Content: "hi there"})
+	if m := it.Next(); m == nil || m.Content != "hi there" || m.Idx != 1 {
+		t.Fatalf("second message = %+v", m)
+	}
+
+	remote.UserMessage(ctx, "do the thing")
+	if got := agent.UserMessages(); !slices.Equal(got, []string{"do the thing"}) {
+		t.Errorf("user messages = %q", got)
+	}
+	remote.SetTurnTimeout(20 * time.Minute)
+	if d := remote.TurnTimeout(); d != 20*time.Minute {
+		t.Errorf("TurnTimeout after setting = %v", d)
+	}
+	temp := 0.0
+	if err := remote.SetSampling(ctx, llm.Sampling{Temperature: &temp}); err != nil {
+		t.Fatal(err)
+	}
+	if s := remote.Sampling(); s.Temperature == nil || *s.Temperature != 0 {
+		t.Errorf("Sampling after setting = %v", s)
+	}
+	if err := remote.SetModel(ctx, "opus"); err != nil || remote.ModelName() != "opus" {
+		t.Errorf("SetModel: %v; ModelName = %q", err, remote.ModelName())
+	}
+	if gs := remote.GitStats(); gs.Additions != 3 || gs.Deletions != 1 {
+		t.Errorf("GitStats = %+v", gs)
+	}
+	if _, err := remote.ApplyPatch(ctx, "  ", ""); err == nil {
+		t.Error("empty patch applied")
+	}
+
+	remote.Close()
+	if m := it.Next(); m != nil {
+		t.Errorf("Next after detaching = %+v", m)
+	}
+	if remote.Err() == nil {
+		t.Error("no error after detaching")
+	}
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"sketch.dev/browser"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+	"sketch.dev/loop"
+)
+
+// A RemoteAgent is a session on another machine, driven through its /attach endpoint.
+// It provides what the terminal UI needs of an agent (termui.Agent).
+type RemoteAgent struct {
+	ws     *websocket.Conn
+	budget conversation.Budget
+
+	mu       sync.Mutex
+	state    State
+	messages []loop.AgentMessage
+	changed  chan struct{} // closed and replaced whenever messages grow or the connection ends
+	pending  map[int]chan attachFrame
+	nextID   int
+	err      error // why the connection ended, once it has
+	done     chan struct{}
+}
+
+// DialAttach attaches to the session whose web UI is at baseURL, such as
+// http://buildbox:8000, authenticating with the session's attach token.
+func DialAttach(ctx context.Context, baseURL, token string) (*RemoteAgent, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("remote URL: %w", err)
+	}
+	origin := u.Scheme + "://" + u.Host
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("remote URL %q: want an http or https URL", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/attach"
+	u.RawQuery = ""
+
+	cfg, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Header.Set("Authorization", "Bearer "+token)
+	ws, err := cfg.DialContext(ctx)
+	if errors.Is(err, websocket.ErrBadStatus) {
+		return nil, fmt.Errorf("%s refused to attach: check the URL, and that the session was started with this -attach-token", baseURL)
+	} else if err != nil {
+		return nil, fmt.Errorf("attach to %s: %w", baseURL, err)
+	}
+
+	var hello attachFrame
+	ws.SetReadDeadline(time.Now().Add(30 * time.Second))
+	if err := websocket.JSON.Receive(ws, &hello); err != nil || hello.Type != "hello" || hello.State == nil {
+		ws.Close()
+		return nil, fmt.Errorf("attach to %s: no greeting from the session", baseURL)
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	r := &RemoteAgent{
+		ws:      ws,
+		state:   *hello.State,
+		changed: make(chan struct{}),
+		pending: make(map[int]chan attachFrame),
+		done:    make(chan struct{}),
+	}
+	if hello.Budget != nil {
+		r.budget = *hello.Budget
+	}
+	go r.readLoop()
+	return r, nil
+}
+
+func (r *RemoteAgent) readLoop() {
+	for {
+		var f attachFrame
+		if err := websocket.JSON.Receive(r.ws, &f); err != nil {
+			r.end(err)
+			return
+		}
+		r.mu.Lock()
+		if f.State != nil {
+			r.state = *f.State
+		}
+		switch f.Type {
+		case "message":
+			if f.Message != nil {
+				r.messages = append(r.messages, *f.Message)
+				r.notifyLocked()
+			}
+		case "result":
+			if ch, ok := r.pending[f.ID]; ok {
+				delete(r.pending, f.ID)
+				ch <- f
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *RemoteAgent) notifyLocked() {
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+func (r *RemoteAgent) end(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = fmt.Errorf("connection to the session lost: %w", err)
+	close(r.done)
+	r.notifyLocked()
+}
+
+// Done is closed when the connection to the session ends.
+func (r *RemoteAgent) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err reports why the connection ended, or nil while it is up.
+func (r *RemoteAgent) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close detaches from the session, which carries on.
+func (r *RemoteAgent) Close() error {
+	err := r.ws.Close()
+	r.end(errors.New("detached"))
+	return err
+}
+
+// command sends f to the session and waits for its result.
+func (r *RemoteAgent) command(ctx context.Context, f attachFrame) (attachFrame, error) {
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return attachFrame{}, r.err
+	}
+	r.nextID++
+	f.ID = r.nextID
+	ch := make(chan attachFrame, 1)
+	r.pending[f.ID] = ch
+	r.mu.Unlock()
+
+	if err := websocket.JSON.Send(r.ws, f); err != nil {
+		r.end(err)
+		return attachFrame{}, r.Err()
+	}
+	select {
+	case res := <-ch:
+		if res.Error != "" {
+			return res, errors.New(res.Error)
+		}
+		return res, nil
+	case <-r.done:
+		return attachFrame{}, r.Err()
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.pending, f.ID)
+		r.mu.Unlock()
+		return attachFrame{}, ctx.Err()
+	}
+}
+
+// run is command for the methods that can't report errors.
+func (r *RemoteAgent) run(f attachFrame) {
+	if _, err := r.command(context.Background(), f); err != nil {
+		slog.Warn("remote command failed", "command", f.Type, "err", err)
+	}
+}
+
+func (r *RemoteAgent) UserMessage(ctx context.Context, msg string) {
+	if _, err := r.command(ctx, attachFrame{Type: "user_message", Text: msg}); err != nil {
+		slog.WarnContext(ctx, "sending message to remote session", "err", err)
+	}
+}
+
+func (r *RemoteAgent) CancelTurn(cause error) {
+	r.run(attachFrame{Type: "cancel", Text: cause.Error()})
+}
+
+func (r *RemoteAgent) SetTurnTimeout(d time.Duration) {
+	r.run(attachFrame{Type: "set_turn_timeout", Timeout: d.String()})
+}
+
+func (r *RemoteAgent) SetSampling(ctx context.Context, s llm.Sampling) error {
+	_, err := r.command(ctx, attachFrame{Type: "set_sampling", Sampling: &s})
+	return err
+}
+
+func (r *RemoteAgent) ApplyPatch(ctx context.Context, patch, note string) (loop.PatchResult, error) {
+	res, err := r.command(ctx, attachFrame{Type: "apply_patch", Patch: &ApplyPatchRequest{Patch: patch, Note: note}})
+	if err != nil || res.PatchResult == nil {
+		return loop.PatchResult{}, err
+	}
+	return *res.PatchResult, nil
+}
+
+func (r *RemoteAgent) Compact(ctx context.Context, strategy string) error {
+	_, err := r.command(ctx, attachFrame{Type: "compact", Strategy: strategy})
+	return err
+}
+
+func (r *RemoteAgent) SetModel(ctx context.Context, name string) error {
+	_, err := r.command(ctx, attachFrame{Type: "set_model", Model: name})
+	return err
+}
+
+// DetectGitChanges has the session push its new commits.
+func (r *RemoteAgent) DetectGitChanges(ctx context.Context) error {
+	_, err := r.command(ctx, attachFrame{Type: "push"})
+	return err
+}
+
+// GitStats asks the session for its changes; it is empty if the session can't be reached.
+func (r *RemoteAgent) GitStats() loop.GitStats {
+	res, err := r.command(context.Background(), attachFrame{Type: "git_stats"})
+	if err != nil || res.GitStats == nil {
+		return loop.GitStats{}
+	}
+	return *res.GitStats
+}
+
+// NewIterator follows the session's messages from nextMessageIdx on,
+// until ctx is done or the connection ends.
+func (r *RemoteAgent) NewIterator(ctx context.Context, nextMessageIdx int) loop.MessageIterator {
+	return &remoteIterator{r: r, ctx: ctx, next: nextMessageIdx}
+}
+
+type remoteIterator struct {
+	r    *RemoteAgent
+	ctx  context.Context
+	next int
+}
+
+func (it *remoteIterator) Next() *loop.AgentMessage {
+	for {
+		it.r.mu.Lock()
+		if it.next < len(it.r.messages) {
+			m := it.r.messages[it.next]
+			it.next++
+			it.r.mu.Unlock()
+			return &m
+		}
+		if it.r.err != nil {
+			it.r.mu.Unlock()
+			return nil
+		}
+		changed := it.r.changed
+		it.r.mu.Unlock()
+		select {
+		case <-changed:
+		case <-it.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (it *remoteIterator) Close() {}
+
+func (r *RemoteAgent) snapshot() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+func (r *RemoteAgent) TotalUsage() conversation.CumulativeUsage {
+	if u := r.snapshot().TotalUsage; u != nil {
+		return *u
+	}
+	return conversation.CumulativeUsage{}
+}
+
+func (r *RemoteAgent) OriginalBudget() conversation.Budget { return r.budget }
+
+func (r *RemoteAgent) TurnTimeout() time.Duration {
+	d, _ := time.ParseDuration(r.snapshot().TurnTimeout)
+	return d
+}
+
+func (r *RemoteAgent) Sampling() llm.Sampling {
+	s, _ := llm.ParseSampling(r.snapshot().Sampling)
+	return s
+}
+
+func (r *RemoteAgent) ModelName() string     { return r.snapshot().Model }
+func (r *RemoteAgent) Slug() string          { return r.snapshot().Slug }
+func (r *RemoteAgent) BranchPrefix() string  { return r.snapshot().BranchPrefix }
+func (r *RemoteAgent) SketchGitBase() string { return r.snapshot().InitialCommit }
+func (r *RemoteAgent) GitOrigin() string     { return r.snapshot().GitOrigin }
+func (r *RemoteAgent) LinkToGitHub() bool    { return r.snapshot().LinkToGitHub }
+
+// WorkingDir is the local working directory, where the terminal UI reads patch files from.
+func (r *RemoteAgent) WorkingDir() string {
+	wd, _ := os.Getwd()
+	return wd
+}
+
+// OpenBrowser opens url on this machine.
+func (r *RemoteAgent) OpenBrowser(url string) {
+	browser.Open(url)
+}
@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/oidc"
+)
+
+// Sign-in to the web UI, for shared deployments: with SetOIDC, every request
+// needs a session from signing in with the OIDC provider, except
+//
+//	GET  /auth/login?next=PATH   redirects to the provider
+//	GET  /auth/callback          where the provider redirects back
+//
+// and /attach, which has its own token. Spectators may only read, and
+// not the terminals, container files or debug pages. The session's own
+// endpoints are
+//
+//	GET  /auth/me                the signed-in AuthUser
+//	POST /auth/logout            ends the browser's session
+//	GET  /auth/audit             who sent messages, cancelled, pushed or ended the session, as []AuditEntry
+const (
+	sessionCookie   = "sketch_session"
+	loginCookie     = "sketch_login"
+	sessionTTL      = 12 * time.Hour
+	loginTTL        = 10 * time.Minute
+	maxAuditEntries = 1000
+
+	// HostTokenHeader carries the host token of SetOIDC, with which the
+	// host sketch reaches the container's server without signing in.
+	HostTokenHeader = "X-Sketch-Host-Token"
+)
+
+// spectatorDenied are the paths spectators can't even read.
+var spectatorDenied = []string{"/terminal", "/container/files", "/debug/", "/download", "/auth/audit"}
+
+// AuthUser is a signed-in user of the web UI.
+type AuthUser struct {
+	User string    `json:"user"` // email, or the provider's subject
+	Name string    `json:"name,omitempty"`
+	Role oidc.Role `json:"role"`
+}
+
+// An AuditEntry records a signed-in user's action on the session.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user"`
+	Action string    `json:"action"` // chat, cancel, push or end
+	Detail string    `json:"detail,omitempty"`
+}
+
+type webAuth struct {
+	provider  *oidc.Provider
+	hostToken string
+	secure    bool // cookies only go over https
+
+	mu       sync.Mutex
+	sessions map[string]*authSession
+	logins   map[string]*pendingLogin // by state
+	audit    []AuditEntry
+}
+
+type authSession struct {
+	AuthUser
+	expires time.Time
+}
+
+type pendingLogin struct {
+	nonce, verifier, next string
+	expires               time.Time
+}
+
+type authUserKey struct{}
+
+// SetOIDC requires signing in with c's provider for the web UI. Requests
+// presenting hostToken in HostTokenHeader, the host sketch's, need none.
+func (s *Server) SetOIDC(c *oidc.Config, hostToken string) {
+	s.auth = &webAuth{
+		provider:  oidc.NewProvider(c),
+		hostToken: hostToken,
+		secure:    strings.HasPrefix(c.RedirectURL, "https:"),
+		sessions:  make(map[string]*authSession),
+		logins:    make(map[string]*pendingLogin),
+	}
+}
+
+// authUser returns the user who made r, if sign-in is on.
+func authUser(r *http.Request) *AuthUser {
+	u, _ := r.Context().Value(authUserKey{}).(*AuthUser)
+	return u
+}
+
+// authorize checks that r may be served, answering it itself if not.
+// It returns r with its user.
+func (a *webAuth) authorize(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	switch r.URL.Path {
+	case "/auth/login", "/auth/callback", "/attach":
+		return r, true
+	}
+	if tok := r.Header.Get(HostTokenHeader); tok != "" && a.hostToken != "" && subtle.ConstantTimeCompare([]byte(tok), []byte(a.hostToken)) == 1 {
+		return r, true
+	}
+	sess := a.session(r)
+	if sess == nil {
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		} else {
+			http.Error(w, "sign in at /auth/login", http.StatusUnauthorized)
+		}
+		return r, false
+	}
+	if sess.Role == oidc.RoleSpectator {
+		readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if r.URL.Path == "/auth/logout" {
+			readOnly = true
+		}
+		for _, p := range spectatorDenied {
+			if strings.HasPrefix(r.URL.Path, p) {
+				readOnly = false
+			}
+		}
+		if !readOnly {
+			http.Error(w, "spectators can only watch this session", http.StatusForbidden)
+			return r, false
+		}
+	}
+	user := sess.AuthUser
+	return r.WithContext(context.WithValue(r.Context(), authUserKey{}, &user)), true
+}
+
+func (a *webAuth) session(r *http.Request) *authSession {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sess := a.sessions[c.Value]
+	if sess == nil || time.Now().After(sess.expires) {
+		delete(a.sessions, c.Value)
+		return nil
+	}
+	return sess
+}
+
+func (s *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	a := s.auth
+	if a == nil {
+		httpError(w, r, "sign-in is not enabled", http.StatusNotFound)
+		return
+	}
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+	// The verifier gets two: PKCE wants at least 43 characters.
+	state, nonce, verifier := rand.Text(), rand.Text(), rand.Text()+rand.Text()
+	u, err := a.provider.AuthURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadGateway)
+		return
+	}
+	a.mu.Lock()
+	now := time.Now()
+	for k, l := range a.logins {
+		if now.After(l.expires) {
+			delete(a.logins, k)
+		}
+	}
+	a.logins[state] = &pendingLogin{nonce: nonce, verifier: verifier, next: next, expires: now.Add(loginTTL)}
+	a.mu.Unlock()
+	// Ties the callback to this browser, so no one can sign someone else in as themselves.
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Value: state, Path: "/auth/", MaxAge: int(loginTTL.Seconds()), HttpOnly: true, Secure: a.secure, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	a := s.auth
+	if a == nil {
+		httpError(w, r, "sign-in is not enabled", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		httpError(w, r, "sign-in failed: "+e+" "+q.Get("error_description"), http.StatusForbidden)
+		return
+	}
+	state := q.Get("state")
+	c, err := r.Cookie(loginCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		httpError(w, r, "sign-in was started in another browser, or too long ago; try again", http.StatusBadRequest)
+		return
+	}
+	a.mu.Lock()
+	login := a.logins[state]
+	delete(a.logins, state)
+	a.mu.Unlock()
+	if login == nil || time.Now().After(login.expires) {
+		httpError(w, r, "sign-in took too long; try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Path: "/auth/", MaxAge: -1})
+
+	claims, err := a.provider.Exchange(r.Context(), q.Get("code"), login.verifier, login.nonce)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusForbidden)
+		return
+	}
+	role := a.provider.Config().Role(claims)
+	if role == "" {
+		slog.WarnContext(r.Context(), "sign-in refused", "user", claims.User())
+		httpError(w, r, claims.User()+" may not use this session", http.StatusForbidden)
+		return
+	}
+	id := rand.Text() + rand.Text()
+	a.mu.Lock()
+	now := time.Now()
+	for k, sess := range a.sessions {
+		if now.After(sess.expires) {
+			delete(a.sessions, k)
+		}
+	}
+	a.sessions[id] = &authSession{AuthUser: AuthUser{User: claims.User(), Name: claims.Name, Role: role}, expires: now.Add(sessionTTL)}
+	a.mu.Unlock()
+	slog.InfoContext(r.Context(), "signed in", "user", claims.User(), "role", role)
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/", MaxAge: int(sessionTTL.Seconds()), HttpOnly: true, Secure: a.secure, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, login.next, http.StatusFound)
+}
+
+func (s *Server) handleAuthMe(w http.ResponseWriter, r *http.Request) {
+	u := authUser(r)
+	if u == nil {
+		httpError(w, r, "sign-in is not enabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+func (s *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		httpError(w, r, "sign-in is not enabled", http.StatusNotFound)
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.auth.mu.Lock()
+		delete(s.auth.sessions, c.Value)
+		s.auth.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAuthAudit(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		httpError(w, r, "sign-in is not enabled", http.StatusNotFound)
+		return
+	}
+	s.auth.mu.Lock()
+	entries := append([]AuditEntry{}, s.auth.audit...)
+	s.auth.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// recordAudit attributes action, on behalf of r's user, in the audit trail.
+// Without sign-in there is no one to attribute it to.
+func (s *Server) recordAudit(r *http.Request, action, detail string) {
+	u := authUser(r)
+	if s.auth == nil || u == nil {
+		return
+	}
+	if len(detail) > 200 {
+		detail = detail[:200] + "…"
+	}
+	e := AuditEntry{Time: time.Now().UTC(), User: u.User, Action: action, Detail: detail}
+	slog.InfoContext(r.Context(), "audit", "user", e.User, "action", action, "detail", detail)
+	s.auth.mu.Lock()
+	defer s.auth.mu.Unlock()
+	s.auth.audit = append(s.auth.audit, e)
+	if n := len(s.auth.audit); n > maxAuditEntries {
+		s.auth.audit = s.auth.audit[n-maxAuditEntries:]
+	}
+}
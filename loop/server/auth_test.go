@@ -0,0 +1,164 @@
+package server_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+	"sketch.dev/oidc"
+)
+
+// fakeProvider is an OIDC provider that signs in whoever email is.
+type fakeProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	email string
+	nonce string // of the last authorization request
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeProvider{key: key}
+	b64 := base64.RawURLEncoding.EncodeToString
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "authorization_endpoint": p.URL + "/authorize", "token_endpoint": p.URL + "/token", "jwks_uri": p.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{"kty": "RSA", "kid": "k", "n": b64(key.N.Bytes()), "e": b64([]byte{1, 0, 1})}}})
+		case "/token":
+			if user, pass, _ := r.BasicAuth(); user != "sketch" || pass != "s3cret" || r.FormValue("code") != "the-code" || r.FormValue("code_verifier") == "" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			h, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k"})
+			c, _ := json.Marshal(map[string]any{"iss": p.URL, "sub": p.email, "aud": "sketch", "exp": time.Now().Add(time.Hour).Unix(), "nonce": p.nonce, "email": p.email, "email_verified": true})
+			signed := b64(h) + "." + b64(c)
+			digest := sha256.Sum256([]byte(signed))
+			sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			json.NewEncoder(w).Encode(map[string]string{"id_token": signed + "." + b64(sig)})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return p
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	idp := newFakeProvider(t)
+	defer idp.Close()
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{SessionID: "test-session"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetOIDC(&oidc.Config{
+		Issuer: idp.URL, ClientID: "sketch", ClientSecret: "s3cret", RedirectURL: "http://sketch.example.com/auth/callback",
+		Owners: []string{"ann@example.com"}, Spectators: []string{"@example.com"},
+	}, "host-token")
+
+	do := func(method, path string, cookies []*http.Cookie, header ...string) *httptest.ResponseRecorder {
+		body := ""
+		if method == "POST" {
+			body = `{"message":"hi","reason":"stop"}`
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, req)
+		return rr
+	}
+	signIn := func(email string) []*http.Cookie {
+		t.Helper()
+		idp.email = email
+		rr := do("GET", "/auth/login?next=/state", nil)
+		if rr.Code != http.StatusFound {
+			t.Fatalf("GET /auth/login: %d %s", rr.Code, rr.Body)
+		}
+		loc, _ := url.Parse(rr.Header().Get("Location"))
+		q := loc.Query()
+		if !strings.HasPrefix(loc.String(), idp.URL+"/authorize") || q.Get("code_challenge_method") != "S256" {
+			t.Fatalf("login redirects to %s", loc)
+		}
+		idp.nonce = q.Get("nonce")
+		rr = do("GET", "/auth/callback?code=the-code&state="+q.Get("state"), rr.Result().Cookies())
+		if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/state" {
+			t.Fatalf("GET /auth/callback: %d %s", rr.Code, rr.Body)
+		}
+		return rr.Result().Cookies()
+	}
+
+	if rr := do("GET", "/state", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("GET /state signed out: %d", rr.Code)
+	}
+	if rr := do("GET", "/", nil, "Accept", "text/html"); rr.Code != http.StatusFound || !strings.HasPrefix(rr.Header().Get("Location"), "/auth/login?next=") {
+		t.Errorf("GET / signed out: %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := do("GET", "/state", nil, server.HostTokenHeader, "host-token"); rr.Code != http.StatusOK {
+		t.Errorf("GET /state with the host token: %d", rr.Code)
+	}
+	if rr := do("GET", "/auth/callback?code=the-code&state=forged", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("callback without the login cookie: %d", rr.Code)
+	}
+
+	bob := signIn("bob@example.com")
+	if rr := do("GET", "/state", bob); rr.Code != http.StatusOK {
+		t.Errorf("spectator GET /state: %d", rr.Code)
+	}
+	for _, path := range []string{"/chat", "/cancel"} {
+		if rr := do("POST", path, bob); rr.Code != http.StatusForbidden {
+			t.Errorf("spectator POST %s: %d", path, rr.Code)
+		}
+	}
+	if rr := do("GET", "/auth/audit", bob); rr.Code != http.StatusForbidden {
+		t.Errorf("spectator GET /auth/audit: %d", rr.Code)
+	}
+
+	ann := signIn("ann@example.com")
+	if rr := do("GET", "/auth/me", ann); !strings.Contains(rr.Body.String(), `"role":"owner"`) {
+		t.Errorf("GET /auth/me: %d %s", rr.Code, rr.Body)
+	}
+	for _, path := range []string{"/chat", "/cancel"} {
+		if rr := do("POST", path, ann); rr.Code != http.StatusOK {
+			t.Errorf("owner POST %s: %d %s", path, rr.Code, rr.Body)
+		}
+	}
+	rr := do("GET", "/auth/audit", ann)
+	var audit []server.AuditEntry
+	if err := json.Unmarshal(rr.Body.Bytes(), &audit); err != nil || len(audit) != 2 || audit[0].User != "ann@example.com" || audit[0].Action != "chat" || audit[1].Action != "cancel" {
+		t.Errorf("audit = %s", rr.Body)
+	}
+
+	if rr := do("POST", "/auth/logout", ann); rr.Code != http.StatusNoContent {
+		t.Errorf("POST /auth/logout: %d", rr.Code)
+	}
+	if rr := do("GET", "/state", ann); rr.Code != http.StatusUnauthorized {
+		t.Errorf("GET /state after logout: %d", rr.Code)
+	}
+
+	idp.email = "eve@elsewhere.com"
+	rr = do("GET", "/auth/login", nil)
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	idp.nonce = loc.Query().Get("nonce")
+	if rr := do("GET", "/auth/callback?code=the-code&state="+loc.Query().Get("state"), rr.Result().Cookies()); rr.Code != http.StatusForbidden {
+		t.Errorf("callback for someone not allowed: %d", rr.Code)
+	}
+}
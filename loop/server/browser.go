@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"sketch.dev/claudetool/browse"
+)
+
+// handleBrowserProfiles serves GET /browser/profiles.
+func (s *Server) handleBrowserProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.agent.BrowserProfiles()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profiles)
+}
+
+// handleBrowserProfileApply serves POST /browser/profiles/{name}/apply, which
+// switches the browser to the profile's viewport, user agent, and saved state.
+func (s *Server) handleBrowserProfileApply(w http.ResponseWriter, r *http.Request) {
+	p, err := s.agent.ApplyBrowserProfile(r.PathValue("name"))
+	writeBrowserProfile(w, r, p, err)
+}
+
+// handleBrowserProfileSave serves POST /browser/profiles/{name}/save, which
+// saves the browser's cookies and local storage to the profile, creating it if need be.
+func (s *Server) handleBrowserProfileSave(w http.ResponseWriter, r *http.Request) {
+	p, err := s.agent.SaveBrowserState(r.PathValue("name"))
+	writeBrowserProfile(w, r, p, err)
+}
+
+func writeBrowserProfile(w http.ResponseWriter, r *http.Request, p *browse.Profile, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		httpError(w, r, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sketch.dev/loop/server/gzhandler"
+)
+
+// minCompressSize is the smallest response worth compressing, when its size is known up front.
+const minCompressSize = 1024
+
+// compressible reports whether responses of contentType shrink when compressed.
+func compressible(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mt, "text/"):
+		return true
+	case mt == "application/json", mt == "application/javascript", mt == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// serveCompressed serves r with h, gzipping the response for clients that accept it,
+// if it is text or JSON the handler didn't encode itself. Flushes go through, so that
+// server-sent events arrive compressed as they happen.
+func serveCompressed(h http.Handler, w http.ResponseWriter, r *http.Request) {
+	if !gzhandler.Accepts(r, "gzip") || r.Header.Get("Upgrade") != "" {
+		h.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	cw := &compressWriter{ResponseWriter: w, head: r.Method == http.MethodHead}
+	defer cw.close()
+	h.ServeHTTP(cw, r)
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	head        bool
+	wroteHeader bool
+	gz          *gzip.Writer // nil unless the response is being compressed
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	hdr := cw.Header()
+	size, sizeErr := strconv.Atoi(hdr.Get("Content-Length"))
+	if code == http.StatusOK && !cw.head && hdr.Get("Content-Encoding") == "" && compressible(hdr.Get("Content-Type")) &&
+		(sizeErr != nil || size >= minCompressSize) {
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Del("Content-Length")
+		// A strong validator names the bytes sent; these aren't the handler's.
+		if etag := hdr.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			hdr.Set("ETag", "W/"+etag)
+		}
+		cw.gz = gzip.NewWriter(cw.ResponseWriter)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.gz != nil {
+		return cw.gz.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Hijack lets websocket handlers take over the connection; they never write a compressed response.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+func (cw *compressWriter) close() {
+	if cw.gz != nil {
+		cw.gz.Close()
+	}
+}
+
+// validated makes h's successful GET responses conditional: they get a weak ETag
+// from their content, and a Last-Modified header h sets is honored too, so that
+// a client polling an unchanged resource gets a bodiless 304 Not Modified.
+func validated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h(w, r)
+			return
+		}
+		rec := &recordingWriter{header: http.Header{}, code: http.StatusOK}
+		h(rec, r)
+		for k, v := range rec.header {
+			w.Header()[k] = v
+		}
+		if rec.code != http.StatusOK {
+			w.WriteHeader(rec.code)
+			w.Write(rec.body.Bytes())
+			return
+		}
+		// Have browsers revalidate each time, rather than guess how long the response stays fresh.
+		if w.Header().Get("Cache-Control") == "" {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		sum := sha256.Sum256(rec.body.Bytes())
+		w.Header().Set("ETag", `W/"`+hex.EncodeToString(sum[:12])+`"`)
+		modtime, _ := http.ParseTime(rec.header.Get("Last-Modified"))
+		w.Header().Del("Last-Modified") // ServeContent sets it from modtime
+		http.ServeContent(w, r, "", modtime.Truncate(time.Second), bytes.NewReader(rec.body.Bytes()))
+	}
+}
+
+// recordingWriter holds a response until it is complete.
+type recordingWriter struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *recordingWriter) Header() http.Header { return rw.header }
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.code = code
+		rw.wroteHeader = true
+	}
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.body.Write(b)
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeCompressed(t *testing.T) {
+	big := strings.Repeat(`{"content": "hello"}`, 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, big)
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "2")
+		io.WriteString(w, "{}")
+	})
+	mux.HandleFunc("/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, big)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "event: state\ndata: {}\n\n")
+		w.(http.Flusher).Flush()
+	})
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		serveCompressed(mux, rec, req)
+		return rec
+	}
+	gunzip := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	rec := get("/json", "gzip, br")
+	if rec.Header().Get("Content-Encoding") != "gzip" || gunzip(rec) != big {
+		t.Errorf("/json: encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec := get("/json", "gzip;q=0"); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != big {
+		t.Errorf("/json refusing gzip: encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+	for _, path := range []string{"/small", "/png"} {
+		if rec := get(path, "gzip"); rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s compressed", path)
+		}
+	}
+	rec = get("/stream", "gzip")
+	if !rec.Flushed || gunzip(rec) != "event: state\ndata: {}\n\n" {
+		t.Errorf("/stream: flushed %v", rec.Flushed)
+	}
+}
+
+func TestValidated(t *testing.T) {
+	body := "[1, 2, 3]"
+	h := validated(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("missing") != "" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	})
+	get := func(path, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	rec := get("/messages", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != body || !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("first GET: %d %q, ETag %q", rec.Code, rec.Body, etag)
+	}
+	if rec := get("/messages", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidating: %d %q", rec.Code, rec.Body)
+	}
+	body = "[1, 2, 3, 4]"
+	if rec := get("/messages", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("after a change: %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := get("/messages?missing=1", ""); rec.Code != http.StatusNotFound || rec.Header().Get("ETag") != "" {
+		t.Errorf("error response: %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The web UI moves files in and out of the container without docker cp:
+//
+//	GET  /container/files?path=DIR                  -> ContainerFileList
+//	GET  /container/files/download?path=FILE        the file's bytes
+//	POST /container/files/upload?path=FILE          the file's bytes -> ContainerFile
+//
+// Only paths under the file roots (/app and /tmp in a container; none
+// elsewhere) are served, after resolving symlinks. Files in both directions
+// are limited to the upload size limit. Bodies stream to and from disk, so
+// progress is whatever the browser reports; an upload lands under a temporary
+// name and is renamed into place once complete, and replaces an existing file
+// only with overwrite=1.
+
+// maxContainerFileList bounds the number of entries a listing returns.
+const maxContainerFileList = 2000
+
+var (
+	errContainerFileOutside = errors.New("path is outside the directories files may be transferred from")
+	errContainerFileInvalid = errors.New("path must be absolute and clean")
+)
+
+// ContainerFile describes a file or directory in the container.
+type ContainerFile struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// ContainerFileList is the response to GET /container/files.
+type ContainerFileList struct {
+	Path      string          `json:"path"`  // the listed directory, or empty for the roots
+	Roots     []string        `json:"roots"` // the directories files may be transferred from
+	Files     []ContainerFile `json:"files"` // directories first, then by name
+	Truncated bool            `json:"truncated,omitempty"`
+	MaxSize   int64           `json:"max_size"` // the largest file that can be downloaded or uploaded
+}
+
+// SetContainerFileRoots sets the directories the web UI may transfer files from and to.
+func (s *Server) SetContainerFileRoots(roots ...string) {
+	s.fileRoots = roots
+}
+
+// resolveContainerPath returns where p, an absolute path under one of the file
+// roots, actually refers to. For a file that doesn't exist yet, pass parent, and
+// only its directory is resolved.
+func (s *Server) resolveContainerPath(p string, parent bool) (string, error) {
+	if !filepath.IsAbs(p) || filepath.Clean(p) != p {
+		return "", errContainerFileInvalid
+	}
+	resolved := p
+	if parent {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+		if err != nil {
+			return "", err
+		}
+		resolved = filepath.Join(dir, filepath.Base(p))
+	} else {
+		var err error
+		if resolved, err = filepath.EvalSymlinks(p); err != nil {
+			return "", err
+		}
+	}
+	for _, root := range s.fileRoots {
+		root, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			return resolved, nil
+		}
+	}
+	return "", errContainerFileOutside
+}
+
+func containerFileErrorCode(err error) int {
+	switch {
+	case errors.Is(err, errContainerFileInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errContainerFileOutside):
+		return http.StatusForbidden
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func containerFile(p string, info os.FileInfo) ContainerFile {
+	f := ContainerFile{Name: info.Name(), Path: p, Dir: info.IsDir(), ModTime: info.ModTime()}
+	if !f.Dir {
+		f.Size = info.Size()
+	}
+	return f
+}
+
+func (s *Server) handleContainerFiles(w http.ResponseWriter, r *http.Request) {
+	list := ContainerFileList{
+		Path:    r.URL.Query().Get("path"),
+		Roots:   append([]string{}, s.fileRoots...),
+		Files:   []ContainerFile{},
+		MaxSize: s.uploads.max,
+	}
+	if list.Path == "" {
+		for _, root := range s.fileRoots {
+			if info, err := os.Stat(root); err == nil && info.IsDir() {
+				list.Files = append(list.Files, containerFile(root, info))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	dir, err := s.resolveContainerPath(list.Path, false)
+	if err != nil {
+		httpError(w, r, err.Error(), containerFileErrorCode(err))
+		return
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		httpError(w, r, err.Error(), containerFileErrorCode(err))
+		return
+	}
+	defer f.Close()
+	entries, err := f.ReadDir(maxContainerFileList + 1)
+	if err != nil && err != io.EOF {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for i, e := range entries {
+		if i == maxContainerFileList {
+			list.Truncated = true
+			break
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since it was listed
+		}
+		// Paths stay as the client asked for them, so that it can navigate through symlinks.
+		f := containerFile(filepath.Join(list.Path, e.Name()), info)
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(dir, e.Name())); err == nil {
+				f.Dir, f.Size = target.IsDir(), 0
+				if !f.Dir {
+					f.Size = target.Size()
+				}
+			}
+		}
+		list.Files = append(list.Files, f)
+	}
+	slices.SortFunc(list.Files, func(a, b ContainerFile) int {
+		if a.Dir != b.Dir {
+			if a.Dir {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func (s *Server) handleContainerFileDownload(w http.ResponseWriter, r *http.Request) {
+	p, err := s.resolveContainerPath(r.URL.Query().Get("path"), false)
+	if err != nil {
+		httpError(w, r, err.Error(), containerFileErrorCode(err))
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		httpError(w, r, err.Error(), containerFileErrorCode(err))
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		httpError(w, r, "Not a regular file", http.StatusBadRequest)
+		return
+	}
+	if info.Size() > s.uploads.max {
+		httpError(w, r, "File is larger than the transfer limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(p)}))
+	http.ServeContent(w, r, filepath.Base(p), info.ModTime(), f)
+}
+
+func (s *Server) handleContainerFileUpload(w http.ResponseWriter, r *http.Request) {
+	p, err := s.resolveContainerPath(r.URL.Query().Get("path"), true)
+	if err != nil {
+		httpError(w, r, err.Error(), containerFileErrorCode(err))
+		return
+	}
+	if info, err := os.Stat(p); err == nil {
+		if info.IsDir() {
+			httpError(w, r, "A directory already exists at that path", http.StatusConflict)
+			return
+		}
+		if r.URL.Query().Get("overwrite") != "1" {
+			httpError(w, r, "File already exists", http.StatusConflict)
+			return
+		}
+	}
+	if r.ContentLength > s.uploads.max {
+		httpError(w, r, "File is larger than the transfer limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".upload-*")
+	if err != nil {
+		httpError(w, r, err.Error(), containerFileErrorCode(err))
+		return
+	}
+	defer os.Remove(tmp.Name()) // a no-op once renamed
+	_, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, s.uploads.max))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			httpError(w, r, "File is larger than the transfer limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+		httpError(w, r, "Failed to save file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		httpError(w, r, "Failed to save file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(containerFile(r.URL.Query().Get("path"), info))
+}
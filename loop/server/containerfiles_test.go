@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestContainerFiles(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "bin"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "report.txt"), []byte("all good"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("shh"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{uploads: newUploadStore(t.TempDir())}
+	s.SetContainerFileRoots(root)
+	s.uploads.max = 16
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		switch {
+		case strings.HasPrefix(target, "/container/files/download"):
+			s.handleContainerFileDownload(w, r)
+		case strings.HasPrefix(target, "/container/files/upload"):
+			s.handleContainerFileUpload(w, r)
+		default:
+			s.handleContainerFiles(w, r)
+		}
+		return w
+	}
+	q := func(p string) string { return url.QueryEscape(p) }
+
+	w := do("GET", "/container/files?path="+q(root), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", w.Code, w.Body)
+	}
+	var list ContainerFileList
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range list.Files {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, " "); got != "bin escape report.txt" {
+		t.Errorf("listed %q, want directories first", got)
+	}
+	if list.MaxSize != 16 || len(list.Roots) != 1 {
+		t.Errorf("list = %+v", list)
+	}
+
+	w = do("GET", "/container/files/download?path="+q(filepath.Join(root, "report.txt")), "")
+	if w.Code != http.StatusOK || w.Body.String() != "all good" {
+		t.Errorf("download: %d %q", w.Code, w.Body)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "report.txt") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	for target, want := range map[string]int{
+		"/container/files/download?path=" + q(filepath.Join(root, "escape", "secret")): http.StatusForbidden,
+		"/container/files/download?path=" + q(filepath.Join(outside, "secret")):        http.StatusForbidden,
+		"/container/files/download?path=" + q(root+"/bin/../report.txt"):               http.StatusBadRequest,
+		"/container/files/download?path=" + q(filepath.Join(root, "bin")):              http.StatusBadRequest,
+		"/container/files/download?path=" + q(filepath.Join(root, "missing")):          http.StatusNotFound,
+		"/container/files?path=" + q(filepath.Join(root, "escape")):                    http.StatusForbidden,
+	} {
+		if w := do("GET", target, ""); w.Code != want {
+			t.Errorf("GET %s: %d, want %d", target, w.Code, want)
+		}
+	}
+
+	upload := func(p, body string, query ...string) int {
+		return do("POST", "/container/files/upload?path="+q(p)+strings.Join(query, ""), body).Code
+	}
+	if code := upload(filepath.Join(root, "bin", "tool"), "#!/bin/sh"); code != http.StatusOK {
+		t.Errorf("upload: %d", code)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "bin", "tool")); err != nil || string(got) != "#!/bin/sh" {
+		t.Errorf("uploaded %q, %v", got, err)
+	}
+	if code := upload(filepath.Join(root, "report.txt"), "new"); code != http.StatusConflict {
+		t.Errorf("upload over an existing file: %d, want 409", code)
+	}
+	if code := upload(filepath.Join(root, "report.txt"), "new", "&overwrite=1"); code != http.StatusOK {
+		t.Errorf("upload with overwrite: %d", code)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "report.txt")); string(got) != "new" {
+		t.Errorf("overwritten file has %q", got)
+	}
+	if code := upload(filepath.Join(root, "escape", "planted"), "x"); code != http.StatusForbidden {
+		t.Errorf("upload through a symlink out of the root: %d, want 403", code)
+	}
+	if code := upload(filepath.Join(root, "big"), strings.Repeat("x", 17)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over the limit: %d, want 413", code)
+	}
+	entries, _ := os.ReadDir(root)
+	for _, e := range entries {
+		if e.Name() == "big" || strings.Contains(e.Name(), ".upload-") {
+			t.Errorf("left %s behind after a failed upload", e.Name())
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"sketch.dev/loop"
+)
+
+// AttachContextRequest is the body of POST /context.
+type AttachContextRequest struct {
+	Paths []string `json:"paths"` // relative to the repository root; directories stand for their files
+}
+
+// maxContextCandidates bounds the files GET /context/files offers the picker.
+const maxContextCandidates = 5000
+
+// handleContext serves GET /context, the files attached to the next message.
+func (s *Server) handleContext(w http.ResponseWriter, r *http.Request) {
+	writeContextBundle(w, s.agent.AttachedContext())
+}
+
+// handleContextAttach serves POST /context, which attaches more files to the next message.
+func (s *Server) handleContextAttach(w http.ResponseWriter, r *http.Request) {
+	var req AttachContextRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 {
+		httpError(w, r, "No paths to attach", http.StatusBadRequest)
+		return
+	}
+	b, err := s.agent.AttachContext(r.Context(), req.Paths)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.recordAudit(r, "attach", strings.Join(req.Paths, ", "))
+	writeContextBundle(w, b)
+}
+
+// handleContextClear serves DELETE /context, which detaches all files.
+func (s *Server) handleContextClear(w http.ResponseWriter, r *http.Request) {
+	s.agent.ClearContext()
+	writeContextBundle(w, s.agent.AttachedContext())
+}
+
+// handleContextFiles serves GET /context/files, the files the picker offers:
+// those in the repository git doesn't ignore.
+func (s *Server) handleContextFiles(w http.ResponseWriter, r *http.Request) {
+	cmd := exec.CommandContext(r.Context(), "git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = s.agent.RepoRoot()
+	out, err := cmd.Output()
+	if err != nil {
+		httpError(w, r, "Listing files: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	files := []string{}
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" && len(files) < maxContextCandidates {
+			files = append(files, f)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+func writeContextBundle(w http.ResponseWriter, b loop.ContextBundle) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b)
+}
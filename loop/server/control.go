@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"sketch.dev/control"
+	"sketch.dev/loop"
+)
+
+// HandleControl answers the host's requests on the control plane p: init,
+// health, and shutdown.
+func (s *Server) HandleControl(p *control.Peer) {
+	p.Handle(control.MethodInit, s.controlInit)
+	p.Handle(control.MethodHealth, s.controlHealth)
+	p.Handle(control.MethodShutdown, func(ctx context.Context, body []byte) ([]byte, error) {
+		s.end("the host asked sketch to shut down")
+		return nil, nil
+	})
+}
+
+func (s *Server) controlInit(ctx context.Context, body []byte) ([]byte, error) {
+	m := &InitRequest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	err := s.init(m, s.agent.Init)
+	if errors.Is(err, loop.ErrInitConflict) {
+		// An earlier host process initialized the agent; carry the session over.
+		err = s.init(m, s.agent.Reinit)
+	}
+	return nil, err
+}
+
+func (s *Server) controlHealth(ctx context.Context, body []byte) ([]byte, error) {
+	h := control.Health{
+		State:        s.agent.CurrentStateName(),
+		MessageCount: s.agent.MessageCount(),
+	}
+	select {
+	case <-s.agent.Ready():
+		h.Ready = true
+	default:
+	}
+	return json.Marshal(h)
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"sketch.dev/control"
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+// reinitAgent is a FakeAgent an earlier host process already initialized.
+type reinitAgent struct {
+	*looptest.FakeAgent
+	reinit chan loop.AgentInit
+}
+
+func (a *reinitAgent) Init(loop.AgentInit) error {
+	return fmt.Errorf("Agent.Init: %w", loop.ErrInitConflict)
+}
+
+func (a *reinitAgent) Reinit(ini loop.AgentInit) error {
+	a.reinit <- ini
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	agent := &reinitAgent{FakeAgent: looptest.NewFakeAgent(looptest.Config{}), reinit: make(chan loop.AgentInit, 1)}
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, container := control.NewPeer(), control.NewPeer()
+	srv.HandleControl(container)
+	go host.Serve(ctx, ln, "secret")
+	go container.DialAndServe(ctx, ln.Addr().String(), "secret")
+
+	// The agent is already initialized, so init carries the session over.
+	if err := host.CallJSON(ctx, control.MethodInit, server.InitRequest{HostAddr: "127.0.0.1:8000"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if ini := <-agent.reinit; ini.HostAddr != "127.0.0.1:8000" || !ini.InDocker {
+		t.Errorf("Reinit(%+v)", ini)
+	}
+
+	var h control.Health
+	if err := host.CallJSON(ctx, control.MethodHealth, nil, &h); err != nil || !h.Ready {
+		t.Errorf("health = %+v, %v", h, err)
+	}
+
+	if _, err := host.Call(ctx, control.MethodShutdown, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-srv.Ended():
+	case <-ctx.Done():
+		t.Error("shutdown didn't end the session")
+	}
+}
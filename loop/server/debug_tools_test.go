@@ -5,7 +5,9 @@ import (
 	"strings"
 	"testing"
 
+	"sketch.dev/claudetool"
 	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
 )
 
 func TestRenderToolsDebugPage_UsesPre(t *testing.T) {
@@ -18,7 +20,7 @@ func TestRenderToolsDebugPage_UsesPre(t *testing.T) {
 	}
 
 	w := httptest.NewRecorder()
-	renderToolsDebugPage(w, tools)
+	renderToolsDebugPage(w, tools, nil)
 	html := w.Body.String()
 
 	// Verify CSS includes pre-wrap styling
@@ -55,3 +57,63 @@ func TestRenderToolsDebugPage_UsesPre(t *testing.T) {
 		t.Error("Expected tool description to be included")
 	}
 }
+
+func TestRenderToolsDebugPage_Disabled(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderToolsDebugPage(w, []*llm.Tool{{Name: "bash"}}, []string{"browser_navigate", "mcp"})
+	html := w.Body.String()
+
+	if !strings.Contains(html, "<strong>Disabled for this session:</strong> browser_navigate, mcp") {
+		t.Errorf("Expected disabled tools to be listed:\n%s", html)
+	}
+
+	w = httptest.NewRecorder()
+	renderToolsDebugPage(w, []*llm.Tool{{Name: "bash"}}, nil)
+	if strings.Contains(w.Body.String(), "Disabled for this session") {
+		t.Error("Expected no disabled section without a tool filter")
+	}
+}
+
+func TestRenderArtifactsDebugPage(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderArtifactsDebugPage(w, map[string]claudetool.Artifact{
+		"b-notes":    {Value: "hello", Description: "<b>notes</b>"},
+		"a-listing?": {Value: "x"},
+	})
+	html := w.Body.String()
+
+	if !strings.Contains(html, "<strong>Total Artifacts:</strong> 2") {
+		t.Error("Expected artifact count")
+	}
+	if !strings.Contains(html, "&lt;b&gt;notes&lt;/b&gt;") {
+		t.Error("Expected description to be HTML-escaped")
+	}
+	if !strings.Contains(html, `href="artifacts?key=a-listing%3F"`) {
+		t.Error("Expected key to be query-escaped in link")
+	}
+	if strings.Index(html, "a-listing?") > strings.Index(html, "b-notes") {
+		t.Error("Expected artifacts sorted by key")
+	}
+}
+
+func TestRenderContextDebugPage(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderContextDebugPage(w, conversation.ContextSize{
+		SystemTokens:   1200,
+		Tools:          []conversation.ToolSize{{Name: "think", SentTokens: 20, FullTokens: 20}, {Name: "bash", SentTokens: 40, FullTokens: 900}, {Name: "tool_help", SentTokens: 60}},
+		ToolTokens:     120,
+		FullToolTokens: 920,
+		SlimTools:      true,
+	}, llm.Usage{})
+	html := w.Body.String()
+
+	if !strings.Contains(html, "on: saves 800 tokens a request") {
+		t.Errorf("Expected the savings of slimming:\n%s", html)
+	}
+	if b, th := strings.Index(html, "<td>bash</td>"), strings.Index(html, "<td>think</td>"); b < 0 || th < b {
+		t.Error("Expected the tools largest first")
+	}
+	if strings.Contains(html, "as billed") {
+		t.Error("Expected no billed input before the first request")
+	}
+}
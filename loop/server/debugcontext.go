@@ -0,0 +1,60 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// renderContextDebugPage renders an HTML page breaking down what each request
+// spends its tokens on, tool by tool, largest first.
+func renderContextDebugPage(w http.ResponseWriter, cs conversation.ContextSize, last llm.Usage) {
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+	<title>Sketch Context Debug</title>
+	<style>
+		body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; margin: 40px; }
+		table { border-collapse: collapse; margin-bottom: 24px; }
+		td, th { border: 1px solid #e9ecef; padding: 4px 10px; text-align: left; }
+		td.num { text-align: right; font-family: 'SF Mono', Monaco, monospace; }
+	</style>
+</head>
+<body>
+	<h1>Sketch Context Debug</h1>
+	<p>Estimated at 4 bytes a token. JSON: <a href="context?format=json">context?format=json</a></p>
+	<table>
+		<tr><th>Part</th><th>Tokens</th></tr>
+		<tr><td>System prompt</td><td class="num">%d</td></tr>
+		<tr><td>Tools, as sent</td><td class="num">%d</td></tr>
+		<tr><td>Tools, in full</td><td class="num">%d</td></tr>
+		<tr><td>Messages</td><td class="num">%d</td></tr>
+`, cs.SystemTokens, cs.ToolTokens, cs.FullToolTokens, cs.MessageTokens)
+	if !last.IsZero() {
+		fmt.Fprintf(w, "\t\t<tr><td>Last request's input, as billed</td><td class=\"num\">%d</td></tr>\n",
+			last.InputTokens+last.CacheReadInputTokens+last.CacheCreationInputTokens)
+	}
+	slim := "off: tools are sent in full"
+	if cs.SlimTools {
+		slim = fmt.Sprintf("on: saves %d tokens a request", cs.FullToolTokens-min(cs.ToolTokens, cs.FullToolTokens))
+	}
+	fmt.Fprintf(w, `	</table>
+	<p><strong>Tool slimming</strong> %s</p>
+	<table>
+		<tr><th>Tool</th><th>Sent</th><th>Full</th></tr>
+`, html.EscapeString(slim))
+	tools := slices.SortedFunc(slices.Values(cs.Tools), func(a, b conversation.ToolSize) int {
+		return cmp.Or(cmp.Compare(b.FullTokens, a.FullTokens), cmp.Compare(a.Name, b.Name))
+	})
+	for _, t := range tools {
+		fmt.Fprintf(w, "\t\t<tr><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%d</td></tr>\n", html.EscapeString(t.Name), t.SentTokens, t.FullTokens)
+	}
+	fmt.Fprintf(w, `	</table>
+</body>
+</html>`)
+}
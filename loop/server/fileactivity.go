@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/loop"
+)
+
+// FileActivityEntry is one point in the conversation where the agent touched a file.
+type FileActivityEntry struct {
+	Idx        int       `json:"idx"`  // index of the message in the conversation
+	Kind       string    `json:"kind"` // "edit", "bash", "tool", or "commit"
+	ToolName   string    `json:"tool_name,omitempty"`
+	ToolCallID string    `json:"tool_call_id,omitempty"`
+	CommitHash string    `json:"commit_hash,omitempty"`
+	Summary    string    `json:"summary"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// FileActivityResponse lists the conversation entries that touched a file, oldest first.
+type FileActivityResponse struct {
+	Path    string              `json:"path"`
+	Entries []FileActivityEntry `json:"entries"`
+}
+
+// commitFilesCache maps commit hashes to the repo-relative paths they changed.
+// Commits are immutable, so entries never go stale.
+type commitFilesCache struct {
+	mu    sync.Mutex
+	files map[string][]string
+}
+
+func (c *commitFilesCache) get(repoRoot, hash string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if files, ok := c.files[hash]; ok {
+		return files
+	}
+	cmd := exec.Command("git", "diff-tree", "-z", "--no-commit-id", "--name-only", "-r", "--root", hash)
+	cmd.Dir = repoRoot
+	out, err := cmd.Output()
+	if err != nil {
+		// Don't cache failures; the commit may not have been fetched yet.
+		return nil
+	}
+	var files []string
+	for name := range strings.SplitSeq(string(out), "\x00") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	if c.files == nil {
+		c.files = make(map[string][]string)
+	}
+	c.files[hash] = files
+	return files
+}
+
+// handleFileActivity serves /files/{path}/activity, where path is relative to the repo root.
+func (s *Server) handleFileActivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rest, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/files/"), "/activity")
+	if !ok || rest == "" {
+		httpError(w, r, "Not found", http.StatusNotFound)
+		return
+	}
+	relPath := filepath.Clean(rest)
+	if filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		httpError(w, r, "Path must be relative to the repository root", http.StatusBadRequest)
+		return
+	}
+
+	resp := FileActivityResponse{
+		Path:    relPath,
+		Entries: s.fileActivity(relPath),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// fileActivity scans the conversation for tool calls and commits involving relPath.
+func (s *Server) fileActivity(relPath string) []FileActivityEntry {
+	repoRoot := s.agent.RepoRoot()
+	absPath := filepath.Join(repoRoot, relPath)
+	entries := []FileActivityEntry{}
+	for _, msg := range s.agent.Messages(0, s.agent.MessageCount()) {
+		switch msg.Type {
+		case loop.ToolUseMessageType:
+			if entry, ok := toolFileActivity(msg, repoRoot, relPath, absPath); ok {
+				entries = append(entries, entry)
+			}
+		case loop.CommitMessageType:
+			for _, c := range msg.Commits {
+				for _, f := range s.commitFiles.get(repoRoot, c.Hash) {
+					if f == relPath {
+						entries = append(entries, FileActivityEntry{
+							Idx:        msg.Idx,
+							Kind:       "commit",
+							CommitHash: c.Hash,
+							Summary:    c.Subject,
+							Timestamp:  msg.Timestamp,
+						})
+						break
+					}
+				}
+			}
+		}
+	}
+	return entries
+}
+
+// toolFileActivity reports whether a tool call involved the file at relPath (or, equivalently, absPath).
+func toolFileActivity(msg loop.AgentMessage, repoRoot, relPath, absPath string) (FileActivityEntry, bool) {
+	entry := FileActivityEntry{
+		Idx:        msg.Idx,
+		ToolName:   msg.ToolName,
+		ToolCallID: msg.ToolCallId,
+		Timestamp:  msg.Timestamp,
+	}
+	var input struct {
+		Path    string `json:"path"`
+		Command string `json:"command"`
+	}
+	json.Unmarshal([]byte(msg.ToolInput), &input)
+
+	if input.Path != "" {
+		p := input.Path
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(repoRoot, p)
+		}
+		if filepath.Clean(p) != absPath {
+			return entry, false
+		}
+		entry.Kind = "tool"
+		if msg.ToolName == claudetool.PatchName {
+			entry.Kind = "edit"
+		}
+		entry.Summary = fmt.Sprintf("%s %s", msg.ToolName, relPath)
+		if msg.ToolError {
+			entry.Summary += " (failed)"
+		}
+		return entry, true
+	}
+
+	if msg.ToolName == "bash" {
+		// Match whole path mentions only, so that foo.go doesn't match foo.go.orig.
+		if mentionsPath(input.Command, relPath) || mentionsPath(input.Command, absPath) || mentionsPath(msg.ToolResult, relPath) {
+			entry.Kind = "bash"
+			entry.Summary = firstLine(input.Command)
+			return entry, true
+		}
+	}
+	return entry, false
+}
+
+// mentionsPath reports whether text contains path delimited by non-path characters.
+func mentionsPath(text, path string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], path)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(path)
+		before := strings.TrimSuffix(text[:start], "./")
+		if (before == "" || !isPathChar(before[len(before)-1])) && (end == len(text) || !isPathChar(text[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isPathChar(c byte) bool {
+	return c == '/' || c == '.' || c == '_' || c == '-' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sketch.dev/loop"
+)
+
+func TestMentionsPath(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"go test ./loop/agent.go", true},
+		{"gofmt -l loop/agent.go", true},
+		{"cat loop/agent.go | head", true},
+		{"loop/agent.go:12: undefined: x", true},
+		{"cat loop/agent.go.orig", false},
+		{"cat myloop/agent.go", false},
+		{"cat other/loop/agent.go", false},
+		{"go build ./...", false},
+	}
+	for _, tt := range tests {
+		if got := mentionsPath(tt.text, "loop/agent.go"); got != tt.want {
+			t.Errorf("mentionsPath(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestToolFileActivity(t *testing.T) {
+	const root = "/app"
+	tests := []struct {
+		name     string
+		msg      loop.AgentMessage
+		wantKind string
+	}{
+		{
+			name:     "patch with absolute path",
+			msg:      loop.AgentMessage{ToolName: "patch", ToolInput: `{"path": "/app/main.go", "patches": []}`},
+			wantKind: "edit",
+		},
+		{
+			name:     "other tool with relative path",
+			msg:      loop.AgentMessage{ToolName: "read_image", ToolInput: `{"path": "main.go"}`},
+			wantKind: "tool",
+		},
+		{
+			name: "patch to another file",
+			msg:  loop.AgentMessage{ToolName: "patch", ToolInput: `{"path": "/app/other.go"}`},
+		},
+		{
+			name:     "bash command",
+			msg:      loop.AgentMessage{ToolName: "bash", ToolInput: `{"command": "go vet main.go\necho done"}`},
+			wantKind: "bash",
+		},
+		{
+			name:     "bash output",
+			msg:      loop.AgentMessage{ToolName: "bash", ToolInput: `{"command": "gofmt -l ."}`, ToolResult: "main.go\n"},
+			wantKind: "bash",
+		},
+		{
+			name: "unrelated bash",
+			msg:  loop.AgentMessage{ToolName: "bash", ToolInput: `{"command": "ls"}`, ToolResult: "README.md"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, ok := toolFileActivity(tt.msg, root, "main.go", "/app/main.go")
+			if ok != (tt.wantKind != "") || entry.Kind != tt.wantKind {
+				t.Errorf("got kind %q (ok=%v), want %q", entry.Kind, ok, tt.wantKind)
+			}
+		})
+	}
+}
+
+func TestCommitFilesCache(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "my notes.md"), []byte("notes\n"), 0o644)
+	os.WriteFile(filepath.Join(root, "caf\u00e9.go"), []byte("package main\n"), 0o644)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=t", "-c", "user.email=t@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", root}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+	var c commitFilesCache
+	files := c.get(root, "HEAD")
+	if want := []string{"caf\u00e9.go", "my notes.md"}; !slices.Equal(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"sketch.dev/loop"
+)
+
+// GitIdentityRequest is the body of POST /git/identity. Fields left out keep
+// their current values.
+type GitIdentityRequest struct {
+	Name     string              `json:"name,omitempty"`
+	Email    string              `json:"email,omitempty"`
+	Trailers *loop.TrailerPolicy `json:"trailers,omitempty"`
+}
+
+// gitIdentityAgent is implemented by agents whose git identity can change
+// during the session.
+type gitIdentityAgent interface {
+	GitIdentity() loop.GitIdentity
+	SetGitIdentity(ctx context.Context, id loop.GitIdentity) error
+}
+
+// handleGitIdentity serves GET /git/identity: who the agent commits as, and
+// the trailers it adds.
+func (s *Server) handleGitIdentity(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.agent.(gitIdentityAgent)
+	if !ok {
+		httpError(w, r, "this agent's git identity is fixed", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.GitIdentity())
+}
+
+// handleSetGitIdentity serves POST /git/identity, which changes who the agent
+// commits as from its next commit on.
+func (s *Server) handleSetGitIdentity(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.agent.(gitIdentityAgent)
+	if !ok {
+		httpError(w, r, "this agent's git identity is fixed", http.StatusNotImplemented)
+		return
+	}
+	var req GitIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := a.GitIdentity()
+	id.Name, id.Email = req.Name, req.Email
+	if req.Trailers != nil {
+		id.Trailers = *req.Trailers
+	}
+	if err := a.SetGitIdentity(r.Context(), id); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id = a.GitIdentity()
+	s.recordAudit(r, "git identity", id.Name+" <"+id.Email+"> trailers "+id.Trailers.String())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(id)
+}
@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+// identityAgent is a FakeAgent whose git identity can change.
+type identityAgent struct {
+	*looptest.FakeAgent
+	id loop.GitIdentity
+}
+
+func (a *identityAgent) GitIdentity() loop.GitIdentity { return a.id }
+
+func (a *identityAgent) SetGitIdentity(ctx context.Context, id loop.GitIdentity) error {
+	if err := id.Trailers.Validate(); err != nil {
+		return err
+	}
+	if id.Name == "" {
+		return errors.New("no name")
+	}
+	a.id = id
+	return nil
+}
+
+func TestGitIdentity(t *testing.T) {
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/git/identity", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("GET /git/identity of a fixed identity = %d", w.Code)
+	}
+
+	agent := &identityAgent{
+		FakeAgent: looptest.NewFakeAgent(looptest.Config{}),
+		id:        loop.GitIdentity{Name: "Ada", Email: "ada@example.com", Trailers: loop.DefaultTrailerPolicy()},
+	}
+	if srv, err = server.New(agent, nil); err != nil {
+		t.Fatal(err)
+	}
+	post := func(body string) (int, loop.GitIdentity) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", "/git/identity", strings.NewReader(body)))
+		var id loop.GitIdentity
+		json.Unmarshal(w.Body.Bytes(), &id)
+		return w.Code, id
+	}
+
+	// Leaving the trailers out keeps them.
+	code, id := post(`{"name":"Ada L","email":"ada@example.org"}`)
+	if code != http.StatusOK || id.Name != "Ada L" || id.Email != "ada@example.org" || !id.Trailers.ChangeID {
+		t.Errorf("POST /git/identity = %d, %+v", code, id)
+	}
+	code, id = post(`{"name":"Ada L","trailers":{"co_authors":null,"change_id":false,"sign_off":true}}`)
+	if code != http.StatusOK || id.Trailers.String() != "co-author=off,change-id=off,signoff=on" {
+		t.Errorf("POST /git/identity trailers = %d, %+v", code, id)
+	}
+	if code, _ := post(`{"name":"Ada L","trailers":{"co_authors":["nobody"]}}`); code != http.StatusBadRequest {
+		t.Errorf("POST /git/identity with a bad co-author = %d", code)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Statuses of a pushed ref, as GitPushResponse reports them.
+const (
+	pushNew         = "new"
+	pushFastForward = "fast-forward"
+	pushForced      = "forced"
+	pushUpToDate    = "up-to-date"
+	pushRejected    = "rejected"
+)
+
+// validBranchName reports why branch can't name a branch, or "" if it can.
+func validBranchName(branch string) string {
+	if strings.HasPrefix(branch, "-") {
+		return "a branch name can't start with -"
+	}
+	if strings.HasPrefix(branch, "refs/") {
+		return "give the branch name without refs/"
+	}
+	if err := exec.Command("git", "check-ref-format", "refs/heads/"+branch).Run(); err != nil {
+		return fmt.Sprintf("%q isn't a valid git branch name", branch)
+	}
+	return ""
+}
+
+// parsePushPorcelain reads the status of a ref from the output of git push --porcelain,
+// lines of the form "<flag>\t<from>:<to>\t<summary>". For a rejected ref, the reason
+// is the summary, such as "[rejected] (non-fast-forward)".
+func parsePushPorcelain(out string) (status, reason string) {
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		flag, rest, ok := strings.Cut(sc.Text(), "\t")
+		if !ok || len(flag) != 1 {
+			continue
+		}
+		_, summary, _ := strings.Cut(rest, "\t")
+		switch flag {
+		case "*":
+			return pushNew, ""
+		case " ":
+			return pushFastForward, ""
+		case "+":
+			return pushForced, ""
+		case "=":
+			return pushUpToDate, ""
+		case "!":
+			reason = summary
+			if open := strings.Index(summary, "("); open >= 0 {
+				reason = strings.TrimSuffix(summary[open+1:], ")")
+			}
+			return pushRejected, reason
+		}
+	}
+	return "", ""
+}
+
+var (
+	// pullRequestPrompt matches the line before the link hosts print for
+	// opening a pull request (GitHub, Bitbucket) or merge request (GitLab).
+	pullRequestPrompt = regexp.MustCompile(`(?i)(pull|merge) request`)
+	remoteURL         = regexp.MustCompile(`https?://\S+`)
+)
+
+// pullRequestURL finds the link for opening a pull request in the remote's
+// messages in the output of git push.
+func pullRequestURL(out string) string {
+	prompted := false
+	for line := range strings.SplitSeq(out, "\n") {
+		msg, ok := strings.CutPrefix(strings.TrimSpace(line), "remote:")
+		if !ok {
+			continue
+		}
+		if url := remoteURL.FindString(msg); url != "" && (prompted || pullRequestPrompt.MatchString(msg)) {
+			return url
+		}
+		prompted = pullRequestPrompt.MatchString(msg)
+	}
+	return ""
+}
+
+// setUpstream makes the branch checked out in repoDir track branch on remote.
+func setUpstream(repoDir, remote, branch string) (string, error) {
+	cmd := exec.Command("git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	cmd.Dir = repoDir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("HEAD isn't a branch, so nothing can track %s/%s", remote, branch)
+	}
+	local := strings.TrimSpace(string(out))
+	for key, value := range map[string]string{"remote": remote, "merge": "refs/heads/" + branch} {
+		cmd := exec.Command("git", "config", "branch."+local+"."+key, value)
+		cmd.Dir = repoDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("git config: %s: %w", strings.TrimSpace(string(out)), err)
+		}
+	}
+	return remote + "/" + branch, nil
+}
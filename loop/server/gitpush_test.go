@@ -0,0 +1,52 @@
+package server
+
+import "testing"
+
+func TestParsePushPorcelain(t *testing.T) {
+	for _, tt := range []struct {
+		out, status, reason string
+	}{
+		{"To /tmp/remote.git\n*\tabc123:refs/heads/feature\t[new branch]\nDone\n", pushNew, ""},
+		{"To /tmp/remote.git\n \tabc123:refs/heads/main\t1234567..abc1234\nDone\n", pushFastForward, ""},
+		{"To /tmp/remote.git\n+\tabc123:refs/heads/main\t1234567...abc1234 (forced update)\nDone\n", pushForced, ""},
+		{"To /tmp/remote.git\n=\tabc123:refs/heads/main\t[up to date]\nDone\n", pushUpToDate, ""},
+		{"To /tmp/remote.git\n!\tabc123:refs/heads/main\t[rejected] (non-fast-forward)\nDone\n", pushRejected, "non-fast-forward"},
+		{"To /tmp/remote.git\n!\tabc123:refs/heads/main\t[remote rejected] (pre-receive hook declined)\nDone\n", pushRejected, "pre-receive hook declined"},
+		{"fatal: 'nowhere' does not appear to be a git repository\n", "", ""},
+	} {
+		status, reason := parsePushPorcelain(tt.out)
+		if status != tt.status || reason != tt.reason {
+			t.Errorf("parsePushPorcelain(%q) = %q, %q; want %q, %q", tt.out, status, reason, tt.status, tt.reason)
+		}
+	}
+}
+
+func TestPullRequestURL(t *testing.T) {
+	for out, want := range map[string]string{
+		"remote: \nremote: Create a pull request for 'feature' on GitHub by visiting:\nremote:      https://github.com/o/r/pull/new/feature\nremote: \nTo github.com:o/r.git\n":     "https://github.com/o/r/pull/new/feature",
+		"remote:\nremote: To create a merge request for feature, visit:\nremote:   https://gitlab.com/o/r/-/merge_requests/new?merge_request%5Bsource_branch%5D=feature\nremote:\n": "https://gitlab.com/o/r/-/merge_requests/new?merge_request%5Bsource_branch%5D=feature",
+		"remote: Resolving deltas: 100% (1/1)\nremote: See https://example.com/docs for the hook\n":                                                                                 "",
+		"To /tmp/remote.git\n": "",
+	} {
+		if got := pullRequestURL(out); got != want {
+			t.Errorf("pullRequestURL(%q) = %q, want %q", out, got, want)
+		}
+	}
+}
+
+func TestValidBranchName(t *testing.T) {
+	for branch, valid := range map[string]bool{
+		"feature/login": true,
+		"sketch/fix-1":  true,
+		"-rf":           false,
+		"a..b":          false,
+		"with space":    false,
+		"refs/heads/x":  false,
+		"ends.lock":     false,
+		"trailing/":     false,
+	} {
+		if got := validBranchName(branch) == ""; got != valid {
+			t.Errorf("validBranchName(%q) valid = %v, want %v", branch, got, valid)
+		}
+	}
+}
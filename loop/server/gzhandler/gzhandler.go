@@ -1,21 +1,44 @@
 // Package gzhandler provides an HTTP file server implementation that serves pre-compressed files
-// when available to clients that support gzip encoding.
+// when available to clients that support gzip or brotli encoding.
 package gzhandler
 
 import (
+	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
 	"io/fs"
 	"mime"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 // Handler is an http.Handler that checks for pre-compressed files
 // and serves them with appropriate headers when available.
 type Handler struct {
-	root http.FileSystem
+	root  http.FileSystem
+	etags sync.Map // .gz path to the ETag of its content; the files don't change
+}
+
+// Accepts reports whether r's Accept-Encoding lists coding, without a q=0 refusing it.
+func Accepts(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		q := "1"
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q = v
+		}
+		f, err := strconv.ParseFloat(q, 64)
+		return err == nil && f > 0
+	}
+	return false
 }
 
 // New creates a handler that serves HTTP requests
@@ -33,8 +56,8 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	urlPath = path.Clean(urlPath)
 
-	// Check if the file itself is not a gzip file (we don't want to double-compress)
-	isCompressibleFile := !strings.HasSuffix(urlPath, ".gz")
+	// Check if the file itself is not a compressed file (we don't want to double-compress)
+	isCompressibleFile := !strings.HasSuffix(urlPath, ".gz") && !strings.HasSuffix(urlPath, ".br")
 	if !isCompressibleFile {
 		// Fall back to regular serving.
 		http.FileServer(h.root).ServeHTTP(w, r)
@@ -65,18 +88,41 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		contentType = "application/octet-stream"
 	}
 
-	acceptsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
-	if acceptsGzip {
-		w.Header().Set("Content-Type", contentType)
-		w.Header().Set("Content-Encoding", "gzip")
-		w.Header().Set("Vary", "Accept-Encoding")
+	// Read the gzipped file into memory to avoid 'seeker can't seek' error
+	gzippedData, err := io.ReadAll(gzFile)
+	if err != nil {
+		http.Error(w, "Error reading gzipped content", http.StatusInternalServerError)
+		return
+	}
+
+	// Every encoding of the file shares a weak ETag, so browsers can revalidate
+	// what they have cached instead of downloading it again.
+	etag, ok := h.etags.Load(gzPath)
+	if !ok {
+		sum := sha256.Sum256(gzippedData)
+		etag, _ = h.etags.LoadOrStore(gzPath, `W/"`+hex.EncodeToString(sum[:12])+`"`)
+	}
+	w.Header().Set("ETag", etag.(string))
+	w.Header().Set("Vary", "Accept-Encoding")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && strings.Contains(inm, strings.TrimPrefix(etag.(string), "W/")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-		// Read the gzipped file into memory to avoid 'seeker can't seek' error
-		gzippedData, err := io.ReadAll(gzFile)
-		if err != nil {
-			http.Error(w, "Error reading gzipped content", http.StatusInternalServerError)
+	// Brotli is smaller still, for the clients that take it.
+	if Accepts(r, "br") {
+		if brData, err := readFile(h.root, urlPath+".br"); err == nil {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Encoding", "br")
+			w.WriteHeader(http.StatusOK)
+			w.Write(brData)
 			return
 		}
+	}
+
+	if Accepts(r, "gzip") {
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", "gzip")
 
 		// Write the headers and gzipped content
 		w.WriteHeader(http.StatusOK)
@@ -87,7 +133,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// No gzip support; decompress for them.
 
 	// Decompress the .gz file and serve it uncompressed
-	gzReader, err := gzip.NewReader(gzFile)
+	gzReader, err := gzip.NewReader(bytes.NewReader(gzippedData))
 	if err != nil {
 		http.FileServer(h.root).ServeHTTP(w, r)
 		return
@@ -97,3 +143,12 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	io.Copy(w, gzReader)
 }
+
+func readFile(root http.FileSystem, name string) ([]byte, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
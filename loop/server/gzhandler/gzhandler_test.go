@@ -238,3 +238,36 @@ func compressString(t *testing.T, s string) []byte {
 
 	return []byte(buf.String())
 }
+
+func TestBrotliAndETag(t *testing.T) {
+	testFS := fstest.MapFS{
+		"app.js.gz": &fstest.MapFile{Data: compressString(t, "app()")},
+		"app.js.br": &fstest.MapFile{Data: []byte("brotli bytes")},
+	}
+	handler := New(testFS)
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/app.js", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("gzip, deflate, br", "")
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "brotli bytes" {
+		t.Errorf("br: encoding %q, body %q", rec.Header().Get("Content-Encoding"), rec.Body)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec := get("gzip, br;q=0", ""); rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("ETag") != etag {
+		t.Errorf("refusing br: encoding %q, ETag %q, want %q", rec.Header().Get("Content-Encoding"), rec.Header().Get("ETag"), etag)
+	}
+	if rec := get("gzip", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("revalidating: %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("", ""); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "app()" {
+		t.Errorf("identity: encoding %q, body %q", rec.Header().Get("Content-Encoding"), rec.Body)
+	}
+}
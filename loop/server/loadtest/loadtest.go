@@ -0,0 +1,307 @@
+// Package loadtest drives loop/server with many concurrent SSE clients and a
+// high message rate, to catch regressions in lock contention, delivery
+// latency, and goroutine counts. It backs both the package's benchmarks and
+// cmd/loadtest.
+package loadtest
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+// Config describes a load test run.
+type Config struct {
+	Clients  int           // concurrent SSE clients
+	Messages int           // messages published during the run
+	Rate     float64       // messages per second; zero publishes as fast as possible
+	Size     int           // bytes of content per message
+	Timeout  time.Duration // for the whole run; zero means a minute
+
+	// ProfileDir, if set, receives CPU, mutex, block, and goroutine profiles of the run.
+	// The goroutine profile is taken while every client is connected.
+	ProfileDir string
+}
+
+// Result summarizes a load test run.
+type Result struct {
+	Clients   int
+	Messages  int
+	Delivered int64 // message events received, summed over clients
+	Elapsed   time.Duration
+
+	// Delivery latency, from publishing a message to a client decoding it.
+	P50, P99, Max time.Duration
+
+	GoroutinesBefore int // before the server starts
+	GoroutinesPeak   int // sampled while clients are connected
+	GoroutinesAfter  int // once every client has disconnected and the server closed
+}
+
+// PerClient returns the goroutines each connected client cost the server (and itself).
+func (r *Result) PerClient() float64 {
+	if r.Clients == 0 {
+		return 0
+	}
+	return float64(r.GoroutinesPeak-r.GoroutinesBefore) / float64(r.Clients)
+}
+
+func (r *Result) String() string {
+	rate := float64(r.Delivered) / r.Elapsed.Seconds()
+	return fmt.Sprintf("%d clients × %d messages: %d delivered in %v (%.0f/s); latency p50 %v p99 %v max %v; goroutines %d → %d peak (%.1f per client) → %d after",
+		r.Clients, r.Messages, r.Delivered, r.Elapsed.Round(time.Millisecond), rate,
+		r.P50, r.P99, r.Max, r.GoroutinesBefore, r.GoroutinesPeak, r.PerClient(), r.GoroutinesAfter)
+}
+
+// Run serves a fake agent with loop/server, connects cfg.Clients SSE clients to
+// it, publishes cfg.Messages messages, and waits for every client to receive all of them.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Clients <= 0 || cfg.Messages <= 0 {
+		return nil, fmt.Errorf("loadtest: need at least one client and one message")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cmp.Or(cfg.Timeout, time.Minute))
+	defer cancel()
+
+	res := &Result{Clients: cfg.Clients, Messages: cfg.Messages, GoroutinesBefore: runtime.NumGoroutine()}
+
+	agent := looptest.NewFakeAgent(looptest.Config{State: "Ready", SessionID: "loadtest"})
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		return nil, err
+	}
+	ts := httptest.NewServer(srv)
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: cfg.Clients}}
+
+	stopProfiles, err := startProfiles(cfg.ProfileDir)
+	if err != nil {
+		ts.Close()
+		return nil, err
+	}
+
+	// Connect every client before publishing, so each one should see every message.
+	var (
+		wg        sync.WaitGroup
+		connected sync.WaitGroup
+		delivered atomic.Int64
+		mu        sync.Mutex
+		latencies []time.Duration
+		clientErr error
+	)
+	clientCtx, disconnect := context.WithCancel(ctx)
+	for range cfg.Clients {
+		wg.Add(1)
+		connected.Add(1)
+		go func() {
+			defer wg.Done()
+			lat, err := runClient(clientCtx, client, ts.URL, cfg.Messages, &connected, &delivered)
+			mu.Lock()
+			defer mu.Unlock()
+			latencies = append(latencies, lat...)
+			if err != nil && clientErr == nil {
+				clientErr = err
+			}
+		}()
+	}
+	connected.Wait()
+
+	var peak atomic.Int64
+	sampleDone := make(chan struct{})
+	go func() {
+		defer close(sampleDone)
+		t := time.NewTicker(10 * time.Millisecond)
+		defer t.Stop()
+		for {
+			if n := int64(runtime.NumGoroutine()); n > peak.Load() {
+				peak.Store(n)
+			}
+			select {
+			case <-t.C:
+			case <-clientCtx.Done():
+				return
+			}
+		}
+	}()
+	if cfg.ProfileDir != "" {
+		if err := writeProfile(cfg.ProfileDir, "goroutine"); err != nil {
+			disconnect()
+			ts.Close()
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	content := strings.Repeat("x", cfg.Size)
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer t.Stop()
+		tick = t.C
+	}
+	for range cfg.Messages {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		agent.AddMessage(loop.AgentMessage{Type: loop.AgentMessageType, Content: content, Timestamp: time.Now()})
+	}
+
+	// Clients return once they've seen every message, or on timeout.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		disconnect()
+		<-done
+	}
+	res.Elapsed = time.Since(start)
+	disconnect()
+	<-sampleDone
+	stopProfiles()
+	ts.Close()
+	client.CloseIdleConnections()
+
+	res.Delivered = delivered.Load()
+	res.GoroutinesPeak = int(peak.Load())
+	res.GoroutinesAfter = settledGoroutines(res.GoroutinesBefore)
+	slices.Sort(latencies)
+	if n := len(latencies); n > 0 {
+		res.P50 = latencies[n/2]
+		res.P99 = latencies[n*99/100]
+		res.Max = latencies[n-1]
+	}
+	if clientErr != nil {
+		return res, clientErr
+	}
+	if want := int64(cfg.Clients) * int64(cfg.Messages); res.Delivered < want {
+		return res, fmt.Errorf("loadtest: delivered %d of %d messages: %w", res.Delivered, want, ctx.Err())
+	}
+	return res, nil
+}
+
+// runClient reads the SSE stream until it has seen n messages,
+// returning how long each one took to arrive.
+func runClient(ctx context.Context, client *http.Client, url string, n int, connected *sync.WaitGroup, delivered *atomic.Int64) ([]time.Duration, error) {
+	once := sync.OnceFunc(connected.Done)
+	defer once()
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"/stream?from=0", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /stream: %s", resp.Status)
+	}
+
+	latencies := make([]time.Duration, 0, n)
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(nil, 16<<20)
+	event := ""
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := strings.TrimPrefix(line, "data: ")
+			if event == "state" {
+				once() // the stream's first event
+				continue
+			}
+			if event != "message" {
+				continue
+			}
+			var m loop.AgentMessage
+			if err := json.Unmarshal([]byte(data), &m); err != nil {
+				return latencies, err
+			}
+			latencies = append(latencies, time.Since(m.Timestamp))
+			delivered.Add(1)
+			if len(latencies) == n {
+				return latencies, nil
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return latencies, nil // timed out; Run reports the shortfall
+	}
+	return latencies, sc.Err()
+}
+
+// settledGoroutines waits briefly for goroutines to wind down toward baseline,
+// and returns how many remain.
+func settledGoroutines(baseline int) int {
+	n := runtime.NumGoroutine()
+	for i := 0; i < 50 && n > baseline; i++ {
+		time.Sleep(20 * time.Millisecond)
+		n = runtime.NumGoroutine()
+	}
+	return n
+}
+
+// startProfiles starts CPU profiling and mutex and block sampling into dir,
+// returning a function that stops them and writes the profiles out.
+func startProfiles(dir string) (stop func(), err error) {
+	if dir == "" {
+		return func() {}, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	prevMutex := runtime.SetMutexProfileFraction(5)
+	runtime.SetBlockProfileRate(int(time.Millisecond))
+	return func() {
+		pprof.StopCPUProfile()
+		f.Close()
+		writeProfile(dir, "mutex")
+		writeProfile(dir, "block")
+		runtime.SetMutexProfileFraction(prevMutex)
+		runtime.SetBlockProfileRate(0)
+	}, nil
+}
+
+func writeProfile(dir, name string) error {
+	f, err := os.Create(filepath.Join(dir, name+".pprof"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return pprof.Lookup(name).WriteTo(f, 0)
+}
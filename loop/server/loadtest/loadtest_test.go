@@ -0,0 +1,51 @@
+package loadtest
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var profiles = flag.String("loadtest.profiles", "", "write profiles of each benchmark run under this directory")
+
+func TestRun(t *testing.T) {
+	res, err := Run(t.Context(), Config{Clients: 20, Messages: 50, Timeout: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(res)
+	if res.Delivered != 20*50 {
+		t.Errorf("delivered %d messages, want %d", res.Delivered, 20*50)
+	}
+	// Each client costs a handful of goroutines on either end of the
+	// connection; an SSE handler that leaks or spawns per message shows up here.
+	if pc := res.PerClient(); pc > 10 {
+		t.Errorf("%.1f goroutines per client", pc)
+	}
+	if leaked := res.GoroutinesAfter - res.GoroutinesBefore; leaked > 5 {
+		t.Errorf("%d goroutines outlived the run", leaked)
+	}
+}
+
+func BenchmarkSSE(b *testing.B) {
+	for _, clients := range []int{1, 50, 300} {
+		b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+			cfg := Config{Clients: clients, Messages: 100, Size: 1 << 10}
+			if *profiles != "" {
+				cfg.ProfileDir = filepath.Join(*profiles, b.Name())
+			}
+			var res *Result
+			for b.Loop() {
+				var err error
+				if res, err = Run(b.Context(), cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(res.P99.Microseconds()), "p99-µs")
+			b.ReportMetric(res.PerClient(), "goroutines/client")
+			b.ReportMetric(float64(res.Delivered)/res.Elapsed.Seconds(), "msgs/s")
+		})
+	}
+}
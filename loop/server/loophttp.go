@@ -2,11 +2,11 @@
 package server
 
 import (
+	"bytes"
+	"cmp"
 	"context"
-	"crypto/rand"
 	"embed"
 	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,28 +14,40 @@ import (
 	"html/template"
 	"io"
 	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httputil"
 	"net/http/pprof"
 	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata" // for /download?tz=, as containers may lack zoneinfo
 
 	"github.com/creack/pty"
+	"github.com/gliderlabs/ssh"
+	"sketch.dev/claudetool"
 	"sketch.dev/claudetool/browse"
+	"sketch.dev/claudetool/depaudit"
+	"sketch.dev/claudetool/mergequeue"
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/claudetool/testreport"
+	"sketch.dev/devcontainer"
 	"sketch.dev/embedded"
 	"sketch.dev/git_tools"
 	"sketch.dev/llm"
 	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
 	"sketch.dev/loop/server/gzhandler"
+	"sketch.dev/netpolicy"
+	"sketch.dev/onboarding"
+	"sketch.dev/skabandclient"
 )
 
 //go:embed templates/*
@@ -54,6 +66,7 @@ type GitPushInfoResponse struct {
 	Hash    string   `json:"hash"`
 	Subject string   `json:"subject"`
 	Remotes []Remote `json:"remotes"`
+	Branch  string   `json:"branch,omitempty"` // the branch checked out, if HEAD is one
 }
 
 // GitPushRequest represents the request body for /git/push
@@ -63,6 +76,10 @@ type GitPushRequest struct {
 	Commit string `json:"commit"`
 	DryRun bool   `json:"dry_run"`
 	Force  bool   `json:"force"`
+	// CreateBranch pushes to a new branch, failing if the remote has it at another commit
+	CreateBranch bool `json:"create_branch"`
+	// SetUpstream makes the branch checked out track the pushed one
+	SetUpstream bool `json:"set_upstream"`
 }
 
 // GitPushResponse represents the response from /git/push
@@ -71,6 +88,13 @@ type GitPushResponse struct {
 	Output  string `json:"output"`
 	DryRun  bool   `json:"dry_run"`
 	Error   string `json:"error,omitempty"`
+	// Status is how the remote branch changed: "new", "fast-forward",
+	// "forced", "up-to-date" or "rejected"
+	Status string `json:"status,omitempty"`
+	// Reason is why the remote rejected the push, such as "non-fast-forward"
+	Reason         string `json:"reason,omitempty"`
+	Upstream       string `json:"upstream,omitempty"`         // remote/branch, once the branch checked out tracks it
+	PullRequestURL string `json:"pull_request_url,omitempty"` // where the remote offers to open a pull request
 }
 
 // httpError logs the error and sends an HTTP error response
@@ -104,10 +128,21 @@ func simplifyGitHubURL(url string) (string, bool) {
 // terminalSession represents a terminal session with its PTY and the event channel
 type terminalSession struct {
 	pty                *os.File
-	eventsClients      map[chan []byte]bool
+	eventsClients      map[chan []byte]*terminalClient
 	lastEventClientID  int
 	eventsClientsMutex sync.Mutex
 	cmd                *exec.Cmd
+	name               string
+	createdAt          time.Time
+	// scrollback holds the most recent output (up to maxTerminalScrollback bytes),
+	// replayed to clients when they (re)connect. Protected by eventsClientsMutex.
+	scrollback []byte
+	// writer is the ID of the client that has control of the keyboard, or 0 if
+	// nobody has typed since the last one left. Protected by eventsClientsMutex.
+	writer int
+	// inputMutex serializes writes to the pty, so that one request's input
+	// reaches the terminal in one piece.
+	inputMutex sync.Mutex
 }
 
 // TerminalMessage represents a message sent from the client for terminal resize events
@@ -122,6 +157,30 @@ type TerminalResponse struct {
 	SessionID string `json:"sessionId"`
 }
 
+// TerminalCreateRequest is the body of a POST /terminal request.
+// Both fields are optional; an ID is picked if none is given.
+type TerminalCreateRequest struct {
+	SessionID string `json:"sessionId,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// TerminalInfo describes a live terminal session, as returned by GET /terminal.
+type TerminalInfo struct {
+	SessionID string    `json:"sessionId"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Clients   int       `json:"clients"`
+	PID       int       `json:"pid"`
+	Writer    int       `json:"writer,omitempty"` // the client in control of the keyboard, if any
+}
+
+// TerminalControl is the data of the "control" events on a terminal's event stream,
+// sent on connecting and whenever another client takes control of the keyboard.
+type TerminalControl struct {
+	Client int `json:"client"` // the ID of the client receiving the event, for ?client= on input
+	Writer int `json:"writer"` // the client in control, or 0 if nobody is
+}
+
 // TodoItem represents a single todo item for task management
 type TodoItem struct {
 	ID     string `json:"id"`
@@ -151,6 +210,7 @@ type State struct {
 	GitUsername          string                        `json:"git_username,omitempty"`
 	OutstandingLLMCalls  int                           `json:"outstanding_llm_calls"`
 	OutstandingToolCalls []string                      `json:"outstanding_tool_calls"`
+	ToolProgress         []loop.ToolCallProgress       `json:"tool_progress,omitempty"` // what long-running tool calls report they are doing
 	SessionID            string                        `json:"session_id"`
 	SSHAvailable         bool                          `json:"ssh_available"`
 	SSHError             string                        `json:"ssh_error,omitempty"`
@@ -165,16 +225,69 @@ type State struct {
 	InsideWorkingDir     string                        `json:"inside_working_dir,omitempty"`
 	TodoContent          string                        `json:"todo_content,omitempty"`          // Contains todo list JSON data
 	SkabandAddr          string                        `json:"skaband_addr,omitempty"`          // URL of the skaband server
+	Skaband              *skabandclient.Status         `json:"skaband,omitempty"`               // connection to the skaband server, if used
 	LinkToGitHub         bool                          `json:"link_to_github,omitempty"`        // Enable GitHub branch linking in UI
 	SSHConnectionString  string                        `json:"ssh_connection_string,omitempty"` // SSH connection string for container
 	DiffLinesAdded       int                           `json:"diff_lines_added"`                // Lines added from sketch-base to HEAD
 	DiffLinesRemoved     int                           `json:"diff_lines_removed"`              // Lines removed from sketch-base to HEAD
+	DiffFilesChanged     int                           `json:"diff_files_changed"`              // Files changed from sketch-base to HEAD
+	DiffCommits          int                           `json:"diff_commits"`                    // Commits from sketch-base to HEAD, at most 100
 	OpenPorts            []Port                        `json:"open_ports,omitempty"`            // Currently open TCP ports
 	TokenContextWindow   int                           `json:"token_context_window,omitempty"`
 	Model                string                        `json:"model,omitempty"` // Name of the model being used
 	SessionEnded         bool                          `json:"session_ended,omitempty"`
 	CanSendMessages      bool                          `json:"can_send_messages,omitempty"`
 	EndedAt              time.Time                     `json:"ended_at,omitempty"`
+	TurnTimeout          string                        `json:"turn_timeout,omitempty"`  // Per-turn wall-clock limit, as a Go duration
+	Sampling             string                        `json:"sampling,omitempty"`      // Sampling parameters, e.g. "temperature=0,seed=42"
+	LastActivity         *time.Time                    `json:"last_activity,omitempty"` // When the agent last recorded a message
+	IdleSuspend          string                        `json:"idle_suspend,omitempty"`  // Idle time after which the host suspends the container
+	Suspensions          int                           `json:"suspensions,omitempty"`   // Times the container was suspended for being idle
+	ResumedAt            *time.Time                    `json:"resumed_at,omitempty"`    // When the container was last resumed
+	Image                *loop.ImageProvenance         `json:"image,omitempty"`         // The image the container was started from
+	PlanMode             bool                          `json:"plan_mode,omitempty"`     // Whether changes wait for the user to approve a plan
+	PendingApprovals     []permission.ApprovalRequest  `json:"approvals,omitempty"`     // Tool calls waiting for the user's approval
+}
+
+// TurnTimeoutRequest is the body of a POST /turn-timeout request, and also the
+// response to GET. Timeout is a Go duration string such as "30m"; "0s" disables the limit.
+type TurnTimeoutRequest struct {
+	Timeout string `json:"timeout"`
+}
+
+// CostEstimateRequest is the body of a POST /estimate request.
+type CostEstimateRequest struct {
+	Message string `json:"message"`
+}
+
+// MergeQueueEnqueueRequest is the body of a POST /merge-queue request.
+// An empty Branch enqueues the agent's branch.
+type MergeQueueEnqueueRequest struct {
+	Branch string `json:"branch"`
+}
+
+// ApplyPatchRequest is the body of a POST /patch request.
+type ApplyPatchRequest struct {
+	Patch string `json:"patch"`          // a unified diff, as from git diff or git format-patch
+	Note  string `json:"note,omitempty"` // what the patch is, for the commit message and the agent
+}
+
+// FeedbackRequest is the body of a POST /feedback request.
+type FeedbackRequest struct {
+	MessageIdx int    `json:"message_idx"`
+	Rating     string `json:"rating"`            // "up" or "down"
+	Comment    string `json:"comment,omitempty"` // what was good or bad about the message
+}
+
+// CompactRequest is the body of a POST /compact request.
+type CompactRequest struct {
+	Strategy string `json:"strategy,omitempty"` // see loop.ParseCompactionStrategy; empty uses the session's
+}
+
+// PinRequest is the body of a POST /pin request.
+type PinRequest struct {
+	MessageIdx int  `json:"message_idx"`
+	Pinned     bool `json:"pinned"`
 }
 
 // Port represents an open TCP port
@@ -196,6 +309,12 @@ type InitRequest struct {
 	SSHHostCertificate []byte `json:"ssh_host_certificate"`
 	SSHAvailable       bool   `json:"ssh_available"`
 	SSHError           string `json:"ssh_error,omitempty"`
+
+	// IdleSuspend, as a Go duration, is how long the agent may be idle before the host suspends the container
+	IdleSuspend string `json:"idle_suspend,omitempty"`
+
+	// Onboarding is what the host found for guiding the user through setting sketch up
+	Onboarding *onboarding.Discovery `json:"onboarding,omitempty"`
 }
 
 // Server serves sketch HTTP. Server implements http.Handler.
@@ -207,18 +326,42 @@ type Server struct {
 	// Mutex to protect terminalSessions
 	ptyMutex         sync.Mutex
 	terminalSessions map[string]*terminalSession
-	sshAvailable     bool
-	sshError         string
+	commitFiles      commitFilesCache
+	uploads          *uploadStore
+	fileRoots        []string // for /container/files; see SetContainerFileRoots
+	attachToken      string   // enables /attach; see SetAttachToken
+	auth             *webAuth // requires signing in; see SetOIDC
+
+	// Mutex to protect the SSH state below
+	sshMu        sync.Mutex
+	sshServer    *ssh.Server // nil until /init delivers SSH keys
+	sshMaterial  sshMaterial
+	sshAvailable bool
+	sshError     string
+
+	suspendMu  sync.Mutex
+	suspension suspension
+
+	onboardMu sync.Mutex
+	onboard   *onboarding.Guide // see SetOnboarding
+
+	ended chan string // receives the reason of a POST /end
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.auth != nil {
+		var ok bool
+		if r, ok = s.auth.authorize(w, r); !ok {
+			return
+		}
+	}
 	// Check if Host header matches "p<port>.localhost" pattern and proxy to that port
 	if port := s.ParsePortProxyHost(r.Host); port != "" {
 		s.proxyToPort(w, r, port)
 		return
 	}
 
-	s.mux.ServeHTTP(w, r)
+	serveCompressed(s.mux, w, r)
 }
 
 // ParsePortProxyHost checks if host matches "p<port>.localhost" pattern and returns the port
@@ -284,17 +427,75 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		terminalSessions: make(map[string]*terminalSession),
 		sshAvailable:     false,
 		sshError:         "",
+		ended:            make(chan string, 1),
+	}
+	if agent != nil { // nil in tests of the terminal endpoints
+		s.uploads = newUploadStore(uploadDir(agent.SessionID()))
+		if agent.IsInContainer() {
+			s.fileRoots = []string{"/app", "/tmp"}
+		}
 	}
 
 	s.mux.HandleFunc("/stream", s.handleSSEStream)
+	s.mux.HandleFunc("GET /attach", s.handleAttach)
+
+	// Sign-in, when enabled; see auth.go
+	s.mux.HandleFunc("GET /auth/login", s.handleAuthLogin)
+	s.mux.HandleFunc("GET /auth/callback", s.handleAuthCallback)
+	s.mux.HandleFunc("GET /auth/me", s.handleAuthMe)
+	s.mux.HandleFunc("POST /auth/logout", s.handleAuthLogout)
+	s.mux.HandleFunc("GET /auth/audit", s.handleAuthAudit)
 
 	// Git tool endpoints
-	s.mux.HandleFunc("/git/rawdiff", s.handleGitRawDiff)
-	s.mux.HandleFunc("/git/show", s.handleGitShow)
-	s.mux.HandleFunc("/git/cat", s.handleGitCat)
+	s.mux.HandleFunc("/git/rawdiff", validated(s.handleGitRawDiff))
+	s.mux.HandleFunc("GET /git/diff", validated(s.handleGitDiff))
+	s.mux.HandleFunc("/git/show", validated(s.handleGitShow))
+	s.mux.HandleFunc("/git/cat", validated(s.handleGitCat))
 	s.mux.HandleFunc("/git/save", s.handleGitSave)
-	s.mux.HandleFunc("/git/recentlog", s.handleGitRecentLog)
-	s.mux.HandleFunc("/git/untracked", s.handleGitUntracked)
+	s.mux.HandleFunc("/git/recentlog", validated(s.handleGitRecentLog))
+	s.mux.HandleFunc("/git/untracked", validated(s.handleGitUntracked))
+	s.mux.HandleFunc("GET /git/stats", validated(s.handleGitStats))
+	s.mux.HandleFunc("GET /git/identity", s.handleGitIdentity)
+	s.mux.HandleFunc("POST /git/identity", s.handleSetGitIdentity)
+
+	// Plan mode: the agent proposes a plan before it may change anything
+	s.mux.HandleFunc("GET /plan", s.handlePlanMode)
+	s.mux.HandleFunc("POST /plan", s.handleSetPlanMode)
+	s.mux.HandleFunc("POST /plan/review", s.handlePlanReview)
+
+	// Tool calls that the session's tool permissions hold for the user's approval
+	s.mux.HandleFunc("GET /approve", s.handlePendingApprovals)
+	s.mux.HandleFunc("POST /approve", s.handleApprove)
+
+	// The session's conversations as a parent/child graph, so the UI can show
+	// what the hidden subconversations did and what they cost
+	s.mux.HandleFunc("GET /conversations", validated(s.handleConversations))
+
+	// Files attached to the next message, and the files there are to pick from
+	s.mux.HandleFunc("GET /context", s.handleContext)
+	s.mux.HandleFunc("POST /context", s.handleContextAttach)
+	s.mux.HandleFunc("DELETE /context", s.handleContextClear)
+	s.mux.HandleFunc("GET /context/files", validated(s.handleContextFiles))
+
+	// Per-file history: /files/{path}/activity
+	s.mux.HandleFunc("/files/", s.handleFileActivity)
+
+	// Todo list endpoints, for integrations that seed or follow the agent's plan
+	s.mux.HandleFunc("GET /todos", s.handleTodos)
+	s.mux.HandleFunc("PUT /todos", s.handleTodosReplace)
+	s.mux.HandleFunc("POST /todos", s.handleTodoAdd)
+	s.mux.HandleFunc("POST /todos/reorder", s.handleTodoReorder)
+	s.mux.HandleFunc("PATCH /todos/{id}", s.handleTodoUpdate)
+	s.mux.HandleFunc("DELETE /todos/{id}", s.handleTodoDelete)
+
+	// Browser profile endpoints, to carry a logged-in browser over to later sessions
+	s.mux.HandleFunc("GET /browser/profiles", s.handleBrowserProfiles)
+	s.mux.HandleFunc("GET /onboarding", s.handleOnboarding)
+	s.mux.HandleFunc("GET /onboarding/progress", s.handleOnboardingProgress)
+	s.mux.HandleFunc("POST /onboarding/steps/{id}", s.handleOnboardingStep)
+	s.mux.HandleFunc("POST /onboarding/dismiss", s.handleOnboardingDismiss)
+	s.mux.HandleFunc("POST /browser/profiles/{name}/apply", s.handleBrowserProfileApply)
+	s.mux.HandleFunc("POST /browser/profiles/{name}/save", s.handleBrowserProfileSave)
 
 	s.mux.HandleFunc("/diff", func(w http.ResponseWriter, r *http.Request) {
 		// Check if a specific commit hash was requested
@@ -324,113 +525,23 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		w.Write([]byte(diff))
 	})
 
-	// Handler for initialization called by host sketch binary when inside docker.
+	// Handler for initialization when inside docker; the host sketch binary sends it over the control plane instead.
 	s.mux.HandleFunc("/init", func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.ErrorContext(r.Context(), "/init panic", slog.Any("recovered_err", err))
-
-				// Return an error response to the client
-				httpError(w, r, fmt.Sprintf("panic: %v\n", err), http.StatusInternalServerError)
-			}
-		}()
-
-		if r.Method != "POST" {
-			httpError(w, r, "POST required", http.StatusBadRequest)
-			return
-		}
-
-		body, err := io.ReadAll(r.Body)
-		r.Body.Close()
-		if err != nil {
-			httpError(w, r, "failed to read request body: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		m := &InitRequest{}
-		if err := json.Unmarshal(body, m); err != nil {
-			httpError(w, r, "bad request body: "+err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		// Store SSH availability info
-		s.sshAvailable = m.SSHAvailable
-		s.sshError = m.SSHError
-
-		// Start the SSH server if the init request included ssh keys.
-		if len(m.SSHAuthorizedKeys) > 0 && len(m.SSHServerIdentity) > 0 {
-			go func() {
-				ctx := context.Background()
-				if err := s.ServeSSH(ctx, m.SSHServerIdentity, m.SSHAuthorizedKeys, m.SSHContainerCAKey, m.SSHHostCertificate); err != nil {
-					slog.ErrorContext(r.Context(), "/init ServeSSH", slog.String("err", err.Error()))
-					// Update SSH error if server fails to start
-					s.sshAvailable = false
-					s.sshError = err.Error()
-				}
-			}()
-		}
-
-		ini := loop.AgentInit{
-			InDocker: true,
-			HostAddr: m.HostAddr,
-		}
-		if err := agent.Init(ini); err != nil {
-			httpError(w, r, "init failed: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		io.WriteString(w, "{}\n")
+		s.handleInit(w, r, agent.Init)
 	})
 
-	// Handler for /messages?start=N&end=M (start/end are optional)
-	s.mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-
-		// Extract query parameters for range
-		var start, end int
-		var err error
-
-		currentCount := agent.MessageCount()
-
-		startParam := r.URL.Query().Get("start")
-		if startParam != "" {
-			start, err = strconv.Atoi(startParam)
-			if err != nil {
-				httpError(w, r, "Invalid 'start' parameter", http.StatusBadRequest)
-				return
-			}
-		}
-
-		endParam := r.URL.Query().Get("end")
-		if endParam != "" {
-			end, err = strconv.Atoi(endParam)
-			if err != nil {
-				httpError(w, r, "Invalid 'end' parameter", http.StatusBadRequest)
-				return
-			}
-		} else {
-			end = currentCount
-		}
+	// Handler for POST /resumed - the host unpaused the container after suspending it for being idle
+	s.mux.HandleFunc("POST /resumed", s.handleResumed)
 
-		if start < 0 || start > end || end > currentCount {
-			httpError(w, r, fmt.Sprintf("Invalid range: start %d end %d currentCount %d", start, end, currentCount), http.StatusBadRequest)
-			return
-		}
-
-		start = max(0, start)
-		end = min(agent.MessageCount(), end)
-		messages := agent.Messages(start, end)
-
-		// Create a JSON encoder with indentation for pretty-printing
-		encoder := json.NewEncoder(w)
-		encoder.SetIndent("", "  ") // Two spaces for each indentation level
-
-		err = encoder.Encode(messages)
-		if err != nil {
-			httpError(w, r, err.Error(), http.StatusInternalServerError)
-		}
+	// Handler for /reinit - like /init, but for an agent that is already running,
+	// when the outside process restarts and reconnects with new settings
+	s.mux.HandleFunc("/reinit", func(w http.ResponseWriter, r *http.Request) {
+		s.handleInit(w, r, agent.Reinit)
 	})
 
+	// Handler for /messages?start=N&end=M; see messagesQuery for the other options
+	s.mux.HandleFunc("/messages", validated(s.handleMessages))
+
 	// Handler for /debug/logs - displays the contents of the log file
 	s.mux.HandleFunc("/debug/logs", func(w http.ResponseWriter, r *http.Request) {
 		if s.logFile == nil {
@@ -448,23 +559,46 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		fmt.Fprintf(w, "</body>\n</html>")
 	})
 
-	// Handler for /download - downloads both messages and status as a JSON file
+	// Handler for /download - downloads both messages and status as a JSON file,
+	// or with ?format=markdown, a readable transcript. ?tz names the IANA time zone
+	// to show times in; message timestamps in the JSON stay in UTC.
 	s.mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		// Set headers for file download
-		w.Header().Set("Content-Type", "application/octet-stream")
-
-		// Generate filename with format: sketch-YYYYMMDD-HHMMSS.json
-		timestamp := time.Now().Format("20060102-150405")
-		filename := fmt.Sprintf("sketch-%s.json", timestamp)
-
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+		loc := time.UTC
+		if tz := r.URL.Query().Get("tz"); tz != "" {
+			var err error
+			if loc, err = time.LoadLocation(tz); err != nil {
+				httpError(w, r, "Invalid time zone: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		now := time.Now().In(loc)
 
 		// Get all messages
 		messageCount := agent.MessageCount()
 		messages := agent.Messages(0, messageCount)
 
+		// Generate filename with format: sketch-YYYYMMDD-HHMMSS.{json,md}
+		timestamp := now.Format("20060102-150405")
+
+		if r.URL.Query().Get("format") == "markdown" {
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sketch-%s.md\"", timestamp))
+			title := "Sketch session " + agent.SessionID()
+			if slug := agent.Slug(); slug != "" {
+				title += ": " + slug
+			}
+			writeMarkdown(w, title, messages, loc)
+			return
+		}
+
+		// Set headers for file download
+		w.Header().Set("Content-Type", "application/octet-stream")
+		filename := fmt.Sprintf("sketch-%s.json", timestamp)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
 		// Get status information (usage and other metadata)
 		totalUsage := agent.TotalUsage()
+		totalUsage.StartTime = totalUsage.StartTime.UTC()
 		hostname := getHostname()
 		workingDir := getWorkingDir()
 
@@ -476,13 +610,19 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 			Hostname     string                       `json:"hostname"`
 			WorkingDir   string                       `json:"working_dir"`
 			DownloadTime string                       `json:"download_time"`
+			TimeZone     string                       `json:"time_zone"`
+			Sampling     *llm.Sampling                `json:"sampling,omitempty"`
 		}{
 			Messages:     messages,
 			MessageCount: messageCount,
 			TotalUsage:   totalUsage,
 			Hostname:     hostname,
 			WorkingDir:   workingDir,
-			DownloadTime: time.Now().Format(time.RFC3339),
+			DownloadTime: now.Format(time.RFC3339),
+			TimeZone:     loc.String(),
+		}
+		if sm := agent.Sampling(); !sm.IsZero() {
+			downloadData.Sampling = &sm
 		}
 
 		// Marshal the JSON with indentation for better readability
@@ -494,6 +634,9 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		w.Write(jsonData)
 	})
 
+	// The states the agent went through, and how long it spent in each
+	s.mux.HandleFunc("GET /state/history", s.handleStateHistory)
+
 	// The latter doesn't return until the number of messages has changed (from seen
 	// or from when this was called.)
 	s.mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
@@ -556,9 +699,11 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 
 	s.mux.Handle("/static/", http.StripPrefix("/static/", gzhandler.New(embedded.WebUIFS())))
 
-	// Terminal WebSocket handler
-	// Terminal endpoints - predefined terminals 1-9
-	// TODO: The UI doesn't actually know how to use terminals 2-9!
+	// Terminal lifecycle: list (GET /terminal), create (POST /terminal), kill (DELETE /terminal/{id})
+	s.mux.HandleFunc("/terminal", s.handleTerminals)
+	s.mux.HandleFunc("/terminal/", s.handleTerminalKill)
+
+	// Terminal output stream. Connecting to a terminal that doesn't exist yet creates it.
 	s.mux.HandleFunc("/terminal/events/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
@@ -571,9 +716,8 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		}
 
 		sessionID := pathParts[3]
-		// Validate that the terminal ID is between 1-9
-		if len(sessionID) != 1 || sessionID[0] < '1' || sessionID[0] > '9' {
-			httpError(w, r, "Terminal ID must be between 1 and 9", http.StatusBadRequest)
+		if !validTerminalID(sessionID) {
+			httpError(w, r, "Invalid terminal ID", http.StatusBadRequest)
 			return
 		}
 
@@ -594,6 +738,20 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		s.handleTerminalInput(w, r, sessionID)
 	})
 
+	// Take control of a terminal's keyboard away from whichever client has it.
+	s.mux.HandleFunc("/terminal/control/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pathParts := strings.Split(r.URL.Path, "/")
+		if len(pathParts) < 4 {
+			httpError(w, r, "Invalid terminal ID", http.StatusBadRequest)
+			return
+		}
+		s.handleTerminalControl(w, r, pathParts[3])
+	})
+
 	// Handler for interface selection via URL parameters (?m for mobile)
 	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		webuiFS := embedded.WebUIFS()
@@ -677,6 +835,25 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		http.ServeFile(w, r, filePath)
 	})
 
+	// Images and diagrams attached to messages
+	s.mux.HandleFunc("GET /artifacts", s.handleArtifacts)
+	s.mux.HandleFunc("GET /artifacts/{id}", s.handleArtifact)
+
+	// Handler for GET /test-output/{id} - serves the raw output of a test run the bash tool summarized
+	s.mux.HandleFunc("GET /test-output/{id}", func(w http.ResponseWriter, r *http.Request) {
+		path := testreport.RawPath(r.PathValue("id"))
+		if path == "" {
+			httpError(w, r, "Invalid test output ID", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			httpError(w, r, "Test output not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, path)
+	})
+
 	// Handler for POST /chat
 	s.mux.HandleFunc("/chat", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -701,11 +878,27 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 			return
 		}
 
+		s.recordAudit(r, "chat", requestBody.Message)
 		agent.UserMessage(r.Context(), requestBody.Message)
 
 		w.WriteHeader(http.StatusOK)
 	})
 
+	// Handler for POST /estimate - estimates the cost of sending a chat message
+	s.mux.HandleFunc("/estimate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req CostEstimateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.EstimateCost(req.Message))
+	})
+
 	// Handler for POST /external - e.g. where you send messages about e.g. github workflow
 	// outcomes and other external events that the agent wouldn't otherwise be aware of.
 	s.mux.HandleFunc("/external", func(w http.ResponseWriter, r *http.Request) {
@@ -735,53 +928,137 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	// Handler for POST /upload - uploads a file to /tmp
-	s.mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+	// Handler for POST /patch - applies a unified diff from the user on the agent's branch
+	s.mux.HandleFunc("/patch", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
-
-		// Limit to 10MB file size
 		r.Body = http.MaxBytesReader(w, r.Body, 10*1024*1024)
+		var req ApplyPatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := agent.ApplyPatch(r.Context(), req.Patch, req.Note)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	})
 
-		// Parse the multipart form
-		if err := r.ParseMultipartForm(10 * 1024 * 1024); err != nil {
-			httpError(w, r, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+	// Handler for /feedback - GET lists the user's ratings of messages, POST rates one
+	s.mux.HandleFunc("/feedback", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			feedback := agent.Feedback()
+			if feedback == nil {
+				feedback = []loop.Feedback{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(feedback)
+		case http.MethodPost:
+			var req FeedbackRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			fb, err := agent.RecordFeedback(r.Context(), req.MessageIdx, req.Rating, req.Comment)
+			if err != nil {
+				httpError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(fb)
+		default:
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Handler for POST /compact - compacts the conversation between turns
+	s.mux.HandleFunc("POST /compact", func(w http.ResponseWriter, r *http.Request) {
+		var req CompactRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := loop.ParseCompactionStrategy(req.Strategy); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := agent.Compact(r.Context(), req.Strategy); errors.Is(err, loop.ErrCompactBusy) {
+			httpError(w, r, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	})
 
-		// Get the file from the multipart form
-		file, handler, err := r.FormFile("file")
+	// Handler for POST /pin - pins a message for compaction to keep, or unpins it
+	s.mux.HandleFunc("POST /pin", func(w http.ResponseWriter, r *http.Request) {
+		var req PinRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := agent.PinMessage(req.MessageIdx, req.Pinned); err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(req)
+	})
+
+	// Handler for GET /history/search?q=... - finds past sessions recorded on the
+	// machine the agent runs on by prompt text, touched files, or branch name
+	s.mux.HandleFunc("GET /history/search", func(w http.ResponseWriter, r *http.Request) {
+		dir, err := loop.SessionRecordDir()
 		if err != nil {
-			httpError(w, r, "Failed to get uploaded file: "+err.Error(), http.StatusBadRequest)
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer file.Close()
+		matches, err := loop.SearchHistory(dir, r.URL.Query().Get("q"))
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if matches == nil {
+			matches = []loop.HistoryMatch{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(matches)
+	})
 
-		// Generate a unique ID (8 random bytes converted to 16 hex chars)
-		randBytes := make([]byte, 8)
-		if _, err := rand.Read(randBytes); err != nil {
-			httpError(w, r, "Failed to generate random filename: "+err.Error(), http.StatusInternalServerError)
+	// Handler for POST /upload - uploads a file in one piece; see upload.go for larger ones
+	s.mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// Get file extension from the original filename
-		ext := filepath.Ext(handler.Filename)
+		r.Body = http.MaxBytesReader(w, r.Body, s.uploads.max)
 
-		// Create a unique filename in the /tmp directory
-		filename := fmt.Sprintf("/tmp/sketch_file_%s%s", hex.EncodeToString(randBytes), ext)
+		// Parse the multipart form, keeping up to 10MB in memory
+		if err := r.ParseMultipartForm(10 * 1024 * 1024); err != nil {
+			httpError(w, r, "Failed to parse form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		// Create the destination file
-		destFile, err := os.Create(filename)
+		// Get the file from the multipart form
+		file, handler, err := r.FormFile("file")
 		if err != nil {
-			httpError(w, r, "Failed to create destination file: "+err.Error(), http.StatusInternalServerError)
+			httpError(w, r, "Failed to get uploaded file: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		defer destFile.Close()
+		defer file.Close()
 
-		// Copy the file contents to the destination file
-		if _, err := io.Copy(destFile, file); err != nil {
+		filename, err := s.uploads.save(handler.Filename, file)
+		if err != nil {
 			httpError(w, r, "Failed to save file: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -791,6 +1068,15 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		json.NewEncoder(w).Encode(map[string]string{"path": filename})
 	})
 
+	// Chunked, resumable uploads
+	s.mux.HandleFunc("/uploads", s.handleUploadCreate)
+	s.mux.HandleFunc("/uploads/", s.handleUpload)
+
+	// Transfers to and from the container; see containerfiles.go
+	s.mux.HandleFunc("GET /container/files", s.handleContainerFiles)
+	s.mux.HandleFunc("GET /container/files/download", s.handleContainerFileDownload)
+	s.mux.HandleFunc("POST /container/files/upload", s.handleContainerFileUpload)
+
 	// Handler for /git/pushinfo - returns HEAD commit and remotes for push dialog
 	s.mux.HandleFunc("/git/pushinfo", s.handleGitPushInfo)
 
@@ -822,6 +1108,7 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 			cancelReason = requestBody.Reason
 		}
 
+		s.recordAudit(r, "cancel", cancelReason)
 		if requestBody.ToolCallID != "" {
 			err := agent.CancelToolUse(requestBody.ToolCallID, fmt.Errorf("%s", cancelReason))
 			if err != nil {
@@ -844,7 +1131,207 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 		json.NewEncoder(w).Encode(map[string]string{"status": "cancelled", "reason": cancelReason})
 	})
 
-	// Handler for /end - shuts down the inner sketch process
+	// Handler for /audit/deps - GET returns the latest dependency audit, POST runs a new one
+	s.mux.HandleFunc("/audit/deps", func(w http.ResponseWriter, r *http.Request) {
+		var report *depaudit.Report
+		switch r.Method {
+		case http.MethodGet:
+			report = s.agent.LastDependencyAudit()
+		case http.MethodPost:
+			var err error
+			report, err = s.agent.RunDependencyAudit(r.Context())
+			if err != nil {
+				httpError(w, r, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+
+	// Handler for /network/violations - lists accesses blocked by the container's network allowlist
+	s.mux.HandleFunc("/network/violations", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		violations := s.agent.NetworkViolations()
+		if violations == nil {
+			violations = []netpolicy.Violation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(violations)
+	})
+
+	// Handler for GET /bash/blocked - lists bash commands blocked by the session's bash policy
+	s.mux.HandleFunc("GET /bash/blocked", func(w http.ResponseWriter, r *http.Request) {
+		blocked := s.agent.BlockedCommands()
+		if blocked == nil {
+			blocked = []loop.BlockedCommand{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(blocked)
+	})
+
+	// Handler for /devcontainer - describes this container for IDEs to attach to;
+	// with ?download=1, just the devcontainer.json
+	s.mux.HandleFunc("/devcontainer", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.agent.IsInContainer() {
+			httpError(w, r, "sketch is not running in a container", http.StatusNotFound)
+			return
+		}
+		container := "sketch-" + s.agent.SessionID()
+		info := devcontainer.New(devcontainer.Target{
+			Container:       container,
+			SSHHost:         cmp.Or(s.agent.SSHConnectionString(), container),
+			WorkspaceFolder: s.agent.WorkingDir(),
+			RepoDir:         s.agent.RepoRoot(),
+		})
+		var v any = info
+		if r.URL.Query().Get("download") != "" {
+			w.Header().Set("Content-Disposition", `attachment; filename="devcontainer.json"`)
+			v = info.Config
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(v)
+	})
+
+	// Handler for /merge-queue - GET lists enqueued branches, POST enqueues one
+	s.mux.HandleFunc("/merge-queue", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			entries := s.agent.MergeQueueEntries()
+			if entries == nil {
+				entries = []mergequeue.Entry{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entries)
+		case http.MethodPost:
+			var req MergeQueueEnqueueRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			entry, err := s.agent.EnqueueMerge(r.Context(), req.Branch)
+			if err != nil {
+				httpError(w, r, err.Error(), http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(entry)
+		default:
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// Handler for /turn-timeout - reports or adjusts the per-turn time limit
+	s.mux.HandleFunc("/turn-timeout", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req TurnTimeoutRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			d, err := time.ParseDuration(req.Timeout)
+			if err != nil || d < 0 {
+				httpError(w, r, "Invalid timeout: must be a non-negative duration such as 30m", http.StatusBadRequest)
+				return
+			}
+			s.agent.SetTurnTimeout(d)
+		default:
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TurnTimeoutRequest{Timeout: s.agent.TurnTimeout().String()})
+	})
+
+	// Handler for /sampling - reports or replaces the sampling parameters;
+	// parameters left out of a POST go back to the model's defaults
+	s.mux.HandleFunc("/sampling", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req llm.Sampling
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.agent.SetSampling(r.Context(), req); err != nil {
+				httpError(w, r, "Invalid sampling parameters: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.agent.Sampling())
+	})
+
+	// Handler for /image - reports whether the base image the container was
+	// built from has a newer digest in its registry
+	s.mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.agent.ImageProvenance() == nil {
+			httpError(w, r, "sketch is not running in a container", http.StatusNotFound)
+			return
+		}
+		st, err := s.agent.ImageStatus(r.Context())
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	})
+
+	// Handler for /image/rebuild - moves the session to a container built from
+	// the latest base image: the working tree comes along through the git
+	// server, and the conversation through the session record
+	s.mux.HandleFunc("/image/rebuild", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.agent.ImageProvenance() == nil {
+			httpError(w, r, "sketch is not running in a container", http.StatusNotFound)
+			return
+		}
+		if s.agent.OutstandingLLMCallCount() > 0 || len(s.agent.OutstandingToolCalls()) > 0 {
+			httpError(w, r, "the agent is working; wait for the turn to end, or cancel it, before rebuilding", http.StatusConflict)
+			return
+		}
+		if err := s.agent.PrepareRebuild(r.Context()); err != nil {
+			httpError(w, r, "Rebuild failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		const reason = "moving to a container rebuilt from the latest base image"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ending", "reason": reason})
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		slog.Info("Ending session", "reason", reason)
+		s.recordAudit(r, "rebuild", reason)
+		s.end(reason)
+	})
+
+	// Handler for /end - ends the session; see Ended
 	s.mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
@@ -877,14 +1364,10 @@ func New(agent loop.CodingAgent, logFile *os.File) (*Server, error) {
 			f.Flush()
 		}
 
-		// Log that we're shutting down
+		// Leave the shutdown, which stops this server, to whoever watches Ended.
 		slog.Info("Ending session", "reason", endReason)
-
-		// Give a brief moment for the response to be sent before exiting
-		go func() {
-			time.Sleep(100 * time.Millisecond)
-			os.Exit(0)
-		}()
+		s.recordAudit(r, "end", endReason)
+		s.end(endReason)
 	})
 
 	debugMux := initDebugMux(agent)
@@ -912,8 +1395,9 @@ func getWorkingDir() string {
 	return wd
 }
 
-// createTerminalSession creates a new terminal session with the given ID
-func (s *Server) createTerminalSession(sessionID string) (*terminalSession, error) {
+// createTerminalSession creates a new terminal session with the given ID.
+// The caller must hold s.ptyMutex and is responsible for storing the session.
+func (s *Server) createTerminalSession(sessionID, name string) (*terminalSession, error) {
 	// Start a new shell process
 	shellPath := getShellPath()
 	cmd := exec.Command(shellPath)
@@ -933,10 +1417,15 @@ func (s *Server) createTerminalSession(sessionID string) (*terminalSession, erro
 	}
 
 	// Create the terminal session
+	if name == "" {
+		name = "Terminal " + sessionID
+	}
 	session := &terminalSession{
 		pty:           ptmx,
-		eventsClients: make(map[chan []byte]bool),
+		eventsClients: make(map[chan []byte]*terminalClient),
 		cmd:           cmd,
+		name:          name,
+		createdAt:     time.Now(),
 	}
 
 	// Start goroutine to read from pty and broadcast to all connected SSE clients
@@ -954,7 +1443,7 @@ func (s *Server) handleTerminalEvents(w http.ResponseWriter, r *http.Request, se
 	if !exists {
 		// Create a new terminal session
 		var err error
-		session, err = s.createTerminalSession(sessionID)
+		session, err = s.createTerminalSession(sessionID, "")
 		if err != nil {
 			s.ptyMutex.Unlock()
 			httpError(w, r, fmt.Sprintf("Failed to create terminal: %v", err), http.StatusInternalServerError)
@@ -975,21 +1464,39 @@ func (s *Server) handleTerminalEvents(w http.ResponseWriter, r *http.Request, se
 	// Create a channel for this client
 	events := make(chan []byte, 4096) // Buffer to prevent blocking
 
-	// Register this client's channel
+	// Register this client's channel, and grab the scrollback under the same lock
+	// so that no output is lost or duplicated between the replay and live data.
 	session.eventsClientsMutex.Lock()
 	clientID := session.lastEventClientID + 1
 	session.lastEventClientID = clientID
-	session.eventsClients[events] = true
+	client := &terminalClient{id: clientID, control: make(chan int, 1)}
+	session.eventsClients[events] = client
+	replay := bytes.Clone(session.scrollback)
+	writer := session.writer
 	session.eventsClientsMutex.Unlock()
 
-	// When the client disconnects, remove their channel
+	// When the client disconnects, remove their channel, and give up the keyboard if they had it
 	defer func() {
 		session.eventsClientsMutex.Lock()
-		delete(session.eventsClients, events)
-		close(events)
+		// readFromPtyAndBroadcast closes (and removes) all channels when the terminal exits
+		if session.eventsClients[events] != nil {
+			delete(session.eventsClients, events)
+			close(events)
+		}
+		if session.writer == clientID {
+			session.setWriter(0)
+		}
 		session.eventsClientsMutex.Unlock()
 	}()
 
+	// Tell the client who it is and who is typing; it needs its ID to send input.
+	writeTerminalControl(w, clientID, writer)
+
+	// Replay what this terminal printed before the client connected (e.g. across page reloads)
+	if len(replay) > 0 {
+		fmt.Fprintf(w, "data: %s\n\n", base64.StdEncoding.EncodeToString(replay))
+	}
+
 	// Flush to send headers to client immediately
 	if f, ok := w.(http.Flusher); ok {
 		f.Flush()
@@ -1000,7 +1507,16 @@ func (s *Server) handleTerminalEvents(w http.ResponseWriter, r *http.Request, se
 		select {
 		case <-r.Context().Done():
 			return
-		case data := <-events:
+		case writer := <-client.control:
+			writeTerminalControl(w, clientID, writer)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		case data, ok := <-events:
+			if !ok {
+				// Terminal exited
+				return
+			}
 			// Format as SSE with base64 encoding
 			fmt.Fprintf(w, "data: %s\n\n", base64.StdEncoding.EncodeToString(data))
 
@@ -1031,11 +1547,19 @@ func (s *Server) handleTerminalInput(w http.ResponseWriter, r *http.Request, ses
 		return
 	}
 
+	// Only the client in control may type or resize; the others are read-only
+	// until they take control.
+	client, _ := strconv.Atoi(r.URL.Query().Get("client"))
+
 	// Check if it's a resize message
 	if len(body) > 0 && body[0] == '{' {
 		var msg TerminalMessage
 		if err := json.Unmarshal(body, &msg); err == nil && msg.Type == "resize" {
 			if msg.Cols > 0 && msg.Rows > 0 {
+				if !session.mayWrite(client, false) {
+					httpError(w, r, "Another client has control of this terminal", http.StatusConflict)
+					return
+				}
 				pty.Setsize(session.pty, &pty.Winsize{
 					Cols: msg.Cols,
 					Rows: msg.Rows,
@@ -1049,7 +1573,13 @@ func (s *Server) handleTerminalInput(w http.ResponseWriter, r *http.Request, ses
 	}
 
 	// Regular terminal input
+	if !session.mayWrite(client, true) {
+		httpError(w, r, "Another client has control of this terminal", http.StatusConflict)
+		return
+	}
+	session.inputMutex.Lock()
 	_, err = session.pty.Write(body)
+	session.inputMutex.Unlock()
 	if err != nil {
 		slog.Error("Failed to write to pty", "error", err)
 		httpError(w, r, "Failed to write to terminal", http.StatusInternalServerError)
@@ -1102,6 +1632,7 @@ func (s *Server) readFromPtyAndBroadcast(sessionID string, session *terminalSess
 
 		// Broadcast to all connected clients
 		session.eventsClientsMutex.Lock()
+		session.appendScrollback(data)
 		for ch := range session.eventsClients {
 			// Try to send, but don't block if channel is full
 			select {
@@ -1140,12 +1671,21 @@ func initDebugMux(agent loop.CodingAgent) *http.ServeMux {
 	}
 	mux.HandleFunc("GET /debug/{$}", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		// The limits the model's requests go out with, defaults filled in.
+		shaping := "not reported by this model's service"
+		if p, ok := agent.(interface{ RequestShaping() (llm.Shaping, bool) }); ok {
+			if sh, ok := p.RequestShaping(); ok {
+				shaping = sh.String()
+			}
+		}
 		// TODO: pid is not as useful as "outside pid"
 		fmt.Fprintf(w, `<!doctype html>
 			<html><head><title>sketch debug</title></head><body>
 			<h1>sketch debug</h1>
 			pid %d<br>
 			build %s<br>
+			model %s<br>
+			request shaping %s<br>
 			<ul>
 				<li><a href="pprof/cmdline">pprof/cmdline</a></li>
 				<li><a href="pprof/profile">pprof/profile</a></li>
@@ -1154,12 +1694,15 @@ func initDebugMux(agent loop.CodingAgent) *http.ServeMux {
 				<li><a href="pprof/goroutine?debug=1">pprof/goroutine?debug=1</a></li>
 				<li><a href="conversation-history">conversation-history</a></li>
 				<li><a href="tools">tools</a></li>
+				<li><a href="context">context</a></li>
 				<li><a href="system-prompt">system-prompt</a></li>
+				<li><a href="artifacts">artifacts</a></li>
+				<li><a href="states">states</a></li>
 				<li><a href="logs">logs</a></li>
 			</ul>
 			</body>
 			</html>
-			`, os.Getpid(), build)
+			`, os.Getpid(), build, html.EscapeString(agent.ModelName()), html.EscapeString(shaping))
 	})
 	mux.HandleFunc("GET /debug/pprof/", pprof.Index)
 	mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
@@ -1213,8 +1756,39 @@ func initDebugMux(agent loop.CodingAgent) *http.ServeMux {
 			return
 		}
 
+		// Tools kept out of the conversation by -enable-tools/-disable-tools
+		var disabled []string
+		if p, ok := agent.(interface{ DisabledTools() []string }); ok {
+			disabled = p.DisabledTools()
+		}
+
 		// Render the tools debug page
-		renderToolsDebugPage(w, convo.Tools)
+		renderToolsDebugPage(w, convo.Tools, disabled)
+	})
+
+	// Add context size debug handler; ?format=json for the numbers alone
+	mux.HandleFunc("GET /debug/context", func(w http.ResponseWriter, r *http.Request) {
+		type ConvoProvider interface {
+			GetConvo() loop.ConvoInterface
+		}
+		convoProvider, ok := agent.(ConvoProvider)
+		if !ok {
+			http.Error(w, "Agent does not support conversation debugging", http.StatusNotImplemented)
+			return
+		}
+		convo, ok := convoProvider.GetConvo().(*conversation.Convo)
+		if !ok {
+			http.Error(w, "Unable to access the conversation", http.StatusInternalServerError)
+			return
+		}
+		cs := convo.ContextSize()
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(cs)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderContextDebugPage(w, cs, convo.LastUsage())
 	})
 
 	// Add system prompt debug handler
@@ -1243,11 +1817,67 @@ func initDebugMux(agent loop.CodingAgent) *http.ServeMux {
 		renderSystemPromptDebugPage(w, convo.SystemPrompt)
 	})
 
+	// Add artifact store debug handler; ?key=name shows a single artifact's raw value
+	mux.HandleFunc("GET /debug/artifacts", func(w http.ResponseWriter, r *http.Request) {
+		artifacts, err := claudetool.LoadArtifacts(agent.SessionID())
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if key := r.URL.Query().Get("key"); key != "" {
+			a, ok := artifacts[key]
+			if !ok {
+				httpError(w, r, "Artifact not found", http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			io.WriteString(w, a.Value)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderArtifactsDebugPage(w, artifacts)
+	})
+
+	// Add state history debug handler
+	mux.HandleFunc("GET /debug/states", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		renderStatesDebugPage(w, agent.StateHistory())
+	})
+
 	return mux
 }
 
+// renderArtifactsDebugPage renders an HTML page listing the model's artifact store
+func renderArtifactsDebugPage(w http.ResponseWriter, artifacts map[string]claudetool.Artifact) {
+	keys := slices.Sorted(maps.Keys(artifacts))
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+	<title>Sketch Artifacts Debug</title>
+	<style>
+		body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; margin: 40px; }
+		table { border-collapse: collapse; }
+		td, th { border: 1px solid #e9ecef; padding: 6px 12px; text-align: left; }
+	</style>
+</head>
+<body>
+	<h1>Sketch Artifacts Debug</h1>
+	<p><strong>Total Artifacts:</strong> %d</p>
+	<table>
+		<tr><th>Key</th><th>Bytes</th><th>Updated</th><th>Description</th></tr>
+`, len(keys))
+	for _, k := range keys {
+		a := artifacts[k]
+		fmt.Fprintf(w, "\t\t<tr><td><a href=\"artifacts?key=%s\">%s</a></td><td>%d</td><td>%s</td><td>%s</td></tr>\n",
+			url.QueryEscape(k), html.EscapeString(k), len(a.Value), a.UpdatedAt.Format(time.RFC3339), html.EscapeString(a.Description))
+	}
+	fmt.Fprintf(w, `	</table>
+</body>
+</html>`)
+}
+
 // renderToolsDebugPage renders an HTML page showing all available tools
-func renderToolsDebugPage(w http.ResponseWriter, tools []*llm.Tool) {
+func renderToolsDebugPage(w http.ResponseWriter, tools []*llm.Tool, disabled []string) {
 	fmt.Fprintf(w, `<!DOCTYPE html>
 <html>
 <head>
@@ -1267,8 +1897,13 @@ func renderToolsDebugPage(w http.ResponseWriter, tools []*llm.Tool) {
 	<h1>Sketch Tools Debug</h1>
 	<div class="summary">
 		<strong>Total Tools Available:</strong> %d
-	</div>
 `, len(tools))
+	if len(disabled) > 0 {
+		fmt.Fprintf(w, `		<br><strong>Disabled for this session:</strong> %s
+`, html.EscapeString(strings.Join(disabled, ", ")))
+	}
+	fmt.Fprintf(w, `	</div>
+`)
 
 	for i, tool := range tools {
 		fmt.Fprintf(w, `	<div class="tool">
@@ -1539,13 +2174,75 @@ func (s *Server) handleSSEStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleInit serves /init and /reinit, which hand the container its address
+// and SSH keys as control.MethodInit does. Both are safe to retry.
+func (s *Server) handleInit(w http.ResponseWriter, r *http.Request, initAgent func(loop.AgentInit) error) {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.ErrorContext(r.Context(), r.URL.Path+" panic", slog.Any("recovered_err", err))
+
+			// Return an error response to the client
+			httpError(w, r, fmt.Sprintf("panic: %v\n", err), http.StatusInternalServerError)
+		}
+	}()
+
+	if r.Method != "POST" {
+		httpError(w, r, "POST required", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		httpError(w, r, "failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m := &InitRequest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		httpError(w, r, "bad request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.init(m, initAgent); errors.Is(err, loop.ErrInitConflict) {
+		httpError(w, r, "init failed: "+err.Error()+"; POST /reinit to change the settings", http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, r, "init failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}\n")
+}
+
+// init applies m, from the host, and initializes the agent with initAgent.
+func (s *Server) init(m *InitRequest, initAgent func(loop.AgentInit) error) error {
+	// Start the SSH server if the request included ssh keys.
+	s.startSSH(context.Background(), m)
+	s.setIdleSuspend(m.IdleSuspend)
+	// On /reinit, the flow carries on where the session left it.
+	if m.Onboarding != nil && s.Onboarding() == nil {
+		s.SetOnboarding(onboarding.NewGuide(*m.Onboarding))
+	}
+
+	return initAgent(loop.AgentInit{
+		InDocker: true,
+		HostAddr: m.HostAddr,
+	})
+}
+
 // Helper function to get the current state
 func (s *Server) getState() State {
 	serverMessageCount := s.agent.MessageCount()
 	totalUsage := s.agent.TotalUsage()
+	totalUsage.StartTime = totalUsage.StartTime.UTC()
 
 	// Get diff stats
 	diffAdded, diffRemoved := s.agent.DiffStats()
+	gitStats := s.agent.GitStats()
+	sshAvailable, sshError := s.sshStatus()
+	suspension := s.suspensionStatus()
 
 	return State{
 		StateVersion: 2,
@@ -1569,24 +2266,45 @@ func (s *Server) getState() State {
 		GitUsername:          s.agent.GitUsername(),
 		OutstandingLLMCalls:  s.agent.OutstandingLLMCallCount(),
 		OutstandingToolCalls: s.agent.OutstandingToolCalls(),
+		ToolProgress:         s.agent.ToolProgress(),
 		SessionID:            s.agent.SessionID(),
-		SSHAvailable:         s.sshAvailable,
-		SSHError:             s.sshError,
+		SSHAvailable:         sshAvailable,
+		SSHError:             sshError,
 		InContainer:          s.agent.IsInContainer(),
 		FirstMessageIndex:    s.agent.FirstMessageIndex(),
 		AgentState:           s.agent.CurrentStateName(),
 		TodoContent:          s.agent.CurrentTodoContent(),
 		SkabandAddr:          s.agent.SkabandAddr(),
+		Skaband:              s.agent.SkabandStatus(),
 		LinkToGitHub:         s.agent.LinkToGitHub(),
 		SSHConnectionString:  s.agent.SSHConnectionString(),
 		DiffLinesAdded:       diffAdded,
 		DiffLinesRemoved:     diffRemoved,
+		DiffFilesChanged:     gitStats.FilesChanged,
+		DiffCommits:          len(gitStats.Commits),
 		OpenPorts:            s.getOpenPorts(),
 		TokenContextWindow:   s.agent.TokenContextWindow(),
 		Model:                s.agent.ModelName(),
+		TurnTimeout:          formatTurnTimeout(s.agent.TurnTimeout()),
+		Sampling:             s.agent.Sampling().String(),
+		LastActivity:         s.lastActivity(),
+		IdleSuspend:          suspension.idleLimit,
+		Suspensions:          suspension.count,
+		ResumedAt:            suspension.resumed(),
+		Image:                s.agent.ImageProvenance(),
+		PlanMode:             s.planMode(),
+		PendingApprovals:     s.pendingApprovals(),
 	}
 }
 
+// formatTurnTimeout renders a turn timeout for State, omitting it when disabled.
+func formatTurnTimeout(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return d.String()
+}
+
 // getOpenPorts retrieves the current open ports from the agent
 func (s *Server) getOpenPorts() []Port {
 	ports := s.agent.GetPorts()
@@ -1649,6 +2367,32 @@ func (s *Server) handleGitRawDiff(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleGitDiff serves the structured diff between two revisions: per-file status,
+// renames, binary files, and hunk boundaries. Without parameters, it is the diff from
+// sketch-base to the working directory.
+func (s *Server) handleGitDiff(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to := query.Get("from"), query.Get("to")
+	if commit := query.Get("commit"); commit != "" {
+		if !isValidGitSHA(commit) {
+			httpError(w, r, fmt.Sprintf("Invalid git commit SHA format: %s", commit), http.StatusBadRequest)
+			return
+		}
+		from, to = commit+"^", commit
+	}
+
+	files, err := s.agent.DiffStructured(from, to)
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("Error getting git diff: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if files == nil {
+		files = []git_tools.FileDiff{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
 func (s *Server) handleGitShow(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -1711,6 +2455,15 @@ func (s *Server) handleGitRecentLog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleGitStats returns the changes from sketch-base to HEAD broken down by file and by commit.
+func (s *Server) handleGitStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.agent.GitStats()); err != nil {
+		httpError(w, r, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
 func (s *Server) handleGitCat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -1896,11 +2649,16 @@ func (s *Server) handleGitPushInfo(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	cmd = exec.Command("git", "symbolic-ref", "--quiet", "--short", "HEAD")
+	cmd.Dir = repoDir
+	branch, _ := cmd.Output()
+
 	w.Header().Set("Content-Type", "application/json")
 	response := GitPushInfoResponse{
 		Hash:    hash,
 		Subject: subject,
 		Remotes: remotes,
+		Branch:  strings.TrimSpace(string(branch)),
 	}
 	_ = json.NewEncoder(w).Encode(response)
 }
@@ -1925,11 +2683,18 @@ func (s *Server) handleGitPush(w http.ResponseWriter, r *http.Request) {
 		httpError(w, r, "Missing required parameters: remote, branch, and commit", http.StatusBadRequest)
 		return
 	}
+	if msg := validBranchName(requestBody.Branch); msg != "" {
+		httpError(w, r, msg, http.StatusBadRequest)
+		return
+	}
 
+	if !requestBody.DryRun {
+		s.recordAudit(r, "push", fmt.Sprintf("%s to %s %s", requestBody.Commit, requestBody.Remote, requestBody.Branch))
+	}
 	repoDir := s.agent.RepoRoot()
 
 	// Build the git push command
-	args := []string{"push"}
+	args := []string{"push", "--porcelain"}
 	if requestBody.DryRun {
 		args = append(args, "--dry-run")
 	}
@@ -1947,6 +2712,11 @@ func (s *Server) handleGitPush(w http.ResponseWriter, r *http.Request) {
 		targetRef = fmt.Sprintf("refs/heads/%s", requestBody.Branch)
 	}
 
+	if requestBody.CreateBranch {
+		// An empty expected value leases the ref only if it doesn't exist.
+		args = append(args, "--force-with-lease="+targetRef+":")
+	}
+
 	args = append(args, requestBody.Remote, fmt.Sprintf("%s:%s", requestBody.Commit, targetRef))
 
 	// Log the git push command being executed
@@ -1969,7 +2739,11 @@ func (s *Server) handleGitPush(w http.ResponseWriter, r *http.Request) {
 	// doesn't take a "-c" option, and the only handy env variable that
 	// because a header is the user agent, so we abuse it...
 	cmd.Env = append(os.Environ(), "GIT_HTTP_USER_AGENT=sketch-intentional-push")
-	output, err := cmd.CombinedOutput()
+	// With --porcelain, the status of the ref goes to stdout and the remote's messages to stderr.
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	output := stderr.String() + stdout.String()
 
 	// Log the result of the git push command
 	if err != nil {
@@ -1985,15 +2759,43 @@ func (s *Server) handleGitPush(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare response
 	response := GitPushResponse{
-		Success: err == nil,
-		Output:  string(output),
-		DryRun:  requestBody.DryRun,
+		Success:        err == nil,
+		Output:         output,
+		DryRun:         requestBody.DryRun,
+		PullRequestURL: pullRequestURL(stderr.String()),
+	}
+	response.Status, response.Reason = parsePushPorcelain(stdout.String())
+	if response.Status == pushRejected {
+		response.Success = false
+	}
+	if requestBody.CreateBranch && response.Reason == "stale info" {
+		response.Reason = "the branch already exists on the remote"
 	}
 
 	if err != nil {
 		response.Error = err.Error()
+	} else if requestBody.SetUpstream && !requestBody.DryRun {
+		if strings.HasPrefix(targetRef, "refs/heads/") {
+			response.Upstream, err = setUpstream(repoDir, requestBody.Remote, requestBody.Branch)
+		} else {
+			err = fmt.Errorf("%s isn't a branch of %s to track", targetRef, requestBody.Remote)
+		}
+		if err != nil {
+			response.Error = "pushed, but not tracking: " + err.Error()
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(response)
 }
+
+// handleConversations serves GET /conversations, the session's conversations
+// in the order they started, each naming its parent.
+func (s *Server) handleConversations(w http.ResponseWriter, r *http.Request) {
+	nodes := s.agent.Conversations()
+	if nodes == nil {
+		nodes = []loop.ConversationNode{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
@@ -3,330 +3,54 @@ package server_test
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"slices"
+	"os/exec"
 	"strings"
-	"sync"
 	"testing"
 	"time"
 
-	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
 	"sketch.dev/loop/server"
+	"sketch.dev/netpolicy"
 	"tailscale.com/portlist"
 )
 
-// mockAgent is a mock implementation of loop.CodingAgent for testing
-type mockAgent struct {
-	mu                       sync.RWMutex
-	messages                 []loop.AgentMessage
-	messageCount             int
-	currentState             string
-	subscribers              []chan *loop.AgentMessage
-	stateTransitionListeners []chan loop.StateTransition
-	gitUsername              string
-	initialCommit            string
-	branchName               string
-	branchPrefix             string
-	workingDir               string
-	sessionID                string
-	slug                     string
-	retryNumber              int
-	skabandAddr              string
-	model                    string
-}
-
-// ExternalMessage implements loop.CodingAgent.
-func (m *mockAgent) ExternalMessage(ctx context.Context, msg loop.ExternalMessage) error {
-	panic("unimplemented")
-}
-
-// TokenContextWindow implements loop.CodingAgent.
-func (m *mockAgent) TokenContextWindow() int {
-	return 200000
-}
-
-// ModelName implements loop.CodingAgent.
-func (m *mockAgent) ModelName() string {
-	return m.model
-}
-
-func (m *mockAgent) NewIterator(ctx context.Context, nextMessageIdx int) loop.MessageIterator {
-	m.mu.RLock()
-	// Send existing messages that should be available immediately
-	ch := make(chan *loop.AgentMessage, 100)
-	iter := &mockIterator{
-		agent:          m,
-		ctx:            ctx,
-		nextMessageIdx: nextMessageIdx,
-		ch:             ch,
-	}
-	m.mu.RUnlock()
-	return iter
-}
-
-type mockIterator struct {
-	agent          *mockAgent
-	ctx            context.Context
-	nextMessageIdx int
-	ch             chan *loop.AgentMessage
-	subscribed     bool
-}
-
-func (m *mockIterator) Next() *loop.AgentMessage {
-	if !m.subscribed {
-		m.agent.mu.Lock()
-		m.agent.subscribers = append(m.agent.subscribers, m.ch)
-		m.agent.mu.Unlock()
-		m.subscribed = true
-	}
-
-	for {
-		select {
-		case <-m.ctx.Done():
-			return nil
-		case msg := <-m.ch:
-			return msg
-		}
-	}
-}
-
-func (m *mockIterator) Close() {
-	// Remove from subscribers using slices.Delete
-	m.agent.mu.Lock()
-	for i, ch := range m.agent.subscribers {
-		if ch == m.ch {
-			m.agent.subscribers = slices.Delete(m.agent.subscribers, i, i+1)
-			break
-		}
-	}
-	m.agent.mu.Unlock()
-	close(m.ch)
-}
-
-func (m *mockAgent) Messages(start int, end int) []loop.AgentMessage {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	if start >= len(m.messages) || end > len(m.messages) || start < 0 || end < 0 {
-		return []loop.AgentMessage{}
-	}
-	return slices.Clone(m.messages[start:end])
-}
-
-func (m *mockAgent) MessageCount() int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.messageCount
-}
-
-func (m *mockAgent) AddMessage(msg loop.AgentMessage) {
-	m.mu.Lock()
-	msg.Idx = m.messageCount
-	m.messages = append(m.messages, msg)
-	m.messageCount++
-
-	// Create a copy of subscribers to avoid holding the lock while sending
-	subscribers := make([]chan *loop.AgentMessage, len(m.subscribers))
-	copy(subscribers, m.subscribers)
-	m.mu.Unlock()
-
-	// Notify subscribers
-	msgCopy := msg // Create a copy to avoid race conditions
-	for _, ch := range subscribers {
-		ch <- &msgCopy
-	}
-}
-
-func (m *mockAgent) NewStateTransitionIterator(ctx context.Context) loop.StateTransitionIterator {
-	m.mu.Lock()
-	ch := make(chan loop.StateTransition, 10)
-	m.stateTransitionListeners = append(m.stateTransitionListeners, ch)
-	m.mu.Unlock()
-
-	return &mockStateTransitionIterator{
-		agent: m,
-		ctx:   ctx,
-		ch:    ch,
-	}
-}
-
-type mockStateTransitionIterator struct {
-	agent *mockAgent
-	ctx   context.Context
-	ch    chan loop.StateTransition
-}
-
-func (m *mockStateTransitionIterator) Next() *loop.StateTransition {
-	select {
-	case <-m.ctx.Done():
-		return nil
-	case transition, ok := <-m.ch:
-		if !ok {
-			return nil
-		}
-		transitionCopy := transition
-		return &transitionCopy
-	}
-}
-
-func (m *mockStateTransitionIterator) Close() {
-	m.agent.mu.Lock()
-	for i, ch := range m.agent.stateTransitionListeners {
-		if ch == m.ch {
-			m.agent.stateTransitionListeners = slices.Delete(m.agent.stateTransitionListeners, i, i+1)
-			break
-		}
-	}
-	m.agent.mu.Unlock()
-	close(m.ch)
-}
-
-func (m *mockAgent) CurrentStateName() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.currentState
-}
-
-func (m *mockAgent) TriggerStateTransition(from, to loop.State, event loop.TransitionEvent) {
-	m.mu.Lock()
-	m.currentState = to.String()
-	transition := loop.StateTransition{
-		From:  from,
-		To:    to,
-		Event: event,
-	}
-
-	// Create a copy of listeners to avoid holding the lock while sending
-	listeners := make([]chan loop.StateTransition, len(m.stateTransitionListeners))
-	copy(listeners, m.stateTransitionListeners)
-	m.mu.Unlock()
-
-	// Notify listeners
-	for _, ch := range listeners {
-		ch <- transition
-	}
-}
-
-func (m *mockAgent) InitialCommit() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.initialCommit
-}
-
-func (m *mockAgent) SketchGitBase() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.initialCommit
-}
-
-func (m *mockAgent) SketchGitBaseRef() string {
-	return "sketch-base-test-session"
-}
-
-func (m *mockAgent) BranchName() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.branchName
-}
-
-// Other required methods of loop.CodingAgent with minimal implementation
-func (m *mockAgent) Init(loop.AgentInit) error                   { return nil }
-func (m *mockAgent) Ready() <-chan struct{}                      { ch := make(chan struct{}); close(ch); return ch }
-func (m *mockAgent) URL() string                                 { return "http://localhost:8080" }
-func (m *mockAgent) UserMessage(ctx context.Context, msg string) {}
-func (m *mockAgent) Loop(ctx context.Context)                    {}
-func (m *mockAgent) CancelTurn(cause error)                      {}
-func (m *mockAgent) CancelToolUse(id string, cause error) error  { return nil }
-func (m *mockAgent) TotalUsage() conversation.CumulativeUsage    { return conversation.CumulativeUsage{} }
-func (m *mockAgent) OriginalBudget() conversation.Budget         { return conversation.Budget{} }
-func (m *mockAgent) WorkingDir() string                          { return m.workingDir }
-func (m *mockAgent) RepoRoot() string                            { return m.workingDir }
-func (m *mockAgent) Diff(commit *string) (string, error)         { return "", nil }
-func (m *mockAgent) OS() string                                  { return "linux" }
-func (m *mockAgent) SessionID() string                           { return m.sessionID }
-func (m *mockAgent) SSHConnectionString() string                 { return "sketch-" + m.sessionID }
-func (m *mockAgent) BranchPrefix() string                        { return m.branchPrefix }
-func (m *mockAgent) CurrentTodoContent() string                  { return "" } // Mock returns empty for simplicity
-func (m *mockAgent) OutstandingLLMCallCount() int                { return 0 }
-func (m *mockAgent) OutstandingToolCalls() []string              { return nil }
-func (m *mockAgent) OutsideOS() string                           { return "linux" }
-func (m *mockAgent) OutsideHostname() string                     { return "test-host" }
-func (m *mockAgent) OutsideWorkingDir() string                   { return "/app" }
-func (m *mockAgent) GitOrigin() string                           { return "" }
-func (m *mockAgent) GitUsername() string                         { return m.gitUsername }
-func (m *mockAgent) PassthroughUpstream() bool                   { return false }
-func (m *mockAgent) OpenBrowser(url string)                      {}
-func (m *mockAgent) CompactConversation(ctx context.Context) error {
-	// Mock implementation - just return nil
-	return nil
-}
-func (m *mockAgent) IsInContainer() bool                        { return false }
-func (m *mockAgent) FirstMessageIndex() int                     { return 0 }
-func (m *mockAgent) DetectGitChanges(ctx context.Context) error { return nil }
-
-func (m *mockAgent) Slug() string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	return m.slug
-}
-
-func (m *mockAgent) IncrementRetryNumber() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.retryNumber++
-}
-
-func (m *mockAgent) SkabandAddr() string   { return m.skabandAddr }
-func (m *mockAgent) LinkToGitHub() bool    { return false }
-func (m *mockAgent) DiffStats() (int, int) { return 0, 0 }
-func (m *mockAgent) GetPorts() []portlist.Port {
-	// Mock returns a few test ports
-	return []portlist.Port{
-		{Proto: "tcp", Port: 22, Process: "sshd", Pid: 1234},
-		{Proto: "tcp", Port: 80, Process: "nginx", Pid: 5678},
-		{Proto: "tcp", Port: 8080, Process: "test-server", Pid: 9012},
-	}
+// testPorts are the open ports reported by fake agents in these tests.
+var testPorts = []portlist.Port{
+	{Proto: "tcp", Port: 22, Process: "sshd", Pid: 1234},
+	{Proto: "tcp", Port: 80, Process: "nginx", Pid: 5678},
+	{Proto: "tcp", Port: 8080, Process: "test-server", Pid: 9012},
 }
 
 // TestSSEStream tests the SSE stream endpoint
 func TestSSEStream(t *testing.T) {
-	// Create a mock agent with initial messages
-	mockAgent := &mockAgent{
-		messages:                 []loop.AgentMessage{},
-		messageCount:             0,
-		currentState:             "Ready",
-		subscribers:              []chan *loop.AgentMessage{},
-		stateTransitionListeners: []chan loop.StateTransition{},
-		initialCommit:            "abcd1234",
-		branchName:               "sketch/test-branch",
-		branchPrefix:             "sketch/",
-		slug:                     "test-slug",
-		model:                    "fake-model",
-	}
-
-	// Add the initial messages before creating the server
-	// to ensure they're available in the Messages slice
-	msg1 := loop.AgentMessage{
-		Type:      loop.UserMessageType,
-		Content:   "Hello, this is a test message",
-		Timestamp: time.Now(),
-	}
-	mockAgent.messages = append(mockAgent.messages, msg1)
-	msg1.Idx = mockAgent.messageCount
-	mockAgent.messageCount++
-
-	msg2 := loop.AgentMessage{
-		Type:      loop.AgentMessageType,
-		Content:   "This is a response message",
-		Timestamp: time.Now(),
-		EndOfTurn: true,
-	}
-	mockAgent.messages = append(mockAgent.messages, msg2)
-	msg2.Idx = mockAgent.messageCount
-	mockAgent.messageCount++
+	// Create a fake agent with initial messages
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		State:         "Ready",
+		SketchGitBase: "abcd1234",
+		BranchName:    "sketch/test-branch",
+		BranchPrefix:  "sketch/",
+		Slug:          "test-slug",
+		Model:         "fake-model",
+		Messages: []loop.AgentMessage{
+			{
+				Type:      loop.UserMessageType,
+				Content:   "Hello, this is a test message",
+				Timestamp: time.Now(),
+			},
+			{
+				Type:      loop.AgentMessageType,
+				Content:   "This is a response message",
+				Timestamp: time.Now(),
+				EndOfTurn: true,
+			},
+		},
+	})
 
 	// Create a server with the mock agent
 	srv, err := server.New(mockAgent, nil)
@@ -391,7 +115,7 @@ func TestSSEStream(t *testing.T) {
 
 		// Trigger a state transition to test state updates
 		time.Sleep(200 * time.Millisecond)
-		mockAgent.TriggerStateTransition(loop.StateReady, loop.StateSendingToLLM, loop.TransitionEvent{
+		mockAgent.Transition(loop.StateReady, loop.StateSendingToLLM, loop.TransitionEvent{
 			Description: "Agent started thinking",
 			Data:        "start_thinking",
 		})
@@ -437,11 +161,11 @@ func TestSSEStream(t *testing.T) {
 
 func TestGitRawDiffHandler(t *testing.T) {
 	// Create a mock agent
-	mockAgent := &mockAgent{
-		workingDir:   t.TempDir(), // Use a temp directory
-		branchPrefix: "sketch/",
-		model:        "fake-model",
-	}
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		WorkingDir:   t.TempDir(), // Use a temp directory
+		BranchPrefix: "sketch/",
+		Model:        "fake-model",
+	})
 
 	// Create the server with the mock agent
 	server, err := server.New(mockAgent, nil)
@@ -485,11 +209,11 @@ func TestGitRawDiffHandler(t *testing.T) {
 
 func TestGitShowHandler(t *testing.T) {
 	// Create a mock agent
-	mockAgent := &mockAgent{
-		workingDir:   t.TempDir(), // Use a temp directory
-		branchPrefix: "sketch/",
-		model:        "fake-model",
-	}
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		WorkingDir:   t.TempDir(), // Use a temp directory
+		BranchPrefix: "sketch/",
+		Model:        "fake-model",
+	})
 
 	// Create the server with the mock agent
 	server, err := server.New(mockAgent, nil)
@@ -523,13 +247,11 @@ func TestGitShowHandler(t *testing.T) {
 
 func TestCompactHandler(t *testing.T) {
 	// Test that mock CompactConversation works
-	mockAgent := &mockAgent{
-		messages:     []loop.AgentMessage{},
-		messageCount: 0,
-		sessionID:    "test-session",
-		branchPrefix: "sketch/",
-		model:        "fake-model",
-	}
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		SessionID:    "test-session",
+		BranchPrefix: "sketch/",
+		Model:        "fake-model",
+	})
 
 	ctx := context.Background()
 	err := mockAgent.CompactConversation(ctx)
@@ -622,21 +344,19 @@ func TestParsePortProxyHost(t *testing.T) {
 
 // TestStateEndpointIncludesPorts tests that the /state endpoint includes port information
 func TestStateEndpointIncludesPorts(t *testing.T) {
-	mockAgent := &mockAgent{
-		messages:      []loop.AgentMessage{},
-		messageCount:  0,
-		currentState:  "initial",
-		subscribers:   []chan *loop.AgentMessage{},
-		gitUsername:   "test-user",
-		initialCommit: "abc123",
-		branchName:    "test-branch",
-		branchPrefix:  "test-",
-		workingDir:    "/tmp/test",
-		sessionID:     "test-session",
-		model:         "fake-model",
-		slug:          "test-slug",
-		skabandAddr:   "http://localhost:8080",
-	}
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		State:         "initial",
+		GitUsername:   "test-user",
+		SketchGitBase: "abc123",
+		BranchName:    "test-branch",
+		BranchPrefix:  "test-",
+		WorkingDir:    "/tmp/test",
+		SessionID:     "test-session",
+		Model:         "fake-model",
+		Slug:          "test-slug",
+		SkabandAddr:   "http://localhost:8080",
+		Ports:         testPorts,
+	})
 
 	// Create a test server
 	server, err := server.New(mockAgent, nil)
@@ -699,11 +419,11 @@ func TestStateEndpointIncludesPorts(t *testing.T) {
 
 // TestGitPushHandler tests the git push endpoint
 func TestGitPushHandler(t *testing.T) {
-	mockAgent := &mockAgent{
-		workingDir:   t.TempDir(),
-		branchPrefix: "sketch/",
-		model:        "fake-model",
-	}
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		WorkingDir:   t.TempDir(),
+		BranchPrefix: "sketch/",
+		Model:        "fake-model",
+	})
 
 	// Create the server with the mock agent
 	server, err := server.New(mockAgent, nil)
@@ -785,11 +505,11 @@ func TestGitPushHandler(t *testing.T) {
 
 // TestGitPushInfoHandler tests the git push info endpoint
 func TestGitPushInfoHandler(t *testing.T) {
-	mockAgent := &mockAgent{
-		workingDir:   t.TempDir(),
-		branchPrefix: "sketch/",
-		model:        "fake-model",
-	}
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		WorkingDir:   t.TempDir(),
+		BranchPrefix: "sketch/",
+		Model:        "fake-model",
+	})
 
 	// Create the server with the mock agent
 	server, err := server.New(mockAgent, nil)
@@ -825,3 +545,286 @@ func TestGitPushInfoHandler(t *testing.T) {
 		t.Errorf("Expected status 405, got: %d", resp.StatusCode)
 	}
 }
+
+// TestTurnTimeoutHandler tests reading and adjusting the turn timeout at runtime
+func TestTurnTimeoutHandler(t *testing.T) {
+	mockAgent := looptest.NewFakeAgent(looptest.Config{
+		WorkingDir:  t.TempDir(),
+		TurnTimeout: 10 * time.Minute,
+	})
+	server, err := server.New(mockAgent, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/turn-timeout")
+	if err != nil {
+		t.Fatalf("Failed to make HTTP request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"timeout":"10m0s"`) {
+		t.Errorf("Expected current timeout in response, got: %s", body)
+	}
+
+	resp, err = http.Post(testServer.URL+"/turn-timeout", "application/json", strings.NewReader(`{"timeout": "45s"}`))
+	if err != nil {
+		t.Fatalf("Failed to make HTTP request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got: %d", http.StatusOK, resp.StatusCode)
+	}
+	if got := mockAgent.TurnTimeout(); got != 45*time.Second {
+		t.Errorf("Expected turn timeout 45s, got: %v", got)
+	}
+
+	for _, bad := range []string{`{"timeout": "soon"}`, `{"timeout": "-1m"}`, `not json`} {
+		resp, err = http.Post(testServer.URL+"/turn-timeout", "application/json", strings.NewReader(bad))
+		if err != nil {
+			t.Fatalf("Failed to make HTTP request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got: %d", http.StatusBadRequest, bad, resp.StatusCode)
+		}
+	}
+}
+
+// TestDependencyAuditHandler tests the dependency audit endpoint
+func TestDependencyAuditHandler(t *testing.T) {
+	server, err := server.New(looptest.NewFakeAgent(looptest.Config{WorkingDir: t.TempDir()}), nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/audit/deps")
+	if err != nil {
+		t.Fatalf("Failed to make HTTP request: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(body)) != "null" {
+		t.Errorf("Expected null report before any audit, got: %s", body)
+	}
+
+	resp, err = http.Post(testServer.URL+"/audit/deps", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to make HTTP request: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"introduced":[]`) {
+		t.Errorf("Unexpected audit response %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestNetworkViolationsHandler(t *testing.T) {
+	agent := looptest.NewFakeAgent(looptest.Config{NetViolations: []netpolicy.Violation{{Host: "example.com", Via: "dns"}}})
+	server, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	testServer := httptest.NewServer(server)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/network/violations")
+	if err != nil {
+		t.Fatalf("Failed to make HTTP request: %v", err)
+	}
+	defer resp.Body.Close()
+	var violations []netpolicy.Violation
+	if err := json.NewDecoder(resp.Body).Decode(&violations); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(violations) != 1 || violations[0].Host != "example.com" || violations[0].Via != "dns" {
+		t.Errorf("Unexpected violations: %+v", violations)
+	}
+}
+
+func TestEndReportsReasonWithoutExiting(t *testing.T) {
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{SessionID: "test-session"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("POST", "/end", strings.NewReader(`{"reason":"done for today"}`)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("POST /end: %d %s", rr.Code, rr.Body)
+		}
+	}
+	select {
+	case reason := <-srv.Ended():
+		if reason != "done for today" {
+			t.Errorf("Ended() = %q", reason)
+		}
+	default:
+		t.Fatal("POST /end didn't report on Ended")
+	}
+	srv.Shutdown()
+}
+
+func TestImageRebuildEndsSession(t *testing.T) {
+	agent := looptest.NewFakeAgent(looptest.Config{
+		Image:       &loop.ImageProvenance{BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:old", Image: "sketch-abc"},
+		ImageStatus: loop.ImageStatus{BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:old", LatestDigest: "sha256:new", UpdateAvailable: true},
+	})
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/image", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"update_available":true`) {
+		t.Errorf("GET /image: %d %s", rr.Code, rr.Body)
+	}
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("POST", "/image/rebuild", nil))
+	if rr.Code != http.StatusOK || agent.Rebuilds() != 1 {
+		t.Fatalf("POST /image/rebuild: %d %s, %d rebuilds", rr.Code, rr.Body, agent.Rebuilds())
+	}
+	select {
+	case reason := <-srv.Ended():
+		if !strings.Contains(reason, "rebuilt") {
+			t.Errorf("Ended() = %q", reason)
+		}
+	default:
+		t.Fatal("POST /image/rebuild didn't end the session")
+	}
+
+	outside, err := server.New(looptest.NewFakeAgent(looptest.Config{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outside.Shutdown()
+	rr = httptest.NewRecorder()
+	outside.ServeHTTP(rr, httptest.NewRequest("POST", "/image/rebuild", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("POST /image/rebuild outside a container: %d", rr.Code)
+	}
+}
+
+func TestConversations(t *testing.T) {
+	nodes := []loop.ConversationNode{
+		{ID: "abc-defg", Purpose: "main", Responses: 3},
+		{ID: "hij-klmn", ParentID: "abc-defg", Purpose: "turn summary", Hidden: true, Depth: 1, CostUSD: 0.01},
+	}
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{Conversations: nodes}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/conversations", nil))
+	var got []loop.ConversationNode
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("GET /conversations: %d %s: %v", rr.Code, rr.Body, err)
+	}
+	if len(got) != 2 || got[1].ParentID != "abc-defg" || got[1].Purpose != "turn summary" || !got[1].Hidden {
+		t.Errorf("GET /conversations = %+v", got)
+	}
+
+	empty, err := server.New(looptest.NewFakeAgent(looptest.Config{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Shutdown()
+	rr = httptest.NewRecorder()
+	empty.ServeHTTP(rr, httptest.NewRequest("GET", "/conversations", nil))
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("GET /conversations with none = %s", rr.Body)
+	}
+}
+
+func TestContextBundle(t *testing.T) {
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	do := func(method, body string) (int, loop.ContextBundle) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(method, "/context", strings.NewReader(body)))
+		var b loop.ContextBundle
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &b); err != nil {
+				t.Fatalf("%s /context: %s: %v", method, rr.Body, err)
+			}
+		}
+		return rr.Code, b
+	}
+
+	if code, _ := do("POST", `{"paths":[]}`); code != http.StatusBadRequest {
+		t.Errorf("POST /context with no paths: %d", code)
+	}
+	if code, _ := do("POST", `{"paths":["missing.go"]}`); code != http.StatusBadRequest {
+		t.Errorf("POST /context with a missing file: %d", code)
+	}
+	if code, b := do("POST", `{"paths":["main.go","docs"]}`); code != http.StatusOK || len(b.Files) != 2 || b.Files[1].Path != "docs" {
+		t.Errorf("POST /context: %d %+v", code, b)
+	}
+	if code, b := do("GET", ""); code != http.StatusOK || len(b.Files) != 2 {
+		t.Errorf("GET /context: %d %+v", code, b)
+	}
+	if code, b := do("DELETE", ""); code != http.StatusOK || len(b.Files) != 0 {
+		t.Errorf("DELETE /context: %d %+v", code, b)
+	}
+}
+
+func TestGitPushCreateBranch(t *testing.T) {
+	repo := newGoldenRepo(t)
+	remote := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "-q", "--bare", remote},
+		{"-C", repo, "remote", "add", "local", remote},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+	head, err := exec.Command("git", "-C", repo, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{WorkingDir: repo}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	push := func(body string) (int, server.GitPushResponse) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("POST", "/git/push", strings.NewReader(body)))
+		var resp server.GitPushResponse
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("POST /git/push: %s: %v", rr.Body, err)
+			}
+		}
+		return rr.Code, resp
+	}
+	req := fmt.Sprintf(`{"remote": "local", "branch": "feature", "commit": %q, "create_branch": true, "set_upstream": true}`, strings.TrimSpace(string(head)))
+
+	if code, _ := push(`{"remote": "local", "branch": "bad..name", "commit": "HEAD"}`); code != http.StatusBadRequest {
+		t.Errorf("pushing to an invalid branch name: %d", code)
+	}
+	if code, resp := push(req); code != http.StatusOK || !resp.Success || resp.Status != "new" || resp.Upstream != "local/feature" {
+		t.Fatalf("creating a branch: %d %+v", code, resp)
+	}
+	if out, _ := exec.Command("git", "-C", repo, "config", "branch.main.merge").Output(); strings.TrimSpace(string(out)) != "refs/heads/feature" {
+		t.Errorf("main tracks %q", out)
+	}
+	req = strings.Replace(req, strings.TrimSpace(string(head)), "HEAD~1", 1)
+	if code, resp := push(req); code != http.StatusOK || resp.Success || resp.Status != "rejected" || resp.Reason != "the branch already exists on the remote" {
+		t.Errorf("creating it again: %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sketch.dev/loop"
+)
+
+// writeMarkdown writes a readable transcript of messages: the user's and agent's text,
+// the tools the agent called, commits, images and diagrams, and each turn's milestone
+// summary, if any.
+// Hidden messages and subconversations are left out. Times are shown in loc.
+func writeMarkdown(w io.Writer, title string, messages []loop.AgentMessage, loc *time.Location) {
+	milestones := make(map[int]string) // end-of-turn Idx -> summary
+	for _, m := range messages {
+		if m.Type == loop.MilestoneMessageType && m.Milestone != nil {
+			milestones[m.Milestone.LastIdx] = m.Milestone.Summary
+		}
+	}
+
+	fmt.Fprintf(w, "# %s\n", title)
+	fmt.Fprintf(w, "\n_Times are in %s._\n", loc)
+	for _, m := range messages {
+		if m.HideOutput || m.ParentConversationID != nil {
+			continue
+		}
+		content := strings.TrimSpace(m.Content)
+		switch m.Type {
+		case loop.UserMessageType:
+			fmt.Fprintf(w, "\n## 🦸 User")
+			if !m.Timestamp.IsZero() {
+				fmt.Fprintf(w, " · %s", m.Timestamp.In(loc).Format("2006-01-02 15:04:05 -07:00"))
+			}
+			fmt.Fprintf(w, "\n\n%s\n", content)
+		case loop.AgentMessageType:
+			if content != "" {
+				fmt.Fprintf(w, "\n%s\n", content)
+			}
+			if len(m.ToolCalls) > 0 {
+				fmt.Fprintln(w)
+			}
+			for _, tc := range m.ToolCalls {
+				fmt.Fprintf(w, "- 🛠️ `%s` %s\n", tc.Name, markdownCode(tc.Input, 120))
+			}
+		case loop.CommitMessageType:
+			fmt.Fprintln(w)
+			for _, c := range m.Commits {
+				fmt.Fprintf(w, "- 🔄 commit `%.8s` %s\n", c.Hash, c.Subject)
+			}
+		case loop.ErrorMessageType, loop.BudgetMessageType:
+			fmt.Fprintf(w, "\n> ❌ %s\n", content)
+		}
+		writeArtifactRefs(w, m.Artifacts)
+		if summary, ok := milestones[m.Idx]; ok {
+			fmt.Fprintf(w, "\n> 📌 **Turn summary:** %s\n", summary)
+		}
+	}
+}
+
+// writeArtifactRefs links to a message's artifacts, relative to the web UI,
+// showing images inline.
+func writeArtifactRefs(w io.Writer, artifacts []loop.Artifact) {
+	if len(artifacts) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	for _, a := range artifacts {
+		switch a.Kind {
+		case loop.ArtifactMermaid:
+			fmt.Fprintf(w, "- 📊 [mermaid diagram `%s`](artifacts/%s)\n", a.ID, a.ID)
+		case loop.ArtifactSBOM, loop.ArtifactProvenance:
+			fmt.Fprintf(w, "- 🔏 [%s `%s`](artifacts/%s)\n", a.Kind, a.ID, a.ID)
+		default:
+			fmt.Fprintf(w, "- 🖼️ ![%s `%s`](artifacts/%s)\n", a.Kind, a.ID, a.ID)
+		}
+	}
+}
+
+// markdownCode formats s as inline code on one line, truncated to n bytes.
+func markdownCode(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > n {
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n] + "…"
+	}
+	if s == "" {
+		return ""
+	}
+	return "`` " + s + " ``"
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/loop"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	messages := []loop.AgentMessage{
+		{Idx: 0, Type: loop.UserMessageType, Content: "fix the build", Timestamp: time.Date(2025, 3, 13, 22, 30, 0, 0, time.UTC)},
+		{Idx: 1, Type: loop.AgentMessageType, Content: "Looking.", ToolCalls: []loop.ToolCall{{Name: "bash", Input: `{"command": "go build ./..."}`}}},
+		{Idx: 2, Type: loop.AgentMessageType, Content: "thinking", HideOutput: true},
+		{Idx: 3, Type: loop.AgentMessageType, Content: "Fixed.", EndOfTurn: true, Artifacts: []loop.Artifact{{ID: "5e1f", Kind: loop.ArtifactMermaid}}},
+		{Idx: 4, Type: loop.MilestoneMessageType, Content: "Fixed the build", Milestone: &loop.Milestone{FirstIdx: 0, LastIdx: 3, Summary: "Fixed the build"}},
+		{Idx: 5, Type: loop.ToolUseMessageType, ToolName: "browser_take_screenshot", Artifacts: []loop.Artifact{{ID: "0a9c", Kind: loop.ArtifactImage}}},
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	writeMarkdown(&b, "Sketch session s1", messages, tokyo)
+	want := "# Sketch session s1\n" +
+		"\n_Times are in Asia/Tokyo._\n" +
+		"\n## 🦸 User · 2025-03-14 07:30:00 +09:00\n\nfix the build\n" +
+		"\nLooking.\n\n- 🛠️ `bash` `` {\"command\": \"go build ./...\"} ``\n" +
+		"\nFixed.\n" +
+		"\n- 📊 [mermaid diagram `5e1f`](artifacts/5e1f)\n" +
+		"\n> 📌 **Turn summary:** Fixed the build\n" +
+		"\n- 🖼️ ![image `0a9c`](artifacts/0a9c)\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
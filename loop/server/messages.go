@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"sketch.dev/loop"
+)
+
+// maxMessagesLimit caps the page size of GET /messages.
+const maxMessagesLimit = 1000
+
+// messagesQuery holds the options of a GET /messages request:
+//
+//	start, end       the index range to look at (default: all messages)
+//	cursor           resume after a previous page; the X-Next-Cursor header of its response
+//	limit            return at most this many messages, and a cursor for the rest
+//	type             comma-separated message types to keep, e.g. "user,agent"
+//	conversation_id  keep only messages of this conversation
+//	tool_results     "full" (default), "omit", or "truncate"
+//	tool_result_max  the bytes of each tool result to keep when truncating (default 1024)
+//
+// Omitted and truncated tool results say how much is missing in tool_result_omitted;
+// fetching the message alone, with start and end, returns it in full.
+type messagesQuery struct {
+	start, end     int
+	limit          int // 0 means no limit
+	types          []loop.CodingAgentMessageType
+	conversationID string
+	toolResults    string
+	toolResultMax  int
+}
+
+func parseMessagesQuery(r *http.Request, count int) (messagesQuery, error) {
+	q := r.URL.Query()
+	mq := messagesQuery{end: count, toolResults: "full", toolResultMax: 1024}
+	intParam := func(name string, dst *int) error {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("Invalid '%s' parameter", name)
+			}
+			*dst = n
+		}
+		return nil
+	}
+	// A cursor takes over from start.
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"start", &mq.start}, {"cursor", &mq.start}, {"end", &mq.end}, {"limit", &mq.limit}, {"tool_result_max", &mq.toolResultMax}} {
+		if err := intParam(p.name, p.dst); err != nil {
+			return mq, err
+		}
+	}
+	if mq.start < 0 || mq.start > mq.end || mq.end > count {
+		return mq, fmt.Errorf("Invalid range: start %d end %d currentCount %d", mq.start, mq.end, count)
+	}
+	if mq.limit < 0 || mq.limit > maxMessagesLimit {
+		return mq, fmt.Errorf("Invalid 'limit' parameter: must be between 0 (no limit) and %d", maxMessagesLimit)
+	}
+	if mq.toolResultMax < 0 {
+		return mq, fmt.Errorf("Invalid 'tool_result_max' parameter: must not be negative")
+	}
+	for t := range strings.SplitSeq(q.Get("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			mq.types = append(mq.types, loop.CodingAgentMessageType(t))
+		}
+	}
+	mq.conversationID = q.Get("conversation_id")
+	switch tr := q.Get("tool_results"); tr {
+	case "":
+	case "full", "omit", "truncate":
+		mq.toolResults = tr
+	default:
+		return mq, fmt.Errorf("Invalid 'tool_results' parameter %q: want full, omit, or truncate", tr)
+	}
+	return mq, nil
+}
+
+func (mq messagesQuery) keep(m *loop.AgentMessage) bool {
+	if len(mq.types) > 0 && !slices.Contains(mq.types, m.Type) {
+		return false
+	}
+	return mq.conversationID == "" || m.ConversationID == mq.conversationID
+}
+
+// trimToolResults applies the tool_results option to m and the tool responses it carries.
+func (mq messagesQuery) trimToolResults(m *loop.AgentMessage) {
+	if mq.toolResults == "full" {
+		return
+	}
+	keep := 0
+	if mq.toolResults == "truncate" {
+		keep = mq.toolResultMax
+	}
+	if len(m.ToolResult) > keep {
+		cut := keep
+		for cut > 0 && !utf8.RuneStart(m.ToolResult[cut]) {
+			cut--
+		}
+		m.ToolResultOmitted = len(m.ToolResult) - cut
+		m.ToolResult = m.ToolResult[:cut]
+	}
+	if len(m.ToolResponses) > 0 {
+		m.ToolResponses = slices.Clone(m.ToolResponses)
+		for i := range m.ToolResponses {
+			mq.trimToolResults(&m.ToolResponses[i])
+		}
+	}
+}
+
+// handleMessages serves GET /messages: a page of the message history,
+// filtered and trimmed as described by messagesQuery.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	mq, err := parseMessagesQuery(r, s.agent.MessageCount())
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	history := s.agent.Messages(mq.start, mq.end)
+	messages := []loop.AgentMessage{}
+	for i := range history {
+		m := &history[i]
+		if !mq.keep(m) {
+			continue
+		}
+		if mq.limit > 0 && len(messages) == mq.limit {
+			w.Header().Set("X-Next-Cursor", strconv.Itoa(mq.start+i))
+			break
+		}
+		mq.trimToolResults(m)
+		messages = append(messages, *m)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ") // Two spaces for each indentation level
+	if err := encoder.Encode(messages); err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
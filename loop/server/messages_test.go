@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+func TestMessagesPaging(t *testing.T) {
+	var history []loop.AgentMessage
+	for i := range 5 {
+		history = append(history,
+			loop.AgentMessage{Type: loop.AgentMessageType, Content: "thinking", ConversationID: "main"},
+			loop.AgentMessage{
+				Type:           loop.ToolUseMessageType,
+				ToolName:       "bash",
+				ToolResult:     strings.Repeat("é", 10+i),
+				ConversationID: "main",
+				ToolResponses:  []loop.AgentMessage{{ToolResult: "nested result"}},
+			},
+			loop.AgentMessage{Type: loop.AgentMessageType, Content: "sub", ConversationID: "sub"},
+		)
+	}
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{Messages: history}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	get := func(query string) ([]loop.AgentMessage, string) {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/messages?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /messages?%s: %s", query, resp.Status)
+		}
+		var msgs []loop.AgentMessage
+		if err := json.NewDecoder(resp.Body).Decode(&msgs); err != nil {
+			t.Fatal(err)
+		}
+		return msgs, resp.Header.Get("X-Next-Cursor")
+	}
+
+	// Page through the tool calls of the main conversation, two at a time.
+	var got []int
+	query := "type=tool&conversation_id=main&limit=2&tool_results=truncate&tool_result_max=5"
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging doesn't end")
+		}
+		q := query
+		if cursor != "" {
+			q += "&cursor=" + cursor
+		}
+		msgs, next := get(q)
+		for _, m := range msgs {
+			got = append(got, m.Idx)
+			// é is two bytes, so five bytes keep two of them.
+			if m.ToolResult != "éé" || m.ToolResultOmitted != len(strings.Repeat("é", 10+m.Idx/3))-4 {
+				t.Errorf("message %d: tool result %q, %d bytes omitted", m.Idx, m.ToolResult, m.ToolResultOmitted)
+			}
+			if r := m.ToolResponses[0]; r.ToolResult != "neste" || r.ToolResultOmitted != 8 {
+				t.Errorf("message %d: nested tool result %q, %d bytes omitted", m.Idx, r.ToolResult, r.ToolResultOmitted)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []int{1, 4, 7, 10, 13}; !slices.Equal(got, want) {
+		t.Errorf("paged tool calls = %v, want %v", got, want)
+	}
+
+	// Omitting leaves the stored messages alone.
+	if msgs, _ := get("start=1&end=2&tool_results=omit"); msgs[0].ToolResult != "" || msgs[0].ToolResultOmitted != 20 {
+		t.Errorf("omitted tool result = %q, %d bytes omitted", msgs[0].ToolResult, msgs[0].ToolResultOmitted)
+	}
+	if msgs, next := get("start=1&end=2"); msgs[0].ToolResult != strings.Repeat("é", 10) || next != "" {
+		t.Errorf("full tool result = %q, next cursor %q", msgs[0].ToolResult, next)
+	}
+
+	for _, bad := range []string{"limit=-1", "limit=5000", "tool_results=some", "cursor=99", "tool_result_max=x"} {
+		resp, err := http.Get(ts.URL + "/messages?" + bad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("GET /messages?%s: %s, want 400", bad, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"sketch.dev/onboarding"
+)
+
+// OnboardingStepRequest is the body of POST /onboarding/steps/{id}.
+type OnboardingStepRequest struct {
+	Action string `json:"action"` // "complete" or "skip"
+}
+
+// SetOnboarding has the web UI walk the user through the onboarding flow g
+// runs. An outtie passes what it discovered in its /init request instead.
+func (s *Server) SetOnboarding(g *onboarding.Guide) {
+	s.onboardMu.Lock()
+	defer s.onboardMu.Unlock()
+	s.onboard = g
+}
+
+// Onboarding returns the session's onboarding flow, nil if it has none.
+func (s *Server) Onboarding() *onboarding.Guide {
+	s.onboardMu.Lock()
+	defer s.onboardMu.Unlock()
+	return s.onboard
+}
+
+// guide returns the onboarding flow, or answers that there is none.
+func (s *Server) guide(w http.ResponseWriter, r *http.Request) *onboarding.Guide {
+	g := s.Onboarding()
+	if g == nil {
+		httpError(w, r, "this session has no onboarding", http.StatusNotFound)
+	}
+	return g
+}
+
+// handleOnboarding serves GET /onboarding: the steps, where the user is, and
+// what the host can use.
+func (s *Server) handleOnboarding(w http.ResponseWriter, r *http.Request) {
+	if g := s.guide(w, r); g != nil {
+		writeOnboardingState(w, g.State())
+	}
+}
+
+// handleOnboardingProgress serves GET /onboarding/progress, which the host
+// reads at the end of the session to keep for the next one.
+func (s *Server) handleOnboardingProgress(w http.ResponseWriter, r *http.Request) {
+	if g := s.guide(w, r); g != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(g.Progress())
+	}
+}
+
+// handleOnboardingStep serves POST /onboarding/steps/{id}, which completes or
+// skips the current step.
+func (s *Server) handleOnboardingStep(w http.ResponseWriter, r *http.Request) {
+	g := s.guide(w, r)
+	if g == nil {
+		return
+	}
+	var req OnboardingStepRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	var st onboarding.State
+	var err error
+	switch req.Action {
+	case "complete":
+		st, err = g.Complete(id)
+	case "skip":
+		st, err = g.Skip(id)
+	default:
+		httpError(w, r, `action must be "complete" or "skip"`, http.StatusBadRequest)
+		return
+	}
+	switch {
+	case errors.Is(err, onboarding.ErrUnknownStep):
+		httpError(w, r, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		httpError(w, r, err.Error(), http.StatusConflict)
+		return
+	}
+	s.recordAudit(r, "onboarding", req.Action+" "+id)
+	writeOnboardingState(w, st)
+}
+
+// handleOnboardingDismiss serves POST /onboarding/dismiss, after which the
+// web UI no longer guides the user.
+func (s *Server) handleOnboardingDismiss(w http.ResponseWriter, r *http.Request) {
+	if g := s.guide(w, r); g != nil {
+		s.recordAudit(r, "onboarding", "dismiss")
+		writeOnboardingState(w, g.Dismiss())
+	}
+}
+
+func writeOnboardingState(w http.ResponseWriter, st onboarding.State) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
@@ -0,0 +1,76 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+	"sketch.dev/onboarding"
+)
+
+func TestOnboarding(t *testing.T) {
+	srv, err := server.New(looptest.NewFakeAgent(looptest.Config{}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, body string) (int, onboarding.State) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var st onboarding.State
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return w.Code, st
+	}
+
+	if code, _ := do("GET", "/onboarding", ""); code != http.StatusNotFound {
+		t.Errorf("GET /onboarding without onboarding = %d, want 404", code)
+	}
+
+	srv.SetOnboarding(onboarding.NewGuide(onboarding.Discovery{
+		FirstRun: true,
+		Capabilities: []onboarding.Capability{
+			{ID: onboarding.CapModels, Available: true},
+			{ID: onboarding.CapDocker, Available: true},
+			{ID: onboarding.CapSSH, Fix: "configure ssh"},
+		},
+	}))
+	code, st := do("GET", "/onboarding", "")
+	if code != http.StatusOK || !st.FirstRun || st.Current != "ssh" || len(st.Steps) != len(onboarding.Steps) {
+		t.Fatalf("GET /onboarding = %d, %+v", code, st)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/onboarding/steps/ssh", `{"action":"finish"}`, http.StatusBadRequest},
+		{"/onboarding/steps/nope", `{"action":"skip"}`, http.StatusNotFound},
+		{"/onboarding/steps/review", `{"action":"complete"}`, http.StatusConflict},
+		// ssh isn't configured, so the step can only be skipped.
+		{"/onboarding/steps/ssh", `{"action":"complete"}`, http.StatusConflict},
+		{"/onboarding/steps/ssh", `{"action":"skip"}`, http.StatusOK},
+	} {
+		if code, _ := do("POST", tc.path, tc.body); code != tc.want {
+			t.Errorf("POST %s %s = %d, want %d", tc.path, tc.body, code, tc.want)
+		}
+	}
+
+	if _, st := do("POST", "/onboarding/dismiss", ""); st.Status != onboarding.Dismissed || st.Current != "skaband" {
+		t.Errorf("after dismissing: %s at %q", st.Status, st.Current)
+	}
+
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/onboarding/progress", nil))
+	var p onboarding.Progress
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Dismissed == nil || len(p.Skipped) != 1 {
+		t.Errorf("GET /onboarding/progress = %s", w.Body)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"sketch.dev/loop"
+)
+
+// PlanModeRequest is the body of POST /plan, and the response to GET /plan.
+type PlanModeRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// PlanReviewRequest is the body of POST /plan/review: the user's answer to
+// the plan the agent proposed in plan mode.
+type PlanReviewRequest struct {
+	Approve bool   `json:"approve"`
+	Comment string `json:"comment,omitempty"` // sent to the agent; required to reject a plan
+}
+
+// planModeAgent is implemented by agents that support plan mode.
+type planModeAgent interface {
+	PlanMode() bool
+	SetPlanMode(ctx context.Context, on bool)
+	ReviewPlan(ctx context.Context, approve bool, comment string) error
+}
+
+// planMode reports whether the agent is in plan mode, for State.
+func (s *Server) planMode() bool {
+	a, ok := s.agent.(planModeAgent)
+	return ok && a.PlanMode()
+}
+
+// handlePlanMode serves GET /plan, whether the agent is in plan mode.
+func (s *Server) handlePlanMode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlanModeRequest{Enabled: s.planMode()})
+}
+
+// handleSetPlanMode serves POST /plan, which enters or leaves plan mode.
+func (s *Server) handleSetPlanMode(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.agent.(planModeAgent)
+	if !ok {
+		httpError(w, r, "this agent has no plan mode", http.StatusNotImplemented)
+		return
+	}
+	var req PlanModeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.SetPlanMode(r.Context(), req.Enabled)
+	if req.Enabled {
+		s.recordAudit(r, "plan mode", "on")
+	} else {
+		s.recordAudit(r, "plan mode", "off")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlanModeRequest{Enabled: a.PlanMode()})
+}
+
+// handlePlanReview serves POST /plan/review, which approves the agent's plan,
+// letting it change files and commit, or sends it back to revise the plan.
+func (s *Server) handlePlanReview(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.agent.(planModeAgent)
+	if !ok {
+		httpError(w, r, "this agent has no plan mode", http.StatusNotImplemented)
+		return
+	}
+	var req PlanReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := a.ReviewPlan(r.Context(), req.Approve, req.Comment); errors.Is(err, loop.ErrNotPlanning) {
+		httpError(w, r, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Approve {
+		s.recordAudit(r, "plan", "approved")
+	} else {
+		s.recordAudit(r, "plan", "rejected")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlanModeRequest{Enabled: a.PlanMode()})
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+// planningAgent is a FakeAgent with a plan mode.
+type planningAgent struct {
+	*looptest.FakeAgent
+	planning bool
+	comments []string
+}
+
+func (a *planningAgent) PlanMode() bool { return a.planning }
+
+func (a *planningAgent) SetPlanMode(ctx context.Context, on bool) { a.planning = on }
+
+func (a *planningAgent) ReviewPlan(ctx context.Context, approve bool, comment string) error {
+	if !a.planning {
+		return loop.ErrNotPlanning
+	}
+	a.planning = !approve
+	a.comments = append(a.comments, comment)
+	return nil
+}
+
+func TestPlanMode(t *testing.T) {
+	agent := &planningAgent{FakeAgent: looptest.NewFakeAgent(looptest.Config{})}
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post := func(path, body string) (int, server.PlanModeRequest) {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		var pm server.PlanModeRequest
+		json.Unmarshal(w.Body.Bytes(), &pm)
+		return w.Code, pm
+	}
+
+	if code, _ := post("/plan/review", `{"approve":true}`); code != http.StatusConflict {
+		t.Errorf("approving outside plan mode = %d", code)
+	}
+	if code, pm := post("/plan", `{"enabled":true}`); code != http.StatusOK || !pm.Enabled {
+		t.Errorf("POST /plan = %d, %+v", code, pm)
+	}
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, httptest.NewRequest("GET", "/state", nil))
+	var state server.State
+	json.Unmarshal(w.Body.Bytes(), &state)
+	if !state.PlanMode {
+		t.Error("state doesn't show plan mode")
+	}
+	if code, pm := post("/plan/review", `{"approve":true,"comment":"ship it"}`); code != http.StatusOK || pm.Enabled {
+		t.Errorf("approving the plan = %d, %+v", code, pm)
+	}
+	if len(agent.comments) != 1 || agent.comments[0] != "ship it" {
+		t.Errorf("the agent got %q", agent.comments)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"log/slog"
+	"syscall"
+	"time"
+)
+
+// Ended returns a channel that receives the reason given for ending the
+// session with POST /end or /image/rebuild. The server doesn't stop itself:
+// whoever receives shuts the process down.
+func (s *Server) Ended() <-chan string {
+	return s.ended
+}
+
+// end ends the session for reason, unless it is already ending.
+func (s *Server) end(reason string) {
+	select {
+	case s.ended <- reason:
+	default:
+	}
+}
+
+// Shutdown stops the SSH server and hangs up the web UI's terminals.
+// A terminal whose shell outlives the hangup by terminalHangupGrace is
+// logged and killed, so that no PTY is left behind.
+// The HTTP server serving s is the caller's to stop.
+func (s *Server) Shutdown() {
+	s.sshMu.Lock()
+	if s.sshServer != nil {
+		if err := s.sshServer.Close(); err != nil {
+			slog.Warn("closing SSH server", "error", err)
+		}
+		s.sshServer = nil
+		s.sshAvailable = false
+		s.sshError = "sketch is shutting down"
+	}
+	s.sshMu.Unlock()
+
+	s.ptyMutex.Lock()
+	for _, session := range s.terminalSessions {
+		if session.cmd.Process != nil {
+			session.cmd.Process.Signal(syscall.SIGHUP)
+		}
+		session.pty.Close()
+	}
+	s.ptyMutex.Unlock()
+
+	// Each session leaves terminalSessions once its shell has exited; see readFromPtyAndBroadcast.
+	deadline := time.Now().Add(terminalHangupGrace)
+	for {
+		s.ptyMutex.Lock()
+		n := len(s.terminalSessions)
+		if n == 0 || time.Now().After(deadline) {
+			for id, session := range s.terminalSessions {
+				slog.Warn("terminal shell still running after hangup; killing it", "terminal", id, "name", session.name)
+				if session.cmd.Process != nil {
+					session.cmd.Process.Kill()
+				}
+			}
+			s.ptyMutex.Unlock()
+			return
+		}
+		s.ptyMutex.Unlock()
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// terminalHangupGrace is how long Shutdown gives the terminals' shells to exit once hung up.
+const terminalHangupGrace = 2 * time.Second
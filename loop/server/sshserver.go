@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -24,7 +25,64 @@ func setWinsize(f *os.File, w, h int) {
 		uintptr(unsafe.Pointer(&struct{ h, w, x, y uint16 }{uint16(h), uint16(w), 0, 0})))
 }
 
-func (s *Server) ServeSSH(ctx context.Context, hostKey, authorizedKeys []byte, containerCAKey, hostCertificate []byte) error {
+// sshMaterial is the SSH configuration an /init request delivers.
+// It is kept to tell a re-delivery of the same material from a change.
+type sshMaterial struct {
+	hostKey, authorizedKeys, containerCAKey, hostCertificate string
+}
+
+// startSSH starts the SSH server with the material in m, replacing a server
+// started with different material. Re-delivering the same material, as a retried
+// or repeated /init does, leaves the running server alone.
+func (s *Server) startSSH(ctx context.Context, m *InitRequest) {
+	s.sshMu.Lock()
+	defer s.sshMu.Unlock()
+	s.sshAvailable = m.SSHAvailable
+	s.sshError = m.SSHError
+	if len(m.SSHAuthorizedKeys) == 0 || len(m.SSHServerIdentity) == 0 {
+		return
+	}
+	mat := sshMaterial{string(m.SSHServerIdentity), string(m.SSHAuthorizedKeys), string(m.SSHContainerCAKey), string(m.SSHHostCertificate)}
+	if s.sshServer != nil && s.sshMaterial == mat {
+		return
+	}
+	if s.sshServer != nil {
+		slog.InfoContext(ctx, "restarting SSH server with new keys")
+		s.sshServer.Close()
+		s.sshServer = nil
+	}
+	srv, err := newSSHServer(ctx, m.SSHServerIdentity, m.SSHAuthorizedKeys, m.SSHContainerCAKey, m.SSHHostCertificate)
+	if err != nil {
+		slog.ErrorContext(ctx, "/init newSSHServer", slog.String("err", err.Error()))
+		s.sshAvailable = false
+		s.sshError = err.Error()
+		return
+	}
+	s.sshServer, s.sshMaterial = srv, mat
+	go func() {
+		err := srv.ListenAndServe()
+		if errors.Is(err, ssh.ErrServerClosed) {
+			return
+		}
+		slog.ErrorContext(ctx, "/init ServeSSH", slog.String("err", err.Error()))
+		s.sshMu.Lock()
+		defer s.sshMu.Unlock()
+		if s.sshServer == srv {
+			s.sshServer = nil
+			s.sshAvailable = false
+			s.sshError = err.Error()
+		}
+	}()
+}
+
+// sshStatus reports whether SSH is available and, if not, why.
+func (s *Server) sshStatus() (available bool, errMsg string) {
+	s.sshMu.Lock()
+	defer s.sshMu.Unlock()
+	return s.sshAvailable, s.sshError
+}
+
+func newSSHServer(ctx context.Context, hostKey, authorizedKeys []byte, containerCAKey, hostCertificate []byte) (*ssh.Server, error) {
 	// Parse all authorized keys
 	allowedKeys := make([]ssh.PublicKey, 0)
 	rest := authorizedKeys
@@ -47,7 +105,7 @@ func (s *Server) ServeSSH(ctx context.Context, hostKey, authorizedKeys []byte, c
 		allowedKeys = append(allowedKeys, key)
 	}
 	if len(allowedKeys) == 0 {
-		return fmt.Errorf("ServeSSH: no valid authorized keys found")
+		return nil, fmt.Errorf("newSSHServer: no valid authorized keys found")
 	}
 
 	// Set up the certificate verifier if containerCAKey is provided
@@ -79,11 +137,11 @@ func (s *Server) ServeSSH(ctx context.Context, hostKey, authorizedKeys []byte, c
 
 	signer, err := gossh.ParsePrivateKey(hostKey)
 	if err != nil {
-		return fmt.Errorf("ServeSSH: failed to parse host private key, err: %w", err)
+		return nil, fmt.Errorf("newSSHServer: failed to parse host private key, err: %w", err)
 	}
 	forwardHandler := &ssh.ForwardedTCPHandler{}
 
-	server := ssh.Server{
+	server := &ssh.Server{
 		LocalPortForwardingCallback: ssh.LocalPortForwardingCallback(func(ctx ssh.Context, dhost string, dport uint32) bool {
 			return true
 		}),
@@ -141,7 +199,7 @@ func (s *Server) ServeSSH(ctx context.Context, hostKey, authorizedKeys []byte, c
 			// Standard key-based authentication fallback
 			for _, allowedKey := range allowedKeys {
 				if ssh.KeysEqual(key, allowedKey) {
-					slog.DebugContext(ctx, "ServeSSH: allow key", slog.String("key", string(key.Marshal())))
+					slog.DebugContext(ctx, "SSH: allow key", slog.String("key", string(key.Marshal())))
 					return true
 				}
 			}
@@ -154,7 +212,7 @@ func (s *Server) ServeSSH(ctx context.Context, hostKey, authorizedKeys []byte, c
 	// like "Failed to set up dynamic port forwarding connection over SSH to the VS Code Server."
 	server.ChannelHandlers["direct-tcpip"] = ssh.DirectTCPIPHandler
 
-	return server.ListenAndServe()
+	return server, nil
 }
 
 func handleSftp(ctx context.Context, sess ssh.Session) {
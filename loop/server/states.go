@@ -0,0 +1,97 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sketch.dev/loop"
+)
+
+// handleStateHistory serves GET /state/history: the states the agent went
+// through, with their durations, as JSON. ?turn=N narrows it to one turn.
+func (s *Server) handleStateHistory(w http.ResponseWriter, r *http.Request) {
+	visits := s.agent.StateHistory()
+	if t := r.URL.Query().Get("turn"); t != "" {
+		turn, err := strconv.Atoi(t)
+		if err != nil {
+			httpError(w, r, "Invalid turn", http.StatusBadRequest)
+			return
+		}
+		visits = slices.DeleteFunc(visits, func(v loop.StateVisit) bool { return v.Turn != turn })
+	}
+	if visits == nil {
+		visits = []loop.StateVisit{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visits)
+}
+
+// renderStatesDebugPage renders an HTML page of the state history, turn by
+// turn, newest first, each with the time it spent in each state.
+func renderStatesDebugPage(w http.ResponseWriter, visits []loop.StateVisit) {
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html>
+<head>
+	<title>Sketch States Debug</title>
+	<style>
+		body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', sans-serif; margin: 40px; }
+		table { border-collapse: collapse; margin-bottom: 12px; }
+		td, th { border: 1px solid #e9ecef; padding: 4px 10px; text-align: left; }
+		td.num { text-align: right; font-family: 'SF Mono', Monaco, monospace; }
+		details { margin-bottom: 24px; }
+	</style>
+</head>
+<body>
+	<h1>Sketch States Debug</h1>
+	<p>JSON: <a href="../state/history">state/history</a>, or state/history?turn=N</p>
+`)
+	var turns []int
+	for _, v := range visits {
+		if len(turns) == 0 || turns[len(turns)-1] != v.Turn {
+			turns = append(turns, v.Turn)
+		}
+	}
+	for i, turn := range slices.Backward(turns) {
+		durations := loop.StateDurations(visits, turn)
+		var total time.Duration
+		for _, d := range durations {
+			total += d
+		}
+		open := ""
+		if i == len(turns)-1 {
+			open = " open"
+		}
+		fmt.Fprintf(w, "\t<details%s>\n\t\t<summary><strong>Turn %d</strong>: %s</summary>\n\t\t<table>\n\t\t\t<tr><th>State</th><th>Time</th><th>Share</th></tr>\n",
+			open, turn, total.Round(time.Millisecond))
+		states := slices.SortedFunc(maps.Keys(durations), func(a, b string) int {
+			return cmp.Or(cmp.Compare(durations[b], durations[a]), strings.Compare(a, b))
+		})
+		for _, state := range states {
+			share := 0.0
+			if total > 0 {
+				share = 100 * float64(durations[state]) / float64(total)
+			}
+			fmt.Fprintf(w, "\t\t\t<tr><td>%s</td><td class=\"num\">%s</td><td class=\"num\">%.1f%%</td></tr>\n",
+				html.EscapeString(state), durations[state].Round(time.Millisecond), share)
+		}
+		fmt.Fprintf(w, "\t\t</table>\n\t\t<table>\n\t\t\t<tr><th>Entered</th><th>State</th><th>Time</th><th>Reason</th></tr>\n")
+		for _, v := range visits {
+			if v.Turn != turn {
+				continue
+			}
+			fmt.Fprintf(w, "\t\t\t<tr><td>%s</td><td>%s</td><td class=\"num\">%s</td><td>%s</td></tr>\n",
+				v.Entered.Format("15:04:05.000"), html.EscapeString(v.State), v.Duration.Round(time.Millisecond), html.EscapeString(v.Reason))
+		}
+		fmt.Fprintf(w, "\t\t</table>\n\t</details>\n")
+	}
+	fmt.Fprintf(w, `</body>
+</html>`)
+}
@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+	"sketch.dev/loop/server"
+)
+
+func TestStateHistory(t *testing.T) {
+	agent := looptest.NewFakeAgent(looptest.Config{SessionID: "test-session"})
+	now := time.Now()
+	agent.Transition(loop.StateReady, loop.StateWaitingForUserInput, loop.TransitionEvent{Description: "Starting turn", Timestamp: now})
+	agent.Transition(loop.StateWaitingForUserInput, loop.StateSendingToLLM, loop.TransitionEvent{Description: "Sending <user> message", Timestamp: now})
+	srv, err := server.New(agent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/state/history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var visits []loop.StateVisit
+	err = json.NewDecoder(resp.Body).Decode(&visits)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visits) != 2 || visits[1].State != "SendingToLLM" || visits[1].Reason != "Sending <user> message" {
+		t.Errorf("state history = %+v", visits)
+	}
+
+	resp, err = http.Get(ts.URL + "/state/history?turn=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("history of a turn that didn't happen = %s", body)
+	}
+
+	resp, err = http.Get(ts.URL + "/debug/states")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if page := string(body); !strings.Contains(page, "<td>SendingToLLM</td>") || !strings.Contains(page, "Sending &lt;user&gt; message") {
+		t.Errorf("debug page lacks the visits:\n%s", page)
+	}
+}
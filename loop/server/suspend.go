@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ResumedRequest is the body of the POST /resumed request the host sketch binary
+// makes after unpausing a container it suspended for being idle.
+type ResumedRequest struct {
+	SuspendedAt time.Time `json:"suspended_at"`
+}
+
+// suspension is what the host has told the server about suspending the container.
+type suspension struct {
+	idleLimit   string    // from InitRequest.IdleSuspend
+	count       int       // times the container was suspended and resumed
+	suspendedAt time.Time // when it was last suspended
+	resumedAt   time.Time // when it was last resumed
+}
+
+// resumed is when the container was last resumed, or nil if it never was.
+func (su suspension) resumed() *time.Time {
+	if su.count == 0 {
+		return nil
+	}
+	return &su.resumedAt
+}
+
+func (s *Server) setIdleSuspend(limit string) {
+	s.suspendMu.Lock()
+	defer s.suspendMu.Unlock()
+	s.suspension.idleLimit = limit
+}
+
+func (s *Server) suspensionStatus() suspension {
+	s.suspendMu.Lock()
+	defer s.suspendMu.Unlock()
+	return s.suspension
+}
+
+// handleResumed serves POST /resumed.
+func (s *Server) handleResumed(w http.ResponseWriter, r *http.Request) {
+	var req ResumedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	s.suspendMu.Lock()
+	s.suspension.count++
+	s.suspension.suspendedAt = req.SuspendedAt.UTC()
+	s.suspension.resumedAt = now
+	s.suspendMu.Unlock()
+	slog.InfoContext(r.Context(), "container resumed after idle suspension", "suspended_at", req.SuspendedAt, "suspended_for", now.Sub(req.SuspendedAt).Round(time.Second))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// lastActivity is when the agent last recorded a message: a user message,
+// a tool call, or a reply. It is nil before the first message.
+func (s *Server) lastActivity() *time.Time {
+	n := s.agent.MessageCount()
+	if n == 0 {
+		return nil
+	}
+	msgs := s.agent.Messages(n-1, n)
+	if len(msgs) == 0 {
+		return nil
+	}
+	t := msgs[0].Timestamp.UTC()
+	return &t
+}
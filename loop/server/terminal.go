@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// maxTerminalScrollback is how much output we keep per terminal for replay on reconnect.
+const maxTerminalScrollback = 256 * 1024
+
+// appendScrollback records data in the session's scrollback buffer,
+// discarding the oldest output once it exceeds maxTerminalScrollback.
+// The caller must hold eventsClientsMutex.
+func (ts *terminalSession) appendScrollback(data []byte) {
+	ts.scrollback = append(ts.scrollback, data...)
+	if over := len(ts.scrollback) - maxTerminalScrollback; over > 0 {
+		ts.scrollback = append(ts.scrollback[:0], ts.scrollback[over:]...)
+	}
+}
+
+// terminalClient is a browser connected to a terminal's event stream.
+type terminalClient struct {
+	id int
+	// control carries the latest writer to the client's event stream; only the
+	// latest one matters, so it holds one and notify replaces it.
+	control chan int
+}
+
+func (c *terminalClient) notify(writer int) {
+	select {
+	case <-c.control:
+	default:
+	}
+	c.control <- writer
+}
+
+// setWriter gives control of the keyboard to client id (0 for nobody)
+// and tells every client. The caller must hold eventsClientsMutex.
+func (ts *terminalSession) setWriter(id int) {
+	ts.writer = id
+	for _, c := range ts.eventsClients {
+		c.notify(id)
+	}
+}
+
+// mayWrite reports whether client may send input to the terminal. Only the
+// writer may, unless there is none, in which case anybody may and, with claim,
+// a connected client becomes the writer.
+func (ts *terminalSession) mayWrite(client int, claim bool) bool {
+	ts.eventsClientsMutex.Lock()
+	defer ts.eventsClientsMutex.Unlock()
+	if ts.writer != 0 {
+		return client == ts.writer
+	}
+	if claim && ts.connected(client) {
+		ts.setWriter(client)
+	}
+	return true
+}
+
+// connected reports whether id is a client on the terminal's event stream.
+// The caller must hold eventsClientsMutex.
+func (ts *terminalSession) connected(id int) bool {
+	for _, c := range ts.eventsClients {
+		if c.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTerminalControl writes a "control" event to a terminal's event stream.
+// The browser's onmessage only sees unnamed events, so the output stays separate.
+func writeTerminalControl(w http.ResponseWriter, client, writer int) {
+	data, _ := json.Marshal(TerminalControl{Client: client, Writer: writer})
+	fmt.Fprintf(w, "event: control\ndata: %s\n\n", data)
+}
+
+// validTerminalID reports whether id is usable as a terminal session ID.
+// IDs end up in URL paths, so we keep them short and boring.
+func validTerminalID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// nextTerminalID returns the smallest positive integer not yet used as a session ID.
+// The caller must hold s.ptyMutex.
+func (s *Server) nextTerminalID() string {
+	for i := 1; ; i++ {
+		id := strconv.Itoa(i)
+		if _, exists := s.terminalSessions[id]; !exists {
+			return id
+		}
+	}
+}
+
+// listTerminals returns info about all live terminal sessions, ordered by creation time.
+func (s *Server) listTerminals() []TerminalInfo {
+	s.ptyMutex.Lock()
+	defer s.ptyMutex.Unlock()
+	infos := make([]TerminalInfo, 0, len(s.terminalSessions))
+	for id, session := range s.terminalSessions {
+		session.eventsClientsMutex.Lock()
+		clients := len(session.eventsClients)
+		writer := session.writer
+		session.eventsClientsMutex.Unlock()
+		info := TerminalInfo{
+			SessionID: id,
+			Name:      session.name,
+			CreatedAt: session.createdAt,
+			Clients:   clients,
+			Writer:    writer,
+		}
+		if session.cmd.Process != nil {
+			info.PID = session.cmd.Process.Pid
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b TerminalInfo) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.SessionID, b.SessionID)
+	})
+	return infos
+}
+
+// handleTerminals lists (GET) or creates (POST) terminal sessions.
+func (s *Server) handleTerminals(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.listTerminals())
+	case http.MethodPost:
+		var req TerminalCreateRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.SessionID != "" && !validTerminalID(req.SessionID) {
+			httpError(w, r, "Invalid terminal ID", http.StatusBadRequest)
+			return
+		}
+
+		s.ptyMutex.Lock()
+		if req.SessionID == "" {
+			req.SessionID = s.nextTerminalID()
+		} else if _, exists := s.terminalSessions[req.SessionID]; exists {
+			s.ptyMutex.Unlock()
+			httpError(w, r, "Terminal already exists", http.StatusConflict)
+			return
+		}
+		session, err := s.createTerminalSession(req.SessionID, req.Name)
+		if err != nil {
+			s.ptyMutex.Unlock()
+			httpError(w, r, fmt.Sprintf("Failed to create terminal: %v", err), http.StatusInternalServerError)
+			return
+		}
+		s.terminalSessions[req.SessionID] = session
+		s.ptyMutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(TerminalResponse{SessionID: req.SessionID})
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleTerminalKill kills the terminal named by DELETE /terminal/{id}.
+// The PTY reader notices the exit and removes the session.
+func (s *Server) handleTerminalKill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sessionID := strings.TrimPrefix(r.URL.Path, "/terminal/")
+	if !validTerminalID(sessionID) {
+		httpError(w, r, "Invalid terminal ID", http.StatusBadRequest)
+		return
+	}
+
+	s.ptyMutex.Lock()
+	session, exists := s.terminalSessions[sessionID]
+	s.ptyMutex.Unlock()
+	if !exists {
+		httpError(w, r, "Terminal session not found", http.StatusNotFound)
+		return
+	}
+
+	if session.cmd.Process != nil {
+		// SIGHUP is what a shell expects when its terminal goes away.
+		session.cmd.Process.Signal(syscall.SIGHUP)
+	}
+	session.pty.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTerminalControl gives the keyboard of a terminal to the client named by
+// POST /terminal/control/{id}?client=N, making whoever had it read-only.
+func (s *Server) handleTerminalControl(w http.ResponseWriter, r *http.Request, sessionID string) {
+	s.ptyMutex.Lock()
+	session, exists := s.terminalSessions[sessionID]
+	s.ptyMutex.Unlock()
+	if !exists {
+		httpError(w, r, "Terminal session not found", http.StatusNotFound)
+		return
+	}
+
+	client, _ := strconv.Atoi(r.URL.Query().Get("client"))
+	session.eventsClientsMutex.Lock()
+	defer session.eventsClientsMutex.Unlock()
+	if !session.connected(client) {
+		httpError(w, r, "Unknown terminal client", http.StatusBadRequest)
+		return
+	}
+	if session.writer != client {
+		session.setWriter(client)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
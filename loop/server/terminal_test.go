@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendScrollback(t *testing.T) {
+	ts := &terminalSession{}
+	ts.appendScrollback([]byte("hello "))
+	ts.appendScrollback([]byte("world"))
+	if got := string(ts.scrollback); got != "hello world" {
+		t.Errorf("scrollback = %q, want %q", got, "hello world")
+	}
+
+	big := bytes.Repeat([]byte("x"), maxTerminalScrollback)
+	ts.appendScrollback(big)
+	ts.appendScrollback([]byte("tail"))
+	if len(ts.scrollback) != maxTerminalScrollback {
+		t.Errorf("scrollback length = %d, want %d", len(ts.scrollback), maxTerminalScrollback)
+	}
+	if !bytes.HasSuffix(ts.scrollback, []byte("xtail")) {
+		t.Errorf("scrollback should keep the newest output")
+	}
+}
+
+func TestValidTerminalID(t *testing.T) {
+	for _, id := range []string{"1", "9", "42", "build-logs", "a_b"} {
+		if !validTerminalID(id) {
+			t.Errorf("validTerminalID(%q) = false, want true", id)
+		}
+	}
+	for _, id := range []string{"", "a/b", "../x", "a b", strings.Repeat("x", 33)} {
+		if validTerminalID(id) {
+			t.Errorf("validTerminalID(%q) = true, want false", id)
+		}
+	}
+}
+
+func TestTerminalLifecycle(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	s, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	create := func(body string) (int, TerminalResponse) {
+		resp, err := http.Post(srv.URL+"/terminal", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var tr TerminalResponse
+		if resp.StatusCode == http.StatusCreated {
+			if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, tr
+	}
+	list := func() []TerminalInfo {
+		resp, err := http.Get(srv.URL + "/terminal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var infos []TerminalInfo
+		if err := json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+			t.Fatal(err)
+		}
+		return infos
+	}
+
+	code, first := create("")
+	if code != http.StatusCreated || first.SessionID != "1" {
+		t.Fatalf("create = %d %+v, want 201 with session 1", code, first)
+	}
+	code, named := create(`{"sessionId": "logs", "name": "Logs"}`)
+	if code != http.StatusCreated || named.SessionID != "logs" {
+		t.Fatalf("create named = %d %+v", code, named)
+	}
+	if code, _ := create(`{"sessionId": "logs"}`); code != http.StatusConflict {
+		t.Errorf("duplicate create = %d, want %d", code, http.StatusConflict)
+	}
+
+	infos := list()
+	if len(infos) != 2 {
+		t.Fatalf("got %d terminals, want 2: %+v", len(infos), infos)
+	}
+	if infos[0].SessionID != "1" || infos[0].Name != "Terminal 1" {
+		t.Errorf("first terminal = %+v", infos[0])
+	}
+	if infos[1].SessionID != "logs" || infos[1].Name != "Logs" || infos[1].PID == 0 {
+		t.Errorf("second terminal = %+v", infos[1])
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/terminal/logs", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete = %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(list()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("killed terminal still listed: %+v", list())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL+"/terminal/logs", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("second delete = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	// Clean up the remaining terminal.
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL+"/terminal/1", nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func TestTerminalControl(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	s, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// connect opens an event stream and returns a func that waits for its next control event.
+	connect := func() func() TerminalControl {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/terminal/events/1", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		events := bufio.NewReader(resp.Body)
+		return func() TerminalControl {
+			t.Helper()
+			for control := false; ; {
+				line, err := events.ReadString('\n')
+				if err != nil {
+					t.Fatalf("reading events: %v", err)
+				}
+				if line == "event: control\n" {
+					control = true
+				} else if data, ok := strings.CutPrefix(line, "data: "); ok && control {
+					var c TerminalControl
+					if err := json.Unmarshal([]byte(data), &c); err != nil {
+						t.Fatal(err)
+					}
+					return c
+				}
+			}
+		}
+	}
+	post := func(path string, client int, body string) int {
+		resp, err := http.Post(srv.URL+path+"?client="+strconv.Itoa(client), "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	first, second := connect(), connect()
+	a, b := first(), second()
+	if a.Writer != 0 || b.Writer != 0 || a.Client == b.Client {
+		t.Fatalf("initial control events = %+v, %+v", a, b)
+	}
+
+	// Whoever types first gets the keyboard, and the other is told.
+	if code := post("/terminal/input/1", a.Client, "true\n"); code != http.StatusOK {
+		t.Fatalf("first input = %d", code)
+	}
+	if c1, c2 := first(), second(); c1.Writer != a.Client || c2.Writer != a.Client {
+		t.Errorf("clients told writer = %d and %d, want %d", c1.Writer, c2.Writer, a.Client)
+	}
+	if code := post("/terminal/input/1", b.Client, "true\n"); code != http.StatusConflict {
+		t.Errorf("input from the read-only client = %d, want %d", code, http.StatusConflict)
+	}
+	if code := post("/terminal/input/1", b.Client, `{"type":"resize","cols":80,"rows":24}`); code != http.StatusConflict {
+		t.Errorf("resize from the read-only client = %d, want %d", code, http.StatusConflict)
+	}
+
+	if code := post("/terminal/control/1", b.Client, ""); code != http.StatusNoContent {
+		t.Fatalf("take control = %d", code)
+	}
+	if c := first(); c.Writer != b.Client {
+		t.Errorf("first client told writer = %d, want %d", c.Writer, b.Client)
+	}
+	if code := post("/terminal/input/1", a.Client, "true\n"); code != http.StatusConflict {
+		t.Errorf("input after losing control = %d, want %d", code, http.StatusConflict)
+	}
+	if code := post("/terminal/input/1", b.Client, "true\n"); code != http.StatusOK {
+		t.Errorf("input after taking control = %d", code)
+	}
+	if code := post("/terminal/control/1", 999, ""); code != http.StatusBadRequest {
+		t.Errorf("take control by an unknown client = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	infos := s.listTerminals()
+	if len(infos) != 1 || infos[0].Writer != b.Client || infos[0].Clients != 2 {
+		t.Errorf("terminals = %+v", infos)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/terminal/1", nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
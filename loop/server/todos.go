@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"sketch.dev/claudetool"
+)
+
+// TodoUpdateRequest is the body of PATCH /todos/{id}; unset fields are left alone.
+type TodoUpdateRequest struct {
+	Task   *string `json:"task,omitempty"`
+	Status *string `json:"status,omitempty"` // queued, in-progress, completed
+}
+
+// TodoReorderRequest is the body of POST /todos/reorder. The listed tasks move
+// to the front in the given order; the rest keep their order after them.
+type TodoReorderRequest struct {
+	IDs []string `json:"ids"`
+}
+
+var errNoSuchTodo = errors.New("no such task")
+
+// handleTodos serves GET /todos.
+func (s *Server) handleTodos(w http.ResponseWriter, r *http.Request) {
+	list, err := s.agent.Todos()
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeTodoList(w, list)
+}
+
+// handleTodosReplace serves PUT /todos, which replaces the whole list,
+// e.g. to seed a plan from a tracker before the first turn.
+func (s *Server) handleTodosReplace(w http.ResponseWriter, r *http.Request) {
+	var req TodoList
+	if !decodeTodoRequest(w, r, &req) {
+		return
+	}
+	s.editTodos(w, r, func(list *claudetool.TodoList) error {
+		list.Items = list.Items[:0]
+		for _, item := range req.Items {
+			list.Items = append(list.Items, claudetool.TodoItem(item))
+		}
+		return nil
+	})
+}
+
+// handleTodoAdd serves POST /todos, which appends a task. The id defaults to
+// a slug of the task and the status to queued.
+func (s *Server) handleTodoAdd(w http.ResponseWriter, r *http.Request) {
+	var req TodoItem
+	if !decodeTodoRequest(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Task) == "" {
+		httpError(w, r, "task is required", http.StatusBadRequest)
+		return
+	}
+	if req.Status == "" {
+		req.Status = "queued"
+	}
+	s.editTodos(w, r, func(list *claudetool.TodoList) error {
+		if req.ID == "" {
+			req.ID = todoSlug(req.Task, list.Items)
+		}
+		list.Items = append(list.Items, claudetool.TodoItem(req))
+		return nil
+	})
+}
+
+// handleTodoUpdate serves PATCH /todos/{id}; completing a task is
+// {"status": "completed"}.
+func (s *Server) handleTodoUpdate(w http.ResponseWriter, r *http.Request) {
+	var req TodoUpdateRequest
+	if !decodeTodoRequest(w, r, &req) {
+		return
+	}
+	id := r.PathValue("id")
+	s.editTodos(w, r, func(list *claudetool.TodoList) error {
+		i := slices.IndexFunc(list.Items, func(item claudetool.TodoItem) bool { return item.ID == id })
+		if i < 0 {
+			return fmt.Errorf("%w: %q", errNoSuchTodo, id)
+		}
+		if req.Task != nil {
+			list.Items[i].Task = *req.Task
+		}
+		if req.Status != nil {
+			list.Items[i].Status = *req.Status
+		}
+		return nil
+	})
+}
+
+// handleTodoDelete serves DELETE /todos/{id}.
+func (s *Server) handleTodoDelete(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.editTodos(w, r, func(list *claudetool.TodoList) error {
+		n := len(list.Items)
+		list.Items = slices.DeleteFunc(list.Items, func(item claudetool.TodoItem) bool { return item.ID == id })
+		if len(list.Items) == n {
+			return fmt.Errorf("%w: %q", errNoSuchTodo, id)
+		}
+		return nil
+	})
+}
+
+// handleTodoReorder serves POST /todos/reorder.
+func (s *Server) handleTodoReorder(w http.ResponseWriter, r *http.Request) {
+	var req TodoReorderRequest
+	if !decodeTodoRequest(w, r, &req) {
+		return
+	}
+	s.editTodos(w, r, func(list *claudetool.TodoList) error {
+		var front []claudetool.TodoItem
+		rest := slices.Clone(list.Items)
+		for _, id := range req.IDs {
+			i := slices.IndexFunc(rest, func(item claudetool.TodoItem) bool { return item.ID == id })
+			if i < 0 {
+				return fmt.Errorf("%w: %q", errNoSuchTodo, id)
+			}
+			front = append(front, rest[i])
+			rest = slices.Delete(rest, i, i+1)
+		}
+		list.Items = append(front, rest...)
+		return nil
+	})
+}
+
+// editTodos applies edit through the agent and responds with the new list.
+func (s *Server) editTodos(w http.ResponseWriter, r *http.Request, edit func(*claudetool.TodoList) error) {
+	list, err := s.agent.EditTodos(r.Context(), edit)
+	if errors.Is(err, errNoSuchTodo) {
+		httpError(w, r, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeTodoList(w, list)
+}
+
+func decodeTodoRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeTodoList(w http.ResponseWriter, list claudetool.TodoList) {
+	resp := TodoList{Items: []TodoItem{}}
+	for _, item := range list.Items {
+		resp.Items = append(resp.Items, TodoItem(item))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// todoSlug makes an id for task in the style todo_write asks the model for:
+// a short hyphenated slug, unique among items.
+func todoSlug(task string, items []claudetool.TodoItem) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(task), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words = append(words, word)
+		if len(words) == 5 {
+			break
+		}
+	}
+	base := strings.Join(words, "-")
+	if base == "" {
+		base = "task"
+	}
+	taken := func(id string) bool {
+		return slices.ContainsFunc(items, func(item claudetool.TodoItem) bool { return item.ID == id })
+	}
+	id := base
+	for n := 2; taken(id); n++ {
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+	return id
+}
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"sketch.dev/loop"
+)
+
+// Files too big for a single POST /upload arrive in chunks:
+//
+//	POST /uploads                {"name", "size", "sha256"}  -> UploadStatus
+//	PUT  /uploads/{id}?offset=N  the chunk's bytes           -> UploadStatus
+//	GET  /uploads/{id}                                       -> UploadStatus
+//
+// Each chunk must start where the previous one ended. After an interruption,
+// GET the upload (or, if sketch restarted, POST the same file again) to learn
+// the offset to resume from. A finished upload is checked against its sha256
+// and moved to a path, stable for a given name and content, that the agent
+// can be pointed at.
+
+const (
+	defaultMaxUpload = 512 << 20
+	uploadChunkSize  = 8 << 20
+)
+
+var (
+	errUploadInvalid  = errors.New("invalid upload")
+	errUploadNotFound = errors.New("no such upload")
+	errUploadOffset   = errors.New("chunk does not start where the upload left off")
+	errUploadTooLarge = errors.New("upload is larger than allowed")
+	errUploadChecksum = errors.New("upload does not match its sha256")
+	errUploadNoSpace  = errors.New("not enough disk space for upload")
+)
+
+// UploadCreateRequest is the body of a POST /uploads request.
+type UploadCreateRequest struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// SHA256 is the hex digest of the whole file. It is checked once the last
+	// chunk arrives, and it lets an interrupted upload resume after a restart.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// UploadStatus reports a chunked upload's progress.
+type UploadStatus struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`     // bytes received; the next chunk starts here
+	ChunkSize int64  `json:"chunk_size"` // the largest chunk accepted
+	SHA256    string `json:"sha256,omitempty"`
+	Path      string `json:"path,omitempty"` // where the file is, once complete
+}
+
+// uploadStore keeps uploads under dir: finished files in dir/{id}/{name},
+// and partial ones in dir/.partial/{id}.
+type uploadStore struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+type upload struct {
+	mu     sync.Mutex // serializes chunks
+	status UploadStatus
+}
+
+func newUploadStore(dir string) *uploadStore {
+	return &uploadStore{dir: dir, max: defaultMaxUpload, uploads: make(map[string]*upload)}
+}
+
+// uploadDir is where a session's uploads go: next to its session record,
+// rather than in /tmp, where they would be mixed in with everything else.
+func uploadDir(sessionID string) string {
+	dir, err := loop.SessionRecordDir()
+	if err != nil {
+		dir = filepath.Join(os.TempDir(), "sketch-sessions")
+	}
+	return filepath.Join(dir, cmp.Or(sessionID, "default"), "uploads")
+}
+
+// SetMaxUpload limits the size of uploaded files to n bytes.
+func (s *Server) SetMaxUpload(n int64) {
+	s.uploads.max = n
+}
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// create starts an upload, or picks up one already under way for the same file.
+func (st *uploadStore) create(req UploadCreateRequest) (UploadStatus, error) {
+	name := uploadName(req.Name)
+	req.SHA256 = strings.ToLower(req.SHA256)
+	switch {
+	case req.Size <= 0:
+		return UploadStatus{}, fmt.Errorf("%w: size must be positive", errUploadInvalid)
+	case req.Size > st.max:
+		return UploadStatus{}, fmt.Errorf("%w: %d bytes, limit %d", errUploadTooLarge, req.Size, st.max)
+	case req.SHA256 != "" && !sha256Hex.MatchString(req.SHA256):
+		return UploadStatus{}, fmt.Errorf("%w: sha256 must be 64 hex digits", errUploadInvalid)
+	}
+	if err := os.MkdirAll(filepath.Join(st.dir, ".partial"), 0o755); err != nil {
+		return UploadStatus{}, err
+	}
+	if free, ok := freeSpace(st.dir); ok && free < uint64(req.Size) {
+		return UploadStatus{}, fmt.Errorf("%w: %d bytes needed, %d free", errUploadNoSpace, req.Size, free)
+	}
+
+	// With a checksum, the ID is derived from the file, so sending it again finds it.
+	id := ""
+	if req.SHA256 != "" {
+		id = req.SHA256[:16]
+	} else {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if u, ok := st.uploads[id]; ok && u.status.Name == name && u.status.Size == req.Size {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return u.status, nil
+	}
+
+	u := &upload{status: UploadStatus{ID: id, Name: name, Size: req.Size, ChunkSize: uploadChunkSize, SHA256: req.SHA256}}
+	if final := st.finalPath(id, name); req.SHA256 != "" && fileSize(final) == req.Size {
+		u.status.Offset, u.status.Path = req.Size, final
+	} else if n := fileSize(st.partialPath(id)); n > 0 && n <= req.Size {
+		u.status.Offset = n
+	} else if err := os.WriteFile(st.partialPath(id), nil, 0o644); err != nil {
+		return UploadStatus{}, err
+	}
+	st.uploads[id] = u
+	return u.status, nil
+}
+
+func (st *uploadStore) get(id string) (*upload, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	u, ok := st.uploads[id]
+	if !ok {
+		return nil, errUploadNotFound
+	}
+	return u, nil
+}
+
+func (st *uploadStore) status(id string) (UploadStatus, error) {
+	u, err := st.get(id)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status, nil
+}
+
+// write appends a chunk that starts at offset, finishing the upload if it is the last.
+func (st *uploadStore) write(id string, offset int64, chunk io.Reader) (UploadStatus, error) {
+	u, err := st.get(id)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.status.Path != "" || offset != u.status.Offset {
+		return u.status, fmt.Errorf("%w: offset %d, want %d", errUploadOffset, offset, u.status.Offset)
+	}
+
+	f, err := os.OpenFile(st.partialPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return u.status, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return u.status, err
+	}
+	limit := min(u.status.ChunkSize, u.status.Size-offset)
+	n, err := io.Copy(f, io.LimitReader(chunk, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("%w: chunk exceeds %d bytes", errUploadTooLarge, limit)
+	}
+	if err != nil {
+		// Drop the partial chunk; the client resends it from the same offset.
+		f.Truncate(offset)
+		return u.status, err
+	}
+	u.status.Offset += n
+	if u.status.Offset < u.status.Size {
+		return u.status, nil
+	}
+	if err := f.Close(); err != nil {
+		return u.status, err
+	}
+	err = st.finish(u)
+	return u.status, err
+}
+
+// finish verifies a fully received upload and moves it into place.
+func (st *uploadStore) finish(u *upload) error {
+	partial := st.partialPath(u.status.ID)
+	sum, err := fileSHA256(partial)
+	if err != nil {
+		return err
+	}
+	if u.status.SHA256 != "" && sum != u.status.SHA256 {
+		os.Remove(partial)
+		os.WriteFile(partial, nil, 0o644)
+		u.status.Offset = 0
+		return fmt.Errorf("%w: got %s", errUploadChecksum, sum)
+	}
+	final := st.finalPath(u.status.ID, u.status.Name)
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(partial, final); err != nil {
+		return err
+	}
+	u.status.SHA256, u.status.Path = sum, final
+	return nil
+}
+
+// save stores a file received in one piece, as POST /upload does, returning its path.
+func (st *uploadStore) save(name string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(filepath.Join(st.dir, ".partial"), 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Join(st.dir, ".partial"), "single-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	final := st.finalPath(hex.EncodeToString(h.Sum(nil))[:16], uploadName(name))
+	if err := os.MkdirAll(filepath.Dir(final), 0o755); err != nil {
+		return "", err
+	}
+	return final, os.Rename(tmp.Name(), final)
+}
+
+func (st *uploadStore) partialPath(id string) string {
+	return filepath.Join(st.dir, ".partial", id)
+}
+
+func (st *uploadStore) finalPath(id, name string) string {
+	return filepath.Join(st.dir, id, name)
+}
+
+// uploadName reduces a client-supplied file name to a safe base name.
+func uploadName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "upload"
+	}
+	return name
+}
+
+func fileSize(path string) int64 {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	return fi.Size()
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// freeSpace reports the bytes available to unprivileged users on dir's filesystem.
+func freeSpace(dir string) (uint64, bool) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, false
+	}
+	return fs.Bavail * uint64(fs.Bsize), true
+}
+
+func (s *Server) handleUploadCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req UploadCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, r, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status, err := s.uploads.create(req)
+	if err != nil {
+		httpError(w, r, err.Error(), uploadErrorCode(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/uploads/")
+	var status UploadStatus
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		status, err = s.uploads.status(id)
+	case http.MethodPut:
+		offset, perr := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		if perr != nil {
+			httpError(w, r, "Invalid offset: "+perr.Error(), http.StatusBadRequest)
+			return
+		}
+		status, err = s.uploads.write(id, offset, r.Body)
+	default:
+		httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		httpError(w, r, err.Error(), uploadErrorCode(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func uploadErrorCode(err error) int {
+	switch {
+	case errors.Is(err, errUploadInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUploadOffset):
+		return http.StatusConflict
+	case errors.Is(err, errUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errUploadChecksum):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errUploadNoSpace):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
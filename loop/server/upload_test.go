@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	dir := t.TempDir()
+	st := newUploadStore(dir)
+	data := bytes.Repeat([]byte("sketch"), uploadChunkSize/4) // a chunk and a half
+	sum := sha256.Sum256(data)
+	req := UploadCreateRequest{Name: "../../design.fig", Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+
+	status, err := st.create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Name != "design.fig" || status.Offset != 0 || status.ChunkSize != uploadChunkSize {
+		t.Fatalf("created %+v", status)
+	}
+	if _, err := st.write(status.ID, 0, bytes.NewReader(data[:uploadChunkSize])); err != nil {
+		t.Fatal(err)
+	}
+
+	// Out-of-order chunks are refused.
+	if _, err := st.write(status.ID, 0, bytes.NewReader(data)); !errors.Is(err, errUploadOffset) {
+		t.Errorf("rewriting offset 0: err = %v, want errUploadOffset", err)
+	}
+
+	// A restarted server picks up where the upload left off.
+	st = newUploadStore(dir)
+	status, err = st.create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Offset != uploadChunkSize {
+		t.Fatalf("resumed at %d, want %d", status.Offset, uploadChunkSize)
+	}
+	status, err = st.write(status.ID, status.Offset, bytes.NewReader(data[uploadChunkSize:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, status.ID, "design.fig"); status.Path != want || status.Offset != status.Size {
+		t.Fatalf("finished %+v, want path %s", status, want)
+	}
+	if got, err := os.ReadFile(status.Path); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stored file differs: %v", err)
+	}
+
+	// Sending a finished file again doesn't upload it again.
+	if again, err := st.create(req); err != nil || again.Path != status.Path {
+		t.Errorf("re-creating: %+v, %v", again, err)
+	}
+}
+
+func TestUploadChecks(t *testing.T) {
+	st := newUploadStore(t.TempDir())
+	st.max = 10
+
+	if _, err := st.create(UploadCreateRequest{Name: "big", Size: 11}); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("oversized upload: err = %v", err)
+	}
+	if _, err := st.create(UploadCreateRequest{Name: "bad", Size: 5, SHA256: "xyz"}); !errors.Is(err, errUploadInvalid) {
+		t.Errorf("bad checksum format: err = %v", err)
+	}
+
+	// More data than declared is rejected, and leaves the offset alone.
+	status, err := st.create(UploadCreateRequest{Name: "f.txt", Size: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.write(status.ID, 0, strings.NewReader("too long")); !errors.Is(err, errUploadTooLarge) {
+		t.Errorf("overlong chunk: err = %v", err)
+	}
+	if s, _ := st.status(status.ID); s.Offset != 0 {
+		t.Errorf("offset after overlong chunk = %d, want 0", s.Offset)
+	}
+
+	// A checksum mismatch starts the upload over.
+	status, err = st.create(UploadCreateRequest{Name: "g.txt", Size: 5, SHA256: strings.Repeat("0", 64)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.write(status.ID, 0, strings.NewReader("hello")); !errors.Is(err, errUploadChecksum) {
+		t.Errorf("mismatched checksum: err = %v", err)
+	}
+	if s, _ := st.status(status.ID); s.Offset != 0 || s.Path != "" {
+		t.Errorf("after mismatch: %+v", s)
+	}
+
+	if _, err := st.status("nope"); !errors.Is(err, errUploadNotFound) {
+		t.Errorf("unknown upload: err = %v", err)
+	}
+}
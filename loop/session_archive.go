@@ -0,0 +1,362 @@
+package loop
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sessionArchiveVersion is the version of the archives ExportSession writes.
+const sessionArchiveVersion = 1
+
+// A SessionArchive describes a session exported by ExportSession, for
+// ImportSession to recreate elsewhere. It is the archive's manifest.json.
+type SessionArchive struct {
+	Version    int              `json:"version"`
+	SessionID  string           `json:"session_id"`
+	ExportedAt time.Time        `json:"exported_at"`
+	Branch     string           `json:"branch,omitempty"` // the agent's branch, in the bundle if the exporting repo had it
+	Commit     string           `json:"commit,omitempty"` // where the code was left, in the bundle if the exporting repo had it
+	Repo       string           `json:"repo,omitempty"`
+	Image      *ImageProvenance `json:"image,omitempty"`       // what the container was built from
+	BundleRefs []string         `json:"bundle_refs,omitempty"` // the refs in repo.bundle, if there is one
+	Artifacts  []string         `json:"artifacts,omitempty"`   // the files under artifacts/
+}
+
+// BaseImageRef is the base image the session's container was built on, pinned
+// to its digest when it was pulled from a registry; empty outside a container.
+func (a *SessionArchive) BaseImageRef() string {
+	if a.Image == nil {
+		return ""
+	}
+	if a.Image.BaseDigest == "" || strings.Contains(a.Image.BaseImage, "@") {
+		return a.Image.BaseImage
+	}
+	return a.Image.BaseImage + "@" + a.Image.BaseDigest
+}
+
+// The files in a session archive, besides artifacts/.
+const (
+	archiveManifest = "manifest.json"
+	archiveRecord   = "record.json"
+	archiveMessages = "messages.jsonl"
+	archiveBundle   = "repo.bundle"
+)
+
+// exportRef is the ref a session's commit is bundled as, when no branch holds it.
+func exportRef(sessionID string) string {
+	return "refs/sketch-export/" + sessionID
+}
+
+// ExportSession writes the session id, as recorded in dir, to w as a
+// gzipped tar: its record and messages, its artifacts, and a git bundle of its
+// branch and commit from the repository at repoRoot, if it has them. Without
+// a repoRoot, or the session's commits, the archive has no code.
+func ExportSession(ctx context.Context, w io.Writer, dir, repoRoot, id string) (*SessionArchive, error) {
+	recordPath := filepath.Join(dir, filepath.Base(id)+".json")
+	rec, err := LoadSessionRecord(recordPath)
+	if err != nil {
+		return nil, err
+	}
+	a := &SessionArchive{
+		Version:    sessionArchiveVersion,
+		SessionID:  rec.SessionID,
+		ExportedAt: time.Now().UTC(),
+		Branch:     rec.Branch,
+		Commit:     rec.Commit,
+		Repo:       rec.Repo,
+		Image:      rec.Image,
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name, src string) error {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return writeTarFile(tw, name, data)
+	}
+	if err := add(archiveRecord, recordPath); err != nil {
+		return nil, err
+	}
+	if err := add(archiveMessages, MessagesPath(recordPath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	artifacts := ArtifactsDir(rec.SessionID)
+	entries, err := os.ReadDir(artifacts)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if err := add("artifacts/"+e.Name(), filepath.Join(artifacts, e.Name())); err != nil {
+			return nil, err
+		}
+		a.Artifacts = append(a.Artifacts, e.Name())
+	}
+
+	if repoRoot != "" {
+		bundle, refs, err := bundleSession(ctx, repoRoot, rec)
+		if err != nil {
+			return nil, err
+		}
+		if bundle != "" {
+			defer os.Remove(bundle)
+			if err := add(archiveBundle, bundle); err != nil {
+				return nil, err
+			}
+			a.BundleRefs = refs
+		}
+	}
+
+	manifest, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, archiveManifest, manifest); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return a, gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// bundleSession bundles the session's branch and commit, those of them that
+// repoRoot has, returning the bundle's path and refs; no path if it has
+// neither.
+func bundleSession(ctx context.Context, repoRoot string, rec *SessionRecord) (string, []string, error) {
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = repoRoot
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	var refs []string
+	if rec.Branch != "" {
+		if _, err := git("rev-parse", "--verify", "--quiet", "refs/heads/"+rec.Branch); err == nil {
+			refs = append(refs, "refs/heads/"+rec.Branch)
+		}
+	}
+	if rec.Commit != "" {
+		// The branch may have moved on, or never been pushed here.
+		if _, err := git("cat-file", "-e", rec.Commit+"^{commit}"); err == nil {
+			ref := exportRef(rec.SessionID)
+			if _, err := git("update-ref", ref, rec.Commit); err != nil {
+				return "", nil, err
+			}
+			defer git("update-ref", "-d", ref)
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return "", nil, nil
+	}
+	f, err := os.CreateTemp("", "sketch-export-*.bundle")
+	if err != nil {
+		return "", nil, err
+	}
+	f.Close()
+	if _, err := git(append([]string{"bundle", "create", f.Name()}, refs...)...); err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), refs, nil
+}
+
+// ErrSessionExists is the error of importing a session that is already here.
+var ErrSessionExists = errors.New("the session is already on this machine")
+
+// ImportSession recreates the session exported to r: its record and messages
+// in dir, its artifacts, and its branch and commit in the repository at
+// repoRoot, under ImportRef. Unless replace is set, a session already in dir
+// fails with ErrSessionExists.
+func ImportSession(ctx context.Context, r io.Reader, dir, repoRoot string, replace bool) (*SessionArchive, error) {
+	files, err := readSessionArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	var a SessionArchive
+	if err := json.Unmarshal(files[archiveManifest], &a); err != nil {
+		return nil, fmt.Errorf("not a session archive: %w", err)
+	}
+	if a.Version != sessionArchiveVersion {
+		return nil, fmt.Errorf("session archive version %d; this sketch reads version %d", a.Version, sessionArchiveVersion)
+	}
+	if a.SessionID == "" || filepath.Base(a.SessionID) != a.SessionID {
+		return nil, fmt.Errorf("session archive: bad session id %q", a.SessionID)
+	}
+	recordPath := filepath.Join(dir, a.SessionID+".json")
+	if _, err := os.Stat(recordPath); err == nil && !replace {
+		return nil, fmt.Errorf("%s: %w", a.SessionID, ErrSessionExists)
+	}
+
+	if bundle, ok := files[archiveBundle]; ok {
+		if repoRoot == "" {
+			return nil, errors.New("importing a session's code needs a git repository")
+		}
+		if err := fetchSessionBundle(ctx, repoRoot, &a, bundle); err != nil {
+			return nil, err
+		}
+	}
+
+	artifacts := ArtifactsDir(a.SessionID)
+	for _, name := range a.Artifacts {
+		data, ok := files["artifacts/"+name]
+		if !ok || filepath.Base(name) != name {
+			continue
+		}
+		if err := os.MkdirAll(artifacts, 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(artifacts, name), data, 0o600); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if msgs, ok := files[archiveMessages]; ok {
+		if err := os.WriteFile(MessagesPath(recordPath), msgs, 0o600); err != nil {
+			return nil, err
+		}
+	}
+	// The record last: it is what makes the session resumable.
+	if err := os.WriteFile(recordPath, files[archiveRecord], 0o600); err != nil {
+		return nil, err
+	}
+	if _, err := LoadSessionRecord(recordPath); err != nil {
+		os.Remove(recordPath)
+		return nil, err
+	}
+	return &a, nil
+}
+
+// readSessionArchive reads the files of a session archive into memory.
+func readSessionArchive(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a session archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading session archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading session archive: %w", err)
+		}
+		files[path.Clean(hdr.Name)] = data
+	}
+	for _, name := range []string{archiveManifest, archiveRecord} {
+		if _, ok := files[name]; !ok {
+			return nil, fmt.Errorf("not a session archive: no %s", name)
+		}
+	}
+	return files, nil
+}
+
+// ImportRef is where ImportSession fetches ref, one of the refs bundled with
+// the session sessionID: refs/heads/sketch/fix becomes
+// refs/sketch-import/ID/heads/sketch/fix. The importer's own branches are left alone.
+func ImportRef(sessionID, ref string) string {
+	return "refs/sketch-import/" + sessionID + "/" + strings.TrimPrefix(ref, "refs/")
+}
+
+// WorkRef is the ImportRef that holds the session's work once imported: its
+// branch's, if the bundle has the branch, and otherwise its commit's.
+// onBranch reports which; ref is empty if the archive has no code.
+func (a *SessionArchive) WorkRef() (ref string, onBranch bool) {
+	if branch := "refs/heads/" + a.Branch; a.Branch != "" && slices.Contains(a.BundleRefs, branch) {
+		return ImportRef(a.SessionID, branch), true
+	}
+	if slices.Contains(a.BundleRefs, exportRef(a.SessionID)) {
+		return ImportRef(a.SessionID, exportRef(a.SessionID)), false
+	}
+	return "", false
+}
+
+// checkBundleRef reports whether ref, from an archive's manifest, is one
+// ExportSession could have bundled. The manifest is not to be trusted: a ref
+// that is an option or a refspec of its own would reach past ImportRef.
+func checkBundleRef(ctx context.Context, sessionID, ref string) error {
+	if strings.HasPrefix(ref, "-") || strings.HasPrefix(ref, "+") || strings.Contains(ref, ":") ||
+		!(strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/sketch-export/")) {
+		return fmt.Errorf("session archive: bad bundle ref %q", ref)
+	}
+	for _, r := range []string{ref, ImportRef(sessionID, ref)} {
+		if err := exec.CommandContext(ctx, "git", "check-ref-format", r).Run(); err != nil {
+			return fmt.Errorf("session archive: bad bundle ref %q", r)
+		}
+	}
+	return nil
+}
+
+// fetchSessionBundle fetches the refs in the bundle the archive carries to
+// their ImportRefs, and with them the session's commit.
+func fetchSessionBundle(ctx context.Context, repoRoot string, a *SessionArchive, bundle []byte) error {
+	var refspecs []string
+	for _, ref := range a.BundleRefs {
+		if err := checkBundleRef(ctx, a.SessionID, ref); err != nil {
+			return err
+		}
+		// Forced: the refs are the session's, and a second import only gets here with replace.
+		refspecs = append(refspecs, "+"+ref+":"+ImportRef(a.SessionID, ref))
+	}
+	f, err := os.CreateTemp("", "sketch-import-*.bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bundle); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	args := append([]string{"fetch", "--no-tags", "--", f.Name()}, refspecs...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = repoRoot
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git fetch of the session's bundle: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
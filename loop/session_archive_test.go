@@ -0,0 +1,138 @@
+package loop
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestSessionArchive(t *testing.T) {
+	ctx := context.Background()
+	git := func(dir string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	src, dst := t.TempDir(), t.TempDir()
+	git(src, "init", "-q", "-b", "main")
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("a\n"), 0o644)
+	git(src, "add", "-A")
+	git(src, "commit", "-q", "-m", "initial")
+	git(dst, "clone", "-q", src, ".")
+	git(src, "checkout", "-q", "-b", "sketch/fix")
+	os.WriteFile(filepath.Join(src, "a.txt"), []byte("fixed\n"), 0o644)
+	git(src, "commit", "-q", "-am", "fix")
+	// The session's commit is past its branch, as a snapshot of uncommitted work is.
+	os.WriteFile(filepath.Join(src, "b.txt"), []byte("wip\n"), 0o644)
+	git(src, "add", "-A")
+	git(src, "commit", "-q", "-m", "wip")
+	commit := git(src, "rev-parse", "HEAD")
+	git(src, "reset", "-q", "--soft", "HEAD~1")
+
+	id := "archive-" + filepath.Base(src)
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(ArtifactsDir(id))) })
+	srcDir := t.TempDir()
+	s, _, err := OpenFileStore(srcDir, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AppendMessage(AgentMessage{Type: UserMessageType, Content: "fix a.txt"})
+	s.SaveRecord(&SessionRecord{
+		SessionID: id,
+		Commit:    commit,
+		Branch:    "sketch/fix",
+		Image:     &ImageProvenance{BaseImage: "ghcr.io/example/base:1", BaseDigest: "sha256:beef"},
+		Messages:  []llm.Message{{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("fix a.txt")}}},
+	})
+	s.Close()
+	os.MkdirAll(ArtifactsDir(id), 0o700)
+	os.WriteFile(filepath.Join(ArtifactsDir(id), "shot.png"), []byte("png"), 0o600)
+
+	var buf bytes.Buffer
+	a, err := ExportSession(ctx, &buf, srcDir, src, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.BundleRefs) != 2 || len(a.Artifacts) != 1 {
+		t.Errorf("exported %+v", a)
+	}
+	if out, err := exec.Command("git", "-C", src, "show-ref", exportRef(id)).CombinedOutput(); err == nil {
+		t.Errorf("export left its ref behind: %s", out)
+	}
+
+	os.RemoveAll(ArtifactsDir(id))
+	dstDir := t.TempDir()
+	archive := buf.Bytes()
+	a, err = ImportSession(ctx, bytes.NewReader(archive), dstDir, dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.BaseImageRef(); got != "ghcr.io/example/base:1@sha256:beef" {
+		t.Errorf("BaseImageRef() = %q", got)
+	}
+	if got := git(dst, "rev-parse", ImportRef(id, "refs/heads/sketch/fix")); got != git(src, "rev-parse", "sketch/fix") {
+		t.Errorf("imported branch at %s", got)
+	}
+	if ref, onBranch := a.WorkRef(); ref != ImportRef(id, "refs/heads/sketch/fix") || !onBranch {
+		t.Errorf("WorkRef() = %q, %v", ref, onBranch)
+	}
+	// The branch may not have been in the exporting repository.
+	noBranch := &SessionArchive{SessionID: id, Branch: "sketch/fix", BundleRefs: []string{exportRef(id)}}
+	if ref, onBranch := noBranch.WorkRef(); ref != ImportRef(id, exportRef(id)) || onBranch {
+		t.Errorf("WorkRef() without the branch = %q, %v", ref, onBranch)
+	}
+	if out, err := exec.Command("git", "-C", dst, "show-ref", "--heads", "sketch/fix").CombinedOutput(); err == nil {
+		t.Errorf("import made a branch of its own: %s", out)
+	}
+	git(dst, "cat-file", "-e", commit)
+	rec, err := LoadSessionRecord(filepath.Join(dstDir, id+".json"))
+	if err != nil || rec.Commit != commit {
+		t.Fatalf("imported record %+v, %v", rec, err)
+	}
+	if msgs, err := LoadMessages(MessagesPath(filepath.Join(dstDir, id+".json"))); err != nil || len(msgs) != 1 {
+		t.Errorf("imported messages %+v, %v", msgs, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(ArtifactsDir(id), "shot.png")); string(data) != "png" {
+		t.Errorf("imported artifact %q, %v", data, err)
+	}
+
+	if _, err := ImportSession(ctx, bytes.NewReader(archive), dstDir, dst, false); !errors.Is(err, ErrSessionExists) {
+		t.Errorf("second import: %v", err)
+	}
+	if _, err := ImportSession(ctx, bytes.NewReader(archive), dstDir, dst, true); err != nil {
+		t.Errorf("forced import: %v", err)
+	}
+}
+
+func TestCheckBundleRef(t *testing.T) {
+	ctx := context.Background()
+	for ref, ok := range map[string]bool{
+		"refs/heads/sketch/fix":            true,
+		"refs/sketch-export/s1":            true,
+		"+refs/heads/main:refs/heads/main": false,
+		"refs/heads/main:refs/heads/main":  false,
+		"--upload-pack=touch /tmp/x":       false,
+		"refs/tags/v1":                     false,
+		"refs/heads/a..b":                  false,
+		"refs/heads/":                      false,
+	} {
+		if err := checkBundleRef(ctx, "s1", ref); (err == nil) != ok {
+			t.Errorf("checkBundleRef(%q) = %v", ref, err)
+		}
+	}
+	if err := checkBundleRef(ctx, "..", "refs/heads/main"); err == nil {
+		t.Error("session id .. makes an import ref")
+	}
+}
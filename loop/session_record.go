@@ -0,0 +1,172 @@
+package loop
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// A SessionRecord is what -resume-from needs to continue an earlier run:
+// the conversation as the model saw it, and where the code was left.
+type SessionRecord struct {
+	SessionID string           `json:"session_id"`
+	Prompt    string           `json:"prompt"`           // the run's first user message
+	Outcome   string           `json:"outcome"`          // the message that ended the run, e.g. a budget error
+	Commit    string           `json:"commit,omitempty"` // HEAD of the agent's repo when the run ended
+	Branch    string           `json:"branch,omitempty"` // the branch the agent pushed its work to
+	Repo      string           `json:"repo,omitempty"`   // the repository the agent worked in
+	Sampling  *llm.Sampling    `json:"sampling,omitempty"`
+	Image     *ImageProvenance `json:"image,omitempty"` // what the container was built from; nil outside a container
+	Messages  []llm.Message    `json:"messages"`
+	SavedAt   time.Time        `json:"saved_at"`
+	// Usage is what the session spent, which a -resume of it carries on from
+	Usage *conversation.CumulativeUsage `json:"usage,omitempty"`
+	// Rebuild is set when the session ended to move to a rebuilt container;
+	// Commit is then a snapshot of the working tree, and nothing was interrupted.
+	Rebuild bool `json:"rebuild,omitempty"`
+}
+
+// SessionRecordDir is where sessions leave their records.
+func SessionRecordDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "sessions"), nil
+}
+
+// SessionRecord captures the session so far.
+func (a *Agent) SessionRecord(ctx context.Context) (*SessionRecord, error) {
+	data, err := a.convo.DebugJSON()
+	if err != nil {
+		return nil, err
+	}
+	rec := &SessionRecord{
+		SessionID: a.config.SessionID,
+		Branch:    a.BranchName(),
+		Repo:      cmp.Or(a.config.OriginalGitOrigin, a.config.OutsideWorkingDir),
+		Sampling:  samplingOrNil(a.Sampling()),
+		Image:     a.config.Image,
+		SavedAt:   time.Now(),
+	}
+	if usage := a.convo.CumulativeUsage(); usage.Responses > 0 {
+		rec.Usage = &usage
+	}
+	if err := json.Unmarshal(data, &rec.Messages); err != nil {
+		return nil, fmt.Errorf("session record: %w", err)
+	}
+
+	a.mu.Lock()
+	for _, m := range a.history {
+		if m.HideOutput || m.ParentConversationID != nil {
+			continue
+		}
+		if m.Type == UserMessageType && rec.Prompt == "" {
+			rec.Prompt = m.Content
+		}
+		if m.EndOfTurn {
+			rec.Outcome = m.Content
+		}
+	}
+	rec.Commit, rec.Rebuild = a.rebuildCommit, a.rebuildCommit != ""
+	a.mu.Unlock()
+
+	if rec.Commit != "" {
+		return rec, nil
+	}
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd.Dir = a.repoRoot
+	if out, err := cmd.Output(); err == nil {
+		rec.Commit = strings.TrimSpace(string(out))
+	}
+	return rec, nil
+}
+
+// Save writes r to path.
+func (r *SessionRecord) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// LoadSessionRecord reads the session record at path and prepares its
+// conversation to be continued.
+func LoadSessionRecord(path string) (*SessionRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r SessionRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s is not a session record: %w", path, err)
+	}
+	if len(r.Messages) == 0 {
+		return nil, fmt.Errorf("%s has no conversation to resume", path)
+	}
+	for i := range r.Messages {
+		clearNullInputs(r.Messages[i].Content)
+	}
+	// A run stopped by its budget can end on tool calls that never ran;
+	// the model would expect their results before anything else.
+	if last := r.Messages[len(r.Messages)-1]; last.Role == llm.MessageRoleAssistant && hasToolUse(last) {
+		r.Messages = r.Messages[:len(r.Messages)-1]
+	}
+	return &r, nil
+}
+
+// clearNullInputs undoes the JSON round trip turning empty tool inputs into "null".
+func clearNullInputs(contents []llm.Content) {
+	for i := range contents {
+		if string(contents[i].ToolInput) == "null" {
+			contents[i].ToolInput = nil
+		}
+		clearNullInputs(contents[i].ToolResult)
+	}
+}
+
+func hasToolUse(m llm.Message) bool {
+	for _, c := range m.Content {
+		if c.Type == llm.ContentTypeToolUse {
+			return true
+		}
+	}
+	return false
+}
+
+// ContinuePrompt is the message that resumes r when the user doesn't supply a new one.
+func (r *SessionRecord) ContinuePrompt() string {
+	outcome := strings.TrimSpace(r.Outcome)
+	if outcome == "" {
+		return "The previous run of this session was interrupted. Continue the task from where you left off."
+	}
+	return fmt.Sprintf("The previous run of this session stopped with: %s\n\nContinue the task from where you left off.", outcome)
+}
+
+// saveSessionRecord saves the session's record to its store, at the end of a turn.
+func (a *Agent) saveSessionRecord(ctx context.Context) {
+	if a.convo == nil {
+		return // before Init, there is no conversation to record
+	}
+	rec, err := a.SessionRecord(ctx)
+	if err == nil {
+		err = a.config.Store.SaveRecord(rec)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "saving session record", "error", err)
+	}
+}
@@ -0,0 +1,62 @@
+package loop
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestSessionRecordRoundTrip(t *testing.T) {
+	rec := &SessionRecord{
+		SessionID: "s1",
+		Prompt:    "fix the flaky test",
+		Outcome:   "budget exceeded",
+		Messages: []llm.Message{
+			{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("fix the flaky test")}},
+			{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+				llm.StringContent("Running it."),
+				{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "bash", ToolInput: json.RawMessage(`{"command":"go test"}`)},
+			}},
+			{Role: llm.MessageRoleUser, Content: []llm.Content{{Type: llm.ContentTypeToolResult, ToolUseID: "t1", ToolResult: []llm.Content{llm.StringContent("FAIL")}}}},
+			// The run stopped before this tool call ran.
+			{Role: llm.MessageRoleAssistant, Content: []llm.Content{
+				{Type: llm.ContentTypeToolUse, ID: "t2", ToolName: "bash", ToolInput: json.RawMessage(`{"command":"go test -count=10"}`)},
+			}},
+		},
+	}
+	path := filepath.Join(t.TempDir(), "s1.json")
+	if err := rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadSessionRecord(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Messages) != 3 {
+		t.Fatalf("got %d messages, want the dangling tool call dropped", len(got.Messages))
+	}
+	if in := got.Messages[0].Content[0].ToolInput; in != nil {
+		t.Errorf("text content has tool input %q", in)
+	}
+	var in bytes.Buffer
+	if err := json.Compact(&in, got.Messages[1].Content[1].ToolInput); err != nil || in.String() != `{"command":"go test"}` {
+		t.Errorf("tool input = %q, %v", in.String(), err)
+	}
+	if p := got.ContinuePrompt(); !strings.Contains(p, "budget exceeded") {
+		t.Errorf("ContinuePrompt() = %q", p)
+	}
+}
+
+func TestLoadSessionRecordEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.json")
+	if err := (&SessionRecord{SessionID: "s1"}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSessionRecord(path); err == nil {
+		t.Error("loading a record without messages should fail")
+	}
+}
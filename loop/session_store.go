@@ -0,0 +1,132 @@
+package loop
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A SessionStore keeps a session as it goes, so that it outlives the process
+// that ran it: each message as the agent records it, and, at the end of each
+// turn, the SessionRecord that -resume continues from.
+type SessionStore interface {
+	// AppendMessage records m, the next of the session's messages.
+	AppendMessage(m AgentMessage) error
+	// SaveRecord replaces the session's record with r.
+	SaveRecord(r *SessionRecord) error
+	Close() error
+}
+
+// FileStore is the SessionStore of a directory, SessionRecordDir by default:
+// a session's record is ID.json, as the session records of earlier sketch
+// versions are, and its messages are appended to ID.messages.jsonl.
+type FileStore struct {
+	dir, id string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenFileStore opens, creating it if need be, the store of the session id in
+// dir, and returns the messages it already holds, from an earlier run of the
+// session.
+func OpenFileStore(dir, id string) (*FileStore, []AgentMessage, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, nil, fmt.Errorf("bad session id %q", id)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, nil, err
+	}
+	s := &FileStore{dir: dir, id: id}
+	msgs, err := LoadMessages(s.MessagesPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.f, err = os.OpenFile(s.MessagesPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+		return nil, nil, err
+	}
+	return s, msgs, nil
+}
+
+// RecordPath is where s keeps the session's record.
+func (s *FileStore) RecordPath() string {
+	return filepath.Join(s.dir, s.id+".json")
+}
+
+// MessagesPath is where s keeps the session's messages.
+func (s *FileStore) MessagesPath() string {
+	return MessagesPath(s.RecordPath())
+}
+
+// MessagesPath is the messages file next to the session record at recordPath.
+func MessagesPath(recordPath string) string {
+	ext := filepath.Ext(recordPath)
+	return recordPath[:len(recordPath)-len(ext)] + ".messages.jsonl"
+}
+
+func (s *FileStore) AppendMessage(m AgentMessage) error {
+	line, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+// SaveRecord writes r to a temporary file and renames it into place, so that
+// a crash mid-write leaves the previous record.
+func (s *FileStore) SaveRecord(r *SessionRecord) error {
+	tmp := s.RecordPath() + ".tmp"
+	if err := r.Save(tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.RecordPath())
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// LoadMessages reads the messages file at path; a missing file has none. A
+// last line cut short, by a crash mid-write, is dropped.
+func LoadMessages(path string) ([]AgentMessage, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var msgs []AgentMessage
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 64<<20)
+	for n := 1; sc.Scan(); n++ {
+		var m AgentMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			if n == bytes.Count(data, []byte("\n"))+1 {
+				break
+			}
+			return nil, fmt.Errorf("%s: line %d: %w", path, n, err)
+		}
+		m.Idx = len(msgs)
+		msgs = append(msgs, m)
+	}
+	return msgs, sc.Err()
+}
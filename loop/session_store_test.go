@@ -0,0 +1,69 @@
+package loop
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s, msgs, err := OpenFileStore(dir, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("a new session has %d messages", len(msgs))
+	}
+	for _, m := range []AgentMessage{
+		{Type: UserMessageType, Content: "fix the flaky test", Idx: 0},
+		{Type: AgentMessageType, Content: "Fixed.", EndOfTurn: true, Idx: 1},
+	} {
+		if err := s.AppendMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rec := &SessionRecord{
+		SessionID: "s1",
+		Commit:    "abc123",
+		Messages:  []llm.Message{{Role: llm.MessageRoleUser, Content: []llm.Content{llm.StringContent("fix the flaky test")}}},
+		Usage:     &conversation.CumulativeUsage{Responses: 1, TotalCostUSD: 0.25},
+	}
+	if err := s.SaveRecord(rec); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash mid-write leaves a partial last line.
+	f, err := os.OpenFile(s.MessagesPath(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"agent","content":"Half`)
+	f.Close()
+
+	s, msgs, err = OpenFileStore(dir, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if len(msgs) != 2 || msgs[1].Content != "Fixed." || msgs[1].Idx != 1 {
+		t.Errorf("reopened messages = %+v", msgs)
+	}
+	got, err := LoadSessionRecord(filepath.Join(dir, "s1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Commit != "abc123" || got.Usage == nil || got.Usage.TotalCostUSD != 0.25 {
+		t.Errorf("record = %+v", got)
+	}
+
+	if _, _, err := OpenFileStore(dir, "../s1"); err == nil {
+		t.Error("opened a store outside dir")
+	}
+}
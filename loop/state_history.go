@@ -0,0 +1,87 @@
+package loop
+
+import (
+	"time"
+)
+
+// maxStateVisits bounds the state history kept for StateHistory; a busy turn
+// enters a few dozen states.
+const maxStateVisits = 5000
+
+// A StateVisit is one stay of the state machine in a state.
+type StateVisit struct {
+	Turn     int           `json:"turn"` // 0 before the first turn
+	State    string        `json:"state"`
+	Reason   string        `json:"reason"` // why the machine entered the state
+	Entered  time.Time     `json:"entered"`
+	Exited   *time.Time    `json:"exited,omitempty"` // nil for the current state
+	Duration time.Duration `json:"duration"`         // so far, for the current state
+}
+
+type stateVisit struct {
+	turn    int
+	state   State
+	reason  string
+	entered time.Time
+}
+
+// recordVisit records entering the current state, at stateEnteredAt.
+// Entering StateWaitingForUserInput starts a turn. The caller must hold mu.
+func (sm *StateMachine) recordVisit(reason string) {
+	if sm.currentState == StateWaitingForUserInput {
+		sm.turn++
+	}
+	sm.visits = append(sm.visits, stateVisit{turn: sm.turn, state: sm.currentState, reason: reason, entered: sm.stateEnteredAt})
+	if len(sm.visits) > maxStateVisits {
+		sm.visits = sm.visits[len(sm.visits)-maxStateVisits:]
+	}
+}
+
+// Turn returns the number of the current turn, counting from 1.
+func (sm *StateMachine) Turn() int {
+	if sm == nil {
+		return 0
+	}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.turn
+}
+
+// StateHistory returns the states the machine went through, oldest first, with
+// how long it stayed in each.
+func (sm *StateMachine) StateHistory() []StateVisit {
+	if sm == nil {
+		return nil
+	}
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	now := time.Now()
+	out := make([]StateVisit, 0, len(sm.visits))
+	for i, v := range sm.visits {
+		sv := StateVisit{Turn: v.turn, State: v.state.String(), Reason: v.reason, Entered: v.entered.UTC()}
+		if i+1 < len(sm.visits) {
+			exited := sm.visits[i+1].entered.UTC()
+			sv.Exited = &exited
+			sv.Duration = exited.Sub(v.entered)
+		} else {
+			sv.Duration = now.Sub(v.entered)
+		}
+		out = append(out, sv)
+	}
+	return out
+}
+
+// StateDurations adds up how long turn spent in each state among visits, by state name.
+func StateDurations(visits []StateVisit, turn int) map[string]time.Duration {
+	var durations map[string]time.Duration
+	for _, v := range visits {
+		if v.Turn != turn {
+			continue
+		}
+		if durations == nil {
+			durations = make(map[string]time.Duration)
+		}
+		durations[v.State] += v.Duration
+	}
+	return durations
+}
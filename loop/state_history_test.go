@@ -0,0 +1,46 @@
+package loop
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStateHistory(t *testing.T) {
+	ctx := context.Background()
+	sm := NewStateMachine()
+	for turn := 1; turn <= 2; turn++ {
+		for _, s := range []State{StateWaitingForUserInput, StateSendingToLLM, StateProcessingLLMResponse, StateEndOfTurn} {
+			if err := sm.Transition(ctx, s, "to "+s.String()); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	sm.ForceTransition(ctx, StateCancelled, "test")
+
+	visits := sm.StateHistory()
+	if len(visits) != 10 {
+		t.Fatalf("got %d visits, want 10: %+v", len(visits), visits)
+	}
+	if v := visits[0]; v.Turn != 0 || v.State != "Ready" || v.Exited == nil {
+		t.Errorf("first visit = %+v", v)
+	}
+	if v := visits[5]; v.Turn != 2 || v.State != "WaitingForUserInput" || v.Reason != "to WaitingForUserInput" || v.Duration < time.Millisecond {
+		t.Errorf("second turn's first visit = %+v", v)
+	}
+	if v := visits[9]; v.Turn != 2 || v.State != "Cancelled" || v.Exited != nil || v.Reason != "Forced transition: test" {
+		t.Errorf("current visit = %+v", v)
+	}
+	if sm.Turn() != 2 {
+		t.Errorf("Turn = %d, want 2", sm.Turn())
+	}
+
+	durations := StateDurations(visits, 1)
+	if len(durations) != 4 || durations["SendingToLLM"] != visits[2].Duration {
+		t.Errorf("turn 1 durations = %v", durations)
+	}
+	if durations := StateDurations(visits, 3); durations != nil {
+		t.Errorf("durations of a turn not yet started = %v", durations)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
 	"sync"
 	"time"
 )
@@ -49,6 +50,9 @@ const (
 	StateError
 	// StateCompacting occurs when the agent is compacting the conversation
 	StateCompacting
+	// StateAwaitingCostConfirmation occurs when a request to the LLM would cost more
+	// than the confirmation threshold, and is held until the user says to go ahead
+	StateAwaitingCostConfirmation
 )
 
 // TransitionEvent represents an event that causes a state transition
@@ -84,6 +88,10 @@ type StateMachine struct {
 	history []StateTransition
 	// maxHistorySize limits the number of transitions to keep in history
 	maxHistorySize int
+	// visits records the states entered, by turn, for StateHistory
+	visits []stateVisit
+	// turn counts the turns started, by entering StateWaitingForUserInput
+	turn int
 	// eventListeners are notified when state transitions occur
 	eventListeners []chan<- StateTransition
 	// onTransition is a callback function that's called when a transition occurs
@@ -103,6 +111,7 @@ func NewStateMachine() *StateMachine {
 
 	// Initialize valid transitions
 	sm.initTransitions()
+	sm.recordVisit("")
 
 	return sm
 }
@@ -191,9 +200,9 @@ func (sm *StateMachine) initTransitions() {
 	addTransition(StateReady, StateWaitingForUserInput)
 
 	// Main flow
-	addTransition(StateWaitingForUserInput, StateSendingToLLM, StateCompacting, StateError)
+	addTransition(StateWaitingForUserInput, StateSendingToLLM, StateCompacting, StateAwaitingCostConfirmation, StateEndOfTurn, StateError)
 	addTransition(StateSendingToLLM, StateProcessingLLMResponse, StateError)
-	addTransition(StateProcessingLLMResponse, StateEndOfTurn, StateToolUseRequested, StateError)
+	addTransition(StateProcessingLLMResponse, StateEndOfTurn, StateToolUseRequested, StateCompacting, StateError)
 	addTransition(StateEndOfTurn, StateWaitingForUserInput)
 
 	// Tool use flow
@@ -203,15 +212,16 @@ func (sm *StateMachine) initTransitions() {
 	addTransition(StateCheckingGitCommits, StateRunningAutoformatters, StateCheckingBudget)
 	addTransition(StateRunningAutoformatters, StateCheckingBudget)
 	addTransition(StateCheckingBudget, StateGatheringAdditionalMessages, StateBudgetExceeded)
-	addTransition(StateGatheringAdditionalMessages, StateSendingToolResults, StateError)
+	addTransition(StateGatheringAdditionalMessages, StateSendingToolResults, StateAwaitingCostConfirmation, StateError)
 	addTransition(StateSendingToolResults, StateProcessingLLMResponse, StateError)
 
 	// Compaction flow
-	addTransition(StateCompacting, StateWaitingForUserInput, StateError)
+	addTransition(StateCompacting, StateWaitingForUserInput, StateEndOfTurn, StateProcessingLLMResponse, StateError)
 
 	// Terminal states to new turn
 	addTransition(StateCancelled, StateWaitingForUserInput)
 	addTransition(StateBudgetExceeded, StateWaitingForUserInput)
+	addTransition(StateAwaitingCostConfirmation, StateWaitingForUserInput)
 	addTransition(StateError, StateWaitingForUserInput)
 }
 
@@ -271,6 +281,7 @@ func (sm *StateMachine) TransitionWithEvent(ctx context.Context, newState State,
 	sm.previousState = sm.currentState
 	sm.currentState = newState
 	sm.stateEnteredAt = time.Now()
+	sm.recordVisit(event.Description)
 
 	// Add to history
 	sm.history = append(sm.history, transition)
@@ -322,6 +333,25 @@ func (sm *StateMachine) TransitionWithEvent(ctx context.Context, newState State,
 	return nil
 }
 
+// ReportProgress tells transition listeners about progress within the current
+// state, such as a running tool's own steps, without changing state. Listeners get
+// a StateTransition from the current state to itself carrying event; it is not
+// recorded in History.
+func (sm *StateMachine) ReportProgress(ctx context.Context, event TransitionEvent) {
+	sm.mu.RLock()
+	transition := StateTransition{From: sm.currentState, To: sm.currentState, Event: event}
+	eventListenersCopy := slices.Clone(sm.eventListeners)
+	sm.mu.RUnlock()
+
+	for _, ch := range eventListenersCopy {
+		select {
+		case ch <- transition:
+		default:
+			// Progress is superseded by the next report anyway.
+		}
+	}
+}
+
 // CurrentState returns the current state
 func (sm *StateMachine) CurrentState() State {
 	sm.mu.RLock()
@@ -373,6 +403,7 @@ func (sm *StateMachine) Reset() {
 	sm.currentState = StateReady
 	sm.previousState = StateUnknown
 	sm.stateEnteredAt = time.Now()
+	sm.recordVisit("Reset")
 }
 
 // IsInTerminalState returns whether the current state is a terminal state
@@ -381,7 +412,7 @@ func (sm *StateMachine) IsInTerminalState() bool {
 	defer sm.mu.RUnlock()
 
 	switch sm.currentState {
-	case StateEndOfTurn, StateCancelled, StateBudgetExceeded, StateError:
+	case StateEndOfTurn, StateCancelled, StateBudgetExceeded, StateAwaitingCostConfirmation, StateError:
 		return true
 	default:
 		return false
@@ -425,6 +456,7 @@ func (sm *StateMachine) ForceTransition(ctx context.Context, newState State, rea
 	sm.previousState = sm.currentState
 	sm.currentState = newState
 	sm.stateEnteredAt = time.Now()
+	sm.recordVisit(event.Description)
 
 	// Add to history
 	sm.history = append(sm.history, transition)
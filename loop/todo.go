@@ -0,0 +1,48 @@
+package loop
+
+import (
+	"context"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/i18n"
+)
+
+// todosEditedNote goes along with the next user message after EditTodos,
+// so that a plan seeded from outside is followed rather than overwritten.
+const todosEditedNote = "The todo list was changed from outside this conversation. Read it with todo_read and follow it, keeping its task ids."
+
+// Todos returns the session's todo list.
+func (a *Agent) Todos() (claudetool.TodoList, error) {
+	return claudetool.ReadTodoList(a.config.SessionID)
+}
+
+// EditTodos applies edit to the session's todo list and saves the result,
+// for integrations that plan work outside the conversation. Edits are
+// serialized with each other, not with the agent's own todo_write calls,
+// which replace the whole list anyway.
+//
+// The change is announced with a message carrying the new list, so clients
+// showing the list from the message stream stay current, and the model is
+// told about it along with the next user message.
+func (a *Agent) EditTodos(ctx context.Context, edit func(*claudetool.TodoList) error) (claudetool.TodoList, error) {
+	a.todoMu.Lock()
+	defer a.todoMu.Unlock()
+	list, err := a.Todos()
+	if err != nil {
+		return list, err
+	}
+	if err := edit(&list); err != nil {
+		return list, err
+	}
+	if err := claudetool.WriteTodoList(a.config.SessionID, list); err != nil {
+		return list, err
+	}
+	a.todosEdited.Store(true)
+	content := a.CurrentTodoContent()
+	a.pushToOutbox(ctx, AgentMessage{
+		Type:        AutoMessageType,
+		Content:     a.localize(i18n.TodosEdited, len(list.Items)),
+		TodoContent: &content,
+	})
+	return list, nil
+}
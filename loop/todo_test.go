@@ -0,0 +1,47 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/claudetool"
+)
+
+func TestEditTodos(t *testing.T) {
+	sessionID := "edit-todos-" + filepath.Base(t.TempDir())
+	t.Cleanup(func() { os.RemoveAll(filepath.Dir(claudetool.TodoFilePath(sessionID))) })
+	a := &Agent{config: AgentConfig{SessionID: sessionID}}
+	ctx := context.Background()
+
+	list, err := a.EditTodos(ctx, func(l *claudetool.TodoList) error {
+		l.Items = append(l.Items, claudetool.TodoItem{ID: "plan", Task: "Plan the work", Status: "queued"})
+		return nil
+	})
+	if err != nil || len(list.Items) != 1 {
+		t.Fatalf("EditTodos = %+v, %v", list, err)
+	}
+	if got, _ := a.Todos(); len(got.Items) != 1 || got.Items[0].ID != "plan" {
+		t.Errorf("Todos() = %+v", got)
+	}
+	if len(a.history) != 1 || a.history[0].TodoContent == nil || !strings.Contains(*a.history[0].TodoContent, `"plan"`) {
+		t.Errorf("no message announcing the new list: %+v", a.history)
+	}
+	if !a.todosEdited.Load() {
+		t.Error("the model won't hear about the edit")
+	}
+
+	// Invalid lists aren't saved.
+	_, err = a.EditTodos(ctx, func(l *claudetool.TodoList) error {
+		l.Items = append(l.Items, claudetool.TodoItem{ID: "plan", Task: "Again", Status: "queued"})
+		return nil
+	})
+	if err == nil {
+		t.Error("saved a list with a duplicate id")
+	}
+	if got, _ := a.Todos(); len(got.Items) != 1 {
+		t.Errorf("after a failed edit, Todos() = %+v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package loop
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// Tool groups that can be enabled or disabled as a whole, in addition to individual tool names.
+const (
+	ToolGroupBrowser = "browser" // browser_* tools and read_image
+	ToolGroupMCP     = "mcp"     // every tool from configured MCP servers
+)
+
+// requiredTools can't be disabled: the agent loop can't end a turn without them.
+var requiredTools = []string{"done"}
+
+// A ToolFilter restricts which tools are registered for a session.
+// A nil *ToolFilter allows every tool.
+type ToolFilter struct {
+	enabled  []string // if non-nil, only these tools and groups are allowed
+	disabled []string
+}
+
+// ParseToolFilter parses the -enable-tools and -disable-tools flags: comma-separated
+// tool names or groups ("browser", "mcp"). An empty enable list allows every tool
+// not disabled. It returns nil if neither restricts anything.
+func ParseToolFilter(enable, disable string) (*ToolFilter, error) {
+	f := &ToolFilter{enabled: splitToolList(enable), disabled: splitToolList(disable)}
+	for _, name := range f.disabled {
+		if slices.Contains(requiredTools, name) {
+			return nil, fmt.Errorf("the %s tool can't be disabled", name)
+		}
+	}
+	if f.enabled == nil && f.disabled == nil {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func splitToolList(s string) []string {
+	var names []string
+	for name := range strings.SplitSeq(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Allows reports whether the tool with the given name, belonging to group
+// (which may be empty), may be registered.
+func (f *ToolFilter) Allows(name, group string) bool {
+	if f == nil || slices.Contains(requiredTools, name) {
+		return true
+	}
+	if slices.Contains(f.disabled, name) || (group != "" && slices.Contains(f.disabled, group)) {
+		return false
+	}
+	if f.enabled == nil {
+		return true
+	}
+	return slices.Contains(f.enabled, name) || (group != "" && slices.Contains(f.enabled, group))
+}
+
+// AllowsGroup reports whether group is not disabled as a whole, so callers can
+// skip setting it up (e.g. connecting to MCP servers). Its tools are still
+// subject to Allows.
+func (f *ToolFilter) AllowsGroup(group string) bool {
+	return f == nil || !slices.Contains(f.disabled, group)
+}
+
+// filter returns the tools in group that f allows, and the names of those it doesn't.
+func (f *ToolFilter) filter(group string, tools []*llm.Tool) (kept []*llm.Tool, removed []string) {
+	if f == nil {
+		return tools, nil
+	}
+	for _, t := range tools {
+		if f.Allows(t.Name, group) {
+			kept = append(kept, t)
+		} else {
+			removed = append(removed, t.Name)
+		}
+	}
+	return kept, removed
+}
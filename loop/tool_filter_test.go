@@ -0,0 +1,103 @@
+package loop
+
+import (
+	"slices"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestToolFilter(t *testing.T) {
+	tools := func(names ...string) []*llm.Tool {
+		var ts []*llm.Tool
+		for _, n := range names {
+			ts = append(ts, &llm.Tool{Name: n})
+		}
+		return ts
+	}
+	names := func(ts []*llm.Tool) []string {
+		var ns []string
+		for _, t := range ts {
+			ns = append(ns, t.Name)
+		}
+		return ns
+	}
+
+	tests := []struct {
+		name            string
+		enable, disable string
+		group           string
+		in              []string
+		want            []string
+		allowsMCP       bool
+	}{
+		{
+			name:      "no filter",
+			in:        []string{"bash", "done"},
+			want:      []string{"bash", "done"},
+			allowsMCP: true,
+		},
+		{
+			name:      "disable by name",
+			disable:   "bash, patch",
+			in:        []string{"bash", "patch", "think", "done"},
+			want:      []string{"think", "done"},
+			allowsMCP: true,
+		},
+		{
+			name:    "disable group",
+			disable: "browser,mcp",
+			group:   ToolGroupBrowser,
+			in:      []string{"browser_navigate", "read_image"},
+		},
+		{
+			name:      "allowlist keeps required tools",
+			enable:    "think",
+			in:        []string{"bash", "think", "done"},
+			want:      []string{"think", "done"},
+			allowsMCP: true,
+		},
+		{
+			name:      "allowlist by group",
+			enable:    "browser",
+			group:     ToolGroupBrowser,
+			in:        []string{"browser_navigate", "read_image"},
+			want:      []string{"browser_navigate", "read_image"},
+			allowsMCP: true,
+		},
+		{
+			name:      "disable wins over enable",
+			enable:    "browser",
+			disable:   "browser_eval",
+			group:     ToolGroupBrowser,
+			in:        []string{"browser_navigate", "browser_eval"},
+			want:      []string{"browser_navigate"},
+			allowsMCP: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseToolFilter(tt.enable, tt.disable)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kept, removed := f.filter(tt.group, tools(tt.in...))
+			if got := names(kept); !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+			if len(kept)+len(removed) != len(tt.in) {
+				t.Errorf("kept %v and removed %v don't add up to %v", names(kept), removed, tt.in)
+			}
+			if got := f.AllowsGroup(ToolGroupMCP); got != tt.allowsMCP {
+				t.Errorf("AllowsGroup(mcp) = %v, want %v", got, tt.allowsMCP)
+			}
+		})
+	}
+
+	if f, err := ParseToolFilter("", " , "); f != nil || err != nil {
+		t.Errorf("empty lists: got %v, %v; want nil filter", f, err)
+	}
+	if _, err := ParseToolFilter("", "done"); err == nil {
+		t.Error("disabling done should fail")
+	}
+}
@@ -0,0 +1,46 @@
+package loop
+
+import (
+	"context"
+
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/i18n"
+)
+
+// ToolPermissions returns the session's tool permissions, including the
+// decisions the user applied to every later call of a tool.
+func (a *Agent) ToolPermissions() permission.Policy {
+	return a.permissions.Policy()
+}
+
+// PendingApprovals returns the tool calls waiting for the user's approval,
+// oldest first. The agent's turn doesn't go on until they are decided.
+func (a *Agent) PendingApprovals() []permission.ApprovalRequest {
+	return a.permissions.Pending()
+}
+
+// DecideApproval approves or denies the tool call waiting with the given id.
+func (a *Agent) DecideApproval(ctx context.Context, id string, d permission.Decision) error {
+	return a.permissions.Decide(id, d)
+}
+
+// askApproval tells the user that a tool call waits for their approval.
+func (a *Agent) askApproval(ctx context.Context, req permission.ApprovalRequest) {
+	a.pushToOutbox(ctx, AgentMessage{
+		Type:       ApprovalMessageType,
+		Content:    a.localize(i18n.ApprovalNeeded, req.Tool),
+		ToolName:   req.Tool,
+		ToolInput:  req.Input,
+		ToolCallId: req.ToolCallID,
+		Approval:   &req,
+	})
+}
+
+// approvalDecided tells the user what became of a tool call that waited for them.
+func (a *Agent) approvalDecided(ctx context.Context, req permission.ApprovalRequest, d permission.Decision) {
+	key := i18n.ApprovalDenied
+	if d.Approve {
+		key = i18n.ApprovalGranted
+	}
+	a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(key, req.Tool)})
+}
@@ -0,0 +1,53 @@
+package loop
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/llm"
+)
+
+func TestToolPermissions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	a := NewAgent(AgentConfig{ToolPermissions: permission.Policy{"bash": permission.Ask}})
+	lastMessage := func() AgentMessage {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if len(a.history) == 0 {
+			return AgentMessage{}
+		}
+		return a.history[len(a.history)-1]
+	}
+	bash := a.permissions.Wrap(&llm.Tool{Name: "bash", Run: func(context.Context, json.RawMessage) llm.ToolOut {
+		return llm.ToolOut{LLMContent: llm.TextContent("ran")}
+	}})
+
+	done := make(chan llm.ToolOut)
+	go func() { done <- bash.Run(ctx, json.RawMessage(`{"command":"make deploy"}`)) }()
+	for lastMessage().Type != ApprovalMessageType && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	if m := lastMessage(); m.Approval == nil || m.ToolInput != `{"command":"make deploy"}` {
+		t.Fatalf("the user was asked with %+v", m)
+	}
+	pending := a.PendingApprovals()
+	if len(pending) != 1 {
+		t.Fatalf("pending = %+v", pending)
+	}
+	if err := a.DecideApproval(ctx, pending[0].ID, permission.Decision{Comment: "not from here"}); err != nil {
+		t.Fatal(err)
+	}
+	if out := <-done; out.Error == nil {
+		t.Error("a denied bash call ran")
+	}
+	if m := lastMessage(); m.Type != AutoMessageType {
+		t.Errorf("the denial was reported with %+v", m)
+	}
+	if len(a.PendingApprovals()) != 0 {
+		t.Error("the denied call is still pending")
+	}
+}
@@ -0,0 +1,82 @@
+package loop
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"sketch.dev/claudetool"
+	"sketch.dev/llm/conversation"
+)
+
+// ToolCallProgress is the latest progress a running tool call reported.
+type ToolCallProgress struct {
+	ToolCallID string `json:"tool_call_id"`
+	ToolName   string `json:"tool_name"`
+	Label      string `json:"label"`
+	Done       int    `json:"done,omitempty"`
+	Total      int    `json:"total,omitempty"`
+}
+
+// String formats p for a status line, e.g. "running tests 34/120".
+func (p ToolCallProgress) String() string {
+	switch {
+	case p.Total > 0:
+		return fmt.Sprintf("%s %d/%d", p.Label, p.Done, p.Total)
+	case p.Done > 0:
+		return fmt.Sprintf("%s %d", p.Label, p.Done)
+	}
+	return p.Label
+}
+
+// maxProgressLabel keeps a chatty tool from filling the status line.
+const maxProgressLabel = 120
+
+// reportToolProgress is the progress reporter tools run with. It records the
+// progress of the calling tool and passes it on as a progress event of the
+// RunningTool state, so that clients following state transitions refresh.
+func (a *Agent) reportToolProgress(ctx context.Context, p claudetool.ToolProgress) {
+	id := conversation.ToolCallInfoFromContext(ctx).ToolUseID
+	if id == "" {
+		return
+	}
+	label := strings.TrimSpace(p.Label)
+	if r := []rune(label); len(r) > maxProgressLabel {
+		label = string(r[:maxProgressLabel-1]) + "…"
+	}
+	a.mu.Lock()
+	name, running := a.outstandingToolCalls[id]
+	if !running {
+		a.mu.Unlock()
+		return // the call finished before its report arrived
+	}
+	tp := ToolCallProgress{ToolCallID: id, ToolName: name, Label: label, Done: p.Done, Total: p.Total}
+	if a.toolProgress == nil {
+		a.toolProgress = make(map[string]ToolCallProgress)
+	}
+	a.toolProgress[id] = tp
+	a.mu.Unlock()
+
+	a.stateMachine.ReportProgress(ctx, TransitionEvent{
+		Description: name + ": " + tp.String(),
+		Data:        tp,
+		Timestamp:   time.Now(),
+	})
+}
+
+// ToolProgress returns the progress reported by the tool calls still running,
+// ordered by tool call ID.
+func (a *Agent) ToolProgress() []ToolCallProgress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var out []ToolCallProgress
+	for id, p := range a.toolProgress {
+		if _, running := a.outstandingToolCalls[id]; running {
+			out = append(out, p)
+		}
+	}
+	slices.SortFunc(out, func(x, y ToolCallProgress) int { return strings.Compare(x.ToolCallID, y.ToolCallID) })
+	return out
+}
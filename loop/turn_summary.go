@@ -0,0 +1,89 @@
+package loop
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
+)
+
+// A Milestone marks a completed turn, so that long sessions can be skimmed
+// one line per turn.
+type Milestone struct {
+	FirstIdx int    `json:"first_idx"` // Idx of the user message that started the turn
+	LastIdx  int    `json:"last_idx"`  // Idx of the turn's end-of-turn message
+	Summary  string `json:"summary"`   // one line describing what the turn accomplished
+}
+
+const turnSummaryPrompt = `Summarize what you accomplished in your most recent turn (since my last message) in a single line of at most 15 words, such as "Fixed the flaky login test by waiting for the session cookie".
+Describe outcomes, not process. Do not use any tools. Reply with only the summary.`
+
+// lastTurn returns the range of the turn that just ended: from the most recent
+// user message to the end-of-turn message that closed it. Messages pushed after
+// the end of the turn, such as commits, are skipped.
+func (a *Agent) lastTurn() (first, last int, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	last = -1
+	for i := len(a.history) - 1; i >= 0; i-- {
+		m := a.history[i]
+		if m.HideOutput || m.ParentConversationID != nil {
+			continue
+		}
+		switch {
+		case m.Type == UserMessageType:
+			if last < 0 {
+				return 0, 0, false
+			}
+			return i, last, true
+		case last < 0 && m.Type == AgentMessageType && m.EndOfTurn:
+			last = i
+		}
+	}
+	return 0, 0, false
+}
+
+// summarizeTurn records a one-line summary of the turn that just ended as a milestone.
+// The summary is generated in a hidden subconversation that shares the main
+// conversation's system prompt, tools, and history, so the request reads almost
+// entirely from the prompt cache. It must be called between turns, while the
+// main conversation is idle; the model call itself runs in the background.
+func (a *Agent) summarizeTurn(ctx context.Context) {
+	first, last, ok := a.lastTurn()
+	if !ok {
+		return
+	}
+	main, ok := a.convo.(*conversation.Convo)
+	if !ok {
+		return // mock convo
+	}
+	convo := main.SubConvoWithHistory()
+	convo.SystemPrompt = main.SystemPrompt
+	convo.Tools = main.Tools
+	convo.Hidden = true
+	convo.Purpose = "turn summary"
+
+	prompt := turnSummaryPrompt
+	if a.config.Language != "" {
+		prompt += "\nWrite the summary in " + a.config.Language + "."
+	}
+	go func() {
+		resp, err := convo.SendMessage(llm.UserStringMessage(prompt))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to summarize turn", "error", err)
+			return
+		}
+		summary := strings.TrimSpace(collectTextContent(resp))
+		summary, _, _ = strings.Cut(summary, "\n")
+		if summary == "" {
+			return
+		}
+		a.pushToOutbox(ctx, AgentMessage{
+			Type:      MilestoneMessageType,
+			Content:   summary,
+			Milestone: &Milestone{FirstIdx: first, LastIdx: last, Summary: summary},
+		})
+	}()
+}
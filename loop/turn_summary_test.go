@@ -0,0 +1,49 @@
+package loop
+
+import "testing"
+
+func TestLastTurn(t *testing.T) {
+	parent := "main"
+	tests := []struct {
+		name        string
+		history     []AgentMessage
+		first, last int
+		ok          bool
+	}{
+		{
+			name: "completed turn",
+			history: []AgentMessage{
+				{Type: UserMessageType},
+				{Type: AgentMessageType, EndOfTurn: true},
+				{Type: UserMessageType},
+				{Type: AgentMessageType},
+				{Type: ToolUseMessageType},
+				{Type: AgentMessageType, EndOfTurn: true},
+				{Type: CommitMessageType},
+				{Type: AgentMessageType, HideOutput: true},
+				{Type: AgentMessageType, ParentConversationID: &parent},
+			},
+			first: 2, last: 5, ok: true,
+		},
+		{
+			name: "turn ended in an error",
+			history: []AgentMessage{
+				{Type: UserMessageType},
+				{Type: ErrorMessageType, EndOfTurn: true},
+			},
+		},
+		{
+			name:    "no user message",
+			history: []AgentMessage{{Type: AgentMessageType, EndOfTurn: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Agent{history: tt.history}
+			first, last, ok := a.lastTurn()
+			if ok != tt.ok || first != tt.first || last != tt.last {
+				t.Errorf("lastTurn() = %d, %d, %v; want %d, %d, %v", first, last, ok, tt.first, tt.last, tt.ok)
+			}
+		})
+	}
+}
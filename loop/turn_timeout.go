@@ -0,0 +1,98 @@
+package loop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// turnTimeoutError is the cancellation cause used when a turn runs past its wall-clock limit.
+type turnTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *turnTimeoutError) Error() string {
+	return fmt.Sprintf("turn exceeded time limit of %s", e.timeout)
+}
+
+// turnTimedOut reports whether ctx was cancelled because the turn ran too long.
+func turnTimedOut(ctx context.Context) (*turnTimeoutError, bool) {
+	var tte *turnTimeoutError
+	if errors.As(context.Cause(ctx), &tte) {
+		return tte, true
+	}
+	return nil, false
+}
+
+// turnTimeoutPrompt is sent to the model in place of cancelToolUseMessage when a turn times out.
+const turnTimeoutPrompt = "This turn has exceeded its time limit of %s and has been stopped. " +
+	"Do not use any more tools. Briefly summarize for the user what you attempted during this turn, " +
+	"what is working and what is not, and suggest concrete next steps (for example, a narrower task to try next)."
+
+// TurnTimeout returns the wall-clock limit for a single turn; zero means no limit.
+func (a *Agent) TurnTimeout() time.Duration {
+	a.turnTimerMu.Lock()
+	defer a.turnTimerMu.Unlock()
+	return a.turnTimeout
+}
+
+// SetTurnTimeout changes the per-turn time limit. If a turn is in progress,
+// the new limit applies to it, measured from when the turn started.
+func (a *Agent) SetTurnTimeout(d time.Duration) {
+	a.turnTimerMu.Lock()
+	defer a.turnTimerMu.Unlock()
+	a.turnTimeout = d
+	if a.turnTimer == nil {
+		return
+	}
+	a.turnTimer.Stop()
+	a.turnTimer = nil
+	if d > 0 {
+		a.armTurnTimerLocked(d - time.Since(a.turnTimerStart))
+	}
+}
+
+// startTurnTimer starts the clock on the current turn, if a turn timeout is configured.
+// It is called once the user's message has been gathered, so time spent idle doesn't count.
+func (a *Agent) startTurnTimer() {
+	a.turnTimerMu.Lock()
+	defer a.turnTimerMu.Unlock()
+	a.turnTimerStart = time.Now()
+	if a.turnTimeout > 0 {
+		a.armTurnTimerLocked(a.turnTimeout)
+	}
+}
+
+// stopTurnTimer stops the clock at the end of a turn.
+func (a *Agent) stopTurnTimer() {
+	a.turnTimerMu.Lock()
+	defer a.turnTimerMu.Unlock()
+	if a.turnTimer != nil {
+		a.turnTimer.Stop()
+		a.turnTimer = nil
+	}
+}
+
+// armTurnTimerLocked schedules the turn to time out after remaining.
+// The caller must hold turnTimerMu.
+func (a *Agent) armTurnTimerLocked(remaining time.Duration) {
+	timeout := a.turnTimeout
+	a.turnTimer = time.AfterFunc(max(remaining, 0), func() {
+		a.timeOutTurn(timeout)
+	})
+}
+
+// timeOutTurn cancels the current turn, much like CancelTurn does for the user.
+func (a *Agent) timeOutTurn(timeout time.Duration) {
+	a.cancelTurnMu.Lock()
+	defer a.cancelTurnMu.Unlock()
+	if a.cancelTurn == nil {
+		return
+	}
+	ctx := a.config.Context
+	slog.WarnContext(ctx, "Turn timed out", "timeout", timeout)
+	a.stateMachine.ForceTransition(ctx, StateCancelled, fmt.Sprintf("Turn timed out after %s", timeout))
+	a.cancelTurn(&turnTimeoutError{timeout: timeout})
+}
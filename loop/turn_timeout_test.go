@@ -0,0 +1,105 @@
+package loop
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"sketch.dev/llm"
+)
+
+func TestTurnTimeout(t *testing.T) {
+	agent := &Agent{
+		config:       AgentConfig{Context: context.Background()},
+		stateMachine: NewStateMachine(),
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	agent.cancelTurn = cancel
+
+	// An hour-long limit shouldn't fire, but shrinking it mid-turn should.
+	agent.SetTurnTimeout(time.Hour)
+	agent.startTurnTimer()
+	agent.SetTurnTimeout(10 * time.Millisecond)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("turn was not cancelled after timeout")
+	}
+	tte, ok := turnTimedOut(ctx)
+	if !ok {
+		t.Fatalf("expected turn timeout cause, got %v", context.Cause(ctx))
+	}
+	if tte.timeout != 10*time.Millisecond {
+		t.Errorf("timeout = %v, want 10ms", tte.timeout)
+	}
+	if got := agent.stateMachine.CurrentState(); got != StateCancelled {
+		t.Errorf("state = %v, want %v", got, StateCancelled)
+	}
+	agent.stopTurnTimer()
+}
+
+func TestTurnTimeoutStopped(t *testing.T) {
+	agent := &Agent{
+		config:       AgentConfig{Context: context.Background()},
+		stateMachine: NewStateMachine(),
+		turnTimeout:  20 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	agent.cancelTurn = cancel
+
+	agent.startTurnTimer()
+	agent.stopTurnTimer()
+	time.Sleep(50 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Errorf("turn cancelled after timer was stopped: %v", context.Cause(ctx))
+	}
+
+	// User cancellation is not mistaken for a timeout.
+	cancel(nil)
+	if _, ok := turnTimedOut(ctx); ok {
+		t.Error("plain cancellation reported as timeout")
+	}
+}
+
+func TestTurnTimeoutWrapUp(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	convo := &mockConvoInterface{}
+	agent := &Agent{
+		convo:                convo,
+		config:               AgentConfig{Context: context.Background()},
+		inbox:                make(chan string, 1),
+		outstandingLLMCalls:  make(map[string]struct{}),
+		outstandingToolCalls: make(map[string]string),
+		stateMachine:         NewStateMachine(),
+	}
+	agent.inbox <- "take your time"
+
+	// The deadline passes while the model is deciding to call a tool.
+	convo.SendMessageFunc = func(llm.Message) (*llm.Response, error) {
+		cancel(&turnTimeoutError{timeout: time.Minute})
+		return &llm.Response{StopReason: llm.StopReasonToolUse, Content: []llm.Content{
+			{Type: llm.ContentTypeToolUse, ID: "t1", ToolName: "bash", ToolInput: []byte("{}")},
+		}}, nil
+	}
+	convo.ToolResultContentsFunc = func(context.Context, *llm.Response) ([]llm.Content, bool, error) {
+		t.Error("a tool ran past the deadline")
+		return nil, false, nil
+	}
+	var wrapUp []llm.Message
+	convo.SendMessageWithoutToolsFunc = func(m llm.Message) (*llm.Response, error) {
+		wrapUp = append(wrapUp, m)
+		return &llm.Response{StopReason: llm.StopReasonEndTurn, Content: []llm.Content{llm.StringContent("I tried…")}}, nil
+	}
+
+	if err := agent.processTurn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(wrapUp) != 1 || !strings.Contains(wrapUp[0].Content[len(wrapUp[0].Content)-1].Text, "exceeded its time limit of 1m0s") {
+		t.Errorf("wrap-up requests = %+v", wrapUp)
+	}
+}
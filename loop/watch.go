@@ -0,0 +1,272 @@
+package loop
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/i18n"
+)
+
+// maxWatchedFiles is how many changed files a note on them names.
+const maxWatchedFiles = 30
+
+// A fileStamp is what the watcher knows of a changed file, enough to tell
+// whether it changed again.
+type fileStamp struct {
+	status  string // the two-letter git status, e.g. " M" or "??"
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+// A treeSnapshot is the state of a working tree: its HEAD and the files that
+// differ from it. Files git ignores are left out.
+type treeSnapshot struct {
+	head  string
+	files map[string]fileStamp // by path relative to the repo root
+}
+
+func (s treeSnapshot) equal(o treeSnapshot) bool {
+	return s.head == o.head && maps.Equal(s.files, o.files)
+}
+
+// snapshotTree takes a treeSnapshot of the repo at root.
+func snapshotTree(ctx context.Context, root string) (treeSnapshot, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "-q", "--verify", "HEAD")
+	cmd.Dir = root
+	head, _ := cmd.Output() // empty before the first commit
+	cmd = exec.CommandContext(ctx, "git", "status", "--porcelain=v1", "-z", "--untracked-files=all")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return treeSnapshot{}, fmt.Errorf("git status: %w", err)
+	}
+	snap := treeSnapshot{head: string(bytes.TrimSpace(head)), files: make(map[string]fileStamp)}
+	records := strings.Split(string(out), "\x00")
+	for i := 0; i < len(records); i++ {
+		rec := records[i]
+		if len(rec) < 4 {
+			continue
+		}
+		status, path := rec[:2], rec[3:]
+		if status[0] == 'R' || status[0] == 'C' {
+			i++ // the path it was renamed or copied from
+		}
+		stamp := fileStamp{status: status}
+		if fi, err := os.Lstat(filepath.Join(root, path)); err == nil {
+			stamp.exists, stamp.size, stamp.modTime = true, fi.Size(), fi.ModTime()
+		}
+		snap.files[path] = stamp
+	}
+	return snap, nil
+}
+
+// A fileChange is a file that changed between two snapshots.
+type fileChange struct {
+	Path string
+	Kind string // "new", "modified", or "deleted"
+}
+
+// treeChanges lists the files that changed from base to now, by path.
+func treeChanges(root string, base, now treeSnapshot) []fileChange {
+	var changes []fileChange
+	for _, path := range slices.Sorted(maps.Keys(now.files)) {
+		stamp := now.files[path]
+		was, ok := base.files[path]
+		switch {
+		case ok && was == stamp:
+		case !stamp.exists:
+			changes = append(changes, fileChange{path, "deleted"})
+		case !ok && stamp.status == "??":
+			changes = append(changes, fileChange{path, "new"})
+		default:
+			changes = append(changes, fileChange{path, "modified"})
+		}
+	}
+	for _, path := range slices.Sorted(maps.Keys(base.files)) {
+		if _, ok := now.files[path]; ok {
+			continue
+		}
+		// Back in line with HEAD: reverted, committed, or an untracked file removed.
+		kind := "modified"
+		if _, err := os.Lstat(filepath.Join(root, path)); err != nil {
+			kind = "deleted"
+		}
+		changes = append(changes, fileChange{path, kind})
+	}
+	slices.SortFunc(changes, func(a, b fileChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes
+}
+
+// listChanges formats changes as "a.go (modified), b.go (new)", naming at most maxWatchedFiles.
+func listChanges(changes []fileChange) string {
+	var parts []string
+	for i, c := range changes {
+		if i == maxWatchedFiles {
+			parts = append(parts, fmt.Sprintf("and %d more", len(changes)-i))
+			break
+		}
+		parts = append(parts, c.Path+" ("+c.Kind+")")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// A fileWatcher notices the changes made to the working tree between the
+// agent's turns, most likely by the user over SSH or in an IDE. It polls, since
+// ignored and untracked files come and go in ways git already sorts out.
+// Changes made during a turn count as the agent's own.
+type fileWatcher struct {
+	root     string
+	interval time.Duration // between polls
+	quiet    time.Duration // how long the tree must stay as it is before notify hears of it
+	// notify is told of the changes since the last turn once they settle.
+	notify func(ctx context.Context, changes []fileChange)
+
+	mu        sync.Mutex
+	paused    bool // during a turn
+	base      treeSnapshot
+	last      treeSnapshot // as of the last poll
+	changedAt time.Time    // when last was first seen
+	notified  string       // the changes notify last heard of
+}
+
+func newFileWatcher(root string, notify func(context.Context, []fileChange)) *fileWatcher {
+	return &fileWatcher{root: root, interval: 2 * time.Second, quiet: 3 * time.Second, notify: notify, paused: true}
+}
+
+// run polls the working tree until ctx is done.
+func (w *fileWatcher) run(ctx context.Context) {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			w.poll(ctx, now)
+		}
+	}
+}
+
+func (w *fileWatcher) poll(ctx context.Context, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused {
+		return
+	}
+	snap, err := snapshotTree(ctx, w.root)
+	if err != nil {
+		slog.DebugContext(ctx, "watch: snapshot failed", "error", err)
+		return
+	}
+	if !snap.equal(w.last) {
+		w.last, w.changedAt = snap, now
+		return
+	}
+	if now.Sub(w.changedAt) < w.quiet {
+		return
+	}
+	changes := treeChanges(w.root, w.base, snap)
+	if list := listChanges(changes); list != w.notified {
+		w.notified = list
+		if len(changes) > 0 {
+			w.notify(ctx, changes)
+		}
+	}
+}
+
+// take pauses the watcher for a turn and returns the changes since the last
+// one ended, settled or not, and whether HEAD moved.
+func (w *fileWatcher) take(ctx context.Context) ([]fileChange, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused {
+		return nil, false
+	}
+	w.paused = true
+	snap, err := snapshotTree(ctx, w.root)
+	if err != nil {
+		slog.WarnContext(ctx, "watch: snapshot failed", "error", err)
+		return nil, false
+	}
+	return treeChanges(w.root, w.base, snap), snap.head != w.base.head
+}
+
+// pending reports whether the working tree changed since the last turn
+// ended, so that the next turn's note would tell of it.
+func (w *fileWatcher) pending(ctx context.Context) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.paused {
+		return false
+	}
+	snap, err := snapshotTree(ctx, w.root)
+	if err != nil {
+		return false
+	}
+	return snap.head != w.base.head || len(treeChanges(w.root, w.base, snap)) > 0
+}
+
+// resume takes the working tree as it is, after a turn, as the base that
+// later changes are the user's from.
+func (w *fileWatcher) resume(ctx context.Context) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.paused {
+		return
+	}
+	snap, err := snapshotTree(ctx, w.root)
+	if err != nil {
+		slog.WarnContext(ctx, "watch: snapshot failed", "error", err)
+		return
+	}
+	w.paused, w.base, w.last, w.notified = false, snap, snap, ""
+}
+
+// startWatch starts the -watch file watcher, for the rest of ctx.
+func (a *Agent) startWatch(ctx context.Context) {
+	if !a.config.Watch || a.repoRoot == "" {
+		return
+	}
+	a.watcher = newFileWatcher(a.repoRoot, func(ctx context.Context, changes []fileChange) {
+		a.pushToOutbox(ctx, AgentMessage{Type: AutoMessageType, Content: a.localize(i18n.FilesWatched, len(changes), listChanges(changes))})
+	})
+	a.watcher.resume(ctx)
+	go a.watcher.run(ctx)
+}
+
+// takeWatchNote returns the note that tells the model of the changes made to
+// the working tree since its last turn, and pauses the watcher for this one.
+func (a *Agent) takeWatchNote(ctx context.Context) string {
+	if a.watcher == nil {
+		return ""
+	}
+	changes, headMoved := a.watcher.take(ctx)
+	if len(changes) == 0 && !headMoved {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("Since your last turn, the working tree changed outside of it, most likely by the user's hand. ")
+	b.WriteString("What you remember of these files may be out of date; re-read them before relying on it, and don't undo the changes unless asked.\n")
+	for i, c := range changes {
+		if i == maxWatchedFiles {
+			fmt.Fprintf(&b, "- and %d more; see git status\n", len(changes)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s (%s)\n", c.Path, c.Kind)
+	}
+	if headMoved {
+		b.WriteString("HEAD moved too; see git log.\n")
+	}
+	return b.String()
+}
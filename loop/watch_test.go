@@ -0,0 +1,85 @@
+package loop
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", root, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %v", args, out, err)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q")
+	write("main.go", "package main\n")
+	write("old.go", "package main\n")
+	write(".gitignore", "*.log\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	write("wip.go", "package main // the agent's, from its last turn\n")
+
+	var notified []string
+	w := newFileWatcher(root, func(ctx context.Context, changes []fileChange) {
+		notified = append(notified, listChanges(changes))
+	})
+	w.resume(ctx)
+
+	start := time.Now()
+	w.poll(ctx, start)
+	write("main.go", "package main\n\nfunc main() {}\n")
+	write("notes.md", "# notes\n")
+	write("debug.log", "ignored\n")
+	os.Remove(filepath.Join(root, "old.go"))
+
+	w.poll(ctx, start.Add(2*time.Second))
+	if len(notified) != 0 {
+		t.Fatalf("notified before the changes settled: %q", notified)
+	}
+	w.poll(ctx, start.Add(6*time.Second))
+	w.poll(ctx, start.Add(8*time.Second))
+	want := "main.go (modified), notes.md (new), old.go (deleted)"
+	if len(notified) != 1 || notified[0] != want {
+		t.Fatalf("notified %q, want once %q", notified, want)
+	}
+
+	if !w.pending(ctx) {
+		t.Error("the changes are not pending")
+	}
+	a := &Agent{watcher: w}
+	note := a.takeWatchNote(ctx)
+	if !strings.Contains(note, "- main.go (modified)\n- notes.md (new)\n- old.go (deleted)\n") || strings.Contains(note, "wip.go") || strings.Contains(note, "HEAD") {
+		t.Errorf("note = %q", note)
+	}
+	// During the turn, the changes are the agent's.
+	write("notes.md", "# the agent's notes\n")
+	w.poll(ctx, start.Add(20*time.Second))
+	if len(notified) != 1 {
+		t.Errorf("notified during a turn: %q", notified)
+	}
+	if a.takeWatchNote(ctx) != "" {
+		t.Error("told the model twice")
+	}
+
+	w.resume(ctx)
+	git("add", ".")
+	git("commit", "-q", "-m", "the user's")
+	if note := a.takeWatchNote(ctx); !strings.Contains(note, "wip.go (modified)") || !strings.Contains(note, "HEAD moved") {
+		t.Errorf("after a commit, note = %q", note)
+	}
+}
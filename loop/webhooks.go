@@ -0,0 +1,54 @@
+package loop
+
+import (
+	"strings"
+
+	"sketch.dev/webhook"
+)
+
+// webhookEvent returns the webhook event m makes, if any. Only the top-level
+// conversation's messages count: a subagent ending its turn isn't the agent
+// waiting for the user.
+func webhookEvent(m AgentMessage) (webhook.Event, bool) {
+	if m.ParentConversationID != nil {
+		return webhook.Event{}, false
+	}
+	e := webhook.Event{Time: m.Timestamp, Message: m.Content}
+	switch {
+	case m.Type == CommitMessageType && len(m.Commits) > 0:
+		e.Type, e.Message = webhook.CommitEvent, ""
+		for _, c := range m.Commits {
+			e.Commits = append(e.Commits, webhook.Commit{Hash: c.Hash, Subject: c.Subject, Branch: c.PushedBranch})
+		}
+	case m.Type == BudgetMessageType:
+		e.Type = webhook.BudgetEvent
+	case m.Type == ErrorMessageType:
+		e.Type = webhook.ErrorEvent
+		e.Message, _, _ = strings.Cut(m.Content, " Stacktrace: ")
+	case m.Type == AgentMessageType && m.EndOfTurn:
+		e.Type = webhook.EndOfTurnEvent
+	case m.Type == ApprovalMessageType:
+		e.Type = webhook.ApprovalEvent
+	default:
+		return webhook.Event{}, false
+	}
+	return e, true
+}
+
+// sendWebhook reports m to the session's webhooks, if it is an event they get.
+// It must not be called with a.mu held.
+func (a *Agent) sendWebhook(m AgentMessage) {
+	if a.config.Webhooks == nil {
+		return
+	}
+	e, ok := webhookEvent(m)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	if a.convo != nil {
+		e.CostUSD = a.convo.CumulativeUsage().TotalCostUSD
+	}
+	a.mu.Unlock()
+	a.config.Webhooks.Send(e)
+}
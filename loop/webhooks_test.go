@@ -0,0 +1,40 @@
+package loop
+
+import (
+	"errors"
+	"testing"
+
+	"sketch.dev/webhook"
+)
+
+func TestWebhookEvent(t *testing.T) {
+	sub := "sub"
+	tests := []struct {
+		m    AgentMessage
+		want webhook.EventType // "" for no event
+	}{
+		{AgentMessage{Type: AgentMessageType, Content: "done", EndOfTurn: true}, webhook.EndOfTurnEvent},
+		{AgentMessage{Type: AgentMessageType, Content: "working"}, ""},
+		{AgentMessage{Type: AgentMessageType, EndOfTurn: true, ParentConversationID: &sub}, ""},
+		{AgentMessage{Type: CommitMessageType, Commits: []*GitCommit{{Hash: "abc", Subject: "Fix", PushedBranch: "sketch/fix"}}}, webhook.CommitEvent},
+		{AgentMessage{Type: CommitMessageType}, ""},
+		{budgetMessage(errors.New("over budget")), webhook.BudgetEvent},
+		{AgentMessage{Type: ErrorMessageType, Content: "boom Stacktrace: goroutine 1"}, webhook.ErrorEvent},
+		{AgentMessage{Type: UserMessageType, Content: "hi"}, ""},
+		{AgentMessage{Type: ApprovalMessageType, Content: "sketch wants to run bash"}, webhook.ApprovalEvent},
+	}
+	for _, tt := range tests {
+		e, ok := webhookEvent(tt.m)
+		if ok != (tt.want != "") || e.Type != tt.want {
+			t.Errorf("webhookEvent(%s %q) = %q, %v; want %q", tt.m.Type, tt.m.Content, e.Type, ok, tt.want)
+		}
+	}
+
+	e, _ := webhookEvent(AgentMessage{Type: CommitMessageType, Commits: []*GitCommit{{Hash: "abc", Subject: "Fix", PushedBranch: "sketch/fix"}}})
+	if len(e.Commits) != 1 || e.Commits[0] != (webhook.Commit{Hash: "abc", Subject: "Fix", Branch: "sketch/fix"}) {
+		t.Errorf("commit event commits = %+v", e.Commits)
+	}
+	if e, _ := webhookEvent(AgentMessage{Type: ErrorMessageType, Content: "boom Stacktrace: goroutine 1"}); e.Message != "boom" {
+		t.Errorf("error event message = %q, want the stack trace cut", e.Message)
+	}
+}
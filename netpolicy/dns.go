@@ -0,0 +1,154 @@
+package netpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ServeDNS answers DNS queries on pc, forwarding allowed names to the upstream
+// resolver (host:port) and answering NXDOMAIN for everything else.
+// It returns when pc is closed.
+func (p *Policy) ServeDNS(pc net.PacketConn, upstream string) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		query := bytes.Clone(buf[:n])
+		go func() {
+			resp, err := p.handleDNS(query, upstream)
+			if err != nil {
+				slog.Debug("network policy dns query failed", "err", err)
+				return
+			}
+			pc.WriteTo(resp, addr)
+		}()
+	}
+}
+
+func (p *Policy) handleDNS(query []byte, upstream string) ([]byte, error) {
+	var parser dnsmessage.Parser
+	hdr, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("parse dns header: %w", err)
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, fmt.Errorf("parse dns questions: %w", err)
+	}
+	for _, q := range questions {
+		name := q.Name.String()
+		if !p.Allowed(name) {
+			slog.Warn("network policy blocked dns lookup", "host", name)
+			p.recordViolation(name, "dns")
+			return nxdomain(hdr, questions)
+		}
+	}
+	return forwardDNS(query, upstream)
+}
+
+func nxdomain(hdr dnsmessage.Header, questions []dnsmessage.Question) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		OpCode:             hdr.OpCode,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              dnsmessage.RCodeNameError,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func forwardDNS(query []byte, upstream string) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", upstream, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// interceptResolvConf points path (normally /etc/resolv.conf) at listenIP,
+// returning the first nameserver it previously used so queries can be forwarded there.
+func interceptResolvConf(path, listenIP string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var upstream string
+	var kept []string
+	sc := bufio.NewScanner(bytes.NewReader(content))
+	for sc.Scan() {
+		line := sc.Text()
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if upstream == "" && fields[1] != listenIP {
+				upstream = fields[1]
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if upstream == "" {
+		return "", fmt.Errorf("no upstream nameserver in %s", path)
+	}
+	out := "nameserver " + listenIP + "\n" + strings.Join(kept, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(upstream, "53"), nil
+}
+
+// startDNS serves DNS on listenIP:53 and redirects the system resolver to it.
+func (p *Policy) startDNS(ctx context.Context, listenIP, resolvConf string) error {
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(listenIP, "53"))
+	if err != nil {
+		return fmt.Errorf("listen for dns: %w", err)
+	}
+	upstream, err := interceptResolvConf(resolvConf, listenIP)
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("redirect resolver: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+	go func() {
+		if err := p.ServeDNS(pc, upstream); err != nil {
+			slog.ErrorContext(ctx, "network policy dns server stopped", "err", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,44 @@
+package netpolicy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+)
+
+// noProxy lists destinations that bypass the proxy: the container itself and the host running sketch.
+const noProxy = "localhost,127.0.0.1,::1,host.docker.internal"
+
+// Enforce applies p to the current container: it starts the proxy and DNS
+// forwarder, points /etc/resolv.conf at the forwarder, and sets the proxy
+// environment variables for this process and everything it spawns.
+// It must be called before any outbound HTTP requests are made, because
+// net/http reads the proxy environment only once.
+func Enforce(ctx context.Context, p *Policy) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen for proxy: %w", err)
+	}
+	if err := p.startDNS(ctx, "127.0.0.1", "/etc/resolv.conf"); err != nil {
+		ln.Close()
+		return err
+	}
+	srv := &http.Server{Handler: NewProxy(p)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go srv.Serve(ln)
+
+	proxyURL := "http://" + ln.Addr().String()
+	for _, k := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		os.Setenv(k, proxyURL)
+	}
+	os.Setenv("NO_PROXY", noProxy)
+	os.Setenv("no_proxy", noProxy)
+	slog.InfoContext(ctx, "network allowlist enforced", "proxy", proxyURL, "allow", p.Allowlist())
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package netpolicy restricts a sketch container's network egress to an allowlist of domains.
+//
+// Enforcement is advisory: an HTTP/CONNECT proxy is advertised via the usual
+// *_PROXY environment variables, and a DNS forwarder refuses to resolve names
+// that aren't allowed. Processes that ignore proxy settings and dial raw IP
+// addresses are not stopped, and the agent runs as root, so it can undo both;
+// the goal is to keep the agent's tooling honest, not to contain a hostile process.
+package netpolicy
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAllowlist covers the common package registries and source hosts.
+// It is what "default" expands to in an allowlist specification.
+var DefaultAllowlist = []string{
+	// Go
+	"proxy.golang.org",
+	"sum.golang.org",
+	"storage.googleapis.com",
+	// JavaScript
+	"registry.npmjs.org",
+	"registry.yarnpkg.com",
+	// Python
+	"pypi.org",
+	"files.pythonhosted.org",
+	// Source hosting
+	"github.com",
+	"githubusercontent.com",
+	"codeload.github.com",
+}
+
+// maxViolations bounds the violation log kept in memory.
+const maxViolations = 500
+
+// Violation records a blocked attempt to reach a host.
+type Violation struct {
+	Host string    `json:"host"`
+	Via  string    `json:"via"` // "dns", "http", or "connect"
+	Time time.Time `json:"time"`
+}
+
+// Policy decides which hosts may be reached and records violations.
+type Policy struct {
+	allow []string
+
+	mu          sync.Mutex
+	violations  []Violation
+	onViolation func(Violation)
+}
+
+// ParseAllowlist splits a comma-separated allowlist specification.
+// The entry "default" expands to DefaultAllowlist.
+func ParseAllowlist(spec string) []string {
+	var hosts []string
+	for entry := range strings.SplitSeq(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		switch entry {
+		case "":
+		case "default":
+			hosts = append(hosts, DefaultAllowlist...)
+		default:
+			hosts = append(hosts, entry)
+		}
+	}
+	return hosts
+}
+
+// New creates a Policy that allows the given domains and all of their subdomains.
+// Entries may be written as "example.com" or "*.example.com"; both mean the same thing.
+func New(allow []string) *Policy {
+	p := &Policy{}
+	for _, a := range allow {
+		a = normalizeHost(strings.TrimPrefix(strings.TrimSpace(a), "*."))
+		if a != "" && !slices.Contains(p.allow, a) {
+			p.allow = append(p.allow, a)
+		}
+	}
+	return p
+}
+
+// Allowlist returns the normalized domains the policy allows.
+func (p *Policy) Allowlist() []string {
+	return slices.Clone(p.allow)
+}
+
+// Allowed reports whether host (optionally with a port) may be reached.
+// Loopback hosts are always allowed, since they never leave the container.
+// Other IP literals are allowed only if they are on the allowlist themselves:
+// a domain's address is not the domain.
+func (p *Policy) Allowed(host string) bool {
+	host = normalizeHost(host)
+	if host == "" || host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, a := range p.allow {
+		if host == a || strings.HasSuffix(host, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetViolationHandler registers fn to be called, without locks held, for every violation.
+func (p *Policy) SetViolationHandler(fn func(Violation)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onViolation = fn
+}
+
+// Violations returns the recorded violations, oldest first.
+func (p *Policy) Violations() []Violation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.violations)
+}
+
+func (p *Policy) recordViolation(host, via string) {
+	v := Violation{Host: normalizeHost(host), Via: via, Time: time.Now()}
+	p.mu.Lock()
+	if len(p.violations) >= maxViolations {
+		p.violations = slices.Delete(p.violations, 0, len(p.violations)-maxViolations+1)
+	}
+	p.violations = append(p.violations, v)
+	fn := p.onViolation
+	p.mu.Unlock()
+	if fn != nil {
+		fn(v)
+	}
+}
+
+// normalizeHost lowercases host and strips any port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.ToLower(host)
+}
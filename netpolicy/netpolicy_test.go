@@ -0,0 +1,157 @@
+package netpolicy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestAllowed(t *testing.T) {
+	p := New([]string{"proxy.golang.org", "*.npmjs.org", "GitHub.com."})
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"proxy.golang.org", true},
+		{"proxy.golang.org:443", true},
+		{"registry.npmjs.org", true},
+		{"npmjs.org", true},
+		{"api.github.com.", true},
+		{"GITHUB.COM", true},
+		{"golang.org", false},
+		{"evilgithub.com", false},
+		{"example.com:80", false},
+		{"localhost:8080", true},
+		{"10.0.0.1", false},
+		{"93.184.215.14:443", false},
+		{"127.0.0.1:8080", true},
+		{"[::1]:80", true},
+		{"[2606:2800:21f:cb07:6820:80da:af6b:8b2c]:443", false},
+	}
+	for _, tt := range tests {
+		if got := p.Allowed(tt.host); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestParseAllowlist(t *testing.T) {
+	got := ParseAllowlist(" example.com, default ,,")
+	if got[0] != "example.com" || len(got) != 1+len(DefaultAllowlist) {
+		t.Errorf("ParseAllowlist = %v", got)
+	}
+	if ParseAllowlist("") != nil {
+		t.Errorf("empty spec should yield no hosts")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer backend.Close()
+
+	p := New([]string{"allowed.test"})
+	var seen atomic.Int32
+	p.SetViolationHandler(func(Violation) { seen.Add(1) })
+	proxy := httptest.NewServer(NewProxy(p))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	// Loopback is always allowed, so the backend is reachable directly.
+	resp, err := client.Get(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("allowed request = %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = client.Get("http://blocked.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "blocked.test") {
+		t.Errorf("blocked request = %d %q", resp.StatusCode, body)
+	}
+
+	if _, err := client.Get("https://blocked.test/"); err == nil {
+		t.Errorf("CONNECT to blocked host succeeded")
+	}
+
+	if _, err := client.Get("https://10.0.0.1/"); err == nil {
+		t.Errorf("CONNECT to a numeric address succeeded")
+	}
+
+	vs := p.Violations()
+	if len(vs) != 3 || vs[0].Via != "http" || vs[1].Via != "connect" || vs[1].Host != "blocked.test" || vs[2].Host != "10.0.0.1" {
+		t.Errorf("violations = %+v", vs)
+	}
+	if n := seen.Load(); n != 3 {
+		t.Errorf("handler saw %d violations, want 3", n)
+	}
+}
+
+func TestHandleDNSBlocked(t *testing.T) {
+	p := New([]string{"allowed.test"})
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("www.blocked.test."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	})
+	query, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The upstream is never contacted for blocked names.
+	resp, err := p.handleDNS(query, "127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 42 || !msg.Response || msg.RCode != dnsmessage.RCodeNameError || len(msg.Questions) != 1 {
+		t.Errorf("response = %+v", msg.Header)
+	}
+	if vs := p.Violations(); len(vs) != 1 || vs[0].Host != "www.blocked.test" || vs[0].Via != "dns" {
+		t.Errorf("violations = %+v", vs)
+	}
+}
+
+func TestInterceptResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("search example.internal\nnameserver 192.168.65.7\nnameserver 8.8.8.8\noptions ndots:0\n"), 0o644)
+
+	upstream, err := interceptResolvConf(path, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream != "192.168.65.7:53" {
+		t.Errorf("upstream = %q", upstream)
+	}
+	content, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	want := []string{"nameserver 127.0.0.1", "search example.internal", "options ndots:0"}
+	if !slices.Equal(lines, want) {
+		t.Errorf("resolv.conf = %q, want %q", lines, want)
+	}
+}
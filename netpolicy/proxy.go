@@ -0,0 +1,136 @@
+package netpolicy
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Proxy is an HTTP forward proxy that only connects to hosts allowed by its Policy.
+// It handles both plain HTTP requests with absolute URLs and CONNECT tunnels.
+type Proxy struct {
+	Policy *Policy
+
+	transport http.RoundTripper
+}
+
+// NewProxy returns a Proxy enforcing p.
+func NewProxy(p *Policy) *Proxy {
+	return &Proxy{
+		Policy: p,
+		// Proxy must be nil here, or we'd loop back through ourselves via HTTP_PROXY.
+		transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           (&net.Dialer{Timeout: 30 * time.Second}).DialContext,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+func (px *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		px.serveConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a forward proxy; requests must use absolute URLs", http.StatusBadRequest)
+		return
+	}
+	if !px.Policy.Allowed(r.URL.Host) {
+		px.deny(w, r.URL.Host, "http")
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	removeHopHeaders(out.Header)
+	resp, err := px.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (px *Proxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	if !px.Policy.Allowed(r.Host) {
+		px.deny(w, r.Host, "connect")
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", r.Host, 30*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, rw, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+
+	// Forward anything the client sent after the CONNECT that's already buffered.
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		if _, err := upstream.Write(buffered); err != nil {
+			client.Close()
+			upstream.Close()
+			return
+		}
+	}
+	go func() {
+		io.Copy(upstream, client)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
+
+func (px *Proxy) deny(w http.ResponseWriter, host, via string) {
+	slog.Warn("network policy blocked request", "host", host, "via", via)
+	px.Policy.recordViolation(host, via)
+	http.Error(w, fmt.Sprintf("sketch network policy: %s is not in the allowlist", normalizeHost(host)), http.StatusForbidden)
+}
+
+// hopHeaders are removed when forwarding, per RFC 9110 section 7.6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, k := range hopHeaders {
+		h.Del(k)
+	}
+}
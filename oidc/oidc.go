@@ -0,0 +1,514 @@
+// Package oidc signs users in to the web UI with an OpenID Connect provider,
+// for sketch deployments shared by several people. It implements the
+// authorization code flow with PKCE, and verifies the ID tokens it gets
+// against the provider's published keys.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Config says which provider to use, and who gets which Role.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string   // empty for a public client
+	RedirectURL  string   // the web UI's /auth/callback, as registered with the provider
+	Owners       []string // who gets RoleOwner; see ParseConfig for the forms
+	Spectators   []string // who gets RoleSpectator, if not an owner
+}
+
+// A Role is what a signed-in user may do.
+type Role string
+
+const (
+	RoleOwner     Role = "owner"     // drives the session
+	RoleSpectator Role = "spectator" // watches it, read-only
+)
+
+// ParseConfig parses an -oidc spec, space-separated settings from
+//
+//	issuer=URL        the provider, e.g. https://accounts.google.com
+//	client=ID         the client ID registered with it
+//	redirect=URL      the web UI's /auth/callback URL registered with it
+//	owners=LIST       who may drive the session
+//	spectators=LIST   who may watch it (default: no one else)
+//
+// A LIST is comma-separated: an email address, @domain for its addresses,
+// sub:ID for a subject, group:NAME for a member of a group in the groups
+// claim, or * for anyone the provider signs in. Email addresses must be
+// verified by the provider. The client secret is set separately; an empty
+// spec returns nil.
+func ParseConfig(spec string) (*Config, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	c := &Config{}
+	for _, setting := range strings.Fields(spec) {
+		key, val, _ := strings.Cut(setting, "=")
+		switch key {
+		case "issuer":
+			c.Issuer = strings.TrimSuffix(val, "/")
+		case "client":
+			c.ClientID = val
+		case "redirect":
+			c.RedirectURL = val
+		case "owners":
+			c.Owners = splitList(val)
+		case "spectators":
+			c.Spectators = splitList(val)
+		default:
+			return nil, fmt.Errorf("oidc: unknown setting %q (want issuer, client, redirect, owners or spectators)", key)
+		}
+	}
+	for _, u := range []struct{ name, val string }{{"issuer", c.Issuer}, {"redirect", c.RedirectURL}} {
+		parsed, err := url.Parse(u.val)
+		if u.val == "" || err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return nil, fmt.Errorf("oidc: %s wants an http or https URL, got %q", u.name, u.val)
+		}
+	}
+	if c.ClientID == "" {
+		return nil, fmt.Errorf("oidc: client is required")
+	}
+	if len(c.Owners) == 0 {
+		return nil, fmt.Errorf("oidc: owners is required, or no one could drive the session")
+	}
+	for _, m := range slices.Concat(c.Owners, c.Spectators) {
+		if strings.HasPrefix(m, "sub:") || strings.HasPrefix(m, "group:") || m == "*" || strings.Contains(m, "@") {
+			continue
+		}
+		return nil, fmt.Errorf("oidc: %q isn't an email, @domain, sub:ID, group:NAME or *", m)
+	}
+	return c, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Role returns the role c gives the user cl describes, or "" if none.
+func (c *Config) Role(cl *Claims) Role {
+	switch {
+	case matchAny(c.Owners, cl):
+		return RoleOwner
+	case matchAny(c.Spectators, cl):
+		return RoleSpectator
+	}
+	return ""
+}
+
+func matchAny(list []string, cl *Claims) bool {
+	email := ""
+	if cl.EmailVerified {
+		email = strings.ToLower(cl.Email)
+	}
+	for _, m := range list {
+		switch {
+		case m == "*":
+			return true
+		case strings.HasPrefix(m, "sub:"):
+			if m[len("sub:"):] == cl.Subject {
+				return true
+			}
+		case strings.HasPrefix(m, "group:"):
+			if slices.Contains(cl.Groups, m[len("group:"):]) {
+				return true
+			}
+		case strings.HasPrefix(m, "@"):
+			if email != "" && strings.HasSuffix(email, strings.ToLower(m)) {
+				return true
+			}
+		default:
+			if email != "" && email == strings.ToLower(m) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Claims are the parts of an ID token sketch uses.
+type Claims struct {
+	Issuer        string    `json:"iss"`
+	Subject       string    `json:"sub"`
+	Audience      audience  `json:"aud"`
+	Expiry        int64     `json:"exp"`
+	Nonce         string    `json:"nonce"`
+	Email         string    `json:"email"`
+	EmailVerified looseBool `json:"email_verified"`
+	Name          string    `json:"name"`
+	Groups        []string  `json:"groups"`
+}
+
+// User names the user for logs and the audit trail.
+func (cl *Claims) User() string {
+	if cl.Email != "" {
+		return cl.Email
+	}
+	return cl.Subject
+}
+
+// audience is the aud claim, a string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// looseBool is a boolean claim, which some providers send as a string.
+type looseBool bool
+
+func (v *looseBool) UnmarshalJSON(b []byte) error {
+	*v = looseBool(string(b) == "true" || string(b) == `"true"`)
+	return nil
+}
+
+// A Provider runs sign-ins with the provider of a Config. It looks the
+// provider's endpoints up on first use, so sketch starts without reaching it.
+type Provider struct {
+	config *Config
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+type metadata struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+}
+
+// NewProvider returns a Provider for c.
+func NewProvider(c *Config) *Provider {
+	return &Provider{config: c, client: &http.Client{Timeout: 15 * time.Second}}
+}
+
+// Config returns p's configuration.
+func (p *Provider) Config() *Config {
+	return p.config
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var m metadata
+	if err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: provider says it is %q, not %q", m.Issuer, p.config.Issuer)
+	}
+	if m.AuthURL == "" || m.TokenURL == "" || m.JWKSURL == "" {
+		return nil, fmt.Errorf("oidc discovery: %s lacks an authorization, token or jwks endpoint", p.config.Issuer)
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// AuthURL returns where to send the browser to sign in. The caller keeps
+// state, nonce and verifier for Exchange.
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(m.AuthURL, "?") {
+		sep = "&"
+	}
+	return m.AuthURL + sep + q.Encode(), nil
+}
+
+// Exchange trades the code the provider redirected back with for an ID
+// token, and returns its verified claims.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil || tok.IDToken == "" {
+		return nil, fmt.Errorf("oidc token exchange: no id_token in the response")
+	}
+	return p.Verify(ctx, tok.IDToken, nonce)
+}
+
+// Verify checks raw, an ID token, and returns its claims.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("oidc: ID token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: ID token signature: %w", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var cl Claims
+	if err := decodeSegment(parts[1], &cl); err != nil {
+		return nil, fmt.Errorf("oidc: ID token claims: %w", err)
+	}
+	switch {
+	case strings.TrimSuffix(cl.Issuer, "/") != p.config.Issuer:
+		return nil, fmt.Errorf("oidc: ID token from %q, not %q", cl.Issuer, p.config.Issuer)
+	case !slices.Contains(cl.Audience, p.config.ClientID):
+		return nil, errors.New("oidc: ID token is for another client")
+	case time.Unix(cl.Expiry, 0).Before(time.Now().Add(-time.Minute)): // allows a little clock skew
+		return nil, errors.New("oidc: ID token expired")
+	case cl.Nonce != nonce:
+		return nil, errors.New("oidc: ID token nonce doesn't match the sign-in")
+	case cl.Subject == "":
+		return nil, errors.New("oidc: ID token has no subject")
+	}
+	return &cl, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// key returns the provider's signing key kid, fetching the keys again,
+// at most once a minute, when the provider has rotated them.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lookup := func() crypto.PublicKey {
+		if k, ok := p.keys[kid]; ok {
+			return k
+		}
+		if kid == "" && len(p.keys) == 1 {
+			for _, k := range p.keys {
+				return k
+			}
+		}
+		return nil
+	}
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	if time.Since(p.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("oidc: no signing key %q", kid)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURL, &set); err != nil {
+		return nil, fmt.Errorf("oidc signing keys: %w", err)
+	}
+	p.keysFetched = time.Now()
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			p.keys[k.Kid] = pub
+		}
+	}
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: no signing key %q", kid)
+}
+
+// A jwk is a public key in a JSON Web Key Set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	num := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("bad key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := num(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := num(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := num(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := num(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var h hash.Hash
+	var ch crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		h, ch = sha256.New(), crypto.SHA256
+	case "384":
+		h, ch = sha512.New384(), crypto.SHA384
+	case "512":
+		h, ch = sha512.New(), crypto.SHA512
+	default:
+		return fmt.Errorf("oidc: unsupported ID token algorithm %q", alg)
+	}
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, ch, digest, sig) == nil {
+			return nil
+		}
+		if strings.HasPrefix(alg, "PS") && rsa.VerifyPSS(k, ch, digest, sig, nil) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(sig) == 2*size {
+			r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("oidc: ID token signature doesn't verify")
+}
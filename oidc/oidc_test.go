@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig("issuer=https://id.example.com/ client=sketch redirect=https://sketch.example.com/auth/callback owners=ann@example.com,group:admins spectators=@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Issuer != "https://id.example.com" || c.ClientID != "sketch" || len(c.Owners) != 2 || len(c.Spectators) != 1 {
+		t.Errorf("ParseConfig = %+v", c)
+	}
+	if c, err := ParseConfig(""); c != nil || err != nil {
+		t.Errorf("ParseConfig(\"\") = %v, %v", c, err)
+	}
+	for _, spec := range []string{
+		"issuer=https://id.example.com client=sketch redirect=https://s.example.com/auth/callback", // no owners
+		"issuer=id.example.com client=sketch redirect=https://s.example.com/auth/callback owners=*",
+		"issuer=https://id.example.com redirect=https://s.example.com/auth/callback owners=*",
+		"issuer=https://id.example.com client=sketch redirect=https://s.example.com/auth/callback owners=ann",
+		"issuer=https://id.example.com client=sketch redirect=https://s.example.com/auth/callback owners=* scope=x",
+	} {
+		if _, err := ParseConfig(spec); err == nil {
+			t.Errorf("ParseConfig(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRole(t *testing.T) {
+	c := &Config{Owners: []string{"Ann@example.com", "sub:42", "group:admins"}, Spectators: []string{"@example.com"}}
+	for _, tt := range []struct {
+		cl   Claims
+		want Role
+	}{
+		{Claims{Email: "ann@example.com", EmailVerified: true}, RoleOwner},
+		{Claims{Email: "ann@example.com"}, ""}, // unverified
+		{Claims{Subject: "42"}, RoleOwner},
+		{Claims{Groups: []string{"admins"}}, RoleOwner},
+		{Claims{Email: "bob@example.com", EmailVerified: true}, RoleSpectator},
+		{Claims{Email: "bob@example.com.evil.com", EmailVerified: true}, ""},
+		{Claims{Email: "eve@elsewhere.com", EmailVerified: true}, ""},
+	} {
+		if got := c.Role(&tt.cl); got != tt.want {
+			t.Errorf("Role(%+v) = %q, want %q", tt.cl, got, tt.want)
+		}
+	}
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": ts.URL, "authorization_endpoint": ts.URL + "/a", "token_endpoint": ts.URL + "/t", "jwks_uri": ts.URL + "/keys"})
+		case "/keys":
+			ecPub, _ := ecKey.PublicKey.ECDH()
+			raw := ecPub.Bytes() // 0x04 || X || Y
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+				{"kty": "RSA", "kid": "r", "n": b64(rsaKey.N.Bytes()), "e": b64([]byte{1, 0, 1})},
+				{"kty": "EC", "kid": "e", "crv": "P-256", "x": b64(raw[1:33]), "y": b64(raw[33:])},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	p := NewProvider(&Config{Issuer: ts.URL, ClientID: "sketch"})
+
+	mint := func(alg, kid string, claims map[string]any) string {
+		h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+		c, _ := json.Marshal(claims)
+		signed := b64(h) + "." + b64(c)
+		digest := sha256.Sum256([]byte(signed))
+		var sig []byte
+		if alg == "RS256" {
+			sig, _ = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		} else {
+			r, s, _ := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+		return signed + "." + b64(sig)
+	}
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{"iss": ts.URL, "sub": "42", "aud": "sketch", "exp": time.Now().Add(time.Hour).Unix(), "nonce": "n", "email": "ann@example.com", "email_verified": "true"}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	ctx := context.Background()
+
+	cl, err := p.Verify(ctx, mint("RS256", "r", claims(nil)), "n")
+	if err != nil || cl.User() != "ann@example.com" || !cl.EmailVerified {
+		t.Fatalf("Verify RS256 = %+v, %v", cl, err)
+	}
+	if _, err := p.Verify(ctx, mint("ES256", "e", claims(func(c map[string]any) { c["aud"] = []string{"other", "sketch"} })), "n"); err != nil {
+		t.Errorf("Verify ES256: %v", err)
+	}
+	for name, tok := range map[string]string{
+		"wrong key":      mint("RS256", "e", claims(nil)),
+		"unknown key":    mint("RS256", "gone", claims(nil)),
+		"other audience": mint("RS256", "r", claims(func(c map[string]any) { c["aud"] = "other" })),
+		"other issuer":   mint("RS256", "r", claims(func(c map[string]any) { c["iss"] = "https://evil.example.com" })),
+		"expired":        mint("RS256", "r", claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() })),
+		"other nonce":    mint("RS256", "r", claims(func(c map[string]any) { c["nonce"] = "m" })),
+		"tampered":       strings.Replace(mint("RS256", "r", claims(nil)), ".", ".e30", 1),
+		"alg none":       mint("none", "r", claims(nil)),
+	} {
+		if _, err := p.Verify(ctx, tok, "n"); err == nil {
+			t.Errorf("Verify accepted a token with %s", name)
+		}
+	}
+}
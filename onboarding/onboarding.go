@@ -0,0 +1,394 @@
+// Package onboarding walks new users through setting sketch up. It finds which
+// of what sketch can use is available on the machine, and tracks the setup
+// steps done so far, so that the web UI can guide a first run to a working
+// setup rather than leave it with the errors of a missing one.
+package onboarding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sketch.dev/llm/ant"
+	"sketch.dev/llm/gem"
+	"sketch.dev/llm/oai"
+)
+
+// Capabilities sketch can use, when available.
+const (
+	CapModels  = "models"  // API keys, or sketch.dev, for at least one model
+	CapDocker  = "docker"  // a docker daemon to run sessions' containers
+	CapSSH     = "ssh"     // ssh, configured to reach containers
+	CapSkaband = "skaband" // sketch.dev, signed in
+)
+
+// A Capability is something sketch can use on the host, if Available.
+type Capability struct {
+	ID        string `json:"id"`
+	Available bool   `json:"available"`
+	Detail    string `json:"detail"`
+	Fix       string `json:"fix,omitempty"` // how to make it available
+}
+
+// A Step is a stage of onboarding. A step that needs a capability is done
+// once the capability is available; the others are done when the user says so.
+type Step struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Needs    string `json:"needs,omitempty"` // a capability
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Steps are the steps of onboarding, in order.
+var Steps = []Step{
+	{ID: "model", Title: "Connect a model", Needs: CapModels},
+	{ID: "docker", Title: "Run sessions in containers", Needs: CapDocker, Optional: true},
+	{ID: "ssh", Title: "Reach containers over SSH", Needs: CapSSH, Optional: true},
+	{ID: "skaband", Title: "Sign in to sketch.dev", Needs: CapSkaband, Optional: true},
+	{ID: "first-task", Title: "Give the agent a task"},
+	{ID: "review", Title: "Review the agent's changes in the diff view"},
+}
+
+// Progress is what the user did of onboarding, kept on the host across sessions.
+type Progress struct {
+	Done      map[string]time.Time `json:"done,omitempty"`
+	Skipped   map[string]time.Time `json:"skipped,omitempty"`
+	Dismissed *time.Time           `json:"dismissed,omitempty"`
+}
+
+// DefaultPath is where Progress is kept. Its absence marks a first run.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "sketch", "onboarding.json")
+}
+
+// Load reads the Progress at path. It reports whether there was any:
+// with none, this is sketch's first run.
+func Load(path string) (Progress, bool, error) {
+	var p Progress
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, false, nil
+	} else if err != nil {
+		return p, false, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, true, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, true, nil
+}
+
+// Save writes p to path.
+func (p Progress) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// A Discovery is what the host found: its capabilities and the user's progress.
+type Discovery struct {
+	FirstRun     bool         `json:"first_run"`
+	Capabilities []Capability `json:"capabilities"`
+	Models       []string     `json:"models"` // those with API keys
+	Progress     Progress     `json:"progress"`
+}
+
+// Options say where Discover looks.
+type Options struct {
+	Path        string // of the Progress; see DefaultPath
+	SkabandAddr string // empty if sketch.dev isn't used
+	SkabandKey  string // the private key signing in to SkabandAddr leaves
+}
+
+// Discover finds what sketch can use on this machine, and how far the user got.
+func Discover(ctx context.Context, opts Options) Discovery {
+	p, found, err := Load(opts.Path)
+	if err != nil {
+		// Start over rather than fail to start.
+		p, found = Progress{}, false
+	}
+	d := Discovery{FirstRun: !found, Progress: p, Models: modelsWithKeys(os.Getenv)}
+	d.Capabilities = []Capability{
+		modelsCapability(d.Models, opts.SkabandAddr),
+		dockerCapability(ctx),
+		sshCapability(),
+		skabandCapability(opts.SkabandAddr, opts.SkabandKey),
+	}
+	return d
+}
+
+// modelsWithKeys lists the models whose provider's API key is in the environment.
+func modelsWithKeys(getenv func(string) string) []string {
+	var models []string
+	signedIn := false
+	if path, err := ant.DefaultOAuthTokenPath(); err == nil {
+		_, err = ant.LoadOAuthToken(path)
+		signedIn = err == nil
+	}
+	if getenv(ant.APIKeyEnv) != "" || signedIn {
+		models = append(models, "claude", "opus")
+	}
+	if getenv(gem.GeminiAPIKeyEnv) != "" {
+		models = append(models, "gemini")
+	}
+	for _, m := range oai.ModelsRegistry {
+		if m.UserName != "" && m.APIKeyEnv != "" && m.APIKeyEnv != "NONE" && getenv(m.APIKeyEnv) != "" {
+			models = append(models, m.UserName)
+		}
+	}
+	return models
+}
+
+func modelsCapability(models []string, skabandAddr string) Capability {
+	c := Capability{ID: CapModels}
+	switch {
+	case len(models) > 0:
+		c.Available, c.Detail = true, "API keys for "+strings.Join(models, ", ")
+	case skabandAddr != "":
+		c.Available, c.Detail = true, "models through "+skabandAddr
+	default:
+		c.Detail = "no model API keys"
+		c.Fix = fmt.Sprintf("set %s, or run sketch -anthropic-login; sketch -list-models shows the other models and their keys", ant.APIKeyEnv)
+	}
+	return c
+}
+
+func dockerCapability(ctx context.Context) Capability {
+	c := Capability{ID: CapDocker}
+	if _, err := exec.LookPath("docker"); err != nil {
+		c.Detail = "docker isn't installed"
+		c.Fix = "install docker (on macOS: brew install docker colima && colima start), or run sketch -unsafe to work without a container"
+		return c
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{.ServerVersion}}").CombinedOutput()
+	if err != nil {
+		c.Detail = "can't reach the docker daemon"
+		c.Fix = "start the docker daemon and make sure your user may use it; sketch doctor says more"
+		return c
+	}
+	c.Available, c.Detail = true, "docker daemon "+strings.TrimSpace(string(out))
+	return c
+}
+
+func sshCapability() Capability {
+	c := Capability{ID: CapSSH}
+	if _, err := exec.LookPath("ssh"); err != nil {
+		c.Detail = "ssh isn't installed"
+		c.Fix = "install OpenSSH to ssh into containers and attach IDEs to them"
+		return c
+	}
+	home, _ := os.UserHomeDir()
+	include := "Include " + filepath.Join(home, ".config", "sketch", "ssh_config")
+	config, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil || !strings.Contains(string(config), include) {
+		c.Detail = "~/.ssh/config doesn't include sketch's ssh config"
+		c.Fix = "accept sketch's offer to add it on launch, or add this as the first line of ~/.ssh/config: " + include
+		return c
+	}
+	c.Available, c.Detail = true, "ssh installed and configured"
+	return c
+}
+
+func skabandCapability(addr, key string) Capability {
+	c := Capability{ID: CapSkaband}
+	if addr == "" {
+		c.Detail = "sketch.dev is turned off with -skaband-addr=\"\""
+		c.Fix = "leave -skaband-addr at its default to share sessions through sketch.dev"
+		return c
+	}
+	if _, err := os.Stat(key); err != nil {
+		c.Detail = "not signed in to " + addr
+		c.Fix = "run sketch and follow the sign-in link it prints"
+		return c
+	}
+	c.Available, c.Detail = true, "signed in to "+addr
+	return c
+}
+
+// Step statuses in a State.
+const (
+	StepDone    = "done"
+	StepSkipped = "skipped"
+	StepReady   = "ready"   // the user can do it now
+	StepBlocked = "blocked" // its capability isn't available; see Fix
+)
+
+// Onboarding statuses in a State.
+const (
+	InProgress = "in-progress"
+	Complete   = "complete"
+	Dismissed  = "dismissed"
+)
+
+// State is the onboarding flow as the web UI shows it.
+type State struct {
+	FirstRun     bool         `json:"first_run"`
+	Status       string       `json:"status"`
+	Current      string       `json:"current,omitempty"` // the step to show
+	Steps        []StepState  `json:"steps"`
+	Capabilities []Capability `json:"capabilities"`
+	Models       []string     `json:"models"`
+}
+
+// A StepState is a Step and how far the user got with it.
+type StepState struct {
+	Step
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"` // of the capability it needs
+	Fix    string `json:"fix,omitempty"`
+}
+
+// A Guide runs the onboarding flow of a session. The current step is the
+// first one neither done nor skipped; the user completes it, or skips it if
+// it is optional, to move on. Required steps that are blocked stay current
+// until their capability becomes available.
+type Guide struct {
+	mu sync.Mutex
+	d  Discovery
+}
+
+// NewGuide starts the onboarding flow where d left it.
+func NewGuide(d Discovery) *Guide {
+	return &Guide{d: d}
+}
+
+// Errors of Complete and Skip.
+var (
+	ErrUnknownStep = errors.New("no such onboarding step")
+	ErrNotCurrent  = errors.New("not the current onboarding step")
+	ErrRequired    = errors.New("required onboarding steps can't be skipped")
+	ErrBlocked     = errors.New("the onboarding step needs a capability that isn't available")
+)
+
+// State returns the flow as it stands.
+func (g *Guide) State() State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state()
+}
+
+func (g *Guide) state() State {
+	st := State{
+		FirstRun:     g.d.FirstRun,
+		Status:       Complete,
+		Capabilities: slices.Clone(g.d.Capabilities),
+		Models:       slices.Clone(g.d.Models),
+	}
+	for _, step := range Steps {
+		ss := StepState{Step: step, Status: StepReady}
+		if c, ok := g.capability(step.Needs); ok {
+			ss.Detail, ss.Fix = c.Detail, c.Fix
+			if c.Available {
+				ss.Status, ss.Fix = StepDone, ""
+			} else {
+				ss.Status = StepBlocked
+			}
+		}
+		if _, ok := g.d.Progress.Done[step.ID]; ok {
+			ss.Status = StepDone
+		} else if _, ok := g.d.Progress.Skipped[step.ID]; ok && ss.Status != StepDone {
+			ss.Status = StepSkipped
+		}
+		if st.Current == "" && ss.Status != StepDone && ss.Status != StepSkipped {
+			st.Current = step.ID
+			st.Status = InProgress
+		}
+		st.Steps = append(st.Steps, ss)
+	}
+	if g.d.Progress.Dismissed != nil {
+		st.Status = Dismissed
+	}
+	return st
+}
+
+func (g *Guide) capability(id string) (Capability, bool) {
+	for _, c := range g.d.Capabilities {
+		if c.ID == id {
+			return c, true
+		}
+	}
+	return Capability{}, false
+}
+
+// Complete marks the current step, id, done.
+func (g *Guide) Complete(id string) (State, error) {
+	return g.advance(id, func(step StepState) error {
+		if step.Status == StepBlocked {
+			return ErrBlocked
+		}
+		mark(&g.d.Progress.Done, id)
+		return nil
+	})
+}
+
+// Skip passes over the current step, id, which must be optional.
+func (g *Guide) Skip(id string) (State, error) {
+	return g.advance(id, func(step StepState) error {
+		if !step.Optional {
+			return ErrRequired
+		}
+		mark(&g.d.Progress.Skipped, id)
+		return nil
+	})
+}
+
+func (g *Guide) advance(id string, f func(StepState) error) (State, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	st := g.state()
+	i := slices.IndexFunc(st.Steps, func(s StepState) bool { return s.ID == id })
+	if i < 0 {
+		return st, fmt.Errorf("%w: %q", ErrUnknownStep, id)
+	}
+	if st.Current != id {
+		return st, fmt.Errorf("%w: %q is %s; the current step is %q", ErrNotCurrent, id, st.Steps[i].Status, st.Current)
+	}
+	if err := f(st.Steps[i]); err != nil {
+		return st, err
+	}
+	return g.state(), nil
+}
+
+func mark(m *map[string]time.Time, id string) {
+	if *m == nil {
+		*m = make(map[string]time.Time)
+	}
+	(*m)[id] = time.Now().UTC().Truncate(time.Second)
+}
+
+// Dismiss stops guiding the user, with or without the flow complete.
+func (g *Guide) Dismiss() State {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now().UTC().Truncate(time.Second)
+	g.d.Progress.Dismissed = &now
+	return g.state()
+}
+
+// Progress returns the user's progress, for the host to keep.
+func (g *Guide) Progress() Progress {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.d.Progress
+	p.Done, p.Skipped = maps.Clone(p.Done), maps.Clone(p.Skipped)
+	return p
+}
@@ -0,0 +1,115 @@
+package onboarding
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"sketch.dev/llm/ant"
+)
+
+func discovery(available ...string) Discovery {
+	d := Discovery{FirstRun: true}
+	for _, id := range []string{CapModels, CapDocker, CapSSH, CapSkaband} {
+		d.Capabilities = append(d.Capabilities, Capability{ID: id, Available: slices.Contains(available, id), Fix: "fix " + id})
+	}
+	return d
+}
+
+func TestGuide(t *testing.T) {
+	g := NewGuide(discovery(CapModels, CapSkaband))
+	st := g.State()
+	if st.Status != InProgress || st.Current != "docker" {
+		t.Fatalf("starts %s at %q, want in-progress at docker", st.Status, st.Current)
+	}
+	if st.Steps[0].Status != StepDone || st.Steps[1].Status != StepBlocked || st.Steps[1].Fix != "fix docker" {
+		t.Errorf("steps %+v", st.Steps[:2])
+	}
+
+	if _, err := g.Complete("docker"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("completing a blocked step: %v", err)
+	}
+	if _, err := g.Complete("first-task"); !errors.Is(err, ErrNotCurrent) {
+		t.Errorf("completing a later step: %v", err)
+	}
+	if _, err := g.Skip("nope"); !errors.Is(err, ErrUnknownStep) {
+		t.Errorf("skipping an unknown step: %v", err)
+	}
+	var err error
+	for _, id := range []string{"docker", "ssh"} {
+		if st, err = g.Skip(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sketch.dev is signed in, so its step is already done.
+	if st.Current != "first-task" {
+		t.Errorf("after skipping to the tasks, current is %q", st.Current)
+	}
+	if _, err := g.Skip("first-task"); !errors.Is(err, ErrRequired) {
+		t.Errorf("skipping a required step: %v", err)
+	}
+	g.Complete("first-task")
+	if st, err = g.Complete("review"); err != nil || st.Status != Complete || st.Current != "" {
+		t.Errorf("after the last step: %s at %q, %v", st.Status, st.Current, err)
+	}
+
+	p := g.Progress()
+	if len(p.Done) != 2 || len(p.Skipped) != 2 || p.Dismissed != nil {
+		t.Errorf("progress %+v", p)
+	}
+
+	// A blocked required step keeps the flow from moving on, but not from
+	// being dismissed.
+	g = NewGuide(discovery())
+	if st := g.State(); st.Current != "model" || st.Steps[0].Status != StepBlocked {
+		t.Errorf("with no models, current is %q", st.Current)
+	}
+	if st := g.Dismiss(); st.Status != Dismissed || st.Current != "model" {
+		t.Errorf("dismissed: %s at %q", st.Status, st.Current)
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sketch", "onboarding.json")
+	if _, found, err := Load(path); found || err != nil {
+		t.Fatalf("Load of nothing = %v, %v", found, err)
+	}
+	g := NewGuide(discovery(CapModels, CapDocker))
+	g.Skip("ssh")
+	if err := g.Progress().Save(path); err != nil {
+		t.Fatal(err)
+	}
+	p, found, err := Load(path)
+	if !found || err != nil {
+		t.Fatalf("Load = %v, %v", found, err)
+	}
+	if _, ok := p.Skipped["ssh"]; !ok {
+		t.Errorf("loaded %+v", p)
+	}
+
+	// Picked up again, the flow carries on where it was.
+	if st := NewGuide(Discovery{Capabilities: discovery(CapModels, CapDocker).Capabilities, Progress: p}).State(); st.Current != "skaband" {
+		t.Errorf("resumed at %q, want skaband", st.Current)
+	}
+}
+
+func TestModelsWithKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // no Anthropic OAuth token
+	env := map[string]string{
+		ant.APIKeyEnv:    "k",
+		"GEMINI_API_KEY": "k",
+	}
+	models := modelsWithKeys(func(k string) string { return env[k] })
+	for _, want := range []string{"claude", "gemini"} {
+		if !slices.Contains(models, want) {
+			t.Errorf("models %q lack %s", models, want)
+		}
+	}
+	if slices.Contains(models, "gpt4.1") {
+		t.Errorf("models %q include gpt4.1 without OPENAI_API_KEY", models)
+	}
+	if models := modelsWithKeys(func(string) string { return "" }); len(models) != 0 {
+		t.Errorf("models with no keys: %q", models)
+	}
+}
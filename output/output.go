@@ -0,0 +1,181 @@
+// Package output renders the status lines sketch prints outside of termui:
+// banners, launch progress, warnings, and links.
+//
+// The default fancy mode decorates lines with emoji and box drawing. That
+// garbles CI logs and dumb terminals, so plain and CI modes print bare text,
+// and JSON mode prints one JSON object per line for programs to consume.
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// A Mode selects how lines are rendered.
+type Mode string
+
+const (
+	Auto  Mode = "auto"  // detect from the environment; see Detect
+	Fancy Mode = "fancy" // emoji, box drawing, and spinners, for terminals
+	Plain Mode = "plain" // bare text
+	CI    Mode = "ci"    // bare text, with warnings as CI annotations where the CI understands them
+	JSON  Mode = "json"  // one JSON object per line
+)
+
+// ParseMode parses a -output flag value.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case Auto, Fancy, Plain, CI, JSON:
+		return m, nil
+	default:
+		return "", fmt.Errorf("invalid output mode %q: want auto, fancy, plain, ci, or json", s)
+	}
+}
+
+// Detect picks a mode for output to f: CI under a CI system, plain when
+// NO_COLOR is set, TERM is dumb, or f isn't a terminal, and fancy otherwise.
+func Detect(f *os.File) Mode {
+	switch {
+	case os.Getenv("CI") != "" || os.Getenv("GITHUB_ACTIONS") != "":
+		return CI
+	case os.Getenv("NO_COLOR") != "", os.Getenv("TERM") == "dumb", !term.IsTerminal(int(f.Fd())):
+		return Plain
+	default:
+		return Fancy
+	}
+}
+
+// A Level classifies a line.
+type Level string
+
+const (
+	Info     Level = "info"
+	Success  Level = "success"
+	Warning  Level = "warning"
+	Progress Level = "progress"
+)
+
+// A Renderer writes lines in one mode.
+type Renderer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	mode Mode
+	now  func() time.Time
+}
+
+// New returns a Renderer writing to w. mode must not be Auto.
+func New(w io.Writer, mode Mode) *Renderer {
+	return &Renderer{w: w, mode: mode, now: time.Now}
+}
+
+// Mode returns the mode r renders in.
+func (r *Renderer) Mode() Mode { return r.mode }
+
+// Emit writes a line. icon, such as "📦", shows only in fancy mode; fields
+// add detail to JSON lines, and are otherwise left out.
+func (r *Renderer) Emit(level Level, icon, msg string, fields map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch r.mode {
+	case JSON:
+		line := map[string]any{"time": r.now().UTC().Format(time.RFC3339Nano), "level": level, "msg": msg}
+		for k, v := range fields {
+			line[k] = v
+		}
+		b, _ := json.Marshal(line)
+		fmt.Fprintf(r.w, "%s\n", b)
+	case Fancy:
+		if icon == "" {
+			fmt.Fprintln(r.w, msg)
+		} else {
+			fmt.Fprintf(r.w, "%s %s\n", icon, msg)
+		}
+	case CI:
+		if level == Warning && os.Getenv("GITHUB_ACTIONS") != "" {
+			fmt.Fprintf(r.w, "::warning::%s\n", strings.ReplaceAll(msg, "\n", "%0A"))
+			return
+		}
+		fallthrough
+	default:
+		if level == Warning {
+			msg = "warning: " + msg
+		}
+		fmt.Fprintln(r.w, msg)
+	}
+}
+
+// Banner writes a titled block of lines, boxed in fancy mode.
+func (r *Renderer) Banner(title string, lines ...string) {
+	if r.mode == JSON {
+		r.Emit(Info, "", title, map[string]any{"lines": lines})
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mode != Fancy {
+		fmt.Fprintf(r.w, "%s:\n", title)
+		for _, l := range lines {
+			fmt.Fprintf(r.w, "  %s\n", l)
+		}
+		return
+	}
+	width := len([]rune(title))
+	for _, l := range lines {
+		width = max(width, len([]rune(l))+2)
+	}
+	row := func(s string) {
+		fmt.Fprintf(r.w, "│ %s%s │\n", s, strings.Repeat(" ", width-len([]rune(s))))
+	}
+	fmt.Fprintln(r.w)
+	fmt.Fprintf(r.w, "┌%s┐\n", strings.Repeat("─", width+2))
+	row(title)
+	row("")
+	for _, l := range lines {
+		row("• " + l)
+	}
+	fmt.Fprintf(r.w, "└%s┘\n", strings.Repeat("─", width+2))
+	fmt.Fprintln(r.w)
+}
+
+// std is the Renderer the package-level functions use.
+var std = New(os.Stdout, Fancy)
+
+// SetMode makes the package-level functions render to stdout in mode,
+// detecting it if mode is Auto. It returns the mode chosen.
+func SetMode(mode Mode) Mode {
+	if mode == Auto {
+		mode = Detect(os.Stdout)
+	}
+	std = New(os.Stdout, mode)
+	return mode
+}
+
+// Default returns the Renderer the package-level functions use.
+func Default() *Renderer { return std }
+
+// Printf writes an informational line, decorated with icon in fancy mode.
+func Printf(icon, format string, args ...any) {
+	std.Emit(Info, icon, fmt.Sprintf(format, args...), nil)
+}
+
+// Successf writes a line reporting that something finished.
+func Successf(format string, args ...any) {
+	std.Emit(Success, "✅", fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf writes a warning.
+func Warnf(format string, args ...any) {
+	std.Emit(Warning, "⚠️ ", fmt.Sprintf(format, args...), nil)
+}
+
+// Banner writes a titled block of lines.
+func Banner(title string, lines ...string) {
+	std.Banner(title, lines...)
+}
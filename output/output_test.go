@@ -0,0 +1,86 @@
+package output
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmit(t *testing.T) {
+	t.Setenv("GITHUB_ACTIONS", "")
+	tests := []struct {
+		mode Mode
+		want string
+	}{
+		{Fancy, "📦 started\n⚠️  slow disk\n"},
+		{Plain, "started\nwarning: slow disk\n"},
+		{CI, "started\nwarning: slow disk\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		r := New(&b, tt.mode)
+		r.Emit(Info, "📦", "started", map[string]any{"ignored": true})
+		r.Emit(Warning, "⚠️ ", "slow disk", nil)
+		if b.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.mode, b.String(), tt.want)
+		}
+	}
+}
+
+func TestEmitGitHubActions(t *testing.T) {
+	t.Setenv("GITHUB_ACTIONS", "true")
+	var b strings.Builder
+	New(&b, CI).Emit(Warning, "", "two\nlines", nil)
+	if want := "::warning::two%0Alines\n"; b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestEmitJSON(t *testing.T) {
+	var b strings.Builder
+	r := New(&b, JSON)
+	r.now = func() time.Time { return time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC) }
+	r.Emit(Progress, "🐋", "pulling", map[string]any{"percent": 40})
+	r.Banner("checks", "one", "two")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), b.String())
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["level"] != "progress" || got["msg"] != "pulling" || got["percent"] != 40.0 || got["time"] != "2025-01-02T03:04:05Z" {
+		t.Errorf("progress line = %v", got)
+	}
+	if want := `{"level":"info","lines":["one","two"],"msg":"checks","time":"2025-01-02T03:04:05Z"}`; lines[1] != want {
+		t.Errorf("banner line = %s, want %s", lines[1], want)
+	}
+}
+
+func TestBanner(t *testing.T) {
+	var b strings.Builder
+	New(&b, Plain).Banner("Checks", "docker missing")
+	if want := "Checks:\n  docker missing\n"; b.String() != want {
+		t.Errorf("plain banner = %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	New(&b, Fancy).Banner("Checks", "docker missing")
+	if !strings.Contains(b.String(), "│ • docker missing │") || !strings.Contains(b.String(), "┌") {
+		t.Errorf("fancy banner:\n%s", b.String())
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, s := range []string{"auto", "fancy", "plain", "ci", "json"} {
+		if m, err := ParseMode(s); err != nil || string(m) != s {
+			t.Errorf("ParseMode(%q) = %q, %v", s, m, err)
+		}
+	}
+	if _, err := ParseMode("color"); err == nil {
+		t.Error("ParseMode accepted an unknown mode")
+	}
+}
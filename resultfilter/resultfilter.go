@@ -0,0 +1,286 @@
+// Package resultfilter trims noise from tool results before the model reads
+// them, as a repository's .sketchfilters file configures: terminal escape
+// codes, repeated stack frames, timestamps that change from run to run, and
+// whatever its own regular expressions match.
+//
+// Each line of the file names a filter, after the tools it applies to if not
+// all of them:
+//
+//	# Filters run in this order.
+//	ansi
+//	bash, run_tests: timestamps
+//	bash: stack-frames
+//	regex s/tmp\.[A-Za-z0-9]+/tmp.XXXX/
+//
+// The built-in filters are ansi, stack-frames, timestamps, and blank-lines;
+// regex takes a sed-style s/RE/REPLACEMENT/ argument, with any delimiter, Go
+// regular expression syntax, $1 for submatches, and an optional i flag.
+// Blank lines and lines starting with # are ignored.
+package resultfilter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sketch.dev/llm"
+)
+
+// FileName is the name of the filter file, at the repository root.
+const FileName = ".sketchfilters"
+
+// builtins are the filters that take no argument.
+var builtins = map[string]func(string) string{
+	"ansi":         stripANSI,
+	"stack-frames": collapseRepeats,
+	"timestamps":   dropTimestamps,
+	"blank-lines":  collapseBlankLines,
+}
+
+// A Pipeline is the filters of a .sketchfilters file, in order.
+// A nil Pipeline changes nothing.
+type Pipeline struct {
+	filters []filter
+	source  string
+}
+
+type filter struct {
+	name  string
+	tools []string // nil for every tool
+	apply func(string) string
+}
+
+// Load reads the .sketchfilters file in repoRoot. It returns nil if there is none.
+func Load(repoRoot string) (*Pipeline, error) {
+	data, err := os.ReadFile(filepath.Join(repoRoot, FileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FileName, err)
+	}
+	return p, nil
+}
+
+// toolsPrefix matches the tool list that scopes a filter, as in "bash, run_tests: ansi".
+var toolsPrefix = regexp.MustCompile(`^([\w.-]+(?:\s*,\s*[\w.-]+)*)\s*:\s*`)
+
+// Parse parses the contents of a .sketchfilters file.
+func Parse(data string) (*Pipeline, error) {
+	p := &Pipeline{source: data}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var f filter
+		if m := toolsPrefix.FindStringSubmatch(line); m != nil {
+			for tool := range strings.SplitSeq(m[1], ",") {
+				f.tools = append(f.tools, strings.TrimSpace(tool))
+			}
+			line = line[len(m[0]):]
+		}
+		name, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		f.name = name
+		if name == "regex" {
+			re, repl, err := parseSubstitution(arg)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			f.apply = func(s string) string { return re.ReplaceAllString(s, repl) }
+		} else if apply, ok := builtins[name]; ok {
+			if arg != "" {
+				return nil, fmt.Errorf("line %d: the %s filter takes no argument", n, name)
+			}
+			f.apply = apply
+		} else {
+			return nil, fmt.Errorf("line %d: unknown filter %q (want ansi, stack-frames, timestamps, blank-lines, or regex)", n, name)
+		}
+		p.filters = append(p.filters, f)
+	}
+	return p, nil
+}
+
+// parseSubstitution parses the argument of a regex filter: s/RE/REPLACEMENT/,
+// where the character after s is the delimiter and a backslash escapes it.
+func parseSubstitution(arg string) (*regexp.Regexp, string, error) {
+	if len(arg) < 2 || arg[0] != 's' {
+		return nil, "", fmt.Errorf("regex filter %q: want s/RE/REPLACEMENT/", arg)
+	}
+	delim := arg[1]
+	var parts []string
+	var cur strings.Builder
+	for i := 2; i < len(arg); i++ {
+		switch {
+		case arg[i] == '\\' && i+1 < len(arg) && arg[i+1] == delim:
+			cur.WriteByte(delim)
+			i++
+		case arg[i] == delim:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(arg[i])
+		}
+	}
+	if len(parts) != 2 {
+		return nil, "", fmt.Errorf("regex filter %q: want s/RE/REPLACEMENT/", arg)
+	}
+	expr := parts[0]
+	switch flags := cur.String(); flags {
+	case "":
+	case "i":
+		expr = "(?i)" + expr
+	default:
+		return nil, "", fmt.Errorf("regex filter %q: unknown flags %q (want i)", arg, flags)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, "", fmt.Errorf("regex filter %q: %w", arg, err)
+	}
+	return re, parts[1], nil
+}
+
+// Apply runs the filters that apply to tool over text.
+func (p *Pipeline) Apply(tool, text string) string {
+	if p == nil {
+		return text
+	}
+	for _, f := range p.filters {
+		if f.tools == nil || slices.Contains(f.tools, tool) {
+			text = f.apply(text)
+		}
+	}
+	return text
+}
+
+// Wrap returns tool with its text results and errors to the model filtered,
+// since a failed command's output comes back as its error. What the user
+// sees of the results is left as is.
+func (p *Pipeline) Wrap(tool *llm.Tool) *llm.Tool {
+	if p == nil || !slices.ContainsFunc(p.filters, func(f filter) bool { return f.tools == nil || slices.Contains(f.tools, tool.Name) }) {
+		return tool
+	}
+	wrapped := *tool
+	run := tool.Run
+	wrapped.Run = func(ctx context.Context, input json.RawMessage) llm.ToolOut {
+		out := run(ctx, input)
+		before, after := 0, 0
+		if out.Error != nil {
+			text := out.Error.Error()
+			filtered := p.Apply(tool.Name, text)
+			before, after = len(text), len(filtered)
+			out.Error = errors.New(filtered)
+		}
+		contents := make([]llm.Content, len(out.LLMContent))
+		for i, c := range out.LLMContent {
+			if c.Type == llm.ContentTypeText {
+				before += len(c.Text)
+				c.Text = p.Apply(tool.Name, c.Text)
+				after += len(c.Text)
+			}
+			contents[i] = c
+		}
+		if after < before {
+			slog.DebugContext(ctx, "filtered tool result", "tool", tool.Name, "bytes", before, "kept", after)
+		}
+		out.LLMContent = contents
+		return out
+	}
+	return &wrapped
+}
+
+// String returns the file p was parsed from, "" for nil.
+func (p *Pipeline) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.source
+}
+
+// ansiEscape matches CSI sequences (colors, cursor movement), OSC sequences
+// (titles, hyperlinks), and the remaining two-character escapes.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+func stripANSI(s string) string {
+	return ansiEscape.ReplaceAllString(s, "")
+}
+
+// maxRepeatedLines is the longest run of lines collapseRepeats looks for
+// repeats of, enough for a frame of most languages' stack traces.
+const maxRepeatedLines = 8
+
+// collapseRepeats replaces the third and later consecutive copies of a run of
+// lines, such as the frames of a deep recursion, with a line saying how many
+// there were.
+func collapseRepeats(s string) string {
+	lines := strings.Split(s, "\n")
+	var out []string
+	for i := 0; i < len(lines); {
+		collapsed := false
+		for k := 1; k <= maxRepeatedLines && i+2*k <= len(lines); k++ {
+			block := lines[i : i+k]
+			reps := 1
+			for i+(reps+1)*k <= len(lines) && slices.Equal(lines[i+reps*k:i+(reps+1)*k], block) {
+				reps++
+			}
+			if reps < 3 || slices.ContainsFunc(block, func(l string) bool { return strings.TrimSpace(l) == "" }) {
+				continue
+			}
+			out = append(out, block...)
+			out = append(out, block...)
+			if k == 1 {
+				out = append(out, fmt.Sprintf("[line repeated %d more times]", reps-2))
+			} else {
+				out = append(out, fmt.Sprintf("[previous %d lines repeated %d more times]", k, reps-2))
+			}
+			i += reps * k
+			collapsed = true
+			break
+		}
+		if !collapsed {
+			out = append(out, lines[i])
+			i++
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// timestamps match dates and times of day in common log formats, with the
+// space after them, and the durations go test reports per test and package.
+var timestamps = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`\b\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?\b ?`), ""},
+	{regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(?:[.,]\d+)?\b ?`), ""},
+	{regexp.MustCompile(`(?m)^(\s*--- (?:PASS|FAIL|SKIP): \S+) \(\d+(?:\.\d+)?s\)`), "$1"},
+	{regexp.MustCompile(`(?m)^((?:ok|FAIL)\s+\S+)\s+\d+(?:\.\d+)?s\b`), "$1"},
+}
+
+func dropTimestamps(s string) string {
+	for _, t := range timestamps {
+		s = t.re.ReplaceAllString(s, t.repl)
+	}
+	return s
+}
+
+var blankLines = regexp.MustCompile(`\n(?:[ \t]*\n){2,}`)
+
+// collapseBlankLines leaves at most one blank line in a row.
+func collapseBlankLines(s string) string {
+	return blankLines.ReplaceAllString(s, "\n\n")
+}
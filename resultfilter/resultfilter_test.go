@@ -0,0 +1,161 @@
+package resultfilter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sketch.dev/llm"
+)
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name, filters, tool, in, want string
+	}{
+		{
+			name:    "ansi",
+			filters: "ansi",
+			in:      "\x1b[1;32mok\x1b[0m \x1b]8;;https://example.com\x07link\x1b]8;;\x07\x1b[2K",
+			want:    "ok link",
+		},
+		{
+			name:    "stack frames",
+			filters: "stack-frames",
+			in:      "panic: boom\nmain.f()\n\tmain.go:3\nmain.f()\n\tmain.go:3\nmain.f()\n\tmain.go:3\nmain.f()\n\tmain.go:3\nmain.main()",
+			want:    "panic: boom\nmain.f()\n\tmain.go:3\nmain.f()\n\tmain.go:3\n[previous 2 lines repeated 2 more times]\nmain.main()",
+		},
+		{
+			name:    "repeated line",
+			filters: "stack-frames",
+			in:      "a\nx\nx\nx\nx\nx\nb",
+			want:    "a\nx\nx\n[line repeated 3 more times]\nb",
+		},
+		{
+			name:    "two copies stay",
+			filters: "stack-frames",
+			in:      "x\nx\n\n\n\n",
+			want:    "x\nx\n\n\n\n",
+		},
+		{
+			name:    "timestamps",
+			filters: "timestamps",
+			in:      "2025/06/01 12:00:00 starting\n2025-06-01T12:00:00.123Z listening\n12:00:01.5 done\n--- PASS: TestX (0.03s)\nok  \tsketch.dev/llm\t0.123s",
+			want:    "starting\nlistening\ndone\n--- PASS: TestX\nok  \tsketch.dev/llm",
+		},
+		{
+			name:    "blank lines",
+			filters: "blank-lines",
+			in:      "a\n\n\n  \nb\n\nc",
+			want:    "a\n\nb\n\nc",
+		},
+		{
+			name:    "regex",
+			filters: `regex s|/tmp/go-build\d+|/tmp/go-buildN|` + "\n" + `regex s/(\w+)@example\.COM/<$1>/i`,
+			in:      "/tmp/go-build123/b001 from bob@example.com",
+			want:    "/tmp/go-buildN/b001 from <bob>",
+		},
+		{
+			name:    "escaped delimiter",
+			filters: `regex s/a\/b/c/`,
+			in:      "a/b",
+			want:    "c",
+		},
+		{
+			name:    "scoped to other tools",
+			filters: "bash, run_tests: ansi",
+			tool:    "keyword_search",
+			in:      "\x1b[1mx\x1b[0m",
+			want:    "\x1b[1mx\x1b[0m",
+		},
+		{
+			name:    "scoped to this tool",
+			filters: "  # comment\n\nbash, run_tests: ansi",
+			tool:    "run_tests",
+			in:      "\x1b[1mx\x1b[0m",
+			want:    "x",
+		},
+		{
+			name:    "in order",
+			filters: "timestamps\nstack-frames",
+			in:      "12:00:01 retrying\n12:00:02 retrying\n12:00:03 retrying\n12:00:04 retrying",
+			want:    "retrying\nretrying\n[line repeated 2 more times]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Parse(tt.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tool := tt.tool
+			if tool == "" {
+				tool = "bash"
+			}
+			if got := p.Apply(tool, tt.in); got != tt.want {
+				t.Errorf("Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		"colors",
+		"ansi please",
+		"regex /x/y/",
+		"regex s/x/",
+		"regex s/(/x/",
+		"regex s/x/y/g",
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Parse(%q) succeeded", data)
+		}
+	}
+	// A regex whose delimiter is a colon isn't a tool list.
+	p, err := Parse("regex s:a:b:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Apply("bash", "a"); got != "b" {
+		t.Errorf("Apply = %q, want b", got)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	dir := t.TempDir()
+	if p, err := Load(dir); p != nil || err != nil {
+		t.Fatalf("Load without a file = %v, %v", p, err)
+	}
+	os.WriteFile(filepath.Join(dir, FileName), []byte("bash: ansi\n"), 0o644)
+	p, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bash := &llm.Tool{Name: "bash", Run: func(context.Context, json.RawMessage) llm.ToolOut {
+		return llm.ToolOut{LLMContent: llm.TextContent("\x1b[31mred\x1b[0m"), Display: "\x1b[31mred\x1b[0m"}
+	}}
+	out := p.Wrap(bash).Run(context.Background(), nil)
+	if out.LLMContent[0].Text != "red" || out.Display != "\x1b[31mred\x1b[0m" {
+		t.Errorf("wrapped bash = %q, display %q", out.LLMContent[0].Text, out.Display)
+	}
+	failing := &llm.Tool{Name: "bash", Run: func(context.Context, json.RawMessage) llm.ToolOut {
+		return llm.ErrorToolOut(fmt.Errorf("[command failed: exit status 1]\n%s", "\x1b[31mFAIL\x1b[0m"))
+	}}
+	if out := p.Wrap(failing).Run(context.Background(), nil); out.Error == nil || out.Error.Error() != "[command failed: exit status 1]\nFAIL" {
+		t.Errorf("wrapped failing bash = %v", out.Error)
+	}
+	other := &llm.Tool{Name: "patch"}
+	if p.Wrap(other) != other {
+		t.Errorf("wrapped a tool no filter applies to")
+	}
+
+	os.WriteFile(filepath.Join(dir, FileName), []byte("bash: nope\n"), 0o644)
+	if _, err := Load(dir); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Load of a bad file = %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Package scaffold creates new repositories from project templates, for
+// "sketch new". Templates are either built in (see Templates) or fetched
+// from a git repository.
+package scaffold
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed all:templates
+var templatesFS embed.FS
+
+// A Template is a built-in project template.
+type Template struct {
+	Name        string
+	Description string
+	// prompt tells the agent what it is looking at; it's a text/template of Params.
+	prompt string
+}
+
+// Templates are the built-in templates.
+var Templates = []Template{
+	{
+		Name:        "go-service",
+		Description: "Go HTTP service with a health check and graceful shutdown",
+		prompt: `This repository is {{.Name}}, a Go HTTP service (module {{.Module}}) I just scaffolded: main.go serves GET /healthz and shuts down gracefully, and main_test.go tests it.
+Read it, then build the service on that skeleton: add handlers in newMux with tests for each, keep the standard library unless a dependency is clearly worth it, and update README.md as you go.`,
+	},
+	{
+		Name:        "cli",
+		Description: "Go command-line tool using the flag package",
+		prompt: `This repository is {{.Name}}, a Go command-line tool (module {{.Module}}) I just scaffolded: main.go parses flags and hands the arguments to run, which main_test.go tests.
+Read it, then build the tool on that skeleton: keep main thin and the logic in testable functions, report errors on stderr with a non-zero exit, and document usage in README.md.`,
+	},
+	{
+		Name:        "web-app",
+		Description: "Web app built with Vite and plain JavaScript",
+		prompt: `This repository is {{.Name}}, a web app I just scaffolded with Vite: index.html loads src/main.js and src/style.css, and package.json has the dev, build and preview scripts.
+Run npm install, then build the app on that skeleton; check your work in the browser with npm run dev, and keep npm run build passing.`,
+	},
+}
+
+// fetchedPrompt is the prompt for templates fetched from a repository, which sketch knows nothing about.
+const fetchedPrompt = `This repository is {{.Name}}, which I just created from the project template at {{.Source}}.
+Read it to learn its layout, build and test commands, then build the project on that skeleton.`
+
+// Params fill in a template.
+type Params struct {
+	Name   string // the project's name; defaults to the directory's base name
+	Module string // the Go module path; defaults to Name
+	Source string // where a fetched template came from
+}
+
+// Lookup returns the built-in template with the given name.
+func Lookup(name string) (Template, bool) {
+	for _, t := range Templates {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// IsRemote reports whether name is a git URL to fetch a template from,
+// rather than the name of a built-in template.
+func IsRemote(name string) bool {
+	return strings.Contains(name, "://") || strings.HasPrefix(name, "git@") || strings.HasSuffix(name, ".git")
+}
+
+// Write fills in t with p into dir, which must not exist or be empty.
+// Each file's name drops its .tmpl suffix.
+func (t Template) Write(dir string, p Params) error {
+	if err := checkEmpty(dir); err != nil {
+		return err
+	}
+	root := path.Join("templates", t.Name)
+	return fs.WalkDir(templatesFS, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		text, err := templatesFS.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		out, err := execute(name, string(text), p)
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimSuffix(strings.TrimPrefix(name, root+"/"), ".tmpl")
+		dst := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(dst, out, 0o644)
+	})
+}
+
+// Prompt returns the first request to the agent about a project made from t.
+func (t Template) Prompt(p Params) (string, error) {
+	out, err := execute(t.Name, t.prompt, p)
+	return string(out), err
+}
+
+// FetchedPrompt returns the first request to the agent about a project made by Fetch.
+func FetchedPrompt(p Params) (string, error) {
+	out, err := execute("fetched", fetchedPrompt, p)
+	return string(out), err
+}
+
+func execute(name, text string, p Params) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Fetch copies the files of the template repository at url into dir, which
+// must not exist or be empty, without its history.
+func Fetch(ctx context.Context, url, dir string) error {
+	if err := checkEmpty(dir); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "git", "clone", "--quiet", "--depth", "1", url, dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("fetching template %s: %s: %w", url, bytes.TrimSpace(out), err)
+	}
+	return os.RemoveAll(filepath.Join(dir, ".git"))
+}
+
+// InitRepo makes dir a git repository on branch main whose first commit,
+// with the given message, holds its files.
+func InitRepo(ctx context.Context, dir, message string) error {
+	for _, args := range [][]string{
+		{"init", "--quiet", "--initial-branch", "main"},
+		{"add", "--all"},
+		{"commit", "--quiet", "--no-verify", "-m", message},
+	} {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %s: %w", args[0], bytes.TrimSpace(out), err)
+		}
+	}
+	return nil
+}
+
+func checkEmpty(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package scaffold
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplates(t *testing.T) {
+	for _, tmpl := range Templates {
+		t.Run(tmpl.Name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "hello")
+			p := Params{Name: "hello", Module: "example.com/hello"}
+			if err := tmpl.Write(dir, p); err != nil {
+				t.Fatal(err)
+			}
+			readme, err := os.ReadFile(filepath.Join(dir, "README.md"))
+			if err != nil || !strings.HasPrefix(string(readme), "# hello\n") {
+				t.Errorf("README.md = %q, %v", readme, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, ".gitignore")); err != nil {
+				t.Error(err)
+			}
+			prompt, err := tmpl.Prompt(p)
+			if err != nil || !strings.Contains(prompt, "hello") {
+				t.Errorf("Prompt = %q, %v", prompt, err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+				return
+			}
+			if _, err := exec.LookPath("go"); err != nil {
+				t.Skip("no go")
+			}
+			cmd := exec.Command("go", "vet", "./...")
+			cmd.Dir = dir
+			cmd.Env = append(os.Environ(), "GOWORK=off", "GOFLAGS=")
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("go vet: %s: %v", out, err)
+			}
+		})
+	}
+
+	if err := Templates[0].Write(t.TempDir(), Params{Name: "x", Module: "x"}); err != nil {
+		t.Errorf("writing into an empty directory: %v", err)
+	}
+	full := t.TempDir()
+	os.WriteFile(filepath.Join(full, "keep"), nil, 0o644)
+	if err := Templates[0].Write(full, Params{Name: "x", Module: "x"}); err == nil {
+		t.Error("wrote into a directory that isn't empty")
+	}
+}
+
+func TestFetchAndInit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+	config := filepath.Join(t.TempDir(), "gitconfig")
+	os.WriteFile(config, []byte("[user]\n\tname = Test\n\temail = test@example.com\n"), 0o600)
+	t.Setenv("GIT_CONFIG_GLOBAL", config)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	ctx := context.Background()
+
+	src := filepath.Join(t.TempDir(), "template")
+	os.MkdirAll(src, 0o755)
+	os.WriteFile(filepath.Join(src, "Makefile"), []byte("all:\n"), 0o644)
+	if err := InitRepo(ctx, src, "template"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "project")
+	if err := Fetch(ctx, "file://"+src, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); !os.IsNotExist(err) {
+		t.Errorf("fetched template kept its history: %v", err)
+	}
+	if err := InitRepo(ctx, dir, "Create project"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("git", "-C", dir, "log", "--format=%s", "main").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "Create project" {
+		t.Errorf("git log = %q, %v", out, err)
+	}
+
+	if !IsRemote("https://github.com/acme/template") || !IsRemote("git@github.com:acme/template.git") || IsRemote("go-service") {
+		t.Error("IsRemote is wrong")
+	}
+}
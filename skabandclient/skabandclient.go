@@ -2,6 +2,7 @@ package skabandclient
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	crand "crypto/rand"
@@ -36,8 +37,54 @@ type SkabandClient struct {
 	addr      string
 	publicKey string
 	client    *http.Client
+
+	mu     sync.Mutex
+	status Status
+	queue  []queuedRequest // requests to send once reconnected, oldest first
+}
+
+// A ConnState is the state of a session's connection to skaband.
+type ConnState string
+
+const (
+	Connecting ConnState = "connecting" // not connected yet
+	Connected  ConnState = "connected"
+	Offline    ConnState = "offline" // disconnected or failing to connect, and retrying
+)
+
+// offlineFeatures are what stops working while skaband is unreachable.
+var offlineFeatures = []string{
+	"session history tools (the sketchdev MCP server)",
+	"the sketch.dev link to this session",
+}
+
+// Status describes the connection to skaband.
+type Status struct {
+	State       ConnState `json:"state"`
+	Since       time.Time `json:"since"`                 // when State last changed
+	LastError   string    `json:"last_error,omitempty"`  // why the connection was lost or couldn't be made
+	Queued      int       `json:"queued,omitempty"`      // requests waiting to be sent until reconnected
+	Unavailable []string  `json:"unavailable,omitempty"` // features that don't work until reconnected
 }
 
+// ErrQueued is returned by requests that couldn't reach skaband and will be
+// retried when the connection comes back.
+var ErrQueued = errors.New("skaband unreachable; queued until reconnected")
+
+// maxQueued bounds the requests kept while offline; the oldest are dropped first.
+const maxQueued = 100
+
+type queuedRequest struct {
+	path      string
+	sessionID string
+	body      []byte
+}
+
+const (
+	minRedialDelay = 200 * time.Millisecond
+	maxRedialDelay = 10 * time.Second
+)
+
 func DialAndServe(ctx context.Context, hostURL, sessionID, clientPubKey string, sessionSecret string, h http.Handler) (err error) {
 	// Connect to the server.
 	var conn net.Conn
@@ -290,6 +337,127 @@ func (c *SkabandClient) Addr() string {
 	return c.addr
 }
 
+// Status reports the state of the connection to skaband.
+func (c *SkabandClient) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.status
+	if st.State == "" {
+		st.State = Connecting
+	}
+	st.Queued = len(c.queue)
+	if st.State == Offline {
+		st.Unavailable = offlineFeatures
+	}
+	return st
+}
+
+// setState records a change of connection state and reports whether it was one.
+func (c *SkabandClient) setState(state ConnState, err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.status.LastError = err.Error()
+	}
+	if c.status.State == state {
+		return false
+	}
+	c.status.State = state
+	c.status.Since = time.Now()
+	return true
+}
+
+// SendFeedback reports a user's rating of an agent message in sessionID to skaband,
+// where ratings are aggregated across sessions. feedback is encoded as JSON.
+// While skaband is unreachable, the rating is queued and ErrQueued returned.
+func (c *SkabandClient) SendFeedback(ctx context.Context, sessionID string, feedback any) error {
+	body, err := json.Marshal(feedback)
+	if err != nil {
+		return err
+	}
+	return c.postOrQueue(ctx, queuedRequest{path: "/feedback", sessionID: sessionID, body: body})
+}
+
+// postOrQueue sends r, or queues it if skaband is offline or can't be reached.
+func (c *SkabandClient) postOrQueue(ctx context.Context, r queuedRequest) error {
+	if c.Status().State == Offline {
+		c.enqueue(r)
+		return ErrQueued
+	}
+	retry, err := c.post(ctx, r)
+	if retry {
+		c.enqueue(r)
+		return fmt.Errorf("%w: %w", ErrQueued, err)
+	}
+	return err
+}
+
+// post sends r, reporting whether a failure is worth retrying later.
+func (c *SkabandClient) post(ctx context.Context, r queuedRequest) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.addr+r.path, bytes.NewReader(r.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Public-Key", c.publicKey)
+	req.Header.Set("Session-ID", r.sessionID)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("skaband %s: %w", r.path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode >= 500, fmt.Errorf("skaband %s: %s: %s", r.path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return false, nil
+}
+
+func (c *SkabandClient) enqueue(r queuedRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queue) >= maxQueued {
+		slog.Warn("skaband queue full, dropping oldest request", "path", c.queue[0].path)
+		c.queue = c.queue[1:]
+	}
+	c.queue = append(c.queue, r)
+}
+
+// Flush sends the queued requests in order, stopping at the first that still
+// can't get through, and returns how many remain queued. It is called on
+// reconnecting, and on shutdown so that updates queued while offline aren't lost.
+func (c *SkabandClient) Flush(ctx context.Context) int {
+	for {
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			return 0
+		}
+		r := c.queue[0]
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+
+		reqCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		retry, err := c.post(reqCtx, r)
+		cancel()
+		if retry {
+			c.mu.Lock()
+			c.queue = append([]queuedRequest{r}, c.queue...)
+			n := len(c.queue)
+			c.mu.Unlock()
+			return n
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "sending queued request to skaband", "error", err)
+		}
+	}
+}
+
+// nextRedialDelay doubles d, up to maxRedialDelay.
+func nextRedialDelay(d time.Duration) time.Duration {
+	return min(2*d, maxRedialDelay)
+}
+
 // NewSkabandClient creates a new skaband client
 func NewSkabandClient(addr, publicKey string) *SkabandClient {
 	// Apply localhost-to-docker-internal transformation if needed
@@ -306,7 +474,9 @@ func NewSkabandClient(addr, publicKey string) *SkabandClient {
 	}
 }
 
-// DialAndServeLoop is a redial loop around DialAndServe.
+// DialAndServeLoop is a redial loop around DialAndServe. It tracks the
+// connection state reported by Status and sends requests queued while offline
+// once reconnected.
 func (c *SkabandClient) DialAndServeLoop(ctx context.Context, sessionID string, sessionSecret string, srv http.Handler, connectFn func(connected bool)) {
 	skabandAddr := c.addr
 	clientPubKey := c.publicKey
@@ -331,6 +501,8 @@ func (c *SkabandClient) DialAndServeLoop(ctx context.Context, sessionID string,
 				return
 			}
 			skabandConnected.Store(true)
+			c.setState(Connected, nil)
+			go c.Flush(ctx)
 			if connectFn != nil {
 				connectFn(true)
 			}
@@ -340,23 +512,32 @@ func (c *SkabandClient) DialAndServeLoop(ctx context.Context, sessionID string,
 	})
 
 	var lastErrLog time.Time
+	delay := minRedialDelay
 	for {
-		if err := DialAndServe(ctx, skabandAddr, sessionID, clientPubKey, sessionSecret, skabandHandler); err != nil {
-			// NOTE: *just* backoff the logging. Backing off dialing
-			// is bad UX. Doing so saves negligible CPU and doing so
-			// without hurting UX requires interrupting the backoff with
-			// wake-from-sleep and network-up events from the OS,
-			// which are a pain to plumb.
+		err := DialAndServe(ctx, skabandAddr, sessionID, clientPubKey, sessionSecret, skabandHandler)
+		if err != nil {
 			if time.Since(lastErrLog) > 1*time.Minute {
 				slog.DebugContext(ctx, "skaband connection failed", "err", err)
 				lastErrLog = time.Now()
 			}
+		} else {
+			err = errors.New("connection closed")
 		}
+		if ctx.Err() != nil {
+			return
+		}
+		c.setState(Offline, err)
 		if skabandConnected.CompareAndSwap(true, false) {
+			delay = minRedialDelay
 			if connectFn != nil {
 				connectFn(false)
 			}
+		} else {
+			// Back off, but not so far that a session coming back from
+			// sleep or a network outage stays offline for long; there is
+			// no OS wake-up or network-up event to interrupt the wait with.
+			delay = nextRedialDelay(delay)
 		}
-		time.Sleep(200 * time.Millisecond)
+		time.Sleep(delay)
 	}
 }
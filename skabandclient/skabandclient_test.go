@@ -1,6 +1,13 @@
 package skabandclient
 
 import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
 	"testing"
 )
 
@@ -116,3 +123,75 @@ func TestNewSessionID_Uniqueness(t *testing.T) {
 		t.Errorf("Expected %d unique session IDs, got %d", count, len(seen))
 	}
 }
+
+func TestSendFeedbackQueuesWhileOffline(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, r.URL.Path+" "+r.Header.Get("Session-ID")+" "+string(b))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	c := &SkabandClient{addr: srv.URL, client: srv.Client()}
+	if st := c.Status(); st.State != Connecting || len(st.Unavailable) != 0 {
+		t.Errorf("initial status = %+v", st)
+	}
+	c.setState(Offline, errors.New("boom"))
+	ctx := context.Background()
+	for _, n := range []int{1, 2} {
+		if err := c.SendFeedback(ctx, "s1", n); !errors.Is(err, ErrQueued) {
+			t.Fatalf("SendFeedback while offline = %v, want ErrQueued", err)
+		}
+	}
+	st := c.Status()
+	if st.State != Offline || st.Queued != 2 || st.LastError != "boom" || len(st.Unavailable) == 0 {
+		t.Errorf("offline status = %+v", st)
+	}
+	if len(got) != 0 {
+		t.Errorf("sent while offline: %q", got)
+	}
+
+	c.setState(Connected, nil)
+	if n := c.Flush(ctx); n != 0 {
+		t.Errorf("Flush left %d queued", n)
+	}
+	if want := []string{"/feedback s1 1", "/feedback s1 2"}; !slices.Equal(got, want) {
+		t.Errorf("flushed %q, want %q", got, want)
+	}
+	if st := c.Status(); st.Queued != 0 || len(st.Unavailable) != 0 {
+		t.Errorf("reconnected status = %+v", st)
+	}
+}
+
+func TestSendFeedbackQueuesUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	addr := srv.URL
+	srv.Close()
+
+	c := &SkabandClient{addr: addr, client: &http.Client{}}
+	c.setState(Connected, nil)
+	if err := c.SendFeedback(context.Background(), "s1", 1); !errors.Is(err, ErrQueued) {
+		t.Fatalf("SendFeedback to a closed server = %v, want ErrQueued", err)
+	}
+	// Still unreachable: the request stays queued.
+	if n := c.Flush(context.Background()); n != 1 {
+		t.Errorf("Flush left %d queued, want 1", n)
+	}
+	if st := c.Status(); st.Queued != 1 {
+		t.Errorf("Queued = %d, want 1", st.Queued)
+	}
+}
+
+func TestNextRedialDelay(t *testing.T) {
+	d := minRedialDelay
+	for range 20 {
+		d = nextRedialDelay(d)
+	}
+	if d != maxRedialDelay {
+		t.Errorf("delay after 20 failures = %v, want %v", d, maxRedialDelay)
+	}
+}
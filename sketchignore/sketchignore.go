@@ -0,0 +1,158 @@
+// Package sketchignore reads a repository's .sketchignore file, which lists paths
+// sketch leaves out of codebase analysis, keyword search, diff stats, and the
+// codebase summary in the system prompt, such as vendored or generated code.
+//
+// The file uses .gitignore syntax: one pattern per line, # for comments, ! to
+// re-include, a trailing / to match only directories, and a leading or inner /
+// to anchor a pattern to the repository root. Unlike git, a negation can
+// re-include a file inside an ignored directory.
+package sketchignore
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the name of the ignore file, at the repository root.
+const FileName = ".sketchignore"
+
+// A Matcher reports which paths a .sketchignore file ignores.
+// A nil Matcher ignores nothing.
+type Matcher struct {
+	patterns []pattern
+	source   string
+}
+
+type pattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Load reads the .sketchignore file in repoRoot. It returns nil if there is none.
+func Load(repoRoot string) (*Matcher, error) {
+	data, err := os.ReadFile(filepath.Join(repoRoot, FileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(data)), nil
+}
+
+// Parse parses the contents of a .sketchignore file.
+func Parse(data string) *Matcher {
+	m := &Matcher{source: data}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p pattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		line = strings.TrimPrefix(line, `\`) // for patterns starting with a literal # or !
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		expr := globToRegexp(line)
+		if !anchored {
+			expr = "(?:.*/)?" + expr
+		}
+		p.re = regexp.MustCompile("^" + expr + "$")
+		m.patterns = append(m.patterns, p)
+	}
+	return m
+}
+
+// globToRegexp translates a gitignore glob, in which * and ? don't match /
+// and ** matches any number of directories.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Ignored reports whether the repo-relative, slash-separated path is ignored,
+// itself or through a parent directory. A path ending in / is a directory.
+func (m *Matcher) Ignored(path string) bool {
+	if m == nil {
+		return false
+	}
+	isDir := strings.HasSuffix(path, "/")
+	path = strings.Trim(path, "/")
+	ignored := false
+	for _, p := range m.patterns {
+		if p.matches(path, isDir) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// matches reports whether p matches path or one of its parent directories.
+func (p pattern) matches(path string, isDir bool) bool {
+	if p.re.MatchString(path) && (isDir || !p.dirOnly) {
+		return true
+	}
+	for i := len(path) - 1; i > 0; i-- {
+		if path[i] == '/' && p.re.MatchString(path[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the file m was parsed from.
+func (m *Matcher) String() string {
+	if m == nil {
+		return ""
+	}
+	return m.source
+}
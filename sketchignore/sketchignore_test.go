@@ -0,0 +1,66 @@
+package sketchignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	m := Parse(`
+# vendored and generated code
+vendor/
+*.pb.go
+/build
+docs/**/*.html
+!vendor/modules.txt
+gen?/
+\#notes
+`)
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{"vendor/github.com/x/y.go", true},
+		{"third_party/vendor/z.go", true},
+		{"vendor/", true},
+		{"vendor", false}, // a file, not the directory
+		{"vendor/modules.txt", false},
+		{"api/v1/service.pb.go", true},
+		{"service.pb.go", true},
+		{"service.go", false},
+		{"build/out.o", true},
+		{"cmd/build/main.go", false}, // anchored
+		{"docs/a/b/page.html", true},
+		{"docs/page.html", true},
+		{"docs/page.md", false},
+		{"gen1/x.go", true},
+		{"gen10/x.go", false},
+		{"#notes", true},
+	} {
+		if got := m.Ignored(tc.path); got != tc.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Load(dir)
+	if m != nil || err != nil {
+		t.Fatalf("Load without a file = %v, %v", m, err)
+	}
+	if m.Ignored("anything") {
+		t.Error("nil Matcher ignored a path")
+	}
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte("testdata/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Ignored("pkg/testdata/big.json") || m.String() != "testdata/\n" {
+		t.Errorf("Load = %q, which doesn't ignore testdata", m)
+	}
+}
@@ -0,0 +1,415 @@
+// Package supervisor runs many sketch sessions side by side in one container,
+// for teams that deploy sketch on a shared dev server.
+//
+// Giving every session its own container costs an image, a docker daemon round
+// trip, and a few hundred megabytes per session. Instead, the supervisor clones
+// a separate workspace for each session and runs sketch in it as a child
+// process in unsafe mode, optionally as a unix user of its own so that sessions
+// can't read or write each other's files. The container is the boundary
+// between sketch and the host; the unix users are the boundaries between sessions.
+//
+// The supervisor's HTTP API creates, lists, and stops sessions, and proxies
+// /s/<session-id>/ to each session's web UI, which is served on a unix socket
+// only the session's user and the supervisor can reach. The API itself has no
+// authentication: whoever can reach it controls every session.
+package supervisor
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"sketch.dev/skabandclient"
+)
+
+// Config configures a Supervisor.
+type Config struct {
+	// Dir holds a directory per session, with its workspace, home, and log.
+	Dir string
+	// Sketch is the sketch binary to run sessions with.
+	Sketch string
+	// Args are extra flags for every session's sketch, such as -model or -skaband-addr.
+	Args []string
+	// Users runs each session, and the clone of its repo, as a unix user of its
+	// own, created for the session and removed with it. It requires running as root.
+	Users bool
+	// MaxSessions, if positive, caps the number of sessions running at once.
+	MaxSessions int
+}
+
+// CreateRequest is the body of a POST /sessions request.
+type CreateRequest struct {
+	Repo   string `json:"repo"`             // git URL or path to clone the workspace from
+	Ref    string `json:"ref,omitempty"`    // branch or tag to check out; the default branch if empty
+	Prompt string `json:"prompt,omitempty"` // first message to the agent
+}
+
+// Session describes a session the supervisor started.
+type Session struct {
+	ID        string     `json:"id"`
+	Repo      string     `json:"repo"`
+	Ref       string     `json:"ref,omitempty"`
+	Workspace string     `json:"workspace"`
+	User      string     `json:"user,omitempty"` // the session's unix user, with Config.Users
+	URL       string     `json:"url"`            // the session's web UI, relative to the supervisor
+	State     string     `json:"state"`          // "running" or "exited"
+	Error     string     `json:"error,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	ExitedAt  *time.Time `json:"exited_at,omitempty"`
+
+	socket    string          // the unix socket the session's sketch serves HTTP on, in its directory
+	transport *http.Transport // to socket
+	cmd       *exec.Cmd
+	done      chan struct{} // closed when the session's sketch exits
+}
+
+// A Supervisor runs sketch sessions as child processes.
+type Supervisor struct {
+	cfg Config
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// errTooManySessions is returned by Create when Config.MaxSessions are running.
+var errTooManySessions = errors.New("too many sessions running")
+
+// New returns a supervisor for cfg. It starts no sessions.
+func New(cfg Config) (*Supervisor, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("supervisor: Dir must be set")
+	}
+	if cfg.Users && os.Geteuid() != 0 {
+		return nil, fmt.Errorf("supervisor: per-session users require running as root")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o711); err != nil {
+		return nil, err
+	}
+	cfg.Sketch = cmp.Or(cfg.Sketch, os.Args[0])
+	return &Supervisor{cfg: cfg, sessions: make(map[string]*Session)}, nil
+}
+
+// Create clones a workspace for req and starts a session in it.
+func (s *Supervisor) Create(ctx context.Context, req CreateRequest) (Session, error) {
+	if req.Repo == "" {
+		return Session{}, fmt.Errorf("repo is required")
+	}
+	if strings.HasPrefix(req.Ref, "-") {
+		return Session{}, fmt.Errorf("invalid ref %q", req.Ref)
+	}
+	if s.cfg.MaxSessions > 0 && s.running() >= s.cfg.MaxSessions {
+		return Session{}, fmt.Errorf("%w: the limit is %d", errTooManySessions, s.cfg.MaxSessions)
+	}
+
+	id := skabandclient.NewSessionID()
+	dir := filepath.Join(s.cfg.Dir, id)
+	sess := &Session{
+		ID:        id,
+		Repo:      req.Repo,
+		Ref:       req.Ref,
+		Workspace: filepath.Join(dir, "workspace"),
+		URL:       "./s/" + id + "/",
+		done:      make(chan struct{}),
+	}
+	started := false
+	defer func() {
+		if !started {
+			s.cleanup(ctx, sess)
+		}
+	}()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Session{}, err
+	}
+	home := filepath.Join(dir, "home")
+	if err := os.MkdirAll(home, 0o700); err != nil {
+		return Session{}, err
+	}
+	var cred *syscall.Credential
+	if s.cfg.Users {
+		var err error
+		sess.User, cred, err = addSessionUser(ctx, id, home, dir)
+		if err != nil {
+			return Session{}, err
+		}
+	}
+
+	// The clone runs as the session's user, so a local path as the repo can't
+	// reach what that user can't, such as another session's workspace.
+	args := []string{"clone", "--quiet"}
+	if req.Ref != "" {
+		args = append(args, "--branch", req.Ref)
+	}
+	args = append(args, "--", req.Repo, sess.Workspace)
+	clone := exec.CommandContext(ctx, "git", args...)
+	if cred != nil {
+		clone.Env = append(os.Environ(), "HOME="+home)
+		clone.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	if out, err := clone.CombinedOutput(); err != nil {
+		return Session{}, fmt.Errorf("cloning %s: %s: %w", req.Repo, strings.TrimSpace(string(out)), err)
+	}
+
+	// A unix socket in the session's directory, unlike a local port, is out of
+	// other sessions' users' reach: the session's web UI has a terminal.
+	sess.socket = filepath.Join(dir, "http.sock")
+	sess.transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sess.socket)
+		},
+	}
+	cmdArgs := slices.Concat(s.cfg.Args, []string{
+		"-unsafe",
+		"-termui=false",
+		"-open=false",
+		"-addr=unix:" + sess.socket,
+		"-session-id=" + id,
+	})
+	if req.Prompt != "" {
+		cmdArgs = append(cmdArgs, "-prompt="+req.Prompt)
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, "sketch.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return Session{}, err
+	}
+	defer logFile.Close()
+	if cred != nil {
+		os.Chown(logFile.Name(), int(cred.Uid), int(cred.Gid))
+	}
+
+	// Sessions outlive the request that created them, so they don't get its context.
+	cmd := exec.Command(s.cfg.Sketch, cmdArgs...)
+	cmd.Dir = sess.Workspace
+	cmd.Env = append(os.Environ(), "HOME="+home, "XDG_CACHE_HOME="+filepath.Join(home, ".cache"))
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+	if err := cmd.Start(); err != nil {
+		return Session{}, fmt.Errorf("starting sketch: %w", err)
+	}
+	started = true
+	sess.cmd = cmd
+	sess.State = "running"
+	sess.StartedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	s.sessions[id] = sess
+	s.mu.Unlock()
+	go s.wait(sess)
+
+	slog.InfoContext(ctx, "supervisor: started session", "id", id, "repo", req.Repo, "user", sess.User, "socket", sess.socket)
+	return s.snapshot(sess), nil
+}
+
+// wait records when and how sess's sketch exits.
+func (s *Supervisor) wait(sess *Session) {
+	err := sess.cmd.Wait()
+	s.mu.Lock()
+	now := time.Now().UTC()
+	sess.State = "exited"
+	sess.ExitedAt = &now
+	if err != nil {
+		sess.Error = err.Error()
+	}
+	s.mu.Unlock()
+	close(sess.done)
+}
+
+// List returns the sessions, oldest first.
+func (s *Supervisor) List() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		out = append(out, *sess)
+	}
+	slices.SortFunc(out, func(a, b Session) int { return a.StartedAt.Compare(b.StartedAt) })
+	return out
+}
+
+// Get returns the session with id.
+func (s *Supervisor) Get(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return *sess, true
+}
+
+// Stop stops the session with id and deletes its workspace and user.
+func (s *Supervisor) Stop(ctx context.Context, id string) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no session %q", id)
+	}
+	// Signal the whole process group: sketch's own children (shells, servers
+	// the agent started) belong to the session too.
+	syscall.Kill(-sess.cmd.Process.Pid, syscall.SIGTERM)
+	select {
+	case <-sess.done:
+	case <-time.After(10 * time.Second):
+		syscall.Kill(-sess.cmd.Process.Pid, syscall.SIGKILL)
+		<-sess.done
+	}
+	s.cleanup(ctx, sess)
+	slog.InfoContext(ctx, "supervisor: stopped session", "id", id)
+	return nil
+}
+
+// Close stops all sessions.
+func (s *Supervisor) Close(ctx context.Context) {
+	for _, sess := range s.List() {
+		s.Stop(ctx, sess.ID)
+	}
+}
+
+// cleanup removes what Create set up for sess.
+func (s *Supervisor) cleanup(ctx context.Context, sess *Session) {
+	if sess.transport != nil {
+		sess.transport.CloseIdleConnections()
+	}
+	if sess.User != "" {
+		if out, err := exec.CommandContext(ctx, "userdel", sess.User).CombinedOutput(); err != nil {
+			slog.WarnContext(ctx, "supervisor: removing session user", "user", sess.User, "error", err, "output", string(out))
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(s.cfg.Dir, sess.ID)); err != nil {
+		slog.WarnContext(ctx, "supervisor: removing session directory", "id", sess.ID, "error", err)
+	}
+}
+
+func (s *Supervisor) running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, sess := range s.sessions {
+		if sess.State == "running" {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Supervisor) snapshot(sess *Session) Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *sess
+}
+
+// addSessionUser creates a unix user for session id, with home as its home
+// directory, and gives it dir. It returns the user name and credentials to run as.
+func addSessionUser(ctx context.Context, id, home, dir string) (string, *syscall.Credential, error) {
+	// Session IDs are xxxx-xxxx-xxxx-xxxx; user names are best kept short and lowercase.
+	name := "sketch-" + strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	if out, err := exec.CommandContext(ctx, "useradd", "--no-create-home", "--home-dir", home, "--shell", "/bin/bash", "--user-group", name).CombinedOutput(); err != nil {
+		return "", nil, fmt.Errorf("creating session user: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return name, nil, err
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	if out, err := exec.CommandContext(ctx, "chown", "-R", u.Uid+":"+u.Gid, dir).CombinedOutput(); err != nil {
+		return name, nil, fmt.Errorf("giving the session user its directory: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return name, &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}, nil
+}
+
+// Handler returns the supervisor's HTTP API:
+//
+//	POST   /sessions        create a session from a CreateRequest
+//	GET    /sessions        list sessions
+//	GET    /sessions/{id}   describe a session
+//	DELETE /sessions/{id}   stop a session and delete its workspace
+//	       /s/{id}/...      the session's own web UI and API
+func (s *Supervisor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /sessions", func(w http.ResponseWriter, r *http.Request) {
+		var req CreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sess, err := s.Create(r.Context(), req)
+		if errors.Is(err, errTooManySessions) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, sess)
+	})
+	mux.HandleFunc("GET /sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.List())
+	})
+	mux.HandleFunc("GET /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := s.Get(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, sess)
+	})
+	mux.HandleFunc("DELETE /sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Stop(r.Context(), r.PathValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/s/{id}/", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		sess, ok := s.Get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if sess.State != "running" {
+			http.Error(w, "session has exited", http.StatusBadGateway)
+			return
+		}
+		proxy := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(&url.URL{Scheme: "http", Host: "session"})
+				pr.Out.URL.Path = strings.TrimPrefix(pr.In.URL.Path, "/s/"+id)
+				pr.Out.URL.RawPath = ""
+				pr.SetXForwarded()
+			},
+			Transport:     sess.transport,
+			FlushInterval: -1, // the session streams events
+		}
+		proxy.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,138 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary stand in for sketch: with SUPERVISOR_FAKE_SKETCH
+// set, it serves its working directory's name and request paths on -addr.
+func TestMain(m *testing.M) {
+	if os.Getenv("SUPERVISOR_FAKE_SKETCH") == "" {
+		os.Exit(m.Run())
+	}
+	fs := flag.NewFlagSet("sketch", flag.ContinueOnError)
+	addr := fs.String("addr", "", "")
+	fs.Bool("unsafe", false, "")
+	fs.Bool("termui", true, "")
+	fs.Bool("open", true, "")
+	fs.String("session-id", "", "")
+	fs.String("prompt", "", "")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	wd, _ := os.Getwd()
+	ln, err := net.Listen("unix", strings.TrimPrefix(*addr, "unix:"))
+	if err != nil {
+		os.Exit(1)
+	}
+	http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", filepath.Base(filepath.Dir(wd)), r.URL.Path)
+	}))
+}
+
+func TestSupervisor(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "--allow-empty", "-m", "initial"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	t.Setenv("SUPERVISOR_FAKE_SKETCH", "1")
+	sup, err := New(Config{Dir: t.TempDir(), Sketch: os.Args[0], MaxSessions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(sup.Handler())
+	defer srv.Close()
+	defer sup.Close(context.Background())
+
+	body, _ := json.Marshal(CreateRequest{Repo: repo})
+	resp, err := http.Post(srv.URL+"/sessions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sess Session
+	json.NewDecoder(resp.Body).Decode(&sess)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || sess.State != "running" {
+		t.Fatalf("create: %s, %+v", resp.Status, sess)
+	}
+	if _, err := os.Stat(filepath.Join(sess.Workspace, ".git")); err != nil {
+		t.Errorf("workspace not cloned: %v", err)
+	}
+
+	// One session is the limit.
+	resp, err = http.Post(srv.URL+"/sessions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("second create: %s, want 503", resp.Status)
+	}
+
+	// The session's UI is proxied with the /s/<id> prefix stripped.
+	want := sess.ID + " /state"
+	var got string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		resp, err := http.Get(srv.URL + "/s/" + sess.ID + "/state")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got = string(b); resp.StatusCode == http.StatusOK {
+			break
+		}
+	}
+	if got != want {
+		t.Errorf("proxied response = %q, want %q", got, want)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/sessions/"+sess.ID, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete: %s", resp.Status)
+	}
+	if _, err := os.Stat(sess.Workspace); !os.IsNotExist(err) {
+		t.Errorf("workspace still exists after stop: %v", err)
+	}
+	if list := sup.List(); len(list) != 0 {
+		t.Errorf("sessions after stop = %+v", list)
+	}
+}
+
+func TestCreateRejectsOptionLikeRef(t *testing.T) {
+	sup, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sup.Create(context.Background(), CreateRequest{Repo: "x", Ref: "--upload-pack=evil"})
+	if err == nil || !strings.Contains(err.Error(), "invalid ref") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package termui
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A keymap binds control keys to palette commands, such as "compact summary".
+type keymap map[rune]string
+
+// defaultKeymap is the keymap before the user's keys file is applied.
+var defaultKeymap = keymap{
+	ctrl('g'): "cancel",
+	ctrl('o'): "help",
+	ctrl('t'): "diff",
+}
+
+// bindable are the control keys the line editor leaves alone.
+// The rest move the cursor, edit the line, or end it.
+const bindable = "gqorstvxyz"
+
+func ctrl(c byte) rune { return rune(c - 'a' + 1) }
+
+func keyName(k rune) string { return fmt.Sprintf("ctrl-%c", 'a'+k-1) }
+
+// keymapPath is the user's keys file, which has lines such as
+// "ctrl-x = compact summary" and, to unbind a key, "ctrl-g =".
+func keymapPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "sketch", "keys")
+}
+
+// loadKeymap returns the default keymap with the keys file at path applied.
+// A missing file leaves the defaults.
+func loadKeymap(path string) (keymap, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return parseKeymap(strings.NewReader(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseKeymap(f)
+}
+
+func parseKeymap(r io.Reader) (keymap, error) {
+	km := keymap{}
+	for k, v := range defaultKeymap {
+		km[k] = v
+	}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, cmd, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want key = command", n)
+		}
+		k, err := parseKey(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		cmd = strings.TrimPrefix(strings.TrimSpace(cmd), ":")
+		if cmd == "" {
+			delete(km, k)
+			continue
+		}
+		if name, _, _ := strings.Cut(cmd, " "); findCommand(name) == nil {
+			return nil, fmt.Errorf("line %d: unknown command %q", n, name)
+		}
+		km[k] = cmd
+	}
+	return km, sc.Err()
+}
+
+// parseKey parses a key such as "ctrl-g", "C-g" or "^G".
+func parseKey(s string) (rune, error) {
+	lower := strings.ToLower(s)
+	var c string
+	for _, prefix := range []string{"ctrl-", "ctrl+", "c-", "^"} {
+		if rest, ok := strings.CutPrefix(lower, prefix); ok {
+			c = rest
+			break
+		}
+	}
+	if len(c) != 1 || !strings.Contains(bindable, c) {
+		return 0, fmt.Errorf("can't bind %q: bindable keys are ctrl- and one of %s", s, bindable)
+	}
+	return ctrl(c[0]), nil
+}
+
+// A command is a palette command, run by typing ":" and its name, or with a key.
+type command struct {
+	name string
+	args string
+	help string
+}
+
+var commands = []command{
+	{"cancel", "", "cancel the current turn"},
+	{"compact", "[strategy]", "compact the conversation now (summary, drop-tool-results, or keep-pinned)"},
+	{"diff", "", "show what changed since sketch-base, by file"},
+	{"push", "", "push new commits to the host"},
+	{"model", "[name]", "show the model, or switch to claude, sonnet or opus"},
+	{"help", "", "show the commands and key bindings"},
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand runs a palette command line, without its ":".
+func (ui *TermUI) runCommand(ctx context.Context, line string) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "cancel":
+		ui.agent.CancelTurn(fmt.Errorf("user canceled the operation"))
+	case "compact":
+		ui.compact(ctx, arg)
+	case "diff":
+		ui.showDiff()
+	case "push":
+		ui.push(ctx)
+	case "model":
+		ui.model(ctx, arg)
+	case "help":
+		ui.showHelp()
+	default:
+		ui.AppendSystemMessage("❌ Unknown command :%s; :help lists them", name)
+	}
+}
+
+// handleKey is the terminal's AutoCompleteCallback: it runs the command bound
+// to key and completes command names on tab. The terminal isn't locked while
+// it runs, but it does hold up reading keys, so commands run separately.
+func (ui *TermUI) handleKey(ctx context.Context) func(line string, pos int, key rune) (string, int, bool) {
+	return func(line string, pos int, key rune) (string, int, bool) {
+		if key == '\t' {
+			return ui.complete(line, pos)
+		}
+		cmd, ok := ui.keys[key]
+		if !ok {
+			return "", 0, false
+		}
+		go ui.runCommand(ctx, cmd)
+		return line, pos, true
+	}
+}
+
+// complete completes the name of the command being typed at the end of line.
+// With more than one candidate, it completes their common prefix and lists them.
+func (ui *TermUI) complete(line string, pos int) (string, int, bool) {
+	prefix, ok := strings.CutPrefix(line, ":")
+	if !ok || pos != len(line) || strings.Contains(prefix, " ") {
+		return "", 0, false
+	}
+	var names []string
+	for _, c := range commands {
+		if strings.HasPrefix(c.name, prefix) {
+			names = append(names, c.name)
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", 0, false
+	case 1:
+		line = ":" + names[0] + " "
+		return line, len(line), true
+	}
+	common := names[0]
+	for _, n := range names[1:] {
+		for !strings.HasPrefix(n, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	go ui.AppendSystemMessage("%s", strings.Join(names, "  "))
+	line = ":" + common
+	return line, len(line), true
+}
+
+func (ui *TermUI) showDiff() {
+	gs := ui.agent.GitStats()
+	if gs.FilesChanged == 0 {
+		ui.AppendSystemMessage("🗂️  No changes since sketch-base")
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "🗂️  %d file(s) changed since sketch-base, +%d -%d", gs.FilesChanged, gs.Additions, gs.Deletions)
+	for _, f := range gs.Files {
+		if f.Binary {
+			fmt.Fprintf(&b, "\n   %s (binary)", f.Path)
+		} else {
+			fmt.Fprintf(&b, "\n   %s +%d -%d", f.Path, f.Additions, f.Deletions)
+		}
+	}
+	ui.AppendSystemMessage("%s", b.String())
+}
+
+// push pushes the commits the agent hasn't pushed yet; the agent announces each one.
+func (ui *TermUI) push(ctx context.Context) {
+	if err := ui.agent.DetectGitChanges(ctx); err != nil {
+		ui.AppendSystemMessage("❌ Push failed: %v", err)
+		return
+	}
+	ui.AppendSystemMessage("🔄 Checked for new commits to push")
+}
+
+// model shows the model, or switches to the model named arg; the agent announces the switch.
+func (ui *TermUI) model(ctx context.Context, arg string) {
+	if arg == "" {
+		ui.AppendSystemMessage("🤖 Model: %s", ui.agent.ModelName())
+		return
+	}
+	if err := ui.agent.SetModel(ctx, arg); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+	}
+}
+
+// showHelp shows the palette commands and the key bindings, as a framed overlay
+// that stands out from the conversation.
+func (ui *TermUI) showHelp() {
+	lines := []string{"Commands (type : and tab to complete):"}
+	for _, c := range commands {
+		lines = append(lines, fmt.Sprintf("  :%-20s %s", strings.TrimSpace(c.name+" "+c.args), c.help))
+	}
+	lines = append(lines, "", "Keys (rebind them in "+keymapPath()+"):")
+	keys := make([]rune, 0, len(ui.keys))
+	for k := range ui.keys {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("  %-21s :%s", keyName(k), ui.keys[k]))
+	}
+	width := 0
+	for _, l := range lines {
+		width = max(width, len([]rune(l)))
+	}
+	var b strings.Builder
+	b.WriteString("┌" + strings.Repeat("─", width+2) + "┐\n")
+	for _, l := range lines {
+		b.WriteString("│ " + l + strings.Repeat(" ", width-len([]rune(l))) + " │\n")
+	}
+	b.WriteString("└" + strings.Repeat("─", width+2) + "┘")
+	ui.AppendSystemMessage("%s", b.String())
+}
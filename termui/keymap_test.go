@@ -0,0 +1,79 @@
+package termui
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sketch.dev/git_tools"
+	"sketch.dev/loop"
+	"sketch.dev/loop/looptest"
+)
+
+func TestParseKeymap(t *testing.T) {
+	km, err := parseKeymap(strings.NewReader(`# my keys
+ctrl-x = :compact summary
+^G =
+C-t = model opus
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if km[ctrl('x')] != "compact summary" || km[ctrl('t')] != "model opus" || km[ctrl('o')] != "help" {
+		t.Errorf("keymap = %q", km)
+	}
+	if _, ok := km[ctrl('g')]; ok {
+		t.Error("ctrl-g is still bound")
+	}
+	if defaultKeymap[ctrl('g')] != "cancel" {
+		t.Error("parseKeymap changed the defaults")
+	}
+
+	for _, bad := range []string{"ctrl-a = cancel", "ctrl-x = launch", "ctrl-x", "alt-x = diff"} {
+		if _, err := parseKeymap(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseKeymap accepted %q", bad)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	ui := &TermUI{termLogCh: make(chan string, 1)}
+	if line, pos, ok := ui.complete(":mo", 3); !ok || line != ":model " || pos != len(line) {
+		t.Errorf("complete(:mo) = %q, %d, %v", line, pos, ok)
+	}
+	if line, _, ok := ui.complete(":c", 2); !ok || line != ":c" {
+		t.Errorf("complete(:c) = %q, %v", line, ok)
+	} else if got := <-ui.termLogCh; got != "cancel  compact" {
+		t.Errorf("candidates = %q", got)
+	}
+	if _, _, ok := ui.complete("fix the bug", 11); ok {
+		t.Error("completed a chat message")
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	agent := looptest.NewFakeAgent(looptest.Config{
+		Model: "claude",
+		GitStats: loop.GitStats{Additions: 5, Deletions: 2, FilesChanged: 1,
+			Files: []git_tools.FileStat{{Path: "main.go", Additions: 5, Deletions: 2}}},
+	})
+	ui := New(agent, "", true)
+	ui.keys = defaultKeymap
+	run := func(line string) string {
+		go ui.runCommand(context.Background(), line)
+		return <-ui.termLogCh
+	}
+	if got := run("diff"); !strings.Contains(got, "+5 -2") || !strings.Contains(got, "main.go +5 -2") {
+		t.Errorf(":diff = %q", got)
+	}
+	ui.runCommand(context.Background(), "model opus")
+	if got := run("model"); got != "🤖 Model: opus" {
+		t.Errorf(":model = %q", got)
+	}
+	if got := run("help"); !strings.Contains(got, ":compact [strategy]") || !strings.Contains(got, "ctrl-g") {
+		t.Errorf(":help = %q", got)
+	}
+	if got := run("launch"); !strings.Contains(got, "Unknown command :launch") {
+		t.Errorf(":launch = %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package termui
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// terminal is the subset of *term.Terminal that TermUI uses after setup,
+// so that plainTerminal can stand in for it.
+type terminal interface {
+	io.Writer
+	ReadLine() (string, error)
+	SetPrompt(prompt string)
+}
+
+// PlainTerminal reports whether the current terminal should get the plain-line UI:
+// no raw mode, no cursor addressing, and no title escape sequences.
+func PlainTerminal() bool {
+	return isPlainTerminal(os.Getenv, runtime.GOOS)
+}
+
+func isPlainTerminal(getenv func(string) string, goos string) bool {
+	t := strings.ToLower(getenv("TERM"))
+	switch {
+	case t == "dumb" || t == "unknown" || strings.HasPrefix(t, "emacs"):
+		return true
+	case getenv("INSIDE_EMACS") != "":
+		return true
+	case goos == "windows":
+		// The legacy console host sets none of these; Windows Terminal,
+		// ConEmu, mintty and editor terminals set at least one.
+		for _, v := range []string{"WT_SESSION", "TERM_PROGRAM", "ANSICON", "ConEmuANSI"} {
+			if getenv(v) != "" {
+				return false
+			}
+		}
+		return t == ""
+	default:
+		return t == ""
+	}
+}
+
+// plainTerminal is a line-oriented terminal for consoles that can't
+// redraw a prompt: output is written as it arrives, and the prompt is
+// printed only when the agent is idle. An empty prompt means "busy".
+type plainTerminal struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	mu          sync.Mutex
+	prompt      string
+	reading     bool // a ReadLine is waiting for input
+	promptShown bool // the prompt is the last thing on the current line
+}
+
+func newPlainTerminal(in io.Reader, out io.Writer) *plainTerminal {
+	return &plainTerminal{in: bufio.NewReader(in), out: out}
+}
+
+func (t *plainTerminal) SetPrompt(prompt string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prompt = prompt
+}
+
+func (t *plainTerminal) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.promptShown {
+		io.WriteString(t.out, "\n")
+		t.promptShown = false
+	}
+	n, err := t.out.Write(b)
+	if t.reading {
+		t.showPromptLocked()
+	}
+	return n, err
+}
+
+func (t *plainTerminal) ReadLine() (string, error) {
+	t.mu.Lock()
+	t.reading = true
+	t.showPromptLocked()
+	t.mu.Unlock()
+
+	line, err := t.in.ReadString('\n')
+
+	t.mu.Lock()
+	t.reading = false
+	t.promptShown = false
+	t.mu.Unlock()
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (t *plainTerminal) showPromptLocked() {
+	if t.prompt != "" && !t.promptShown {
+		io.WriteString(t.out, t.prompt)
+		t.promptShown = true
+	}
+}
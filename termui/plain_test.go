@@ -0,0 +1,61 @@
+package termui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestIsPlainTerminal(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		goos string
+		want bool
+	}{
+		{"xterm", map[string]string{"TERM": "xterm-256color"}, "linux", false},
+		{"dumb", map[string]string{"TERM": "dumb"}, "linux", true},
+		{"unset", map[string]string{}, "linux", true},
+		{"emacs shell", map[string]string{"TERM": "xterm", "INSIDE_EMACS": "29.1,comint"}, "linux", true},
+		{"legacy windows console", map[string]string{}, "windows", true},
+		{"windows terminal", map[string]string{"WT_SESSION": "abc"}, "windows", false},
+		{"mintty", map[string]string{"TERM": "xterm"}, "windows", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(k string) string { return tt.env[k] }
+			if got := isPlainTerminal(getenv, tt.goos); got != tt.want {
+				t.Errorf("isPlainTerminal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainTerminal(t *testing.T) {
+	var out bytes.Buffer
+	trm := newPlainTerminal(strings.NewReader("hello\r\nbye"), &out)
+
+	trm.SetPrompt("slug> ")
+	line, err := trm.ReadLine()
+	if err != nil || line != "hello" {
+		t.Fatalf("ReadLine() = %q, %v", line, err)
+	}
+	// While busy there is no prompt, and output is written as-is.
+	trm.SetPrompt("")
+	trm.Write([]byte("working\n"))
+	trm.SetPrompt("slug> ")
+	trm.Write([]byte("done\n"))
+	line, err = trm.ReadLine()
+	if err != nil || line != "bye" {
+		t.Fatalf("ReadLine() = %q, %v", line, err)
+	}
+	if _, err := trm.ReadLine(); err == nil {
+		t.Fatal("expected EOF")
+	}
+	if got, want := out.String(), "slug> working\ndone\nslug> slug> "; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if strings.Contains(out.String(), "\x1b") {
+		t.Error("plain terminal wrote an escape sequence")
+	}
+}
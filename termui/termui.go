@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
@@ -20,6 +21,9 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/fatih/color"
 	"golang.org/x/term"
+	"sketch.dev/claudetool/permission"
+	"sketch.dev/llm"
+	"sketch.dev/llm/conversation"
 	"sketch.dev/loop"
 )
 
@@ -37,6 +41,10 @@ var (
 {{else if eq .msg.ToolName "todo_write" }}
 {{range .input.tasks}}{{if eq .status "queued"}}⚪{{else if eq .status "in-progress"}}🦉{{else if eq .status "completed"}}✅{{end}} {{.task}}
 {{end}}
+{{else if eq .msg.ToolName "artifacts" -}}
+ 🗃️  {{.input.action}}{{if .input.key}} {{.input.key}}{{end -}}
+{{else if eq .msg.ToolName "scratchpad" -}}
+ 🧮 {{.input.language}}: {{.input.code -}}
 {{else if eq .msg.ToolName "keyword_search" -}}
  🔍 {{ .input.query}}: {{.input.search_terms -}}
 {{else if eq .msg.ToolName "bash" -}}
@@ -47,8 +55,28 @@ var (
 {{/* nothing to show here, the agent will write more in its next message */}}
 {{else if eq .msg.ToolName "about_sketch" -}}
 📚 About Sketch
+{{else if eq .msg.ToolName "tool_help" -}}
+ 📖 Reading up on {{.input.name -}}
 {{else if eq .msg.ToolName "codereview" -}}
  🐛  Running automated code review, may be slow
+{{else if eq .msg.ToolName "dependency_audit" -}}
+ 🛡️  Auditing dependencies for vulnerabilities
+{{else if eq .msg.ToolName "todo_scan" -}}
+ 📌 Scanning for TODOs{{if eq .input.scope "repo"}} in {{if .input.path}}{{.input.path}}{{else}}the repo{{end}}{{end -}}
+{{else if eq .msg.ToolName "session_recap" -}}
+ 🧾 Recapping what was tried so far
+{{else if eq .msg.ToolName "rebase_upstream" -}}
+ 🔀 rebase {{if .input.action}}{{.input.action}}{{else}}start{{end}}{{if .input.onto}} onto {{.input.onto}}{{end -}}
+{{else if eq .msg.ToolName "build_attestation" -}}
+ 🔏 Attesting {{range $i, $s := .input.subjects}}{{if $i}}, {{end}}{{$s}}{{end -}}
+{{else if eq .msg.ToolName "verify_matrix" -}}
+ 🧪 Verifying in {{if .input.envs}}{{range $i, $e := .input.envs}}{{if $i}}, {{end}}{{$e}}{{end}}{{else}}every matrix environment{{end -}}
+{{else if eq .msg.ToolName "git_diff" -}}
+ 🗂️  diff {{if .input.from}}{{.input.from}}{{else}}sketch-base{{end}}..{{if .input.to}}{{.input.to}}{{else}}working tree{{end}}{{if .input.path}} in {{.input.path}}{{end -}}
+{{else if eq .msg.ToolName "merge_queue" -}}
+ 🚦 merge queue {{.input.action}}{{if .input.branch}} {{.input.branch}}{{end -}}
+{{else if eq .msg.ToolName "task_runner" -}}
+ 🏗️  {{.input.action}} {{.input.target -}}
 {{else if eq .msg.ToolName "browser_navigate" -}}
  🌐 {{.input.url -}}
 {{else if eq .msg.ToolName "browser_eval" -}}
@@ -74,15 +102,46 @@ var (
 	toolUseTmpl = template.Must(template.New("tool_use").Parse(toolUseTemplTxt))
 )
 
+// Agent is the part of loop.CodingAgent the terminal UI drives. Besides a local
+// agent, it can be a session on another machine; see server.DialAttach.
+type Agent interface {
+	UserMessage(ctx context.Context, msg string)
+	NewIterator(ctx context.Context, nextMessageIdx int) loop.MessageIterator
+	CancelTurn(cause error)
+	ApplyPatch(ctx context.Context, patch, note string) (loop.PatchResult, error)
+	Compact(ctx context.Context, strategy string) error
+	TotalUsage() conversation.CumulativeUsage
+	OriginalBudget() conversation.Budget
+	TurnTimeout() time.Duration
+	SetTurnTimeout(d time.Duration)
+	Sampling() llm.Sampling
+	SetSampling(ctx context.Context, s llm.Sampling) error
+	ModelName() string
+	SetModel(ctx context.Context, name string) error
+	GitStats() loop.GitStats
+	DetectGitChanges(ctx context.Context) error
+	Slug() string
+	BranchPrefix() string
+	WorkingDir() string
+	SketchGitBase() string
+	GitOrigin() string
+	LinkToGitHub() bool
+	OpenBrowser(url string)
+}
+
+var _ Agent = loop.CodingAgent(nil)
+
 type TermUI struct {
 	stdin  *os.File
 	stdout *os.File
 	stderr *os.File
 
-	agent   loop.CodingAgent
+	agent   Agent
 	httpURL string
 
-	trm *term.Terminal
+	trm   terminal
+	plain bool // line-oriented output for terminals without cursor addressing; see PlainTerminal
+	keys  keymap
 
 	// the chatMsgCh channel is for "conversation" messages, like responses to user input
 	// from the LLM, or output from executing slash-commands issued by the user.
@@ -112,9 +171,12 @@ type chatMessage struct {
 	thinking bool
 }
 
-func New(agent loop.CodingAgent, httpURL string) *TermUI {
+// New returns a terminal UI for agent. If plain is set, the UI avoids raw mode
+// and escape sequences, for dumb terminals and legacy consoles.
+func New(agent Agent, httpURL string, plain bool) *TermUI {
 	return &TermUI{
 		agent:          agent,
+		plain:          plain,
 		stdin:          os.Stdin,
 		stdout:         os.Stdout,
 		stderr:         os.Stderr,
@@ -130,6 +192,13 @@ func (ui *TermUI) Run(ctx context.Context) error {
 	fmt.Println(`💬 type 'help' for help`)
 	fmt.Println()
 
+	keys, err := loadKeymap(keymapPath())
+	if err != nil {
+		fmt.Printf("⌨️  using the default keys: %s: %v\n", keymapPath(), err)
+		keys = defaultKeymap
+	}
+	ui.keys = keys
+
 	// Start up the main terminal UI:
 	if err := ui.initializeTerminalUI(ctx); err != nil {
 		return err
@@ -214,6 +283,16 @@ func (ui *TermUI) receiveMessagesLoop(ctx context.Context) {
 			ui.updateTitleWithSlug(resp.Content)
 		case loop.CompactMessageType:
 			// TODO: print something for compaction?
+		case loop.MilestoneMessageType:
+			ui.AppendSystemMessage("📌 %s", resp.Content)
+		case loop.ApprovalMessageType:
+			ui.AppendSystemMessage("🔐 %s\n%s\nallow, allow always, or deny [reason]?", resp.Content, resp.ToolInput)
+		case loop.ExternalMessageType:
+			if p, ok := resp.ExternalMessage.Body.(loop.PatchResult); ok {
+				ui.AppendSystemMessage("🩹 applied patch as %s: %s", getShortSHA(p.Commit), strings.Join(p.Files, ", "))
+			} else {
+				ui.AppendSystemMessage("📨 %s", resp.ExternalMessage.TextContent)
+			}
 		default:
 			ui.AppendSystemMessage("❌ Unexpected Message Type %s %v", resp.Type, resp)
 		}
@@ -243,8 +322,17 @@ Special commands:
 - usage, cost         : Show current token usage and cost
 - browser, open, b    : Open current conversation in browser
 - stop, cancel, abort : Cancel the current operation
+- timeout [duration]  : Show or set the per-turn time limit (e.g. timeout 30m, timeout 0)
+- sampling [params]   : Show or set sampling (e.g. sampling temperature=0,seed=42, sampling default)
+- patch [file]        : Apply a unified diff from file, or paste one and end it with a line containing only "."
+- compact [strategy]  : Compact the conversation now (summary, drop-tool-results, or keep-pinned)
+- plan [on|off]       : Show, enter, or leave plan mode, in which sketch doesn't change files or commit
+- approve [comment]   : Approve sketch's plan and leave plan mode
+- allow [always]      : Let the tool call waiting for approval run, and with always, every later call of its tool
+- deny [reason]       : Refuse the tool call waiting for approval, telling sketch why
 - exit, quit, q       : Exit sketch
-- ! <command>         : Execute a shell command (e.g. !ls -la)`)
+- ! <command>         : Execute a shell command (e.g. !ls -la)
+- :<command>          : Run a palette command (e.g. :diff, :model opus); :help lists them and the keys`)
 		case "budget":
 			originalBudget := ui.agent.OriginalBudget()
 			ui.AppendSystemMessage("💰 Budget summary:")
@@ -325,6 +413,30 @@ Special commands:
 			// Wait for all pending messages to be processed before exiting
 			ui.messageWaitGroup.Wait()
 			return nil
+		case "timeout":
+			if d := ui.agent.TurnTimeout(); d > 0 {
+				ui.AppendSystemMessage("⏱️  Turn timeout: %s", d)
+			} else {
+				ui.AppendSystemMessage("⏱️  No turn timeout set")
+			}
+		case "sampling":
+			if sm := ui.agent.Sampling(); !sm.IsZero() {
+				ui.AppendSystemMessage("🎲 Sampling: %s", sm)
+			} else {
+				ui.AppendSystemMessage("🎲 Sampling: model defaults")
+			}
+		case "plan":
+			ui.planMode(ctx, "")
+		case "approve":
+			ui.approvePlan(ctx, "")
+		case "allow", "allow always":
+			ui.decideApproval(ctx, permission.Decision{Approve: true, Always: line == "allow always"})
+		case "deny":
+			ui.decideApproval(ctx, permission.Decision{})
+		case "patch":
+			ui.applyPatch(ctx, "")
+		case "compact":
+			ui.compact(ctx, "")
 		case "stop", "cancel", "abort":
 			ui.agent.CancelTurn(fmt.Errorf("user canceled the operation"))
 		case "panic":
@@ -333,6 +445,54 @@ Special commands:
 			if line == "" {
 				continue
 			}
+			if arg, ok := strings.CutPrefix(line, "timeout "); ok {
+				d, err := time.ParseDuration(strings.TrimSpace(arg))
+				if err != nil || d < 0 {
+					ui.AppendSystemMessage("❌ Invalid timeout %q; use a duration like 30m, or 0 to disable", arg)
+					continue
+				}
+				ui.agent.SetTurnTimeout(d)
+				ui.AppendSystemMessage("⏱️  Turn timeout set to %s", d)
+				continue
+			}
+			if arg, ok := strings.CutPrefix(line, "sampling "); ok {
+				arg = strings.TrimSpace(arg)
+				if arg == "default" {
+					arg = ""
+				}
+				sm, err := llm.ParseSampling(arg)
+				if err == nil {
+					err = ui.agent.SetSampling(ctx, sm)
+				}
+				if err != nil {
+					ui.AppendSystemMessage("❌ Invalid sampling: %v", err)
+				}
+				continue
+			}
+			if arg, ok := strings.CutPrefix(line, "plan "); ok {
+				ui.planMode(ctx, strings.TrimSpace(arg))
+				continue
+			}
+			if arg, ok := strings.CutPrefix(line, "approve "); ok {
+				ui.approvePlan(ctx, strings.TrimSpace(arg))
+				continue
+			}
+			if arg, ok := strings.CutPrefix(line, "deny "); ok {
+				ui.decideApproval(ctx, permission.Decision{Comment: strings.TrimSpace(arg)})
+				continue
+			}
+			if arg, ok := strings.CutPrefix(line, "patch "); ok {
+				ui.applyPatch(ctx, strings.TrimSpace(arg))
+				continue
+			}
+			if arg, ok := strings.CutPrefix(line, "compact "); ok {
+				ui.compact(ctx, strings.TrimSpace(arg))
+				continue
+			}
+			if cmd, ok := strings.CutPrefix(line, ":"); ok {
+				ui.runCommand(ctx, cmd)
+				continue
+			}
 			if strings.HasPrefix(line, "!") {
 				// Execute as shell command
 				line = line[1:] // remove the '!' prefix
@@ -371,9 +531,121 @@ Special commands:
 	}
 }
 
+// compact compacts the conversation with strategy, or the session's strategy if it is empty.
+// The agent reports the compaction itself.
+func (ui *TermUI) compact(ctx context.Context, strategy string) {
+	if err := ui.agent.Compact(ctx, strategy); err != nil {
+		ui.AppendSystemMessage("❌ Compaction failed: %v", err)
+	}
+}
+
+// planModeAgent is implemented by agents that support plan mode.
+type planModeAgent interface {
+	PlanMode() bool
+	SetPlanMode(ctx context.Context, on bool)
+	ReviewPlan(ctx context.Context, approve bool, comment string) error
+}
+
+// planMode shows whether the agent is in plan mode, or, with "on" or "off",
+// enters or leaves it. The agent reports the change itself.
+func (ui *TermUI) planMode(ctx context.Context, arg string) {
+	a, ok := ui.agent.(planModeAgent)
+	if !ok {
+		ui.AppendSystemMessage("❌ This agent has no plan mode")
+		return
+	}
+	switch arg {
+	case "":
+		if a.PlanMode() {
+			ui.AppendSystemMessage("📝 Plan mode is on; approve the plan to let sketch change files and commit")
+		} else {
+			ui.AppendSystemMessage("📝 Plan mode is off")
+		}
+	case "on", "off":
+		a.SetPlanMode(ctx, arg == "on")
+	default:
+		ui.AppendSystemMessage("❌ Use plan on or plan off")
+	}
+}
+
+// approvePlan approves the plan the agent proposed in plan mode, sending it
+// comment, if any.
+func (ui *TermUI) approvePlan(ctx context.Context, comment string) {
+	a, ok := ui.agent.(planModeAgent)
+	if !ok {
+		ui.AppendSystemMessage("❌ This agent has no plan mode")
+		return
+	}
+	if err := a.ReviewPlan(ctx, true, comment); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+	}
+}
+
+// approvalAgent is implemented by agents that hold tool calls for approval.
+type approvalAgent interface {
+	PendingApprovals() []permission.ApprovalRequest
+	DecideApproval(ctx context.Context, id string, d permission.Decision) error
+}
+
+// decideApproval decides on the oldest tool call waiting for approval.
+// The agent reports the decision itself.
+func (ui *TermUI) decideApproval(ctx context.Context, d permission.Decision) {
+	a, ok := ui.agent.(approvalAgent)
+	if !ok {
+		ui.AppendSystemMessage("❌ This agent doesn't hold tool calls for approval")
+		return
+	}
+	pending := a.PendingApprovals()
+	if len(pending) == 0 {
+		ui.AppendSystemMessage("❌ No tool call is waiting for approval")
+		return
+	}
+	if err := a.DecideApproval(ctx, pending[0].ID, d); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+	}
+}
+
+// applyPatch applies a unified diff read from path, relative to the working
+// directory, or pasted into the terminal if path is empty.
+func (ui *TermUI) applyPatch(ctx context.Context, path string) {
+	var patch string
+	if path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(ui.agent.WorkingDir(), path)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			ui.AppendSystemMessage("❌ %v", err)
+			return
+		}
+		patch = string(b)
+	} else {
+		ui.AppendSystemMessage("📋 Paste the patch, then enter a line containing only \".\"")
+		var b strings.Builder
+		for {
+			line, err := ui.trm.ReadLine()
+			if err != nil || strings.TrimRight(line, "\r") == "." {
+				break
+			}
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+		patch = b.String()
+	}
+	// On success, the agent announces the patch along with its commit.
+	if _, err := ui.agent.ApplyPatch(ctx, patch, ""); err != nil {
+		ui.AppendSystemMessage("❌ %v", err)
+	}
+}
+
 func (ui *TermUI) updatePrompt(thinking bool) {
 	var t string
 	if thinking {
+		if ui.plain {
+			// The plain terminal can't redraw the prompt, so only show it when idle.
+			ui.trm.SetPrompt("")
+			return
+		}
 		// Emoji don't seem to work here? Messes up my terminal.
 		t = " *"
 	}
@@ -385,6 +657,13 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 	ui.mu.Lock()
 	defer ui.mu.Unlock()
 
+	if ui.plain {
+		ui.trm = newPlainTerminal(ui.stdin, ui.stdout)
+		ui.updatePrompt(false)
+		ui.startOutputLoop(ctx)
+		return nil
+	}
+
 	if !term.IsTerminal(int(ui.stdin.Fd())) {
 		return fmt.Errorf("this command requires terminal I/O when termui=true")
 	}
@@ -394,12 +673,14 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 		return err
 	}
 	ui.oldState = oldState
-	ui.trm = term.NewTerminal(ui.stdin, "")
+	trm := term.NewTerminal(ui.stdin, "")
+	trm.AutoCompleteCallback = ui.handleKey(ctx)
+	ui.trm = trm
 	width, height, err := term.GetSize(int(ui.stdin.Fd()))
 	if err != nil {
 		return fmt.Errorf("get terminal size: %v", err)
 	}
-	ui.trm.SetSize(width, height)
+	trm.SetSize(width, height)
 	// Handle terminal resizes...
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGWINCH)
@@ -412,7 +693,7 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 			}
 			if newWidth != width || newHeight != height {
 				width, height = newWidth, newHeight
-				ui.trm.SetSize(width, height)
+				trm.SetSize(width, height)
 			}
 		}
 	}()
@@ -420,7 +701,12 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 	ui.updatePrompt(false)
 	ui.pushTerminalTitle()
 	ui.setTerminalTitle("sketch")
+	ui.startOutputLoop(ctx)
+	return nil
+}
 
+// startOutputLoop starts the goroutine that writes chat and log messages to the terminal.
+func (ui *TermUI) startOutputLoop(ctx context.Context) {
 	// This is the only place where we should call fe.trm.Write:
 	go func() {
 		var lastMsg *chatMessage
@@ -456,13 +742,15 @@ func (ui *TermUI) initializeTerminalUI(ctx context.Context) error {
 			}
 		}
 	}()
-
-	return nil
 }
 
 func (ui *TermUI) RestoreOldState() error {
 	ui.mu.Lock()
 	defer ui.mu.Unlock()
+	if ui.oldState == nil {
+		// Plain mode, or the terminal was never set up.
+		return nil
+	}
 	ui.setTerminalTitle("")
 	ui.popTerminalTitle()
 	return term.Restore(int(ui.stdin.Fd()), ui.oldState)
@@ -570,6 +858,9 @@ func (ui *TermUI) updateTitleWithSlug(slug string) {
 	ui.mu.Lock()
 	defer ui.mu.Unlock()
 	ui.currentSlug = slug
+	if ui.plain {
+		return
+	}
 	title := "sketch"
 	if slug != "" {
 		title = fmt.Sprintf("sketch: %s", slug)
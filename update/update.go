@@ -1,18 +1,25 @@
 package update
 
 import (
+	"cmp"
 	"context"
 	"crypto/ed25519"
 	"crypto/x509"
 	_ "embed"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/fynelabs/selfupdate"
@@ -38,45 +45,188 @@ var publicKey = sync.OnceValue(func() ed25519.PublicKey {
 	return key
 })
 
-// Do updates sketch in-place.
-func Do(ctx context.Context, currentVersion, binaryPath string) error {
-	fmt.Printf("Current version: %s. Checking for updates...\n", currentVersion)
+// A Channel is a stream of releases to update from.
+type Channel string
+
+const (
+	Stable  Channel = "stable"  // releases
+	Beta    Channel = "beta"    // releases and their -beta and -rc previews
+	Nightly Channel = "nightly" // everything, nightly builds included
+)
+
+// Channels are the channels, from the most conservative.
+var Channels = []Channel{Stable, Beta, Nightly}
+
+// ParseChannel parses a channel's name.
+func ParseChannel(s string) (Channel, error) {
+	c := Channel(strings.ToLower(strings.TrimSpace(s)))
+	if !slices.Contains(Channels, c) {
+		return "", fmt.Errorf("unknown channel %q: want stable, beta or nightly", s)
+	}
+	return c, nil
+}
+
+// includes reports whether r belongs on c.
+func (c Channel) includes(r release) bool {
+	pre := r.version.Prerelease()
+	switch c {
+	case Stable:
+		return pre == "" && !r.Prerelease
+	case Beta:
+		return pre == "" || strings.HasPrefix(pre, "beta") || strings.HasPrefix(pre, "rc")
+	default:
+		return true
+	}
+}
+
+// settings is what the update configuration file holds.
+type settings struct {
+	Channel Channel `json:"channel"`
+}
+
+// ConfigPath is where the update settings, like the channel, are kept.
+func ConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "update.json"), nil
+}
+
+// LoadChannel returns the channel last set with SaveChannel, or Stable.
+func LoadChannel() (Channel, error) {
+	path, err := ConfigPath()
+	if err != nil {
+		return Stable, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Stable, nil
+	} else if err != nil {
+		return Stable, err
+	}
+	var st settings
+	if err := json.Unmarshal(b, &st); err != nil {
+		return Stable, fmt.Errorf("%s: %w", path, err)
+	}
+	if st.Channel == "" {
+		return Stable, nil
+	}
+	c, err := ParseChannel(string(st.Channel))
+	if err != nil {
+		return Stable, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// SaveChannel makes c the channel that updates come from.
+func SaveChannel(c Channel) (path string, err error) {
+	path, err = ConfigPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(settings{Channel: c}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// Options are how Do updates.
+type Options struct {
+	Channel Channel // Stable if empty
+	// Check, if set, only reports the newer release, with the release notes
+	// of everything since currentVersion, without installing it.
+	Check bool
+	Out   io.Writer // os.Stdout if nil
+}
+
+// Do updates sketch in-place to the newest release on opts.Channel.
+// The downloaded binary must carry our signature.
+func Do(ctx context.Context, currentVersion, binaryPath string, opts Options) error {
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	channel := cmp.Or(opts.Channel, Stable)
+	fmt.Fprintf(out, "Current version: %s (%s channel). Checking for updates...\n", currentVersion, channel)
 
 	currentVer, err := semver.NewVersion(currentVersion)
 	if err != nil {
 		return fmt.Errorf("could not parse current version %q as semver: %w", currentVersion, err)
 	}
 
-	source := &ghSource{currentVer: currentVer}
+	source := &ghSource{currentVer: currentVer, channel: channel}
 	if err := source.initialize(ctx); err != nil {
 		return err
 	}
 
-	if !source.latestVer.GreaterThan(currentVer) {
-		fmt.Printf("%s is up to date.\n", currentVersion)
+	if len(source.changes) == 0 {
+		fmt.Fprintf(out, "%s is up to date.\n", currentVersion)
+		return nil
+	}
+	if opts.Check {
+		fmt.Fprintf(out, "%s is available. Changes since %s:\n", source.latestVer, currentVersion)
+		for _, r := range source.changes {
+			writeReleaseNotes(out, r)
+		}
+		fmt.Fprintf(out, "\nRun \"sketch update\" to install it.\n")
 		return nil
 	}
-	fmt.Printf("Updating to %s...\n", source.latestVer)
+	fmt.Fprintf(out, "Updating to %s...\n", source.latestVer)
 
 	if err := selfupdate.ManualUpdate(source, publicKey()); err != nil {
 		return fmt.Errorf("failed to perform update: %w", err)
 	}
-	fmt.Printf("Updated to %s.\n", source.latestVer)
+	fmt.Fprintf(out, "Updated to %s.\n", source.latestVer)
 	return nil
 }
 
+func writeReleaseNotes(w io.Writer, r release) {
+	fmt.Fprintf(w, "\n%s", r.version)
+	if !r.PublishedAt.IsZero() {
+		fmt.Fprintf(w, " (%s)", r.PublishedAt.Format(time.DateOnly))
+	}
+	fmt.Fprintln(w)
+	body := strings.TrimSpace(strings.ReplaceAll(r.Body, "\r\n", "\n"))
+	if body == "" {
+		body = "(no release notes)"
+	}
+	for line := range strings.Lines(body) {
+		fmt.Fprintf(w, "  %s", line)
+	}
+	fmt.Fprintln(w)
+}
+
+// releasesURL lists sketch's releases, newest first.
+var releasesURL = "https://api.github.com/repos/boldsoftware/sketch/releases?per_page=100"
+
 // ghSource implements selfupdate.Source for sketch GitHub releases
 type ghSource struct {
 	currentVer *semver.Version
+	channel    Channel
 	latestVer  *semver.Version
+	changes    []release // on the channel, newer than currentVer, from latestVer down
 	asset      ghAsset
 	signature  [64]byte
 }
 
 // ghRelease represents a GitHub release
 type ghRelease struct {
-	TagName string    `json:"tag_name"`
-	Assets  []ghAsset `json:"assets"`
+	TagName     string    `json:"tag_name"`
+	Prerelease  bool      `json:"prerelease"`
+	Body        string    `json:"body"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []ghAsset `json:"assets"`
+}
+
+// release is a ghRelease with a version to go by.
+type release struct {
+	ghRelease
+	version *semver.Version
 }
 
 // ghAsset represents a release asset
@@ -86,10 +236,9 @@ type ghAsset struct {
 	Size               int64  `json:"size"`
 }
 
-// initialize fetches the latest release information if not already done
+// initialize fetches the release information if not already done
 func (gs *ghSource) initialize(ctx context.Context) error {
-	url := "https://api.github.com/repos/boldsoftware/sketch/releases/latest"
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", releasesURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -104,18 +253,20 @@ func (gs *ghSource) initialize(ctx context.Context) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
-	var release ghRelease
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+	var releases []ghRelease
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
 		return fmt.Errorf("failed to decode release info: %w", err)
 	}
 
-	gs.latestVer, err = semver.NewVersion(release.TagName)
-	if err != nil {
-		return fmt.Errorf("invalid latest version %q: %w", release.TagName, err)
+	gs.changes = newerReleases(releases, gs.channel, gs.currentVer)
+	if len(gs.changes) == 0 {
+		return nil
 	}
+	latest := gs.changes[0]
+	gs.latestVer = latest.version
 
 	// Find the appropriate asset for current platform
-	gs.asset, err = gs.assetForPlatform(release, runtime.GOOS, runtime.GOARCH)
+	gs.asset, err = gs.assetForPlatform(latest.ghRelease, runtime.GOOS, runtime.GOARCH)
 	if err != nil {
 		return fmt.Errorf("failed to find asset for current platform: %w", err)
 	}
@@ -127,6 +278,24 @@ func (gs *ghSource) initialize(ctx context.Context) error {
 	return nil
 }
 
+// newerReleases returns the releases on channel newer than current, newest first.
+// Tags that aren't versions are skipped.
+func newerReleases(releases []ghRelease, channel Channel, current *semver.Version) []release {
+	var newer []release
+	for _, gr := range releases {
+		v, err := semver.NewVersion(gr.TagName)
+		if err != nil {
+			continue
+		}
+		r := release{ghRelease: gr, version: v}
+		if channel.includes(r) && v.GreaterThan(current) {
+			newer = append(newer, r)
+		}
+	}
+	slices.SortFunc(newer, func(a, b release) int { return b.version.Compare(a.version) })
+	return newer
+}
+
 // assetForPlatform finds the appropriate asset for the given platform
 func (gs *ghSource) assetForPlatform(release ghRelease, goos, goarch string) (ghAsset, error) {
 	for _, asset := range release.Assets {
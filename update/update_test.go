@@ -0,0 +1,95 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestNewerReleases(t *testing.T) {
+	releases := []ghRelease{
+		{TagName: "v0.4.0-nightly.20261012", Prerelease: true},
+		{TagName: "v0.3.1"},
+		{TagName: "v0.4.0-beta.1", Prerelease: true},
+		{TagName: "v0.3.0"},
+		{TagName: "v0.2.0"},
+		{TagName: "not-a-version"},
+	}
+	current := semver.MustParse("v0.2.0")
+	for channel, want := range map[Channel]string{
+		Stable:  "0.3.1 0.3.0",
+		Beta:    "0.4.0-beta.1 0.3.1 0.3.0",
+		Nightly: "0.4.0-nightly.20261012 0.4.0-beta.1 0.3.1 0.3.0",
+	} {
+		var got []string
+		for _, r := range newerReleases(releases, channel, current) {
+			got = append(got, r.version.String())
+		}
+		if strings.Join(got, " ") != want {
+			t.Errorf("%s: newerReleases = %v, want %s", channel, got, want)
+		}
+	}
+	// Going back to stable from a preview waits for the next release.
+	if got := newerReleases(releases, Stable, semver.MustParse("v0.4.0-beta.1")); len(got) != 0 {
+		t.Errorf("stable after a beta: %v", got)
+	}
+}
+
+func TestChannelConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	if c, err := LoadChannel(); c != Stable || err != nil {
+		t.Errorf("LoadChannel without config = %q, %v", c, err)
+	}
+	if _, err := SaveChannel(Beta); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := LoadChannel(); c != Beta || err != nil {
+		t.Errorf("LoadChannel = %q, %v", c, err)
+	}
+	if _, err := ParseChannel("weekly"); err == nil {
+		t.Error("ParseChannel accepted weekly")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases":
+			asset := ghAsset{Name: "sketch_" + runtime.GOOS + "_" + runtime.GOARCH, BrowserDownloadURL: ts.URL + "/sketch"}
+			json.NewEncoder(w).Encode([]ghRelease{
+				{TagName: "v0.3.0", Body: "- Faster startup\r\n- Fewer crashes", Assets: []ghAsset{asset}},
+				{TagName: "v0.2.1", Body: "- Fix the thing", Assets: []ghAsset{asset}},
+				{TagName: "v0.2.0", Body: "- Already have it", Assets: []ghAsset{asset}},
+			})
+		case "/sketch.ed25519":
+			w.Write(make([]byte, 64))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	defer func(u string) { releasesURL = u }(releasesURL)
+	releasesURL = ts.URL + "/releases"
+
+	var out strings.Builder
+	if err := Do(context.Background(), "0.2.0", "/nonexistent", Options{Check: true, Out: &out}); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, want := range []string{"0.3.0 is available", "  - Faster startup\n  - Fewer crashes", "0.2.1\n  - Fix the thing"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output lacks %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Already have it") {
+		t.Errorf("output has the current release's notes:\n%s", got)
+	}
+}
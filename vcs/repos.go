@@ -0,0 +1,105 @@
+package vcs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+type gitRepo struct{ root string }
+
+func (r *gitRepo) Kind() Kind   { return Git }
+func (r *gitRepo) Root() string { return r.root }
+
+func (r *gitRepo) Head(ctx context.Context) (string, error) {
+	out, err := run(ctx, r.root, "git", "rev-parse", "HEAD")
+	return firstLine(out), err
+}
+
+func (r *gitRepo) Log(ctx context.Context, base, head string, n int) ([]Commit, error) {
+	out, err := run(ctx, r.root, "git", "log", "-n", strconv.Itoa(n), "--pretty=format:%H%x00%B%x00", "^"+base, head)
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out), nil
+}
+
+func (r *gitRepo) Diff(ctx context.Context, base, to string, lines int) (string, error) {
+	args := []string{"diff", fmt.Sprintf("--unified=%d", lines), base}
+	if to != "" {
+		args = append(args, to)
+	}
+	return run(ctx, r.root, "git", args...)
+}
+
+func (r *gitRepo) Show(ctx context.Context, id string, lines int) (string, error) {
+	return run(ctx, r.root, "git", "show", fmt.Sprintf("--unified=%d", lines), id)
+}
+
+// hgRepo is a Mercurial repository. Its commits are those of the working
+// directory's parent and its ancestors.
+type hgRepo struct{ root string }
+
+func (r *hgRepo) Kind() Kind   { return Mercurial }
+func (r *hgRepo) Root() string { return r.root }
+
+func (r *hgRepo) Head(ctx context.Context) (string, error) {
+	out, err := run(ctx, r.root, "hg", "log", "-r", ".", "-T", "{node}")
+	return firstLine(out), err
+}
+
+func (r *hgRepo) Log(ctx context.Context, base, head string, n int) ([]Commit, error) {
+	revs := fmt.Sprintf("reverse(only(%s, %s))", head, base)
+	out, err := run(ctx, r.root, "hg", "log", "-r", revs, "-l", strconv.Itoa(n), "-T", `{node}\0{desc}\0`)
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out), nil
+}
+
+func (r *hgRepo) Diff(ctx context.Context, base, to string, lines int) (string, error) {
+	args := []string{"diff", "--git", "-U", strconv.Itoa(lines), "-r", base}
+	if to != "" {
+		args = append(args, "-r", to)
+	}
+	return run(ctx, r.root, "hg", args...)
+}
+
+func (r *hgRepo) Show(ctx context.Context, id string, lines int) (string, error) {
+	return run(ctx, r.root, "hg", "diff", "--git", "-U", strconv.Itoa(lines), "-c", id)
+}
+
+// jjRepo is a Jujutsu repository. jj has no staging area: the working copy is
+// itself a commit, @, that jj amends as files change. Work counts as committed
+// once it has been moved out of @, so Head is @'s parent.
+type jjRepo struct{ root string }
+
+func (r *jjRepo) Kind() Kind   { return Jujutsu }
+func (r *jjRepo) Root() string { return r.root }
+
+func (r *jjRepo) Head(ctx context.Context) (string, error) {
+	// With a merge as @'s parent, either side will do as a base.
+	out, err := run(ctx, r.root, "jj", "log", "--no-graph", "--ignore-working-copy", "-r", "@-", "-T", `commit_id ++ "\n"`)
+	return firstLine(out), err
+}
+
+func (r *jjRepo) Log(ctx context.Context, base, head string, n int) ([]Commit, error) {
+	out, err := run(ctx, r.root, "jj", "log", "--no-graph", "--ignore-working-copy",
+		"-r", base+".."+head, "-n", strconv.Itoa(n), "-T", `commit_id ++ "\0" ++ description ++ "\0"`)
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out), nil
+}
+
+func (r *jjRepo) Diff(ctx context.Context, base, to string, lines int) (string, error) {
+	args := []string{"diff", "--git", "--context", strconv.Itoa(lines), "--from", base}
+	if to != "" {
+		args = append(args, "--to", to)
+	}
+	return run(ctx, r.root, "jj", args...)
+}
+
+func (r *jjRepo) Show(ctx context.Context, id string, lines int) (string, error) {
+	return run(ctx, r.root, "jj", "diff", "--git", "--context", strconv.Itoa(lines), "-r", id)
+}
@@ -0,0 +1,146 @@
+// Package vcs gives sketch a read-only view of a repository under git,
+// Mercurial, or Jujutsu: its latest commit, the commits made since a base
+// commit, and diffs from that base.
+//
+// Everything that writes to a repository, such as tagging sketch-base and
+// pushing the sketch-wip branch, remains git-only and lives with the agent.
+package vcs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// A Kind is a version control system.
+type Kind string
+
+const (
+	Git       Kind = "git"
+	Mercurial Kind = "hg"
+	Jujutsu   Kind = "jj"
+)
+
+// A Commit is a commit in a repository's history.
+type Commit struct {
+	ID      string // full commit hash
+	Subject string
+	Body    string
+}
+
+// A Repo is a working copy under version control.
+type Repo interface {
+	Kind() Kind
+	Root() string
+
+	// Head returns the ID of the latest commit, leaving out changes
+	// that haven't been committed.
+	Head(ctx context.Context) (string, error)
+
+	// Log returns up to n commits that head has and base doesn't, newest first.
+	Log(ctx context.Context, base, head string, n int) ([]Commit, error)
+
+	// Diff returns a git-style unified diff, with lines of context,
+	// from base to to, or to the working copy if to is empty.
+	Diff(ctx context.Context, base, to string, lines int) (string, error)
+
+	// Show returns the diff a single commit made, with lines of context.
+	Show(ctx context.Context, id string, lines int) (string, error)
+}
+
+// ErrNoRepo is returned by Detect for directories outside any repository.
+var ErrNoRepo = errors.New("not in a git, jj, or hg repository")
+
+// Detect returns the repository dir is in. A Jujutsu repo colocated with
+// git is treated as a git repo, which gets sketch's full git support.
+func Detect(dir string) (Repo, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		switch {
+		case exists(filepath.Join(d, ".git")):
+			return &gitRepo{root: d}, nil
+		case exists(filepath.Join(d, ".jj")):
+			return &jjRepo{root: d}, nil
+		case exists(filepath.Join(d, ".hg")):
+			return &hgRepo{root: d}, nil
+		}
+		if filepath.Dir(d) == d {
+			return nil, fmt.Errorf("%s: %w", dir, ErrNoRepo)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// DiffStat counts the lines a unified diff adds and removes.
+func DiffStat(diff string) (added, removed int) {
+	sc := bufio.NewScanner(strings.NewReader(diff))
+	sc.Buffer(nil, 1<<20)
+	inHunk := false
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			inHunk = false
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+		case !inHunk:
+			// file headers, including the ---/+++ lines
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// run runs a version control command in dir and returns its stdout.
+func run(ctx context.Context, dir, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	stderr := new(strings.Builder)
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w\n%s", name, strings.Join(args, " "), err, stderr)
+	}
+	return string(out), nil
+}
+
+// parseLog parses log output in which each commit is its ID and full
+// description, each followed by a NUL byte.
+func parseLog(out string) []Commit {
+	parts := strings.Split(out, "\x00")
+	var commits []Commit
+	for i := 0; i+1 < len(parts); i += 2 {
+		id := strings.TrimSpace(parts[i])
+		if id == "" {
+			continue
+		}
+		subject, body, _ := strings.Cut(strings.TrimSpace(parts[i+1]), "\n")
+		commits = append(commits, Commit{ID: id, Subject: subject, Body: strings.TrimSpace(body)})
+	}
+	return commits
+}
+
+// firstLine returns the first non-empty line of out.
+func firstLine(out string) string {
+	for line := range strings.Lines(out) {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	return ""
+}
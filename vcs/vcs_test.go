@@ -0,0 +1,165 @@
+package vcs
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"hg/.hg", "hg/sub/dir", "jj/.jj", "colocated/.jj", "colocated/.git", "plain"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		dir  string
+		kind Kind
+		root string
+	}{
+		{"hg/sub/dir", Mercurial, "hg"},
+		{"jj", Jujutsu, "jj"},
+		{"colocated", Git, "colocated"},
+	}
+	for _, tt := range tests {
+		repo, err := Detect(filepath.Join(dir, tt.dir))
+		if err != nil {
+			t.Errorf("Detect(%s): %v", tt.dir, err)
+			continue
+		}
+		if repo.Kind() != tt.kind || repo.Root() != filepath.Join(dir, tt.root) {
+			t.Errorf("Detect(%s) = %s at %s, want %s at %s", tt.dir, repo.Kind(), repo.Root(), tt.kind, tt.root)
+		}
+	}
+}
+
+func TestDiffStat(t *testing.T) {
+	diff := `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ keep
+--- a removed line that looks like a header
++new
++another
+diff --git a/b.txt b/b.txt
+new file mode 100644
+--- /dev/null
++++ b/b.txt
+@@ -0,0 +1 @@
++b
+`
+	if added, removed := DiffStat(diff); added != 3 || removed != 1 {
+		t.Errorf("DiffStat = +%d -%d, want +3 -1", added, removed)
+	}
+}
+
+func TestParseLog(t *testing.T) {
+	out := "abc\x00add feature\n\nLonger explanation.\n\x00\ndef\x00fix typo\x00"
+	want := []Commit{
+		{ID: "abc", Subject: "add feature", Body: "Longer explanation."},
+		{ID: "def", Subject: "fix typo"},
+	}
+	if got := parseLog(out); !slices.Equal(got, want) {
+		t.Errorf("parseLog = %+v, want %+v", got, want)
+	}
+}
+
+// TestRepos exercises each implementation against a real repository,
+// for the version control tools that are installed.
+func TestRepos(t *testing.T) {
+	setups := map[Kind][][]string{
+		Git: {
+			{"git", "init"},
+			{"git", "config", "user.name", "Test"},
+			{"git", "config", "user.email", "test@example.com"},
+			{"git", "add", "."},
+			{"git", "commit", "-m", "base"},
+		},
+		Mercurial: {
+			{"hg", "init"},
+			{"hg", "commit", "-A", "-u", "test", "-m", "base"},
+		},
+		Jujutsu: {
+			{"jj", "git", "init"},
+			{"jj", "commit", "-m", "base"},
+		},
+	}
+	commit := map[Kind][][]string{
+		Git:       {{"git", "commit", "-a", "-m", "change one\n\nWith a body."}},
+		Mercurial: {{"hg", "commit", "-u", "test", "-m", "change one\n\nWith a body."}},
+		Jujutsu:   {{"jj", "commit", "-m", "change one\n\nWith a body."}},
+	}
+	for kind, setup := range setups {
+		t.Run(string(kind), func(t *testing.T) {
+			if _, err := exec.LookPath(setup[0][0]); err != nil {
+				t.Skipf("%s not installed", setup[0][0])
+			}
+			dir := t.TempDir()
+			t.Setenv("JJ_USER", "Test")
+			t.Setenv("JJ_EMAIL", "test@example.com")
+			write := func(content string) {
+				if err := os.WriteFile(filepath.Join(dir, "f.txt"), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			runAll := func(cmds [][]string) {
+				for _, args := range cmds {
+					cmd := exec.Command(args[0], args[1:]...)
+					cmd.Dir = dir
+					if out, err := cmd.CombinedOutput(); err != nil {
+						t.Fatalf("%v: %s: %v", args, out, err)
+					}
+				}
+			}
+			write("one\ntwo\n")
+			runAll(setup)
+
+			repo, err := Detect(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if repo.Kind() != kind {
+				t.Fatalf("Detect found %s", repo.Kind())
+			}
+			ctx := t.Context()
+			base, err := repo.Head(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			write("one\n2\n3\n")
+			runAll(commit[kind])
+			head, err := repo.Head(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			log, err := repo.Log(ctx, base, head, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []Commit{{ID: head, Subject: "change one", Body: "With a body."}}; !slices.Equal(log, want) {
+				t.Errorf("Log = %+v, want %+v", log, want)
+			}
+
+			write("one\n2\n3\n4\n")
+			diff, err := repo.Diff(ctx, base, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added, removed := DiffStat(diff); added != 3 || removed != 1 {
+				t.Errorf("working copy diff = +%d -%d, want +3 -1:\n%s", added, removed, diff)
+			}
+			show, err := repo.Show(ctx, head, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added, removed := DiffStat(show); added != 2 || removed != 1 {
+				t.Errorf("commit diff = +%d -%d, want +2 -1:\n%s", added, removed, show)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+// Package webhook posts session events to a user's HTTP endpoint, so that
+// chat-ops integrations and dashboards can follow a session without polling.
+//
+// Each event is a JSON POST. With a secret, the X-Sketch-Signature-256 header
+// carries "sha256=" and the hex HMAC-SHA256 of the body, as GitHub's webhooks
+// do. Deliveries that fail with a network error, a 429 or a 5xx are retried
+// with backoff; every delivery, successful or not, is appended to a log.
+//
+// Webhooks are sent from the host, which holds the secret: in a container the
+// agent forwards its events to the outer sketch instead.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An EventType is a kind of event a webhook reports.
+type EventType string
+
+const (
+	CommitEvent    EventType = "commit"      // the agent made commits
+	EndOfTurnEvent EventType = "end_of_turn" // the agent finished its turn and waits for the user
+	ErrorEvent     EventType = "error"       // the agent hit an error
+	BudgetEvent    EventType = "budget"      // the agent stopped for exceeding its budget
+	ApprovalEvent  EventType = "approval"    // a tool call waits for the user's approval
+)
+
+// Events are all the event types.
+var Events = []EventType{CommitEvent, EndOfTurnEvent, ErrorEvent, BudgetEvent, ApprovalEvent}
+
+// A Commit is a commit in a commit event.
+type Commit struct {
+	Hash    string `json:"hash"`
+	Subject string `json:"subject"`
+	Branch  string `json:"branch,omitempty"` // where it was pushed, if it was
+}
+
+// An Event is the body of a webhook delivery.
+type Event struct {
+	ID        string    `json:"id"` // the same across retries, for receivers to drop duplicates
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id"`
+	URL       string    `json:"url,omitempty"`      // of the session's web UI
+	Message   string    `json:"message,omitempty"`  // the agent's closing message, or the error
+	Commits   []Commit  `json:"commits,omitempty"`  // for commit events
+	CostUSD   float64   `json:"cost_usd,omitempty"` // the session's cost so far
+}
+
+// maxMessage bounds an event's Message; the start is kept.
+const maxMessage = 4096
+
+// A Sender takes events to deliver. Send must not block.
+type Sender interface {
+	Send(Event)
+}
+
+// ParseEvents parses a comma-separated list of event types.
+// An empty list is all of them.
+func ParseEvents(spec string) ([]EventType, error) {
+	var events []EventType
+	for name := range strings.SplitSeq(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(Events, EventType(name)) {
+			return nil, fmt.Errorf("unknown webhook event %q; events are %s", name, eventList())
+		}
+		events = append(events, EventType(name))
+	}
+	if len(events) == 0 {
+		return slices.Clone(Events), nil
+	}
+	return events, nil
+}
+
+func eventList() string {
+	names := make([]string, len(Events))
+	for i, e := range Events {
+		names[i] = string(e)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ValidateURL reports whether u can receive webhooks.
+func ValidateURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook URL %q: want an http or https URL", u)
+	}
+	return nil
+}
+
+// Sign returns the X-Sketch-Signature-256 header value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DefaultLogPath is where the deliveries of a session are logged.
+func DefaultLogPath(sessionID string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sketch", "webhooks", sessionID+".jsonl"), nil
+}
+
+// Config configures a Dispatcher.
+type Config struct {
+	URL     string
+	Secret  string      // signs deliveries, if set
+	Events  []EventType // the events to send; nil sends all
+	LogPath string      // where deliveries are logged, if set
+}
+
+// A Delivery is the outcome of sending one event, as logged.
+type Delivery struct {
+	ID        string    `json:"id"`
+	Type      EventType `json:"type"`
+	Time      time.Time `json:"time"` // of the last attempt
+	Attempts  int       `json:"attempts"`
+	Status    int       `json:"status,omitempty"` // the last HTTP status, if there was a response
+	Error     string    `json:"error,omitempty"`
+	Delivered bool      `json:"delivered"`
+}
+
+const (
+	queueSize   = 100 // events waiting to be sent; more are dropped
+	maxAttempts = 5
+)
+
+// retryDelay is the wait after the first failed attempt; it doubles after each.
+var retryDelay = time.Second
+
+// A Dispatcher delivers events to a webhook, in order, one at a time.
+// A nil Dispatcher sends nothing.
+type Dispatcher struct {
+	config Config
+	client *http.Client
+	queue  chan Event
+	done   chan struct{} // closed when the queue has drained
+	stop   chan struct{} // closed to abandon retries
+	closed sync.Once
+	gaveUp sync.Once
+
+	mu      sync.Mutex
+	closing bool // once the queue is closed
+}
+
+// New returns a Dispatcher for cfg, or nil if cfg has no URL.
+func New(cfg Config) (*Dispatcher, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	if err := ValidateURL(cfg.URL); err != nil {
+		return nil, err
+	}
+	if cfg.Events == nil {
+		cfg.Events = Events
+	}
+	d := &Dispatcher{
+		config: cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, queueSize),
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go d.run()
+	return d, nil
+}
+
+// Send queues e for delivery, unless it isn't one of the configured events.
+// It fills in the ID and time if they are unset.
+func (d *Dispatcher) Send(e Event) {
+	if d == nil || !slices.Contains(d.config.Events, e.Type) {
+		return
+	}
+	if e.ID == "" {
+		e.ID = rand.Text()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if len(e.Message) > maxMessage {
+		e.Message = e.Message[:maxMessage] + "…"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closing {
+		return
+	}
+	select {
+	case d.queue <- e:
+	default:
+		slog.Warn("webhook queue full, dropping event", "type", e.Type, "id", e.ID)
+	}
+}
+
+// Close stops taking events and waits, until ctx is done, for the queued ones
+// to be delivered. Events sent after Close are dropped.
+func (d *Dispatcher) Close(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	d.closed.Do(func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.closing = true
+		close(d.queue)
+	})
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		d.gaveUp.Do(func() {
+			close(d.stop)
+			slog.Warn("webhook deliveries abandoned at shutdown")
+		})
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for e := range d.queue {
+		d.record(d.deliver(e))
+	}
+}
+
+// deliver sends e, retrying while the failure looks temporary.
+func (d *Dispatcher) deliver(e Event) Delivery {
+	dl := Delivery{ID: e.ID, Type: e.Type}
+	body, err := json.Marshal(e)
+	if err != nil {
+		dl.Error = err.Error()
+		return dl
+	}
+	delay := retryDelay
+	for dl.Attempts < maxAttempts {
+		dl.Attempts++
+		dl.Time = time.Now()
+		retry := false
+		dl.Status, retry, err = d.post(e, body)
+		if err == nil {
+			dl.Delivered, dl.Error = true, ""
+			return dl
+		}
+		dl.Error = err.Error()
+		if !retry || dl.Attempts == maxAttempts {
+			break
+		}
+		select {
+		case <-time.After(delay):
+		case <-d.stop:
+			return dl
+		}
+		delay *= 2
+	}
+	return dl
+}
+
+func (d *Dispatcher) post(e Event, body []byte) (status int, retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, d.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sketch-webhook")
+	req.Header.Set("X-Sketch-Event", string(e.Type))
+	req.Header.Set("X-Sketch-Delivery", e.ID)
+	if d.config.Secret != "" {
+		req.Header.Set("X-Sketch-Signature-256", Sign(d.config.Secret, body))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return resp.StatusCode, false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return resp.StatusCode, retry, fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+}
+
+// record logs dl, and appends it to the delivery log if there is one.
+func (d *Dispatcher) record(dl Delivery) {
+	if dl.Delivered {
+		slog.Debug("webhook delivered", "type", dl.Type, "id", dl.ID, "attempts", dl.Attempts)
+	} else {
+		slog.Warn("webhook delivery failed", "type", dl.Type, "id", dl.ID, "attempts", dl.Attempts, "error", dl.Error)
+	}
+	if d.config.LogPath == "" {
+		return
+	}
+	line, err := json.Marshal(dl)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(d.config.LogPath), 0o700); err != nil {
+		slog.Warn("webhook delivery log", "error", err)
+		return
+	}
+	f, err := os.OpenFile(d.config.LogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		slog.Warn("webhook delivery log", "error", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
+
+// A Forwarder sends events to the outer sketch, at URL, which delivers them.
+// It is what a container uses, since the webhook's secret stays on the host.
+type Forwarder struct {
+	URL    string
+	Events []EventType
+}
+
+// Send posts e in the background, unless it isn't one of f's events.
+func (f *Forwarder) Send(e Event) {
+	if f == nil || !slices.Contains(f.Events, e.Type) {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	go func() {
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Post(f.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			slog.Debug("forwarding webhook event", "type", e.Type, "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			slog.Debug("forwarding webhook event", "type", e.Type, "status", resp.Status)
+		}
+	}()
+}
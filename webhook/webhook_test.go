@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseEvents(t *testing.T) {
+	if got, err := ParseEvents(""); err != nil || len(got) != len(Events) {
+		t.Errorf("ParseEvents(\"\") = %v, %v; want all events", got, err)
+	}
+	if got, err := ParseEvents("commit, budget"); err != nil || len(got) != 2 || got[0] != CommitEvent || got[1] != BudgetEvent {
+		t.Errorf("ParseEvents = %v, %v", got, err)
+	}
+	if _, err := ParseEvents("commit,push"); err == nil {
+		t.Error("ParseEvents accepted an unknown event")
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	retryDelay = time.Millisecond
+	var calls atomic.Int32
+	got := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.Header.Get("X-Sketch-Event") {
+		case string(CommitEvent):
+			if calls.Add(1) < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+		case string(ErrorEvent):
+			http.Error(w, "no", http.StatusBadRequest)
+			return
+		}
+		got <- r
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	logPath := filepath.Join(t.TempDir(), "deliveries.jsonl")
+	d, err := New(Config{URL: ts.URL, Secret: "s3cret", Events: []EventType{CommitEvent, ErrorEvent}, LogPath: logPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Send(Event{Type: CommitEvent, SessionID: "abc", Commits: []Commit{{Hash: "1234", Subject: "Fix it"}}})
+	d.Send(Event{Type: EndOfTurnEvent, SessionID: "abc"}) // not configured
+	d.Send(Event{Type: ErrorEvent, SessionID: "abc", Message: "oops"})
+	d.Close(context.Background())
+
+	r, body := <-got, <-bodies
+	if sig := r.Header.Get("X-Sketch-Signature-256"); sig != Sign("s3cret", body) {
+		t.Errorf("signature = %q, want %q", sig, Sign("s3cret", body))
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil || e.Type != CommitEvent || e.ID == "" || e.ID != r.Header.Get("X-Sketch-Delivery") || len(e.Commits) != 1 {
+		t.Errorf("delivered %s (%v)", body, err)
+	}
+	if len(got) != 0 {
+		t.Errorf("%d more deliveries, want none", len(got))
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var log []Delivery
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var dl Delivery
+		if err := json.Unmarshal(sc.Bytes(), &dl); err != nil {
+			t.Fatal(err)
+		}
+		log = append(log, dl)
+	}
+	if len(log) != 2 {
+		t.Fatalf("delivery log = %+v, want 2 entries", log)
+	}
+	if dl := log[0]; !dl.Delivered || dl.Attempts != 3 || dl.Status != http.StatusOK {
+		t.Errorf("commit delivery = %+v, want delivered on the third attempt", dl)
+	}
+	if dl := log[1]; dl.Delivered || dl.Attempts != 1 || dl.Status != http.StatusBadRequest {
+		t.Errorf("error delivery = %+v, want one failed attempt", dl)
+	}
+}
+
+func TestNewWithoutURL(t *testing.T) {
+	d, err := New(Config{})
+	if d != nil || err != nil {
+		t.Fatalf("New(Config{}) = %v, %v; want nil, nil", d, err)
+	}
+	d.Send(Event{Type: CommitEvent})
+	d.Close(context.Background())
+	if _, err := New(Config{URL: "ftp://example.com"}); err == nil {
+		t.Error("New accepted an ftp URL")
+	}
+}
@@ -353,6 +353,7 @@ func Build() (fs.FS, error) {
 	}
 
 	// Compress all .js, .js.map, and .css files with gzip, leaving the originals in place
+	var compressed []string
 	err = filepath.Walk(tmpHashDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -397,20 +398,41 @@ func Build() (fs.FS, error) {
 			return fmt.Errorf("failed to close gzip file %s: %w", gzipPath, err)
 		}
 
-		// The gzip handler will decompress on-the-fly for browsers that don't support gzip.
-		if err := os.Remove(path); err != nil {
-			return fmt.Errorf("failed to remove uncompressed file %s: %w", path, err)
-		}
-
+		compressed = append(compressed, path)
 		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to compress .js/.js.map/.css files: %w", err)
 	}
+	if err := brotliCompress(compressed); err != nil {
+		return nil, err
+	}
+	// The gzip handler will decompress on-the-fly for browsers that don't support gzip.
+	for _, path := range compressed {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove uncompressed file %s: %w", path, err)
+		}
+	}
 
 	return os.DirFS(tmpHashDir), nil
 }
 
+// brotliCompress writes a brotli-compressed copy of each file next to it, as file.br.
+// Go has no brotli encoder, but node, which the build needs anyway, does.
+func brotliCompress(files []string) error {
+	const script = `const fs = require("fs"), zlib = require("zlib");
+for (const f of process.argv.slice(1)) {
+  fs.writeFileSync(f + ".br", zlib.brotliCompressSync(fs.readFileSync(f), {
+    params: {[zlib.constants.BROTLI_PARAM_QUALITY]: zlib.constants.BROTLI_MAX_QUALITY},
+  }));
+}`
+	cmd := exec.Command("node", append([]string{"-e", script}, files...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("brotli compression: %s: %v", out, err)
+	}
+	return nil
+}
+
 func esbuildBundle(outDir, src, metafilePath string) error {
 	args := []string{
 		src,
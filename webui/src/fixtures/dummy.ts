@@ -572,6 +572,8 @@ export const initialState: State = {
   agent_state: "WaitingForUserInput",
   diff_lines_added: 42,
   diff_lines_removed: 7,
+  diff_files_changed: 3,
+  diff_commits: 2,
   open_ports: [
     {
       port: 3000,
@@ -0,0 +1,70 @@
+import { UploadCreateRequest, UploadStatus } from "../types";
+
+// How many times a chunk is retried before the upload gives up.
+const maxChunkAttempts = 3;
+
+async function sha256Hex(file: File): Promise<string | undefined> {
+  // crypto.subtle only exists in secure contexts (https or localhost).
+  if (!crypto?.subtle) return undefined;
+  const digest = await crypto.subtle.digest(
+    "SHA-256",
+    await file.arrayBuffer(),
+  );
+  return Array.from(new Uint8Array(digest))
+    .map((b) => b.toString(16).padStart(2, "0"))
+    .join("");
+}
+
+async function uploadError(response: Response): Promise<Error> {
+  const text = (await response.text()).trim();
+  return new Error(text || response.statusText);
+}
+
+/**
+ * Uploads file to the session in chunks, resuming after dropped chunks,
+ * and resolves to the path the agent can find it at.
+ */
+export async function uploadFile(
+  file: File,
+  onProgress?: (sent: number, total: number) => void,
+): Promise<string> {
+  const req: UploadCreateRequest = {
+    name: file.name,
+    size: file.size,
+    sha256: await sha256Hex(file),
+  };
+  const created = await fetch("./uploads", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify(req),
+  });
+  if (!created.ok) throw await uploadError(created);
+  let status: UploadStatus = await created.json();
+
+  let attempts = 0;
+  while (!status.path) {
+    onProgress?.(status.offset, status.size);
+    const chunk = file.slice(status.offset, status.offset + status.chunk_size);
+    let response: Response | undefined;
+    try {
+      response = await fetch(
+        `./uploads/${encodeURIComponent(status.id)}?offset=${status.offset}`,
+        { method: "PUT", body: chunk },
+      );
+    } catch (error) {
+      if (++attempts >= maxChunkAttempts) throw error;
+    }
+    if (response?.ok) {
+      status = await response.json();
+      attempts = 0;
+      continue;
+    }
+    // Besides dropped connections, only a disagreement over the offset is worth retrying.
+    if (response && response.status !== 409) throw await uploadError(response);
+    const current = await fetch(`./uploads/${encodeURIComponent(status.id)}`);
+    if (!current.ok) throw await uploadError(current);
+    status = await current.json();
+  }
+  onProgress?.(status.size, status.size);
+  return status.path;
+}
@@ -1,6 +1,20 @@
 // Auto-generated by sketch.dev/cmd/go2ts.go
 // DO NOT EDIT. This file is automatically generated.
 
+export interface SkabandStatus {
+	state: ConnState;
+	since: string;
+	last_error?: string;
+	queued?: number;
+	unavailable?: string[] | null;
+}
+
+export interface BashViolation {
+	command: string;
+	rule?: string;
+	reason: string;
+}
+
 export interface ExternalMessage {
 	message_type: string;
 	body: any;
@@ -31,6 +45,28 @@ export interface Usage {
 	cost_usd: number;
 }
 
+export interface Milestone {
+	first_idx: number;
+	last_idx: number;
+	summary: string;
+}
+
+export interface ApprovalRequest {
+	id: string;
+	tool: string;
+	input: string;
+	tool_call_id?: string;
+	requested: string;
+}
+
+export interface Artifact {
+	id: string;
+	kind: string;
+	media_type: string;
+	size: number;
+	created_at: string;
+}
+
 export interface AgentMessage {
 	type: CodingAgentMessageType;
 	end_of_turn: boolean;
@@ -41,6 +77,7 @@ export interface AgentMessage {
 	tool_result?: string;
 	tool_error?: boolean;
 	tool_call_id?: string;
+	tool_result_omitted?: number;
 	tool_calls?: ToolCall[] | null;
 	toolResponses?: AgentMessage[] | null;
 	commits?: (GitCommit | null)[] | null;
@@ -52,12 +89,63 @@ export interface AgentMessage {
 	end_time?: string | null;
 	elapsed?: Duration | null;
 	turnDuration?: Duration | null;
+	stateDurations?: { [key: string]: Duration } | null;
 	hide_output?: boolean;
 	todo_content?: string | null;
 	display?: any;
+	milestone?: Milestone | null;
+	approval?: ApprovalRequest | null;
+	artifacts?: Artifact[] | null;
+	pinned?: boolean;
 	idx: number;
 }
 
+export interface StateVisit {
+	turn: number;
+	state: string;
+	reason: string;
+	entered: string;
+	exited?: string | null;
+	duration: Duration;
+}
+
+export interface Subject {
+	name: string;
+	digest: { [key: string]: string } | null;
+}
+
+export interface Attestation {
+	subjects: Subject[] | null;
+	commit: string;
+	dirty?: boolean;
+	sbom_tool: string;
+	packages: number;
+	written?: string[] | null;
+	artifacts: Artifact[] | null;
+}
+
+export interface MatrixResult {
+	env: string;
+	image: string;
+	passed: boolean;
+	exit_code: number;
+	output?: string;
+	duration: Duration;
+	error?: string;
+}
+
+export interface MatrixRun {
+	command: string;
+	results: MatrixResult[] | null;
+}
+
+export interface BlockedCommand {
+	time: string;
+	session_id: string;
+	script: string;
+	violation: BashViolation;
+}
+
 export interface CumulativeUsage {
 	start_time: string;
 	messages: number;
@@ -69,6 +157,14 @@ export interface CumulativeUsage {
 	tool_uses: { [key: string]: number } | null;
 }
 
+export interface ToolCallProgress {
+	tool_call_id: string;
+	tool_name: string;
+	label: string;
+	done?: number;
+	total?: number;
+}
+
 export interface Port {
 	proto: string;
 	port: number;
@@ -76,6 +172,12 @@ export interface Port {
 	pid: number;
 }
 
+export interface ImageProvenance {
+	base_image: string;
+	base_digest?: string;
+	image: string;
+}
+
 export interface State {
 	state_version: number;
 	message_count: number;
@@ -91,6 +193,7 @@ export interface State {
 	git_username?: string;
 	outstanding_llm_calls: number;
 	outstanding_tool_calls: string[] | null;
+	tool_progress?: ToolCallProgress[] | null;
 	session_id: string;
 	ssh_available: boolean;
 	ssh_error?: string;
@@ -105,16 +208,28 @@ export interface State {
 	inside_working_dir?: string;
 	todo_content?: string;
 	skaband_addr?: string;
+	skaband?: SkabandStatus | null;
 	link_to_github?: boolean;
 	ssh_connection_string?: string;
 	diff_lines_added: number;
 	diff_lines_removed: number;
+	diff_files_changed: number;
+	diff_commits: number;
 	open_ports?: Port[] | null;
 	token_context_window?: number;
 	model?: string;
 	session_ended?: boolean;
 	can_send_messages?: boolean;
 	ended_at?: string;
+	turn_timeout?: string;
+	sampling?: string;
+	last_activity?: string | null;
+	idle_suspend?: string;
+	suspensions?: number;
+	resumed_at?: string | null;
+	image?: ImageProvenance | null;
+	plan_mode?: boolean;
+	approvals?: ApprovalRequest[] | null;
 }
 
 export interface TodoItem {
@@ -127,6 +242,15 @@ export interface TodoList {
 	items: TodoItem[] | null;
 }
 
+export interface TodoUpdateRequest {
+	task?: string | null;
+	status?: string | null;
+}
+
+export interface TodoReorderRequest {
+	ids: string[] | null;
+}
+
 export interface Remote {
 	name: string;
 	url: string;
@@ -138,6 +262,7 @@ export interface GitPushInfoResponse {
 	hash: string;
 	subject: string;
 	remotes: Remote[] | null;
+	branch?: string;
 }
 
 export interface GitPushRequest {
@@ -146,6 +271,8 @@ export interface GitPushRequest {
 	commit: string;
 	dry_run: boolean;
 	force: boolean;
+	create_branch: boolean;
+	set_upstream: boolean;
 }
 
 export interface GitPushResponse {
@@ -153,6 +280,318 @@ export interface GitPushResponse {
 	output: string;
 	dry_run: boolean;
 	error?: string;
+	status?: string;
+	reason?: string;
+	upstream?: string;
+	pull_request_url?: string;
+}
+
+export interface TerminalInfo {
+	sessionId: string;
+	name: string;
+	createdAt: string;
+	clients: number;
+	pid: number;
+	writer?: number;
+}
+
+export interface TerminalControl {
+	client: number;
+	writer: number;
+}
+
+export interface TerminalCreateRequest {
+	sessionId?: string;
+	name?: string;
+}
+
+export interface TerminalResponse {
+	sessionId: string;
+}
+
+export interface TurnTimeoutRequest {
+	timeout: string;
+}
+
+export interface CostEstimateRequest {
+	message: string;
+}
+
+export interface FileActivityEntry {
+	idx: number;
+	kind: string;
+	tool_name?: string;
+	tool_call_id?: string;
+	commit_hash?: string;
+	summary: string;
+	timestamp: string;
+}
+
+export interface FileActivityResponse {
+	path: string;
+	entries: FileActivityEntry[] | null;
+}
+
+export interface EcosystemResult {
+	ecosystem: string;
+	tool: string;
+	skipped?: string;
+	error?: string;
+	base_error?: string;
+}
+
+export interface Vulnerability {
+	ecosystem: string;
+	id: string;
+	package: string;
+	version?: string;
+	fixed_version?: string;
+	severity?: string;
+	summary?: string;
+}
+
+export interface Report {
+	ran_at: string;
+	commit: string;
+	ecosystems: EcosystemResult[] | null;
+	introduced: Vulnerability[] | null;
+	fixed: Vulnerability[] | null;
+	unchanged: number;
+	unknown_baseline?: Vulnerability[] | null;
+}
+
+export interface Entry {
+	id: string;
+	branch: string;
+	commit?: string;
+	state: State;
+	url?: string;
+	detail?: string;
+	updated_at: string;
+}
+
+export interface MergeQueueEnqueueRequest {
+	branch: string;
+}
+
+export interface ApplyPatchRequest {
+	patch: string;
+	note?: string;
+}
+
+export interface UploadCreateRequest {
+	name: string;
+	size: number;
+	sha256?: string;
+}
+
+export interface UploadStatus {
+	id: string;
+	name: string;
+	size: number;
+	offset: number;
+	chunk_size: number;
+	sha256?: string;
+	path?: string;
+}
+
+export interface ContainerFile {
+	name: string;
+	path: string;
+	dir: boolean;
+	size: number;
+	mod_time: string;
+}
+
+export interface ContainerFileList {
+	path: string;
+	roots: string[] | null;
+	files: ContainerFile[] | null;
+	truncated?: boolean;
+	max_size: number;
+}
+
+export interface AuthUser {
+	user: string;
+	name?: string;
+	role: Role;
+}
+
+export interface AuditEntry {
+	time: string;
+	user: string;
+	action: string;
+	detail?: string;
+}
+
+export interface PatchResult {
+	commit: string;
+	files: string[] | null;
+	checks?: string;
+}
+
+export interface FeedbackRequest {
+	message_idx: number;
+	rating: string;
+	comment?: string;
+}
+
+export interface CompactRequest {
+	strategy?: string;
+}
+
+export interface PinRequest {
+	message_idx: number;
+	pinned: boolean;
+}
+
+export interface ResumedRequest {
+	suspended_at: string;
+}
+
+export interface Feedback {
+	session_id: string;
+	message_idx: number;
+	rating: string;
+	comment?: string;
+	message_type: CodingAgentMessageType;
+	tools?: string[] | null;
+	model?: string;
+	time: string;
+}
+
+export interface HistoryMatch {
+	matched: string[] | null;
+	session_id: string;
+	path: string;
+	prompt: string;
+	outcome?: string;
+	branch?: string;
+	repo?: string;
+	commit?: string;
+	files?: string[] | null;
+	saved_at: string;
+}
+
+export interface FileStat {
+	path: string;
+	old_path?: string;
+	additions: number;
+	deletions: number;
+	binary?: boolean;
+}
+
+export interface CommitStats {
+	hash: string;
+	subject: string;
+	additions: number;
+	deletions: number;
+	files: FileStat[] | null;
+}
+
+export interface GitStats {
+	additions: number;
+	deletions: number;
+	files_changed: number;
+	files: FileStat[] | null;
+	commits: CommitStats[] | null;
+}
+
+export interface ImageStatus {
+	base_image: string;
+	base_digest?: string;
+	latest_digest?: string;
+	update_available: boolean;
+	checked_at?: string;
+	error?: string;
+}
+
+export interface ConversationNode {
+	id: string;
+	parent_id?: string;
+	purpose?: string;
+	hidden: boolean;
+	depth: number;
+	start_time: string;
+	last_activity: string;
+	responses: number;
+	tool_calls: number;
+	input_tokens: number;
+	output_tokens: number;
+	cost_usd: number;
+}
+
+export interface BundledFile {
+	path: string;
+	size: number;
+	included: number;
+	truncated?: boolean;
+	skipped?: string;
+}
+
+export interface ContextBundle {
+	files: BundledFile[] | null;
+	bytes: number;
+	budget: number;
+	warnings?: string[] | null;
+}
+
+export interface TrailerPolicy {
+	co_authors: string[] | null;
+	change_id: boolean;
+	sign_off: boolean;
+}
+
+export interface GitIdentity {
+	name: string;
+	email: string;
+	trailers: TrailerPolicy;
+}
+
+export interface GitIdentityRequest {
+	name?: string;
+	email?: string;
+	trailers?: TrailerPolicy | null;
+}
+
+export interface PlanModeRequest {
+	enabled: boolean;
+}
+
+export interface PlanReviewRequest {
+	approve: boolean;
+	comment?: string;
+}
+
+export interface ApproveRequest {
+	id: string;
+	approve: boolean;
+	comment?: string;
+	always?: boolean;
+}
+
+export interface AttachContextRequest {
+	paths: string[] | null;
+}
+
+export interface Sampling {
+	temperature?: number | null;
+	top_p?: number | null;
+	seed?: number | null;
+}
+
+export interface Profile {
+	name: string;
+	width?: number;
+	height?: number;
+	user_agent?: string;
+	has_state: boolean;
+}
+
+export interface Violation {
+	host: string;
+	via: string;
+	time: string;
 }
 
 export interface DiffFile {
@@ -173,6 +612,120 @@ export interface GitLogEntry {
 	subject: string;
 }
 
-export type CodingAgentMessageType = 'user' | 'agent' | 'error' | 'budget' | 'tool' | 'commit' | 'auto' | 'port' | 'compact' | 'slug' | 'external';
+export interface Hunk {
+	old_start: number;
+	old_lines: number;
+	new_start: number;
+	new_lines: number;
+	header?: string;
+}
+
+export interface FileDiff {
+	path: string;
+	old_path?: string;
+	status: string;
+	similarity?: number;
+	binary?: boolean;
+	additions: number;
+	deletions: number;
+	hunks?: Hunk[] | null;
+}
+
+export interface CostEstimate {
+	context_tokens: number;
+	new_tokens: number;
+	output_tokens: number;
+	cost_usd: number;
+	priced: boolean;
+}
+
+export interface TestFailure {
+	name: string;
+	package?: string;
+	location?: string;
+	message?: string;
+}
+
+export interface TestReport {
+	framework: string;
+	passed: number;
+	failed: number;
+	skipped?: number;
+	failures?: TestFailure[] | null;
+	passed_names?: string[] | null;
+	raw_id?: string;
+	raw_bytes?: number;
+}
+
+export interface DevcontainerVSCode {
+	extensions: string[] | null;
+}
+
+export interface DevcontainerJetBrains {
+	backend?: string;
+}
+
+export interface DevcontainerCustomizations {
+	vscode: DevcontainerVSCode;
+	jetbrains: DevcontainerJetBrains;
+}
+
+export interface DevcontainerConfig {
+	name: string;
+	workspaceFolder: string;
+	remoteUser: string;
+	customizations: DevcontainerCustomizations;
+}
+
+export interface DevcontainerInfo {
+	config: DevcontainerConfig;
+	vscode_uri: string;
+	gateway_url: string;
+}
+
+export interface OnboardingCapability {
+	id: string;
+	available: boolean;
+	detail: string;
+	fix?: string;
+}
+
+export interface OnboardingStep {
+	id: string;
+	title: string;
+	needs?: string;
+	optional?: boolean;
+}
+
+export interface OnboardingStepState {
+	status: string;
+	detail?: string;
+	fix?: string;
+	id: string;
+	title: string;
+	needs?: string;
+	optional?: boolean;
+}
+
+export interface OnboardingState {
+	first_run: boolean;
+	status: string;
+	current?: string;
+	steps: OnboardingStepState[] | null;
+	capabilities: OnboardingCapability[] | null;
+	models: string[] | null;
+}
+
+export interface OnboardingStepRequest {
+	action: string;
+}
+
+export type CodingAgentMessageType = 'user' | 'agent' | 'error' | 'budget' | 'tool' | 'commit' | 'auto' | 'port' | 'compact' | 'slug' | 'external' | 'milestone' | 'approval';
+
+export type ConnState = string;
 
 export type Duration = number;
+
+export type State = string;
+
+export type Role = string;
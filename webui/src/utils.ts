@@ -48,3 +48,56 @@ export function generateColorFromId(id: string | null | undefined): string {
   }
   return color;
 }
+
+const displayTimeZoneKey = "displayTimeZone";
+
+/**
+ * Returns the IANA time zone times are displayed in: the one chosen with
+ * setDisplayTimeZone, or else the browser's.
+ */
+export function getDisplayTimeZone(): string {
+  return (
+    localStorage.getItem(displayTimeZoneKey) ||
+    Intl.DateTimeFormat().resolvedOptions().timeZone
+  );
+}
+
+/**
+ * Sets the time zone times are displayed in; "" goes back to the browser's.
+ * Dispatches a "timezone-changed" event on document so that views can re-render.
+ */
+export function setDisplayTimeZone(timeZone: string): void {
+  if (timeZone) {
+    localStorage.setItem(displayTimeZoneKey, timeZone);
+  } else {
+    localStorage.removeItem(displayTimeZoneKey);
+  }
+  document.dispatchEvent(
+    new CustomEvent("timezone-changed", {
+      detail: { timeZone: getDisplayTimeZone() },
+    }),
+  );
+}
+
+/**
+ * Formats a timestamp from the server, which sends UTC, in the display time zone.
+ * Returns defaultValue if the timestamp is missing or invalid.
+ */
+export function formatDateTime(
+  timestamp: string | number | Date | null | undefined,
+  options: Intl.DateTimeFormatOptions,
+  defaultValue: string = "",
+): string {
+  if (!timestamp) return defaultValue;
+  const date = new Date(timestamp);
+  if (isNaN(date.getTime())) return defaultValue;
+  try {
+    return date.toLocaleString("en-US", {
+      ...options,
+      timeZone: getDisplayTimeZone(),
+    });
+  } catch {
+    // An unknown stored zone shouldn't hide times altogether.
+    return date.toLocaleString("en-US", options);
+  }
+}
@@ -52,6 +52,8 @@ export const sampleContainerState: State = {
   ssh_connection_string: "ssh user@example.com",
   diff_lines_added: 245,
   diff_lines_removed: 67,
+  diff_files_changed: 3,
+  diff_commits: 2,
 };
 
 export const lightUsageState: State = {
@@ -70,6 +72,8 @@ export const lightUsageState: State = {
   },
   diff_lines_added: 45,
   diff_lines_removed: 12,
+  diff_files_changed: 3,
+  diff_commits: 2,
 };
 
 export const heavyUsageState: State = {
@@ -94,4 +98,6 @@ export const heavyUsageState: State = {
   },
   diff_lines_added: 2847,
   diff_lines_removed: 1456,
+  diff_files_changed: 3,
+  diff_commits: 2,
 };
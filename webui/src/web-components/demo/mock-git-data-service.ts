@@ -636,6 +636,14 @@ export function setupMockPushEndpoints() {
           success: true,
           output: mockOutput,
           dry_run: isDryRun,
+          status: body.create_branch ? "new" : "fast-forward",
+          upstream:
+            body.set_upstream && !isDryRun
+              ? `${body.remote}/${body.branch}`
+              : undefined,
+          pull_request_url: isDryRun
+            ? undefined
+            : `https://github.com/boldsoftware/bold/pull/new/${body.branch || "main"}`,
         }),
         {
           status: 200,
@@ -2,6 +2,7 @@ import { html } from "lit";
 import { customElement, property, state } from "lit/decorators.js";
 import { createRef, ref } from "lit/directives/ref.js";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
+import { uploadFile as uploadToSession } from "../services/upload";
 
 @customElement("mobile-chat-input")
 export class MobileChatInput extends SketchTailwindElement {
@@ -60,26 +61,12 @@ export class MobileChatInput extends SketchTailwindElement {
     this.adjustTextareaHeight();
 
     try {
-      // Create a FormData object to send the file
-      const formData = new FormData();
-      formData.append("file", file);
-
-      // Upload the file to the server using a relative path
-      const response = await fetch("./upload", {
-        method: "POST",
-        body: formData,
-      });
-
-      if (!response.ok) {
-        throw new Error(`Upload failed: ${response.statusText}`);
-      }
-
-      const data = await response.json();
+      const path = await uploadToSession(file);
 
       // Replace the loading placeholder with the actual file path
-      this.inputValue = this.inputValue.replace(loadingText, `[${data.path}]`);
+      this.inputValue = this.inputValue.replace(loadingText, `[${path}]`);
 
-      return data.path;
+      return path;
     } catch (error) {
       console.error("Failed to upload file:", error);
 
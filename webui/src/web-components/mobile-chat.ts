@@ -3,6 +3,7 @@ import { customElement, property, state } from "lit/decorators.js";
 import { unsafeHTML } from "lit/directives/unsafe-html.js";
 import { repeat } from "lit/directives/repeat.js";
 import { AgentMessage } from "../types";
+import { formatDateTime } from "../utils";
 import { createRef, ref } from "lit/directives/ref.js";
 import { marked, MarkedOptions, Renderer } from "marked";
 import DOMPurify from "dompurify";
@@ -93,8 +94,7 @@ export class MobileChat extends SketchTailwindElement {
   }
 
   private formatTime(timestamp: string): string {
-    const date = new Date(timestamp);
-    return date.toLocaleTimeString([], { hour: "2-digit", minute: "2-digit" });
+    return formatDateTime(timestamp, { hour: "2-digit", minute: "2-digit" });
   }
 
   private getMessageRole(message: AgentMessage): string {
@@ -140,6 +140,8 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
     first_message_index: 0,
     diff_lines_added: 0,
     diff_lines_removed: 0,
+    diff_files_changed: 0,
+    diff_commits: 0,
   };
 
   // Mutation observer to detect when new messages are added
@@ -903,6 +905,8 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
         <sketch-view-mode-select
           .diffLinesAdded=${this.containerState?.diff_lines_added || 0}
           .diffLinesRemoved=${this.containerState?.diff_lines_removed || 0}
+          .diffFilesChanged=${this.containerState?.diff_files_changed || 0}
+          .diffCommits=${this.containerState?.diff_commits || 0}
         ></sketch-view-mode-select>
 
         <!-- Control buttons and status -->
@@ -1003,6 +1007,32 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
     `;
   }
 
+  // Shown while sketch.dev is unreachable, listing what won't work until it reconnects.
+  protected renderSkabandBanner() {
+    const skaband = this.containerState?.skaband;
+    if (skaband?.state !== "offline") {
+      return "";
+    }
+    const unavailable = skaband.unavailable || [];
+    return html`
+      <div
+        id="skaband-banner"
+        class="self-stretch px-5 py-1.5 text-xs bg-amber-50 dark:bg-amber-900/30 text-amber-800 dark:text-amber-200 border-b border-amber-200 dark:border-amber-800"
+        title="${skaband.last_error || ""}"
+      >
+        Reconnecting to sketch.dev…
+        ${unavailable.length > 0
+          ? html`Until then, unavailable: ${unavailable.join(", ")}.`
+          : ""}
+        ${skaband.queued
+          ? html`${skaband.queued} queued
+            ${skaband.queued === 1 ? "update" : "updates"} will be sent when
+            it's back.`
+          : ""}
+      </div>
+    `;
+  }
+
   protected renderMainViews() {
     return html`
       <!-- Chat View -->
@@ -1074,6 +1104,8 @@ export abstract class SketchAppShellBase extends SketchTailwindElement {
         <sketch-chat-input
           @send-chat="${this._sendChat}"
           .isDisconnected=${this.connectionStatus === "disconnected"}
+          .planMode=${!!this.containerState?.plan_mode}
+          .approvals=${this.containerState?.approvals ?? []}
         ></sketch-chat-input>
       </div>
     `;
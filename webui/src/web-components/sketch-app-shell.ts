@@ -24,6 +24,7 @@ export class SketchAppShell extends SketchAppShellBase {
         class="block font-sans text-gray-800 dark:text-neutral-200 leading-relaxed h-screen w-full relative overflow-x-hidden flex flex-col bg-white dark:bg-neutral-900"
       >
         ${this.renderTopBanner()}
+        ${this.renderSkabandBanner()}
 
         <!-- Main content area: scrollable, flex-1 -->
         <div
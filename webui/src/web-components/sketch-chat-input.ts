@@ -1,6 +1,8 @@
 import { html } from "lit";
 import { customElement, state, query, property } from "lit/decorators.js";
 import { SketchTailwindElement } from "./sketch-tailwind-element.js";
+import { ApprovalRequest, CostEstimate, ContextBundle } from "../types";
+import { uploadFile } from "../services/upload";
 
 @customElement("sketch-chat-input")
 export class SketchChatInput extends SketchTailwindElement {
@@ -19,6 +21,42 @@ export class SketchChatInput extends SketchTailwindElement {
   @property()
   isDisconnected: boolean = false;
 
+  // Whether the agent is in plan mode, waiting for its plan to be approved
+  @property({ type: Boolean })
+  planMode: boolean = false;
+
+  @state()
+  planError: string = "";
+
+  // Tool calls waiting for the user's approval under the tool permissions
+  @property({ attribute: false })
+  approvals: ApprovalRequest[] = [];
+
+  @state()
+  approvalError: string = "";
+
+  // Estimated cost of sending the current content, refreshed as it changes
+  @state()
+  estimate: CostEstimate | null = null;
+
+  private estimateTimer: number | undefined;
+
+  // Files attached to the next message, and the picker that attaches them.
+  @state()
+  attached: ContextBundle | null = null;
+
+  @state()
+  showAttachPicker: boolean = false;
+
+  @state()
+  attachCandidates: string[] = [];
+
+  @state()
+  attachPath: string = "";
+
+  @state()
+  attachError: string = "";
+
   constructor() {
     super();
     this._handleDiffComment = this._handleDiffComment.bind(this);
@@ -52,26 +90,12 @@ export class SketchChatInput extends SketchTailwindElement {
     requestAnimationFrame(() => this.adjustChatSpacing());
 
     try {
-      // Create a FormData object to send the file
-      const formData = new FormData();
-      formData.append("file", file);
-
-      // Upload the file to the server using a relative path
-      const response = await fetch("./upload", {
-        method: "POST",
-        body: formData,
-      });
-
-      if (!response.ok) {
-        throw new Error(`Upload failed: ${response.statusText}`);
-      }
-
-      const data = await response.json();
+      const path = await uploadFile(file);
 
       // Replace the loading placeholder with the actual file path
-      this.content = this.content.replace(loadingText, `[${data.path}]`);
+      this.content = this.content.replace(loadingText, `[${path}]`);
 
-      return data.path;
+      return path;
     } catch (error) {
       console.error("Failed to upload file:", error);
 
@@ -236,9 +260,292 @@ export class SketchChatInput extends SketchTailwindElement {
 
       // TODO(philip?): Ideally we only clear the content if the send is successful.
       this.content = ""; // Clear content after sending
+      this.estimate = null;
+      // The attached files go with this message.
+      this.attached = null;
+      this.showAttachPicker = false;
     }
   }
 
+  // Answers the agent's plan; what is typed goes along as the comment,
+  // which rejecting it requires.
+  private async _reviewPlan(approve: boolean) {
+    this.planError = "";
+    try {
+      const response = await fetch("plan/review", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ approve, comment: this.content.trim() }),
+      });
+      if (!response.ok) {
+        this.planError = await response.text();
+        return;
+      }
+      this.content = "";
+      this.estimate = null;
+    } catch (err) {
+      this.planError = `Could not review the plan: ${err}`;
+    }
+  }
+
+  private async _leavePlanMode() {
+    this.planError = "";
+    try {
+      const response = await fetch("plan", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ enabled: false }),
+      });
+      if (!response.ok) {
+        this.planError = await response.text();
+      }
+    } catch (err) {
+      this.planError = `Could not leave plan mode: ${err}`;
+    }
+  }
+
+  private renderPlanMode() {
+    if (!this.planMode) {
+      return "";
+    }
+    return html`
+      <div
+        class="plan-mode flex items-center flex-wrap max-w-6xl mx-auto gap-2.5 mb-2 text-xs text-amber-900 dark:text-amber-100"
+      >
+        <span class="font-semibold">Plan mode:</span>
+        <span
+          >sketch won't change files or commit until you approve its
+          plan.</span
+        >
+        <button
+          id="approvePlanButton"
+          @click="${() => this._reviewPlan(true)}"
+          ?disabled=${this.isDisconnected}
+          class="px-2 py-1 rounded bg-green-600 hover:bg-green-700 text-white cursor-pointer disabled:cursor-not-allowed"
+        >
+          Approve plan
+        </button>
+        <button
+          id="rejectPlanButton"
+          @click="${() => this._reviewPlan(false)}"
+          ?disabled=${this.isDisconnected || !this.content.trim()}
+          title="Send what you typed as the changes to make to the plan"
+          class="px-2 py-1 rounded border border-amber-600 hover:bg-amber-100 dark:hover:bg-neutral-700 cursor-pointer disabled:cursor-not-allowed disabled:opacity-50"
+        >
+          Request changes
+        </button>
+        <button
+          @click="${this._leavePlanMode}"
+          ?disabled=${this.isDisconnected}
+          class="px-2 py-1 rounded text-gray-600 dark:text-neutral-400 hover:underline cursor-pointer"
+        >
+          Leave plan mode
+        </button>
+        ${this.planError
+          ? html`<span class="text-red-600">${this.planError}</span>`
+          : ""}
+      </div>
+    `;
+  }
+
+  // Decides on a waiting tool call; what is typed goes along as the
+  // reason for a denial.
+  private async _decideApproval(
+    id: string,
+    approve: boolean,
+    always: boolean = false,
+  ) {
+    this.approvalError = "";
+    const comment = approve ? "" : this.content.trim();
+    try {
+      const response = await fetch("approve", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id, approve, comment, always }),
+      });
+      if (!response.ok) {
+        this.approvalError = await response.text();
+        return;
+      }
+      if (comment) {
+        this.content = "";
+        this.estimate = null;
+      }
+    } catch (err) {
+      this.approvalError = `Could not answer the tool call: ${err}`;
+    }
+  }
+
+  private renderApprovals() {
+    if (!this.approvals.length) {
+      return "";
+    }
+    return html`
+      ${this.approvals.map(
+        (req) => html`
+          <div
+            class="approval flex items-center flex-wrap max-w-6xl mx-auto gap-2.5 mb-2 text-xs text-amber-900 dark:text-amber-100"
+          >
+            <span class="font-semibold">Run ${req.tool}?</span>
+            <code
+              class="truncate max-w-xl bg-white dark:bg-neutral-900 px-1 rounded"
+              title="${req.input}"
+              >${req.input}</code
+            >
+            <button
+              @click="${() => this._decideApproval(req.id, true)}"
+              ?disabled=${this.isDisconnected}
+              class="px-2 py-1 rounded bg-green-600 hover:bg-green-700 text-white cursor-pointer disabled:cursor-not-allowed"
+            >
+              Allow
+            </button>
+            <button
+              @click="${() => this._decideApproval(req.id, true, true)}"
+              ?disabled=${this.isDisconnected}
+              title="Allow this and every later ${req.tool} call in this session"
+              class="px-2 py-1 rounded border border-green-600 hover:bg-green-100 dark:hover:bg-neutral-700 cursor-pointer disabled:cursor-not-allowed"
+            >
+              Always allow
+            </button>
+            <button
+              @click="${() => this._decideApproval(req.id, false)}"
+              ?disabled=${this.isDisconnected}
+              title="Refuse the call, sending what you typed as the reason"
+              class="px-2 py-1 rounded border border-amber-600 hover:bg-amber-100 dark:hover:bg-neutral-700 cursor-pointer disabled:cursor-not-allowed"
+            >
+              Deny
+            </button>
+          </div>
+        `,
+      )}
+      ${this.approvalError
+        ? html`<div class="max-w-6xl mx-auto mb-2 text-xs text-red-600">
+            ${this.approvalError}
+          </div>`
+        : ""}
+    `;
+  }
+
+  private async _toggleAttachPicker() {
+    this.showAttachPicker = !this.showAttachPicker;
+    if (!this.showAttachPicker) {
+      return;
+    }
+    try {
+      const [files, attached] = await Promise.all([
+        this.attachCandidates.length
+          ? Promise.resolve(null)
+          : fetch("context/files").then((r) => (r.ok ? r.json() : null)),
+        fetch("context").then((r) => (r.ok ? r.json() : null)),
+      ]);
+      if (files) {
+        this.attachCandidates = files;
+      }
+      this.attached = attached;
+    } catch (err) {
+      console.error("Could not load the files to attach: ", err);
+    }
+  }
+
+  private async _attach() {
+    const path = this.attachPath.trim();
+    if (!path) {
+      return;
+    }
+    this.attachError = "";
+    const response = await fetch("context", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ paths: [path] }),
+    });
+    if (!response.ok) {
+      this.attachError = await response.text();
+      return;
+    }
+    this.attached = await response.json();
+    this.attachPath = "";
+  }
+
+  private async _clearAttached() {
+    const response = await fetch("context", { method: "DELETE" });
+    if (response.ok) {
+      this.attached = await response.json();
+    }
+  }
+
+  private renderAttachPicker() {
+    if (!this.showAttachPicker) {
+      return "";
+    }
+    const files = this.attached?.files ?? [];
+    return html`<div
+      id="attachPicker"
+      class="absolute bottom-full right-4 mb-2 w-[28rem] max-w-[90vw] bg-white dark:bg-neutral-800 border border-gray-300 dark:border-neutral-600 rounded shadow-lg p-3 z-20 text-xs text-gray-900 dark:text-neutral-100"
+    >
+      <div class="font-semibold mb-2">Attach files to the next message</div>
+      <div class="flex gap-2">
+        <input
+          id="attachPath"
+          list="attachCandidates"
+          placeholder="path/to/file or directory"
+          .value=${this.attachPath}
+          @input=${(e: Event) =>
+            (this.attachPath = (e.target as HTMLInputElement).value)}
+          @keydown=${(e: KeyboardEvent) => {
+            if (e.key === "Enter") {
+              e.preventDefault();
+              this._attach();
+            }
+          }}
+          class="flex-1 p-1.5 border border-gray-300 dark:border-neutral-600 rounded font-mono bg-gray-50 dark:bg-neutral-700"
+        />
+        <datalist id="attachCandidates">
+          ${this.attachCandidates.map((f) => html`<option value=${f}></option>`)}
+        </datalist>
+        <button
+          class="bg-blue-500 hover:bg-blue-600 text-white rounded px-3"
+          @click=${this._attach}
+        >
+          Attach
+        </button>
+      </div>
+      ${this.attachError
+        ? html`<div class="text-red-600 mt-1">${this.attachError}</div>`
+        : ""}
+      ${files.length
+        ? html`<ul class="mt-2 max-h-48 overflow-y-auto font-mono">
+              ${files.map(
+                (f) =>
+                  html`<li class="${f.skipped ? "line-through text-gray-500" : ""}">
+                    ${f.path}
+                    <span class="text-gray-500 dark:text-neutral-400"
+                      >${f.skipped
+                        ? `(${f.skipped})`
+                        : f.truncated
+                          ? `(${Math.round(f.included / 1024)} of ${Math.round(f.size / 1024)} KB)`
+                          : `(${Math.max(1, Math.round(f.size / 1024))} KB)`}</span
+                    >
+                  </li>`,
+              )}
+            </ul>
+            <div class="flex justify-between mt-2">
+              <span class="text-gray-600 dark:text-neutral-400"
+                >${Math.round((this.attached?.bytes ?? 0) / 1024)} of
+                ${Math.round((this.attached?.budget ?? 0) / 1024)} KB</span
+              >
+              <button class="text-blue-600" @click=${this._clearAttached}>
+                Clear
+              </button>
+            </div>`
+        : ""}
+      ${this.attached?.warnings?.length
+        ? html`<div class="text-yellow-700 dark:text-yellow-400 mt-1">
+            Some files won't fit in full; they are cut short or left out.
+          </div>`
+        : ""}
+    </div>`;
+  }
+
   adjustChatSpacing() {
     if (!this.chatInput) return;
 
@@ -271,6 +578,38 @@ export class SketchChatInput extends SketchTailwindElement {
     this.content = event.target.value;
     // Use requestAnimationFrame to ensure DOM updates have completed
     requestAnimationFrame(() => this.adjustChatSpacing());
+    this.scheduleEstimate();
+  }
+
+  // Re-estimate the cost of sending once typing pauses.
+  private scheduleEstimate() {
+    window.clearTimeout(this.estimateTimer);
+    if (!this.content.trim()) {
+      this.estimate = null;
+      return;
+    }
+    this.estimateTimer = window.setTimeout(() => this.fetchEstimate(), 500);
+  }
+
+  private async fetchEstimate() {
+    const message = this.content;
+    try {
+      const response = await fetch("./estimate", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ message }),
+      });
+      if (!response.ok || message !== this.content) {
+        return;
+      }
+      this.estimate = (await response.json()) as CostEstimate;
+    } catch (error) {
+      console.error("Error estimating cost:", error);
+    }
+  }
+
+  private formatEstimate(est: CostEstimate): string {
+    return est.cost_usd < 0.01 ? "<$0.01" : `~$${est.cost_usd.toFixed(2)}`;
   }
 
   @query("#chatInput")
@@ -317,6 +656,8 @@ export class SketchChatInput extends SketchTailwindElement {
       <div
         class="chat-container w-full bg-gray-100 dark:bg-neutral-800 p-4 min-h-[40px] relative"
       >
+        ${this.renderApprovals()}
+        ${this.renderPlanMode()}
         <div class="chat-input-wrapper flex max-w-6xl mx-auto gap-2.5">
           <textarea
             id="chatInput"
@@ -328,6 +669,28 @@ export class SketchChatInput extends SketchTailwindElement {
             .value=${this.content || ""}
             class="flex-1 p-3 border border-gray-300 dark:border-neutral-600 rounded resize-y font-mono text-xs min-h-[40px] max-h-[300px] bg-gray-50 dark:bg-neutral-700 text-gray-900 dark:text-neutral-100 overflow-y-auto box-border leading-relaxed disabled:bg-gray-200 dark:disabled:bg-neutral-800 disabled:text-gray-500 dark:disabled:text-neutral-500 disabled:cursor-not-allowed"
           ></textarea>
+          ${this.estimate?.priced && this.content.trim()
+            ? html`<span
+                class="cost-estimate self-center text-xs text-gray-500 dark:text-neutral-400 whitespace-nowrap"
+                title="Estimated cost of sending this message: ${this.estimate
+                  .context_tokens} tokens of context, ${this.estimate
+                  .new_tokens} new, ~${this.estimate.output_tokens} out"
+                >${this.formatEstimate(this.estimate)}</span
+              >`
+            : ""}
+          <button
+            @click="${this._toggleAttachPicker}"
+            id="attachButton"
+            title="Attach files to the next message"
+            ?disabled=${isDisabled}
+            class="self-center h-10 px-2 rounded border border-gray-300 dark:border-neutral-600 text-gray-700 dark:text-neutral-200 hover:bg-gray-200 dark:hover:bg-neutral-700 cursor-pointer disabled:cursor-not-allowed"
+          >
+            📎${this.attached?.files?.length
+              ? html`<span class="ml-1 text-xs font-semibold"
+                  >${this.attached.files.length}</span
+                >`
+              : ""}
+          </button>
           <button
             @click="${this._sendChatClicked}"
             id="sendChatButton"
@@ -341,6 +704,7 @@ export class SketchChatInput extends SketchTailwindElement {
                 : "Send"}
           </button>
         </div>
+        ${this.renderAttachPicker()}
         ${this.isDraggingOver
           ? html`
               <div
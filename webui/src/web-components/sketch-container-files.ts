@@ -0,0 +1,258 @@
+import { html } from "lit";
+import { customElement, property, state } from "lit/decorators.js";
+import { SketchTailwindElement } from "./sketch-tailwind-element";
+import type { ContainerFile, ContainerFileList } from "../types";
+
+// A browser for /app and /tmp in the container, for downloading artifacts
+// and uploading files without docker cp. Downloads are plain links, so the
+// browser shows their progress; uploads report theirs here.
+@customElement("sketch-container-files")
+export class SketchContainerFiles extends SketchTailwindElement {
+  @property({ type: Boolean })
+  open = false;
+
+  @state()
+  private list: ContainerFileList | null = null;
+
+  @state()
+  private error = "";
+
+  @state()
+  private upload: { name: string; loaded: number; total: number } | null =
+    null;
+
+  updated(changed: Map<string, unknown>) {
+    if (changed.has("open") && this.open && !this.list) {
+      this.load("");
+    }
+  }
+
+  private async load(path: string) {
+    this.error = "";
+    try {
+      const response = await fetch(
+        `container/files?path=${encodeURIComponent(path)}`,
+      );
+      if (!response.ok) {
+        this.error = (await response.text()).trim();
+        return;
+      }
+      this.list = await response.json();
+    } catch (err) {
+      this.error = `Could not list files: ${err}`;
+    }
+  }
+
+  private close() {
+    this.dispatchEvent(
+      new CustomEvent("close", { bubbles: true, composed: true }),
+    );
+  }
+
+  private onFileChosen(event: Event) {
+    const input = event.target as HTMLInputElement;
+    const file = input.files?.[0];
+    input.value = "";
+    const dir = this.list?.path;
+    if (!file || !dir) {
+      return;
+    }
+    if (this.list && file.size > this.list.max_size) {
+      this.error = `${file.name} is larger than the ${formatSize(this.list.max_size)} transfer limit`;
+      return;
+    }
+    const exists = this.list?.files?.some((f) => f.name === file.name);
+    if (exists && !confirm(`Replace ${file.name} in ${dir}?`)) {
+      return;
+    }
+    this.send(file, `${dir.replace(/\/$/, "")}/${file.name}`, !!exists);
+  }
+
+  // XMLHttpRequest rather than fetch, which can't report upload progress.
+  private send(file: File, path: string, overwrite: boolean) {
+    this.error = "";
+    this.upload = { name: file.name, loaded: 0, total: file.size };
+    const xhr = new XMLHttpRequest();
+    xhr.open(
+      "POST",
+      `container/files/upload?path=${encodeURIComponent(path)}${overwrite ? "&overwrite=1" : ""}`,
+    );
+    xhr.upload.onprogress = (e) => {
+      if (e.lengthComputable && this.upload) {
+        this.upload = { ...this.upload, loaded: e.loaded };
+      }
+    };
+    xhr.onload = () => {
+      this.upload = null;
+      if (xhr.status !== 200) {
+        this.error = xhr.responseText.trim() || `Upload failed (${xhr.status})`;
+        return;
+      }
+      this.load(this.list?.path ?? "");
+    };
+    xhr.onerror = () => {
+      this.upload = null;
+      this.error = "Upload failed: connection lost";
+    };
+    xhr.send(file);
+  }
+
+  // The path from the root it's in down to the listed directory, as links.
+  private renderBreadcrumbs() {
+    const path = this.list?.path ?? "";
+    const root = this.list?.roots?.find(
+      (r) => path === r || path.startsWith(r + "/"),
+    );
+    const crumbs: { name: string; path: string }[] = [];
+    if (root) {
+      crumbs.push({ name: root, path: root });
+      let current = root;
+      for (const part of path.slice(root.length).split("/").filter(Boolean)) {
+        current = `${current}/${part}`;
+        crumbs.push({ name: part, path: current });
+      }
+    }
+    return html`<div class="flex flex-wrap items-center gap-1 text-xs mb-2">
+      <button
+        class="text-blue-600 hover:underline cursor-pointer"
+        @click=${() => this.load("")}
+      >
+        Container
+      </button>
+      ${crumbs.map(
+        (c) =>
+          html`<span class="text-gray-400">/</span
+            ><button
+              class="text-blue-600 hover:underline cursor-pointer font-mono"
+              @click=${() => this.load(c.path)}
+            >
+              ${c.name}
+            </button>`,
+      )}
+    </div>`;
+  }
+
+  private renderFile(f: ContainerFile) {
+    const tooLarge = !f.dir && f.size > (this.list?.max_size ?? 0);
+    return html`<tr class="border-t border-gray-100 dark:border-neutral-700">
+      <td class="py-1 pr-2 font-mono break-all">
+        ${f.dir
+          ? html`<button
+              class="text-blue-600 hover:underline cursor-pointer text-left"
+              @click=${() => this.load(f.path)}
+            >
+              📁 ${f.name}
+            </button>`
+          : tooLarge
+            ? html`<span
+                class="text-gray-500"
+                title="Larger than the transfer limit"
+                >${f.name}</span
+              >`
+            : html`<a
+                class="text-blue-600 hover:underline"
+                href="container/files/download?path=${encodeURIComponent(
+                  f.path,
+                )}"
+                download=${f.name}
+                >${f.name}</a
+              >`}
+      </td>
+      <td
+        class="py-1 text-right whitespace-nowrap text-gray-600 dark:text-neutral-400"
+      >
+        ${f.dir ? "" : formatSize(f.size)}
+      </td>
+    </tr>`;
+  }
+
+  render() {
+    if (!this.open) {
+      return html``;
+    }
+    const files = this.list?.files ?? [];
+    const upload = this.upload;
+    return html`<div
+      class="fixed inset-0 bg-black/30 dark:bg-black/50 z-[10000] flex items-center justify-center"
+      @click=${() => this.close()}
+    >
+      <div
+        class="bg-white dark:bg-neutral-800 border border-gray-300 dark:border-neutral-600 rounded-md shadow-lg p-4 w-[36rem] max-w-[90vw] max-h-[80vh] overflow-y-auto text-gray-900 dark:text-neutral-100"
+        @click=${(e: Event) => e.stopPropagation()}
+      >
+        <div class="flex justify-between items-center mb-3">
+          <h3 class="m-0 text-sm font-medium">Container files</h3>
+          <button
+            class="bg-transparent border-none cursor-pointer text-lg text-gray-500 dark:text-neutral-400 px-1.5 py-0.5 hover:text-gray-800 dark:hover:text-neutral-200"
+            @click=${() => this.close()}
+          >
+            ×
+          </button>
+        </div>
+        ${this.renderBreadcrumbs()}
+        ${this.error
+          ? html`<div
+              class="text-xs text-red-700 dark:text-red-400 bg-red-50 dark:bg-red-900/30 rounded p-2 mb-2"
+            >
+              ${this.error}
+            </div>`
+          : ""}
+        <table class="w-full text-xs">
+          ${files.map((f) => this.renderFile(f))}
+        </table>
+        ${files.length === 0 && this.list
+          ? html`<div class="text-xs text-gray-500 py-2">
+              ${this.list.path ? "Empty directory" : "No directories to browse"}
+            </div>`
+          : ""}
+        ${this.list?.truncated
+          ? html`<div class="text-xs text-gray-500 py-1">
+              Only the first ${files.length} entries are shown.
+            </div>`
+          : ""}
+        ${this.list?.path
+          ? html`<div
+              class="mt-3 pt-2 border-t border-gray-200 dark:border-neutral-600 text-xs"
+            >
+              ${upload
+                ? html`<div>
+                    Uploading ${upload.name}…
+                    ${formatSize(upload.loaded)} of ${formatSize(upload.total)}
+                    <progress
+                      class="w-full"
+                      max=${upload.total}
+                      value=${upload.loaded}
+                    ></progress>
+                  </div>`
+                : html`<label class="cursor-pointer text-blue-600">
+                    Upload a file here (up to
+                    ${formatSize(this.list.max_size)})
+                    <input
+                      type="file"
+                      class="hidden"
+                      @change=${this.onFileChosen}
+                    />
+                  </label>`}
+            </div>`
+          : ""}
+      </div>
+    </div>`;
+  }
+}
+
+function formatSize(bytes: number): string {
+  const units = ["B", "KB", "MB", "GB"];
+  let size = bytes;
+  let unit = 0;
+  while (size >= 1024 && unit < units.length - 1) {
+    size /= 1024;
+    unit++;
+  }
+  return `${unit === 0 ? size : size.toFixed(1)} ${units[unit]}`;
+}
+
+declare global {
+  interface HTMLElementTagNameMap {
+    "sketch-container-files": SketchContainerFiles;
+  }
+}
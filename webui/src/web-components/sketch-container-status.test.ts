@@ -29,6 +29,8 @@ const mockCompleteState: State = {
   first_message_index: 0,
   diff_lines_added: 15,
   diff_lines_removed: 3,
+  diff_files_changed: 3,
+  diff_commits: 2,
 };
 
 test("render props", async ({ mount }) => {
@@ -1,9 +1,23 @@
-import { State, AgentMessage, Usage, Port } from "../types";
+import {
+  State,
+  AgentMessage,
+  Usage,
+  Port,
+  DevcontainerInfo,
+  AuthUser,
+  ImageStatus,
+  ConversationNode,
+} from "../types";
 import { html } from "lit";
 import { customElement, property, state } from "lit/decorators.js";
-import { formatNumber } from "../utils";
+import {
+  formatNumber,
+  getDisplayTimeZone,
+  setDisplayTimeZone,
+} from "../utils";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 import "./sketch-push-button";
+import "./sketch-container-files";
 
 @customElement("sketch-container-status")
 export class SketchContainerStatus extends SketchTailwindElement {
@@ -33,6 +47,30 @@ export class SketchContainerStatus extends SketchTailwindElement {
   @state()
   highlightedPorts: Set<number> = new Set();
 
+  @state()
+  devcontainer: DevcontainerInfo | null = null;
+
+  @state()
+  displayTimeZone: string = getDisplayTimeZone();
+
+  @state()
+  showFiles: boolean = false;
+
+  // Who is signed in, when the session requires signing in.
+  @state()
+  authUser: AuthUser | null = null;
+
+  // Whether the registry has a newer base image than the container's.
+  @state()
+  imageStatus: ImageStatus | null = null;
+
+  @state()
+  rebuildMessage: string = "";
+
+  // The session's conversations, parents first; fetched when the details open.
+  @state()
+  conversations: ConversationNode[] = [];
+
   // CSS animations that can't be easily replaced with Tailwind
   connectedCallback() {
     super.connectedCallback();
@@ -80,9 +118,185 @@ export class SketchContainerStatus extends SketchTailwindElement {
   private _toggleInfoDetails(event: Event) {
     event.stopPropagation();
     this.showDetails = !this.showDetails;
+    if (this.showDetails && !this.devcontainer && this.state?.in_container) {
+      this.fetchDevcontainer();
+    }
+    if (this.showDetails && !this.authUser) {
+      this.fetchAuthUser();
+    }
+    if (this.showDetails && !this.imageStatus && this.state?.image) {
+      this.fetchImageStatus();
+    }
+    if (this.showDetails) {
+      this.fetchConversations();
+    }
     this.requestUpdate();
   }
 
+  private async fetchDevcontainer() {
+    try {
+      const response = await fetch("devcontainer");
+      if (response.ok) {
+        this.devcontainer = await response.json();
+      }
+    } catch (err) {
+      console.error("Could not fetch devcontainer info: ", err);
+    }
+  }
+
+  private async fetchAuthUser() {
+    try {
+      // 404 when sign-in is off.
+      const response = await fetch("auth/me");
+      if (response.ok) {
+        this.authUser = await response.json();
+      }
+    } catch (err) {
+      console.error("Could not fetch the signed-in user: ", err);
+    }
+  }
+
+  private async fetchImageStatus() {
+    try {
+      const response = await fetch("image");
+      if (response.ok) {
+        this.imageStatus = await response.json();
+      }
+    } catch (err) {
+      console.error("Could not check the base image: ", err);
+    }
+  }
+
+  private async fetchConversations() {
+    try {
+      const response = await fetch("conversations");
+      if (response.ok) {
+        this.conversations = await response.json();
+      }
+    } catch (err) {
+      console.error("Could not fetch the conversations: ", err);
+    }
+  }
+
+  // Moves the session to a container built from the latest base image.
+  // The web UI reconnects to it once it is up, at the same address.
+  private async _rebuild() {
+    if (
+      !confirm(
+        "Rebuild the container from the latest base image? The session stops briefly and continues with the same conversation and working tree.",
+      )
+    ) {
+      return;
+    }
+    this.rebuildMessage = "Pulling the latest base image…";
+    try {
+      const response = await fetch("image/rebuild", { method: "POST" });
+      this.rebuildMessage = response.ok
+        ? "Rebuilding; the session continues in the new container shortly."
+        : await response.text();
+    } catch (err) {
+      this.rebuildMessage = `Rebuild failed: ${err}`;
+    }
+  }
+
+  private renderImage() {
+    const image = this.state?.image;
+    if (!image) {
+      return "";
+    }
+    const digest = image.base_digest
+      ? image.base_digest.replace("sha256:", "").slice(0, 12)
+      : "local";
+    return html`<div
+      class="flex items-center whitespace-nowrap mr-2.5 text-xs col-span-full"
+      title="${image.image} (run sketch -rebuild to rebuild it on the same base)"
+    >
+      <span
+        class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
+        >Base image:</span
+      >
+      <span
+        id="baseImage"
+        class="text-xs font-semibold break-all text-gray-900 dark:text-neutral-100"
+        >${image.base_image} (${digest})</span
+      >
+      ${this.rebuildMessage
+        ? html`<span class="ml-2 text-gray-600 dark:text-neutral-400"
+            >${this.rebuildMessage}</span
+          >`
+        : this.imageStatus?.update_available
+          ? html`<button
+              id="rebuildImage"
+              class="text-blue-600 cursor-pointer ml-2"
+              @click=${this._rebuild}
+            >
+              Rebuild with latest base
+            </button>`
+          : this.imageStatus && !this.imageStatus.error
+            ? html`<span class="ml-2 text-gray-600 dark:text-neutral-400"
+                >up to date</span
+              >`
+            : ""}
+    </div>`;
+  }
+
+  // A collapsible tree of the conversations, so that the work subconversations
+  // did out of sight, and its cost, can be looked into.
+  private renderConversations() {
+    if (this.conversations.length < 2) {
+      return "";
+    }
+    const children = new Map<string, ConversationNode[]>();
+    for (const c of this.conversations) {
+      const key = c.parent_id ?? "";
+      children.set(key, [...(children.get(key) ?? []), c]);
+    }
+    const node = (c: ConversationNode): unknown => {
+      const kids = children.get(c.id) ?? [];
+      const label = html`<span class="font-semibold">${c.purpose || c.id}</span>
+        <span class="text-gray-600 dark:text-neutral-400"
+          >${c.hidden ? "hidden · " : ""}${c.responses} responses,
+          ${c.tool_calls} tool calls, ${formatNumber(c.input_tokens)} in /
+          ${formatNumber(c.output_tokens)} out,
+          $${c.cost_usd.toFixed(2)}</span
+        >`;
+      if (kids.length === 0) {
+        return html`<div class="ml-4" title="${c.id}">${label}</div>`;
+      }
+      return html`<details class="ml-4" ?open=${c.depth === 0}>
+        <summary class="cursor-pointer" title="${c.id}">${label}</summary>
+        ${kids.map(node)}
+      </details>`;
+    };
+    return html`<div class="text-xs col-span-full mt-1.5">
+      <span
+        class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
+        >Conversations:</span
+      >
+      ${(children.get("") ?? []).map(node)}
+    </div>`;
+  }
+
+  private async _signOut() {
+    await fetch("auth/logout", { method: "POST" });
+    window.location.reload();
+  }
+
+  // The browser's zone and UTC first, then every zone the browser knows.
+  private timeZoneOptions(): string[] {
+    const browser = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    // Intl.supportedValuesOf is newer than our ES2020 lib typings.
+    const all: string[] = (Intl as any).supportedValuesOf?.("timeZone") ?? [];
+    return [...new Set([browser, "UTC", this.displayTimeZone, ...all])];
+  }
+
+  private _onTimeZoneChange(event: Event) {
+    const timeZone = (event.target as HTMLSelectElement).value;
+    const browser = Intl.DateTimeFormat().resolvedOptions().timeZone;
+    setDisplayTimeZone(timeZone === browser ? "" : timeZone);
+    this.displayTimeZone = getDisplayTimeZone();
+  }
+
   /**
    * Update the last commit information based on messages
    */
@@ -447,6 +661,31 @@ export class SketchContainerStatus extends SketchTailwindElement {
             </svg>
             <span>Open in VSCode</span>
           </a>
+          ${this.devcontainer
+            ? html`
+                <a
+                  href="${this.devcontainer.vscode_uri}"
+                  class="text-white no-underline bg-blue-500 px-2 py-1 rounded text-xs transition-colors hover:bg-blue-800"
+                  title="Attach VS Code to the container with the Dev Containers extension"
+                >
+                  Attach Dev Container
+                </a>
+                <a
+                  href="${this.devcontainer.gateway_url}"
+                  class="text-white no-underline bg-gray-700 px-2 py-1 rounded text-xs transition-colors hover:bg-gray-900"
+                  title="Open in a JetBrains IDE through Gateway"
+                >
+                  Open in JetBrains Gateway
+                </a>
+                <a
+                  href="devcontainer?download=1"
+                  class="text-blue-600 dark:text-blue-400 text-xs"
+                  download
+                >
+                  devcontainer.json
+                </a>
+              `
+            : ""}
         </div>
       </div>
     `;
@@ -780,6 +1019,45 @@ export class SketchContainerStatus extends SketchTailwindElement {
                   </div>
                 `
               : ""}
+            ${this.state?.sampling
+              ? html`
+                  <div
+                    class="flex items-center whitespace-nowrap mr-2.5 text-xs"
+                  >
+                    <span
+                      class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
+                      >Sampling:</span
+                    >
+                    <span
+                      id="sampling"
+                      class="text-xs font-semibold break-all text-gray-900 dark:text-neutral-100"
+                      >${this.state?.sampling}</span
+                    >
+                  </div>
+                `
+              : ""}
+            ${this.state?.idle_suspend
+              ? html`
+                  <div
+                    class="flex items-center whitespace-nowrap mr-2.5 text-xs"
+                    title="${this.state?.resumed_at
+                      ? `Last resumed ${new Date(this.state.resumed_at).toLocaleString()}`
+                      : "The container is paused when idle and resumed when next used"}"
+                  >
+                    <span
+                      class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
+                      >Suspends after:</span
+                    >
+                    <span
+                      id="idleSuspend"
+                      class="text-xs font-semibold break-all text-gray-900 dark:text-neutral-100"
+                      >${this.state?.idle_suspend} idle${this.state?.suspensions
+                        ? ` (suspended ${this.state.suspensions}×)`
+                        : ""}</span
+                    >
+                  </div>
+                `
+              : ""}
             <div class="flex items-center whitespace-nowrap mr-2.5 text-xs">
               <span
                 class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
@@ -810,14 +1088,78 @@ export class SketchContainerStatus extends SketchTailwindElement {
                   </div>
                 `
               : ""}
+            ${this.renderImage()} ${this.renderConversations()}
             <div
               class="flex items-center whitespace-nowrap mr-2.5 text-xs col-span-full mt-1.5 border-t border-gray-300 dark:border-neutral-600 pt-1.5"
             >
               <a href="debug/logs" class="text-blue-600">Logs</a> (<a
-                href="download"
+                href="download?tz=${encodeURIComponent(this.displayTimeZone)}"
                 class="text-blue-600"
                 >Download</a
-              >)
+              >,
+              <a
+                href="download?format=markdown&tz=${encodeURIComponent(
+                  this.displayTimeZone,
+                )}"
+                class="text-blue-600"
+                >Markdown</a
+              >)${this.state?.in_container
+                ? html`,
+                    <button
+                      class="text-blue-600 cursor-pointer ml-1"
+                      @click=${() => (this.showFiles = true)}
+                    >
+                      Files
+                    </button>`
+                : ""}
+            </div>
+            ${this.authUser
+              ? html`<div
+                  class="flex items-center whitespace-nowrap mr-2.5 text-xs col-span-full"
+                >
+                  <span
+                    class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
+                    >Signed in:</span
+                  >
+                  <span
+                    class="text-xs font-semibold text-gray-900 dark:text-neutral-100"
+                    title=${this.authUser.name || ""}
+                    >${this.authUser.user}</span
+                  >
+                  <span class="ml-1 text-gray-600 dark:text-neutral-400"
+                    >(${this.authUser.role})</span
+                  >
+                  <button
+                    class="text-blue-600 cursor-pointer ml-2"
+                    @click=${this._signOut}
+                  >
+                    Sign out
+                  </button>
+                </div>`
+              : ""}
+            <div
+              class="flex items-center whitespace-nowrap mr-2.5 text-xs col-span-full"
+            >
+              <label
+                for="displayTimeZone"
+                class="text-xs text-gray-600 dark:text-neutral-400 mr-1 font-medium"
+                >Time zone:</label
+              >
+              <select
+                id="displayTimeZone"
+                class="text-xs bg-transparent border border-gray-300 dark:border-neutral-600 rounded px-1"
+                @change=${this._onTimeZoneChange}
+              >
+                ${this.timeZoneOptions().map(
+                  (tz) =>
+                    html`<option
+                      value=${tz}
+                      ?selected=${tz === this.displayTimeZone}
+                    >
+                      ${tz}
+                    </option>`,
+                )}
+              </select>
             </div>
           </div>
 
@@ -825,6 +1167,11 @@ export class SketchContainerStatus extends SketchTailwindElement {
           ${this.renderSSHSection()}
         </div>
 
+        <sketch-container-files
+          .open=${this.showFiles}
+          @close=${() => (this.showFiles = false)}
+        ></sketch-container-files>
+
         <!-- Ports popup -->
         <div
           class="${this.showPortsPopup
@@ -6,6 +6,8 @@ import "./sketch-diff-range-picker";
 import "./sketch-diff-empty-view";
 import { GitDiffFile, GitDataService } from "./git-data-service";
 import { DiffRange } from "./sketch-diff-range-picker";
+import { FileActivityEntry, FileActivityResponse } from "../types";
+import { formatDateTime } from "../utils";
 
 /**
  * A component that displays diffs using Monaco editor with range and file pickers
@@ -120,6 +122,12 @@ export class SketchDiff2View extends SketchTailwindElement {
   @state()
   private showUntrackedPopup: boolean = false;
 
+  @state()
+  private showFileHistory: boolean = false;
+
+  @state()
+  private fileHistory: FileActivityEntry[] | null = null;
+
   @property({ attribute: false, type: Object })
   gitService!: GitDataService;
 
@@ -278,6 +286,96 @@ export class SketchDiff2View extends SketchTailwindElement {
           )}
         </select>
         ${this.selectedFile ? this.renderSingleFileExpandButton() : ""}
+        ${this.selectedFile ? this.renderFileHistoryButton() : ""}
+      </div>
+    `;
+  }
+
+  renderFileHistoryButton() {
+    return html`
+      <button
+        class="bg-transparent border border-gray-300 rounded px-2 py-1.5 text-sm cursor-pointer whitespace-nowrap transition-colors duration-200 hover:bg-gray-200 ${this
+          .showFileHistory
+          ? "bg-gray-200 dark:bg-neutral-700"
+          : ""}"
+        @click="${this.toggleFileHistory}"
+        title="Show what the agent did to this file"
+      >
+        History
+      </button>
+    `;
+  }
+
+  async toggleFileHistory() {
+    this.showFileHistory = !this.showFileHistory;
+    if (this.showFileHistory) {
+      await this.loadFileHistory();
+    }
+  }
+
+  async loadFileHistory() {
+    const path = this.selectedFile;
+    this.fileHistory = null;
+    if (!path) return;
+    try {
+      const encoded = path.split("/").map(encodeURIComponent).join("/");
+      const response = await fetch(`files/${encoded}/activity`);
+      if (!response.ok) {
+        throw new Error(`${response.status} ${response.statusText}`);
+      }
+      const data: FileActivityResponse = await response.json();
+      // Ignore stale responses if the selection changed while loading.
+      if (path === this.selectedFile) {
+        this.fileHistory = data.entries ?? [];
+      }
+    } catch (error) {
+      console.error("Error loading file history:", error);
+      this.fileHistory = [];
+    }
+  }
+
+  renderFileHistoryPanel() {
+    if (!this.showFileHistory) return "";
+    let body;
+    if (this.fileHistory === null) {
+      body = html`<div class="p-3 text-gray-500">Loading...</div>`;
+    } else if (this.fileHistory.length === 0) {
+      body = html`<div class="p-3 text-gray-500">
+        The agent hasn't touched this file.
+      </div>`;
+    } else {
+      body = this.fileHistory.map(
+        (entry) => html`
+          <div
+            class="px-3 py-2 border-b border-gray-200 dark:border-gray-700"
+            title="${entry.summary}"
+          >
+            <div class="flex items-center gap-2 text-xs text-gray-500">
+              <span class="uppercase font-semibold">${entry.kind}</span>
+              <span>#${entry.idx}</span>
+              <span
+                >${formatDateTime(entry.timestamp, {
+                  hour: "numeric",
+                  minute: "2-digit",
+                  second: "2-digit",
+                })}</span
+              >
+            </div>
+            <div class="truncate font-mono text-xs">${entry.summary}</div>
+          </div>
+        `,
+      );
+    }
+    return html`
+      <div
+        class="w-72 flex-shrink-0 overflow-auto border-l border-gray-300 dark:border-gray-600 bg-white dark:bg-neutral-900 text-sm"
+      >
+        <div
+          class="px-3 py-2 font-semibold border-b border-gray-300 dark:border-gray-600"
+        >
+          History of ${this.selectedFile}
+        </div>
+        ${body}
       </div>
     `;
   }
@@ -769,6 +867,9 @@ export class SketchDiff2View extends SketchTailwindElement {
     const selectedValue = selectElement.value;
 
     this.selectedFile = selectedValue;
+    if (this.showFileHistory) {
+      this.loadFileHistory();
+    }
 
     // Force re-render
     this.requestUpdate();
@@ -841,20 +942,23 @@ export class SketchDiff2View extends SketchTailwindElement {
     }
 
     return html`
-      <div class="flex-1 flex flex-col min-h-0">
-        <!-- Monaco editor at full height without redundant header -->
-        <sketch-monaco-view
-          class="flex-1 w-full min-h-0"
-          .originalCode="${content.original}"
-          .modifiedCode="${content.modified}"
-          .originalFilename="${selectedFileData.path}"
-          .modifiedFilename="${selectedFileData.path}"
-          ?readOnly="${!content.editable}"
-          ?editable-right="${content.editable}"
-          @monaco-comment="${this.handleMonacoComment}"
-          @monaco-save="${this.handleMonacoSave}"
-          data-file-path="${selectedFileData.path}"
-        ></sketch-monaco-view>
+      <div class="flex-1 flex min-h-0">
+        <div class="flex-1 flex flex-col min-h-0">
+          <!-- Monaco editor at full height without redundant header -->
+          <sketch-monaco-view
+            class="flex-1 w-full min-h-0"
+            .originalCode="${content.original}"
+            .modifiedCode="${content.modified}"
+            .originalFilename="${selectedFileData.path}"
+            .modifiedFilename="${selectedFileData.path}"
+            ?readOnly="${!content.editable}"
+            ?editable-right="${content.editable}"
+            @monaco-comment="${this.handleMonacoComment}"
+            @monaco-save="${this.handleMonacoSave}"
+            data-file-path="${selectedFileData.path}"
+          ></sketch-monaco-view>
+        </div>
+        ${this.renderFileHistoryPanel()}
       </div>
     `;
   }
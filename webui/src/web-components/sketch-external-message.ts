@@ -1,6 +1,6 @@
 import { html, TemplateResult } from "lit";
 import { customElement, property, state } from "lit/decorators.js";
-import { ExternalMessage } from "../types";
+import { ExternalMessage, PatchResult } from "../types";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 import type { WorkflowRunEvent } from "@octokit/webhooks-types";
 
@@ -98,6 +98,19 @@ export class SketchExternalMessage extends SketchTailwindElement {
           </a>
         </div>
       `;
+    } else if (this.message?.message_type === "user_patch") {
+      const patch = this.message.body as PatchResult;
+      this.summaryContent = html`🩹 Applied your patch as
+        ${patch.commit.substring(0, 8)} (${patch.files?.length ?? 0}
+        ${patch.files?.length === 1 ? "file" : "files"})`;
+      this.detailsContent = html`
+        <ul class="list-none p-0 m-0">
+          ${(patch.files ?? []).map((f) => html`<li>${f}</li>`)}
+        </ul>
+        ${patch.checks
+          ? html`<div class="mt-2 whitespace-pre-wrap">${patch.checks}</div>`
+          : ""}
+      `;
     }
 
     return html`
@@ -0,0 +1,71 @@
+import { html } from "lit";
+import { customElement, property } from "lit/decorators.js";
+import { Artifact } from "../types";
+import { SketchTailwindElement } from "./sketch-tailwind-element";
+
+// Shows the images and diagrams attached to a message. Images (screenshots,
+// SVGs the model wrote) are shown inline; mermaid diagrams are already drawn
+// in the message's Markdown, so they just get a link to their source, as do
+// the JSON documents of build attestations.
+const documentLabels: Record<string, string> = {
+  mermaid: "📊 mermaid source",
+  sbom: "📦 SBOM (SPDX)",
+  provenance: "🔏 provenance (in-toto)",
+};
+
+@customElement("sketch-message-artifacts")
+export class SketchMessageArtifacts extends SketchTailwindElement {
+  @property({ type: Array })
+  artifacts: Artifact[] | null = null;
+
+  render() {
+    if (!this.artifacts || this.artifacts.length === 0) {
+      return html``;
+    }
+    const images = this.artifacts.filter(
+      (a) => a.kind === "image" || a.kind === "svg",
+    );
+    const documents = this.artifacts.filter(
+      (a) => a.kind !== "image" && a.kind !== "svg",
+    );
+    return html`<div class="mt-2 flex flex-col gap-2">
+      ${images.map(
+        (a) => html`
+          <a
+            href="./artifacts/${a.id}"
+            target="_blank"
+            title="Open ${a.kind} ${a.id}"
+            class="block max-w-full"
+          >
+            <img
+              src="./artifacts/${a.id}"
+              alt="${a.kind} ${a.id}"
+              loading="lazy"
+              class="max-w-full max-h-96 rounded border border-gray-200 dark:border-neutral-600 bg-white"
+            />
+          </a>
+        `,
+      )}
+      ${documents.length > 0
+        ? html`<div class="flex flex-wrap gap-2 text-xs">
+            ${documents.map(
+              (a) => html`
+                <a
+                  href="./artifacts/${a.id}"
+                  target="_blank"
+                  class="text-blue-600 dark:text-blue-400 hover:underline"
+                  >${documentLabels[a.kind] || a.kind} ${a.id}</a
+                >
+              `,
+            )}
+          </div>`
+        : ""}
+    </div>`;
+  }
+}
+
+declare global {
+  interface HTMLElementTagNameMap {
+    "sketch-message-artifacts": SketchMessageArtifacts;
+  }
+}
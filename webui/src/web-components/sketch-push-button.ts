@@ -1,7 +1,7 @@
 import { html } from "lit";
 import { customElement, state } from "lit/decorators.js";
 import { SketchTailwindElement } from "./sketch-tailwind-element.js";
-import type { Remote } from "../types.js";
+import type { GitPushResponse, Remote } from "../types.js";
 
 @customElement("sketch-push-button")
 export class SketchPushButton extends SketchTailwindElement {
@@ -27,12 +27,13 @@ export class SketchPushButton extends SketchTailwindElement {
   private _branch = "";
 
   @state()
-  private _pushResult: {
-    success: boolean;
-    output: string;
-    error?: string;
-    dry_run: boolean;
-  } | null = null;
+  private _createBranch = false;
+
+  @state()
+  private _setUpstream = false;
+
+  @state()
+  private _pushResult: GitPushResponse | null = null;
 
   private async _openModal() {
     this._modalOpen = true;
@@ -49,6 +50,9 @@ export class SketchPushButton extends SketchTailwindElement {
           subject: data.subject,
         };
         this._remotes = data.remotes;
+        if (!this._branch && data.branch) {
+          this._branch = data.branch;
+        }
 
         // Auto-select first remote if available
         if (this._remotes.length > 0) {
@@ -107,16 +111,20 @@ export class SketchPushButton extends SketchTailwindElement {
           branch: this._branch,
           commit: this._headCommit.hash,
           dry_run: dryRun,
+          create_branch: this._createBranch,
+          set_upstream: this._setUpstream,
         }),
       });
 
       if (response.ok) {
         this._pushResult = await response.json();
       } else {
+        // The server explains what it refused, such as an invalid branch name.
+        const text = (await response.text()).trim();
         this._pushResult = {
           success: false,
           output: "",
-          error: `HTTP ${response.status}: ${response.statusText}`,
+          error: text || `HTTP ${response.status}: ${response.statusText}`,
           dry_run: dryRun,
         };
       }
@@ -185,6 +193,32 @@ export class SketchPushButton extends SketchTailwindElement {
     return this._remotes.find((r) => r.name === this._selectedRemote) || null;
   }
 
+  // _pushStatusText describes how the remote branch changed, when the server could tell.
+  private _pushStatusText(result: GitPushResponse): string {
+    switch (result.status) {
+      case "new":
+        return "new branch";
+      case "fast-forward":
+        return "fast-forward";
+      case "forced":
+        return "forced update";
+      case "up-to-date":
+        return "already up to date";
+      case "rejected":
+        return result.reason ? `rejected: ${result.reason}` : "rejected";
+      default:
+        return "";
+    }
+  }
+
+  // _needsRebase reports whether the remote has commits the push lacks.
+  private _needsRebase(result: GitPushResponse): boolean {
+    if (result.status !== "rejected") {
+      return !result.success;
+    }
+    return result.reason === "non-fast-forward" || result.reason === "fetch first";
+  }
+
   private _computeBranchURL(): string {
     const selectedRemote = this._getSelectedRemote();
     if (!selectedRemote || !selectedRemote.is_github) {
@@ -386,6 +420,40 @@ export class SketchPushButton extends SketchTailwindElement {
                     placeholder="Enter branch name..."
                     class="w-full p-2 border border-gray-300 dark:border-neutral-600 rounded text-xs bg-white dark:bg-neutral-700 text-gray-900 dark:text-neutral-100 focus:ring-2 focus:ring-blue-500 focus:border-blue-500"
                   />
+                  <div class="flex gap-4 mt-2">
+                    <label
+                      class="flex items-center gap-1 text-xs text-gray-700 dark:text-neutral-300 cursor-pointer"
+                      title="Fail rather than overwrite a branch the remote already has"
+                    >
+                      <input
+                        type="checkbox"
+                        .checked=${this._createBranch}
+                        ?disabled=${this._loading}
+                        @change=${(e: Event) => {
+                          this._createBranch = (
+                            e.target as HTMLInputElement
+                          ).checked;
+                        }}
+                      />
+                      New branch
+                    </label>
+                    <label
+                      class="flex items-center gap-1 text-xs text-gray-700 dark:text-neutral-300 cursor-pointer"
+                      title="Make the checked-out branch track the pushed one"
+                    >
+                      <input
+                        type="checkbox"
+                        .checked=${this._setUpstream}
+                        ?disabled=${this._loading}
+                        @change=${(e: Event) => {
+                          this._setUpstream = (
+                            e.target as HTMLInputElement
+                          ).checked;
+                        }}
+                      />
+                      Track upstream
+                    </label>
+                  </div>
                 </div>
 
                 <!-- Action buttons -->
@@ -462,34 +530,60 @@ export class SketchPushButton extends SketchTailwindElement {
                             ${this._pushResult.success
                               ? "Successful"
                               : "Failed"}
+                            ${this._pushStatusText(this._pushResult)
+                              ? html`<span class="font-normal"
+                                  >(${this._pushStatusText(
+                                    this._pushResult,
+                                  )})</span
+                                >`
+                              : ""}
                           </p>
-                          ${this._pushResult.success &&
-                          !this._pushResult.dry_run
-                            ? (() => {
-                                const branchURL = this._computeBranchURL();
-                                return branchURL
-                                  ? html`
-                                      <a
-                                        href="${branchURL}"
-                                        target="_blank"
-                                        class="inline-flex items-center gap-1 px-2 py-1 text-xs bg-gray-900 dark:bg-neutral-700 hover:bg-gray-800 dark:hover:bg-neutral-600 text-white rounded transition-colors"
-                                      >
-                                        <svg
-                                          class="w-3 h-3"
-                                          viewBox="0 0 24 24"
-                                          fill="currentColor"
+                          ${this._pushResult.pull_request_url
+                            ? html`
+                                <a
+                                  href="${this._pushResult.pull_request_url}"
+                                  target="_blank"
+                                  class="inline-flex items-center gap-1 px-2 py-1 text-xs bg-green-700 hover:bg-green-800 text-white rounded transition-colors"
+                                >
+                                  Open Pull Request
+                                </a>
+                              `
+                            : this._pushResult.success &&
+                                !this._pushResult.dry_run
+                              ? (() => {
+                                  const branchURL = this._computeBranchURL();
+                                  return branchURL
+                                    ? html`
+                                        <a
+                                          href="${branchURL}"
+                                          target="_blank"
+                                          class="inline-flex items-center gap-1 px-2 py-1 text-xs bg-gray-900 dark:bg-neutral-700 hover:bg-gray-800 dark:hover:bg-neutral-600 text-white rounded transition-colors"
                                         >
-                                          <path
-                                            d="M12 0C5.37 0 0 5.37 0 12c0 5.31 3.435 9.795 8.205 11.385.6.105.825-.255.825-.57 0-.285-.015-1.23-.015-2.235-3.015.555-3.795-.735-4.035-1.41-.135-.345-.72-1.41-1.23-1.695-.42-.225-1.02-.78-.015-.795.945-.015 1.62.87 1.845 1.23 1.08 1.815 2.805 1.305 3.495.99.105-.78.42-1.305.765-1.605-2.67-.3-5.46-1.335-5.46-5.925 0-1.305.465-2.385 1.23-3.225-.12-.3-.54-1.53.12-3.18 0 0 1.005-.315 3.3 1.23.96-.27 1.98-.405 3-.405s2.04.135 3 .405c2.295-1.56 3.3-1.23 3.3-1.23.66 1.65.24 2.88.12 3.18.765.84 1.23 1.905 1.23 3.225 0 4.605-2.805 5.625-5.475 5.925.435.375.81 1.095.81 2.22 0 1.605-.015 2.895-.015 3.3 0 .315.225.69.825.57A12.02 12.02 0 0024 12c0-6.63-5.37-12-12-12z"
-                                          />
-                                        </svg>
-                                        Open on GitHub
-                                      </a>
-                                    `
-                                  : "";
-                              })()
-                            : ""}
+                                          <svg
+                                            class="w-3 h-3"
+                                            viewBox="0 0 24 24"
+                                            fill="currentColor"
+                                          >
+                                            <path
+                                              d="M12 0C5.37 0 0 5.37 0 12c0 5.31 3.435 9.795 8.205 11.385.6.105.825-.255.825-.57 0-.285-.015-1.23-.015-2.235-3.015.555-3.795-.735-4.035-1.41-.135-.345-.72-1.41-1.23-1.695-.42-.225-1.02-.78-.015-.795.945-.015 1.62.87 1.845 1.23 1.08 1.815 2.805 1.305 3.495.99.105-.78.42-1.305.765-1.605-2.67-.3-5.46-1.335-5.46-5.925 0-1.305.465-2.385 1.23-3.225-.12-.3-.54-1.53.12-3.18 0 0 1.005-.315 3.3 1.23.96-.27 1.98-.405 3-.405s2.04.135 3 .405c2.295-1.56 3.3-1.23 3.3-1.23.66 1.65.24 2.88.12 3.18.765.84 1.23 1.905 1.23 3.225 0 4.605-2.805 5.625-5.475 5.925.435.375.81 1.095.81 2.22 0 1.605-.015 2.895-.015 3.3 0 .315.225.69.825.57A12.02 12.02 0 0024 12c0-6.63-5.37-12-12-12z"
+                                            />
+                                          </svg>
+                                          Open on GitHub
+                                        </a>
+                                      `
+                                    : "";
+                                })()
+                              : ""}
                         </div>
+                        ${this._pushResult.upstream
+                          ? html`
+                              <p
+                                class="text-xs text-gray-700 dark:text-neutral-300 mb-2"
+                              >
+                                Now tracking ${this._pushResult.upstream}
+                              </p>
+                            `
+                          : ""}
                         ${this._pushResult.output
                           ? html`
                               <pre
@@ -511,7 +605,7 @@ export class SketchPushButton extends SketchTailwindElement {
                           : ""}
 
                         <div class="flex gap-2 items-center">
-                          ${!this._pushResult.success
+                          ${this._needsRebase(this._pushResult)
                             ? html`
                                 <button
                                   @click=${(e: Event) => this._handleRebase(e)}
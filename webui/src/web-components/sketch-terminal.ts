@@ -3,9 +3,14 @@ import { FitAddon } from "@xterm/addon-fit";
 
 /* eslint-disable @typescript-eslint/ban-ts-comment */
 import { html } from "lit";
-import { customElement } from "lit/decorators.js";
+import { customElement, state } from "lit/decorators.js";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 import { ThemeService } from "./theme-service";
+import type {
+  TerminalControl,
+  TerminalInfo,
+  TerminalResponse,
+} from "../types";
 import "./sketch-container-status";
 
 const darkTheme = {
@@ -48,12 +53,22 @@ export class SketchTerminal extends SketchTailwindElement {
   private isInitialized: boolean = false;
   // Terminal EventSource for SSE
   private terminalEventSource: EventSource | null = null;
-  // Terminal ID (always 1 for now, will support 1-9 later)
-  private terminalId: string = "1";
+  // ID of the terminal currently shown; remembered across page reloads
+  private terminalId: string =
+    localStorage.getItem("sketch-terminal-id") || "1";
+  // All terminal sessions known to the server
+  @state()
+  private terminals: TerminalInfo[] = [];
   // Queue for serializing terminal inputs
   private terminalInputQueue: string[] = [];
   // Flag to track if we're currently processing a terminal input
   private processingTerminalInput: boolean = false;
+  // Our client ID on the current terminal's event stream, and the ID of the
+  // client in control of its keyboard (0 if nobody); see the "control" events
+  @state()
+  private clientId: number = 0;
+  @state()
+  private writer: number = 0;
 
   constructor() {
     super();
@@ -203,7 +218,25 @@ export class SketchTerminal extends SketchTailwindElement {
     // Open the terminal in the container
     this.terminal.open(terminalContainer);
 
-    // Connect to WebSocket
+    // Send key inputs to the server via POST requests, unless another
+    // client (e.g. another tab) has control of the terminal
+    this.terminal.onData((data) => {
+      if (!this.readOnly) {
+        this.sendTerminalInput(data);
+      }
+    });
+
+    // Reattach to an existing terminal if there is one, so reloading the page
+    // doesn't lose state. The server replays scrollback on connect.
+    await this.refreshTerminals();
+    if (!this.terminals.some((t) => t.sessionId === this.terminalId)) {
+      if (this.terminals.length > 0) {
+        this.terminalId = this.terminals[0].sessionId;
+      } else {
+        await this.createTerminal(false);
+      }
+    }
+
     await this.connectTerminal();
 
     // Fit the terminal to the container
@@ -223,9 +256,11 @@ export class SketchTerminal extends SketchTailwindElement {
 
     // Close existing connections if any
     this.closeTerminalConnections();
+    this.clientId = 0;
+    this.writer = 0;
 
     try {
-      // Connect directly to the SSE endpoint for terminal 1
+      // Connect directly to the SSE endpoint for the current terminal
       // Use relative URL based on current location
       const baseUrl = window.location.pathname.endsWith("/") ? "." : ".";
       const eventsUrl = `${baseUrl}/terminal/events/${this.terminalId}`;
@@ -234,9 +269,19 @@ export class SketchTerminal extends SketchTailwindElement {
       // Handle SSE events
       this.terminalEventSource.onopen = () => {
         console.log("Terminal SSE connection opened");
-        this.sendTerminalResize();
       };
 
+      // The server names us on connecting, and tells us whenever the keyboard
+      // changes hands.
+      this.terminalEventSource.addEventListener("control", (event) => {
+        const control = JSON.parse(
+          (event as MessageEvent).data,
+        ) as TerminalControl;
+        this.clientId = control.client;
+        this.writer = control.writer;
+        this.sendTerminalResize();
+      });
+
       this.terminalEventSource.onmessage = (event) => {
         if (this.terminal) {
           // Decode base64 data before writing to terminal
@@ -260,13 +305,6 @@ export class SketchTerminal extends SketchTailwindElement {
           this.closeTerminalConnections();
         }
       };
-
-      // Send key inputs to the server via POST requests
-      if (this.terminal) {
-        this.terminal.onData((data) => {
-          this.sendTerminalInput(data);
-        });
-      }
     } catch (error) {
       console.error("Failed to connect to terminal:", error);
       if (this.terminal) {
@@ -277,6 +315,110 @@ export class SketchTerminal extends SketchTailwindElement {
     }
   }
 
+  /**
+   * Fetch the list of terminal sessions from the server
+   */
+  private async refreshTerminals(): Promise<void> {
+    try {
+      const response = await fetch("./terminal");
+      if (!response.ok) {
+        console.error(`Failed to list terminals: ${response.status}`);
+        return;
+      }
+      this.terminals = (await response.json()) as TerminalInfo[];
+    } catch (error) {
+      console.error("Error listing terminals:", error);
+    }
+  }
+
+  /**
+   * Create a new terminal session on the server, optionally switching to it
+   */
+  private async createTerminal(switchTo: boolean = true): Promise<void> {
+    try {
+      const response = await fetch("./terminal", { method: "POST" });
+      if (!response.ok) {
+        console.error(`Failed to create terminal: ${response.status}`);
+        return;
+      }
+      const created = (await response.json()) as TerminalResponse;
+      await this.refreshTerminals();
+      if (switchTo) {
+        await this.switchTerminal(created.sessionId);
+      } else {
+        this.terminalId = created.sessionId;
+      }
+    } catch (error) {
+      console.error("Error creating terminal:", error);
+    }
+  }
+
+  /**
+   * Show a different terminal session
+   */
+  private async switchTerminal(id: string): Promise<void> {
+    this.terminalId = id;
+    localStorage.setItem("sketch-terminal-id", id);
+    this.terminal?.reset();
+    await this.connectTerminal();
+    this.terminal?.focus();
+  }
+
+  /**
+   * Kill a terminal session on the server
+   */
+  private async killTerminal(id: string): Promise<void> {
+    if (id === this.terminalId) {
+      // Stop listening first, or the EventSource would reconnect and recreate it
+      this.closeTerminalConnections();
+    }
+    try {
+      await fetch(`./terminal/${encodeURIComponent(id)}`, {
+        method: "DELETE",
+      });
+    } catch (error) {
+      console.error("Error killing terminal:", error);
+    }
+    // The server removes the session once the shell exits
+    await new Promise((resolve) => setTimeout(resolve, 100));
+    await this.refreshTerminals();
+    this.terminals = this.terminals.filter((t) => t.sessionId !== id);
+    if (id === this.terminalId) {
+      if (this.terminals.length > 0) {
+        await this.switchTerminal(this.terminals[0].sessionId);
+      } else {
+        await this.createTerminal();
+      }
+    }
+  }
+
+  /**
+   * Whether another client has control of the current terminal
+   */
+  private get readOnly(): boolean {
+    return this.writer !== 0 && this.writer !== this.clientId;
+  }
+
+  /**
+   * Take control of the current terminal's keyboard from whichever client has it
+   */
+  private async takeControl(): Promise<void> {
+    try {
+      const response = await fetch(
+        `./terminal/control/${this.terminalId}?client=${this.clientId}`,
+        { method: "POST" },
+      );
+      if (!response.ok) {
+        console.error(`Failed to take control of terminal: ${response.status}`);
+        return;
+      }
+      // The control event that follows makes us the writer
+      this.terminal?.focus();
+    } catch (error) {
+      console.error("Error taking control of terminal:", error);
+    }
+  }
+
   /**
    * Close any active terminal connections
    */
@@ -324,7 +466,7 @@ export class SketchTerminal extends SketchTailwindElement {
       // Use relative URL based on current location
       const baseUrl = window.location.pathname.endsWith("/") ? "." : ".";
       const response = await fetch(
-        `${baseUrl}/terminal/input/${this.terminalId}`,
+        `${baseUrl}/terminal/input/${this.terminalId}?client=${this.clientId}`,
         {
           method: "POST",
           body: combinedData,
@@ -351,7 +493,8 @@ export class SketchTerminal extends SketchTailwindElement {
    * Send terminal resize information to the server
    */
   private async sendTerminalResize(): Promise<void> {
-    if (!this.terminal || !this.fitAddon) {
+    // The client in control of the terminal decides its size
+    if (!this.terminal || !this.fitAddon || this.readOnly) {
       return;
     }
 
@@ -361,7 +504,7 @@ export class SketchTerminal extends SketchTailwindElement {
       // Use relative URL based on current location
       const baseUrl = window.location.pathname.endsWith("/") ? "." : ".";
       const response = await fetch(
-        `${baseUrl}/terminal/input/${this.terminalId}`,
+        `${baseUrl}/terminal/input/${this.terminalId}?client=${this.clientId}`,
         {
           method: "POST",
           body: JSON.stringify({
@@ -375,7 +518,8 @@ export class SketchTerminal extends SketchTailwindElement {
         },
       );
 
-      if (!response.ok) {
+      // 409 means another client took control meanwhile; it sets the size
+      if (!response.ok && response.status !== 409) {
         console.error(
           `Failed to send terminal resize: ${response.status} ${response.statusText}`,
         );
@@ -387,6 +531,55 @@ export class SketchTerminal extends SketchTailwindElement {
 
   render() {
     return html`
+      <div class="flex items-center gap-1 mb-2 text-sm">
+        ${this.terminals.map(
+          (t) => html`
+            <div
+              class="flex items-center rounded px-2 py-1 cursor-pointer ${t.sessionId ===
+              this.terminalId
+                ? "bg-blue-500 text-white"
+                : "bg-gray-200 dark:bg-neutral-700 dark:text-gray-200"}"
+              @click=${() => this.switchTerminal(t.sessionId)}
+            >
+              <span>${t.name}</span>
+              <button
+                class="ml-2 opacity-70 hover:opacity-100"
+                title="Kill terminal"
+                @click=${(e: Event) => {
+                  e.stopPropagation();
+                  this.killTerminal(t.sessionId);
+                }}
+              >
+                ×
+              </button>
+            </div>
+          `,
+        )}
+        <button
+          class="rounded px-2 py-1 bg-gray-200 dark:bg-neutral-700 dark:text-gray-200 hover:bg-gray-300"
+          title="New terminal"
+          @click=${() => this.createTerminal()}
+        >
+          +
+        </button>
+      </div>
+      ${this.readOnly
+        ? html`
+            <div
+              class="flex items-center gap-2 mb-2 px-3 py-2 rounded text-sm bg-yellow-100 text-yellow-900 dark:bg-yellow-900 dark:text-yellow-100"
+            >
+              <span>
+                Another tab is typing in this terminal; it is read-only here.
+              </span>
+              <button
+                class="ml-auto rounded px-2 py-1 bg-blue-500 text-white hover:bg-blue-600"
+                @click=${() => this.takeControl()}
+              >
+                Take control
+              </button>
+            </div>
+          `
+        : ""}
       <div
         id="terminalView"
         class="w-full bg-gray-100 dark:bg-neutral-800 rounded-lg overflow-hidden mb-5 shadow-md p-4"
@@ -1,13 +1,15 @@
 import { html, render } from "lit";
 import { unsafeHTML } from "lit/directives/unsafe-html.js";
 import { customElement, property, state } from "lit/decorators.js";
-import { AgentMessage, State } from "../types";
+import { AgentMessage, FeedbackRequest, PinRequest, State } from "../types";
+import { formatDateTime } from "../utils";
 import { marked, MarkedOptions, Renderer, Tokens } from "marked";
 import type mermaid from "mermaid";
 import DOMPurify from "dompurify";
 import "./sketch-tool-calls";
 import "./sketch-external-message";
 import "./sketch-commits";
+import "./sketch-message-artifacts";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 
 // Mermaid is loaded dynamically - see loadMermaid() function
@@ -82,6 +84,13 @@ export class SketchTimelineMessage extends SketchTailwindElement {
   @state()
   showInfo: boolean = false;
 
+  // The user's rating of this message: "up", "down", or "" if unrated
+  @state()
+  feedbackRating: string = "";
+
+  @state()
+  showFeedbackComment: boolean = false;
+
   // Styles have been converted to Tailwind classes applied directly to HTML elements
   // since this component now extends SketchTailwindElement which disables shadow DOM
 
@@ -97,8 +106,13 @@ export class SketchTimelineMessage extends SketchTailwindElement {
   connectedCallback() {
     super.connectedCallback();
     this.ensureGlobalStyles();
+    document.addEventListener("timezone-changed", this.handleTimeZoneChange);
   }
 
+  private handleTimeZoneChange = () => {
+    this.requestUpdate();
+  };
+
   // Ensure global styles are injected when component is used
   private ensureGlobalStyles() {
     if (!document.querySelector("#sketch-timeline-message-styles")) {
@@ -518,6 +532,7 @@ export class SketchTimelineMessage extends SketchTailwindElement {
   // See https://lit.dev/docs/components/lifecycle/
   disconnectedCallback() {
     super.disconnectedCallback();
+    document.removeEventListener("timezone-changed", this.handleTimeZoneChange);
   }
 
   // Add post-sanitization button replacement
@@ -720,13 +735,10 @@ export class SketchTimelineMessage extends SketchTailwindElement {
     timestamp: string | number | Date | null | undefined,
     defaultValue: string = "",
   ): string {
-    if (!timestamp) return defaultValue;
-    try {
-      const date = new Date(timestamp);
-      if (isNaN(date.getTime())) return defaultValue;
-
-      // Format: Mar 13, 2025 09:53:25 AM
-      return date.toLocaleString("en-US", {
+    // Format: Mar 13, 2025 09:53:25 AM, in the display time zone
+    return formatDateTime(
+      timestamp,
+      {
         month: "short",
         day: "numeric",
         year: "numeric",
@@ -734,10 +746,9 @@ export class SketchTimelineMessage extends SketchTailwindElement {
         minute: "2-digit",
         second: "2-digit",
         hour12: true,
-      });
-    } catch {
-      return defaultValue;
-    }
+      },
+      defaultValue,
+    );
   }
 
   formatNumber(
@@ -791,6 +802,125 @@ export class SketchTimelineMessage extends SketchTailwindElement {
     this.showInfo = !this.showInfo;
   }
 
+  async sendFeedback(rating: string, comment: string, event: Event) {
+    event.stopPropagation();
+    const rect = (event.currentTarget as HTMLElement).getBoundingClientRect();
+    const req: FeedbackRequest = {
+      message_idx: this.message.idx,
+      rating,
+      comment: comment || undefined,
+    };
+    try {
+      const response = await fetch("./feedback", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(req),
+      });
+      if (!response.ok) {
+        const text = (await response.text()).trim();
+        throw new Error(text || response.statusText);
+      }
+      this.feedbackRating = rating;
+      // A thumbs down is most useful with a word on what went wrong.
+      this.showFeedbackComment = rating === "down" && !comment;
+      if (comment) this.showFloatingMessage("Thanks!", rect, "success");
+    } catch (err) {
+      console.error("Failed to send feedback:", err);
+      this.showFloatingMessage("Failed to send feedback!", rect, "error");
+    }
+  }
+
+  async togglePin(event: Event) {
+    event.stopPropagation();
+    const rect = (event.currentTarget as HTMLElement).getBoundingClientRect();
+    const req: PinRequest = {
+      message_idx: this.message.idx,
+      pinned: !this.message.pinned,
+    };
+    try {
+      const response = await fetch("./pin", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(req),
+      });
+      if (!response.ok) {
+        const text = (await response.text()).trim();
+        throw new Error(text || response.statusText);
+      }
+      this.message.pinned = req.pinned;
+      this.requestUpdate();
+      this.showFloatingMessage(
+        req.pinned ? "Pinned: kept through compaction" : "Unpinned",
+        rect,
+        "success",
+      );
+    } catch (err) {
+      console.error("Failed to pin message:", err);
+      this.showFloatingMessage("Failed to pin message!", rect, "error");
+    }
+  }
+
+  private pinButton() {
+    const pinned = !!this.message?.pinned;
+    return html`<button
+      class="bg-transparent border-none cursor-pointer p-0.5 rounded-full flex items-center justify-center w-6 h-6 text-sm transition-all duration-150 hover:bg-black/8 dark:hover:bg-white/10 ${pinned
+        ? "opacity-100"
+        : "opacity-60 grayscale"}"
+      title="${pinned
+        ? "Unpin message"
+        : "Pin message, so that compaction keeps it verbatim"}"
+      aria-pressed="${pinned}"
+      @click=${(e: Event) => this.togglePin(e)}
+    >
+      📌
+    </button>`;
+  }
+
+  private feedbackButton(rating: string, label: string, emoji: string) {
+    const selected = this.feedbackRating === rating;
+    return html`<button
+      class="bg-transparent border-none cursor-pointer p-0.5 rounded-full flex items-center justify-center w-6 h-6 text-sm transition-all duration-150 hover:bg-black/8 dark:hover:bg-white/10 ${selected
+        ? "opacity-100"
+        : "opacity-60 grayscale"}"
+      title="${label}"
+      aria-pressed="${selected}"
+      @click=${(e: Event) => this.sendFeedback(rating, "", e)}
+    >
+      ${emoji}
+    </button>`;
+  }
+
+  private renderFeedbackComment() {
+    let comment = "";
+    return html`
+      <div
+        class="mt-2 flex gap-1.5 items-end"
+        @click=${(e: Event) => e.stopPropagation()}
+      >
+        <textarea
+          class="flex-1 text-xs p-1 rounded border border-gray-300 dark:border-neutral-600 bg-white dark:bg-neutral-900 text-black dark:text-neutral-100 resize-y"
+          rows="2"
+          placeholder="What went wrong? (optional)"
+          @input=${(e: Event) => {
+            comment = (e.target as HTMLTextAreaElement).value;
+          }}
+        ></textarea>
+        <button
+          class="text-xs px-2 py-1 rounded bg-blue-500 text-white border-none cursor-pointer"
+          @click=${(e: Event) => this.sendFeedback("down", comment.trim(), e)}
+        >
+          Send
+        </button>
+        <button
+          class="text-xs px-2 py-1 rounded bg-transparent border-none cursor-pointer text-gray-500 dark:text-neutral-400"
+          @click=${() => (this.showFeedbackComment = false)}
+        >
+          Skip
+        </button>
+      </div>
+    `;
+  }
+
   copyToClipboard(text: string, event: Event) {
     const element = event.currentTarget as HTMLElement;
     const rect = element.getBoundingClientRect();
@@ -940,6 +1070,14 @@ export class SketchTimelineMessage extends SketchTailwindElement {
                       <line x1="12" y1="8" x2="12.01" y2="8"></line>
                     </svg>
                   </button>
+                  ${this.message?.type === "user" ||
+                  this.message?.type === "agent"
+                    ? this.pinButton()
+                    : ""}
+                  ${this.message?.type === "agent"
+                    ? html`${this.feedbackButton("up", "Good response", "👍")}
+                      ${this.feedbackButton("down", "Bad response", "👎")}`
+                    : ""}
                 </div>
                 ${this.message?.content
                   ? html`
@@ -955,6 +1093,9 @@ export class SketchTimelineMessage extends SketchTailwindElement {
                       </div>
                     `
                   : ""}
+                <sketch-message-artifacts
+                  .artifacts=${this.message?.artifacts}
+                ></sketch-message-artifacts>
 
                 <!-- End of turn indicator inside the bubble -->
                 ${isEndOfTurn && this.message?.elapsed
@@ -970,6 +1111,8 @@ export class SketchTimelineMessage extends SketchTailwindElement {
                     `
                   : ""}
 
+                ${this.showFeedbackComment ? this.renderFeedbackComment() : ""}
+
                 <!-- Info panel that can be toggled -->
                 ${this.showInfo
                   ? html`
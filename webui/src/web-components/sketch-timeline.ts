@@ -2,7 +2,7 @@ import { html } from "lit";
 import { PropertyValues } from "lit";
 import { repeat } from "lit/directives/repeat.js";
 import { customElement, property, state } from "lit/decorators.js";
-import { AgentMessage, State } from "../types";
+import { AgentMessage, Milestone, State } from "../types";
 import "./sketch-timeline-message";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 import { Ref } from "lit/directives/ref";
@@ -75,6 +75,11 @@ export class SketchTimeline extends SketchTailwindElement {
   @state()
   private isLoadingOlderMessages: boolean = false;
 
+  // When collapsed, turns with a milestone are shown as the user's message
+  // followed by the turn's one-line summary.
+  @state()
+  private collapsed: boolean = false;
+
   // Threshold for triggering load more (pixels from top)
   private loadMoreThreshold: number = 100;
 
@@ -266,10 +271,24 @@ export class SketchTimeline extends SketchTailwindElement {
    * Get the filtered messages (excluding hidden ones)
    */
   private get filteredMessages(): AgentMessage[] {
+    const collapsedTurns = this.collapsed
+      ? Array.from(this.milestones.values())
+      : [];
     return this.messages.filter((msg) => {
       if (msg.hide_output) {
         return false; // Hide messages marked to be hidden
       }
+      // Milestones are rendered next to the turn they summarize, not in idx order.
+      if (msg.type === "milestone") {
+        return false;
+      }
+      if (
+        collapsedTurns.some(
+          (m) => msg.idx > m.first_idx && msg.idx <= m.last_idx,
+        )
+      ) {
+        return false;
+      }
       // HACK: Hide external messages that are not related to GitHub workflow failures
       if (
         msg.type === "external" &&
@@ -286,6 +305,57 @@ export class SketchTimeline extends SketchTailwindElement {
     });
   }
 
+  /**
+   * Get the turn milestones, keyed by the idx of the message they are rendered after:
+   * the end of the turn, or when collapsed, the user message that started it.
+   */
+  private get milestones(): Map<number, Milestone> {
+    const milestones = new Map<number, Milestone>();
+    for (const msg of this.messages) {
+      if (msg.type === "milestone" && msg.milestone) {
+        const m = msg.milestone;
+        milestones.set(this.collapsed ? m.first_idx : m.last_idx, m);
+      }
+    }
+    return milestones;
+  }
+
+  private renderMilestone(milestone: Milestone | undefined) {
+    if (!milestone) {
+      return "";
+    }
+    return html`<div
+      class="ml-[85px] my-2 px-3 py-1.5 border-l-4 border-blue-400 dark:border-blue-500 bg-blue-50 dark:bg-neutral-800 text-sm text-gray-700 dark:text-neutral-300 rounded-r cursor-pointer"
+      data-testid="turn-milestone"
+      title=${this.collapsed ? "Expand all turns" : "Collapse turns"}
+      @click=${() => (this.collapsed = !this.collapsed)}
+    >
+      📌 ${milestone.summary}
+    </div>`;
+  }
+
+  // renderToolProgress shows what running tools say they are up to,
+  // e.g. "bash: running tests 34/120".
+  private renderToolProgress() {
+    const progress = this.state?.tool_progress;
+    if (!progress?.length) {
+      return "";
+    }
+    return html`<div
+      class="ml-3 self-center flex flex-col text-xs text-gray-600 dark:text-neutral-400"
+      data-testid="tool-progress"
+    >
+      ${progress.map(
+        (p) =>
+          html`<span class="truncate max-w-md"
+            >${p.tool_name}: ${p.label}${p.total
+              ? ` ${p.done}/${p.total}`
+              : ""}</span
+          >`,
+      )}
+    </div>`;
+  }
+
   /**
    * Get the currently visible messages based on viewport rendering
    * Race-condition safe implementation
@@ -882,6 +952,8 @@ export class SketchTimeline extends SketchTailwindElement {
     // Compact padding class
     const compactClass = this.compactPadding ? "compact-padding" : "";
 
+    const milestones = this.milestones;
+
     return html`
       <div class="relative h-full">
         <div
@@ -920,6 +992,19 @@ export class SketchTimeline extends SketchTailwindElement {
                   </div>
                 `
               : ""}
+            ${this.isInitialLoadComplete && milestones.size > 0
+              ? html`
+                  <div class="flex justify-end py-1 print:hidden">
+                    <button
+                      class="text-xs text-blue-600 dark:text-blue-400 hover:underline"
+                      data-testid="collapse-turns"
+                      @click=${() => (this.collapsed = !this.collapsed)}
+                    >
+                      ${this.collapsed ? "Expand all turns" : "Collapse turns"}
+                    </button>
+                  </div>
+                `
+              : ""}
             ${this.isInitialLoadComplete
               ? repeat(
                   this.visibleMessages,
@@ -942,7 +1027,8 @@ export class SketchTimeline extends SketchTailwindElement {
                       .firstMessageIndex=${this.firstMessageIndex}
                       .state=${this.state}
                       .compactPadding=${this.compactPadding}
-                    ></sketch-timeline-message>`;
+                    ></sketch-timeline-message>
+                    ${this.renderMilestone(milestones.get(message.idx))}`;
                   },
                 )
               : ""}
@@ -975,6 +1061,7 @@ export class SketchTimeline extends SketchTailwindElement {
                         ></div>
                       </div>
                     </div>
+                    ${this.renderToolProgress()}
                   </div>
                 `
               : ""}
@@ -5,6 +5,7 @@ import { State, ToolCall } from "../types";
 import { SketchTailwindElement } from "./sketch-tailwind-element.js";
 import "./sketch-tool-card";
 import "./sketch-tool-card-take-screenshot";
+import "./sketch-message-artifacts";
 import "./sketch-tool-card-about-sketch";
 import "./sketch-tool-card-browser-navigate";
 import "./sketch-tool-card-browser-eval";
@@ -43,6 +44,61 @@ export class SketchToolCalls extends SketchTailwindElement {
           .open=${open}
           .toolCall=${toolCall}
         ></sketch-tool-card-codereview>`;
+      case "dependency_audit":
+        return html`<sketch-tool-card-dependency-audit
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-dependency-audit>`;
+      case "todo_scan":
+        return html`<sketch-tool-card-todo-scan
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-todo-scan>`;
+      case "session_recap":
+        return html`<sketch-tool-card-session-recap
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-session-recap>`;
+      case "rebase_upstream":
+        return html`<sketch-tool-card-rebase-upstream
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-rebase-upstream>`;
+      case "build_attestation":
+        return html`<sketch-tool-card-build-attestation
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-build-attestation>`;
+      case "verify_matrix":
+        return html`<sketch-tool-card-verify-matrix
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-verify-matrix>`;
+      case "git_diff":
+        return html`<sketch-tool-card-git-diff
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-git-diff>`;
+      case "merge_queue":
+        return html`<sketch-tool-card-merge-queue
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-merge-queue>`;
+      case "task_runner":
+        return html`<sketch-tool-card-task-runner
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-task-runner>`;
+      case "scratchpad":
+        return html`<sketch-tool-card-scratchpad
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-scratchpad>`;
+      case "artifacts":
+        return html`<sketch-tool-card-artifacts
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-artifacts>`;
       case "done":
         return html`<sketch-tool-card-done
           .open=${open}
@@ -68,6 +124,11 @@ export class SketchToolCalls extends SketchTailwindElement {
           .open=${open}
           .toolCall=${toolCall}
         ></sketch-tool-card-about-sketch>`;
+      case "tool_help":
+        return html`<sketch-tool-card-tool-help
+          .open=${open}
+          .toolCall=${toolCall}
+        ></sketch-tool-card-tool-help>`;
       case "todo_write":
         return html`<sketch-tool-card-todo-write
           .open=${open}
@@ -150,6 +211,12 @@ export class SketchToolCalls extends SketchTailwindElement {
             class="flex flex-col bg-white/60 dark:bg-neutral-700/60 rounded-md mb-1.5 overflow-hidden cursor-pointer border-l-2 border-black/10 dark:border-white/10 shadow-sm max-w-full break-words ${toolCall.name}"
           >
             ${this.cardForToolCall(toolCall, shouldOpen)}
+            ${toolCall.name !== "browser_take_screenshot"
+              ? html`<sketch-message-artifacts
+                  class="px-2"
+                  .artifacts=${toolCall.result_message?.artifacts}
+                ></sketch-message-artifacts>`
+              : ""}
           </div>`;
         })}
       </div>
@@ -1,7 +1,14 @@
 import { html } from "lit";
 import { unsafeHTML } from "lit/directives/unsafe-html.js";
-import { customElement, property } from "lit/decorators.js";
-import { ToolCall } from "../types";
+import { customElement, property, state } from "lit/decorators.js";
+import {
+  Attestation,
+  BashViolation,
+  FileDiff,
+  MatrixRun,
+  TestReport,
+  ToolCall,
+} from "../types";
 import { marked } from "marked";
 import DOMPurify from "dompurify";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
@@ -74,6 +81,10 @@ export class SketchToolCardBash extends SketchTailwindElement {
   @property() toolCall: ToolCall;
   @property() open: boolean;
 
+  // The raw output of the test run, fetched when asked for.
+  @state() rawOutput: string | null = null;
+  @state() rawOutputError: string | null = null;
+
   render() {
     const inputData = JSON.parse(this.toolCall?.input || "{}");
     const isBackground = inputData?.background === true;
@@ -108,14 +119,39 @@ ${backgroundIcon}${slowIcon}${inputData?.command}</pre
       </div>
     </div>`;
 
-    const resultContent = this.toolCall?.result_message?.tool_result
-      ? html`<div class="w-full relative">
-          ${createPreElement(
-            this.toolCall.result_message.tool_result,
-            "mt-0 text-gray-600 rounded-t-none rounded-b w-full box-border max-h-[300px] overflow-y-auto",
-          )}
-        </div>`
-      : "";
+    const display = this.toolCall?.result_message?.display;
+    const report: TestReport | null =
+      display && typeof display === "object" && "framework" in display
+        ? (display as TestReport)
+        : null;
+    const violation: BashViolation | null =
+      display && typeof display === "object" && "reason" in display
+        ? (display as BashViolation)
+        : null;
+
+    let resultContent;
+    if (report) {
+      resultContent = this.renderTestReport(report);
+    } else if (violation) {
+      resultContent = html`<div class="w-full p-2 text-sm">
+        <div class="font-semibold text-red-600">
+          🚫 Blocked by the bash policy
+        </div>
+        <code class="block mt-1 font-mono">${violation.command}</code>
+        <div class="mt-1 text-gray-600 dark:text-neutral-400">
+          ${violation.reason}
+        </div>
+      </div>`;
+    } else if (this.toolCall?.result_message?.tool_result) {
+      resultContent = html`<div class="w-full relative">
+        ${createPreElement(
+          this.toolCall.result_message.tool_result,
+          "mt-0 text-gray-600 rounded-t-none rounded-b w-full box-border max-h-[300px] overflow-y-auto",
+        )}
+      </div>`;
+    } else {
+      resultContent = "";
+    }
 
     return html`<sketch-tool-card-base
       .open=${this.open}
@@ -125,6 +161,79 @@ ${backgroundIcon}${slowIcon}${inputData?.command}</pre
       .resultContent=${resultContent}
     ></sketch-tool-card-base>`;
   }
+
+  async loadRawOutput(report: TestReport) {
+    try {
+      const response = await fetch(`./test-output/${report.raw_id}`);
+      if (!response.ok) {
+        throw new Error(`${response.status} ${await response.text()}`);
+      }
+      this.rawOutput = await response.text();
+    } catch (e) {
+      this.rawOutputError = `Failed to load test output: ${e}`;
+    }
+  }
+
+  renderTestReport(report: TestReport) {
+    const failures = report.failures || [];
+    const counts = [
+      `${report.failed} failed`,
+      `${report.passed} passed`,
+      report.skipped ? `${report.skipped} skipped` : "",
+    ]
+      .filter(Boolean)
+      .join(", ");
+
+    let raw;
+    if (this.rawOutput !== null) {
+      raw = createPreElement(
+        this.rawOutput,
+        "mt-1 text-gray-600 w-full box-border max-h-[300px] overflow-y-auto",
+      );
+    } else if (this.rawOutputError) {
+      raw = html`<div class="mt-1 text-red-600">${this.rawOutputError}</div>`;
+    } else if (report.raw_id) {
+      raw = html`<button
+        class="mt-1 text-xs text-blue-600 dark:text-blue-400 hover:underline"
+        @click=${() => this.loadRawOutput(report)}
+      >
+        Show full output${report.raw_bytes
+          ? ` (${Math.ceil(report.raw_bytes / 1024)} kB)`
+          : ""}
+      </button>`;
+    } else {
+      raw = createPreElement(
+        this.toolCall?.result_message?.tool_result || "",
+        "mt-1 text-gray-600 w-full box-border max-h-[300px] overflow-y-auto",
+      );
+    }
+
+    return html`<div class="w-full p-2 text-sm">
+      <div
+        class="font-semibold ${failures.length
+          ? "text-red-600"
+          : "text-green-700"}"
+      >
+        ${failures.length ? "❌" : "✅"} ${report.framework} tests: ${counts}
+      </div>
+      ${failures.map(
+        (f) => html`<details class="mt-1">
+          <summary class="cursor-pointer font-mono">
+            ${f.name}${f.location
+              ? html` <span class="text-gray-500">${f.location}</span>`
+              : ""}
+          </summary>
+          ${f.message
+            ? createPreElement(
+                f.message,
+                "mt-1 text-gray-700 w-full box-border",
+              )
+            : ""}
+        </details>`,
+      )}
+      ${raw}
+    </div>`;
+  }
 }
 
 @customElement("sketch-tool-card-codereview")
@@ -408,6 +517,472 @@ export class SketchToolCardTodoRead extends SketchTailwindElement {
   }
 }
 
+@customElement("sketch-tool-card-dependency-audit")
+export class SketchToolCardDependencyAudit extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    const resultText = this.toolCall?.result_message?.tool_result || "";
+    let statusIcon = "";
+    if (resultText.includes("Introduced vulnerabilities")) statusIcon = "⚠️";
+    else if (resultText) statusIcon = "✔️";
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      ${statusIcon} Dependency audit
+    </span>`;
+    const resultContent = resultText ? createPreElement(resultText) : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-todo-scan")
+export class SketchToolCardTodoScan extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let where = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      if (input.scope === "repo") where = ` in ${input.path || "the repo"}`;
+    } catch (e) {
+      console.error("Error parsing todo_scan input:", e);
+    }
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      📌 Scanning for TODOs${where}
+    </span>`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-session-recap")
+export class SketchToolCardSessionRecap extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    const summaryContent = html`<span class="italic text-gray-600">
+      🧾 Recap of what was tried so far
+    </span>`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-tool-help")
+export class SketchToolCardToolHelp extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let name = "";
+    try {
+      name = JSON.parse(this.toolCall?.input || "{}").name || "";
+    } catch (e) {
+      console.error("Error parsing tool_help input:", e);
+    }
+    const summaryContent = html`<span class="italic text-gray-600">
+      📖 Reading up on <span class="font-mono">${name}</span>
+    </span>`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-scratchpad")
+export class SketchToolCardScratchpad extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let language = "";
+    let code = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      language = input.language || "";
+      code = input.code || "";
+    } catch (e) {
+      console.error("Error parsing scratchpad input:", e);
+    }
+    const firstLine = code.split("\n")[0];
+    const summaryContent = html`<div
+      class="max-w-full overflow-hidden text-ellipsis whitespace-nowrap"
+    >
+      🧮 <span class="font-semibold">${language}</span>
+      <span class="font-mono">${firstLine}</span>
+    </div>`;
+    const inputContent = html`<pre
+      class="bg-gray-200 dark:bg-neutral-700 text-black dark:text-neutral-100 p-2 rounded whitespace-pre-wrap break-words max-w-full w-full box-border mb-0"
+    >
+${code}</pre
+    >`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .inputContent=${inputContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-rebase-upstream")
+export class SketchToolCardRebaseUpstream extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let action = "start";
+    let onto = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      action = input.action || "start";
+      onto = input.onto || "";
+    } catch (e) {
+      console.error("Error parsing rebase_upstream input:", e);
+    }
+
+    const resultText = this.toolCall?.result_message?.tool_result || "";
+    let statusIcon = "";
+    if (resultText.startsWith("Conflict")) statusIcon = "⚠️";
+    else if (resultText.includes("The tests fail")) statusIcon = "❌";
+    else if (resultText.startsWith("Rebased")) statusIcon = "✔️";
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      ${statusIcon} 🔀 Rebase ${action}${onto ? ` onto ${onto}` : ""}
+    </span>`;
+    const resultContent = resultText ? createPreElement(resultText) : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-git-diff")
+export class SketchToolCardGitDiff extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let from = "";
+    let to = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      from = input.from || "sketch-base";
+      to = input.to || "working tree";
+    } catch (e) {
+      console.error("Error parsing git_diff input:", e);
+    }
+
+    const display = this.toolCall?.result_message?.display;
+    const files: FileDiff[] | null = Array.isArray(display) ? display : null;
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      🗂️ Diff ${from}..${to}${files
+        ? ` (${files.length} file${files.length === 1 ? "" : "s"})`
+        : ""}
+    </span>`;
+
+    let resultContent;
+    if (files) {
+      resultContent = html`<div class="w-full p-2 text-sm font-mono">
+        ${files.map(
+          (f) => html`<div class="mb-1">
+            <span class="font-semibold">${f.status}</span>
+            ${f.old_path ? `${f.old_path} → ${f.path}` : f.path}
+            ${f.binary
+              ? html`<span class="text-gray-500">binary</span>`
+              : html`<span class="text-green-700">+${f.additions}</span>
+                  <span class="text-red-600">-${f.deletions}</span>`}
+            ${(f.hunks || []).map(
+              (h) => html`<div class="pl-4 text-gray-500">
+                @@ -${h.old_start},${h.old_lines} +${h.new_start},${h.new_lines}
+                @@ ${h.header || ""}
+              </div>`,
+            )}
+          </div>`,
+        )}
+      </div>`;
+    } else if (this.toolCall?.result_message?.tool_result) {
+      resultContent = createPreElement(this.toolCall.result_message.tool_result);
+    } else {
+      resultContent = "";
+    }
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-build-attestation")
+export class SketchToolCardBuildAttestation extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let subjects: string[] = [];
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      subjects = input.subjects || [];
+    } catch (e) {
+      console.error("Error parsing build_attestation input:", e);
+    }
+
+    const at = this.toolCall?.result_message?.display as
+      | Attestation
+      | undefined;
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      🔏 Attest ${subjects.join(", ")}
+    </span>`;
+
+    let resultContent;
+    if (at?.subjects) {
+      resultContent = html`<div class="w-full p-2 text-sm">
+        <div class="mb-1">
+          Built from <span class="font-mono">${at.commit.slice(0, 12)}</span>
+          ${at.dirty
+            ? html`<span class="text-amber-600"
+                >with uncommitted changes to tracked files</span
+              >`
+            : ""}
+        </div>
+        ${at.subjects.map(
+          (s) => html`<div class="font-mono text-xs">
+            ${s.name}
+            <span class="text-gray-500">sha256:${s.digest?.sha256}</span>
+          </div>`,
+        )}
+        <div class="mt-1 text-gray-600">
+          SBOM by ${at.sbom_tool}: ${at.packages}
+          package${at.packages === 1 ? "" : "s"}
+        </div>
+        ${(at.written || []).map(
+          (w) => html`<div class="text-xs text-gray-500">Wrote ${w}</div>`,
+        )}
+      </div>`;
+    } else if (this.toolCall?.result_message?.tool_result) {
+      resultContent = createPreElement(this.toolCall.result_message.tool_result);
+    } else {
+      resultContent = "";
+    }
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-verify-matrix")
+export class SketchToolCardVerifyMatrix extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let envs: string[] = [];
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      envs = input.envs || [];
+    } catch (e) {
+      console.error("Error parsing verify_matrix input:", e);
+    }
+
+    const run = this.toolCall?.result_message?.display as
+      | MatrixRun
+      | undefined;
+    const results = run?.results || [];
+    const failed = results.filter((r) => !r.passed).length;
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      🧪 Verify in ${envs.length ? envs.join(", ") : "every environment"}
+      ${run
+        ? failed
+          ? html`<span class="text-red-600"
+              >${failed} of ${results.length} failed</span
+            >`
+          : html`<span class="text-green-600">all passed</span>`
+        : ""}
+    </span>`;
+
+    let resultContent;
+    if (run) {
+      resultContent = html`<div class="w-full p-2 text-sm">
+        <div class="mb-1 font-mono text-xs text-gray-600">${run.command}</div>
+        ${results.map(
+          (r) => html`<div class="mt-1">
+            <span>${r.passed ? "✅" : "❌"}</span>
+            <span class="font-semibold">${r.env}</span>
+            <span class="font-mono text-xs text-gray-500">${r.image}</span>
+            <span class="text-gray-500">
+              ${r.error
+                ? `could not run: ${r.error}`
+                : r.passed
+                  ? `passed in ${Math.round(r.duration / 1e9)}s`
+                  : `exit code ${r.exit_code} after ${Math.round(r.duration / 1e9)}s`}
+            </span>
+            ${!r.passed && r.output ? createPreElement(r.output) : ""}
+          </div>`,
+        )}
+      </div>`;
+    } else if (this.toolCall?.result_message?.tool_result) {
+      resultContent = createPreElement(this.toolCall.result_message.tool_result);
+    } else {
+      resultContent = "";
+    }
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-merge-queue")
+export class SketchToolCardMergeQueue extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let action = "";
+    let branch = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      action = input.action || "";
+      branch = input.branch || "";
+    } catch (e) {
+      console.error("Error parsing merge_queue input:", e);
+    }
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      🚦 Merge queue ${action} ${branch}
+    </span>`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-task-runner")
+export class SketchToolCardTaskRunner extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let action = "";
+    let target = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      action = input.action || "";
+      target = input.target || "";
+    } catch (e) {
+      console.error("Error parsing task_runner input:", e);
+    }
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      🏗️ ${action} <span class="font-mono">${target}</span>
+    </span>`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
+@customElement("sketch-tool-card-artifacts")
+export class SketchToolCardArtifacts extends SketchTailwindElement {
+  @property() toolCall: ToolCall;
+  @property() open: boolean;
+
+  render() {
+    let action = "";
+    let key = "";
+    try {
+      const input = JSON.parse(this.toolCall?.input || "{}");
+      action = input.action || "";
+      key = input.key || "";
+    } catch (e) {
+      console.error("Error parsing artifacts input:", e);
+    }
+
+    const summaryContent = html`<span class="italic text-gray-600">
+      🗃️ ${action} ${key}
+    </span>`;
+    const resultContent = this.toolCall?.result_message?.tool_result
+      ? createPreElement(this.toolCall.result_message.tool_result)
+      : "";
+
+    return html`<sketch-tool-card-base
+      .open=${this.open}
+      .toolCall=${this.toolCall}
+      .summaryContent=${summaryContent}
+      .resultContent=${resultContent}
+    ></sketch-tool-card-base>`;
+  }
+}
+
 @customElement("sketch-tool-card-generic")
 export class SketchToolCardGeneric extends SketchTailwindElement {
   @property() toolCall: ToolCall;
@@ -449,6 +1024,16 @@ declare global {
     "sketch-tool-card-generic": SketchToolCardGeneric;
     "sketch-tool-card-bash": SketchToolCardBash;
     "sketch-tool-card-codereview": SketchToolCardCodeReview;
+    "sketch-tool-card-artifacts": SketchToolCardArtifacts;
+    "sketch-tool-card-dependency-audit": SketchToolCardDependencyAudit;
+    "sketch-tool-card-merge-queue": SketchToolCardMergeQueue;
+    "sketch-tool-card-task-runner": SketchToolCardTaskRunner;
+    "sketch-tool-card-todo-scan": SketchToolCardTodoScan;
+    "sketch-tool-card-rebase-upstream": SketchToolCardRebaseUpstream;
+    "sketch-tool-card-git-diff": SketchToolCardGitDiff;
+    "sketch-tool-card-session-recap": SketchToolCardSessionRecap;
+    "sketch-tool-card-tool-help": SketchToolCardToolHelp;
+    "sketch-tool-card-scratchpad": SketchToolCardScratchpad;
     "sketch-tool-card-done": SketchToolCardDone;
     "sketch-tool-card-patch": SketchToolCardPatch;
     "sketch-tool-card-think": SketchToolCardThink;
@@ -16,8 +16,26 @@ export class SketchViewModeSelect extends SketchTailwindElement {
   @property({ type: Number })
   diffLinesRemoved: number = 0;
 
+  @property({ type: Number })
+  diffFilesChanged: number = 0;
+
+  @property({ type: Number })
+  diffCommits: number = 0;
+
   // Header bar: view mode buttons
 
+  private diffTitle(): string {
+    if (this.diffLinesAdded === 0 && this.diffLinesRemoved === 0) {
+      return "No changes";
+    }
+    const files = `${this.diffFilesChanged} ${this.diffFilesChanged === 1 ? "file" : "files"}`;
+    const commits =
+      this.diffCommits > 0
+        ? `, ${this.diffCommits} ${this.diffCommits === 1 ? "commit" : "commits"}`
+        : "";
+    return `+${this.diffLinesAdded} -${this.diffLinesRemoved} in ${files}${commits}`;
+  }
+
   constructor() {
     super();
 
@@ -94,10 +112,7 @@ export class SketchViewModeSelect extends SketchTailwindElement {
             .activeMode === "diff2"
             ? "border-b-blue-600 dark:border-b-neutral-500 text-blue-600 font-medium bg-blue-50 dark:bg-neutral-700"
             : "hover:bg-gray-200 dark:hover:bg-neutral-700"} @xl:px-3 @xl:py-2 @max-xl:px-2.5 @max-xl:[&>span:not(.tab-icon):not(.diff-stats)]:hidden @max-xl:[&>.diff-stats]:inline @max-xl:[&>.diff-stats]:text-xs @max-xl:[&>.diff-stats]:ml-0.5 border-r border-gray-200 dark:border-neutral-600 last-of-type:border-r-0"
-          title="Diff View - ${this.diffLinesAdded > 0 ||
-          this.diffLinesRemoved > 0
-            ? `+${this.diffLinesAdded} -${this.diffLinesRemoved}`
-            : "No changes"}"
+          title="Diff View - ${this.diffTitle()}"
           @click=${() => this._handleViewModeClick("diff2")}
         >
           <span class="tab-icon text-base">±</span>
@@ -1,6 +1,7 @@
 import { html, TemplateResult } from "lit";
 import { customElement, property, state } from "lit/decorators.js";
 import { AgentMessage } from "../types";
+import { formatDateTime } from "../utils";
 import { SketchTailwindElement } from "./sketch-tailwind-element";
 import type { WorkflowRunEvent } from "@octokit/webhooks-types";
 
@@ -269,21 +270,15 @@ export class SketchWorkflowStatusSummary extends SketchTailwindElement {
 
   // Format timestamp for display
   private formatTimestamp(timestamp: string): string {
-    const date = new Date(timestamp);
-    return (
-      date.toLocaleDateString("en-US", {
-        month: "short",
-        day: "numeric",
-        year: "numeric",
-      }) +
-      ", " +
-      date.toLocaleTimeString("en-US", {
-        hour: "numeric",
-        minute: "2-digit",
-        second: "2-digit",
-        hour12: true,
-      })
-    );
+    return formatDateTime(timestamp, {
+      month: "short",
+      day: "numeric",
+      year: "numeric",
+      hour: "numeric",
+      minute: "2-digit",
+      second: "2-digit",
+      hour12: true,
+    });
   }
 
   // Badge layout with details/summary (horizontal row of badges with expandable events)